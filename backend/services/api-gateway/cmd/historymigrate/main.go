@@ -0,0 +1,93 @@
+// Command historymigrate copies prompt history from one history store to
+// another. Entries already in the destination are skipped, so it can be
+// rerun after an interruption and run again just before switching
+// HISTORY_STORE to pick up entries written in the meantime.
+//
+//	historymigrate -from postgres -to dynamodb -create-table
+//	historymigrate -from dynamodb -to postgres -batch 1000
+//
+// Postgres is reached through DATABASE_URL and DynamoDB through the
+// DYNAMODB_* and AWS_* settings the gateway uses.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	from := flag.String("from", "postgres", "store to copy from: postgres or dynamodb")
+	to := flag.String("to", "dynamodb", "store to copy to: postgres or dynamodb")
+	batch := flag.Int("batch", 500, "entries read per batch")
+	createTable := flag.Bool("create-table", false, "create the DynamoDB table and index when missing")
+	flag.Parse()
+
+	if *from == *to {
+		fatalf("-from and -to are both %s", *from)
+	}
+	if *batch < 1 {
+		fatalf("-batch must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	source := openStore(ctx, *from, false)
+	destination := openStore(ctx, *to, *createTable)
+
+	started := time.Now()
+	copied, err := services.MigrateHistory(ctx, source, destination, *batch, func(copied int) {
+		fmt.Fprintf(os.Stderr, "\rCopied %d entries", copied)
+	})
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		fatalf("stopped after %d entries: %v", copied, err)
+	}
+	fmt.Printf("Copied %d entries from %s to %s in %s\n", copied, *from, *to, time.Since(started).Round(time.Second))
+}
+
+func openStore(ctx context.Context, name string, createTable bool) services.HistoryStore {
+	switch name {
+	case "postgres":
+		dsn := os.Getenv("DATABASE_URL")
+		if dsn == "" {
+			fatalf("postgres needs DATABASE_URL")
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			fatalf("failed to open postgres: %v", err)
+		}
+		if err := db.PingContext(ctx); err != nil {
+			fatalf("failed to reach postgres: %v", err)
+		}
+		return services.NewDatabaseService(db)
+	case "dynamodb":
+		store, err := services.NewDynamoHistoryStore(services.LoadDynamoConfig())
+		if err != nil {
+			fatalf("%v", err)
+		}
+		if createTable {
+			if err := store.EnsureTable(ctx); err != nil {
+				fatalf("%v", err)
+			}
+		}
+		return store
+	default:
+		fatalf("unknown store %q", name)
+		return nil
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "historymigrate: "+format+"\n", args...)
+	os.Exit(2)
+}
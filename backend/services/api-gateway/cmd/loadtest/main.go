@@ -0,0 +1,95 @@
+// Command loadtest runs a load profile against a running gateway and exits
+// non-zero when the profile's SLOs aren't met.
+//
+//	loadtest -target http://localhost:8080 -profile smoke -token $TOKEN
+//	loadtest -profile ./profiles/checkout.json -format json -out report.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/loadtest"
+)
+
+func main() {
+	target := flag.String("target", envOr("LOADTEST_TARGET", "http://localhost:8080"), "base URL of the gateway")
+	profileName := flag.String("profile", "smoke", "built-in profile ("+strings.Join(loadtest.ProfileNames(), ", ")+") or path to a JSON profile")
+	token := flag.String("token", os.Getenv("LOADTEST_TOKEN"), "bearer token sent with every request")
+	format := flag.String("format", "text", "report format: text or json")
+	out := flag.String("out", "", "write the report to this file instead of stdout")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-request timeout")
+	maxInFlight := flag.Int("max-in-flight", 256, "requests in flight before new ones are dropped")
+	flag.Parse()
+
+	if *format != "text" && *format != "json" {
+		fatalf("unknown format %q", *format)
+	}
+
+	profile, err := loadtest.LoadProfile(*profileName)
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	runner := loadtest.NewRunner(*target, *token)
+	runner.Client.Timeout = *timeout
+	runner.MaxInFlight = *maxInFlight
+
+	// Ctrl-C stops sending and still reports what was measured
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(os.Stderr, "Running profile %s against %s for %s\n", profile.Name, runner.Target, profile.Duration())
+	report, err := runner.Run(ctx, profile)
+	if err != nil && report == nil {
+		fatalf("%v", err)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Run interrupted: %v\n", err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(report)
+	} else {
+		err = report.WriteText(w)
+	}
+	if err != nil {
+		fatalf("failed to write report: %v", err)
+	}
+
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "loadtest: "+format+"\n", args...)
+	os.Exit(2)
+}
@@ -1,18 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/betterprompts/api-gateway/internal/auth"
 	"github.com/betterprompts/api-gateway/internal/handlers"
 	"github.com/betterprompts/api-gateway/internal/middleware"
 	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/betterprompts/api-gateway/internal/startup"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 )
 
 func main() {
+	failFast := flag.Bool("fail-fast", false, "exit right away if a dependency isn't ready instead of waiting for it")
+	flag.Parse()
+
 	// Initialize logger
 	logger := logrus.New()
 	logger.SetFormatter(&logrus.JSONFormatter{})
@@ -44,6 +57,41 @@ func main() {
 		"environment": environment,
 	}).Info("Starting API Gateway")
 
+	// Panics are reported with recent log entries as breadcrumbs, so the
+	// hook goes in before anything else logs
+	crashReporter, err := services.NewCrashReporterFromEnv(environment, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid crash report configuration")
+	}
+
+	// The port is served from the start: liveness probes pass while the
+	// gateway waits for its dependencies, everything else gets a 503
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to listen")
+	}
+	startupHandler := startup.NewHandler()
+	server := &http.Server{Handler: startupHandler}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	startupConfig := startup.LoadConfig()
+	startupConfig.FailFast = *failFast
+	waitCtx, stopWaiting := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	err = startup.Wait(waitCtx, services.StartupDependencies(), startupConfig, logger)
+	stopWaiting()
+	if errors.Is(err, context.Canceled) {
+		logger.Info("Stopped while waiting for dependencies")
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Fatal("Dependencies not ready")
+	}
+
 	// Initialize service clients
 	clients, err := services.InitializeClients(logger)
 	if err != nil {
@@ -70,78 +118,597 @@ func main() {
 	}
 	userService := services.NewUserService(dbService, emailService)
 
+	// Refresh tokens are bound to the device they were issued to
+	deviceService := services.NewDeviceService(dbService)
+	deviceHandler := handlers.NewDeviceHandler(deviceService, logger.WithField("component", "devices"))
+
 	// Initialize auth handler
-	authHandler := handlers.NewAuthHandler(userService, jwtManager, clients.Cache, logger)
+	authHandler := handlers.NewAuthHandler(userService, jwtManager, clients.Cache, deviceService, logger)
 	
 	// Initialize feedback handler
 	feedbackHandler := handlers.NewFeedbackHandler(clients, logger.WithField("component", "feedback"))
 
+	// Initialize API key and integration handlers
+	apiKeyService := services.NewAPIKeyService(dbService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, logger.WithField("component", "api_keys"))
+
+	// Personal access tokens act as their owner on the routes their scopes grant
+	personalTokenService := services.NewPersonalAccessTokenService(dbService)
+	personalTokenHandler := handlers.NewPersonalAccessTokenHandler(personalTokenService, logger.WithField("component", "personal_access_tokens"))
+	tokenOrSession := func(scope string, sessionAuth gin.HandlerFunc) gin.HandlerFunc {
+		return middleware.PersonalAccessTokenAuth(personalTokenService, scope, sessionAuth, logger)
+	}
+
+	// Avatar uploads go to object storage (local disk or S3)
+	storage, err := services.NewStorageFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid storage configuration")
+	}
+	avatarService := services.NewAvatarService(dbService, storage, logger)
+	avatarHandler := handlers.NewAvatarHandler(avatarService, logger.WithField("component", "avatars"))
+
+	// Search analytics
+	clients.SearchAnalytics = services.NewSearchAnalyticsService(dbService, logger)
+	searchAnalyticsHandler := handlers.NewSearchAnalyticsHandler(clients.SearchAnalytics, logger.WithField("component", "search_analytics"))
+
+	// Periodic analytics jobs; with Redis each job runs on one instance per interval
+	scheduler := services.NewJobScheduler(clients.Cache, logger)
+
+	// Cohort retention and activation funnels
+	cohortService := services.NewCohortService(dbService, logger)
+	scheduler.Register(cohortService.AggregationJob(services.CohortAggregationInterval()))
+	cohortHandler := handlers.NewCohortHandler(cohortService, logger.WithField("component", "cohorts"))
+
+	// Technique popularity trends
+	techniqueTrendService := services.NewTechniqueTrendService(dbService, logger)
+	scheduler.Register(techniqueTrendService.RefreshJob(services.TechniqueTrendsRefreshInterval()))
+	techniqueTrendHandler := handlers.NewTechniqueTrendHandler(techniqueTrendService, logger.WithField("component", "technique_trends"))
+
+	// Coarse stats for the marketing site, computed daily
+	publicStatsService := services.NewPublicStatsService(dbService, clients.Cache, logger)
+	scheduler.Register(publicStatsService.ComputeJob())
+	publicStatsHandler := handlers.NewPublicStatsHandler(publicStatsService, logger.WithField("component", "public_stats"))
+
+	// Nightly anonymized exports to object storage for the ML training pipeline
+	exportStorage, err := services.NewExportStorageFromEnv()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid export storage configuration")
+	}
+	warehouseExports := services.NewWarehouseExportService(dbService, exportStorage, os.Getenv("EXPORT_ANONYMIZATION_KEY"), logger)
+	if warehouseExports.Enabled() {
+		scheduler.Register(warehouseExports.ExportJob())
+	}
+	warehouseExportHandler := handlers.NewWarehouseExportHandler(warehouseExports, logger.WithField("component", "warehouse_exports"))
+
+	// Weekly and monthly usage reports, stored next to the exports and emailed
+	reportService := services.NewReportService(dbService, exportStorage, emailService, logger)
+	scheduler.Register(reportService.ReportJob())
+	reportHandler := handlers.NewReportHandler(reportService, logger.WithField("component", "reports"))
+
+	// Per-user access logs for SOC 2 evidence and data subject access requests
+	accessLog := services.NewAccessLogService(dbService, logger)
+	accessLogHandler := handlers.NewAccessLogHandler(accessLog, logger.WithField("component", "access_log"))
+
+	// Recent requests, searchable by admins and linked to their traces
+	requestLog := services.NewRequestLogService(dbService, services.LoadRequestLogConfig(), logger)
+	if requestLog != nil {
+		scheduler.Register(requestLog.PruneJob())
+	}
+	requestLogHandler := handlers.NewRequestLogHandler(requestLog, logger.WithField("component", "request_log"))
+
+	// Account browsing and export for admins
+	adminUsersHandler := handlers.NewAdminUsersHandler(userService, logger.WithField("component", "admin_users"))
+
+	// Training data curation; users' intent corrections are kept as labels too
+	clients.Training = services.NewTrainingService(dbService, logger)
+	trainingHandler := handlers.NewTrainingHandler(clients.Training, logger.WithField("component", "training"))
+
+	// Human review of low-confidence enhancements; reviewers can drain the
+	// queue even after new enqueues are switched off
+	clients.EnhancementReviews = services.NewEnhancementReviewService(dbService, userService, emailService, services.LoadReviewQueueConfig(), logger)
+	enhancementReviewHandler := handlers.NewEnhancementReviewHandler(clients.EnhancementReviews, logger.WithField("component", "enhancement_reviews"))
+
+	// Admin-defined technique presets, applied on request or when the selector fails
+	clients.TechniquePresets = services.NewTechniquePresetService(dbService, logger)
+	techniquePresetHandler := handlers.NewTechniquePresetHandler(clients.TechniquePresets, logger.WithField("component", "technique_presets"))
+
+	// Users' enhancement profiles, picked per request or by their preferences
+	clients.EnhancementProfiles = services.NewEnhancementProfileService(dbService)
+	enhancementProfileHandler := handlers.NewEnhancementProfileHandler(clients.EnhancementProfiles, logger.WithField("component", "enhancement_profiles"))
+
+	// Settings bundles users carry between accounts
+	settingsService := services.NewSettingsService(dbService, clients.EnhancementProfiles, logger)
+	settingsHandler := handlers.NewSettingsHandler(settingsService, logger.WithField("component", "settings"))
+
+	// Technique affinity learned from feedback and reruns, sent to the
+	// selector as per-user weights
+	techniqueAffinity := services.NewTechniqueAffinityService(dbService, logger)
+	scheduler.Register(techniqueAffinity.AffinityJob(services.TechniqueAffinityInterval()))
+	if selector, ok := clients.TechniqueSelector.(*services.TechniqueSelectorClient); ok {
+		selector.SetTechniqueWeights(techniqueAffinity)
+	}
+	techniqueAffinityHandler := handlers.NewTechniqueAffinityHandler(techniqueAffinity, logger.WithField("component", "technique_affinity"))
+
+	// prompts.history is partitioned by month; partitions are made ahead of
+	// time and detached once past retention
+	historyPartitions := services.NewHistoryPartitionService(dbService, services.LoadHistoryPartitionConfig(), logger)
+	scheduler.Register(historyPartitions.MaintenanceJob())
+
+	// Caller tier, organization and feature flags, resolved once per request
+	// after authentication and handed to the pipeline
+	accountResolver := services.NewAccountResolver(dbService, time.Minute)
+
+	// Organizations pinned to a region have their history kept in that
+	// region's database and unreadable from other regions
+	residencyConfig, err := services.LoadResidencyConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid data residency configuration")
+	}
+	residency, err := services.NewResidencyService(dbService, residencyConfig, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to data residency databases")
+	}
+	if residency.Enabled() {
+		logger.WithField("regions", residency.AvailableRegions()).Info("Data residency enabled")
+	}
+	clients.History = residency.WrapHistory(clients.History, accountResolver)
+
+	// Old history moved out of Postgres into compressed objects, still
+	// readable by ID through the archive
+	archiveConfig := services.LoadHistoryArchiveConfig()
+	var archiveStorage services.ObjectStorage
+	if archiveConfig.Enabled {
+		if archiveStorage, err = services.NewArchiveStorageFromEnv(); err != nil {
+			logger.WithError(err).Warn("History archiving disabled: invalid archive storage configuration")
+			archiveStorage = nil
+		}
+	}
+	historyArchive := services.NewHistoryArchiveService(dbService, archiveStorage, archiveConfig, logger)
+	if historyArchive.Enabled() {
+		scheduler.Register(historyArchive.ArchiveJob())
+		clients.History = historyArchive.Wrap(clients.History)
+	}
+
+	// Technique feedback is summed in memory and written once per interval;
+	// batches left unwritten by a previous shutdown are replayed first
+	clients.Effectiveness = services.NewEffectivenessAggregator(dbService, clients.Cache, services.LoadEffectivenessAggregatorConfig(), logger)
+	scheduler.Register(clients.Effectiveness.PruneJob())
+	catchUpCtx, cancelCatchUp := context.WithTimeout(context.Background(), 30*time.Second)
+	if replayed, err := clients.Effectiveness.CatchUp(catchUpCtx); err != nil {
+		logger.WithError(err).Warn("Failed to replay spilled technique effectiveness batches")
+	} else if replayed > 0 {
+		logger.WithField("batches", replayed).Info("Replayed spilled technique effectiveness batches")
+	}
+	cancelCatchUp()
+
+	// Feedback is rate-limited per history item, and spam is marked so
+	// effectiveness analytics leave it out
+	clients.FeedbackFilter = services.NewFeedbackFilter(clients.Cache, services.LoadFeedbackFilterConfig(), logger)
+
+	// Who may read, rate and delete a prompt: its owner, admins, members of
+	// the owner's organization and share-token holders
+	clients.PromptAuthz = services.NewPromptAuthorizer(accountResolver)
+
+	// Organizations that isolate their data get their own keys; their
+	// members' history is sealed and unreadable from outside the organization
+	tenantKeyConfig, err := services.LoadTenantKeyConfig()
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid tenant key configuration")
+	}
+	tenantKeys, err := services.NewTenantKeyService(dbService, tenantKeyConfig, logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to create tenant key service")
+	}
+	if tenantKeys.Enabled() {
+		clients.History = tenantKeys.WrapHistory(clients.History, accountResolver)
+	}
+	// Bulk history actions go through the fully wrapped store. Collections
+	// reference prompts.history, so they need history kept in Postgres.
+	if store := os.Getenv("HISTORY_STORE"); store == "" || store == "postgres" {
+		clients.HistoryBulk = services.NewHistoryBulkService(dbService, clients.History, logger)
+	}
+	tenantIsolationHandler := handlers.NewTenantIsolationHandler(tenantKeys, clients.History, logger.WithField("component", "tenant_isolation"))
+	residencyHandler := handlers.NewResidencyHandler(residency, clients.History, logger.WithField("component", "residency"))
+
+	// Discussion on saved prompts between the owner and their organization;
+	// comments with too many links wait for an admin
+	promptCommentService := services.NewPromptCommentService(dbService, emailService, clients.PromptAuthz, logger, services.LoadCommentConfig().Hooks()...)
+	promptCommentHandler := handlers.NewPromptCommentHandler(promptCommentService, clients.PromptAuthz, logger.WithField("component", "prompt_comments"))
+
+	// Enhancements pinned for production use, served from a copy that
+	// doesn't drift with the cache or model; organizations with isolated or
+	// regional history can't pin
+	clients.Pins = services.NewPinService(dbService, clients.Cache, accountResolver, tenantKeys, residency, logger)
+
+	// Everything that runs the enhancement pipeline or reads prompt history
+	// is built from one set of dependencies, once the optional services above
+	// are attached to the clients
+	deps := handlers.NewDependencies(clients)
+	enhanceHandler := handlers.NewEnhanceHandler(deps)
+	historyHandler := handlers.NewHistoryHandler(deps)
+	integrationHandler := handlers.NewIntegrationHandler(deps, logger.WithField("component", "integrations"))
+
+	// Public demo on the landing page, for visitors without an account
+	demoConfig := services.LoadDemoConfig()
+	demo, err := services.NewDemoService(demoConfig, clients.Cache, logger)
+	if errors.Is(err, services.ErrDemoSecretMissing) {
+		logger.WithError(err).Warn("Public demo disabled: no key to sign challenges with")
+	} else if err != nil {
+		logger.WithError(err).Error("Public demo disabled")
+	}
+
+	// Live editing sessions on saved prompts for the owner and their
+	// organization, streamed to each participant
+	collabService := services.NewCollabService(userService, services.LoadCollabConfig(), logger)
+	collabHandler := handlers.NewCollabHandler(deps, collabService, dbService, logger.WithField("component", "collab"))
+
+	// Abuse detection needs Redis for its sliding windows; without it the
+	// guard is a no-op
+	var abuseService *services.AbuseService
+	if clients.Cache != nil {
+		abuseService = services.NewAbuseService(clients.Cache, dbService, userService, emailService, logger)
+	}
+	abuseGuard := middleware.AbuseGuard(abuseService, logger)
+
+	// The account is resolved once per request after authentication
+	requestContext := middleware.RequestContextMiddleware(accountResolver, logger)
+
+	// Stripe subscriptions for the paid tiers; webhooks update the user's
+	// tier and quota
+	billingService := services.NewBillingService(dbService, services.LoadBillingConfig(), accountResolver, logger)
+	authHandler.EnableBilling(billingService)
+	billingHandler := handlers.NewBillingHandler(billingService, logger.WithField("component", "billing"))
+
+	// Finding and merging duplicate accounts; merges are reversible
+	accountMergeService := services.NewAccountMergeService(dbService, clients.History, accountResolver, logger)
+	accountMergeHandler := handlers.NewAccountMergeHandler(accountMergeService, logger.WithField("component", "account_merges"))
+
+	// New users start on a pro trial; the job warns and downgrades them
+	trialConfig := services.LoadTrialConfig()
+	trialService := services.NewTrialService(dbService, emailService, accountResolver, trialConfig, logger)
+	if trialService.Enabled() {
+		scheduler.Register(trialService.TrialJob())
+	}
+	authHandler.EnableTrials(trialService)
+	trialRateLimit := middleware.TrialRateLimitConfig(trialConfig.DailyQuota)
+
+	// Referral codes and promo coupons, both redeemable at signup for extra enhancements
+	referralService := services.NewReferralService(dbService, services.LoadReferralConfig(), logger)
+	couponService := services.NewCouponService(dbService, logger)
+	authHandler.EnablePromoCodes(referralService, couponService)
+	referralHandler := handlers.NewReferralHandler(referralService, logger.WithField("component", "referrals"))
+	couponHandler := handlers.NewCouponHandler(couponService, logger.WithField("component", "coupons"))
+
+	// Single sign-on through organizations' OpenID Connect providers; their
+	// members can't sign in or sign up with a password
+	ssoService := services.NewSSOService(dbService, services.LoadSSOConfig(jwtManager.GetConfig().SecretKey), accountResolver, logger)
+	authHandler.EnableSSO(ssoService)
+	ssoHandler := handlers.NewSSOHandler(ssoService, logger.WithField("component", "sso"))
+
+	// SCIM provisioning from organizations' enterprise directories
+	scimService := services.NewSCIMService(dbService, clients.Cache, accountResolver, logger)
+	scimHandler := handlers.NewSCIMHandler(scimService, logger.WithField("component", "scim"))
+
+	// Announcement banners, polled by the frontend
+	announcementService := services.NewAnnouncementService(dbService, clients.Cache, logger)
+	scheduler.Register(announcementService.PurgeJob())
+	announcementHandler := handlers.NewAnnouncementHandler(announcementService, logger.WithField("component", "announcements"))
+
+	// In-product changelog, and first uses of the features it announces
+	changelogService := services.NewChangelogService(dbService, logger)
+	featureAdoption := services.NewFeatureAdoptionService(dbService, logger)
+	changelogHandler := handlers.NewChangelogHandler(changelogService, featureAdoption, logger.WithField("component", "changelog"))
+
+	// Failed logins are limited per account and IP, with stricter limits for
+	// accounts attacked from many IPs
+	var loginThrottle *services.LoginThrottle
+	if clients.Cache != nil {
+		loginThrottle = services.NewLoginThrottle(clients.Cache, services.DefaultLoginThrottleConfig())
+	}
+
+	// Per-route deadlines; the request context carries them to downstream calls
+	routeTimeouts := middleware.LoadRouteTimeoutConfig()
+	analyzeTimeout := middleware.RouteTimeout(routeTimeouts.Analyze, logger)
+	enhanceTimeout := middleware.RouteTimeout(routeTimeouts.Enhance, logger)
+	exportTimeout := middleware.RouteTimeout(routeTimeouts.Export, logger)
+
+	// Rate limiters, shared with GET /limits so clients see the limits actually enforced
+	webRateLimit := middleware.GetRateLimitConfigForEnvironment(environment)
+	extensionRateLimit := middleware.ExtensionRateLimitConfig()
+
+	// Callers nearing a limit are warned in response headers, and the
+	// crossings feed abuse detection and optional in-app notices
+	rateLimitWarningConfig := services.LoadRateLimitWarningConfig()
+	rateLimitWarnings := services.NewRateLimitWarnings(clients.Cache, abuseService, rateLimitWarningConfig, logger)
+	for _, limiter := range []*middleware.RateLimitConfig{&webRateLimit, &extensionRateLimit, &trialRateLimit} {
+		limiter.WarnAt = rateLimitWarningConfig.Threshold
+		limiter.OnWarning = middleware.RecordRateLimitWarnings(rateLimitWarnings)
+	}
+
+	// Simultaneous in-flight enhancements per user or API key, by tier
+	var concurrencyLimiter *services.ConcurrencyLimiter
+	if clients.Cache != nil {
+		concurrencyLimiter = services.NewConcurrencyLimiter(clients.Cache, services.LoadConcurrencyConfig())
+	}
+	abuseHandler := handlers.NewAbuseHandler(abuseService, logger.WithField("component", "abuse"))
+
+	// Network access rules; admin changes are persisted in Redis
+	var geoIP services.CountryResolver
+	if path := os.Getenv("GEOIP_DATABASE_PATH"); path != "" {
+		geoDB, err := services.LoadGeoIPDatabase(path)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to load GeoIP database")
+		}
+		logger.WithField("networks", geoDB.Len()).Info("GeoIP database loaded")
+		geoIP = geoDB
+	}
+	networkAccess, err := services.NewNetworkAccessService(clients.Cache, geoIP, services.LoadNetworkAccessRules(), logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid network access configuration")
+	}
+	networkAccessHandler := handlers.NewNetworkAccessHandler(networkAccess, logger.WithField("component", "network_access"))
+
+	// Fine-grained cache administration; a nil *CacheService must stay a nil interface
+	var cacheInspector handlers.CacheInspector
+	if clients.Cache != nil {
+		cacheInspector = clients.Cache
+	}
+	cacheAdminHandler := handlers.NewCacheAdminHandler(cacheInspector, logger.WithField("component", "cache_admin"))
+
+	// Activity event bus for the admin live feed, shared across instances via Redis
+	eventBus := services.NewEventBus(logger)
+	if clients.Cache != nil {
+		eventBus.BridgeRedis(context.Background(), clients.Cache)
+	}
+
+	// Intent classifier drift against a trailing baseline; alerts go to the
+	// admin activity feed and admin inboxes
+	intentDriftService := services.NewIntentDriftService(dbService, emailService, eventBus, services.LoadIntentDriftConfig(), logger)
+	scheduler.Register(intentDriftService.MonitorJob())
+	intentDriftHandler := handlers.NewIntentDriftHandler(intentDriftService, logger.WithField("component", "intent_drift"))
+
+	// Built-in alerts on this instance's error rate, latency and classifier
+	// fallbacks, for deployments without a monitoring stack
+	alertService := services.NewAlertService(dbService, emailService, eventBus, services.LoadAlertConfig(logger), logger)
+	go alertService.Run(context.Background())
+	alertHandler := handlers.NewAlertHandler(alertService, logger.WithField("component", "alerts"))
+
+	scheduler.Start(context.Background())
+
+	// Rolling deploys drain the gateway first: readiness fails while traffic
+	// is still served, until the load balancer has moved it elsewhere
+	drainer := services.NewDrainer(services.LoadDrainMinDuration())
+	drainHandler := handlers.NewDrainHandler(drainer, logger.WithField("component", "drain"))
+
 	// Setup Gin router
 	router := gin.New()
+	if err := middleware.TrustProxies(router, services.LoadTrustedProxies()); err != nil {
+		logger.WithError(err).Fatal("Invalid TRUSTED_PROXIES")
+	}
 	
 	// Add middleware
-	router.Use(gin.Recovery())
+	router.Use(middleware.TrafficStats("/metrics", startup.LivePath, "/api/v1/health", "/api/v1/ready"))
+	router.Use(middleware.Recovery(crashReporter, logger))
+	router.Use(middleware.Drain(drainer))
 	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestIDInErrors())
+	if clients.Cache != nil && clients.Cache.Region() != "" {
+		router.Use(middleware.Region(clients.Cache.Region()))
+	}
 	router.Use(middleware.Logger(logger))
+	router.Use(middleware.RequestLog(requestLog))
+	router.Use(middleware.ActivityEvents(eventBus))
+	router.Use(middleware.AccessLogging(accessLog))
+	router.Use(middleware.NetworkAccess(networkAccess, logger, startup.LivePath, "/api/v1/health", "/api/v1/ready"))
 	router.Use(middleware.SessionMiddleware(clients.Cache, logger))
 	
-	// CORS configuration
-	router.Use(middleware.CORSConfig(logger))
+	// CORS policies per route group; startup fails on an unsafe policy
+	corsHandler, err := middleware.RouteCORS(logger,
+		middleware.CORSRoute{PathPrefix: "/", Policy: middleware.PublicCORSPolicy()},
+		middleware.CORSRoute{PathPrefix: "/api/v1/admin", Policy: middleware.AdminCORSPolicy()},
+		middleware.CORSRoute{PathPrefix: "/api/v1/integrations", Policy: middleware.IntegrationsCORSPolicy()},
+		middleware.CORSRoute{PathPrefix: "/api/v1/quick-enhance", Policy: middleware.ExtensionCORSPolicy()},
+	)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid CORS configuration")
+	}
+	router.Use(corsHandler)
+
+	// Prometheus metrics
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET(startup.LivePath, handlers.LivenessCheck)
 
 	// Public routes
 	public := router.Group("/api/v1")
 	{
 		// Health check
 		public.GET("/health", handlers.HealthCheck)
-		public.GET("/ready", handlers.ReadinessCheck(clients))
+		public.GET("/ready", drainHandler.GateReadiness, handlers.ReadinessCheck(clients))
+		public.GET("/versions", handlers.GetVersions(clients))
 		
 		// Authentication routes
 		public.POST("/auth/register", authHandler.Register)
-		public.POST("/auth/login", authHandler.Login)
+		public.POST("/auth/login",
+			middleware.LoginThrottle(loginThrottle, abuseService, userService, logger),
+			authHandler.Login)
 		public.POST("/auth/refresh", authHandler.RefreshToken)
 		public.POST("/auth/verify-email", authHandler.VerifyEmail)
 		public.POST("/auth/resend-verification", authHandler.ResendVerification)
+		public.POST("/auth/email-change/confirm", authHandler.ConfirmEmailChange)
+		public.GET("/auth/availability", authHandler.CheckAvailability)
+		public.POST("/auth/sso/discover", authHandler.SSODiscover)
+		public.GET("/auth/sso/login", authHandler.SSOLogin)
+		public.GET("/auth/sso/callback", authHandler.SSOCallback)
+		public.GET("/handles/:username", authHandler.ResolveHandle)
+		public.POST("/billing/webhook", billingHandler.Webhook)
+		public.GET("/avatars/:user_id", avatarHandler.GetAvatar)
+		if local, ok := storage.(*services.LocalStorage); ok {
+			public.GET("/storage/*key", handlers.ServeLocalObject(local))
+		}
 		
 		// Public analysis endpoint (optional auth)
 		public.POST("/analyze", 
+			analyzeTimeout,
 			middleware.OptionalAuth(jwtManager, logger),
-			handlers.AnalyzeIntent(clients))
+			requestContext,
+			enhanceHandler.Analyze)
+		public.POST("/analyze/quality",
+			middleware.EndpointRateLimitMiddleware(clients.Cache, "analyze_quality", 60, time.Minute, logger),
+			handlers.AnalyzeQuality)
+		public.POST("/tokens/count",
+			middleware.EndpointRateLimitMiddleware(clients.Cache, "tokens_count", 120, time.Minute, logger),
+			handlers.CountTokens(clients.Tokenizers))
 		
 		// Techniques endpoint (public)
 		public.GET("/techniques", handlers.GetAvailableTechniques(clients))
+
+		// Technique trends; anonymized unless the caller is an admin
+		public.GET("/analytics/techniques/trends",
+			middleware.OptionalAuth(jwtManager, logger),
+			techniqueTrendHandler.GetTrends)
+
+		// Public stats for the marketing site
+		public.GET("/stats/public",
+			middleware.EndpointRateLimitMiddleware(clients.Cache, "stats_public", 10, time.Minute, logger),
+			publicStatsHandler.GetPublicStats)
+
+		// Announcement banners for the caller's tier and roles
+		public.GET("/announcements",
+			middleware.OptionalAuth(jwtManager, logger),
+			requestContext,
+			announcementHandler.GetAnnouncements)
+
+		// Release notes
+		public.GET("/changelog", changelogHandler.GetChangelog)
 		
 		// Main enhancement endpoint (public with optional auth)
 		public.POST("/enhance", 
+			enhanceTimeout,
+			tokenOrSession(services.TokenScopeEnhance, middleware.OptionalAuth(jwtManager, logger)),
+			requestContext,
+			abuseGuard,
+			middleware.RateLimitMiddleware(clients.Cache, webRateLimit, logger),
+			middleware.RateLimitMiddleware(clients.Cache, trialRateLimit, logger),
+			middleware.ConcurrencyLimit(concurrencyLimiter, logger),
+			enhanceHandler.Enhance)
+
+		// Enhancements that outlived the generation soft timeout
+		public.GET("/enhance/jobs/:id",
+			tokenOrSession(services.TokenScopeEnhance, middleware.OptionalAuth(jwtManager, logger)),
+			enhanceHandler.GetJob)
+
+		// Lightweight enhancement for browser extensions
+		public.POST("/quick-enhance",
+			enhanceTimeout,
+			tokenOrSession(services.TokenScopeEnhance, middleware.OptionalAuth(jwtManager, logger)),
+			requestContext,
+			abuseGuard,
+			middleware.RateLimitMiddleware(clients.Cache, extensionRateLimit, logger),
+			middleware.ConcurrencyLimit(concurrencyLimiter, logger),
+			middleware.TrackFeature(featureAdoption, services.FeatureQuickEnhance),
+			enhanceHandler.QuickEnhance)
+
+		// Landing page demo: no account, but a challenge to solve and daily
+		// caps per IP and overall
+		if demo != nil {
+			demoHandler := handlers.NewDemoHandler(deps, demo)
+			demoCaps := middleware.DemoRateLimitConfigs(demoConfig.PerIPDaily, demoConfig.GlobalDaily)
+			public.GET("/demo/challenge",
+				middleware.EndpointRateLimitMiddleware(clients.Cache, "demo_challenge", 30, time.Minute, logger),
+				demoHandler.GetChallenge)
+			public.POST("/demo/enhance",
+				enhanceTimeout,
+				middleware.DemoChallenge(demo, logger),
+				middleware.RateLimitMiddleware(clients.Cache, demoCaps[0], logger),
+				middleware.RateLimitMiddleware(clients.Cache, demoCaps[1], logger),
+				middleware.ConcurrencyLimit(concurrencyLimiter, logger),
+				demoHandler.Enhance)
+		}
+
+		// Caller's current rate limit standing
+		public.GET("/limits",
 			middleware.OptionalAuth(jwtManager, logger),
-			middleware.RateLimitMiddleware(clients.Cache, middleware.GetRateLimitConfigForEnvironment(environment), logger),
-			handlers.EnhancePrompt(clients))
+			requestContext,
+			handlers.GetLimits(clients.Cache, abuseService, rateLimitWarnings, webRateLimit, extensionRateLimit, trialRateLimit))
 	}
 
 	// Protected routes
 	protected := router.Group("/api/v1")
 	protected.Use(middleware.AuthMiddleware(jwtManager, logger))
+	protected.Use(requestContext)
+	protected.Use(abuseGuard)
 	{
 		// User profile
 		protected.GET("/auth/profile", authHandler.GetProfile)
 		protected.PUT("/auth/profile", authHandler.UpdateProfile)
+		protected.GET("/preferences", authHandler.GetPreferences)
+		protected.PUT("/preferences", authHandler.UpdatePreferences)
+		protected.GET("/profiles", enhancementProfileHandler.ListProfiles)
+		protected.POST("/profiles", middleware.TrackFeature(featureAdoption, services.FeatureProfiles), enhancementProfileHandler.CreateProfile)
+		protected.GET("/profiles/:name", enhancementProfileHandler.GetProfile)
+		protected.PUT("/profiles/:name", enhancementProfileHandler.UpdateProfile)
+		protected.DELETE("/profiles/:name", enhancementProfileHandler.DeleteProfile)
+		protected.GET("/settings/export", middleware.AuditAccess(services.AccessDataAccess, "settings_export", ""), settingsHandler.ExportSettings)
+		protected.POST("/settings/import",
+			middleware.EndpointRateLimitMiddleware(clients.Cache, "settings_import", 10, time.Hour, logger),
+			settingsHandler.ImportSettings)
+		protected.GET("/me/technique-affinity", techniqueAffinityHandler.GetAffinity)
+		protected.DELETE("/me/technique-affinity", techniqueAffinityHandler.ResetAffinity)
+		protected.GET("/billing/subscription", billingHandler.GetSubscription)
+		protected.PUT("/billing/subscription", billingHandler.ChangePlan)
+		protected.POST("/billing/checkout", billingHandler.CreateCheckout)
+		protected.GET("/billing/invoices", billingHandler.ListInvoices)
+		protected.GET("/referrals", referralHandler.GetReferrals)
+		protected.POST("/coupons/redeem", couponHandler.RedeemCoupon)
+		protected.POST("/announcements/:id/dismiss", announcementHandler.DismissAnnouncement)
 		protected.POST("/auth/change-password", authHandler.ChangePassword)
 		protected.POST("/auth/logout", authHandler.Logout)
+		protected.GET("/auth/devices", deviceHandler.ListDevices)
+		protected.PUT("/auth/devices/:id", deviceHandler.RenameDevice)
+		protected.DELETE("/auth/devices/:id", deviceHandler.RevokeDevice)
+		protected.POST("/auth/tokens", personalTokenHandler.CreateToken)
+		protected.GET("/auth/tokens", personalTokenHandler.ListTokens)
+		protected.DELETE("/auth/tokens/:id", personalTokenHandler.RevokeToken)
+		protected.POST("/auth/email-change", authHandler.RequestEmailChange)
+		protected.GET("/auth/email-change", authHandler.GetEmailChange)
+		protected.DELETE("/auth/email-change", authHandler.CancelEmailChange)
+		protected.POST("/auth/avatar", avatarHandler.UploadAvatar)
+		protected.DELETE("/auth/avatar", avatarHandler.DeleteAvatar)
 		
 		// Batch enhancement endpoint (commented out - not implemented yet)
 		// protected.POST("/enhance/batch",
 		// 	middleware.RateLimitMiddleware(clients.Cache, middleware.GetRateLimitConfigForEnvironment(environment), logger),
 		// 	handlers.HandleBatchEnhance(clients))
 		
-		// Prompt history endpoints
-		protected.GET("/prompts/history", handlers.GetPromptHistory(clients))
-		protected.GET("/prompts/:id", handlers.GetPromptByID(clients))
-		protected.POST("/prompts/:id/rerun", handlers.RerunPrompt(clients))
+		// Prompt history endpoints; reading is in the history group below
+		protected.POST("/prompts/:id/rerun", enhanceTimeout, middleware.TrackFeature(featureAdoption, services.FeatureRerun), historyHandler.RerunPrompt)
+		protected.POST("/prompts/bulk", middleware.AuditAccess(services.AccessDataAccess, "history_bulk", ""), historyHandler.BulkUpdateHistory)
+		
+		// Pinned enhancements; reading is in the history group below
+		protected.POST("/pins", historyHandler.PinPrompt)
+		protected.POST("/pins/:id/versions", enhanceTimeout, historyHandler.ReenhancePin)
+		protected.DELETE("/pins/:id", historyHandler.UnpinPrompt)
 		
 		// Legacy history endpoints (for backward compatibility)
-		protected.GET("/history", handlers.GetPromptHistory(clients))
-		protected.GET("/history/:id", handlers.GetPromptHistoryItem(clients))
-		protected.DELETE("/history/:id", handlers.DeletePromptHistoryItem(clients))
+		protected.DELETE("/history/:id", middleware.AuditAccess(services.AccessDataAccess, "history_delete", ""), historyHandler.DeletePromptHistoryItem)
 		
+		// Comments on saved prompts
+		protected.GET("/library/prompts/:id/comments", promptCommentHandler.ListComments)
+		protected.POST("/library/prompts/:id/comments", promptCommentHandler.CreateComment)
+		protected.PUT("/library/prompts/:id/comments/:comment_id", promptCommentHandler.UpdateComment)
+		protected.DELETE("/library/prompts/:id/comments/:comment_id", promptCommentHandler.DeleteComment)
+
+		// Live editing sessions on saved prompts
+		protected.GET("/collab/prompts/:id/session", collabHandler.JoinSession)
+		protected.POST("/collab/prompts/:id/operations", collabHandler.SubmitEdit)
+		protected.POST("/collab/prompts/:id/presence", collabHandler.UpdatePresence)
+		protected.POST("/collab/prompts/:id/enhance",
+			enhanceTimeout,
+			middleware.RateLimitMiddleware(clients.Cache, webRateLimit, logger),
+			middleware.RateLimitMiddleware(clients.Cache, trialRateLimit, logger),
+			middleware.ConcurrencyLimit(concurrencyLimiter, logger),
+			collabHandler.EnhanceDraft)
+
 		// Techniques selection endpoint (requires auth to save preferences)
 		protected.POST("/techniques/select", handlers.SelectTechniques(clients))
 		
@@ -149,6 +716,29 @@ func main() {
 		protected.POST("/feedback", feedbackHandler.SubmitFeedback)
 		protected.GET("/feedback/:prompt_history_id", feedbackHandler.GetFeedback)
 		protected.POST("/feedback/effectiveness", feedbackHandler.GetTechniqueEffectiveness)
+
+		// Search click-through tracking
+		protected.POST("/search/click", searchAnalyticsHandler.RecordClick)
+	}
+
+	// Reading prompt history, also open to personal access tokens scoped read-history
+	history := router.Group("/api/v1")
+	history.Use(tokenOrSession(services.TokenScopeReadHistory, middleware.AuthMiddleware(jwtManager, logger)))
+	history.Use(requestContext)
+	history.Use(abuseGuard)
+	{
+		history.GET("/prompts/history", middleware.AuditAccess(services.AccessDataAccess, "history_list", ""), historyHandler.GetPromptHistory)
+		history.GET("/prompts/:id", middleware.AuditAccess(services.AccessDataAccess, "history_view", ""), historyHandler.GetPromptByID)
+		history.GET("/prompts/:id/export", exportTimeout, middleware.TrackFeature(featureAdoption, services.FeaturePromptExport), middleware.AuditAccess(services.AccessDataAccess, "history_export", ""), historyHandler.ExportPrompt)
+
+		// Legacy history endpoints (for backward compatibility)
+		history.GET("/history", middleware.AuditAccess(services.AccessDataAccess, "history_list", ""), historyHandler.GetPromptHistory)
+		history.GET("/history/:id", middleware.AuditAccess(services.AccessDataAccess, "history_view", ""), historyHandler.GetPromptHistoryItem)
+
+		// Pinned enhancements at their stable URLs
+		history.GET("/pins", historyHandler.ListPins)
+		history.GET("/pins/:id", historyHandler.GetPin)
+		history.GET("/pins/:id/versions/:version", historyHandler.GetPinVersion)
 	}
 
 	// Admin routes
@@ -157,10 +747,21 @@ func main() {
 	admin.Use(middleware.RequireRole("admin"))
 	{
 		// User management
-		admin.GET("/users", handlers.GetUsers(clients))
-		admin.GET("/users/:id", handlers.GetUser(clients))
-		admin.PUT("/users/:id", handlers.UpdateUser(clients))
+		admin.GET("/users", adminUsersHandler.ListUsers)
+		admin.GET("/users/:id", middleware.AuditAccess(services.AccessDataAccess, "admin_view_user", "id"), handlers.GetUser(clients))
+		admin.PUT("/users/:id", middleware.AuditAccess(services.AccessAdminAction, "admin_update_user", "id"), handlers.UpdateUser(clients))
 		admin.DELETE("/users/:id", handlers.DeleteUser(clients))
+		admin.GET("/users/:id/access-log", middleware.AuditAccess(services.AccessDataAccess, "admin_export_access_log", "id"), accessLogHandler.GetUserAccessLog)
+		admin.GET("/requests", requestLogHandler.SearchRequests)
+
+		// Duplicate accounts
+		admin.GET("/accounts/duplicates", accountMergeHandler.FindDuplicates)
+		admin.POST("/accounts/merges/preview", accountMergeHandler.PreviewMerge)
+		admin.POST("/accounts/merges", accountMergeHandler.MergeAccounts)
+		admin.GET("/accounts/merges", accountMergeHandler.ListMerges)
+		admin.GET("/accounts/merges/:id", accountMergeHandler.GetMerge)
+		admin.POST("/accounts/merges/:id/revert", accountMergeHandler.RevertMerge)
+		admin.POST("/accounts/merges/:id/history", accountMergeHandler.ResumeMergeHistory)
 		
 		// System metrics
 		admin.GET("/metrics", handlers.GetSystemMetrics(clients))
@@ -168,7 +769,141 @@ func main() {
 		
 		// Cache management
 		admin.POST("/cache/clear", handlers.ClearCache(clients))
-		admin.POST("/cache/invalidate/:user_id", handlers.InvalidateUserCache(clients))
+		admin.POST("/cache/invalidate/:user_id", middleware.AuditAccess(services.AccessAdminAction, "admin_invalidate_cache", "user_id"), handlers.InvalidateUserCache(clients))
+		admin.GET("/cache/keys", cacheAdminHandler.ListKeys)
+		admin.GET("/cache/entry", cacheAdminHandler.GetEntry)
+		admin.DELETE("/cache/entry", cacheAdminHandler.EvictKey)
+		admin.POST("/cache/evict", cacheAdminHandler.EvictPattern)
+		admin.GET("/cache/stats", cacheAdminHandler.GetStats)
+
+		// Search analytics
+		admin.GET("/analytics/search", searchAnalyticsHandler.GetSummary)
+		admin.GET("/analytics/cohorts", cohortHandler.GetCohorts)
+		admin.POST("/analytics/cohorts/refresh", cohortHandler.RefreshCohorts)
+		admin.GET("/analytics/intent-drift", intentDriftHandler.GetDrift)
+		admin.POST("/analytics/intent-drift/refresh", intentDriftHandler.RefreshDrift)
+		admin.GET("/alerts", alertHandler.ListAlerts)
+		admin.GET("/analytics/presets", techniquePresetHandler.GetUsage)
+		admin.GET("/analytics/features", changelogHandler.GetAdoption)
+
+		// Technique presets
+		admin.GET("/presets", techniquePresetHandler.ListPresets)
+		admin.POST("/presets", techniquePresetHandler.CreatePreset)
+		admin.GET("/presets/:id", techniquePresetHandler.GetPreset)
+		admin.PUT("/presets/:id", techniquePresetHandler.UpdatePreset)
+		admin.DELETE("/presets/:id", techniquePresetHandler.DeletePreset)
+
+		// Promo coupons and referral review
+		admin.GET("/coupons", couponHandler.ListCoupons)
+		admin.POST("/coupons", couponHandler.CreateCoupon)
+		admin.GET("/coupons/:code", couponHandler.GetCoupon)
+		admin.PUT("/coupons/:code", couponHandler.UpdateCoupon)
+		admin.DELETE("/coupons/:code", couponHandler.DeleteCoupon)
+		admin.GET("/referrals", referralHandler.ListReferrals)
+
+		// Announcement banners
+		admin.GET("/announcements", announcementHandler.ListAnnouncements)
+		admin.POST("/announcements", announcementHandler.CreateAnnouncement)
+		admin.GET("/announcements/:id", announcementHandler.GetAnnouncement)
+		admin.PUT("/announcements/:id", announcementHandler.UpdateAnnouncement)
+		admin.DELETE("/announcements/:id", announcementHandler.DeleteAnnouncement)
+
+		// Changelog
+		admin.GET("/changelog", changelogHandler.ListEntries)
+		admin.POST("/changelog", changelogHandler.CreateEntry)
+		admin.GET("/changelog/:id", changelogHandler.GetEntry)
+		admin.PUT("/changelog/:id", changelogHandler.UpdateEntry)
+		admin.DELETE("/changelog/:id", changelogHandler.DeleteEntry)
+
+		// Warehouse exports
+		admin.GET("/exports", warehouseExportHandler.ListExports)
+		admin.GET("/exports/:id", warehouseExportHandler.GetExport)
+		admin.POST("/exports", warehouseExportHandler.TriggerExport)
+
+		// Live activity feed
+		admin.GET("/activity/stream", handlers.ActivityStream(eventBus))
+
+		// Connection draining for rolling deploys
+		admin.GET("/health/drain", drainHandler.GetDrain)
+		admin.POST("/health/drain", drainHandler.StartDrain)
+		admin.DELETE("/health/drain", drainHandler.CancelDrain)
+
+		// Network access rules
+		admin.GET("/network-access", networkAccessHandler.GetRules)
+		admin.PUT("/network-access", networkAccessHandler.UpdateRules)
+		admin.GET("/network-access/check", networkAccessHandler.CheckIP)
+
+		// Abuse review queue
+		if abuseService != nil {
+			admin.GET("/abuse/reviews", abuseHandler.ListReviews)
+			admin.POST("/abuse/reviews/:id/resolve", abuseHandler.ResolveReview)
+			admin.GET("/abuse/users/:user_id", middleware.AuditAccess(services.AccessDataAccess, "admin_view_restriction", "user_id"), abuseHandler.GetUserRestriction)
+		}
+
+		// Comments held by moderation hooks
+		admin.GET("/comments/held", promptCommentHandler.ListHeldComments)
+		admin.PUT("/comments/:id/moderation", promptCommentHandler.ModerateComment)
+
+		// Scheduled reports, scoped to the admin's organization
+		orgAdmin := admin.Group("")
+		orgAdmin.Use(requestContext)
+		orgAdmin.GET("/reports", reportHandler.ListReports)
+		orgAdmin.GET("/reports/:id", reportHandler.GetReport)
+		orgAdmin.GET("/reports/:id/download", reportHandler.DownloadReport)
+		orgAdmin.GET("/report-schedules", reportHandler.ListSchedules)
+		orgAdmin.POST("/report-schedules", reportHandler.CreateSchedule)
+		orgAdmin.PUT("/report-schedules/:id", reportHandler.UpdateSchedule)
+		orgAdmin.DELETE("/report-schedules/:id", reportHandler.DeleteSchedule)
+
+		// Single sign-on for the admin's organization
+		orgAdmin.GET("/sso", ssoHandler.GetConnection)
+		orgAdmin.PUT("/sso", ssoHandler.PutConnection)
+		orgAdmin.DELETE("/sso", ssoHandler.DeleteConnection)
+
+		// Tokens the organization's directory provisions users with
+		orgAdmin.GET("/scim/tokens", scimHandler.ListTokens)
+		orgAdmin.POST("/scim/tokens", scimHandler.CreateToken)
+		orgAdmin.DELETE("/scim/tokens/:id", scimHandler.RevokeToken)
+
+		// Data isolation under the organization's own keys
+		orgAdmin.GET("/tenant-isolation", tenantIsolationHandler.GetStatus)
+		orgAdmin.POST("/tenant-isolation", tenantIsolationHandler.Isolate)
+		orgAdmin.POST("/tenant-isolation/rotate", tenantIsolationHandler.RotateKey)
+		orgAdmin.GET("/tenant-isolation/verify", tenantIsolationHandler.Verify)
+
+		// Region the organization's history is kept in; admins without an
+		// organization see every pinned organization
+		orgAdmin.GET("/residency", residencyHandler.GetResidency)
+		orgAdmin.PUT("/residency", residencyHandler.SetResidency)
+	}
+
+	// Training data curation for the ML team
+	training := router.Group("/api/v1/training")
+	training.Use(middleware.AuthMiddleware(jwtManager, logger))
+	{
+		training.GET("/labels", middleware.RequirePermission("training:read:all"), trainingHandler.ListLabels)
+		training.PUT("/labels/:history_id", middleware.RequirePermission("training:write:all"), trainingHandler.SetLabel)
+		training.DELETE("/labels/:history_id", middleware.RequirePermission("training:write:all"), trainingHandler.DeleteLabel)
+		training.POST("/labels/bulk", middleware.RequirePermission("training:write:all"), trainingHandler.BulkLabel)
+
+		training.GET("/datasets", middleware.RequirePermission("training:read:all"), trainingHandler.ListDatasets)
+		training.POST("/datasets", middleware.RequirePermission("training:write:all"), trainingHandler.CreateDataset)
+		training.GET("/datasets/:id", middleware.RequirePermission("training:read:all"), trainingHandler.GetDataset)
+		training.GET("/datasets/:id/export", middleware.RouteDeadline(routeTimeouts.Export), middleware.RequirePermission("training:read:all"), trainingHandler.ExportDataset)
+
+		training.GET("/intent-labels", middleware.RequirePermission("training:read:all"), trainingHandler.ListIntentLabels)
+		training.GET("/intent-labels/export", middleware.RouteDeadline(routeTimeouts.Export), middleware.RequirePermission("training:read:all"), trainingHandler.ExportIntentLabels)
+	}
+
+	// Human review queue for low-confidence enhancements
+	reviews := router.Group("/api/v1/reviews")
+	reviews.Use(middleware.AuthMiddleware(jwtManager, logger))
+	{
+		reviews.GET("", middleware.RequirePermission("review:read:all"), enhancementReviewHandler.ListReviews)
+		reviews.GET("/:id", middleware.RequirePermission("review:read:all"), enhancementReviewHandler.GetReview)
+		reviews.POST("/:id/claim", middleware.RequirePermission("review:write:all"), enhancementReviewHandler.ClaimReview)
+		reviews.PUT("/:id/correction", middleware.RequirePermission("review:write:all"), enhancementReviewHandler.CorrectReview)
+		reviews.POST("/:id/approve", middleware.RequirePermission("review:write:all"), enhancementReviewHandler.ApproveReview)
 	}
 
 	// Developer API routes
@@ -177,23 +912,106 @@ func main() {
 	developer.Use(middleware.RequireRole("developer", "admin"))
 	{
 		// API key management
-		developer.POST("/api-keys", handlers.CreateAPIKey(clients))
-		developer.GET("/api-keys", handlers.GetAPIKeys(clients))
-		developer.DELETE("/api-keys/:id", handlers.DeleteAPIKey(clients))
+		developer.POST("/api-keys", middleware.TrackFeature(featureAdoption, services.FeatureAPIKeys), apiKeyHandler.CreateAPIKey)
+		developer.GET("/api-keys", apiKeyHandler.GetAPIKeys)
+		developer.DELETE("/api-keys/:id", apiKeyHandler.DeleteAPIKey)
 		
 		// Usage analytics
 		developer.GET("/analytics/usage", handlers.GetDeveloperUsage(clients))
 		developer.GET("/analytics/performance", handlers.GetPerformanceMetrics(clients))
 	}
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// No-code integration routes (Zapier, Make) authenticated by API key
+	integrations := router.Group("/api/v1/integrations")
+	integrations.Use(middleware.APIKeyAuth(apiKeyService, logger))
+	integrations.Use(requestContext)
+	integrations.Use(abuseGuard)
+	{
+		integrations.GET("/auth/test", integrationHandler.TestAuth)
+		integrations.POST("/enhance",
+			middleware.RequireAPIKeyScope(services.APIKeyScopePromptWrite),
+			enhanceTimeout,
+			middleware.RateLimitMiddleware(clients.Cache, webRateLimit, logger),
+			middleware.RateLimitMiddleware(clients.Cache, trialRateLimit, logger),
+			middleware.ConcurrencyLimit(concurrencyLimiter, logger),
+			integrationHandler.Enhance)
+		integrations.GET("/history", middleware.RequireAPIKeyScope(services.APIKeyScopePromptRead), integrationHandler.PollHistory)
+		integrations.GET("/pins/:id", middleware.RequireAPIKeyScope(services.APIKeyScopePromptRead), historyHandler.GetPin)
+		integrations.GET("/pins/:id/versions/:version", middleware.RequireAPIKeyScope(services.APIKeyScopePromptRead), historyHandler.GetPinVersion)
 	}
 
-	logger.Infof("Starting API Gateway on port %s", port)
-	if err := router.Run(fmt.Sprintf(":%s", port)); err != nil {
+	// SCIM 2.0 provisioning, authenticated by an organization's directory token
+	scim := router.Group("/scim/v2")
+	scim.Use(middleware.SCIMAuth(scimService, logger))
+	{
+		scim.GET("/Users", scimHandler.ListUsers)
+		scim.POST("/Users", scimHandler.CreateUser)
+		scim.GET("/Users/:id", scimHandler.GetUser)
+		scim.PUT("/Users/:id", scimHandler.ReplaceUser)
+		scim.PATCH("/Users/:id", scimHandler.PatchUser)
+		scim.DELETE("/Users/:id", scimHandler.DeleteUser)
+		scim.GET("/Groups", scimHandler.ListGroups)
+		scim.POST("/Groups", scimHandler.CreateGroup)
+		scim.GET("/Groups/:id", scimHandler.GetGroup)
+		scim.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+		scim.PATCH("/Groups/:id", scimHandler.PatchGroup)
+		scim.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+	}
+
+	// Start serving
+	startupHandler.Ready(router)
+	go handleSignals(server, drainer, logger)
+
+	logger.Infof("API Gateway ready on port %s", port)
+	if err := <-served; err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.WithError(err).Fatal("Failed to start server")
 	}
+
+	// Feedback summed since the last flush is written, or spilled for the
+	// next instance, before the deferred clients close
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelClose()
+	if err := clients.Effectiveness.Close(closeCtx); err != nil {
+		logger.WithError(err).Error("Technique effectiveness counts lost at shutdown")
+	}
+	if requestLog != nil {
+		requestLog.Close()
+	}
+}
+
+// handleSignals drains the gateway on SIGUSR1, and on SIGTERM or SIGINT
+// drains it for at least the minimum drain duration before shutting the
+// server down gracefully
+func handleSignals(server *http.Server, drainer *services.Drainer, logger *logrus.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range signals {
+		if drainer.Start(sig.String()) {
+			logger.WithField("signal", sig.String()).Warn("Gateway draining")
+		}
+		if sig == syscall.SIGUSR1 {
+			continue
+		}
+
+		timeout := 30 * time.Second
+		if d, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && d > 0 {
+			timeout = d
+		}
+		if err := drainer.Wait(context.Background()); err != nil {
+			logger.WithError(err).Warn("Drain interrupted")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		status := drainer.Status()
+		logger.WithFields(logrus.Fields{
+			"in_flight":        status.InFlight,
+			"drained_requests": status.DrainedRequests,
+		}).Info("Shutting down API Gateway")
+		if err := server.Shutdown(ctx); err != nil {
+			logger.WithError(err).Error("Graceful shutdown failed")
+		}
+		cancel()
+		return
+	}
 }
\ No newline at end of file
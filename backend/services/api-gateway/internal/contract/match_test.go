@@ -0,0 +1,45 @@
+package contract
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	expected := Body(map[string]interface{}{
+		"primary_technique": "chain_of_thought",
+		"confidence":        0.8,
+		"techniques":        []map[string]interface{}{{"id": "chain_of_thought", "score": 1.5}},
+		"metadata":          nil,
+	})
+	rules := map[string]Rule{
+		"$.body.primary_technique": {Match: "regex", Regex: "^(chain_of_thought|few_shot)$"},
+		"$.body.techniques[*].id":  {Match: "regex", Regex: "^(chain_of_thought|few_shot)$"},
+	}
+
+	actual := Body(map[string]interface{}{
+		"primary_technique": "few_shot",
+		"confidence":        0.55,
+		"techniques":        []map[string]interface{}{{"id": "few_shot", "score": 3, "extra": true}, {"id": "chain_of_thought", "score": 1}},
+		"reasoning":         "extra keys are fine",
+	})
+	assert.Empty(t, Match("$.body", expected, actual, rules))
+
+	drifted := Body(map[string]interface{}{
+		"primaryTechnique": "few_shot",
+		"confidence":       "high",
+		"techniques":       []map[string]interface{}{{"id": "step_by_step", "score": 1}},
+	})
+	assert.Equal(t, []string{
+		"$.body.confidence: expected number, got string",
+		"$.body.primary_technique: missing",
+		`$.body.techniques[*].id: "step_by_step" doesn't match ^(chain_of_thought|few_shot)$`,
+	}, Match("$.body", expected, drifted, rules))
+
+	empty := Body(map[string]interface{}{"primary_technique": "few_shot", "confidence": 0.5, "techniques": []string{}})
+	assert.Equal(t, []string{"$.body.techniques: expected at least 1 elements, got 0"}, Match("$.body", expected, empty, rules))
+
+	rules["$.body.techniques"] = Rule{Match: "type", Min: 0}
+	assert.Empty(t, Match("$.body", expected, empty, rules))
+}
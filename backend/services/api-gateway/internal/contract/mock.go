@@ -0,0 +1,98 @@
+package contract
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// MockProvider serves an interaction's response to a client under test and
+// records where the client's request departed from the interaction
+type MockProvider struct {
+	URL string
+
+	interaction Interaction
+	server      *httptest.Server
+	mu          sync.Mutex
+	requests    int
+	mismatches  []string
+}
+
+// NewMockProvider starts a mock provider for interaction
+func NewMockProvider(interaction Interaction) *MockProvider {
+	m := &MockProvider{interaction: interaction}
+	m.server = httptest.NewServer(http.HandlerFunc(m.serve))
+	m.URL = m.server.URL
+	return m
+}
+
+// Close shuts the mock provider down
+func (m *MockProvider) Close() {
+	m.server.Close()
+}
+
+// Mismatches returns how the requests received differed from the
+// interaction, including the interaction never being requested
+func (m *MockProvider) Mismatches() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.requests == 0 {
+		return []string{fmt.Sprintf("%q was never requested", m.interaction.Description)}
+	}
+	return append([]string(nil), m.mismatches...)
+}
+
+func (m *MockProvider) serve(w http.ResponseWriter, r *http.Request) {
+	mismatches := m.matchRequest(r)
+
+	m.mu.Lock()
+	m.requests++
+	m.mismatches = append(m.mismatches, mismatches...)
+	m.mu.Unlock()
+
+	resp := m.interaction.Response
+	for key, value := range resp.Headers {
+		w.Header().Set(key, value)
+	}
+	if resp.Body == nil {
+		w.WriteHeader(resp.Status)
+		return
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(resp.Status)
+	json.NewEncoder(w).Encode(resp.Body)
+}
+
+func (m *MockProvider) matchRequest(r *http.Request) []string {
+	want := m.interaction.Request
+	var mismatches []string
+
+	if r.Method != want.Method || r.URL.Path != want.Path {
+		mismatches = append(mismatches, fmt.Sprintf("expected %s %s, got %s %s", want.Method, want.Path, r.Method, r.URL.Path))
+	}
+	for key, value := range want.Headers {
+		if got := r.Header.Get(key); !strings.HasPrefix(got, value) {
+			mismatches = append(mismatches, fmt.Sprintf("header %s: expected %q, got %q", key, value, got))
+		}
+	}
+
+	if want.Body == nil {
+		return mismatches
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return append(mismatches, fmt.Sprintf("failed to read request body: %v", err))
+	}
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return append(mismatches, fmt.Sprintf("request body isn't JSON: %v", err))
+	}
+	return append(mismatches, Match("$.body", want.Body, body, want.MatchingRules)...)
+}
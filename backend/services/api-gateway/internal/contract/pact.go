@@ -0,0 +1,136 @@
+// Package contract records the gateway's expectations of downstream services
+// as Pact-style consumer contracts. Client tests run against a MockProvider
+// built from each interaction, and the resulting pact files are committed so
+// providers can verify them in their own CI.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Consumer is the name the gateway uses in its pacts
+const Consumer = "api-gateway"
+
+// Pacticipant names one side of a pact
+type Pacticipant struct {
+	Name string `json:"name"`
+}
+
+// Rule loosens or tightens how a value at a path is matched. Paths use
+// Pact's JSONPath form, e.g. "$.body.techniques[*].id".
+type Rule struct {
+	Match string `json:"match"`           // "type" or "regex"
+	Regex string `json:"regex,omitempty"` // For "regex"
+	Min   int    `json:"min,omitempty"`   // Minimum array length for "type"
+}
+
+// Request is the request a consumer sends
+type Request struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          interface{}       `json:"body,omitempty"`
+	MatchingRules map[string]Rule   `json:"matchingRules,omitempty"`
+}
+
+// Response is the response a consumer relies on
+type Response struct {
+	Status        int               `json:"status"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          interface{}       `json:"body,omitempty"`
+	MatchingRules map[string]Rule   `json:"matchingRules,omitempty"`
+}
+
+// Interaction is one request/response pair the consumer depends on
+type Interaction struct {
+	Description   string   `json:"description"`
+	ProviderState string   `json:"providerState,omitempty"`
+	Request       Request  `json:"request"`
+	Response      Response `json:"response"`
+}
+
+// Pact is the set of interactions between the gateway and one provider
+type Pact struct {
+	Consumer     Pacticipant            `json:"consumer"`
+	Provider     Pacticipant            `json:"provider"`
+	Interactions []Interaction          `json:"interactions"`
+	Metadata     map[string]interface{} `json:"metadata"`
+}
+
+// NewPact creates an empty pact between the gateway and provider
+func NewPact(provider string) *Pact {
+	return &Pact{
+		Consumer: Pacticipant{Name: Consumer},
+		Provider: Pacticipant{Name: provider},
+		Metadata: map[string]interface{}{
+			"pactSpecification": map[string]string{"version": "2.0.0"},
+		},
+	}
+}
+
+// AddInteraction appends an interaction to the pact
+func (p *Pact) AddInteraction(i Interaction) {
+	p.Interactions = append(p.Interactions, i)
+}
+
+// FileName is the pact's file name, "<consumer>-<provider>.json"
+func (p *Pact) FileName() string {
+	return p.Consumer.Name + "-" + p.Provider.Name + ".json"
+}
+
+// Marshal encodes the pact as indented JSON with a trailing newline
+func (p *Pact) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Sync compares the pact with its committed file in dir, or rewrites the
+// file when update is set. A stale file is an error so contract changes
+// are always reviewed alongside the client change that caused them.
+func (p *Pact) Sync(dir string, update bool) error {
+	data, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, p.FileName())
+	if update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, 0o644)
+	}
+
+	committed, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("contract %s is missing, rerun with -update to create it: %w", path, err)
+	}
+	if !bytes.Equal(committed, data) {
+		return fmt.Errorf("contract %s is out of date, rerun with -update and commit the result", path)
+	}
+	return nil
+}
+
+// Body marshals v, typically one of the client's own request or response
+// types, into a generic JSON value so the contract reflects its field tags
+func Body(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("contract: body is not JSON encodable: %v", err))
+	}
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		panic(fmt.Sprintf("contract: body is not JSON decodable: %v", err))
+	}
+	return body
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AbuseHandler exposes the abuse review queue to admins
+type AbuseHandler struct {
+	abuse  *services.AbuseService
+	logger *logrus.Entry
+}
+
+// NewAbuseHandler creates a new abuse review handler
+func NewAbuseHandler(abuse *services.AbuseService, logger *logrus.Entry) *AbuseHandler {
+	return &AbuseHandler{
+		abuse:  abuse,
+		logger: logger,
+	}
+}
+
+// ResolveAbuseReviewRequest is an admin decision on a queued restriction
+type ResolveAbuseReviewRequest struct {
+	Status string `json:"status" binding:"required,oneof=upheld lifted"`
+}
+
+// ListReviews returns queued restrictions, pending by default
+func (h *AbuseHandler) ListReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", services.AbuseReviewPending)
+	switch status {
+	case services.AbuseReviewPending, services.AbuseReviewUpheld, services.AbuseReviewLifted:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status filter"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	reviews, err := h.abuse.ListReviews(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list abuse reviews")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list abuse reviews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"status":  status,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// ResolveReview upholds or lifts a queued restriction
+func (h *AbuseHandler) ResolveReview(c *gin.Context) {
+	reviewerID, _ := middleware.GetUserID(c)
+
+	var req ResolveAbuseReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	review, err := h.abuse.ResolveReview(c.Request.Context(), c.Param("id"), reviewerID, req.Status)
+	if err != nil {
+		if err.Error() == "abuse review not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to resolve abuse review")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve abuse review"})
+		return
+	}
+
+	middleware.RecordAccess(c, services.AccessAdminAction, "admin_resolve_abuse_review", review.UserID, map[string]interface{}{
+		"status": review.Status,
+	})
+	c.JSON(http.StatusOK, review)
+}
+
+// GetUserRestriction returns the active restriction on a user, if any
+func (h *AbuseHandler) GetUserRestriction(c *gin.Context) {
+	restriction, err := h.abuse.GetRestriction(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get abuse restriction")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get restriction"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     c.Param("user_id"),
+		"restricted":  restriction != nil,
+		"restriction": restriction,
+	})
+}
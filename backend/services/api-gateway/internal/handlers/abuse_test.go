@@ -0,0 +1,85 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAbuseHandlerRouter(t *testing.T, server *miniredis.Miniredis) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// Requests that fail validation never reach the database
+	abuse := services.NewAbuseService(services.NewCacheService(client, logger), nil, nil, nil, logger)
+	h := handlers.NewAbuseHandler(abuse, logrus.NewEntry(logger))
+
+	router := gin.New()
+	router.GET("/admin/abuse/reviews", h.ListReviews)
+	router.POST("/admin/abuse/reviews/:id/resolve", h.ResolveReview)
+	router.GET("/admin/abuse/users/:user_id", h.GetUserRestriction)
+	return router
+}
+
+func TestAbuseHandlerGetUserRestriction(t *testing.T) {
+	server := miniredis.RunT(t)
+	router := newAbuseHandlerRouter(t, server)
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	data, err := json.Marshal(services.AbuseRestriction{UserID: "u1", Action: services.AbuseActionSuspend, Rule: "repeated_moderation_flags", Until: until})
+	require.NoError(t, err)
+	require.NoError(t, server.Set("betterprompts:abuse:restriction:u1", string(data)))
+
+	for userID, restricted := range map[string]bool{"u1": true, "u2": false} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/abuse/users/"+userID, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var body struct {
+			Restricted  bool                       `json:"restricted"`
+			Restriction *services.AbuseRestriction `json:"restriction"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, restricted, body.Restricted, userID)
+		if restricted {
+			assert.Equal(t, services.AbuseActionSuspend, body.Restriction.Action)
+			assert.True(t, until.Equal(body.Restriction.Until))
+		}
+	}
+
+	server.Close()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/abuse/users/u1", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestAbuseHandlerRejectsInvalidRequests(t *testing.T) {
+	router := newAbuseHandlerRouter(t, miniredis.RunT(t))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/abuse/reviews?status=closed", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	for _, body := range []string{`{}`, `{"status":"pending"}`, `not json`} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/abuse/reviews/r1/resolve", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+}
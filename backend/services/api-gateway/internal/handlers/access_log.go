@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AccessLogHandler serves users' access logs to admins answering SOC 2
+// evidence requests and data subject access requests
+type AccessLogHandler struct {
+	accessLog *services.AccessLogService
+	logger    *logrus.Entry
+}
+
+// NewAccessLogHandler creates a new access log handler
+func NewAccessLogHandler(accessLog *services.AccessLogService, logger *logrus.Entry) *AccessLogHandler {
+	return &AccessLogHandler{
+		accessLog: accessLog,
+		logger:    logger,
+	}
+}
+
+// GetUserAccessLog returns a user's access log: their authentications, the
+// accesses to their data and the admin actions affecting them, oldest first.
+// ?from= and ?to= (RFC 3339) bound it, defaulting to the user's whole history
+// up to now; ?category= takes a comma-separated list of categories; and
+// ?format=csv downloads it as CSV instead of JSON.
+func (h *AccessLogHandler) GetUserAccessLog(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	query := services.AccessLogQuery{From: time.Unix(0, 0).UTC(), To: time.Now().UTC()}
+	for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 timestamp"})
+			return
+		}
+		*bound = parsed.UTC()
+	}
+	if !query.From.Before(query.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if value := c.Query("category"); value != "" {
+		for _, category := range strings.Split(value, ",") {
+			category = strings.TrimSpace(category)
+			if !slices.Contains(services.AccessLogCategories, category) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("category must be one of %s", strings.Join(services.AccessLogCategories, ", ")),
+				})
+				return
+			}
+			query.Categories = append(query.Categories, category)
+		}
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	log, err := h.accessLog.Compile(c.Request.Context(), userID, query)
+	if err != nil {
+		if errors.Is(err, services.ErrAccessLogUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to compile access log")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to compile access log"})
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	h.logger.WithFields(logrus.Fields{
+		"audit":     true,
+		"admin_id":  adminID,
+		"user_id":   userID,
+		"events":    len(log.Events),
+		"truncated": log.Truncated,
+		"format":    format,
+	}).Info("Access log exported")
+
+	if format == "json" {
+		c.JSON(http.StatusOK, log)
+		return
+	}
+	body, err := services.RenderAccessLogCSV(log)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to render access log")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render access log"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="access-log-%s.csv"`, userID))
+	if log.Truncated {
+		c.Header("X-Access-Log-Truncated", "true")
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", body)
+}
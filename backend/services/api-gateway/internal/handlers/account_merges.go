@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// AccountMergeRequest asks to merge one account into another
+type AccountMergeRequest struct {
+	SourceUserID string `json:"source_user_id" binding:"required,uuid"` // Merged away
+	TargetUserID string `json:"target_user_id" binding:"required,uuid"` // Kept
+	Reason       string `json:"reason" binding:"max=500"`
+}
+
+// AccountMergeHandler lets admins find duplicate accounts and merge them
+type AccountMergeHandler struct {
+	merges *services.AccountMergeService
+	logger *logrus.Entry
+}
+
+// NewAccountMergeHandler creates a new account merge handler
+func NewAccountMergeHandler(merges *services.AccountMergeService, logger *logrus.Entry) *AccountMergeHandler {
+	return &AccountMergeHandler{
+		merges: merges,
+		logger: logger,
+	}
+}
+
+// FindDuplicates lists pairs of accounts likely to belong to the same
+// person. ?user_id= lists only that user's pairs; ?limit= sizes the list.
+func (h *AccountMergeHandler) FindDuplicates(c *gin.Context) {
+	userID, limit, ok := parseAccountMergeListQuery(c)
+	if !ok {
+		return
+	}
+	candidates, err := h.merges.FindDuplicates(c.Request.Context(), userID, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to find duplicate accounts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to find duplicate accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"candidates": candidates})
+}
+
+// PreviewMerge reports what merging the accounts would move, without
+// merging them. Admins confirm a merge by repeating the request to
+// MergeAccounts.
+func (h *AccountMergeHandler) PreviewMerge(c *gin.Context) {
+	h.merge(c, true)
+}
+
+// MergeAccounts merges source_user_id into target_user_id
+func (h *AccountMergeHandler) MergeAccounts(c *gin.Context) {
+	h.merge(c, false)
+}
+
+func (h *AccountMergeHandler) merge(c *gin.Context, dryRun bool) {
+	var req AccountMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	merge, err := h.merges.Merge(c.Request.Context(), services.AccountMergeRequest{
+		SourceID: req.SourceUserID,
+		TargetID: req.TargetUserID,
+		AdminID:  adminID,
+		Reason:   req.Reason,
+		DryRun:   dryRun,
+	})
+	if err != nil {
+		h.respondError(c, err, "Failed to merge accounts")
+		return
+	}
+	if dryRun {
+		c.JSON(http.StatusOK, merge)
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":           true,
+		"admin_id":        adminID,
+		"merge_id":        merge.ID,
+		"source_user_id":  merge.SourceUserID,
+		"target_user_id":  merge.TargetUserID,
+		"history_pending": merge.HistoryPending,
+	}).Info("Accounts merged")
+	c.JSON(http.StatusCreated, merge)
+}
+
+// ListMerges lists merges newest first. ?user_id= lists only the merges
+// involving that user; ?limit= sizes the list.
+func (h *AccountMergeHandler) ListMerges(c *gin.Context) {
+	userID, limit, ok := parseAccountMergeListQuery(c)
+	if !ok {
+		return
+	}
+	merges, err := h.merges.ListMerges(c.Request.Context(), userID, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list account merges")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list account merges"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"merges": merges})
+}
+
+// GetMerge returns a merge with the backups of both accounts
+func (h *AccountMergeHandler) GetMerge(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merge ID"})
+		return
+	}
+	merge, err := h.merges.GetMerge(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "Failed to get account merge")
+		return
+	}
+	c.JSON(http.StatusOK, merge)
+}
+
+// RevertMerge undoes a merge
+func (h *AccountMergeHandler) RevertMerge(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merge ID"})
+		return
+	}
+	adminID, _ := middleware.GetUserID(c)
+	merge, err := h.merges.Revert(c.Request.Context(), c.Param("id"), adminID)
+	if err != nil {
+		h.respondError(c, err, "Failed to revert account merge")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":          true,
+		"admin_id":       adminID,
+		"merge_id":       merge.ID,
+		"source_user_id": merge.SourceUserID,
+		"target_user_id": merge.TargetUserID,
+	}).Info("Account merge reverted")
+	c.JSON(http.StatusOK, merge)
+}
+
+// ResumeMergeHistory finishes moving the history of a merge or revert whose
+// history move failed part way
+func (h *AccountMergeHandler) ResumeMergeHistory(c *gin.Context) {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merge ID"})
+		return
+	}
+	merge, err := h.merges.ResumeHistory(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "Failed to move account merge history")
+		return
+	}
+	c.JSON(http.StatusOK, merge)
+}
+
+func (h *AccountMergeHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAccountMerge):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAccountMergeUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrAccountMergeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account merge not found"})
+	case errors.Is(err, services.ErrAccountMergeSubscription),
+		errors.Is(err, services.ErrAccountMergeReverted),
+		errors.Is(err, services.ErrAccountMergeConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "account merge failed"})
+	}
+}
+
+func parseAccountMergeListQuery(c *gin.Context) (string, int, bool) {
+	userID := c.Query("user_id")
+	if userID != "" {
+		if _, err := uuid.Parse(userID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return "", 0, false
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > services.MaxDuplicateCandidates {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(services.MaxDuplicateCandidates)})
+			return "", 0, false
+		}
+	}
+	return userID, limit, true
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultActivityReplay   = 20
+	maxActivityReplay       = 200
+	activityStreamBuffer    = 256
+	activityHeartbeatPeriod = 15 * time.Second
+)
+
+// ActivityStream serves a live server-sent events feed of gateway activity
+// for operators. Query parameters:
+//   - types: comma-separated event types (enhancement, error, login,
+//     login_failed, rate_limit); all types when omitted
+//   - user_id: only events for this user
+//   - replay: number of recent events to send first (default 20, max 200)
+//   - schema_version: event payload schema version (see
+//     services.EventSchemaVersions); version 1 when omitted
+//
+// Each event is sent with its type as the SSE event name and the event as
+// JSON data. A comment line is sent periodically to keep proxies from
+// closing idle connections.
+func ActivityStream(bus *services.EventBus) gin.HandlerFunc {
+	knownTypes := make(map[string]bool, len(services.ActivityEventTypes))
+	for _, t := range services.ActivityEventTypes {
+		knownTypes[t] = true
+	}
+
+	return func(c *gin.Context) {
+		filter := services.EventFilter{UserID: c.Query("user_id")}
+		if types := c.Query("types"); types != "" {
+			filter.Types = make(map[string]bool)
+			for _, t := range strings.Split(types, ",") {
+				t = strings.TrimSpace(t)
+				if !knownTypes[t] {
+					c.JSON(http.StatusBadRequest, gin.H{
+						"error":   "Invalid event type",
+						"details": fmt.Sprintf("%q is not one of %s", t, strings.Join(services.ActivityEventTypes, ", ")),
+					})
+					return
+				}
+				filter.Types[t] = true
+			}
+		}
+
+		replay := defaultActivityReplay
+		if value := c.Query("replay"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxActivityReplay {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("replay must be between 0 and %d", maxActivityReplay),
+				})
+				return
+			}
+			replay = n
+		}
+
+		schema, err := services.ParseEventSchemaVersion(c.Query("schema_version"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid schema_version",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		// Subscribe before replaying so nothing published in between is lost
+		events, unsubscribe := bus.Subscribe(filter, activityStreamBuffer)
+		defer unsubscribe()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // Disable nginx response buffering
+		c.Status(http.StatusOK)
+
+		sent := make(map[string]bool)
+		for _, event := range bus.Recent(filter, replay) {
+			sent[event.ID] = true
+			writeActivityEvent(c.Writer, event, schema)
+		}
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(activityHeartbeatPeriod)
+		defer heartbeat.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				return true
+			case event := <-events:
+				if sent[event.ID] {
+					delete(sent, event.ID)
+					return true
+				}
+				writeActivityEvent(w, event, schema)
+				return true
+			}
+		})
+	}
+}
+
+// writeActivityEvent writes one event in SSE wire format, rendered at the
+// stream's schema version
+func writeActivityEvent(w io.Writer, event services.ActivityEvent, schema string) {
+	payload, err := services.RenderActivityEvent(event, schema)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -0,0 +1,77 @@
+package handlers_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivityStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	bus := services.NewEventBus(logger)
+	bus.Publish(services.ActivityEvent{ID: "replayed", Type: services.ActivityLogin, UserID: "user-1"})
+	bus.Publish(services.ActivityEvent{ID: "other-user", Type: services.ActivityLogin, UserID: "user-2"})
+
+	router := gin.New()
+	router.GET("/activity/stream", handlers.ActivityStream(bus))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	t.Run("rejects unknown event types", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/activity/stream?types=login,bogus")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("replays and streams filtered events", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/activity/stream?types=login,error&user_id=user-1", nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		lines := make(chan string)
+		go func() {
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				lines <- scanner.Text()
+			}
+			close(lines)
+		}()
+
+		readEventID := func() string {
+			for line := range lines {
+				if id, ok := strings.CutPrefix(line, "id: "); ok {
+					return id
+				}
+			}
+			return ""
+		}
+
+		assert.Equal(t, "replayed", readEventID())
+
+		bus.Publish(services.ActivityEvent{ID: "filtered-type", Type: services.ActivityEnhancement, UserID: "user-1"})
+		bus.Publish(services.ActivityEvent{ID: "live", Type: services.ActivityError, UserID: "user-1"})
+		assert.Equal(t, "live", readEventID())
+	})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AdminUsersHandler lets admins browse and export accounts
+type AdminUsersHandler struct {
+	users  *services.UserService
+	logger *logrus.Entry
+}
+
+// NewAdminUsersHandler creates a new admin users handler
+func NewAdminUsersHandler(users *services.UserService, logger *logrus.Entry) *AdminUsersHandler {
+	return &AdminUsersHandler{
+		users:  users,
+		logger: logger,
+	}
+}
+
+// ListUsers returns a page of accounts. ?q= searches emails and usernames;
+// ?tier=, ?role=, ?verified= and ?locked= (true or false) filter, as do
+// ?created_from= and ?created_to= (RFC 3339). ?sort= is created_at,
+// last_login_at, email or username, prefixed with - for descending, and
+// defaults to -created_at. ?limit= sizes the page and ?cursor= takes the
+// previous page's next_cursor. ?format=csv downloads every matching account
+// instead, up to services.MaxUserExportRows.
+func (h *AdminUsersHandler) ListUsers(c *gin.Context) {
+	query, err := parseUserListQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	if format == "csv" {
+		h.exportUsers(c, adminID, query)
+		return
+	}
+
+	page, err := h.users.ListUsers(c.Request.Context(), query)
+	if err != nil {
+		h.respondListError(c, err)
+		return
+	}
+	h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": adminID,
+		"results":  len(page.Users),
+	}).Info("User list viewed")
+
+	c.JSON(http.StatusOK, page)
+}
+
+func (h *AdminUsersHandler) exportUsers(c *gin.Context, adminID string, query services.UserListQuery) {
+	users, truncated, err := h.users.ExportUsers(c.Request.Context(), query)
+	if err != nil {
+		h.respondListError(c, err)
+		return
+	}
+	body, err := services.RenderUserListCSV(users)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to render user list")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render user list"})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":     true,
+		"admin_id":  adminID,
+		"users":     len(users),
+		"truncated": truncated,
+	}).Info("User list exported")
+
+	c.Header("Content-Disposition", `attachment; filename="users-`+time.Now().UTC().Format("20060102")+`.csv"`)
+	if truncated {
+		c.Header("X-User-Export-Truncated", "true")
+	}
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", body)
+}
+
+func (h *AdminUsersHandler) respondListError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrInvalidUserListQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.WithError(err).Error("Failed to list users")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list users"})
+}
+
+func parseUserListQuery(c *gin.Context) (services.UserListQuery, error) {
+	query := services.UserListQuery{
+		Search:     c.Query("q"),
+		Role:       c.Query("role"),
+		Sort:       services.UserSortCreatedAt,
+		Descending: true,
+		Cursor:     c.Query("cursor"),
+	}
+	if len(query.Search) > 255 {
+		return query, errors.New("q must be at most 255 characters")
+	}
+
+	if tier := c.Query("tier"); tier != "" {
+		switch tier {
+		case services.TierFree, services.TierPro, services.TierEnterprise:
+			query.Tier = tier
+		default:
+			return query, errors.New("tier must be free, pro or enterprise")
+		}
+	}
+
+	for name, filter := range map[string]**bool{"verified": &query.Verified, "locked": &query.Locked} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, errors.New(name + " must be true or false")
+		}
+		*filter = &parsed
+	}
+
+	for name, bound := range map[string]*time.Time{"created_from": &query.CreatedFrom, "created_to": &query.CreatedTo} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, errors.New(name + " must be an RFC 3339 timestamp")
+		}
+		*bound = parsed.UTC()
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		query.Sort, query.Descending = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+		switch query.Sort {
+		case services.UserSortCreatedAt, services.UserSortLastLogin, services.UserSortEmail, services.UserSortUsername:
+		default:
+			return query, errors.New("sort must be created_at, last_login_at, email or username, optionally prefixed with -")
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > services.MaxUserListResults {
+			return query, errors.New("limit must be between 1 and " + strconv.Itoa(services.MaxUserListResults))
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
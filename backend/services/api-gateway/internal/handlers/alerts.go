@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AlertHandler serves the built-in alerts
+type AlertHandler struct {
+	alerts *services.AlertService
+	logger *logrus.Entry
+}
+
+// NewAlertHandler creates a new alert handler
+func NewAlertHandler(alerts *services.AlertService, logger *logrus.Entry) *AlertHandler {
+	return &AlertHandler{
+		alerts: alerts,
+		logger: logger,
+	}
+}
+
+// ListAlerts returns the alerts firing on the instance serving the request,
+// those it resolved recently and the rules it evaluates
+func (h *AlertHandler) ListAlerts(c *gin.Context) {
+	rules := []string{}
+	for _, rule := range h.alerts.Rules() {
+		rules = append(rules, rule.Name())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active":   h.alerts.Active(),
+		"resolved": h.alerts.Resolved(),
+		"rules":    rules,
+	})
+}
@@ -12,41 +12,83 @@ import (
 type AnalyzeRequest struct {
 	Text    string                 `json:"text" binding:"required,min=1,max=5000"`
 	Context map[string]interface{} `json:"context,omitempty"`
+	TopK    int                    `json:"top_k,omitempty" binding:"omitempty,min=1,max=10"` // Return this many candidate intents for the user to pick from
 }
 
-// AnalyzeIntent handles intent analysis without enhancement
+// AnalyzeResponse is the classification, plus the candidate intents when
+// top_k was asked for. A candidate can be sent back as EnhanceRequest.Intent
+// to correct the classification.
+type AnalyzeResponse struct {
+	*services.IntentClassificationResult
+	Candidates []services.IntentCandidate `json:"candidates,omitempty"`
+}
+
+// AnalyzeIntent handles intent analysis without enhancement.
+//
+// Deprecated: wire an EnhanceHandler and route to its Analyze method.
 func AnalyzeIntent(clients *services.ServiceClients) gin.HandlerFunc {
-	logger := logrus.New()
-	logger.SetFormatter(&logrus.JSONFormatter{})
+	return NewEnhanceHandler(NewDependencies(clients)).Analyze
+}
+
+// Analyze handles intent analysis without enhancement
+func (h *EnhanceHandler) Analyze(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	logger.Info("Analyze endpoint called")
 	
-	return func(c *gin.Context) {
-		logger.Info("Analyze endpoint called")
-		
-		var req AnalyzeRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			logger.WithError(err).Error("Failed to bind JSON")
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid request body",
-				"details": err.Error(),
-			})
-			return
-		}
-
-		logger.WithField("text", req.Text).Info("Classifying intent")
-		
-		// Classify intent
-		result, err := clients.IntentClassifier.ClassifyIntent(c.Request.Context(), req.Text)
-		if err != nil {
-			// Log the error for debugging
-			logger.WithError(err).Error("Failed to classify intent")
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to analyze intent",
-				"details": err.Error(), // Include error details for debugging
-			})
-			return
-		}
-
-		logger.Info("Successfully classified intent")
-		c.JSON(http.StatusOK, result)
+	var req AnalyzeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.WithError(err).Error("Failed to bind JSON")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	logger.WithField("text", req.Text).Info("Classifying intent")
+	
+	// Classify intent, with the runner-up intents when the classifier can score them
+	var result *services.IntentClassificationResult
+	var err error
+	if suggester, ok := h.deps.Classifier.(IntentSuggester); ok && req.TopK > 0 {
+		result, err = suggester.SuggestIntents(c.Request.Context(), req.Text, req.TopK)
+	} else {
+		result, err = h.deps.Classifier.ClassifyIntent(c.Request.Context(), req.Text)
+	}
+	if err != nil {
+		// Log the error for debugging
+		logger.WithError(err).Error("Failed to classify intent")
+		c.JSON(downstreamStatus(err), gin.H{
+			"error": "Failed to analyze intent",
+			"details": err.Error(), // Include error details for debugging
+		})
+		return
 	}
-}
\ No newline at end of file
+
+	logger.Info("Successfully classified intent")
+	response := AnalyzeResponse{IntentClassificationResult: result}
+	if req.TopK > 0 {
+		response.Candidates = result.TopIntents(req.TopK)
+	}
+	c.JSON(http.StatusOK, response)
+}
+// PromptQualityRequest is the prompt to diagnose before enhancement
+type PromptQualityRequest struct {
+	Text string `json:"text" binding:"required,min=1,max=5000"`
+}
+
+// AnalyzeQuality diagnoses a prompt before it is enhanced: vague wording,
+// missing audience, format and constraints, estimated complexity and what
+// the user could add. No downstream service is called.
+func AnalyzeQuality(c *gin.Context) {
+	var req PromptQualityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, services.AnalyzePromptQuality(req.Text))
+}
@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AnnouncementHandler serves announcement banners to the frontend and lets
+// admins schedule them
+type AnnouncementHandler struct {
+	announcements *services.AnnouncementService
+	logger        *logrus.Entry
+}
+
+// NewAnnouncementHandler creates a new announcement handler
+func NewAnnouncementHandler(announcements *services.AnnouncementService, logger *logrus.Entry) *AnnouncementHandler {
+	return &AnnouncementHandler{
+		announcements: announcements,
+		logger:        logger,
+	}
+}
+
+// AnnouncementRequest creates or replaces an announcement
+type AnnouncementRequest struct {
+	Kind        string     `json:"kind" binding:"required"`
+	Title       string     `json:"title" binding:"required,max=200"`
+	Body        string     `json:"body" binding:"max=2000"`
+	LinkURL     string     `json:"link_url" binding:"max=500"`
+	Tiers       []string   `json:"tiers"`
+	Roles       []string   `json:"roles"`
+	Dismissible *bool      `json:"dismissible"` // Defaults to true
+	StartsAt    *time.Time `json:"starts_at"`   // Defaults to now
+	EndsAt      time.Time  `json:"ends_at" binding:"required"`
+}
+
+// GetAnnouncements returns the announcements the caller should see now.
+// The frontend polls it, so it is served from the shared cache.
+func (h *AnnouncementHandler) GetAnnouncements(c *gin.Context) {
+	announcements, err := h.announcements.Active(c.Request.Context(), middleware.GetRequestContext(c))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get announcements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"announcements": announcements})
+}
+
+// DismissAnnouncement stops showing an announcement to the caller
+func (h *AnnouncementHandler) DismissAnnouncement(c *gin.Context) {
+	if err := h.announcements.Dismiss(c.Request.Context(), middleware.GetRequestContext(c), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to dismiss announcement")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ListAnnouncements returns scheduled and live announcements, and ended ones
+// too with ?include_ended=true
+func (h *AnnouncementHandler) ListAnnouncements(c *gin.Context) {
+	announcements, err := h.announcements.ListAnnouncements(c.Request.Context(), c.Query("include_ended") == "true")
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list announcements")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list announcements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"announcements": announcements})
+}
+
+// GetAnnouncement returns one announcement
+func (h *AnnouncementHandler) GetAnnouncement(c *gin.Context) {
+	announcement, err := h.announcements.GetAnnouncement(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get announcement")
+		return
+	}
+
+	c.JSON(http.StatusOK, announcement)
+}
+
+// CreateAnnouncement schedules an announcement
+func (h *AnnouncementHandler) CreateAnnouncement(c *gin.Context) {
+	announcement, ok := bindAnnouncement(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	created, err := h.announcements.CreateAnnouncement(c.Request.Context(), announcement, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to create announcement")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateAnnouncement replaces an announcement
+func (h *AnnouncementHandler) UpdateAnnouncement(c *gin.Context) {
+	announcement, ok := bindAnnouncement(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	updated, err := h.announcements.UpdateAnnouncement(c.Request.Context(), c.Param("id"), announcement, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to update announcement")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteAnnouncement removes an announcement
+func (h *AnnouncementHandler) DeleteAnnouncement(c *gin.Context) {
+	adminID, _ := middleware.GetUserID(c)
+	if err := h.announcements.DeleteAnnouncement(c.Request.Context(), c.Param("id"), adminID); err != nil {
+		h.respondError(c, err, "failed to delete announcement")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AnnouncementHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrAnnouncementNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAnnouncementNotDismissible):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Announcement operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// bindAnnouncement reads and normalizes an announcement from the request
+// body, answering 400 when it is invalid
+func bindAnnouncement(c *gin.Context) (services.Announcement, bool) {
+	var req AnnouncementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.Announcement{}, false
+	}
+
+	announcement := services.Announcement{
+		Kind:        req.Kind,
+		Title:       req.Title,
+		Body:        req.Body,
+		LinkURL:     req.LinkURL,
+		Tiers:       req.Tiers,
+		Roles:       req.Roles,
+		Dismissible: req.Dismissible == nil || *req.Dismissible,
+		StartsAt:    time.Now(),
+		EndsAt:      req.EndsAt,
+	}
+	if req.StartsAt != nil {
+		announcement.StartsAt = *req.StartsAt
+	}
+	if err := services.NormalizeAnnouncement(&announcement); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid announcement",
+			"details": err.Error(),
+		})
+		return services.Announcement{}, false
+	}
+	return announcement, true
+}
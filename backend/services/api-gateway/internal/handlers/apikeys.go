@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyHandler handles developer API key management
+type APIKeyHandler struct {
+	apiKeys *services.APIKeyService
+	logger  *logrus.Entry
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(apiKeys *services.APIKeyService, logger *logrus.Entry) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeys: apiKeys,
+		logger:  logger,
+	}
+}
+
+// CreateAPIKeyRequest represents the request body for issuing an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,min=1,max=255"`
+	Scopes    []string   `json:"scopes,omitempty"` // Defaults to every scope
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// CreateAPIKey issues a new API key. The raw key is only shown once.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	key, rawKey, err := h.apiKeys.CreateAPIKey(c.Request.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKeyScopes) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  err.Error(),
+				"scopes": services.APIKeyScopes,
+			})
+			return
+		}
+		if err.Error() == "api key name already exists" {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to create API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create api key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"api_key": rawKey,
+		"key":     key,
+	})
+}
+
+// GetAPIKeys lists the caller's API keys
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	keys, err := h.apiKeys.ListAPIKeys(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list API keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve api keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// DeleteAPIKey revokes one of the caller's API keys
+func (h *APIKeyHandler) DeleteAPIKey(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.apiKeys.RevokeAPIKey(c.Request.Context(), userID, c.Param("id")); err != nil {
+		if err.Error() == "api key not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to revoke API key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke api key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/betterprompts/api-gateway/internal/auth"
@@ -10,6 +15,7 @@ import (
 	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/betterprompts/api-gateway/internal/services"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,6 +24,12 @@ type AuthHandler struct {
 	userService *services.UserService
 	jwtManager  *auth.JWTManager
 	cache       *services.CacheService
+	devices     *services.DeviceService   // Optional; refresh tokens aren't device-bound when nil
+	billing     *services.BillingService  // Optional; set by EnableBilling
+	trials      *services.TrialService    // Optional; set by EnableTrials
+	referrals   *services.ReferralService // Optional; set by EnablePromoCodes
+	coupons     *services.CouponService   // Optional; set by EnablePromoCodes
+	sso         *services.SSOService      // Optional; set by EnableSSO
 	logger      *logrus.Logger
 }
 
@@ -28,19 +40,60 @@ func isProduction() bool {
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(userService *services.UserService, jwtManager *auth.JWTManager, cache *services.CacheService, logger *logrus.Logger) *AuthHandler {
+func NewAuthHandler(userService *services.UserService, jwtManager *auth.JWTManager, cache *services.CacheService, devices *services.DeviceService, logger *logrus.Logger) *AuthHandler {
 	return &AuthHandler{
 		userService: userService,
 		jwtManager:  jwtManager,
 		cache:       cache,
+		devices:     devices,
 		logger:      logger,
 	}
 }
 
+// EnableBilling creates a Stripe customer for every new user, so upgrading
+// later only needs a checkout
+func (h *AuthHandler) EnableBilling(billing *services.BillingService) {
+	if billing != nil && billing.Enabled() {
+		h.billing = billing
+	}
+}
+
+// EnableTrials starts every new user on a pro trial
+func (h *AuthHandler) EnableTrials(trials *services.TrialService) {
+	if trials != nil && trials.Enabled() {
+		h.trials = trials
+	}
+}
+
+// EnablePromoCodes lets signups carry a referral_code and a coupon_code.
+// Either service may be nil to leave its code ignored.
+func (h *AuthHandler) EnablePromoCodes(referrals *services.ReferralService, coupons *services.CouponService) {
+	h.referrals = referrals
+	h.coupons = coupons
+}
+
+// EnableSSO adds single sign-on, and turns away password logins and
+// signups of users an organization's connection manages
+func (h *AuthHandler) EnableSSO(sso *services.SSOService) {
+	h.sso = sso
+}
+
+// registrationCodes are the promo codes a signup can carry next to the
+// registration fields
+type registrationCodes struct {
+	ReferralCode string `json:"referral_code" binding:"max=32"`
+	CouponCode   string `json:"coupon_code" binding:"max=32"`
+}
+
 // Register handles user registration
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req models.UserRegistrationRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	var codes registrationCodes
+	err := c.ShouldBindBodyWith(&req, binding.JSON)
+	if err == nil {
+		err = c.ShouldBindBodyWith(&codes, binding.JSON)
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "Invalid request body",
 			"details": err.Error(),
@@ -56,6 +109,15 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Codes are checked up front so a typo doesn't cost the signup its reward
+	if !h.checkPromoCodes(c, codes) {
+		return
+	}
+
+	if h.requireSSO(c, "", req.Email) {
+		return
+	}
+
 	// Create user
 	user, err := h.userService.CreateUser(c.Request.Context(), req)
 	if err != nil {
@@ -67,7 +129,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 		if errMsg == "email already exists" || errMsg == "username already exists" {
 			statusCode = http.StatusConflict
-		} else if errMsg == "password validation failed" {
+		} else if errMsg == "password validation failed" || strings.HasPrefix(errMsg, "username validation failed") {
 			statusCode = http.StatusBadRequest
 		}
 
@@ -77,6 +139,18 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// The account works without a trial, so a failure is only logged
+	if h.trials != nil {
+		expires, err := h.trials.StartTrial(c.Request.Context(), user.ID)
+		if err != nil {
+			h.logger.WithError(err).WithField("user_id", user.ID).Warn("Failed to start trial")
+		} else if !expires.IsZero() {
+			user.Tier = services.TierPro
+		}
+	}
+
+	h.applyPromoCodes(c, user.ID, codes)
+
 	// Generate tokens
 	accessToken, refreshToken, err := h.jwtManager.GenerateTokenPair(user.ID, user.Email, user.Roles)
 	if err != nil {
@@ -88,13 +162,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	}
 
 	// Store refresh token in cache if available
-	if h.cache != nil {
-		refreshKey := h.cache.Key("refresh_token", user.ID, refreshToken[:16])
-		h.cache.StoreSession(c.Request.Context(), refreshKey, map[string]interface{}{
-			"user_id": user.ID,
-			"token":   refreshToken,
-		}, 7*24*time.Hour)
-	}
+	h.storeRefreshToken(c, user.ID, refreshToken)
 
 	// Set cookies for hybrid authentication approach
 	secure := isProduction()
@@ -123,6 +191,17 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		"email":   user.Email,
 	}).Info("User registered successfully")
 
+	if h.billing != nil {
+		// Checkout creates the customer too, so a failure here costs nothing
+		go func(userID, email, name string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if _, err := h.billing.EnsureCustomer(ctx, userID, email, name); err != nil {
+				h.logger.WithError(err).WithField("user_id", userID).Warn("Failed to create billing customer")
+			}
+		}(user.ID, user.Email, strings.TrimSpace(user.FirstName.String+" "+user.LastName.String))
+	}
+
 	c.JSON(http.StatusCreated, models.UserLoginResponse{
 		User:         user,
 		AccessToken:  accessToken,
@@ -169,6 +248,10 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if h.requireSSO(c, user.ID, user.Email) {
+		return
+	}
+
 	// Verify password
 	if err := auth.VerifyPassword(req.Password, user.PasswordHash); err != nil {
 		// Increment failed login attempts
@@ -182,6 +265,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid credentials",
 		})
+		middleware.PublishActivity(c, services.ActivityLoginFailed, user.ID, nil)
+		middleware.RecordAccess(c, services.AccessAuthentication, "login_failed", user.ID, nil)
 		return
 	}
 
@@ -216,13 +301,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Store refresh token in cache if available
-	if h.cache != nil {
-		refreshKey := h.cache.Key("refresh_token", user.ID, refreshToken[:16])
-		h.cache.StoreSession(c.Request.Context(), refreshKey, map[string]interface{}{
-			"user_id": user.ID,
-			"token":   refreshToken,
-		}, 7*24*time.Hour)
-	}
+	h.storeRefreshToken(c, user.ID, refreshToken)
 
 	// Always set cookies for hybrid authentication approach
 	// This allows the frontend middleware to authenticate requests
@@ -260,6 +339,12 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		RefreshToken: refreshToken,
 		ExpiresIn:    int64(tokenExpiry.Seconds()),
 	})
+	middleware.PublishActivity(c, services.ActivityLogin, user.ID, map[string]interface{}{
+		"remember_me": req.RememberMe,
+	})
+	middleware.RecordAccess(c, services.AccessAuthentication, "login", user.ID, map[string]interface{}{
+		"remember_me": req.RememberMe,
+	})
 }
 
 // RefreshToken handles token refresh
@@ -285,7 +370,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 
 	// Check if refresh token exists in cache
 	if h.cache != nil {
-		refreshKey := h.cache.Key("refresh_token", claims.UserID, req.RefreshToken[:16])
+		refreshKey := h.refreshTokenKey(claims.UserID, req.RefreshToken)
 		var tokenData map[string]interface{}
 		if err := h.cache.GetSession(c.Request.Context(), refreshKey, &tokenData); err != nil {
 			h.logger.WithError(err).Debug("Refresh token not found in cache")
@@ -294,6 +379,11 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 			})
 			return
 		}
+
+		// Tokens bound to a device may only be refreshed from that device
+		if deviceID, _ := tokenData["device_id"].(string); deviceID != "" && !h.checkRefreshDevice(c, claims.UserID, deviceID) {
+			return
+		}
 	}
 
 	// Get user to refresh roles
@@ -338,6 +428,144 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	})
 }
 
+// checkPromoCodes answers 400 for referral and coupon codes that can't be
+// used. Codes are ignored when their service isn't enabled.
+func (h *AuthHandler) checkPromoCodes(c *gin.Context, codes registrationCodes) bool {
+	if codes.ReferralCode != "" && h.referrals != nil {
+		if _, err := h.referrals.LookupCode(c.Request.Context(), codes.ReferralCode); err != nil {
+			if errors.Is(err, services.ErrUnknownReferralCode) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid referral code"})
+				return false
+			}
+			h.logger.WithError(err).Error("Failed to check referral code")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check referral code"})
+			return false
+		}
+	}
+	if codes.CouponCode != "" && h.coupons != nil {
+		if _, err := h.coupons.CheckCoupon(c.Request.Context(), codes.CouponCode); err != nil {
+			if errors.Is(err, services.ErrCouponNotFound) || errors.Is(err, services.ErrCouponUnavailable) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid coupon code", "details": err.Error()})
+				return false
+			}
+			h.logger.WithError(err).Error("Failed to check coupon code")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check coupon code"})
+			return false
+		}
+	}
+	return true
+}
+
+// applyPromoCodes rewards a new user's referral and coupon codes. The
+// account is already created, so failures are only logged.
+func (h *AuthHandler) applyPromoCodes(c *gin.Context, userID string, codes registrationCodes) {
+	logger := h.logger.WithField("user_id", userID)
+	if codes.ReferralCode != "" && h.referrals != nil {
+		if _, err := h.referrals.ApplyReferral(c.Request.Context(), userID, codes.ReferralCode, requestDevice(c)); err != nil {
+			logger.WithError(err).Warn("Failed to apply referral code")
+		}
+	}
+	if codes.CouponCode != "" && h.coupons != nil {
+		if _, err := h.coupons.Redeem(c.Request.Context(), userID, codes.CouponCode); err != nil {
+			logger.WithError(err).Warn("Failed to redeem coupon at signup")
+		}
+	}
+}
+
+// requestDevice describes the device making the request from the
+// X-Device-Fingerprint, X-Device-Name and X-Device-Platform headers
+func requestDevice(c *gin.Context) services.DeviceInfo {
+	userAgent := c.Request.UserAgent()
+	return services.DeviceInfo{
+		Fingerprint: services.DeviceFingerprint(c.GetHeader("X-Device-Fingerprint"), userAgent),
+		Name:        c.GetHeader("X-Device-Name"),
+		Platform:    c.GetHeader("X-Device-Platform"),
+		UserAgent:   userAgent,
+		IP:          c.ClientIP(),
+	}
+}
+
+// storeRefreshToken records a newly issued refresh token, bound to the
+// requesting device when device tracking is enabled
+func (h *AuthHandler) storeRefreshToken(c *gin.Context, userID, refreshToken string) {
+	if h.cache == nil {
+		return
+	}
+
+	tokenData := map[string]interface{}{
+		"user_id": userID,
+		"token":   refreshToken,
+	}
+	if h.devices != nil {
+		device, err := h.devices.RegisterDevice(c.Request.Context(), userID, requestDevice(c))
+		if err != nil {
+			h.logger.WithError(err).Warn("Failed to register device")
+		} else {
+			tokenData["device_id"] = device.ID
+			c.Header("X-Device-ID", device.ID)
+		}
+	}
+
+	h.cache.StoreSession(c.Request.Context(), h.refreshTokenKey(userID, refreshToken), tokenData, 7*24*time.Hour)
+}
+
+// refreshTokenKey is the session key of a refresh token. Tokens are keyed by
+// a hash of the whole token: HS256 tokens all begin with the same header, so
+// any prefix of them would give each user a single slot.
+func (h *AuthHandler) refreshTokenKey(userID, refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return h.cache.Key("refresh_token", userID, hex.EncodeToString(sum[:]))
+}
+
+// checkRefreshDevice rejects refreshes of a device-bound token from a revoked
+// device or from a different device, logging the latter as suspicious
+func (h *AuthHandler) checkRefreshDevice(c *gin.Context, userID, deviceID string) bool {
+	if h.devices == nil {
+		return true
+	}
+
+	device, err := h.devices.GetDevice(c.Request.Context(), userID, deviceID)
+	if err != nil && !errors.Is(err, services.ErrDeviceNotFound) {
+		// Don't lock users out over a lookup failure
+		h.logger.WithError(err).Warn("Failed to look up refresh token device")
+		return true
+	}
+	if err != nil || device.Revoked() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Device has been signed out",
+		})
+		return false
+	}
+
+	current := requestDevice(c)
+	if current.Fingerprint != device.Fingerprint {
+		h.logger.WithFields(logrus.Fields{
+			"user_id":    userID,
+			"device_id":  deviceID,
+			"ip":         current.IP,
+			"user_agent": current.UserAgent,
+			"suspicious": true,
+		}).Warn("Refresh token presented from a different device")
+		middleware.PublishActivity(c, services.ActivityLoginFailed, userID, map[string]interface{}{
+			"reason":    "device_mismatch",
+			"device_id": deviceID,
+		})
+		middleware.RecordAccess(c, services.AccessAuthentication, "refresh_rejected", userID, map[string]interface{}{
+			"reason":    "device_mismatch",
+			"device_id": deviceID,
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid refresh token",
+		})
+		return false
+	}
+
+	if err := h.devices.TouchDevice(c.Request.Context(), deviceID, current.IP); err != nil {
+		h.logger.WithError(err).Debug("Failed to update device last seen")
+	}
+	return true
+}
+
 // Logout handles user logout
 func (h *AuthHandler) Logout(c *gin.Context) {
 	// Get user ID from context
@@ -351,7 +579,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 
 	// Invalidate refresh token in cache
 	if h.cache != nil && req.RefreshToken != "" && userID != "" {
-		refreshKey := h.cache.Key("refresh_token", userID, req.RefreshToken[:16])
+		refreshKey := h.refreshTokenKey(userID, req.RefreshToken)
 		h.cache.DeleteSession(c.Request.Context(), refreshKey)
 	}
 
@@ -389,6 +617,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	)
 
 	h.logger.WithField("user_id", userID).Info("User logged out")
+	middleware.RecordAccess(c, services.AccessAuthentication, "logout", userID, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Logged out successfully",
@@ -405,7 +634,7 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.GetUserByID(c.Request.Context(), userID)
+	profile, err := h.userService.GetProfile(c.Request.Context(), userID)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to get user profile")
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -414,10 +643,13 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	c.Header("ETag", versionETag(profile.Version))
+	c.JSON(http.StatusOK, profile)
 }
 
-// UpdateProfile updates user profile
+// UpdateProfile updates user profile. With If-Match set to the profile's
+// ETag, an update racing another one gets 409 and the current profile to
+// merge with instead of overwriting it.
 func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
 	if !exists {
@@ -427,6 +659,11 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	var req models.UserUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -436,13 +673,39 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.UpdateUser(c.Request.Context(), userID, req)
+	profile, err := h.userService.UpdateProfile(c.Request.Context(), userID, req, expectedVersion)
 	if err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			current, getErr := h.userService.GetProfile(c.Request.Context(), userID)
+			if getErr != nil {
+				h.logger.WithError(getErr).Error("Failed to get user profile")
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get profile"})
+				return
+			}
+			c.Header("ETag", versionETag(current.Version))
+			c.JSON(http.StatusConflict, gin.H{
+				"error":   "Profile was changed by another request",
+				"current": current,
+			})
+			return
+		}
+
 		h.logger.WithError(err).Error("Failed to update user profile")
 
+		var cooldownErr *services.UsernameCooldownError
+		if errors.As(err, &cooldownErr) {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":    err.Error(),
+				"retry_at": cooldownErr.RetryAt,
+			})
+			return
+		}
+
 		statusCode := http.StatusInternalServerError
 		if err.Error() == "username already exists" {
 			statusCode = http.StatusConflict
+		} else if strings.HasPrefix(err.Error(), "username validation failed") {
+			statusCode = http.StatusBadRequest
 		}
 
 		c.JSON(statusCode, gin.H{
@@ -453,7 +716,8 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 
 	h.logger.WithField("user_id", userID).Info("Profile updated successfully")
 
-	c.JSON(http.StatusOK, user)
+	c.Header("ETag", versionETag(profile.Version))
+	c.JSON(http.StatusOK, profile)
 }
 
 // ChangePassword changes user password
@@ -498,6 +762,7 @@ func (h *AuthHandler) ChangePassword(c *gin.Context) {
 	}
 
 	h.logger.WithField("user_id", userID).Info("Password changed successfully")
+	middleware.RecordAccess(c, services.AccessAuthentication, "password_changed", userID, nil)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Password changed successfully",
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/auth"
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ssoLoginPath starts a single sign-on for ?email=
+	ssoLoginPath = "/api/v1/auth/sso/login"
+	// ssoStateCookie ties the callback to the browser that started the
+	// sign-in, so nobody can finish theirs in someone else's session
+	ssoStateCookie = "sso_state"
+	ssoCookiePath  = "/api/v1/auth/sso"
+)
+
+// SSODiscoverRequest asks how an email address signs in
+type SSODiscoverRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ssoLoginURL is where a user with email starts their single sign-on
+func ssoLoginURL(email string) string {
+	return ssoLoginPath + "?" + url.Values{"email": {email}}.Encode()
+}
+
+// requireSSO answers 403 with where to sign in instead when an
+// organization's connection manages the account, reporting whether it did
+func (h *AuthHandler) requireSSO(c *gin.Context, userID, email string) bool {
+	if h.sso == nil {
+		return false
+	}
+
+	conn, err := h.sso.ManagedConnection(c.Request.Context(), userID, email)
+	if errors.Is(err, services.ErrSSOConnectionNotFound) {
+		return false
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to look up sso connection")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to check how the account signs in",
+		})
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":         "This account signs in through single sign-on",
+		"sso_required":  true,
+		"org_id":        conn.OrgID,
+		"sso_login_url": ssoLoginURL(email),
+	})
+	return true
+}
+
+// SSODiscover tells the login form whether an email address signs in
+// through its organization's provider
+func (h *AuthHandler) SSODiscover(c *gin.Context) {
+	var req SSODiscoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if h.sso == nil {
+		c.JSON(http.StatusOK, gin.H{"sso": false})
+		return
+	}
+
+	conn, err := h.sso.ConnectionForEmail(c.Request.Context(), req.Email)
+	if errors.Is(err, services.ErrSSOConnectionNotFound) {
+		c.JSON(http.StatusOK, gin.H{"sso": false})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to look up sso connection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up single sign-on"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sso":       true,
+		"org_id":    conn.OrgID,
+		"login_url": ssoLoginURL(req.Email),
+	})
+}
+
+// SSOLogin sends the browser to the provider of ?email='s organization
+func (h *AuthHandler) SSOLogin(c *gin.Context) {
+	email := strings.ToLower(strings.TrimSpace(c.Query("email")))
+	if h.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	if services.EmailDomain(email) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email is required"})
+		return
+	}
+
+	conn, err := h.sso.ConnectionForEmail(c.Request.Context(), email)
+	if errors.Is(err, services.ErrSSOConnectionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No single sign-on is configured for this email domain"})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to look up sso connection")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+
+	location, state, err := h.sso.AuthorizationURL(c.Request.Context(), conn, email)
+	if err != nil {
+		h.logger.WithError(err).WithField("org_id", conn.OrgID).Error("Failed to start sso login")
+		c.JSON(http.StatusBadGateway, gin.H{"error": "The identity provider could not be reached"})
+		return
+	}
+
+	// Lax, as the provider sends the browser back with a top-level redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ssoStateCookie, state, int(h.sso.Config().StateTTL.Seconds()), ssoCookiePath, "", isProduction(), true)
+	c.Redirect(http.StatusFound, location)
+}
+
+// SSOCallback finishes a sign-in the provider sent the browser back from:
+// it provisions the user, sets the session cookies and redirects to the
+// app, or to its login page with ?error= when sign-in failed
+func (h *AuthHandler) SSOCallback(c *gin.Context) {
+	if h.sso == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not enabled"})
+		return
+	}
+	config := h.sso.Config()
+
+	fail := func(reason string) {
+		location, err := url.Parse(config.FailureURL)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed", "reason": reason})
+			return
+		}
+		query := location.Query()
+		query.Set("error", reason)
+		location.RawQuery = query.Encode()
+		c.Redirect(http.StatusFound, location.String())
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(ssoStateCookie)
+	c.SetCookie(ssoStateCookie, "", -1, ssoCookiePath, "", isProduction(), true)
+	if providerErr := c.Query("error"); providerErr != "" {
+		h.logger.WithFields(logrus.Fields{
+			"error":       providerErr,
+			"description": c.Query("error_description"),
+		}).Info("Identity provider declined sso login")
+		fail("sso_denied")
+		return
+	}
+	if state == "" || !auth.ConstantTimeCompare(state, cookie) {
+		fail("sso_state")
+		return
+	}
+
+	userID, identity, err := h.sso.CompleteLogin(c.Request.Context(), state, c.Query("code"))
+	if err != nil {
+		h.logger.WithError(err).Warn("SSO login failed")
+		fail("sso_failed")
+		return
+	}
+
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).WithField("user_id", userID).Error("Failed to load sso user")
+		fail("sso_failed")
+		return
+	}
+	if !user.IsActive {
+		fail("account_inactive")
+		return
+	}
+	if err := h.userService.UpdateLastLoginAt(c.Request.Context(), user.ID); err != nil {
+		h.logger.WithError(err).Warn("Failed to update last login")
+	}
+
+	accessToken, refreshToken, err := h.jwtManager.GenerateTokenPair(user.ID, user.Email, user.Roles)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to generate tokens")
+		fail("sso_failed")
+		return
+	}
+	h.storeRefreshToken(c, user.ID, refreshToken)
+
+	secure := isProduction()
+	c.SetCookie("auth_token", accessToken, int(h.jwtManager.GetConfig().AccessExpiry.Seconds()), "/", "", secure, true)
+	c.SetCookie("refresh_token", refreshToken, int(7*24*time.Hour.Seconds()), "/", "", secure, true)
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id": user.ID,
+		"email":   user.Email,
+		"org_id":  identity.OrgID,
+	}).Info("User logged in through SSO")
+	middleware.PublishActivity(c, services.ActivityLogin, user.ID, map[string]interface{}{
+		"sso":    true,
+		"org_id": identity.OrgID,
+	})
+	middleware.RecordAccess(c, services.AccessAuthentication, "sso_login", user.ID, map[string]interface{}{
+		"org_id": identity.OrgID,
+	})
+	c.Redirect(http.StatusFound, config.SuccessURL)
+}
@@ -159,6 +159,7 @@ func (suite *AuthHandlerTestSuite) SetupTest() {
 		suite.userService,
 		suite.jwtManager,
 		suite.cacheService,
+		nil,
 		suite.logger,
 	)
 
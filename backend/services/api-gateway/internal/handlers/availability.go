@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// CheckAvailability reports whether a username and/or email can be
+// registered, for validating sign-up and profile forms as the user types.
+// Results are cached briefly, both in Redis and by the client.
+func (h *AuthHandler) CheckAvailability(c *gin.Context) {
+	username, hasUsername := c.GetQuery("username")
+	email, hasEmail := c.GetQuery("email")
+	if !hasUsername && !hasEmail {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Either username or email must be provided",
+		})
+		return
+	}
+
+	response := gin.H{}
+	if hasUsername {
+		availability, err := h.cachedAvailability(c.Request.Context(), "username", username, h.userService.CheckUsernameAvailability)
+		if err != nil {
+			h.logger.WithError(err).Error("Failed to check username availability")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check availability",
+			})
+			return
+		}
+		response["username"] = availability
+	}
+	if hasEmail {
+		availability, err := h.cachedAvailability(c.Request.Context(), "email", email, h.userService.CheckEmailAvailability)
+		if err != nil {
+			h.logger.WithError(err).Error("Failed to check email availability")
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to check availability",
+			})
+			return
+		}
+		response["email"] = availability
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(services.AvailabilityCacheTTL.Seconds())))
+	c.JSON(http.StatusOK, response)
+}
+
+// cachedAvailability runs check unless the same value was checked moments ago
+func (h *AuthHandler) cachedAvailability(ctx context.Context, kind, value string, check func(context.Context, string) (*services.Availability, error)) (*services.Availability, error) {
+	value = strings.TrimSpace(value)
+	if h.cache != nil {
+		if cached, err := h.cache.GetCachedAvailability(ctx, kind, value); err == nil && cached != nil {
+			return cached, nil
+		}
+	}
+
+	availability, err := check(ctx, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.cache != nil {
+		if err := h.cache.CacheAvailability(ctx, kind, availability); err != nil {
+			h.logger.WithError(err).Debug("Failed to cache availability")
+		}
+	}
+	return availability, nil
+}
+
+// ResolveHandle maps the handle in a public share URL to its owner's current
+// username. Handles retired by a rename answer 301 with the current
+// handle's location so old share links keep working.
+func (h *AuthHandler) ResolveHandle(c *gin.Context) {
+	handle := c.Param("username")
+
+	current, renamed, err := h.userService.ResolveUsername(c.Request.Context(), handle)
+	if err != nil {
+		if err.Error() == "user not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to resolve handle")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to resolve handle",
+		})
+		return
+	}
+
+	if renamed {
+		location := strings.TrimSuffix(c.Request.URL.Path, handle) + url.PathEscape(current)
+		if c.Request.URL.RawQuery != "" {
+			location += "?" + c.Request.URL.RawQuery
+		}
+		c.Header("Location", location)
+		c.JSON(http.StatusMovedPermanently, gin.H{
+			"username":     current,
+			"renamed_from": handle,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"username": current,
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AvatarHandler handles avatar uploads and serving
+type AvatarHandler struct {
+	avatars *services.AvatarService
+	logger  *logrus.Entry
+}
+
+// NewAvatarHandler creates a new avatar handler
+func NewAvatarHandler(avatars *services.AvatarService, logger *logrus.Entry) *AvatarHandler {
+	return &AvatarHandler{
+		avatars: avatars,
+		logger:  logger,
+	}
+}
+
+// UploadAvatar replaces the caller's avatar with the image in the "avatar"
+// multipart field
+func (h *AvatarHandler) UploadAvatar(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxAvatarUploadSize+64<<10)
+
+	file, header, err := c.Request.FormFile("avatar")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("avatar must be at most %d MB", services.MaxAvatarUploadSize>>20),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": "expected an image in the avatar form field",
+		})
+		return
+	}
+	defer file.Close()
+
+	if header.Size > services.MaxAvatarUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("avatar must be at most %d MB", services.MaxAvatarUploadSize>>20),
+		})
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, services.MaxAvatarUploadSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read avatar"})
+		return
+	}
+
+	url, err := h.avatars.UploadAvatar(c.Request.Context(), userID, data)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAvatar) || strings.HasPrefix(err.Error(), "avatar dimensions") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to upload avatar")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload avatar"})
+		return
+	}
+
+	h.logger.WithField("user_id", userID).Info("Avatar updated")
+
+	c.JSON(http.StatusOK, gin.H{"avatar_url": url})
+}
+
+// DeleteAvatar removes the caller's avatar
+func (h *AvatarHandler) DeleteAvatar(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.avatars.DeleteAvatar(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to delete avatar")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete avatar"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+}
+
+// GetAvatar redirects a user's stable avatar URL to a signed storage URL
+func (h *AvatarHandler) GetAvatar(c *gin.Context) {
+	url, err := h.avatars.SignedAvatarURL(c.Request.Context(), c.Param("user_id"))
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to sign avatar URL")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get avatar"})
+		return
+	}
+
+	// Cache the redirect for well under the signed URL's lifetime
+	c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(services.AvatarURLExpiry.Seconds()/2)))
+	c.Redirect(http.StatusFound, url)
+}
+
+// ServeLocalObject serves files from local storage behind the signed URLs
+// LocalStorage hands out
+func ServeLocalObject(storage *services.LocalStorage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		path, err := storage.Open(key, c.Query("expires"), c.Query("signature"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			return
+		}
+
+		c.Header("Cache-Control", "private, max-age=300")
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.File(path)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// maxWebhookBody bounds Stripe webhook payloads, which are a few KB
+const maxWebhookBody = 1 << 16
+
+// BillingHandler serves subscription checkout, plan changes and invoices,
+// and receives Stripe's webhooks
+type BillingHandler struct {
+	billing *services.BillingService
+	logger  *logrus.Entry
+}
+
+// NewBillingHandler creates a new billing handler
+func NewBillingHandler(billing *services.BillingService, logger *logrus.Entry) *BillingHandler {
+	return &BillingHandler{
+		billing: billing,
+		logger:  logger,
+	}
+}
+
+// BillingPlanRequest names the tier to subscribe or switch to
+type BillingPlanRequest struct {
+	Tier string `json:"tier" binding:"required,oneof=pro enterprise"`
+}
+
+// CreateCheckout starts a Stripe Checkout for a paid tier and returns the
+// URL to send the user to
+func (h *BillingHandler) CreateCheckout(c *gin.Context) {
+	var req BillingPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	url, err := h.billing.CreateCheckoutSession(c.Request.Context(), rc.UserID, rc.Email, req.Tier)
+	if err != nil {
+		h.respondError(c, err, "failed to start checkout")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"checkout_url": url})
+}
+
+// GetSubscription returns the caller's subscription
+func (h *BillingHandler) GetSubscription(c *gin.Context) {
+	rc := middleware.GetRequestContext(c)
+	sub, err := h.billing.GetSubscription(c.Request.Context(), rc.UserID)
+	if err != nil {
+		h.respondError(c, err, "failed to get subscription")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tier":         rc.Tier,
+		"subscription": sub,
+	})
+}
+
+// ChangePlan moves the caller's subscription to another paid tier, with
+// proration. Cancelling is done through Stripe's customer portal.
+func (h *BillingHandler) ChangePlan(c *gin.Context) {
+	var req BillingPlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	if err := h.billing.ChangePlan(c.Request.Context(), rc.UserID, req.Tier); err != nil {
+		h.respondError(c, err, "failed to change plan")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":   true,
+		"user_id": rc.UserID,
+		"tier":    req.Tier,
+	}).Info("Subscription plan change requested")
+	c.JSON(http.StatusAccepted, gin.H{"tier": req.Tier})
+}
+
+// ListInvoices returns the caller's invoices, ?limit= at most (default 20,
+// max 100)
+func (h *BillingHandler) ListInvoices(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	invoices, err := h.billing.ListInvoices(c.Request.Context(), middleware.GetRequestContext(c).UserID, limit)
+	if err != nil {
+		h.respondError(c, err, "failed to list invoices")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// Webhook receives Stripe events. Anything but a 2xx makes Stripe retry
+// the delivery, so only events that could succeed later fail with 500.
+func (h *BillingHandler) Webhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+		return
+	}
+
+	err = h.billing.HandleWebhook(c.Request.Context(), payload, c.GetHeader("Stripe-Signature"))
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"received": true})
+	case errors.Is(err, services.ErrInvalidStripeSignature):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBillingDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Failed to process Stripe webhook")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process webhook"})
+	}
+}
+
+func (h *BillingHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrBillingDisabled):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownPlan):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAlreadySubscribed), errors.Is(err, services.ErrNoSubscription):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		status := downstreamStatus(err)
+		h.logger.WithError(err).Error("Billing operation failed")
+		c.JSON(status, gin.H{"error": message})
+	}
+}
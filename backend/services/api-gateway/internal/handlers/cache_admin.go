@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CacheInspector lists, reads and evicts individual cache entries
+type CacheInspector interface {
+	ListKeys(ctx context.Context, prefix string, cursor uint64, count int64) ([]services.CacheEntry, uint64, error)
+	GetEntry(ctx context.Context, key string) (*services.CacheEntryValue, error)
+	EvictKey(ctx context.Context, key string) error
+	EvictPattern(ctx context.Context, pattern string) (int, error)
+	NamespaceStats(ctx context.Context) (map[string]*services.CacheNamespaceStats, error)
+}
+
+var _ CacheInspector = (*services.CacheService)(nil)
+
+// CacheAdminHandler lets admins inspect and evict specific cache entries
+type CacheAdminHandler struct {
+	cache  CacheInspector
+	logger *logrus.Entry
+}
+
+// NewCacheAdminHandler creates a new cache admin handler. cache may be nil
+// when Redis is unavailable, in which case every request gets 503.
+func NewCacheAdminHandler(cache CacheInspector, logger *logrus.Entry) *CacheAdminHandler {
+	return &CacheAdminHandler{
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// EvictCachePatternRequest evicts every key matching a glob pattern
+type EvictCachePatternRequest struct {
+	Pattern string `json:"pattern" binding:"required,max=512"`
+}
+
+// auditLogger returns a logger for admin access to cached data
+func (h *CacheAdminHandler) auditLogger(c *gin.Context) *logrus.Entry {
+	userID, _ := middleware.GetUserID(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":   true,
+		"user_id": userID,
+	})
+}
+
+// available answers 503 when there is no cache to administer
+func (h *CacheAdminHandler) available(c *gin.Context) bool {
+	if h.cache == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Cache is not available"})
+		return false
+	}
+	return true
+}
+
+// ListKeys returns a page of keys under ?prefix= with their TTLs. Follow
+// next_cursor, passed back as ?cursor=, until it is zero.
+func (h *CacheAdminHandler) ListKeys(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	cursor, err := strconv.ParseUint(c.DefaultQuery("cursor", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cursor must be a non-negative integer"})
+		return
+	}
+	count, err := strconv.ParseInt(c.DefaultQuery("count", "100"), 10, 64)
+	if err != nil || count < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "count must be a positive integer"})
+		return
+	}
+
+	keys, next, err := h.cache.ListKeys(c.Request.Context(), c.Query("prefix"), cursor, count)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list cache keys")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list cache keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"keys":        keys,
+		"next_cursor": next,
+	})
+}
+
+// GetEntry returns the cached payload of ?key=
+func (h *CacheAdminHandler) GetEntry(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	entry, err := h.cache.GetEntry(c.Request.Context(), key)
+	if errors.Is(err, services.ErrCacheKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get cache entry")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cache entry"})
+		return
+	}
+
+	// Payloads can hold user prompts, so reads are audited too
+	h.auditLogger(c).WithField("key", entry.Key).Info("Cache entry viewed")
+	c.JSON(http.StatusOK, entry)
+}
+
+// EvictKey deletes the single key ?key=
+func (h *CacheAdminHandler) EvictKey(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+	key := c.Query("key")
+	if key == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key is required"})
+		return
+	}
+
+	err := h.cache.EvictKey(c.Request.Context(), key)
+	if errors.Is(err, services.ErrCacheKeyNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to evict cache key")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evict cache key"})
+		return
+	}
+
+	h.auditLogger(c).WithField("key", key).Info("Cache key evicted")
+	c.Status(http.StatusNoContent)
+}
+
+// EvictPattern deletes every key matching a glob pattern, such as
+// "intent:*". Patterns must start with a literal prefix; use the clear
+// endpoint to empty the whole cache.
+func (h *CacheAdminHandler) EvictPattern(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	var req EvictCachePatternRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	deleted, err := h.cache.EvictPattern(c.Request.Context(), req.Pattern)
+	if errors.Is(err, services.ErrCachePatternTooBroad) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Keys deleted before a failure are gone, so they are audited either way
+	logger := h.auditLogger(c).WithFields(logrus.Fields{
+		"pattern": req.Pattern,
+		"deleted": deleted,
+	})
+	if err != nil {
+		logger.WithError(err).Error("Failed to evict cache pattern")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":   "Failed to evict cache pattern",
+			"deleted": deleted,
+		})
+		return
+	}
+
+	logger.Info("Cache pattern evicted")
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// GetStats returns key counts and hit rates per cache namespace
+func (h *CacheAdminHandler) GetStats(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	stats, err := h.cache.NamespaceStats(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get cache stats")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cache stats"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"namespaces": stats})
+}
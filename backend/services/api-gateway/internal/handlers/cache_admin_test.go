@@ -0,0 +1,128 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCacheInspector serves one cached key and records evictions
+type fakeCacheInspector struct {
+	evicted  []string
+	patterns []string
+}
+
+func (f *fakeCacheInspector) ListKeys(ctx context.Context, prefix string, cursor uint64, count int64) ([]services.CacheEntry, uint64, error) {
+	return []services.CacheEntry{{Key: "betterprompts:intent:abc", Namespace: "intent", Type: "string", TTL: 60}}, 0, nil
+}
+
+func (f *fakeCacheInspector) GetEntry(ctx context.Context, key string) (*services.CacheEntryValue, error) {
+	if key != "intent:abc" {
+		return nil, services.ErrCacheKeyNotFound
+	}
+	return &services.CacheEntryValue{
+		CacheEntry: services.CacheEntry{Key: "betterprompts:intent:abc", Namespace: "intent", Type: "string", TTL: 60},
+		Value:      []byte(`{"intent":"reasoning"}`),
+	}, nil
+}
+
+func (f *fakeCacheInspector) EvictKey(ctx context.Context, key string) error {
+	if key != "intent:abc" {
+		return services.ErrCacheKeyNotFound
+	}
+	f.evicted = append(f.evicted, key)
+	return nil
+}
+
+func (f *fakeCacheInspector) EvictPattern(ctx context.Context, pattern string) (int, error) {
+	if pattern == "*" {
+		return 0, services.ErrCachePatternTooBroad
+	}
+	f.patterns = append(f.patterns, pattern)
+	return 3, nil
+}
+
+func (f *fakeCacheInspector) NamespaceStats(ctx context.Context) (map[string]*services.CacheNamespaceStats, error) {
+	return map[string]*services.CacheNamespaceStats{"intent": {Keys: 1}}, nil
+}
+
+func TestCacheAdminHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func(cache handlers.CacheInspector) (*gin.Engine, *test.Hook) {
+		logger, hook := test.NewNullLogger()
+		handler := handlers.NewCacheAdminHandler(cache, logrus.NewEntry(logger))
+
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("user_id", "admin-1") })
+		router.GET("/cache/keys", handler.ListKeys)
+		router.GET("/cache/entry", handler.GetEntry)
+		router.DELETE("/cache/entry", handler.EvictKey)
+		router.POST("/cache/evict", handler.EvictPattern)
+		router.GET("/cache/stats", handler.GetStats)
+		return router, hook
+	}
+	serve := func(router *gin.Engine, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("reads", func(t *testing.T) {
+		router, hook := newRouter(&fakeCacheInspector{})
+
+		rec := serve(router, http.MethodGet, "/cache/keys?prefix=intent:", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"next_cursor":0`)
+
+		assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodGet, "/cache/keys?cursor=-1", "").Code)
+		assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodGet, "/cache/entry", "").Code)
+		assert.Equal(t, http.StatusNotFound, serve(router, http.MethodGet, "/cache/entry?key=intent:missing", "").Code)
+
+		rec = serve(router, http.MethodGet, "/cache/entry?key=intent:abc", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"value":{"intent":"reasoning"}`)
+
+		// Viewing a payload is audited
+		require.NotNil(t, hook.LastEntry())
+		assert.Equal(t, true, hook.LastEntry().Data["audit"])
+		assert.Equal(t, "admin-1", hook.LastEntry().Data["user_id"])
+
+		assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/cache/stats", "").Code)
+	})
+
+	t.Run("evictions", func(t *testing.T) {
+		cache := &fakeCacheInspector{}
+		router, hook := newRouter(cache)
+
+		assert.Equal(t, http.StatusNoContent, serve(router, http.MethodDelete, "/cache/entry?key=intent:abc", "").Code)
+		assert.Equal(t, []string{"intent:abc"}, cache.evicted)
+		assert.Equal(t, "Cache key evicted", hook.LastEntry().Message)
+		assert.Equal(t, http.StatusNotFound, serve(router, http.MethodDelete, "/cache/entry?key=intent:missing", "").Code)
+
+		rec := serve(router, http.MethodPost, "/cache/evict", `{"pattern":"enhanced:*"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"deleted":3}`, rec.Body.String())
+		assert.Equal(t, 3, hook.LastEntry().Data["deleted"])
+
+		assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodPost, "/cache/evict", `{"pattern":"*"}`).Code)
+		assert.Equal(t, []string{"enhanced:*"}, cache.patterns)
+	})
+
+	t.Run("without a cache", func(t *testing.T) {
+		router, _ := newRouter(nil)
+		assert.Equal(t, http.StatusServiceUnavailable, serve(router, http.MethodGet, "/cache/stats", "").Code)
+	})
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ChangelogHandler serves the in-product changelog, lets admins write it,
+// and reports how the features it announces are adopted
+type ChangelogHandler struct {
+	changelog *services.ChangelogService
+	adoption  *services.FeatureAdoptionService
+	logger    *logrus.Entry
+}
+
+// NewChangelogHandler creates a new changelog handler
+func NewChangelogHandler(changelog *services.ChangelogService, adoption *services.FeatureAdoptionService, logger *logrus.Entry) *ChangelogHandler {
+	return &ChangelogHandler{
+		changelog: changelog,
+		adoption:  adoption,
+		logger:    logger,
+	}
+}
+
+// ChangelogEntryRequest creates or replaces a changelog entry. Entries
+// without published_at are drafts; a future published_at schedules them.
+type ChangelogEntryRequest struct {
+	Version     string     `json:"version" binding:"max=50"`
+	Title       string     `json:"title" binding:"required,max=200"`
+	Body        string     `json:"body" binding:"max=10000"`
+	Category    string     `json:"category" binding:"required"`
+	Features    []string   `json:"features"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// GetChangelog returns published entries, newest first, ?limit= at most
+// (default 20, max 100), published before ?before= (RFC 3339) when set
+func (h *ChangelogHandler) GetChangelog(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+	var before time.Time
+	if value := c.Query("before"); value != "" {
+		if before, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "before must be an RFC 3339 timestamp"})
+			return
+		}
+	}
+
+	entries, err := h.changelog.Published(c.Request.Context(), before, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get changelog")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get changelog"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// ListEntries returns every entry, drafts included
+func (h *ChangelogHandler) ListEntries(c *gin.Context) {
+	entries, err := h.changelog.ListEntries(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list changelog entries")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list changelog entries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GetEntry returns one entry
+func (h *ChangelogHandler) GetEntry(c *gin.Context) {
+	entry, err := h.changelog.GetEntry(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get changelog entry")
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// CreateEntry adds an entry
+func (h *ChangelogHandler) CreateEntry(c *gin.Context) {
+	entry, ok := bindChangelogEntry(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	created, err := h.changelog.CreateEntry(c.Request.Context(), entry, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to create changelog entry")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateEntry replaces an entry
+func (h *ChangelogHandler) UpdateEntry(c *gin.Context) {
+	entry, ok := bindChangelogEntry(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	updated, err := h.changelog.UpdateEntry(c.Request.Context(), c.Param("id"), entry, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to update changelog entry")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteEntry removes an entry
+func (h *ChangelogHandler) DeleteEntry(c *gin.Context) {
+	adminID, _ := middleware.GetUserID(c)
+	if err := h.changelog.DeleteEntry(c.Request.Context(), c.Param("id"), adminID); err != nil {
+		h.respondError(c, err, "failed to delete changelog entry")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetAdoption reports the adoption of every tracked feature among users
+// active in the last ?days= days (default 30, max 365)
+func (h *ChangelogHandler) GetAdoption(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+
+	report, err := h.adoption.Report(c.Request.Context(), time.Now().UTC().AddDate(0, 0, -days))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to report feature adoption")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to report feature adoption"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *ChangelogHandler) respondError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrChangelogEntryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.logger.WithError(err).Error("Changelog operation failed")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// bindChangelogEntry reads and normalizes an entry from the request body,
+// answering 400 when it is invalid
+func bindChangelogEntry(c *gin.Context) (services.ChangelogEntry, bool) {
+	var req ChangelogEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.ChangelogEntry{}, false
+	}
+
+	entry := services.ChangelogEntry{
+		Version:     req.Version,
+		Title:       req.Title,
+		Body:        req.Body,
+		Category:    req.Category,
+		Features:    req.Features,
+		PublishedAt: req.PublishedAt,
+	}
+	if err := services.NormalizeChangelogEntry(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid changelog entry",
+			"details": err.Error(),
+		})
+		return services.ChangelogEntry{}, false
+	}
+	return entry, true
+}
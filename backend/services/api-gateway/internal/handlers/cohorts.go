@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CohortHandler serves cohort retention and activation funnel analytics
+type CohortHandler struct {
+	cohorts *services.CohortService
+	logger  *logrus.Entry
+}
+
+// NewCohortHandler creates a new cohort analytics handler
+func NewCohortHandler(cohorts *services.CohortService, logger *logrus.Entry) *CohortHandler {
+	return &CohortHandler{
+		cohorts: cohorts,
+		logger:  logger,
+	}
+}
+
+// parseCohortWeeks reads ?weeks= (default 12, max 52)
+func parseCohortWeeks(c *gin.Context) (int, bool) {
+	weeks, err := strconv.Atoi(c.DefaultQuery("weeks", "12"))
+	if err != nil || weeks < 1 || weeks > 52 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be between 1 and 52"})
+		return 0, false
+	}
+	return weeks, true
+}
+
+// GetCohorts returns weekly signup cohorts of the last ?weeks= weeks with
+// their retention and activation funnel, as of the last aggregation run
+func (h *CohortHandler) GetCohorts(c *gin.Context) {
+	weeks, ok := parseCohortWeeks(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.cohorts.GetReport(c.Request.Context(), weeks)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get cohort report")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cohort report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RefreshCohorts recomputes the last ?weeks= cohorts immediately rather than
+// waiting for the next scheduled run
+func (h *CohortHandler) RefreshCohorts(c *gin.Context) {
+	weeks, ok := parseCohortWeeks(c)
+	if !ok {
+		return
+	}
+
+	if err := h.cohorts.Aggregate(c.Request.Context(), weeks); err != nil {
+		h.logger.WithError(err).Error("Failed to aggregate cohorts")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to aggregate cohorts"})
+		return
+	}
+
+	report, err := h.cohorts.GetReport(c.Request.Context(), weeks)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get cohort report")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get cohort report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
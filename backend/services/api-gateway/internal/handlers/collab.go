@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const collabHeartbeatPeriod = 15 * time.Second
+
+// CollabEditRequest is an edit made on the draft at Revision
+type CollabEditRequest struct {
+	ParticipantID string                    `json:"participant_id" binding:"required"`
+	Revision      *int                      `json:"revision" binding:"required"`
+	Operation     *services.TextOperation   `json:"operation" binding:"required"`
+	Selection     *services.CollabSelection `json:"selection,omitempty"` // Where the editor is after the edit
+}
+
+// CollabPresenceRequest moves a participant's cursor in the draft at Revision
+type CollabPresenceRequest struct {
+	ParticipantID string                   `json:"participant_id" binding:"required"`
+	Revision      *int                     `json:"revision" binding:"required"`
+	Selection     services.CollabSelection `json:"selection"`
+}
+
+// CollabEnhanceRequest enhances the session's current draft
+type CollabEnhanceRequest struct {
+	ParticipantID string `json:"participant_id" binding:"required"`
+}
+
+// CollabHandler serves live editing sessions on saved prompts. Each
+// participant follows the session as a server-sent events stream and posts
+// their edits, cursor moves and enhance requests.
+type CollabHandler struct {
+	deps         *Dependencies
+	collab       *services.CollabService
+	prompts      SavedPromptStore
+	explanations enhanceExplanations
+	logger       *logrus.Entry
+}
+
+// NewCollabHandler creates a new collaboration handler
+func NewCollabHandler(deps *Dependencies, collab *services.CollabService, prompts SavedPromptStore, logger *logrus.Entry) *CollabHandler {
+	return &CollabHandler{
+		deps:         deps,
+		collab:       collab,
+		prompts:      prompts,
+		explanations: loadEnhanceExplanations(),
+		logger:       logger,
+	}
+}
+
+// JoinSession joins the caller to the prompt's editing session and streams
+// its events until they disconnect. The first event is a snapshot with the
+// draft, its revision and the caller's participant ID for later requests.
+func (h *CollabHandler) JoinSession(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	participant, events, err := h.collab.Join(c.Request.Context(), prompt, userID)
+	if err != nil {
+		h.respondError(c, err, "Failed to join editing session")
+		return
+	}
+	defer h.collab.Leave(prompt.ID, participant.ID)
+
+	logger := h.logger.WithFields(logrus.Fields{
+		"prompt_id":      prompt.ID,
+		"participant_id": participant.ID,
+		"user_id":        userID,
+	})
+	logger.Info("Joined editing session")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	heartbeat := time.NewTicker(collabHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			return true
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for falling behind; the client rejoins for a snapshot
+				logger.Info("Editing session stream closed")
+				return false
+			}
+			writeCollabEvent(w, event)
+			return true
+		}
+	})
+}
+
+// SubmitEdit applies the caller's edit to the draft and answers with the
+// revision it became. Everyone, the caller included, receives it as an
+// operation event.
+func (h *CollabHandler) SubmitEdit(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c)
+	if !ok {
+		return
+	}
+
+	var req CollabEditRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	revision, err := h.collab.Edit(prompt.ID, req.ParticipantID, userID, *req.Revision, req.Operation, req.Selection)
+	if err != nil {
+		h.respondError(c, err, "Failed to apply edit")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revision": revision})
+}
+
+// UpdatePresence moves the caller's cursor
+func (h *CollabHandler) UpdatePresence(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c)
+	if !ok {
+		return
+	}
+
+	var req CollabPresenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	if err := h.collab.MoveCursor(prompt.ID, req.ParticipantID, userID, *req.Revision, req.Selection); err != nil {
+		h.respondError(c, err, "Failed to update presence")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// EnhanceDraft runs the current draft through the enhancement pipeline on
+// the caller's behalf. Participants are told when it starts and get the
+// result, which is also the response.
+func (h *CollabHandler) EnhanceDraft(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	prompt, ok := h.authorizedPrompt(c)
+	if !ok {
+		return
+	}
+
+	var req CollabEnhanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	draft, revision, err := h.collab.StartEnhancement(prompt.ID, req.ParticipantID, rc.UserID)
+	if err != nil {
+		h.respondError(c, err, "Failed to start enhancement")
+		return
+	}
+
+	opts := enhanceOptions{Request: rc, Explain: h.explanations.enabledFor(rc)}
+	response, err := runEnhancement(c.Request.Context(), h.deps, logger, EnhanceRequest{Text: draft}, opts)
+	h.collab.FinishEnhancement(prompt.ID, req.ParticipantID, revision, response, err)
+	if err != nil {
+		respondPipelineError(c, err)
+		return
+	}
+
+	markModerationFlag(c, response)
+	c.JSON(http.StatusOK, response)
+	publishEnhancement(c, response, "collab")
+}
+
+// authorizedPrompt loads the saved prompt in the path and checks the caller
+// may still edit it, as access can be lost mid-session
+func (h *CollabHandler) authorizedPrompt(c *gin.Context) (*models.SavedPrompt, bool) {
+	prompt, ok := loadSavedPrompt(c, h.prompts, h.logger)
+	if !ok || !authorizeSavedPrompt(c, h.deps.Authz, prompt, services.PromptActionEdit, h.logger) {
+		return nil, false
+	}
+	return prompt, true
+}
+
+func (h *CollabHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrCollabSessionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCollabSessionFull), errors.Is(err, services.ErrCollabRevisionUnavailable),
+		errors.Is(err, services.ErrCollabEnhanceInProgress):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOperation), errors.Is(err, services.ErrCollabDraftTooLong):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process edit"})
+	}
+}
+
+// writeCollabEvent writes one session event in SSE wire format
+func writeCollabEvent(w io.Writer, event services.CollabEvent) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
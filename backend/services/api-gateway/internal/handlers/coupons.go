@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CouponHandler lets admins manage promo coupons and users redeem them
+type CouponHandler struct {
+	coupons *services.CouponService
+	logger  *logrus.Entry
+}
+
+// NewCouponHandler creates a new coupon handler
+func NewCouponHandler(coupons *services.CouponService, logger *logrus.Entry) *CouponHandler {
+	return &CouponHandler{
+		coupons: coupons,
+		logger:  logger,
+	}
+}
+
+// CouponRequest creates or updates a coupon. The code can't be changed once
+// the coupon exists.
+type CouponRequest struct {
+	Code           string     `json:"code"`
+	Description    string     `json:"description" binding:"max=500"`
+	BonusQuota     int        `json:"bonus_quota" binding:"required"`
+	MaxRedemptions *int       `json:"max_redemptions"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	Active         *bool      `json:"active"` // Defaults to true
+}
+
+// RedeemCouponRequest redeems a coupon for the caller
+type RedeemCouponRequest struct {
+	Code string `json:"code" binding:"required,max=32"`
+}
+
+// ListCoupons returns every coupon
+func (h *CouponHandler) ListCoupons(c *gin.Context) {
+	coupons, err := h.coupons.ListCoupons(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list coupons")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list coupons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
+}
+
+// GetCoupon returns one coupon
+func (h *CouponHandler) GetCoupon(c *gin.Context) {
+	coupon, err := h.coupons.GetCoupon(c.Request.Context(), c.Param("code"))
+	if err != nil {
+		h.respondError(c, err, "failed to get coupon")
+		return
+	}
+
+	c.JSON(http.StatusOK, coupon)
+}
+
+// CreateCoupon adds a coupon
+func (h *CouponHandler) CreateCoupon(c *gin.Context) {
+	coupon, ok := bindCoupon(c, "")
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	created, err := h.coupons.CreateCoupon(c.Request.Context(), coupon, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to create coupon")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateCoupon replaces a coupon's terms
+func (h *CouponHandler) UpdateCoupon(c *gin.Context) {
+	coupon, ok := bindCoupon(c, c.Param("code"))
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	updated, err := h.coupons.UpdateCoupon(c.Request.Context(), c.Param("code"), coupon, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to update coupon")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteCoupon removes a coupon
+func (h *CouponHandler) DeleteCoupon(c *gin.Context) {
+	adminID, _ := middleware.GetUserID(c)
+	if err := h.coupons.DeleteCoupon(c.Request.Context(), c.Param("code"), adminID); err != nil {
+		h.respondError(c, err, "failed to delete coupon")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RedeemCoupon grants the caller a coupon's bonus enhancements
+func (h *CouponHandler) RedeemCoupon(c *gin.Context) {
+	var req RedeemCouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	coupon, err := h.coupons.Redeem(c.Request.Context(), middleware.GetRequestContext(c).UserID, req.Code)
+	if err != nil {
+		h.respondError(c, err, "failed to redeem coupon")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":        coupon.Code,
+		"bonus_quota": coupon.BonusQuota,
+	})
+}
+
+func (h *CouponHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrCouponNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCouponExists), errors.Is(err, services.ErrCouponRedeemed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCouponUnavailable):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Coupon operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// bindCoupon reads and normalizes a coupon from the request body, answering
+// 400 when it is invalid. code, when set, is the coupon being updated.
+func bindCoupon(c *gin.Context, code string) (services.Coupon, bool) {
+	var req CouponRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.Coupon{}, false
+	}
+
+	coupon := services.Coupon{
+		Code:           req.Code,
+		Description:    req.Description,
+		BonusQuota:     req.BonusQuota,
+		MaxRedemptions: req.MaxRedemptions,
+		ExpiresAt:      req.ExpiresAt,
+		Active:         req.Active == nil || *req.Active,
+	}
+	if code != "" {
+		coupon.Code = code
+	}
+	if err := services.NormalizeCoupon(&coupon); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid coupon",
+			"details": err.Error(),
+		})
+		return services.Coupon{}, false
+	}
+	return coupon, true
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DemoEnhanceRequest is a prompt typed into the landing page demo
+type DemoEnhanceRequest struct {
+	Text string `json:"text" binding:"required,min=1"`
+}
+
+// DemoEnhanceResponse is a watermarked demo result
+type DemoEnhanceResponse struct {
+	EnhancedText   string   `json:"enhanced_text"` // Ends with the watermark
+	Intent         string   `json:"intent"`
+	TechniquesUsed []string `json:"techniques_used"`
+	Watermark      string   `json:"watermark"`
+	Demo           bool     `json:"demo"`
+}
+
+// DemoHandler serves the public demo on the landing page
+type DemoHandler struct {
+	deps *Dependencies
+	demo *services.DemoService
+}
+
+// NewDemoHandler creates a demo handler. Demo enhancements run without
+// anything that would keep the prompt: no history, cache, review queue or
+// training labels.
+func NewDemoHandler(deps *Dependencies, demo *services.DemoService) *DemoHandler {
+	return &DemoHandler{
+		deps: &Dependencies{
+			Classifier: deps.Classifier,
+			Selector:   deps.Selector,
+			Generator:  deps.Generator,
+			Latency:    deps.Latency,
+			Tokens:     deps.Tokens,
+		},
+		demo: demo,
+	}
+}
+
+// GetChallenge issues what the landing page needs to make a demo request
+func (h *DemoHandler) GetChallenge(c *gin.Context) {
+	challenge, err := h.demo.NewChallenge()
+	if err != nil {
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to issue demo challenge")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue challenge"})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, challenge)
+}
+
+// Enhance enhances a prompt for the demo. Requests have passed
+// middleware.DemoChallenge and the demo caps by the time they get here.
+func (h *DemoHandler) Enhance(c *gin.Context) {
+	var req DemoEnhanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if maxLength := h.demo.Config().MaxTextLength; utf8.RuneCountInString(req.Text) > maxLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":      "Prompt too long for the demo",
+			"max_length": maxLength,
+		})
+		return
+	}
+
+	result, err := runEnhancement(c.Request.Context(), h.deps, demoLogger(c), EnhanceRequest{Text: req.Text}, enhanceOptions{
+		Request:     middleware.GetRequestContext(c),
+		SkipHistory: true,
+	})
+	if err != nil {
+		respondPipelineError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, DemoEnhanceResponse{
+		EnhancedText:   h.demo.Watermark(result.EnhancedText),
+		Intent:         result.Intent,
+		TechniquesUsed: result.TechniquesUsed,
+		Watermark:      h.demo.Config().Watermark,
+		Demo:           true,
+	})
+}
+
+// demoLogger is the request's logger capped at info, as the pipeline logs
+// prompt text at debug and demo prompts aren't kept anywhere
+func demoLogger(c *gin.Context) *logrus.Entry {
+	entry := c.MustGet("logger").(*logrus.Entry)
+	if !entry.Logger.IsLevelEnabled(logrus.DebugLevel) {
+		return entry
+	}
+	capped := &logrus.Logger{
+		Out:          entry.Logger.Out,
+		Hooks:        entry.Logger.Hooks,
+		Formatter:    entry.Logger.Formatter,
+		ReportCaller: entry.Logger.ReportCaller,
+		Level:        logrus.InfoLevel,
+		ExitFunc:     entry.Logger.ExitFunc,
+	}
+	return capped.WithFields(entry.Data)
+}
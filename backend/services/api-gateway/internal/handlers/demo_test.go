@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemoEnhance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&logs)
+	logger.SetLevel(logrus.DebugLevel)
+
+	demo, err := services.NewDemoService(services.DemoConfig{
+		Enabled:       true,
+		Challenge:     services.DemoChallengeCaptcha,
+		CaptchaSecret: "secret",
+		MaxTextLength: 50,
+		Watermark:     "Made with the demo",
+	}, services.NewCacheService(nil, logger), logger)
+	require.NoError(t, err)
+
+	// History, cache and the review queue would panic or record the prompt
+	// if the demo reached them
+	h := NewDemoHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  stubGenerator{},
+		History:    new(MockDatabase),
+		Cache:      &services.CacheService{},
+	}, demo)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/demo/enhance", h.Enhance)
+	enhance := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/demo/enhance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := enhance(`{"text":"secret plans for the launch"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	var response DemoEnhanceResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, DemoEnhanceResponse{
+		EnhancedText:   "enhanced secret plans for the launch\n\n---\nMade with the demo",
+		Intent:         "reasoning",
+		TechniquesUsed: []string{"chain_of_thought"},
+		Watermark:      "Made with the demo",
+		Demo:           true,
+	}, response)
+	assert.NotContains(t, logs.String(), "secret plans", "demo prompts aren't logged")
+
+	rec = enhance(`{"text":"` + strings.Repeat("a", 51) + `"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/betterprompts/api-gateway/internal/tokenizer"
+)
+
+// IntentClassifier classifies the intent and complexity of a prompt
+type IntentClassifier interface {
+	ClassifyIntent(ctx context.Context, text string) (*services.IntentClassificationResult, error)
+}
+
+// IntentSuggester is implemented by classifiers that can score the runner-up
+// intents, returning the topK in IntentScores
+type IntentSuggester interface {
+	SuggestIntents(ctx context.Context, text string, topK int) (*services.IntentClassificationResult, error)
+}
+
+// IntentLabels keeps users' intent corrections as training labels
+type IntentLabels interface {
+	RecordIntentCorrection(ctx context.Context, correction services.IntentCorrection) error
+}
+
+// TechniqueSelector picks the techniques to apply to a prompt. Selectors
+// that also implement RulesVersion() string have it recorded with each
+// enhancement.
+type TechniqueSelector interface {
+	SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error)
+}
+
+// ReasoningSelector is implemented by selectors that can say why they chose
+// each technique, which is shown to users as the enhancement's explanation
+type ReasoningSelector interface {
+	SelectTechniquesWithReasoning(ctx context.Context, req models.TechniqueSelectionRequest) (*services.TechniqueSelectionResponse, error)
+}
+
+// Generator writes the enhanced prompt. Generators that also implement
+// Variant(routingKey string) string have the variant recorded when they
+// split traffic.
+type Generator interface {
+	GeneratePrompt(ctx context.Context, req models.PromptGenerationRequest) (*models.PromptGenerationResponse, error)
+}
+
+// TechniquePresets supplies the admin-defined technique combination for an
+// intent and tier, nil when there is none
+type TechniquePresets interface {
+	MatchPreset(ctx context.Context, intent, tier string) *services.TechniquePreset
+}
+
+// EnhancementProfiles resolves the enhancement profile a user's request runs
+// with, by name or from their default
+type EnhancementProfiles interface {
+	ResolveProfile(ctx context.Context, userID, name string) (*services.EnhancementProfile, error)
+}
+
+// HistoryStore persists enhancements as the user's prompt history
+type HistoryStore interface {
+	GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error)
+	SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error)
+	GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error)
+	DeletePromptHistory(ctx context.Context, id string) error
+}
+
+// profileHistoryStore is implemented by history stores that can list the
+// history made with one enhancement profile
+type profileHistoryStore interface {
+	GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error)
+}
+
+// SavedPromptStore loads the prompts users keep in their library
+type SavedPromptStore interface {
+	GetSavedPrompt(ctx context.Context, id string) (*models.SavedPrompt, error)
+}
+
+// Cache holds classifications and enhancements so repeated prompts skip the
+// downstream services
+type Cache interface {
+	GetCachedIntentClassification(ctx context.Context, textHash string) (*services.IntentClassificationResult, error)
+	CacheIntentClassification(ctx context.Context, textHash string, result *services.IntentClassificationResult, ttl time.Duration) error
+	GetCachedEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}) error
+	CacheEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}, ttl time.Duration) error
+	GetPreviousEnhancement(ctx context.Context, userID, textHash string) (*services.EnhancementSnapshot, error)
+	StorePreviousEnhancement(ctx context.Context, userID, textHash string, snapshot services.EnhancementSnapshot) error
+}
+
+var _ Cache = (*services.CacheService)(nil)
+
+// Pins keeps the enhancements users pinned for production use
+type Pins interface {
+	CreatePin(ctx context.Context, userID, name string, result services.PinnedResult) (*services.Pin, error)
+	AddVersion(ctx context.Context, userID, pinID string, result services.PinnedResult) (*services.Pin, error)
+	GetPin(ctx context.Context, userID, pinID string) (*services.Pin, error)
+	GetVersion(ctx context.Context, userID, pinID string, version int) (*services.PinnedResult, error)
+	ListPins(ctx context.Context, userID string) ([]*services.Pin, error)
+	DeletePin(ctx context.Context, userID, pinID string) error
+	PinnedHistory(ctx context.Context, userID string, historyIDs []string) (map[string]services.PinRef, error)
+}
+
+var _ Pins = (*services.PinService)(nil)
+
+// HistoryBulk applies one action to many history entries
+type HistoryBulk interface {
+	Apply(ctx context.Context, req services.HistoryBulkRequest, authorize func(services.PromptResource) error) (*services.HistoryBulkReport, error)
+}
+
+var _ HistoryBulk = (*services.HistoryBulkService)(nil)
+
+// Dependencies are the services the enhancement and history handlers are
+// built from. Tests construct one directly with stubs; production builds it
+// from the service clients with NewDependencies.
+type Dependencies struct {
+	Classifier IntentClassifier
+	Selector   TechniqueSelector
+	Generator  Generator
+	History    HistoryStore
+	Cache      Cache                              // Optional; nothing is cached when nil
+	Jobs       *services.EnhanceJobStore          // Optional; enhancements never outlive the request when nil
+	Dedup      *services.RequestDeduplicator      // Optional; double submits each run the pipeline when nil
+	Reviews    *services.EnhancementReviewService // Optional; nothing is queued for review when nil
+	Searches   *services.SearchAnalyticsService   // Optional; history searches aren't tracked when nil
+	Presets    TechniquePresets                   // Optional; the selector always decides when nil
+	Profiles   EnhancementProfiles                // Optional; requests can't pick a profile when nil
+	Authz      *services.PromptAuthorizer         // Optional; owners, admins and share tokens are still honoured when nil
+	Labels     IntentLabels                       // Optional; intent corrections aren't kept for training when nil
+	Pins       Pins                               // Optional; enhancements can't be pinned when nil
+	Bulk       HistoryBulk                        // Optional; bulk history actions are unavailable when nil
+	Latency    *services.LatencyTargets           // Optional; enhancements are never degraded to save time when nil
+	Tokens     *tokenizer.Registry                // Optional; token counts are estimated from text length when nil
+}
+
+// NewDependencies wires the handler dependencies from the service clients.
+// Job and deduplication stores need Redis and are left nil without it.
+func NewDependencies(clients *services.ServiceClients) *Dependencies {
+	deps := &Dependencies{
+		Classifier: clients.IntentClassifier,
+		Selector:   clients.TechniqueSelector,
+		Generator:  clients.PromptGenerator,
+		History:    clients.Database,
+		Reviews:    clients.EnhancementReviews,
+		Searches:   clients.SearchAnalytics,
+		Authz:      clients.PromptAuthz,
+		Latency:    clients.LatencyTargets,
+		Tokens:     clients.Tokenizers,
+	}
+	if clients.History != nil {
+		deps.History = clients.History
+	}
+	// A nil *CacheService must stay a nil interface so "no cache" checks hold
+	if clients.Cache != nil {
+		deps.Cache = clients.Cache
+		deps.Jobs = services.NewEnhanceJobStore(clients.Cache)
+		deps.Dedup = services.NewRequestDeduplicator(clients.Cache)
+	}
+	if clients.TechniquePresets != nil {
+		deps.Presets = clients.TechniquePresets
+	}
+	if clients.EnhancementProfiles != nil {
+		deps.Profiles = clients.EnhancementProfiles
+	}
+	if clients.Training != nil {
+		deps.Labels = clients.Training
+	}
+	if clients.Pins != nil {
+		deps.Pins = clients.Pins
+	}
+	if clients.HistoryBulk != nil {
+		deps.Bulk = clients.HistoryBulk
+	}
+	return deps
+}
+
+// rulesVersion returns the selector's rules version, empty when it doesn't
+// report one
+func (d *Dependencies) rulesVersion() string {
+	if selector, ok := d.Selector.(interface{ RulesVersion() string }); ok {
+		return selector.RulesVersion()
+	}
+	return ""
+}
+
+// generatorVariant returns the generator variant serving the routing key,
+// empty when the generator doesn't split traffic
+func (d *Dependencies) generatorVariant(key string) string {
+	if generator, ok := d.Generator.(interface{ Variant(string) string }); ok {
+		return generator.Variant(key)
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// countingClassifier records how often the pipeline had to classify
+type countingClassifier struct {
+	stubClassifier
+	calls int
+}
+
+func (c *countingClassifier) ClassifyIntent(ctx context.Context, text string) (*services.IntentClassificationResult, error) {
+	c.calls++
+	return c.stubClassifier.ClassifyIntent(ctx, text)
+}
+
+// versionedSelector reports a rules version like the real selector client
+type versionedSelector struct{ stubSelector }
+
+func (versionedSelector) RulesVersion() string { return "rules-1" }
+
+// memoryCache is an in-process Cache holding intent classifications only
+type memoryCache struct {
+	intents map[string]*services.IntentClassificationResult
+}
+
+func (m *memoryCache) GetCachedIntentClassification(ctx context.Context, textHash string) (*services.IntentClassificationResult, error) {
+	return m.intents[textHash], nil
+}
+
+func (m *memoryCache) CacheIntentClassification(ctx context.Context, textHash string, result *services.IntentClassificationResult, ttl time.Duration) error {
+	m.intents[textHash] = result
+	return nil
+}
+
+func (m *memoryCache) GetCachedEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}) error {
+	return errors.New("cache miss")
+}
+
+func (m *memoryCache) CacheEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}, ttl time.Duration) error {
+	return nil
+}
+
+func (m *memoryCache) GetPreviousEnhancement(ctx context.Context, userID, textHash string) (*services.EnhancementSnapshot, error) {
+	return nil, nil
+}
+
+func (m *memoryCache) StorePreviousEnhancement(ctx context.Context, userID, textHash string, snapshot services.EnhancementSnapshot) error {
+	return nil
+}
+
+func TestNewDependencies(t *testing.T) {
+	db := new(MockDatabase)
+	deps := NewDependencies(&services.ServiceClients{
+		IntentClassifier:  stubClassifier{},
+		TechniqueSelector: versionedSelector{},
+		PromptGenerator:   stubGenerator{},
+		Database:          db,
+	})
+
+	// Without Redis the optional dependencies must be untyped nils
+	assert.Nil(t, deps.Cache)
+	assert.Nil(t, deps.Jobs)
+	assert.Nil(t, deps.Dedup)
+	assert.Equal(t, db, deps.History)
+	assert.Equal(t, "rules-1", deps.rulesVersion())
+	assert.Empty(t, deps.generatorVariant("user-1"))
+}
+
+func TestEnhanceHandlerWithStubDependencies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	classifier := &countingClassifier{}
+	db := new(MockDatabase)
+	db.On("SavePromptHistory", mock.Anything, mock.AnythingOfType("models.PromptHistory")).Return("history-1", nil)
+	handler := NewEnhanceHandler(&Dependencies{
+		Classifier: classifier,
+		Selector:   versionedSelector{},
+		Generator:  stubGenerator{},
+		History:    db,
+		Cache:      &memoryCache{intents: map[string]*services.IntentClassificationResult{}},
+	})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logrus.NewEntry(logger))
+		c.Set("request_id", "req-1")
+	})
+	router.POST("/enhance", handler.Enhance)
+
+	enhance := func() EnhanceResponse {
+		body, _ := json.Marshal(EnhanceRequest{Text: "why is the sky blue"})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/enhance", bytes.NewReader(body)))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response EnhanceResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	first := enhance()
+	assert.Equal(t, "history-1", first.ID)
+	assert.Equal(t, "enhanced why is the sky blue", first.EnhancedText)
+	assert.Equal(t, []string{"chain_of_thought"}, first.TechniquesUsed)
+	assert.Equal(t, "rules-1", first.Metadata["versions"].(map[string]interface{})["rules"])
+
+	enhance()
+	assert.Equal(t, 1, classifier.calls, "second request should reuse the cached classification")
+
+	saved := db.Calls[0].Arguments.Get(1).(models.PromptHistory)
+	assert.Equal(t, "why is the sky blue", saved.OriginalInput)
+}
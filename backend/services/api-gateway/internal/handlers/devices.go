@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// DeviceHandler handles the signed-in device list in session management
+type DeviceHandler struct {
+	devices *services.DeviceService
+	logger  *logrus.Entry
+}
+
+// NewDeviceHandler creates a new device handler
+func NewDeviceHandler(devices *services.DeviceService, logger *logrus.Entry) *DeviceHandler {
+	return &DeviceHandler{
+		devices: devices,
+		logger:  logger,
+	}
+}
+
+// DeviceResponse is a device as shown to its owner
+type DeviceResponse struct {
+	*services.Device
+	Current bool `json:"current"` // The device making this request
+}
+
+// RenameDeviceRequest represents the request body for naming a device
+type RenameDeviceRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=100"`
+}
+
+// ListDevices lists the devices the caller has signed in from
+func (h *DeviceHandler) ListDevices(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	devices, err := h.devices.ListDevices(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list devices")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve devices"})
+		return
+	}
+
+	fingerprint := requestDevice(c).Fingerprint
+	response := make([]DeviceResponse, len(devices))
+	for i, device := range devices {
+		response[i] = DeviceResponse{
+			Device:  device,
+			Current: device.Fingerprint == fingerprint,
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"devices": response})
+}
+
+// RenameDevice sets the display name of one of the caller's devices
+func (h *DeviceHandler) RenameDevice(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req RenameDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if !validDeviceID(c) {
+		return
+	}
+
+	device, err := h.devices.RenameDevice(c.Request.Context(), userID, c.Param("id"), req.Name)
+	if err != nil {
+		if errors.Is(err, services.ErrDeviceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to rename device")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to rename device"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"device": device})
+}
+
+// RevokeDevice signs one of the caller's devices out by rejecting every
+// refresh token issued to it
+func (h *DeviceHandler) RevokeDevice(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if !validDeviceID(c) {
+		return
+	}
+
+	if err := h.devices.RevokeDevice(c.Request.Context(), userID, c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrDeviceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to revoke device")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke device"})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"device_id": c.Param("id"),
+	}).Info("Device revoked")
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
+
+// validDeviceID answers 404 for device IDs that aren't UUIDs, which no
+// device has, rather than letting the database reject them
+func validDeviceID(c *gin.Context) bool {
+	if _, err := uuid.Parse(c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrDeviceNotFound.Error()})
+		return false
+	}
+	return true
+}
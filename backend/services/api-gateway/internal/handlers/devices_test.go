@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshTokenKeys(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	h := &AuthHandler{cache: services.NewCacheService(client, logrus.New())}
+
+	// Refresh tokens share their JWT header; each still gets its own session
+	first := h.refreshTokenKey("u1", "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.first.sig")
+	second := h.refreshTokenKey("u1", "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.second.sig")
+	assert.NotEqual(t, first, second)
+	assert.True(t, strings.HasPrefix(first, h.cache.Key("refresh_token", "u1")+":"), "sessions can be dropped per user")
+	assert.Equal(t, first, h.refreshTokenKey("u1", "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.first.sig"))
+
+	assert.NotPanics(t, func() { h.refreshTokenKey("u1", "short") })
+}
+
+func TestDeviceHandlerRejectsMalformedIDs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Malformed IDs never reach the device service
+	h := NewDeviceHandler(nil, logrus.NewEntry(logrus.New()))
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+	})
+	router.PATCH("/devices/:id", h.RenameDevice)
+	router.DELETE("/devices/:id", h.RevokeDevice)
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPatch, "/devices/not-a-uuid", strings.NewReader(`{"name":"Laptop"}`)),
+		httptest.NewRequest(http.MethodDelete, "/devices/not-a-uuid", nil),
+	} {
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, req.Method)
+	}
+}
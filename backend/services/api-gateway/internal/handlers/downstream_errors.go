@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// pipelineError is a pipeline failure with its client-facing message. The
+// cause, usually a services.DownstreamError, decides the HTTP status.
+type pipelineError struct {
+	message string
+	cause   error
+}
+
+func (e *pipelineError) Error() string { return e.message }
+
+func (e *pipelineError) Unwrap() error { return e.cause }
+
+// downstreamStatus maps a failure to the HTTP status that describes it:
+// 504 when a service timed out, 503 when one is down or shedding load, 502
+// when one rejected what the gateway sent or answered with something that
+// breaks its contract, and 500 for anything else
+func downstreamStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrDownstreamTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, services.ErrDownstreamUnavailable), errors.Is(err, services.ErrDownstreamRateLimited):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, services.ErrDownstreamBadRequest), errors.Is(err, services.ErrDownstreamInvalidResponse):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// respondPipelineError answers a failed enhancement with the status for its
+// cause, passing on how long a rate limited service asked callers to wait
+func respondPipelineError(c *gin.Context, err error) {
+	var downstream *services.DownstreamError
+	if errors.As(err, &downstream) && downstream.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(downstream.RetryAfter.Seconds())))
+	}
+	c.JSON(downstreamStatus(err), gin.H{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnhanceDownstreamFailureStatuses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	for _, tc := range []struct {
+		name       string
+		err        error
+		status     int
+		retryAfter string
+	}{
+		{"timeout", &services.DownstreamError{Service: "intent classifier", Kind: services.ErrDownstreamTimeout, Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, ""},
+		{"unavailable", &services.DownstreamError{Service: "intent classifier", Kind: services.ErrDownstreamUnavailable, StatusCode: 503}, http.StatusServiceUnavailable, ""},
+		{"rate limited", &services.DownstreamError{Service: "intent classifier", Kind: services.ErrDownstreamRateLimited, StatusCode: 429, RetryAfter: 7 * time.Second}, http.StatusServiceUnavailable, "7"},
+		{"rejected", &services.DownstreamError{Service: "intent classifier", Kind: services.ErrDownstreamBadRequest, StatusCode: 422}, http.StatusBadGateway, ""},
+		{"other", errors.New("boom"), http.StatusInternalServerError, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewEnhanceHandler(&Dependencies{
+				Classifier: stubClassifier{err: tc.err},
+				Selector:   stubSelector{},
+				Generator:  stubGenerator{},
+				History:    new(MockDatabase),
+			})
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				c.Set("request_id", "req-1")
+				c.Set("logger", logrus.NewEntry(logger))
+			})
+			router.POST("/enhance", h.Enhance)
+
+			req := httptest.NewRequest(http.MethodPost, "/enhance", strings.NewReader(`{"text":"why is the sky blue"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.retryAfter, rec.Header().Get("Retry-After"))
+			assert.JSONEq(t, `{"error":"Failed to analyze intent"}`, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DrainHandler lets deploy tooling take the gateway out of rotation before
+// stopping it
+type DrainHandler struct {
+	drainer *services.Drainer
+	logger  *logrus.Entry
+}
+
+// NewDrainHandler creates a new drain handler
+func NewDrainHandler(drainer *services.Drainer, logger *logrus.Entry) *DrainHandler {
+	return &DrainHandler{
+		drainer: drainer,
+		logger:  logger,
+	}
+}
+
+// DrainRequest starts draining
+type DrainRequest struct {
+	Reason string `json:"reason" binding:"max=200"`
+}
+
+// GetDrain returns the drain state; deploy tooling polls it until
+// ready_to_stop is set
+func (h *DrainHandler) GetDrain(c *gin.Context) {
+	c.JSON(http.StatusOK, h.drainer.Status())
+}
+
+// StartDrain fails readiness from now on while still serving traffic
+func (h *DrainHandler) StartDrain(c *gin.Context) {
+	var req DrainRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+	}
+	if req.Reason == "" {
+		req.Reason = "admin"
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	if h.drainer.Start(req.Reason) {
+		h.logger.WithFields(logrus.Fields{
+			"audit":      true,
+			"updated_by": adminID,
+			"reason":     req.Reason,
+		}).Warn("Gateway draining")
+	}
+
+	c.JSON(http.StatusAccepted, h.drainer.Status())
+}
+
+// CancelDrain puts the gateway back in rotation, e.g. after an aborted deploy
+func (h *DrainHandler) CancelDrain(c *gin.Context) {
+	adminID, _ := middleware.GetUserID(c)
+	if h.drainer.Cancel() {
+		h.logger.WithFields(logrus.Fields{
+			"audit":      true,
+			"updated_by": adminID,
+		}).Warn("Gateway drain cancelled")
+	}
+
+	c.JSON(http.StatusOK, h.drainer.Status())
+}
+
+// GateReadiness answers readiness checks with 503 while draining, before
+// the dependency checks run
+func (h *DrainHandler) GateReadiness(c *gin.Context) {
+	if h.drainer.Draining() {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"status": "draining",
+		})
+		return
+	}
+	c.Next()
+}
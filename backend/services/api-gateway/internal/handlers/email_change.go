@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// EmailChangeRequest represents the request body for starting an email change
+type EmailChangeRequest struct {
+	NewEmail string `json:"new_email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ConfirmEmailChangeRequest represents the request body for a confirmation link
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestEmailChange starts an email change, sending confirmation links to
+// both the current and the new address
+func (h *AuthHandler) RequestEmailChange(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Authentication required",
+		})
+		return
+	}
+
+	var req EmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	change, err := h.userService.RequestEmailChange(c.Request.Context(), userID, req.Password, req.NewEmail)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to request email change")
+
+		statusCode := http.StatusInternalServerError
+		switch err.Error() {
+		case "current password is incorrect", "new email is the same as the current email":
+			statusCode = http.StatusBadRequest
+		case "email already exists":
+			statusCode = http.StatusConflict
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"old_email": change.OldEmail,
+		"new_email": change.NewEmail,
+		"ip":        c.ClientIP(),
+		"audit":     true,
+	}).Info("Email change requested")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":      "Confirmation links sent to your current and new email addresses",
+		"email_change": change,
+	})
+}
+
+// GetEmailChange returns the caller's pending email change
+func (h *AuthHandler) GetEmailChange(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Authentication required",
+		})
+		return
+	}
+
+	change, err := h.userService.GetPendingEmailChange(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, services.ErrEmailChangeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to get email change")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to get email change",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email_change": change})
+}
+
+// CancelEmailChange discards the caller's pending email change
+func (h *AuthHandler) CancelEmailChange(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Authentication required",
+		})
+		return
+	}
+
+	if err := h.userService.CancelEmailChange(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, services.ErrEmailChangeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to cancel email change")
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to cancel email change",
+		})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"ip":      c.ClientIP(),
+		"audit":   true,
+	}).Info("Email change cancelled")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email change cancelled",
+	})
+}
+
+// ConfirmEmailChange handles a confirmation link from either address. The
+// link needs no session since the old address's owner may be signed out.
+// When the second confirmation arrives the email is switched and every
+// session is signed out.
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	var req ConfirmEmailChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	change, err := h.userService.ConfirmEmailChange(c.Request.Context(), req.Token)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to confirm email change")
+
+		statusCode := http.StatusInternalServerError
+		if errors.Is(err, services.ErrEmailChangeNotFound) {
+			statusCode = http.StatusBadRequest
+		} else if err.Error() == "email already exists" {
+			statusCode = http.StatusConflict
+		}
+
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	fields := logrus.Fields{
+		"user_id":   change.UserID,
+		"old_email": change.OldEmail,
+		"new_email": change.NewEmail,
+		"ip":        c.ClientIP(),
+		"audit":     true,
+	}
+
+	if !change.Completed {
+		h.logger.WithFields(fields).Info("Email change confirmed by one address")
+		c.JSON(http.StatusOK, gin.H{
+			"message":      "Confirmation received, waiting for the other address",
+			"email_change": change,
+		})
+		return
+	}
+
+	h.invalidateSessions(c, change.UserID)
+	h.logger.WithFields(fields).Info("Email changed")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "Email changed successfully, please sign in again",
+		"email_change": change,
+	})
+}
+
+// invalidateSessions signs the user out everywhere by deleting their refresh
+// tokens and revoking their devices. Access tokens lapse on their own.
+func (h *AuthHandler) invalidateSessions(c *gin.Context, userID string) {
+	ctx := c.Request.Context()
+
+	if h.cache != nil {
+		if _, err := h.cache.DeleteSessionsWithPrefix(ctx, h.cache.Key("refresh_token", userID)); err != nil {
+			h.logger.WithError(err).WithField("user_id", userID).Error("Failed to delete refresh tokens")
+		}
+	}
+	if h.devices != nil {
+		if _, err := h.devices.RevokeAllDevices(ctx, userID); err != nil {
+			h.logger.WithError(err).WithField("user_id", userID).Error("Failed to revoke devices")
+		}
+	}
+}
@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/betterprompts/api-gateway/internal/middleware"
 	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/betterprompts/api-gateway/internal/services"
 	"github.com/gin-gonic/gin"
@@ -15,13 +20,28 @@ import (
 
 // EnhanceRequest represents the request body for prompt enhancement
 type EnhanceRequest struct {
-	Text              string                 `json:"text" binding:"required,min=1,max=5000"`
+	Text              string                 `json:"text" binding:"required_without=Messages,max=5000"`
+	Messages          []services.ChatMessage `json:"messages,omitempty" binding:"omitempty,max=50,dive"`
 	Context           map[string]interface{} `json:"context,omitempty"`
 	PreferTechniques  []string               `json:"prefer_techniques,omitempty"`
 	ExcludeTechniques []string               `json:"exclude_techniques,omitempty"`
 	TargetComplexity  string                 `json:"target_complexity,omitempty"`
+	OutputFormat      string                 `json:"output_format,omitempty" binding:"omitempty,oneof=text structured"`
+	UsePreset         bool                   `json:"use_preset,omitempty"`               // Apply the admin preset for the intent instead of asking the selector
+	Profile           string                 `json:"profile,omitempty" binding:"max=50"` // Enhancement profile; defaults to the one in the caller's preferences
+	Intent            string                 `json:"intent,omitempty" binding:"max=100"` // Intent picked by the user from /analyze candidates, overriding the classifier
+	DryRun            bool                   `json:"dry_run,omitempty"`                  // Stop after technique selection and return the plan with its estimated cost
 }
 
+// maxClassificationLength bounds the flattened conversation sent to the intent classifier
+const maxClassificationLength = 5000
+
+// Supported values for EnhanceRequest.OutputFormat
+const (
+	OutputFormatText       = "text"
+	OutputFormatStructured = "structured"
+)
+
 // EnhanceResponse represents the response for prompt enhancement
 type EnhanceResponse struct {
 	ID               string                 `json:"id"`
@@ -36,198 +56,690 @@ type EnhanceResponse struct {
 	ProcessingTime   float64                `json:"processing_time_ms"`
 	Enhanced         bool                   `json:"enhanced"`        // Flag to indicate enhancement
 	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	Sections         *services.PromptSections `json:"sections,omitempty"` // Set when output_format is "structured"
+	Status           string                 `json:"status,omitempty"` // "pending" when generation continues in a job, "dry_run" for a plan
+	JobID            string                 `json:"job_id,omitempty"` // Poll GET /enhance/jobs/:id for the result
+	Explanation      *EnhancementExplanation `json:"explanation,omitempty"` // Why these techniques, for showing to the user
+	Estimate         *EnhancementEstimate    `json:"estimate,omitempty"`    // What generation would use; set on dry runs
+}
+
+// EnhanceHandler serves the enhancement pipeline endpoints
+type EnhanceHandler struct {
+	deps         *Dependencies
+	timeouts     generationTimeouts
+	quickCaching quickEnhanceCaching
+	explanations enhanceExplanations
+	costs        generationCosts
+
+	// Quick enhancement cache keys being regenerated in the background
+	revalidating  sync.Map
+	revalidations sync.WaitGroup
 }
 
-// EnhancePrompt handles the main prompt enhancement endpoint
+// NewEnhanceHandler creates a new enhance handler
+func NewEnhanceHandler(deps *Dependencies) *EnhanceHandler {
+	return &EnhanceHandler{
+		deps:         deps,
+		timeouts:     loadGenerationTimeouts(),
+		quickCaching: loadQuickEnhanceCaching(),
+		explanations: loadEnhanceExplanations(),
+		costs:        loadGenerationCosts(),
+	}
+}
+
+// EnhancePrompt handles the main prompt enhancement endpoint.
+//
+// Deprecated: wire an EnhanceHandler and route to its Enhance method.
 func EnhancePrompt(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		startTime := time.Now()
-		logger := c.MustGet("logger").(*logrus.Entry)
-
-		var req EnhanceRequest
-		if err := c.ShouldBindJSON(&req); err != nil {
-			logger.WithError(err).Error("Invalid request body")
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid request body",
-				"details": err.Error(),
-			})
-			return
+	return NewEnhanceHandler(NewDependencies(clients)).Enhance
+}
+
+// Enhance handles the main prompt enhancement endpoint
+func (h *EnhanceHandler) Enhance(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+
+	representation, ok := negotiateEnhanceRepresentation(c.GetHeader("Accept"))
+	if !ok {
+		respondNotAcceptable(c)
+		return
+	}
+
+	var req EnhanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.WithError(err).Error("Invalid request body")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// The latest user turn stands in as the prompt text for conversations.
+	// An empty messages array doesn't excuse a missing text.
+	if req.Text == "" {
+		req.Text = services.LastUserMessage(req.Messages)
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		details := "text is required"
+		if len(req.Messages) > 0 {
+			details = "messages must contain at least one user message"
 		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Invalid request body",
+			"details": details,
+		})
+		return
+	}
+	// Corrections pick one of the classifier's intents; anything else would
+	// drive the pipeline and become a training label
+	if req.Intent != "" && !services.IsKnownIntent(req.Intent) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": "intent must be one of: " + strings.Join(services.KnownIntents, ", "),
+		})
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
 
-		// Get user ID if authenticated
-		userID, _ := c.Get("user_id")
+	// The caller's profile fills in whatever the request leaves unset
+	profile, ok := resolveEnhancementProfile(c, h.deps, rc, req.Profile)
+	if !ok {
+		return
+	}
+	var profileName string
+	if profile != nil {
+		applyEnhancementProfile(&req, profile)
+		profileName = profile.Name
+	}
 
-		// Generate text hash for caching
-		textHash := generateTextHash(req.Text)
+	// Version 2 responses always carry the output's sections
+	if representation.version == 2 && req.OutputFormat == "" {
+		req.OutputFormat = OutputFormatStructured
+	}
 
-		// Check cache for intent classification
-		var intentResult *services.IntentClassificationResult
-		if clients.Cache != nil {
-			intentResult, _ = clients.Cache.GetCachedIntentClassification(c.Request.Context(), textHash)
+	enhance := func() (interface{}, error) {
+		opts := enhanceOptions{Request: rc, Profile: profileName, Explain: h.explanations.enabledFor(rc), DryRun: req.DryRun}
+		if h.deps.Jobs != nil && h.timeouts.Soft > 0 && !req.DryRun {
+			return runEnhancementWithSoftTimeout(c.Request.Context(), h.deps, logger, req, opts, h.timeouts)
 		}
+		return runEnhancement(c.Request.Context(), h.deps, logger, req, opts)
+	}
 
-		// Step 1: Analyze intent if not cached
-		if intentResult == nil {
-			var err error
-			intentResult, err = clients.IntentClassifier.ClassifyIntent(c.Request.Context(), req.Text)
-			if err != nil {
-				logger.WithError(err).Error("Intent classification failed")
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"error": "Failed to analyze intent",
-				})
-				return
+	// Collapse double submits from the same user onto one pipeline run
+	response := &EnhanceResponse{}
+	var err error
+	if rc.Authenticated() && h.deps.Dedup != nil {
+		var deduplicated bool
+		deduplicated, err = h.deps.Dedup.Do(c.Request.Context(), enhanceDedupKey(rc.UserID, req), response, enhance)
+		if deduplicated {
+			if response.Metadata == nil {
+				response.Metadata = map[string]interface{}{}
 			}
+			response.Metadata["deduplicated"] = true
+			logger.WithField("history_id", response.ID).Info("Deduplicated concurrent enhance request")
+		}
+	} else {
+		var result interface{}
+		result, err = enhance()
+		if err == nil {
+			response = result.(*EnhanceResponse)
+		}
+	}
+	if err != nil {
+		respondPipelineError(c, err)
+		return
+	}
 
-			// Cache the result
-			if clients.Cache != nil {
-				clients.Cache.CacheIntentClassification(c.Request.Context(), textHash, intentResult, 1*time.Hour)
-			}
+	// Generation outlived the soft timeout and finishes in a job
+	if response.JobID != "" {
+		c.Header("Location", enhanceJobURL(response.JobID))
+		respondEnhance(c, representation, http.StatusAccepted, response)
+		return
+	}
+
+	markModerationFlag(c, response)
+	if response.Status == EnhanceStatusDryRun {
+		response.Estimate = h.costs.estimate(h.deps.Tokens.For(""), req, response.TechniquesUsed)
+		respondEnhance(c, representation, http.StatusOK, response)
+		return
+	}
+	respondEnhance(c, representation, http.StatusOK, response)
+	publishEnhancement(c, response, "web")
+}
+
+// enhanceOptions controls the side effects of runEnhancement
+type enhanceOptions struct {
+	// Request is the caller; its tier selects the prompt injection policy
+	Request     *services.RequestContext
+	SkipHistory bool   // Don't persist the result to prompt history
+	Profile     string // Enhancement profile applied to the request, recorded with the result
+	Explain     bool   // Explain the choice of techniques in the response
+	DryRun      bool   // Stop before generation, returning the plan with nothing persisted or recorded
+	// OnGenerating, when set, receives the classification and technique
+	// selection just before prompt generation starts
+	OnGenerating func(partial *EnhanceResponse)
+}
+
+// selectTechniques asks the selector for techniques, with its reasoning when
+// an explanation is wanted and the selector can give one
+func selectTechniques(ctx context.Context, deps *Dependencies, req models.TechniqueSelectionRequest, withReasoning bool) ([]string, *services.TechniqueSelectionResponse, error) {
+	reasoner, ok := deps.Selector.(ReasoningSelector)
+	if !ok || !withReasoning {
+		techniques, err := deps.Selector.SelectTechniques(ctx, req)
+		return techniques, nil, err
+	}
+
+	selection, err := reasoner.SelectTechniquesWithReasoning(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	techniques := make([]string, len(selection.Techniques))
+	for i, t := range selection.Techniques {
+		techniques[i] = t.ID
+	}
+	return techniques, selection, nil
+}
+
+// runEnhancement classifies, selects techniques for and generates an enhanced
+// prompt. Errors carry the client-facing message; details are logged here.
+func runEnhancement(ctx context.Context, deps *Dependencies, logger *logrus.Entry, req EnhanceRequest, opts enhanceOptions) (*EnhanceResponse, error) {
+	startTime := time.Now()
+	rc := opts.Request
+	if rc == nil {
+		rc = &services.RequestContext{Tier: services.TierAnonymous}
+	}
+	ctx = services.WithRequestContext(ctx, rc)
+
+	// Conversations are classified on a flattened transcript
+	classificationText := req.Text
+	if len(req.Messages) > 0 {
+		classificationText = services.FlattenConversation(req.Messages, maxClassificationLength)
+	}
+
+	// Generate text hash for caching
+	textHash := generateTextHash(classificationText)
+
+	// Check cache for intent classification
+	var intentResult *services.IntentClassificationResult
+	if deps.Cache != nil {
+		intentResult, _ = deps.Cache.GetCachedIntentClassification(ctx, textHash)
+	}
+
+	// Step 1: Analyze intent if not cached
+	if intentResult == nil {
+		var err error
+		intentResult, err = deps.Classifier.ClassifyIntent(ctx, classificationText)
+		if err != nil {
+			logger.WithError(err).Error("Intent classification failed")
+			return nil, &pipelineError{message: "Failed to analyze intent", cause: err}
 		}
 
-		// Step 2: Select techniques
-		techniqueRequest := models.TechniqueSelectionRequest{
-			Text:              req.Text,
-			Intent:            intentResult.Intent,
-			Complexity:        intentResult.Complexity,
-			PreferTechniques:  req.PreferTechniques,
-			ExcludeTechniques: req.ExcludeTechniques,
-			UserID:            userID,
+		// Cache the result
+		if deps.Cache != nil {
+			deps.Cache.CacheIntentClassification(ctx, textHash, intentResult, 1*time.Hour)
 		}
-		
-		// Debug log what we're sending
-		logger.WithFields(logrus.Fields{
-			"text_len":   len(req.Text),
-			"text":       req.Text,
-			"intent":     techniqueRequest.Intent,
-			"complexity": techniqueRequest.Complexity,
-		}).Debug("Sending technique selection request")
+	}
 
-		techniques, err := clients.TechniqueSelector.SelectTechniques(c.Request.Context(), techniqueRequest)
-		if err != nil {
-			logger.WithError(err).Error("Technique selection failed")
-			// Fall back to suggested techniques from intent classifier
+	// The user corrected the intent before enhancing. Their pick drives the
+	// pipeline; the prediction is kept so the classifier can learn from it.
+	var correction *services.IntentCorrection
+	if req.Intent != "" && req.Intent != intentResult.Intent {
+		correction = &services.IntentCorrection{
+			Intent:              req.Intent,
+			PredictedIntent:     intentResult.Intent,
+			PredictedConfidence: intentResult.Confidence,
+			UserID:              rc.UserID,
+		}
+		corrected := *intentResult
+		corrected.Intent = req.Intent
+		corrected.Confidence = 1
+		intentResult = &corrected
+	}
+
+	// Step 2: Select techniques
+	techniqueRequest := models.TechniqueSelectionRequest{
+		Text:              req.Text,
+		Intent:            intentResult.Intent,
+		Complexity:        intentResult.Complexity,
+		PreferTechniques:  req.PreferTechniques,
+		ExcludeTechniques: req.ExcludeTechniques,
+	}
+	if rc.Authenticated() {
+		techniqueRequest.UserID = rc.UserID
+	}
+	
+	// Debug log what we're sending
+	logger.WithFields(logrus.Fields{
+		"text_len":   len(req.Text),
+		"text":       req.Text,
+		"intent":     techniqueRequest.Intent,
+		"complexity": techniqueRequest.Complexity,
+	}).Debug("Sending technique selection request")
+
+	// Rules version is only meaningful when the selector made the choice
+	var rulesVersion string
+	var techniques []string
+	var selection *services.TechniqueSelectionResponse
+	var err error
+	explanationSource := explanationSourceSelector
+	preset, presetReason := matchPreset(ctx, deps, req, intentResult.Intent, rc.Tier, services.PresetReasonRequested)
+
+	// Without the selector, fall back to the admin preset, then to the
+	// intent classifier's suggestions
+	withoutSelector := func(reason string) {
+		preset, presetReason = matchPreset(ctx, deps, req, intentResult.Intent, rc.Tier, reason)
+		if preset != nil {
+			techniques = preset.TechniquesExcluding(req.ExcludeTechniques)
+			explanationSource = explanationSourcePreset
+		} else {
 			techniques = intentResult.SuggestedTechniques
+			explanationSource = explanationSourceDefault
 		}
-		
-		// Ensure we have at least some techniques
-		if len(techniques) == 0 {
-			// Apply default techniques based on intent and complexity
-			switch intentResult.Intent {
-			case "explanation", "education":
-				techniques = []string{"step_by_step", "analogical"}
-			case "reasoning", "problem_solving":
-				techniques = []string{"chain_of_thought"}
-			case "task_planning":
-				techniques = []string{"step_by_step", "structured_output"}
-			case "creative_writing":
-				techniques = []string{"role_play", "emotional_appeal"}
-			default:
-				techniques = []string{"step_by_step"}
-			}
-			logger.WithFields(logrus.Fields{
-				"intent": intentResult.Intent,
-				"complexity": intentResult.Complexity,
-				"default_techniques": techniques,
-			}).Info("Applied default techniques due to empty selection")
-		}
-
-		// Step 3: Generate enhanced prompt
-		// Ensure context includes enhanced flag
-		generationContext := make(map[string]interface{})
-		if req.Context != nil {
-			for k, v := range req.Context {
-				generationContext[k] = v
-			}
+	}
+
+	// Intents with a latency target give up quality, a step at a time, when
+	// the stages left wouldn't otherwise finish in time
+	latencyPlan := deps.Latency.Plan(intentResult.Intent, startTime)
+
+	selectionStart := time.Now()
+	if preset != nil {
+		techniques = preset.TechniquesExcluding(req.ExcludeTechniques)
+		explanationSource = explanationSourcePreset
+	} else if latencyPlan.SkipSelector() {
+		withoutSelector(services.PresetReasonLatencyTarget)
+	} else if techniques, selection, err = selectTechniques(ctx, deps, techniqueRequest, opts.Explain); err != nil {
+		logger.WithError(err).Error("Technique selection failed")
+		withoutSelector(services.PresetReasonSelectorUnavailable)
+	} else {
+		deps.Latency.ObserveSelector(time.Since(selectionStart))
+		rulesVersion = deps.rulesVersion()
+	}
+
+	var presetUse map[string]interface{}
+	if preset != nil {
+		if !opts.DryRun {
+			services.RecordPresetApplied(preset, presetReason)
 		}
-		generationContext["enhanced"] = true // Critical: This flag enables enhancement
-		
-		generationRequest := models.PromptGenerationRequest{
-			Text:       req.Text,
-			Intent:     intentResult.Intent,
-			Complexity: intentResult.Complexity,
-			Techniques: techniques,
-			Context:    generationContext,
+		presetUse = map[string]interface{}{"id": preset.ID, "reason": presetReason}
+		logger.WithFields(logrus.Fields{
+			"preset_id":  preset.ID,
+			"reason":     presetReason,
+			"techniques": techniques,
+		}).Info("Applied technique preset")
+	}
+	
+	// Ensure we have at least some techniques
+	if len(techniques) == 0 {
+		// Apply default techniques based on intent and complexity
+		switch intentResult.Intent {
+		case "explanation", "education":
+			techniques = []string{"step_by_step", "analogical"}
+		case "reasoning", "problem_solving":
+			techniques = []string{"chain_of_thought"}
+		case "task_planning":
+			techniques = []string{"step_by_step", "structured_output"}
+		case "creative_writing":
+			techniques = []string{"role_play", "emotional_appeal"}
+		default:
+			techniques = []string{"step_by_step"}
 		}
+		explanationSource = explanationSourceDefault
+		logger.WithFields(logrus.Fields{
+			"intent": intentResult.Intent,
+			"complexity": intentResult.Complexity,
+			"default_techniques": techniques,
+		}).Info("Applied default techniques due to empty selection")
+	}
 
-		enhancedPrompt, err := clients.PromptGenerator.GeneratePrompt(c.Request.Context(), generationRequest)
-		if err != nil {
-			logger.WithError(err).Error("Prompt generation failed")
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to generate enhanced prompt",
-			})
-			return
-		}
-		
-		// Debug log the response
+	techniques = latencyPlan.LimitTechniques(techniques)
+
+	// Step 3: Generate enhanced prompt
+	// Ensure context includes enhanced flag
+	// Screen the conversation and free-form context for prompt injection before they reach generation
+	sanitizedContext, injection := services.InspectForInjection(req.Text, req.Messages, req.Context, services.InjectionPolicyForTier(rc.Tier))
+	if injection.Flagged {
 		logger.WithFields(logrus.Fields{
-			"enhanced_text": enhancedPrompt.Text,
-			"tokens_used":   enhancedPrompt.TokensUsed,
-			"model_version": enhancedPrompt.ModelVersion,
-		}).Debug("Prompt generation response")
+			"risk_score": injection.RiskScore,
+			"mode":       injection.Mode,
+			"findings":   injection.Findings,
+		}).Warn("Possible prompt injection detected")
+	}
 
-		// Step 4: Save to history if user is authenticated
-		sessionID := c.GetHeader("X-Session-ID")
-		if sessionID == "" {
-			sessionID = c.MustGet("request_id").(string)
-		}
+	generationContext := make(map[string]interface{})
+	for k, v := range sanitizedContext {
+		generationContext[k] = v
+	}
+	generationContext["enhanced"] = true // Critical: This flag enables enhancement
+	if len(req.Messages) > 0 {
+		generationContext["messages"] = req.Messages
+	}
+	
+	generationRequest := models.PromptGenerationRequest{
+		Text:       req.Text,
+		Intent:     intentResult.Intent,
+		Complexity: intentResult.Complexity,
+		Techniques: techniques,
+		Context:    generationContext,
+	}
 
-		historyEntry := models.PromptHistory{
-			UserID:         sql.NullString{String: func() string { if uid, ok := userID.(string); ok { return uid } else { return "" } }(), Valid: userID != nil},
-			SessionID:      sql.NullString{String: sessionID, Valid: sessionID != ""},
-			OriginalInput:  req.Text,
-			EnhancedOutput: enhancedPrompt.Text,
-			Intent:         sql.NullString{String: intentResult.Intent, Valid: true},
-			Complexity:     sql.NullString{String: intentResult.Complexity, Valid: true},
+	// Keep each user on the same generator variant when a canary is running
+	routingKey := rc.RoutingKey()
+	generatorVariant := deps.generatorVariant(routingKey)
+
+	generationCtx := services.WithRoutingKey(ctx, routingKey)
+	if maxTokens := latencyPlan.MaxTokens(len(techniques)); maxTokens > 0 {
+		generationCtx = services.WithMaxGenerationTokens(generationCtx, maxTokens)
+	}
+	latency := latencyPlan.Report()
+	if latency != nil {
+		logger.WithFields(logrus.Fields{
+			"target_ms":    latency.TargetMs,
+			"degradations": latency.Degradations,
+		}).Info("Degraded enhancement to meet latency target")
+	}
+
+	if opts.DryRun {
+		response := &EnhanceResponse{
+			OriginalText:   req.Text,
+			Intent:         intentResult.Intent,
+			Complexity:     intentResult.Complexity,
+			Techniques:     techniques,
 			TechniquesUsed: techniques,
-			IntentConfidence: sql.NullFloat64{Float64: intentResult.Confidence, Valid: true},
+			Confidence:     intentResult.Confidence,
+			ProcessingTime: float64(time.Since(startTime).Milliseconds()),
+			Status:         EnhanceStatusDryRun,
 			Metadata: map[string]interface{}{
-				"processing_time_ms": time.Since(startTime).Milliseconds(),
-				"model_version":      enhancedPrompt.ModelVersion,
+				"versions": services.PipelineVersions{Gateway: services.BuildVersion, Rules: rulesVersion},
 			},
 		}
-
-		historyID, err := clients.Database.SavePromptHistory(c.Request.Context(), historyEntry)
-		if err != nil {
-			logger.WithError(err).Warn("Failed to save prompt history")
-			// Don't fail the request if history save fails
+		if generatorVariant != "" {
+			response.Metadata["generator_variant"] = generatorVariant
+		}
+		if presetUse != nil {
+			response.Metadata["technique_preset"] = presetUse
+		}
+		if opts.Profile != "" {
+			response.Metadata["profile"] = opts.Profile
+		}
+		if injection.Flagged {
+			response.Metadata["injection"] = injection
+		}
+		if correction != nil {
+			response.Metadata["predicted_intent"] = correction.PredictedIntent
+		}
+		if latency != nil {
+			response.Metadata["latency"] = latency
+		}
+		if opts.Explain {
+			response.Explanation = explainEnhancement(techniques, selection, explanationSource, rc.Locale, rc.ExplanationStyle)
 		}
+		logger.WithFields(logrus.Fields{
+			"intent":     response.Intent,
+			"techniques": techniques,
+		}).Info("Planned dry-run enhancement")
+		return response, nil
+	}
 
-		// Prepare response
-		response := EnhanceResponse{
-			ID:             historyID,
+	if opts.OnGenerating != nil {
+		opts.OnGenerating(&EnhanceResponse{
 			OriginalText:   req.Text,
-			EnhancedText:   enhancedPrompt.Text,
-			EnhancedPrompt: enhancedPrompt.Text, // Alias for compatibility
 			Intent:         intentResult.Intent,
 			Complexity:     intentResult.Complexity,
-			Techniques:     techniques,          // Alias for compatibility
+			Techniques:     techniques,
 			TechniquesUsed: techniques,
 			Confidence:     intentResult.Confidence,
-			ProcessingTime: float64(time.Since(startTime).Milliseconds()),
-			Enhanced:       true,                // Always true for successful enhancement
-			Metadata: map[string]interface{}{
-				"tokens_used":   enhancedPrompt.TokensUsed,
-				"model_version": enhancedPrompt.ModelVersion,
-			},
+		})
+	}
+
+	generationStart := time.Now()
+	enhancedPrompt, err := deps.Generator.GeneratePrompt(generationCtx, generationRequest)
+	if err != nil {
+		logger.WithError(err).Error("Prompt generation failed")
+		return nil, &pipelineError{message: "Failed to generate enhanced prompt", cause: err}
+	}
+	deps.Latency.ObserveGeneration(time.Since(generationStart), len(techniques))
+	
+	// Debug log the response
+	logger.WithFields(logrus.Fields{
+		"enhanced_text": enhancedPrompt.Text,
+		"tokens_used":   enhancedPrompt.TokensUsed,
+		"model_version": enhancedPrompt.ModelVersion,
+	}).Debug("Prompt generation response")
+
+	versions := services.PipelineVersions{
+		Gateway: services.BuildVersion,
+		Rules:   rulesVersion,
+		Model:   enhancedPrompt.ModelVersion,
+	}
+
+	// Step 4: Save to history if user is authenticated
+	sessionID := rc.Client.SessionID
+	historyEntry := models.PromptHistory{
+		UserID:         sql.NullString{String: rc.UserID, Valid: rc.UserID != ""},
+		SessionID:      sql.NullString{String: sessionID, Valid: sessionID != ""},
+		OriginalInput:  req.Text,
+		EnhancedOutput: enhancedPrompt.Text,
+		Intent:         sql.NullString{String: intentResult.Intent, Valid: true},
+		Complexity:     sql.NullString{String: intentResult.Complexity, Valid: true},
+		TechniquesUsed: techniques,
+		IntentConfidence: sql.NullFloat64{Float64: intentResult.Confidence, Valid: true},
+		TokenCount:     generationTokens(deps.Tokens, req.Text, enhancedPrompt.Text),
+		Metadata: map[string]interface{}{
+			"processing_time_ms": time.Since(startTime).Milliseconds(),
+			"model_version":      enhancedPrompt.ModelVersion,
+			"versions":           versions,
+		},
+	}
+
+	if generatorVariant != "" {
+		historyEntry.Metadata["generator_variant"] = generatorVariant
+	}
+
+	// Preset usage analytics are read back from history
+	if presetUse != nil {
+		historyEntry.Metadata["technique_preset"] = presetUse
+	}
+
+	// History is filterable by profile
+	if opts.Profile != "" {
+		historyEntry.Metadata["profile"] = opts.Profile
+	}
+
+	if latency != nil {
+		historyEntry.Metadata["latency"] = latency
+	}
+
+	// Which of the classifier's models answered, for drift monitoring
+	if classifier, ok := intentResult.Metadata["classifier"].(string); ok && classifier != "" {
+		historyEntry.Metadata["intent_classifier"] = classifier
+	}
+
+	if correction != nil {
+		historyEntry.Metadata["intent_correction"] = map[string]interface{}{
+			"predicted_intent":     correction.PredictedIntent,
+			"predicted_confidence": correction.PredictedConfidence,
 		}
+	}
+
+	var historyID string
+	if !opts.SkipHistory {
+		historyID, err = deps.History.SavePromptHistory(ctx, historyEntry)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to save prompt history")
+			// Don't fail the request if history save fails
+		}
+	}
+
+	// Prepare response
+	response := EnhanceResponse{
+		ID:             historyID,
+		OriginalText:   req.Text,
+		EnhancedText:   enhancedPrompt.Text,
+		EnhancedPrompt: enhancedPrompt.Text, // Alias for compatibility
+		Intent:         intentResult.Intent,
+		Complexity:     intentResult.Complexity,
+		Techniques:     techniques,          // Alias for compatibility
+		TechniquesUsed: techniques,
+		Confidence:     intentResult.Confidence,
+		ProcessingTime: float64(time.Since(startTime).Milliseconds()),
+		Enhanced:       true,                // Always true for successful enhancement
+		Metadata: map[string]interface{}{
+			"tokens_used":   enhancedPrompt.TokensUsed,
+			"model_version": enhancedPrompt.ModelVersion,
+			"versions":      versions,
+		},
+	}
 
-		// Cache the enhanced result
-		if clients.Cache != nil {
-			err = clients.Cache.CacheEnhancedPrompt(c.Request.Context(), textHash, techniques, &response, 1*time.Hour)
-			if err != nil {
-				logger.WithError(err).Debug("Failed to cache enhanced prompt")
+	if generatorVariant != "" {
+		response.Metadata["generator_variant"] = generatorVariant
+	}
+
+	if presetUse != nil {
+		response.Metadata["technique_preset"] = presetUse
+	}
+
+	if opts.Profile != "" {
+		response.Metadata["profile"] = opts.Profile
+	}
+
+	if injection.Flagged {
+		response.Metadata["injection"] = injection
+	}
+
+	if latency != nil {
+		response.Metadata["latency"] = latency
+	}
+
+	if opts.Explain {
+		response.Explanation = explainEnhancement(techniques, selection, explanationSource, rc.Locale, rc.ExplanationStyle)
+	}
+
+	if correction != nil {
+		response.Metadata["predicted_intent"] = correction.PredictedIntent
+
+		// Signed-in users' corrections become intent labels for retraining
+		if deps.Labels != nil && historyID != "" && rc.Authenticated() {
+			correction.HistoryID = historyID
+			if err := deps.Labels.RecordIntentCorrection(ctx, *correction); err != nil {
+				logger.WithError(err).Warn("Failed to record intent correction")
 			}
 		}
+	}
 
-		logger.WithFields(logrus.Fields{
-			"intent":          response.Intent,
-			"complexity":      response.Complexity,
-			"techniques_used": response.TechniquesUsed,
-			"processing_time": response.ProcessingTime,
-		}).Info("Prompt enhanced successfully")
+	// Queue low-confidence results for a human to check; corrections reach
+	// the user later by email and in their history
+	if deps.Reviews != nil && historyID != "" {
+		validationScore := services.GenerationValidationScore(enhancedPrompt.Metadata)
+		if reasons := deps.Reviews.Config().ReviewReasons(intentResult.Confidence, validationScore); len(reasons) > 0 {
+			deps.Reviews.Enqueue(services.ReviewCandidate{
+				HistoryID:        historyID,
+				UserID:           rc.UserID,
+				Reasons:          reasons,
+				IntentConfidence: intentResult.Confidence,
+				ValidationScore:  validationScore,
+			})
+			response.Metadata["review_pending"] = true
+		}
+	}
 
-		c.JSON(http.StatusOK, response)
+	// Decompose into sections for programmatic consumers
+	if req.OutputFormat == OutputFormatStructured {
+		sections := services.ParsePromptSections(enhancedPrompt.Text, req.Text)
+		response.Sections = &sections
 	}
+
+	// Cache the enhanced result
+	if deps.Cache != nil {
+		err = deps.Cache.CacheEnhancedPrompt(ctx, textHash, techniques, &response, 1*time.Hour)
+		if err != nil {
+			logger.WithError(err).Debug("Failed to cache enhanced prompt")
+		}
+	}
+
+	// Show how the result evolved since the user last enhanced the same text
+	if rc.Authenticated() && deps.Cache != nil {
+		compareWithPreviousEnhancement(ctx, deps.Cache, logger, rc.UserID, textHash, &response, enhancedPrompt.ModelVersion)
+	}
+
+	logger.WithFields(logrus.Fields{
+		"intent":          response.Intent,
+		"complexity":      response.Complexity,
+		"techniques_used": response.TechniquesUsed,
+		"processing_time": response.ProcessingTime,
+	}).Info("Prompt enhanced successfully")
+
+	return &response, nil
+}
+
+// matchPreset returns the admin preset to apply for reason. Presets are only
+// requested when the caller set use_preset, and one whose techniques are all
+// excluded by the caller doesn't apply.
+func matchPreset(ctx context.Context, deps *Dependencies, req EnhanceRequest, intent, tier, reason string) (*services.TechniquePreset, string) {
+	if deps.Presets == nil || (reason == services.PresetReasonRequested && !req.UsePreset) {
+		return nil, ""
+	}
+	preset := deps.Presets.MatchPreset(ctx, intent, tier)
+	if preset == nil || len(preset.TechniquesExcluding(req.ExcludeTechniques)) == 0 {
+		return nil, ""
+	}
+	return preset, reason
+}
+
+// compareWithPreviousEnhancement adds a previous_result reference and a diff
+// summary to the response metadata when the user has enhanced the same text
+// before, then remembers this result for the next comparison
+func compareWithPreviousEnhancement(ctx context.Context, cache Cache, logger *logrus.Entry, userID, textHash string, response *EnhanceResponse, modelVersion string) {
+	current := services.EnhancementSnapshot{
+		ID:           response.ID,
+		EnhancedText: response.EnhancedText,
+		Techniques:   response.TechniquesUsed,
+		ModelVersion: modelVersion,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	previous, err := cache.GetPreviousEnhancement(ctx, userID, textHash)
+	if err != nil {
+		logger.WithError(err).Debug("Failed to look up previous enhancement")
+	} else if previous != nil {
+		response.Metadata["previous_result"] = map[string]interface{}{
+			"id":            previous.ID,
+			"created_at":    previous.CreatedAt,
+			"model_version": previous.ModelVersion,
+			"techniques":    previous.Techniques,
+		}
+		response.Metadata["diff"] = services.DiffEnhancements(*previous, current)
+	}
+
+	if err := cache.StorePreviousEnhancement(ctx, userID, textHash, current); err != nil {
+		logger.WithError(err).Debug("Failed to remember enhancement for comparison")
+	}
+}
+
+// publishEnhancement reports a completed enhancement to the admin activity feed
+func publishEnhancement(c *gin.Context, response *EnhanceResponse, source string) {
+	middleware.PublishActivity(c, services.ActivityEnhancement, "", map[string]interface{}{
+		"history_id":         response.ID,
+		"source":             source,
+		"intent":             response.Intent,
+		"techniques":         response.TechniquesUsed,
+		"processing_time_ms": response.ProcessingTime,
+		"flagged":            response.Metadata["injection"] != nil,
+	})
+}
+
+// markModerationFlag lets AbuseGuard count enhancements that tripped the
+// injection scanner towards the user's abuse signals
+func markModerationFlag(c *gin.Context, response *EnhanceResponse) {
+	if _, flagged := response.Metadata["injection"]; flagged {
+		c.Set("moderation_flagged", true)
+	}
+}
+
+// enhanceDedupKey identifies identical enhance requests from the same user
+func enhanceDedupKey(userID string, req EnhanceRequest) string {
+	body, _ := json.Marshal(req)
+	return generateTextHash(userID + "|" + string(body))
 }
 
 // generateTextHash creates a hash of the input text for caching
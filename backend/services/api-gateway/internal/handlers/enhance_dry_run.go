@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/tokenizer"
+)
+
+// EnhanceStatusDryRun marks a response that stopped before generation
+const EnhanceStatusDryRun = "dry_run"
+
+// Rough token counts of what techniques add to a generation, which can't
+// be counted before it runs
+const (
+	techniqueInstructionTokens = 40 // Instructions sent to the generator per technique
+	techniqueOutputTokens      = 60 // Text each technique adds to the enhanced prompt
+)
+
+// EnhancementEstimate is the expected size and cost of generating a dry
+// run's plan
+type EnhancementEstimate struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	TotalTokens  int     `json:"total_tokens"`
+	Cost         float64 `json:"cost"`
+	Currency     string  `json:"currency"`
+}
+
+// generationCosts is what generation costs per thousand tokens
+type generationCosts struct {
+	InputPerThousand  float64
+	OutputPerThousand float64
+	Currency          string
+}
+
+// loadGenerationCosts reads GENERATION_COST_PER_1K_INPUT_TOKENS (default
+// 0.0015), GENERATION_COST_PER_1K_OUTPUT_TOKENS (default 0.002) and
+// GENERATION_COST_CURRENCY (default USD)
+func loadGenerationCosts() generationCosts {
+	costs := generationCosts{InputPerThousand: 0.0015, OutputPerThousand: 0.002, Currency: "USD"}
+	if v, err := strconv.ParseFloat(os.Getenv("GENERATION_COST_PER_1K_INPUT_TOKENS"), 64); err == nil && v >= 0 {
+		costs.InputPerThousand = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("GENERATION_COST_PER_1K_OUTPUT_TOKENS"), 64); err == nil && v >= 0 {
+		costs.OutputPerThousand = v
+	}
+	if v := strings.TrimSpace(os.Getenv("GENERATION_COST_CURRENCY")); v != "" {
+		costs.Currency = strings.ToUpper(v)
+	}
+	return costs
+}
+
+// estimate sizes the generation request for the prompt and techniques,
+// counting text with the generation model's tokenizer: the generator reads
+// the prompt, any conversation and the techniques' instructions, and writes
+// the prompt back with each technique's additions
+func (g generationCosts) estimate(t tokenizer.Tokenizer, req EnhanceRequest, techniques []string) *EnhancementEstimate {
+	promptTokens := t.Count(req.Text)
+	input := promptTokens + len(techniques)*techniqueInstructionTokens
+	for _, message := range req.Messages {
+		input += t.Count(message.Content)
+	}
+	output := promptTokens + len(techniques)*techniqueOutputTokens
+
+	cost := float64(input)/1000*g.InputPerThousand + float64(output)/1000*g.OutputPerThousand
+	return &EnhancementEstimate{
+		InputTokens:  input,
+		OutputTokens: output,
+		TotalTokens:  input + output,
+		Cost:         math.Round(cost*1e6) / 1e6,
+		Currency:     g.Currency,
+	}
+}
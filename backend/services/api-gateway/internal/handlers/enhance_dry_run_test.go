@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/betterprompts/api-gateway/internal/tokenizer"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnhanceDryRun(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	t.Setenv("GENERATION_COST_PER_1K_INPUT_TOKENS", "0.01")
+	t.Setenv("GENERATION_COST_PER_1K_OUTPUT_TOKENS", "0.03")
+
+	// Neither generation nor history may be touched; the mock panics on any call
+	db := new(MockDatabase)
+	generator := &recordingGenerator{}
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  generator,
+		History:    db,
+	})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+		c.Set("user_id", "user-1")
+	})
+	router.POST("/enhance", h.Enhance)
+
+	body := `{"text":"` + strings.Repeat("abcd", 100) + `","dry_run":true}`
+	req := httptest.NewRequest(http.MethodPost, "/enhance", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var response EnhanceResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, EnhanceStatusDryRun, response.Status)
+	assert.False(t, response.Enhanced)
+	assert.Empty(t, response.ID)
+	assert.Empty(t, response.EnhancedText)
+	assert.Equal(t, "reasoning", response.Intent)
+	assert.Equal(t, []string{"chain_of_thought"}, response.TechniquesUsed)
+	assert.NotNil(t, response.Explanation)
+	assert.Empty(t, generator.last.Text, "generation is skipped")
+
+	require.NotNil(t, response.Estimate)
+	assert.Equal(t, &EnhancementEstimate{
+		InputTokens:  140,
+		OutputTokens: 160,
+		TotalTokens:  300,
+		Cost:         0.0062,
+		Currency:     "USD",
+	}, response.Estimate)
+}
+
+func TestGenerationCostsEstimate(t *testing.T) {
+	costs := generationCosts{InputPerThousand: 1, OutputPerThousand: 2, Currency: "EUR"}
+	req := EnhanceRequest{
+		Text:     "fix this bug",
+		Messages: []services.ChatMessage{{Role: "user", Content: "it crashes on start"}, {Role: "user", Content: "fix this bug"}},
+	}
+
+	estimate := costs.estimate(tokenizer.Estimate{}, req, []string{"step_by_step", "chain_of_thought"})
+	assert.Equal(t, 3+5+3+80, estimate.InputTokens)
+	assert.Equal(t, 3+120, estimate.OutputTokens)
+	assert.Equal(t, 0.091+0.246, estimate.Cost)
+	assert.Equal(t, "EUR", estimate.Currency)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// generationTimeouts bound how long an enhance request waits for generation.
+// Past Soft the request returns a partial response and the enhancement
+// finishes in a job; Hard caps the whole pipeline.
+type generationTimeouts struct {
+	Soft time.Duration
+	Hard time.Duration
+}
+
+// loadGenerationTimeouts reads ENHANCE_SOFT_TIMEOUT (default 5s, 0 disables
+// partial responses) and ENHANCE_HARD_TIMEOUT (default 30s)
+func loadGenerationTimeouts() generationTimeouts {
+	timeouts := generationTimeouts{
+		Soft: 5 * time.Second,
+		Hard: 30 * time.Second,
+	}
+	if d, err := time.ParseDuration(os.Getenv("ENHANCE_SOFT_TIMEOUT")); err == nil && d >= 0 {
+		timeouts.Soft = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("ENHANCE_HARD_TIMEOUT")); err == nil && d > 0 {
+		timeouts.Hard = d
+	}
+	return timeouts
+}
+
+type enhanceResult struct {
+	response *EnhanceResponse
+	err      error
+}
+
+// runEnhancementWithSoftTimeout runs the pipeline detached from the request
+// so it can outlive it. If generation hasn't finished Soft after it started,
+// the classification and technique selection are returned as a pending
+// response with a job ID, and the job receives the result when it's ready.
+// Failures before generation are returned directly. deps.Jobs must be set.
+func runEnhancementWithSoftTimeout(ctx context.Context, deps *Dependencies, logger *logrus.Entry, req EnhanceRequest, opts enhanceOptions, timeouts generationTimeouts) (*EnhanceResponse, error) {
+	jobs := deps.Jobs
+	generating := make(chan *EnhanceResponse, 1)
+	opts.OnGenerating = func(partial *EnhanceResponse) {
+		generating <- partial
+	}
+
+	done := make(chan enhanceResult, 1)
+	go func() {
+		pipelineCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeouts.Hard)
+		defer cancel()
+		response, err := runEnhancement(pipelineCtx, deps, logger, req, opts)
+		done <- enhanceResult{response, err}
+	}()
+
+	var partial *EnhanceResponse
+	select {
+	case result := <-done:
+		return result.response, result.err
+	case partial = <-generating:
+	}
+
+	softTimeout := time.NewTimer(timeouts.Soft)
+	defer softTimeout.Stop()
+	select {
+	case result := <-done:
+		return result.response, result.err
+	case <-softTimeout.C:
+	}
+
+	var userID string
+	if opts.Request != nil {
+		userID = opts.Request.UserID
+	}
+	job, err := jobs.Create(ctx, userID)
+	if err != nil {
+		// Without a job to hand off to, wait for the result
+		logger.WithError(err).Warn("Failed to create enhancement job, waiting for generation")
+		result := <-done
+		return result.response, result.err
+	}
+
+	go func() {
+		result := <-done
+		storeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var err error
+		if result.err != nil {
+			err = jobs.Fail(storeCtx, job, result.err.Error())
+		} else {
+			err = jobs.Complete(storeCtx, job, result.response)
+		}
+		if err != nil {
+			logger.WithError(err).WithField("job_id", job.ID).Error("Failed to store enhancement job result")
+		}
+	}()
+
+	logger.WithFields(logrus.Fields{
+		"job_id":       job.ID,
+		"soft_timeout": timeouts.Soft.String(),
+	}).Info("Generation exceeded soft timeout, returning partial response")
+
+	partial.Status = services.EnhanceJobPending
+	partial.JobID = job.ID
+	return partial, nil
+}
+
+// GetJob returns the state of an enhancement that outlived its request.
+// Jobs are only visible to the user that started them; anonymous jobs are
+// reachable by their unguessable ID alone.
+func (h *EnhanceHandler) GetJob(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	jobs := h.deps.Jobs
+
+	if jobs == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrEnhanceJobNotFound.Error()})
+		return
+	}
+
+	job, err := jobs.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrEnhanceJobNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		logger.WithError(err).Error("Failed to get enhancement job")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get enhancement job"})
+		return
+	}
+
+	if job.UserID != "" {
+		if userID, _ := middleware.GetUserID(c); userID != job.UserID {
+			c.JSON(http.StatusNotFound, gin.H{"error": services.ErrEnhanceJobNotFound.Error()})
+			return
+		}
+	}
+
+	if job.Status == services.EnhanceJobPending {
+		c.Header("Retry-After", "2")
+	}
+	c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type stubClassifier struct{ err error }
+
+func (s stubClassifier) ClassifyIntent(ctx context.Context, text string) (*services.IntentClassificationResult, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &services.IntentClassificationResult{Intent: "reasoning", Complexity: "moderate", Confidence: 0.9}, nil
+}
+
+type stubSelector struct{}
+
+func (stubSelector) SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error) {
+	return []string{"chain_of_thought"}, nil
+}
+
+type stubGenerator struct{}
+
+func (stubGenerator) GeneratePrompt(ctx context.Context, req models.PromptGenerationRequest) (*models.PromptGenerationResponse, error) {
+	return &models.PromptGenerationResponse{Text: "enhanced " + req.Text, ModelVersion: "v1"}, nil
+}
+
+func TestRunEnhancementWithSoftTimeout(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	entry := logrus.NewEntry(logger)
+
+	// The store is never touched unless generation outlives the soft timeout
+	jobs := services.NewEnhanceJobStore(nil)
+	timeouts := generationTimeouts{Soft: time.Second, Hard: 5 * time.Second}
+	req := EnhanceRequest{Text: "why is the sky blue"}
+
+	t.Run("returns the full response when generation is fast", func(t *testing.T) {
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Return("history-1", nil)
+		deps := &Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   stubSelector{},
+			Generator:  stubGenerator{},
+			History:    db,
+			Jobs:       jobs,
+		}
+
+		response, err := runEnhancementWithSoftTimeout(context.Background(), deps, entry, req, enhanceOptions{}, timeouts)
+		require.NoError(t, err)
+		assert.Equal(t, "history-1", response.ID)
+		assert.Equal(t, "enhanced why is the sky blue", response.EnhancedText)
+		assert.Empty(t, response.JobID)
+		assert.Empty(t, response.Status)
+	})
+
+	t.Run("returns failures before generation directly", func(t *testing.T) {
+		deps := &Dependencies{
+			Classifier: stubClassifier{err: errors.New("classifier down")},
+			Selector:   stubSelector{},
+			Generator:  stubGenerator{},
+			Jobs:       jobs,
+		}
+
+		_, err := runEnhancementWithSoftTimeout(context.Background(), deps, entry, req, enhanceOptions{}, timeouts)
+		assert.EqualError(t, err, "Failed to analyze intent")
+	})
+}
+
+func TestLoadGenerationTimeouts(t *testing.T) {
+	t.Setenv("ENHANCE_SOFT_TIMEOUT", "")
+	t.Setenv("ENHANCE_HARD_TIMEOUT", "")
+	assert.Equal(t, generationTimeouts{Soft: 5 * time.Second, Hard: 30 * time.Second}, loadGenerationTimeouts())
+
+	t.Setenv("ENHANCE_SOFT_TIMEOUT", "0")
+	t.Setenv("ENHANCE_HARD_TIMEOUT", "1m")
+	assert.Equal(t, generationTimeouts{Soft: 0, Hard: time.Minute}, loadGenerationTimeouts())
+
+	t.Setenv("ENHANCE_HARD_TIMEOUT", "-1s")
+	assert.Equal(t, 30*time.Second, loadGenerationTimeouts().Hard)
+}
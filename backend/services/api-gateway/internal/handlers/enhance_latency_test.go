@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSelector is a technique selector that counts its calls
+type countingSelector struct{ calls *int }
+
+func (s countingSelector) SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error) {
+	*s.calls++
+	return []string{"chain_of_thought"}, nil
+}
+
+func TestRunEnhancementLatencyTargets(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	entry := logrus.NewEntry(logger)
+
+	presets := stubPresets{"reasoning": {ID: "preset-1", Intent: "reasoning", Techniques: []string{"tree_of_thoughts", "self_consistency", "few_shot"}}}
+	run := func(t *testing.T, latency *services.LatencyTargets) (*EnhanceResponse, models.PromptHistory, int) {
+		var saved models.PromptHistory
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			saved = args.Get(1).(models.PromptHistory)
+		}).Return("history-1", nil)
+
+		var selections int
+		response, err := runEnhancement(context.Background(), &Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   countingSelector{calls: &selections},
+			Generator:  stubGenerator{},
+			History:    db,
+			Presets:    presets,
+			Latency:    latency,
+		}, entry, EnhanceRequest{Text: "why"}, enhanceOptions{})
+		require.NoError(t, err)
+		return response, saved, selections
+	}
+
+	t.Run("slow stages are degraded for a tight target", func(t *testing.T) {
+		latency := services.NewLatencyTargets(services.LatencyTargetConfig{
+			Intents:       map[string]time.Duration{"reasoning": 200 * time.Millisecond},
+			MaxTechniques: 2,
+			MaxTokens:     100,
+		})
+		latency.ObserveSelector(time.Second)
+		latency.ObserveGeneration(time.Second, 1)
+
+		response, saved, selections := run(t, latency)
+		assert.Zero(t, selections)
+		assert.Equal(t, []string{"tree_of_thoughts", "self_consistency"}, response.TechniquesUsed)
+		assert.Equal(t, map[string]interface{}{"id": "preset-1", "reason": services.PresetReasonLatencyTarget}, response.Metadata["technique_preset"])
+
+		report := &services.LatencyReport{TargetMs: 200, Degradations: []string{
+			services.DegradationSkipSelector, services.DegradationFewerTechniques, services.DegradationSmallerGeneration,
+		}}
+		assert.Equal(t, report, response.Metadata["latency"])
+		assert.Equal(t, report, saved.Metadata["latency"])
+	})
+
+	t.Run("loose target", func(t *testing.T) {
+		latency := services.NewLatencyTargets(services.LatencyTargetConfig{Default: time.Minute, MaxTechniques: 2})
+		latency.ObserveSelector(time.Second)
+		latency.ObserveGeneration(time.Second, 1)
+
+		response, _, selections := run(t, latency)
+		assert.Equal(t, 1, selections)
+		assert.Equal(t, []string{"chain_of_thought"}, response.TechniquesUsed)
+		assert.NotContains(t, response.Metadata, "latency")
+	})
+
+	t.Run("no targets", func(t *testing.T) {
+		response, saved, selections := run(t, nil)
+		assert.Equal(t, 1, selections)
+		assert.NotContains(t, response.Metadata, "latency")
+		assert.NotContains(t, saved.Metadata, "latency")
+	})
+}
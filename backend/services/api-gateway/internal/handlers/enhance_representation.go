@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Media types of the /enhance response representations. Callers that don't
+// ask for one by Accept header get version 1, the original flat shape the
+// frontend reads; version 2 groups the response into the input, the plan
+// and the output, and always carries the output's sections.
+const (
+	MediaTypeEnhanceV1 = "application/vnd.betterprompts.v1+json"
+	MediaTypeEnhanceV2 = "application/vnd.betterprompts.v2+json"
+
+	// vendorMediaTypePrefix starts every versioned media type
+	vendorMediaTypePrefix = "application/vnd.betterprompts."
+)
+
+// EnhanceStatusCompleted is the status of a finished enhancement in
+// version 2, where every response has one; version 1 leaves it empty
+const EnhanceStatusCompleted = "completed"
+
+// enhanceRepresentation is one shape of the /enhance response
+type enhanceRepresentation struct {
+	mediaType string // Content-Type of the response; empty for plain JSON
+	version   int
+}
+
+// enhanceRepresentations are the media types an Accept header can name,
+// the generic JSON types meaning version 1
+var enhanceRepresentations = map[string]enhanceRepresentation{
+	MediaTypeEnhanceV1: {mediaType: MediaTypeEnhanceV1, version: 1},
+	MediaTypeEnhanceV2: {mediaType: MediaTypeEnhanceV2, version: 2},
+	"application/json": {version: 1},
+	"application/*":    {version: 1},
+	"*/*":              {version: 1},
+}
+
+// negotiateEnhanceRepresentation picks the representation the Accept
+// header prefers. Headers naming nothing /enhance produces get version 1,
+// unless they name only versioned media types: a client asking for a
+// version that doesn't exist can't read version 1 either.
+func negotiateEnhanceRepresentation(accept string) (enhanceRepresentation, bool) {
+	best, bestQuality := enhanceRepresentation{version: 1}, 0.0
+	found, versioned := false, false
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+		if mediaType == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				parsed, err := strconv.ParseFloat(q, 64)
+				if err != nil {
+					parsed = 0
+				}
+				quality = parsed
+			}
+		}
+		representation, ok := enhanceRepresentations[mediaType]
+		if !ok {
+			versioned = versioned || strings.HasPrefix(mediaType, vendorMediaTypePrefix)
+			continue
+		}
+		if quality > bestQuality {
+			best, bestQuality, found = representation, quality, true
+		}
+	}
+	if !found && versioned {
+		return enhanceRepresentation{}, false
+	}
+	return best, true
+}
+
+// respondEnhance writes an /enhance response in the negotiated
+// representation. Every /enhance outcome goes through here, so both
+// versions render from the same EnhanceResponse.
+func respondEnhance(c *gin.Context, representation enhanceRepresentation, status int, response *EnhanceResponse) {
+	c.Header("Vary", "Accept")
+	if representation.mediaType != "" {
+		// gin keeps a Content-Type that is already set
+		c.Header("Content-Type", representation.mediaType)
+	}
+	if representation.version == 2 {
+		c.JSON(status, renderEnhanceV2(response))
+		return
+	}
+	c.JSON(status, response)
+}
+
+// respondNotAcceptable lists the media types /enhance can answer with
+func respondNotAcceptable(c *gin.Context) {
+	c.Header("Vary", "Accept")
+	c.JSON(http.StatusNotAcceptable, gin.H{
+		"error":     "Unsupported response version",
+		"supported": []string{MediaTypeEnhanceV2, MediaTypeEnhanceV1, "application/json"},
+	})
+}
+
+// EnhanceResponseV2 is version 2 of the /enhance response
+type EnhanceResponseV2 struct {
+	ID             string                 `json:"id,omitempty"`
+	Status         string                 `json:"status"` // "completed", "pending" or "dry_run"
+	Input          EnhanceInputV2         `json:"input"`
+	Plan           EnhancePlanV2          `json:"plan"`
+	Output         *EnhanceOutputV2       `json:"output,omitempty"` // Absent until generation finishes
+	Job            *EnhanceJobV2          `json:"job,omitempty"`    // Set while pending
+	ProcessingTime float64                `json:"processing_time_ms"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// EnhanceInputV2 is the prompt and how it was classified
+type EnhanceInputV2 struct {
+	Text       string  `json:"text"`
+	Intent     string  `json:"intent"`
+	Complexity string  `json:"complexity"`
+	Confidence float64 `json:"confidence"`
+}
+
+// EnhancePlanV2 is the techniques chosen for the prompt, why, and on dry
+// runs what generating with them would cost
+type EnhancePlanV2 struct {
+	Techniques  []string                `json:"techniques"`
+	Explanation *EnhancementExplanation `json:"explanation,omitempty"`
+	Estimate    *EnhancementEstimate    `json:"estimate,omitempty"`
+}
+
+// EnhanceOutputV2 is the enhanced prompt, whole and in sections
+type EnhanceOutputV2 struct {
+	Text     string                   `json:"text"`
+	Sections *services.PromptSections `json:"sections,omitempty"`
+}
+
+// EnhanceJobV2 is where a pending enhancement's result will be
+type EnhanceJobV2 struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+func renderEnhanceV2(response *EnhanceResponse) *EnhanceResponseV2 {
+	v2 := &EnhanceResponseV2{
+		ID:     response.ID,
+		Status: response.Status,
+		Input: EnhanceInputV2{
+			Text:       response.OriginalText,
+			Intent:     response.Intent,
+			Complexity: response.Complexity,
+			Confidence: response.Confidence,
+		},
+		Plan: EnhancePlanV2{
+			Techniques:  response.TechniquesUsed,
+			Explanation: response.Explanation,
+			Estimate:    response.Estimate,
+		},
+		ProcessingTime: response.ProcessingTime,
+		Metadata:       response.Metadata,
+	}
+	if v2.Plan.Techniques == nil {
+		v2.Plan.Techniques = []string{}
+	}
+	switch {
+	case response.JobID != "":
+		v2.Job = &EnhanceJobV2{ID: response.JobID, URL: enhanceJobURL(response.JobID)}
+	case response.Status == EnhanceStatusDryRun:
+	default:
+		v2.Status = EnhanceStatusCompleted
+		v2.Output = &EnhanceOutputV2{Text: response.EnhancedText, Sections: response.Sections}
+	}
+	return v2
+}
+
+// enhanceJobURL is where a pending enhancement is polled
+func enhanceJobURL(id string) string {
+	return "/api/v1/enhance/jobs/" + id
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEnhanceRepresentation(t *testing.T) {
+	cases := []struct {
+		accept    string
+		mediaType string
+		version   int
+		ok        bool
+	}{
+		{"", "", 1, true},
+		{"application/json, text/plain, */*", "", 1, true},
+		{"text/html", "", 1, true},
+		{MediaTypeEnhanceV2, MediaTypeEnhanceV2, 2, true},
+		{"Application/VND.BetterPrompts.v2+JSON", MediaTypeEnhanceV2, 2, true},
+		{MediaTypeEnhanceV1, MediaTypeEnhanceV1, 1, true},
+		{"application/json;q=0.5, " + MediaTypeEnhanceV2, MediaTypeEnhanceV2, 2, true},
+		{MediaTypeEnhanceV2 + ";q=0.4, application/json", "", 1, true},
+		{MediaTypeEnhanceV2 + ";q=0, application/json;q=0.1", "", 1, true},
+		{"application/vnd.betterprompts.v9+json", "", 0, false},
+		{"application/vnd.betterprompts.v9+json, */*;q=0.1", "", 1, true},
+	}
+	for _, tc := range cases {
+		representation, ok := negotiateEnhanceRepresentation(tc.accept)
+		assert.Equal(t, tc.ok, ok, tc.accept)
+		assert.Equal(t, tc.mediaType, representation.mediaType, tc.accept)
+		assert.Equal(t, tc.version, representation.version, tc.accept)
+	}
+}
+
+func TestEnhanceRepresentations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	db := new(MockDatabase)
+	db.On("SavePromptHistory", mock.Anything, mock.Anything).Return("history-1", nil)
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  stubGenerator{},
+		History:    db,
+	})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/enhance", h.Enhance)
+
+	enhance := func(accept, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/enhance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("version 1 by default", func(t *testing.T) {
+		rec := enhance("application/json, text/plain, */*", `{"text":"why is the sky blue"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/json; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "history-1", body["id"])
+		assert.Equal(t, "enhanced why is the sky blue", body["enhanced_text"])
+		assert.Equal(t, "enhanced why is the sky blue", body["enhanced_prompt"])
+		assert.Equal(t, []interface{}{"chain_of_thought"}, body["techniques_used"])
+		assert.NotContains(t, body, "sections", "sections stay opt-in")
+		assert.NotContains(t, body, "status")
+		assert.NotContains(t, body, "output")
+	})
+
+	t.Run("version 1 by media type", func(t *testing.T) {
+		rec := enhance(MediaTypeEnhanceV1, `{"text":"why is the sky blue"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, MediaTypeEnhanceV1, rec.Header().Get("Content-Type"))
+
+		var body EnhanceResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "enhanced why is the sky blue", body.EnhancedText)
+	})
+
+	t.Run("version 2", func(t *testing.T) {
+		rec := enhance(MediaTypeEnhanceV2, `{"text":"why is the sky blue"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, MediaTypeEnhanceV2, rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Accept", rec.Header().Get("Vary"))
+
+		var body EnhanceResponseV2
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, "history-1", body.ID)
+		assert.Equal(t, EnhanceStatusCompleted, body.Status)
+		assert.Equal(t, EnhanceInputV2{Text: "why is the sky blue", Intent: "reasoning", Complexity: "moderate", Confidence: 0.9}, body.Input)
+		assert.Equal(t, []string{"chain_of_thought"}, body.Plan.Techniques)
+		assert.Nil(t, body.Plan.Estimate)
+		require.NotNil(t, body.Output)
+		assert.Equal(t, "enhanced why is the sky blue", body.Output.Text)
+		require.NotNil(t, body.Output.Sections, "version 2 always structures the output")
+		assert.Nil(t, body.Job)
+		assert.Equal(t, "v1", body.Metadata["model_version"])
+
+		var raw map[string]interface{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &raw))
+		assert.NotContains(t, raw, "enhanced_text")
+	})
+
+	t.Run("version 2 dry run", func(t *testing.T) {
+		rec := enhance(MediaTypeEnhanceV2, `{"text":"why is the sky blue","dry_run":true}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		var body EnhanceResponseV2
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, EnhanceStatusDryRun, body.Status)
+		assert.Nil(t, body.Output)
+		require.NotNil(t, body.Plan.Estimate)
+		assert.Positive(t, body.Plan.Estimate.TotalTokens)
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		rec := enhance("application/vnd.betterprompts.v9+json", `{"text":"why is the sky blue"}`)
+		assert.Equal(t, http.StatusNotAcceptable, rec.Code)
+		assert.Contains(t, rec.Body.String(), MediaTypeEnhanceV2)
+	})
+}
+
+func TestRenderEnhanceV2Pending(t *testing.T) {
+	body := renderEnhanceV2(&EnhanceResponse{
+		OriginalText:   "why",
+		Intent:         "reasoning",
+		TechniquesUsed: []string{"chain_of_thought"},
+		Status:         "pending",
+		JobID:          "job-1",
+	})
+	assert.Equal(t, "pending", body.Status)
+	assert.Nil(t, body.Output)
+	assert.Equal(t, &EnhanceJobV2{ID: "job-1", URL: "/api/v1/enhance/jobs/job-1"}, body.Job)
+
+	body = renderEnhanceV2(&EnhanceResponse{OriginalText: "why"})
+	assert.Equal(t, []string{}, body.Plan.Techniques)
+	assert.Equal(t, EnhanceStatusCompleted, body.Status)
+}
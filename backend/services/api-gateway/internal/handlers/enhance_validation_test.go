@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnhanceRejectsInvalidRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// Nothing past validation may run; the mock panics on any call
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  stubGenerator{},
+		History:    new(MockDatabase),
+	})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/enhance", h.Enhance)
+
+	manyMessages := strings.Repeat(`{"role":"user","content":"hi"},`, 51)
+	cases := map[string]string{
+		"missing text":           `{}`,
+		"blank text":             `{"text":"   "}`,
+		"empty messages":         `{"messages":[]}`,
+		"no user message":        `{"messages":[{"role":"system","content":"be brief"}]}`,
+		"oversized message":      `{"messages":[{"role":"user","content":"` + strings.Repeat("a", 5001) + `"}]}`,
+		"too many messages":      `{"messages":[` + strings.TrimSuffix(manyMessages, ",") + `]}`,
+		"unknown message role":   `{"messages":[{"role":"tool","content":"hi"}]}`,
+		"oversized text":         `{"text":"` + strings.Repeat("a", 5001) + `"}`,
+		"empty message contents": `{"messages":[{"role":"user","content":""}]}`,
+	}
+	for name, body := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/enhance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, name)
+	}
+}
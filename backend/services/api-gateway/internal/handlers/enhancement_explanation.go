@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+)
+
+// Where an enhancement's techniques came from
+const (
+	explanationSourceSelector = "selector"
+	explanationSourcePreset   = "preset"
+	explanationSourceDefault  = "default" // Selector unavailable or chose nothing
+)
+
+// EnhancementExplanation tells the user why their prompt was enhanced with
+// the techniques it was, in their language and explanation style
+type EnhancementExplanation struct {
+	Summary    string                 `json:"summary"`
+	Source     string                 `json:"source"`
+	Techniques []TechniqueExplanation `json:"techniques"`
+	Locale     string                 `json:"locale"`
+	Style      string                 `json:"style"`
+}
+
+// TechniqueExplanation is a technique's one-liner from the catalog and what
+// about the prompt made the selector choose it
+type TechniqueExplanation struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Reasons     []string `json:"reasons,omitempty"`
+}
+
+// enhanceExplanations controls which callers get explanations with their
+// enhancements
+type enhanceExplanations struct {
+	Enabled bool
+	APIKeys bool // Callers authenticated with an API key, who mostly want the prompt alone
+}
+
+// loadEnhanceExplanations reads ENHANCE_EXPLANATIONS and
+// ENHANCE_EXPLANATIONS_API_KEYS, both on by default
+func loadEnhanceExplanations() enhanceExplanations {
+	explanations := enhanceExplanations{Enabled: true, APIKeys: true}
+	if v, err := strconv.ParseBool(os.Getenv("ENHANCE_EXPLANATIONS")); err == nil {
+		explanations.Enabled = v
+	}
+	if v, err := strconv.ParseBool(os.Getenv("ENHANCE_EXPLANATIONS_API_KEYS")); err == nil {
+		explanations.APIKeys = v
+	}
+	return explanations
+}
+
+// enabledFor reports whether the caller gets an explanation
+func (e enhanceExplanations) enabledFor(rc *services.RequestContext) bool {
+	if !e.Enabled {
+		return false
+	}
+	return e.APIKeys || rc == nil || rc.Client.APIKeyID == ""
+}
+
+// selectorReasons maps the clauses of the selector's per-technique reasoning
+// to explanation phrases. Clauses about scoring internals (thresholds,
+// priority boosts) map to the phrase of the condition behind them or are
+// dropped; unknown clauses are dropped too, as they can't be translated.
+var selectorReasons = []struct {
+	prefix string
+	phrase string
+}{
+	{"matches intent", "reason.intent"},
+	{"matches complexity level", "reason.complexity"},
+	{"complexity ", "reason.complexity"},
+	{"contains multi-step indicators", "reason.multi_step"},
+	{"requires exploration", "reason.exploration"},
+	{"requires pattern matching", "reason.pattern"},
+	{"requires accuracy", "reason.accuracy"},
+	{"simple request", "reason.simple"},
+	{"intent priority boost", ""},
+	{"personalized weight", "reason.personalized"},
+}
+
+// explanationPhrases holds the plain-language explanation text keyed by
+// locale then phrase. Locales match techniqueDescriptions, so descriptions
+// and phrases agree.
+var explanationPhrases = map[string]map[string]string{
+	"en": {
+		"summary.selector":    "These techniques were the best match for what your prompt asks for.",
+		"summary.preset":      "A technique preset curated for this kind of prompt was applied.",
+		"summary.default":     "The standard techniques for this kind of prompt were applied.",
+		"reason.intent":       "Suited to the kind of request you made",
+		"reason.complexity":   "Right for how involved your prompt is",
+		"reason.keywords":     "Your prompt mentions things it handles well",
+		"reason.multi_step":   "Your prompt has several steps",
+		"reason.exploration":  "Your prompt benefits from weighing alternatives",
+		"reason.pattern":      "Your prompt follows a pattern that examples make clearer",
+		"reason.accuracy":     "Your prompt calls for accurate answers",
+		"reason.simple":       "Your prompt is a simple, direct request",
+		"reason.personalized": "It has worked well for you before",
+	},
+	"es": {
+		"summary.selector":    "Estas técnicas son las que mejor se ajustan a lo que pide tu prompt.",
+		"summary.preset":      "Se aplicó un conjunto de técnicas predefinido para este tipo de prompt.",
+		"summary.default":     "Se aplicaron las técnicas habituales para este tipo de prompt.",
+		"reason.intent":       "Adecuada para el tipo de petición que hiciste",
+		"reason.complexity":   "Acorde con la complejidad de tu prompt",
+		"reason.keywords":     "Tu prompt menciona aspectos que esta técnica trata bien",
+		"reason.multi_step":   "Tu prompt tiene varios pasos",
+		"reason.exploration":  "A tu prompt le conviene sopesar alternativas",
+		"reason.pattern":      "Tu prompt sigue un patrón que los ejemplos aclaran",
+		"reason.accuracy":     "Tu prompt requiere respuestas precisas",
+		"reason.simple":       "Tu prompt es una petición sencilla y directa",
+		"reason.personalized": "Te ha dado buenos resultados antes",
+	},
+	"fr": {
+		"summary.selector":    "Ces techniques correspondent le mieux à ce que demande votre prompt.",
+		"summary.preset":      "Un ensemble de techniques prédéfini pour ce type de prompt a été appliqué.",
+		"summary.default":     "Les techniques habituelles pour ce type de prompt ont été appliquées.",
+		"reason.intent":       "Adaptée au type de demande que vous avez faite",
+		"reason.complexity":   "Adaptée à la complexité de votre prompt",
+		"reason.keywords":     "Votre prompt évoque des points que cette technique traite bien",
+		"reason.multi_step":   "Votre prompt comporte plusieurs étapes",
+		"reason.exploration":  "Votre prompt gagne à comparer plusieurs pistes",
+		"reason.pattern":      "Votre prompt suit un modèle que des exemples rendent plus clair",
+		"reason.accuracy":     "Votre prompt exige des réponses exactes",
+		"reason.simple":       "Votre prompt est une demande simple et directe",
+		"reason.personalized": "Elle vous a déjà donné de bons résultats",
+	},
+	"de": {
+		"summary.selector":    "Diese Techniken passen am besten zu dem, was Ihr Prompt verlangt.",
+		"summary.preset":      "Ein vordefiniertes Technik-Set für diese Art von Prompt wurde angewendet.",
+		"summary.default":     "Die üblichen Techniken für diese Art von Prompt wurden angewendet.",
+		"reason.intent":       "Passend zur Art Ihrer Anfrage",
+		"reason.complexity":   "Passend zur Komplexität Ihres Prompts",
+		"reason.keywords":     "Ihr Prompt erwähnt Dinge, die diese Technik gut abdeckt",
+		"reason.multi_step":   "Ihr Prompt umfasst mehrere Schritte",
+		"reason.exploration":  "Ihr Prompt profitiert davon, Alternativen abzuwägen",
+		"reason.pattern":      "Ihr Prompt folgt einem Muster, das Beispiele verdeutlichen",
+		"reason.accuracy":     "Ihr Prompt erfordert genaue Antworten",
+		"reason.simple":       "Ihr Prompt ist eine einfache, direkte Anfrage",
+		"reason.personalized": "Sie hat bei Ihnen schon gut funktioniert",
+	},
+}
+
+// technicalExplanationPhrases holds the technical style's summaries; its
+// reasons are the selector's own
+var technicalExplanationPhrases = map[string]map[string]string{
+	"en": {
+		"summary.selector": "Techniques ranked highest by the technique selector for the classified intent and complexity.",
+		"summary.preset":   "Techniques taken from the technique preset matched to this prompt.",
+		"summary.default":  "Default techniques for the classified intent; the technique selector was unavailable or selected none.",
+	},
+	"es": {
+		"summary.selector": "Técnicas mejor puntuadas por el selector de técnicas según la intención y la complejidad clasificadas.",
+		"summary.preset":   "Técnicas tomadas del preset de técnicas asociado a este prompt.",
+		"summary.default":  "Técnicas predeterminadas para la intención clasificada; el selector de técnicas no estaba disponible o no seleccionó ninguna.",
+	},
+	"fr": {
+		"summary.selector": "Techniques les mieux classées par le sélecteur de techniques pour l'intention et la complexité détectées.",
+		"summary.preset":   "Techniques issues du preset de techniques associé à ce prompt.",
+		"summary.default":  "Techniques par défaut pour l'intention détectée ; le sélecteur de techniques était indisponible ou n'en a retenu aucune.",
+	},
+	"de": {
+		"summary.selector": "Vom Technik-Selektor für die erkannte Absicht und Komplexität am höchsten bewertete Techniken.",
+		"summary.preset":   "Techniken aus dem Technik-Preset, das diesem Prompt zugeordnet ist.",
+		"summary.default":  "Standardtechniken für die erkannte Absicht; der Technik-Selektor war nicht verfügbar oder hat keine ausgewählt.",
+	},
+}
+
+// plainTechniqueDescriptions describes the built-in techniques by what they
+// have the AI do, for the plain-language style
+var plainTechniqueDescriptions = map[string]map[string]string{
+	"en": {
+		"chain_of_thought":     "Has the AI work through your request one step at a time",
+		"tree_of_thoughts":     "Has the AI try a few different ways of answering and pick the best one",
+		"few_shot":             "Shows the AI examples of the kind of answer you want",
+		"zero_shot":            "Asks the AI to answer directly, without examples",
+		"self_consistency":     "Has the AI answer more than once and check the answers agree",
+		"constitutional_ai":    "Has the AI check its answer is fair, safe and respectful",
+		"iterative_refinement": "Has the AI improve its answer over a few rounds",
+	},
+	"es": {
+		"chain_of_thought":     "Hace que la IA resuelva tu petición paso a paso",
+		"tree_of_thoughts":     "Hace que la IA pruebe varias formas de responder y elija la mejor",
+		"few_shot":             "Muestra a la IA ejemplos del tipo de respuesta que quieres",
+		"zero_shot":            "Pide a la IA que responda directamente, sin ejemplos",
+		"self_consistency":     "Hace que la IA responda más de una vez y compruebe que las respuestas coinciden",
+		"constitutional_ai":    "Hace que la IA compruebe que su respuesta es justa, segura y respetuosa",
+		"iterative_refinement": "Hace que la IA mejore su respuesta en varias rondas",
+	},
+	"fr": {
+		"chain_of_thought":     "Demande à l'IA de traiter votre demande étape par étape",
+		"tree_of_thoughts":     "Demande à l'IA d'essayer plusieurs façons de répondre et de garder la meilleure",
+		"few_shot":             "Montre à l'IA des exemples du type de réponse que vous voulez",
+		"zero_shot":            "Demande à l'IA de répondre directement, sans exemples",
+		"self_consistency":     "Demande à l'IA de répondre plusieurs fois et de vérifier que les réponses concordent",
+		"constitutional_ai":    "Demande à l'IA de vérifier que sa réponse est juste, sûre et respectueuse",
+		"iterative_refinement": "Demande à l'IA d'améliorer sa réponse en plusieurs tours",
+	},
+	"de": {
+		"chain_of_thought":     "Lässt die KI Ihre Anfrage Schritt für Schritt bearbeiten",
+		"tree_of_thoughts":     "Lässt die KI mehrere Antwortwege ausprobieren und den besten wählen",
+		"few_shot":             "Zeigt der KI Beispiele für die gewünschte Art von Antwort",
+		"zero_shot":            "Bittet die KI, direkt und ohne Beispiele zu antworten",
+		"self_consistency":     "Lässt die KI mehrmals antworten und prüfen, ob die Antworten übereinstimmen",
+		"constitutional_ai":    "Lässt die KI prüfen, ob ihre Antwort fair, sicher und respektvoll ist",
+		"iterative_refinement": "Lässt die KI ihre Antwort in mehreren Runden verbessern",
+	},
+}
+
+// explanationStyle is the wording of one explanation style: its message
+// catalogs, and how it turns the selector's reasoning into reasons
+type explanationStyle struct {
+	phrases      map[string]map[string]string // Summaries and reasons by locale
+	descriptions map[string]map[string]string // Technique descriptions by locale, over the catalog's
+	reasons      func(reasoning string, phrases map[string]string) []string
+}
+
+// explanationStyles are the styles by services.ExplanationStyle* name
+var explanationStyles = map[string]explanationStyle{
+	services.ExplanationStylePlain: {
+		phrases:      explanationPhrases,
+		descriptions: plainTechniqueDescriptions,
+		reasons:      explainSelectorReasoning,
+	},
+	services.ExplanationStyleTechnical: {
+		phrases:      technicalExplanationPhrases,
+		descriptions: techniqueDescriptions,
+		reasons:      technicalSelectorReasoning,
+	},
+}
+
+// explainEnhancement composes the explanation of an enhancement. selection
+// is the selector's response when it chose the techniques, nil otherwise.
+// Unknown styles get plain language.
+func explainEnhancement(techniques []string, selection *services.TechniqueSelectionResponse, source, locale, style string) *EnhancementExplanation {
+	locale = negotiateTechniqueLocale(locale, "")
+	if !services.ValidExplanationStyle(style) {
+		style = services.ExplanationStylePlain
+	}
+	wording := explanationStyles[style]
+	phrases := wording.phrases[locale]
+
+	builtin := make(map[string]Technique, len(builtinTechniques))
+	for _, t := range builtinTechniques {
+		builtin[t.ID] = t
+	}
+	selected := make(map[string]services.SelectedTechnique)
+	if selection != nil {
+		for _, t := range selection.Techniques {
+			selected[t.ID] = t
+		}
+	}
+
+	explanation := &EnhancementExplanation{
+		Summary:    phrases["summary."+source],
+		Source:     source,
+		Techniques: make([]TechniqueExplanation, 0, len(techniques)),
+		Locale:     locale,
+		Style:      style,
+	}
+	for _, id := range techniques {
+		t := TechniqueExplanation{ID: id, Name: builtin[id].Name, Description: builtin[id].Description}
+		if s, ok := selected[id]; ok {
+			if t.Name == "" {
+				t.Name = s.Name
+			}
+			if t.Description == "" {
+				t.Description = s.Description
+			}
+			t.Reasons = wording.reasons(s.Reasoning, phrases)
+		}
+		if description, ok := wording.descriptions[locale][id]; ok {
+			t.Description = description
+		}
+		if t.Name == "" {
+			t.Name = techniqueDisplayName(id)
+		}
+		explanation.Techniques = append(explanation.Techniques, t)
+	}
+	return explanation
+}
+
+// explainSelectorReasoning turns the selector's comma-separated reasoning for
+// a technique into user-facing phrases, without repeats
+func explainSelectorReasoning(reasoning string, phrases map[string]string) []string {
+	var reasons []string
+	seen := make(map[string]bool)
+	for _, clause := range strings.Split(reasoning, ",") {
+		clause = strings.TrimSpace(clause)
+
+		var key string
+		if strings.HasSuffix(clause, " keyword matches") {
+			key = "reason.keywords"
+		}
+		for _, r := range selectorReasons {
+			if key == "" && strings.HasPrefix(clause, r.prefix) {
+				key = r.phrase
+				break
+			}
+		}
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		reasons = append(reasons, phrases[key])
+	}
+	return reasons
+}
+
+// technicalSelectorReasoning keeps the selector's reasoning clauses as they
+// are, without repeats
+func technicalSelectorReasoning(reasoning string, _ map[string]string) []string {
+	var reasons []string
+	for _, clause := range strings.Split(reasoning, ",") {
+		clause = strings.TrimSpace(clause)
+		if clause != "" && !slices.Contains(reasons, clause) {
+			reasons = append(reasons, clause)
+		}
+	}
+	return reasons
+}
+
+// techniqueDisplayName names a technique the catalog doesn't know from its ID
+func techniqueDisplayName(id string) string {
+	words := strings.Fields(strings.ReplaceAll(id, "_", " "))
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
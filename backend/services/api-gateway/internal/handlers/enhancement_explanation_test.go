@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// reasoningSelector answers like the selector service, reasoning included
+type reasoningSelector struct {
+	stubSelector
+	err error
+}
+
+func (s reasoningSelector) SelectTechniquesWithReasoning(ctx context.Context, req models.TechniqueSelectionRequest) (*services.TechniqueSelectionResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &services.TechniqueSelectionResponse{
+		Techniques: []services.SelectedTechnique{
+			{
+				ID:        "chain_of_thought",
+				Name:      "Chain of Thought",
+				Reasoning: "matches intent 'reasoning', matches complexity level 'moderate', complexity 0.62 >= 0.50, intent priority boost +2",
+			},
+			{
+				ID:          "step_back",
+				Name:        "Step-Back Prompting",
+				Description: "Asks for the general principle before the specifics",
+				Reasoning:   "3 keyword matches, personalized weight x1.20, needs a new clause",
+			},
+		},
+		PrimaryTechnique: "chain_of_thought",
+		Reasoning:        "Based on intent 'reasoning' and complexity 'moderate'. selected 2 techniques",
+	}, nil
+}
+
+func TestExplainEnhancement(t *testing.T) {
+	selection, _ := reasoningSelector{}.SelectTechniquesWithReasoning(context.Background(), models.TechniqueSelectionRequest{})
+
+	t.Run("selector reasoning becomes user-facing reasons", func(t *testing.T) {
+		explanation := explainEnhancement([]string{"chain_of_thought", "step_back"}, selection, explanationSourceSelector, "en-GB", "")
+
+		assert.Equal(t, "en", explanation.Locale)
+		assert.Equal(t, services.ExplanationStylePlain, explanation.Style)
+		assert.Equal(t, explanationPhrases["en"]["summary.selector"], explanation.Summary)
+		require.Len(t, explanation.Techniques, 2)
+
+		cot := explanation.Techniques[0]
+		assert.Equal(t, "Chain of Thought", cot.Name)
+		assert.Equal(t, "Has the AI work through your request one step at a time", cot.Description)
+		assert.Equal(t, []string{
+			"Suited to the kind of request you made",
+			"Right for how involved your prompt is",
+		}, cot.Reasons)
+
+		// Techniques outside the gateway catalog use the selector's details
+		stepBack := explanation.Techniques[1]
+		assert.Equal(t, "Step-Back Prompting", stepBack.Name)
+		assert.Equal(t, "Asks for the general principle before the specifics", stepBack.Description)
+		assert.Equal(t, []string{
+			"Your prompt mentions things it handles well",
+			"It has worked well for you before",
+		}, stepBack.Reasons)
+	})
+
+	t.Run("localized to the caller's language", func(t *testing.T) {
+		explanation := explainEnhancement([]string{"chain_of_thought"}, selection, explanationSourceSelector, "de", services.ExplanationStylePlain)
+
+		assert.Equal(t, "de", explanation.Locale)
+		assert.Equal(t, "Diese Techniken passen am besten zu dem, was Ihr Prompt verlangt.", explanation.Summary)
+		assert.Equal(t, plainTechniqueDescriptions["de"]["chain_of_thought"], explanation.Techniques[0].Description)
+		assert.Equal(t, []string{"Passend zur Art Ihrer Anfrage", "Passend zur Komplexität Ihres Prompts"}, explanation.Techniques[0].Reasons)
+	})
+
+	t.Run("techniques the selector didn't choose have no reasons", func(t *testing.T) {
+		explanation := explainEnhancement([]string{"role_play"}, nil, explanationSourceDefault, "fr", "")
+
+		assert.Equal(t, explanationPhrases["fr"]["summary.default"], explanation.Summary)
+		assert.Equal(t, []TechniqueExplanation{{ID: "role_play", Name: "Role Play"}}, explanation.Techniques)
+	})
+
+	t.Run("technical style keeps the selector's reasoning", func(t *testing.T) {
+		explanation := explainEnhancement([]string{"chain_of_thought", "step_back"}, selection, explanationSourceSelector, "en", services.ExplanationStyleTechnical)
+
+		assert.Equal(t, services.ExplanationStyleTechnical, explanation.Style)
+		assert.Equal(t, technicalExplanationPhrases["en"]["summary.selector"], explanation.Summary)
+		assert.Equal(t, builtinTechniques[0].Description, explanation.Techniques[0].Description)
+		assert.Equal(t, []string{
+			"matches intent 'reasoning'",
+			"matches complexity level 'moderate'",
+			"complexity 0.62 >= 0.50",
+			"intent priority boost +2",
+		}, explanation.Techniques[0].Reasons)
+
+		explanation = explainEnhancement([]string{"chain_of_thought"}, selection, explanationSourceSelector, "de", services.ExplanationStyleTechnical)
+		assert.Equal(t, techniqueDescriptions["de"]["chain_of_thought"], explanation.Techniques[0].Description)
+	})
+
+	t.Run("plain language has no scoring vocabulary", func(t *testing.T) {
+		for _, locale := range []string{"en", "es", "fr", "de"} {
+			explanation := explainEnhancement([]string{"chain_of_thought", "step_back"}, selection, explanationSourceSelector, locale, "verbose")
+			assert.Equal(t, services.ExplanationStylePlain, explanation.Style)
+			for _, technique := range explanation.Techniques {
+				for _, reason := range technique.Reasons {
+					assert.NotRegexp(t, `[0-9]|threshold|boost|weight|>=`, reason, locale)
+				}
+			}
+		}
+	})
+
+	t.Run("every style covers every locale", func(t *testing.T) {
+		for name, style := range explanationStyles {
+			for locale := range explanationPhrases {
+				for _, source := range []string{explanationSourceSelector, explanationSourcePreset, explanationSourceDefault} {
+					assert.NotEmpty(t, style.phrases[locale]["summary."+source], "%s %s %s", name, locale, source)
+				}
+			}
+		}
+		for locale := range explanationPhrases {
+			for _, technique := range builtinTechniques {
+				assert.NotEmpty(t, plainTechniqueDescriptions[locale][technique.ID], "%s %s", locale, technique.ID)
+			}
+		}
+	})
+}
+
+func TestEnhanceExplanationsEnabledFor(t *testing.T) {
+	web := &services.RequestContext{UserID: "user-1"}
+	api := &services.RequestContext{UserID: "user-1", Client: services.ClientInfo{APIKeyID: "key-1"}}
+
+	t.Setenv("ENHANCE_EXPLANATIONS_API_KEYS", "false")
+	explanations := loadEnhanceExplanations()
+	assert.True(t, explanations.enabledFor(web))
+	assert.True(t, explanations.enabledFor(nil))
+	assert.False(t, explanations.enabledFor(api))
+
+	t.Setenv("ENHANCE_EXPLANATIONS", "false")
+	assert.False(t, loadEnhanceExplanations().enabledFor(web))
+}
+
+func TestRunEnhancementExplanation(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	entry := logrus.NewEntry(logger)
+	req := EnhanceRequest{Text: "why is the sky blue"}
+
+	newDeps := func(selector TechniqueSelector) *Dependencies {
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Return("history-1", nil)
+		return &Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   selector,
+			Generator:  stubGenerator{},
+			History:    db,
+		}
+	}
+
+	t.Run("explains the selector's choice", func(t *testing.T) {
+		opts := enhanceOptions{Explain: true, Request: &services.RequestContext{Tier: services.TierAnonymous, Locale: "es"}}
+		response, err := runEnhancement(context.Background(), newDeps(reasoningSelector{}), entry, req, opts)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"chain_of_thought", "step_back"}, response.TechniquesUsed)
+		require.NotNil(t, response.Explanation)
+		assert.Equal(t, explanationSourceSelector, response.Explanation.Source)
+		assert.Equal(t, "es", response.Explanation.Locale)
+		assert.Equal(t, "Adecuada para el tipo de petición que hiciste", response.Explanation.Techniques[0].Reasons[0])
+	})
+
+	t.Run("in the caller's explanation style", func(t *testing.T) {
+		opts := enhanceOptions{Explain: true, Request: &services.RequestContext{Tier: services.TierFree, UserID: "user-1", ExplanationStyle: services.ExplanationStyleTechnical}}
+		response, err := runEnhancement(context.Background(), newDeps(reasoningSelector{}), entry, req, opts)
+		require.NoError(t, err)
+
+		require.NotNil(t, response.Explanation)
+		assert.Equal(t, services.ExplanationStyleTechnical, response.Explanation.Style)
+		assert.Contains(t, response.Explanation.Techniques[0].Reasons, "complexity 0.62 >= 0.50")
+	})
+
+	t.Run("explains fallbacks when the selector is down", func(t *testing.T) {
+		selector := reasoningSelector{err: errors.New("selector down")}
+		response, err := runEnhancement(context.Background(), newDeps(selector), entry, req, enhanceOptions{Explain: true})
+		require.NoError(t, err)
+
+		require.NotNil(t, response.Explanation)
+		assert.Equal(t, explanationSourceDefault, response.Explanation.Source)
+		assert.Equal(t, "chain_of_thought", response.Explanation.Techniques[0].ID)
+		assert.Empty(t, response.Explanation.Techniques[0].Reasons)
+	})
+
+	t.Run("left out unless asked for", func(t *testing.T) {
+		response, err := runEnhancement(context.Background(), newDeps(reasoningSelector{}), entry, req, enhanceOptions{})
+		require.NoError(t, err)
+		assert.Nil(t, response.Explanation)
+	})
+}
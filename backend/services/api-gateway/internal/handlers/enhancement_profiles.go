@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// EnhancementProfileHandler serves the caller's enhancement profiles. The
+// profile used when a request names none is set in preferences under
+// services.DefaultProfilePreference.
+type EnhancementProfileHandler struct {
+	profiles *services.EnhancementProfileService
+	logger   *logrus.Entry
+}
+
+// NewEnhancementProfileHandler creates a new enhancement profile handler
+func NewEnhancementProfileHandler(profiles *services.EnhancementProfileService, logger *logrus.Entry) *EnhancementProfileHandler {
+	return &EnhancementProfileHandler{
+		profiles: profiles,
+		logger:   logger,
+	}
+}
+
+// EnhancementProfileRequest creates or replaces an enhancement profile
+type EnhancementProfileRequest struct {
+	Name              string   `json:"name" binding:"required,max=50"`
+	PreferTechniques  []string `json:"prefer_techniques" binding:"max=10"`
+	ExcludeTechniques []string `json:"exclude_techniques" binding:"max=10"`
+	Tone              string   `json:"tone" binding:"max=200"`
+	TargetModel       string   `json:"target_model" binding:"max=100"`
+	OutputFormat      string   `json:"output_format" binding:"omitempty,oneof=text structured"`
+}
+
+// ListProfiles returns the caller's profiles
+func (h *EnhancementProfileHandler) ListProfiles(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	profiles, err := h.profiles.ListProfiles(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list enhancement profiles")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"profiles": profiles})
+}
+
+// GetProfile returns one of the caller's profiles
+func (h *EnhancementProfileHandler) GetProfile(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	profile, err := h.profiles.GetProfile(c.Request.Context(), userID, c.Param("name"))
+	if err != nil {
+		h.respondError(c, err, "failed to get profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// CreateProfile adds a profile
+func (h *EnhancementProfileHandler) CreateProfile(c *gin.Context) {
+	profile, ok := bindEnhancementProfile(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	created, err := h.profiles.CreateProfile(c.Request.Context(), userID, profile)
+	if err != nil {
+		h.respondError(c, err, "failed to create profile")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateProfile replaces a profile, renaming it when the body's name differs
+func (h *EnhancementProfileHandler) UpdateProfile(c *gin.Context) {
+	profile, ok := bindEnhancementProfile(c)
+	if !ok {
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	updated, err := h.profiles.UpdateProfile(c.Request.Context(), userID, c.Param("name"), profile)
+	if err != nil {
+		h.respondError(c, err, "failed to update profile")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteProfile removes a profile. A default naming it is then ignored.
+func (h *EnhancementProfileHandler) DeleteProfile(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	if err := h.profiles.DeleteProfile(c.Request.Context(), userID, c.Param("name")); err != nil {
+		h.respondError(c, err, "failed to delete profile")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *EnhancementProfileHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProfileExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTooManyProfiles):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Enhancement profile operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// bindEnhancementProfile reads and normalizes a profile from the request
+// body, answering 400 when it is invalid
+func bindEnhancementProfile(c *gin.Context) (services.EnhancementProfile, bool) {
+	var req EnhancementProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.EnhancementProfile{}, false
+	}
+
+	profile := services.EnhancementProfile{
+		Name:              req.Name,
+		PreferTechniques:  req.PreferTechniques,
+		ExcludeTechniques: req.ExcludeTechniques,
+		Tone:              req.Tone,
+		TargetModel:       req.TargetModel,
+		OutputFormat:      req.OutputFormat,
+	}
+	if err := services.NormalizeEnhancementProfile(&profile); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid profile",
+			"details": err.Error(),
+		})
+		return services.EnhancementProfile{}, false
+	}
+	return profile, true
+}
+
+// resolveEnhancementProfile looks up the profile an enhance request runs
+// with: the one it names or the caller's default. Only a named profile that
+// can't be used fails the request; ok is false once that has been answered.
+func resolveEnhancementProfile(c *gin.Context, deps *Dependencies, rc *services.RequestContext, name string) (profile *services.EnhancementProfile, ok bool) {
+	if !rc.Authenticated() || deps.Profiles == nil {
+		if name != "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": "profiles are only available to signed-in users",
+			})
+			return nil, false
+		}
+		return nil, true
+	}
+
+	profile, err := deps.Profiles.ResolveProfile(c.Request.Context(), rc.UserID, name)
+	switch {
+	case errors.Is(err, services.ErrProfileNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": "unknown profile: " + name,
+		})
+		return nil, false
+	case err != nil && name != "":
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to resolve enhancement profile")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resolve profile"})
+		return nil, false
+	case err != nil:
+		// Enhancing without the default beats failing the request
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Warn("Failed to resolve default enhancement profile")
+		return nil, true
+	}
+	return profile, true
+}
+
+// applyEnhancementProfile fills in the techniques, output format and
+// generation context a request leaves unset from the profile
+func applyEnhancementProfile(req *EnhanceRequest, profile *services.EnhancementProfile) {
+	if len(req.PreferTechniques) == 0 {
+		req.PreferTechniques = profile.PreferTechniques
+	}
+	if len(req.ExcludeTechniques) == 0 {
+		req.ExcludeTechniques = profile.ExcludeTechniques
+	}
+	if req.OutputFormat == "" {
+		req.OutputFormat = profile.OutputFormat
+	}
+
+	generationContext := make(map[string]interface{}, len(req.Context)+2)
+	for k, v := range req.Context {
+		generationContext[k] = v
+	}
+	if _, set := generationContext["tone"]; !set && profile.Tone != "" {
+		generationContext["tone"] = profile.Tone
+	}
+	if _, set := generationContext["target_model"]; !set && profile.TargetModel != "" {
+		generationContext["target_model"] = profile.TargetModel
+	}
+	req.Context = generationContext
+}
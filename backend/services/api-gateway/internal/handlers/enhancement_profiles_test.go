@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stubProfiles resolves profiles by name, with "" as the default
+type stubProfiles map[string]*services.EnhancementProfile
+
+func (s stubProfiles) ResolveProfile(ctx context.Context, userID, name string) (*services.EnhancementProfile, error) {
+	profile, ok := s[name]
+	if !ok && name != "" {
+		return nil, services.ErrProfileNotFound
+	}
+	return profile, nil
+}
+
+// recordingGenerator keeps the last generation request
+type recordingGenerator struct {
+	last models.PromptGenerationRequest
+}
+
+func (g *recordingGenerator) GeneratePrompt(ctx context.Context, req models.PromptGenerationRequest) (*models.PromptGenerationResponse, error) {
+	g.last = req
+	return &models.PromptGenerationResponse{Text: "enhanced", ModelVersion: "v1"}, nil
+}
+
+func TestEnhanceWithProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	study := &services.EnhancementProfile{Name: "study", PreferTechniques: []string{"step_by_step"}, Tone: "patient", OutputFormat: "structured"}
+	work := &services.EnhancementProfile{Name: "work", Tone: "concise", TargetModel: "claude"}
+
+	enhance := func(t *testing.T, userID, body string) (*httptest.ResponseRecorder, *recordingGenerator, models.PromptHistory) {
+		var saved models.PromptHistory
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			saved = args.Get(1).(models.PromptHistory)
+		}).Return("history-1", nil)
+		generator := &recordingGenerator{}
+		h := NewEnhanceHandler(&Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   stubSelector{},
+			Generator:  generator,
+			History:    db,
+			Profiles:   stubProfiles{"": study, "study": study, "work": work},
+		})
+
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("request_id", "req-1")
+			c.Set("logger", logrus.NewEntry(logger))
+			if userID != "" {
+				c.Set("user_id", userID)
+			}
+		})
+		router.POST("/enhance", h.Enhance)
+
+		req := httptest.NewRequest(http.MethodPost, "/enhance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec, generator, saved
+	}
+
+	t.Run("default profile", func(t *testing.T) {
+		rec, generator, saved := enhance(t, "user-1", `{"text":"explain recursion"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "patient", generator.last.Context["tone"])
+		assert.Contains(t, rec.Body.String(), `"sections"`, "the profile's output format applies")
+		assert.Equal(t, "study", saved.Metadata["profile"])
+	})
+
+	t.Run("named profile, with the request's own settings winning", func(t *testing.T) {
+		rec, generator, saved := enhance(t, "user-1", `{"text":"write a memo","profile":"work","context":{"tone":"formal"}}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.Equal(t, "formal", generator.last.Context["tone"])
+		assert.Equal(t, "claude", generator.last.Context["target_model"])
+		assert.Equal(t, "work", saved.Metadata["profile"])
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		rec, _, _ := enhance(t, "user-1", `{"text":"write a memo","profile":"play"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "unknown profile: play")
+	})
+
+	t.Run("anonymous callers have no profiles", func(t *testing.T) {
+		rec, generator, _ := enhance(t, "", `{"text":"write a memo"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.NotContains(t, generator.last.Context, "tone")
+
+		rec, _, _ = enhance(t, "", `{"text":"write a memo","profile":"work"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// EnhancementReviewHandler exposes the human review queue to reviewers
+type EnhancementReviewHandler struct {
+	reviews *services.EnhancementReviewService
+	logger  *logrus.Entry
+}
+
+// NewEnhancementReviewHandler creates a new enhancement review handler
+func NewEnhancementReviewHandler(reviews *services.EnhancementReviewService, logger *logrus.Entry) *EnhancementReviewHandler {
+	return &EnhancementReviewHandler{
+		reviews: reviews,
+		logger:  logger,
+	}
+}
+
+// ListReviews returns queued enhancements, pending by default
+func (h *EnhancementReviewHandler) ListReviews(c *gin.Context) {
+	status := c.DefaultQuery("status", services.EnhancementReviewPending)
+	switch status {
+	case services.EnhancementReviewPending, services.EnhancementReviewClaimed,
+		services.EnhancementReviewApproved, services.EnhancementReviewCorrected:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status filter"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	reviews, err := h.reviews.ListReviews(c.Request.Context(), status, limit, offset)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list enhancement reviews")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list reviews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reviews": reviews,
+		"status":  status,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// GetReview returns one queued enhancement
+func (h *EnhancementReviewHandler) GetReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+
+	review, err := h.reviews.GetReview(c.Request.Context(), id)
+	if err != nil {
+		h.respondError(c, err, "Failed to get enhancement review")
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+// ClaimReview assigns a queued enhancement to the caller
+func (h *EnhancementReviewHandler) ClaimReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+
+	reviewerID, _ := middleware.GetUserID(c)
+	review, err := h.reviews.Claim(c.Request.Context(), id, reviewerID)
+	if err != nil {
+		h.respondError(c, err, "Failed to claim enhancement review")
+		return
+	}
+
+	h.auditLogger(c, review).Info("Enhancement review claimed")
+	c.JSON(http.StatusOK, review)
+}
+
+// CorrectReview saves the caller's correction to a review they have claimed
+func (h *EnhancementReviewHandler) CorrectReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+
+	var req services.EnhancementCorrection
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	reviewerID, _ := middleware.GetUserID(c)
+	review, err := h.reviews.Correct(c.Request.Context(), id, reviewerID, req)
+	if err != nil {
+		h.respondError(c, err, "Failed to correct enhancement review")
+		return
+	}
+
+	h.auditLogger(c, review).Info("Enhancement review corrected")
+	c.JSON(http.StatusOK, review)
+}
+
+// ApproveReview resolves a review the caller has claimed, keeping their
+// correction if they made one
+func (h *EnhancementReviewHandler) ApproveReview(c *gin.Context) {
+	id, ok := reviewID(c)
+	if !ok {
+		return
+	}
+
+	reviewerID, _ := middleware.GetUserID(c)
+	review, err := h.reviews.Approve(c.Request.Context(), id, reviewerID)
+	if err != nil {
+		h.respondError(c, err, "Failed to approve enhancement review")
+		return
+	}
+
+	h.auditLogger(c, review).WithField("status", review.Status).Info("Enhancement review approved")
+	c.JSON(http.StatusOK, review)
+}
+
+func (h *EnhancementReviewHandler) auditLogger(c *gin.Context, review *services.EnhancementReview) *logrus.Entry {
+	reviewerID, _ := middleware.GetUserID(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":       true,
+		"reviewer_id": reviewerID,
+		"review_id":   review.ID,
+		"history_id":  review.HistoryID,
+	})
+}
+
+func (h *EnhancementReviewHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrEnhancementReviewNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEnhancementReviewUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process review"})
+	}
+}
+
+func reviewID(c *gin.Context) (string, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrEnhancementReviewNotFound.Error()})
+		return "", false
+	}
+	return id, true
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/betterprompts/api-gateway/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -82,17 +84,44 @@ func (h *FeedbackHandler) SubmitFeedback(c *gin.Context) {
 		return
 	}
 
-	// Get user context
-	userCtx, _ := middleware.GetUserContext(c)
-	
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	// Only the user a prompt was enhanced for can rate it
+	entry, ok := h.authorizedEntry(c, req.PromptHistoryID, services.PromptActionRate)
+	if !ok {
+		return
+	}
+
+	reasons, ok := h.screen(c, req, entry)
+	if !ok {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too much feedback for this prompt, try again later"})
+		return
+	}
+	// Only the gateway marks feedback as filtered
+	delete(req.Metadata, "filtered")
+	delete(req.Metadata, "filter_reasons")
+	if len(reasons) > 0 {
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]interface{})
+		}
+		req.Metadata["filtered"] = true
+		req.Metadata["filter_reasons"] = reasons
+		h.logger.WithFields(logrus.Fields{
+			"user_id":           rc.UserID,
+			"prompt_history_id": req.PromptHistoryID,
+			"reasons":           reasons,
+		}).Warn("Feedback filtered as spam")
+	}
+
 	// Add user information to the request headers for the prompt-generator service
 	headers := map[string]string{
 		"Content-Type": "application/json",
 		"User-Agent":   c.GetHeader("User-Agent"),
-	}
-	
-	if userCtx != nil {
-		headers["X-User-ID"] = userCtx.UserID
+		"X-User-ID":    rc.UserID,
 	}
 	
 	// Add session ID if available
@@ -111,7 +140,7 @@ func (h *FeedbackHandler) SubmitFeedback(c *gin.Context) {
 		return
 	}
 
-	httpReq, err := http.NewRequest("POST", feedbackURL, bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", feedbackURL, bytes.NewReader(reqBody))
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to create feedback request")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -154,6 +183,9 @@ func (h *FeedbackHandler) SubmitFeedback(c *gin.Context) {
 			"prompt_history_id": feedbackResp.PromptHistoryID,
 			"rating":           feedbackResp.Rating,
 		}).Info("Feedback submitted successfully")
+		if len(reasons) == 0 {
+			h.recordEffectiveness(req, entry)
+		}
 		
 		c.JSON(resp.StatusCode, feedbackResp)
 	} else {
@@ -163,6 +195,79 @@ func (h *FeedbackHandler) SubmitFeedback(c *gin.Context) {
 	}
 }
 
+// historyStore returns where prompt history is read from
+func (h *FeedbackHandler) historyStore() HistoryStore {
+	if h.clients.History != nil {
+		return h.clients.History
+	}
+	return h.clients.Database
+}
+
+// authorizedEntry loads a history entry and checks the caller may act on
+// it. It writes the error response and returns false when not.
+func (h *FeedbackHandler) authorizedEntry(c *gin.Context, historyID string, action services.PromptAction) (*models.PromptHistory, bool) {
+	entry, err := h.historyStore().GetPromptHistory(c.Request.Context(), historyID)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptHistoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Prompt history not found"})
+			return nil, false
+		}
+		h.logger.WithError(err).Error("Failed to get prompt history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return nil, false
+	}
+	if !authorizeHistory(c, h.clients.PromptAuthz, entry, action, h.logger) {
+		return nil, false
+	}
+	return entry, true
+}
+
+// screen applies the feedback filter, returning false when the submission
+// is over the per-item limit and otherwise the reasons it is filtered
+func (h *FeedbackHandler) screen(c *gin.Context, req FeedbackRequest, entry *models.PromptHistory) ([]string, bool) {
+	if h.clients.FeedbackFilter == nil {
+		return nil, true
+	}
+	sub := services.FeedbackSubmission{
+		UserID:     entry.UserID.String,
+		HistoryID:  entry.ID,
+		Rating:     req.Rating,
+		Text:       req.FeedbackText,
+		EnhancedAt: entry.CreatedAt,
+	}
+	if !h.clients.FeedbackFilter.AllowSubmission(c.Request.Context(), sub) {
+		return nil, false
+	}
+	return h.clients.FeedbackFilter.Assess(c.Request.Context(), sub), true
+}
+
+// recordEffectiveness counts a rating toward each technique the rated
+// prompt was enhanced with, using the per-technique rating where one was
+// given. Entries without an intent and ratings off the 1-5 scale aren't
+// counted.
+func (h *FeedbackHandler) recordEffectiveness(req FeedbackRequest, entry *models.PromptHistory) {
+	if h.clients.Effectiveness == nil || !entry.Intent.Valid {
+		return
+	}
+	for _, technique := range entry.TechniquesUsed {
+		score, ok := req.TechniqueRatings[technique]
+		if !ok {
+			if req.Rating == nil {
+				continue
+			}
+			score = *req.Rating
+		}
+		if score < 1 || score > 5 {
+			continue
+		}
+		h.clients.Effectiveness.Record(services.TechniqueFeedback{
+			Technique: technique,
+			Intent:    entry.Intent.String,
+			Score:     float64(score),
+		})
+	}
+}
+
 // GetFeedback handles GET /api/v1/feedback/:prompt_history_id
 func (h *FeedbackHandler) GetFeedback(c *gin.Context) {
 	promptHistoryID := c.Param("prompt_history_id")
@@ -171,22 +276,25 @@ func (h *FeedbackHandler) GetFeedback(c *gin.Context) {
 		return
 	}
 
-	// Get user context
-	userCtx, _ := middleware.GetUserContext(c)
-	
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+	if _, ok := h.authorizedEntry(c, promptHistoryID, services.PromptActionRead); !ok {
+		return
+	}
+
 	// Build request headers
 	headers := map[string]string{
 		"Content-Type": "application/json",
-	}
-	
-	if userCtx != nil {
-		headers["X-User-ID"] = userCtx.UserID
+		"X-User-ID":    rc.UserID,
 	}
 
 	// Forward to prompt-generator service
 	feedbackURL := fmt.Sprintf("%s/api/v1/feedback/prompt/%s", h.clients.PromptGeneratorURL, promptHistoryID)
 	
-	httpReq, err := http.NewRequest("GET", feedbackURL, nil)
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "GET", feedbackURL, nil)
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to create feedback request")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -255,7 +363,7 @@ func (h *FeedbackHandler) GetTechniqueEffectiveness(c *gin.Context) {
 		return
 	}
 
-	httpReq, err := http.NewRequest("POST", effectivenessURL, bytes.NewReader(reqBody))
+	httpReq, err := http.NewRequestWithContext(c.Request.Context(), "POST", effectivenessURL, bytes.NewReader(reqBody))
 	if err != nil {
 		h.logger.WithError(err).Error("Failed to create effectiveness request")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
@@ -0,0 +1,104 @@
+package handlers_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedbackHistory serves one history entry; nothing else is called
+type feedbackHistory struct {
+	services.HistoryStore
+	entry *models.PromptHistory
+}
+
+func (h feedbackHistory) GetPromptHistory(_ context.Context, id string) (*models.PromptHistory, error) {
+	if id != h.entry.ID {
+		return nil, services.ErrPromptHistoryNotFound
+	}
+	return h.entry, nil
+}
+
+func TestSubmitFeedback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// The prompt generator records what it was asked to store
+	var forwarded map[string]interface{}
+	generator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = nil
+		json.NewDecoder(r.Body).Decode(&forwarded)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(handlers.FeedbackResponse{ID: "feedback-1", PromptHistoryID: "history-1"})
+	}))
+	defer generator.Close()
+
+	submit := func(enhancedAt time.Time, body map[string]interface{}) int {
+		history := feedbackHistory{entry: &models.PromptHistory{
+			ID:        "history-1",
+			UserID:    sql.NullString{String: "owner", Valid: true},
+			CreatedAt: enhancedAt,
+		}}
+		clients := &services.ServiceClients{
+			History:            history,
+			FeedbackFilter:     services.NewFeedbackFilter(nil, services.FeedbackFilterConfig{MinDelay: time.Minute}, logger),
+			HTTPClient:         generator.Client(),
+			PromptGeneratorURL: generator.URL,
+		}
+		handler := handlers.NewFeedbackHandler(clients, logger.WithField("test", true))
+
+		data, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/feedback", bytes.NewReader(data))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", body["user"])
+		handler.SubmitFeedback(c)
+		return w.Code
+	}
+
+	t.Run("only the owner can rate a prompt", func(t *testing.T) {
+		forwarded = nil
+		code := submit(time.Now().Add(-time.Hour), map[string]interface{}{
+			"user": "someone-else", "prompt_history_id": "history-1", "rating": 5,
+		})
+		assert.Equal(t, http.StatusForbidden, code)
+		assert.Nil(t, forwarded)
+	})
+
+	t.Run("spam is forwarded marked as filtered", func(t *testing.T) {
+		code := submit(time.Now(), map[string]interface{}{
+			"user": "owner", "prompt_history_id": "history-1", "rating": 1,
+			"metadata": map[string]interface{}{"filtered": false},
+		})
+		assert.Equal(t, http.StatusCreated, code)
+		require.NotNil(t, forwarded)
+		metadata := forwarded["metadata"].(map[string]interface{})
+		assert.Equal(t, true, metadata["filtered"])
+		assert.Equal(t, []interface{}{services.FeedbackFilterInstant}, metadata["filter_reasons"])
+	})
+
+	t.Run("clients can't mark feedback filtered themselves", func(t *testing.T) {
+		code := submit(time.Now().Add(-time.Hour), map[string]interface{}{
+			"user": "owner", "prompt_history_id": "history-1", "rating": 4,
+			"metadata": map[string]interface{}{"filtered": true, "source": "web"},
+		})
+		assert.Equal(t, http.StatusCreated, code)
+		require.NotNil(t, forwarded)
+		assert.Equal(t, map[string]interface{}{"source": "web"}, forwarded["metadata"])
+	})
+}
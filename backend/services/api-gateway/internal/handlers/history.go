@@ -1,134 +1,207 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
 
+	"github.com/betterprompts/api-gateway/internal/middleware"
 	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/betterprompts/api-gateway/internal/services"
 	"github.com/gin-gonic/gin"
 )
 
-// GetPromptHistory retrieves the user's prompt history
+// HistoryHandler serves the user's prompt history and the actions on
+// individual prompts in it
+type HistoryHandler struct {
+	deps *Dependencies
+}
+
+// NewHistoryHandler creates a new history handler
+func NewHistoryHandler(deps *Dependencies) *HistoryHandler {
+	return &HistoryHandler{deps: deps}
+}
+
+// GetPromptHistory retrieves the user's prompt history.
+//
+// Deprecated: wire a HistoryHandler and route to its GetPromptHistory method.
 func GetPromptHistory(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user ID from context (set by auth middleware)
-		userID, exists := c.Get("user_id")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
+	return NewHistoryHandler(NewDependencies(clients)).GetPromptHistory
+}
 
-		// Parse pagination and filter parameters
-		paginationReq := models.ParsePaginationRequest(c)
+// GetPromptHistory retrieves the user's prompt history
+func (h *HistoryHandler) GetPromptHistory(c *gin.Context) {
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 
-		// Get history from database with filters
-		history, totalCount, err := clients.Database.GetUserPromptHistoryWithFilters(
-			c.Request.Context(),
-			userID.(string),
-			paginationReq,
-		)
-		if err != nil {
-			c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history"})
+	// Parse pagination and filter parameters
+	paginationReq := models.ParsePaginationRequest(c)
+
+	// Entries carry text previews; ?fields= opts into full texts
+	fullFields, err := parseHistoryFields(c.Query("fields"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid fields parameter",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Get history from database with filters, narrowed to one enhancement
+	// profile with ?profile=
+	var history []*models.PromptHistory
+	var totalCount int64
+	if profile := c.Query("profile"); profile != "" {
+		store, ok := h.deps.History.(profileHistoryStore)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "filtering by profile is not supported"})
 			return
 		}
-
-		// Create paginated response
-		response := models.CreatePaginatedResponse(
-			history,
-			paginationReq.Page,
-			paginationReq.Limit,
-			totalCount,
+		history, totalCount, err = store.GetUserPromptHistoryForProfile(c.Request.Context(), rc.UserID, strings.ToLower(profile), paginationReq)
+	} else {
+		history, totalCount, err = h.deps.History.GetUserPromptHistoryWithFilters(
+			c.Request.Context(),
+			rc.UserID,
+			paginationReq,
 		)
+	}
+	if errors.Is(err, services.ErrHistorySearchUnsupported) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history"})
+		return
+	}
 
-		// Return the paginated history
-		c.JSON(http.StatusOK, response)
+	// Track searches; clients report result clicks against X-Search-ID
+	if paginationReq.Search != "" && h.deps.Searches != nil {
+		searchID := h.deps.Searches.TrackQuery(services.SearchQuery{
+			UserID:      rc.UserID,
+			Source:      services.SearchSourceHistory,
+			Query:       paginationReq.Search,
+			ResultCount: int(totalCount),
+		})
+		c.Header("X-Search-ID", searchID)
 	}
+
+	h.markPinned(c, rc.UserID, history...)
+	previewHistory(history, fullFields)
+
+	// Create paginated response
+	response := models.CreatePaginatedResponse(
+		history,
+		paginationReq.Page,
+		paginationReq.Limit,
+		totalCount,
+	)
+
+	// Return the paginated history
+	c.JSON(http.StatusOK, response)
 }
 
-// GetPromptHistoryItem retrieves a specific prompt history item
+// GetPromptHistoryItem retrieves a specific prompt history item.
+//
+// Deprecated: wire a HistoryHandler and route to its GetPromptHistoryItem method.
 func GetPromptHistoryItem(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user ID from context
-		userID, exists := c.Get("user_id")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
+	return NewHistoryHandler(NewDependencies(clients)).GetPromptHistoryItem
+}
 
-		// Get history ID from URL parameter
-		historyID := c.Param("id")
-		if historyID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "history ID required"})
-			return
-		}
+// GetPromptHistoryItem retrieves a specific prompt history item
+func (h *HistoryHandler) GetPromptHistoryItem(c *gin.Context) {
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 
-		// Get the history item
-		item, err := clients.Database.GetPromptHistory(c.Request.Context(), historyID)
-		if err != nil {
-			if err.Error() == "prompt history not found" {
-				c.JSON(http.StatusNotFound, gin.H{"error": "history item not found"})
-				return
-			}
-			c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history item")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history item"})
-			return
-		}
+	// Get history ID from URL parameter
+	historyID := c.Param("id")
+	if historyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "history ID required"})
+		return
+	}
 
-		// Verify the user owns this history item
-		if !item.UserID.Valid || item.UserID.String != userID.(string) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	// Get the history item
+	item, err := h.deps.History.GetPromptHistory(c.Request.Context(), historyID)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptHistoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "history item not found"})
 			return
 		}
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history item"})
+		return
+	}
 
-		c.JSON(http.StatusOK, item)
+	if !authorizeHistory(c, h.deps.Authz, item, services.PromptActionRead, c.MustGet("logger").(*logrus.Entry)) {
+		return
 	}
+	h.markPinned(c, rc.UserID, item)
+
+	c.JSON(http.StatusOK, item)
 }
 
-// DeletePromptHistoryItem deletes a specific prompt history item
+// DeletePromptHistoryItem deletes a specific prompt history item.
+//
+// Deprecated: wire a HistoryHandler and route to its DeletePromptHistoryItem method.
 func DeletePromptHistoryItem(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Get user ID from context
-		userID, exists := c.Get("user_id")
-		if !exists {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-			return
-		}
+	return NewHistoryHandler(NewDependencies(clients)).DeletePromptHistoryItem
+}
 
-		// Get history ID from URL parameter
-		historyID := c.Param("id")
-		if historyID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "history ID required"})
-			return
-		}
+// DeletePromptHistoryItem deletes a specific prompt history item
+func (h *HistoryHandler) DeletePromptHistoryItem(c *gin.Context) {
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
 
-		// First, get the item to verify ownership
-		item, err := clients.Database.GetPromptHistory(c.Request.Context(), historyID)
-		if err != nil {
-			if err.Error() == "prompt history not found" {
-				c.JSON(http.StatusNotFound, gin.H{"error": "history item not found"})
-				return
-			}
-			c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history item")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history item"})
-			return
-		}
+	// Get history ID from URL parameter
+	historyID := c.Param("id")
+	if historyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "history ID required"})
+		return
+	}
 
-		// Verify the user owns this history item
-		if !item.UserID.Valid || item.UserID.String != userID.(string) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	// First, get the item to verify ownership
+	item, err := h.deps.History.GetPromptHistory(c.Request.Context(), historyID)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptHistoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "history item not found"})
 			return
 		}
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to get prompt history item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history item"})
+		return
+	}
 
-		// Delete the item
-		err = clients.Database.DeletePromptHistory(c.Request.Context(), historyID)
-		if err != nil {
-			c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to delete prompt history item")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete history item"})
-			return
-		}
+	if !authorizeHistory(c, h.deps.Authz, item, services.PromptActionDelete, c.MustGet("logger").(*logrus.Entry)) {
+		return
+	}
 
-		c.JSON(http.StatusOK, gin.H{"message": "history item deleted successfully"})
+	// Pinned results are immutable until unpinned
+	h.markPinned(c, rc.UserID, item)
+	if ref, pinned := item.Metadata["pin"].(services.PinRef); pinned {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "history item is pinned; unpin it first",
+			"pin_id": ref.PinID,
+		})
+		return
 	}
+
+	// Delete the item
+	err = h.deps.History.DeletePromptHistory(c.Request.Context(), historyID)
+	if err != nil {
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to delete prompt history item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete history item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "history item deleted successfully"})
 }
\ No newline at end of file
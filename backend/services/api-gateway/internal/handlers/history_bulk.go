@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// BulkHistoryRequest represents the request body for a bulk history action
+type BulkHistoryRequest struct {
+	Action       string   `json:"action" binding:"required,oneof=delete favorite unfavorite tag move"`
+	IDs          []string `json:"ids" binding:"required,min=1,max=500,dive,uuid"`
+	Tags         []string `json:"tags" binding:"max=20,dive,min=1,max=50"`
+	CollectionID string   `json:"collection_id" binding:"omitempty,uuid"`
+}
+
+// BulkUpdateHistory applies one action to up to services.MaxHistoryBulkItems
+// history entries. Each entry is authorized like its single-entry route, and
+// the response reports what happened to each; entries the caller may not
+// touch, or pinned entries being deleted, are skipped rather than failing
+// the request. Entries are changed one at a time, so a failure can leave
+// part of the request applied; the report's partial flag says so.
+func (h *HistoryHandler) BulkUpdateHistory(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if h.deps.Bulk == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "bulk history actions are not available"})
+		return
+	}
+
+	var req BulkHistoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	for i, tag := range req.Tags {
+		req.Tags[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+
+	authz := h.deps.Authz
+	if authz == nil {
+		authz = services.NewPromptAuthorizer(nil)
+	}
+	action := services.HistoryBulkAction(req.Action)
+	report, err := h.deps.Bulk.Apply(c.Request.Context(), services.HistoryBulkRequest{
+		UserID:       rc.UserID,
+		Action:       action,
+		IDs:          req.IDs,
+		Tags:         req.Tags,
+		CollectionID: req.CollectionID,
+	}, func(resource services.PromptResource) error {
+		_, err := authz.Authorize(c.Request.Context(), rc, resource, action.PromptAction(), "")
+		return err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidHistoryBulkRequest):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrCollectionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			logger.WithError(err).Error("Failed to apply bulk history action")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to apply bulk action"})
+		}
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"action":  report.Action,
+		"applied": report.Applied,
+		"skipped": report.Skipped,
+		"failed":  report.Failed,
+	}).Info("Bulk history action applied")
+
+	c.JSON(http.StatusOK, report)
+}
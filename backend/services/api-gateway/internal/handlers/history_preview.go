@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// historyPreviewRunes is the most of each text a history list entry
+	// carries unless the full text is asked for with ?fields=
+	historyPreviewRunes = 240
+
+	// historyFieldsFull selects every full text at once
+	historyFieldsFull = "full"
+)
+
+// historyTextFields are the texts selectable in full with ?fields=
+var historyTextFields = map[string]bool{
+	"original_input":  true,
+	"enhanced_output": true,
+}
+
+var (
+	historyPreviewRatio = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gateway_history_preview_ratio",
+		Help:    "Full over previewed text size of history list responses that were previewed",
+		Buckets: []float64{1, 1.25, 1.5, 2, 3, 5, 8, 13, 21},
+	})
+
+	historyPreviewOmittedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_history_preview_omitted_bytes_total",
+		Help: "Text bytes left out of history list responses by previews",
+	})
+)
+
+// historyPreview tells clients a list entry's texts were shortened and how
+// long they are in full; the detail endpoint returns them whole
+type historyPreview struct {
+	OriginalInputLength  int `json:"original_input_length,omitempty"`
+	EnhancedOutputLength int `json:"enhanced_output_length,omitempty"`
+}
+
+// parseHistoryFields validates ?fields=, returning the texts to return in
+// full. "full" selects all of them.
+func parseHistoryFields(value string) (map[string]bool, error) {
+	full := make(map[string]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case field == historyFieldsFull:
+			for name := range historyTextFields {
+				full[name] = true
+			}
+		case historyTextFields[field]:
+			full[field] = true
+		default:
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return full, nil
+}
+
+// previewHistory shortens the texts of entries not selected in full,
+// flagging each shortened entry with metadata.preview and recording how
+// much smaller the response got
+func previewHistory(entries []*models.PromptHistory, full map[string]bool) {
+	var fullBytes, previewBytes int
+	for _, entry := range entries {
+		var preview historyPreview
+		var previewed bool
+		fullBytes += len(entry.OriginalInput) + len(entry.EnhancedOutput)
+
+		if !full["original_input"] {
+			if text, ok := summarizeText(entry.OriginalInput, historyPreviewRunes); ok {
+				preview.OriginalInputLength = len([]rune(entry.OriginalInput))
+				entry.OriginalInput = text
+				previewed = true
+			}
+		}
+		if !full["enhanced_output"] {
+			if text, ok := summarizeText(entry.EnhancedOutput, historyPreviewRunes); ok {
+				preview.EnhancedOutputLength = len([]rune(entry.EnhancedOutput))
+				entry.EnhancedOutput = text
+				previewed = true
+			}
+		}
+
+		previewBytes += len(entry.OriginalInput) + len(entry.EnhancedOutput)
+		if previewed {
+			if entry.Metadata == nil {
+				entry.Metadata = map[string]interface{}{}
+			}
+			entry.Metadata["preview"] = preview
+		}
+	}
+
+	if previewBytes > 0 && fullBytes > previewBytes {
+		historyPreviewRatio.Observe(float64(fullBytes) / float64(previewBytes))
+		historyPreviewOmittedBytes.Add(float64(fullBytes - previewBytes))
+	}
+}
+
+// summarizeText shortens text to at most limit runes plus an ellipsis,
+// reporting whether it had to. It prefers to end on a sentence, then on a
+// word, as long as that keeps at least half of the allowance.
+func summarizeText(text string, limit int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+
+	// A sentence ends at . ! or ? followed by whitespace, or at a line break
+	for i := limit - 1; i >= limit/2; i-- {
+		if runes[i] == '\n' || (strings.ContainsRune(".!?", runes[i]) && unicode.IsSpace(runes[i+1])) {
+			return strings.TrimRightFunc(string(runes[:i+1]), unicode.IsSpace) + " …", true
+		}
+	}
+	for i := limit; i >= limit/2; i-- {
+		if unicode.IsSpace(runes[i]) {
+			return strings.TrimRight(string(runes[:i]), " \t,;:-") + "…", true
+		}
+	}
+	return string(runes[:limit]) + "…", true
+}
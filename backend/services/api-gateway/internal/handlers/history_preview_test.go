@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummarizeText(t *testing.T) {
+	text, truncated := summarizeText("Short prompt.", 20)
+	assert.False(t, truncated)
+	assert.Equal(t, "Short prompt.", text)
+
+	t.Run("ends on a sentence", func(t *testing.T) {
+		text, truncated := summarizeText("Write a haiku. Make it about autumn leaves falling.", 24)
+		assert.True(t, truncated)
+		assert.Equal(t, "Write a haiku. …", text)
+	})
+
+	t.Run("ends on a word without a sentence in reach", func(t *testing.T) {
+		text, _ := summarizeText("Explain recursion, with examples in Go and Python", 30)
+		assert.Equal(t, "Explain recursion, with…", text)
+	})
+
+	t.Run("abbreviations aren't sentence ends", func(t *testing.T) {
+		text, _ := summarizeText("Compare e.g.the two approaches and pick one", 16)
+		assert.Equal(t, "Compare e.g.the…", text)
+	})
+
+	t.Run("cuts on runes", func(t *testing.T) {
+		text, _ := summarizeText(strings.Repeat("日本語", 20), 10)
+		assert.True(t, utf8.ValidString(text))
+		assert.Equal(t, 11, utf8.RuneCountInString(text))
+	})
+}
+
+func TestPreviewHistory(t *testing.T) {
+	long := strings.Repeat("Step through the problem carefully. ", 40)
+	newEntries := func() []*models.PromptHistory {
+		return []*models.PromptHistory{
+			{ID: "a", OriginalInput: "short", EnhancedOutput: long},
+			{ID: "b", OriginalInput: "short", EnhancedOutput: "short"},
+		}
+	}
+
+	entries := newEntries()
+	previewHistory(entries, nil)
+	assert.LessOrEqual(t, utf8.RuneCountInString(entries[0].EnhancedOutput), historyPreviewRunes+2)
+	assert.Equal(t, "short", entries[0].OriginalInput)
+	assert.Equal(t, historyPreview{EnhancedOutputLength: len(long)}, entries[0].Metadata["preview"])
+	assert.NotContains(t, entries[1].Metadata, "preview", "short entries aren't flagged")
+
+	full, err := parseHistoryFields("enhanced_output")
+	require.NoError(t, err)
+	entries = newEntries()
+	previewHistory(entries, full)
+	assert.Equal(t, long, entries[0].EnhancedOutput)
+
+	full, err = parseHistoryFields("full")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"original_input": true, "enhanced_output": true}, full)
+
+	_, err = parseHistoryFields("original_input,password_hash")
+	assert.Error(t, err)
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// IntegrationHandler serves endpoints shaped for no-code platforms such as
+// Zapier and Make: API key auth, flat inputs and flat outputs.
+type IntegrationHandler struct {
+	deps   *Dependencies
+	logger *logrus.Entry
+}
+
+// NewIntegrationHandler creates a new integration handler
+func NewIntegrationHandler(deps *Dependencies, logger *logrus.Entry) *IntegrationHandler {
+	return &IntegrationHandler{
+		deps:   deps,
+		logger: logger,
+	}
+}
+
+// IntegrationEnhanceRequest accepts flat form or JSON fields. Technique lists
+// are comma-separated strings since most no-code builders can't send arrays.
+type IntegrationEnhanceRequest struct {
+	Text              string `form:"text" json:"text" binding:"required,min=1,max=5000"`
+	PreferTechniques  string `form:"prefer_techniques" json:"prefer_techniques"`
+	ExcludeTechniques string `form:"exclude_techniques" json:"exclude_techniques"`
+	TargetComplexity  string `form:"target_complexity" json:"target_complexity"`
+}
+
+// IntegrationPrompt is the flat representation of an enhanced prompt
+type IntegrationPrompt struct {
+	ID               string  `json:"id"`
+	OriginalText     string  `json:"original_text"`
+	EnhancedText     string  `json:"enhanced_text"`
+	Intent           string  `json:"intent"`
+	Complexity       string  `json:"complexity"`
+	Techniques       string  `json:"techniques"`
+	Confidence       float64 `json:"confidence,omitempty"`
+	ProcessingTimeMs float64 `json:"processing_time_ms,omitempty"`
+	CreatedAt        string  `json:"created_at"`
+}
+
+const (
+	defaultIntegrationPollLimit = 50
+	maxIntegrationPollLimit     = 100
+)
+
+// TestAuth lets platforms verify a connection's API key
+func (h *IntegrationHandler) TestAuth(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	key, _ := c.Get("api_key")
+
+	response := gin.H{"user_id": userID}
+	if apiKey, ok := key.(*services.APIKey); ok {
+		response["api_key_name"] = apiKey.Name
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Enhance runs a prompt enhancement and returns a flat response
+func (h *IntegrationHandler) Enhance(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+
+	var req IntegrationEnhanceRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	enhanceReq := EnhanceRequest{
+		Text:              req.Text,
+		PreferTechniques:  splitCommaList(req.PreferTechniques),
+		ExcludeTechniques: splitCommaList(req.ExcludeTechniques),
+		TargetComplexity:  req.TargetComplexity,
+	}
+
+	response, err := runEnhancement(c.Request.Context(), h.deps, logger, enhanceReq, enhanceOptions{
+		Request: middleware.GetRequestContext(c),
+	})
+	if err != nil {
+		respondPipelineError(c, err)
+		return
+	}
+	markModerationFlag(c, response)
+	publishEnhancement(c, response, "integration")
+
+	c.JSON(http.StatusOK, IntegrationPrompt{
+		ID:               response.ID,
+		OriginalText:     response.OriginalText,
+		EnhancedText:     response.EnhancedText,
+		Intent:           response.Intent,
+		Complexity:       response.Complexity,
+		Techniques:       strings.Join(response.TechniquesUsed, ", "),
+		Confidence:       response.Confidence,
+		ProcessingTimeMs: response.ProcessingTime,
+		CreatedAt:        time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// PollHistory returns history items created after the "since" timestamp,
+// newest first, as a bare array so polling triggers can deduplicate on id.
+func (h *IntegrationHandler) PollHistory(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	since, err := parseSince(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "invalid since parameter",
+			"details": "use an RFC 3339 timestamp or unix seconds",
+		})
+		return
+	}
+
+	limit := defaultIntegrationPollLimit
+	if l, err := strconv.Atoi(c.Query("limit")); err == nil && l > 0 {
+		limit = min(l, maxIntegrationPollLimit)
+	}
+
+	history, _, err := h.deps.History.GetUserPromptHistoryWithFilters(
+		c.Request.Context(),
+		userID,
+		models.PaginationRequest{
+			Page:          1,
+			Limit:         limit,
+			DateFrom:      since,
+			SortBy:        "created_at",
+			SortDirection: "DESC",
+		},
+	)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to poll prompt history")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history"})
+		return
+	}
+
+	items := make([]IntegrationPrompt, 0, len(history))
+	for _, entry := range history {
+		// DateFrom is inclusive; polling wants strictly newer items
+		if !since.IsZero() && !entry.CreatedAt.After(since) {
+			continue
+		}
+		items = append(items, IntegrationPrompt{
+			ID:           entry.ID,
+			OriginalText: entry.OriginalInput,
+			EnhancedText: entry.EnhancedOutput,
+			Intent:       entry.Intent.String,
+			Complexity:   entry.Complexity.String,
+			Techniques:   strings.Join(entry.TechniquesUsed, ", "),
+			CreatedAt:    entry.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// parseSince accepts an empty value, an RFC 3339 timestamp or unix seconds
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// splitCommaList splits a comma-separated field, dropping empty entries
+func splitCommaList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,97 @@
+package handlers_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrationPollHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	since := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	userID := "user-123"
+
+	tests := []struct {
+		name           string
+		query          string
+		history        []*models.PromptHistory
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{
+			name:  "returns only items strictly after since",
+			query: "?since=" + since.Format(time.RFC3339),
+			history: []*models.PromptHistory{
+				{ID: "newer", UserID: sql.NullString{String: userID, Valid: true}, TechniquesUsed: []string{"chain_of_thought", "few_shot"}, CreatedAt: since.Add(time.Minute)},
+				{ID: "boundary", UserID: sql.NullString{String: userID, Valid: true}, CreatedAt: since},
+			},
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"newer"},
+		},
+		{
+			name:           "accepts unix seconds",
+			query:          "?since=1714564800",
+			history:        []*models.PromptHistory{},
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{},
+		},
+		{
+			name:           "rejects malformed since",
+			query:          "?since=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(handlers.MockDatabase)
+			if tt.history != nil {
+				mockDB.On("GetUserPromptHistoryWithFilters", mock.Anything, userID, mock.AnythingOfType("models.PaginationRequest")).
+					Return(tt.history, int64(len(tt.history)), nil)
+			}
+
+			logger := logrus.NewEntry(logrus.New())
+			handler := handlers.NewIntegrationHandler(&handlers.Dependencies{History: mockDB}, logger)
+
+			router := gin.New()
+			router.GET("/integrations/history", func(c *gin.Context) {
+				c.Set("user_id", userID)
+				handler.PollHistory(c)
+			})
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/integrations/history"+tt.query, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var items []handlers.IntegrationPrompt
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+
+			ids := []string{}
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			assert.Equal(t, tt.expectedIDs, ids)
+			if len(items) > 0 {
+				assert.Equal(t, "chain_of_thought, few_shot", items[0].Techniques)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// IntentDriftHandler serves the intent classifier drift dashboard
+type IntentDriftHandler struct {
+	drift  *services.IntentDriftService
+	logger *logrus.Entry
+}
+
+// NewIntentDriftHandler creates a new intent drift handler
+func NewIntentDriftHandler(drift *services.IntentDriftService, logger *logrus.Entry) *IntentDriftHandler {
+	return &IntentDriftHandler{
+		drift:  drift,
+		logger: logger,
+	}
+}
+
+// GetDrift returns the latest drift snapshot and the drift metrics over the
+// last ?days= days (default 7, max 90)
+func (h *IntentDriftHandler) GetDrift(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "7"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+
+	since := time.Now().AddDate(0, 0, -days)
+	dashboard, err := h.drift.Dashboard(c.Request.Context(), since)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get intent drift dashboard")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get intent drift"})
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+// RefreshDrift takes a snapshot immediately rather than waiting for the next
+// scheduled run
+func (h *IntentDriftHandler) RefreshDrift(c *gin.Context) {
+	snapshot, err := h.drift.Snapshot(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to take intent drift snapshot")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to take intent drift snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
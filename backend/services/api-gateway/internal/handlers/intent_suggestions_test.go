@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// suggestingClassifier scores runner-up intents like the real classifier client
+type suggestingClassifier struct {
+	stubClassifier
+	topK int
+}
+
+func (s *suggestingClassifier) SuggestIntents(ctx context.Context, text string, topK int) (*services.IntentClassificationResult, error) {
+	s.topK = topK
+	return &services.IntentClassificationResult{
+		Intent:     "reasoning",
+		Confidence: 0.6,
+		IntentScores: map[string]float64{
+			"reasoning":       0.6,
+			"code_generation": 0.3,
+			"question_answer": 0.1,
+		},
+	}, nil
+}
+
+// intentSelector records the intent techniques were selected for
+type intentSelector struct {
+	stubSelector
+	intent string
+}
+
+func (s *intentSelector) SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error) {
+	s.intent = req.Intent
+	return s.stubSelector.SelectTechniques(ctx, req)
+}
+
+// recordedLabels keeps intent corrections in memory
+type recordedLabels struct {
+	corrections []services.IntentCorrection
+}
+
+func (r *recordedLabels) RecordIntentCorrection(ctx context.Context, correction services.IntentCorrection) error {
+	r.corrections = append(r.corrections, correction)
+	return nil
+}
+
+func TestAnalyzeWithTopK(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	classifier := &suggestingClassifier{}
+	handler := NewEnhanceHandler(&Dependencies{Classifier: classifier})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/analyze", handler.Analyze)
+
+	analyze := func(req AnalyzeRequest) (int, map[string]interface{}) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze", bytes.NewReader(body)))
+		var response map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response
+	}
+
+	t.Run("returns candidates most likely first", func(t *testing.T) {
+		code, response := analyze(AnalyzeRequest{Text: "sort this list", TopK: 2})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, classifier.topK)
+		assert.Equal(t, "reasoning", response["intent"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"intent": "reasoning", "score": 0.6},
+			map[string]interface{}{"intent": "code_generation", "score": 0.3},
+		}, response["candidates"])
+	})
+
+	t.Run("plain requests keep the classification response", func(t *testing.T) {
+		code, response := analyze(AnalyzeRequest{Text: "sort this list"})
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "reasoning", response["intent"])
+		assert.NotContains(t, response, "candidates")
+	})
+
+	t.Run("rejects out of range top_k", func(t *testing.T) {
+		code, _ := analyze(AnalyzeRequest{Text: "sort this list", TopK: 11})
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func TestEnhanceRejectsUnknownIntent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	selector := &intentSelector{}
+	labels := &recordedLabels{}
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   selector,
+		Generator:  stubGenerator{},
+		History:    new(MockDatabase),
+		Labels:     labels,
+	})
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/enhance", h.Enhance)
+
+	body, _ := json.Marshal(EnhanceRequest{Text: "sort this list", Intent: "ignore_all_rules"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/enhance", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, selector.intent, "the pipeline never runs")
+	assert.Empty(t, labels.corrections)
+}
+
+func TestRunEnhancementWithCorrectedIntent(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	entry := logrus.NewEntry(logger)
+
+	newDeps := func() (*Dependencies, *MockDatabase, *intentSelector, *recordedLabels) {
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Return("history-1", nil)
+		selector := &intentSelector{}
+		labels := &recordedLabels{}
+		return &Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   selector,
+			Generator:  stubGenerator{},
+			History:    db,
+			Labels:     labels,
+		}, db, selector, labels
+	}
+	user := enhanceOptions{Request: &services.RequestContext{UserID: "user-1", Tier: services.TierFree}}
+
+	t.Run("the user's intent drives the pipeline and becomes a label", func(t *testing.T) {
+		deps, db, selector, labels := newDeps()
+		req := EnhanceRequest{Text: "sort this list", Intent: "code_generation"}
+
+		response, err := runEnhancement(context.Background(), deps, entry, req, user)
+		require.NoError(t, err)
+		assert.Equal(t, "code_generation", response.Intent)
+		assert.Equal(t, "code_generation", selector.intent)
+		assert.Equal(t, "reasoning", response.Metadata["predicted_intent"])
+
+		saved := db.Calls[0].Arguments.Get(1).(models.PromptHistory)
+		assert.Equal(t, "code_generation", saved.Intent.String)
+		assert.Equal(t, map[string]interface{}{
+			"predicted_intent":     "reasoning",
+			"predicted_confidence": 0.9,
+		}, saved.Metadata["intent_correction"])
+
+		assert.Equal(t, []services.IntentCorrection{{
+			HistoryID:           "history-1",
+			Intent:              "code_generation",
+			PredictedIntent:     "reasoning",
+			PredictedConfidence: 0.9,
+			UserID:              "user-1",
+		}}, labels.corrections)
+	})
+
+	t.Run("confirming the prediction is not a correction", func(t *testing.T) {
+		deps, _, _, labels := newDeps()
+		req := EnhanceRequest{Text: "sort this list", Intent: "reasoning"}
+
+		response, err := runEnhancement(context.Background(), deps, entry, req, user)
+		require.NoError(t, err)
+		assert.NotContains(t, response.Metadata, "predicted_intent")
+		assert.Empty(t, labels.corrections)
+	})
+
+	t.Run("anonymous corrections are applied but not kept", func(t *testing.T) {
+		deps, _, selector, labels := newDeps()
+		req := EnhanceRequest{Text: "sort this list", Intent: "code_generation"}
+
+		_, err := runEnhancement(context.Background(), deps, entry, req, enhanceOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "code_generation", selector.intent)
+		assert.Empty(t, labels.corrections)
+	})
+}
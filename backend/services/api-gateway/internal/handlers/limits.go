@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// GetLimits reports the caller's standing in every rate limiter that applies
+// to them, plus any abuse restriction on their account and the rate limit
+// warnings whose windows haven't reset, so clients can back off before they are
+// rejected. Reading the limits doesn't count against them.
+func GetLimits(cache *services.CacheService, abuse *services.AbuseService, warnings *services.RateLimitWarnings, limiters ...middleware.RateLimitConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := c.MustGet("logger").(*logrus.Entry)
+		c.Header("Cache-Control", "no-store")
+
+		if cache == nil {
+			c.JSON(http.StatusOK, gin.H{
+				"enabled":     false,
+				"rate_limits": []middleware.RateLimitWindow{},
+			})
+			return
+		}
+
+		windows, err := middleware.RateLimitStatus(c, cache, limiters...)
+		if err != nil {
+			logger.WithError(err).Error("Failed to read rate limit status")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read rate limits"})
+			return
+		}
+
+		response := gin.H{
+			"enabled":     true,
+			"rate_limits": windows,
+			"checked_at":  time.Now().UTC(),
+		}
+
+		userID, _ := middleware.GetUserID(c)
+		if userID != "" && warnings != nil {
+			names := make([]string, len(limiters))
+			for i, limiter := range limiters {
+				names[i] = limiter.Name
+			}
+			notices, err := warnings.Notices(c.Request.Context(), userID, names...)
+			if err != nil {
+				logger.WithError(err).Warn("Failed to read rate limit notices")
+			} else {
+				response["warnings"] = notices
+			}
+		}
+
+		if userID != "" && abuse != nil {
+			restriction, err := abuse.GetRestriction(c.Request.Context(), userID)
+			if err != nil {
+				logger.WithError(err).Warn("Abuse restriction lookup failed")
+			}
+			if restriction != nil {
+				response["restriction"] = restriction
+				if restriction.Action == services.AbuseActionThrottle {
+					used, resetAt, err := abuse.ThrottleStatus(c.Request.Context(), userID)
+					if err != nil {
+						logger.WithError(err).Warn("Failed to read throttle status")
+					} else {
+						response["rate_limits"] = append(windows, middleware.NewRateLimitWindow(
+							"abuse_throttle", services.ThrottledRequestsPerMinute, used, time.Minute, resetAt))
+					}
+				}
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
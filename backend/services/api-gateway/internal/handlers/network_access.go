@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// NetworkAccessHandler lets admins manage IP and country access rules at runtime
+type NetworkAccessHandler struct {
+	access *services.NetworkAccessService
+	logger *logrus.Entry
+}
+
+// NewNetworkAccessHandler creates a new network access handler
+func NewNetworkAccessHandler(access *services.NetworkAccessService, logger *logrus.Entry) *NetworkAccessHandler {
+	return &NetworkAccessHandler{
+		access: access,
+		logger: logger,
+	}
+}
+
+// UpdateNetworkAccessRequest replaces the enforced network access rules
+type UpdateNetworkAccessRequest struct {
+	AllowCIDRs       []string `json:"allow_cidrs" binding:"max=1000"`
+	DenyCIDRs        []string `json:"deny_cidrs" binding:"max=1000"`
+	BlockedCountries []string `json:"blocked_countries" binding:"max=250"`
+	DefaultDeny      bool     `json:"default_deny"`
+}
+
+// GetRules returns the rules currently enforced by this gateway
+func (h *NetworkAccessHandler) GetRules(c *gin.Context) {
+	c.JSON(http.StatusOK, h.access.GetRules(c.Request.Context()))
+}
+
+// UpdateRules replaces the allow/deny lists and blocked countries
+func (h *NetworkAccessHandler) UpdateRules(c *gin.Context) {
+	var req UpdateNetworkAccessRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	rules := services.NetworkAccessRules{
+		AllowCIDRs:       req.AllowCIDRs,
+		DenyCIDRs:        req.DenyCIDRs,
+		BlockedCountries: req.BlockedCountries,
+		DefaultDeny:      req.DefaultDeny,
+	}
+	if err := services.ValidateNetworkAccessRules(rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid network access rules",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	rules, err := h.access.UpdateRules(c.Request.Context(), rules, adminID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to update network access rules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update network access rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// CheckIP reports how the current rules treat an address, e.g. ?ip=203.0.113.7
+func (h *NetworkAccessHandler) CheckIP(c *gin.Context) {
+	ip := net.ParseIP(c.Query("ip"))
+	if ip == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ip must be a valid IP address"})
+		return
+	}
+
+	c.JSON(http.StatusOK, h.access.Check(c.Request.Context(), ip))
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PersonalAccessTokenHandler handles the tokens users issue themselves for
+// scripts and browser extensions
+type PersonalAccessTokenHandler struct {
+	tokens *services.PersonalAccessTokenService
+	logger *logrus.Entry
+}
+
+// NewPersonalAccessTokenHandler creates a new personal access token handler
+func NewPersonalAccessTokenHandler(tokens *services.PersonalAccessTokenService, logger *logrus.Entry) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		tokens: tokens,
+		logger: logger,
+	}
+}
+
+// CreatePersonalAccessTokenRequest represents the request body for issuing
+// a personal access token
+type CreatePersonalAccessTokenRequest struct {
+	Name      string     `json:"name" binding:"required,min=1,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // Defaults to 90 days from now
+}
+
+// CreateToken issues a personal access token. The raw token is only shown once.
+func (h *PersonalAccessTokenHandler) CreateToken(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	token, rawToken, err := h.tokens.CreateToken(c.Request.Context(), userID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTokenScopes), errors.Is(err, services.ErrInvalidTokenExpiry):
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  err.Error(),
+				"scopes": services.PersonalAccessTokenScopes,
+			})
+		case errors.Is(err, services.ErrPersonalAccessTokenNameTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.WithError(err).Error("Failed to create personal access token")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create token"})
+		}
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"token_id": token.ID,
+		"scopes":   token.Scopes,
+	}).Info("Personal access token created")
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":        rawToken,
+		"access_token": token,
+	})
+}
+
+// ListTokens lists the caller's personal access tokens
+func (h *PersonalAccessTokenHandler) ListTokens(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	tokens, err := h.tokens.ListTokens(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list personal access tokens")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// RevokeToken revokes one of the caller's personal access tokens
+func (h *PersonalAccessTokenHandler) RevokeToken(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.tokens.RevokeToken(c.Request.Context(), userID, c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrPersonalAccessTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to revoke personal access token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke token"})
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"user_id":  userID,
+		"token_id": c.Param("id"),
+	}).Info("Personal access token revoked")
+
+	c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PinPromptRequest represents the request body for pinning a history entry
+type PinPromptRequest struct {
+	HistoryID string `json:"history_id" binding:"required,uuid"`
+	Name      string `json:"name" binding:"max=100"`
+}
+
+// PinPrompt pins an enhancement the caller may modify. The pin keeps a copy of
+// the result, served unchanged at /pins/:id until the caller re-enhances it.
+func (h *HistoryHandler) PinPrompt(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	var req PinPromptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	entry, err := h.deps.History.GetPromptHistory(c.Request.Context(), req.HistoryID)
+	if err != nil {
+		if errors.Is(err, services.ErrPromptHistoryNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "history item not found"})
+			return
+		}
+		logger.WithError(err).Error("Failed to get prompt history item")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve history item"})
+		return
+	}
+	if !authorizeHistory(c, h.deps.Authz, entry, services.PromptActionModify, logger) {
+		return
+	}
+
+	pin, err := h.deps.Pins.CreatePin(c.Request.Context(), rc.UserID, req.Name, services.PinnedResultFromHistory(entry))
+	if err != nil {
+		if errors.Is(err, services.ErrAlreadyPinned) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrPinsUnavailable) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		logger.WithError(err).Error("Failed to pin prompt")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to pin prompt"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"pin": pin})
+}
+
+// ListPins lists the caller's pins without their results
+func (h *HistoryHandler) ListPins(c *gin.Context) {
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	pins, err := h.deps.Pins.ListPins(c.Request.Context(), rc.UserID)
+	if err != nil {
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Error("Failed to list pins")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve pins"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pins": pins})
+}
+
+// GetPin returns a pin with its current version. This is the pin's stable
+// URL: it only changes when the owner re-enhances, so clients revalidate
+// with the ETag.
+func (h *HistoryHandler) GetPin(c *gin.Context) {
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	pin, err := h.deps.Pins.GetPin(c.Request.Context(), rc.UserID, c.Param("id"))
+	if err != nil {
+		h.respondPinError(c, err, "Failed to get pin")
+		return
+	}
+
+	if pinNotModified(c, pin.ID, pin.Version, "private, no-cache") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"pin": pin})
+}
+
+// GetPinVersion returns one version of a pin. Versions never change, so
+// they may be cached for good.
+func (h *HistoryHandler) GetPinVersion(c *gin.Context) {
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "version must be a positive integer"})
+		return
+	}
+
+	result, err := h.deps.Pins.GetVersion(c.Request.Context(), rc.UserID, c.Param("id"), version)
+	if err != nil {
+		h.respondPinError(c, err, "Failed to get pin version")
+		return
+	}
+
+	if pinNotModified(c, c.Param("id"), result.Version, "private, max-age=31536000, immutable") {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// ReenhancePin runs the pipeline again on a pin's original text and makes
+// the result the pin's next version. Earlier versions stay available.
+func (h *HistoryHandler) ReenhancePin(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	pin, err := h.deps.Pins.GetPin(c.Request.Context(), rc.UserID, c.Param("id"))
+	if err != nil {
+		h.respondPinError(c, err, "Failed to get pin")
+		return
+	}
+
+	result, err := runEnhancement(c.Request.Context(), h.deps, logger, EnhanceRequest{
+		Text: pin.Result.OriginalText,
+	}, enhanceOptions{
+		Request: rc,
+	})
+	if err != nil {
+		respondPipelineError(c, err)
+		return
+	}
+
+	versions, _ := result.Metadata["versions"].(services.PipelineVersions)
+	pin, err = h.deps.Pins.AddVersion(c.Request.Context(), rc.UserID, pin.ID, services.PinnedResult{
+		HistoryID:    result.ID,
+		OriginalText: result.OriginalText,
+		EnhancedText: result.EnhancedText,
+		Intent:       result.Intent,
+		Complexity:   result.Complexity,
+		Techniques:   result.TechniquesUsed,
+		Versions:     versions,
+	})
+	if err != nil {
+		h.respondPinError(c, err, "Failed to add pin version")
+		return
+	}
+
+	logger.WithFields(logrus.Fields{
+		"pin_id":  pin.ID,
+		"version": pin.Version,
+	}).Info("Pin re-enhanced")
+
+	c.JSON(http.StatusCreated, gin.H{"pin": pin})
+}
+
+// UnpinPrompt deletes a pin and all its versions. The history entries they
+// were made from stay.
+func (h *HistoryHandler) UnpinPrompt(c *gin.Context) {
+	rc, ok := h.pinsCaller(c)
+	if !ok {
+		return
+	}
+
+	if err := h.deps.Pins.DeletePin(c.Request.Context(), rc.UserID, c.Param("id")); err != nil {
+		h.respondPinError(c, err, "Failed to delete pin")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "unpinned"})
+}
+
+// pinsCaller returns the signed-in caller, writing the error response and
+// returning false when there is none or pinning isn't available
+func (h *HistoryHandler) pinsCaller(c *gin.Context) (*services.RequestContext, bool) {
+	rc := middleware.GetRequestContext(c)
+	if !rc.Authenticated() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return nil, false
+	}
+	if h.deps.Pins == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "pinning is not available"})
+		return nil, false
+	}
+	return rc, true
+}
+
+func (h *HistoryHandler) respondPinError(c *gin.Context, err error, message string) {
+	if errors.Is(err, services.ErrPinNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrPinsUnavailable) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.MustGet("logger").(*logrus.Entry).WithError(err).Error(message)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve pin"})
+}
+
+// pinNotModified sets a pin version's caching headers and answers 304 when
+// the client already has it
+func pinNotModified(c *gin.Context, pinID string, version int, cacheControl string) bool {
+	etag := `"` + pinID + "-" + strconv.Itoa(version) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cacheControl)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// markPinned flags the caller's history entries that are pin versions with
+// metadata.pin. Flags are best effort; entries are returned without them
+// when pins can't be read.
+func (h *HistoryHandler) markPinned(c *gin.Context, userID string, entries ...*models.PromptHistory) {
+	if h.deps.Pins == nil || len(entries) == 0 {
+		return
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	refs, err := h.deps.Pins.PinnedHistory(c.Request.Context(), userID, ids)
+	if err != nil {
+		c.MustGet("logger").(*logrus.Entry).WithError(err).Warn("Failed to flag pinned history")
+		return
+	}
+	for _, entry := range entries {
+		if ref, ok := refs[entry.ID]; ok {
+			if entry.Metadata == nil {
+				entry.Metadata = map[string]interface{}{}
+			}
+			entry.Metadata["pin"] = ref
+		}
+	}
+}
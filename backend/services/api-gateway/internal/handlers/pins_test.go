@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pinHistory is an in-memory HistoryStore
+type pinHistory struct {
+	entries map[string]*models.PromptHistory
+	saved   int
+}
+
+func (h *pinHistory) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	entry, ok := h.entries[id]
+	if !ok {
+		return nil, services.ErrPromptHistoryNotFound
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (h *pinHistory) SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error) {
+	h.saved++
+	entry.ID = fmt.Sprintf("00000000-0000-0000-0000-%012d", h.saved)
+	h.entries[entry.ID] = &entry
+	return entry.ID, nil
+}
+
+func (h *pinHistory) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	return nil, 0, nil
+}
+
+func (h *pinHistory) DeletePromptHistory(ctx context.Context, id string) error {
+	delete(h.entries, id)
+	return nil
+}
+
+// memoryPins is an in-memory Pins
+type memoryPins struct {
+	pins     map[string]*services.Pin
+	versions map[string][]services.PinnedResult
+}
+
+func (m *memoryPins) CreatePin(ctx context.Context, userID, name string, result services.PinnedResult) (*services.Pin, error) {
+	for _, versions := range m.versions {
+		for _, v := range versions {
+			if v.HistoryID == result.HistoryID {
+				return nil, services.ErrAlreadyPinned
+			}
+		}
+	}
+	pin := &services.Pin{ID: fmt.Sprintf("pin-%d", len(m.pins)+1), UserID: userID, Name: name}
+	m.pins[pin.ID] = pin
+	return m.AddVersion(ctx, userID, pin.ID, result)
+}
+
+func (m *memoryPins) AddVersion(ctx context.Context, userID, pinID string, result services.PinnedResult) (*services.Pin, error) {
+	pin, ok := m.pins[pinID]
+	if !ok || pin.UserID != userID {
+		return nil, services.ErrPinNotFound
+	}
+	pin.Version++
+	result.Version = pin.Version
+	m.versions[pinID] = append(m.versions[pinID], result)
+	copied := *pin
+	copied.Result = &result
+	return &copied, nil
+}
+
+func (m *memoryPins) GetPin(ctx context.Context, userID, pinID string) (*services.Pin, error) {
+	pin, ok := m.pins[pinID]
+	if !ok || pin.UserID != userID {
+		return nil, services.ErrPinNotFound
+	}
+	copied := *pin
+	copied.Result = &m.versions[pinID][pin.Version-1]
+	return &copied, nil
+}
+
+func (m *memoryPins) GetVersion(ctx context.Context, userID, pinID string, version int) (*services.PinnedResult, error) {
+	pin, ok := m.pins[pinID]
+	if !ok || pin.UserID != userID || version > pin.Version {
+		return nil, services.ErrPinNotFound
+	}
+	return &m.versions[pinID][version-1], nil
+}
+
+func (m *memoryPins) ListPins(ctx context.Context, userID string) ([]*services.Pin, error) {
+	pins := []*services.Pin{}
+	for _, pin := range m.pins {
+		if pin.UserID == userID {
+			pins = append(pins, pin)
+		}
+	}
+	return pins, nil
+}
+
+func (m *memoryPins) DeletePin(ctx context.Context, userID, pinID string) error {
+	if pin, ok := m.pins[pinID]; !ok || pin.UserID != userID {
+		return services.ErrPinNotFound
+	}
+	delete(m.pins, pinID)
+	delete(m.versions, pinID)
+	return nil
+}
+
+func (m *memoryPins) PinnedHistory(ctx context.Context, userID string, historyIDs []string) (map[string]services.PinRef, error) {
+	refs := map[string]services.PinRef{}
+	for pinID, versions := range m.versions {
+		if m.pins[pinID].UserID != userID {
+			continue
+		}
+		for _, v := range versions {
+			for _, id := range historyIDs {
+				if v.HistoryID == id {
+					refs[id] = services.PinRef{PinID: pinID, Version: v.Version}
+				}
+			}
+		}
+	}
+	return refs, nil
+}
+
+func TestPins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	const owned = "00000000-0000-0000-0000-00000000000a"
+	const others = "00000000-0000-0000-0000-00000000000b"
+	history := &pinHistory{entries: map[string]*models.PromptHistory{
+		owned: {
+			ID:             owned,
+			UserID:         sql.NullString{String: "user-1", Valid: true},
+			OriginalInput:  "explain recursion",
+			EnhancedOutput: "pinned output",
+			TechniquesUsed: []string{"chain_of_thought"},
+			Metadata:       map[string]interface{}{"model_version": "v0"},
+			CreatedAt:      time.Now(),
+		},
+		others: {
+			ID:     others,
+			UserID: sql.NullString{String: "user-2", Valid: true},
+		},
+	}}
+	h := NewHistoryHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  stubGenerator{},
+		History:    history,
+		Pins:       &memoryPins{pins: map[string]*services.Pin{}, versions: map[string][]services.PinnedResult{}},
+	})
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+		c.Set("user_id", "user-1")
+	})
+	router.POST("/pins", h.PinPrompt)
+	router.GET("/pins/:id", h.GetPin)
+	router.GET("/pins/:id/versions/:version", h.GetPinVersion)
+	router.POST("/pins/:id/versions", h.ReenhancePin)
+	router.GET("/history/:id", h.GetPromptHistoryItem)
+	router.DELETE("/history/:id", h.DeletePromptHistoryItem)
+
+	do := func(method, path, body string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for i := 0; i < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	type pinResponse struct {
+		Pin services.Pin `json:"pin"`
+	}
+
+	w := do(http.MethodPost, "/pins", `{"history_id":"`+others+`"}`)
+	assert.Equal(t, http.StatusForbidden, w.Code, "other users' history can't be pinned")
+
+	w = do(http.MethodPost, "/pins", `{"history_id":"00000000-0000-0000-0000-00000000dead"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodPost, "/pins", `{"history_id":"`+owned+`","name":"support bot"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created pinResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	pinID := created.Pin.ID
+	assert.Equal(t, 1, created.Pin.Version)
+	assert.Equal(t, "pinned output", created.Pin.Result.EnhancedText)
+	assert.Equal(t, "v0", created.Pin.Result.Versions.Model)
+
+	w = do(http.MethodPost, "/pins", `{"history_id":"`+owned+`"}`)
+	assert.Equal(t, http.StatusConflict, w.Code, "an entry is pinned once")
+
+	t.Run("the history entry is flagged and can't be deleted", func(t *testing.T) {
+		w := do(http.MethodGet, "/history/"+owned, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"pin":{"id":"`+pinID+`","version":1}`)
+
+		w = do(http.MethodDelete, "/history/"+owned, "")
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Contains(t, history.entries, owned)
+	})
+
+	t.Run("the stable URL revalidates with its ETag", func(t *testing.T) {
+		w := do(http.MethodGet, "/pins/"+pinID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		etag := w.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+		assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+
+		w = do(http.MethodGet, "/pins/"+pinID, "", "If-None-Match", etag)
+		assert.Equal(t, http.StatusNotModified, w.Code)
+	})
+
+	t.Run("re-enhancing adds a version", func(t *testing.T) {
+		w := do(http.MethodPost, "/pins/"+pinID+"/versions", "")
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		var updated pinResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.Equal(t, 2, updated.Pin.Version)
+		assert.Equal(t, "enhanced explain recursion", updated.Pin.Result.EnhancedText)
+		assert.Equal(t, "v1", updated.Pin.Result.Versions.Model)
+		assert.NotEmpty(t, updated.Pin.Result.HistoryID, "the re-enhancement is in history too")
+
+		w = do(http.MethodGet, "/pins/"+pinID+"/versions/1", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "pinned output", "earlier versions don't change")
+		assert.Contains(t, w.Header().Get("Cache-Control"), "immutable")
+
+		w = do(http.MethodGet, "/pins/"+pinID+"/versions/3", "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("pinning is unavailable without a pin store", func(t *testing.T) {
+		h := NewHistoryHandler(&Dependencies{History: history})
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set("logger", logrus.NewEntry(logger))
+			c.Set("user_id", "user-1")
+		})
+		router.GET("/pins/:id", h.GetPin)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pins/"+pinID, nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
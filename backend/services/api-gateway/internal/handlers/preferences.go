@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// UpdatePreferencesRequest replaces the caller's preferences
+type UpdatePreferencesRequest struct {
+	Preferences map[string]interface{} `json:"preferences" binding:"required"`
+}
+
+// GetPreferences returns the caller's preferences with their version as the
+// ETag
+func (h *AuthHandler) GetPreferences(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	prefs, err := h.userService.GetPreferences(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get preferences")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+		return
+	}
+
+	c.Header("ETag", versionETag(prefs.Version))
+	c.JSON(http.StatusOK, prefs)
+}
+
+// UpdatePreferences replaces the caller's preferences. With If-Match set to
+// the preferences' ETag, a stale update gets 409 and the current
+// preferences rather than overwriting a newer change.
+func (h *AuthHandler) UpdatePreferences(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	expectedVersion, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	var req UpdatePreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if style, ok := req.Preferences[services.ExplanationStylePreference]; ok {
+		if style, _ := style.(string); !services.ValidExplanationStyle(style) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": services.ExplanationStylePreference + " must be plain or technical",
+			})
+			return
+		}
+	}
+
+	prefs, err := h.userService.UpdatePreferences(c.Request.Context(), userID, req.Preferences, expectedVersion)
+	if errors.Is(err, services.ErrVersionConflict) {
+		current, getErr := h.userService.GetPreferences(c.Request.Context(), userID)
+		if getErr != nil {
+			h.logger.WithError(getErr).Error("Failed to get preferences")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get preferences"})
+			return
+		}
+		c.Header("ETag", versionETag(current.Version))
+		c.JSON(http.StatusConflict, gin.H{
+			"error":   "Preferences were changed by another request",
+			"current": current,
+		})
+		return
+	}
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to update preferences")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
+		return
+	}
+
+	c.Header("ETag", versionETag(prefs.Version))
+	c.JSON(http.StatusOK, prefs)
+}
+
+// versionETag is the strong ETag for a resource version
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion reads the version an update expects from If-Match. No
+// header, or "*", expects nothing and returns zero. An If-Match that isn't a
+// version ETag can never match, so it is answered with 412 and ok is false.
+func ifMatchVersion(c *gin.Context) (version int64, ok bool) {
+	match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if match == "" || match == "*" {
+		return 0, true
+	}
+
+	// Weak tags aren't valid for If-Match, but some clients echo the ETag
+	// back through caches that weakened it
+	tag := strings.TrimPrefix(match, "W/")
+	if len(tag) >= 2 && tag[0] == '"' && tag[len(tag)-1] == '"' {
+		if v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64); err == nil && v > 0 {
+			return v, true
+		}
+	}
+
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error": "If-Match must be a single ETag returned for this resource",
+	})
+	return 0, false
+}
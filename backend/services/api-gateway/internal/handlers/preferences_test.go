@@ -0,0 +1,35 @@
+package handlers_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdatePreferencesRejectsForeignIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := handlers.NewAuthHandler(nil, nil, nil, nil, logrus.New())
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", "user-1") })
+	router.PUT("/preferences", handler.UpdatePreferences)
+	router.PUT("/auth/profile", handler.UpdateProfile)
+
+	for _, match := range []string{`"abc"`, `3`, `"1", "2"`, `"0"`} {
+		for _, path := range []string{"/preferences", "/auth/profile"} {
+			req := httptest.NewRequest(http.MethodPut, path, bytes.NewBufferString(`{"preferences":{}}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", match)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusPreconditionFailed, rec.Code, "%s If-Match %s", path, match)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// authorizeHistory checks the caller may act on a history entry. It writes
+// the error response and returns false when not.
+func authorizeHistory(c *gin.Context, authz *services.PromptAuthorizer, entry *models.PromptHistory, action services.PromptAction, logger *logrus.Entry) bool {
+	if authz == nil {
+		authz = services.NewPromptAuthorizer(nil)
+	}
+	_, err := authz.Authorize(c.Request.Context(), middleware.GetRequestContext(c), services.HistoryResource(entry), action, promptShareToken(c))
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, services.ErrPromptAccessDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return false
+	}
+	logger.WithError(err).Error("Failed to authorize prompt access")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authorize request"})
+	return false
+}
+
+// authorizeSavedPrompt checks the caller may act on a saved prompt, writing
+// the error response and returning false when not. Share tokens don't count:
+// they open a public prompt, not its discussion or editing sessions.
+func authorizeSavedPrompt(c *gin.Context, authz *services.PromptAuthorizer, saved *models.SavedPrompt, action services.PromptAction, logger *logrus.Entry) bool {
+	if authz == nil {
+		authz = services.NewPromptAuthorizer(nil)
+	}
+	_, err := authz.Authorize(c.Request.Context(), middleware.GetRequestContext(c), services.SavedPromptResource(saved), action, "")
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, services.ErrPromptAccessDenied) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+		return false
+	}
+	logger.WithError(err).Error("Failed to authorize prompt access")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authorize request"})
+	return false
+}
+
+// loadSavedPrompt loads the saved prompt in the id path parameter, writing
+// the error response and returning false when it can't
+func loadSavedPrompt(c *gin.Context, prompts SavedPromptStore, logger *logrus.Entry) (*models.SavedPrompt, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrSavedPromptNotFound.Error()})
+		return nil, false
+	}
+
+	saved, err := prompts.GetSavedPrompt(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, services.ErrSavedPromptNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		} else {
+			logger.WithError(err).Error("Failed to load saved prompt")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load saved prompt"})
+		}
+		return nil, false
+	}
+	return saved, true
+}
+
+// promptShareToken is the share token the caller presented, from the
+// X-Share-Token header or the share_token query parameter
+func promptShareToken(c *gin.Context) string {
+	if token := c.GetHeader("X-Share-Token"); token != "" {
+		return token
+	}
+	return c.Query("share_token")
+}
@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// promptStore holds one history entry
+type promptStore struct {
+	services.HistoryStore
+	entry *models.PromptHistory
+}
+
+func (s promptStore) GetPromptHistory(_ context.Context, id string) (*models.PromptHistory, error) {
+	if id != s.entry.ID {
+		return nil, services.ErrPromptHistoryNotFound
+	}
+	return s.entry, nil
+}
+
+func (s promptStore) SavePromptHistory(context.Context, models.PromptHistory) (string, error) {
+	return "history-2", nil
+}
+
+func (s promptStore) DeletePromptHistory(context.Context, string) error { return nil }
+
+// TestPromptRouteAuthorization runs every route serving a single prompt
+// against each kind of caller
+func TestPromptRouteAuthorization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	generator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"feedback-1","prompt_history_id":"history-1"}`))
+	}))
+	defer generator.Close()
+
+	store := promptStore{entry: &models.PromptHistory{
+		ID:               "history-1",
+		UserID:           sql.NullString{String: "owner", Valid: true},
+		OriginalInput:    "why is the sky blue",
+		Intent:           sql.NullString{String: "reasoning", Valid: true},
+		IntentConfidence: sql.NullFloat64{Float64: 0.9, Valid: true},
+	}}
+	deps := &Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  stubGenerator{},
+		History:    store,
+		Authz:      services.NewPromptAuthorizer(nil),
+	}
+	clients := &services.ServiceClients{
+		History:            store,
+		PromptAuthz:        deps.Authz,
+		HTTPClient:         generator.Client(),
+		PromptGeneratorURL: generator.URL,
+	}
+	history := NewHistoryHandler(deps)
+	feedback := NewFeedbackHandler(clients, logger.WithField("test", true))
+
+	callers := map[string]*services.RequestContext{
+		"owner":    {UserID: "owner", Tier: services.TierFree},
+		"stranger": {UserID: "stranger", Tier: services.TierFree},
+		"admin":    {UserID: "admin", Roles: []string{"admin"}, Tier: services.TierFree},
+	}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logger.WithField("test", true))
+		c.Set("request_context", callers[c.GetHeader("X-Caller")])
+		c.Next()
+	})
+	router.GET("/prompts/:id", history.GetPromptByID)
+	router.POST("/prompts/:id/rerun", history.RerunPrompt)
+	router.GET("/prompts/:id/export", history.ExportPrompt)
+	router.GET("/history/:id", history.GetPromptHistoryItem)
+	router.DELETE("/history/:id", history.DeletePromptHistoryItem)
+	router.POST("/feedback", feedback.SubmitFeedback)
+	router.GET("/feedback/:prompt_history_id", feedback.GetFeedback)
+
+	routes := []struct {
+		method, path, body string
+		allowed            []string // Callers let through; the rest get 403
+	}{
+		{http.MethodGet, "/prompts/history-1", "", []string{"owner", "admin"}},
+		{http.MethodPost, "/prompts/history-1/rerun", "", []string{"owner", "admin"}},
+		{http.MethodGet, "/prompts/history-1/export", "", []string{"owner", "admin"}},
+		{http.MethodGet, "/history/history-1", "", []string{"owner", "admin"}},
+		{http.MethodDelete, "/history/history-1", "", []string{"owner", "admin"}},
+		{http.MethodPost, "/feedback", `{"prompt_history_id":"history-1","rating":4}`, []string{"owner"}},
+		{http.MethodGet, "/feedback/history-1", "", []string{"owner", "admin"}},
+	}
+	for _, route := range routes {
+		for caller := range callers {
+			t.Run(route.method+" "+route.path+" as "+caller, func(t *testing.T) {
+				req := httptest.NewRequest(route.method, route.path, strings.NewReader(route.body))
+				req.Header.Set("Content-Type", "application/json")
+				req.Header.Set("X-Caller", caller)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				allowed := false
+				for _, a := range route.allowed {
+					allowed = allowed || a == caller
+				}
+				if allowed {
+					assert.Less(t, w.Code, 300, w.Body.String())
+				} else {
+					assert.Equal(t, http.StatusForbidden, w.Code)
+				}
+			})
+		}
+	}
+
+	t.Run("missing prompts are not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/history/history-9", nil)
+		req.Header.Set("X-Caller", "owner")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
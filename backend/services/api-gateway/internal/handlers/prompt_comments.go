@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// PromptCommentRequest is the body of a new or edited comment
+type PromptCommentRequest struct {
+	Body     string `json:"body" binding:"required,min=1,max=5000"`
+	ParentID string `json:"parent_id" binding:"omitempty,uuid"`
+}
+
+// CommentModerationRequest releases or removes a comment
+type CommentModerationRequest struct {
+	Status string `json:"status" binding:"required,oneof=visible removed"`
+	Reason string `json:"reason" binding:"max=1000"`
+}
+
+// PromptCommentHandler serves the discussion on saved prompts
+type PromptCommentHandler struct {
+	comments *services.PromptCommentService
+	authz    *services.PromptAuthorizer
+	logger   *logrus.Entry
+}
+
+// NewPromptCommentHandler creates a new prompt comment handler
+func NewPromptCommentHandler(comments *services.PromptCommentService, authz *services.PromptAuthorizer, logger *logrus.Entry) *PromptCommentHandler {
+	return &PromptCommentHandler{
+		comments: comments,
+		authz:    authz,
+		logger:   logger,
+	}
+}
+
+// ListComments returns the comment threads on a saved prompt
+func (h *PromptCommentHandler) ListComments(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c, services.PromptActionRead)
+	if !ok {
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	comments, err := h.comments.List(c.Request.Context(), prompt.ID, rc.UserID, slices.Contains(rc.Roles, "admin"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list comments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list comments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"comments": comments})
+}
+
+// CreateComment posts a comment or a reply on a saved prompt
+func (h *PromptCommentHandler) CreateComment(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c, services.PromptActionComment)
+	if !ok {
+		return
+	}
+
+	var req PromptCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	comment, err := h.comments.Create(c.Request.Context(), prompt, userID, req.ParentID, req.Body)
+	if err != nil {
+		h.respondError(c, err, "Failed to create comment")
+		return
+	}
+	h.flagHeld(c, comment)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// UpdateComment edits the caller's comment
+func (h *PromptCommentHandler) UpdateComment(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c, services.PromptActionComment)
+	if !ok {
+		return
+	}
+	commentID, ok := commentParam(c, "comment_id")
+	if !ok {
+		return
+	}
+
+	var req PromptCommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	comment, err := h.comments.Edit(c.Request.Context(), prompt, commentID, userID, req.Body)
+	if err != nil {
+		h.respondError(c, err, "Failed to edit comment")
+		return
+	}
+	h.flagHeld(c, comment)
+	c.JSON(http.StatusOK, comment)
+}
+
+// DeleteComment deletes a comment. Authors delete their own; the prompt's
+// owner and admins delete any.
+func (h *PromptCommentHandler) DeleteComment(c *gin.Context) {
+	prompt, ok := h.authorizedPrompt(c, services.PromptActionRead)
+	if !ok {
+		return
+	}
+	commentID, ok := commentParam(c, "comment_id")
+	if !ok {
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	_, err := h.authz.Authorize(c.Request.Context(), rc, services.SavedPromptResource(prompt), services.PromptActionDelete, "")
+	if err != nil && !errors.Is(err, services.ErrPromptAccessDenied) {
+		h.logger.WithError(err).Error("Failed to authorize comment deletion")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authorize request"})
+		return
+	}
+	moderator := err == nil
+
+	if err := h.comments.Delete(c.Request.Context(), prompt.ID, commentID, rc.UserID, moderator); err != nil {
+		h.respondError(c, err, "Failed to delete comment")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted"})
+}
+
+// ListHeldComments returns comments waiting for moderation
+func (h *PromptCommentHandler) ListHeldComments(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	comments, err := h.comments.ListHeld(c.Request.Context(), limit, offset)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list held comments")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list comments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"comments": comments,
+		"limit":    limit,
+		"offset":   offset,
+	})
+}
+
+// ModerateComment releases a held comment or removes a comment
+func (h *PromptCommentHandler) ModerateComment(c *gin.Context) {
+	id, ok := commentParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req CommentModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	moderatorID, _ := middleware.GetUserID(c)
+	comment, err := h.comments.Moderate(c.Request.Context(), id, moderatorID, req.Status, req.Reason)
+	if err != nil {
+		h.respondError(c, err, "Failed to moderate comment")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":        true,
+		"moderator_id": moderatorID,
+		"comment_id":   comment.ID,
+		"author_id":    comment.AuthorID,
+		"status":       comment.Status,
+	}).Info("Comment moderated")
+	c.JSON(http.StatusOK, comment)
+}
+
+// authorizedPrompt loads the saved prompt in the path and checks the caller
+// may act on it. It writes the error response and returns false when not.
+func (h *PromptCommentHandler) authorizedPrompt(c *gin.Context, action services.PromptAction) (*models.SavedPrompt, bool) {
+	prompt, ok := loadSavedPrompt(c, h.comments, h.logger)
+	if !ok || !authorizeSavedPrompt(c, h.authz, prompt, action, h.logger) {
+		return nil, false
+	}
+	return prompt, true
+}
+
+// flagHeld tells the abuse guard a comment tripped moderation
+func (h *PromptCommentHandler) flagHeld(c *gin.Context, comment *services.PromptComment) {
+	if comment.Status == services.CommentStatusHeld {
+		c.Set("moderation_flagged", true)
+	}
+}
+
+func (h *PromptCommentHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrCommentNotFound), errors.Is(err, services.ErrCommentParentNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrCommentNotAuthor):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error(message)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process comment"})
+	}
+}
+
+func commentParam(c *gin.Context, param string) (string, bool) {
+	id := c.Param(param)
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrCommentNotFound.Error()})
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PublicStatsHandler serves the anonymized stats shown on the marketing site
+type PublicStatsHandler struct {
+	stats  *services.PublicStatsService
+	logger *logrus.Entry
+}
+
+// NewPublicStatsHandler creates a new public stats handler
+func NewPublicStatsHandler(stats *services.PublicStatsService, logger *logrus.Entry) *PublicStatsHandler {
+	return &PublicStatsHandler{
+		stats:  stats,
+		logger: logger,
+	}
+}
+
+// GetPublicStats returns the latest daily public stats
+func (h *PublicStatsHandler) GetPublicStats(c *gin.Context) {
+	stats, err := h.stats.Get(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, services.ErrPublicStatsUnavailable) {
+			c.Header("Retry-After", "3600")
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to get public stats")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get public stats"})
+		return
+	}
+
+	// Stats change once a day; let CDNs and the marketing site cache them
+	c.Header("Cache-Control", "public, max-age=3600, stale-while-revalidate=86400")
+	c.JSON(http.StatusOK, stats)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// QuickEnhanceRequest is the minimal request sent by browser extensions
+type QuickEnhanceRequest struct {
+	Text              string   `json:"text" binding:"required,min=1,max=5000"`
+	PreferTechniques  []string `json:"prefer_techniques,omitempty"`
+	ExcludeTechniques []string `json:"exclude_techniques,omitempty"`
+}
+
+// QuickEnhanceResponse carries only what an extension needs to replace the user's text
+type QuickEnhanceResponse struct {
+	EnhancedText string   `json:"enhanced_text"`
+	Techniques   []string `json:"techniques"`
+}
+
+// cachedQuickEnhancement is a cached quick enhancement and when it was generated
+type cachedQuickEnhancement struct {
+	QuickEnhanceResponse
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// quickEnhanceCaching controls how long quick enhancements are served from
+// cache. Entries older than FreshFor are still served, but regenerated in
+// the background; entries are dropped after MaxAge, so nothing older is
+// ever served.
+type quickEnhanceCaching struct {
+	FreshFor time.Duration
+	MaxAge   time.Duration
+}
+
+// loadQuickEnhanceCaching reads QUICK_ENHANCE_CACHE_FRESH_FOR (default 10m)
+// and QUICK_ENHANCE_CACHE_MAX_AGE (default 1h)
+func loadQuickEnhanceCaching() quickEnhanceCaching {
+	caching := quickEnhanceCaching{
+		FreshFor: 10 * time.Minute,
+		MaxAge:   1 * time.Hour,
+	}
+	if d, err := time.ParseDuration(os.Getenv("QUICK_ENHANCE_CACHE_FRESH_FOR")); err == nil && d > 0 {
+		caching.FreshFor = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("QUICK_ENHANCE_CACHE_MAX_AGE")); err == nil && d > 0 {
+		caching.MaxAge = d
+	}
+	return caching
+}
+
+// QuickEnhance is a lightweight enhancement endpoint for browser extensions.
+// Results are never written to prompt history and a caller's identical
+// requests are served from cache with an ETag so clients can revalidate
+// cheaply. Cached results past their freshness are served while a fresh one
+// is generated.
+func (h *EnhanceHandler) QuickEnhance(c *gin.Context) {
+	logger := c.MustGet("logger").(*logrus.Entry)
+
+	var req QuickEnhanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	// Enhancements are personalised by tier and user, so cached ones are
+	// only served back to the same caller
+	rc := middleware.GetRequestContext(c)
+	cacheKey := "quick-" + generateTextHash(strings.Join([]string{
+		rc.Tier,
+		rc.UserID,
+		req.Text,
+		strings.Join(req.PreferTechniques, ","),
+		strings.Join(req.ExcludeTechniques, ","),
+	}, "|"))
+
+	var entry cachedQuickEnhancement
+	cached := h.deps.Cache != nil &&
+		h.deps.Cache.GetCachedEnhancedPrompt(c.Request.Context(), cacheKey, nil, &entry) == nil
+
+	if cached {
+		age := time.Since(entry.GeneratedAt)
+		if age > h.quickCaching.FreshFor {
+			h.revalidateQuickEnhancement(cacheKey, req, rc, logger)
+			c.Header("X-Cache", "STALE")
+		} else {
+			c.Header("X-Cache", "HIT")
+		}
+		if !entry.GeneratedAt.IsZero() {
+			c.Header("Age", strconv.Itoa(int(age.Seconds())))
+		}
+	} else {
+		result, err := h.generateQuickEnhancement(c.Request.Context(), cacheKey, req, rc, logger, &entry)
+		if err != nil {
+			respondPipelineError(c, err)
+			return
+		}
+		markModerationFlag(c, result)
+		publishEnhancement(c, result, "extension")
+		c.Header("X-Cache", "MISS")
+	}
+	response := entry.QuickEnhanceResponse
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to encode response",
+		})
+		return
+	}
+
+	etag := quickEnhanceETag(body)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+
+	if match := c.GetHeader("If-None-Match"); match != "" && match == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// quickEnhanceETag derives a strong ETag from the response body
+func quickEnhanceETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:])[:32] + `"`
+}
+
+// generateQuickEnhancement runs the pipeline for a quick enhancement and
+// caches the result in entry
+func (h *EnhanceHandler) generateQuickEnhancement(ctx context.Context, cacheKey string, req QuickEnhanceRequest, rc *services.RequestContext, logger *logrus.Entry, entry *cachedQuickEnhancement) (*EnhanceResponse, error) {
+	result, err := runEnhancement(ctx, h.deps, logger, EnhanceRequest{
+		Text:              req.Text,
+		PreferTechniques:  req.PreferTechniques,
+		ExcludeTechniques: req.ExcludeTechniques,
+	}, enhanceOptions{
+		Request:     rc,
+		SkipHistory: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	*entry = cachedQuickEnhancement{
+		QuickEnhanceResponse: QuickEnhanceResponse{
+			EnhancedText: result.EnhancedText,
+			Techniques:   result.TechniquesUsed,
+		},
+		GeneratedAt: time.Now().UTC(),
+	}
+	if h.deps.Cache != nil {
+		if err := h.deps.Cache.CacheEnhancedPrompt(ctx, cacheKey, nil, entry, h.quickCaching.MaxAge); err != nil {
+			logger.WithError(err).Debug("Failed to cache quick enhancement")
+		}
+	}
+	return result, nil
+}
+
+// revalidateQuickEnhancement regenerates a stale cached quick enhancement
+// in the background. Each instance runs at most one regeneration per key.
+func (h *EnhanceHandler) revalidateQuickEnhancement(cacheKey string, req QuickEnhanceRequest, rc *services.RequestContext, logger *logrus.Entry) {
+	if _, running := h.revalidating.LoadOrStore(cacheKey, struct{}{}); running {
+		return
+	}
+
+	h.revalidations.Add(1)
+	go func() {
+		defer h.revalidations.Done()
+		defer h.revalidating.Delete(cacheKey)
+
+		ctx, cancel := context.WithTimeout(services.WithRequestContext(context.Background(), rc), h.timeouts.Hard)
+		defer cancel()
+
+		var entry cachedQuickEnhancement
+		if _, err := h.generateQuickEnhancement(ctx, cacheKey, req, rc, logger, &entry); err != nil {
+			// The stale entry keeps being served until it expires
+			logger.WithError(err).Warn("Failed to revalidate quick enhancement")
+			return
+		}
+		logger.WithField("cache_key", cacheKey).Debug("Revalidated quick enhancement")
+	}()
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// enhancementCache is a memoryCache that also holds enhanced prompts
+type enhancementCache struct {
+	memoryCache
+	mu       sync.Mutex
+	enhanced map[string][]byte
+}
+
+func (m *enhancementCache) GetCachedEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.enhanced[textHash]
+	if !ok {
+		return errors.New("cache miss")
+	}
+	return json.Unmarshal(data, result)
+}
+
+func (m *enhancementCache) CacheEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enhanced[textHash] = data
+	return nil
+}
+
+// numberingGenerator numbers its enhancements so regenerations show
+type numberingGenerator struct{ calls atomic.Int32 }
+
+func (g *numberingGenerator) GeneratePrompt(ctx context.Context, req models.PromptGenerationRequest) (*models.PromptGenerationResponse, error) {
+	n := g.calls.Add(1)
+	return &models.PromptGenerationResponse{Text: fmt.Sprintf("enhanced #%d", n), ModelVersion: "v1"}, nil
+}
+
+func TestQuickEnhanceStaleWhileRevalidate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	cache := &enhancementCache{
+		memoryCache: memoryCache{intents: map[string]*services.IntentClassificationResult{}},
+		enhanced:    map[string][]byte{},
+	}
+	generator := &numberingGenerator{}
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  generator,
+		History:    new(MockDatabase),
+		Cache:      cache,
+	})
+	h.quickCaching = quickEnhanceCaching{FreshFor: time.Minute, MaxAge: time.Hour}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.POST("/quick-enhance", h.QuickEnhance)
+
+	enhance := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/quick-enhance", strings.NewReader(`{"text":"sort a list"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		return rec
+	}
+	// age rewrites the single cached entry as generated that long ago
+	age := func(d time.Duration) {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		for key, data := range cache.enhanced {
+			var entry cachedQuickEnhancement
+			require.NoError(t, json.Unmarshal(data, &entry))
+			entry.GeneratedAt = entry.GeneratedAt.Add(-d)
+			cache.enhanced[key], _ = json.Marshal(entry)
+		}
+	}
+
+	rec := enhance()
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Contains(t, rec.Body.String(), "enhanced #1")
+
+	rec = enhance()
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Contains(t, rec.Body.String(), "enhanced #1")
+	assert.Equal(t, int32(1), generator.calls.Load())
+
+	// A stale entry is served as is while it is regenerated
+	age(2 * time.Minute)
+	rec = enhance()
+	assert.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "120", rec.Header().Get("Age"))
+	assert.Contains(t, rec.Body.String(), "enhanced #1")
+
+	h.revalidations.Wait()
+	assert.Equal(t, int32(2), generator.calls.Load())
+
+	rec = enhance()
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Contains(t, rec.Body.String(), "enhanced #2")
+}
+
+func TestQuickEnhanceCachedPerCaller(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	generator := &numberingGenerator{}
+	h := NewEnhanceHandler(&Dependencies{
+		Classifier: stubClassifier{},
+		Selector:   stubSelector{},
+		Generator:  generator,
+		History:    new(MockDatabase),
+		Cache: &enhancementCache{
+			memoryCache: memoryCache{intents: map[string]*services.IntentClassificationResult{}},
+			enhanced:    map[string][]byte{},
+		},
+	})
+	h.quickCaching = quickEnhanceCaching{FreshFor: time.Minute, MaxAge: time.Hour}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-1")
+		c.Set("logger", logrus.NewEntry(logger))
+		if user := c.GetHeader("X-Test-User"); user != "" {
+			c.Set("user_id", user)
+		}
+		if tier := c.GetHeader("X-Test-Tier"); tier != "" {
+			c.Set("user_tier", tier)
+		}
+	})
+	router.POST("/quick-enhance", h.QuickEnhance)
+
+	enhance := func(user, tier string) string {
+		req := httptest.NewRequest(http.MethodPost, "/quick-enhance", strings.NewReader(`{"text":"sort a list"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-User", user)
+		req.Header.Set("X-Test-Tier", tier)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		return rec.Header().Get("X-Cache")
+	}
+
+	assert.Equal(t, "MISS", enhance("user-1", ""))
+	assert.Equal(t, "HIT", enhance("user-1", ""))
+	assert.Equal(t, "MISS", enhance("user-2", ""), "other users get their own enhancement")
+	assert.Equal(t, "MISS", enhance("user-1", "pro"), "so does the same user on another tier")
+	assert.Equal(t, "MISS", enhance("", ""))
+	assert.Equal(t, int32(4), generator.calls.Load())
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ReferralHandler shows users their referral code and lets admins review
+// the referrals made with codes
+type ReferralHandler struct {
+	referrals *services.ReferralService
+	logger    *logrus.Entry
+}
+
+// NewReferralHandler creates a new referral handler
+func NewReferralHandler(referrals *services.ReferralService, logger *logrus.Entry) *ReferralHandler {
+	return &ReferralHandler{
+		referrals: referrals,
+		logger:    logger,
+	}
+}
+
+// GetReferrals returns the caller's referral code and what it has earned
+func (h *ReferralHandler) GetReferrals(c *gin.Context) {
+	summary, err := h.referrals.Summary(c.Request.Context(), middleware.GetRequestContext(c).UserID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get referrals")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get referrals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// ListReferrals returns the most recent referrals, ?status= rewarded or
+// rejected only, ?limit= at most (default 50, max 200)
+func (h *ReferralHandler) ListReferrals(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != services.ReferralStatusRewarded && status != services.ReferralStatusRejected {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be rewarded or rejected"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	referrals, err := h.referrals.ListReferrals(c.Request.Context(), status, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list referrals")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list referrals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"referrals": referrals})
+}
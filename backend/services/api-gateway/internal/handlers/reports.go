@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ReportHandler lets admins schedule usage reports and download past ones.
+// Admins only see their own organization's schedules and reports; admins
+// without one manage the platform-wide reports.
+type ReportHandler struct {
+	reports *services.ReportService
+	logger  *logrus.Entry
+}
+
+// NewReportHandler creates a new report handler
+func NewReportHandler(reports *services.ReportService, logger *logrus.Entry) *ReportHandler {
+	return &ReportHandler{
+		reports: reports,
+		logger:  logger,
+	}
+}
+
+// ReportScheduleRequest creates or replaces a report schedule
+type ReportScheduleRequest struct {
+	Frequency  string   `json:"frequency" binding:"required,oneof=weekly monthly"`
+	Recipients []string `json:"recipients" binding:"required"`
+	Enabled    *bool    `json:"enabled"` // Defaults to true
+}
+
+// ListReports returns the organization's reports, ?limit= at most
+// (default 50, max 200)
+func (h *ReportHandler) ListReports(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	orgID := middleware.GetRequestContext(c).OrgID
+	reports, err := h.reports.ListReports(c.Request.Context(), orgID, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list reports")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list reports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reports": reports})
+}
+
+// GetReport returns one report
+func (h *ReportHandler) GetReport(c *gin.Context) {
+	report, ok := h.report(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// DownloadReport redirects to a short-lived link to a report's ?file=, csv
+// (the default) or summary
+func (h *ReportHandler) DownloadReport(c *gin.Context) {
+	file := c.DefaultQuery("file", services.ReportFileCSV)
+	if file != services.ReportFileCSV && file != services.ReportFileSummary {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file must be csv or summary"})
+		return
+	}
+
+	report, ok := h.report(c)
+	if !ok {
+		return
+	}
+	url, err := h.reports.DownloadURL(c.Request.Context(), report, file)
+	if err != nil {
+		if errors.Is(err, services.ErrReportNotReady) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": report.Status})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to sign report download")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to download report"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, url)
+}
+
+// report loads the :id report of the caller's organization, answering
+// when it can't
+func (h *ReportHandler) report(c *gin.Context) (*services.Report, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrReportNotFound.Error()})
+		return nil, false
+	}
+
+	report, err := h.reports.GetReport(c.Request.Context(), middleware.GetRequestContext(c).OrgID, id)
+	if err != nil {
+		if errors.Is(err, services.ErrReportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		h.logger.WithError(err).Error("Failed to get report")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get report"})
+		return nil, false
+	}
+	return report, true
+}
+
+// ListSchedules returns the organization's report schedules
+func (h *ReportHandler) ListSchedules(c *gin.Context) {
+	schedules, err := h.reports.ListSchedules(c.Request.Context(), middleware.GetRequestContext(c).OrgID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list report schedules")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list report schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+// CreateSchedule subscribes recipients to the organization's weekly or
+// monthly report
+func (h *ReportHandler) CreateSchedule(c *gin.Context) {
+	schedule, ok := bindReportSchedule(c)
+	if !ok {
+		return
+	}
+
+	rc := middleware.GetRequestContext(c)
+	schedule.OrgID = rc.OrgID
+	schedule.CreatedBy = rc.UserID
+	created, err := h.reports.CreateSchedule(c.Request.Context(), schedule)
+	if err != nil {
+		h.respondError(c, err, "failed to create report schedule")
+		return
+	}
+
+	h.audit(c, created.ID).WithField("frequency", created.Frequency).Info("Report schedule created")
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdateSchedule replaces a report schedule
+func (h *ReportHandler) UpdateSchedule(c *gin.Context) {
+	schedule, ok := bindReportSchedule(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrReportScheduleNotFound.Error()})
+		return
+	}
+	updated, err := h.reports.UpdateSchedule(c.Request.Context(), middleware.GetRequestContext(c).OrgID, id, schedule)
+	if err != nil {
+		h.respondError(c, err, "failed to update report schedule")
+		return
+	}
+
+	h.audit(c, id).WithField("enabled", updated.Enabled).Info("Report schedule updated")
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSchedule stops a report. Reports already generated are kept.
+func (h *ReportHandler) DeleteSchedule(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrReportScheduleNotFound.Error()})
+		return
+	}
+	if err := h.reports.DeleteSchedule(c.Request.Context(), middleware.GetRequestContext(c).OrgID, id); err != nil {
+		h.respondError(c, err, "failed to delete report schedule")
+		return
+	}
+
+	h.audit(c, id).Info("Report schedule deleted")
+	c.Status(http.StatusNoContent)
+}
+
+func (h *ReportHandler) audit(c *gin.Context, scheduleID string) *logrus.Entry {
+	rc := middleware.GetRequestContext(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":       true,
+		"admin_id":    rc.UserID,
+		"org_id":      rc.OrgID,
+		"schedule_id": scheduleID,
+	})
+}
+
+func (h *ReportHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrReportScheduleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrReportScheduleExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Report schedule operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// bindReportSchedule reads a schedule from the request body, answering 400
+// when it is invalid
+func bindReportSchedule(c *gin.Context) (services.ReportSchedule, bool) {
+	var req ReportScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.ReportSchedule{}, false
+	}
+
+	recipients, err := services.NormalizeReportRecipients(req.Recipients)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.ReportSchedule{}, false
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	return services.ReportSchedule{
+		Frequency:  req.Frequency,
+		Recipients: recipients,
+		Enabled:    enabled,
+	}, true
+}
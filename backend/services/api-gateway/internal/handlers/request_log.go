@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// RequestLogHandler lets admins search the gateway's recent requests
+type RequestLogHandler struct {
+	requestLog *services.RequestLogService
+	logger     *logrus.Entry
+}
+
+// NewRequestLogHandler creates a new request log handler
+func NewRequestLogHandler(requestLog *services.RequestLogService, logger *logrus.Entry) *RequestLogHandler {
+	return &RequestLogHandler{
+		requestLog: requestLog,
+		logger:     logger,
+	}
+}
+
+// SearchRequests returns the requests matching the query, newest first.
+// It filters on ?user_id=, ?request_id=, ?trace_id=, ?status= (a code such
+// as 503 or a class such as 5xx), ?path= (a path prefix),
+// ?min_latency_ms=, ?max_latency_ms= and ?from= and ?to= (RFC 3339).
+// ?limit= sizes the page and ?before= takes the previous page's
+// next_before. Only requests within the retention are ever returned.
+func (h *RequestLogHandler) SearchRequests(c *gin.Context) {
+	if h.requestLog == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "request log is not available"})
+		return
+	}
+
+	query, err := parseRequestLogQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.requestLog.Search(c.Request.Context(), query)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRequestLogQuery) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to search request log")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to search request log"})
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": adminID,
+		"user_id":  query.UserID,
+		"results":  len(page.Records),
+	}).Info("Request log searched")
+
+	c.JSON(http.StatusOK, page)
+}
+
+func parseRequestLogQuery(c *gin.Context) (services.RequestLogQuery, error) {
+	query := services.RequestLogQuery{
+		UserID:     c.Query("user_id"),
+		RequestID:  c.Query("request_id"),
+		TraceID:    strings.ToLower(c.Query("trace_id")),
+		PathPrefix: c.Query("path"),
+	}
+	if query.UserID != "" {
+		if _, err := uuid.Parse(query.UserID); err != nil {
+			return query, errors.New("invalid user ID")
+		}
+	}
+
+	if status := strings.ToLower(c.Query("status")); status != "" {
+		if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+			query.StatusClass = int(status[0] - '0')
+		} else if code, err := strconv.Atoi(status); err == nil && code >= 100 && code <= 599 {
+			query.Status = code
+		} else {
+			return query, errors.New("status must be an HTTP status code or class such as 5xx")
+		}
+	}
+
+	for name, bound := range map[string]*time.Duration{"min_latency_ms": &query.MinLatency, "max_latency_ms": &query.MaxLatency} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		ms, err := strconv.Atoi(value)
+		if err != nil || ms < 0 {
+			return query, errors.New(name + " must be a non-negative integer")
+		}
+		*bound = time.Duration(ms) * time.Millisecond
+	}
+
+	for name, bound := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, errors.New(name + " must be an RFC 3339 timestamp")
+		}
+		*bound = parsed.UTC()
+	}
+
+	if value := c.Query("before"); value != "" {
+		before, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || before <= 0 {
+			return query, errors.New("before must be a positive integer")
+		}
+		query.Before = before
+	}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > services.MaxRequestLogResults {
+			return query, errors.New("limit must be between 1 and " + strconv.Itoa(services.MaxRequestLogResults))
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// ResidencyHandler lets organization admins choose the region their
+// organization's prompt history is kept in
+type ResidencyHandler struct {
+	residency *services.ResidencyService
+	history   services.HistoryStore // Checked for existing history before moving
+	logger    *logrus.Entry
+}
+
+// NewResidencyHandler creates a new residency handler
+func NewResidencyHandler(residency *services.ResidencyService, history services.HistoryStore, logger *logrus.Entry) *ResidencyHandler {
+	return &ResidencyHandler{
+		residency: residency,
+		history:   history,
+		logger:    logger,
+	}
+}
+
+// ResidencyRequest pins an organization's data to a region
+type ResidencyRequest struct {
+	Region string `json:"region" binding:"required"`
+}
+
+// GetResidency returns the organization's region and the regions available.
+// Admins without an organization get the report of every pinned one.
+func (h *ResidencyHandler) GetResidency(c *gin.Context) {
+	orgID := middleware.GetRequestContext(c).OrgID
+	if orgID == "" {
+		report, err := h.residency.Report(c.Request.Context())
+		if err != nil {
+			h.respondError(c, err, "failed to get data residency")
+			return
+		}
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	residency, err := h.residency.Get(c.Request.Context(), orgID)
+	if err != nil {
+		h.respondError(c, err, "failed to get data residency")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"residency":         residency,
+		"available_regions": h.residency.AvailableRegions(),
+	})
+}
+
+// SetResidency pins the organization's data to a region. It can only move
+// while the organization's members have no history.
+func (h *ResidencyHandler) SetResidency(c *gin.Context) {
+	rc := middleware.GetRequestContext(c)
+	if rc.OrgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "data residency is managed by an organization's admins"})
+		return
+	}
+
+	var req ResidencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	residency, err := h.residency.SetRegion(c.Request.Context(), rc.OrgID, req.Region, rc.UserID, h.history)
+	if err != nil {
+		h.respondError(c, err, "failed to set data residency")
+		return
+	}
+
+	h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": rc.UserID,
+		"org_id":   rc.OrgID,
+		"region":   residency.Region,
+	}).Info("Data residency set")
+	c.JSON(http.StatusOK, residency)
+}
+
+func (h *ResidencyHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidResidency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrResidencyUnavailable):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrResidencyLocked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Data residency operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const scimContentType = "application/scim+json"
+
+// SCIMHandler serves the SCIM 2.0 Users and Groups endpoints enterprise
+// directories provision organizations through, and lets organization admins
+// manage the tokens those directories authenticate with.
+type SCIMHandler struct {
+	scim   *services.SCIMService
+	logger *logrus.Entry
+}
+
+// NewSCIMHandler creates a new SCIM handler
+func NewSCIMHandler(scim *services.SCIMService, logger *logrus.Entry) *SCIMHandler {
+	return &SCIMHandler{
+		scim:   scim,
+		logger: logger,
+	}
+}
+
+// CreateSCIMTokenRequest names a new directory token
+type CreateSCIMTokenRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=255"`
+}
+
+// ListUsers returns a page of the organization's provisioned users
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	startIndex, count, ok := h.page(c)
+	if !ok {
+		return
+	}
+	list, err := h.scim.ListUsers(c.Request.Context(), scimOrgID(c), c.Query("filter"), startIndex, count)
+	if err != nil {
+		h.respondError(c, err, "failed to list users")
+		return
+	}
+	for _, user := range list.Resources.([]*services.SCIMUser) {
+		user.Meta.Location = scimLocation(c, "Users", user.ID)
+	}
+	h.respond(c, http.StatusOK, list)
+}
+
+// GetUser returns one of the organization's provisioned users
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.scim.GetUser(c.Request.Context(), scimOrgID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get user")
+		return
+	}
+	h.respondUser(c, http.StatusOK, user)
+}
+
+// CreateUser provisions a user into the organization
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var in services.SCIMUser
+	if !h.bind(c, &in) {
+		return
+	}
+	user, err := h.scim.CreateUser(c.Request.Context(), scimOrgID(c), &in)
+	if err != nil {
+		h.respondError(c, err, "failed to create user")
+		return
+	}
+	h.respondUser(c, http.StatusCreated, user)
+}
+
+// ReplaceUser overwrites a provisioned user's attributes
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var in services.SCIMUser
+	if !h.bind(c, &in) {
+		return
+	}
+	user, err := h.scim.ReplaceUser(c.Request.Context(), scimOrgID(c), c.Param("id"), &in)
+	if err != nil {
+		h.respondError(c, err, "failed to replace user")
+		return
+	}
+	h.respondUser(c, http.StatusOK, user)
+}
+
+// PatchUser changes some of a provisioned user's attributes, which is how
+// most directories deactivate users
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var req services.SCIMPatchRequest
+	if !h.bind(c, &req) {
+		return
+	}
+	user, err := h.scim.PatchUser(c.Request.Context(), scimOrgID(c), c.Param("id"), req.Operations)
+	if err != nil {
+		h.respondError(c, err, "failed to patch user")
+		return
+	}
+	h.respondUser(c, http.StatusOK, user)
+}
+
+// DeleteUser deprovisions a user, deactivating their account
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	if err := h.scim.DeleteUser(c.Request.Context(), scimOrgID(c), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete user")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups returns a page of the organization's groups. Directories that
+// only look groups up ask for ?excludedAttributes=members.
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	startIndex, count, ok := h.page(c)
+	if !ok {
+		return
+	}
+	withMembers := !strings.EqualFold(c.Query("excludedAttributes"), "members")
+	list, err := h.scim.ListGroups(c.Request.Context(), scimOrgID(c), c.Query("filter"), startIndex, count, withMembers)
+	if err != nil {
+		h.respondError(c, err, "failed to list groups")
+		return
+	}
+	for _, group := range list.Resources.([]*services.SCIMGroup) {
+		group.Meta.Location = scimLocation(c, "Groups", group.ID)
+	}
+	h.respond(c, http.StatusOK, list)
+}
+
+// GetGroup returns one of the organization's groups
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := h.scim.GetGroup(c.Request.Context(), scimOrgID(c), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get group")
+		return
+	}
+	h.respondGroup(c, http.StatusOK, group)
+}
+
+// CreateGroup creates a group of provisioned users
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var in services.SCIMGroup
+	if !h.bind(c, &in) {
+		return
+	}
+	group, err := h.scim.CreateGroup(c.Request.Context(), scimOrgID(c), &in)
+	if err != nil {
+		h.respondError(c, err, "failed to create group")
+		return
+	}
+	h.respondGroup(c, http.StatusCreated, group)
+}
+
+// ReplaceGroup overwrites a group's name and members
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var in services.SCIMGroup
+	if !h.bind(c, &in) {
+		return
+	}
+	group, err := h.scim.ReplaceGroup(c.Request.Context(), scimOrgID(c), c.Param("id"), &in)
+	if err != nil {
+		h.respondError(c, err, "failed to replace group")
+		return
+	}
+	h.respondGroup(c, http.StatusOK, group)
+}
+
+// PatchGroup renames a group or adds and removes its members
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	var req services.SCIMPatchRequest
+	if !h.bind(c, &req) {
+		return
+	}
+	group, err := h.scim.PatchGroup(c.Request.Context(), scimOrgID(c), c.Param("id"), req.Operations)
+	if err != nil {
+		h.respondError(c, err, "failed to patch group")
+		return
+	}
+	h.respondGroup(c, http.StatusOK, group)
+}
+
+// DeleteGroup removes a group
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	if err := h.scim.DeleteGroup(c.Request.Context(), scimOrgID(c), c.Param("id")); err != nil {
+		h.respondError(c, err, "failed to delete group")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListTokens returns the admin's organization's active directory tokens
+func (h *SCIMHandler) ListTokens(c *gin.Context) {
+	orgID, ok := h.adminOrgID(c)
+	if !ok {
+		return
+	}
+	tokens, err := h.scim.ListTokens(c.Request.Context(), orgID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list SCIM tokens")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list scim tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateToken issues a directory token. The raw token is only shown once.
+func (h *SCIMHandler) CreateToken(c *gin.Context) {
+	orgID, ok := h.adminOrgID(c)
+	if !ok {
+		return
+	}
+	var req CreateSCIMTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	token, rawToken, err := h.scim.CreateToken(c.Request.Context(), orgID, req.Name, middleware.GetRequestContext(c).UserID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to create SCIM token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create scim token"})
+		return
+	}
+
+	h.audit(c).WithField("token_id", token.ID).Info("SCIM token created")
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      rawToken,
+		"scim_token": token,
+	})
+}
+
+// RevokeToken stops a directory token from authenticating
+func (h *SCIMHandler) RevokeToken(c *gin.Context) {
+	orgID, ok := h.adminOrgID(c)
+	if !ok {
+		return
+	}
+	if err := h.scim.RevokeToken(c.Request.Context(), orgID, c.Param("id")); err != nil {
+		if errors.Is(err, services.ErrSCIMTokenNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to revoke SCIM token")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke scim token"})
+		return
+	}
+
+	h.audit(c).WithField("token_id", c.Param("id")).Info("SCIM token revoked")
+	c.Status(http.StatusNoContent)
+}
+
+// adminOrgID returns the admin's organization, answering 400 when they have
+// none
+func (h *SCIMHandler) adminOrgID(c *gin.Context) (string, bool) {
+	orgID := middleware.GetRequestContext(c).OrgID
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "directory tokens are issued by an organization's admins"})
+		return "", false
+	}
+	return orgID, true
+}
+
+func (h *SCIMHandler) audit(c *gin.Context) *logrus.Entry {
+	rc := middleware.GetRequestContext(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": rc.UserID,
+		"org_id":   rc.OrgID,
+	})
+}
+
+// scimOrgID returns the organization the directory's token was issued for
+func scimOrgID(c *gin.Context) string {
+	return c.GetString("scim_org_id")
+}
+
+// scimLocation is a resource's URL, as the directory reached the gateway
+func scimLocation(c *gin.Context, resource, id string) string {
+	scheme := "http"
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + c.Request.Host + "/scim/v2/" + resource + "/" + id
+}
+
+// page reads the 1-based startIndex and count of a list request
+func (h *SCIMHandler) page(c *gin.Context) (int, int, bool) {
+	startIndex, count := 1, services.DefaultSCIMPageSize
+	if raw := c.Query("startIndex"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			h.respondError(c, &services.SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidValue", Detail: "startIndex must be a number"}, "")
+			return 0, 0, false
+		}
+		if n > 1 {
+			startIndex = n
+		}
+	}
+	if raw := c.Query("count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			h.respondError(c, &services.SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidValue", Detail: "count must be a number"}, "")
+			return 0, 0, false
+		}
+		count = n
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > services.MaxSCIMPageSize {
+		count = services.MaxSCIMPageSize
+	}
+	return startIndex, count, true
+}
+
+func (h *SCIMHandler) bind(c *gin.Context, target interface{}) bool {
+	if err := json.NewDecoder(c.Request.Body).Decode(target); err != nil {
+		h.respondError(c, &services.SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidSyntax", Detail: "invalid request body"}, "")
+		return false
+	}
+	return true
+}
+
+func (h *SCIMHandler) respondUser(c *gin.Context, status int, user *services.SCIMUser) {
+	user.Meta.Location = scimLocation(c, "Users", user.ID)
+	c.Header("Location", user.Meta.Location)
+	h.respond(c, status, user)
+}
+
+func (h *SCIMHandler) respondGroup(c *gin.Context, status int, group *services.SCIMGroup) {
+	group.Meta.Location = scimLocation(c, "Groups", group.ID)
+	c.Header("Location", group.Meta.Location)
+	h.respond(c, status, group)
+}
+
+func (h *SCIMHandler) respond(c *gin.Context, status int, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		h.respondError(c, err, "failed to encode response")
+		return
+	}
+	c.Data(status, scimContentType, data)
+}
+
+func (h *SCIMHandler) respondError(c *gin.Context, err error, message string) {
+	var scimErr *services.SCIMError
+	if !errors.As(err, &scimErr) {
+		h.logger.WithError(err).WithField("org_id", scimOrgID(c)).Error("SCIM operation failed")
+		scimErr = &services.SCIMError{Status: http.StatusInternalServerError, Detail: message}
+	}
+	body := gin.H{
+		"schemas": []string{services.SCIMErrorSchema},
+		"status":  strconv.Itoa(scimErr.Status),
+		"detail":  scimErr.Detail,
+	}
+	if scimErr.SCIMType != "" {
+		body["scimType"] = scimErr.SCIMType
+	}
+	data, _ := json.Marshal(body)
+	c.Data(scimErr.Status, scimContentType, data)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SearchAnalyticsHandler records search click-through and reports search analytics
+type SearchAnalyticsHandler struct {
+	analytics *services.SearchAnalyticsService
+	logger    *logrus.Entry
+}
+
+// NewSearchAnalyticsHandler creates a new search analytics handler
+func NewSearchAnalyticsHandler(analytics *services.SearchAnalyticsService, logger *logrus.Entry) *SearchAnalyticsHandler {
+	return &SearchAnalyticsHandler{
+		analytics: analytics,
+		logger:    logger,
+	}
+}
+
+// SearchClickRequest reports that a search result was opened
+type SearchClickRequest struct {
+	SearchID string `json:"search_id" binding:"required,uuid"`
+	ResultID string `json:"result_id" binding:"required,max=255"`
+	Position int    `json:"position" binding:"min=0"`
+}
+
+// RecordClick records a click on a result of a search identified by the
+// X-Search-ID header returned with the search results
+func (h *SearchAnalyticsHandler) RecordClick(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req SearchClickRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	if err := h.analytics.RecordClick(c.Request.Context(), req.SearchID, userID, req.ResultID, req.Position); err != nil {
+		if err.Error() == "search not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to record search click")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to record search click"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetSummary returns top and zero-result queries for the last ?days= days
+// (default 30, max 365), ?limit= terms per list (default 20, max 100)
+func (h *SearchAnalyticsHandler) GetSummary(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+		return
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	summary, err := h.analytics.Summary(c.Request.Context(), since, limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to summarize search analytics")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to summarize search analytics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SettingsHandler moves users' settings between accounts as JSON bundles
+type SettingsHandler struct {
+	settings *services.SettingsService
+	logger   *logrus.Entry
+}
+
+// NewSettingsHandler creates a new settings handler
+func NewSettingsHandler(settings *services.SettingsService, logger *logrus.Entry) *SettingsHandler {
+	return &SettingsHandler{
+		settings: settings,
+		logger:   logger,
+	}
+}
+
+// ExportSettings downloads the caller's settings as a bundle that
+// ImportSettings takes as is
+func (h *SettingsHandler) ExportSettings(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	bundle, err := h.settings.Export(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to export settings")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to export settings"})
+		return
+	}
+
+	filename := fmt.Sprintf("betterprompts-settings-%s.json", bundle.ExportedAt.Format(time.DateOnly))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// ImportSettings applies a settings bundle to the caller's account. The
+// mode query parameter picks how it meets existing settings: "merge", the
+// default, overwrites only what the bundle has, and "replace" also removes
+// what it doesn't.
+func (h *SettingsHandler) ImportSettings(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	mode := services.SettingsImportMode(c.DefaultQuery("mode", string(services.SettingsImportMerge)))
+	if mode != services.SettingsImportMerge && mode != services.SettingsImportReplace {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be merge or replace"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxSettingsBundleSize)
+	var bundle services.SettingsBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("settings bundle must be at most %d MB", services.MaxSettingsBundleSize>>20),
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	result, err := h.settings.Import(c.Request.Context(), userID, &bundle, mode)
+	if err != nil {
+		var bundleErr *services.SettingsBundleError
+		switch {
+		case errors.As(err, &bundleErr):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   "Invalid settings bundle",
+				"details": bundleErr.Problems,
+			})
+		case errors.Is(err, services.ErrTooManyProfiles), errors.Is(err, services.ErrTooManyTemplates):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.WithError(err).Error("Failed to import settings")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import settings"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SSOHandler lets organization admins configure the provider their members
+// sign in through. Admins without an organization have nothing to configure.
+type SSOHandler struct {
+	sso    *services.SSOService
+	logger *logrus.Entry
+}
+
+// NewSSOHandler creates a new SSO handler
+func NewSSOHandler(sso *services.SSOService, logger *logrus.Entry) *SSOHandler {
+	return &SSOHandler{
+		sso:    sso,
+		logger: logger,
+	}
+}
+
+// SSOConnectionRequest creates or replaces an organization's connection
+type SSOConnectionRequest struct {
+	Protocol     string            `json:"protocol"` // Only "oidc"
+	Issuer       string            `json:"issuer" binding:"required"`
+	ClientID     string            `json:"client_id" binding:"required"`
+	ClientSecret string            `json:"client_secret"` // Keeps the stored secret when empty
+	Domains      []string          `json:"domains" binding:"required"`
+	RoleClaim    string            `json:"role_claim"` // Defaults to "groups"
+	RoleMapping  map[string]string `json:"role_mapping"`
+	DefaultRole  string            `json:"default_role"` // Defaults to "user"
+	Enabled      *bool             `json:"enabled"`      // Defaults to true
+}
+
+// GetConnection returns the organization's connection, without its secret
+func (h *SSOHandler) GetConnection(c *gin.Context) {
+	orgID, ok := h.orgID(c)
+	if !ok {
+		return
+	}
+
+	conn, err := h.sso.GetConnection(c.Request.Context(), orgID)
+	if err != nil {
+		h.respondError(c, err, "failed to get sso connection")
+		return
+	}
+	c.JSON(http.StatusOK, conn)
+}
+
+// PutConnection creates or replaces the organization's connection
+func (h *SSOHandler) PutConnection(c *gin.Context) {
+	orgID, ok := h.orgID(c)
+	if !ok {
+		return
+	}
+
+	var req SSOConnectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+	conn, err := services.NormalizeSSOConnection(services.SSOConnection{
+		OrgID:        orgID,
+		Protocol:     req.Protocol,
+		Issuer:       req.Issuer,
+		ClientID:     req.ClientID,
+		ClientSecret: req.ClientSecret,
+		Domains:      req.Domains,
+		RoleClaim:    req.RoleClaim,
+		RoleMapping:  req.RoleMapping,
+		DefaultRole:  req.DefaultRole,
+		Enabled:      enabled,
+		CreatedBy:    middleware.GetRequestContext(c).UserID,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	saved, err := h.sso.SaveConnection(c.Request.Context(), conn)
+	if err != nil {
+		h.respondError(c, err, "failed to save sso connection")
+		return
+	}
+
+	h.audit(c).WithFields(logrus.Fields{
+		"issuer":  saved.Issuer,
+		"domains": saved.Domains,
+		"enabled": saved.Enabled,
+	}).Info("SSO connection saved")
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteConnection removes the organization's connection
+func (h *SSOHandler) DeleteConnection(c *gin.Context) {
+	orgID, ok := h.orgID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.sso.DeleteConnection(c.Request.Context(), orgID); err != nil {
+		h.respondError(c, err, "failed to delete sso connection")
+		return
+	}
+
+	h.audit(c).Info("SSO connection deleted")
+	c.Status(http.StatusNoContent)
+}
+
+// orgID returns the admin's organization, answering 400 when they have none
+func (h *SSOHandler) orgID(c *gin.Context) (string, bool) {
+	orgID := middleware.GetRequestContext(c).OrgID
+	if orgID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "single sign-on is configured by an organization's admins"})
+		return "", false
+	}
+	return orgID, true
+}
+
+func (h *SSOHandler) audit(c *gin.Context) *logrus.Entry {
+	rc := middleware.GetRequestContext(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": rc.UserID,
+		"org_id":   rc.OrgID,
+	})
+}
+
+func (h *SSOHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrSSOConnectionNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSSODomainTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSSOClientSecretRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("SSO connection operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
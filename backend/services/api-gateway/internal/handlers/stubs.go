@@ -8,11 +8,6 @@ import (
 
 // Stub handlers to make the build work
 
-func GetAvailableTechniques(clients *services.ServiceClients) gin.HandlerFunc {
-	// Use the proper GetTechniques handler that returns full technique objects
-	return GetTechniques()
-}
-
 func SelectTechniques(clients *services.ServiceClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"selected": []string{"cot"}})
@@ -22,11 +17,8 @@ func SelectTechniques(clients *services.ServiceClients) gin.HandlerFunc {
 // SubmitFeedback is now implemented in feedback.go
 // Use NewFeedbackHandler(clients, logger).SubmitFeedback instead
 
-func GetUsers(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"users": []interface{}{}})
-	}
-}
+// GetUsers is now implemented in admin_users.go
+// Use NewAdminUsersHandler(userService, logger).ListUsers instead
 
 func GetUser(clients *services.ServiceClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -48,7 +40,19 @@ func DeleteUser(clients *services.ServiceClients) gin.HandlerFunc {
 
 func GetSystemMetrics(clients *services.ServiceClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"metrics": map[string]interface{}{}})
+		metrics := map[string]interface{}{}
+		if clients.Cache != nil {
+			if region := clients.Cache.Region(); region != "" {
+				metrics["region"] = region
+			}
+			if stats := clients.Cache.ReplicationStats(); stats != nil {
+				metrics["session_replication"] = stats
+			}
+		}
+		if stats := clients.GeneratorCanaryStats(); stats != nil {
+			metrics["prompt_generator_canary"] = stats
+		}
+		c.JSON(http.StatusOK, gin.H{"metrics": metrics})
 	}
 }
 
@@ -70,24 +74,6 @@ func InvalidateUserCache(clients *services.ServiceClients) gin.HandlerFunc {
 	}
 }
 
-func CreateAPIKey(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"api_key": "stub-key"})
-	}
-}
-
-func GetAPIKeys(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"api_keys": []interface{}{}})
-	}
-}
-
-func DeleteAPIKey(clients *services.ServiceClients) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
-	}
-}
-
 func GetDeveloperUsage(clients *services.ServiceClients) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"usage": map[string]interface{}{}})
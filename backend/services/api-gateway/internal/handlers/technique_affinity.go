@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// techniqueAffinityStore is the part of the affinity service the handler uses
+type techniqueAffinityStore interface {
+	GetAffinity(ctx context.Context, userID string) (*services.TechniqueAffinityReport, error)
+	ResetAffinity(ctx context.Context, userID string) error
+}
+
+// TechniqueAffinityHandler shows users the technique affinity learned from
+// their history and lets them reset it
+type TechniqueAffinityHandler struct {
+	affinity techniqueAffinityStore
+	logger   *logrus.Entry
+}
+
+// NewTechniqueAffinityHandler creates a new technique affinity handler
+func NewTechniqueAffinityHandler(affinity *services.TechniqueAffinityService, logger *logrus.Entry) *TechniqueAffinityHandler {
+	return &TechniqueAffinityHandler{
+		affinity: affinity,
+		logger:   logger,
+	}
+}
+
+// GetAffinity handles GET /api/v1/me/technique-affinity
+func (h *TechniqueAffinityHandler) GetAffinity(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	report, err := h.affinity.GetAffinity(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get technique affinity")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get technique affinity"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ResetAffinity handles DELETE /api/v1/me/technique-affinity
+func (h *TechniqueAffinityHandler) ResetAffinity(c *gin.Context) {
+	userID, _ := middleware.GetUserID(c)
+	if err := h.affinity.ResetAffinity(c.Request.Context(), userID); err != nil {
+		h.logger.WithError(err).Error("Failed to reset technique affinity")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset technique affinity"})
+		return
+	}
+
+	h.logger.WithField("user_id", userID).Info("Technique affinity reset")
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,449 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// techniqueCatalogTTL is how long the selector's catalog is trusted
+	// before it is revalidated with a conditional request
+	techniqueCatalogTTL          = 5 * time.Minute
+	techniqueCatalogFetchTimeout = 2 * time.Second
+
+	defaultTechniquePageSize = 50
+	maxTechniquePageSize     = 100
+	defaultTechniqueLocale   = "en"
+
+	// maxEncodedCatalogBodies bounds the compressed response cache
+	maxEncodedCatalogBodies = 256
+)
+
+// techniqueFields are the fields selectable with ?fields=
+var techniqueFields = map[string]bool{
+	"id":            true,
+	"name":          true,
+	"category":      true,
+	"description":   true,
+	"complexity":    true,
+	"examples":      true,
+	"parameters":    true,
+	"effectiveness": true,
+}
+
+// techniqueDescriptions holds localized technique descriptions keyed by
+// locale then technique ID. English comes from builtinTechniques.
+var techniqueDescriptions = map[string]map[string]string{
+	"es": {
+		"chain_of_thought":     "Razonamiento paso a paso que divide problemas complejos en partes manejables",
+		"tree_of_thoughts":     "Explora múltiples líneas de razonamiento y evalúa distintos enfoques",
+		"few_shot":             "Proporciona ejemplos para guiar el formato y el estilo de la respuesta",
+		"zero_shot":            "Resuelve la tarea directamente, sin ejemplos, usando el conocimiento general de la IA",
+		"self_consistency":     "Varios intentos de resolución con verificación de coherencia",
+		"constitutional_ai":    "Aplica principios éticos y pautas a las respuestas",
+		"iterative_refinement": "Mejora progresiva a lo largo de varias iteraciones",
+	},
+	"fr": {
+		"chain_of_thought":     "Raisonnement étape par étape qui décompose les problèmes complexes en parties gérables",
+		"tree_of_thoughts":     "Explore plusieurs pistes de raisonnement et évalue différentes approches",
+		"few_shot":             "Fournit des exemples pour guider le format et le style de la réponse",
+		"zero_shot":            "Réalisation directe de la tâche sans exemples, en s'appuyant sur les connaissances générales de l'IA",
+		"self_consistency":     "Plusieurs tentatives de résolution avec vérification de la cohérence",
+		"constitutional_ai":    "Applique des principes éthiques et des lignes directrices aux réponses",
+		"iterative_refinement": "Amélioration progressive au fil de plusieurs itérations",
+	},
+	"de": {
+		"chain_of_thought":     "Schrittweises Denken, das komplexe Probleme in überschaubare Teile zerlegt",
+		"tree_of_thoughts":     "Untersucht mehrere Denkwege und bewertet unterschiedliche Ansätze",
+		"few_shot":             "Liefert Beispiele, um Format und Stil der Antwort vorzugeben",
+		"zero_shot":            "Direkte Aufgabenlösung ohne Beispiele auf Basis des Allgemeinwissens der KI",
+		"self_consistency":     "Mehrere Lösungsversuche mit Konsistenzprüfung",
+		"constitutional_ai":    "Wendet ethische Prinzipien und Richtlinien auf Antworten an",
+		"iterative_refinement": "Schrittweise Verbesserung über mehrere Iterationen",
+	},
+}
+
+// catalogEncoders compress catalog responses by Accept-Encoding token, in
+// order of preference
+var catalogEncoders = []struct {
+	name   string
+	encode func([]byte) ([]byte, error)
+}{
+	{"gzip", gzipBytes},
+}
+
+// TechniqueCatalogResponse is a page of the techniques catalog
+type TechniqueCatalogResponse struct {
+	Techniques []map[string]interface{} `json:"techniques"`
+	Total      int                      `json:"total"`
+	Page       int                      `json:"page"`
+	Limit      int                      `json:"limit"`
+	TotalPages int                      `json:"total_pages"`
+	Locale     string                   `json:"locale"`
+	Version    string                   `json:"version"`
+}
+
+// techniqueCatalog is one version of the merged catalog
+type techniqueCatalog struct {
+	techniques []Technique
+	version    string
+	modified   time.Time
+}
+
+// techniqueCatalogLoader keeps the catalog in sync with the technique
+// selector. Within techniqueCatalogTTL no selector call is made at all;
+// after that the selector is revalidated with its ETag.
+type techniqueCatalogLoader struct {
+	source services.TechniqueCatalogSource
+
+	mu           sync.Mutex
+	catalog      *techniqueCatalog
+	selectorETag string
+	checkedAt    time.Time
+	encoded      map[string][]byte
+}
+
+func newTechniqueCatalogLoader(source services.TechniqueCatalogSource) *techniqueCatalogLoader {
+	return &techniqueCatalogLoader{
+		source:  source,
+		catalog: buildTechniqueCatalog(nil),
+		encoded: make(map[string][]byte),
+	}
+}
+
+// get returns the current catalog, refreshing it from the selector when stale.
+// Selector failures keep serving the last known catalog.
+func (l *techniqueCatalogLoader) get(ctx context.Context, logger *logrus.Entry) *techniqueCatalog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.source == nil || time.Since(l.checkedAt) < techniqueCatalogTTL {
+		return l.catalog
+	}
+	l.checkedAt = time.Now()
+
+	// Don't hold every catalog request hostage to a slow selector
+	ctx, cancel := context.WithTimeout(ctx, techniqueCatalogFetchTimeout)
+	defer cancel()
+
+	list, err := l.source.ListTechniques(ctx, l.selectorETag)
+	if err != nil {
+		if !errors.Is(err, services.ErrNotModified) {
+			logger.WithError(err).Warn("Failed to refresh technique catalog, serving cached copy")
+		}
+		return l.catalog
+	}
+
+	l.selectorETag = list.ETag
+	if catalog := buildTechniqueCatalog(list.Techniques); catalog.version != l.catalog.version {
+		l.catalog = catalog
+		l.encoded = make(map[string][]byte)
+		logger.WithField("version", catalog.version).Info("Technique catalog updated")
+	}
+	return l.catalog
+}
+
+// encodedBody returns body compressed with encoding, caching the result per ETag
+func (l *techniqueCatalogLoader) encodedBody(etag, encoding string, body []byte, encode func([]byte) ([]byte, error)) ([]byte, error) {
+	key := encoding + "|" + etag
+
+	l.mu.Lock()
+	cached, ok := l.encoded[key]
+	l.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	encoded, err := encode(body)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	if len(l.encoded) >= maxEncodedCatalogBodies {
+		l.encoded = make(map[string][]byte)
+	}
+	l.encoded[key] = encoded
+	l.mu.Unlock()
+
+	return encoded, nil
+}
+
+// buildTechniqueCatalog merges the selector's technique list with the
+// gateway's descriptive metadata. A nil list means the selector hasn't been
+// reached and the built-in techniques are served as-is.
+func buildTechniqueCatalog(selected []services.CatalogTechnique) *techniqueCatalog {
+	techniques := builtinTechniques
+	if selected != nil {
+		builtin := make(map[string]Technique, len(builtinTechniques))
+		for _, t := range builtinTechniques {
+			builtin[t.ID] = t
+		}
+
+		techniques = make([]Technique, 0, len(selected))
+		for _, s := range selected {
+			if t, ok := builtin[s.ID]; ok {
+				techniques = append(techniques, t)
+				continue
+			}
+			techniques = append(techniques, Technique{
+				ID:          s.ID,
+				Name:        s.Name,
+				Category:    "general",
+				Description: s.Description,
+				Examples:    []string{},
+			})
+		}
+	}
+
+	data, _ := json.Marshal(struct {
+		Techniques   []Technique                  `json:"techniques"`
+		Descriptions map[string]map[string]string `json:"descriptions"`
+	}{techniques, techniqueDescriptions})
+	sum := sha256.Sum256(data)
+
+	return &techniqueCatalog{
+		techniques: techniques,
+		version:    hex.EncodeToString(sum[:8]),
+		modified:   time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// GetAvailableTechniques serves the techniques catalog.
+//
+// Query parameters: page, limit, fields (comma-separated sparse fieldset,
+// id is always included) and locale (otherwise taken from Accept-Language).
+// Responses carry the catalog version in X-Catalog-Version and an ETag and
+// Last-Modified for conditional requests, and are gzip-compressed when the
+// client accepts it.
+func GetAvailableTechniques(clients *services.ServiceClients) gin.HandlerFunc {
+	var source services.TechniqueCatalogSource
+	if clients != nil {
+		if s, ok := clients.TechniqueSelector.(services.TechniqueCatalogSource); ok {
+			source = s
+		}
+	}
+	loader := newTechniqueCatalogLoader(source)
+
+	return func(c *gin.Context) {
+		logger := c.MustGet("logger").(*logrus.Entry)
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "page must be a positive integer"})
+			return
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultTechniquePageSize)))
+		if err != nil || limit < 1 || limit > maxTechniquePageSize {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("limit must be between 1 and %d", maxTechniquePageSize),
+			})
+			return
+		}
+
+		fields, err := parseTechniqueFields(c.Query("fields"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid fields parameter",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		locale := negotiateTechniqueLocale(c.Query("locale"), c.GetHeader("Accept-Language"))
+		catalog := loader.get(c.Request.Context(), logger)
+
+		variant := generateTextHash(fmt.Sprintf("%s|%s|%d|%d", locale, strings.Join(fields, ","), page, limit))
+		etag := `"` + catalog.version + "-" + variant[:8] + `"`
+
+		c.Header("ETag", etag)
+		c.Header("Last-Modified", catalog.modified.Format(http.TimeFormat))
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Header("Vary", "Accept-Language, Accept-Encoding")
+		c.Header("Content-Language", locale)
+		c.Header("X-Catalog-Version", catalog.version)
+
+		if catalogNotModified(c.Request, etag, catalog.modified) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		response := paginateTechniqueCatalog(catalog, locale, fields, page, limit)
+		body, err := json.Marshal(response)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode techniques"})
+			return
+		}
+
+		for _, encoder := range catalogEncoders {
+			if !acceptsEncoding(c.GetHeader("Accept-Encoding"), encoder.name) {
+				continue
+			}
+			encoded, err := loader.encodedBody(etag, encoder.name, body, encoder.encode)
+			if err != nil {
+				logger.WithError(err).Warn("Failed to compress technique catalog")
+				break
+			}
+			c.Header("Content-Encoding", encoder.name)
+			c.Data(http.StatusOK, "application/json; charset=utf-8", encoded)
+			return
+		}
+
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// paginateTechniqueCatalog renders one localized page of the catalog
+func paginateTechniqueCatalog(catalog *techniqueCatalog, locale string, fields []string, page, limit int) TechniqueCatalogResponse {
+	total := len(catalog.techniques)
+	start := (page - 1) * limit
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	items := make([]map[string]interface{}, 0, end-start)
+	for _, t := range catalog.techniques[start:end] {
+		if description, ok := techniqueDescriptions[locale][t.ID]; ok {
+			t.Description = description
+		}
+		items = append(items, techniqueFieldset(t, fields))
+	}
+
+	return TechniqueCatalogResponse{
+		Techniques: items,
+		Total:      total,
+		Page:       page,
+		Limit:      limit,
+		TotalPages: (total + limit - 1) / limit,
+		Locale:     locale,
+		Version:    catalog.version,
+	}
+}
+
+// techniqueFieldset renders a technique with only the requested fields, or
+// all fields when none are requested
+func techniqueFieldset(t Technique, fields []string) map[string]interface{} {
+	data, _ := json.Marshal(t)
+	var all map[string]interface{}
+	_ = json.Unmarshal(data, &all)
+
+	if len(fields) == 0 {
+		return all
+	}
+
+	sparse := map[string]interface{}{"id": t.ID}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			sparse[field] = value
+		}
+	}
+	return sparse
+}
+
+// parseTechniqueFields validates a comma-separated sparse fieldset
+func parseTechniqueFields(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	seen := make(map[string]bool)
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if !techniqueFields[field] {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// negotiateTechniqueLocale picks the explicit locale if supported, otherwise
+// the first supported language in Accept-Language, falling back to English
+func negotiateTechniqueLocale(explicit, acceptLanguage string) string {
+	candidates := []string{explicit}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		candidates = append(candidates, tag)
+	}
+
+	for _, candidate := range candidates {
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(candidate)), "-")
+		if lang == defaultTechniqueLocale {
+			return lang
+		}
+		if _, ok := techniqueDescriptions[lang]; ok {
+			return lang
+		}
+	}
+	return defaultTechniqueLocale
+}
+
+// catalogNotModified evaluates If-None-Match, falling back to
+// If-Modified-Since only when no ETag was sent
+func catalogNotModified(r *http.Request, etag string, modified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+		return !modified.After(since)
+	}
+	return false
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+	}
+	return false
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
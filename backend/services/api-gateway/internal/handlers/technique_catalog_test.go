@@ -0,0 +1,145 @@
+package handlers_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/handlers"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubCatalogSelector is a technique selector that only lists techniques
+type stubCatalogSelector struct {
+	list  *services.TechniqueList
+	calls int
+}
+
+func (s *stubCatalogSelector) SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error) {
+	return nil, nil
+}
+
+func (s *stubCatalogSelector) ListTechniques(ctx context.Context, etag string) (*services.TechniqueList, error) {
+	s.calls++
+	if etag != "" && etag == s.list.ETag {
+		return nil, services.ErrNotModified
+	}
+	return s.list, nil
+}
+
+func newCatalogRouter(selector *stubCatalogSelector) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("logger", logrus.NewEntry(logger))
+	})
+	router.GET("/techniques", handlers.GetAvailableTechniques(&services.ServiceClients{TechniqueSelector: selector}))
+	return router
+}
+
+func getCatalog(t *testing.T, router *gin.Engine, query string, headers map[string]string) (*httptest.ResponseRecorder, handlers.TechniqueCatalogResponse) {
+	req := httptest.NewRequest(http.MethodGet, "/techniques"+query, nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response handlers.TechniqueCatalogResponse
+	if w.Code == http.StatusOK && w.Header().Get("Content-Encoding") == "" {
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w, response
+}
+
+func TestGetAvailableTechniques(t *testing.T) {
+	selector := &stubCatalogSelector{list: &services.TechniqueList{
+		Techniques: []services.CatalogTechnique{
+			{ID: "chain_of_thought", Name: "Chain of Thought"},
+			{ID: "few_shot", Name: "Few-Shot Learning"},
+			{ID: "role_based", Name: "Role-Based Prompting", Description: "Assumes a specific role"},
+		},
+		ETag: `"v1"`,
+	}}
+	router := newCatalogRouter(selector)
+
+	t.Run("merges selector list with gateway metadata", func(t *testing.T) {
+		w, response := getCatalog(t, router, "", nil)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 3, response.Total)
+		assert.Equal(t, "reasoning", response.Techniques[0]["category"])
+		assert.Equal(t, "Assumes a specific role", response.Techniques[2]["description"])
+		assert.Equal(t, response.Version, w.Header().Get("X-Catalog-Version"))
+	})
+
+	t.Run("paginates", func(t *testing.T) {
+		_, response := getCatalog(t, router, "?page=2&limit=2", nil)
+
+		assert.Equal(t, 2, response.TotalPages)
+		require.Len(t, response.Techniques, 1)
+		assert.Equal(t, "role_based", response.Techniques[0]["id"])
+	})
+
+	t.Run("sparse fieldsets always include id", func(t *testing.T) {
+		_, response := getCatalog(t, router, "?fields=name", nil)
+
+		assert.Equal(t, map[string]interface{}{"id": "chain_of_thought", "name": "Chain of Thought"}, response.Techniques[0])
+	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		w, _ := getCatalog(t, router, "?fields=name,secret", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("localizes descriptions from Accept-Language", func(t *testing.T) {
+		w, response := getCatalog(t, router, "", map[string]string{"Accept-Language": "pt-BR, es-MX;q=0.8"})
+
+		assert.Equal(t, "es", response.Locale)
+		assert.Equal(t, "es", w.Header().Get("Content-Language"))
+		assert.Contains(t, response.Techniques[0]["description"], "paso a paso")
+	})
+
+	t.Run("revalidates with ETag and Last-Modified", func(t *testing.T) {
+		w, _ := getCatalog(t, router, "", nil)
+
+		notModified, _ := getCatalog(t, router, "", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+		assert.Equal(t, http.StatusNotModified, notModified.Code)
+
+		notModified, _ = getCatalog(t, router, "", map[string]string{"If-Modified-Since": w.Header().Get("Last-Modified")})
+		assert.Equal(t, http.StatusNotModified, notModified.Code)
+
+		otherVariant, _ := getCatalog(t, router, "?locale=de", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+		assert.Equal(t, http.StatusOK, otherVariant.Code)
+	})
+
+	t.Run("compresses when accepted", func(t *testing.T) {
+		w, _ := getCatalog(t, router, "", map[string]string{"Accept-Encoding": "br, gzip"})
+
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		reader, err := gzip.NewReader(w.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		var response handlers.TechniqueCatalogResponse
+		require.NoError(t, json.Unmarshal(body, &response))
+		assert.Equal(t, 3, response.Total)
+	})
+
+	t.Run("serves cached catalog without calling the selector", func(t *testing.T) {
+		assert.Equal(t, 1, selector.calls)
+	})
+}
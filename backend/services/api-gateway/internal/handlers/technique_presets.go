@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TechniquePresetHandler lets admins manage the default technique
+// combinations applied per intent and tier
+type TechniquePresetHandler struct {
+	presets *services.TechniquePresetService
+	logger  *logrus.Entry
+}
+
+// NewTechniquePresetHandler creates a new technique preset handler
+func NewTechniquePresetHandler(presets *services.TechniquePresetService, logger *logrus.Entry) *TechniquePresetHandler {
+	return &TechniquePresetHandler{
+		presets: presets,
+		logger:  logger,
+	}
+}
+
+// TechniquePresetRequest creates or replaces a technique preset. An empty
+// tier applies the preset to every tier without one of its own.
+type TechniquePresetRequest struct {
+	Intent      string   `json:"intent" binding:"required,max=100"`
+	Tier        string   `json:"tier" binding:"max=50"`
+	Techniques  []string `json:"techniques" binding:"required,min=1"`
+	Description string   `json:"description" binding:"max=500"`
+	Enabled     *bool    `json:"enabled"` // Defaults to true
+}
+
+// ListPresets returns every preset, enabled or not
+func (h *TechniquePresetHandler) ListPresets(c *gin.Context) {
+	presets, err := h.presets.ListPresets(c.Request.Context())
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list technique presets")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list technique presets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"presets": presets})
+}
+
+// GetPreset returns one preset
+func (h *TechniquePresetHandler) GetPreset(c *gin.Context) {
+	preset, err := h.presets.GetPreset(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.respondError(c, err, "failed to get technique preset")
+		return
+	}
+
+	c.JSON(http.StatusOK, preset)
+}
+
+// CreatePreset adds a preset for an intent and tier
+func (h *TechniquePresetHandler) CreatePreset(c *gin.Context) {
+	preset, ok := h.bindPreset(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	created, err := h.presets.CreatePreset(c.Request.Context(), preset, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to create technique preset")
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// UpdatePreset replaces a preset
+func (h *TechniquePresetHandler) UpdatePreset(c *gin.Context) {
+	preset, ok := h.bindPreset(c)
+	if !ok {
+		return
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	updated, err := h.presets.UpdatePreset(c.Request.Context(), c.Param("id"), preset, adminID)
+	if err != nil {
+		h.respondError(c, err, "failed to update technique preset")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeletePreset removes a preset
+func (h *TechniquePresetHandler) DeletePreset(c *gin.Context) {
+	adminID, _ := middleware.GetUserID(c)
+	if err := h.presets.DeletePreset(c.Request.Context(), c.Param("id"), adminID); err != nil {
+		h.respondError(c, err, "failed to delete technique preset")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetUsage counts the enhancements each preset was applied to over the last
+// ?days= days (default 30, max 90), by why the preset was used
+func (h *TechniquePresetHandler) GetUsage(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 90 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 90"})
+		return
+	}
+
+	usage, err := h.presets.PresetUsage(c.Request.Context(), time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get technique preset usage")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get technique preset usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"days": days, "usage": usage})
+}
+
+// bindPreset reads and normalizes a preset from the request body, answering
+// 400 when it is invalid
+func (h *TechniquePresetHandler) bindPreset(c *gin.Context) (services.TechniquePreset, bool) {
+	var req TechniquePresetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return services.TechniquePreset{}, false
+	}
+
+	preset := services.TechniquePreset{
+		Intent:      req.Intent,
+		Tier:        req.Tier,
+		Techniques:  req.Techniques,
+		Description: req.Description,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+	}
+	if err := services.NormalizeTechniquePreset(&preset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid technique preset",
+			"details": err.Error(),
+		})
+		return services.TechniquePreset{}, false
+	}
+	return preset, true
+}
+
+func (h *TechniquePresetHandler) respondError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, services.ErrPresetNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPresetExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.logger.WithError(err).Error("Technique preset operation failed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
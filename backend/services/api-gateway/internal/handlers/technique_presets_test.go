@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPresets holds presets by intent; tiers are ignored
+type stubPresets map[string]*services.TechniquePreset
+
+func (s stubPresets) MatchPreset(ctx context.Context, intent, tier string) *services.TechniquePreset {
+	return s[intent]
+}
+
+// failingSelector is a technique selector that is down
+type failingSelector struct{}
+
+func (failingSelector) SelectTechniques(ctx context.Context, req models.TechniqueSelectionRequest) ([]string, error) {
+	return nil, &services.DownstreamError{Service: "technique selector", Kind: services.ErrDownstreamUnavailable, StatusCode: 503}
+}
+
+func TestRunEnhancementPresets(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	entry := logrus.NewEntry(logger)
+
+	presets := stubPresets{"reasoning": {ID: "preset-1", Intent: "reasoning", Techniques: []string{"tree_of_thoughts", "self_consistency"}}}
+	run := func(t *testing.T, selector TechniqueSelector, req EnhanceRequest) (*EnhanceResponse, models.PromptHistory) {
+		var saved models.PromptHistory
+		db := new(MockDatabase)
+		db.On("SavePromptHistory", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			saved = args.Get(1).(models.PromptHistory)
+		}).Return("history-1", nil)
+
+		response, err := runEnhancement(context.Background(), &Dependencies{
+			Classifier: stubClassifier{},
+			Selector:   selector,
+			Generator:  stubGenerator{},
+			History:    db,
+			Presets:    presets,
+		}, entry, req, enhanceOptions{})
+		require.NoError(t, err)
+		return response, saved
+	}
+
+	t.Run("selector decides unless a preset is requested", func(t *testing.T) {
+		response, saved := run(t, stubSelector{}, EnhanceRequest{Text: "why"})
+		assert.Equal(t, []string{"chain_of_thought"}, response.TechniquesUsed)
+		assert.NotContains(t, response.Metadata, "technique_preset")
+		assert.NotContains(t, saved.Metadata, "technique_preset")
+	})
+
+	t.Run("requested preset", func(t *testing.T) {
+		response, saved := run(t, stubSelector{}, EnhanceRequest{Text: "why", UsePreset: true, ExcludeTechniques: []string{"self_consistency"}})
+		assert.Equal(t, []string{"tree_of_thoughts"}, response.TechniquesUsed)
+		use := map[string]interface{}{"id": "preset-1", "reason": services.PresetReasonRequested}
+		assert.Equal(t, use, response.Metadata["technique_preset"])
+		assert.Equal(t, use, saved.Metadata["technique_preset"], "usage analytics are read from history")
+	})
+
+	t.Run("preset stands in for a failed selector", func(t *testing.T) {
+		response, _ := run(t, failingSelector{}, EnhanceRequest{Text: "why"})
+		assert.Equal(t, []string{"tree_of_thoughts", "self_consistency"}, response.TechniquesUsed)
+		assert.Equal(t, map[string]interface{}{"id": "preset-1", "reason": services.PresetReasonSelectorUnavailable}, response.Metadata["technique_preset"])
+	})
+
+	t.Run("fully excluded preset is skipped", func(t *testing.T) {
+		response, _ := run(t, stubSelector{}, EnhanceRequest{Text: "why", UsePreset: true, ExcludeTechniques: []string{"tree_of_thoughts", "self_consistency"}})
+		assert.Equal(t, []string{"chain_of_thought"}, response.TechniquesUsed)
+		assert.NotContains(t, response.Metadata, "technique_preset")
+	})
+}
+
+func TestMatchPresetWithoutPresets(t *testing.T) {
+	preset, reason := matchPreset(context.Background(), &Dependencies{}, EnhanceRequest{UsePreset: true}, "reasoning", "free", services.PresetReasonRequested)
+	assert.Nil(t, preset)
+	assert.Empty(t, reason)
+}
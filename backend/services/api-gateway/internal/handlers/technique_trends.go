@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TechniqueTrendHandler serves technique popularity and trend reports
+type TechniqueTrendHandler struct {
+	trends *services.TechniqueTrendService
+	logger *logrus.Entry
+}
+
+// NewTechniqueTrendHandler creates a new technique trend handler
+func NewTechniqueTrendHandler(trends *services.TechniqueTrendService, logger *logrus.Entry) *TechniqueTrendHandler {
+	return &TechniqueTrendHandler{
+		trends: trends,
+		logger: logger,
+	}
+}
+
+// GetTrends reports technique usage over the last ?weeks= weeks (default
+// 12, max 52), optionally for one ?intent=. Admins get full counts; everyone
+// else gets the anonymized report.
+func (h *TechniqueTrendHandler) GetTrends(c *gin.Context) {
+	weeks, err := strconv.Atoi(c.DefaultQuery("weeks", "12"))
+	if err != nil || weeks < 1 || weeks > 52 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be between 1 and 52"})
+		return
+	}
+	intent := strings.ToLower(strings.TrimSpace(c.Query("intent")))
+	if len(intent) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "intent must be at most 100 characters"})
+		return
+	}
+
+	report, err := h.trends.GetTrends(c.Request.Context(), weeks, intent)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to get technique trends")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get technique trends"})
+		return
+	}
+
+	if middleware.IsAdmin(c) {
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusOK, report)
+		return
+	}
+
+	// The views only change when the scheduler refreshes them
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, report.Anonymize(services.MinPublicTechniqueUses))
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/betterprompts/api-gateway/internal/tokenizer"
+	"github.com/gin-gonic/gin"
+)
+
+// TokenCountRequest is the text, or conversation, to count the tokens of
+type TokenCountRequest struct {
+	Text     string                 `json:"text" binding:"required_without=Messages,max=20000"`
+	Messages []services.ChatMessage `json:"messages,omitempty" binding:"omitempty,max=50,dive"`
+	Model    string                 `json:"model,omitempty" binding:"max=100"` // The generation model when empty
+}
+
+// CountTokens counts the tokens of a text and any conversation for a model,
+// so clients can show how much of a budget a prompt uses as it is written.
+// Counts are marked approximate when the model's vocabulary isn't loaded.
+func CountTokens(tokens *tokenizer.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TokenCountRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request body",
+				"details": err.Error(),
+			})
+			return
+		}
+
+		count := tokens.Count(req.Model, req.Text)
+		t := tokens.For(count.Model)
+		for _, message := range req.Messages {
+			count.Tokens += t.Count(message.Content)
+		}
+		c.JSON(http.StatusOK, count)
+	}
+}
+
+// generationTokens is what a generation from input to output is accounted
+// in history: tokens of the generation model, counted here because the
+// generator's own tokens_used is only a length estimate
+func generationTokens(tokens *tokenizer.Registry, input, output string) sql.NullInt64 {
+	t := tokens.For("")
+	return sql.NullInt64{Int64: int64(t.Count(input) + t.Count(output)), Valid: true}
+}
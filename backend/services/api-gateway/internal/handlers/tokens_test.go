@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/tokenizer"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry, err := tokenizer.NewRegistry(tokenizer.Config{DefaultModel: "gpt-4"})
+	require.NoError(t, err)
+	router := gin.New()
+	router.POST("/tokens/count", CountTokens(registry))
+
+	count := func(body string) (int, tokenizer.Count) {
+		req := httptest.NewRequest(http.MethodPost, "/tokens/count", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		var count tokenizer.Count
+		json.Unmarshal(rec.Body.Bytes(), &count)
+		return rec.Code, count
+	}
+
+	code, result := count(`{"text":"explain this code"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, tokenizer.Count{Model: "gpt-4", Encoding: "estimate", Tokens: 5, Approximate: true}, result)
+
+	code, result = count(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi there"},{"role":"assistant","content":"hello"}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "gpt-4o", result.Model)
+	assert.Equal(t, 2+2, result.Tokens)
+
+	code, _ = count(`{"model":"gpt-4"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
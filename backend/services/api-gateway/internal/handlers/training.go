@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// TrainingHandler lets the ML team curate training data from prompt history
+type TrainingHandler struct {
+	training *services.TrainingService
+	logger   *logrus.Entry
+}
+
+// NewTrainingHandler creates a new training data handler
+func NewTrainingHandler(training *services.TrainingService, logger *logrus.Entry) *TrainingHandler {
+	return &TrainingHandler{
+		training: training,
+		logger:   logger,
+	}
+}
+
+// SetLabelRequest labels a single history entry
+type SetLabelRequest struct {
+	Label string `json:"label" binding:"required"`
+	Note  string `json:"note" binding:"max=1000"`
+}
+
+// BulkLabelRequest labels every history entry matching a filter
+type BulkLabelRequest struct {
+	Label  string                  `json:"label" binding:"required"`
+	Note   string                  `json:"note" binding:"max=1000"`
+	Filter services.TrainingFilter `json:"filter"`
+	DryRun bool                    `json:"dry_run"` // Only count the matching entries
+}
+
+// auditLogger returns a logger for changes to training data
+func (h *TrainingHandler) auditLogger(c *gin.Context) *logrus.Entry {
+	userID, _ := middleware.GetUserID(c)
+	return h.logger.WithFields(logrus.Fields{
+		"audit":   true,
+		"user_id": userID,
+	})
+}
+
+// SetLabel marks a history entry as a good or bad training example
+func (h *TrainingHandler) SetLabel(c *gin.Context) {
+	historyID := c.Param("history_id")
+	if _, err := uuid.Parse(historyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrTrainingExampleNotFound.Error()})
+		return
+	}
+
+	var req SetLabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !services.ValidTrainingLabel(req.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label must be good or bad"})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	if err := h.training.SetLabel(c.Request.Context(), historyID, req.Label, req.Note, userID); err != nil {
+		if errors.Is(err, services.ErrTrainingExampleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to set training label")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set label"})
+		return
+	}
+
+	h.auditLogger(c).WithFields(logrus.Fields{
+		"history_id": historyID,
+		"label":      req.Label,
+	}).Info("Training label set")
+
+	c.JSON(http.StatusOK, gin.H{
+		"history_id": historyID,
+		"label":      req.Label,
+	})
+}
+
+// DeleteLabel removes a history entry's training label
+func (h *TrainingHandler) DeleteLabel(c *gin.Context) {
+	historyID := c.Param("history_id")
+	if _, err := uuid.Parse(historyID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrTrainingLabelNotFound.Error()})
+		return
+	}
+
+	if err := h.training.DeleteLabel(c.Request.Context(), historyID); err != nil {
+		if errors.Is(err, services.ErrTrainingLabelNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to delete training label")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete label"})
+		return
+	}
+
+	h.auditLogger(c).WithField("history_id", historyID).Info("Training label removed")
+	c.Status(http.StatusNoContent)
+}
+
+// BulkLabel labels every history entry matching a filter. Use dry_run to
+// see how many entries a filter matches before labeling them.
+func (h *TrainingHandler) BulkLabel(c *gin.Context) {
+	var req BulkLabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if !services.ValidTrainingLabel(req.Label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label must be good or bad"})
+		return
+	}
+	if len(req.Filter.Labels) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filter.labels is only supported when creating datasets"})
+		return
+	}
+	if err := req.Filter.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid filter",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	count, err := h.training.BulkLabel(c.Request.Context(), req.Filter, req.Label, req.Note, userID, req.DryRun)
+	if err != nil {
+		if count > services.MaxBulkTrainingLabels {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":   "filter matches too many entries",
+				"details": err.Error(),
+				"matched": count,
+			})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to bulk label training examples")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to label entries"})
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{"matched": count, "dry_run": true})
+		return
+	}
+
+	h.auditLogger(c).WithFields(logrus.Fields{
+		"label":   req.Label,
+		"filter":  req.Filter,
+		"labeled": count,
+	}).Info("Training examples bulk labeled")
+
+	c.JSON(http.StatusOK, gin.H{"labeled": count})
+}
+
+// ListLabels returns labeled history entries, optionally for one ?label=
+func (h *TrainingHandler) ListLabels(c *gin.Context) {
+	label := c.Query("label")
+	if label != "" && !services.ValidTrainingLabel(label) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "label must be good or bad"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	examples, total, err := h.training.ListLabeled(c.Request.Context(), label, limit, (page-1)*limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list training labels")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list labels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"examples": examples,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// CreateDataset freezes the matching labeled entries into a new dataset version
+func (h *TrainingHandler) CreateDataset(c *gin.Context) {
+	var req services.CreateTrainingDatasetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	userID, _ := middleware.GetUserID(c)
+	dataset, err := h.training.CreateDataset(c.Request.Context(), req, userID)
+	if err != nil {
+		if errors.Is(err, services.ErrTrainingDatasetEmpty) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidTrainingDataset) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid dataset",
+				"details": err.Error(),
+			})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to create training dataset")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create dataset"})
+		return
+	}
+
+	h.auditLogger(c).WithFields(logrus.Fields{
+		"dataset_id":  dataset.ID,
+		"name":        dataset.Name,
+		"version":     dataset.Version,
+		"train_count": dataset.TrainCount,
+		"val_count":   dataset.ValCount,
+	}).Info("Training dataset created")
+
+	c.JSON(http.StatusCreated, dataset)
+}
+
+// ListDatasets returns dataset versions, optionally for one ?name=
+func (h *TrainingHandler) ListDatasets(c *gin.Context) {
+	datasets, err := h.training.ListDatasets(c.Request.Context(), c.Query("name"))
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list training datasets")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list datasets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"datasets": datasets})
+}
+
+// GetDataset returns one dataset version
+func (h *TrainingHandler) GetDataset(c *gin.Context) {
+	dataset, ok := h.lookupDataset(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, dataset)
+}
+
+// ExportDataset streams a dataset version as JSON lines, optionally only
+// one ?split= (train or val)
+func (h *TrainingHandler) ExportDataset(c *gin.Context) {
+	split := c.Query("split")
+	if split != "" && split != "train" && split != "val" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "split must be train or val"})
+		return
+	}
+
+	dataset, ok := h.lookupDataset(c)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("%s-v%d", dataset.Name, dataset.Version)
+	if split != "" {
+		filename += "-" + split
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".jsonl"))
+	c.Status(http.StatusOK)
+
+	written, err := h.training.ExportDataset(c.Request.Context(), dataset.ID, split, c.Writer)
+	logger := h.auditLogger(c).WithFields(logrus.Fields{
+		"dataset_id": dataset.ID,
+		"split":      split,
+		"examples":   written,
+	})
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated file
+		logger.WithError(err).Error("Training dataset export failed")
+		return
+	}
+	logger.Info("Training dataset exported")
+}
+
+func (h *TrainingHandler) lookupDataset(c *gin.Context) (*services.TrainingDataset, bool) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrTrainingDatasetNotFound.Error()})
+		return nil, false
+	}
+
+	dataset, err := h.training.GetDataset(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, services.ErrTrainingDatasetNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		h.logger.WithError(err).Error("Failed to get training dataset")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get dataset"})
+		return nil, false
+	}
+	return dataset, true
+}
+
+// ListIntentLabels lists the intents users picked over the classifier's
+// prediction, most recent first
+func (h *TrainingHandler) ListIntentLabels(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+
+	labels, total, err := h.training.ListIntentLabels(c.Request.Context(), limit, (page-1)*limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list intent labels")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list intent labels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"labels": labels,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
+
+// ExportIntentLabels streams every intent label as JSON lines for retraining
+// the intent classifier
+func (h *TrainingHandler) ExportIntentLabels(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="intent-labels.jsonl"`)
+	c.Status(http.StatusOK)
+
+	written, err := h.training.ExportIntentLabels(c.Request.Context(), c.Writer)
+	logger := h.auditLogger(c).WithField("labels", written)
+	if err != nil {
+		// Headers are already sent, so the client sees a truncated file
+		logger.WithError(err).Error("Intent label export failed")
+		return
+	}
+	logger.Info("Intent labels exported")
+}
@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// GetVersions reports the currently deployed version of every pipeline
+// component: the gateway build, the technique selector rules and the
+// classifier and generator models
+func GetVersions(clients *services.ServiceClients) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"components": clients.DeployedVersions(c.Request.Context()),
+			"checked_at": time.Now().UTC(),
+		})
+	}
+}
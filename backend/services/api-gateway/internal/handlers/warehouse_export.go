@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// WarehouseExportHandler lets admins trigger and monitor warehouse exports
+type WarehouseExportHandler struct {
+	exports *services.WarehouseExportService
+	logger  *logrus.Entry
+}
+
+// NewWarehouseExportHandler creates a new warehouse export handler
+func NewWarehouseExportHandler(exports *services.WarehouseExportService, logger *logrus.Entry) *WarehouseExportHandler {
+	return &WarehouseExportHandler{
+		exports: exports,
+		logger:  logger,
+	}
+}
+
+// TriggerExportRequest starts an export outside the nightly schedule
+type TriggerExportRequest struct {
+	Date     string   `json:"date"`     // YYYY-MM-DD, defaults to yesterday (UTC)
+	Datasets []string `json:"datasets"` // Defaults to every dataset
+	Force    bool     `json:"force"`    // Re-export days that already succeeded
+}
+
+// TriggerExport starts exporting a day in the background. Progress is
+// visible through ListExports.
+func (h *WarehouseExportHandler) TriggerExport(c *gin.Context) {
+	if !h.exports.Enabled() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": services.ErrWarehouseExportsDisabled.Error()})
+		return
+	}
+
+	// An empty body exports yesterday's data for every dataset
+	var req TriggerExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	date := today.AddDate(0, 0, -1)
+	if req.Date != "" {
+		parsed, err := time.Parse("2006-01-02", req.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be formatted YYYY-MM-DD"})
+			return
+		}
+		if !parsed.Before(today) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "only completed days can be exported"})
+			return
+		}
+		date = parsed
+	}
+
+	known := map[string]bool{}
+	for _, name := range services.WarehouseDatasetNames() {
+		known[name] = true
+	}
+	for _, name := range req.Datasets {
+		if !known[name] {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "unknown dataset",
+				"details": name,
+				"allowed": services.WarehouseDatasetNames(),
+			})
+			return
+		}
+	}
+
+	adminID, _ := middleware.GetUserID(c)
+	logger := h.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"admin_id": adminID,
+		"date":     date.Format("2006-01-02"),
+		"datasets": req.Datasets,
+		"force":    req.Force,
+	})
+	logger.Info("Warehouse export triggered")
+
+	// Exports outlive the request
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), services.WarehouseExportTimeout)
+		defer cancel()
+		if _, err := h.exports.Export(ctx, date, req.Datasets, adminID, req.Force); err != nil {
+			logger.WithError(err).Error("Triggered warehouse export failed")
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "started",
+		"date":   date.Format("2006-01-02"),
+	})
+}
+
+// ListExports returns recent export runs, optionally for one ?dataset=,
+// ?limit= runs (default 50, max 200)
+func (h *WarehouseExportHandler) ListExports(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+		return
+	}
+
+	exports, err := h.exports.ListExports(c.Request.Context(), c.Query("dataset"), limit)
+	if err != nil {
+		h.logger.WithError(err).Error("Failed to list warehouse exports")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list exports"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"exports":  exports,
+		"datasets": services.WarehouseDatasetNames(),
+		"enabled":  h.exports.Enabled(),
+	})
+}
+
+// GetExport returns one export run
+func (h *WarehouseExportHandler) GetExport(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrWarehouseExportNotFound.Error()})
+		return
+	}
+
+	export, err := h.exports.GetExport(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, services.ErrWarehouseExportNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.WithError(err).Error("Failed to get warehouse export")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get export"})
+		return
+	}
+
+	c.JSON(http.StatusOK, export)
+}
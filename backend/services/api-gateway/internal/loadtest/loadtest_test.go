@@ -0,0 +1,150 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, Percentile(sorted, 50))
+	assert.Equal(t, 95*time.Millisecond, Percentile(sorted, 95))
+	assert.Equal(t, 100*time.Millisecond, Percentile(sorted, 100))
+	assert.Equal(t, 1*time.Millisecond, Percentile(sorted, 0))
+	assert.Equal(t, 7*time.Millisecond, Percentile([]time.Duration{7 * time.Millisecond}, 99))
+	assert.Equal(t, time.Duration(0), Percentile(nil, 95))
+}
+
+func TestRateAt(t *testing.T) {
+	p := Profile{Stages: []Stage{
+		{Duration: Duration(10 * time.Second), RPS: 100, Ramp: true},
+		{Duration: Duration(10 * time.Second), RPS: 100},
+		{Duration: Duration(10 * time.Second), RPS: 20, Ramp: true},
+	}}
+
+	assert.Equal(t, 0.0, p.RateAt(0))
+	assert.InDelta(t, 50, p.RateAt(5*time.Second), 1e-9)
+	assert.Equal(t, 100.0, p.RateAt(15*time.Second))
+	assert.InDelta(t, 60, p.RateAt(25*time.Second), 1e-9)
+	assert.Equal(t, -1.0, p.RateAt(30*time.Second))
+	assert.Equal(t, 30*time.Second, p.Duration())
+}
+
+func TestEvaluate(t *testing.T) {
+	maxErrors := 0.01
+	report := &Report{
+		Total: Stats{Requests: 100, Errors: 2, ErrorRate: 0.02, RPS: 10, P95: Duration(400 * time.Millisecond)},
+		Scenarios: map[string]*Stats{
+			"enhance": {Requests: 60, P95: Duration(1200 * time.Millisecond), P99: Duration(1500 * time.Millisecond)},
+		},
+	}
+
+	report.Evaluate([]SLO{
+		{P95: Duration(500 * time.Millisecond), MaxErrorRate: &maxErrors},
+		{Scenario: "enhance", P95: Duration(time.Second), P99: Duration(2 * time.Second)},
+	})
+
+	assert.False(t, report.Passed)
+	require.Len(t, report.Violations, 2)
+	assert.Equal(t, Violation{Scenario: "all", Metric: "error_rate", Limit: "1.00%", Actual: "2.00%"}, report.Violations[0])
+	assert.Equal(t, Violation{Scenario: "enhance", Metric: "p95", Limit: "1s", Actual: "1.2s"}, report.Violations[1])
+
+	report.Evaluate([]SLO{{P95: Duration(500 * time.Millisecond)}})
+	assert.True(t, report.Passed)
+	assert.Empty(t, report.Violations)
+}
+
+func TestLoadProfile(t *testing.T) {
+	for _, name := range ProfileNames() {
+		profile, err := LoadProfile(name)
+		require.NoError(t, err)
+		assert.NoError(t, profile.Validate(), name)
+	}
+
+	path := filepath.Join(t.TempDir(), "profile.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"stages": [{"duration": "2s", "rps": 5}],
+		"scenarios": [{"name": "health", "path": "/api/v1/health", "weight": 1}],
+		"slos": [{"scenario": "health", "p95": "250ms", "max_error_rate": 0}]
+	}`), 0o600))
+
+	profile, err := LoadProfile(path)
+	require.NoError(t, err)
+	assert.Equal(t, path, profile.Name)
+	assert.Equal(t, http.MethodGet, profile.Scenarios[0].Method)
+	assert.Equal(t, Duration(250*time.Millisecond), profile.SLOs[0].P95)
+	require.NotNil(t, profile.SLOs[0].MaxErrorRate)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"stages": [{"duration": "2s", "rps": 5}],
+		"scenarios": [{"name": "health", "path": "/api/v1/health", "weight": 1}],
+		"slos": [{"scenario": "enhance", "p95": "1s"}]
+	}`), 0o600))
+	_, err = LoadProfile(path)
+	assert.ErrorContains(t, err, "unknown scenario")
+
+	_, err = LoadProfile("no-such-profile")
+	assert.Error(t, err)
+}
+
+func TestRunnerRun(t *testing.T) {
+	var calls, authorized int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		if r.Header.Get("Authorization") == "Bearer test-token" {
+			atomic.AddInt64(&authorized, 1)
+		}
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	noErrors := 0.0
+	profile := Profile{
+		Name:   "test",
+		Stages: []Stage{{Duration: Duration(500 * time.Millisecond), RPS: 40}},
+		Scenarios: []Scenario{
+			{Name: "ok", Method: http.MethodGet, Path: "/ok", Weight: 3},
+			{Name: "broken", Method: http.MethodGet, Path: "/broken", Weight: 1},
+		},
+		SLOs: []SLO{
+			{Scenario: "ok", P95: Duration(time.Second), MaxErrorRate: &noErrors},
+			{Scenario: "broken", MaxErrorRate: &noErrors},
+		},
+	}
+
+	report, err := NewRunner(server.URL+"/", "test-token").Run(context.Background(), profile)
+	require.NoError(t, err)
+
+	assert.Equal(t, int(atomic.LoadInt64(&calls)), report.Total.Requests)
+	assert.InDelta(t, 20, report.Total.Requests, 3)
+	assert.Equal(t, atomic.LoadInt64(&calls), atomic.LoadInt64(&authorized))
+	assert.Equal(t, server.URL, report.Target)
+
+	ok, broken := report.Scenarios["ok"], report.Scenarios["broken"]
+	require.NotNil(t, ok)
+	require.NotNil(t, broken)
+	assert.Zero(t, ok.Errors)
+	assert.Equal(t, broken.Requests, broken.Errors)
+	assert.Equal(t, broken.Requests, broken.Failures["status 500"])
+
+	assert.False(t, report.Passed)
+	require.Len(t, report.Violations, 1)
+	assert.Equal(t, "broken", report.Violations[0].Scenario)
+}
@@ -0,0 +1,259 @@
+// Package loadtest drives configurable request rates against a running
+// gateway, reports latency percentiles and checks them against SLO
+// thresholds so performance regressions fail a build.
+package loadtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+// Duration is a time.Duration that reads and writes as a string such as "30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a Go duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a Go duration string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Stage holds a request rate for a duration. With Ramp, the rate moves
+// linearly from the previous stage's rate (or zero) to RPS instead.
+type Stage struct {
+	Duration Duration `json:"duration"`
+	RPS      float64  `json:"rps"`
+	Ramp     bool     `json:"ramp,omitempty"`
+}
+
+// Scenario is one kind of request in the traffic mix
+type Scenario struct {
+	Name         string            `json:"name"`
+	Method       string            `json:"method"`
+	Path         string            `json:"path"`
+	Body         json.RawMessage   `json:"body,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Weight       int               `json:"weight"`                  // Share of traffic relative to the other scenarios
+	ExpectStatus []int             `json:"expect_status,omitempty"` // Defaults to any 2xx
+}
+
+// expected reports whether a response status counts as a success
+func (s Scenario) expected(status int) bool {
+	if len(s.ExpectStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, code := range s.ExpectStatus {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// SLO is a pass/fail threshold on one scenario, or on all traffic when
+// Scenario is empty. Zero limits aren't checked.
+type SLO struct {
+	Scenario     string   `json:"scenario,omitempty"`
+	P50          Duration `json:"p50,omitempty"`
+	P90          Duration `json:"p90,omitempty"`
+	P95          Duration `json:"p95,omitempty"`
+	P99          Duration `json:"p99,omitempty"`
+	MaxErrorRate *float64 `json:"max_error_rate,omitempty"`
+	MinRPS       float64  `json:"min_rps,omitempty"`
+}
+
+// Profile is a traffic shape, request mix and the SLOs it must meet
+type Profile struct {
+	Name      string     `json:"name"`
+	Stages    []Stage    `json:"stages"`
+	Scenarios []Scenario `json:"scenarios"`
+	SLOs      []SLO      `json:"slos"`
+}
+
+// Duration is the total length of the profile's stages
+func (p Profile) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range p.Stages {
+		total += time.Duration(stage.Duration)
+	}
+	return total
+}
+
+// RateAt returns the target request rate at elapsed time into the profile,
+// or -1 once every stage has finished
+func (p Profile) RateAt(elapsed time.Duration) float64 {
+	previous := 0.0
+	for _, stage := range p.Stages {
+		length := time.Duration(stage.Duration)
+		if elapsed < length {
+			if !stage.Ramp {
+				return stage.RPS
+			}
+			progress := float64(elapsed) / float64(length)
+			return previous + (stage.RPS-previous)*progress
+		}
+		elapsed -= length
+		previous = stage.RPS
+	}
+	return -1
+}
+
+// Validate checks the profile can be run
+func (p Profile) Validate() error {
+	if len(p.Stages) == 0 {
+		return errors.New("profile has no stages")
+	}
+	for i, stage := range p.Stages {
+		if stage.Duration <= 0 {
+			return fmt.Errorf("stage %d: duration must be positive", i+1)
+		}
+		if stage.RPS < 0 {
+			return fmt.Errorf("stage %d: rps must not be negative", i+1)
+		}
+	}
+
+	if len(p.Scenarios) == 0 {
+		return errors.New("profile has no scenarios")
+	}
+	names := map[string]bool{}
+	for _, scenario := range p.Scenarios {
+		if scenario.Name == "" || scenario.Path == "" {
+			return errors.New("scenarios need a name and a path")
+		}
+		if names[scenario.Name] {
+			return fmt.Errorf("duplicate scenario %q", scenario.Name)
+		}
+		names[scenario.Name] = true
+		if scenario.Weight < 1 {
+			return fmt.Errorf("scenario %q: weight must be at least 1", scenario.Name)
+		}
+	}
+
+	for _, slo := range p.SLOs {
+		if slo.Scenario != "" && !names[slo.Scenario] {
+			return fmt.Errorf("SLO for unknown scenario %q", slo.Scenario)
+		}
+	}
+	return nil
+}
+
+// LoadProfile returns a built-in profile by name, or reads one from a JSON file
+func LoadProfile(nameOrPath string) (Profile, error) {
+	if profile, ok := builtinProfiles()[nameOrPath]; ok {
+		return profile, nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return Profile{}, fmt.Errorf("unknown profile %q (built in: %v): %w", nameOrPath, ProfileNames(), err)
+	}
+	var profile Profile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return Profile{}, fmt.Errorf("invalid profile %s: %w", nameOrPath, err)
+	}
+	for i := range profile.Scenarios {
+		if profile.Scenarios[i].Method == "" {
+			profile.Scenarios[i].Method = http.MethodGet
+		}
+	}
+	if profile.Name == "" {
+		profile.Name = nameOrPath
+	}
+	return profile, profile.Validate()
+}
+
+// ProfileNames lists the built-in profiles
+func ProfileNames() []string {
+	var names []string
+	for name := range builtinProfiles() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func errorRate(rate float64) *float64 {
+	return &rate
+}
+
+// enhanceScenarios is the default traffic mix: mostly enhancements with
+// some cheap reads. Enhancements need auth when the gateway requires it.
+func enhanceScenarios() []Scenario {
+	return []Scenario{
+		{
+			Name:   "enhance",
+			Method: http.MethodPost,
+			Path:   "/api/v1/enhance",
+			Body:   json.RawMessage(`{"text":"Write a function to sort an array of users by signup date"}`),
+			Weight: 3,
+		},
+		{
+			Name:   "techniques",
+			Method: http.MethodGet,
+			Path:   "/api/v1/techniques",
+			Weight: 1,
+		},
+		{
+			Name:   "health",
+			Method: http.MethodGet,
+			Path:   "/api/v1/health",
+			Weight: 1,
+		},
+	}
+}
+
+// builtinProfiles mirror the thresholds of the k6 suites in tests/performance
+func builtinProfiles() map[string]Profile {
+	return map[string]Profile{
+		"smoke": {
+			Name:      "smoke",
+			Stages:    []Stage{{Duration: Duration(30 * time.Second), RPS: 2}},
+			Scenarios: enhanceScenarios(),
+			SLOs: []SLO{
+				{MaxErrorRate: errorRate(0.01)},
+				{Scenario: "enhance", P95: Duration(3 * time.Second), P99: Duration(5 * time.Second)},
+				{Scenario: "health", P95: Duration(300 * time.Millisecond)},
+			},
+		},
+		"baseline": {
+			Name:      "baseline",
+			Stages:    []Stage{{Duration: Duration(2 * time.Minute), RPS: 10}},
+			Scenarios: enhanceScenarios(),
+			SLOs: []SLO{
+				{P95: Duration(500 * time.Millisecond), MaxErrorRate: errorRate(0.05)},
+			},
+		},
+		"load": {
+			Name: "load",
+			Stages: []Stage{
+				{Duration: Duration(1 * time.Minute), RPS: 50, Ramp: true},
+				{Duration: Duration(5 * time.Minute), RPS: 50},
+				{Duration: Duration(1 * time.Minute), RPS: 100, Ramp: true},
+				{Duration: Duration(5 * time.Minute), RPS: 100},
+				{Duration: Duration(1 * time.Minute), RPS: 0, Ramp: true},
+			},
+			Scenarios: enhanceScenarios(),
+			SLOs: []SLO{
+				{P95: Duration(800 * time.Millisecond), P99: Duration(1500 * time.Millisecond), MaxErrorRate: errorRate(0.01)},
+				{Scenario: "enhance", P95: Duration(1 * time.Second), P99: Duration(2 * time.Second)},
+			},
+		},
+	}
+}
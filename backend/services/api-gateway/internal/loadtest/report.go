@@ -0,0 +1,260 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stats summarizes the requests of one scenario, or of all traffic
+type Stats struct {
+	Requests  int            `json:"requests"`
+	Errors    int            `json:"errors"`
+	ErrorRate float64        `json:"error_rate"`
+	RPS       float64        `json:"rps"`
+	Mean      Duration       `json:"mean"`
+	P50       Duration       `json:"p50"`
+	P90       Duration       `json:"p90"`
+	P95       Duration       `json:"p95"`
+	P99       Duration       `json:"p99"`
+	Max       Duration       `json:"max"`
+	Statuses  map[int]int    `json:"statuses"`
+	Failures  map[string]int `json:"failures,omitempty"` // Transport errors and unexpected statuses
+}
+
+// Violation is an SLO the run didn't meet
+type Violation struct {
+	Scenario string `json:"scenario"`
+	Metric   string `json:"metric"`
+	Limit    string `json:"limit"`
+	Actual   string `json:"actual"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s %s: %s exceeds %s", v.Scenario, v.Metric, v.Actual, v.Limit)
+}
+
+// Report is the outcome of running a profile
+type Report struct {
+	Profile    string            `json:"profile"`
+	Target     string            `json:"target"`
+	StartedAt  time.Time         `json:"started_at"`
+	Elapsed    Duration          `json:"elapsed"`
+	Dropped    int               `json:"dropped"` // Requests skipped because too many were in flight
+	Total      Stats             `json:"total"`
+	Scenarios  map[string]*Stats `json:"scenarios"`
+	Violations []Violation       `json:"violations"`
+	Passed     bool              `json:"passed"`
+}
+
+// Evaluate checks the report against slos, recording any violations
+func (r *Report) Evaluate(slos []SLO) {
+	r.Violations = []Violation{}
+	for _, slo := range slos {
+		name, stats := "all", &r.Total
+		if slo.Scenario != "" {
+			name, stats = slo.Scenario, r.Scenarios[slo.Scenario]
+			if stats == nil {
+				stats = &Stats{}
+			}
+		}
+
+		latencies := []struct {
+			metric string
+			limit  Duration
+			actual Duration
+		}{
+			{"p50", slo.P50, stats.P50},
+			{"p90", slo.P90, stats.P90},
+			{"p95", slo.P95, stats.P95},
+			{"p99", slo.P99, stats.P99},
+		}
+		for _, l := range latencies {
+			if l.limit > 0 && l.actual > l.limit {
+				r.Violations = append(r.Violations, Violation{
+					Scenario: name,
+					Metric:   l.metric,
+					Limit:    time.Duration(l.limit).String(),
+					Actual:   time.Duration(l.actual).String(),
+				})
+			}
+		}
+
+		if slo.MaxErrorRate != nil && stats.ErrorRate > *slo.MaxErrorRate {
+			r.Violations = append(r.Violations, Violation{
+				Scenario: name,
+				Metric:   "error_rate",
+				Limit:    formatPercent(*slo.MaxErrorRate),
+				Actual:   formatPercent(stats.ErrorRate),
+			})
+		}
+		if slo.MinRPS > 0 && stats.RPS < slo.MinRPS {
+			r.Violations = append(r.Violations, Violation{
+				Scenario: name,
+				Metric:   "rps",
+				Limit:    fmt.Sprintf("at least %.1f", slo.MinRPS),
+				Actual:   fmt.Sprintf("%.1f", stats.RPS),
+			})
+		}
+	}
+	r.Passed = len(r.Violations) == 0
+}
+
+// WriteText writes a human-readable summary of the report
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Profile %s against %s: %d requests in %s",
+		r.Profile, r.Target, r.Total.Requests, time.Duration(r.Elapsed).Round(time.Millisecond))
+	if r.Dropped > 0 {
+		fmt.Fprintf(&b, " (%d dropped)", r.Dropped)
+	}
+	b.WriteString("\n\n")
+
+	fmt.Fprintf(&b, "%-16s %8s %8s %8s %10s %10s %10s %10s %10s\n",
+		"scenario", "requests", "errors", "rps", "p50", "p90", "p95", "p99", "max")
+	row := func(name string, s *Stats) {
+		fmt.Fprintf(&b, "%-16s %8d %7.2f%% %8.1f %10s %10s %10s %10s %10s\n",
+			name, s.Requests, s.ErrorRate*100, s.RPS,
+			roundMillis(s.P50), roundMillis(s.P90), roundMillis(s.P95), roundMillis(s.P99), roundMillis(s.Max))
+	}
+	for _, name := range r.scenarioNames() {
+		row(name, r.Scenarios[name])
+	}
+	row("all", &r.Total)
+
+	for _, name := range r.scenarioNames() {
+		for failure, count := range r.Scenarios[name].Failures {
+			fmt.Fprintf(&b, "\n%s: %d x %s", name, count, failure)
+		}
+	}
+
+	if r.Passed {
+		b.WriteString("\n\nPASS: all SLOs met\n")
+	} else {
+		b.WriteString("\n\nFAIL:\n")
+		for _, v := range r.Violations {
+			fmt.Fprintf(&b, "  %s\n", v)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r *Report) scenarioNames() []string {
+	names := make([]string, 0, len(r.Scenarios))
+	for name := range r.Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// result is the outcome of one request
+type result struct {
+	scenario string
+	latency  time.Duration
+	status   int
+	failure  string // Empty for successful requests
+}
+
+// recorder collects results from concurrent requests
+type recorder struct {
+	mu      sync.Mutex
+	results []result
+}
+
+func (r *recorder) add(res result) {
+	r.mu.Lock()
+	r.results = append(r.results, res)
+	r.mu.Unlock()
+}
+
+// report aggregates the recorded results over elapsed
+func (r *recorder) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byScenario := map[string][]result{}
+	for _, res := range r.results {
+		byScenario[res.scenario] = append(byScenario[res.scenario], res)
+	}
+
+	report := &Report{
+		Elapsed:   Duration(elapsed),
+		Total:     summarize(r.results, elapsed),
+		Scenarios: map[string]*Stats{},
+	}
+	for name, results := range byScenario {
+		stats := summarize(results, elapsed)
+		report.Scenarios[name] = &stats
+	}
+	return report
+}
+
+// summarize computes stats for results collected over elapsed
+func summarize(results []result, elapsed time.Duration) Stats {
+	stats := Stats{
+		Requests: len(results),
+		Statuses: map[int]int{},
+		Failures: map[string]int{},
+	}
+	if len(results) == 0 {
+		return stats
+	}
+
+	latencies := make([]time.Duration, 0, len(results))
+	var total time.Duration
+	for _, res := range results {
+		latencies = append(latencies, res.latency)
+		total += res.latency
+		if res.status != 0 {
+			stats.Statuses[res.status]++
+		}
+		if res.failure != "" {
+			stats.Errors++
+			stats.Failures[res.failure]++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+	if elapsed > 0 {
+		stats.RPS = float64(stats.Requests) / elapsed.Seconds()
+	}
+	stats.Mean = Duration(total / time.Duration(len(latencies)))
+	stats.P50 = Duration(Percentile(latencies, 50))
+	stats.P90 = Duration(Percentile(latencies, 90))
+	stats.P95 = Duration(Percentile(latencies, 95))
+	stats.P99 = Duration(Percentile(latencies, 99))
+	stats.Max = Duration(latencies[len(latencies)-1])
+	return stats
+}
+
+// Percentile returns the nearest-rank percentile p (0-100) of sorted
+// latencies
+func Percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func roundMillis(d Duration) string {
+	return time.Duration(d).Round(100 * time.Microsecond).String()
+}
+
+func formatPercent(rate float64) string {
+	return fmt.Sprintf("%.2f%%", rate*100)
+}
@@ -0,0 +1,170 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// idleStep is how often the runner rechecks the rate while it is zero
+const idleStep = 50 * time.Millisecond
+
+// Runner sends a profile's traffic to a gateway. Requests are issued on
+// schedule whether or not earlier ones have finished, so a slow gateway
+// shows up as latency rather than as a lower request rate.
+type Runner struct {
+	Target      string       // Base URL of the gateway, e.g. http://localhost:8080
+	Token       string       // Bearer token sent with every request when set
+	Client      *http.Client // Per-request timeout comes from the client
+	MaxInFlight int          // Requests beyond this are dropped and counted as errors
+	Seed        int64        // Seeds the scenario mix so runs are repeatable
+}
+
+// NewRunner creates a runner for target with default limits
+func NewRunner(target, token string) *Runner {
+	return &Runner{
+		Target:      strings.TrimRight(target, "/"),
+		Token:       token,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		MaxInFlight: 256,
+		Seed:        1,
+	}
+}
+
+// Run sends profile's traffic, waits for outstanding requests and returns
+// the report evaluated against the profile's SLOs. If ctx is cancelled the
+// report covers the requests sent so far and ctx's error is returned with it.
+func (r *Runner) Run(ctx context.Context, profile Profile) (*Report, error) {
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Expand weights so picking a scenario is a single random index
+	var mix []*Scenario
+	for i := range profile.Scenarios {
+		for w := 0; w < profile.Scenarios[i].Weight; w++ {
+			mix = append(mix, &profile.Scenarios[i])
+		}
+	}
+	rng := rand.New(rand.NewSource(r.Seed))
+
+	rec := &recorder{}
+	inFlight := make(chan struct{}, r.MaxInFlight)
+	var wg sync.WaitGroup
+	dropped := 0
+
+	start := time.Now()
+	next := start
+	var runErr error
+
+schedule:
+	for {
+		rate := profile.RateAt(next.Sub(start))
+		if rate < 0 {
+			break
+		}
+
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-ctx.Done():
+				runErr = ctx.Err()
+				break schedule
+			case <-time.After(wait):
+			}
+		}
+
+		if rate == 0 {
+			next = next.Add(idleStep)
+			continue
+		}
+		next = next.Add(time.Duration(float64(time.Second) / rate))
+
+		scenario := mix[rng.Intn(len(mix))]
+		select {
+		case inFlight <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-inFlight }()
+				rec.add(r.send(scenario))
+			}()
+		default:
+			dropped++
+			rec.add(result{scenario: scenario.Name, failure: "dropped: too many requests in flight"})
+		}
+	}
+
+	wg.Wait()
+
+	report := rec.report(time.Since(start))
+	report.Profile = profile.Name
+	report.Target = r.Target
+	report.StartedAt = start
+	report.Dropped = dropped
+	report.Evaluate(profile.SLOs)
+	return report, runErr
+}
+
+// send issues one request and times it through reading the full body.
+// Requests aren't tied to the run's context so cancelling a run lets
+// in-flight requests finish and be counted.
+func (r *Runner) send(scenario *Scenario) result {
+	res := result{scenario: scenario.Name}
+
+	var body io.Reader
+	if len(scenario.Body) > 0 {
+		body = bytes.NewReader(scenario.Body)
+	}
+	req, err := http.NewRequest(scenario.Method, r.Target+scenario.Path, body)
+	if err != nil {
+		res.failure = "invalid request: " + err.Error()
+		return res
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+	for key, value := range scenario.Headers {
+		req.Header.Set(key, value)
+	}
+
+	start := time.Now()
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		res.latency = time.Since(start)
+		res.failure = transportFailure(err)
+		return res
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	res.latency = time.Since(start)
+	res.status = resp.StatusCode
+
+	switch {
+	case err != nil:
+		res.failure = transportFailure(err)
+	case !scenario.expected(resp.StatusCode):
+		res.failure = fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	return res
+}
+
+// transportFailure reduces a client error to a short label so failures
+// group in the report instead of listing every URL and port
+func transportFailure(err error) string {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return "timeout"
+	}
+	return "connection error"
+}
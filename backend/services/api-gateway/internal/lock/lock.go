@@ -0,0 +1,279 @@
+// Package lock implements distributed locks on Redis.
+//
+// A lock is a lease: it expires after its TTL unless renewed, so a holder
+// that crashes or stalls can't keep it forever. Held locks are renewed in the
+// background until released. Expiry means two holders can briefly believe
+// they hold the same lock, the stalled one and the one that took over, so
+// every acquisition also gets a fencing token: a number that grows with each
+// acquisition of a key. Pass it along with writes to the resource the lock
+// protects and have the resource refuse writes carrying a lower token than
+// one it has already seen.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrNotAcquired is returned by TryAcquire when the lock is held
+	// elsewhere
+	ErrNotAcquired = errors.New("lock is held elsewhere")
+	// ErrLost means a lease expired before it was renewed, and the lock may
+	// have been taken over since
+	ErrLost = errors.New("lock lost")
+)
+
+// Default lease timings
+const (
+	DefaultTTL           = 30 * time.Second
+	DefaultRetryInterval = 100 * time.Millisecond
+)
+
+// Options tune an acquisition. Zero values take the defaults.
+type Options struct {
+	TTL           time.Duration // How long the lease lasts without renewal
+	RenewInterval time.Duration // How often a held lock is renewed; a third of the TTL by default, negative to never renew
+	RetryInterval time.Duration // How often Acquire tries again while the lock is held elsewhere
+}
+
+func (o Options) withDefaults() Options {
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+	if o.RenewInterval == 0 {
+		o.RenewInterval = o.TTL / 3
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = DefaultRetryInterval
+	}
+	return o
+}
+
+// store keeps leases. Each method is atomic.
+type store interface {
+	// acquire takes key for token unless it is held, returning the key's
+	// next fencing token, or ErrNotAcquired
+	acquire(ctx context.Context, key, token string, ttl time.Duration) (int64, error)
+	// extend renews the lease of key if token still holds it, else returns
+	// ErrLost
+	extend(ctx context.Context, key, token string, ttl time.Duration) error
+	// release frees key if token still holds it, else returns ErrLost
+	release(ctx context.Context, key, token string) error
+	// held reports whether anyone holds key
+	held(ctx context.Context, key string) (bool, error)
+}
+
+// Locker takes locks. Keys are Redis keys and used as given; fencing tokens
+// are counted under the key with ":fence" appended.
+type Locker struct {
+	store store
+	token func() string
+}
+
+// New creates a locker keeping its locks in client
+func New(client redis.Cmdable) *Locker {
+	return newLocker(&redisStore{client: client})
+}
+
+func newLocker(s store) *Locker {
+	return &Locker{store: s, token: newToken}
+}
+
+// TryAcquire takes the lock on key, returning ErrNotAcquired right away if
+// it is held elsewhere. ctx only bounds the attempt; the lock is held until
+// released or lost.
+func (l *Locker) TryAcquire(ctx context.Context, key string, options Options) (*Lock, error) {
+	options = options.withDefaults()
+	token := l.token()
+	start := time.Now()
+	fence, err := l.store.acquire(ctx, key, token, options.TTL)
+	if err != nil {
+		if errors.Is(err, ErrNotAcquired) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+	return newLock(l.store, key, token, fence, start, options), nil
+}
+
+// Acquire takes the lock on key, waiting for it while it is held elsewhere
+// until ctx is done
+func (l *Locker) Acquire(ctx context.Context, key string, options Options) (*Lock, error) {
+	options = options.withDefaults()
+	ticker := time.NewTicker(options.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		lock, err := l.TryAcquire(ctx, key, options)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lock, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Held reports whether the lock on key is held by anyone
+func (l *Locker) Held(ctx context.Context, key string) (bool, error) {
+	held, err := l.store.held(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to check lock %s: %w", key, err)
+	}
+	return held, nil
+}
+
+// Lock is a held lock
+type Lock struct {
+	store store
+	key   string
+	token string
+	fence int64
+	ttl   time.Duration
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	stop   chan struct{} // Closed to stop renewing
+	done   chan struct{} // Closed once renewal stopped
+	expiry *time.Timer   // Loses an unrenewed lock when its lease runs out
+
+	mu       sync.Mutex
+	deadline time.Time // When the lease runs out unless renewed
+	err      error     // ErrLost once lost
+	released bool
+}
+
+func newLock(s store, key, token string, fence int64, start time.Time, options Options) *Lock {
+	lock := &Lock{
+		store:    s,
+		key:      key,
+		token:    token,
+		fence:    fence,
+		ttl:      options.TTL,
+		deadline: start.Add(options.TTL),
+	}
+	lock.ctx, lock.cancel = context.WithCancelCause(context.Background())
+	if options.RenewInterval > 0 {
+		lock.stop, lock.done = make(chan struct{}), make(chan struct{})
+		go lock.renew(options.RenewInterval)
+	} else {
+		lock.expiry = time.AfterFunc(time.Until(lock.deadline), func() {
+			lock.lose(fmt.Errorf("%w: lease expired", ErrLost))
+		})
+	}
+	return lock
+}
+
+// Key returns the key locked
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Fence returns the lock's fencing token. Tokens of later acquisitions of
+// the same key are greater.
+func (l *Lock) Fence() int64 {
+	return l.fence
+}
+
+// Context returns a context cancelled once the lock is lost or released.
+// Work done under the lock should run in it.
+func (l *Lock) Context() context.Context {
+	return l.ctx
+}
+
+// Err returns ErrLost once the lock is lost, nil while it is held or after
+// it was released
+func (l *Lock) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Release frees the lock and stops renewing it. It returns ErrLost when the
+// lease had already run out, in which case the lock may be someone else's
+// and is left alone.
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	if l.released {
+		l.mu.Unlock()
+		return nil
+	}
+	l.released = true
+	lost := l.err
+	l.mu.Unlock()
+
+	if l.expiry != nil {
+		l.expiry.Stop()
+	} else {
+		close(l.stop)
+		<-l.done
+	}
+	defer l.cancel(context.Canceled)
+	if lost != nil {
+		return lost
+	}
+	if err := l.store.release(ctx, l.key, l.token); err != nil {
+		if errors.Is(err, ErrLost) {
+			return err
+		}
+		return fmt.Errorf("failed to release lock %s: %w", l.key, err)
+	}
+	return nil
+}
+
+// renew extends the lease every interval until the lock is released or
+// lost. Failing to reach Redis isn't losing the lock, but once the lease
+// runs out without a renewal it may have been taken over, so it is.
+func (l *Lock) renew(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := l.store.extend(ctx, l.key, l.token, l.ttl)
+		cancel()
+
+		l.mu.Lock()
+		switch {
+		case err == nil:
+			l.deadline = start.Add(l.ttl)
+			l.mu.Unlock()
+			continue
+		case errors.Is(err, ErrLost):
+		case time.Now().Before(l.deadline):
+			l.mu.Unlock()
+			continue
+		default:
+			err = fmt.Errorf("%w: lease expired while renewal failed: %v", ErrLost, err)
+		}
+		l.mu.Unlock()
+		l.lose(err)
+		return
+	}
+}
+
+// lose marks the lock lost and cancels its context
+func (l *Lock) lose(err error) {
+	l.mu.Lock()
+	if l.err == nil && !l.released {
+		l.err = err
+	}
+	l.mu.Unlock()
+	l.cancel(err)
+}
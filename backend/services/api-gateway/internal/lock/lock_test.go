@@ -0,0 +1,319 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryStore keeps leases in memory on a clock the test can move
+type memoryStore struct {
+	mu      sync.Mutex
+	offset  time.Duration // Added to the wall clock
+	leases  map[string]memoryLease
+	fences  map[string]int64
+	failing atomic.Bool // Fail every call as if Redis were down
+}
+
+type memoryLease struct {
+	token   string
+	expires time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{leases: map[string]memoryLease{}, fences: map[string]int64{}}
+}
+
+var errStoreDown = errors.New("connection refused")
+
+// advance moves the store's clock, expiring leases as Redis would
+func (s *memoryStore) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// current returns key's unexpired lease. Call with mu held.
+func (s *memoryStore) current(key string) (memoryLease, bool) {
+	lease, ok := s.leases[key]
+	if !ok || !time.Now().Add(s.offset).Before(lease.expires) {
+		return memoryLease{}, false
+	}
+	return lease, true
+}
+
+func (s *memoryStore) acquire(_ context.Context, key, token string, ttl time.Duration) (int64, error) {
+	if s.failing.Load() {
+		return 0, errStoreDown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, held := s.current(key); held {
+		return 0, ErrNotAcquired
+	}
+	s.leases[key] = memoryLease{token: token, expires: time.Now().Add(s.offset + ttl)}
+	s.fences[key]++
+	return s.fences[key], nil
+}
+
+func (s *memoryStore) extend(_ context.Context, key, token string, ttl time.Duration) error {
+	if s.failing.Load() {
+		return errStoreDown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, held := s.current(key); !held || lease.token != token {
+		return ErrLost
+	}
+	s.leases[key] = memoryLease{token: token, expires: time.Now().Add(s.offset + ttl)}
+	return nil
+}
+
+func (s *memoryStore) release(_ context.Context, key, token string) error {
+	if s.failing.Load() {
+		return errStoreDown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if lease, held := s.current(key); !held || lease.token != token {
+		return ErrLost
+	}
+	delete(s.leases, key)
+	return nil
+}
+
+func (s *memoryStore) held(_ context.Context, key string) (bool, error) {
+	if s.failing.Load() {
+		return false, errStoreDown
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, held := s.current(key)
+	return held, nil
+}
+
+// unrenewed leases last an hour unless the test moves the clock
+var unrenewed = Options{TTL: time.Hour, RenewInterval: -1}
+
+func TestTryAcquire(t *testing.T) {
+	ctx := context.Background()
+	locker := newLocker(newMemoryStore())
+
+	first, err := locker.TryAcquire(ctx, "jobs:cohorts", unrenewed)
+	require.NoError(t, err)
+	_, err = locker.TryAcquire(ctx, "jobs:cohorts", unrenewed)
+	assert.ErrorIs(t, err, ErrNotAcquired)
+	held, err := locker.Held(ctx, "jobs:cohorts")
+	require.NoError(t, err)
+	assert.True(t, held)
+
+	other, err := locker.TryAcquire(ctx, "jobs:trends", unrenewed)
+	require.NoError(t, err, "keys lock independently")
+	require.NoError(t, other.Release(ctx))
+
+	require.NoError(t, first.Release(ctx))
+	require.NoError(t, first.Release(ctx), "releasing twice is harmless")
+	assert.ErrorIs(t, first.Context().Err(), context.Canceled)
+	assert.NoError(t, first.Err())
+
+	second, err := locker.TryAcquire(ctx, "jobs:cohorts", unrenewed)
+	require.NoError(t, err)
+	assert.Greater(t, second.Fence(), first.Fence())
+	require.NoError(t, second.Release(ctx))
+}
+
+func TestConcurrentAcquisition(t *testing.T) {
+	ctx := context.Background()
+	locker := newLocker(newMemoryStore())
+
+	var wg sync.WaitGroup
+	var winners atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := locker.TryAcquire(ctx, "warm", unrenewed); err == nil {
+				winners.Add(1)
+			} else {
+				assert.ErrorIs(t, err, ErrNotAcquired)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), winners.Load())
+}
+
+func TestExpiredLockIsTakenOver(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore()
+	locker := newLocker(store)
+
+	stalled, err := locker.TryAcquire(ctx, "migrations", unrenewed)
+	require.NoError(t, err)
+
+	// The holder stalls past its lease and another takes over
+	store.advance(2 * time.Hour)
+	successor, err := locker.TryAcquire(ctx, "migrations", unrenewed)
+	require.NoError(t, err)
+	assert.Greater(t, successor.Fence(), stalled.Fence(), "the successor's writes fence off the stalled holder's")
+
+	// Waking up, the stalled holder can neither renew nor free the successor's lock
+	assert.ErrorIs(t, store.extend(ctx, "migrations", stalled.token, time.Hour), ErrLost)
+	assert.ErrorIs(t, stalled.Release(ctx), ErrLost)
+	held, err := locker.Held(ctx, "migrations")
+	require.NoError(t, err)
+	assert.True(t, held)
+	require.NoError(t, successor.Release(ctx))
+}
+
+func TestRenewal(t *testing.T) {
+	ctx := context.Background()
+	options := Options{TTL: 60 * time.Millisecond, RenewInterval: 10 * time.Millisecond}
+
+	t.Run("keeps the lease past its TTL", func(t *testing.T) {
+		locker := newLocker(newMemoryStore())
+		held, err := locker.TryAcquire(ctx, "dedup", options)
+		require.NoError(t, err)
+
+		time.Sleep(4 * options.TTL)
+		_, err = locker.TryAcquire(ctx, "dedup", options)
+		assert.ErrorIs(t, err, ErrNotAcquired)
+		assert.NoError(t, held.Err())
+		assert.NoError(t, held.Context().Err())
+		require.NoError(t, held.Release(ctx))
+	})
+
+	t.Run("notices a takeover", func(t *testing.T) {
+		store := newMemoryStore()
+		locker := newLocker(store)
+		held, err := locker.TryAcquire(ctx, "dedup", options)
+		require.NoError(t, err)
+
+		// The lease expires between renewals and is taken over
+		store.advance(time.Hour)
+		_, err = locker.TryAcquire(ctx, "dedup", unrenewed)
+		require.NoError(t, err)
+
+		select {
+		case <-held.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("losing the lock didn't cancel its context")
+		}
+		assert.ErrorIs(t, held.Err(), ErrLost)
+		assert.ErrorIs(t, context.Cause(held.Context()), ErrLost)
+		assert.ErrorIs(t, held.Release(ctx), ErrLost)
+	})
+
+	t.Run("gives up once the lease runs out while Redis is unreachable", func(t *testing.T) {
+		store := newMemoryStore()
+		locker := newLocker(store)
+		start := time.Now()
+		held, err := locker.TryAcquire(ctx, "dedup", options)
+		require.NoError(t, err)
+
+		store.failing.Store(true)
+		time.Sleep(options.TTL / 2)
+		assert.NoError(t, held.Err(), "a failed renewal isn't a lost lease")
+
+		select {
+		case <-held.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("lease never ran out")
+		}
+		assert.GreaterOrEqual(t, time.Since(start), options.TTL)
+		assert.ErrorIs(t, held.Err(), ErrLost)
+	})
+
+	t.Run("unrenewed leases run out", func(t *testing.T) {
+		locker := newLocker(newMemoryStore())
+		held, err := locker.TryAcquire(ctx, "scheduler", Options{TTL: 20 * time.Millisecond, RenewInterval: -1})
+		require.NoError(t, err)
+		select {
+		case <-held.Context().Done():
+		case <-time.After(time.Second):
+			t.Fatal("lease never ran out")
+		}
+		assert.ErrorIs(t, held.Err(), ErrLost)
+	})
+}
+
+func TestAcquireWaits(t *testing.T) {
+	ctx := context.Background()
+	locker := newLocker(newMemoryStore())
+	options := Options{TTL: time.Hour, RenewInterval: -1, RetryInterval: 5 * time.Millisecond}
+
+	held, err := locker.TryAcquire(ctx, "warm", options)
+	require.NoError(t, err)
+
+	timeout, cancel := context.WithTimeout(ctx, 30*time.Millisecond)
+	defer cancel()
+	_, err = locker.Acquire(timeout, "warm", options)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	acquired := make(chan *Lock)
+	go func() {
+		lock, err := locker.Acquire(ctx, "warm", options)
+		assert.NoError(t, err)
+		acquired <- lock
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, held.Release(ctx))
+
+	select {
+	case next := <-acquired:
+		assert.Greater(t, next.Fence(), held.Fence())
+		require.NoError(t, next.Release(ctx))
+	case <-time.After(time.Second):
+		t.Fatal("Acquire didn't take the released lock")
+	}
+}
+
+func TestAcquireReportsStoreErrors(t *testing.T) {
+	store := newMemoryStore()
+	store.failing.Store(true)
+	_, err := newLocker(store).Acquire(context.Background(), "warm", Options{})
+	assert.ErrorIs(t, err, errStoreDown, "Redis failures aren't waited out")
+	assert.NotErrorIs(t, err, ErrNotAcquired)
+}
+
+func TestRedisLocks(t *testing.T) {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR not set")
+	}
+	ctx := context.Background()
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+	key := "betterprompts:test:lock:" + newToken()
+	defer client.Del(ctx, key, key+":fence")
+
+	locker := New(client)
+	options := Options{TTL: 200 * time.Millisecond, RenewInterval: -1}
+	first, err := locker.TryAcquire(ctx, key, options)
+	require.NoError(t, err)
+	_, err = locker.TryAcquire(ctx, key, options)
+	assert.ErrorIs(t, err, ErrNotAcquired)
+
+	time.Sleep(2 * options.TTL)
+	second, err := locker.TryAcquire(ctx, key, options)
+	require.NoError(t, err, "expired leases are free")
+	assert.Equal(t, first.Fence()+1, second.Fence())
+	assert.ErrorIs(t, first.Release(ctx), ErrLost)
+
+	renewed, err := locker.Acquire(ctx, key, Options{TTL: 100 * time.Millisecond, RetryInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	assert.NoError(t, renewed.Err())
+	require.NoError(t, renewed.Release(ctx))
+	held, err := locker.Held(ctx, key)
+	require.NoError(t, err)
+	assert.False(t, held)
+}
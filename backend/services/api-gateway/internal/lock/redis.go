@@ -0,0 +1,77 @@
+package lock
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// acquireScript sets the lock if it is free and counts the key's fencing
+// token up in the same step, so tokens are ordered like acquisitions
+var acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+
+// extendScript renews the lease only if it is still held by the given token
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript deletes the lock only if it is still held by the given token
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// redisStore keeps leases in Redis. A lock's value is its holder's token.
+type redisStore struct {
+	client redis.Cmdable
+}
+
+func (s *redisStore) acquire(ctx context.Context, key, token string, ttl time.Duration) (int64, error) {
+	fence, err := acquireScript.Run(ctx, s.client, []string{key, key + ":fence"}, token, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	if fence == 0 {
+		return 0, ErrNotAcquired
+	}
+	return fence, nil
+}
+
+func (s *redisStore) extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	return ownedResult(extendScript.Run(ctx, s.client, []string{key}, token, ttl.Milliseconds()).Int64())
+}
+
+func (s *redisStore) release(ctx context.Context, key, token string) error {
+	return ownedResult(releaseScript.Run(ctx, s.client, []string{key}, token).Int64())
+}
+
+func (s *redisStore) held(ctx context.Context, key string) (bool, error) {
+	n, err := s.client.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+// ownedResult turns the result of a script acting on a lock only while its
+// token holds it into ErrLost when it didn't
+func ownedResult(n int64, err error) error {
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// newToken identifies one acquisition of a lock
+func newToken() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// AbuseGuard enforces abuse restrictions for authenticated users and feeds
+// their request patterns into abuse detection. Handlers mark content that
+// tripped moderation with c.Set("moderation_flagged", true). Detection
+// failures never block a request.
+func AbuseGuard(abuse *services.AbuseService, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, ok := GetUserID(c)
+		if abuse == nil || !ok || userID == "" {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		restriction, err := abuse.GetRestriction(ctx, userID)
+		if err != nil {
+			logger.WithError(err).Warn("Abuse restriction lookup failed")
+		}
+
+		if restriction != nil {
+			retryAfter := int(time.Until(restriction.Until).Seconds())
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			switch restriction.Action {
+			case services.AbuseActionSuspend:
+				c.Header("Retry-After", strconv.Itoa(retryAfter))
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "Account temporarily suspended",
+					"until": restriction.Until,
+				})
+				c.Abort()
+				return
+			case services.AbuseActionThrottle:
+				allowed, err := abuse.AllowThrottled(ctx, userID)
+				if err == nil && !allowed {
+					c.Header("Retry-After", "60")
+					c.JSON(http.StatusTooManyRequests, gin.H{
+						"error": "Account temporarily rate limited",
+						"until": restriction.Until,
+					})
+					c.Abort()
+					return
+				}
+			}
+		}
+
+		if _, err := abuse.RecordSignal(ctx, userID, services.AbuseSignalRequest, ""); err != nil {
+			logger.WithError(err).Debug("Failed to record request signal")
+		}
+
+		c.Next()
+
+		if c.GetBool("moderation_flagged") {
+			if _, err := abuse.RecordSignal(ctx, userID, services.AbuseSignalModerationFlag, ""); err != nil {
+				logger.WithError(err).Debug("Failed to record moderation signal")
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAbuseRouter serves GET /prompts behind AbuseGuard for userID, with
+// abuse state kept in server
+func newAbuseRouter(t *testing.T, server *miniredis.Miniredis, userID string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	abuse := services.NewAbuseService(services.NewCacheService(client, logger), nil, nil, nil, logger)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Next()
+	})
+	router.Use(middleware.AbuseGuard(abuse, logger))
+	router.GET("/prompts", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+// restrict stores a restriction the way AbuseService applies one
+func restrict(t *testing.T, server *miniredis.Miniredis, userID, action string) {
+	data, err := json.Marshal(services.AbuseRestriction{
+		UserID: userID,
+		Action: action,
+		Rule:   "test",
+		Until:  time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.NoError(t, server.Set("betterprompts:abuse:restriction:"+userID, string(data)))
+}
+
+func serveAbuse(router *gin.Engine) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/prompts", nil))
+	return w
+}
+
+func TestAbuseGuard(t *testing.T) {
+	t.Run("rejects suspended users", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		restrict(t, server, "u1", services.AbuseActionSuspend)
+
+		w := serveAbuse(newAbuseRouter(t, server, "u1"))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "Account temporarily suspended")
+		assert.NotEmpty(t, w.Header().Get("Retry-After"))
+		assert.False(t, server.Exists("betterprompts:abuse:signals:request:u1"), "rejected requests aren't counted")
+	})
+
+	t.Run("limits throttled users", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		restrict(t, server, "u1", services.AbuseActionThrottle)
+		router := newAbuseRouter(t, server, "u1")
+
+		for i := 0; i < services.ThrottledRequestsPerMinute; i++ {
+			require.Equal(t, http.StatusOK, serveAbuse(router).Code)
+		}
+		w := serveAbuse(router)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("counts unrestricted users' requests", func(t *testing.T) {
+		server := miniredis.RunT(t)
+
+		w := serveAbuse(newAbuseRouter(t, server, "u1"))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.True(t, server.Exists("betterprompts:abuse:signals:request:u1"))
+	})
+
+	t.Run("lets requests through when Redis is down", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		router := newAbuseRouter(t, server, "u1")
+		server.Close()
+
+		assert.Equal(t, http.StatusOK, serveAbuse(router).Code)
+	})
+}
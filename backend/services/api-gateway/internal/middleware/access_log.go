@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLogging makes the access log available to handlers through
+// RecordAccess and AuditAccess
+func AccessLogging(log *services.AccessLogService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if log != nil {
+			c.Set("access_log", log)
+		}
+		c.Next()
+	}
+}
+
+// RecordAccess records an access event affecting userID for the current
+// request. An empty userID falls back to the authenticated user; when the
+// authenticated user is someone else, they are recorded as the actor. It is a
+// no-op when the access log isn't configured.
+func RecordAccess(c *gin.Context, category, action, userID string, details map[string]interface{}) {
+	value, exists := c.Get("access_log")
+	if !exists {
+		return
+	}
+	log, ok := value.(*services.AccessLogService)
+	if !ok {
+		return
+	}
+
+	caller, _ := GetUserID(c)
+	if userID == "" {
+		userID = caller
+	}
+	if userID == "" {
+		return
+	}
+	event := &services.AccessEvent{
+		UserID:    userID,
+		Category:  category,
+		Action:    action,
+		RequestID: c.GetString("request_id"),
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details:   details,
+	}
+	if caller != userID {
+		event.ActorID = caller
+	}
+	if id := c.Param("id"); id != "" && id != userID {
+		event.ResourceID = id
+	}
+
+	log.RecordDetached(c.Request.Context(), event)
+}
+
+// AuditAccess records an access event once the route succeeds. The affected
+// user is taken from the subjectParam path parameter, or is the caller
+// themselves when subjectParam is empty.
+func AuditAccess(category, action, subjectParam string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		userID := ""
+		if subjectParam != "" {
+			if userID = c.Param(subjectParam); userID == "" {
+				return
+			}
+		}
+		RecordAccess(c, category, action, userID, nil)
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ActivityEvents makes the event bus available to handlers through
+// PublishActivity and publishes server errors and rate-limit rejections for
+// every request
+func ActivityEvents(bus *services.EventBus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if bus == nil {
+			c.Next()
+			return
+		}
+
+		c.Set("event_bus", bus)
+		c.Next()
+
+		status := c.Writer.Status()
+		switch {
+		case status == http.StatusTooManyRequests:
+			PublishActivity(c, services.ActivityRateLimit, "", nil)
+		case status >= http.StatusInternalServerError:
+			var data map[string]interface{}
+			if len(c.Errors) > 0 {
+				data = map[string]interface{}{"error": c.Errors.String()}
+			}
+			PublishActivity(c, services.ActivityError, "", data)
+		}
+	}
+}
+
+// PublishActivity publishes an activity event for the current request. An
+// empty userID falls back to the authenticated user, if any. It is a no-op
+// when the event bus isn't configured.
+func PublishActivity(c *gin.Context, eventType, userID string, data map[string]interface{}) {
+	value, exists := c.Get("event_bus")
+	if !exists {
+		return
+	}
+	bus, ok := value.(*services.EventBus)
+	if !ok {
+		return
+	}
+
+	if userID == "" {
+		userID, _ = GetUserID(c)
+	}
+	path := c.FullPath()
+	if path == "" {
+		path = c.Request.URL.Path
+	}
+
+	bus.Publish(services.ActivityEvent{
+		Type:      eventType,
+		UserID:    userID,
+		RequestID: c.GetString("request_id"),
+		Method:    c.Request.Method,
+		Path:      path,
+		Status:    c.Writer.Status(),
+		Data:      data,
+	})
+}
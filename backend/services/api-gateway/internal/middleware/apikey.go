@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyAuth authenticates requests with a developer API key. The key is read
+// from the X-API-Key header or a "Bearer bp_..." Authorization header, never
+// from the query string, where it would end up in access logs and referrers.
+func APIKeyAuth(apiKeys *services.APIKeyService, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawKey := extractAPIKey(c)
+		if rawKey == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "API key required",
+			})
+			c.Abort()
+			return
+		}
+
+		key, err := apiKeys.ValidateAPIKey(c.Request.Context(), rawKey)
+		if err != nil {
+			logger.WithError(err).Debug("API key validation failed")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or expired API key",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", key.UserID)
+		c.Set("api_key_id", key.ID)
+		c.Set("api_key", key)
+
+		c.Next()
+	}
+}
+
+// RequireAPIKeyScope ensures the authenticated API key grants a scope
+func RequireAPIKeyScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("api_key")
+		key, ok := value.(*services.APIKey)
+		if !exists || !ok || !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "API key lacks required scope",
+				"scope": scope,
+			})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func extractAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return key
+	}
+	if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer "+services.APIKeyPrefix) {
+		return strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	return ""
+}
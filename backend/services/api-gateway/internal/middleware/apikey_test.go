@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyAuthIgnoresQueryString(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	// Requests without a key in a header never reach the key service
+	router.GET("/integrations/enhance", middleware.APIKeyAuth(nil, logrus.New()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/integrations/enhance?api_key="+services.APIKeyPrefix+"secret", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "API key required")
+}
+
+func TestRequireAPIKeyScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, tc := range map[string]struct {
+		scopes []string
+		status int
+	}{
+		"granted":   {[]string{services.APIKeyScopePromptRead}, http.StatusOK},
+		"other":     {[]string{services.APIKeyScopePromptWrite}, http.StatusForbidden},
+		"no scopes": {nil, http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/integrations/history", func(c *gin.Context) {
+				c.Set("api_key", &services.APIKey{Scopes: tc.scopes})
+			}, middleware.RequireAPIKeyScope(services.APIKeyScopePromptRead), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/integrations/history", nil))
+
+			assert.Equal(t, tc.status, w.Code)
+		})
+	}
+}
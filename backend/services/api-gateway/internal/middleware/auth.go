@@ -288,6 +288,8 @@ func roleHasPermission(role, permission string) bool {
 			"user:write:all",
 			"user:delete:all",
 			"system:config:all",
+			"training:*:all",
+			"review:*:all",
 		},
 		"developer": {
 			"prompt:read:own",
@@ -301,6 +303,22 @@ func roleHasPermission(role, permission string) bool {
 			"prompt:write:own",
 			"prompt:delete:own",
 		},
+		"ml_engineer": {
+			"prompt:read:own",
+			"prompt:write:own",
+			"prompt:delete:own",
+			"training:read:all",
+			"training:write:all",
+			"review:read:all",
+			"review:write:all",
+		},
+		"reviewer": {
+			"prompt:read:own",
+			"prompt:write:own",
+			"prompt:delete:own",
+			"review:read:all",
+			"review:write:all",
+		},
 	}
 
 	permissions, exists := rolePermissions[role]
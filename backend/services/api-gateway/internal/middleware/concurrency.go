@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// concurrencyReleaseTimeout bounds releasing a slot after the request, whose
+// own context may already be cancelled
+const concurrencyReleaseTimeout = 2 * time.Second
+
+// ConcurrencyKey identifies whose slots a request uses: the API key for
+// integrations, otherwise the user, otherwise the client IP
+func ConcurrencyKey(c *gin.Context) string {
+	rc := GetRequestContext(c)
+	switch {
+	case rc.Client.APIKeyID != "":
+		return "api_key:" + rc.Client.APIKeyID
+	case rc.Authenticated():
+		return "user:" + rc.UserID
+	default:
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// ConcurrencyLimit refuses an enhancement with 429 and code
+// concurrency_limit_exceeded while the caller already has their tier's
+// limit in flight. The slot is held until the handler returns, renewed
+// halfway through each lease TTL. Requests are let through when the
+// limiter is nil or Redis fails.
+func ConcurrencyLimit(limiter *services.ConcurrencyLimiter, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		rc := GetRequestContext(c)
+		limit := limiter.Config().Limit(rc.Tier)
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		lease, held, err := limiter.Acquire(c.Request.Context(), ConcurrencyKey(c), rc.Tier, limit)
+		if err != nil {
+			logger.WithError(err).Error("Concurrency limit check failed")
+			c.Next()
+			return
+		}
+
+		c.Header("X-Concurrency-Limit", strconv.Itoa(limit))
+		if lease == nil {
+			c.Header("Retry-After", "1")
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error":       "Concurrency limit exceeded",
+				"code":        "concurrency_limit_exceeded",
+				"message":     fmt.Sprintf("You already have %d enhancements in progress. Please wait for one to finish.", held),
+				"limit":       limit,
+				"retry_after": 1,
+			})
+			c.Abort()
+			return
+		}
+
+		done := make(chan struct{})
+		go renewConcurrencyLease(limiter, lease, done, logger)
+		defer func() {
+			close(done)
+			ctx, cancel := context.WithTimeout(context.Background(), concurrencyReleaseTimeout)
+			defer cancel()
+			if err := limiter.Release(ctx, lease); err != nil {
+				logger.WithError(err).Warn("Failed to release concurrency slot")
+			}
+		}()
+
+		c.Next()
+	}
+}
+
+// renewConcurrencyLease keeps a lease alive until done is closed
+func renewConcurrencyLease(limiter *services.ConcurrencyLimiter, lease *services.ConcurrencyLease, done <-chan struct{}, logger *logrus.Logger) {
+	ticker := time.NewTicker(limiter.Config().LeaseTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), concurrencyReleaseTimeout)
+			renewed, err := limiter.Renew(ctx, lease)
+			cancel()
+			if err != nil {
+				logger.WithError(err).Warn("Failed to renew concurrency slot")
+			} else if !renewed {
+				return
+			}
+		}
+	}
+}
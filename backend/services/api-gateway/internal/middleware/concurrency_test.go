@@ -0,0 +1,45 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key := func(values map[string]string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		c.Request.RemoteAddr = "203.0.113.7:1234"
+		for name, value := range values {
+			c.Set(name, value)
+		}
+		return middleware.ConcurrencyKey(c)
+	}
+
+	assert.Equal(t, "ip:203.0.113.7", key(nil))
+	assert.Equal(t, "user:user-1", key(map[string]string{"user_id": "user-1"}))
+	assert.Equal(t, "api_key:key-1", key(map[string]string{"user_id": "user-1", "api_key_id": "key-1"}),
+		"each API key gets its own slots")
+}
+
+func TestConcurrencyLimitWithoutLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/enhance", middleware.ConcurrencyLimit(nil, logrus.New()), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/enhance", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Concurrency-Limit"))
+}
@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"fmt"
+	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -10,117 +13,356 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// CORSConfig returns a CORS middleware configuration
-func CORSConfig(logger *logrus.Logger) gin.HandlerFunc {
-	// Default allowed origins for development
-	allowedOrigins := []string{
-		"http://localhost:3000",     // Frontend development server
-		"http://localhost:3001",     // Alternative frontend port
-		"http://localhost",          // Production frontend through nginx
-		"http://127.0.0.1:3000",     // Alternative localhost notation
-		"http://localhost:80",       // Explicit port 80
-	}
-	
-	// Add additional origins from environment variable
-	if envOrigins := os.Getenv("CORS_ALLOWED_ORIGINS"); envOrigins != "" {
-		additionalOrigins := strings.Split(envOrigins, ",")
-		for _, origin := range additionalOrigins {
-			origin = strings.TrimSpace(origin)
-			if origin != "" {
-				allowedOrigins = append(allowedOrigins, origin)
-			}
-		}
+// Preflight cache bounds. Chromium caps Access-Control-Max-Age at 2 hours
+// and Firefox at 24 hours, so longer values only waste configuration.
+const (
+	corsDefaultMaxAge    = 2 * time.Hour
+	corsMaxMaxAge        = 24 * time.Hour
+	corsWildcardMaxAge   = 10 * time.Minute
+	corsAdminMaxAge      = 10 * time.Minute
+	corsAllOriginPattern = "*"
+)
+
+// CORSPolicy describes the cross-origin rules for one route group.
+//
+// AllowedOrigins entries may be exact origins ("https://app.example.com"),
+// subdomain wildcards ("https://*.example.com", which matches any subdomain
+// but not the apex), prefix wildcards ending in "*" ("moz-extension://*")
+// or "*" for any origin. "*" can't be combined with AllowCredentials.
+type CORSPolicy struct {
+	Name             string
+	AllowedOrigins   []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	AllowLocalhost   bool          // Accept any localhost origin (development only)
+	MaxAge           time.Duration // Zero picks a value from the policy, see EffectiveMaxAge
+}
+
+// CORSRoute binds a CORS policy to every path under PathPrefix
+type CORSRoute struct {
+	PathPrefix string
+	Policy     CORSPolicy
+}
+
+var defaultCORSHeaders = []string{
+	"Origin",
+	"Content-Type",
+	"Content-Length",
+	"Accept",
+	"Accept-Encoding",
+	"Accept-Language",
+	"Authorization",
+	"X-Session-ID",
+	"X-Request-ID",
+	"X-CSRF-Token",
+	"X-Requested-With",
+	"Cache-Control",
+	"Pragma",
+	"If-Match",
+	"X-Device-Fingerprint",
+	"X-Device-Name",
+	"X-Device-Platform",
+	HeaderDemoChallenge,
+	HeaderDemoSolution,
+	HeaderCaptchaToken,
+}
+
+var defaultCORSExposeHeaders = []string{
+	"ETag",
+	"X-Request-ID",
+	"X-Session-ID",
+	"X-Search-ID",
+	"X-Device-ID",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-Reset",
+	"X-RateLimit-Warning",
+	"Content-Length",
+	"Content-Type",
+	"Content-Disposition",
+}
+
+// frontendOrigins are the first-party web app origins: local development
+// servers plus CORS_ALLOWED_ORIGINS and PRODUCTION_ORIGIN
+func frontendOrigins() []string {
+	origins := []string{
+		"http://localhost:3000", // Frontend development server
+		"http://localhost:3001", // Alternative frontend port
+		"http://localhost",      // Production frontend through nginx
+		"http://127.0.0.1:3000", // Alternative localhost notation
+		"http://localhost:80",   // Explicit port 80
 	}
-	
-	// Add production origin if set
+	origins = append(origins, envOrigins("CORS_ALLOWED_ORIGINS")...)
 	if prodOrigin := os.Getenv("PRODUCTION_ORIGIN"); prodOrigin != "" {
-		allowedOrigins = append(allowedOrigins, prodOrigin)
+		origins = append(origins, prodOrigin)
 	}
-	
-	config := cors.Config{
-		AllowOrigins: allowedOrigins,
-		AllowMethods: []string{
-			"GET",
-			"POST",
-			"PUT",
-			"PATCH",
-			"DELETE",
-			"OPTIONS",
-			"HEAD",
-		},
+	return origins
+}
+
+func envOrigins(name string) []string {
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv(name), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// policyFromEnv applies CORS_<NAME>_ALLOWED_ORIGINS and CORS_<NAME>_MAX_AGE
+// (a Go duration such as "30m") overrides to a policy
+func policyFromEnv(policy CORSPolicy) CORSPolicy {
+	prefix := "CORS_" + strings.ToUpper(policy.Name) + "_"
+	if origins := envOrigins(prefix + "ALLOWED_ORIGINS"); len(origins) > 0 {
+		policy.AllowedOrigins = origins
+	}
+	if maxAge, err := time.ParseDuration(os.Getenv(prefix + "MAX_AGE")); err == nil && maxAge > 0 {
+		policy.MaxAge = maxAge
+	}
+	return policy
+}
+
+// PublicCORSPolicy is the policy for the first-party web app on /api/v1
+func PublicCORSPolicy() CORSPolicy {
+	return policyFromEnv(CORSPolicy{
+		Name:             "public",
+		AllowedOrigins:   frontendOrigins(),
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS", "HEAD"},
+		AllowHeaders:     defaultCORSHeaders,
+		ExposeHeaders:    defaultCORSExposeHeaders,
+		AllowCredentials: true,
+		AllowLocalhost:   os.Getenv("NODE_ENV") == "development",
+	})
+}
+
+// AdminCORSPolicy restricts admin routes to the first-party frontend (or
+// CORS_ADMIN_ALLOWED_ORIGINS) with a short preflight cache so origin changes
+// take effect quickly
+func AdminCORSPolicy() CORSPolicy {
+	return policyFromEnv(CORSPolicy{
+		Name:             "admin",
+		AllowedOrigins:   frontendOrigins(),
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     defaultCORSHeaders,
+		ExposeHeaders:    defaultCORSExposeHeaders,
+		AllowCredentials: true,
+		AllowLocalhost:   os.Getenv("NODE_ENV") == "development",
+		MaxAge:           corsAdminMaxAge,
+	})
+}
+
+// IntegrationsCORSPolicy is for browser-based no-code builders calling the
+// API-key routes. Keys travel in headers, so credentials are never allowed
+// and any origin is accepted unless CORS_INTEGRATIONS_ALLOWED_ORIGINS is set.
+func IntegrationsCORSPolicy() CORSPolicy {
+	return policyFromEnv(CORSPolicy{
+		Name:           "integrations",
+		AllowedOrigins: []string{corsAllOriginPattern},
+		AllowMethods:   []string{"GET", "POST", "OPTIONS"},
 		AllowHeaders: []string{
 			"Origin",
 			"Content-Type",
-			"Content-Length",
 			"Accept",
-			"Accept-Encoding",
-			"Accept-Language",
 			"Authorization",
-			"X-Session-ID",
+			"X-API-Key",
 			"X-Request-ID",
-			"X-CSRF-Token",
-			"X-Requested-With",
-			"Cache-Control",
-			"Pragma",
 		},
 		ExposeHeaders: []string{
 			"X-Request-ID",
-			"X-Session-ID",
 			"X-RateLimit-Limit",
 			"X-RateLimit-Remaining",
 			"X-RateLimit-Reset",
-			"Content-Length",
+			"X-RateLimit-Warning",
+			"Retry-After",
+		},
+	})
+}
+
+// ExtensionCORSPolicy is for browser extension clients. Origins come from
+// EXTENSION_ALLOWED_ORIGINS (comma-separated, trailing "*" allowed, e.g.
+// "chrome-extension://abcdef,moz-extension://*"). Credentials are never
+// allowed since extensions authenticate with bearer tokens.
+func ExtensionCORSPolicy() CORSPolicy {
+	return policyFromEnv(CORSPolicy{
+		Name:           "extension",
+		AllowedOrigins: envOrigins("EXTENSION_ALLOWED_ORIGINS"),
+		AllowMethods:   []string{"POST", "OPTIONS"},
+		AllowHeaders: []string{
+			"Origin",
 			"Content-Type",
-			"Content-Disposition",
+			"Accept",
+			"Authorization",
+			"If-None-Match",
+			"X-Request-ID",
 		},
-		AllowCredentials: true,
-		MaxAge:          12 * time.Hour,
-		AllowWildcard:   false, // Don't allow wildcard for security
-		AllowWebSockets: true,  // Allow WebSocket upgrades if needed
-	}
-	
-	// Custom validation function for dynamic origin checking
-	config.AllowOriginFunc = func(origin string) bool {
-		// Check if origin is in allowed list
-		for _, allowed := range allowedOrigins {
-			if origin == allowed {
-				return true
+		ExposeHeaders: []string{
+			"ETag",
+			"X-Request-ID",
+			"X-RateLimit-Limit",
+			"X-RateLimit-Remaining",
+			"X-RateLimit-Reset",
+			"X-RateLimit-Warning",
+			"Retry-After",
+		},
+		MaxAge: corsMaxMaxAge,
+	})
+}
+
+// Validate rejects policies browsers would refuse or that are unsafe,
+// notably a "*" origin together with credentials
+func (p CORSPolicy) Validate() error {
+	for _, origin := range p.AllowedOrigins {
+		if origin == corsAllOriginPattern {
+			if p.AllowCredentials {
+				return fmt.Errorf("cors policy %q: allowed origin \"*\" cannot be combined with credentials", p.Name)
 			}
+			continue
 		}
-		
-		// In development, be more permissive
-		if os.Getenv("NODE_ENV") == "development" {
-			// Allow any localhost origin in development
-			if strings.HasPrefix(origin, "http://localhost:") ||
-				strings.HasPrefix(origin, "http://127.0.0.1:") ||
-				strings.HasPrefix(origin, "http://[::1]:") {
-				logger.WithField("origin", origin).Debug("Allowing development origin")
-				return true
+		if strings.HasSuffix(origin, "*") {
+			continue
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			return fmt.Errorf("cors policy %q: invalid allowed origin %q", p.Name, origin)
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("cors policy %q: max age must not be negative", p.Name)
+	}
+	return nil
+}
+
+// EffectiveMaxAge returns the Access-Control-Max-Age to send. Explicit values
+// are capped at the longest duration any browser honours. Otherwise the
+// Chromium cap is used, shortened for credentialed policies with wildcard
+// origins so a revoked subdomain stops working soon after a config change.
+func (p CORSPolicy) EffectiveMaxAge() time.Duration {
+	if p.MaxAge > 0 {
+		if p.MaxAge > corsMaxMaxAge {
+			return corsMaxMaxAge
+		}
+		return p.MaxAge
+	}
+	if p.AllowCredentials {
+		for _, origin := range p.AllowedOrigins {
+			if strings.Contains(origin, "*") {
+				return corsWildcardMaxAge
 			}
 		}
-		
-		logger.WithField("origin", origin).Warn("Rejected CORS origin")
-		return false
 	}
-	
-	// Log configuration for debugging
+	return corsDefaultMaxAge
+}
+
+// AllowsOrigin reports whether origin matches the policy
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	if ValidateOrigin(origin, p.AllowedOrigins) {
+		return true
+	}
+	return p.AllowLocalhost && (strings.HasPrefix(origin, "http://localhost:") ||
+		strings.HasPrefix(origin, "http://127.0.0.1:") ||
+		strings.HasPrefix(origin, "http://[::1]:"))
+}
+
+// Middleware builds the CORS handler for the policy after validating it
+func (p CORSPolicy) Middleware(logger *logrus.Logger) (gin.HandlerFunc, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	config := cors.Config{
+		AllowMethods:     p.AllowMethods,
+		AllowHeaders:     p.AllowHeaders,
+		ExposeHeaders:    p.ExposeHeaders,
+		AllowCredentials: p.AllowCredentials,
+		MaxAge:           p.EffectiveMaxAge(),
+		AllowWebSockets:  true,
+		AllowOriginFunc: func(origin string) bool {
+			if p.AllowsOrigin(origin) {
+				return true
+			}
+			logger.WithFields(logrus.Fields{
+				"origin": origin,
+				"policy": p.Name,
+			}).Warn("Rejected CORS origin")
+			return false
+		},
+	}
+
 	logger.WithFields(logrus.Fields{
-		"allowed_origins":   allowedOrigins,
-		"allow_credentials": config.AllowCredentials,
-		"max_age":          config.MaxAge,
-		"environment":      os.Getenv("NODE_ENV"),
-	}).Info("CORS middleware configured")
-	
-	return cors.New(config)
+		"policy":            p.Name,
+		"allowed_origins":   p.AllowedOrigins,
+		"allow_credentials": p.AllowCredentials,
+		"max_age":           config.MaxAge,
+	}).Info("CORS policy configured")
+
+	return cors.New(config), nil
+}
+
+// RouteCORS applies a different CORS policy per route group, choosing the
+// route with the longest matching path prefix. Preflight requests rarely
+// match a registered route, so this must be installed on the engine rather
+// than on the groups themselves. Every policy is validated up front and an
+// error is returned for the first invalid one.
+func RouteCORS(logger *logrus.Logger, routes ...CORSRoute) (gin.HandlerFunc, error) {
+	type compiledRoute struct {
+		prefix  string
+		handler gin.HandlerFunc
+	}
+
+	compiled := make([]compiledRoute, 0, len(routes))
+	for _, route := range routes {
+		handler, err := route.Policy.Middleware(logger)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledRoute{
+			prefix:  strings.TrimSuffix(route.PathPrefix, "/"),
+			handler: handler,
+		})
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return len(compiled[i].prefix) > len(compiled[j].prefix)
+	})
+
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		for _, route := range compiled {
+			if route.prefix == "" || path == route.prefix || strings.HasPrefix(path, route.prefix+"/") {
+				route.handler(c)
+				return
+			}
+		}
+		c.Next()
+	}, nil
+}
+
+// CORSConfig returns a CORS middleware configuration
+func CORSConfig(logger *logrus.Logger) gin.HandlerFunc {
+	handler, err := PublicCORSPolicy().Middleware(logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid CORS configuration")
+	}
+	return handler
 }
 
 // ValidateOrigin checks if an origin is allowed
 func ValidateOrigin(origin string, allowedOrigins []string) bool {
 	for _, allowed := range allowedOrigins {
-		if origin == allowed {
+		if origin == allowed || allowed == corsAllOriginPattern {
 			return true
 		}
-		// Support wildcard subdomains
+		// Support wildcard subdomains, e.g. https://*.example.com
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			if rest, found := strings.CutPrefix(origin, scheme+"://"); found &&
+				strings.HasSuffix(rest, "."+domain) && len(rest) > len(domain)+1 {
+				return true
+			}
+			continue
+		}
+		// Support prefix wildcards, e.g. moz-extension://*
 		if strings.HasSuffix(allowed, "*") {
 			prefix := strings.TrimSuffix(allowed, "*")
 			if strings.HasPrefix(origin, prefix) {
@@ -129,4 +371,29 @@ func ValidateOrigin(origin string, allowedOrigins []string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// ExtensionCORSConfig returns the CORS middleware for ExtensionCORSPolicy
+func ExtensionCORSConfig(logger *logrus.Logger) gin.HandlerFunc {
+	handler, err := ExtensionCORSPolicy().Middleware(logger)
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid extension CORS configuration")
+	}
+	return handler
+}
+
+// SkipPaths runs a middleware for every request except the listed paths, so
+// routes with their own CORS profile aren't rejected by the global one
+func SkipPaths(handler gin.HandlerFunc, paths ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		skip[path] = true
+	}
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		handler(c)
+	}
+}
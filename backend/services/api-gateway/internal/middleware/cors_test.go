@@ -0,0 +1,185 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtensionCORSProfile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("EXTENSION_ALLOWED_ORIGINS", "chrome-extension://abcdef, moz-extension://*")
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	extensionCORS := middleware.ExtensionCORSConfig(logger)
+	router := gin.New()
+	router.Use(middleware.SkipPaths(middleware.CORSConfig(logger), "/quick-enhance"))
+	router.OPTIONS("/quick-enhance", extensionCORS)
+	router.POST("/quick-enhance", extensionCORS, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/enhance", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		origin        string
+		expectedCode  int
+		expectAllowed bool
+	}{
+		{"preflight from allowed extension", http.MethodOptions, "/quick-enhance", "chrome-extension://abcdef", http.StatusNoContent, true},
+		{"wildcard extension origin", http.MethodPost, "/quick-enhance", "moz-extension://1234-5678", http.StatusOK, true},
+		{"unknown extension rejected", http.MethodPost, "/quick-enhance", "chrome-extension://other", http.StatusForbidden, false},
+		{"extension origin rejected on other routes", http.MethodPost, "/enhance", "chrome-extension://abcdef", http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectAllowed {
+				assert.Equal(t, tt.origin, w.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
+
+func TestCORSPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  middleware.CORSPolicy
+		wantErr bool
+	}{
+		{"exact origins with credentials", middleware.CORSPolicy{AllowedOrigins: []string{"https://app.example.com"}, AllowCredentials: true}, false},
+		{"subdomain wildcard", middleware.CORSPolicy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, false},
+		{"any origin without credentials", middleware.CORSPolicy{AllowedOrigins: []string{"*"}}, false},
+		{"any origin with credentials", middleware.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}, true},
+		{"origin with path", middleware.CORSPolicy{AllowedOrigins: []string{"https://example.com/app"}}, true},
+		{"origin without scheme", middleware.CORSPolicy{AllowedOrigins: []string{"example.com"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateOriginWildcardSubdomains(t *testing.T) {
+	allowed := []string{"https://*.example.com"}
+
+	assert.True(t, middleware.ValidateOrigin("https://app.example.com", allowed))
+	assert.True(t, middleware.ValidateOrigin("https://eu.app.example.com", allowed))
+	assert.False(t, middleware.ValidateOrigin("https://example.com", allowed))
+	assert.False(t, middleware.ValidateOrigin("http://app.example.com", allowed))
+	assert.False(t, middleware.ValidateOrigin("https://app.example.com.evil.io", allowed))
+	assert.False(t, middleware.ValidateOrigin("https://badexample.com", allowed))
+}
+
+func TestCORSPolicyEffectiveMaxAge(t *testing.T) {
+	assert.Equal(t, 2*time.Hour, middleware.CORSPolicy{AllowedOrigins: []string{"https://a.io"}}.EffectiveMaxAge())
+	assert.Equal(t, 10*time.Minute, middleware.CORSPolicy{AllowedOrigins: []string{"https://*.a.io"}, AllowCredentials: true}.EffectiveMaxAge())
+	assert.Equal(t, 24*time.Hour, middleware.CORSPolicy{MaxAge: 48 * time.Hour}.EffectiveMaxAge())
+	assert.Equal(t, 5*time.Minute, middleware.CORSPolicy{MaxAge: 5 * time.Minute}.EffectiveMaxAge())
+}
+
+func TestRouteCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("rejects wildcard with credentials at startup", func(t *testing.T) {
+		_, err := middleware.RouteCORS(logger, middleware.CORSRoute{
+			PathPrefix: "/api",
+			Policy:     middleware.CORSPolicy{Name: "bad", AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		})
+		assert.Error(t, err)
+	})
+
+	handler, err := middleware.RouteCORS(logger,
+		middleware.CORSRoute{PathPrefix: "/api", Policy: middleware.CORSPolicy{
+			Name:             "public",
+			AllowedOrigins:   []string{"https://app.example.com"},
+			AllowMethods:     []string{"GET", "POST"},
+			AllowCredentials: true,
+		}},
+		middleware.CORSRoute{PathPrefix: "/api/admin", Policy: middleware.CORSPolicy{
+			Name:             "admin",
+			AllowedOrigins:   []string{"https://admin.example.com"},
+			AllowMethods:     []string{"GET", "POST"},
+			AllowCredentials: true,
+			MaxAge:           10 * time.Minute,
+		}},
+		middleware.CORSRoute{PathPrefix: "/api/integrations", Policy: middleware.CORSPolicy{
+			Name:           "integrations",
+			AllowedOrigins: []string{"*"},
+			AllowMethods:   []string{"POST"},
+		}},
+	)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(handler)
+	for _, path := range []string{"/api/enhance", "/api/admin/users", "/api/administrators", "/api/integrations/enhance"} {
+		router.POST(path, func(c *gin.Context) { c.Status(http.StatusOK) })
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		origin       string
+		expectedCode int
+		expectMaxAge string
+		expectCreds  bool
+	}{
+		{"public origin on public route", "/api/enhance", "https://app.example.com", http.StatusNoContent, "7200", true},
+		{"public origin rejected on admin route", "/api/admin/users", "https://app.example.com", http.StatusForbidden, "", false},
+		{"admin origin on admin route", "/api/admin/users", "https://admin.example.com", http.StatusNoContent, "600", true},
+		{"prefix match respects path segments", "/api/administrators", "https://admin.example.com", http.StatusForbidden, "", false},
+		{"any origin on integrations", "/api/integrations/enhance", "https://zapier.example", http.StatusNoContent, "7200", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectMaxAge, w.Header().Get("Access-Control-Max-Age"))
+			if tt.expectCreds {
+				assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+			} else {
+				assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+			}
+		})
+	}
+}
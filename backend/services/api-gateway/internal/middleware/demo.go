@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Headers demo requests prove themselves with
+const (
+	HeaderDemoChallenge = "X-Demo-Challenge" // A challenge from GET /demo/challenge
+	HeaderDemoSolution  = "X-Demo-Solution"  // Its proof-of-work solution
+	HeaderCaptchaToken  = "X-Captcha-Token"
+)
+
+// DemoChallenge lets through only demo requests carrying a solved
+// proof-of-work challenge or a valid CAPTCHA token. Put it before the demo
+// caps so scripts that can't solve it don't use them up.
+func DemoChallenge(demo *services.DemoService, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := demo.Verify(c.Request.Context(), services.DemoProof{
+			Challenge:    c.GetHeader(HeaderDemoChallenge),
+			Solution:     c.GetHeader(HeaderDemoSolution),
+			CaptchaToken: c.GetHeader(HeaderCaptchaToken),
+			RemoteIP:     c.ClientIP(),
+		})
+		switch {
+		case err == nil:
+			c.Next()
+		case errors.Is(err, services.ErrDemoProofRequired), errors.Is(err, services.ErrDemoProofInvalid):
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":         err.Error(),
+				"challenge_url": "/api/v1/demo/challenge",
+			})
+		case errors.Is(err, services.ErrDemoCaptchaUnavailable):
+			c.Header("Retry-After", "30")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		default:
+			logger.WithError(err).Error("Demo challenge check failed")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "demo temporarily unavailable"})
+		}
+	}
+}
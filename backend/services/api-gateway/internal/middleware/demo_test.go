@@ -0,0 +1,84 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemoChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	captcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("response") {
+		case "good":
+			w.Write([]byte(`{"success":true}`))
+		case "down":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"success":false}`))
+		}
+	}))
+	defer captcha.Close()
+
+	demo, err := services.NewDemoService(services.DemoConfig{
+		Enabled:          true,
+		Challenge:        services.DemoChallengeCaptcha,
+		CaptchaSecret:    "secret",
+		CaptchaVerifyURL: captcha.URL,
+	}, services.NewCacheService(nil, logger), logger)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.POST("/demo/enhance", middleware.DemoChallenge(demo, logger), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/demo/enhance", nil)
+		if token != "" {
+			req.Header.Set(middleware.HeaderCaptchaToken, token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNoContent, request("good").Code)
+
+	rec := request("")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/api/v1/demo/challenge")
+	assert.Equal(t, http.StatusForbidden, request("forged").Code)
+
+	rec = request("down")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestDemoRateLimitsFailClosed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	caps := middleware.DemoRateLimitConfigs(5, 1000)
+	require.Len(t, caps, 2)
+	assert.Equal(t, 5, caps[0].Limit)
+	assert.Equal(t, 1000, caps[1].Limit)
+
+	router := gin.New()
+	router.POST("/demo/enhance", middleware.RateLimitMiddleware(nil, caps[0], logger), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/demo/enhance", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code, "the caps can't be skipped without Redis")
+}
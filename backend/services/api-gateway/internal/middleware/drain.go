@@ -0,0 +1,21 @@
+package middleware
+
+import (
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Drain tracks in-flight requests for the drainer. While draining it asks
+// clients to close keep-alive connections so their next requests reach
+// another instance.
+func Drain(drainer *services.Drainer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		done := drainer.Begin()
+		defer done()
+
+		if drainer.Draining() {
+			c.Header("Connection", "close")
+		}
+		c.Next()
+	}
+}
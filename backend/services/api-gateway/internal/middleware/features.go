@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TrackFeature records the first time each authenticated caller uses a
+// feature, counting only requests that succeeded. It is a no-op when
+// adoption is nil.
+func TrackFeature(adoption *services.FeatureAdoptionService, feature string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if adoption == nil || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		if userID, ok := GetUserID(c); ok {
+			adoption.RecordUse(userID, feature)
+		}
+	}
+}
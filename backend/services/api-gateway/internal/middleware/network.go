@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// TrustProxies makes router take the client IP from X-Forwarded-For and
+// X-Real-IP only on requests from proxies, so a client can't claim an
+// allowlisted address or dodge per-IP limits. With no proxies the client IP
+// is always the peer address.
+func TrustProxies(router *gin.Engine, proxies []string) error {
+	return router.SetTrustedProxies(proxies)
+}
+
+// NetworkAccess rejects requests from denylisted networks and blocked
+// countries before any authentication runs. Requests to bypassPaths, such
+// as health checks, are never blocked. Every block is audit logged.
+func NetworkAccess(access *services.NetworkAccessService, logger *logrus.Logger, bypassPaths ...string) gin.HandlerFunc {
+	bypass := make(map[string]bool, len(bypassPaths))
+	for _, path := range bypassPaths {
+		bypass[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if access == nil || bypass[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		clientIP := c.ClientIP()
+		decision := access.Check(c.Request.Context(), net.ParseIP(clientIP))
+		if decision.Allowed {
+			c.Next()
+			return
+		}
+
+		logger.WithFields(logrus.Fields{
+			"audit":      true,
+			"request_id": c.GetString("request_id"),
+			"client_ip":  clientIP,
+			"reason":     decision.Reason,
+			"rule":       decision.Rule,
+			"country":    decision.Country,
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"user_agent": c.Request.UserAgent(),
+		}).Warn("Request blocked by network access rules")
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Access denied",
+		})
+		c.Abort()
+	}
+}
@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	access, err := services.NewNetworkAccessService(nil, nil, services.NetworkAccessRules{
+		DenyCIDRs: []string{"203.0.113.0/24"},
+	}, logger)
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.NetworkAccess(access, logger, "/health"))
+	router.GET("/health", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/enhance", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name         string
+		path         string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"allowed client", "/enhance", "192.0.2.1:4000", http.StatusOK},
+		{"denylisted client", "/enhance", "203.0.113.5:4000", http.StatusForbidden},
+		{"health check bypasses rules", "/health", "203.0.113.5:4000", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.RemoteAddr = tt.remoteAddr
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestNetworkAccessForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	access, err := services.NewNetworkAccessService(nil, nil, services.NetworkAccessRules{
+		AllowCIDRs:  []string{"192.0.2.0/24"},
+		DefaultDeny: true,
+	}, logger)
+	require.NoError(t, err)
+
+	router := gin.New()
+	require.NoError(t, middleware.TrustProxies(router, []string{"10.0.0.1"}))
+	router.Use(middleware.NetworkAccess(access, logger))
+	router.GET("/enhance", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		expectedCode int
+	}{
+		{"spoofed header from a client is ignored", "203.0.113.5:4000", http.StatusForbidden},
+		{"header from a trusted proxy is believed", "10.0.0.1:4000", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/enhance", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Forwarded-For", "192.0.2.1")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+
+	// Without TRUSTED_PROXIES no proxy is trusted
+	untrusted := gin.New()
+	require.NoError(t, middleware.TrustProxies(untrusted, nil))
+	untrusted.Use(middleware.NetworkAccess(access, logger))
+	untrusted.GET("/enhance", func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest(http.MethodGet, "/enhance", nil)
+	req.RemoteAddr = "10.0.0.1:4000"
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	w := httptest.NewRecorder()
+	untrusted.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
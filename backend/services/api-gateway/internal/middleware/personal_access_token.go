@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// PersonalAccessTokenAuth lets a route be called with a "Bearer bpat_..."
+// personal access token granting scope, acting as the token's owner.
+// Requests without one are handed to sessionAuth, the route's usual auth
+// middleware, so signed-in sessions keep working unrestricted. Tokens carry
+// no roles: a token never acts with its owner's admin or developer rights.
+func PersonalAccessTokenAuth(tokens *services.PersonalAccessTokenService, scope string, sessionAuth gin.HandlerFunc, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawToken, ok := extractPersonalAccessToken(c)
+		if !ok {
+			sessionAuth(c)
+			return
+		}
+
+		token, err := tokens.Authenticate(c.Request.Context(), rawToken, c.ClientIP())
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidPersonalAccessToken) {
+				logger.WithError(err).Error("Personal access token validation failed")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"error": "failed to validate token",
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or expired personal access token",
+			})
+			return
+		}
+
+		if !token.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "Personal access token lacks required scope",
+				"scope": scope,
+			})
+			return
+		}
+
+		c.Set("user_id", token.UserID)
+		c.Set("personal_access_token", token)
+
+		c.Next()
+	}
+}
+
+func extractPersonalAccessToken(c *gin.Context) (string, bool) {
+	rawToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || !strings.HasPrefix(rawToken, services.PersonalAccessTokenPrefix) {
+		return "", false
+	}
+	return rawToken, true
+}
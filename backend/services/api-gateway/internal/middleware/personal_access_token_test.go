@@ -0,0 +1,51 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersonalAccessTokenAuthFallsBackToSessions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var sessionAuthCalls int
+	sessionAuth := func(c *gin.Context) {
+		sessionAuthCalls++
+		c.Set("user_id", "session-user")
+		c.Next()
+	}
+
+	router := gin.New()
+	// Requests without a personal access token never reach the token service
+	router.GET("/history", middleware.PersonalAccessTokenAuth(nil, services.TokenScopeReadHistory, sessionAuth, logrus.New()),
+		func(c *gin.Context) {
+			userID, _ := middleware.GetUserID(c)
+			c.String(http.StatusOK, userID)
+		})
+
+	for name, authorization := range map[string]string{
+		"jwt":     "Bearer eyJhbGciOiJIUzI1NiJ9.e30.sig",
+		"api key": "Bearer " + services.APIKeyPrefix + "secret",
+		"none":    "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			sessionAuthCalls = 0
+			req := httptest.NewRequest(http.MethodGet, "/history", nil)
+			if authorization != "" {
+				req.Header.Set("Authorization", authorization)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "session-user", w.Body.String())
+			assert.Equal(t, 1, sessionAuthCalls)
+		})
+	}
+}
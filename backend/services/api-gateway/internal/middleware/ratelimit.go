@@ -2,7 +2,9 @@ package middleware
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/betterprompts/api-gateway/internal/services"
@@ -12,22 +14,54 @@ import (
 
 // RateLimitConfig defines rate limiting configuration
 type RateLimitConfig struct {
+	Name       string        // Identifies the limiter to clients, e.g. in GET /limits
 	Limit      int           // Maximum number of requests
 	Window     time.Duration // Time window for the limit
 	KeyFunc    func(*gin.Context) string // Function to extract rate limit key
 	SkipFunc   func(*gin.Context) bool   // Function to determine if rate limiting should be skipped
 	OnLimitHit func(*gin.Context, int)   // Callback when rate limit is hit
+	WarnAt     float64                   // Fraction of Limit used from which responses carry X-RateLimit-Warning; zero turns warnings off
+	OnWarning  func(*gin.Context, services.RateLimitWarning) // Callback once per window, on the request that crosses WarnAt
+	FailClosed bool                                          // Reject requests while the limit can't be checked, rather than allow them
+}
+
+// WarningThreshold returns the number of requests in a window from which the
+// caller is warned, or zero when warnings are off
+func (config RateLimitConfig) WarningThreshold() int {
+	if config.WarnAt <= 0 || config.Limit <= 0 {
+		return 0
+	}
+	return int(math.Ceil(config.WarnAt * float64(config.Limit)))
+}
+
+// FormatRateLimitWarning renders an X-RateLimit-Warning header value, e.g.
+// "default; used=80; limit=100; reset=1700000040"
+func FormatRateLimitWarning(warning services.RateLimitWarning) string {
+	return fmt.Sprintf("%s; used=%d; limit=%d; reset=%d", warning.Limiter, warning.Used, warning.Limit, warning.ResetAt.Unix())
+}
+
+// RecordRateLimitWarnings returns an OnWarning callback handing warnings to
+// the recorder, which passes them on to abuse detection and in-app notices
+func RecordRateLimitWarnings(warnings *services.RateLimitWarnings) func(*gin.Context, services.RateLimitWarning) {
+	return func(c *gin.Context, warning services.RateLimitWarning) {
+		var userID string
+		if rc := GetRequestContext(c); rc.Authenticated() {
+			userID = rc.UserID
+		}
+		warnings.Record(c.Request.Context(), userID, warning)
+	}
 }
 
 // DefaultRateLimitConfig returns a default rate limit configuration
 func DefaultRateLimitConfig() RateLimitConfig {
 	return RateLimitConfig{
+		Name:   "default",
 		Limit:  100,
 		Window: 1 * time.Minute,
 		KeyFunc: func(c *gin.Context) string {
 			// Use user ID if authenticated, otherwise use IP
-			if userID, exists := c.Get("user_id"); exists {
-				return fmt.Sprintf("user:%v", userID)
+			if rc := GetRequestContext(c); rc.Authenticated() {
+				return fmt.Sprintf("user:%s", rc.UserID)
 			}
 			return fmt.Sprintf("ip:%s", c.ClientIP())
 		},
@@ -45,12 +79,13 @@ func DefaultRateLimitConfig() RateLimitConfig {
 // TestRateLimitConfig returns a rate limit configuration optimized for testing
 func TestRateLimitConfig() RateLimitConfig {
 	return RateLimitConfig{
+		Name:   "default",
 		Limit:  1000, // Higher limit for tests
 		Window: 1 * time.Minute,
 		KeyFunc: func(c *gin.Context) string {
 			// Use user ID if authenticated, otherwise use IP
-			if userID, exists := c.Get("user_id"); exists {
-				return fmt.Sprintf("test_user:%v", userID)
+			if rc := GetRequestContext(c); rc.Authenticated() {
+				return fmt.Sprintf("test_user:%s", rc.UserID)
 			}
 			return fmt.Sprintf("test_ip:%s", c.ClientIP())
 		},
@@ -67,6 +102,73 @@ func TestRateLimitConfig() RateLimitConfig {
 	}
 }
 
+// ExtensionRateLimitConfig returns the stricter limits applied to browser
+// extension traffic, which fires on keystroke-level user actions
+func ExtensionRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Name:   "extension",
+		Limit:  20,
+		Window: 1 * time.Minute,
+		KeyFunc: func(c *gin.Context) string {
+			if rc := GetRequestContext(c); rc.Authenticated() {
+				return fmt.Sprintf("ext_user:%s", rc.UserID)
+			}
+			return fmt.Sprintf("ext_ip:%s", c.ClientIP())
+		},
+		OnLimitHit: func(c *gin.Context, remaining int) {
+			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+			c.Header("Retry-After", "60")
+		},
+	}
+}
+
+// TrialRateLimitConfig returns the daily enhancement quota of users on a
+// trial, applied on top of the regular limits. Other callers skip it.
+func TrialRateLimitConfig(dailyLimit int) RateLimitConfig {
+	return RateLimitConfig{
+		Name:   "trial",
+		Limit:  dailyLimit,
+		Window: 24 * time.Hour,
+		KeyFunc: func(c *gin.Context) string {
+			return fmt.Sprintf("trial_user:%s", GetRequestContext(c).UserID)
+		},
+		SkipFunc: func(c *gin.Context) bool {
+			return !GetRequestContext(c).OnTrial()
+		},
+		OnLimitHit: func(c *gin.Context, remaining int) {
+			c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", remaining))
+			c.Header("Retry-After", "86400")
+		},
+	}
+}
+
+// DemoRateLimitConfigs returns the daily caps on the public demo: per IP,
+// then across every caller, so one IP can't use up the global cap once it
+// hits its own. Both fail closed, the demo being open to anyone.
+func DemoRateLimitConfigs(perIP, global int) []RateLimitConfig {
+	onLimitHit := func(c *gin.Context, remaining int) {
+		c.Header("Retry-After", strconv.FormatInt(int64(time.Until(services.RateLimitResetAt(time.Now(), 24*time.Hour)).Seconds())+1, 10))
+	}
+	return []RateLimitConfig{
+		{
+			Name:       "demo",
+			Limit:      perIP,
+			Window:     24 * time.Hour,
+			KeyFunc:    func(c *gin.Context) string { return "demo_ip:" + c.ClientIP() },
+			OnLimitHit: onLimitHit,
+			FailClosed: true,
+		},
+		{
+			Name:       "demo_global",
+			Limit:      global,
+			Window:     24 * time.Hour,
+			KeyFunc:    func(c *gin.Context) string { return "demo_global" },
+			OnLimitHit: onLimitHit,
+			FailClosed: true,
+		},
+	}
+}
+
 // GetRateLimitConfigForEnvironment returns appropriate rate limit config based on environment
 func GetRateLimitConfigForEnvironment(env string) RateLimitConfig {
 	switch env {
@@ -88,6 +190,10 @@ func RateLimitMiddleware(cache *services.CacheService, config RateLimitConfig, l
 
 		// Skip if cache is not available
 		if cache == nil {
+			if config.FailClosed {
+				rejectUncheckedRateLimit(c)
+				return
+			}
 			logger.Warn("Rate limiting disabled: cache not available")
 			c.Next()
 			return
@@ -105,6 +211,10 @@ func RateLimitMiddleware(cache *services.CacheService, config RateLimitConfig, l
 		allowed, remaining, err := cache.RateLimitCheck(c.Request.Context(), key, config.Limit, config.Window)
 		if err != nil {
 			logger.WithError(err).Error("Rate limit check failed")
+			if config.FailClosed {
+				rejectUncheckedRateLimit(c)
+				return
+			}
 			// Allow request on error
 			c.Next()
 			return
@@ -130,25 +240,51 @@ func RateLimitMiddleware(cache *services.CacheService, config RateLimitConfig, l
 			return
 		}
 
+		// Heavy but legitimate callers are told before they are rejected.
+		// Every request past the threshold carries the header; the callback
+		// only fires for the one that crossed it.
+		if threshold := config.WarningThreshold(); threshold > 0 {
+			if used := config.Limit - remaining; used >= threshold {
+				warning := services.RateLimitWarning{
+					Limiter: config.Name,
+					Used:    used,
+					Limit:   config.Limit,
+					ResetAt: services.RateLimitResetAt(time.Now(), config.Window),
+				}
+				c.Writer.Header().Add("X-RateLimit-Warning", FormatRateLimitWarning(warning))
+				if used == threshold && config.OnWarning != nil {
+					config.OnWarning(c, warning)
+				}
+			}
+		}
+
 		c.Next()
 	}
 }
 
+// rejectUncheckedRateLimit refuses a request whose fail-closed limit
+// couldn't be checked
+func rejectUncheckedRateLimit(c *gin.Context) {
+	c.Header("Retry-After", "60")
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Temporarily unavailable, please retry later"})
+	c.Abort()
+}
+
 // UserRateLimitMiddleware creates a user-specific rate limiter
 func UserRateLimitMiddleware(cache *services.CacheService, limit int, window time.Duration, logger *logrus.Logger) gin.HandlerFunc {
 	config := RateLimitConfig{
+		Name:   "user",
 		Limit:  limit,
 		Window: window,
 		KeyFunc: func(c *gin.Context) string {
-			if userID, exists := c.Get("user_id"); exists {
-				return fmt.Sprintf("user_rate:%v", userID)
+			if rc := GetRequestContext(c); rc.Authenticated() {
+				return fmt.Sprintf("user_rate:%s", rc.UserID)
 			}
 			return ""
 		},
 		SkipFunc: func(c *gin.Context) bool {
 			// Only apply to authenticated users
-			_, exists := c.Get("user_id")
-			return !exists
+			return !GetRequestContext(c).Authenticated()
 		},
 	}
 	return RateLimitMiddleware(cache, config, logger)
@@ -157,6 +293,7 @@ func UserRateLimitMiddleware(cache *services.CacheService, limit int, window tim
 // IPRateLimitMiddleware creates an IP-based rate limiter
 func IPRateLimitMiddleware(cache *services.CacheService, limit int, window time.Duration, logger *logrus.Logger) gin.HandlerFunc {
 	config := RateLimitConfig{
+		Name:   "ip",
 		Limit:  limit,
 		Window: window,
 		KeyFunc: func(c *gin.Context) string {
@@ -169,12 +306,13 @@ func IPRateLimitMiddleware(cache *services.CacheService, limit int, window time.
 // EndpointRateLimitMiddleware creates an endpoint-specific rate limiter
 func EndpointRateLimitMiddleware(cache *services.CacheService, endpoint string, limit int, window time.Duration, logger *logrus.Logger) gin.HandlerFunc {
 	config := RateLimitConfig{
+		Name:   "endpoint:" + endpoint,
 		Limit:  limit,
 		Window: window,
 		KeyFunc: func(c *gin.Context) string {
 			key := fmt.Sprintf("endpoint:%s", endpoint)
-			if userID, exists := c.Get("user_id"); exists {
-				key = fmt.Sprintf("%s:user:%v", key, userID)
+			if rc := GetRequestContext(c); rc.Authenticated() {
+				key = fmt.Sprintf("%s:user:%s", key, rc.UserID)
 			} else {
 				key = fmt.Sprintf("%s:ip:%s", key, c.ClientIP())
 			}
@@ -182,4 +320,57 @@ func EndpointRateLimitMiddleware(cache *services.CacheService, endpoint string,
 		},
 	}
 	return RateLimitMiddleware(cache, config, logger)
-}
\ No newline at end of file
+}
+
+// RateLimitWindow is the caller's standing in one rate limiter's current window
+type RateLimitWindow struct {
+	Name          string    `json:"name"`
+	Limit         int       `json:"limit"`
+	Used          int       `json:"used"`
+	Remaining     int       `json:"remaining"`
+	WindowSeconds int       `json:"window_seconds"`
+	ResetAt       time.Time `json:"reset_at"`
+	Warning       bool      `json:"warning"` // Past the limiter's warning threshold
+}
+
+// RateLimitStatus reports the caller's current window in each of the given
+// limiters without counting a request. Limiters that wouldn't apply to the
+// caller are left out.
+func RateLimitStatus(c *gin.Context, cache *services.CacheService, configs ...RateLimitConfig) ([]RateLimitWindow, error) {
+	windows := []RateLimitWindow{}
+	for _, config := range configs {
+		if config.SkipFunc != nil && config.SkipFunc(c) {
+			continue
+		}
+		key := config.KeyFunc(c)
+		if key == "" {
+			continue
+		}
+
+		used, resetAt, err := cache.RateLimitStatus(c.Request.Context(), key, config.Window)
+		if err != nil {
+			return nil, err
+		}
+		window := NewRateLimitWindow(config.Name, config.Limit, used, config.Window, resetAt)
+		threshold := config.WarningThreshold()
+		window.Warning = threshold > 0 && used >= threshold
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// NewRateLimitWindow builds a window report, clamping remaining at zero
+func NewRateLimitWindow(name string, limit, used int, window time.Duration, resetAt time.Time) RateLimitWindow {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return RateLimitWindow{
+		Name:          name,
+		Limit:         limit,
+		Used:          used,
+		Remaining:     remaining,
+		WindowSeconds: int(window.Seconds()),
+		ResetAt:       resetAt,
+	}
+}
@@ -0,0 +1,49 @@
+package middleware_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateLimitWindow(t *testing.T) {
+	resetAt := time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC)
+
+	window := middleware.NewRateLimitWindow("default", 100, 40, time.Minute, resetAt)
+	assert.Equal(t, middleware.RateLimitWindow{
+		Name:          "default",
+		Limit:         100,
+		Used:          40,
+		Remaining:     60,
+		WindowSeconds: 60,
+		ResetAt:       resetAt,
+	}, window)
+
+	// Rejected requests still increment the counter past the limit
+	exhausted := middleware.NewRateLimitWindow("extension", 20, 27, time.Minute, resetAt)
+	assert.Equal(t, 0, exhausted.Remaining)
+	assert.Equal(t, 27, exhausted.Used)
+}
+
+func TestRateLimitWarningThreshold(t *testing.T) {
+	config := middleware.RateLimitConfig{Name: "default", Limit: 100, WarnAt: 0.8}
+	assert.Equal(t, 80, config.WarningThreshold())
+
+	// Small limits round up so the warning never comes early
+	config.Limit = 7
+	assert.Equal(t, 6, config.WarningThreshold())
+
+	config.WarnAt = 0
+	assert.Zero(t, config.WarningThreshold(), "warnings are off")
+
+	warning := services.RateLimitWarning{
+		Limiter: "extension",
+		Used:    16,
+		Limit:   20,
+		ResetAt: time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "extension; used=16; limit=20; reset=1704110460", middleware.FormatRateLimitWarning(warning))
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Recovery turns panics into 500s like gin.Recovery, and reports each one
+// with its stack, the sanitized request and recent log entries. Clients get
+// the crash ID to quote to support.
+func Recovery(reporter *services.CrashReporter, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// The client went away mid-response; nothing crashed
+			if err, ok := recovered.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				c.Abort()
+				return
+			}
+
+			report := services.NewCrashReport(recovered, debug.Stack(), 1)
+			report.Request = &services.CrashRequest{
+				Method:    c.Request.Method,
+				Path:      c.Request.URL.Path,
+				Route:     c.FullPath(),
+				Query:     services.SanitizeCrashValues(c.Request.URL.Query()),
+				Headers:   services.SanitizeCrashValues(c.Request.Header),
+				RequestID: c.GetString("request_id"),
+			}
+			report.Request.UserID, _ = GetUserID(c)
+
+			logger.WithFields(logrus.Fields{
+				"crash_id":   report.ID,
+				"signature":  report.Signature,
+				"request_id": report.Request.RequestID,
+				"path":       report.Request.Path,
+			}).Error("Recovered from panic: " + report.Message)
+			if reporter != nil {
+				reporter.Report(report)
+			}
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+				"error":      "internal server error",
+				"crash_id":   report.ID,
+				"request_id": report.Request.RequestID,
+			})
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,63 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type channelSink chan *services.CrashReport
+
+func (s channelSink) Send(ctx context.Context, report *services.CrashReport) error {
+	s <- report
+	return nil
+}
+
+func TestRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	sink := make(channelSink, 1)
+	reporter := services.NewCrashReporter(sink, nil, "test", time.Minute, logger)
+
+	router := gin.New()
+	router.Use(middleware.Recovery(reporter, logger))
+	router.GET("/boom", func(c *gin.Context) {
+		var profile map[string]string
+		profile["name"] = c.Query("name") // nil map write
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/boom?name=ada&token=abc", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+	select {
+	case report := <-sink:
+		assert.Equal(t, body["crash_id"], report.ID)
+		assert.Equal(t, "test", report.Environment)
+		assert.Equal(t, "/boom", report.Request.Route)
+		assert.Equal(t, "ada", report.Request.Query["name"])
+		assert.Equal(t, "[Filtered]", report.Request.Query["token"])
+		assert.Equal(t, "[Filtered]", report.Request.Headers["Authorization"])
+		assert.Contains(t, report.Message, "nil map")
+		assert.Contains(t, report.Stack, "recovery_test.go")
+	case <-time.After(time.Second):
+		t.Fatal("crash wasn't reported")
+	}
+}
@@ -1,20 +1,120 @@
 package middleware
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
-// RequestID middleware adds a unique request ID to each request
+// maxRequestIDLength bounds client-supplied request IDs, which end up in
+// logs and downstream headers
+const maxRequestIDLength = 128
+
+// traceParentPattern matches a W3C traceparent header: version, trace ID,
+// parent span ID and flags
+var traceParentPattern = regexp.MustCompile(`^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// RequestID middleware adds a unique request ID to each request, and joins
+// the caller's W3C trace when it sent a traceparent, starting a new trace
+// otherwise. The gateway's own span becomes the parent of downstream calls.
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestID := c.GetHeader("X-Request-ID")
-		if requestID == "" {
+		if requestID == "" || len(requestID) > maxRequestIDLength || strings.ContainsAny(requestID, "\r\n") {
 			requestID = uuid.New().String()
 		}
 		c.Set("request_id", requestID)
 		c.Header("X-Request-ID", requestID)
+
+		traceID, flags := randomHex(16), "00"
+		if m := traceParentPattern.FindStringSubmatch(c.GetHeader("traceparent")); m != nil && m[1] != "ff" && strings.Trim(m[2], "0") != "" {
+			traceID, flags = m[2], m[4]
+			if state := c.GetHeader("tracestate"); state != "" {
+				c.Set("tracestate", state)
+			}
+		}
+		c.Set("trace_id", traceID)
+		c.Set("traceparent", "00-"+traceID+"-"+randomHex(8)+"-"+flags)
+		c.Next()
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return strings.ReplaceAll(uuid.New().String(), "-", "")[:2*n]
+	}
+	return hex.EncodeToString(b)
+}
+
+// errorBodyWriter holds back error responses so their bodies can be given
+// the request ID
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorBodyWriter) Write(data []byte) (int, error) {
+	if w.Status() >= 400 {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorBodyWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *errorBodyWriter) Written() bool {
+	return w.body.Len() > 0 || w.ResponseWriter.Written()
+}
+
+// RequestIDInErrors adds request_id to every JSON error body that doesn't
+// already carry one, so users can quote it in support tickets. It must run
+// after RequestID.
+func RequestIDInErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		original := c.Writer
+		writer := &errorBodyWriter{ResponseWriter: original}
+		c.Writer = writer
+		completed := false
+		defer func() {
+			// After a panic the recovery middleware answers instead
+			c.Writer = original
+			if !completed || writer.body.Len() == 0 {
+				return
+			}
+			body := writer.body.Bytes()
+			if strings.HasPrefix(original.Header().Get("Content-Type"), "application/json") {
+				var fields map[string]interface{}
+				if json.Unmarshal(body, &fields) == nil && fields != nil {
+					if _, ok := fields["request_id"]; !ok {
+						fields["request_id"] = c.GetString("request_id")
+						if encoded, err := json.Marshal(fields); err == nil {
+							body = encoded
+						}
+					}
+				}
+			}
+			original.Write(body)
+		}()
+		c.Next()
+		completed = true
+	}
+}
+
+// Region tags each request with the region serving it, for logs and clients
+func Region(region string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("region", region)
+		c.Header("X-Served-By-Region", region)
 		c.Next()
 	}
 }
@@ -25,9 +125,13 @@ func Logger(logger *logrus.Logger) gin.HandlerFunc {
 		// Set logger in context
 		entry := logger.WithFields(logrus.Fields{
 			"request_id": c.GetString("request_id"),
+			"trace_id":   c.GetString("trace_id"),
 			"method":     c.Request.Method,
 			"path":       c.Request.URL.Path,
 		})
+		if region := c.GetString("region"); region != "" {
+			entry = entry.WithField("region", region)
+		}
 		c.Set("logger", entry)
 		c.Next()
 		
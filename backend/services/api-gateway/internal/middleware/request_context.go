@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLocale is the locale of requests without an Accept-Language
+const defaultLocale = "en"
+
+// RequestContextMiddleware builds the caller's RequestContext and attaches it
+// to the Gin context and the request's context. It must run after the
+// route's auth middleware. accounts may be nil, leaving authenticated users
+// on the free tier without an organization or flags.
+func RequestContextMiddleware(accounts *services.AccountResolver, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rc := buildRequestContext(c)
+		if rc.UserID != "" && accounts != nil {
+			account, err := accounts.Resolve(c.Request.Context(), rc.UserID)
+			if err != nil {
+				logger.WithError(err).WithField("user_id", rc.UserID).Warn("Failed to resolve account, using defaults")
+			} else {
+				rc.Tier = account.Tier
+				rc.OrgID = account.OrgID
+				rc.Flags = account.Flags
+				rc.Trial = account.Trial
+				rc.ExplanationStyle = account.ExplanationStyle
+			}
+		}
+
+		c.Set("request_context", rc)
+		c.Request = c.Request.WithContext(services.WithRequestContext(c.Request.Context(), rc))
+		if entry, ok := c.Get("logger"); ok {
+			if entry, ok := entry.(*logrus.Entry); ok {
+				c.Set("logger", entry.WithFields(rc.LogFields()))
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// GetRequestContext returns the caller's RequestContext. Routes without
+// RequestContextMiddleware get one built from the request as it stands,
+// without account details.
+func GetRequestContext(c *gin.Context) *services.RequestContext {
+	if value, exists := c.Get("request_context"); exists {
+		if rc, ok := value.(*services.RequestContext); ok {
+			return rc
+		}
+	}
+	return buildRequestContext(c)
+}
+
+func buildRequestContext(c *gin.Context) *services.RequestContext {
+	rc := &services.RequestContext{
+		RequestID:   c.GetString("request_id"),
+		TraceParent: c.GetString("traceparent"),
+		TraceState:  c.GetString("tracestate"),
+		Tier:        services.TierAnonymous,
+		Locale:      requestLocale(c.GetHeader("Accept-Language")),
+		Client: services.ClientInfo{
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+			SessionID: c.GetHeader("X-Session-ID"),
+			APIKeyID:  c.GetString("api_key_id"),
+			Region:    c.GetString("region"),
+		},
+	}
+	if rc.Client.SessionID == "" {
+		rc.Client.SessionID = c.GetString("session_id")
+	}
+	if rc.Client.SessionID == "" {
+		rc.Client.SessionID = rc.RequestID
+	}
+	if deadline, ok := c.Request.Context().Deadline(); ok {
+		rc.Deadline = deadline
+	}
+
+	if userID, ok := GetUserID(c); ok && userID != "" {
+		rc.UserID = userID
+		rc.Email = c.GetString("user_email")
+		rc.Roles, _ = GetUserRoles(c)
+		rc.Tier = services.TierFree
+	}
+	if tier := c.GetString("user_tier"); tier != "" {
+		rc.Tier = tier
+	}
+	return rc
+}
+
+// requestLocale is the caller's most preferred language from Accept-Language
+func requestLocale(acceptLanguage string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > bestQuality {
+			best, bestQuality = tag, quality
+		}
+	}
+	if best == "" {
+		return defaultLocale
+	}
+	return strings.ToLower(best)
+}
@@ -0,0 +1,72 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestContextMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// serve runs a request through RequestContextMiddleware, authenticated as
+	// userID unless it is empty, and returns the contexts the handler saw
+	serve := func(t *testing.T, userID string, header http.Header) (fromGin, fromRequest *services.RequestContext) {
+		router := gin.New()
+		router.Use(middleware.RequestID())
+		router.Use(func(c *gin.Context) {
+			if userID != "" {
+				c.Set("user_id", userID)
+				c.Set("user_roles", []string{"user"})
+			}
+		})
+		router.Use(middleware.RequestContextMiddleware(nil, logrus.New()))
+		router.GET("/", func(c *gin.Context) {
+			fromGin = middleware.GetRequestContext(c)
+			fromRequest = services.RequestContextFrom(c.Request.Context())
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for name, values := range header {
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		require.NotNil(t, fromGin)
+		return fromGin, fromRequest
+	}
+
+	t.Run("anonymous", func(t *testing.T) {
+		rc, fromRequest := serve(t, "", http.Header{"User-Agent": {"test-agent"}})
+
+		assert.Same(t, rc, fromRequest, "clients see the same context as handlers")
+		assert.False(t, rc.Authenticated())
+		assert.Equal(t, services.TierAnonymous, rc.Tier)
+		assert.Equal(t, "en", rc.Locale)
+		assert.NotEmpty(t, rc.RequestID)
+		assert.Equal(t, rc.RequestID, rc.Client.SessionID, "the request stands in for a missing session")
+		assert.Equal(t, "test-agent", rc.Client.UserAgent)
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		rc, _ := serve(t, "user-1", http.Header{
+			"Accept-Language": {"fr;q=0.5, de-DE, en;q=0.8"},
+			"X-Session-ID":    {"session-1"},
+		})
+
+		assert.Equal(t, "user-1", rc.UserID)
+		assert.Equal(t, []string{"user"}, rc.Roles)
+		assert.Equal(t, services.TierFree, rc.Tier)
+		assert.Equal(t, "de-de", rc.Locale)
+		assert.Equal(t, "session-1", rc.Client.SessionID)
+		assert.Equal(t, "user-1", rc.RoutingKey())
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestLog records every request in the admin request log once it
+// completes. It is a no-op when the request log is disabled.
+func RequestLog(log *services.RequestLogService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if log == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		// Only user IDs the table can hold; anything else is left anonymous
+		// rather than failing the batch
+		userID, _ := GetUserID(c)
+		if _, err := uuid.Parse(userID); err != nil {
+			userID = ""
+		}
+		log.Record(&services.RequestRecord{
+			RequestID:     c.GetString("request_id"),
+			TraceID:       c.GetString("trace_id"),
+			UserID:        userID,
+			Method:        c.Request.Method,
+			Route:         c.FullPath(),
+			Path:          c.Request.URL.Path,
+			Status:        c.Writer.Status(),
+			LatencyMs:     time.Since(start).Milliseconds(),
+			ResponseBytes: max(c.Writer.Size(), 0),
+			IP:            c.ClientIP(),
+			Region:        c.GetString("region"),
+			CreatedAt:     start,
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestIDTraceContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var traceID, traceParent string
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.GET("/", func(c *gin.Context) {
+		traceID, traceParent = c.GetString("trace_id"), c.GetString("traceparent")
+	})
+
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = header
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	incoming := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	w := serve(http.Header{"Traceparent": {incoming}, "X-Request-Id": {"support-123"}})
+	assert.Equal(t, "support-123", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID, "the caller's trace is joined")
+	assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, traceParent)
+	assert.NotContains(t, traceParent, "00f067aa0ba902b7", "the gateway is a new span")
+
+	pattern := regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-00$`)
+	for _, invalid := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		w = serve(http.Header{"Traceparent": {invalid}, "X-Request-Id": {strings.Repeat("x", 500)}})
+		assert.Regexp(t, pattern, traceParent, invalid)
+		assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", traceID)
+		assert.Len(t, w.Header().Get("X-Request-ID"), 36, "oversized request IDs are replaced")
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(middleware.RequestID())
+	router.Use(middleware.RequestIDInErrors())
+	router.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prompt not found"})
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/text", func(c *gin.Context) {
+		c.String(http.StatusBadGateway, "upstream failed")
+	})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve("/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, map[string]string{"error": "prompt not found", "request_id": "req-1"}, body)
+
+	w = serve("/ok")
+	assert.JSONEq(t, `{"status":"ok"}`, w.Body.String(), "successful responses are untouched")
+
+	w = serve("/text")
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, "upstream failed", w.Body.String())
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SCIMAuth authenticates an enterprise directory by the "Bearer scim_..."
+// token an organization admin issued it, scoping the request to that
+// organization. Failures are answered in SCIM's error format.
+func SCIMAuth(scim *services.SCIMService, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rawToken := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if rawToken == "" || !strings.HasPrefix(rawToken, services.SCIMTokenPrefix) {
+			abortSCIMUnauthorized(c, "SCIM bearer token required")
+			return
+		}
+
+		token, err := scim.Authenticate(c.Request.Context(), rawToken)
+		if err != nil {
+			if !errors.Is(err, services.ErrInvalidSCIMToken) {
+				logger.WithError(err).Error("SCIM token validation failed")
+				c.Header("Content-Type", "application/scim+json")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+					"schemas": []string{services.SCIMErrorSchema},
+					"status":  "500",
+					"detail":  "failed to validate token",
+				})
+				return
+			}
+			abortSCIMUnauthorized(c, "Invalid or revoked SCIM token")
+			return
+		}
+
+		c.Set("scim_org_id", token.OrgID)
+		c.Set("scim_token_id", token.ID)
+
+		c.Next()
+	}
+}
+
+func abortSCIMUnauthorized(c *gin.Context, detail string) {
+	c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"schemas": []string{services.SCIMErrorSchema},
+		"status":  "401",
+		"detail":  detail,
+	})
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var routeTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_route_timeouts_total",
+	Help: "Requests answered with 504 because their route timeout passed, by route",
+}, []string{"route"})
+
+// RouteTimeoutConfig holds the time each class of route may take
+type RouteTimeoutConfig struct {
+	Analyze time.Duration // Intent analysis, a single classifier call
+	Enhance time.Duration // The full pipeline; longer than ENHANCE_SOFT_TIMEOUT so slow generations become jobs first
+	Export  time.Duration // File exports and downloads
+}
+
+// LoadRouteTimeoutConfig reads ROUTE_TIMEOUT_ANALYZE, ROUTE_TIMEOUT_ENHANCE
+// and ROUTE_TIMEOUT_EXPORT. Zero turns a timeout off.
+func LoadRouteTimeoutConfig() RouteTimeoutConfig {
+	config := RouteTimeoutConfig{
+		Analyze: 10 * time.Second,
+		Enhance: 45 * time.Second,
+		Export:  5 * time.Minute,
+	}
+	for env, target := range map[string]*time.Duration{
+		"ROUTE_TIMEOUT_ANALYZE": &config.Analyze,
+		"ROUTE_TIMEOUT_ENHANCE": &config.Enhance,
+		"ROUTE_TIMEOUT_EXPORT":  &config.Export,
+	} {
+		if d, err := time.ParseDuration(os.Getenv(env)); err == nil && d >= 0 {
+			*target = d
+		}
+	}
+	return config
+}
+
+// RouteTimeout bounds how long the rest of a route's chain may run. The
+// request context carries the deadline, so downstream calls made with it are
+// cancelled when it passes, and the caller gets a 504 JSON body at the
+// deadline whatever the handler is doing. The response is buffered until the
+// handler returns, so streaming routes use RouteDeadline instead.
+func RouteTimeout(timeout time.Duration, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		// Read before the chain starts; it may replace c.Request concurrently
+		route, path, requestID := c.FullPath(), c.Request.URL.Path, c.GetString("request_id")
+
+		original := c.Writer
+		tw := &timeoutWriter{ResponseWriter: original, header: original.Header().Clone()}
+		c.Writer = tw
+
+		done := make(chan struct{})
+		var panicked interface{}
+		go func() {
+			defer close(done)
+			defer func() { panicked = recover() }()
+			c.Next()
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			select {
+			case <-done:
+				// Finished right at the deadline; its response stands
+			default:
+				tw.timeout(timeout)
+				routeTimeouts.WithLabelValues(route).Inc()
+				logger.WithFields(logrus.Fields{
+					"path":       path,
+					"request_id": requestID,
+					"timeout":    timeout.String(),
+				}).Warn("Route timed out")
+
+				// The gin context is recycled once this handler returns, so
+				// the chain must finish first; its writes are discarded
+				<-done
+			}
+		}
+
+		c.Writer = original
+		if panicked != nil {
+			panic(panicked)
+		}
+		tw.flush()
+	}
+}
+
+// RouteDeadline puts a deadline on the request context of a streaming route,
+// where RouteTimeout can't buffer the response. Downstream calls are cancelled
+// the same way, but once the stream has started the client only sees it end
+// early.
+func RouteDeadline(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// timeoutWriter buffers a response until the handler returns, or drops it in
+// favour of a timeout response once the deadline passes
+type timeoutWriter struct {
+	gin.ResponseWriter
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	written  bool
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written && code > 0 {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written = true
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// Flush is a no-op; nothing reaches the client before the handler returns
+func (w *timeoutWriter) Flush() {}
+
+// timeout sends the timeout response. Content-Length is set so the client
+// has the whole response even while the handler is still unwinding.
+func (w *timeoutWriter) timeout(timeout time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timedOut = true
+
+	body, _ := json.Marshal(gin.H{
+		"error":   "Request timed out",
+		"message": fmt.Sprintf("The request took longer than %s and was cancelled", timeout),
+	})
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+}
+
+// flush passes the buffered response on, unless the timeout response was sent
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return
+	}
+
+	dst := w.ResponseWriter.Header()
+	for key := range dst {
+		delete(dst, key)
+	}
+	for key, values := range w.header {
+		dst[key] = values
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+		w.ResponseWriter.Write(w.body.Bytes())
+	}
+}
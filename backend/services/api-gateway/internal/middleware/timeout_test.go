@@ -0,0 +1,129 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// A downstream service that answers after a second unless the caller gives up
+	cancelled := make(chan struct{}, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+			w.Write([]byte(`{"intent":"late"}`))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	defer slow.Close()
+
+	callDownstream := func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, slow.URL, nil)
+		resp, err := slow.Client().Do(req)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "downstream failed"})
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		c.Data(http.StatusOK, "application/json", body)
+	}
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Header("X-Request-ID", "req-1")
+		c.Next()
+	})
+	router.POST("/analyze", middleware.RouteTimeout(50*time.Millisecond, logger), callDownstream)
+	router.POST("/enhance", middleware.RouteTimeout(5*time.Second, logger), callDownstream)
+	router.GET("/fast", middleware.RouteTimeout(time.Second, logger), func(c *gin.Context) {
+		c.Header("X-Result", "fresh")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+	router.GET("/ignores-context", middleware.RouteTimeout(50*time.Millisecond, logger), func(c *gin.Context) {
+		time.Sleep(200 * time.Millisecond)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	t.Run("slow downstream calls are cancelled and answered with JSON", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/analyze", nil))
+
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"))
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+		assert.Equal(t, "Request timed out", body["error"])
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("downstream request was not cancelled")
+		}
+	})
+
+	t.Run("routes with longer timeouts wait for the downstream service", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/enhance", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"intent":"late"}`, w.Body.String())
+	})
+
+	t.Run("responses in time pass through unchanged", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "fresh", w.Header().Get("X-Result"))
+		assert.JSONEq(t, `{"ok":true}`, w.Body.String())
+	})
+
+	t.Run("late writes from handlers ignoring the context are dropped", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ignores-context", nil))
+		assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+		var body map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body), w.Body.String())
+		assert.Equal(t, "Request timed out", body["error"])
+	})
+
+	t.Run("panics reach the recovery middleware", func(t *testing.T) {
+		recovered := gin.New()
+		recovered.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		}))
+		recovered.GET("/boom", middleware.RouteTimeout(time.Second, logger), func(c *gin.Context) {
+			panic("boom")
+		})
+		w := httptest.NewRecorder()
+		recovered.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+}
+
+func TestLoadRouteTimeoutConfig(t *testing.T) {
+	t.Setenv("ROUTE_TIMEOUT_ANALYZE", "3s")
+	t.Setenv("ROUTE_TIMEOUT_EXPORT", "0")
+	t.Setenv("ROUTE_TIMEOUT_ENHANCE", "soon")
+
+	config := middleware.LoadRouteTimeoutConfig()
+	assert.Equal(t, 3*time.Second, config.Analyze)
+	assert.Equal(t, 45*time.Second, config.Enhance)
+	assert.Zero(t, config.Export)
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// TrafficStats counts each request's status and latency towards the
+// built-in alerts. Requests to the exempt paths, such as health checks,
+// aren't counted, and neither are event streams, which last as long as the
+// client stays.
+func TrafficStats(exempt ...string) gin.HandlerFunc {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skip[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+			return
+		}
+		services.RecordRequest(c.Writer.Status(), time.Since(start))
+	}
+}
@@ -0,0 +1,413 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Abuse signals recorded per user
+const (
+	AbuseSignalRequest          = "request"
+	AbuseSignalModerationFlag   = "moderation_flag"
+	AbuseSignalShareView        = "share_view"
+	AbuseSignalRateLimitWarning = "rate_limit_warning" // Crossed the warning threshold of a rate limiter
+)
+
+// Actions taken when an abuse rule trips
+const (
+	AbuseActionThrottle = "throttle" // Reduced rate limit until the restriction expires
+	AbuseActionSuspend  = "suspend"  // All requests rejected until the restriction expires
+	AbuseActionMonitor  = "monitor"  // No restriction; the account is queued for review only
+)
+
+// Review queue statuses
+const (
+	AbuseReviewPending = "pending"
+	AbuseReviewUpheld  = "upheld"
+	AbuseReviewLifted  = "lifted"
+)
+
+// AbuseRule trips when a signal occurs Threshold times within Window
+type AbuseRule struct {
+	Name      string
+	Signal    string
+	Window    time.Duration
+	Threshold int
+	Action    string
+	Duration  time.Duration
+}
+
+// DefaultAbuseRules are ordered most severe first so a suspension wins over a throttle
+var DefaultAbuseRules = []AbuseRule{
+	{Name: "repeated_moderation_flags", Signal: AbuseSignalModerationFlag, Window: 24 * time.Hour, Threshold: 15, Action: AbuseActionSuspend, Duration: 24 * time.Hour},
+	{Name: "moderation_flag_burst", Signal: AbuseSignalModerationFlag, Window: 1 * time.Hour, Threshold: 5, Action: AbuseActionThrottle, Duration: 1 * time.Hour},
+	{Name: "request_burst", Signal: AbuseSignalRequest, Window: 1 * time.Minute, Threshold: 120, Action: AbuseActionThrottle, Duration: 10 * time.Minute},
+	{Name: "share_scraping", Signal: AbuseSignalShareView, Window: 10 * time.Minute, Threshold: 300, Action: AbuseActionThrottle, Duration: 30 * time.Minute},
+	{Name: "sustained_rate_limit_pressure", Signal: AbuseSignalRateLimitWarning, Window: 24 * time.Hour, Threshold: 40, Action: AbuseActionMonitor, Duration: 24 * time.Hour},
+}
+
+// ThrottledRequestsPerMinute is the rate allowed to throttled accounts
+const ThrottledRequestsPerMinute = 5
+
+// AbuseRestriction is an active throttle or suspension on an account
+type AbuseRestriction struct {
+	UserID   string    `json:"user_id"`
+	Action   string    `json:"action"`
+	Rule     string    `json:"rule"`
+	Reason   string    `json:"reason"`
+	Until    time.Time `json:"until"`
+	ReviewID string    `json:"review_id,omitempty"`
+}
+
+// AbuseReview is an entry in the admin review queue
+type AbuseReview struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Rule        string     `json:"rule"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason"`
+	SignalCount int        `json:"signal_count"`
+	Status      string     `json:"status"`
+	Until       time.Time  `json:"until"`
+	ReviewedBy  *string    `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AbuseService detects abusive usage patterns with sliding windows in Redis,
+// applies temporary restrictions and queues them for admin review
+type AbuseService struct {
+	cache  *CacheService
+	db     *DatabaseService
+	users  *UserService
+	email  *EmailService
+	rules  []AbuseRule
+	logger *logrus.Logger
+}
+
+// NewAbuseService creates a new abuse detection service
+func NewAbuseService(cache *CacheService, db *DatabaseService, users *UserService, email *EmailService, logger *logrus.Logger) *AbuseService {
+	return &AbuseService{
+		cache:  cache,
+		db:     db,
+		users:  users,
+		email:  email,
+		rules:  DefaultAbuseRules,
+		logger: logger,
+	}
+}
+
+// RecordSignal adds a signal to the user's sliding window and applies the
+// first rule it trips. member distinguishes events; signals recorded with the
+// same member (e.g. the same share ID) count once. An empty member counts
+// every call. Returns the restriction applied, if any.
+func (s *AbuseService) RecordSignal(ctx context.Context, userID, signal, member string) (*AbuseRestriction, error) {
+	now := time.Now()
+	if member == "" {
+		member = strconv.FormatInt(now.UnixNano(), 10)
+	}
+
+	var maxWindow time.Duration
+	for _, rule := range s.rules {
+		if rule.Signal == signal && rule.Window > maxWindow {
+			maxWindow = rule.Window
+		}
+	}
+	if maxWindow == 0 {
+		return nil, nil
+	}
+
+	key := s.cache.Key("abuse", "signals", signal, userID)
+	pipe := s.cache.client.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-maxWindow).UnixNano(), 10))
+	pipe.Expire(ctx, key, maxWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to record abuse signal: %w", err)
+	}
+
+	existing, err := s.GetRestriction(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range s.rules {
+		if rule.Signal != signal {
+			continue
+		}
+		// Never downgrade a suspension to a throttle
+		if existing != nil && (existing.Action == AbuseActionSuspend || existing.Action == rule.Action) {
+			continue
+		}
+
+		from := strconv.FormatInt(now.Add(-rule.Window).UnixNano(), 10)
+		count, err := s.cache.client.ZCount(ctx, key, from, "+inf").Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to count abuse signals: %w", err)
+		}
+		if int(count) >= rule.Threshold {
+			if rule.Action == AbuseActionMonitor {
+				return nil, s.monitor(ctx, userID, rule, int(count))
+			}
+			return s.restrict(ctx, userID, rule, int(count))
+		}
+	}
+
+	return nil, nil
+}
+
+// restrict applies a rule's action, queues it for review and notifies the user
+func (s *AbuseService) restrict(ctx context.Context, userID string, rule AbuseRule, count int) (*AbuseRestriction, error) {
+	restriction := &AbuseRestriction{
+		UserID: userID,
+		Action: rule.Action,
+		Rule:   rule.Name,
+		Reason: fmt.Sprintf("%d %s events within %s", count, rule.Signal, rule.Window),
+		Until:  time.Now().Add(rule.Duration),
+	}
+
+	review, err := s.createReview(ctx, restriction, count)
+	if err != nil {
+		// Still restrict; the review row can be recreated by an admin
+		s.logger.WithError(err).WithField("user_id", userID).Error("Failed to queue abuse review")
+	} else {
+		restriction.ReviewID = review.ID
+	}
+
+	data, err := json.Marshal(restriction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal restriction: %w", err)
+	}
+	if err := s.cache.client.Set(ctx, s.restrictionKey(userID), data, rule.Duration).Err(); err != nil {
+		return nil, fmt.Errorf("failed to store restriction: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"action":  restriction.Action,
+		"rule":    restriction.Rule,
+		"until":   restriction.Until,
+	}).Warn("Abuse restriction applied")
+
+	go s.notify(restriction)
+
+	return restriction, nil
+}
+
+// monitor queues the account for review once per rule duration, leaving any
+// restriction in place
+func (s *AbuseService) monitor(ctx context.Context, userID string, rule AbuseRule, count int) error {
+	first, err := s.cache.client.SetNX(ctx, s.cache.Key("abuse", "monitored", rule.Name, userID), 1, rule.Duration).Result()
+	if err != nil {
+		return fmt.Errorf("failed to mark monitored account: %w", err)
+	}
+	if !first {
+		return nil
+	}
+
+	reason := fmt.Sprintf("%d %s events within %s", count, rule.Signal, rule.Window)
+	_, err = s.FlagForReview(ctx, userID, rule.Name, reason, count, time.Now().Add(rule.Duration))
+	return err
+}
+
+// GetRestriction returns the user's active restriction, or nil
+func (s *AbuseService) GetRestriction(ctx context.Context, userID string) (*AbuseRestriction, error) {
+	data, err := s.cache.client.Get(ctx, s.restrictionKey(userID)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get restriction: %w", err)
+	}
+
+	var restriction AbuseRestriction
+	if err := json.Unmarshal(data, &restriction); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal restriction: %w", err)
+	}
+	return &restriction, nil
+}
+
+// AllowThrottled applies the reduced rate limit for throttled accounts
+func (s *AbuseService) AllowThrottled(ctx context.Context, userID string) (bool, error) {
+	allowed, _, err := s.cache.RateLimitCheck(ctx, "abuse_throttle:"+userID, ThrottledRequestsPerMinute, time.Minute)
+	return allowed, err
+}
+
+// ThrottleStatus returns the requests a throttled account has made in the
+// current minute and when the window resets
+func (s *AbuseService) ThrottleStatus(ctx context.Context, userID string) (int, time.Time, error) {
+	return s.cache.RateLimitStatus(ctx, "abuse_throttle:"+userID, time.Minute)
+}
+
+// ListReviews returns review queue entries with the given status, oldest first
+func (s *AbuseService) ListReviews(ctx context.Context, status string, limit, offset int) ([]*AbuseReview, error) {
+	query := `
+		SELECT id, user_id, rule, action, reason, signal_count, status,
+			   restricted_until, reviewed_by, reviewed_at, created_at
+		FROM auth.abuse_reviews
+		WHERE status = $1
+		ORDER BY created_at ASC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query abuse reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := []*AbuseReview{}
+	for rows.Next() {
+		review, err := scanAbuseReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate abuse reviews: %w", err)
+	}
+
+	return reviews, nil
+}
+
+// ResolveReview records an admin decision. Lifting a review removes the
+// restriction immediately; upholding leaves it to expire.
+func (s *AbuseService) ResolveReview(ctx context.Context, reviewID, reviewerID, status string) (*AbuseReview, error) {
+	if status != AbuseReviewUpheld && status != AbuseReviewLifted {
+		return nil, fmt.Errorf("invalid review status: %s", status)
+	}
+
+	query := `
+		UPDATE auth.abuse_reviews
+		SET status = $2, reviewed_by = $3, reviewed_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'pending'
+		RETURNING id, user_id, rule, action, reason, signal_count, status,
+				  restricted_until, reviewed_by, reviewed_at, created_at`
+
+	review, err := scanAbuseReview(s.db.DB.QueryRowContext(ctx, query, reviewID, status, reviewerID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("abuse review not found")
+		}
+		return nil, err
+	}
+
+	if status == AbuseReviewLifted && review.Action != AbuseActionMonitor {
+		if err := s.cache.client.Del(ctx, s.restrictionKey(review.UserID)).Err(); err != nil {
+			return nil, fmt.Errorf("failed to lift restriction: %w", err)
+		}
+		s.logger.WithFields(logrus.Fields{
+			"user_id":     review.UserID,
+			"review_id":   review.ID,
+			"reviewed_by": reviewerID,
+		}).Info("Abuse restriction lifted")
+	}
+
+	return review, nil
+}
+
+func (s *AbuseService) createReview(ctx context.Context, restriction *AbuseRestriction, count int) (*AbuseReview, error) {
+	query := `
+		INSERT INTO auth.abuse_reviews (id, user_id, rule, action, reason, signal_count, restricted_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, user_id, rule, action, reason, signal_count, status,
+				  restricted_until, reviewed_by, reviewed_at, created_at`
+
+	return scanAbuseReview(s.db.DB.QueryRowContext(ctx, query,
+		uuid.New().String(), restriction.UserID, restriction.Rule, restriction.Action,
+		restriction.Reason, count, restriction.Until,
+	))
+}
+
+// FlagForReview queues an account for admin attention without restricting
+// it, for accounts that are the target rather than the source of abuse
+func (s *AbuseService) FlagForReview(ctx context.Context, userID, rule, reason string, count int, until time.Time) (*AbuseReview, error) {
+	review, err := s.createReview(ctx, &AbuseRestriction{
+		UserID: userID,
+		Action: AbuseActionMonitor,
+		Rule:   rule,
+		Reason: reason,
+		Until:  until,
+	}, count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to queue abuse review: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"rule":      rule,
+		"review_id": review.ID,
+	}).Warn("Account flagged for abuse review")
+
+	return review, nil
+}
+
+// notify emails the user about a restriction; failures are only logged
+func (s *AbuseService) notify(restriction *AbuseRestriction) {
+	if s.users == nil || s.email == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user, err := s.users.GetUserByID(ctx, restriction.UserID)
+	if err != nil {
+		s.logger.WithError(err).WithField("user_id", restriction.UserID).Warn("Failed to load user for abuse notification")
+		return
+	}
+
+	heading := "Your account has been temporarily rate limited"
+	if restriction.Action == AbuseActionSuspend {
+		heading = "Your account has been temporarily suspended"
+	}
+	message := fmt.Sprintf(
+		"We detected unusual activity on your account. Access will be restored automatically at %s UTC. If you believe this is a mistake, reply to this email and our team will review it.",
+		restriction.Until.UTC().Format("2006-01-02 15:04"),
+	)
+
+	if err := s.email.SendNoticeEmail(ctx, user.Email, user.Username, heading, heading, message); err != nil {
+		s.logger.WithError(err).WithField("user_id", restriction.UserID).Warn("Failed to send abuse notification")
+	}
+}
+
+func (s *AbuseService) restrictionKey(userID string) string {
+	return s.cache.Key("abuse", "restriction", userID)
+}
+
+func scanAbuseReview(row rowScanner) (*AbuseReview, error) {
+	var review AbuseReview
+	var reviewedBy sql.NullString
+	var reviewedAt sql.NullTime
+
+	err := row.Scan(
+		&review.ID, &review.UserID, &review.Rule, &review.Action, &review.Reason,
+		&review.SignalCount, &review.Status, &review.Until, &reviewedBy, &reviewedAt,
+		&review.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan abuse review: %w", err)
+	}
+
+	if reviewedBy.Valid {
+		review.ReviewedBy = &reviewedBy.String
+	}
+	if reviewedAt.Valid {
+		review.ReviewedAt = &reviewedAt.Time
+	}
+
+	return &review, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAbuseRules mirror DefaultAbuseRules with thresholds a test can reach
+var testAbuseRules = []AbuseRule{
+	{Name: "repeated_moderation_flags", Signal: AbuseSignalModerationFlag, Window: time.Hour, Threshold: 4, Action: AbuseActionSuspend, Duration: time.Hour},
+	{Name: "moderation_flag_burst", Signal: AbuseSignalModerationFlag, Window: time.Hour, Threshold: 2, Action: AbuseActionThrottle, Duration: time.Hour},
+	{Name: "request_burst", Signal: AbuseSignalRequest, Window: time.Minute, Threshold: 3, Action: AbuseActionThrottle, Duration: 10 * time.Minute},
+	{Name: "sustained_rate_limit_pressure", Signal: AbuseSignalRateLimitWarning, Window: time.Hour, Threshold: 2, Action: AbuseActionMonitor, Duration: time.Hour},
+}
+
+// newTestAbuseService returns an abuse service over an in-memory Redis and a
+// recording database that answers every abuse_reviews query with reviewRow
+func newTestAbuseService(t *testing.T, reviewRow []driver.Value) (*AbuseService, *recordingDriver) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, "auth.abuse_reviews") {
+			return nil, nil
+		}
+		return []string{"id", "user_id", "rule", "action", "reason", "signal_count", "status",
+			"restricted_until", "reviewed_by", "reviewed_at", "created_at"}, [][]driver.Value{reviewRow}
+	}
+
+	abuse := NewAbuseService(NewCacheService(client, logger), NewDatabaseService(db.DB), nil, nil, logger)
+	abuse.rules = testAbuseRules
+	return abuse, d
+}
+
+func abuseReviewRow(userID, action, status string) []driver.Value {
+	now := time.Now()
+	return []driver.Value{"review-1", userID, "rule", action, "reason", int64(3), status, now.Add(time.Hour), nil, nil, now}
+}
+
+// abuseSignalMembers numbers the signals tests record so each counts
+var abuseSignalMembers atomic.Int64
+
+// recordSignals records n distinct signals and returns the last restriction
+func recordSignals(t *testing.T, abuse *AbuseService, userID, signal string, n int) *AbuseRestriction {
+	var restriction *AbuseRestriction
+	for i := 0; i < n; i++ {
+		var err error
+		member := strconv.FormatInt(abuseSignalMembers.Add(1), 10)
+		restriction, err = abuse.RecordSignal(context.Background(), userID, signal, member)
+		require.NoError(t, err)
+	}
+	return restriction
+}
+
+func countReviewInserts(d *recordingDriver) int {
+	var inserts int
+	for _, entry := range d.entries() {
+		if strings.Contains(entry, "INSERT INTO auth.abuse_reviews") {
+			inserts++
+		}
+	}
+	return inserts
+}
+
+func TestAbuseRecordSignal(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("trips each rule at its threshold", func(t *testing.T) {
+		abuse, _ := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionThrottle, AbuseReviewPending))
+
+		assert.Nil(t, recordSignals(t, abuse, "u1", AbuseSignalRequest, 2), "below the threshold")
+		restriction := recordSignals(t, abuse, "u1", AbuseSignalRequest, 1)
+		require.NotNil(t, restriction)
+		assert.Equal(t, AbuseActionThrottle, restriction.Action)
+		assert.Equal(t, "request_burst", restriction.Rule)
+		assert.Equal(t, "review-1", restriction.ReviewID)
+
+		stored, err := abuse.GetRestriction(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, "request_burst", stored.Rule)
+
+		// More flags escalate a throttle to a suspension
+		restriction = recordSignals(t, abuse, "u2", AbuseSignalModerationFlag, 2)
+		require.NotNil(t, restriction)
+		assert.Equal(t, "moderation_flag_burst", restriction.Rule)
+		restriction = recordSignals(t, abuse, "u2", AbuseSignalModerationFlag, 2)
+		require.NotNil(t, restriction)
+		assert.Equal(t, AbuseActionSuspend, restriction.Action)
+		assert.Equal(t, "repeated_moderation_flags", restriction.Rule)
+	})
+
+	t.Run("signals with the same member count once", func(t *testing.T) {
+		abuse, _ := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionThrottle, AbuseReviewPending))
+		for i := 0; i < 5; i++ {
+			restriction, err := abuse.RecordSignal(ctx, "u1", AbuseSignalRequest, "same")
+			require.NoError(t, err)
+			assert.Nil(t, restriction)
+		}
+	})
+
+	t.Run("never downgrades a suspension", func(t *testing.T) {
+		abuse, _ := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionSuspend, AbuseReviewPending))
+		require.NotNil(t, recordSignals(t, abuse, "u1", AbuseSignalModerationFlag, 4))
+
+		assert.Nil(t, recordSignals(t, abuse, "u1", AbuseSignalRequest, 5))
+		stored, err := abuse.GetRestriction(ctx, "u1")
+		require.NoError(t, err)
+		assert.Equal(t, AbuseActionSuspend, stored.Action)
+		assert.Equal(t, "repeated_moderation_flags", stored.Rule)
+	})
+
+	t.Run("monitoring queues a review once without restricting", func(t *testing.T) {
+		abuse, d := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionMonitor, AbuseReviewPending))
+
+		assert.Nil(t, recordSignals(t, abuse, "u1", AbuseSignalRateLimitWarning, 5))
+		assert.Equal(t, 1, countReviewInserts(d))
+		stored, err := abuse.GetRestriction(ctx, "u1")
+		require.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+
+	t.Run("ignores signals without rules", func(t *testing.T) {
+		abuse, d := newTestAbuseService(t, nil)
+		assert.Nil(t, recordSignals(t, abuse, "u1", AbuseSignalShareView, 10))
+		assert.Empty(t, d.entries())
+	})
+}
+
+func TestAbuseResolveReview(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("lifting removes the restriction", func(t *testing.T) {
+		abuse, _ := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionSuspend, AbuseReviewLifted))
+		require.NotNil(t, recordSignals(t, abuse, "u1", AbuseSignalModerationFlag, 4))
+
+		review, err := abuse.ResolveReview(ctx, "review-1", "admin-1", AbuseReviewLifted)
+		require.NoError(t, err)
+		assert.Equal(t, AbuseReviewLifted, review.Status)
+		stored, err := abuse.GetRestriction(ctx, "u1")
+		require.NoError(t, err)
+		assert.Nil(t, stored)
+	})
+
+	t.Run("upholding leaves the restriction to expire", func(t *testing.T) {
+		abuse, _ := newTestAbuseService(t, abuseReviewRow("u1", AbuseActionSuspend, AbuseReviewUpheld))
+		require.NotNil(t, recordSignals(t, abuse, "u1", AbuseSignalModerationFlag, 4))
+
+		_, err := abuse.ResolveReview(ctx, "review-1", "admin-1", AbuseReviewUpheld)
+		require.NoError(t, err)
+		stored, err := abuse.GetRestriction(ctx, "u1")
+		require.NoError(t, err)
+		assert.NotNil(t, stored)
+	})
+
+	t.Run("rejects other statuses", func(t *testing.T) {
+		abuse, d := newTestAbuseService(t, nil)
+		_, err := abuse.ResolveReview(ctx, "review-1", "admin-1", AbuseReviewPending)
+		assert.Error(t, err)
+		assert.Empty(t, d.entries())
+	})
+}
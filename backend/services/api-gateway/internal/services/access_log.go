@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Access event categories. Activity recorded by the analytics pipeline is
+// compiled into the log as AccessActivity.
+const (
+	AccessAuthentication = "authentication"
+	AccessDataAccess     = "data_access"
+	AccessAdminAction    = "admin_action"
+	AccessActivity       = "activity"
+)
+
+// AccessLogCategories lists the categories an access log can be filtered on
+var AccessLogCategories = []string{AccessAuthentication, AccessDataAccess, AccessAdminAction, AccessActivity}
+
+// MaxAccessLogEvents caps one access log; narrower time ranges get the rest
+const MaxAccessLogEvents = 50000
+
+// ErrAccessLogUserNotFound is returned when compiling the log of a user that
+// doesn't exist
+var ErrAccessLogUserNotFound = errors.New("user not found")
+
+// AccessEvent is one entry of a user's access log
+type AccessEvent struct {
+	ID         string                 `json:"id"`
+	Source     string                 `json:"source"` // Table the entry was compiled from
+	UserID     string                 `json:"user_id"`
+	ActorID    string                 `json:"actor_id,omitempty"` // Who acted, when it wasn't the user
+	Category   string                 `json:"category"`
+	Action     string                 `json:"action"`
+	ResourceID string                 `json:"resource_id,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	IP         string                 `json:"ip_address,omitempty"`
+	UserAgent  string                 `json:"user_agent,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AccessLogQuery selects the part of a user's access log to compile
+type AccessLogQuery struct {
+	From       time.Time
+	To         time.Time
+	Categories []string // All categories when empty
+}
+
+// AccessLog is a user's access log over a time range, oldest first
+type AccessLog struct {
+	UserID    string         `json:"user_id"`
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Events    []*AccessEvent `json:"events"`
+	Truncated bool           `json:"truncated"` // More than MaxAccessLogEvents matched
+}
+
+// AccessLogService records access events and compiles per-user access logs
+// for SOC 2 evidence and data subject access requests. A log combines the
+// recorded events with the user's devices and analytics activity.
+type AccessLogService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewAccessLogService creates a new access log service
+func NewAccessLogService(db *DatabaseService, logger *logrus.Logger) *AccessLogService {
+	return &AccessLogService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Record stores an access event
+func (s *AccessLogService) Record(ctx context.Context, event *AccessEvent) error {
+	if event.UserID == "" {
+		return errors.New("access event has no user")
+	}
+	switch event.Category {
+	case AccessAuthentication, AccessDataAccess, AccessAdminAction:
+	default:
+		return fmt.Errorf("invalid access event category %q", event.Category)
+	}
+	details, err := json.Marshal(event.Details)
+	if err != nil || event.Details == nil {
+		details = []byte("{}")
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO auth.access_events (user_id, actor_id, category, action, resource_id, request_id, ip_address, user_agent, details)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9)`,
+		event.UserID, event.ActorID, event.Category, event.Action, event.ResourceID,
+		event.RequestID, event.IP, event.UserAgent, details)
+	if err != nil {
+		return fmt.Errorf("failed to record access event: %w", err)
+	}
+	return nil
+}
+
+// RecordDetached records an access event in the background, detached from
+// ctx's cancellation. Failures are logged rather than returned so access
+// logging never fails the request being logged.
+func (s *AccessLogService) RecordDetached(ctx context.Context, event *AccessEvent) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		if err := s.Record(ctx, event); err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"user_id": event.UserID,
+				"action":  event.Action,
+			}).Warn("Failed to record access event")
+		}
+	}()
+}
+
+// Compile returns a user's access log over the query's time range
+func (s *AccessLogService) Compile(ctx context.Context, userID string, query AccessLogQuery) (*AccessLog, error) {
+	var exists bool
+	err := s.db.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM auth.users WHERE id = $1)`, userID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	if !exists {
+		return nil, ErrAccessLogUserNotFound
+	}
+
+	categories := query.Categories
+	if len(categories) == 0 {
+		categories = AccessLogCategories
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT * FROM (
+			SELECT id::text AS id, 'access_events' AS source, category, action,
+				COALESCE(actor_id::text, '') AS actor_id, COALESCE(resource_id, '') AS resource_id,
+				COALESCE(request_id, '') AS request_id, COALESCE(ip_address, '') AS ip_address,
+				COALESCE(user_agent, '') AS user_agent, details, created_at
+			FROM auth.access_events
+			WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+			UNION ALL
+			SELECT id::text, 'user_devices', 'authentication', 'device_registered', '', id::text, '', '',
+				COALESCE(user_agent, ''), jsonb_build_object('name', name, 'platform', platform), created_at
+			FROM auth.user_devices
+			WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+			UNION ALL
+			SELECT id::text, 'user_devices', 'authentication', 'device_revoked', '', id::text, '', '',
+				'', jsonb_build_object('name', name, 'platform', platform), revoked_at
+			FROM auth.user_devices
+			WHERE user_id = $1 AND revoked_at >= $2 AND revoked_at < $3
+			UNION ALL
+			SELECT id::text, 'user_activity', 'activity', activity_type, '', '', COALESCE(session_id, ''),
+				COALESCE(ip_address::text, ''), COALESCE(user_agent, ''), COALESCE(activity_data, '{}'::jsonb), created_at
+			FROM analytics.user_activity
+			WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+		) events
+		WHERE category = ANY($4)
+		ORDER BY created_at, id
+		LIMIT $5`, userID, query.From, query.To, pq.Array(categories), MaxAccessLogEvents+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile access log: %w", err)
+	}
+	defer rows.Close()
+
+	log := &AccessLog{UserID: userID, From: query.From, To: query.To, Events: []*AccessEvent{}}
+	for rows.Next() {
+		event := &AccessEvent{UserID: userID}
+		var details []byte
+		if err := rows.Scan(&event.ID, &event.Source, &event.Category, &event.Action, &event.ActorID, &event.ResourceID,
+			&event.RequestID, &event.IP, &event.UserAgent, &details, &event.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan access event: %w", err)
+		}
+		if len(details) > 0 && string(details) != "{}" {
+			json.Unmarshal(details, &event.Details)
+		}
+		log.Events = append(log.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to compile access log: %w", err)
+	}
+	if len(log.Events) > MaxAccessLogEvents {
+		log.Events = log.Events[:MaxAccessLogEvents]
+		log.Truncated = true
+	}
+	return log, nil
+}
+
+// RenderAccessLogCSV renders an access log as CSV, one event per row, with
+// details as a JSON column
+func RenderAccessLogCSV(log *AccessLog) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"timestamp", "category", "action", "user_id", "actor_id", "resource_id",
+		"ip_address", "user_agent", "request_id", "source", "event_id", "details"})
+	for _, event := range log.Events {
+		details := ""
+		if len(event.Details) > 0 {
+			encoded, err := json.Marshal(event.Details)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode access event details: %w", err)
+			}
+			details = string(encoded)
+		}
+		w.Write([]string{event.CreatedAt.UTC().Format(time.RFC3339Nano), event.Category, event.Action,
+			event.UserID, event.ActorID, event.ResourceID, event.IP, event.UserAgent, event.RequestID,
+			event.Source, event.ID, details})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to render access log: %w", err)
+	}
+	return buf.Bytes(), nil
+}
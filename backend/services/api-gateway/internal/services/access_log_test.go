@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	ctx := context.Background()
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	accessLog := NewAccessLogService(NewDatabaseService(db.DB), logger)
+
+	t.Run("records only known categories", func(t *testing.T) {
+		err := accessLog.Record(ctx, &AccessEvent{UserID: "user-1", Category: AccessActivity, Action: "login"})
+		assert.Error(t, err)
+		err = accessLog.Record(ctx, &AccessEvent{Category: AccessAuthentication, Action: "login"})
+		assert.Error(t, err)
+		assert.Empty(t, d.entries())
+
+		err = accessLog.Record(ctx, &AccessEvent{
+			UserID:   "user-1",
+			ActorID:  "admin-1",
+			Category: AccessAdminAction,
+			Action:   "admin_update_user",
+			IP:       "203.0.113.7",
+		})
+		require.NoError(t, err)
+		entries := d.entries()
+		require.Len(t, entries, 1)
+		assert.Contains(t, entries[0], "INSERT INTO auth.access_events")
+		args := d.args[0]
+		assert.Equal(t, "admin-1", args[1].Value)
+		assert.Equal(t, AccessAdminAction, args[2].Value)
+		assert.Equal(t, []byte("{}"), args[8].Value)
+	})
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	events := [][]driver.Value{
+		{"e1", "access_events", AccessAuthentication, "login", "", "", "req-1", "203.0.113.7", "curl/8", []byte(`{"remember_me":true}`), at},
+		{"d1", "user_devices", AccessAuthentication, "device_registered", "", "d1", "", "", "curl/8", []byte(`{"name":"Laptop","platform":"macOS"}`), at.Add(time.Second)},
+		{"e2", "access_events", AccessDataAccess, "admin_view_user", "admin-1", "", "req-2", "198.51.100.2", "Mozilla/5.0", []byte(`{}`), at.Add(time.Minute)},
+		{"a1", "user_activity", AccessActivity, "enhancement", "", "", "sess-1", "", "", []byte(`{"intent":"code"}`), at.Add(time.Hour)},
+	}
+	exists := true
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "SELECT EXISTS") {
+			return []string{"exists"}, [][]driver.Value{{exists}}
+		}
+		return []string{"id", "source", "category", "action", "actor_id", "resource_id", "request_id",
+			"ip_address", "user_agent", "details", "created_at"}, events
+	}
+
+	query := AccessLogQuery{From: at.Add(-time.Hour), To: at.Add(2 * time.Hour)}
+	log, err := accessLog.Compile(ctx, "user-1", query)
+	require.NoError(t, err)
+	assert.False(t, log.Truncated)
+	require.Len(t, log.Events, 4)
+	assert.Equal(t, "user-1", log.Events[2].UserID)
+	assert.Equal(t, "admin-1", log.Events[2].ActorID)
+	assert.Nil(t, log.Events[2].Details)
+	assert.Equal(t, "Laptop", log.Events[1].Details["name"])
+	assert.Equal(t, AccessActivity, log.Events[3].Category)
+
+	t.Run("renders CSV", func(t *testing.T) {
+		body, err := RenderAccessLogCSV(log)
+		require.NoError(t, err)
+		records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, []string{"timestamp", "category", "action", "user_id", "actor_id", "resource_id",
+			"ip_address", "user_agent", "request_id", "source", "event_id", "details"}, records[0])
+		assert.Equal(t, []string{"2026-03-01T12:01:00Z", AccessDataAccess, "admin_view_user", "user-1", "admin-1", "",
+			"198.51.100.2", "Mozilla/5.0", "req-2", "access_events", "e2", ""}, records[3])
+		assert.Equal(t, `{"remember_me":true}`, records[1][11])
+	})
+
+	t.Run("marks logs over the cap as truncated", func(t *testing.T) {
+		many := make([][]driver.Value, MaxAccessLogEvents+1)
+		for i := range many {
+			many[i] = events[0]
+		}
+		compiled := events
+		events = many
+		defer func() { events = compiled }()
+
+		log, err := accessLog.Compile(ctx, "user-1", query)
+		require.NoError(t, err)
+		assert.True(t, log.Truncated)
+		assert.Len(t, log.Events, MaxAccessLogEvents)
+	})
+
+	t.Run("unknown users", func(t *testing.T) {
+		exists = false
+		_, err := accessLog.Compile(ctx, "ghost", query)
+		assert.True(t, errors.Is(err, ErrAccessLogUserNotFound), "%v", err)
+	})
+}
@@ -0,0 +1,859 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Signals that two accounts likely belong to the same person
+const (
+	DuplicateSignalEmailAlias = "email_alias"         // Their emails deliver to the same mailbox
+	DuplicateSignalPayment    = "payment_fingerprint" // They pay with the same card
+	DuplicateSignalDevice     = "shared_device"       // They signed in on the same device
+)
+
+// Account merge statuses
+const (
+	AccountMergeMerged   = "merged"
+	AccountMergeReverted = "reverted"
+)
+
+// MaxDuplicateCandidates bounds one list of likely duplicates
+const MaxDuplicateCandidates = 200
+
+var (
+	// ErrInvalidAccountMerge is returned for merging an account into itself
+	// or merging an account that was already merged away
+	ErrInvalidAccountMerge = errors.New("invalid account merge")
+	// ErrAccountMergeUserNotFound is returned when either account is missing
+	ErrAccountMergeUserNotFound = errors.New("user not found")
+	// ErrAccountMergeSubscription is returned when the account to merge away
+	// still pays for a subscription, which a merge can't carry over
+	ErrAccountMergeSubscription = errors.New("the account to merge away has a live subscription; cancel it first")
+	// ErrAccountMergeNotFound is returned for an unknown merge
+	ErrAccountMergeNotFound = errors.New("account merge not found")
+	// ErrAccountMergeReverted is returned for reverting a merge twice
+	ErrAccountMergeReverted = errors.New("account merge was already reverted")
+	// ErrAccountMergeConflict is returned when the merged-away account has
+	// changed since the merge in a way reverting would undo
+	ErrAccountMergeConflict = errors.New("account merge can no longer be reverted")
+)
+
+// mergedTables are the user-owned rows a merge moves to the kept account,
+// by the column identifying each row. History is moved through the history
+// store instead, since it may not be kept in Postgres.
+var mergedTables = []struct{ table, id string }{
+	{"prompts.archived_history", "history_id"},
+	{"prompts.saved_prompts", "id"},
+	{"prompts.pins", "id"},
+}
+
+// mergedHistory is the key of moved history entries in a merge's record of
+// moved rows
+const mergedHistory = "prompts.history"
+
+// mergeHistoryPageSize is how many history entries a merge lists at a time
+const mergeHistoryPageSize = 100
+
+// DuplicateAccount is one account of a likely duplicate pair
+type DuplicateAccount struct {
+	ID          string     `json:"id"`
+	Email       string     `json:"email"`
+	Username    string     `json:"username"`
+	Tier        string     `json:"tier"`
+	IsActive    bool       `json:"is_active"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// DuplicateCandidate is a pair of accounts likely to belong to the same
+// person, with what they have in common. The older account comes first.
+type DuplicateCandidate struct {
+	Accounts []*DuplicateAccount `json:"accounts"`
+	Signals  []string            `json:"signals"`
+}
+
+// AccountMergeRequest asks to merge one account into another
+type AccountMergeRequest struct {
+	SourceID string // The account merged away
+	TargetID string // The account kept
+	AdminID  string
+	Reason   string
+	DryRun   bool // Work out what the merge would do without making it
+}
+
+// AccountMerge is a merge of one account into another
+type AccountMerge struct {
+	ID              string         `json:"id,omitempty"` // Empty on dry runs
+	SourceUserID    string         `json:"source_user_id"`
+	TargetUserID    string         `json:"target_user_id"`
+	MergedBy        string         `json:"merged_by,omitempty"`
+	Reason          string         `json:"reason,omitempty"`
+	Signals         []string       `json:"signals"`
+	Moved           map[string]int `json:"moved"`       // Rows moved, per table
+	BonusQuota      int            `json:"bonus_quota"` // Bonus quota carried to the kept account
+	Status          string         `json:"status"`
+	DryRun          bool           `json:"dry_run,omitempty"`
+	RevertedBy      string         `json:"reverted_by,omitempty"`
+	RevertedAt      *time.Time     `json:"reverted_at,omitempty"`
+	HistoryPending  bool           `json:"history_pending,omitempty"`  // Its history isn't all moved yet; ResumeHistory finishes it
+	PreferencesKept bool           `json:"preferences_kept,omitempty"` // Reverting left the kept account's since-changed preferences alone
+	CreatedAt       time.Time      `json:"created_at"`
+
+	// The accounts as they were before the merge, without credentials;
+	// only GetMerge returns them
+	SourceSnapshot json.RawMessage `json:"source_snapshot,omitempty"`
+	TargetSnapshot json.RawMessage `json:"target_snapshot,omitempty"`
+}
+
+// AccountMergeService finds likely duplicate accounts and merges them.
+// A merge moves the merged-away account's history, library and pins to the
+// kept account, folds in its preferences and bonus quota, and deactivates
+// it. Only accounts of the same organization can be merged. The
+// merged-away account is kept, with snapshots of both accounts and the IDs
+// of every moved row, so the merge can be reverted.
+//
+// History is moved through the history store one entry at a time, after the
+// merge or revert has committed, so the accounts aren't locked meanwhile.
+// Until it has all moved the merge's history is pending, and a move that
+// failed part way can be resumed.
+type AccountMergeService struct {
+	db       *DatabaseService
+	history  HistoryStore
+	accounts *AccountResolver
+	logger   *logrus.Logger
+}
+
+// NewAccountMergeService creates a new account merge service. history is
+// the store users' history is kept in; accounts is told about merged
+// accounts so their tier and quota apply immediately.
+func NewAccountMergeService(db *DatabaseService, history HistoryStore, accounts *AccountResolver, logger *logrus.Logger) *AccountMergeService {
+	return &AccountMergeService{
+		db:       db,
+		history:  history,
+		accounts: accounts,
+		logger:   logger,
+	}
+}
+
+// FindDuplicates lists pairs of accounts likely to belong to the same
+// person, those with the most signals in common first. With userID set only
+// that user's pairs are listed. Accounts already merged away are left out.
+func (s *AccountMergeService) FindDuplicates(ctx context.Context, userID string, limit int) ([]*DuplicateCandidate, error) {
+	if limit <= 0 || limit > MaxDuplicateCandidates {
+		limit = MaxDuplicateCandidates
+	}
+
+	var args sqlArgs
+	filter := ""
+	if userID != "" {
+		filter = " AND " + args.add(userID) + "::uuid IN (a_id, b_id)"
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `
+		WITH pairs AS (
+			SELECT * FROM (
+				SELECT a.id AS a_id, b.id AS b_id, 'email_alias' AS signal
+				FROM auth.users a
+				JOIN auth.users b ON auth.canonical_email(b.email) = auth.canonical_email(a.email) AND a.id < b.id
+				UNION
+				SELECT a.user_id, b.user_id, 'payment_fingerprint'
+				FROM billing.customers a
+				JOIN billing.customers b ON b.payment_fingerprint = a.payment_fingerprint AND a.user_id < b.user_id
+				UNION
+				SELECT a.user_id, b.user_id, 'shared_device'
+				FROM auth.user_devices a
+				JOIN auth.user_devices b ON b.fingerprint = a.fingerprint AND a.user_id < b.user_id
+			) signals
+			WHERE TRUE`+filter+`
+		)
+		SELECT ua.id, ua.email, ua.username, ua.tier, ua.is_active, ua.last_login_at, ua.created_at,
+			ub.id, ub.email, ub.username, ub.tier, ub.is_active, ub.last_login_at, ub.created_at,
+			array_agg(p.signal ORDER BY p.signal)
+		FROM pairs p
+		JOIN auth.users ua ON ua.id = p.a_id
+		JOIN auth.users ub ON ub.id = p.b_id
+		WHERE ua.merged_into IS NULL AND ub.merged_into IS NULL
+		GROUP BY ua.id, ub.id
+		ORDER BY count(*) DESC, GREATEST(ua.created_at, ub.created_at) DESC
+		LIMIT `+args.add(limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find duplicate accounts: %w", err)
+	}
+	defer rows.Close()
+
+	candidates := []*DuplicateCandidate{}
+	for rows.Next() {
+		a, b := &DuplicateAccount{}, &DuplicateAccount{}
+		var aLogin, bLogin sql.NullTime
+		candidate := &DuplicateCandidate{}
+		if err := rows.Scan(&a.ID, &a.Email, &a.Username, &a.Tier, &a.IsActive, &aLogin, &a.CreatedAt,
+			&b.ID, &b.Email, &b.Username, &b.Tier, &b.IsActive, &bLogin, &b.CreatedAt,
+			pq.Array(&candidate.Signals)); err != nil {
+			return nil, fmt.Errorf("failed to scan duplicate accounts: %w", err)
+		}
+		if aLogin.Valid {
+			a.LastLoginAt = &aLogin.Time
+		}
+		if bLogin.Valid {
+			b.LastLoginAt = &bLogin.Time
+		}
+		if b.CreatedAt.Before(a.CreatedAt) {
+			a, b = b, a
+		}
+		candidate.Accounts = []*DuplicateAccount{a, b}
+		candidates = append(candidates, candidate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find duplicate accounts: %w", err)
+	}
+	return candidates, nil
+}
+
+// mergedAccount is an account as a merge locked it
+type mergedAccount struct {
+	mergedInto sql.NullString
+	orgID      string
+	bonusQuota int
+	snapshot   []byte
+}
+
+// Merge merges req.SourceID into req.TargetID in one transaction, then
+// moves the merged-away account's history. A merge whose history fails to
+// move is returned with HistoryPending set. A dry run makes the same
+// database changes and rolls them back, and only counts the history it
+// would move. The merge is recorded with snapshots of both accounts and in
+// both users' access logs.
+func (s *AccountMergeService) Merge(ctx context.Context, req AccountMergeRequest) (*AccountMerge, error) {
+	if req.SourceID == req.TargetID {
+		return nil, fmt.Errorf("%w: an account can't be merged into itself", ErrInvalidAccountMerge)
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	accounts, err := lockMergedAccounts(ctx, tx, req.SourceID, req.TargetID, true)
+	if err != nil {
+		return nil, err
+	}
+	source, target := accounts[req.SourceID], accounts[req.TargetID]
+	if source == nil || target == nil {
+		return nil, ErrAccountMergeUserNotFound
+	}
+	if source.mergedInto.Valid || target.mergedInto.Valid {
+		return nil, fmt.Errorf("%w: the account was already merged away", ErrInvalidAccountMerge)
+	}
+	// An organization's history stays in its region and under its keys
+	if source.orgID != target.orgID {
+		return nil, fmt.Errorf("%w: the accounts belong to different organizations", ErrInvalidAccountMerge)
+	}
+
+	// Subscriptions are Stripe's to change; a merge would orphan one
+	var subscribed bool
+	if err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM billing.customers
+			WHERE user_id = $1 AND subscription_status IN ('active', 'trialing', 'past_due', 'incomplete')
+		)`, req.SourceID).Scan(&subscribed); err != nil {
+		return nil, fmt.Errorf("failed to check subscription: %w", err)
+	}
+	if subscribed {
+		return nil, ErrAccountMergeSubscription
+	}
+
+	merge := &AccountMerge{
+		SourceUserID: req.SourceID,
+		TargetUserID: req.TargetID,
+		MergedBy:     req.AdminID,
+		Reason:       req.Reason,
+		Moved:        make(map[string]int, len(mergedTables)+1),
+		BonusQuota:   source.bonusQuota,
+		Status:       AccountMergeMerged,
+		DryRun:       req.DryRun,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if merge.Signals, err = duplicateSignals(ctx, tx, req.SourceID, req.TargetID); err != nil {
+		return nil, err
+	}
+
+	moved := make(map[string][]string, len(mergedTables)+1)
+	for _, t := range mergedTables {
+		ids, err := moveRows(ctx, tx, t.table, t.id, req.SourceID, req.TargetID, nil)
+		if err != nil {
+			return nil, err
+		}
+		moved[t.table] = ids
+		merge.Moved[t.table] = len(ids)
+	}
+
+	// The kept account's preferences win; the merged-away account's fill in
+	// the ones it doesn't have
+	var preferencesVersion int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE auth.users t SET
+			preferences = (s.preferences - $3::text[]) || t.preferences,
+			bonus_quota = t.bonus_quota + s.bonus_quota,
+			preferences_version = t.preferences_version + 1,
+			profile_version = t.profile_version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		FROM auth.users s
+		WHERE t.id = $1 AND s.id = $2
+		RETURNING t.preferences_version`, req.TargetID, req.SourceID, pq.Array(privatePreferences)).Scan(&preferencesVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge preferences: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET is_active = false, merged_into = $2, bonus_quota = 0, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, req.SourceID, req.TargetID); err != nil {
+		return nil, fmt.Errorf("failed to deactivate merged account: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.user_devices SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`, req.SourceID); err != nil {
+		return nil, fmt.Errorf("failed to sign out merged account: %w", err)
+	}
+
+	if req.DryRun {
+		// The accounts are unlocked before their history is listed
+		tx.Rollback()
+		history, err := s.userHistory(WithRequestContext(ctx, nil), req.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		merge.Moved[mergedHistory] = len(history)
+		return merge, nil
+	}
+
+	moved[mergedHistory] = []string{}
+	movedJSON, err := json.Marshal(moved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode moved rows: %w", err)
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO auth.account_merges (
+			source_user_id, target_user_id, merged_by, reason, signals,
+			source_snapshot, target_snapshot, moved, target_preferences_version, history_pending
+		) VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7, $8, $9, true)
+		RETURNING id, created_at`,
+		req.SourceID, req.TargetID, req.AdminID, req.Reason, pq.Array(merge.Signals),
+		source.snapshot, target.snapshot, movedJSON, preferencesVersion,
+	).Scan(&merge.ID, &merge.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record account merge: %w", err)
+	}
+
+	details := map[string]interface{}{
+		"source_user_id": req.SourceID,
+		"target_user_id": req.TargetID,
+		"moved":          merge.Moved,
+		"bonus_quota":    merge.BonusQuota,
+		"reason":         req.Reason,
+	}
+	for _, userID := range []string{req.SourceID, req.TargetID} {
+		if err := recordMergeEvent(ctx, tx, userID, req.AdminID, "account_merged", merge.ID, details); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit account merge: %w", err)
+	}
+	s.forget(req.SourceID, req.TargetID)
+
+	history, err := s.moveMergedHistory(ctx, merge.ID, AccountMergeMerged, req.SourceID, req.TargetID, nil)
+	merge.Moved[mergedHistory] = len(history)
+	merge.HistoryPending = err != nil
+	entry := s.logger.WithFields(logrus.Fields{
+		"merge_id":       merge.ID,
+		"source_user_id": req.SourceID,
+		"target_user_id": req.TargetID,
+		"admin_id":       req.AdminID,
+		"moved":          merge.Moved,
+	})
+	if err != nil {
+		entry.WithError(err).Error("Accounts merged but their history wasn't all moved")
+	} else {
+		entry.Info("Accounts merged")
+	}
+	return merge, nil
+}
+
+// Revert undoes a merge: the moved rows and history go back, the
+// merged-away account is reactivated with its bonus quota, and the kept
+// account gets back its own preferences unless they were changed since.
+// Devices signed out by the merge stay signed out. History goes back after
+// the revert commits, leaving it pending if it fails to.
+func (s *AccountMergeService) Revert(ctx context.Context, mergeID, adminID string) (*AccountMerge, error) {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sourceID, targetID, status string
+	var movedJSON, targetPreferences []byte
+	var sourceActive bool
+	var bonusQuota int
+	var preferencesVersion int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT source_user_id, target_user_id, status, moved,
+			COALESCE(target_snapshot->'preferences', '{}'::jsonb),
+			COALESCE((source_snapshot->>'is_active')::boolean, true),
+			COALESCE((source_snapshot->>'bonus_quota')::int, 0),
+			target_preferences_version
+		FROM auth.account_merges
+		WHERE id = $1
+		FOR UPDATE`, mergeID).Scan(&sourceID, &targetID, &status, &movedJSON, &targetPreferences,
+		&sourceActive, &bonusQuota, &preferencesVersion)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountMergeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account merge: %w", err)
+	}
+	if status == AccountMergeReverted {
+		return nil, ErrAccountMergeReverted
+	}
+
+	accounts, err := lockMergedAccounts(ctx, tx, sourceID, targetID, false)
+	if err != nil {
+		return nil, err
+	}
+	source, target := accounts[sourceID], accounts[targetID]
+	if source == nil || target == nil || source.mergedInto.String != targetID {
+		return nil, ErrAccountMergeConflict
+	}
+
+	var moved map[string][]string
+	if err := json.Unmarshal(movedJSON, &moved); err != nil {
+		return nil, fmt.Errorf("failed to decode moved rows: %w", err)
+	}
+	for _, t := range mergedTables {
+		if len(moved[t.table]) == 0 {
+			continue
+		}
+		if _, err := moveRows(ctx, tx, t.table, t.id, targetID, sourceID, moved[t.table]); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET is_active = $2, merged_into = NULL, bonus_quota = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, sourceID, sourceActive, bonusQuota); err != nil {
+		return nil, fmt.Errorf("failed to reactivate merged account: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET bonus_quota = GREATEST(bonus_quota - $2, 0), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, targetID, bonusQuota); err != nil {
+		return nil, fmt.Errorf("failed to restore bonus quota: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE auth.users SET
+			preferences = $2,
+			preferences_version = preferences_version + 1,
+			profile_version = profile_version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND preferences_version = $3`, targetID, targetPreferences, preferencesVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore preferences: %w", err)
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore preferences: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.account_merges
+		SET status = $2, reverted_by = NULLIF($3, '')::uuid, reverted_at = CURRENT_TIMESTAMP, history_pending = true
+		WHERE id = $1`, mergeID, AccountMergeReverted, adminID); err != nil {
+		return nil, fmt.Errorf("failed to record account merge revert: %w", err)
+	}
+	details := map[string]interface{}{
+		"source_user_id":   sourceID,
+		"target_user_id":   targetID,
+		"preferences_kept": restored == 0,
+	}
+	for _, userID := range []string{sourceID, targetID} {
+		if err := recordMergeEvent(ctx, tx, userID, adminID, "account_merge_reverted", mergeID, details); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit account merge revert: %w", err)
+	}
+	s.forget(sourceID, targetID)
+
+	_, err = s.moveMergedHistory(ctx, mergeID, AccountMergeReverted, sourceID, targetID, moved[mergedHistory])
+	entry := s.logger.WithFields(logrus.Fields{
+		"merge_id":       mergeID,
+		"source_user_id": sourceID,
+		"target_user_id": targetID,
+		"admin_id":       adminID,
+	})
+	if err != nil {
+		entry.WithError(err).Error("Account merge reverted but its history wasn't all moved back")
+	} else {
+		entry.Info("Account merge reverted")
+	}
+
+	merge, err := s.GetMerge(ctx, mergeID)
+	if err != nil {
+		return nil, err
+	}
+	merge.PreferencesKept = restored == 0
+	return merge, nil
+}
+
+// ResumeHistory finishes moving the history of a merge whose history is
+// pending: to the kept account, or back to the merged-away one for a
+// reverted merge. Other merges are returned as they are.
+func (s *AccountMergeService) ResumeHistory(ctx context.Context, mergeID string) (*AccountMerge, error) {
+	var sourceID, targetID, status string
+	var pending bool
+	var movedJSON []byte
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT source_user_id, target_user_id, status, history_pending, moved
+		FROM auth.account_merges
+		WHERE id = $1`, mergeID).Scan(&sourceID, &targetID, &status, &pending, &movedJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountMergeNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account merge: %w", err)
+	}
+
+	if pending {
+		var moved map[string][]string
+		if err := json.Unmarshal(movedJSON, &moved); err != nil {
+			return nil, fmt.Errorf("failed to decode moved rows: %w", err)
+		}
+		if _, err := s.moveMergedHistory(ctx, mergeID, status, sourceID, targetID, moved[mergedHistory]); err != nil {
+			return nil, err
+		}
+		s.logger.WithField("merge_id", mergeID).Info("Account merge history moved")
+	}
+	return s.GetMerge(ctx, mergeID)
+}
+
+// accountMergeColumns are read into an AccountMerge by scanAccountMerge
+const accountMergeColumns = `id, source_user_id, target_user_id, COALESCE(merged_by::text, ''), reason, signals, moved,
+	COALESCE((source_snapshot->>'bonus_quota')::int, 0), status, history_pending, COALESCE(reverted_by::text, ''),
+	reverted_at, created_at`
+
+// ListMerges returns merges newest first, only those involving userID when
+// it is set
+func (s *AccountMergeService) ListMerges(ctx context.Context, userID string, limit int) ([]*AccountMerge, error) {
+	if limit <= 0 || limit > MaxDuplicateCandidates {
+		limit = MaxDuplicateCandidates
+	}
+	var args sqlArgs
+	where := "TRUE"
+	if userID != "" {
+		id := args.add(userID)
+		where = "(source_user_id = " + id + " OR target_user_id = " + id + ")"
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+accountMergeColumns+`
+		FROM auth.account_merges
+		WHERE `+where+`
+		ORDER BY created_at DESC
+		LIMIT `+args.add(limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list account merges: %w", err)
+	}
+	defer rows.Close()
+
+	merges := []*AccountMerge{}
+	for rows.Next() {
+		merge, err := scanAccountMerge(rows)
+		if err != nil {
+			return nil, err
+		}
+		merges = append(merges, merge)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list account merges: %w", err)
+	}
+	return merges, nil
+}
+
+// GetMerge returns a merge with the snapshots of both accounts
+func (s *AccountMergeService) GetMerge(ctx context.Context, id string) (*AccountMerge, error) {
+	var sourceSnapshot, targetSnapshot []byte
+	row := s.db.DB.QueryRowContext(ctx, `
+		SELECT `+accountMergeColumns+`, source_snapshot, target_snapshot
+		FROM auth.account_merges
+		WHERE id = $1`, id)
+	merge, err := scanAccountMerge(row, &sourceSnapshot, &targetSnapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAccountMergeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	merge.SourceSnapshot, merge.TargetSnapshot = sourceSnapshot, targetSnapshot
+	return merge, nil
+}
+
+func scanAccountMerge(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*AccountMerge, error) {
+	merge := &AccountMerge{}
+	var movedJSON []byte
+	var revertedAt sql.NullTime
+	dest := append([]interface{}{&merge.ID, &merge.SourceUserID, &merge.TargetUserID, &merge.MergedBy,
+		&merge.Reason, pq.Array(&merge.Signals), &movedJSON, &merge.BonusQuota, &merge.Status,
+		&merge.HistoryPending, &merge.RevertedBy, &revertedAt, &merge.CreatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan account merge: %w", err)
+	}
+	if revertedAt.Valid {
+		merge.RevertedAt = &revertedAt.Time
+	}
+	if merge.Signals == nil {
+		merge.Signals = []string{}
+	}
+	var moved map[string][]string
+	if err := json.Unmarshal(movedJSON, &moved); err != nil {
+		return nil, fmt.Errorf("failed to decode moved rows: %w", err)
+	}
+	merge.Moved = make(map[string]int, len(moved))
+	for table, ids := range moved {
+		merge.Moved[table] = len(ids)
+	}
+	return merge, nil
+}
+
+// lockMergedAccounts locks both accounts of a merge, in ID order so
+// concurrent merges can't deadlock. With snapshot set each is read as the
+// merge backs it up, without credentials.
+func lockMergedAccounts(ctx context.Context, tx *sql.Tx, sourceID, targetID string, snapshot bool) (map[string]*mergedAccount, error) {
+	snapshotColumn := "NULL::jsonb"
+	if snapshot {
+		snapshotColumn = "to_jsonb(u) - 'password_hash' - 'email_verify_token' - 'password_reset_token'"
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, merged_into, COALESCE(metadata->>'org_id', ''), bonus_quota, `+snapshotColumn+`
+		FROM auth.users u
+		WHERE id IN ($1, $2)
+		ORDER BY id
+		FOR UPDATE`, sourceID, targetID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := make(map[string]*mergedAccount, 2)
+	for rows.Next() {
+		var id string
+		account := &mergedAccount{}
+		if err := rows.Scan(&id, &account.mergedInto, &account.orgID, &account.bonusQuota, &account.snapshot); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		accounts[id] = account
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to lock accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// duplicateSignals returns what two accounts have in common
+func duplicateSignals(ctx context.Context, tx *sql.Tx, a, b string) ([]string, error) {
+	var emailAlias, payment, device bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			auth.canonical_email(ua.email) = auth.canonical_email(ub.email),
+			EXISTS (
+				SELECT 1 FROM billing.customers ca
+				JOIN billing.customers cb ON cb.payment_fingerprint = ca.payment_fingerprint
+				WHERE ca.user_id = ua.id AND cb.user_id = ub.id
+			),
+			EXISTS (
+				SELECT 1 FROM auth.user_devices da
+				JOIN auth.user_devices db ON db.fingerprint = da.fingerprint
+				WHERE da.user_id = ua.id AND db.user_id = ub.id
+			)
+		FROM auth.users ua, auth.users ub
+		WHERE ua.id = $1 AND ub.id = $2`, a, b).Scan(&emailAlias, &payment, &device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare accounts: %w", err)
+	}
+	signals := []string{}
+	for signal, found := range map[string]bool{DuplicateSignalEmailAlias: emailAlias, DuplicateSignalPayment: payment, DuplicateSignalDevice: device} {
+		if found {
+			signals = append(signals, signal)
+		}
+	}
+	sort.Strings(signals)
+	return signals, nil
+}
+
+// moveRows gives from's rows of table to to, only those with the given IDs
+// when ids is set, returning the IDs moved
+func moveRows(ctx context.Context, tx *sql.Tx, table, idColumn, from, to string, ids []string) ([]string, error) {
+	var args sqlArgs
+	query := "UPDATE " + table + " SET user_id = " + args.add(to) + " WHERE user_id = " + args.add(from)
+	if ids != nil {
+		query += " AND " + idColumn + " = ANY(" + args.add(pq.Array(ids)) + "::uuid[])"
+	}
+	rows, err := tx.QueryContext(ctx, query+" RETURNING "+idColumn+"::text", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	moved := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to move %s: %w", table, err)
+		}
+		moved = append(moved, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to move %s: %w", table, err)
+	}
+	return moved, nil
+}
+
+// userHistory returns the IDs of all of a user's history entries
+func (s *AccountMergeService) userHistory(ctx context.Context, userID string) ([]string, error) {
+	ids := []string{}
+	for page := 1; ; page++ {
+		entries, total, err := s.history.GetUserPromptHistoryWithFilters(ctx, userID, models.PaginationRequest{
+			Page:          page,
+			Limit:         mergeHistoryPageSize,
+			SortBy:        "created_at",
+			SortDirection: "ASC",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list history: %w", err)
+		}
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		if len(entries) == 0 || int64(len(ids)) >= total {
+			return ids, nil
+		}
+	}
+}
+
+// moveHistory gives from's history entries with the given IDs to to,
+// skipping those deleted or no longer from's. It returns the IDs it moved,
+// also when it fails part way.
+func (s *AccountMergeService) moveHistory(ctx context.Context, from, to string, ids []string) ([]string, error) {
+	moved := make([]string, 0, len(ids))
+	for _, id := range ids {
+		entry, err := s.history.GetPromptHistory(ctx, id)
+		if errors.Is(err, ErrPromptHistoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("failed to get prompt history: %w", err)
+		}
+		if entry.UserID.String != from {
+			continue
+		}
+		err = s.history.UpdatePromptHistory(ctx, id, HistoryUpdate{UserID: to})
+		if errors.Is(err, ErrPromptHistoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("failed to move prompt history: %w", err)
+		}
+		moved = append(moved, id)
+	}
+	return moved, nil
+}
+
+// moveMergedHistory moves a committed merge's history: the merged-away
+// account's to the kept account, or for a reverted merge the history it
+// moved back. The entries of a merge are recorded before they are moved, so
+// a revert can move back whatever a failed move got to. The merge's history
+// stops being pending once it has all moved. It returns the IDs it moved.
+func (s *AccountMergeService) moveMergedHistory(ctx context.Context, mergeID, status, sourceID, targetID string, recorded []string) ([]string, error) {
+	// History is moved as the gateway, in whichever region it's kept, rather
+	// than as the admin making the merge
+	historyCtx := WithRequestContext(ctx, nil)
+	var moved []string
+	var err error
+	if status == AccountMergeReverted {
+		moved, err = s.moveHistory(historyCtx, targetID, sourceID, recorded)
+	} else {
+		var ids []string
+		if ids, err = s.userHistory(historyCtx, sourceID); err != nil {
+			return nil, err
+		}
+		if err := s.recordHistory(ctx, mergeID, ids, true); err != nil {
+			return nil, err
+		}
+		moved, err = s.moveHistory(historyCtx, sourceID, targetID, ids)
+	}
+	if err != nil {
+		return moved, err
+	}
+	return moved, s.recordHistory(ctx, mergeID, nil, false)
+}
+
+// recordHistory adds history entries to those a merge moved and sets
+// whether its history is pending
+func (s *AccountMergeService) recordHistory(ctx context.Context, mergeID string, ids []string, pending bool) error {
+	if ids == nil {
+		ids = []string{}
+	}
+	if _, err := s.db.DB.ExecContext(context.WithoutCancel(ctx), `
+		UPDATE auth.account_merges SET
+			moved = jsonb_set(moved, '{`+mergedHistory+`}', to_jsonb(ARRAY(
+				SELECT jsonb_array_elements_text(COALESCE(moved->'`+mergedHistory+`', '[]'::jsonb))
+				UNION
+				SELECT unnest($2::text[])
+			))),
+			history_pending = $3
+		WHERE id = $1`, mergeID, pq.Array(ids), pending); err != nil {
+		return fmt.Errorf("failed to record moved history: %w", err)
+	}
+	return nil
+}
+
+// recordMergeEvent puts a merge in a user's access log, in the merge's own
+// transaction so the two can't disagree
+func recordMergeEvent(ctx context.Context, tx *sql.Tx, userID, adminID, action, mergeID string, details map[string]interface{}) error {
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode access event details: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO auth.access_events (user_id, actor_id, category, action, resource_id, details)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6)`,
+		userID, adminID, AccessAdminAction, action, mergeID, detailsJSON); err != nil {
+		return fmt.Errorf("failed to record access event: %w", err)
+	}
+	return nil
+}
+
+func (s *AccountMergeService) forget(userIDs ...string) {
+	if s.accounts == nil {
+		return
+	}
+	for _, id := range userIDs {
+		s.accounts.Forget(id)
+	}
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mergeSourceID = "11111111-1111-1111-1111-111111111111"
+	mergeTargetID = "22222222-2222-2222-2222-222222222222"
+	mergeID       = "33333333-3333-3333-3333-333333333333"
+)
+
+// newTestAccountMergeService returns a merge service whose history store
+// holds h1 and h2 of mergeSourceID and h3 of another user
+func newTestAccountMergeService(t *testing.T) (*AccountMergeService, *recordingDriver, *memoryHistory) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	history := &memoryHistory{entries: map[string]models.PromptHistory{}}
+	for id, owner := range map[string]string{"h1": mergeSourceID, "h2": mergeSourceID, "h3": "someone-else"} {
+		history.entries[id] = models.PromptHistory{ID: id, UserID: sql.NullString{String: owner, Valid: true}}
+	}
+	return NewAccountMergeService(NewDatabaseService(db.DB), history, nil, logger), d, history
+}
+
+// historyOwners returns the owner of each entry of history
+func historyOwners(history *memoryHistory) map[string]string {
+	owners := make(map[string]string, len(history.entries))
+	for id, entry := range history.entries {
+		owners[id] = entry.UserID.String
+	}
+	return owners
+}
+
+// mergeRows answers the queries of a merge of mergeSourceID into
+// mergeTargetID; subscribed makes the source a paying customer, sourceOrg
+// puts it in an organization
+func mergeRows(subscribed bool, sourceMergedInto interface{}, sourceOrg string) func(string) ([]string, [][]driver.Value) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	return func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "FOR UPDATE") && strings.Contains(query, "FROM auth.users u"):
+			return []string{"id", "merged_into", "org_id", "bonus_quota", "snapshot"}, [][]driver.Value{
+				{mergeSourceID, sourceMergedInto, sourceOrg, int64(40), []byte(`{"id":"` + mergeSourceID + `","is_active":true,"bonus_quota":40}`)},
+				{mergeTargetID, nil, "", int64(10), []byte(`{"id":"` + mergeTargetID + `","preferences":{"theme":"dark"}}`)},
+			}
+		case strings.Contains(query, "FROM billing.customers") && strings.Contains(query, "subscription_status"):
+			return []string{"exists"}, [][]driver.Value{{subscribed}}
+		case strings.Contains(query, "auth.canonical_email(ua.email)"):
+			return []string{"email_alias", "payment", "device"}, [][]driver.Value{{true, false, true}}
+		case strings.HasPrefix(strings.TrimSpace(query), "UPDATE prompts."):
+			return []string{"id"}, nil
+		case strings.Contains(query, "RETURNING t.preferences_version"):
+			return []string{"preferences_version"}, [][]driver.Value{{int64(7)}}
+		case strings.Contains(query, "INSERT INTO auth.account_merges"):
+			return []string{"id", "created_at"}, [][]driver.Value{{mergeID, created}}
+		case strings.Contains(query, "status, history_pending, moved"):
+			return []string{"source_user_id", "target_user_id", "status", "history_pending", "moved"}, [][]driver.Value{
+				{mergeSourceID, mergeTargetID, AccountMergeMerged, true, []byte(`{"prompts.history":["h1","h2"]}`)},
+			}
+		case strings.Contains(query, "FROM auth.account_merges") && strings.Contains(query, "FOR UPDATE"):
+			return []string{"source_user_id", "target_user_id", "status", "moved", "preferences", "is_active", "bonus_quota", "version"}, [][]driver.Value{
+				{mergeSourceID, mergeTargetID, AccountMergeMerged, []byte(`{"prompts.history":["h1","h2"],"prompts.pins":[]}`), []byte(`{"theme":"dark"}`), true, int64(40), int64(7)},
+			}
+		case strings.Contains(query, "FROM auth.account_merges"):
+			return []string{"id", "source_user_id", "target_user_id", "merged_by", "reason", "signals", "moved", "bonus_quota",
+				"status", "history_pending", "reverted_by", "reverted_at", "created_at", "source_snapshot", "target_snapshot"}, [][]driver.Value{
+				{mergeID, mergeSourceID, mergeTargetID, "", "", "{email_alias}", []byte(`{"prompts.history":["h1","h2"]}`), int64(40),
+					AccountMergeReverted, false, "", created, created, []byte(`{}`), []byte(`{}`)},
+			}
+		}
+		return nil, nil
+	}
+}
+
+// historyRecords returns the arguments of each update recording a merge's
+// moved history, and the position of the merge's commit among d's entries
+func historyRecords(d *recordingDriver) (records [][]driver.NamedValue, commit int) {
+	commit = -1
+	for i, entry := range d.entries() {
+		switch {
+		case entry == "COMMIT" && commit < 0:
+			commit = i
+		case strings.HasPrefix(entry, "UPDATE auth.account_merges SET moved = jsonb_set"):
+			if commit < 0 {
+				return nil, -1
+			}
+			records = append(records, d.args[i])
+		}
+	}
+	return records, commit
+}
+
+func TestAccountMerge(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("merges and records the merge", func(t *testing.T) {
+		merges, d, history := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, nil, "")
+
+		merge, err := merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID, Reason: "same person"})
+		require.NoError(t, err)
+		assert.Equal(t, mergeID, merge.ID)
+		assert.Equal(t, []string{DuplicateSignalEmailAlias, DuplicateSignalDevice}, merge.Signals)
+		assert.Equal(t, 2, merge.Moved["prompts.history"])
+		assert.Equal(t, 0, merge.Moved["prompts.pins"])
+		assert.Equal(t, 40, merge.BonusQuota)
+		assert.Equal(t, map[string]string{"h1": mergeTargetID, "h2": mergeTargetID, "h3": "someone-else"}, historyOwners(history))
+
+		entries := d.entries()
+		records, commit := historyRecords(d)
+		require.NotEqual(t, -1, commit, "history is moved after the merge commits")
+		require.Len(t, records, 2)
+		assert.Equal(t, true, records[0][2].Value, "the entries are recorded before they move")
+		assert.Contains(t, records[0][1].Value, "h1")
+		assert.Equal(t, false, records[1][2].Value)
+		assert.False(t, merge.HistoryPending)
+
+		var insertArgs []driver.NamedValue
+		var events int
+		for i, entry := range entries {
+			switch {
+			case strings.HasPrefix(entry, "UPDATE auth.users t SET preferences = (s.preferences - $3::text[]) || t.preferences"):
+				assert.Contains(t, entry, "bonus_quota = t.bonus_quota + s.bonus_quota")
+			case strings.HasPrefix(entry, "UPDATE auth.users SET is_active = false, merged_into = $2"):
+				assert.Equal(t, mergeSourceID, d.args[i][0].Value)
+			case strings.HasPrefix(entry, "INSERT INTO auth.account_merges"):
+				insertArgs = d.args[i]
+			case strings.HasPrefix(entry, "INSERT INTO auth.access_events"):
+				events++
+				assert.Equal(t, "account_merged", d.args[i][3].Value)
+				assert.Equal(t, mergeID, d.args[i][4].Value)
+			}
+		}
+		require.NotNil(t, insertArgs, "the merge is recorded")
+		assert.Contains(t, string(insertArgs[5].Value.([]byte)), `"bonus_quota":40`, "the merged-away account is backed up")
+		var moved map[string][]string
+		require.NoError(t, json.Unmarshal(insertArgs[7].Value.([]byte), &moved))
+		assert.Equal(t, []string{}, moved["prompts.history"])
+		for _, table := range []string{"prompts.archived_history", "prompts.saved_prompts", "prompts.pins"} {
+			assert.Equal(t, []string{}, moved[table], table)
+		}
+		assert.Equal(t, int64(7), insertArgs[8].Value)
+		assert.Equal(t, 2, events, "both users' access logs record the merge")
+	})
+
+	t.Run("dry run rolls back", func(t *testing.T) {
+		merges, d, history := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, nil, "")
+
+		merge, err := merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID, DryRun: true})
+		require.NoError(t, err)
+		assert.True(t, merge.DryRun)
+		assert.Empty(t, merge.ID)
+		assert.Equal(t, 2, merge.Moved["prompts.history"])
+		assert.Equal(t, mergeSourceID, historyOwners(history)["h1"], "history is only counted")
+
+		entries := d.entries()
+		assert.Equal(t, "ROLLBACK", entries[len(entries)-1])
+		for _, entry := range entries {
+			assert.NotContains(t, entry, "INSERT INTO auth.account_merges")
+			assert.NotContains(t, entry, "INSERT INTO auth.access_events")
+		}
+	})
+
+	t.Run("history stays when the merge fails", func(t *testing.T) {
+		merges, d, history := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, nil, "")
+		d.fail = func(query string) error {
+			if strings.Contains(query, "INSERT INTO auth.access_events") {
+				return errors.New("connection reset")
+			}
+			return nil
+		}
+
+		_, err := merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID})
+		require.Error(t, err)
+		assert.Equal(t, map[string]string{"h1": mergeSourceID, "h2": mergeSourceID, "h3": "someone-else"}, historyOwners(history))
+	})
+
+	t.Run("a failed history move is left pending and resumed", func(t *testing.T) {
+		merges, d, history := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, nil, "")
+		merges.history = failingHistory{history}
+
+		merge, err := merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID})
+		require.NoError(t, err, "the accounts are merged")
+		assert.True(t, merge.HistoryPending)
+		assert.Equal(t, mergeSourceID, historyOwners(history)["h1"])
+		records, _ := historyRecords(d)
+		require.Len(t, records, 1)
+		assert.Equal(t, true, records[0][2].Value)
+
+		merges.history = history
+		_, err = merges.ResumeHistory(ctx, mergeID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"h1": mergeTargetID, "h2": mergeTargetID, "h3": "someone-else"}, historyOwners(history))
+		records, _ = historyRecords(d)
+		assert.Equal(t, false, records[len(records)-1][2].Value, "the history is no longer pending")
+	})
+
+	t.Run("refuses", func(t *testing.T) {
+		merges, d, _ := newTestAccountMergeService(t)
+
+		_, err := merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeSourceID})
+		assert.ErrorIs(t, err, ErrInvalidAccountMerge)
+
+		d.rows = mergeRows(true, nil, "")
+		_, err = merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID})
+		assert.ErrorIs(t, err, ErrAccountMergeSubscription)
+
+		d.rows = mergeRows(false, mergeTargetID, "")
+		_, err = merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID})
+		assert.ErrorIs(t, err, ErrInvalidAccountMerge)
+
+		d.rows = mergeRows(false, nil, "acme")
+		_, err = merges.Merge(ctx, AccountMergeRequest{SourceID: mergeSourceID, TargetID: mergeTargetID})
+		assert.ErrorIs(t, err, ErrInvalidAccountMerge, "accounts of different organizations")
+		for _, entry := range d.entries() {
+			assert.NotContains(t, entry, "UPDATE prompts.")
+		}
+	})
+
+	t.Run("reverts", func(t *testing.T) {
+		merges, d, history := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, mergeTargetID, "")
+		for _, id := range []string{"h1", "h2"} {
+			entry := history.entries[id]
+			entry.UserID.String = mergeTargetID
+			history.entries[id] = entry
+		}
+
+		merge, err := merges.Revert(ctx, mergeID, "")
+		require.NoError(t, err)
+		assert.Equal(t, AccountMergeReverted, merge.Status)
+		assert.False(t, merge.PreferencesKept)
+
+		assert.Equal(t, map[string]string{"h1": mergeSourceID, "h2": mergeSourceID, "h3": "someone-else"}, historyOwners(history),
+			"moved history goes back")
+		records, commit := historyRecords(d)
+		require.NotEqual(t, -1, commit, "history goes back after the revert commits")
+		require.Len(t, records, 1)
+		assert.Equal(t, false, records[0][2].Value)
+		var restored bool
+		for i, entry := range d.entries() {
+			switch {
+			case strings.HasPrefix(entry, "UPDATE prompts.pins"):
+				t.Error("tables nothing was moved from are left alone")
+			case strings.HasPrefix(entry, "UPDATE auth.users SET is_active = $2, merged_into = NULL, bonus_quota = $3"):
+				restored = true
+				assert.Equal(t, true, d.args[i][1].Value)
+				assert.Equal(t, int64(40), d.args[i][2].Value)
+			}
+		}
+		assert.True(t, restored, "the merged-away account is reactivated")
+	})
+
+	t.Run("revert conflicts once the account moved on", func(t *testing.T) {
+		merges, d, _ := newTestAccountMergeService(t)
+		d.rows = mergeRows(false, nil, "")
+
+		_, err := merges.Revert(ctx, mergeID, "")
+		assert.ErrorIs(t, err, ErrAccountMergeConflict)
+	})
+}
+
+func TestFindDuplicates(t *testing.T) {
+	merges, d, _ := newTestAccountMergeService(t)
+	older := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return make([]string, 15), [][]driver.Value{
+			{mergeTargetID, "j.doe+promo@gmail.com", "jdoe2", TierFree, true, nil, newer,
+				mergeSourceID, "jdoe@gmail.com", "jdoe", TierPro, true, older, older,
+				"{email_alias,shared_device}"},
+		}
+	}
+
+	candidates, err := merges.FindDuplicates(context.Background(), mergeSourceID, 10)
+	require.NoError(t, err)
+
+	entries := d.entries()
+	require.Len(t, entries, 1)
+	assert.Contains(t, entries[0], "WHERE TRUE AND $1::uuid IN (a_id, b_id)")
+	assert.Equal(t, mergeSourceID, d.args[0][0].Value)
+
+	require.Len(t, candidates, 1)
+	assert.Equal(t, []string{DuplicateSignalEmailAlias, DuplicateSignalDevice}, candidates[0].Signals)
+	require.Len(t, candidates[0].Accounts, 2)
+	assert.Equal(t, mergeSourceID, candidates[0].Accounts[0].ID, "the older account comes first")
+	assert.NotNil(t, candidates[0].Accounts[0].LastLoginAt)
+}
@@ -0,0 +1,418 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// Metrics alert rules can watch
+const (
+	AlertMetricErrorRate          = "error_rate"               // Share of requests answered with a 5xx
+	AlertMetricP95Latency         = "p95_latency_ms"           // 95th percentile request latency
+	AlertMetricClassifierFallback = "classifier_fallback_rate" // Share of classifications not answered by the primary model
+)
+
+// Alert statuses
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+const (
+	defaultAlertRules   = "error_rate>0.05,p95_latency_ms>2000,classifier_fallback_rate>0.3"
+	alertNotifyTimeout  = 30 * time.Second
+	recentAlertsToKeep  = 50
+	maxAlertRuleWindow  = trafficBuckets * trafficBucketWidth
+	alertWebhookTimeout = 10 * time.Second
+)
+
+var alertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_alerts_fired_total",
+	Help: "Number of times a built-in alert rule started firing",
+}, []string{"metric"})
+
+// AlertRule fires while a metric over the trailing window is above the
+// threshold
+type AlertRule struct {
+	Metric    string
+	Threshold float64
+	Window    time.Duration
+}
+
+// Name identifies the rule, in the form it is configured in
+func (r AlertRule) Name() string {
+	return fmt.Sprintf("%s>%s@%s", r.Metric, strconv.FormatFloat(r.Threshold, 'f', -1, 64), r.Window)
+}
+
+// ParseAlertRules parses comma-separated rules of the form
+// metric>threshold[@window], e.g. "error_rate>0.05@5m". Rules without a
+// window use defaultWindow.
+func ParseAlertRules(spec string, defaultWindow time.Duration) ([]AlertRule, error) {
+	var rules []AlertRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		rule := AlertRule{Window: defaultWindow}
+		if condition, window, ok := strings.Cut(part, "@"); ok {
+			d, err := time.ParseDuration(window)
+			if err != nil || d < trafficBucketWidth || d > maxAlertRuleWindow {
+				return nil, fmt.Errorf("alert rule %q: window must be between %s and %s", part, trafficBucketWidth, maxAlertRuleWindow)
+			}
+			rule.Window, part = d, condition
+		}
+
+		metric, threshold, ok := strings.Cut(part, ">")
+		if !ok {
+			return nil, fmt.Errorf("alert rule %q: expected metric>threshold", part)
+		}
+		rule.Metric = strings.TrimSpace(metric)
+		switch rule.Metric {
+		case AlertMetricErrorRate, AlertMetricP95Latency, AlertMetricClassifierFallback:
+		default:
+			return nil, fmt.Errorf("alert rule %q: unknown metric %q", part, rule.Metric)
+		}
+		var err error
+		if rule.Threshold, err = strconv.ParseFloat(strings.TrimSpace(threshold), 64); err != nil || rule.Threshold < 0 {
+			return nil, fmt.Errorf("alert rule %q: threshold must be a non-negative number", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// AlertConfig controls the built-in alert evaluator
+type AlertConfig struct {
+	Interval          time.Duration // How often rules are evaluated; 0 disables alerting
+	Rules             []AlertRule
+	MinSamples        int64    // Fewer requests or classifications in a rule's window leave it as it was
+	Emails            []string // Notified of alerts; admins when empty
+	WebhookURL        string   // Optional; receives each alert as JSON
+	WebhookSchema     string   // Event schema version the webhook receives; DefaultEventSchemaVersion when empty
+	PrimaryClassifier string   // Classifications by any other classifier count as fallbacks
+}
+
+// LoadAlertConfig reads the ALERT_* environment variables. Invalid rules are
+// logged and the defaults used instead.
+func LoadAlertConfig(logger *logrus.Logger) AlertConfig {
+	config := AlertConfig{
+		Interval:          30 * time.Second,
+		MinSamples:        20,
+		Emails:            splitEnvList("ALERT_EMAILS"),
+		WebhookURL:        os.Getenv("ALERT_WEBHOOK_URL"),
+		PrimaryClassifier: getEnv("INTENT_DRIFT_PRIMARY_CLASSIFIER", "distilbert"),
+	}
+	if d, err := time.ParseDuration(getEnv("ALERT_INTERVAL", "")); err == nil && d >= 0 {
+		config.Interval = d
+	}
+	if n, err := strconv.ParseInt(getEnv("ALERT_MIN_SAMPLES", ""), 10, 64); err == nil && n > 0 {
+		config.MinSamples = n
+	}
+
+	window := 5 * time.Minute
+	if d, err := time.ParseDuration(getEnv("ALERT_WINDOW", "")); err == nil && d >= trafficBucketWidth && d <= maxAlertRuleWindow {
+		window = d
+	}
+	rules, err := ParseAlertRules(getEnv("ALERT_RULES", defaultAlertRules), window)
+	if err != nil {
+		logger.WithError(err).Error("Invalid ALERT_RULES, using the defaults")
+		rules, _ = ParseAlertRules(defaultAlertRules, window)
+	}
+	config.Rules = rules
+	if config.WebhookSchema, err = ParseEventSchemaVersion(os.Getenv("ALERT_WEBHOOK_SCHEMA_VERSION")); err != nil {
+		logger.WithError(err).Error("Invalid ALERT_WEBHOOK_SCHEMA_VERSION, using the default")
+		config.WebhookSchema = DefaultEventSchemaVersion
+	}
+	return config
+}
+
+// Alert is a rule that fired, with the metric as last evaluated
+type Alert struct {
+	Rule        string     `json:"rule"`
+	Metric      string     `json:"metric"`
+	Threshold   float64    `json:"threshold"`
+	Window      string     `json:"window"`
+	Value       float64    `json:"value"`
+	Samples     int64      `json:"samples"`
+	Status      string     `json:"status"`
+	Instance    string     `json:"instance"` // Gateway instance whose traffic it is about
+	FiredAt     time.Time  `json:"fired_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	EvaluatedAt time.Time  `json:"evaluated_at"`
+}
+
+// AlertService evaluates alert rules over this instance's recent traffic
+// and notifies admins when one starts or stops firing. Each instance
+// watches its own traffic, so alerts are per instance.
+type AlertService struct {
+	db       *DatabaseService
+	email    *EmailService
+	eventBus *EventBus
+	config   AlertConfig
+	logger   *logrus.Logger
+	traffic  *trafficWindow
+	client   *http.Client
+	instance string
+
+	mu     sync.Mutex
+	active map[string]*Alert
+	recent []*Alert // Resolved alerts, newest last
+}
+
+// NewAlertService creates a new alert evaluator. email and eventBus are
+// optional.
+func NewAlertService(db *DatabaseService, email *EmailService, eventBus *EventBus, config AlertConfig, logger *logrus.Logger) *AlertService {
+	instance, _ := os.Hostname()
+	return &AlertService{
+		db:       db,
+		email:    email,
+		eventBus: eventBus,
+		config:   config,
+		logger:   logger,
+		traffic:  gatewayTraffic,
+		client:   &http.Client{Timeout: alertWebhookTimeout},
+		instance: instance,
+		active:   make(map[string]*Alert),
+	}
+}
+
+// Run evaluates the rules every interval until ctx is done. Unlike
+// scheduled jobs it runs on every instance, as each has its own traffic.
+func (s *AlertService) Run(ctx context.Context) {
+	if s.config.Interval <= 0 || len(s.config.Rules) == 0 {
+		s.logger.Info("Alerting disabled")
+		return
+	}
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Evaluate()
+		}
+	}
+}
+
+// Evaluate checks every rule once, firing and resolving alerts
+func (s *AlertService) Evaluate() {
+	now := time.Now().UTC()
+	for _, rule := range s.config.Rules {
+		value, samples := s.measure(rule)
+		if samples < s.config.MinSamples {
+			continue
+		}
+
+		s.mu.Lock()
+		alert, firing := s.active[rule.Name()]
+		var changed *Alert
+		switch {
+		case value > rule.Threshold && !firing:
+			alert = &Alert{
+				Rule:      rule.Name(),
+				Metric:    rule.Metric,
+				Threshold: rule.Threshold,
+				Window:    rule.Window.String(),
+				Status:    AlertStatusFiring,
+				Instance:  s.instance,
+				FiredAt:   now,
+			}
+			s.active[alert.Rule] = alert
+			changed = alert
+		case value <= rule.Threshold && firing:
+			alert.Status = AlertStatusResolved
+			alert.ResolvedAt = &now
+			delete(s.active, alert.Rule)
+			s.recent = append(s.recent, alert)
+			if len(s.recent) > recentAlertsToKeep {
+				s.recent = s.recent[len(s.recent)-recentAlertsToKeep:]
+			}
+			changed = alert
+		}
+		if alert != nil {
+			alert.Value, alert.Samples, alert.EvaluatedAt = value, samples, now
+		}
+		var notice Alert
+		if changed != nil {
+			notice = *changed
+		}
+		s.mu.Unlock()
+
+		if changed != nil {
+			s.announce(notice)
+		}
+	}
+}
+
+// measure returns the rule's metric over its window and the number of
+// samples it is based on
+func (s *AlertService) measure(rule AlertRule) (float64, int64) {
+	summary := s.traffic.summary(rule.Window)
+	switch rule.Metric {
+	case AlertMetricErrorRate:
+		return summary.ErrorRate(), summary.Requests
+	case AlertMetricP95Latency:
+		return summary.LatencyPercentile(0.95), summary.Requests
+	default:
+		return summary.ClassifierFallbackRate(s.config.PrimaryClassifier), summary.knownClassifications()
+	}
+}
+
+// Active returns the alerts firing now, by rule
+func (s *AlertService) Active() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(s.active))
+	for _, alert := range s.active {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Rule < alerts[j].Rule })
+	return alerts
+}
+
+// Resolved returns recently resolved alerts, newest first
+func (s *AlertService) Resolved() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	alerts := make([]Alert, len(s.recent))
+	for i, alert := range s.recent {
+		alerts[len(s.recent)-1-i] = *alert
+	}
+	return alerts
+}
+
+// Rules returns the rules being evaluated
+func (s *AlertService) Rules() []AlertRule {
+	return s.config.Rules
+}
+
+// announce logs an alert changing state and sends its notifications in the
+// background
+func (s *AlertService) announce(alert Alert) {
+	logger := s.logger.WithFields(logrus.Fields{
+		"rule":    alert.Rule,
+		"value":   alert.Value,
+		"samples": alert.Samples,
+		"status":  alert.Status,
+	})
+	if alert.Status == AlertStatusFiring {
+		alertsFired.WithLabelValues(alert.Metric).Inc()
+		logger.Warn("Alert firing")
+	} else {
+		logger.Info("Alert resolved")
+	}
+
+	if s.eventBus != nil {
+		s.eventBus.Publish(ActivityEvent{
+			Type: ActivityAlert,
+			Data: map[string]interface{}{
+				"rule":   alert.Rule,
+				"status": alert.Status,
+				"value":  alert.Value,
+			},
+		})
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), alertNotifyTimeout)
+		defer cancel()
+		s.sendWebhook(ctx, alert)
+		s.sendEmails(ctx, alert)
+	}()
+}
+
+func (s *AlertService) sendWebhook(ctx context.Context, alert Alert) {
+	if s.config.WebhookURL == "" {
+		return
+	}
+	schema := s.config.WebhookSchema
+	if schema == "" {
+		schema = DefaultEventSchemaVersion
+	}
+	payload, err := RenderAlertEvent(alert, schema)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to render alert webhook")
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to create alert webhook request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Schema-Version", schema)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to send alert webhook")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		s.logger.WithField("status", resp.StatusCode).Warn("Alert webhook was rejected")
+	}
+}
+
+func (s *AlertService) sendEmails(ctx context.Context, alert Alert) {
+	if s.email == nil {
+		return
+	}
+
+	type recipient struct{ email, name string }
+	var recipients []recipient
+	for _, email := range s.config.Emails {
+		recipients = append(recipients, recipient{email, "admin"})
+	}
+	if len(recipients) == 0 {
+		rows, err := s.db.DB.QueryContext(ctx, `
+			SELECT email, username FROM auth.users
+			WHERE 'admin' = ANY(roles) AND is_active`)
+		if err != nil {
+			s.logger.WithError(err).Warn("Failed to load admins for alert")
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r recipient
+			if err := rows.Scan(&r.email, &r.name); err != nil {
+				s.logger.WithError(err).Warn("Failed to scan admin for alert")
+				return
+			}
+			recipients = append(recipients, r)
+		}
+	}
+
+	subject := fmt.Sprintf("[%s] %s on %s", strings.ToUpper(alert.Status), alert.Rule, alert.Instance)
+	message := fmt.Sprintf(
+		"%s is %s: %s over the last %s is %.4g against a threshold of %.4g, from %d samples. See /api/v1/admin/alerts for active alerts.",
+		alert.Rule, alert.Status, alert.Metric, alert.Window, alert.Value, alert.Threshold, alert.Samples,
+	)
+	for _, r := range recipients {
+		if err := s.email.SendNoticeEmail(ctx, r.email, r.name, subject, subject, message); err != nil {
+			s.logger.WithError(err).Warn("Failed to send alert email")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlertRules(t *testing.T) {
+	rules, err := ParseAlertRules("error_rate>0.05, p95_latency_ms>1500@1m,classifier_fallback_rate>0.3@15m", 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []AlertRule{
+		{Metric: AlertMetricErrorRate, Threshold: 0.05, Window: 5 * time.Minute},
+		{Metric: AlertMetricP95Latency, Threshold: 1500, Window: time.Minute},
+		{Metric: AlertMetricClassifierFallback, Threshold: 0.3, Window: 15 * time.Minute},
+	}, rules)
+	assert.Equal(t, "p95_latency_ms>1500@1m0s", rules[1].Name())
+
+	for _, spec := range []string{"error_rate", "cpu>0.5", "error_rate>high", "error_rate>0.1@2h", "error_rate>0.1@1s", "error_rate>-1"} {
+		_, err := ParseAlertRules(spec, time.Minute)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestTrafficWindow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	window := newTrafficWindow(func() time.Time { return now })
+
+	for i := 0; i < 90; i++ {
+		window.recordRequest(200, 40*time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		window.recordRequest(503, 3*time.Second)
+	}
+	window.recordClassification("distilbert")
+	window.recordClassification("rules")
+	window.recordClassification("")
+
+	summary := window.summary(time.Minute)
+	assert.Equal(t, int64(100), summary.Requests)
+	assert.InDelta(t, 0.1, summary.ErrorRate(), 1e-9)
+	assert.InDelta(t, 40, summary.LatencyPercentile(0.5), 10)
+	// The 95th percentile is halfway through the 2-5s bucket
+	assert.InDelta(t, 3500, summary.LatencyPercentile(0.95), 1e-9)
+	assert.Equal(t, 0.5, summary.ClassifierFallbackRate("distilbert"))
+	assert.Equal(t, int64(2), summary.knownClassifications())
+
+	// Older traffic drops out of the window, and its bucket is reused
+	now = now.Add(2 * time.Minute)
+	window.recordRequest(200, time.Millisecond)
+	assert.Equal(t, int64(1), window.summary(time.Minute).Requests)
+	assert.Equal(t, int64(101), window.summary(5*time.Minute).Requests)
+
+	now = now.Add(trafficBuckets * trafficBucketWidth)
+	assert.Zero(t, window.summary(maxAlertRuleWindow).Requests)
+}
+
+func TestAlertServiceEvaluate(t *testing.T) {
+	now := time.Now()
+	traffic := newTrafficWindow(func() time.Time { return now })
+
+	webhooks := make(chan map[string]interface{}, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		webhooks <- body
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	rules, err := ParseAlertRules("error_rate>0.05@1m", time.Minute)
+	require.NoError(t, err)
+	alerts := NewAlertService(nil, nil, nil, AlertConfig{Interval: time.Second, Rules: rules, MinSamples: 10, WebhookURL: server.URL}, logger)
+	alerts.traffic = traffic
+
+	// Too little traffic to judge
+	for i := 0; i < 5; i++ {
+		traffic.recordRequest(500, time.Millisecond)
+	}
+	alerts.Evaluate()
+	assert.Empty(t, alerts.Active())
+
+	for i := 0; i < 15; i++ {
+		traffic.recordRequest(200, time.Millisecond)
+	}
+	alerts.Evaluate()
+	active := alerts.Active()
+	require.Len(t, active, 1)
+	assert.Equal(t, AlertStatusFiring, active[0].Status)
+	assert.Equal(t, int64(20), active[0].Samples)
+	assert.InDelta(t, 0.25, active[0].Value, 1e-9)
+
+	select {
+	case body := <-webhooks:
+		assert.Equal(t, "alert.firing", body["event"])
+	case <-time.After(time.Second):
+		t.Fatal("no webhook for the firing alert")
+	}
+
+	// Still firing: no second notification
+	alerts.Evaluate()
+	assert.Len(t, alerts.Active(), 1)
+
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 20; i++ {
+		traffic.recordRequest(200, time.Millisecond)
+	}
+	alerts.Evaluate()
+	assert.Empty(t, alerts.Active())
+	resolved := alerts.Resolved()
+	require.Len(t, resolved, 1)
+	assert.Equal(t, AlertStatusResolved, resolved[0].Status)
+	assert.NotNil(t, resolved[0].ResolvedAt)
+
+	select {
+	case body := <-webhooks:
+		assert.Equal(t, "alert.resolved", body["event"])
+	case <-time.After(time.Second):
+		t.Fatal("no webhook for the resolved alert")
+	}
+	assert.Empty(t, webhooks)
+}
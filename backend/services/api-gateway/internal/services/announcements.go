@@ -0,0 +1,421 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Announcement kinds, which the frontend styles differently
+const (
+	AnnouncementKindInfo        = "info"
+	AnnouncementKindFeature     = "feature"
+	AnnouncementKindMaintenance = "maintenance"
+)
+
+const (
+	// announcementsCacheTTL bounds how stale the shared list can be on
+	// instances that didn't make a change
+	announcementsCacheTTL = time.Minute
+	// announcementRetention is how long ended announcements are kept for
+	// admins before the purge job deletes them
+	announcementRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrAnnouncementNotFound is returned for unknown announcements, and for
+	// dismissals of announcements the caller can't see
+	ErrAnnouncementNotFound = errors.New("announcement not found")
+	// ErrAnnouncementNotDismissible is returned when dismissing a banner that
+	// must stay up, such as a maintenance notice
+	ErrAnnouncementNotDismissible = errors.New("announcement can't be dismissed")
+)
+
+// Announcement is a banner shown to the users it targets between StartsAt
+// and EndsAt
+type Announcement struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Title       string    `json:"title"`
+	Body        string    `json:"body"`
+	LinkURL     string    `json:"link_url,omitempty"`
+	Tiers       []string  `json:"tiers"` // Empty targets every tier
+	Roles       []string  `json:"roles"` // Empty targets every role
+	Dismissible bool      `json:"dismissible"`
+	StartsAt    time.Time `json:"starts_at"`
+	EndsAt      time.Time `json:"ends_at"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Live reports whether the announcement is showing at now
+func (a *Announcement) Live(now time.Time) bool {
+	return !now.Before(a.StartsAt) && now.Before(a.EndsAt)
+}
+
+// Targets reports whether the caller is in the announcement's audience.
+// Role-targeted announcements are never shown to anonymous callers.
+func (a *Announcement) Targets(rc *RequestContext) bool {
+	if len(a.Tiers) > 0 && !slices.Contains(a.Tiers, rc.Tier) {
+		return false
+	}
+	if len(a.Roles) > 0 && !slices.ContainsFunc(rc.Roles, func(role string) bool {
+		return slices.Contains(a.Roles, role)
+	}) {
+		return false
+	}
+	return true
+}
+
+// NormalizeAnnouncement trims an announcement and checks it is valid
+func NormalizeAnnouncement(a *Announcement) error {
+	a.Title = strings.TrimSpace(a.Title)
+	a.Body = strings.TrimSpace(a.Body)
+	a.LinkURL = strings.TrimSpace(a.LinkURL)
+	switch a.Kind {
+	case AnnouncementKindInfo, AnnouncementKindFeature, AnnouncementKindMaintenance:
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", AnnouncementKindInfo, AnnouncementKindFeature, AnnouncementKindMaintenance)
+	}
+	if a.Title == "" {
+		return errors.New("title is required")
+	}
+	if a.LinkURL != "" {
+		if u, err := url.Parse(a.LinkURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("link_url must be an http(s) URL")
+		}
+	}
+	if !a.EndsAt.After(a.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	a.Tiers = normalizeAudience(a.Tiers)
+	a.Roles = normalizeAudience(a.Roles)
+	return nil
+}
+
+func normalizeAudience(values []string) []string {
+	audience := []string{}
+	for _, value := range values {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" && !slices.Contains(audience, value) {
+			audience = append(audience, value)
+		}
+	}
+	return audience
+}
+
+// AnnouncementService stores announcements and serves the ones each caller
+// should see. The announcements that haven't ended are shared through Redis,
+// so the frontend's polling doesn't reach Postgres except for the dismissals
+// of users who can dismiss something.
+type AnnouncementService struct {
+	db     *DatabaseService
+	cache  *CacheService // Optional; every poll reads Postgres when nil
+	logger *logrus.Logger
+}
+
+// NewAnnouncementService creates a new announcement service
+func NewAnnouncementService(db *DatabaseService, cache *CacheService, logger *logrus.Logger) *AnnouncementService {
+	return &AnnouncementService{
+		db:     db,
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+const announcementColumns = `id, kind, title, body, COALESCE(link_url, ''), tiers, roles, dismissible,
+	starts_at, ends_at, COALESCE(created_by::text, ''), created_at, updated_at`
+
+func scanAnnouncement(row rowScanner) (*Announcement, error) {
+	var a Announcement
+	if err := row.Scan(&a.ID, &a.Kind, &a.Title, &a.Body, &a.LinkURL, pq.Array(&a.Tiers), pq.Array(&a.Roles),
+		&a.Dismissible, &a.StartsAt, &a.EndsAt, &a.CreatedBy, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if a.Tiers == nil {
+		a.Tiers = []string{}
+	}
+	if a.Roles == nil {
+		a.Roles = []string{}
+	}
+	return &a, nil
+}
+
+// Active returns the announcements showing now to the caller, leaving out
+// the ones they dismissed, soonest ending first
+func (s *AnnouncementService) Active(ctx context.Context, rc *RequestContext) ([]*Announcement, error) {
+	current, err := s.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := []*Announcement{}
+	var dismissible []string
+	for _, a := range current {
+		if a.Live(now) && a.Targets(rc) {
+			active = append(active, a)
+			if a.Dismissible {
+				dismissible = append(dismissible, a.ID)
+			}
+		}
+	}
+	if !rc.Authenticated() || len(dismissible) == 0 {
+		return active, nil
+	}
+
+	dismissed, err := s.dismissed(ctx, rc.UserID, dismissible)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(active, func(a *Announcement) bool {
+		return dismissed[a.ID]
+	}), nil
+}
+
+// current returns every announcement that hasn't ended, from Redis when it
+// is there
+func (s *AnnouncementService) current(ctx context.Context) ([]*Announcement, error) {
+	if s.cache != nil {
+		data, err := s.cache.client.Get(ctx, s.cache.Key("announcements")).Bytes()
+		if err == nil {
+			var announcements []*Announcement
+			if err := json.Unmarshal(data, &announcements); err == nil {
+				return announcements, nil
+			}
+		} else if err != redis.Nil {
+			s.logger.WithError(err).Debug("Failed to read cached announcements")
+		}
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+announcementColumns+`
+		FROM messaging.announcements
+		WHERE ends_at > CURRENT_TIMESTAMP
+		ORDER BY ends_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query announcements: %w", err)
+	}
+	defer rows.Close()
+
+	announcements := []*Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcements = append(announcements, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate announcements: %w", err)
+	}
+
+	if s.cache != nil {
+		if data, err := json.Marshal(announcements); err == nil {
+			if err := s.cache.client.Set(ctx, s.cache.Key("announcements"), data, announcementsCacheTTL).Err(); err != nil {
+				s.logger.WithError(err).Debug("Failed to cache announcements")
+			}
+		}
+	}
+	return announcements, nil
+}
+
+// invalidate drops the shared list after an admin change
+func (s *AnnouncementService) invalidate(ctx context.Context) {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.client.Del(ctx, s.cache.Key("announcements")).Err(); err != nil {
+		s.logger.WithError(err).Warn("Failed to invalidate cached announcements")
+	}
+}
+
+func (s *AnnouncementService) dismissed(ctx context.Context, userID string, ids []string) (map[string]bool, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT announcement_id
+		FROM messaging.announcement_dismissals
+		WHERE user_id = $1 AND announcement_id = ANY($2)`, userID, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dismissals: %w", err)
+	}
+	defer rows.Close()
+
+	dismissed := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan dismissal: %w", err)
+		}
+		dismissed[id] = true
+	}
+	return dismissed, rows.Err()
+}
+
+// Dismiss hides an announcement from the caller for good
+func (s *AnnouncementService) Dismiss(ctx context.Context, rc *RequestContext, id string) error {
+	current, err := s.current(ctx)
+	if err != nil {
+		return err
+	}
+	idx := slices.IndexFunc(current, func(a *Announcement) bool { return a.ID == id })
+	if idx < 0 || !current[idx].Targets(rc) {
+		return ErrAnnouncementNotFound
+	}
+	if !current[idx].Dismissible {
+		return ErrAnnouncementNotDismissible
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO messaging.announcement_dismissals (announcement_id, user_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING`, id, rc.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to dismiss announcement: %w", err)
+	}
+	return nil
+}
+
+// ListAnnouncements returns announcements for admins, newest first. Ended
+// ones are included only when asked for.
+func (s *AnnouncementService) ListAnnouncements(ctx context.Context, includeEnded bool) ([]*Announcement, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+announcementColumns+`
+		FROM messaging.announcements
+		WHERE $1 OR ends_at > CURRENT_TIMESTAMP
+		ORDER BY starts_at DESC`, includeEnded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query announcements: %w", err)
+	}
+	defer rows.Close()
+
+	announcements := []*Announcement{}
+	for rows.Next() {
+		a, err := scanAnnouncement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcements = append(announcements, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate announcements: %w", err)
+	}
+	return announcements, nil
+}
+
+// GetAnnouncement returns one announcement
+func (s *AnnouncementService) GetAnnouncement(ctx context.Context, id string) (*Announcement, error) {
+	a, err := scanAnnouncement(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+announcementColumns+`
+		FROM messaging.announcements
+		WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAnnouncementNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get announcement: %w", err)
+	}
+	return a, nil
+}
+
+// CreateAnnouncement stores a new announcement. It must have been normalized.
+func (s *AnnouncementService) CreateAnnouncement(ctx context.Context, a Announcement, adminID string) (*Announcement, error) {
+	created, err := scanAnnouncement(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO messaging.announcements (
+			kind, title, body, link_url, tiers, roles, dismissible, starts_at, ends_at, created_by
+		) VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, $9, NULLIF($10, '')::uuid)
+		RETURNING `+announcementColumns,
+		a.Kind, a.Title, a.Body, a.LinkURL, pq.Array(a.Tiers), pq.Array(a.Roles), a.Dismissible, a.StartsAt, a.EndsAt, adminID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create announcement: %w", err)
+	}
+
+	s.changed(ctx, created, adminID, "Announcement created")
+	return created, nil
+}
+
+// UpdateAnnouncement replaces an announcement. It must have been normalized.
+// Dismissals are kept, so edits don't bring a banner back.
+func (s *AnnouncementService) UpdateAnnouncement(ctx context.Context, id string, a Announcement, adminID string) (*Announcement, error) {
+	updated, err := scanAnnouncement(s.db.DB.QueryRowContext(ctx, `
+		UPDATE messaging.announcements
+		SET kind = $2, title = $3, body = $4, link_url = NULLIF($5, ''), tiers = $6, roles = $7,
+			dismissible = $8, starts_at = $9, ends_at = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+announcementColumns,
+		id, a.Kind, a.Title, a.Body, a.LinkURL, pq.Array(a.Tiers), pq.Array(a.Roles), a.Dismissible, a.StartsAt, a.EndsAt,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAnnouncementNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update announcement: %w", err)
+	}
+
+	s.changed(ctx, updated, adminID, "Announcement updated")
+	return updated, nil
+}
+
+// DeleteAnnouncement removes an announcement. Ending it early by moving
+// ends_at keeps it for the record instead.
+func (s *AnnouncementService) DeleteAnnouncement(ctx context.Context, id, adminID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM messaging.announcements WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete announcement: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrAnnouncementNotFound
+	}
+
+	s.changed(ctx, &Announcement{ID: id}, adminID, "Announcement deleted")
+	return nil
+}
+
+// changed audits an admin change and drops the shared list
+func (s *AnnouncementService) changed(ctx context.Context, a *Announcement, adminID, message string) {
+	s.invalidate(ctx)
+	s.logger.WithFields(logrus.Fields{
+		"audit":           true,
+		"updated_by":      adminID,
+		"announcement_id": a.ID,
+		"kind":            a.Kind,
+		"starts_at":       a.StartsAt,
+		"ends_at":         a.EndsAt,
+	}).Info(message)
+}
+
+// PurgeJob returns the scheduled job that deletes announcements which ended
+// more than 30 days ago, along with their dismissals
+func (s *AnnouncementService) PurgeJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "announcement_purge",
+		Interval: 24 * time.Hour,
+		Timeout:  time.Minute,
+		Run: func(ctx context.Context) error {
+			result, err := s.db.DB.ExecContext(ctx,
+				`DELETE FROM messaging.announcements WHERE ends_at < $1`, time.Now().Add(-announcementRetention))
+			if err != nil {
+				return fmt.Errorf("failed to purge announcements: %w", err)
+			}
+			if purged, _ := result.RowsAffected(); purged > 0 {
+				s.logger.WithField("purged", purged).Info("Purged ended announcements")
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAnnouncement(t *testing.T) {
+	now := time.Now()
+	announcement := Announcement{
+		Kind:     AnnouncementKindMaintenance,
+		Title:    " Scheduled maintenance ",
+		Tiers:    []string{" Pro", "pro", ""},
+		StartsAt: now,
+		EndsAt:   now.Add(time.Hour),
+	}
+	require.NoError(t, NormalizeAnnouncement(&announcement))
+	assert.Equal(t, "Scheduled maintenance", announcement.Title)
+	assert.Equal(t, []string{"pro"}, announcement.Tiers)
+	assert.Equal(t, []string{}, announcement.Roles)
+
+	for _, invalid := range []Announcement{
+		{Kind: "promo", Title: "New", StartsAt: now, EndsAt: now.Add(time.Hour)},
+		{Kind: AnnouncementKindInfo, Title: " ", StartsAt: now, EndsAt: now.Add(time.Hour)},
+		{Kind: AnnouncementKindInfo, Title: "New", LinkURL: "javascript:alert(1)", StartsAt: now, EndsAt: now.Add(time.Hour)},
+		{Kind: AnnouncementKindInfo, Title: "New", StartsAt: now, EndsAt: now},
+	} {
+		assert.Error(t, NormalizeAnnouncement(&invalid), invalid.Title)
+	}
+}
+
+func TestAnnouncementTargets(t *testing.T) {
+	anonymous := &RequestContext{Tier: "free"}
+	admin := &RequestContext{UserID: "user-1", Tier: "pro", Roles: []string{"user", "admin"}}
+
+	assert.True(t, (&Announcement{}).Targets(anonymous))
+	assert.True(t, (&Announcement{Tiers: []string{"pro"}}).Targets(admin))
+	assert.False(t, (&Announcement{Tiers: []string{"pro"}}).Targets(anonymous))
+	assert.True(t, (&Announcement{Roles: []string{"admin"}}).Targets(admin))
+	assert.False(t, (&Announcement{Roles: []string{"admin"}}).Targets(anonymous), "anonymous callers have no roles")
+}
+
+func TestActiveAnnouncements(t *testing.T) {
+	now := time.Now()
+	columns := []string{"id", "kind", "title", "body", "link_url", "tiers", "roles", "dismissible",
+		"starts_at", "ends_at", "created_by", "created_at", "updated_at"}
+	row := func(id, tiers string, dismissible bool, startsAt time.Time) []driver.Value {
+		return []driver.Value{id, AnnouncementKindInfo, id, "", "", tiers, "{}", dismissible,
+			startsAt, now.Add(time.Hour), "", now, now}
+	}
+
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "announcement_dismissals") {
+			return []string{"announcement_id"}, [][]driver.Value{{"dismissed"}}
+		}
+		return columns, [][]driver.Value{
+			row("live", "{}", true, now.Add(-time.Minute)),
+			row("dismissed", "{}", true, now.Add(-time.Minute)),
+			row("pinned", "{}", false, now.Add(-time.Minute)),
+			row("pro-only", "{pro}", true, now.Add(-time.Minute)),
+			row("scheduled", "{}", true, now.Add(time.Minute)),
+		}
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	announcements := NewAnnouncementService(NewDatabaseService(db.DB), nil, logger)
+
+	ids := func(active []*Announcement) []string {
+		var ids []string
+		for _, a := range active {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+
+	active, err := announcements.Active(context.Background(), &RequestContext{Tier: "free"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"live", "dismissed", "pinned"}, ids(active), "anonymous callers can't dismiss")
+	assert.Len(t, d.entries(), 1)
+
+	active, err = announcements.Active(context.Background(), &RequestContext{UserID: "user-1", Tier: "pro"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"live", "pinned", "pro-only"}, ids(active))
+
+	err = announcements.Dismiss(context.Background(), &RequestContext{UserID: "user-1", Tier: "free"}, "pinned")
+	assert.ErrorIs(t, err, ErrAnnouncementNotDismissible)
+	err = announcements.Dismiss(context.Background(), &RequestContext{UserID: "user-1", Tier: "free"}, "pro-only")
+	assert.ErrorIs(t, err, ErrAnnouncementNotFound, "announcements aimed at others can't be dismissed")
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/auth"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix marks keys issued by BetterPrompts so they are easy to spot in logs and secret scanners
+const APIKeyPrefix = "bp_"
+
+// API key scopes. A key can only call the integration routes its scopes
+// grant.
+const (
+	APIKeyScopePromptRead  = "prompt:read"  // Poll history and read pins
+	APIKeyScopePromptWrite = "prompt:write" // Enhance prompts
+)
+
+// APIKeyScopes lists the scopes a key can be granted. Keys created without
+// scopes get all of them.
+var APIKeyScopes = []string{APIKeyScopePromptRead, APIKeyScopePromptWrite}
+
+// ErrInvalidAPIKeyScopes is returned when a key would be created with
+// unknown scopes
+var ErrInvalidAPIKeyScopes = errors.New("invalid api key scopes")
+
+// APIKey is a developer API key as stored in auth.api_keys
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"`
+	UsageCount int        `json:"usage_count"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	IsActive   bool       `json:"is_active"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyService manages developer API keys
+type APIKeyService struct {
+	db *DatabaseService
+}
+
+// NewAPIKeyService creates a new API key service
+func NewAPIKeyService(db *DatabaseService) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// HashAPIKey returns the stored representation of a raw API key
+func HashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey issues a new key for a user. The raw key is only returned here;
+// only its hash is persisted.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	token, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	rawKey := APIKeyPrefix + strings.TrimRight(token, "=")
+
+	scopes, err = normalizeAPIKeyScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		IsActive:  true,
+	}
+
+	query := `
+		INSERT INTO auth.api_keys (id, user_id, name, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING rate_limit, created_at`
+
+	err = s.db.DB.QueryRowContext(ctx, query,
+		key.ID, key.UserID, key.Name, HashAPIKey(rawKey), pq.Array(key.Scopes), key.ExpiresAt,
+	).Scan(&key.RateLimit, &key.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" && pqErr.Constraint == "idx_api_keys_user_name" {
+			return nil, "", errors.New("api key name already exists")
+		}
+		return nil, "", fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	return key, rawKey, nil
+}
+
+// ListAPIKeys returns all keys belonging to a user, newest first
+func (s *APIKeyService) ListAPIKeys(ctx context.Context, userID string) ([]*APIKey, error) {
+	query := `
+		SELECT id, user_id, name, scopes, rate_limit, usage_count,
+			   last_used_at, expires_at, is_active, created_at
+		FROM auth.api_keys
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey deactivates a key owned by the given user
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, userID, keyID string) error {
+	query := `
+		UPDATE auth.api_keys
+		SET is_active = false, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND is_active = true`
+
+	result, err := s.db.DB.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("api key not found")
+	}
+
+	return nil
+}
+
+// ValidateAPIKey resolves a raw key to an active, unexpired key and records its use
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, rawKey string) (*APIKey, error) {
+	if !strings.HasPrefix(rawKey, APIKeyPrefix) {
+		return nil, errors.New("invalid api key")
+	}
+
+	query := `
+		UPDATE auth.api_keys
+		SET usage_count = usage_count + 1, last_used_at = CURRENT_TIMESTAMP
+		WHERE key_hash = $1
+		  AND is_active = true
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		RETURNING id, user_id, name, scopes, rate_limit, usage_count,
+				  last_used_at, expires_at, is_active, created_at`
+
+	key, err := scanAPIKey(s.db.DB.QueryRowContext(ctx, query, HashAPIKey(rawKey)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid api key")
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// HasScope reports whether the key grants a scope. A key without scopes is
+// granted nothing.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeAPIKeyScopes checks scopes against APIKeyScopes, returning them
+// sorted without duplicates, or all of them when there are none
+func normalizeAPIKeyScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return append([]string(nil), APIKeyScopes...), nil
+	}
+	seen := make(map[string]bool, len(scopes))
+	normalized := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, s := range APIKeyScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyScopes, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var lastUsedAt, expiresAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID, &key.UserID, &key.Name, pq.Array(&key.Scopes), &key.RateLimit,
+		&key.UsageCount, &lastUsedAt, &expiresAt, &key.IsActive, &key.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan api key: %w", err)
+	}
+
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+
+	return &key, nil
+}
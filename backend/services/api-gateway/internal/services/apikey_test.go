@@ -0,0 +1,36 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeAPIKeyScopes(t *testing.T) {
+	scopes, err := normalizeAPIKeyScopes([]string{"prompt:write", " prompt:read", "prompt:write"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{APIKeyScopePromptRead, APIKeyScopePromptWrite}, scopes)
+
+	scopes, err = normalizeAPIKeyScopes(nil)
+	require.NoError(t, err)
+	assert.Equal(t, APIKeyScopes, scopes, "keys without scopes get all of them")
+
+	for name, scopes := range map[string][]string{
+		"unknown":  {"prompt:read", "analytics:read"},
+		"wildcard": {"*"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := normalizeAPIKeyScopes(scopes)
+			assert.ErrorIs(t, err, ErrInvalidAPIKeyScopes)
+		})
+	}
+}
+
+func TestAPIKeyHasScope(t *testing.T) {
+	key := &APIKey{Scopes: []string{APIKeyScopePromptRead}}
+	assert.True(t, key.HasScope(APIKeyScopePromptRead))
+	assert.False(t, key.HasScope(APIKeyScopePromptWrite))
+	assert.False(t, (&APIKey{}).HasScope(APIKeyScopePromptRead), "keys without scopes grant nothing")
+	assert.False(t, (&APIKey{Scopes: []string{"*"}}).HasScope(APIKeyScopePromptRead), "there is no wildcard scope")
+}
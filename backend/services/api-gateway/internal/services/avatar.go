@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // Register GIF decoding for uploads
+	"image/jpeg"
+	"image/png"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxAvatarUploadSize bounds the size of an uploaded avatar file
+	MaxAvatarUploadSize = 5 << 20
+
+	// AvatarSize is the edge length avatars are cropped and scaled to
+	AvatarSize = 256
+
+	// maxAvatarSourcePixels rejects images that would take too much memory
+	// to decode, whatever their file size
+	maxAvatarSourcePixels = 25_000_000
+
+	// AvatarURLExpiry is how long a signed avatar URL stays valid
+	AvatarURLExpiry = 1 * time.Hour
+)
+
+// ErrInvalidAvatar is returned for uploads that aren't a usable image
+var ErrInvalidAvatar = errors.New("avatar must be a JPEG, PNG or GIF image")
+
+// ProcessAvatar validates an uploaded image, crops it to a centred square
+// and scales it to AvatarSize. Images that may be transparent stay PNG;
+// everything else becomes JPEG.
+func ProcessAvatar(data []byte) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidAvatar
+	}
+	if config.Width == 0 || config.Height == 0 || config.Width*config.Height > maxAvatarSourcePixels {
+		return nil, "", fmt.Errorf("avatar dimensions %dx%d are not supported", config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrInvalidAvatar
+	}
+
+	avatar := scaleSquare(src, AvatarSize)
+
+	var buf bytes.Buffer
+	if format == "png" || format == "gif" {
+		if err := png.Encode(&buf, avatar); err != nil {
+			return nil, "", fmt.Errorf("failed to encode avatar: %w", err)
+		}
+		return buf.Bytes(), "image/png", nil
+	}
+	if err := jpeg.Encode(&buf, avatar, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode avatar: %w", err)
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+// scaleSquare crops the centred square of src and box-filters it down to
+// size×size. Smaller sources are scaled up by repeating pixels.
+func scaleSquare(src image.Image, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	origin := image.Pt(bounds.Min.X+(bounds.Dx()-side)/2, bounds.Min.Y+(bounds.Dy()-side)/2)
+
+	square := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), src, origin, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := y*side/size, (y+1)*side/size
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size; x++ {
+			x0, x1 := x*side/size, (x+1)*side/size
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := square.NRGBAAt(sx, sy)
+					r += int(c.R)
+					g += int(c.G)
+					b += int(c.B)
+					a += int(c.A)
+					n++
+				}
+			}
+			dst.SetNRGBA(x, y, color.NRGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// AvatarService stores user avatars in object storage. The users table
+// keeps the object key; clients get a stable gateway URL that redirects to
+// a freshly signed one.
+type AvatarService struct {
+	db      *DatabaseService
+	storage ObjectStorage
+	logger  *logrus.Logger
+}
+
+// NewAvatarService creates a new avatar service
+func NewAvatarService(db *DatabaseService, storage ObjectStorage, logger *logrus.Logger) *AvatarService {
+	return &AvatarService{
+		db:      db,
+		storage: storage,
+		logger:  logger,
+	}
+}
+
+// avatarURL is the stable URL stored as the user's avatar_url. The version
+// changes with every upload so clients don't show a cached old avatar.
+func avatarURL(userID, version string) string {
+	return fmt.Sprintf("/api/v1/avatars/%s?v=%s", userID, version)
+}
+
+// UploadAvatar processes and stores a new avatar for the user, removing the
+// one it replaces, and returns the new avatar URL
+func (s *AvatarService) UploadAvatar(ctx context.Context, userID string, data []byte) (string, error) {
+	processed, contentType, err := ProcessAvatar(data)
+	if err != nil {
+		return "", err
+	}
+
+	version := uuid.New().String()
+	ext := ".jpg"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	key := fmt.Sprintf("avatars/%s/%s%s", userID, version, ext)
+
+	if err := s.storage.Put(ctx, key, processed, contentType); err != nil {
+		return "", fmt.Errorf("failed to store avatar: %w", err)
+	}
+
+	url := avatarURL(userID, version)
+	oldKey, err := s.setAvatar(ctx, userID, key, url)
+	if err != nil {
+		// Don't leave the new object orphaned
+		s.deleteObject(ctx, key)
+		return "", err
+	}
+	if oldKey != "" {
+		s.deleteObject(ctx, oldKey)
+	}
+	return url, nil
+}
+
+// DeleteAvatar removes the user's avatar
+func (s *AvatarService) DeleteAvatar(ctx context.Context, userID string) error {
+	oldKey, err := s.setAvatar(ctx, userID, "", "")
+	if err != nil {
+		return err
+	}
+	if oldKey == "" {
+		return ErrObjectNotFound
+	}
+	s.deleteObject(ctx, oldKey)
+	return nil
+}
+
+// SignedAvatarURL returns a short-lived URL for the user's current avatar
+func (s *AvatarService) SignedAvatarURL(ctx context.Context, userID string) (string, error) {
+	var key sql.NullString
+	err := s.db.DB.QueryRowContext(ctx, `SELECT avatar_key FROM auth.users WHERE id = $1`, userID).Scan(&key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrObjectNotFound
+		}
+		return "", fmt.Errorf("failed to get avatar: %w", err)
+	}
+	if !key.Valid || key.String == "" {
+		return "", ErrObjectNotFound
+	}
+	return s.storage.SignedURL(ctx, key.String, AvatarURLExpiry)
+}
+
+// setAvatar points the user at a new avatar object, returning the key of
+// the one it replaced
+func (s *AvatarService) setAvatar(ctx context.Context, userID, key, url string) (string, error) {
+	query := `
+		UPDATE auth.users u SET
+			avatar_key = NULLIF($2, ''),
+			avatar_url = NULLIF($3, ''),
+			updated_at = CURRENT_TIMESTAMP
+		FROM (SELECT avatar_key FROM auth.users WHERE id = $1 FOR UPDATE) old
+		WHERE u.id = $1
+		RETURNING old.avatar_key`
+
+	var oldKey sql.NullString
+	if err := s.db.DB.QueryRowContext(ctx, query, userID, key, url).Scan(&oldKey); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("user not found")
+		}
+		return "", fmt.Errorf("failed to update avatar: %w", err)
+	}
+	return oldKey.String, nil
+}
+
+// deleteObject removes a replaced avatar. Failures only leave an orphaned
+// object behind, so they're logged rather than returned.
+func (s *AvatarService) deleteObject(ctx context.Context, key string) {
+	if err := s.storage.Delete(ctx, key); err != nil {
+		s.logger.WithError(err).WithField("key", key).Warn("Failed to delete replaced avatar")
+	}
+}
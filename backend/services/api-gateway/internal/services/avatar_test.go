@@ -0,0 +1,59 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func encodeTestImage(t *testing.T, width, height int, asPNG bool) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if asPNG {
+		require.NoError(t, png.Encode(&buf, img))
+	} else {
+		require.NoError(t, jpeg.Encode(&buf, img, nil))
+	}
+	return buf.Bytes()
+}
+
+func TestProcessAvatar(t *testing.T) {
+	t.Run("crops and scales a JPEG", func(t *testing.T) {
+		data, contentType, err := ProcessAvatar(encodeTestImage(t, 800, 400, false))
+		require.NoError(t, err)
+		assert.Equal(t, "image/jpeg", contentType)
+
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, AvatarSize, config.Width)
+		assert.Equal(t, AvatarSize, config.Height)
+	})
+
+	t.Run("keeps PNGs as PNG and scales up small images", func(t *testing.T) {
+		data, contentType, err := ProcessAvatar(encodeTestImage(t, 40, 60, true))
+		require.NoError(t, err)
+		assert.Equal(t, "image/png", contentType)
+
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, AvatarSize, config.Width)
+	})
+
+	t.Run("rejects non-images", func(t *testing.T) {
+		_, _, err := ProcessAvatar([]byte("<svg></svg>"))
+		assert.ErrorIs(t, err, ErrInvalidAvatar)
+	})
+}
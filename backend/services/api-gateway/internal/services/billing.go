@@ -0,0 +1,590 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Paid tiers. Free users have no subscription.
+const (
+	TierPro        = "pro"
+	TierEnterprise = "enterprise"
+)
+
+// tierQuotas are the monthly enhancements each tier allows. Tiers missing
+// here, like enterprise, are unlimited.
+var tierQuotas = map[string]int{
+	TierFree: 100,
+	TierPro:  2000,
+}
+
+// TierQuota returns the monthly enhancement quota of a tier, nil when it is
+// unlimited
+func TierQuota(tier string) *int {
+	if quota, ok := tierQuotas[tier]; ok {
+		return &quota
+	}
+	return nil
+}
+
+var (
+	// ErrBillingDisabled is returned when no Stripe key is configured
+	ErrBillingDisabled = errors.New("billing is not configured")
+	// ErrUnknownPlan is returned for tiers that can't be bought
+	ErrUnknownPlan = errors.New("no plan is available for this tier")
+	// ErrAlreadySubscribed is returned when starting a checkout with a live
+	// subscription, which should be changed instead
+	ErrAlreadySubscribed = errors.New("already subscribed; change the plan instead")
+	// ErrNoSubscription is returned when changing a plan without a live subscription
+	ErrNoSubscription = errors.New("no active subscription")
+)
+
+// BillingConfig configures the Stripe integration
+type BillingConfig struct {
+	SecretKey     string
+	WebhookSecret string
+	APIURL        string
+	SuccessURL    string            // Where Checkout returns after payment
+	CancelURL     string            // Where Checkout returns when abandoned
+	Prices        map[string]string // Stripe price ID by tier
+}
+
+// LoadBillingConfig reads the billing configuration from the environment.
+// Billing is disabled without STRIPE_SECRET_KEY.
+func LoadBillingConfig() BillingConfig {
+	appURL := getEnv("APP_URL", "http://localhost:3000")
+	config := BillingConfig{
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		APIURL:        getEnv("STRIPE_API_URL", "https://api.stripe.com"),
+		SuccessURL:    getEnv("BILLING_SUCCESS_URL", appURL+"/billing/success?session_id={CHECKOUT_SESSION_ID}"),
+		CancelURL:     getEnv("BILLING_CANCEL_URL", appURL+"/billing"),
+		Prices:        map[string]string{},
+	}
+	if price := os.Getenv("STRIPE_PRICE_PRO"); price != "" {
+		config.Prices[TierPro] = price
+	}
+	if price := os.Getenv("STRIPE_PRICE_ENTERPRISE"); price != "" {
+		config.Prices[TierEnterprise] = price
+	}
+	return config
+}
+
+// tierForPrice returns the tier a Stripe price buys
+func (c BillingConfig) tierForPrice(priceID string) (string, bool) {
+	for tier, price := range c.Prices {
+		if price == priceID {
+			return tier, true
+		}
+	}
+	return "", false
+}
+
+// tierRank orders tiers so plan changes can tell upgrades from downgrades
+var tierRank = map[string]int{TierFree: 0, TierPro: 1, TierEnterprise: 2}
+
+// Subscription is a user's billing state as last reported by Stripe
+type Subscription struct {
+	CustomerID        string     `json:"customer_id"`
+	SubscriptionID    string     `json:"subscription_id,omitempty"`
+	Tier              string     `json:"tier,omitempty"`
+	Status            string     `json:"status,omitempty"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+
+	priceID string
+}
+
+// live reports whether the subscription is still billed, so a new checkout
+// would double charge
+func (s *Subscription) live() bool {
+	switch s.Status {
+	case "active", "trialing", "past_due", "incomplete":
+		return s.SubscriptionID != ""
+	}
+	return false
+}
+
+// Invoice is a Stripe invoice as shown to its customer
+type Invoice struct {
+	ID               string    `json:"id"`
+	Number           string    `json:"number,omitempty"`
+	Status           string    `json:"status"`
+	Currency         string    `json:"currency"`
+	AmountDue        int64     `json:"amount_due"` // In the currency's smallest unit
+	AmountPaid       int64     `json:"amount_paid"`
+	Created          time.Time `json:"created"`
+	PeriodStart      time.Time `json:"period_start"`
+	PeriodEnd        time.Time `json:"period_end"`
+	HostedInvoiceURL string    `json:"hosted_invoice_url,omitempty"`
+	InvoicePDF       string    `json:"invoice_pdf,omitempty"`
+}
+
+// BillingService connects users to Stripe: it creates customers, starts
+// Checkout sessions for paid tiers, changes plans with proration and
+// applies subscription changes Stripe reports through webhooks to the
+// user's tier and quota.
+type BillingService struct {
+	db       *DatabaseService
+	config   BillingConfig
+	stripe   *stripeClient
+	accounts *AccountResolver // Optional; cached tiers expire on their own when nil
+	logger   *logrus.Logger
+}
+
+// NewBillingService creates a new billing service. accounts, when set, has
+// tier changes forgotten so they apply to the user's next request.
+func NewBillingService(db *DatabaseService, config BillingConfig, accounts *AccountResolver, logger *logrus.Logger) *BillingService {
+	return &BillingService{
+		db:     db,
+		config: config,
+		stripe: &stripeClient{
+			baseURL:   config.APIURL,
+			secretKey: config.SecretKey,
+			client:    &http.Client{Timeout: 15 * time.Second},
+		},
+		accounts: accounts,
+		logger:   logger,
+	}
+}
+
+// Enabled reports whether Stripe is configured
+func (s *BillingService) Enabled() bool {
+	return s.config.SecretKey != ""
+}
+
+// EnsureCustomer returns the user's Stripe customer ID, creating the
+// customer the first time. Creation is idempotent per user, so racing
+// calls end up with one customer.
+func (s *BillingService) EnsureCustomer(ctx context.Context, userID, email, name string) (string, error) {
+	if !s.Enabled() {
+		return "", ErrBillingDisabled
+	}
+
+	var customerID string
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT stripe_customer_id FROM billing.customers WHERE user_id = $1`, userID).Scan(&customerID)
+	if err == nil {
+		return customerID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to get billing customer: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("email", email)
+	if name != "" {
+		form.Set("name", name)
+	}
+	form.Set("metadata[user_id]", userID)
+	var customer struct {
+		ID string `json:"id"`
+	}
+	if err := s.stripe.post(ctx, "/v1/customers", form, "customer-"+userID, &customer); err != nil {
+		return "", fmt.Errorf("failed to create stripe customer: %w", err)
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO billing.customers (user_id, stripe_customer_id)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING`, userID, customer.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to record billing customer: %w", err)
+	}
+	return customer.ID, nil
+}
+
+// GetSubscription returns the user's billing state, nil when they have
+// never been a customer
+func (s *BillingService) GetSubscription(ctx context.Context, userID string) (*Subscription, error) {
+	var sub Subscription
+	var subscriptionID, priceID, status sql.NullString
+	var periodEnd sql.NullTime
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT stripe_customer_id, subscription_id, price_id, subscription_status,
+			   current_period_end, cancel_at_period_end
+		FROM billing.customers
+		WHERE user_id = $1`, userID,
+	).Scan(&sub.CustomerID, &subscriptionID, &priceID, &status, &periodEnd, &sub.CancelAtPeriodEnd)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+
+	sub.SubscriptionID = subscriptionID.String
+	sub.priceID = priceID.String
+	sub.Status = status.String
+	sub.Tier, _ = s.config.tierForPrice(priceID.String)
+	if periodEnd.Valid {
+		sub.CurrentPeriodEnd = &periodEnd.Time
+	}
+	return &sub, nil
+}
+
+// CreateCheckoutSession starts a Stripe Checkout for a subscription to
+// tier and returns the page to send the user to. The tier is granted by
+// the webhook once payment succeeds.
+func (s *BillingService) CreateCheckoutSession(ctx context.Context, userID, email, tier string) (string, error) {
+	if !s.Enabled() {
+		return "", ErrBillingDisabled
+	}
+	price, ok := s.config.Prices[tier]
+	if !ok {
+		return "", ErrUnknownPlan
+	}
+
+	customerID, err := s.EnsureCustomer(ctx, userID, email, "")
+	if err != nil {
+		return "", err
+	}
+	sub, err := s.GetSubscription(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if sub != nil && sub.live() {
+		return "", ErrAlreadySubscribed
+	}
+
+	form := url.Values{}
+	form.Set("mode", "subscription")
+	form.Set("customer", customerID)
+	form.Set("client_reference_id", userID)
+	form.Set("line_items[0][price]", price)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("subscription_data[metadata][user_id]", userID)
+	form.Set("success_url", s.config.SuccessURL)
+	form.Set("cancel_url", s.config.CancelURL)
+	var session struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := s.stripe.post(ctx, "/v1/checkout/sessions", form, "", &session); err != nil {
+		return "", fmt.Errorf("failed to create checkout session: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"tier":       tier,
+		"session_id": session.ID,
+	}).Info("Checkout session created")
+	return session.URL, nil
+}
+
+// ChangePlan moves a live subscription to tier. Upgrades are prorated and
+// invoiced straight away; downgrades are prorated as credit on the next
+// invoice. The tier itself changes when Stripe reports the update.
+func (s *BillingService) ChangePlan(ctx context.Context, userID, tier string) error {
+	if !s.Enabled() {
+		return ErrBillingDisabled
+	}
+	price, ok := s.config.Prices[tier]
+	if !ok {
+		return ErrUnknownPlan
+	}
+	sub, err := s.GetSubscription(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if sub == nil || !sub.live() {
+		return ErrNoSubscription
+	}
+	if sub.priceID == price {
+		return nil
+	}
+
+	var current struct {
+		Items struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		} `json:"items"`
+	}
+	if err := s.stripe.get(ctx, "/v1/subscriptions/"+url.PathEscape(sub.SubscriptionID), nil, &current); err != nil {
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if len(current.Items.Data) == 0 {
+		return fmt.Errorf("subscription %s has no items", sub.SubscriptionID)
+	}
+
+	proration := "create_prorations"
+	if tierRank[tier] > tierRank[sub.Tier] {
+		proration = "always_invoice"
+	}
+	form := url.Values{}
+	form.Set("items[0][id]", current.Items.Data[0].ID)
+	form.Set("items[0][price]", price)
+	form.Set("proration_behavior", proration)
+	form.Set("cancel_at_period_end", "false")
+	var updated struct {
+		ID string `json:"id"`
+	}
+	if err := s.stripe.post(ctx, "/v1/subscriptions/"+url.PathEscape(sub.SubscriptionID), form, "", &updated); err != nil {
+		return fmt.Errorf("failed to change plan: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":   userID,
+		"from_tier": sub.Tier,
+		"to_tier":   tier,
+		"proration": proration,
+	}).Info("Subscription plan changed")
+	return nil
+}
+
+// ListInvoices returns the user's most recent invoices, newest first
+func (s *BillingService) ListInvoices(ctx context.Context, userID string, limit int) ([]*Invoice, error) {
+	if !s.Enabled() {
+		return nil, ErrBillingDisabled
+	}
+	sub, err := s.GetSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if sub == nil {
+		return []*Invoice{}, nil
+	}
+
+	query := url.Values{}
+	query.Set("customer", sub.CustomerID)
+	query.Set("limit", strconv.Itoa(limit))
+	var list struct {
+		Data []struct {
+			ID               string `json:"id"`
+			Number           string `json:"number"`
+			Status           string `json:"status"`
+			Currency         string `json:"currency"`
+			AmountDue        int64  `json:"amount_due"`
+			AmountPaid       int64  `json:"amount_paid"`
+			Created          int64  `json:"created"`
+			PeriodStart      int64  `json:"period_start"`
+			PeriodEnd        int64  `json:"period_end"`
+			HostedInvoiceURL string `json:"hosted_invoice_url"`
+			InvoicePDF       string `json:"invoice_pdf"`
+		} `json:"data"`
+	}
+	if err := s.stripe.get(ctx, "/v1/invoices", query, &list); err != nil {
+		return nil, fmt.Errorf("failed to list invoices: %w", err)
+	}
+
+	invoices := make([]*Invoice, 0, len(list.Data))
+	for _, inv := range list.Data {
+		invoices = append(invoices, &Invoice{
+			ID:               inv.ID,
+			Number:           inv.Number,
+			Status:           inv.Status,
+			Currency:         inv.Currency,
+			AmountDue:        inv.AmountDue,
+			AmountPaid:       inv.AmountPaid,
+			Created:          time.Unix(inv.Created, 0).UTC(),
+			PeriodStart:      time.Unix(inv.PeriodStart, 0).UTC(),
+			PeriodEnd:        time.Unix(inv.PeriodEnd, 0).UTC(),
+			HostedInvoiceURL: inv.HostedInvoiceURL,
+			InvoicePDF:       inv.InvoicePDF,
+		})
+	}
+	return invoices, nil
+}
+
+// stripeEvent is the envelope of a webhook delivery
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// stripeSubscription is the part of a subscription object billing reads
+type stripeSubscription struct {
+	ID                string `json:"id"`
+	Customer          string `json:"customer"`
+	Status            string `json:"status"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end"`
+	CurrentPeriodEnd  int64  `json:"current_period_end"`
+	Items             struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// HandleWebhook verifies and applies a Stripe webhook delivery. Each event
+// is applied at most once, in the same transaction that records it, so a
+// failed delivery is retried by Stripe from scratch.
+func (s *BillingService) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	if s.config.WebhookSecret == "" {
+		return ErrBillingDisabled
+	}
+	if err := VerifyStripeSignature(payload, signature, s.config.WebhookSecret, time.Now()); err != nil {
+		return err
+	}
+
+	var event stripeEvent
+	if err := json.Unmarshal(payload, &event); err != nil || event.ID == "" {
+		return fmt.Errorf("%w: malformed event", ErrInvalidStripeSignature)
+	}
+	logger := s.logger.WithFields(logrus.Fields{
+		"event_id":   event.ID,
+		"event_type": event.Type,
+	})
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO billing.webhook_events (event_id, type)
+		VALUES ($1, $2)
+		ON CONFLICT (event_id) DO NOTHING`, event.ID, event.Type)
+	if err != nil {
+		return fmt.Errorf("failed to record webhook event: %w", err)
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		logger.Debug("Webhook event already processed")
+		return nil
+	}
+
+	var userID string
+	switch event.Type {
+	case "checkout.session.completed":
+		var session struct {
+			Customer     string `json:"customer"`
+			Subscription string `json:"subscription"`
+		}
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return fmt.Errorf("failed to decode checkout session: %w", err)
+		}
+		// The subscription events carry the status; this only links the
+		// subscription in case they arrive first
+		_, err = tx.ExecContext(ctx, `
+			UPDATE billing.customers
+			SET subscription_id = COALESCE(subscription_id, $2), updated_at = CURRENT_TIMESTAMP
+			WHERE stripe_customer_id = $1`, session.Customer, session.Subscription)
+		if err != nil {
+			return fmt.Errorf("failed to link subscription: %w", err)
+		}
+
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var sub stripeSubscription
+		if err := json.Unmarshal(event.Data.Object, &sub); err != nil {
+			return fmt.Errorf("failed to decode subscription: %w", err)
+		}
+		if event.Type == "customer.subscription.deleted" {
+			sub.Status = "canceled"
+		}
+		if userID, err = s.applySubscription(ctx, tx, sub, logger); err != nil {
+			return err
+		}
+
+	case "payment_method.attached":
+		var method struct {
+			Customer string `json:"customer"`
+			Card     *struct {
+				Fingerprint string `json:"fingerprint"`
+			} `json:"card"`
+		}
+		if err := json.Unmarshal(event.Data.Object, &method); err != nil {
+			return fmt.Errorf("failed to decode payment method: %w", err)
+		}
+		// The card's fingerprint is how duplicate accounts paying with the
+		// same card are found
+		if method.Card != nil && method.Card.Fingerprint != "" {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE billing.customers
+				SET payment_fingerprint = $2, updated_at = CURRENT_TIMESTAMP
+				WHERE stripe_customer_id = $1`, method.Customer, method.Card.Fingerprint)
+			if err != nil {
+				return fmt.Errorf("failed to record payment fingerprint: %w", err)
+			}
+		}
+
+	default:
+		logger.Debug("Ignoring webhook event")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit webhook event: %w", err)
+	}
+	if userID != "" && s.accounts != nil {
+		s.accounts.Forget(userID)
+	}
+	return nil
+}
+
+// applySubscription records a subscription's state and sets the owner's
+// tier and quota from it, returning the owner. Either ends a trial.
+// Subscriptions that lapsed return the user to the free tier; ones still
+// being set up leave the tier alone.
+func (s *BillingService) applySubscription(ctx context.Context, tx *sql.Tx, sub stripeSubscription, logger *logrus.Entry) (string, error) {
+	var priceID string
+	if len(sub.Items.Data) > 0 {
+		priceID = sub.Items.Data[0].Price.ID
+	}
+	var periodEnd *time.Time
+	if sub.CurrentPeriodEnd > 0 {
+		end := time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+		periodEnd = &end
+	}
+
+	var userID string
+	err := tx.QueryRowContext(ctx, `
+		UPDATE billing.customers
+		SET subscription_id = $2, price_id = NULLIF($3, ''), subscription_status = $4,
+			current_period_end = $5, cancel_at_period_end = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE stripe_customer_id = $1
+		RETURNING user_id`,
+		sub.Customer, sub.ID, priceID, sub.Status, periodEnd, sub.CancelAtPeriodEnd,
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Customers created outside the gateway have no user to upgrade
+		logger.WithField("customer", sub.Customer).Warn("Subscription for unknown customer")
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to record subscription: %w", err)
+	}
+
+	var tier string
+	switch sub.Status {
+	case "active", "trialing", "past_due":
+		var ok bool
+		if tier, ok = s.config.tierForPrice(priceID); !ok {
+			logger.WithField("price_id", priceID).Error("Subscription to an unknown price")
+			return userID, nil
+		}
+	case "canceled", "unpaid", "incomplete_expired":
+		tier = TierFree
+	default:
+		return userID, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET tier = $2, enhancement_quota = $3, trial_expires_at = NULL, trial_warned_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID, tier, TierQuota(tier)); err != nil {
+		return "", fmt.Errorf("failed to update tier: %w", err)
+	}
+	logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"tier":    tier,
+		"status":  sub.Status,
+	}).Info("Subscription applied")
+	return userID, nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func signStripePayload(payload, secret string, at time.Time) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", at.Unix(), payload)
+	return fmt.Sprintf("t=%d,v1=%s", at.Unix(), hex.EncodeToString(mac.Sum(nil)))
+}
+
+func TestVerifyStripeSignature(t *testing.T) {
+	now := time.Now()
+	payload := `{"id":"evt_1"}`
+	header := signStripePayload(payload, "whsec", now)
+
+	assert.NoError(t, VerifyStripeSignature([]byte(payload), header, "whsec", now))
+	assert.NoError(t, VerifyStripeSignature([]byte(payload), "v1=deadbeef,"+header, "whsec", now),
+		"any of the signatures may match while secrets are rolled")
+
+	assert.ErrorIs(t, VerifyStripeSignature([]byte(payload), header, "other", now), ErrInvalidStripeSignature)
+	assert.ErrorIs(t, VerifyStripeSignature([]byte(`{"id":"evt_2"}`), header, "whsec", now), ErrInvalidStripeSignature)
+	assert.ErrorIs(t, VerifyStripeSignature([]byte(payload), header, "whsec", now.Add(10*time.Minute)), ErrInvalidStripeSignature,
+		"old deliveries can't be replayed")
+	assert.ErrorIs(t, VerifyStripeSignature([]byte(payload), "", "whsec", now), ErrInvalidStripeSignature)
+}
+
+func newTestBillingService(t *testing.T, rowsAffected func(string) int64) (*BillingService, *recordingDriver) {
+	db, d := newRecordingDB(t, rowsAffected)
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		return []string{"user_id"}, [][]driver.Value{{"user-1"}}
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	return NewBillingService(NewDatabaseService(db.DB), BillingConfig{
+		SecretKey:     "sk_test",
+		WebhookSecret: "whsec",
+		Prices:        map[string]string{TierPro: "price_pro", TierEnterprise: "price_ent"},
+	}, nil, logger), d
+}
+
+func subscriptionEvent(id, eventType, status, price string) string {
+	return fmt.Sprintf(`{"id":%q,"type":%q,"data":{"object":{"id":"sub_1","customer":"cus_1","status":%q,`+
+		`"current_period_end":1772323200,"items":{"data":[{"price":{"id":%q}}]}}}}`, id, eventType, status, price)
+}
+
+func TestHandleWebhookAppliesSubscription(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		status    string
+		price     string
+		tier      string
+		quota     interface{}
+	}{
+		{"upgrade to pro", "customer.subscription.created", "active", "price_pro", TierPro, int64(2000)},
+		{"upgrade to enterprise", "customer.subscription.updated", "active", "price_ent", TierEnterprise, nil},
+		{"cancellation", "customer.subscription.deleted", "active", "price_pro", TierFree, int64(100)},
+		{"unpaid", "customer.subscription.updated", "unpaid", "price_pro", TierFree, int64(100)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			billing, d := newTestBillingService(t, func(string) int64 { return 1 })
+			payload := subscriptionEvent("evt_1", tt.eventType, tt.status, tt.price)
+
+			require.NoError(t, billing.HandleWebhook(context.Background(), []byte(payload), signStripePayload(payload, "whsec", time.Now())))
+
+			var found bool
+			for i, entry := range d.entries() {
+				if strings.HasPrefix(entry, "UPDATE auth.users SET tier = $2") {
+					found = true
+					args := d.args[i]
+					assert.Equal(t, "user-1", args[0].Value)
+					assert.Equal(t, tt.tier, args[1].Value)
+					assert.Equal(t, tt.quota, args[2].Value)
+					assert.Contains(t, entry, "trial_expires_at = NULL", "a subscription ends the trial")
+				}
+			}
+			assert.True(t, found, "the tier is updated")
+		})
+	}
+}
+
+func TestHandleWebhookSkipsProcessedEvents(t *testing.T) {
+	billing, d := newTestBillingService(t, func(query string) int64 {
+		if strings.Contains(query, "billing.webhook_events") {
+			return 0
+		}
+		return 1
+	})
+	payload := subscriptionEvent("evt_1", "customer.subscription.deleted", "canceled", "price_pro")
+
+	require.NoError(t, billing.HandleWebhook(context.Background(), []byte(payload), signStripePayload(payload, "whsec", time.Now())))
+	for _, entry := range d.entries() {
+		assert.NotContains(t, entry, "UPDATE", "a redelivered event is not applied again")
+	}
+
+	err := billing.HandleWebhook(context.Background(), []byte(payload), signStripePayload(payload, "other", time.Now()))
+	assert.ErrorIs(t, err, ErrInvalidStripeSignature)
+}
+
+func TestHandleWebhookRecordsPaymentFingerprint(t *testing.T) {
+	billing, d := newTestBillingService(t, func(string) int64 { return 1 })
+	payload := `{"id":"evt_2","type":"payment_method.attached","data":{"object":{"id":"pm_1","customer":"cus_1","card":{"fingerprint":"Xt5EWLLDS7FJjR1c"}}}}`
+
+	require.NoError(t, billing.HandleWebhook(context.Background(), []byte(payload), signStripePayload(payload, "whsec", time.Now())))
+
+	var found bool
+	for i, entry := range d.entries() {
+		if strings.HasPrefix(entry, "UPDATE billing.customers SET payment_fingerprint = $2") {
+			found = true
+			assert.Equal(t, "cus_1", d.args[i][0].Value)
+			assert.Equal(t, "Xt5EWLLDS7FJjR1c", d.args[i][1].Value)
+		}
+	}
+	assert.True(t, found, "the card's fingerprint is recorded")
+}
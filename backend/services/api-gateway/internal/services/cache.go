@@ -12,20 +12,63 @@ import (
 
 // CacheService wraps Redis client with application-specific methods
 type CacheService struct {
-	client *redis.Client
-	logger *logrus.Logger
-	prefix string
+	client     *redis.Client
+	logger     *logrus.Logger
+	prefix     string
+	region     string
+	replicator *sessionReplicator
+	counters   map[string]*cacheCounters
+
+	// compressAbove is the size from which cached results are stored
+	// compressed, zero to store them as is
+	compressAbove int
 }
 
 // NewCacheService creates a new cache service
 func NewCacheService(client *redis.Client, logger *logrus.Logger) *CacheService {
 	return &CacheService{
-		client: client,
-		logger: logger,
-		prefix: "betterprompts:",
+		client:        client,
+		logger:        logger,
+		prefix:        "betterprompts:",
+		counters:      newCacheCounters(),
+		compressAbove: CacheCompressionThreshold(),
 	}
 }
 
+// NewRegionalCacheService creates a cache service for a multi-region
+// deployment. Cached results are kept region-local while session writes are
+// replicated asynchronously to the peer regions in config.
+func NewRegionalCacheService(client *redis.Client, logger *logrus.Logger, config RegionConfig) *CacheService {
+	cache := NewCacheService(client, logger)
+	cache.region = config.Region
+	if config.Region != "" && len(config.Replicas) > 0 {
+		cache.replicator = newSessionReplicator(config, logger)
+	}
+	return cache
+}
+
+// Region returns the region identity of this cache, empty for single-region deployments
+func (c *CacheService) Region() string {
+	return c.region
+}
+
+// ReplicationStats reports session replication counters, or nil when replication is off
+func (c *CacheService) ReplicationStats() *ReplicationStats {
+	if c.replicator == nil {
+		return nil
+	}
+	stats := c.replicator.stats()
+	return &stats
+}
+
+// Close stops session replication and closes the Redis connection
+func (c *CacheService) Close() error {
+	if c.replicator != nil {
+		c.replicator.close()
+	}
+	return c.client.Close()
+}
+
 // Key generates a cache key with the service prefix
 func (c *CacheService) Key(parts ...string) string {
 	key := c.prefix
@@ -35,16 +78,34 @@ func (c *CacheService) Key(parts ...string) string {
 	return key[:len(key)-1] // Remove trailing colon
 }
 
+// LocalKey generates a key scoped to this region. Used for data that is
+// cheap to recompute and shouldn't be shared across regions. Limiter
+// counters use Key: a region segment would give callers a separate
+// allowance in every region.
+func (c *CacheService) LocalKey(parts ...string) string {
+	if c.region == "" {
+		return c.Key(parts...)
+	}
+	return c.Key(append([]string{c.region}, parts...)...)
+}
+
+// replicate forwards a session write to peer regions when replication is enabled
+func (c *CacheService) replicate(op replicationOp) {
+	if c.replicator != nil {
+		c.replicator.enqueue(op)
+	}
+}
+
 // CacheEnhancedPrompt caches an enhanced prompt result
 func (c *CacheService) CacheEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}, ttl time.Duration) error {
-	key := c.Key("enhanced", textHash, fmt.Sprintf("%v", techniques))
+	key := c.LocalKey("enhanced", textHash, fmt.Sprintf("%v", techniques))
 	
 	data, err := json.Marshal(result)
 	if err != nil {
 		return fmt.Errorf("failed to marshal result: %w", err)
 	}
 
-	err = c.client.Set(ctx, key, data, ttl).Err()
+	err = c.client.Set(ctx, key, encodeCacheValue(CacheNamespaceEnhancement, data, c.compressAbove), ttl).Err()
 	if err != nil {
 		c.logger.WithError(err).Warn("Failed to cache enhanced prompt")
 		return err
@@ -60,9 +121,10 @@ func (c *CacheService) CacheEnhancedPrompt(ctx context.Context, textHash string,
 
 // GetCachedEnhancedPrompt retrieves a cached enhanced prompt
 func (c *CacheService) GetCachedEnhancedPrompt(ctx context.Context, textHash string, techniques []string, result interface{}) error {
-	key := c.Key("enhanced", textHash, fmt.Sprintf("%v", techniques))
+	key := c.LocalKey("enhanced", textHash, fmt.Sprintf("%v", techniques))
 
 	data, err := c.client.Get(ctx, key).Bytes()
+	c.countLookup(CacheNamespaceEnhancement, err == nil)
 	if err != nil {
 		if err == redis.Nil {
 			return fmt.Errorf("cache miss")
@@ -70,6 +132,10 @@ func (c *CacheService) GetCachedEnhancedPrompt(ctx context.Context, textHash str
 		return fmt.Errorf("failed to get cached value: %w", err)
 	}
 
+	data, err = decodeCacheValue(CacheNamespaceEnhancement, data)
+	if err != nil {
+		return err
+	}
 	err = json.Unmarshal(data, result)
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal cached value: %w", err)
@@ -81,14 +147,14 @@ func (c *CacheService) GetCachedEnhancedPrompt(ctx context.Context, textHash str
 
 // CacheIntentClassification caches an intent classification result
 func (c *CacheService) CacheIntentClassification(ctx context.Context, textHash string, result *IntentClassificationResult, ttl time.Duration) error {
-	key := c.Key("intent", textHash)
+	key := c.LocalKey("intent", textHash)
 	
 	data, err := json.Marshal(result)
 	if err != nil {
 		return fmt.Errorf("failed to marshal result: %w", err)
 	}
 
-	err = c.client.Set(ctx, key, data, ttl).Err()
+	err = c.client.Set(ctx, key, encodeCacheValue(CacheNamespaceIntent, data, c.compressAbove), ttl).Err()
 	if err != nil {
 		c.logger.WithError(err).Warn("Failed to cache intent classification")
 		return err
@@ -99,9 +165,10 @@ func (c *CacheService) CacheIntentClassification(ctx context.Context, textHash s
 
 // GetCachedIntentClassification retrieves a cached intent classification
 func (c *CacheService) GetCachedIntentClassification(ctx context.Context, textHash string) (*IntentClassificationResult, error) {
-	key := c.Key("intent", textHash)
+	key := c.LocalKey("intent", textHash)
 
 	data, err := c.client.Get(ctx, key).Bytes()
+	c.countLookup(CacheNamespaceIntent, err == nil)
 	if err != nil {
 		if err == redis.Nil {
 			return nil, fmt.Errorf("cache miss")
@@ -109,6 +176,10 @@ func (c *CacheService) GetCachedIntentClassification(ctx context.Context, textHa
 		return nil, fmt.Errorf("failed to get cached value: %w", err)
 	}
 
+	data, err = decodeCacheValue(CacheNamespaceIntent, data)
+	if err != nil {
+		return nil, err
+	}
 	var result IntentClassificationResult
 	err = json.Unmarshal(data, &result)
 	if err != nil {
@@ -133,6 +204,8 @@ func (c *CacheService) StoreSession(ctx context.Context, sessionID string, data
 		return fmt.Errorf("failed to store session: %w", err)
 	}
 
+	c.replicate(replicationOp{kind: replicateSet, key: key, value: jsonData, ttl: ttl})
+
 	return nil
 }
 
@@ -141,6 +214,7 @@ func (c *CacheService) GetSession(ctx context.Context, sessionID string, data in
 	key := c.Key("session", sessionID)
 
 	jsonData, err := c.client.Get(ctx, key).Bytes()
+	c.countLookup(CacheNamespaceSession, err == nil)
 	if err != nil {
 		if err == redis.Nil {
 			return fmt.Errorf("session not found")
@@ -168,6 +242,8 @@ func (c *CacheService) ExtendSession(ctx context.Context, sessionID string, ttl
 	if !ok {
 		return fmt.Errorf("session not found")
 	}
+
+	c.replicate(replicationOp{kind: replicateExpire, key: key, ttl: ttl})
 	
 	return nil
 }
@@ -180,13 +256,41 @@ func (c *CacheService) DeleteSession(ctx context.Context, sessionID string) erro
 	if err != nil {
 		return fmt.Errorf("failed to delete session: %w", err)
 	}
+
+	c.replicate(replicationOp{kind: replicateDelete, key: key})
 	
 	return nil
 }
 
+// DeleteSessionsWithPrefix removes every session whose ID starts with
+// prefix followed by a separator, e.g. all of a user's refresh tokens
+func (c *CacheService) DeleteSessionsWithPrefix(ctx context.Context, prefix string) (int, error) {
+	iter := c.client.Scan(ctx, 0, c.Key("session", prefix, "*"), 0).Iterator()
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return 0, fmt.Errorf("failed to scan sessions: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if err := c.client.Del(ctx, keys...).Err(); err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	for _, key := range keys {
+		c.replicate(replicationOp{kind: replicateDelete, key: key})
+	}
+
+	return len(keys), nil
+}
+
 // RateLimitCheck checks if a user has exceeded the rate limit
 func (c *CacheService) RateLimitCheck(ctx context.Context, userID string, limit int, window time.Duration) (bool, int, error) {
-	key := c.Key("ratelimit", userID, fmt.Sprintf("%d", time.Now().Unix()/int64(window.Seconds())))
+	bucket, _ := rateLimitBucket(time.Now(), window)
+	key := c.Key("ratelimit", userID, fmt.Sprintf("%d", bucket))
 	
 	// Increment counter
 	count, err := c.client.Incr(ctx, key).Result()
@@ -207,6 +311,26 @@ func (c *CacheService) RateLimitCheck(ctx context.Context, userID string, limit
 	return true, limit - int(count), nil
 }
 
+// RateLimitStatus returns how many requests were counted against key in the
+// current window and when that window resets, without counting a request
+func (c *CacheService) RateLimitStatus(ctx context.Context, userID string, window time.Duration) (int, time.Time, error) {
+	bucket, resetAt := rateLimitBucket(time.Now(), window)
+	key := c.Key("ratelimit", userID, fmt.Sprintf("%d", bucket))
+
+	count, err := c.client.Get(ctx, key).Int()
+	if err != nil && err != redis.Nil {
+		return 0, resetAt, fmt.Errorf("failed to get rate limit counter: %w", err)
+	}
+	return count, resetAt, nil
+}
+
+// rateLimitBucket returns the fixed window containing now and when it ends
+func rateLimitBucket(now time.Time, window time.Duration) (int64, time.Time) {
+	seconds := int64(window.Seconds())
+	bucket := now.Unix() / seconds
+	return bucket, time.Unix((bucket+1)*seconds, 0).UTC()
+}
+
 // InvalidateUserCache invalidates all cache entries for a user
 func (c *CacheService) InvalidateUserCache(ctx context.Context, userID string) error {
 	pattern := c.Key("*", userID, "*")
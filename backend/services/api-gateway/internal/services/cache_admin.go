@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Cache namespaces as reported to admins. Keys outside these are counted
+// under their first key segment.
+const (
+	CacheNamespaceIntent      = "intent"
+	CacheNamespaceEnhancement = "enhancement"
+	CacheNamespaceSession     = "session"
+	CacheNamespaceRateLimit   = "rate-limit"
+	CacheNamespacePin         = "pin"
+)
+
+// cacheNamespaces maps the first segment of a key to its namespace
+var cacheNamespaces = map[string]string{
+	"intent":    CacheNamespaceIntent,
+	"enhanced":  CacheNamespaceEnhancement,
+	"session":   CacheNamespaceSession,
+	"ratelimit": CacheNamespaceRateLimit,
+	"pins":      CacheNamespacePin,
+}
+
+// maxCacheKeysPerPage caps one page of ListKeys
+const maxCacheKeysPerPage = 1000
+
+// ErrCacheKeyNotFound is returned for keys that don't exist or have expired
+var ErrCacheKeyNotFound = errors.New("cache key not found")
+
+// ErrCachePatternTooBroad is returned for eviction patterns that would
+// match every key; clearing the whole cache is a separate operation
+var ErrCachePatternTooBroad = errors.New("pattern must name a key prefix")
+
+// CacheEntry describes one cached key. TTL is in seconds, -1 when the key
+// never expires.
+type CacheEntry struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace"`
+	Type      string `json:"type"`
+	TTL       int64  `json:"ttl"`
+}
+
+// CacheEntryValue is a cached key with its payload. JSON payloads are
+// returned as JSON, anything else as a string. Compressed payloads are
+// shown decompressed; Size is what is stored.
+type CacheEntryValue struct {
+	CacheEntry
+	Size       int             `json:"size"`
+	Compressed bool            `json:"compressed,omitempty"`
+	Value      json.RawMessage `json:"value"`
+}
+
+// CacheNamespaceStats summarizes a cache namespace. Hits and misses count
+// lookups by this instance since it started.
+type CacheNamespaceStats struct {
+	Keys       int64   `json:"keys"`
+	Persistent int64   `json:"persistent"` // Keys without a TTL
+	Hits       int64   `json:"hits"`
+	Misses     int64   `json:"misses"`
+	HitRate    float64 `json:"hit_rate"`
+}
+
+// cacheCounters counts lookups in one namespace
+type cacheCounters struct {
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// newCacheCounters creates counters for the namespaces whose lookups are counted
+func newCacheCounters() map[string]*cacheCounters {
+	return map[string]*cacheCounters{
+		CacheNamespaceIntent:      {},
+		CacheNamespaceEnhancement: {},
+		CacheNamespaceSession:     {},
+		CacheNamespacePin:         {},
+	}
+}
+
+// countLookup records a cache hit or miss in a namespace
+func (c *CacheService) countLookup(namespace string, hit bool) {
+	counters, ok := c.counters[namespace]
+	if !ok {
+		return
+	}
+	if hit {
+		counters.hits.Add(1)
+	} else {
+		counters.misses.Add(1)
+	}
+}
+
+// adminKey returns the Redis key an admin refers to, which may omit the
+// service prefix. Keys outside the prefix belong to other applications and
+// are never touched.
+func (c *CacheService) adminKey(key string) string {
+	if strings.HasPrefix(key, c.prefix) {
+		return key
+	}
+	return c.prefix + key
+}
+
+// cacheNamespace returns the namespace of a full Redis key
+func (c *CacheService) cacheNamespace(key string) string {
+	rest := strings.TrimPrefix(key, c.prefix)
+	if c.region != "" {
+		rest = strings.TrimPrefix(rest, c.region+":")
+	}
+	segment, _, _ := strings.Cut(rest, ":")
+	if namespace, ok := cacheNamespaces[segment]; ok {
+		return namespace
+	}
+	return segment
+}
+
+// ListKeys returns a page of keys starting with prefix, with their TTLs.
+// Pass the returned cursor to get the next page; zero means there are no
+// more. Pages may be shorter than count and, as with any Redis SCAN, a key
+// changed during the listing may be missed or repeated.
+func (c *CacheService) ListKeys(ctx context.Context, prefix string, cursor uint64, count int64) ([]CacheEntry, uint64, error) {
+	if count <= 0 || count > maxCacheKeysPerPage {
+		count = maxCacheKeysPerPage
+	}
+
+	keys, next, err := c.client.Scan(ctx, cursor, c.adminKey(prefix)+"*", count).Result()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to scan keys: %w", err)
+	}
+	sort.Strings(keys)
+
+	pipe := c.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(keys))
+	types := make([]*redis.StatusCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.TTL(ctx, key)
+		types[i] = pipe.Type(ctx, key)
+	}
+	if len(keys) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, 0, fmt.Errorf("failed to describe keys: %w", err)
+		}
+	}
+
+	entries := make([]CacheEntry, 0, len(keys))
+	for i, key := range keys {
+		// Keys that expired since the scan are left out
+		if types[i].Val() == "none" {
+			continue
+		}
+		entries = append(entries, CacheEntry{
+			Key:       key,
+			Namespace: c.cacheNamespace(key),
+			Type:      types[i].Val(),
+			TTL:       ttlSeconds(ttls[i].Val()),
+		})
+	}
+	return entries, next, nil
+}
+
+// GetEntry returns a cached key and its payload. Only string values, which
+// is everything the gateway caches, carry a payload.
+func (c *CacheService) GetEntry(ctx context.Context, key string) (*CacheEntryValue, error) {
+	key = c.adminKey(key)
+
+	pipe := c.client.Pipeline()
+	keyType := pipe.Type(ctx, key)
+	ttl := pipe.TTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to describe key: %w", err)
+	}
+	if keyType.Val() == "none" {
+		return nil, ErrCacheKeyNotFound
+	}
+
+	entry := &CacheEntryValue{CacheEntry: CacheEntry{
+		Key:       key,
+		Namespace: c.cacheNamespace(key),
+		Type:      keyType.Val(),
+		TTL:       ttlSeconds(ttl.Val()),
+	}}
+	if entry.Type != "string" {
+		return entry, nil
+	}
+
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+	entry.Size = len(data)
+	entry.Compressed = isCompressedCacheValue(data)
+	if data, err = decodeCacheValue(entry.Namespace, data); err != nil {
+		return nil, err
+	}
+	entry.Value = cachePayload(data)
+	return entry, nil
+}
+
+// EvictKey deletes a single key, returning ErrCacheKeyNotFound if it didn't
+// exist. Sessions are evicted in the peer regions too.
+func (c *CacheService) EvictKey(ctx context.Context, key string) error {
+	key = c.adminKey(key)
+
+	deleted, err := c.client.Del(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	if deleted == 0 {
+		return ErrCacheKeyNotFound
+	}
+	if c.cacheNamespace(key) == CacheNamespaceSession {
+		c.replicate(replicationOp{kind: replicateDelete, key: key})
+	}
+	return nil
+}
+
+// EvictPattern deletes every key matching a Redis glob pattern and returns
+// how many were deleted. The pattern must start with a literal key prefix.
+func (c *CacheService) EvictPattern(ctx context.Context, pattern string) (int, error) {
+	literal := strings.TrimPrefix(pattern, c.prefix)
+	if i := strings.IndexAny(literal, `*?[\`); i >= 0 {
+		literal = literal[:i]
+	}
+	if literal == "" {
+		return 0, ErrCachePatternTooBroad
+	}
+
+	iter := c.client.Scan(ctx, 0, c.adminKey(pattern), maxCacheKeysPerPage).Iterator()
+	var batch []string
+	deleted := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := c.client.Del(ctx, batch...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to delete keys: %w", err)
+		}
+		deleted += int(n)
+		for _, key := range batch {
+			if c.cacheNamespace(key) == CacheNamespaceSession {
+				c.replicate(replicationOp{kind: replicateDelete, key: key})
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		batch = append(batch, iter.Val())
+		if len(batch) == maxCacheKeysPerPage {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, fmt.Errorf("failed to scan keys: %w", err)
+	}
+	if err := flush(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
+
+// NamespaceStats counts the keys in each namespace and reports this
+// instance's hit rates. It scans the whole keyspace, so it is meant for
+// occasional admin use only.
+func (c *CacheService) NamespaceStats(ctx context.Context) (map[string]*CacheNamespaceStats, error) {
+	stats := make(map[string]*CacheNamespaceStats)
+	for _, namespace := range cacheNamespaces {
+		stats[namespace] = &CacheNamespaceStats{}
+	}
+
+	iter := c.client.Scan(ctx, 0, c.prefix+"*", maxCacheKeysPerPage).Iterator()
+	var keys []string
+	count := func() error {
+		pipe := c.client.Pipeline()
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			ttls[i] = pipe.TTL(ctx, key)
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to get key TTLs: %w", err)
+		}
+		for i, key := range keys {
+			namespace := c.cacheNamespace(key)
+			if stats[namespace] == nil {
+				stats[namespace] = &CacheNamespaceStats{}
+			}
+			stats[namespace].Keys++
+			if ttls[i].Val() == -1 {
+				stats[namespace].Persistent++
+			}
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == maxCacheKeysPerPage {
+			if err := count(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan keys: %w", err)
+	}
+	if len(keys) > 0 {
+		if err := count(); err != nil {
+			return nil, err
+		}
+	}
+
+	for namespace, counters := range c.counters {
+		s := stats[namespace]
+		s.Hits, s.Misses = counters.hits.Load(), counters.misses.Load()
+		if lookups := s.Hits + s.Misses; lookups > 0 {
+			s.HitRate = float64(s.Hits) / float64(lookups)
+		}
+	}
+	return stats, nil
+}
+
+// ttlSeconds converts a Redis TTL reply to seconds, -1 for keys without one
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return -1
+	}
+	return int64(ttl / time.Second)
+}
+
+// cachePayload returns data as JSON, quoting it when it isn't JSON already
+func cachePayload(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return data
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCacheAdminKeys(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	cache := NewRegionalCacheService(client, logrus.New(), RegionConfig{Region: "eu-west"})
+
+	// Admins may leave out the service prefix
+	assert.Equal(t, "betterprompts:intent:abc", cache.adminKey("intent:abc"))
+	assert.Equal(t, "betterprompts:intent:abc", cache.adminKey("betterprompts:intent:abc"))
+
+	for key, namespace := range map[string]string{
+		cache.LocalKey("intent", "abc"):            CacheNamespaceIntent,
+		cache.LocalKey("enhanced", "abc", "[cot]"): CacheNamespaceEnhancement,
+		cache.Key("session", "s1"):                 CacheNamespaceSession,
+		cache.Key("ratelimit", "user:1", "42"):     CacheNamespaceRateLimit,
+		cache.Key("pins", "p1", "2"):               CacheNamespacePin,
+		cache.Key("abuse", "restriction", "u1"):    "abuse",
+	} {
+		assert.Equal(t, namespace, cache.cacheNamespace(key), key)
+	}
+}
+
+func TestEvictPatternRejectsBroadPatterns(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	cache := NewCacheService(client, logrus.New())
+
+	for _, pattern := range []string{"*", "betterprompts:*", "?ntent:*", "[a-z]*"} {
+		_, err := cache.EvictPattern(context.Background(), pattern)
+		assert.ErrorIs(t, err, ErrCachePatternTooBroad, pattern)
+	}
+}
+
+func TestCacheLookupCounters(t *testing.T) {
+	cache := NewCacheService(nil, logrus.New())
+	cache.countLookup(CacheNamespaceIntent, true)
+	cache.countLookup(CacheNamespaceIntent, true)
+	cache.countLookup(CacheNamespaceIntent, false)
+	// Namespaces without counters are ignored
+	cache.countLookup(CacheNamespaceRateLimit, true)
+
+	assert.Equal(t, int64(2), cache.counters[CacheNamespaceIntent].hits.Load())
+	assert.Equal(t, int64(1), cache.counters[CacheNamespaceIntent].misses.Load())
+}
+
+func TestCachePayload(t *testing.T) {
+	assert.JSONEq(t, `{"intent":"reasoning"}`, string(cachePayload([]byte(`{"intent":"reasoning"}`))))
+	assert.Equal(t, `"17"`, string(cachePayload([]byte(`"17"`))))
+	assert.Equal(t, `"not json"`, string(cachePayload([]byte("not json"))))
+
+	assert.Equal(t, int64(-1), ttlSeconds(-1))
+	assert.Equal(t, int64(90), ttlSeconds(90*time.Second+500*time.Millisecond))
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// cacheFormatZstd prefixes zstd-compressed cache values. Uncompressed
+	// values are stored as plain JSON, which never starts with a control
+	// byte, so values written before compression existed still read back.
+	cacheFormatZstd byte = 0x01
+
+	// defaultCacheCompressionThreshold is the smallest value compressed when
+	// CACHE_COMPRESSION_THRESHOLD isn't set
+	defaultCacheCompressionThreshold = 4096
+
+	// cacheDecompressLimit bounds a decompressed value, so a corrupt or
+	// hostile entry can't exhaust memory
+	cacheDecompressLimit = 64 << 20
+)
+
+var (
+	cacheCompressionRatio = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_cache_compression_ratio",
+		Help:    "Uncompressed over compressed size of compressed cache values",
+		Buckets: []float64{1, 1.5, 2, 3, 4, 6, 8, 12, 16},
+	}, []string{"namespace"})
+
+	cacheCompressionSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_cache_compression_duration_seconds",
+		Help:    "Time spent compressing and decompressing cache values",
+		Buckets: []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05},
+	}, []string{"namespace", "operation"})
+)
+
+// The encoder and decoder are safe for concurrent EncodeAll and DecodeAll
+// calls; options are fixed, so construction can't fail
+var (
+	cacheEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	cacheDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(cacheDecompressLimit))
+)
+
+// CacheCompressionThreshold returns the size in bytes from which cached
+// results are compressed, from CACHE_COMPRESSION_THRESHOLD. Zero or less
+// turns compression off.
+func CacheCompressionThreshold() int {
+	if v, err := strconv.Atoi(os.Getenv("CACHE_COMPRESSION_THRESHOLD")); err == nil {
+		return v
+	}
+	return defaultCacheCompressionThreshold
+}
+
+// encodeCacheValue compresses data with zstd behind a format byte when it is
+// at least threshold bytes and compressing saves space, and returns it
+// unchanged otherwise
+func encodeCacheValue(namespace string, data []byte, threshold int) []byte {
+	if threshold <= 0 || len(data) < threshold {
+		return data
+	}
+
+	start := time.Now()
+	compressed := cacheEncoder.EncodeAll(data, append(make([]byte, 0, len(data)/2), cacheFormatZstd))
+	cacheCompressionSeconds.WithLabelValues(namespace, "compress").Observe(time.Since(start).Seconds())
+	if len(compressed) >= len(data) {
+		return data
+	}
+	cacheCompressionRatio.WithLabelValues(namespace).Observe(float64(len(data)) / float64(len(compressed)))
+	return compressed
+}
+
+// decodeCacheValue returns the plain value of a cache entry, compressed or not
+func decodeCacheValue(namespace string, data []byte) ([]byte, error) {
+	if !isCompressedCacheValue(data) {
+		return data, nil
+	}
+
+	start := time.Now()
+	plain, err := cacheDecoder.DecodeAll(data[1:], nil)
+	cacheCompressionSeconds.WithLabelValues(namespace, "decompress").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress cached value: %w", err)
+	}
+	return plain, nil
+}
+
+// isCompressedCacheValue reports whether a stored value is compressed
+func isCompressedCacheValue(data []byte) bool {
+	return len(data) > 0 && data[0] == cacheFormatZstd
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheValueCompression(t *testing.T) {
+	response, err := json.Marshal(map[string]interface{}{
+		"enhanced_text": strings.Repeat("Think step by step about the problem before answering. ", 200),
+		"techniques":    []string{"chain_of_thought", "few_shot"},
+	})
+	require.NoError(t, err)
+
+	stored := encodeCacheValue(CacheNamespaceEnhancement, response, 1024)
+	assert.True(t, isCompressedCacheValue(stored))
+	assert.Less(t, len(stored), len(response)/4)
+
+	plain, err := decodeCacheValue(CacheNamespaceEnhancement, stored)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(response), string(plain))
+
+	t.Run("small values are stored as is", func(t *testing.T) {
+		small := []byte(`{"intent":"reasoning","confidence":0.9}`)
+		assert.Equal(t, small, encodeCacheValue(CacheNamespaceIntent, small, 1024))
+	})
+
+	t.Run("compression can be turned off", func(t *testing.T) {
+		assert.Equal(t, response, encodeCacheValue(CacheNamespaceEnhancement, response, 0))
+	})
+
+	t.Run("incompressible values are stored as is", func(t *testing.T) {
+		random := make([]byte, 4096)
+		_, err := rand.Read(random)
+		require.NoError(t, err)
+		assert.Equal(t, random, encodeCacheValue(CacheNamespaceEnhancement, random, 1024))
+	})
+
+	t.Run("values cached before compression still read", func(t *testing.T) {
+		for _, legacy := range []string{`{"enhanced_text":"x"}`, `  {"a":1}`, `[1,2]`, `"17"`, ``} {
+			plain, err := decodeCacheValue(CacheNamespaceEnhancement, []byte(legacy))
+			require.NoError(t, err)
+			assert.Equal(t, legacy, string(plain))
+		}
+	})
+
+	t.Run("corrupt values fail", func(t *testing.T) {
+		corrupt := append([]byte{}, stored[:len(stored)/2]...)
+		_, err := decodeCacheValue(CacheNamespaceEnhancement, corrupt)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitBucket(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 42, 0, time.UTC)
+
+	bucket, resetAt := rateLimitBucket(now, time.Minute)
+	assert.Equal(t, now.Unix()/60, bucket)
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), resetAt)
+
+	// Every instant in the window shares its bucket and reset time
+	later, laterReset := rateLimitBucket(now.Add(17*time.Second), time.Minute)
+	assert.Equal(t, bucket, later)
+	assert.Equal(t, resetAt, laterReset)
+
+	next, _ := rateLimitBucket(resetAt, time.Minute)
+	assert.Equal(t, bucket+1, next)
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Prompt generator variants a request can be routed to
+const (
+	GeneratorVariantStable = "stable"
+	GeneratorVariantCanary = "canary"
+)
+
+// CanaryConfig routes a share of prompt generation traffic to a second
+// generator deployment
+type CanaryConfig struct {
+	URL     string
+	Percent int // 0-100
+}
+
+// Enabled reports whether any traffic goes to the canary
+func (c CanaryConfig) Enabled() bool {
+	return c.URL != "" && c.Percent > 0
+}
+
+// LoadCanaryConfig reads PROMPT_GENERATOR_CANARY_URL and
+// PROMPT_GENERATOR_CANARY_PERCENT, clamping the percentage to 0-100
+func LoadCanaryConfig() CanaryConfig {
+	config := CanaryConfig{
+		URL: strings.TrimRight(strings.TrimSpace(os.Getenv("PROMPT_GENERATOR_CANARY_URL")), "/"),
+	}
+	if percent, err := strconv.Atoi(os.Getenv("PROMPT_GENERATOR_CANARY_PERCENT")); err == nil {
+		config.Percent = percent
+	}
+	if config.Percent < 0 {
+		config.Percent = 0
+	}
+	if config.Percent > 100 {
+		config.Percent = 100
+	}
+	return config
+}
+
+// canaryBucket maps a routing key onto 0-99 so a key always lands in the same variant
+func canaryBucket(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % 100)
+}
+
+type routingKeyContextKey struct{}
+
+// WithRoutingKey sets the key, usually the user ID, that keeps a caller on
+// the same generator variant across requests
+func WithRoutingKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, routingKeyContextKey{}, key)
+}
+
+func routingKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(routingKeyContextKey{}).(string)
+	return key
+}
+
+// GeneratorVariantStats reports traffic and health of one generator variant
+type GeneratorVariantStats struct {
+	URL          string  `json:"url"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// CanaryStats compares the stable and canary prompt generators
+type CanaryStats struct {
+	Percent  int                              `json:"percent"`
+	Variants map[string]GeneratorVariantStats `json:"variants"`
+}
+
+// variantCounters accumulates request outcomes for one variant
+type variantCounters struct {
+	requests      int64
+	errors        int64
+	latencyMicros int64
+}
+
+func (v *variantCounters) record(elapsed time.Duration, err error) {
+	atomic.AddInt64(&v.requests, 1)
+	atomic.AddInt64(&v.latencyMicros, elapsed.Microseconds())
+	if err != nil {
+		atomic.AddInt64(&v.errors, 1)
+	}
+}
+
+func (v *variantCounters) stats(url string) GeneratorVariantStats {
+	stats := GeneratorVariantStats{
+		URL:      url,
+		Requests: atomic.LoadInt64(&v.requests),
+		Errors:   atomic.LoadInt64(&v.errors),
+	}
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(stats.Errors) / float64(stats.Requests)
+		stats.AvgLatencyMs = float64(atomic.LoadInt64(&v.latencyMicros)) / float64(stats.Requests) / 1000
+	}
+	return stats
+}
+
+// Variant returns the generator variant serving the given routing key
+func (c *PromptGeneratorClient) Variant(key string) string {
+	if c.canary.Enabled() && canaryBucket(key) < c.canary.Percent {
+		return GeneratorVariantCanary
+	}
+	return GeneratorVariantStable
+}
+
+// CanaryStats reports per-variant traffic, or nil when no canary is configured
+func (c *PromptGeneratorClient) CanaryStats() *CanaryStats {
+	if !c.canary.Enabled() {
+		return nil
+	}
+	return &CanaryStats{
+		Percent: c.canary.Percent,
+		Variants: map[string]GeneratorVariantStats{
+			GeneratorVariantStable: c.stableStats.stats(c.baseURL),
+			GeneratorVariantCanary: c.canaryStats.stats(c.canary.URL),
+		},
+	}
+}
+
+// GeneratorVariant returns the prompt generator variant serving the routing
+// key, empty when the configured generator doesn't split traffic
+func (s *ServiceClients) GeneratorVariant(key string) string {
+	if generator, ok := s.PromptGenerator.(interface{ Variant(string) string }); ok {
+		return generator.Variant(key)
+	}
+	return ""
+}
+
+// GeneratorCanaryStats reports prompt generator canary traffic, or nil when
+// no canary is configured
+func (s *ServiceClients) GeneratorCanaryStats() *CanaryStats {
+	if generator, ok := s.PromptGenerator.(interface{ CanaryStats() *CanaryStats }); ok {
+		return generator.CanaryStats()
+	}
+	return nil
+}
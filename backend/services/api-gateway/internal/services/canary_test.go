@@ -0,0 +1,93 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCanaryConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		percent  string
+		expected CanaryConfig
+		enabled  bool
+	}{
+		{"unset", "", "", CanaryConfig{}, false},
+		{"url without percent", "http://canary:8003", "", CanaryConfig{URL: "http://canary:8003"}, false},
+		{"configured", "http://canary:8003/", "10", CanaryConfig{URL: "http://canary:8003", Percent: 10}, true},
+		{"clamped high", "http://canary:8003", "150", CanaryConfig{URL: "http://canary:8003", Percent: 100}, true},
+		{"clamped low", "http://canary:8003", "-5", CanaryConfig{URL: "http://canary:8003"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROMPT_GENERATOR_CANARY_URL", tt.url)
+			t.Setenv("PROMPT_GENERATOR_CANARY_PERCENT", tt.percent)
+
+			config := LoadCanaryConfig()
+			assert.Equal(t, tt.expected, config)
+			assert.Equal(t, tt.enabled, config.Enabled())
+		})
+	}
+}
+
+func TestPromptGeneratorClientVariant(t *testing.T) {
+	client := &PromptGeneratorClient{canary: CanaryConfig{URL: "http://canary", Percent: 20}}
+
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		variant := client.Variant(key)
+		assert.Equal(t, variant, client.Variant(key), "routing must be sticky")
+		if variant == GeneratorVariantCanary {
+			canary++
+		}
+	}
+	assert.InDelta(t, 200, canary, 60)
+
+	disabled := &PromptGeneratorClient{canary: CanaryConfig{Percent: 100}}
+	assert.Equal(t, GeneratorVariantStable, disabled.Variant("user-1"))
+}
+
+func TestPromptGeneratorClientCanaryRouting(t *testing.T) {
+	newServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"text":"enhanced","model_version":"v1"}`))
+		}))
+	}
+	stable := newServer(http.StatusOK)
+	defer stable.Close()
+	canary := newServer(http.StatusInternalServerError)
+	defer canary.Close()
+
+	client := &PromptGeneratorClient{
+		baseURL: stable.URL,
+		client:  http.DefaultClient,
+		canary:  CanaryConfig{URL: canary.URL, Percent: 100},
+	}
+
+	_, err := client.GeneratePrompt(WithRoutingKey(context.Background(), "user-1"), models.PromptGenerationRequest{Text: "hi"})
+	assert.Error(t, err)
+
+	client.canary.Percent = 0
+	_, err = client.GeneratePrompt(context.Background(), models.PromptGenerationRequest{Text: "hi"})
+	assert.NoError(t, err)
+
+	client.canary.Percent = 50
+	stats := client.CanaryStats()
+	require.NotNil(t, stats)
+	assert.Equal(t, 50, stats.Percent)
+	assert.Equal(t, int64(1), stats.Variants[GeneratorVariantCanary].Requests)
+	assert.Equal(t, 1.0, stats.Variants[GeneratorVariantCanary].ErrorRate)
+	assert.Equal(t, int64(1), stats.Variants[GeneratorVariantStable].Requests)
+	assert.Equal(t, 0.0, stats.Variants[GeneratorVariantStable].ErrorRate)
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Changelog entry categories
+const (
+	ChangelogCategoryFeature     = "feature"
+	ChangelogCategoryImprovement = "improvement"
+	ChangelogCategoryFix         = "fix"
+)
+
+// ErrChangelogEntryNotFound is returned for unknown changelog entries, and
+// for drafts outside the admin API
+var ErrChangelogEntryNotFound = errors.New("changelog entry not found")
+
+// ChangelogEntry is one release note. Features lists the tracked features
+// the release introduced, tying their adoption to it.
+type ChangelogEntry struct {
+	ID          string     `json:"id"`
+	Version     string     `json:"version,omitempty"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body"`
+	Category    string     `json:"category"`
+	Features    []string   `json:"features"`
+	PublishedAt *time.Time `json:"published_at"` // Nil while a draft
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// NormalizeChangelogEntry trims an entry and checks it is valid
+func NormalizeChangelogEntry(entry *ChangelogEntry) error {
+	entry.Version = strings.TrimSpace(entry.Version)
+	entry.Title = strings.TrimSpace(entry.Title)
+	entry.Body = strings.TrimSpace(entry.Body)
+	switch entry.Category {
+	case ChangelogCategoryFeature, ChangelogCategoryImprovement, ChangelogCategoryFix:
+	default:
+		return fmt.Errorf("category must be %s, %s or %s", ChangelogCategoryFeature, ChangelogCategoryImprovement, ChangelogCategoryFix)
+	}
+	if entry.Title == "" {
+		return errors.New("title is required")
+	}
+
+	features := []string{}
+	for _, feature := range entry.Features {
+		feature = strings.ToLower(strings.TrimSpace(feature))
+		if !slices.Contains(TrackedFeatures, feature) {
+			return fmt.Errorf("unknown feature %q", feature)
+		}
+		if !slices.Contains(features, feature) {
+			features = append(features, feature)
+		}
+	}
+	entry.Features = features
+	return nil
+}
+
+// ChangelogService manages the release notes shown in the product
+type ChangelogService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewChangelogService creates a new changelog service
+func NewChangelogService(db *DatabaseService, logger *logrus.Logger) *ChangelogService {
+	return &ChangelogService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const changelogColumns = `id, version, title, body, category, features, published_at,
+	COALESCE(created_by::text, ''), created_at, updated_at`
+
+func scanChangelogEntry(row rowScanner) (*ChangelogEntry, error) {
+	var entry ChangelogEntry
+	var publishedAt sql.NullTime
+	if err := row.Scan(&entry.ID, &entry.Version, &entry.Title, &entry.Body, &entry.Category,
+		pq.Array(&entry.Features), &publishedAt, &entry.CreatedBy, &entry.CreatedAt, &entry.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if entry.Features == nil {
+		entry.Features = []string{}
+	}
+	if publishedAt.Valid {
+		entry.PublishedAt = &publishedAt.Time
+	}
+	return &entry, nil
+}
+
+// Published returns the published entries, newest first, published before
+// the given time when it isn't zero so clients can page back
+func (s *ChangelogService) Published(ctx context.Context, before time.Time, limit int) ([]*ChangelogEntry, error) {
+	if before.IsZero() {
+		before = time.Now()
+	}
+	return s.list(ctx, `
+		SELECT `+changelogColumns+`
+		FROM messaging.changelog_entries
+		WHERE published_at IS NOT NULL AND published_at <= CURRENT_TIMESTAMP AND published_at < $1
+		ORDER BY published_at DESC
+		LIMIT $2`, before, limit)
+}
+
+// ListEntries returns every entry for admins, drafts first, then newest
+// first
+func (s *ChangelogService) ListEntries(ctx context.Context) ([]*ChangelogEntry, error) {
+	return s.list(ctx, `
+		SELECT `+changelogColumns+`
+		FROM messaging.changelog_entries
+		ORDER BY published_at DESC NULLS FIRST, created_at DESC`)
+}
+
+func (s *ChangelogService) list(ctx context.Context, query string, args ...interface{}) ([]*ChangelogEntry, error) {
+	rows, err := s.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changelog: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []*ChangelogEntry{}
+	for rows.Next() {
+		entry, err := scanChangelogEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan changelog entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate changelog: %w", err)
+	}
+	return entries, nil
+}
+
+// GetEntry returns one entry, drafts included
+func (s *ChangelogService) GetEntry(ctx context.Context, id string) (*ChangelogEntry, error) {
+	entry, err := scanChangelogEntry(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+changelogColumns+`
+		FROM messaging.changelog_entries
+		WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChangelogEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changelog entry: %w", err)
+	}
+	return entry, nil
+}
+
+// CreateEntry stores a new entry. It must have been normalized.
+func (s *ChangelogService) CreateEntry(ctx context.Context, entry ChangelogEntry, adminID string) (*ChangelogEntry, error) {
+	created, err := scanChangelogEntry(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO messaging.changelog_entries (
+			version, title, body, category, features, published_at, created_by
+		) VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)
+		RETURNING `+changelogColumns,
+		entry.Version, entry.Title, entry.Body, entry.Category, pq.Array(entry.Features), entry.PublishedAt, adminID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create changelog entry: %w", err)
+	}
+
+	s.changed(created, adminID, "Changelog entry created")
+	return created, nil
+}
+
+// UpdateEntry replaces an entry. It must have been normalized.
+func (s *ChangelogService) UpdateEntry(ctx context.Context, id string, entry ChangelogEntry, adminID string) (*ChangelogEntry, error) {
+	updated, err := scanChangelogEntry(s.db.DB.QueryRowContext(ctx, `
+		UPDATE messaging.changelog_entries
+		SET version = $2, title = $3, body = $4, category = $5, features = $6, published_at = $7,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+changelogColumns,
+		id, entry.Version, entry.Title, entry.Body, entry.Category, pq.Array(entry.Features), entry.PublishedAt,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChangelogEntryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update changelog entry: %w", err)
+	}
+
+	s.changed(updated, adminID, "Changelog entry updated")
+	return updated, nil
+}
+
+// DeleteEntry removes an entry
+func (s *ChangelogService) DeleteEntry(ctx context.Context, id, adminID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM messaging.changelog_entries WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete changelog entry: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrChangelogEntryNotFound
+	}
+
+	s.changed(&ChangelogEntry{ID: id}, adminID, "Changelog entry deleted")
+	return nil
+}
+
+func (s *ChangelogService) changed(entry *ChangelogEntry, adminID, message string) {
+	s.logger.WithFields(logrus.Fields{
+		"audit":        true,
+		"updated_by":   adminID,
+		"entry_id":     entry.ID,
+		"version":      entry.Version,
+		"published_at": entry.PublishedAt,
+	}).Info(message)
+}
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeChangelogEntry(t *testing.T) {
+	entry := ChangelogEntry{
+		Version:  " 2.4.0 ",
+		Title:    " Saved profiles ",
+		Category: ChangelogCategoryFeature,
+		Features: []string{" Profiles", "profiles"},
+	}
+	require.NoError(t, NormalizeChangelogEntry(&entry))
+	assert.Equal(t, "2.4.0", entry.Version)
+	assert.Equal(t, "Saved profiles", entry.Title)
+	assert.Equal(t, []string{FeatureProfiles}, entry.Features)
+
+	for _, invalid := range []ChangelogEntry{
+		{Title: "Release", Category: "breaking"},
+		{Title: " ", Category: ChangelogCategoryFix},
+		{Title: "Release", Category: ChangelogCategoryFeature, Features: []string{"teleportation"}},
+	} {
+		assert.Error(t, NormalizeChangelogEntry(&invalid), invalid.Title)
+	}
+}
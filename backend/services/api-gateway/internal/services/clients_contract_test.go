@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/contract"
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var updateContracts = flag.Bool("update", false, "rewrite the consumer contracts in tests/contracts/pacts")
+
+// pactDir is where consumer contracts are committed for providers to verify
+func pactDir() string {
+	if dir := os.Getenv("PACT_DIR"); dir != "" {
+		return dir
+	}
+	return "../../../../../tests/contracts/pacts"
+}
+
+// Values the gateway branches on, so the providers can't change them
+// without the contract failing
+const (
+	complexityPattern  = `^(simple|moderate|complex)$`
+	techniqueIDPattern = `^(chain_of_thought|tree_of_thoughts|few_shot|zero_shot|self_consistency|constitutional_ai|iterative_refinement|role_based|structured_output|metacognitive)$`
+)
+
+var jsonHeaders = map[string]string{"Content-Type": "application/json"}
+
+// contractCase exercises a client against one interaction
+type contractCase struct {
+	interaction contract.Interaction
+	call        func(t *testing.T, baseURL string)
+}
+
+// runContract runs each case against a mock provider and syncs the pact
+func runContract(t *testing.T, provider string, cases []contractCase) {
+	pact := contract.NewPact(provider)
+	for _, tc := range cases {
+		t.Run(tc.interaction.Description, func(t *testing.T) {
+			mock := contract.NewMockProvider(tc.interaction)
+			defer mock.Close()
+
+			tc.call(t, mock.URL)
+			assert.Empty(t, mock.Mismatches())
+		})
+		pact.AddInteraction(tc.interaction)
+	}
+
+	require.NoError(t, pact.Sync(pactDir(), *updateContracts))
+}
+
+func TestIntentClassifierContract(t *testing.T) {
+	runContract(t, "intent-classifier", []contractCase{{
+		interaction: contract.Interaction{
+			Description:   "a classification of a code generation prompt",
+			ProviderState: "the classifier model is loaded",
+			Request: contract.Request{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/intents/classify",
+				Headers: map[string]string{"Content-Type": "application/json", "Accept": "application/json; version=1"},
+				Body:    contract.Body(map[string]string{"text": "Write a Python function that sorts users by signup date"}),
+			},
+			Response: contract.Response{
+				Status: http.StatusOK,
+				Body: contract.Body(IntentClassificationResult{
+					Intent:              "code_generation",
+					Confidence:          0.92,
+					Complexity:          "moderate",
+					SuggestedTechniques: []string{"chain_of_thought", "few_shot"},
+					Metadata:            map[string]interface{}{"classifier": "distilbert"},
+				}),
+				MatchingRules: map[string]contract.Rule{
+					"$.body.complexity":              {Match: "regex", Regex: complexityPattern},
+					"$.body.suggested_techniques":    {Match: "type", Min: 0},
+					"$.body.suggested_techniques[*]": {Match: "regex", Regex: techniqueIDPattern},
+				},
+			},
+		},
+		call: func(t *testing.T, baseURL string) {
+			client := &IntentClassifierClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}, schemas: strictSchemas(t)}
+			result, err := client.ClassifyIntent(context.Background(), "Write a Python function that sorts users by signup date")
+			require.NoError(t, err)
+			assert.Equal(t, "code_generation", result.Intent)
+			assert.Equal(t, "moderate", result.Complexity)
+			assert.Equal(t, []string{"chain_of_thought", "few_shot"}, result.SuggestedTechniques)
+			assert.Equal(t, "distilbert", result.Metadata["classifier"])
+		},
+	}})
+}
+
+func TestTechniqueSelectorContract(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	newClient := func(baseURL string) *TechniqueSelectorClient {
+		return &TechniqueSelectorClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}, logger: logger, schemas: strictSchemas(t)}
+	}
+
+	runContract(t, "technique-selector", []contractCase{
+		{
+			interaction: contract.Interaction{
+				Description:   "a technique selection for a problem solving prompt",
+				ProviderState: "the default rules are loaded",
+				Request: contract.Request{
+					Method:  http.MethodPost,
+					Path:    "/api/v1/select",
+					Headers: jsonHeaders,
+					Body: contract.Body(TechniqueSelectionRequest{
+						Text:       "Explain step by step how to solve a recursion that overflows the stack",
+						Intent:     "problem_solving",
+						Complexity: "complex",
+					}),
+					MatchingRules: map[string]contract.Rule{
+						"$.body.complexity": {Match: "regex", Regex: complexityPattern},
+					},
+				},
+				Response: contract.Response{
+					Status: http.StatusOK,
+					Body: contract.Body(TechniqueSelectionResponse{
+						Techniques: []SelectedTechnique{{
+							ID:          "chain_of_thought",
+							Name:        "Chain of Thought",
+							Description: "Step-by-step reasoning that breaks down complex problems",
+							Priority:    5,
+							Score:       7.5,
+							Confidence:  0.85,
+							Reasoning:   "Selected for problem_solving intent",
+						}},
+						PrimaryTechnique: "chain_of_thought",
+						Confidence:       0.85,
+						Reasoning:        "Selected 1 technique",
+						Metadata:         map[string]interface{}{"rules_version": "3f9a1c2b4d5e6f70"},
+					}),
+					MatchingRules: map[string]contract.Rule{
+						"$.body.techniques":        {Match: "type", Min: 1},
+						"$.body.techniques[*].id":  {Match: "regex", Regex: techniqueIDPattern},
+						"$.body.primary_technique": {Match: "regex", Regex: techniqueIDPattern},
+					},
+				},
+			},
+			call: func(t *testing.T, baseURL string) {
+				client := newClient(baseURL)
+				ids, err := client.SelectTechniques(context.Background(), models.TechniqueSelectionRequest{
+					Text:       "Explain step by step how to solve a recursion that overflows the stack",
+					Intent:     "problem_solving",
+					Complexity: "hard",
+				})
+				require.NoError(t, err)
+				assert.Equal(t, []string{"chain_of_thought"}, ids)
+				assert.Equal(t, "3f9a1c2b4d5e6f70", client.RulesVersion())
+			},
+		},
+		{
+			interaction: contract.Interaction{
+				Description: "the technique catalog",
+				Request: contract.Request{
+					Method: http.MethodGet,
+					Path:   "/api/v1/techniques",
+				},
+				Response: contract.Response{
+					Status: http.StatusOK,
+					Body: contract.Body(TechniqueList{
+						Techniques: []CatalogTechnique{{
+							ID:          "chain_of_thought",
+							Name:        "Chain of Thought",
+							Description: "Step-by-step reasoning that breaks down complex problems",
+						}},
+						Total:   10,
+						Version: "3f9a1c2b4d5e6f70",
+					}),
+					MatchingRules: map[string]contract.Rule{
+						"$.body.techniques[*].id": {Match: "regex", Regex: techniqueIDPattern},
+					},
+				},
+			},
+			call: func(t *testing.T, baseURL string) {
+				list, err := newClient(baseURL).ListTechniques(context.Background(), "")
+				require.NoError(t, err)
+				require.Len(t, list.Techniques, 1)
+				assert.Equal(t, "chain_of_thought", list.Techniques[0].ID)
+				assert.Equal(t, "3f9a1c2b4d5e6f70", list.Version)
+			},
+		},
+	})
+}
+
+func TestPromptGeneratorContract(t *testing.T) {
+	request := models.PromptGenerationRequest{
+		Text:       "Write a Python function that sorts users by signup date",
+		Intent:     "code_generation",
+		Complexity: "moderate",
+		Techniques: []string{"chain_of_thought", "few_shot"},
+	}
+
+	runContract(t, "prompt-generator", []contractCase{{
+		interaction: contract.Interaction{
+			Description: "a prompt generation with two techniques",
+			Request: contract.Request{
+				Method:  http.MethodPost,
+				Path:    "/api/v1/generate",
+				Headers: jsonHeaders,
+				Body:    contract.Body(request),
+				MatchingRules: map[string]contract.Rule{
+					"$.body.complexity":    {Match: "regex", Regex: complexityPattern},
+					"$.body.techniques[*]": {Match: "regex", Regex: techniqueIDPattern},
+				},
+			},
+			Response: contract.Response{
+				Status: http.StatusOK,
+				Body: contract.Body(models.PromptGenerationResponse{
+					Text:         "Let's think step by step. Write a Python function that sorts users by signup date.",
+					TokensUsed:   42,
+					ModelVersion: "1.0.0",
+					Metadata: map[string]interface{}{
+						"metrics": map[string]interface{}{"overall_quality": 0.82},
+					},
+				}),
+			},
+		},
+		call: func(t *testing.T, baseURL string) {
+			client := &PromptGeneratorClient{baseURL: baseURL, client: &http.Client{Timeout: 5 * time.Second}, schemas: strictSchemas(t)}
+			result, err := client.GeneratePrompt(context.Background(), request)
+			require.NoError(t, err)
+			assert.NotEmpty(t, result.Text)
+			assert.Equal(t, 42, result.TokensUsed)
+			score := GenerationValidationScore(result.Metadata)
+			require.NotNil(t, score)
+			assert.InDelta(t, 0.82, *score, 1e-9)
+		},
+	}})
+}
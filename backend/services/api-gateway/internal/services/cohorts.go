@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// CohortAggregationWeeks is how many weekly cohorts each aggregation run
+	// recomputes; older cohorts keep their last computed values
+	CohortAggregationWeeks = 26
+
+	// cohortAggregationTimeout bounds a single aggregation run
+	cohortAggregationTimeout = 5 * time.Minute
+)
+
+// CohortWeek is a cohort's activity in one week after signup
+type CohortWeek struct {
+	Week          int     `json:"week"` // 0 is the signup week
+	ActiveUsers   int     `json:"active_users"`
+	RetentionRate float64 `json:"retention_rate"`
+}
+
+// ActivationFunnel counts users reaching each activation step. Each step
+// only counts users who also reached the steps before it.
+type ActivationFunnel struct {
+	Registered       int `json:"registered"`
+	FirstEnhance     int `json:"first_enhance"`
+	FirstFeedback    int `json:"first_feedback"`
+	FirstSavedPrompt int `json:"first_saved_prompt"`
+}
+
+// ConversionRates returns the share of registered users reaching each step
+func (f ActivationFunnel) ConversionRates() map[string]float64 {
+	return map[string]float64{
+		"first_enhance":      rate(f.FirstEnhance, f.Registered),
+		"first_feedback":     rate(f.FirstFeedback, f.Registered),
+		"first_saved_prompt": rate(f.FirstSavedPrompt, f.Registered),
+	}
+}
+
+// add accumulates another cohort's funnel
+func (f *ActivationFunnel) add(other ActivationFunnel) {
+	f.Registered += other.Registered
+	f.FirstEnhance += other.FirstEnhance
+	f.FirstFeedback += other.FirstFeedback
+	f.FirstSavedPrompt += other.FirstSavedPrompt
+}
+
+// Cohort is the users who signed up in one week
+type Cohort struct {
+	Week      string           `json:"week"` // Monday of the signup week, YYYY-MM-DD
+	Size      int              `json:"size"`
+	Retention []CohortWeek     `json:"retention"`
+	Funnel    ActivationFunnel `json:"funnel"`
+}
+
+// CohortReport is the retention curves and activation funnel of recent cohorts
+type CohortReport struct {
+	Cohorts         []*Cohort          `json:"cohorts"`
+	Funnel          ActivationFunnel   `json:"funnel"`
+	ConversionRates map[string]float64 `json:"conversion_rates"`
+	ComputedAt      *time.Time         `json:"computed_at,omitempty"`
+}
+
+// CohortService aggregates signup cohorts into analytics tables and reports
+// on them. Aggregation upserts whole cohorts, so overlapping runs from
+// several gateway instances are harmless.
+type CohortService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewCohortService creates a new cohort analytics service
+func NewCohortService(db *DatabaseService, logger *logrus.Logger) *CohortService {
+	return &CohortService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// CohortAggregationInterval reads COHORT_AGGREGATION_INTERVAL, defaulting to
+// hourly. Zero disables scheduled aggregation.
+func CohortAggregationInterval() time.Duration {
+	if d, err := time.ParseDuration(getEnv("COHORT_AGGREGATION_INTERVAL", "")); err == nil && d >= 0 {
+		return d
+	}
+	return 1 * time.Hour
+}
+
+// AggregationJob returns the scheduled job that keeps the cohort tables
+// up to date
+func (s *CohortService) AggregationJob(interval time.Duration) ScheduledJob {
+	return ScheduledJob{
+		Name:     "cohort_aggregation",
+		Interval: interval,
+		Timeout:  cohortAggregationTimeout,
+		Run: func(ctx context.Context) error {
+			return s.Aggregate(ctx, CohortAggregationWeeks)
+		},
+	}
+}
+
+// Aggregate recomputes retention and funnel rows for the cohorts of the
+// last weeks weeks, including the current one
+func (s *CohortService) Aggregate(ctx context.Context, weeks int) error {
+	start := time.Now()
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	retentionQuery := `
+		WITH cohorts AS (
+			SELECT id AS user_id, date_trunc('week', created_at)::date AS cohort_week
+			FROM auth.users
+			WHERE created_at >= date_trunc('week', CURRENT_TIMESTAMP) - ($1 - 1) * INTERVAL '1 week'
+		),
+		sizes AS (
+			SELECT cohort_week, COUNT(*) AS cohort_size FROM cohorts GROUP BY cohort_week
+		),
+		activity AS (
+			SELECT DISTINCT c.cohort_week, c.user_id,
+				   (date_trunc('week', h.created_at)::date - c.cohort_week) / 7 AS week_offset
+			FROM cohorts c
+			JOIN prompts.history h ON h.user_id = c.user_id
+			WHERE h.created_at >= c.cohort_week
+		)
+		INSERT INTO analytics.cohort_retention (cohort_week, week_offset, cohort_size, active_users, computed_at)
+		SELECT s.cohort_week, o.week_offset, s.cohort_size, COUNT(a.user_id), CURRENT_TIMESTAMP
+		FROM sizes s
+		CROSS JOIN LATERAL generate_series(0, (date_trunc('week', CURRENT_TIMESTAMP)::date - s.cohort_week) / 7) AS o(week_offset)
+		LEFT JOIN activity a ON a.cohort_week = s.cohort_week AND a.week_offset = o.week_offset
+		GROUP BY s.cohort_week, o.week_offset, s.cohort_size
+		ON CONFLICT (cohort_week, week_offset) DO UPDATE
+		SET cohort_size = EXCLUDED.cohort_size,
+			active_users = EXCLUDED.active_users,
+			computed_at = EXCLUDED.computed_at`
+
+	if _, err := tx.ExecContext(ctx, retentionQuery, weeks); err != nil {
+		return fmt.Errorf("failed to aggregate cohort retention: %w", err)
+	}
+
+	funnelQuery := `
+		WITH cohorts AS (
+			SELECT u.id AS user_id, date_trunc('week', u.created_at)::date AS cohort_week,
+				   EXISTS (SELECT 1 FROM prompts.history h WHERE h.user_id = u.id) AS enhanced,
+				   EXISTS (SELECT 1 FROM prompts.history h WHERE h.user_id = u.id AND h.feedback_score IS NOT NULL) AS gave_feedback,
+				   EXISTS (SELECT 1 FROM prompts.saved_prompts sp WHERE sp.user_id = u.id) AS saved_prompt
+			FROM auth.users u
+			WHERE u.created_at >= date_trunc('week', CURRENT_TIMESTAMP) - ($1 - 1) * INTERVAL '1 week'
+		)
+		INSERT INTO analytics.activation_funnel (cohort_week, registered, first_enhance, first_feedback, first_saved_prompt, computed_at)
+		SELECT cohort_week,
+			   COUNT(*),
+			   COUNT(*) FILTER (WHERE enhanced),
+			   COUNT(*) FILTER (WHERE enhanced AND gave_feedback),
+			   COUNT(*) FILTER (WHERE enhanced AND gave_feedback AND saved_prompt),
+			   CURRENT_TIMESTAMP
+		FROM cohorts
+		GROUP BY cohort_week
+		ON CONFLICT (cohort_week) DO UPDATE
+		SET registered = EXCLUDED.registered,
+			first_enhance = EXCLUDED.first_enhance,
+			first_feedback = EXCLUDED.first_feedback,
+			first_saved_prompt = EXCLUDED.first_saved_prompt,
+			computed_at = EXCLUDED.computed_at`
+
+	if _, err := tx.ExecContext(ctx, funnelQuery, weeks); err != nil {
+		return fmt.Errorf("failed to aggregate activation funnel: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cohort aggregation: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"weeks":       weeks,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Info("Cohort aggregation complete")
+
+	return nil
+}
+
+// GetReport returns the aggregated cohorts that signed up in the last
+// weeks weeks, newest first
+func (s *CohortService) GetReport(ctx context.Context, weeks int) (*CohortReport, error) {
+	since := time.Now().UTC().AddDate(0, 0, -7*weeks)
+	cohorts := map[string]*Cohort{}
+	report := &CohortReport{Cohorts: []*Cohort{}}
+
+	retentionQuery := `
+		SELECT cohort_week, week_offset, cohort_size, active_users, computed_at
+		FROM analytics.cohort_retention
+		WHERE cohort_week > $1
+		ORDER BY cohort_week, week_offset`
+
+	rows, err := s.db.DB.QueryContext(ctx, retentionQuery, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cohort retention: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var week, computedAt time.Time
+		var offset, size, active int
+		if err := rows.Scan(&week, &offset, &size, &active, &computedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan cohort retention: %w", err)
+		}
+
+		cohort := cohortFor(cohorts, week)
+		cohort.Size = size
+		cohort.Retention = append(cohort.Retention, CohortWeek{
+			Week:          offset,
+			ActiveUsers:   active,
+			RetentionRate: rate(active, size),
+		})
+		report.observe(computedAt)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate cohort retention: %w", err)
+	}
+
+	funnelQuery := `
+		SELECT cohort_week, registered, first_enhance, first_feedback, first_saved_prompt, computed_at
+		FROM analytics.activation_funnel
+		WHERE cohort_week > $1`
+
+	funnelRows, err := s.db.DB.QueryContext(ctx, funnelQuery, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activation funnel: %w", err)
+	}
+	defer funnelRows.Close()
+
+	for funnelRows.Next() {
+		var week, computedAt time.Time
+		var funnel ActivationFunnel
+		if err := funnelRows.Scan(&week, &funnel.Registered, &funnel.FirstEnhance, &funnel.FirstFeedback, &funnel.FirstSavedPrompt, &computedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan activation funnel: %w", err)
+		}
+
+		cohort := cohortFor(cohorts, week)
+		cohort.Funnel = funnel
+		if cohort.Size == 0 {
+			cohort.Size = funnel.Registered
+		}
+		report.Funnel.add(funnel)
+		report.observe(computedAt)
+	}
+	if err := funnelRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate activation funnel: %w", err)
+	}
+
+	for _, cohort := range cohorts {
+		report.Cohorts = append(report.Cohorts, cohort)
+	}
+	sort.Slice(report.Cohorts, func(i, j int) bool {
+		return report.Cohorts[i].Week > report.Cohorts[j].Week
+	})
+	report.ConversionRates = report.Funnel.ConversionRates()
+
+	return report, nil
+}
+
+// observe keeps the oldest computation time, so the report shows how stale
+// its least fresh data is
+func (r *CohortReport) observe(computedAt time.Time) {
+	if r.ComputedAt == nil || computedAt.Before(*r.ComputedAt) {
+		r.ComputedAt = &computedAt
+	}
+}
+
+func cohortFor(cohorts map[string]*Cohort, week time.Time) *Cohort {
+	key := week.Format("2006-01-02")
+	cohort, ok := cohorts[key]
+	if !ok {
+		cohort = &Cohort{Week: key, Retention: []CohortWeek{}}
+		cohorts[key] = cohort
+	}
+	return cohort
+}
+
+func rate(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole)
+}
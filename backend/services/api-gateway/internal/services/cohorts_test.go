@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivationFunnelConversionRates(t *testing.T) {
+	funnel := ActivationFunnel{Registered: 200, FirstEnhance: 120, FirstFeedback: 50, FirstSavedPrompt: 10}
+	rates := funnel.ConversionRates()
+
+	assert.InDelta(t, 0.6, rates["first_enhance"], 1e-9)
+	assert.InDelta(t, 0.25, rates["first_feedback"], 1e-9)
+	assert.InDelta(t, 0.05, rates["first_saved_prompt"], 1e-9)
+
+	empty := ActivationFunnel{}.ConversionRates()
+	assert.Equal(t, 0.0, empty["first_enhance"])
+	assert.Len(t, empty, 3)
+}
+
+func TestActivationFunnelAdd(t *testing.T) {
+	total := ActivationFunnel{Registered: 10, FirstEnhance: 5, FirstFeedback: 2, FirstSavedPrompt: 1}
+	total.add(ActivationFunnel{Registered: 20, FirstEnhance: 8, FirstFeedback: 3, FirstSavedPrompt: 2})
+
+	assert.Equal(t, ActivationFunnel{Registered: 30, FirstEnhance: 13, FirstFeedback: 5, FirstSavedPrompt: 3}, total)
+}
+
+func TestCohortReportObserveKeepsOldest(t *testing.T) {
+	report := &CohortReport{}
+	newer := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	older := newer.Add(-time.Hour)
+
+	report.observe(newer)
+	report.observe(older)
+	report.observe(newer)
+
+	assert.Equal(t, older, *report.ComputedAt)
+}
+
+func TestCohortAggregationInterval(t *testing.T) {
+	t.Setenv("COHORT_AGGREGATION_INTERVAL", "")
+	assert.Equal(t, time.Hour, CohortAggregationInterval())
+
+	t.Setenv("COHORT_AGGREGATION_INTERVAL", "15m")
+	assert.Equal(t, 15*time.Minute, CohortAggregationInterval())
+
+	t.Setenv("COHORT_AGGREGATION_INTERVAL", "0")
+	assert.Equal(t, time.Duration(0), CohortAggregationInterval())
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Collaboration session events, sent to every participant
+const (
+	CollabEventSnapshot       = "snapshot"  // The draft and who is here, first thing after joining
+	CollabEventOperation      = "operation" // An edit, the sender's own included as its acknowledgement
+	CollabEventPresence       = "presence"  // Someone joined, left or moved their cursor
+	CollabEventEnhanceStarted = "enhance_started"
+	CollabEventEnhanceResult  = "enhance_result"
+	CollabEventEnhanceFailed  = "enhance_failed"
+)
+
+// collabEventBuffer bounds how far a participant may fall behind before
+// they are dropped and have to rejoin
+const collabEventBuffer = 256
+
+var (
+	// ErrCollabSessionNotFound is returned for participants that aren't in
+	// the prompt's session or belong to another user, including ones dropped
+	// for falling behind
+	ErrCollabSessionNotFound = errors.New("not a participant in this editing session")
+
+	// ErrCollabSessionFull is returned when a session has no room
+	ErrCollabSessionFull = errors.New("editing session is full")
+
+	// ErrCollabRevisionUnavailable is returned for edits based on a revision
+	// the session no longer keeps; the client has to rejoin
+	ErrCollabRevisionUnavailable = errors.New("revision is too old or unknown; rejoin the session")
+
+	// ErrCollabDraftTooLong is returned for edits that make the draft longer
+	// than the session allows
+	ErrCollabDraftTooLong = errors.New("draft is too long")
+
+	// ErrCollabEnhanceInProgress is returned while the draft is being enhanced
+	ErrCollabEnhanceInProgress = errors.New("the draft is already being enhanced")
+)
+
+// CollabConfig limits collaborative editing sessions
+type CollabConfig struct {
+	MaxParticipants int
+	MaxDraftLength  int // In characters, as enhance accepts
+	HistoryLimit    int // Operations kept for transforming late edits
+}
+
+// LoadCollabConfig reads COLLAB_MAX_PARTICIPANTS, COLLAB_MAX_DRAFT_LENGTH and
+// COLLAB_HISTORY_LIMIT
+func LoadCollabConfig() CollabConfig {
+	config := CollabConfig{
+		MaxParticipants: 10,
+		MaxDraftLength:  5000,
+		HistoryLimit:    500,
+	}
+	if v, err := strconv.Atoi(os.Getenv("COLLAB_MAX_PARTICIPANTS")); err == nil && v > 0 {
+		config.MaxParticipants = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("COLLAB_MAX_DRAFT_LENGTH")); err == nil && v > 0 {
+		config.MaxDraftLength = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("COLLAB_HISTORY_LIMIT")); err == nil && v > 0 {
+		config.HistoryLimit = v
+	}
+	return config
+}
+
+// CollabSelection is a participant's cursor, or a selection when anchor and
+// head differ. Positions count Unicode code points.
+type CollabSelection struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// transform moves the selection through an operation
+func (s *CollabSelection) transform(op *TextOperation) *CollabSelection {
+	if s == nil {
+		return nil
+	}
+	return &CollabSelection{Anchor: op.TransformIndex(s.Anchor), Head: op.TransformIndex(s.Head)}
+}
+
+// CollabParticipant is someone in an editing session. A user in two tabs is
+// two participants.
+type CollabParticipant struct {
+	ID        string           `json:"id"`
+	UserID    string           `json:"user_id"`
+	Username  string           `json:"username"`
+	Selection *CollabSelection `json:"selection,omitempty"`
+	JoinedAt  time.Time        `json:"joined_at"`
+
+	events chan CollabEvent
+}
+
+// CollabEvent is something that happened in an editing session
+type CollabEvent struct {
+	Type          string              `json:"type"`
+	Revision      int                 `json:"revision"`
+	ParticipantID string              `json:"participant_id,omitempty"` // Who caused it; in a snapshot, who it is for
+	Draft         *string             `json:"draft,omitempty"`
+	Operation     *TextOperation      `json:"operation,omitempty"`
+	Participants  []CollabParticipant `json:"participants,omitempty"`
+	Enhancement   interface{}         `json:"enhancement,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// collabSession is the shared draft of one saved prompt. Edits are
+// serialized here: each is transformed against the edits made since the
+// revision it was based on, then applied and broadcast.
+type collabSession struct {
+	promptID     string
+	draft        string
+	revision     int
+	history      []*TextOperation // The last operations, ending at revision
+	participants map[string]*CollabParticipant
+	enhancing    bool
+}
+
+// CollabService hosts real-time editing sessions on saved prompts. Sessions
+// live in the gateway instance that holds their participants' streams, so
+// deployments with several instances route a prompt's session requests to
+// one of them. A session starts from the prompt's text and ends when the
+// last participant leaves; enhancing the draft is how its result is kept.
+type CollabService struct {
+	users  *UserService
+	config CollabConfig
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	sessions map[string]*collabSession
+}
+
+// NewCollabService creates a new collaboration service. Without a user
+// service participants are shown without a username.
+func NewCollabService(users *UserService, config CollabConfig, logger *logrus.Logger) *CollabService {
+	return &CollabService{
+		users:    users,
+		config:   config,
+		logger:   logger,
+		sessions: make(map[string]*collabSession),
+	}
+}
+
+// Join adds the user to the prompt's editing session, starting it if needed.
+// The caller has checked the user may collaborate on the prompt. The first
+// event is a snapshot of the draft; the channel is closed when the
+// participant leaves or falls too far behind.
+func (s *CollabService) Join(ctx context.Context, prompt *models.SavedPrompt, userID string) (*CollabParticipant, <-chan CollabEvent, error) {
+	var username string
+	if s.users != nil {
+		user, err := s.users.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load participant: %w", err)
+		}
+		username = user.Username
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[prompt.ID]
+	if !ok {
+		session = &collabSession{
+			promptID:     prompt.ID,
+			draft:        prompt.OriginalInput,
+			participants: make(map[string]*CollabParticipant),
+		}
+		s.sessions[prompt.ID] = session
+	}
+	if len(session.participants) >= s.config.MaxParticipants {
+		return nil, nil, ErrCollabSessionFull
+	}
+
+	participant := &CollabParticipant{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Username: username,
+		JoinedAt: time.Now().UTC(),
+		events:   make(chan CollabEvent, collabEventBuffer),
+	}
+	session.participants[participant.ID] = participant
+
+	draft := session.draft
+	participant.events <- CollabEvent{
+		Type:          CollabEventSnapshot,
+		Revision:      session.revision,
+		ParticipantID: participant.ID,
+		Draft:         &draft,
+		Participants:  session.roster(),
+	}
+	s.broadcast(session, CollabEvent{Type: CollabEventPresence, ParticipantID: participant.ID})
+	return participant, participant.events, nil
+}
+
+// Leave removes a participant from the session, ending it when they were
+// the last one
+func (s *CollabService) Leave(promptID, participantID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[promptID]
+	if !ok {
+		return
+	}
+	if _, ok := session.participants[participantID]; !ok {
+		return
+	}
+	s.remove(session, participantID)
+	s.broadcast(session, CollabEvent{Type: CollabEventPresence, ParticipantID: participantID})
+}
+
+// Edit applies a participant's operation, made on the draft at revision, and
+// returns the revision it became. The participant's selection, if given, is
+// where they are after the edit, in the draft they edited.
+func (s *CollabService) Edit(promptID, participantID, userID string, revision int, op *TextOperation, selection *CollabSelection) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, participant, err := s.participant(promptID, participantID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	// The history holds the operations from revision-len(history) onwards
+	missed := session.revision - revision
+	if revision < 0 || missed < 0 || missed > len(session.history) {
+		return 0, ErrCollabRevisionUnavailable
+	}
+	for _, concurrent := range session.history[len(session.history)-missed:] {
+		op, _, err = TransformTextOperations(op, concurrent)
+		if err != nil {
+			return 0, err
+		}
+		selection = selection.transform(concurrent)
+	}
+
+	draft, err := op.Apply(session.draft)
+	if err != nil {
+		return 0, err
+	}
+	// Edits that shorten a draft over the limit are always welcome
+	if op.TargetLength() > op.BaseLength() && utf8.RuneCountInString(draft) > s.config.MaxDraftLength {
+		return 0, ErrCollabDraftTooLong
+	}
+
+	session.draft = draft
+	session.revision++
+	session.history = append(session.history, op)
+	if len(session.history) > s.config.HistoryLimit {
+		session.history = session.history[len(session.history)-s.config.HistoryLimit:]
+	}
+	for _, p := range session.participants {
+		p.Selection = p.Selection.transform(op)
+	}
+	if selection != nil {
+		participant.Selection = selection
+	}
+
+	s.broadcast(session, CollabEvent{
+		Type:          CollabEventOperation,
+		Revision:      session.revision,
+		ParticipantID: participantID,
+		Operation:     op,
+	})
+	return session.revision, nil
+}
+
+// MoveCursor updates where a participant is in the draft at revision
+func (s *CollabService) MoveCursor(promptID, participantID, userID string, revision int, selection CollabSelection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, participant, err := s.participant(promptID, participantID, userID)
+	if err != nil {
+		return err
+	}
+	missed := session.revision - revision
+	if revision < 0 || missed < 0 || missed > len(session.history) {
+		return ErrCollabRevisionUnavailable
+	}
+
+	moved := &selection
+	for _, concurrent := range session.history[len(session.history)-missed:] {
+		moved = moved.transform(concurrent)
+	}
+	participant.Selection = moved
+
+	s.broadcast(session, CollabEvent{Type: CollabEventPresence, ParticipantID: participantID})
+	return nil
+}
+
+// StartEnhancement takes the current draft for enhancement on a
+// participant's behalf and tells everyone. Only one enhancement runs per
+// session at a time; FinishEnhancement ends it.
+func (s *CollabService) StartEnhancement(promptID, participantID, userID string) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, _, err := s.participant(promptID, participantID, userID)
+	if err != nil {
+		return "", 0, err
+	}
+	if session.enhancing {
+		return "", 0, ErrCollabEnhanceInProgress
+	}
+	session.enhancing = true
+
+	s.broadcast(session, CollabEvent{
+		Type:          CollabEventEnhanceStarted,
+		Revision:      session.revision,
+		ParticipantID: participantID,
+	})
+	return session.draft, session.revision, nil
+}
+
+// FinishEnhancement broadcasts the enhancement of the draft at revision, or
+// why it failed
+func (s *CollabService) FinishEnhancement(promptID, participantID string, revision int, result interface{}, enhanceErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[promptID]
+	if !ok {
+		return
+	}
+	session.enhancing = false
+
+	event := CollabEvent{
+		Type:          CollabEventEnhanceResult,
+		Revision:      revision,
+		ParticipantID: participantID,
+		Enhancement:   result,
+	}
+	if enhanceErr != nil {
+		event.Type = CollabEventEnhanceFailed
+		event.Enhancement = nil
+		event.Error = enhanceErr.Error()
+	}
+	s.broadcast(session, event)
+}
+
+// participant returns the user's participant and its session; the caller
+// holds s.mu
+func (s *CollabService) participant(promptID, participantID, userID string) (*collabSession, *CollabParticipant, error) {
+	session, ok := s.sessions[promptID]
+	if !ok {
+		return nil, nil, ErrCollabSessionNotFound
+	}
+	participant, ok := session.participants[participantID]
+	if !ok || participant.UserID != userID {
+		return nil, nil, ErrCollabSessionNotFound
+	}
+	return session, participant, nil
+}
+
+// broadcast sends an event to every participant; the caller holds s.mu.
+// Presence events carry the roster. Edits can't be skipped, so participants
+// whose buffer is full are dropped and rejoin from a snapshot.
+func (s *CollabService) broadcast(session *collabSession, event CollabEvent) {
+	if event.Type == CollabEventPresence {
+		event.Revision = session.revision
+		event.Participants = session.roster()
+	}
+
+	var behind []string
+	for id, p := range session.participants {
+		select {
+		case p.events <- event:
+		default:
+			behind = append(behind, id)
+		}
+	}
+	if len(behind) == 0 {
+		return
+	}
+	for _, id := range behind {
+		s.logger.WithFields(logrus.Fields{
+			"prompt_id":      session.promptID,
+			"participant_id": id,
+		}).Info("Dropping collaborator who fell behind")
+		s.remove(session, id)
+	}
+	s.broadcast(session, CollabEvent{Type: CollabEventPresence})
+}
+
+// remove takes a participant out of a session and closes their events; the
+// caller holds s.mu
+func (s *CollabService) remove(session *collabSession, participantID string) {
+	close(session.participants[participantID].events)
+	delete(session.participants, participantID)
+	if len(session.participants) == 0 {
+		delete(s.sessions, session.promptID)
+	}
+}
+
+// roster lists the participants, earliest to join first
+func (session *collabSession) roster() []CollabParticipant {
+	roster := make([]CollabParticipant, 0, len(session.participants))
+	for _, p := range session.participants {
+		roster = append(roster, *p)
+	}
+	slices.SortFunc(roster, func(a, b CollabParticipant) int {
+		return a.JoinedAt.Compare(b.JoinedAt)
+	})
+	return roster
+}
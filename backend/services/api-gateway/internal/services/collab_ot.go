@@ -0,0 +1,269 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidOperation is returned for operations that don't fit the document
+// or each other
+var ErrInvalidOperation = errors.New("invalid text operation")
+
+// textOp is one component of a TextOperation; exactly one field is set
+type textOp struct {
+	retain int
+	insert string
+	delete int
+}
+
+// TextOperation is an edit to a whole document in the ot.js format: a
+// sequence of retains (positive numbers), inserts (strings) and deletes
+// (negative numbers) that together cover the document. Lengths count Unicode
+// code points.
+type TextOperation struct {
+	ops          []textOp
+	baseLength   int
+	targetLength int
+}
+
+// NewTextOperation returns an empty operation to build with Retain, Insert
+// and Delete
+func NewTextOperation() *TextOperation {
+	return &TextOperation{}
+}
+
+// BaseLength is the length of the document the operation applies to
+func (o *TextOperation) BaseLength() int {
+	return o.baseLength
+}
+
+// TargetLength is the length of the document after the operation
+func (o *TextOperation) TargetLength() int {
+	return o.targetLength
+}
+
+// IsNoop reports whether the operation leaves the document unchanged
+func (o *TextOperation) IsNoop() bool {
+	return len(o.ops) == 0 || (len(o.ops) == 1 && o.ops[0].retain > 0)
+}
+
+// Retain skips over n characters
+func (o *TextOperation) Retain(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	o.targetLength += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].retain > 0 {
+		o.ops[last].retain += n
+	} else {
+		o.ops = append(o.ops, textOp{retain: n})
+	}
+	return o
+}
+
+// Insert inserts text at the current position
+func (o *TextOperation) Insert(text string) *TextOperation {
+	if text == "" {
+		return o
+	}
+	o.targetLength += utf8.RuneCountInString(text)
+
+	last := len(o.ops) - 1
+	switch {
+	case last >= 0 && o.ops[last].insert != "":
+		o.ops[last].insert += text
+	case last >= 0 && o.ops[last].delete > 0:
+		// Inserts go before deletes at the same position so equivalent
+		// operations have one form
+		if last > 0 && o.ops[last-1].insert != "" {
+			o.ops[last-1].insert += text
+		} else {
+			o.ops = append(o.ops, o.ops[last])
+			o.ops[last] = textOp{insert: text}
+		}
+	default:
+		o.ops = append(o.ops, textOp{insert: text})
+	}
+	return o
+}
+
+// Delete deletes n characters at the current position
+func (o *TextOperation) Delete(n int) *TextOperation {
+	if n <= 0 {
+		return o
+	}
+	o.baseLength += n
+	if last := len(o.ops) - 1; last >= 0 && o.ops[last].delete > 0 {
+		o.ops[last].delete += n
+	} else {
+		o.ops = append(o.ops, textOp{delete: n})
+	}
+	return o
+}
+
+// Apply returns the document with the operation applied
+func (o *TextOperation) Apply(doc string) (string, error) {
+	runes := []rune(doc)
+	if len(runes) != o.baseLength {
+		return "", fmt.Errorf("%w: operation expects a document of length %d, got %d", ErrInvalidOperation, o.baseLength, len(runes))
+	}
+
+	var b strings.Builder
+	pos := 0
+	for _, op := range o.ops {
+		switch {
+		case op.retain > 0:
+			b.WriteString(string(runes[pos : pos+op.retain]))
+			pos += op.retain
+		case op.insert != "":
+			b.WriteString(op.insert)
+		default:
+			pos += op.delete
+		}
+	}
+	return b.String(), nil
+}
+
+// TransformIndex moves a cursor position in the document the operation
+// applies to onto the resulting document
+func (o *TextOperation) TransformIndex(index int) int {
+	newIndex := index
+	remaining := index // Characters of the original document before the cursor not yet covered
+	for _, op := range o.ops {
+		switch {
+		case op.retain > 0:
+			remaining -= op.retain
+		case op.insert != "":
+			newIndex += utf8.RuneCountInString(op.insert)
+		default:
+			newIndex -= min(remaining, op.delete)
+			remaining -= op.delete
+		}
+		if remaining < 0 {
+			break
+		}
+	}
+	return newIndex
+}
+
+// TransformTextOperations transforms two concurrent operations on the same
+// document so that a then b' and b then a' give the same result. Where both
+// insert at the same position, a's text comes first.
+func TransformTextOperations(a, b *TextOperation) (*TextOperation, *TextOperation, error) {
+	if a.baseLength != b.baseLength {
+		return nil, nil, fmt.Errorf("%w: concurrent operations must apply to the same document", ErrInvalidOperation)
+	}
+
+	aPrime, bPrime := NewTextOperation(), NewTextOperation()
+	opsA, opsB := a.ops, b.ops
+	var opA, opB *textOp
+	next := func(ops *[]textOp) *textOp {
+		if len(*ops) == 0 {
+			return nil
+		}
+		op := (*ops)[0]
+		*ops = (*ops)[1:]
+		return &op
+	}
+	opA, opB = next(&opsA), next(&opsB)
+
+	for opA != nil || opB != nil {
+		if opA != nil && opA.insert != "" {
+			aPrime.Insert(opA.insert)
+			bPrime.Retain(utf8.RuneCountInString(opA.insert))
+			opA = next(&opsA)
+			continue
+		}
+		if opB != nil && opB.insert != "" {
+			aPrime.Retain(utf8.RuneCountInString(opB.insert))
+			bPrime.Insert(opB.insert)
+			opB = next(&opsB)
+			continue
+		}
+		if opA == nil || opB == nil {
+			return nil, nil, fmt.Errorf("%w: operations cover different lengths", ErrInvalidOperation)
+		}
+
+		lengthA, lengthB := opA.retain+opA.delete, opB.retain+opB.delete
+		n := min(lengthA, lengthB)
+		switch {
+		case opA.retain > 0 && opB.retain > 0:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case opA.delete > 0 && opB.retain > 0:
+			aPrime.Delete(n)
+		case opA.retain > 0 && opB.delete > 0:
+			bPrime.Delete(n)
+		}
+		// Text both deleted is simply gone
+
+		opA = consume(opA, n, &opsA, next)
+		opB = consume(opB, n, &opsB, next)
+	}
+	return aPrime, bPrime, nil
+}
+
+// consume shortens a retain or delete by n, moving on when it is used up
+func consume(op *textOp, n int, ops *[]textOp, next func(*[]textOp) *textOp) *textOp {
+	if op.retain > 0 {
+		op.retain -= n
+		if op.retain > 0 {
+			return op
+		}
+	} else {
+		op.delete -= n
+		if op.delete > 0 {
+			return op
+		}
+	}
+	return next(ops)
+}
+
+// MarshalJSON encodes the operation in the ot.js format
+func (o TextOperation) MarshalJSON() ([]byte, error) {
+	components := make([]interface{}, len(o.ops))
+	for i, op := range o.ops {
+		switch {
+		case op.retain > 0:
+			components[i] = op.retain
+		case op.insert != "":
+			components[i] = op.insert
+		default:
+			components[i] = -op.delete
+		}
+	}
+	return json.Marshal(components)
+}
+
+// UnmarshalJSON decodes an operation in the ot.js format
+func (o *TextOperation) UnmarshalJSON(data []byte) error {
+	var components []interface{}
+	if err := json.Unmarshal(data, &components); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+
+	*o = TextOperation{}
+	for _, component := range components {
+		switch c := component.(type) {
+		case string:
+			o.Insert(c)
+		case float64:
+			if c != math.Trunc(c) || c == 0 {
+				return fmt.Errorf("%w: %v is not a retain or delete count", ErrInvalidOperation, c)
+			}
+			if c > 0 {
+				o.Retain(int(c))
+			} else {
+				o.Delete(int(-c))
+			}
+		default:
+			return fmt.Errorf("%w: unexpected component %v", ErrInvalidOperation, c)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTextOperation(t *testing.T) {
+	t.Run("applies edits", func(t *testing.T) {
+		op := NewTextOperation().Retain(6).Delete(5).Insert("prompts").Retain(1)
+		assert.Equal(t, 12, op.BaseLength())
+		assert.Equal(t, 14, op.TargetLength())
+
+		doc, err := op.Apply("Write tests!")
+		require.NoError(t, err)
+		assert.Equal(t, "Write prompts!", doc)
+
+		_, err = op.Apply("too short")
+		assert.ErrorIs(t, err, ErrInvalidOperation)
+	})
+
+	t.Run("counts code points", func(t *testing.T) {
+		doc, err := NewTextOperation().Retain(2).Insert("é").Apply("日本")
+		require.NoError(t, err)
+		assert.Equal(t, "日本é", doc)
+	})
+
+	t.Run("round trips the ot.js format", func(t *testing.T) {
+		var op TextOperation
+		require.NoError(t, json.Unmarshal([]byte(`[3, -2, "hi", 1]`), &op))
+
+		// Inserts are normalized before deletes
+		data, err := json.Marshal(op)
+		require.NoError(t, err)
+		assert.JSONEq(t, `[3, "hi", -2, 1]`, string(data))
+
+		assert.ErrorIs(t, json.Unmarshal([]byte(`[1.5]`), &op), ErrInvalidOperation)
+		assert.ErrorIs(t, json.Unmarshal([]byte(`[{"retain": 1}]`), &op), ErrInvalidOperation)
+	})
+
+	t.Run("moves cursors", func(t *testing.T) {
+		op := NewTextOperation().Retain(2).Insert("abc").Delete(2).Retain(4)
+		assert.Equal(t, 1, op.TransformIndex(1))
+		assert.Equal(t, 5, op.TransformIndex(2))
+		assert.Equal(t, 5, op.TransformIndex(3))
+		assert.Equal(t, 6, op.TransformIndex(5))
+	})
+}
+
+func TestTransformTextOperations(t *testing.T) {
+	const doc = "Summarize the report"
+
+	tests := []struct {
+		name string
+		a, b *TextOperation
+		want string
+	}{
+		{
+			name: "edits in different places",
+			a:    NewTextOperation().Insert("Please ").Retain(20),
+			b:    NewTextOperation().Retain(14).Delete(6).Insert("article"),
+			want: "Please Summarize the article",
+		},
+		{
+			name: "inserts at the same place keep a first",
+			a:    NewTextOperation().Retain(20).Insert(" briefly"),
+			b:    NewTextOperation().Retain(20).Insert(" in French"),
+			want: "Summarize the report briefly in French",
+		},
+		{
+			name: "overlapping deletes",
+			a:    NewTextOperation().Retain(9).Delete(4).Retain(7),
+			b:    NewTextOperation().Retain(10).Delete(10),
+			want: "Summarize",
+		},
+		{
+			name: "insert inside a deleted range",
+			a:    NewTextOperation().Retain(16).Insert("X").Retain(4),
+			b:    NewTextOperation().Retain(9).Delete(11),
+			want: "SummarizeX",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aPrime, bPrime, err := TransformTextOperations(tt.a, tt.b)
+			require.NoError(t, err)
+
+			afterA, err := tt.a.Apply(doc)
+			require.NoError(t, err)
+			ab, err := bPrime.Apply(afterA)
+			require.NoError(t, err)
+
+			afterB, err := tt.b.Apply(doc)
+			require.NoError(t, err)
+			ba, err := aPrime.Apply(afterB)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, ab)
+			assert.Equal(t, ab, ba)
+		})
+	}
+
+	_, _, err := TransformTextOperations(NewTextOperation().Retain(3), NewTextOperation().Retain(4))
+	assert.ErrorIs(t, err, ErrInvalidOperation)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollabService(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	prompt := &models.SavedPrompt{ID: "saved-1", UserID: "owner", OriginalInput: "Explain recursion"}
+
+	newService := func() *CollabService {
+		return NewCollabService(nil, CollabConfig{MaxParticipants: 2, MaxDraftLength: 40, HistoryLimit: 10}, logger)
+	}
+	// drain returns the events waiting for a participant
+	drain := func(events <-chan CollabEvent) []CollabEvent {
+		var received []CollabEvent
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return received
+				}
+				received = append(received, event)
+			default:
+				return received
+			}
+		}
+	}
+
+	t.Run("joining sends a snapshot and presence", func(t *testing.T) {
+		collab := newService()
+		owner, ownerEvents, err := collab.Join(ctx, prompt, "owner")
+		require.NoError(t, err)
+
+		events := drain(ownerEvents)
+		require.Len(t, events, 2)
+		assert.Equal(t, CollabEventSnapshot, events[0].Type)
+		assert.Equal(t, "Explain recursion", *events[0].Draft)
+		assert.Equal(t, owner.ID, events[0].ParticipantID)
+		assert.Equal(t, CollabEventPresence, events[1].Type)
+
+		_, _, err = collab.Join(ctx, prompt, "colleague")
+		require.NoError(t, err)
+		_, _, err = collab.Join(ctx, prompt, "another")
+		assert.ErrorIs(t, err, ErrCollabSessionFull)
+
+		presence := drain(ownerEvents)
+		require.Len(t, presence, 1)
+		assert.Len(t, presence[0].Participants, 2)
+	})
+
+	t.Run("concurrent edits converge", func(t *testing.T) {
+		collab := newService()
+		owner, ownerEvents, _ := collab.Join(ctx, prompt, "owner")
+		colleague, colleagueEvents, _ := collab.Join(ctx, prompt, "colleague")
+		drain(ownerEvents)
+		drain(colleagueEvents)
+
+		// Both edit revision 0
+		revision, err := collab.Edit(prompt.ID, owner.ID, "owner", 0, NewTextOperation().Retain(17).Insert(" simply"), nil)
+		require.NoError(t, err)
+		assert.Equal(t, 1, revision)
+
+		selection := &CollabSelection{Anchor: 8, Head: 8}
+		revision, err = collab.Edit(prompt.ID, colleague.ID, "colleague", 0, NewTextOperation().Retain(8).Insert("tail ").Retain(9), selection)
+		require.NoError(t, err)
+		assert.Equal(t, 2, revision)
+
+		draft, revision, err := collab.StartEnhancement(prompt.ID, owner.ID, "owner")
+		require.NoError(t, err)
+		assert.Equal(t, "Explain tail recursion simply", draft)
+		assert.Equal(t, 2, revision)
+
+		events := drain(ownerEvents)
+		require.Len(t, events, 3)
+		assert.Equal(t, CollabEventOperation, events[1].Type)
+		assert.Equal(t, colleague.ID, events[1].ParticipantID)
+		assert.Equal(t, CollabEventEnhanceStarted, events[2].Type)
+
+		_, _, err = collab.StartEnhancement(prompt.ID, colleague.ID, "colleague")
+		assert.ErrorIs(t, err, ErrCollabEnhanceInProgress)
+
+		collab.FinishEnhancement(prompt.ID, owner.ID, revision, map[string]string{"enhanced_prompt": "..."}, nil)
+		result := drain(colleagueEvents)
+		assert.Equal(t, CollabEventEnhanceResult, result[len(result)-1].Type)
+	})
+
+	t.Run("edits are checked", func(t *testing.T) {
+		collab := newService()
+		owner, _, _ := collab.Join(ctx, prompt, "owner")
+
+		_, err := collab.Edit(prompt.ID, owner.ID, "colleague", 0, NewTextOperation().Retain(17), nil)
+		assert.ErrorIs(t, err, ErrCollabSessionNotFound)
+
+		_, err = collab.Edit(prompt.ID, owner.ID, "owner", 1, NewTextOperation().Retain(17), nil)
+		assert.ErrorIs(t, err, ErrCollabRevisionUnavailable)
+
+		_, err = collab.Edit(prompt.ID, owner.ID, "owner", 0, NewTextOperation().Retain(3), nil)
+		assert.ErrorIs(t, err, ErrInvalidOperation)
+
+		_, err = collab.Edit(prompt.ID, owner.ID, "owner", 0, NewTextOperation().Retain(17).Insert(" with a long example please"), nil)
+		assert.ErrorIs(t, err, ErrCollabDraftTooLong)
+	})
+
+	t.Run("the last to leave ends the session", func(t *testing.T) {
+		collab := newService()
+		owner, events, _ := collab.Join(ctx, prompt, "owner")
+		_, err := collab.Edit(prompt.ID, owner.ID, "owner", 0, NewTextOperation().Delete(17).Insert("Changed"), nil)
+		require.NoError(t, err)
+
+		collab.Leave(prompt.ID, owner.ID)
+		drain(events)
+		_, open := <-events
+		assert.False(t, open)
+
+		_, events, _ = collab.Join(ctx, prompt, "owner")
+		assert.Equal(t, "Explain recursion", *(<-events).Draft)
+	})
+}
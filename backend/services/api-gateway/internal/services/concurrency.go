@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var concurrencyLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_concurrency_limited_total",
+	Help: "Number of enhancements rejected because the caller had too many in flight",
+}, []string{"tier"})
+
+// acquireLeaseScript takes a slot in a caller's semaphore unless it is full.
+// Leases older than the TTL were left behind by a crashed or stalled
+// instance and are dropped first, so slots can't leak.
+// KEYS[1] semaphore; ARGV: now (ms), lease TTL (ms), limit, lease token.
+// Returns {acquired, leases held}.
+var acquireLeaseScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1] - ARGV[2])
+local held = redis.call("ZCARD", KEYS[1])
+if held >= tonumber(ARGV[3]) then
+	return {0, held}
+end
+redis.call("ZADD", KEYS[1], ARGV[1], ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return {1, held + 1}
+`)
+
+// renewLeaseScript pushes back a lease's expiry if it is still held
+var renewLeaseScript = redis.NewScript(`
+if redis.call("ZADD", KEYS[1], "XX", "CH", ARGV[1], ARGV[3]) == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// ConcurrencyConfig sets how many enhancements each caller may have in
+// flight at once, by tier. A limit of zero or less means unlimited.
+type ConcurrencyConfig struct {
+	Limits map[string]int
+	// LeaseTTL is how long a slot survives without being renewed, which
+	// bounds how long slots leaked by a crashed instance stay taken
+	LeaseTTL time.Duration
+}
+
+// LoadConcurrencyConfig reads CONCURRENCY_LIMIT_<ANONYMOUS|FREE|PRO|ENTERPRISE>
+// and CONCURRENCY_LEASE_TTL
+func LoadConcurrencyConfig() ConcurrencyConfig {
+	config := ConcurrencyConfig{
+		Limits: map[string]int{
+			TierAnonymous:  1,
+			TierFree:       2,
+			TierPro:        5,
+			TierEnterprise: 20,
+		},
+		LeaseTTL: time.Minute,
+	}
+	for tier := range config.Limits {
+		if v, err := strconv.Atoi(os.Getenv("CONCURRENCY_LIMIT_" + strings.ToUpper(tier))); err == nil {
+			config.Limits[tier] = v
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("CONCURRENCY_LEASE_TTL")); err == nil && d > 0 {
+		config.LeaseTTL = d
+	}
+	return config
+}
+
+// Limit returns the in-flight limit for a tier. Unknown tiers get the free
+// tier's limit.
+func (c ConcurrencyConfig) Limit(tier string) int {
+	if limit, ok := c.Limits[tier]; ok {
+		return limit
+	}
+	return c.Limits[TierFree]
+}
+
+// ConcurrencyLease is a held slot, released once the enhancement finishes
+type ConcurrencyLease struct {
+	key   string
+	token string
+}
+
+// ConcurrencyLimiter caps simultaneous in-flight enhancements per caller
+// with Redis semaphores shared by every gateway instance. It is distinct
+// from rate limiting: a caller under their rate limit can still be refused
+// while too many of their earlier requests are running.
+type ConcurrencyLimiter struct {
+	cache  *CacheService
+	config ConcurrencyConfig
+}
+
+// NewConcurrencyLimiter creates a concurrency limiter
+func NewConcurrencyLimiter(cache *CacheService, config ConcurrencyConfig) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		cache:  cache,
+		config: config,
+	}
+}
+
+// Config returns the limits the limiter enforces
+func (l *ConcurrencyLimiter) Config() ConcurrencyConfig {
+	return l.config
+}
+
+func (l *ConcurrencyLimiter) semaphoreKey(caller string) string {
+	return l.cache.Key("concurrency", caller)
+}
+
+// Acquire takes one of caller's slots, returning a nil lease along with the
+// number of slots held when all of them are taken
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, caller, tier string, limit int) (*ConcurrencyLease, int, error) {
+	lease := &ConcurrencyLease{key: l.semaphoreKey(caller), token: uuid.New().String()}
+	result, err := acquireLeaseScript.Run(ctx, l.cache.client, []string{lease.key},
+		time.Now().UnixMilli(), l.config.LeaseTTL.Milliseconds(), limit, lease.token).Int64Slice()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to acquire concurrency slot: %w", err)
+	}
+	if result[0] == 0 {
+		concurrencyLimited.WithLabelValues(tier).Inc()
+		return nil, int(result[1]), nil
+	}
+	return lease, int(result[1]), nil
+}
+
+// Renew extends a lease by another TTL, returning false when it had
+// already expired
+func (l *ConcurrencyLimiter) Renew(ctx context.Context, lease *ConcurrencyLease) (bool, error) {
+	renewed, err := renewLeaseScript.Run(ctx, l.cache.client, []string{lease.key},
+		time.Now().UnixMilli(), l.config.LeaseTTL.Milliseconds(), lease.token).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew concurrency slot: %w", err)
+	}
+	return renewed == 1, nil
+}
+
+// Release frees a lease's slot
+func (l *ConcurrencyLimiter) Release(ctx context.Context, lease *ConcurrencyLease) error {
+	if err := l.cache.client.ZRem(ctx, lease.key, lease.token).Err(); err != nil {
+		return fmt.Errorf("failed to release concurrency slot: %w", err)
+	}
+	return nil
+}
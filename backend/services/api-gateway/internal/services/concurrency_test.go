@@ -0,0 +1,24 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConcurrencyConfig(t *testing.T) {
+	t.Setenv("CONCURRENCY_LIMIT_PRO", "8")
+	t.Setenv("CONCURRENCY_LIMIT_ENTERPRISE", "0")
+	t.Setenv("CONCURRENCY_LIMIT_FREE", "lots")
+	t.Setenv("CONCURRENCY_LEASE_TTL", "90s")
+
+	config := LoadConcurrencyConfig()
+
+	assert.Equal(t, 8, config.Limit(TierPro))
+	assert.Equal(t, 0, config.Limit(TierEnterprise), "zero disables the limit")
+	assert.Equal(t, 2, config.Limit(TierFree), "invalid values keep the default")
+	assert.Equal(t, 1, config.Limit(TierAnonymous))
+	assert.Equal(t, 2, config.Limit("legacy"), "unknown tiers get the free limit")
+	assert.Equal(t, 90*time.Second, config.LeaseTTL)
+}
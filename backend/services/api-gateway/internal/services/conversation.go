@@ -0,0 +1,50 @@
+package services
+
+import (
+	"strings"
+)
+
+// ChatMessage is a single turn of a multi-turn conversation. Each turn is
+// bounded like a single prompt's text.
+type ChatMessage struct {
+	Role    string `json:"role" binding:"required,oneof=system user assistant"`
+	Content string `json:"content" binding:"required,max=5000"`
+}
+
+// LastUserMessage returns the content of the most recent user turn
+func LastUserMessage(messages []ChatMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return strings.TrimSpace(messages[i].Content)
+		}
+	}
+	return ""
+}
+
+// FlattenConversation renders a conversation as "role: content" lines for
+// intent classification. The most recent turns are kept when the result
+// would exceed maxLen characters, so the current request always survives.
+func FlattenConversation(messages []ChatMessage, maxLen int) string {
+	var lines []string
+	length := 0
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		line := messages[i].Role + ": " + strings.TrimSpace(messages[i].Content)
+		if maxLen > 0 && length+len(line) > maxLen {
+			if len(lines) == 0 {
+				// Keep the tail of an oversized final turn
+				lines = append(lines, line[len(line)-maxLen:])
+			}
+			break
+		}
+		lines = append(lines, line)
+		length += len(line) + 1
+	}
+
+	// Restore chronological order
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenConversation(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "You are a helpful assistant."},
+		{Role: "user", Content: "I need to write a cover letter."},
+		{Role: "assistant", Content: "Sure, what role is it for?"},
+		{Role: "user", Content: "  A backend engineering position  "},
+	}
+
+	tests := []struct {
+		name     string
+		maxLen   int
+		expected string
+	}{
+		{
+			name:   "no limit keeps every turn",
+			maxLen: 0,
+			expected: "system: You are a helpful assistant.\n" +
+				"user: I need to write a cover letter.\n" +
+				"assistant: Sure, what role is it for?\n" +
+				"user: A backend engineering position",
+		},
+		{
+			name:   "limit drops the oldest turns",
+			maxLen: 80,
+			expected: "assistant: Sure, what role is it for?\n" +
+				"user: A backend engineering position",
+		},
+		{
+			name:     "oversized final turn is truncated",
+			maxLen:   10,
+			expected: "g position",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FlattenConversation(messages, tt.maxLen))
+		})
+	}
+
+	assert.Equal(t, "A backend engineering position", LastUserMessage(messages))
+	assert.Empty(t, LastUserMessage(messages[:1]))
+}
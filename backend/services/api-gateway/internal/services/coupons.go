@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+var (
+	// ErrCouponNotFound is returned for unknown coupon codes
+	ErrCouponNotFound = errors.New("coupon not found")
+	// ErrCouponExists is returned when creating a coupon whose code is taken
+	ErrCouponExists = errors.New("a coupon with this code already exists")
+	// ErrCouponUnavailable is returned for coupons that can't be redeemed now
+	ErrCouponUnavailable = errors.New("coupon is expired, inactive or fully redeemed")
+	// ErrCouponRedeemed is returned when a user redeems a coupon twice
+	ErrCouponRedeemed = errors.New("coupon already redeemed")
+)
+
+// Coupon is an admin-issued promo code granting extra enhancements
+type Coupon struct {
+	Code           string     `json:"code"`
+	Description    string     `json:"description"`
+	BonusQuota     int        `json:"bonus_quota"`
+	MaxRedemptions *int       `json:"max_redemptions,omitempty"` // Unlimited when nil
+	Redemptions    int        `json:"redemptions"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	Active         bool       `json:"active"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Redeemable reports whether the coupon can be redeemed at now
+func (c *Coupon) Redeemable(now time.Time) bool {
+	if !c.Active {
+		return false
+	}
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return false
+	}
+	return c.MaxRedemptions == nil || c.Redemptions < *c.MaxRedemptions
+}
+
+// normalizeCouponCode matches codes however they were typed
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// NormalizeCoupon uppercases the code and checks the coupon is valid
+func NormalizeCoupon(coupon *Coupon) error {
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	coupon.Description = strings.TrimSpace(coupon.Description)
+	if !couponCodePattern.MatchString(coupon.Code) {
+		return errors.New("code must be 3 to 32 letters, digits, dashes or underscores")
+	}
+	if coupon.BonusQuota <= 0 {
+		return errors.New("bonus_quota must be positive")
+	}
+	if coupon.MaxRedemptions != nil && *coupon.MaxRedemptions <= 0 {
+		return errors.New("max_redemptions must be positive")
+	}
+	return nil
+}
+
+// CouponService manages promo coupons and their redemptions. Each user can
+// redeem a coupon once, and the redemption count is taken atomically so a
+// coupon is never redeemed past its limit.
+type CouponService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewCouponService creates a new coupon service
+func NewCouponService(db *DatabaseService, logger *logrus.Logger) *CouponService {
+	return &CouponService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const couponColumns = `code, description, bonus_quota, max_redemptions, redemptions,
+	expires_at, active, COALESCE(created_by::text, ''), created_at, updated_at`
+
+func scanCoupon(row rowScanner) (*Coupon, error) {
+	var c Coupon
+	var maxRedemptions sql.NullInt64
+	var expiresAt sql.NullTime
+	if err := row.Scan(&c.Code, &c.Description, &c.BonusQuota, &maxRedemptions, &c.Redemptions,
+		&expiresAt, &c.Active, &c.CreatedBy, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if maxRedemptions.Valid {
+		max := int(maxRedemptions.Int64)
+		c.MaxRedemptions = &max
+	}
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+	return &c, nil
+}
+
+// ListCoupons returns every coupon, newest first
+func (s *CouponService) ListCoupons(ctx context.Context) ([]*Coupon, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+couponColumns+`
+		FROM billing.coupons
+		ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query coupons: %w", err)
+	}
+	defer rows.Close()
+
+	coupons := []*Coupon{}
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan coupon: %w", err)
+		}
+		coupons = append(coupons, coupon)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate coupons: %w", err)
+	}
+	return coupons, nil
+}
+
+// GetCoupon returns one coupon
+func (s *CouponService) GetCoupon(ctx context.Context, code string) (*Coupon, error) {
+	coupon, err := scanCoupon(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+couponColumns+`
+		FROM billing.coupons
+		WHERE code = $1`, normalizeCouponCode(code)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get coupon: %w", err)
+	}
+	return coupon, nil
+}
+
+// CheckCoupon returns a coupon that can be redeemed now
+func (s *CouponService) CheckCoupon(ctx context.Context, code string) (*Coupon, error) {
+	coupon, err := s.GetCoupon(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	if !coupon.Redeemable(time.Now()) {
+		return nil, ErrCouponUnavailable
+	}
+	return coupon, nil
+}
+
+// CreateCoupon stores a new coupon. The coupon must have been normalized.
+func (s *CouponService) CreateCoupon(ctx context.Context, coupon Coupon, adminID string) (*Coupon, error) {
+	created, err := scanCoupon(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO billing.coupons (code, description, bonus_quota, max_redemptions, expires_at, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid)
+		RETURNING `+couponColumns,
+		coupon.Code, coupon.Description, coupon.BonusQuota, coupon.MaxRedemptions, coupon.ExpiresAt, coupon.Active, adminID,
+	))
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrCouponExists
+		}
+		return nil, fmt.Errorf("failed to create coupon: %w", err)
+	}
+
+	s.audit(created, adminID, "Coupon created")
+	return created, nil
+}
+
+// UpdateCoupon replaces a coupon's terms. Its code and redemptions stay.
+func (s *CouponService) UpdateCoupon(ctx context.Context, code string, coupon Coupon, adminID string) (*Coupon, error) {
+	updated, err := scanCoupon(s.db.DB.QueryRowContext(ctx, `
+		UPDATE billing.coupons
+		SET description = $2, bonus_quota = $3, max_redemptions = $4, expires_at = $5,
+			active = $6, updated_at = CURRENT_TIMESTAMP
+		WHERE code = $1
+		RETURNING `+couponColumns,
+		normalizeCouponCode(code), coupon.Description, coupon.BonusQuota, coupon.MaxRedemptions, coupon.ExpiresAt, coupon.Active,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update coupon: %w", err)
+	}
+
+	s.audit(updated, adminID, "Coupon updated")
+	return updated, nil
+}
+
+// DeleteCoupon removes a coupon along with its redemption records. Bonuses
+// already granted are kept.
+func (s *CouponService) DeleteCoupon(ctx context.Context, code, adminID string) error {
+	code = normalizeCouponCode(code)
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM billing.coupons WHERE code = $1`, code)
+	if err != nil {
+		return fmt.Errorf("failed to delete coupon: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrCouponNotFound
+	}
+
+	s.audit(&Coupon{Code: code}, adminID, "Coupon deleted")
+	return nil
+}
+
+// Redeem grants a user a coupon's bonus
+func (s *CouponService) Redeem(ctx context.Context, userID, code string) (*Coupon, error) {
+	code = normalizeCouponCode(code)
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	coupon, err := scanCoupon(tx.QueryRowContext(ctx, `
+		UPDATE billing.coupons
+		SET redemptions = redemptions + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE code = $1 AND active
+		  AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		  AND (max_redemptions IS NULL OR redemptions < max_redemptions)
+		RETURNING `+couponColumns, code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCouponUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem coupon: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO billing.coupon_redemptions (code, user_id, bonus_quota)
+		VALUES ($1, $2, $3)`, code, userID, coupon.BonusQuota)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrCouponRedeemed
+		}
+		return nil, fmt.Errorf("failed to record coupon redemption: %w", err)
+	}
+	if err := addBonusQuota(ctx, tx, userID, coupon.BonusQuota); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit coupon redemption: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":     userID,
+		"code":        code,
+		"bonus_quota": coupon.BonusQuota,
+	}).Info("Coupon redeemed")
+	return coupon, nil
+}
+
+func (s *CouponService) audit(coupon *Coupon, adminID, message string) {
+	s.logger.WithFields(logrus.Fields{
+		"audit":       true,
+		"updated_by":  adminID,
+		"code":        coupon.Code,
+		"bonus_quota": coupon.BonusQuota,
+		"active":      coupon.Active,
+	}).Info(message)
+}
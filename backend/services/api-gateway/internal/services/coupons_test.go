@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeCoupon(t *testing.T) {
+	coupon := Coupon{Code: " launch-2026 ", Description: " Launch week ", BonusQuota: 200}
+	require.NoError(t, NormalizeCoupon(&coupon))
+	assert.Equal(t, "LAUNCH-2026", coupon.Code)
+	assert.Equal(t, "Launch week", coupon.Description)
+
+	zero := 0
+	for _, invalid := range []Coupon{
+		{Code: "X", BonusQuota: 10},
+		{Code: "HAS SPACE", BonusQuota: 10},
+		{Code: "FREE", BonusQuota: 0},
+		{Code: "FREE", BonusQuota: 10, MaxRedemptions: &zero},
+	} {
+		assert.Error(t, NormalizeCoupon(&invalid), invalid.Code)
+	}
+}
+
+func TestCouponRedeemable(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	max := 2
+
+	assert.True(t, (&Coupon{Active: true}).Redeemable(now))
+	assert.False(t, (&Coupon{Active: false}).Redeemable(now))
+	assert.False(t, (&Coupon{Active: true, ExpiresAt: &past}).Redeemable(now))
+	assert.True(t, (&Coupon{Active: true, MaxRedemptions: &max, Redemptions: 1}).Redeemable(now))
+	assert.False(t, (&Coupon{Active: true, MaxRedemptions: &max, Redemptions: 2}).Redeemable(now))
+}
+
+func TestRedeemCoupon(t *testing.T) {
+	now := time.Now()
+	couponRow := []driver.Value{"LAUNCH", "", int64(200), nil, int64(1), nil, true, "", now, now}
+	columns := []string{"code", "description", "bonus_quota", "max_redemptions", "redemptions",
+		"expires_at", "active", "created_by", "created_at", "updated_at"}
+
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return columns, [][]driver.Value{couponRow}
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	coupons := NewCouponService(NewDatabaseService(db.DB), logger)
+
+	coupon, err := coupons.Redeem(context.Background(), "user-1", " launch ")
+	require.NoError(t, err)
+	assert.Equal(t, 200, coupon.BonusQuota)
+	entries := d.entries()
+	assert.True(t, strings.HasPrefix(entries[len(entries)-2], "UPDATE auth.users SET bonus_quota"), "the bonus is granted")
+
+	d.fail = func(query string) error {
+		if strings.Contains(query, "coupon_redemptions") {
+			return &pq.Error{Code: "23505"}
+		}
+		return nil
+	}
+	_, err = coupons.Redeem(context.Background(), "user-1", "LAUNCH")
+	assert.ErrorIs(t, err, ErrCouponRedeemed)
+
+	d.rows = func(string) ([]string, [][]driver.Value) { return columns, nil }
+	_, err = coupons.Redeem(context.Background(), "user-1", "LAUNCH")
+	assert.ErrorIs(t, err, ErrCouponUnavailable, "expired, inactive and exhausted coupons aren't redeemed")
+}
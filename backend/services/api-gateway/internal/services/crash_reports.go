@@ -0,0 +1,438 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultCrashReportWindow is how long identical crashes are suppressed
+	// after one is sent
+	defaultCrashReportWindow = 10 * time.Minute
+	// crashSendTimeout bounds the background delivery of a report
+	crashSendTimeout = 10 * time.Second
+	// maxBreadcrumbs is how many recent log entries reports carry
+	maxBreadcrumbs = 30
+	// signatureFrames is how many application frames identify a crash
+	signatureFrames = 5
+	// filteredValue replaces sensitive values in reports
+	filteredValue = "[Filtered]"
+)
+
+// sensitiveKeyParts mark headers, query parameters and log fields whose
+// values never leave the gateway
+var sensitiveKeyParts = []string{"authorization", "cookie", "token", "secret", "password", "api-key", "api_key", "apikey", "session", "signature"}
+
+// CrashFrame is one stack frame of a crash, innermost last as Sentry expects
+type CrashFrame struct {
+	Function string `json:"function"`
+	File     string `json:"filename"`
+	Line     int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// CrashRequest is the sanitized request that crashed
+type CrashRequest struct {
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Route     string            `json:"route,omitempty"`
+	Query     map[string]string `json:"query,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+	UserID    string            `json:"user_id,omitempty"`
+}
+
+// Breadcrumb is a log entry leading up to a crash
+type Breadcrumb struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	Data      map[string]string `json:"data,omitempty"`
+}
+
+// CrashReport describes a recovered panic
+type CrashReport struct {
+	ID          string        `json:"id"`
+	Signature   string        `json:"signature"`
+	Timestamp   time.Time     `json:"timestamp"`
+	Environment string        `json:"environment,omitempty"`
+	PanicType   string        `json:"panic_type"`
+	Message     string        `json:"message"`
+	Frames      []CrashFrame  `json:"frames"`
+	Stack       string        `json:"stack"` // The crashing goroutine, as runtime/debug prints it
+	Request     *CrashRequest `json:"request,omitempty"`
+	Breadcrumbs []Breadcrumb  `json:"breadcrumbs"`
+	// Suppressed counts identical crashes not reported since the last one
+	// that was
+	Suppressed int `json:"suppressed,omitempty"`
+}
+
+// NewCrashReport builds a report for a recovered panic value. skip is the
+// number of stack frames above the recover call to leave out.
+func NewCrashReport(recovered interface{}, stack []byte, skip int) *CrashReport {
+	report := &CrashReport{
+		ID:          newEventID(),
+		Timestamp:   time.Now().UTC(),
+		PanicType:   fmt.Sprintf("%T", recovered),
+		Message:     fmt.Sprint(recovered),
+		Stack:       string(stack),
+		Breadcrumbs: []Breadcrumb{},
+	}
+	if err, ok := recovered.(error); ok {
+		report.Message = err.Error()
+	}
+
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		// Frames above the panic are the recovery machinery
+		if frame.Function == "runtime.gopanic" {
+			report.Frames = report.Frames[:0]
+			if !more {
+				break
+			}
+			continue
+		}
+		report.Frames = append(report.Frames, CrashFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+			InApp:    strings.Contains(frame.Function, "betterprompts/"),
+		})
+		if !more {
+			break
+		}
+	}
+	// Sentry lists the innermost frame last
+	for i, j := 0, len(report.Frames)-1; i < j; i, j = i+1, j-1 {
+		report.Frames[i], report.Frames[j] = report.Frames[j], report.Frames[i]
+	}
+
+	report.Signature = crashSignature(report.PanicType, report.Frames)
+	return report
+}
+
+// crashSignature identifies crashes by panic type and innermost application
+// frames, so the same bug hit with different values groups together
+func crashSignature(panicType string, frames []CrashFrame) string {
+	h := sha256.New()
+	h.Write([]byte(panicType))
+	n := 0
+	for i := len(frames) - 1; i >= 0 && n < signatureFrames; i-- {
+		if frames[i].InApp {
+			fmt.Fprintf(h, "|%s:%d", frames[i].Function, frames[i].Line)
+			n++
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// SanitizeCrashValues copies values, replacing those under sensitive keys
+func SanitizeCrashValues(values map[string][]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	sanitized := make(map[string]string, len(values))
+	for key, value := range values {
+		if sensitiveKey(key) {
+			sanitized[key] = filteredValue
+		} else {
+			sanitized[key] = strings.Join(value, ",")
+		}
+	}
+	return sanitized
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// BreadcrumbHook is a logrus hook keeping the most recent log entries, which
+// crash reports carry to show what led up to a panic
+type BreadcrumbHook struct {
+	mu      sync.Mutex
+	entries []Breadcrumb
+	next    int
+}
+
+// NewBreadcrumbHook creates a hook keeping the last 30 entries
+func NewBreadcrumbHook() *BreadcrumbHook {
+	return &BreadcrumbHook{entries: make([]Breadcrumb, 0, maxBreadcrumbs)}
+}
+
+// Levels records entries at every level the logger emits
+func (h *BreadcrumbHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire records an entry, filtering sensitive fields
+func (h *BreadcrumbHook) Fire(entry *logrus.Entry) error {
+	crumb := Breadcrumb{
+		Timestamp: entry.Time.UTC(),
+		Level:     entry.Level.String(),
+		Message:   entry.Message,
+	}
+	if len(entry.Data) > 0 {
+		crumb.Data = make(map[string]string, len(entry.Data))
+		for key, value := range entry.Data {
+			if sensitiveKey(key) {
+				crumb.Data[key] = filteredValue
+			} else {
+				crumb.Data[key] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) < maxBreadcrumbs {
+		h.entries = append(h.entries, crumb)
+	} else {
+		h.entries[h.next] = crumb
+	}
+	h.next = (h.next + 1) % maxBreadcrumbs
+	return nil
+}
+
+// Recent returns the kept entries, oldest first
+func (h *BreadcrumbHook) Recent() []Breadcrumb {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < maxBreadcrumbs {
+		return append([]Breadcrumb(nil), h.entries...)
+	}
+	return append(append([]Breadcrumb(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+}
+
+// CrashSink delivers crash reports to an error tracker
+type CrashSink interface {
+	Send(ctx context.Context, report *CrashReport) error
+}
+
+// LogCrashSink writes crash reports to the log, for deployments without an
+// error tracker
+type LogCrashSink struct {
+	logger *logrus.Logger
+}
+
+// Send logs the report
+func (s *LogCrashSink) Send(ctx context.Context, report *CrashReport) error {
+	s.logger.WithFields(logrus.Fields{
+		"crash_id":   report.ID,
+		"signature":  report.Signature,
+		"panic_type": report.PanicType,
+		"request":    report.Request,
+		"suppressed": report.Suppressed,
+		"stack":      report.Stack,
+	}).Error("Crash report: " + report.Message)
+	return nil
+}
+
+// SentrySink sends crash reports to Sentry, or any tracker accepting
+// Sentry's store API
+type SentrySink struct {
+	endpoint string
+	key      string
+	client   *http.Client
+}
+
+// NewSentrySink creates a sink from a DSN such as
+// https://<key>@sentry.example.com/<project>
+func NewSentrySink(dsn string) (*SentrySink, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid crash report DSN")
+	}
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("crash report DSN has no project")
+	}
+
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+	return &SentrySink{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:      u.User.Username(),
+		client:   &http.Client{Timeout: crashSendTimeout},
+	}, nil
+}
+
+// sentryEvent converts a report to a Sentry event
+func sentryEvent(report *CrashReport) map[string]interface{} {
+	event := map[string]interface{}{
+		"event_id":    report.ID,
+		"timestamp":   report.Timestamp.Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"logger":      "api-gateway",
+		"environment": report.Environment,
+		"fingerprint": []string{report.Signature},
+		"exception": map[string]interface{}{
+			"values": []map[string]interface{}{{
+				"type":       report.PanicType,
+				"value":      report.Message,
+				"stacktrace": map[string]interface{}{"frames": report.Frames},
+			}},
+		},
+		"breadcrumbs": map[string]interface{}{"values": report.Breadcrumbs},
+		"extra": map[string]interface{}{
+			"stack":      report.Stack,
+			"suppressed": report.Suppressed,
+		},
+	}
+	if r := report.Request; r != nil {
+		event["request"] = map[string]interface{}{
+			"method":       r.Method,
+			"url":          r.Path,
+			"query_string": r.Query,
+			"headers":      r.Headers,
+		}
+		event["tags"] = map[string]string{"route": r.Route, "request_id": r.RequestID}
+		if r.UserID != "" {
+			event["user"] = map[string]string{"id": r.UserID}
+		}
+	}
+	return event
+}
+
+// Send posts the report as a Sentry event
+func (s *SentrySink) Send(ctx context.Context, report *CrashReport) error {
+	body, err := json.Marshal(sentryEvent(report))
+	if err != nil {
+		return fmt.Errorf("failed to encode crash report: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+		"Sentry sentry_version=7, sentry_client=betterprompts-api-gateway/1.0, sentry_key=%s", s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send crash report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("crash report rejected with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// CrashReporter sends crash reports in the background, at most one per
+// signature per window; the next one sent says how many were suppressed
+type CrashReporter struct {
+	sink        CrashSink
+	breadcrumbs *BreadcrumbHook
+	environment string
+	window      time.Duration
+	logger      *logrus.Logger
+
+	mu         sync.Mutex
+	lastSent   map[string]time.Time
+	suppressed map[string]int
+}
+
+// NewCrashReporter creates a crash reporter. breadcrumbs may be nil.
+func NewCrashReporter(sink CrashSink, breadcrumbs *BreadcrumbHook, environment string, window time.Duration, logger *logrus.Logger) *CrashReporter {
+	return &CrashReporter{
+		sink:        sink,
+		breadcrumbs: breadcrumbs,
+		environment: environment,
+		window:      window,
+		logger:      logger,
+		lastSent:    make(map[string]time.Time),
+		suppressed:  make(map[string]int),
+	}
+}
+
+// NewCrashReporterFromEnv sends to the Sentry-compatible tracker at
+// CRASH_REPORT_DSN, or to the log when it is unset, suppressing identical
+// crashes for CRASH_REPORT_WINDOW (default 10m). It installs a breadcrumb
+// hook on logger.
+func NewCrashReporterFromEnv(environment string, logger *logrus.Logger) (*CrashReporter, error) {
+	var sink CrashSink = &LogCrashSink{logger: logger}
+	if dsn := os.Getenv("CRASH_REPORT_DSN"); dsn != "" {
+		sentry, err := NewSentrySink(dsn)
+		if err != nil {
+			return nil, err
+		}
+		sink = sentry
+	}
+	window := defaultCrashReportWindow
+	if d, err := time.ParseDuration(os.Getenv("CRASH_REPORT_WINDOW")); err == nil && d >= 0 {
+		window = d
+	}
+
+	breadcrumbs := NewBreadcrumbHook()
+	logger.AddHook(breadcrumbs)
+	return NewCrashReporter(sink, breadcrumbs, environment, window, logger), nil
+}
+
+// Report sends a report unless an identical crash was sent within the
+// window, returning whether it will be sent
+func (r *CrashReporter) Report(report *CrashReport) bool {
+	r.mu.Lock()
+	if last, ok := r.lastSent[report.Signature]; ok && time.Since(last) < r.window {
+		r.suppressed[report.Signature]++
+		r.mu.Unlock()
+		return false
+	}
+	r.lastSent[report.Signature] = time.Now()
+	report.Suppressed = r.suppressed[report.Signature]
+	delete(r.suppressed, report.Signature)
+	for signature, sent := range r.lastSent {
+		if time.Since(sent) >= r.window && r.suppressed[signature] == 0 {
+			delete(r.lastSent, signature)
+		}
+	}
+	r.mu.Unlock()
+
+	report.Environment = r.environment
+	if r.breadcrumbs != nil {
+		report.Breadcrumbs = r.breadcrumbs.Recent()
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), crashSendTimeout)
+		defer cancel()
+		if err := r.sink.Send(ctx, report); err != nil {
+			r.logger.WithError(err).WithField("crash_id", report.ID).Warn("Failed to send crash report")
+		}
+	}()
+	return true
+}
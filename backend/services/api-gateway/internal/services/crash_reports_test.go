@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func crashAt(value interface{}) (report *CrashReport) {
+	defer func() {
+		report = NewCrashReport(recover(), nil, 1)
+	}()
+	panic(value)
+}
+
+func TestCrashSignatureGroupsByLocation(t *testing.T) {
+	var reports []*CrashReport
+	for _, id := range []int{1, 2} {
+		reports = append(reports, crashAt(fmt.Errorf("user %d not found", id)))
+	}
+	first, second := reports[0], reports[1]
+	other := crashAt("a string panic")
+
+	assert.Equal(t, first.Signature, second.Signature, "the same bug with different values groups together")
+	assert.NotEqual(t, first.Signature, other.Signature)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, "user 1 not found", first.Message)
+
+	last := first.Frames[len(first.Frames)-1]
+	assert.True(t, last.InApp)
+	assert.Contains(t, last.Function, "crashAt", "the innermost frame is last")
+}
+
+type countingSink struct {
+	reports chan *CrashReport
+}
+
+func (s *countingSink) Send(ctx context.Context, report *CrashReport) error {
+	s.reports <- report
+	return nil
+}
+
+func TestCrashReporterSuppressesIdenticalCrashes(t *testing.T) {
+	sink := &countingSink{reports: make(chan *CrashReport, 10)}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	reporter := NewCrashReporter(sink, nil, "test", 20*time.Millisecond, logger)
+
+	report := func(signature string) bool {
+		return reporter.Report(&CrashReport{ID: newEventID(), Signature: signature})
+	}
+	assert.True(t, report("a"))
+	assert.False(t, report("a"))
+	assert.False(t, report("a"))
+	assert.True(t, report("b"), "other crashes aren't held back")
+
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, report("a"))
+
+	var suppressed []int
+	for i := 0; i < 3; i++ {
+		suppressed = append(suppressed, (<-sink.reports).Suppressed)
+	}
+	assert.ElementsMatch(t, []int{0, 0, 2}, suppressed)
+}
+
+func TestBreadcrumbHook(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	hook := NewBreadcrumbHook()
+	logger.AddHook(hook)
+
+	for i := 0; i < maxBreadcrumbs+5; i++ {
+		logger.WithField("api_key", "bp_live_123").Infof("entry %d", i)
+	}
+
+	recent := hook.Recent()
+	require.Len(t, recent, maxBreadcrumbs)
+	assert.Equal(t, "entry 5", recent[0].Message)
+	assert.Equal(t, fmt.Sprintf("entry %d", maxBreadcrumbs+4), recent[len(recent)-1].Message)
+	assert.Equal(t, filteredValue, recent[0].Data["api_key"])
+}
+
+func TestSentrySink(t *testing.T) {
+	var auth string
+	var event map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/errors/api/42/store/", r.URL.Path)
+		auth = r.Header.Get("X-Sentry-Auth")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+	}))
+	defer server.Close()
+
+	_, err := NewSentrySink("https://sentry.example.com/42")
+	assert.Error(t, err, "the DSN must carry a key")
+
+	sink, err := NewSentrySink(strings.Replace(server.URL, "http://", "http://pubkey@", 1) + "/errors/42")
+	require.NoError(t, err)
+
+	report := crashAt(errors.New("boom"))
+	report.Request = &CrashRequest{Method: http.MethodPost, Path: "/api/v1/enhance", UserID: "user-1"}
+	require.NoError(t, sink.Send(context.Background(), report))
+
+	assert.Contains(t, auth, "sentry_key=pubkey")
+	assert.Equal(t, report.ID, event["event_id"])
+	assert.Equal(t, []interface{}{report.Signature}, event["fingerprint"])
+	assert.Equal(t, map[string]interface{}{"id": "user-1"}, event["user"])
+}
@@ -10,17 +10,69 @@ import (
 
 	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
 )
 
 // DatabaseService extends sql.DB with application-specific methods
 type DatabaseService struct {
 	*sql.DB
+
+	// queries runs the history statements through the statement cache, and
+	// the history operation timeout when the service was created with one
+	queries dbtx
+
+	// repos write batches, on the same statement cache and timeouts
+	repos Repositories
+
+	// writeBehind queues history writes when enabled
+	writeBehind *WriteBehindQueue
 }
 
 // NewDatabaseService creates a new database service
 func NewDatabaseService(db *sql.DB) *DatabaseService {
-	return &DatabaseService{DB: db}
+	return NewDatabaseServiceWithTimeouts(db, DBTimeoutConfig{}, nil)
+}
+
+// NewDatabaseServiceWithTimeouts creates a database service whose statements
+// are bounded by the history operation timeout, logging slow ones to logger
+func NewDatabaseServiceWithTimeouts(db *sql.DB, timeouts DBTimeoutConfig, logger *logrus.Logger) *DatabaseService {
+	prepared := &preparedDB{stmts: newStmtCache(sqlx.NewDb(db, "postgres"))}
+	return &DatabaseService{
+		DB:      db,
+		queries: timeouts.wrap(prepared, "history", logger),
+		repos:   newRepositories(prepared, timeouts, logger),
+	}
+}
+
+// EnableWriteBehind makes SavePromptHistory queue entries and write them in
+// batches. Queued entries aren't readable until their batch is written;
+// FlushWrites waits for them.
+func (db *DatabaseService) EnableWriteBehind(config BatchWriteConfig, logger *logrus.Logger) {
+	db.writeBehind = NewWriteBehindQueue(db.repos, config, logger)
+}
+
+// WriteBehind returns the write-behind queue, nil unless enabled
+func (db *DatabaseService) WriteBehind() *WriteBehindQueue {
+	return db.writeBehind
+}
+
+// FlushWrites writes every queued entry. It returns at once without
+// write-behind.
+func (db *DatabaseService) FlushWrites(ctx context.Context) error {
+	if db.writeBehind == nil {
+		return nil
+	}
+	return db.writeBehind.Flush(ctx)
+}
+
+// Close writes any queued entries and closes the database
+func (db *DatabaseService) Close() error {
+	if db.writeBehind != nil {
+		db.writeBehind.Close()
+	}
+	return db.DB.Close()
 }
 
 // SavePromptHistory saves a prompt history entry to the database
@@ -30,6 +82,11 @@ func (db *DatabaseService) SavePromptHistory(ctx context.Context, entry models.P
 	entry.ID = id
 	entry.CreatedAt = time.Now()
 
+	// The ID is known up front, so queued entries can be returned at once
+	if db.writeBehind != nil && db.writeBehind.SavePromptHistory(&entry) {
+		return id, nil
+	}
+
 	query := `
 		INSERT INTO prompts.history (
 			id, user_id, original_input, enhanced_output,
@@ -43,17 +100,20 @@ func (db *DatabaseService) SavePromptHistory(ctx context.Context, entry models.P
 	// Convert techniques slice to PostgreSQL array
 	techniquesArray := pq.Array(entry.TechniquesUsed)
 
-	_, err := db.ExecContext(ctx, query,
-		entry.ID,
-		entry.UserID.String,
-		entry.OriginalInput,
-		entry.EnhancedOutput,
-		entry.Intent.String,  // intent
-		entry.Complexity.String, // complexity
-		techniquesArray,
-		metadataJSON,
-		entry.CreatedAt,
-	)
+	err := insertHistory(ctx, db.queries, []time.Time{entry.CreatedAt}, func() error {
+		_, err := db.queries.ExecContext(ctx, query,
+			entry.ID,
+			entry.UserID.String,
+			entry.OriginalInput,
+			entry.EnhancedOutput,
+			entry.Intent.String,  // intent
+			entry.Complexity.String, // complexity
+			techniquesArray,
+			metadataJSON,
+			entry.CreatedAt,
+		)
+		return err
+	})
 
 	if err != nil {
 		return "", fmt.Errorf("failed to save prompt history: %w", err)
@@ -62,6 +122,24 @@ func (db *DatabaseService) SavePromptHistory(ctx context.Context, entry models.P
 	return id, nil
 }
 
+// SavePromptHistoryBatch saves entries in a single statement and returns
+// their IDs in order
+func (db *DatabaseService) SavePromptHistoryBatch(ctx context.Context, entries []models.PromptHistory) ([]string, error) {
+	batch := make([]*models.PromptHistory, len(entries))
+	ids := make([]string, len(entries))
+	for i := range entries {
+		entry := entries[i]
+		entry.ID = uuid.New().String()
+		batch[i] = &entry
+		ids[i] = entry.ID
+	}
+
+	if err := db.repos.History.SavePromptHistoryBatch(ctx, batch); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
 // GetPromptHistory retrieves a prompt history entry by ID
 func (db *DatabaseService) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
 	query := `
@@ -81,7 +159,7 @@ func (db *DatabaseService) GetPromptHistory(ctx context.Context, id string) (*mo
 	var feedbackText sql.NullString
 	var updatedAt sql.NullTime
 
-	err := db.QueryRowContext(ctx, query, id).Scan(
+	err := db.queries.QueryRowContext(ctx, query, id).Scan(
 		&entry.ID,
 		&userID,
 		&entry.OriginalInput,
@@ -98,7 +176,7 @@ func (db *DatabaseService) GetPromptHistory(ctx context.Context, id string) (*mo
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("prompt history not found")
+			return nil, ErrPromptHistoryNotFound
 		}
 		return nil, fmt.Errorf("failed to get prompt history: %w", err)
 	}
@@ -124,6 +202,11 @@ func (db *DatabaseService) GetPromptHistory(ctx context.Context, id string) (*mo
 	return &entry, nil
 }
 
+// GetSavedPrompt retrieves a saved prompt by ID
+func (db *DatabaseService) GetSavedPrompt(ctx context.Context, id string) (*models.SavedPrompt, error) {
+	return db.repos.Library.GetSavedPrompt(ctx, id)
+}
+
 // GetUserPromptHistory retrieves prompt history for a user
 func (db *DatabaseService) GetUserPromptHistory(ctx context.Context, userID string, limit, offset int) ([]models.PromptHistory, error) {
 	query := `
@@ -136,7 +219,7 @@ func (db *DatabaseService) GetUserPromptHistory(ctx context.Context, userID stri
 		LIMIT $2 OFFSET $3
 	`
 
-	rows, err := db.QueryContext(ctx, query, userID, limit, offset)
+	rows, err := db.queries.QueryContext(ctx, query, userID, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query prompt history: %w", err)
 	}
@@ -195,7 +278,7 @@ func (db *DatabaseService) GetUserPromptHistory(ctx context.Context, userID stri
 // DeletePromptHistory deletes a prompt history entry
 func (s *DatabaseService) DeletePromptHistory(ctx context.Context, id string) error {
 	query := "DELETE FROM prompts.history WHERE id = $1"
-	result, err := s.DB.ExecContext(ctx, query, id)
+	result, err := s.queries.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete prompt history: %w", err)
 	}
@@ -206,9 +289,156 @@ func (s *DatabaseService) DeletePromptHistory(ctx context.Context, id string) er
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("prompt history not found")
+		return ErrPromptHistoryNotFound
+	}
+
+	return nil
+}
+
+// UpdatePromptHistory applies update to an entry in place
+func (s *DatabaseService) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	args := sqlArgs{}
+	var sets []string
+	if update.UserID != "" {
+		sets = append(sets, "user_id = "+args.add(update.UserID))
+	}
+	if update.Favorite != nil {
+		sets = append(sets, "is_favorite = "+args.add(*update.Favorite))
+	}
+	if len(update.AddTags) > 0 {
+		sets = append(sets, `metadata = jsonb_set(COALESCE(metadata, '{}'::jsonb), '{tags}', (
+			SELECT COALESCE(jsonb_agg(tag ORDER BY tag), '[]'::jsonb)
+			FROM (
+				SELECT jsonb_array_elements_text(COALESCE(metadata->'tags', '[]'::jsonb)) AS tag
+				UNION
+				SELECT unnest(`+args.add(pq.Array(update.AddTags))+`::text[])
+			) tags
+		))`)
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	query := "UPDATE prompts.history SET " + strings.Join(sets, ", ") + " WHERE id = " + args.add(id)
+	result, err := s.queries.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update prompt history: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to check affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrPromptHistoryNotFound
+	}
+	return nil
+}
+
+// ScanPromptHistory returns a page of all history in ID order, for copying
+// it to another store
+func (s *DatabaseService) ScanPromptHistory(ctx context.Context, cursor string, limit int) ([]models.PromptHistory, string, error) {
+	query := `
+		SELECT id, user_id, original_input, enhanced_output,
+			   intent, complexity, techniques_used, metadata,
+			   feedback_score, feedback_text, created_at, updated_at
+		FROM prompts.history`
+	args := sqlArgs{}
+	if cursor != "" {
+		query += `
+		WHERE id > ` + args.add(cursor)
+	}
+	query += `
+		ORDER BY id
+		LIMIT ` + args.add(limit)
+
+	rows, err := s.queries.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan prompt history: %w", err)
 	}
+	defer rows.Close()
 
+	var entries []models.PromptHistory
+	for rows.Next() {
+		var entry models.PromptHistory
+		var techniques pq.StringArray
+		var metadataJSON []byte
+		var updatedAt sql.NullTime
+		err := rows.Scan(
+			&entry.ID,
+			&entry.UserID,
+			&entry.OriginalInput,
+			&entry.EnhancedOutput,
+			&entry.Intent,
+			&entry.Complexity,
+			&techniques,
+			&metadataJSON,
+			&entry.FeedbackScore,
+			&entry.FeedbackText,
+			&entry.CreatedAt,
+			&updatedAt,
+		)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to scan prompt history: %w", err)
+		}
+		entry.TechniquesUsed = []string(techniques)
+		if updatedAt.Valid {
+			entry.UpdatedAt = updatedAt.Time
+		}
+		var metadata map[string]interface{}
+		if err := json.Unmarshal(metadataJSON, &metadata); err == nil {
+			entry.Metadata = metadata
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to iterate prompt history: %w", err)
+	}
+
+	next := ""
+	if len(entries) == limit {
+		next = entries[len(entries)-1].ID
+	}
+	return entries, next, nil
+}
+
+// ImportPromptHistory inserts an entry as is, keeping its ID and timestamps.
+// An entry with the same ID is left alone.
+func (s *DatabaseService) ImportPromptHistory(ctx context.Context, entry models.PromptHistory) error {
+	metadataJSON, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	updatedAt := entry.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = entry.CreatedAt
+	}
+
+	err = insertHistory(ctx, s.queries, []time.Time{entry.CreatedAt}, func() error {
+		_, err := s.queries.ExecContext(ctx, `
+			INSERT INTO prompts.history (
+				id, user_id, original_input, enhanced_output,
+				intent, complexity, techniques_used, metadata,
+				feedback_score, feedback_text, created_at, updated_at
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			ON CONFLICT (id, created_at) DO NOTHING`,
+			entry.ID,
+			entry.UserID,
+			entry.OriginalInput,
+			entry.EnhancedOutput,
+			entry.Intent,
+			entry.Complexity,
+			pq.Array(entry.TechniquesUsed),
+			metadataJSON,
+			entry.FeedbackScore,
+			entry.FeedbackText,
+			entry.CreatedAt,
+			updatedAt,
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import prompt history: %w", err)
+	}
 	return nil
 }
 
@@ -219,84 +449,70 @@ func (s *DatabaseService) Ping() error {
 
 // GetUserPromptHistoryWithFilters retrieves user's prompt history with search and filters
 func (s *DatabaseService) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	return s.userPromptHistory(ctx, userID, "", req)
+}
+
+// GetUserPromptHistoryForProfile is GetUserPromptHistoryWithFilters limited
+// to the enhancements made with one of the user's enhancement profiles
+func (s *DatabaseService) GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	return s.userPromptHistory(ctx, userID, profile, req)
+}
+
+func (s *DatabaseService) userPromptHistory(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
 	// Build the WHERE clause
-	whereConditions := []string{"user_id = $1"}
-	args := []interface{}{userID}
-	argCounter := 2
+	args := sqlArgs{}
+	whereConditions := []string{"user_id = " + args.add(userID)}
+
+	if profile != "" {
+		whereConditions = append(whereConditions, "metadata->>'profile' = "+args.add(profile))
+	}
 
 	// Add search condition
 	if req.Search != "" {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"(original_input ILIKE $%d OR enhanced_output ILIKE $%d)",
-			argCounter, argCounter+1,
-		))
 		searchTerm := "%" + req.Search + "%"
-		args = append(args, searchTerm, searchTerm)
-		argCounter += 2
+		whereConditions = append(whereConditions,
+			"(original_input ILIKE "+args.add(searchTerm)+" OR enhanced_output ILIKE "+args.add(searchTerm)+")")
 	}
 
 	// Add technique filter
 	if req.Technique != "" {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"$%d = ANY(techniques_used::text[])",
-			argCounter,
-		))
-		args = append(args, req.Technique)
-		argCounter++
+		whereConditions = append(whereConditions, args.add(req.Technique)+" = ANY(techniques_used::text[])")
 	}
 
 	// Add date range filters
 	if !req.DateFrom.IsZero() {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"created_at >= $%d",
-			argCounter,
-		))
-		args = append(args, req.DateFrom)
-		argCounter++
+		whereConditions = append(whereConditions, "created_at >= "+args.add(req.DateFrom))
 	}
 
 	if !req.DateTo.IsZero() {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"created_at <= $%d",
-			argCounter,
-		))
-		args = append(args, req.DateTo)
-		argCounter++
+		whereConditions = append(whereConditions, "created_at <= "+args.add(req.DateTo))
 	}
 
 	whereClause := strings.Join(whereConditions, " AND ")
 
 	// First, get the total count
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) 
-		FROM prompts.history 
-		WHERE %s`, whereClause)
+	countQuery := `
+		SELECT COUNT(*)
+		FROM prompts.history
+		WHERE ` + whereClause
 
 	var totalCount int64
-	err := s.QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
+	err := s.queries.QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count prompts: %w", err)
 	}
 
 	// Build the main query with pagination
-	query := fmt.Sprintf(`
+	query := `
 		SELECT id, user_id, original_input, enhanced_output,
 			   intent, complexity, techniques_used, metadata,
 			   feedback_score, feedback_text, created_at, updated_at
 		FROM prompts.history
-		WHERE %s
-		ORDER BY %s %s
-		LIMIT $%d OFFSET $%d`,
-		whereClause,
-		req.SortBy,
-		req.SortDirection,
-		argCounter,
-		argCounter+1,
-	)
-
-	args = append(args, req.Limit, req.CalculateOffset())
+		WHERE ` + whereClause + `
+		ORDER BY ` + historyOrderBy(req) + `
+		LIMIT ` + args.add(req.Limit) + ` OFFSET ` + args.add(req.CalculateOffset())
 
-	rows, err := s.QueryContext(ctx, query, args...)
+	rows, err := s.queries.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query prompts: %w", err)
 	}
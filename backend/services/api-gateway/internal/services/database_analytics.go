@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// analyticsRepo stores usage and technique metrics in the analytics schema
+type analyticsRepo struct {
+	db dbtx
+}
+
+// UpdateTechniqueEffectiveness updates technique effectiveness metrics
+func (r *analyticsRepo) UpdateTechniqueEffectiveness(ctx context.Context, technique, intent string, feedbackScore float64) error {
+	query := `
+		INSERT INTO analytics.technique_effectiveness 
+			(id, technique, intent, success_count, total_count, average_feedback, date)
+		VALUES 
+			($1, $2, $3, $4, $5, $6, CURRENT_DATE)
+		ON CONFLICT (technique, intent, date) DO UPDATE
+		SET 
+			success_count = CASE 
+				WHEN $6 >= 4 THEN technique_effectiveness.success_count + 1 
+				ELSE technique_effectiveness.success_count 
+			END,
+			total_count = technique_effectiveness.total_count + 1,
+			average_feedback = (
+				(technique_effectiveness.average_feedback * technique_effectiveness.total_count) + $6
+			) / (technique_effectiveness.total_count + 1)`
+
+	successCount := 0
+	if feedbackScore >= 4 {
+		successCount = 1
+	}
+
+	_, err := r.db.ExecContext(ctx, query,
+		uuid.New().String(), technique, intent,
+		successCount, 1, feedbackScore,
+	)
+
+	return err
+}
+
+// TechniqueFeedback is one feedback score for a technique used on an intent
+type TechniqueFeedback struct {
+	Technique string
+	Intent    string
+	Score     float64
+}
+
+// UpdateTechniqueEffectivenessBatch folds many feedback scores into
+// today's effectiveness rows in one statement. Scores are summed per
+// technique and intent first, as a single upsert can't touch a row twice,
+// and written in key order so concurrent batches lock rows in the same order.
+func (r *analyticsRepo) UpdateTechniqueEffectivenessBatch(ctx context.Context, feedback []TechniqueFeedback) error {
+	if len(feedback) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO analytics.technique_effectiveness
+			(technique, intent, success_count, total_count, average_feedback, date)
+		SELECT technique, intent, success_count, total_count, average_feedback, CURRENT_DATE
+		FROM unnest($1::text[], $2::text[], $3::int[], $4::int[], $5::float8[])
+			AS batch (technique, intent, success_count, total_count, average_feedback)
+		ON CONFLICT (technique, intent, date) DO UPDATE
+		SET
+			success_count = technique_effectiveness.success_count + EXCLUDED.success_count,
+			total_count = technique_effectiveness.total_count + EXCLUDED.total_count,
+			average_feedback = (
+				(technique_effectiveness.average_feedback * technique_effectiveness.total_count) +
+				(EXCLUDED.average_feedback * EXCLUDED.total_count)
+			) / (technique_effectiveness.total_count + EXCLUDED.total_count)`
+
+	type key struct{ technique, intent string }
+	type totals struct {
+		success, total int
+		sum            float64
+	}
+	byKey := make(map[key]*totals)
+	keys := make([]key, 0, len(feedback))
+	for _, f := range feedback {
+		k := key{f.Technique, f.Intent}
+		t, ok := byKey[k]
+		if !ok {
+			t = &totals{}
+			byKey[k] = t
+			keys = append(keys, k)
+		}
+		if f.Score >= 4 {
+			t.success++
+		}
+		t.total++
+		t.sum += f.Score
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].technique != keys[j].technique {
+			return keys[i].technique < keys[j].technique
+		}
+		return keys[i].intent < keys[j].intent
+	})
+
+	techniques := make([]string, len(keys))
+	intents := make([]string, len(keys))
+	successes := make([]int64, len(keys))
+	counts := make([]int64, len(keys))
+	averages := make([]float64, len(keys))
+	for i, k := range keys {
+		t := byKey[k]
+		techniques[i], intents[i] = k.technique, k.intent
+		successes[i], counts[i] = int64(t.success), int64(t.total)
+		averages[i] = t.sum / float64(t.total)
+	}
+
+	_, err := r.db.ExecContext(ctx, query,
+		pq.Array(techniques), pq.Array(intents),
+		pq.Array(successes), pq.Array(counts), pq.Array(averages),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update technique effectiveness batch: %w", err)
+	}
+	return nil
+}
+
+// EffectivenessRow is the feedback accumulated for one technique and intent
+// on one day
+type EffectivenessRow struct {
+	Technique string    `json:"technique"`
+	Intent    string    `json:"intent"`
+	Date      time.Time `json:"date"` // UTC day the feedback was given
+	Success   int       `json:"success"`
+	Total     int       `json:"total"`
+	ScoreSum  float64   `json:"score_sum"`
+}
+
+// EffectivenessBatch is a set of effectiveness rows written together. Its ID
+// makes the write idempotent: a batch is counted at most once however often
+// it is applied.
+type EffectivenessBatch struct {
+	ID   string             `json:"id"`
+	Rows []EffectivenessRow `json:"rows"`
+}
+
+// ApplyTechniqueEffectiveness adds a batch to the effectiveness rows and
+// records its ID in one statement. It returns false, writing nothing, when
+// the batch was applied before. Rows are written in key order so concurrent
+// batches lock them in the same order.
+func (r *analyticsRepo) ApplyTechniqueEffectiveness(ctx context.Context, batch EffectivenessBatch) (bool, error) {
+	if len(batch.Rows) == 0 {
+		return false, nil
+	}
+
+	query := `
+		WITH claimed AS (
+			INSERT INTO analytics.technique_effectiveness_flushes (id, rows)
+			VALUES ($1, $2)
+			ON CONFLICT (id) DO NOTHING
+			RETURNING id
+		)
+		INSERT INTO analytics.technique_effectiveness
+			(technique, intent, success_count, total_count, average_feedback, date)
+		SELECT technique, intent, success_count, total_count, average_feedback, date
+		FROM unnest($3::text[], $4::text[], $5::int[], $6::int[], $7::float8[], $8::date[])
+			AS batch (technique, intent, success_count, total_count, average_feedback, date)
+		WHERE EXISTS (SELECT 1 FROM claimed)
+		ON CONFLICT (technique, intent, date) DO UPDATE
+		SET
+			success_count = technique_effectiveness.success_count + EXCLUDED.success_count,
+			total_count = technique_effectiveness.total_count + EXCLUDED.total_count,
+			average_feedback = (
+				(COALESCE(technique_effectiveness.average_feedback, 0) * technique_effectiveness.total_count) +
+				(EXCLUDED.average_feedback * EXCLUDED.total_count)
+			) / (technique_effectiveness.total_count + EXCLUDED.total_count),
+			updated_at = CURRENT_TIMESTAMP`
+
+	rows := append([]EffectivenessRow(nil), batch.Rows...)
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Technique != rows[j].Technique {
+			return rows[i].Technique < rows[j].Technique
+		}
+		if rows[i].Intent != rows[j].Intent {
+			return rows[i].Intent < rows[j].Intent
+		}
+		return rows[i].Date.Before(rows[j].Date)
+	})
+
+	techniques := make([]string, len(rows))
+	intents := make([]string, len(rows))
+	successes := make([]int64, len(rows))
+	counts := make([]int64, len(rows))
+	averages := make([]float64, len(rows))
+	dates := make([]string, len(rows))
+	for i, row := range rows {
+		techniques[i], intents[i] = row.Technique, row.Intent
+		successes[i], counts[i] = int64(row.Success), int64(row.Total)
+		averages[i] = row.ScoreSum / float64(row.Total)
+		dates[i] = row.Date.UTC().Format("2006-01-02")
+	}
+
+	result, err := r.db.ExecContext(ctx, query,
+		batch.ID, len(rows),
+		pq.Array(techniques), pq.Array(intents),
+		pq.Array(successes), pq.Array(counts), pq.Array(averages), pq.Array(dates),
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to apply technique effectiveness batch: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to apply technique effectiveness batch: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// PruneTechniqueEffectivenessFlushes forgets the batches applied before a
+// time; a batch replayed after that would be counted again
+func (r *analyticsRepo) PruneTechniqueEffectivenessFlushes(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM analytics.technique_effectiveness_flushes WHERE applied_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune technique effectiveness flushes: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// GetTechniqueEffectiveness retrieves technique effectiveness data
+func (r *analyticsRepo) GetTechniqueEffectiveness(ctx context.Context, days int) ([]models.TechniqueEffectiveness, error) {
+	query := `
+		SELECT technique, intent,
+			   SUM(success_count) as success_count,
+			   SUM(total_count) as total_count,
+			   AVG(average_feedback) as average_feedback
+		FROM analytics.technique_effectiveness
+		WHERE date >= CURRENT_DATE - make_interval(days => $1)
+		GROUP BY technique, intent
+		ORDER BY technique, intent`
+
+	rows, err := r.db.QueryxContext(ctx, query, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []models.TechniqueEffectiveness
+	for rows.Next() {
+		var te models.TechniqueEffectiveness
+		if err := rows.StructScan(&te); err != nil {
+			return nil, err
+		}
+		results = append(results, te)
+	}
+
+	return results, rows.Err()
+}
+
+// RecordUserActivity records user activity
+func (r *analyticsRepo) RecordUserActivity(ctx context.Context, activity *models.UserActivity) error {
+	query := `
+		INSERT INTO analytics.user_activity (
+			id, user_id, activity_type, activity_data,
+			session_id, ip_address, user_agent
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	if activity.ID == "" {
+		activity.ID = uuid.New().String()
+	}
+
+	dataJSON, _ := json.Marshal(activity.Data)
+
+	_, err := r.db.ExecContext(ctx, query,
+		activity.ID, activity.UserID, activity.Type,
+		dataJSON, activity.SessionID, activity.IPAddress,
+		activity.UserAgent,
+	)
+
+	return err
+}
+
+// RecordUserActivityBatch records activities in a single statement
+func (r *analyticsRepo) RecordUserActivityBatch(ctx context.Context, activities []*models.UserActivity) error {
+	if len(activities) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO analytics.user_activity (
+			id, user_id, activity_type, activity_data,
+			session_id, ip_address, user_agent
+		)
+		SELECT * FROM unnest(
+			$1::uuid[], $2::uuid[], $3::text[], $4::jsonb[],
+			$5::text[], $6::text[], $7::text[]
+		)`
+
+	n := len(activities)
+	ids := make([]string, n)
+	userIDs := make([]sql.NullString, n)
+	types := make([]string, n)
+	data := make([]string, n)
+	sessionIDs := make([]sql.NullString, n)
+	ipAddresses := make([]sql.NullString, n)
+	userAgents := make([]sql.NullString, n)
+	for i, activity := range activities {
+		if activity.ID == "" {
+			activity.ID = uuid.New().String()
+		}
+		dataJSON, _ := json.Marshal(activity.Data)
+
+		ids[i] = activity.ID
+		userIDs[i] = activity.UserID
+		types[i] = activity.Type
+		data[i] = string(dataJSON)
+		sessionIDs[i] = activity.SessionID
+		ipAddresses[i] = activity.IPAddress
+		userAgents[i] = activity.UserAgent
+	}
+
+	_, err := r.db.ExecContext(ctx, query,
+		pq.Array(ids), pq.Array(userIDs), pq.Array(types), pq.Array(data),
+		pq.Array(sessionIDs), pq.Array(ipAddresses), pq.Array(userAgents),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record user activity batch: %w", err)
+	}
+	return nil
+}
+
+// UpdateDailyStats updates daily statistics
+func (r *analyticsRepo) UpdateDailyStats(ctx context.Context) error {
+	// This would typically be run as a scheduled job
+	query := `
+		INSERT INTO analytics.daily_stats (
+			id, date, total_requests, unique_users, new_users,
+			total_enhancements, average_response_time_ms, error_count
+		)
+		SELECT 
+			$1,
+			CURRENT_DATE,
+			COUNT(*) as total_requests,
+			COUNT(DISTINCT user_id) as unique_users,
+			COUNT(DISTINCT CASE 
+				WHEN u.created_at::date = CURRENT_DATE THEN u.id 
+			END) as new_users,
+			COUNT(*) as total_enhancements,
+			AVG(processing_time_ms) as average_response_time_ms,
+			0 as error_count
+		FROM prompts.history h
+		LEFT JOIN auth.users u ON h.user_id = u.id
+		WHERE h.created_at::date = CURRENT_DATE
+		ON CONFLICT (date) DO UPDATE
+		SET 
+			total_requests = EXCLUDED.total_requests,
+			unique_users = EXCLUDED.unique_users,
+			new_users = EXCLUDED.new_users,
+			total_enhancements = EXCLUDED.total_enhancements,
+			average_response_time_ms = EXCLUDED.average_response_time_ms`
+
+	_, err := r.db.ExecContext(ctx, query, uuid.New().String())
+	return err
+}
+
+// GetDailyStats retrieves daily statistics
+func (r *analyticsRepo) GetDailyStats(ctx context.Context, days int) ([]models.DailyStats, error) {
+	query := `
+		SELECT date, total_requests, unique_users, new_users,
+			   total_enhancements, average_response_time_ms, error_count
+		FROM analytics.daily_stats
+		WHERE date >= CURRENT_DATE - make_interval(days => $1)
+		ORDER BY date DESC`
+
+	rows, err := r.db.QueryxContext(ctx, query, days)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []models.DailyStats
+	for rows.Next() {
+		var s models.DailyStats
+		if err := rows.StructScan(&s); err != nil {
+			return nil, err
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, rows.Err()
+}
@@ -6,13 +6,48 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/betterprompts/api-gateway/internal/models"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+// historyRepo stores enhancements in prompts.history
+type historyRepo struct {
+	db dbtx
+}
+
+// SavePromptHistory saves a prompt history entry
+func (r *historyRepo) SavePromptHistory(ctx context.Context, entry *models.PromptHistory) error {
+	query := `
+		INSERT INTO prompts.history (
+			id, user_id, session_id, request_id, original_input, enhanced_output,
+			intent, intent_confidence, complexity, techniques_used, technique_scores,
+			processing_time_ms, token_count, model_used, metadata
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`
+
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+
+	techniques := pq.Array(entry.TechniquesUsed)
+	scoresJSON, _ := json.Marshal(entry.TechniqueScores)
+	metaJSON, _ := json.Marshal(entry.Metadata)
+
+	_, err := r.db.ExecContext(ctx, query,
+		entry.ID, entry.UserID, entry.SessionID, entry.RequestID,
+		entry.OriginalInput, entry.EnhancedOutput,
+		entry.Intent, entry.IntentConfidence, entry.Complexity,
+		techniques, scoresJSON, entry.ProcessingTimeMs,
+		entry.TokenCount, entry.ModelUsed, metaJSON,
+	)
+
+	return err
+}
+
 // SavePromptHistoryWithID saves a prompt history entry and returns the ID
-func (s *CompleteDatabaseService) SavePromptHistoryWithID(ctx context.Context, entry *models.PromptHistory) (string, error) {
+func (r *historyRepo) SavePromptHistoryWithID(ctx context.Context, entry *models.PromptHistory) (string, error) {
 	query := `
 		INSERT INTO prompts.history (
 			id, user_id, original_input, enhanced_output,
@@ -29,13 +64,13 @@ func (s *CompleteDatabaseService) SavePromptHistoryWithID(ctx context.Context, e
 	metaJSON, _ := json.Marshal(entry.Metadata)
 
 	var id string
-	err := s.db.QueryRowContext(ctx, query,
-		entry.ID, 
-		entry.UserID, 
-		entry.OriginalInput, 
+	err := r.db.QueryRowContext(ctx, query,
+		entry.ID,
+		entry.UserID,
+		entry.OriginalInput,
 		entry.EnhancedOutput,
-		entry.Intent.String,      // intent
-		entry.Complexity.String,  // complexity
+		entry.Intent.String,     // intent
+		entry.Complexity.String, // complexity
 		techniquesJSON,
 		metaJSON,
 	).Scan(&id)
@@ -47,8 +82,153 @@ func (s *CompleteDatabaseService) SavePromptHistoryWithID(ctx context.Context, e
 	return id, nil
 }
 
+// SavePromptHistoryBatch saves entries in a single statement, assigning IDs
+// and creation times to entries without them. The columns go in as one array
+// each, so the statement is the same whatever the batch size.
+func (r *historyRepo) SavePromptHistoryBatch(ctx context.Context, entries []*models.PromptHistory) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO prompts.history (
+			id, user_id, session_id, request_id, original_input, enhanced_output,
+			intent, intent_confidence, complexity, techniques_used, technique_scores,
+			processing_time_ms, token_count, model_used, metadata, created_at
+		)
+		SELECT id, user_id, session_id, request_id, original_input, enhanced_output,
+			   intent, intent_confidence, complexity,
+			   ARRAY(SELECT jsonb_array_elements_text(techniques_used)), technique_scores,
+			   processing_time_ms, token_count, model_used, metadata, created_at
+		FROM unnest(
+			$1::uuid[], $2::uuid[], $3::text[], $4::text[], $5::text[], $6::text[],
+			$7::text[], $8::float8[], $9::text[], $10::jsonb[], $11::jsonb[],
+			$12::int[], $13::int[], $14::text[], $15::jsonb[], $16::timestamptz[]
+		) AS batch (
+			id, user_id, session_id, request_id, original_input, enhanced_output,
+			intent, intent_confidence, complexity, techniques_used, technique_scores,
+			processing_time_ms, token_count, model_used, metadata, created_at
+		)`
+
+	n := len(entries)
+	ids := make([]string, n)
+	userIDs := make([]sql.NullString, n)
+	sessionIDs := make([]sql.NullString, n)
+	requestIDs := make([]sql.NullString, n)
+	originals := make([]string, n)
+	enhanced := make([]string, n)
+	intents := make([]sql.NullString, n)
+	confidences := make([]sql.NullFloat64, n)
+	complexities := make([]sql.NullString, n)
+	techniques := make([]string, n) // JSON arrays; a text[] per row can't be passed as text[][]
+	scores := make([]string, n)
+	processingTimes := make([]sql.NullInt64, n)
+	tokenCounts := make([]sql.NullInt64, n)
+	modelsUsed := make([]sql.NullString, n)
+	metadata := make([]string, n)
+	createdAt := make([]string, n)
+	createdTimes := make([]time.Time, n)
+
+	now := time.Now()
+	for i, entry := range entries {
+		if entry.ID == "" {
+			entry.ID = uuid.New().String()
+		}
+		if entry.CreatedAt.IsZero() {
+			entry.CreatedAt = now
+		}
+
+		used := entry.TechniquesUsed
+		if used == nil {
+			used = []string{}
+		}
+		techniquesJSON, _ := json.Marshal(used)
+		scoresJSON, _ := json.Marshal(entry.TechniqueScores)
+		metaJSON, _ := json.Marshal(entry.Metadata)
+
+		ids[i] = entry.ID
+		userIDs[i] = entry.UserID
+		sessionIDs[i] = entry.SessionID
+		requestIDs[i] = entry.RequestID
+		originals[i] = entry.OriginalInput
+		enhanced[i] = entry.EnhancedOutput
+		intents[i] = entry.Intent
+		confidences[i] = entry.IntentConfidence
+		complexities[i] = entry.Complexity
+		techniques[i] = string(techniquesJSON)
+		scores[i] = string(scoresJSON)
+		processingTimes[i] = entry.ProcessingTimeMs
+		tokenCounts[i] = entry.TokenCount
+		modelsUsed[i] = entry.ModelUsed
+		metadata[i] = string(metaJSON)
+		createdAt[i] = entry.CreatedAt.Format(time.RFC3339Nano)
+		createdTimes[i] = entry.CreatedAt
+	}
+
+	err := insertHistory(ctx, r.db, createdTimes, func() error {
+		_, err := r.db.ExecContext(ctx, query,
+			pq.Array(ids), pq.Array(userIDs), pq.Array(sessionIDs), pq.Array(requestIDs),
+			pq.Array(originals), pq.Array(enhanced),
+			pq.Array(intents), pq.Array(confidences), pq.Array(complexities),
+			pq.Array(techniques), pq.Array(scores), pq.Array(processingTimes),
+			pq.Array(tokenCounts), pq.Array(modelsUsed), pq.Array(metadata), pq.Array(createdAt),
+		)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save prompt history batch: %w", err)
+	}
+	return nil
+}
+
+// GetPromptHistory retrieves prompt history with pagination
+func (r *historyRepo) GetPromptHistory(ctx context.Context, userID string, limit, offset int) ([]*models.PromptHistory, error) {
+	query := `
+		SELECT id, user_id, session_id, request_id, original_input, enhanced_output,
+			   intent, intent_confidence, complexity, techniques_used, technique_scores,
+			   processing_time_ms, token_count, model_used, feedback_score,
+			   feedback_text, is_favorite, metadata, created_at
+		FROM prompts.history
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*models.PromptHistory
+	for rows.Next() {
+		var entry models.PromptHistory
+		var techniques pq.StringArray
+		var scoresJSON, metaJSON []byte
+
+		err := rows.Scan(
+			&entry.ID, &entry.UserID, &entry.SessionID, &entry.RequestID,
+			&entry.OriginalInput, &entry.EnhancedOutput,
+			&entry.Intent, &entry.IntentConfidence, &entry.Complexity,
+			&techniques, &scoresJSON, &entry.ProcessingTimeMs,
+			&entry.TokenCount, &entry.ModelUsed, &entry.FeedbackScore,
+			&entry.FeedbackText, &entry.IsFavorite, &metaJSON, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.TechniquesUsed = []string(techniques)
+		json.Unmarshal(scoresJSON, &entry.TechniqueScores)
+		json.Unmarshal(metaJSON, &entry.Metadata)
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
 // GetPromptHistoryByID retrieves a single prompt history entry by ID
-func (s *CompleteDatabaseService) GetPromptHistoryByID(ctx context.Context, historyID string) (*models.PromptHistory, error) {
+func (r *historyRepo) GetPromptHistoryByID(ctx context.Context, historyID string) (*models.PromptHistory, error) {
 	query := `
 		SELECT id, user_id, original_input, enhanced_output,
 			   intent, complexity, techniques_used, metadata,
@@ -63,7 +243,7 @@ func (s *CompleteDatabaseService) GetPromptHistoryByID(ctx context.Context, hist
 	var feedbackScore sql.NullInt64
 	var feedbackText sql.NullString
 
-	err := s.db.QueryRowContext(ctx, query, historyID).Scan(
+	err := r.db.QueryRowContext(ctx, query, historyID).Scan(
 		&entry.ID,
 		&userID,
 		&entry.OriginalInput,
@@ -96,7 +276,7 @@ func (s *CompleteDatabaseService) GetPromptHistoryByID(ctx context.Context, hist
 	if err := json.Unmarshal(techniquesJSON, &techniques); err == nil {
 		entry.TechniquesUsed = techniques
 	}
-	
+
 	var metadata map[string]interface{}
 	if err := json.Unmarshal(metaJSON, &metadata); err == nil {
 		entry.Metadata = metadata
@@ -106,85 +286,57 @@ func (s *CompleteDatabaseService) GetPromptHistoryByID(ctx context.Context, hist
 }
 
 // GetUserPromptHistoryWithFilters retrieves user's prompt history with search and filters
-func (s *CompleteDatabaseService) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+func (r *historyRepo) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
 	// Build the WHERE clause
-	whereConditions := []string{"user_id = $1"}
-	args := []interface{}{userID}
-	argCounter := 2
+	args := sqlArgs{}
+	whereConditions := []string{"user_id = " + args.add(userID)}
 
 	// Add search condition
 	if req.Search != "" {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"(original_input ILIKE $%d OR enhanced_output ILIKE $%d)",
-			argCounter, argCounter+1,
-		))
 		searchTerm := "%" + req.Search + "%"
-		args = append(args, searchTerm, searchTerm)
-		argCounter += 2
+		whereConditions = append(whereConditions,
+			"(original_input ILIKE "+args.add(searchTerm)+" OR enhanced_output ILIKE "+args.add(searchTerm)+")")
 	}
 
 	// Add technique filter
 	if req.Technique != "" {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"techniques_used::text ILIKE $%d",
-			argCounter,
-		))
-		args = append(args, "%"+req.Technique+"%")
-		argCounter++
+		whereConditions = append(whereConditions, "techniques_used::text ILIKE "+args.add("%"+req.Technique+"%"))
 	}
 
 	// Add date range filters
 	if !req.DateFrom.IsZero() {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"created_at >= $%d",
-			argCounter,
-		))
-		args = append(args, req.DateFrom)
-		argCounter++
+		whereConditions = append(whereConditions, "created_at >= "+args.add(req.DateFrom))
 	}
 
 	if !req.DateTo.IsZero() {
-		whereConditions = append(whereConditions, fmt.Sprintf(
-			"created_at <= $%d",
-			argCounter,
-		))
-		args = append(args, req.DateTo)
-		argCounter++
+		whereConditions = append(whereConditions, "created_at <= "+args.add(req.DateTo))
 	}
 
 	whereClause := strings.Join(whereConditions, " AND ")
 
 	// First, get the total count
-	countQuery := fmt.Sprintf(`
-		SELECT COUNT(*) 
-		FROM prompts.history 
-		WHERE %s`, whereClause)
+	countQuery := `
+		SELECT COUNT(*)
+		FROM prompts.history
+		WHERE ` + whereClause
 
 	var totalCount int64
-	err := s.db.QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
+	err := r.db.QueryRowContext(ctx, countQuery, args...).Scan(&totalCount)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count prompts: %w", err)
 	}
 
 	// Build the main query with pagination
-	query := fmt.Sprintf(`
+	query := `
 		SELECT id, user_id, original_input, enhanced_output,
 			   intent, complexity, techniques_used, metadata,
 			   feedback_score, feedback_text, created_at, updated_at
 		FROM prompts.history
-		WHERE %s
-		ORDER BY %s %s
-		LIMIT $%d OFFSET $%d`,
-		whereClause,
-		req.SortBy,
-		req.SortDirection,
-		argCounter,
-		argCounter+1,
-	)
+		WHERE ` + whereClause + `
+		ORDER BY ` + historyOrderBy(req) + `
+		LIMIT ` + args.add(req.Limit) + ` OFFSET ` + args.add(req.CalculateOffset())
 
-	args = append(args, req.Limit, req.CalculateOffset())
-
-	rows, err := s.db.QueryContext(ctx, query, args...)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to query prompts: %w", err)
 	}
@@ -236,7 +388,7 @@ func (s *CompleteDatabaseService) GetUserPromptHistoryWithFilters(ctx context.Co
 		if err := json.Unmarshal(techniquesJSON, &techniques); err == nil {
 			entry.TechniquesUsed = techniques
 		}
-		
+
 		var metadata map[string]interface{}
 		if err := json.Unmarshal(metaJSON, &metadata); err == nil {
 			entry.Metadata = metadata
@@ -252,10 +404,44 @@ func (s *CompleteDatabaseService) GetUserPromptHistoryWithFilters(ctx context.Co
 	return entries, totalCount, nil
 }
 
+// UpdatePromptFeedback updates feedback for a prompt
+func (r *historyRepo) UpdatePromptFeedback(ctx context.Context, historyID string, score int, text string) error {
+	query := `
+		UPDATE prompts.history 
+		SET feedback_score = $2, feedback_text = $3
+		WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, historyID, score, text)
+	return err
+}
+
+// TogglePromptFavorite toggles favorite status
+func (r *historyRepo) TogglePromptFavorite(ctx context.Context, historyID string, userID string) error {
+	query := `
+		UPDATE prompts.history 
+		SET is_favorite = NOT is_favorite
+		WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, historyID, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("prompt not found or unauthorized")
+	}
+
+	return nil
+}
+
 // RerunPromptHistory creates a new enhancement using the same technique
-func (s *CompleteDatabaseService) RerunPromptHistory(ctx context.Context, historyID string, userID string) (*models.PromptHistory, error) {
+func (r *historyRepo) RerunPromptHistory(ctx context.Context, historyID string, userID string) (*models.PromptHistory, error) {
 	// First, get the original prompt
-	original, err := s.GetPromptHistoryByID(ctx, historyID)
+	original, err := r.GetPromptHistoryByID(ctx, historyID)
 	if err != nil {
 		return nil, err
 	}
@@ -270,10 +456,26 @@ func (s *CompleteDatabaseService) RerunPromptHistory(ctx context.Context, histor
 	return original, nil
 }
 
+// ClaimPromptHistory assigns the anonymous history recorded under a session
+// to a user, returning how many entries were claimed. Entries that already
+// belong to a user are left alone.
+func (r *historyRepo) ClaimPromptHistory(ctx context.Context, sessionID, userID string) (int64, error) {
+	query := `
+		UPDATE prompts.history
+		SET user_id = $2
+		WHERE session_id = $1 AND user_id IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, sessionID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim prompt history: %w", err)
+	}
+	return result.RowsAffected()
+}
+
 // DeletePromptHistory deletes a prompt history entry
-func (s *CompleteDatabaseService) DeletePromptHistory(ctx context.Context, id string) error {
+func (r *historyRepo) DeletePromptHistory(ctx context.Context, id string) error {
 	query := "DELETE FROM prompts WHERE id = $1"
-	result, err := s.db.ExecContext(ctx, query, id)
+	result, err := r.db.ExecContext(ctx, query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete prompt history: %w", err)
 	}
@@ -288,4 +490,4 @@ func (s *CompleteDatabaseService) DeletePromptHistory(ctx context.Context, id st
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// libraryRepo stores saved prompts and collections
+type libraryRepo struct {
+	db dbtx
+}
+
+// SavePrompt saves a prompt to user's library
+func (r *libraryRepo) SavePrompt(ctx context.Context, saved *models.SavedPrompt) error {
+	query := `
+		INSERT INTO prompts.saved_prompts (
+			id, user_id, history_id, title, description,
+			tags, is_public, share_token
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	if saved.ID == "" {
+		saved.ID = uuid.New().String()
+	}
+	if saved.IsPublic && !saved.ShareToken.Valid || saved.ShareToken.String == "" {
+		saved.ShareToken = sql.NullString{String: uuid.New().String(), Valid: true}
+	}
+
+	tags := pq.Array(saved.Tags)
+
+	_, err := r.db.ExecContext(ctx, query,
+		saved.ID, saved.UserID, saved.HistoryID,
+		saved.Title, saved.Description, tags,
+		saved.IsPublic, saved.ShareToken,
+	)
+
+	return err
+}
+
+// GetSavedPrompts retrieves user's saved prompts
+func (r *libraryRepo) GetSavedPrompts(ctx context.Context, userID string, limit, offset int) ([]*models.SavedPrompt, error) {
+	query := `
+		SELECT sp.id, sp.user_id, sp.history_id, sp.title, sp.description,
+			   sp.tags, sp.is_public, sp.share_token, sp.view_count,
+			   sp.created_at, sp.updated_at,
+			   h.original_input, h.enhanced_output, h.techniques_used
+		FROM prompts.saved_prompts sp
+		JOIN prompts.history h ON sp.history_id = h.id
+		WHERE sp.user_id = $1
+		ORDER BY sp.created_at DESC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prompts []*models.SavedPrompt
+	for rows.Next() {
+		var sp models.SavedPrompt
+		var tags, techniques pq.StringArray
+
+		err := rows.Scan(
+			&sp.ID, &sp.UserID, &sp.HistoryID, &sp.Title,
+			&sp.Description, &tags, &sp.IsPublic, &sp.ShareToken,
+			&sp.ViewCount, &sp.CreatedAt, &sp.UpdatedAt,
+			&sp.OriginalInput, &sp.EnhancedOutput, &techniques,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sp.Tags = []string(tags)
+		sp.TechniquesUsed = []string(techniques)
+		prompts = append(prompts, &sp)
+	}
+
+	return prompts, nil
+}
+
+// ErrSavedPromptNotFound is returned for unknown saved prompts
+var ErrSavedPromptNotFound = errors.New("saved prompt not found")
+
+// GetSavedPrompt retrieves a saved prompt by ID
+func (r *libraryRepo) GetSavedPrompt(ctx context.Context, id string) (*models.SavedPrompt, error) {
+	query := `
+		SELECT sp.id, sp.user_id, sp.history_id, sp.title, sp.description,
+			   sp.tags, sp.is_public, sp.share_token, sp.view_count,
+			   sp.created_at, sp.updated_at,
+			   h.original_input, h.enhanced_output, h.techniques_used
+		FROM prompts.saved_prompts sp
+		JOIN prompts.history h ON sp.history_id = h.id
+		WHERE sp.id = $1`
+
+	var sp models.SavedPrompt
+	var tags, techniques pq.StringArray
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&sp.ID, &sp.UserID, &sp.HistoryID, &sp.Title,
+		&sp.Description, &tags, &sp.IsPublic, &sp.ShareToken,
+		&sp.ViewCount, &sp.CreatedAt, &sp.UpdatedAt,
+		&sp.OriginalInput, &sp.EnhancedOutput, &techniques,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSavedPromptNotFound
+		}
+		return nil, err
+	}
+
+	sp.Tags = []string(tags)
+	sp.TechniquesUsed = []string(techniques)
+	return &sp, nil
+}
+
+// CreateCollection creates a prompt collection
+func (r *libraryRepo) CreateCollection(ctx context.Context, collection *models.Collection) error {
+	query := `
+		INSERT INTO prompts.collections (
+			id, user_id, name, description, color, icon, is_public, share_token
+		) VALUES (
+			:id, :user_id, :name, :description, :color, :icon, :is_public, :share_token
+		)`
+
+	if collection.ID == "" {
+		collection.ID = uuid.New().String()
+	}
+	if collection.IsPublic && !collection.ShareToken.Valid || collection.ShareToken.String == "" {
+		collection.ShareToken = sql.NullString{String: uuid.New().String(), Valid: true}
+	}
+
+	_, err := namedExec(ctx, r.db, query, collection)
+	return err
+}
+
+// AddPromptToCollection adds a prompt to a collection
+func (r *libraryRepo) AddPromptToCollection(ctx context.Context, collectionID, promptID string, position int) error {
+	query := `
+		INSERT INTO prompts.collection_prompts (
+			collection_id, saved_prompt_id, position
+		) VALUES ($1, $2, $3)
+		ON CONFLICT (collection_id, saved_prompt_id) DO UPDATE
+		SET position = $3`
+
+	_, err := r.db.ExecContext(ctx, query, collectionID, promptID, position)
+	return err
+}
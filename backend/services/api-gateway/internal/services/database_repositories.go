@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// UserRepo stores user accounts, login state and preferences
+type UserRepo interface {
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	UpdateUserLastLogin(ctx context.Context, userID string) error
+	UpdateUserMetadata(ctx context.Context, userID string, metadata map[string]interface{}) error
+	IncrementFailedLogins(ctx context.Context, email string) (int, error)
+	LockUser(ctx context.Context, email string, until time.Time) error
+	GetUserPreferences(ctx context.Context, userID string) (*models.UserPreferences, error)
+	UpdateUserPreferences(ctx context.Context, prefs *models.UserPreferences) error
+}
+
+// SessionRepo stores login sessions
+type SessionRepo interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error)
+	UpdateSessionActivity(ctx context.Context, sessionID string) error
+	DeleteSession(ctx context.Context, sessionID string) error
+	DeleteUserSessions(ctx context.Context, userID string) error
+	CleanExpiredSessions(ctx context.Context) (int64, error)
+}
+
+// HistoryRepo stores enhancements as prompt history
+type HistoryRepo interface {
+	SavePromptHistory(ctx context.Context, entry *models.PromptHistory) error
+	SavePromptHistoryWithID(ctx context.Context, entry *models.PromptHistory) (string, error)
+	SavePromptHistoryBatch(ctx context.Context, entries []*models.PromptHistory) error
+	GetPromptHistory(ctx context.Context, userID string, limit, offset int) ([]*models.PromptHistory, error)
+	GetPromptHistoryByID(ctx context.Context, historyID string) (*models.PromptHistory, error)
+	GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error)
+	UpdatePromptFeedback(ctx context.Context, historyID string, score int, text string) error
+	TogglePromptFavorite(ctx context.Context, historyID string, userID string) error
+	RerunPromptHistory(ctx context.Context, historyID string, userID string) (*models.PromptHistory, error)
+	ClaimPromptHistory(ctx context.Context, sessionID, userID string) (int64, error)
+	DeletePromptHistory(ctx context.Context, id string) error
+}
+
+// AnalyticsRepo stores usage analytics and technique effectiveness
+type AnalyticsRepo interface {
+	UpdateTechniqueEffectiveness(ctx context.Context, technique, intent string, feedbackScore float64) error
+	UpdateTechniqueEffectivenessBatch(ctx context.Context, feedback []TechniqueFeedback) error
+	ApplyTechniqueEffectiveness(ctx context.Context, batch EffectivenessBatch) (bool, error)
+	PruneTechniqueEffectivenessFlushes(ctx context.Context, before time.Time) (int64, error)
+	GetTechniqueEffectiveness(ctx context.Context, days int) ([]models.TechniqueEffectiveness, error)
+	RecordUserActivity(ctx context.Context, activity *models.UserActivity) error
+	RecordUserActivityBatch(ctx context.Context, activities []*models.UserActivity) error
+	UpdateDailyStats(ctx context.Context) error
+	GetDailyStats(ctx context.Context, days int) ([]models.DailyStats, error)
+}
+
+// LibraryRepo stores the prompts users save and the collections they
+// organise them in
+type LibraryRepo interface {
+	SavePrompt(ctx context.Context, saved *models.SavedPrompt) error
+	GetSavedPrompts(ctx context.Context, userID string, limit, offset int) ([]*models.SavedPrompt, error)
+	GetSavedPrompt(ctx context.Context, id string) (*models.SavedPrompt, error)
+	CreateCollection(ctx context.Context, collection *models.Collection) error
+	AddPromptToCollection(ctx context.Context, collectionID, promptID string, position int) error
+}
+
+// dbtx is what the repositories query through. Both *sqlx.DB and *sqlx.Tx
+// satisfy it, so the same repository code runs inside a transaction or not.
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+	QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row
+	BindNamed(query string, arg interface{}) (string, []interface{}, error)
+}
+
+// Repositories groups one of each repository over the same connection or
+// transaction
+type Repositories struct {
+	Users     UserRepo
+	Sessions  SessionRepo
+	History   HistoryRepo
+	Analytics AnalyticsRepo
+	Library   LibraryRepo
+}
+
+// NewRepositories creates repositories that run each query as a cached
+// prepared statement on the pool, bounded by the timeout for the
+// repository's operation
+func NewRepositories(db *sqlx.DB, timeouts DBTimeoutConfig, logger *logrus.Logger) Repositories {
+	return newRepositories(&preparedDB{stmts: newStmtCache(db)}, timeouts, logger)
+}
+
+func newRepositories(db dbtx, timeouts DBTimeoutConfig, logger *logrus.Logger) Repositories {
+	return Repositories{
+		Users:     &userRepo{db: timeouts.wrap(db, "users", logger)},
+		Sessions:  &sessionRepo{db: timeouts.wrap(db, "sessions", logger)},
+		History:   &historyRepo{db: timeouts.wrap(db, "history", logger)},
+		Analytics: &analyticsRepo{db: timeouts.wrap(db, "analytics", logger)},
+		Library:   &libraryRepo{db: timeouts.wrap(db, "library", logger)},
+	}
+}
+
+// TxManager runs work spanning several repositories in one transaction
+type TxManager struct {
+	stmts    *stmtCache
+	timeouts DBTimeoutConfig
+	logger   *logrus.Logger
+}
+
+// NewTxManager creates a transaction manager on the connection pool whose
+// repositories use the same statement timeouts as NewRepositories
+func NewTxManager(db *sqlx.DB, timeouts DBTimeoutConfig, logger *logrus.Logger) *TxManager {
+	return &TxManager{stmts: newStmtCache(db), timeouts: timeouts, logger: logger}
+}
+
+// WithinTx calls fn with repositories bound to a single transaction. The
+// transaction commits when fn returns nil and rolls back when it returns an
+// error or panics; the panic is re-raised after the rollback.
+func (m *TxManager) WithinTx(ctx context.Context, fn func(repos Repositories) error) error {
+	tx, err := m.stmts.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(newRepositories(&preparedDB{stmts: m.stmts, tx: tx}, m.timeouts, m.logger)); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingDriver is a database/sql driver that logs statements and
+// transaction boundaries instead of running them. rowsAffected decides what
+// each statement reports, rows what each query returns, fail which
+// statements error and delay how long all of them take.
+type recordingDriver struct {
+	mu           sync.Mutex
+	log          []string
+	args         [][]driver.NamedValue
+	prepares     int
+	rowsAffected func(query string) int64
+	rows         func(query string) ([]string, [][]driver.Value)
+	fail         func(query string) error
+	delay        time.Duration
+}
+
+var recordingDrivers atomic.Int64
+
+func newRecordingDB(t *testing.T, rowsAffected func(query string) int64) (*sqlx.DB, *recordingDriver) {
+	d := &recordingDriver{rowsAffected: rowsAffected}
+	name := fmt.Sprintf("recording-%d", recordingDrivers.Add(1))
+	sql.Register(name, d)
+
+	conn, err := sql.Open(name, "")
+	require.NoError(t, err)
+	// Bind named queries the way they are bound against postgres
+	db := sqlx.NewDb(conn, "postgres")
+	t.Cleanup(func() { db.Close() })
+	return db, d
+}
+
+func (d *recordingDriver) record(entry string, args []driver.NamedValue) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.log = append(d.log, entry)
+	d.args = append(d.args, args)
+}
+
+func (d *recordingDriver) entries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.log...)
+}
+
+func (d *recordingDriver) wait(ctx context.Context) error {
+	select {
+	case <-time.After(d.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *recordingDriver) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	if d.fail != nil {
+		if err := d.fail(query); err != nil {
+			return nil, err
+		}
+	}
+	d.record(strings.Join(strings.Fields(query), " "), args)
+	return driver.RowsAffected(d.rowsAffected(query)), nil
+}
+
+func (d *recordingDriver) query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := d.wait(ctx); err != nil {
+		return nil, err
+	}
+	d.record(strings.Join(strings.Fields(query), " "), args)
+	if d.rows == nil {
+		return nil, errors.New("recording driver has no rows")
+	}
+	columns, values := d.rows(query)
+	return &recordingRows{columns: columns, values: values}, nil
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return recordingConn{d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.mu.Lock()
+	c.d.prepares++
+	c.d.mu.Unlock()
+	return recordingStmt{d: c.d, query: query}, nil
+}
+func (c recordingConn) Close() error { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) {
+	c.d.record("BEGIN", nil)
+	return recordingTx{c.d}, nil
+}
+func (c recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.d.exec(ctx, query, args)
+}
+func (c recordingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.d.query(ctx, query, args)
+}
+
+type recordingTx struct{ d *recordingDriver }
+
+func (tx recordingTx) Commit() error   { tx.d.record("COMMIT", nil); return nil }
+func (tx recordingTx) Rollback() error { tx.d.record("ROLLBACK", nil); return nil }
+
+type recordingStmt struct {
+	d     *recordingDriver
+	query string
+}
+
+func (s recordingStmt) Close() error  { return nil }
+func (s recordingStmt) NumInput() int { return -1 }
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("recording driver only runs statements with a context")
+}
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("recording driver only runs statements with a context")
+}
+func (s recordingStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.d.exec(ctx, s.query, args)
+}
+func (s recordingStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.d.query(ctx, s.query, args)
+}
+
+type recordingRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string { return r.columns }
+func (r *recordingRows) Close() error      { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func TestTxManagerWithinTx(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	tx := NewTxManager(db, DBTimeoutConfig{}, nil)
+	ctx := context.Background()
+
+	err := tx.WithinTx(ctx, func(repos Repositories) error {
+		return repos.Sessions.DeleteUserSessions(ctx, "user-1")
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"BEGIN", "DELETE FROM auth.sessions WHERE user_id = $1", "COMMIT"}, d.entries())
+
+	t.Run("error rolls back", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		failure := errors.New("boom")
+
+		err := NewTxManager(db, DBTimeoutConfig{}, nil).WithinTx(ctx, func(repos Repositories) error {
+			if err := repos.Sessions.DeleteSession(ctx, "session-1"); err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+		assert.Equal(t, []string{"BEGIN", "DELETE FROM auth.sessions WHERE id = $1", "ROLLBACK"}, d.entries())
+	})
+
+	t.Run("panic rolls back", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+
+		assert.PanicsWithValue(t, "boom", func() {
+			NewTxManager(db, DBTimeoutConfig{}, nil).WithinTx(ctx, func(repos Repositories) error {
+				panic("boom")
+			})
+		})
+		assert.Equal(t, []string{"BEGIN", "ROLLBACK"}, d.entries())
+	})
+}
+
+func TestClaimAnonymousHistory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("claims history and updates the user together", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 3 })
+
+		claimed, err := newCompleteDatabaseService(db, DBTimeoutConfig{}, nil).ClaimAnonymousHistory(ctx, "session-1", "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), claimed)
+
+		log := d.entries()
+		require.Len(t, log, 4)
+		assert.Equal(t, "BEGIN", log[0])
+		assert.Contains(t, log[1], "UPDATE prompts.history SET user_id = $2")
+		assert.Contains(t, log[2], "UPDATE auth.users SET metadata = metadata || $2")
+		assert.Equal(t, "COMMIT", log[3])
+	})
+
+	t.Run("nothing to claim leaves the user alone", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+
+		claimed, err := newCompleteDatabaseService(db, DBTimeoutConfig{}, nil).ClaimAnonymousHistory(ctx, "session-1", "user-1")
+		require.NoError(t, err)
+		assert.Zero(t, claimed)
+		assert.Len(t, d.entries(), 3)
+		assert.Equal(t, "COMMIT", d.entries()[2])
+	})
+
+	t.Run("missing user undoes the claim", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(query string) int64 {
+			if strings.Contains(query, "auth.users") {
+				return 0
+			}
+			return 2
+		})
+
+		claimed, err := newCompleteDatabaseService(db, DBTimeoutConfig{}, nil).ClaimAnonymousHistory(ctx, "session-1", "missing")
+		assert.EqualError(t, err, "user not found")
+		assert.Zero(t, claimed)
+		assert.Equal(t, "ROLLBACK", d.entries()[3])
+	})
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+)
+
+// sessionRepo stores login sessions in auth.sessions
+type sessionRepo struct {
+	db dbtx
+}
+
+// CreateSession creates a new session
+func (r *sessionRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	query := `
+		INSERT INTO auth.sessions (
+			id, user_id, token_hash, refresh_token_hash,
+			user_agent, ip_address, expires_at
+		) VALUES (
+			:id, :user_id, :token_hash, :refresh_token_hash,
+			:user_agent, :ip_address, :expires_at
+		)`
+
+	if session.ID == "" {
+		session.ID = uuid.New().String()
+	}
+
+	_, err := namedExec(ctx, r.db, query, session)
+	return err
+}
+
+// GetSessionByTokenHash retrieves a session by token hash
+func (r *sessionRepo) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	var session models.Session
+
+	query := `
+		SELECT id, user_id, token_hash, refresh_token_hash,
+			   user_agent, ip_address, expires_at, created_at, last_activity
+		FROM auth.sessions
+		WHERE token_hash = $1 AND expires_at > CURRENT_TIMESTAMP`
+
+	err := r.db.QueryRowxContext(ctx, query, tokenHash).StructScan(&session)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("session not found or expired")
+	}
+
+	return &session, err
+}
+
+// UpdateSessionActivity updates session last activity
+func (r *sessionRepo) UpdateSessionActivity(ctx context.Context, sessionID string) error {
+	query := `
+		UPDATE auth.sessions 
+		SET last_activity = CURRENT_TIMESTAMP
+		WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, sessionID)
+	return err
+}
+
+// DeleteSession deletes a session
+func (r *sessionRepo) DeleteSession(ctx context.Context, sessionID string) error {
+	query := `DELETE FROM auth.sessions WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, sessionID)
+	return err
+}
+
+// DeleteUserSessions deletes all sessions for a user
+func (r *sessionRepo) DeleteUserSessions(ctx context.Context, userID string) error {
+	query := `DELETE FROM auth.sessions WHERE user_id = $1`
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// CleanExpiredSessions removes expired sessions
+func (r *sessionRepo) CleanExpiredSessions(ctx context.Context) (int64, error) {
+	query := `DELETE FROM auth.sessions WHERE expires_at < CURRENT_TIMESTAMP`
+	result, err := r.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+// sqlArgs collects positional query arguments. add returns the placeholder
+// for the value, so dynamic clauses are assembled from fixed SQL fragments
+// and placeholders and never have values formatted into them.
+type sqlArgs []interface{}
+
+func (a *sqlArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return "$" + strconv.Itoa(len(*a))
+}
+
+// snakeCase maps a struct field to its column for named queries and struct
+// scanning of fields without a db tag: UserID becomes user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// namedExec runs a query with :name parameters bound from arg's fields
+func namedExec(ctx context.Context, db dbtx, query string, arg interface{}) (sql.Result, error) {
+	query, args, err := db.BindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecContext(ctx, query, args...)
+}
+
+// historyOrderColumns are the columns history may be sorted by; anything
+// else falls back to newest first
+var historyOrderColumns = map[string]bool{
+	"created_at":     true,
+	"updated_at":     true,
+	"feedback_score": true,
+	"intent":         true,
+}
+
+// historyOrderBy returns the ORDER BY expression for a history page. Column
+// names can't be placeholders, so only known columns get into the query.
+func historyOrderBy(req models.PaginationRequest) string {
+	column := "created_at"
+	if historyOrderColumns[req.SortBy] {
+		column = req.SortBy
+	}
+	if strings.EqualFold(req.SortDirection, "ASC") {
+		return column + " ASC"
+	}
+	return column + " DESC"
+}
+
+// maxCachedStatements bounds the statement cache. Filtered history queries
+// vary with the filters in use, so the set of distinct queries is finite but
+// not tiny.
+const maxCachedStatements = 256
+
+// stmtCache prepares each distinct query once on the pool. A prepared
+// *sql.Stmt is in turn prepared lazily on each connection it runs on and
+// kept there, so queries are parsed once per connection rather than once per
+// call.
+type stmtCache struct {
+	db    *sqlx.DB
+	mu    sync.Mutex
+	stmts map[string]*sqlx.Stmt
+}
+
+// newStmtCache creates the statement cache for a pool, setting the pool to
+// map untagged struct fields to snake_case columns
+func newStmtCache(db *sqlx.DB) *stmtCache {
+	db.MapperFunc(snakeCase)
+	return &stmtCache{db: db, stmts: map[string]*sqlx.Stmt{}}
+}
+
+// prepare returns the cached statement for query, preparing it on first
+// use. It returns nil once the cache is full.
+func (c *stmtCache) prepare(ctx context.Context, query string) (*sqlx.Stmt, error) {
+	if stmt, full := c.cached(query); stmt != nil || full {
+		return stmt, nil
+	}
+
+	// Prepare without the lock so one slow prepare doesn't hold up queries
+	// that are already cached
+	stmt, err := c.db.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if existing, ok := c.stmts[query]; ok {
+		stmt.Close()
+		return existing, nil
+	}
+	c.stmts[query] = stmt
+	return stmt, nil
+}
+
+// cached returns the statement for query if it has been prepared, and
+// whether the cache has room for no more
+func (c *stmtCache) cached(query string) (*sqlx.Stmt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stmts[query], len(c.stmts) >= maxCachedStatements
+}
+
+// Close closes every cached statement
+func (c *stmtCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range c.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(c.stmts, query)
+	}
+	return firstErr
+}
+
+// preparedDB runs statements through the statement cache, on the pool or
+// bound to tx. Statements that can't be cached run unprepared, which also
+// reports a statement's preparation error to its caller. Preparing takes a
+// pool connection, so inside a transaction, which already holds one, only
+// statements prepared earlier are used.
+type preparedDB struct {
+	stmts *stmtCache
+	tx    *sqlx.Tx // nil outside a transaction
+}
+
+func (p *preparedDB) direct() dbtx {
+	if p.tx != nil {
+		return p.tx
+	}
+	return p.stmts.db
+}
+
+func (p *preparedDB) stmt(ctx context.Context, query string) *sqlx.Stmt {
+	if p.tx != nil {
+		if stmt, _ := p.stmts.cached(query); stmt != nil {
+			return p.tx.StmtxContext(ctx, stmt)
+		}
+		return nil
+	}
+	stmt, err := p.stmts.prepare(ctx, query)
+	if err != nil {
+		return nil
+	}
+	return stmt
+}
+
+func (p *preparedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return p.direct().ExecContext(ctx, query, args...)
+}
+
+func (p *preparedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return p.direct().QueryContext(ctx, query, args...)
+}
+
+func (p *preparedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return p.direct().QueryRowContext(ctx, query, args...)
+}
+
+func (p *preparedDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryxContext(ctx, args...)
+	}
+	return p.direct().QueryxContext(ctx, query, args...)
+}
+
+func (p *preparedDB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowxContext(ctx, args...)
+	}
+	return p.direct().QueryRowxContext(ctx, query, args...)
+}
+
+func (p *preparedDB) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return p.direct().BindNamed(query, arg)
+}
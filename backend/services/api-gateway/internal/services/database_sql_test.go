@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sqlFormat matches format strings that build SQL
+var sqlFormat = regexp.MustCompile(`\bSELECT\b|\bINSERT INTO\b|\bUPDATE\s+\S+\s+SET\b|\bDELETE FROM\b|\bWHERE\b|\bINTERVAL\b|\bORDER BY\b|\bLIMIT\b|\$%d`)
+
+// queryMethods take the query as their first non-context argument
+var queryMethods = regexp.MustCompile(`^(Exec|Query|QueryRow|Queryx|QueryRowx|Prepare|Preparex|Get|Select|NamedExec)(Context)?$`)
+
+// TestNoSprintfSQL keeps SQL in this package parameterized: fmt.Sprintf
+// must neither format a SQL string nor produce a query handed to the
+// database. Dynamic clauses are built with sqlArgs placeholders instead.
+func TestNoSprintfSQL(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	require.NoError(t, err)
+
+	fset := token.NewFileSet()
+	for _, name := range files {
+		if strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, 0)
+		require.NoError(t, err)
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if isSprintf(call) && len(call.Args) > 0 && sqlFormat.MatchString(stringValue(call.Args[0])) {
+				t.Errorf("%s: fmt.Sprintf builds SQL", fset.Position(call.Pos()))
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && queryMethods.MatchString(sel.Sel.Name) {
+				for _, arg := range call.Args {
+					if inner, ok := arg.(*ast.CallExpr); ok && isSprintf(inner) {
+						t.Errorf("%s: query for %s is built with fmt.Sprintf", fset.Position(inner.Pos()), sel.Sel.Name)
+					}
+				}
+			}
+			return true
+		})
+	}
+}
+
+func isSprintf(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != "Sprintf" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == "fmt"
+}
+
+// stringValue returns the string a format argument holds: a literal, or a
+// variable or constant initialised from one
+func stringValue(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if s, err := strconv.Unquote(e.Value); err == nil {
+			return s
+		}
+	case *ast.Ident:
+		if e.Obj == nil {
+			return ""
+		}
+		switch decl := e.Obj.Decl.(type) {
+		case *ast.AssignStmt:
+			for i, lhs := range decl.Lhs {
+				if id, ok := lhs.(*ast.Ident); ok && id.Name == e.Name && i < len(decl.Rhs) {
+					return stringValue(decl.Rhs[i])
+				}
+			}
+		case *ast.ValueSpec:
+			for i, name := range decl.Names {
+				if name.Name == e.Name && i < len(decl.Values) {
+					return stringValue(decl.Values[i])
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func TestSnakeCase(t *testing.T) {
+	for field, column := range map[string]string{
+		"ID":                    "id",
+		"UserID":                "user_id",
+		"IPAddress":             "ip_address",
+		"UITheme":               "ui_theme",
+		"RefreshTokenHash":      "refresh_token_hash",
+		"AverageResponseTimeMs": "average_response_time_ms",
+		"Top5Techniques":        "top5_techniques",
+	} {
+		assert.Equal(t, column, snakeCase(field), field)
+	}
+}
+
+func TestHistoryOrderBy(t *testing.T) {
+	assert.Equal(t, "created_at DESC", historyOrderBy(models.PaginationRequest{}))
+	assert.Equal(t, "feedback_score ASC", historyOrderBy(models.PaginationRequest{SortBy: "feedback_score", SortDirection: "asc"}))
+	assert.Equal(t, "created_at ASC", historyOrderBy(models.PaginationRequest{SortBy: "id; DROP TABLE prompts.history", SortDirection: "ASC"}))
+	assert.Equal(t, "intent DESC", historyOrderBy(models.PaginationRequest{SortBy: "intent", SortDirection: "sideways"}))
+}
+
+func TestSQLArgs(t *testing.T) {
+	var args sqlArgs
+	assert.Equal(t, "$1", args.add("a"))
+	assert.Equal(t, "$2", args.add(2))
+	assert.Equal(t, sqlArgs{"a", 2}, args)
+}
+
+func TestPreparedStatementsAreCached(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	repos := NewRepositories(db, DBTimeoutConfig{}, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, repos.Sessions.DeleteSession(ctx, "session-1"))
+	}
+	require.NoError(t, repos.Sessions.DeleteUserSessions(ctx, "user-1"))
+
+	assert.Len(t, d.entries(), 4)
+	assert.Equal(t, 2, d.prepares, "each distinct statement is prepared once")
+}
+
+func TestNamedQueriesAndStructScanning(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("named insert binds struct fields", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		session := &models.Session{
+			ID:        "session-1",
+			UserID:    "user-1",
+			TokenHash: "token",
+			IPAddress: sql.NullString{String: "10.0.0.1", Valid: true},
+			ExpiresAt: expires,
+		}
+
+		require.NoError(t, NewRepositories(db, DBTimeoutConfig{}, nil).Sessions.CreateSession(ctx, session))
+		require.Len(t, d.args, 1)
+		assert.Contains(t, d.entries()[0], "VALUES ( $1, $2, $3, $4, $5, $6, $7 )")
+
+		var values []driver.Value
+		for _, arg := range d.args[0] {
+			values = append(values, arg.Value)
+		}
+		assert.Equal(t, []driver.Value{"session-1", "user-1", "token", "", nil, "10.0.0.1", expires}, values)
+	})
+
+	t.Run("rows scan into structs by column", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		d.rows = func(string) ([]string, [][]driver.Value) {
+			return []string{"date", "total_requests", "unique_users", "new_users", "total_enhancements", "average_response_time_ms", "error_count"},
+				[][]driver.Value{
+					{expires, int64(120), int64(30), int64(4), int64(118), 212.5, int64(2)},
+					{expires.AddDate(0, 0, -1), int64(90), int64(25), int64(1), int64(90), 180.0, int64(0)},
+				}
+		}
+
+		stats, err := NewRepositories(db, DBTimeoutConfig{}, nil).Analytics.GetDailyStats(ctx, 7)
+		require.NoError(t, err)
+		require.Len(t, stats, 2)
+		assert.Equal(t, models.DailyStats{
+			Date: expires, TotalRequests: 120, UniqueUsers: 30, NewUsers: 4,
+			TotalEnhancements: 118, AverageResponseTimeMs: 212.5, ErrorCount: 2,
+		}, stats[0])
+
+		// The window is a parameter, never part of the SQL
+		assert.Contains(t, d.entries()[0], "make_interval(days => $1)")
+		assert.Equal(t, int64(7), d.args[0][0].Value)
+	})
+}
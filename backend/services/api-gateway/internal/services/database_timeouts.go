@@ -0,0 +1,209 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// DBTimeoutConfig bounds how long database statements may run
+type DBTimeoutConfig struct {
+	Default    time.Duration            // Deadline for each statement; zero leaves the caller's context alone
+	Operations map[string]time.Duration // Overrides by operation: users, sessions, history, analytics, library
+	Statement  time.Duration            // Server-side statement_timeout, the backstop for statements no deadline reaches
+	SlowQuery  time.Duration            // Statements slower than this are logged; zero disables the log
+}
+
+// dbOperations are the operation names DBTimeoutConfig.Operations is keyed by
+var dbOperations = []string{"users", "sessions", "history", "analytics", "library"}
+
+// LoadDBTimeoutConfig reads DB_QUERY_TIMEOUT, DB_QUERY_TIMEOUT_<OPERATION>,
+// DB_STATEMENT_TIMEOUT and DB_SLOW_QUERY_THRESHOLD. Analytics aggregates scan
+// far more rows than the per-request lookups, so they get a longer deadline.
+func LoadDBTimeoutConfig() DBTimeoutConfig {
+	config := DBTimeoutConfig{
+		Default:    5 * time.Second,
+		Operations: map[string]time.Duration{"analytics": 30 * time.Second},
+		Statement:  60 * time.Second,
+		SlowQuery:  500 * time.Millisecond,
+	}
+	if d, err := time.ParseDuration(getEnv("DB_QUERY_TIMEOUT", "")); err == nil && d >= 0 {
+		config.Default = d
+	}
+	for _, op := range dbOperations {
+		if d, err := time.ParseDuration(getEnv("DB_QUERY_TIMEOUT_"+strings.ToUpper(op), "")); err == nil && d >= 0 {
+			config.Operations[op] = d
+		}
+	}
+	if d, err := time.ParseDuration(getEnv("DB_STATEMENT_TIMEOUT", "")); err == nil && d >= 0 {
+		config.Statement = d
+	}
+	if d, err := time.ParseDuration(getEnv("DB_SLOW_QUERY_THRESHOLD", "")); err == nil && d >= 0 {
+		config.SlowQuery = d
+	}
+	return config
+}
+
+// Timeout returns the statement deadline for an operation
+func (c DBTimeoutConfig) Timeout(operation string) time.Duration {
+	if d, ok := c.Operations[operation]; ok {
+		return d
+	}
+	return c.Default
+}
+
+// ApplyToDSN sets statement_timeout on a postgres:// connection URL so the
+// server cancels runaway statements on every pooled connection. DSNs that
+// already set it, or aren't URLs, are returned unchanged.
+func (c DBTimeoutConfig) ApplyToDSN(dsn string) string {
+	if c.Statement <= 0 {
+		return dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return dsn
+	}
+	query := u.Query()
+	if query.Has("statement_timeout") {
+		return dsn
+	}
+	query.Set("statement_timeout", strconv.FormatInt(c.Statement.Milliseconds(), 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// timedDB bounds each statement of one operation with the operation's
+// deadline and logs the slow ones
+type timedDB struct {
+	db        dbtx
+	operation string
+	timeout   time.Duration
+	slow      time.Duration
+	logger    *logrus.Logger
+}
+
+func (c DBTimeoutConfig) wrap(db dbtx, operation string, logger *logrus.Logger) dbtx {
+	return &timedDB{
+		db:        db,
+		operation: operation,
+		timeout:   c.Timeout(operation),
+		slow:      c.SlowQuery,
+		logger:    logger,
+	}
+}
+
+func (t *timedDB) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.timeout)
+}
+
+func (t *timedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := t.bound(ctx)
+	defer cancel()
+
+	start := time.Now()
+	result, err := t.db.ExecContext(ctx, query, args...)
+	t.observe(ctx, query, start, err)
+	return result, err
+}
+
+// QueryContext's deadline covers reading the rows as well as running the
+// query, so it can't be cancelled on return; its timer releases it once the
+// deadline passes.
+func (t *timedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, cancel := t.bound(ctx)
+
+	start := time.Now()
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	t.observe(ctx, query, start, err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	_ = cancel
+	return rows, nil
+}
+
+// QueryRowContext leaves its deadline running for the caller's Scan, like
+// QueryContext
+func (t *timedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, cancel := t.bound(ctx)
+
+	start := time.Now()
+	row := t.db.QueryRowContext(ctx, query, args...)
+	t.observe(ctx, query, start, row.Err())
+	_ = cancel
+	return row
+}
+
+// QueryxContext bounds a struct-scanning query like QueryContext
+func (t *timedDB) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	ctx, cancel := t.bound(ctx)
+
+	start := time.Now()
+	rows, err := t.db.QueryxContext(ctx, query, args...)
+	t.observe(ctx, query, start, err)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	_ = cancel
+	return rows, nil
+}
+
+// QueryRowxContext bounds a struct-scanning row like QueryRowContext
+func (t *timedDB) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	ctx, cancel := t.bound(ctx)
+
+	start := time.Now()
+	row := t.db.QueryRowxContext(ctx, query, args...)
+	t.observe(ctx, query, start, row.Err())
+	_ = cancel
+	return row
+}
+
+func (t *timedDB) BindNamed(query string, arg interface{}) (string, []interface{}, error) {
+	return t.db.BindNamed(query, arg)
+}
+
+// observe logs statements that ran past the slow-query threshold or their
+// deadline. Query times cover the round trip to the first row.
+func (t *timedDB) observe(ctx context.Context, query string, start time.Time, err error) {
+	if t.logger == nil {
+		return
+	}
+	elapsed := time.Since(start)
+	timedOut := errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded)
+	if !timedOut && (t.slow <= 0 || elapsed < t.slow) {
+		return
+	}
+
+	entry := t.logger.WithFields(logrus.Fields{
+		"operation":   t.operation,
+		"duration_ms": elapsed.Milliseconds(),
+		"query":       summarizeQuery(query),
+	})
+	if timedOut {
+		entry.WithField("timeout_ms", t.timeout.Milliseconds()).Warn("Database query timed out")
+		return
+	}
+	entry.Warn("Slow database query")
+}
+
+// summarizeQuery collapses a query's whitespace and caps its length for logs
+func summarizeQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > 200 {
+		query = query[:200] + "..."
+	}
+	return query
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDBTimeoutConfig(t *testing.T) {
+	config := LoadDBTimeoutConfig()
+	assert.Equal(t, 5*time.Second, config.Timeout("users"))
+	assert.Equal(t, 30*time.Second, config.Timeout("analytics"))
+	assert.Equal(t, 500*time.Millisecond, config.SlowQuery)
+
+	t.Setenv("DB_QUERY_TIMEOUT", "2s")
+	t.Setenv("DB_QUERY_TIMEOUT_HISTORY", "3s")
+	t.Setenv("DB_QUERY_TIMEOUT_ANALYTICS", "0")
+	t.Setenv("DB_STATEMENT_TIMEOUT", "45s")
+	t.Setenv("DB_SLOW_QUERY_THRESHOLD", "not a duration")
+
+	config = LoadDBTimeoutConfig()
+	assert.Equal(t, 2*time.Second, config.Timeout("sessions"))
+	assert.Equal(t, 3*time.Second, config.Timeout("history"))
+	assert.Zero(t, config.Timeout("analytics"), "an explicit zero disables the deadline")
+	assert.Equal(t, 45*time.Second, config.Statement)
+	assert.Equal(t, 500*time.Millisecond, config.SlowQuery)
+}
+
+func TestDBTimeoutConfigApplyToDSN(t *testing.T) {
+	config := DBTimeoutConfig{Statement: 45 * time.Second}
+
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"postgres://u:p@db:5432/bp", "postgres://u:p@db:5432/bp?statement_timeout=45000"},
+		{"postgres://u:p@db:5432/bp?sslmode=disable", "postgres://u:p@db:5432/bp?sslmode=disable&statement_timeout=45000"},
+		{"postgres://db/bp?statement_timeout=1000", "postgres://db/bp?statement_timeout=1000"},
+		{"host=db dbname=bp", "host=db dbname=bp"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, config.ApplyToDSN(tt.dsn), tt.dsn)
+	}
+
+	assert.Equal(t, "postgres://db/bp", DBTimeoutConfig{}.ApplyToDSN("postgres://db/bp"))
+}
+
+func TestTimedDB(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	t.Run("deadline cancels the statement", func(t *testing.T) {
+		hook.Reset()
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.delay = time.Second
+		timeouts := DBTimeoutConfig{Default: time.Second, Operations: map[string]time.Duration{"sessions": 20 * time.Millisecond}}
+
+		start := time.Now()
+		err := NewRepositories(db, timeouts, logger).Sessions.DeleteSession(context.Background(), "session-1")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+		assert.Empty(t, d.entries())
+
+		require.Len(t, hook.AllEntries(), 1)
+		entry := hook.LastEntry()
+		assert.Equal(t, "Database query timed out", entry.Message)
+		assert.Equal(t, "sessions", entry.Data["operation"])
+		assert.Equal(t, int64(20), entry.Data["timeout_ms"])
+		assert.Equal(t, "DELETE FROM auth.sessions WHERE id = $1", entry.Data["query"])
+	})
+
+	t.Run("caller cancellation propagates", func(t *testing.T) {
+		hook.Reset()
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.delay = time.Second
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+		err := NewRepositories(db, DBTimeoutConfig{Default: time.Second}, logger).Sessions.DeleteSession(ctx, "session-1")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, hook.AllEntries(), "cancelled requests aren't timeouts")
+	})
+
+	t.Run("slow statements are logged", func(t *testing.T) {
+		hook.Reset()
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.delay = 30 * time.Millisecond
+		timeouts := DBTimeoutConfig{Default: time.Second, SlowQuery: 10 * time.Millisecond}
+
+		require.NoError(t, NewRepositories(db, timeouts, logger).Sessions.DeleteUserSessions(context.Background(), "user-1"))
+		require.Len(t, hook.AllEntries(), 1)
+		assert.Equal(t, "Slow database query", hook.LastEntry().Message)
+		assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+		assert.GreaterOrEqual(t, hook.LastEntry().Data["duration_ms"], int64(30))
+	})
+
+	t.Run("fast statements aren't logged", func(t *testing.T) {
+		hook.Reset()
+		db, _ := newRecordingDB(t, func(string) int64 { return 1 })
+		timeouts := DBTimeoutConfig{Default: time.Second, SlowQuery: time.Second}
+
+		require.NoError(t, NewRepositories(db, timeouts, logger).Sessions.DeleteUserSessions(context.Background(), "user-1"))
+		assert.Empty(t, hook.AllEntries())
+	})
+}
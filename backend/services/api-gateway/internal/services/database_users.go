@@ -0,0 +1,252 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// userRepo stores accounts in auth.users and their preferences in
+// auth.user_preferences
+type userRepo struct {
+	db dbtx
+}
+
+// CreateUser creates a new user
+func (r *userRepo) CreateUser(ctx context.Context, user *models.User) error {
+	query := `
+		INSERT INTO auth.users (
+			id, email, username, password_hash, first_name, last_name,
+			avatar_url, role, tier, preferences, metadata
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		)`
+
+	if user.ID == "" {
+		user.ID = uuid.New().String()
+	}
+	if user.Roles[0] == "" {
+		user.Roles[0] = "user"
+	}
+	if user.Tier == "" {
+		user.Tier = "free"
+	}
+
+	prefsJSON, _ := json.Marshal(user.Preferences)
+	metaJSON, _ := json.Marshal(user.Metadata)
+
+	_, err := r.db.ExecContext(ctx, query,
+		user.ID, user.Email, user.Username, user.PasswordHash,
+		user.FirstName, user.LastName, user.AvatarURL,
+		user.Roles[0], user.Tier, prefsJSON, metaJSON,
+	)
+
+	return err
+}
+
+// GetUserByEmail retrieves a user by email
+func (r *userRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	var prefsJSON, metaJSON []byte
+
+	query := `
+		SELECT id, email, username, password_hash, first_name, last_name,
+			   avatar_url, is_active, is_verified, role, tier, preferences,
+			   metadata, created_at, updated_at, last_login_at
+		FROM auth.users
+		WHERE LOWER(email) = LOWER($1)`
+
+	err := r.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID, &user.Email, &user.Username, &user.PasswordHash,
+		&user.FirstName, &user.LastName, &user.AvatarURL,
+		&user.IsActive, &user.IsVerified, &user.Roles[0], &user.Tier,
+		&prefsJSON, &metaJSON, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastLoginAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal(prefsJSON, &user.Preferences)
+	json.Unmarshal(metaJSON, &user.Metadata)
+
+	return &user, nil
+}
+
+// GetUserByID retrieves a user by ID
+func (r *userRepo) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	var user models.User
+	var prefsJSON, metaJSON []byte
+
+	query := `
+		SELECT id, email, username, password_hash, first_name, last_name,
+			   avatar_url, is_active, is_verified, role, tier, preferences,
+			   metadata, created_at, updated_at, last_login_at
+		FROM auth.users
+		WHERE id = $1`
+
+	err := r.db.QueryRowContext(ctx, query, id).Scan(
+		&user.ID, &user.Email, &user.Username, &user.PasswordHash,
+		&user.FirstName, &user.LastName, &user.AvatarURL,
+		&user.IsActive, &user.IsVerified, &user.Roles[0], &user.Tier,
+		&prefsJSON, &metaJSON, &user.CreatedAt, &user.UpdatedAt,
+		&user.LastLoginAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found")
+	} else if err != nil {
+		return nil, err
+	}
+
+	json.Unmarshal(prefsJSON, &user.Preferences)
+	json.Unmarshal(metaJSON, &user.Metadata)
+
+	return &user, nil
+}
+
+// UpdateUserLastLogin updates user's last login time
+func (r *userRepo) UpdateUserLastLogin(ctx context.Context, userID string) error {
+	query := `
+		UPDATE auth.users 
+		SET last_login_at = CURRENT_TIMESTAMP, failed_login_attempts = 0
+		WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// UpdateUserMetadata merges keys into the user's metadata, leaving keys not
+// in metadata untouched
+func (r *userRepo) UpdateUserMetadata(ctx context.Context, userID string, metadata map[string]interface{}) error {
+	query := `
+		UPDATE auth.users
+		SET metadata = metadata || $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`
+
+	metaJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode user metadata: %w", err)
+	}
+
+	result, err := r.db.ExecContext(ctx, query, userID, metaJSON)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+// IncrementFailedLogins increments failed login attempts
+func (r *userRepo) IncrementFailedLogins(ctx context.Context, email string) (int, error) {
+	var attempts int
+	query := `
+		UPDATE auth.users 
+		SET failed_login_attempts = failed_login_attempts + 1
+		WHERE LOWER(email) = LOWER($1)
+		RETURNING failed_login_attempts`
+
+	err := r.db.QueryRowContext(ctx, query, email).Scan(&attempts)
+	return attempts, err
+}
+
+// LockUser locks a user account until specified time
+func (r *userRepo) LockUser(ctx context.Context, email string, until time.Time) error {
+	query := `
+		UPDATE auth.users 
+		SET locked_until = $2
+		WHERE LOWER(email) = LOWER($1)`
+
+	_, err := r.db.ExecContext(ctx, query, email, until)
+	return err
+}
+
+// GetUserPreferences retrieves user preferences
+func (r *userRepo) GetUserPreferences(ctx context.Context, userID string) (*models.UserPreferences, error) {
+	var prefs models.UserPreferences
+	var preferredTech, excludedTech pq.StringArray
+	var customJSON []byte
+
+	query := `
+		SELECT id, user_id, preferred_techniques, excluded_techniques,
+			   complexity_preference, ui_theme, ui_language,
+			   email_notifications, analytics_opt_in, custom_settings
+		FROM auth.user_preferences
+		WHERE user_id = $1`
+
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&prefs.ID, &prefs.UserID, &preferredTech, &excludedTech,
+		&prefs.ComplexityPreference, &prefs.UITheme, &prefs.UILanguage,
+		&prefs.EmailNotifications, &prefs.AnalyticsOptIn, &customJSON,
+	)
+
+	if err == sql.ErrNoRows {
+		// Return default preferences
+		return &models.UserPreferences{
+			UserID:             userID,
+			UITheme:            "light",
+			UILanguage:         "en",
+			EmailNotifications: true,
+			AnalyticsOptIn:     true,
+		}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	prefs.PreferredTechniques = []string(preferredTech)
+	prefs.ExcludedTechniques = []string(excludedTech)
+	json.Unmarshal(customJSON, &prefs.CustomSettings)
+
+	return &prefs, nil
+}
+
+// UpdateUserPreferences updates user preferences
+func (r *userRepo) UpdateUserPreferences(ctx context.Context, prefs *models.UserPreferences) error {
+	query := `
+		INSERT INTO auth.user_preferences (
+			id, user_id, preferred_techniques, excluded_techniques,
+			complexity_preference, ui_theme, ui_language,
+			email_notifications, analytics_opt_in, custom_settings
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (user_id) DO UPDATE
+		SET 
+			preferred_techniques = $3,
+			excluded_techniques = $4,
+			complexity_preference = $5,
+			ui_theme = $6,
+			ui_language = $7,
+			email_notifications = $8,
+			analytics_opt_in = $9,
+			custom_settings = $10`
+
+	if prefs.ID == "" {
+		prefs.ID = uuid.New().String()
+	}
+
+	preferredTech := pq.Array(prefs.PreferredTechniques)
+	excludedTech := pq.Array(prefs.ExcludedTechniques)
+	customJSON, _ := json.Marshal(prefs.CustomSettings)
+
+	_, err := r.db.ExecContext(ctx, query,
+		prefs.ID, prefs.UserID, preferredTech, excludedTech,
+		prefs.ComplexityPreference, prefs.UITheme, prefs.UILanguage,
+		prefs.EmailNotifications, prefs.AnalyticsOptIn, customJSON,
+	)
+
+	return err
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// BatchWriteConfig sizes the batches the write-behind queue writes
+type BatchWriteConfig struct {
+	Enabled       bool          // Queue prompt history writes rather than writing them inline
+	BatchSize     int           // Rows written per statement
+	FlushInterval time.Duration // Longest a queued row waits for its batch to fill
+	QueueSize     int           // Rows held before writes fall back to inline
+}
+
+var defaultBatchWriteConfig = BatchWriteConfig{
+	BatchSize:     200,
+	FlushInterval: time.Second,
+	QueueSize:     10000,
+}
+
+// LoadBatchWriteConfig reads DB_WRITE_BEHIND, DB_BATCH_SIZE,
+// DB_BATCH_FLUSH_INTERVAL and DB_WRITE_BEHIND_QUEUE_SIZE. Write-behind is
+// off unless explicitly enabled, as queued history isn't readable until its
+// batch is written.
+func LoadBatchWriteConfig() BatchWriteConfig {
+	config := defaultBatchWriteConfig
+	config.Enabled, _ = strconv.ParseBool(getEnv("DB_WRITE_BEHIND", ""))
+	if n, err := strconv.Atoi(getEnv("DB_BATCH_SIZE", "")); err == nil && n > 0 {
+		config.BatchSize = n
+	}
+	if d, err := time.ParseDuration(getEnv("DB_BATCH_FLUSH_INTERVAL", "")); err == nil && d > 0 {
+		config.FlushInterval = d
+	}
+	if n, err := strconv.Atoi(getEnv("DB_WRITE_BEHIND_QUEUE_SIZE", "")); err == nil && n > 0 {
+		config.QueueSize = n
+	}
+	return config
+}
+
+// WriteBehindQueue buffers history, activity and effectiveness writes and
+// writes each kind in batches, once BatchSize rows are waiting or
+// FlushInterval has passed. A batch that fails is retried row by row so one
+// bad row doesn't cost the rest.
+type WriteBehindQueue struct {
+	history   HistoryRepo
+	analytics AnalyticsRepo
+	config    BatchWriteConfig
+	logger    *logrus.Logger
+
+	mu      sync.RWMutex // Held for reading while enqueueing, so Close can't miss a row
+	closed  bool
+	writes  chan interface{}
+	flushes chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewWriteBehindQueue starts a queue writing through repos. Unset sizes
+// take their defaults.
+func NewWriteBehindQueue(repos Repositories, config BatchWriteConfig, logger *logrus.Logger) *WriteBehindQueue {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchWriteConfig.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultBatchWriteConfig.FlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultBatchWriteConfig.QueueSize
+	}
+
+	q := &WriteBehindQueue{
+		history:   repos.History,
+		analytics: repos.Analytics,
+		config:    config,
+		logger:    logger,
+		writes:    make(chan interface{}, config.QueueSize),
+		flushes:   make(chan chan struct{}),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// SavePromptHistory queues an entry. It returns false when the queue is
+// full or closed, and the caller should write the entry itself.
+func (q *WriteBehindQueue) SavePromptHistory(entry *models.PromptHistory) bool {
+	return q.enqueue(entry)
+}
+
+// RecordUserActivity queues an activity, returning false like
+// SavePromptHistory
+func (q *WriteBehindQueue) RecordUserActivity(activity *models.UserActivity) bool {
+	return q.enqueue(activity)
+}
+
+// UpdateTechniqueEffectiveness queues a feedback score, returning false like
+// SavePromptHistory
+func (q *WriteBehindQueue) UpdateTechniqueEffectiveness(feedback TechniqueFeedback) bool {
+	return q.enqueue(feedback)
+}
+
+func (q *WriteBehindQueue) enqueue(write interface{}) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.writes <- write:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush writes everything queued before it was called
+func (q *WriteBehindQueue) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case q.flushes <- flushed:
+	case <-q.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting writes and waits for the queued ones to be written
+func (q *WriteBehindQueue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		<-q.stopped
+		return
+	}
+	q.closed = true
+	q.mu.Unlock()
+
+	close(q.done)
+	<-q.stopped
+}
+
+// writeBatch holds the queued rows of each kind
+type writeBatch struct {
+	history  []*models.PromptHistory
+	activity []*models.UserActivity
+	feedback []TechniqueFeedback
+}
+
+func (b *writeBatch) add(write interface{}) {
+	switch w := write.(type) {
+	case *models.PromptHistory:
+		b.history = append(b.history, w)
+	case *models.UserActivity:
+		b.activity = append(b.activity, w)
+	case TechniqueFeedback:
+		b.feedback = append(b.feedback, w)
+	}
+}
+
+func (q *WriteBehindQueue) run() {
+	defer close(q.stopped)
+
+	ticker := time.NewTicker(q.config.FlushInterval)
+	defer ticker.Stop()
+
+	var batch writeBatch
+	for {
+		select {
+		case write := <-q.writes:
+			batch.add(write)
+			q.flushFull(&batch)
+		case <-ticker.C:
+			q.flush(&batch)
+		case flushed := <-q.flushes:
+			q.drain(&batch)
+			q.flush(&batch)
+			close(flushed)
+		case <-q.done:
+			q.drain(&batch)
+			q.flush(&batch)
+			return
+		}
+	}
+}
+
+// drain moves every write waiting in the channel into batch
+func (q *WriteBehindQueue) drain(batch *writeBatch) {
+	for {
+		select {
+		case write := <-q.writes:
+			batch.add(write)
+		default:
+			return
+		}
+	}
+}
+
+// flushFull writes the kinds that have a full batch waiting
+func (q *WriteBehindQueue) flushFull(batch *writeBatch) {
+	if len(batch.history) >= q.config.BatchSize {
+		q.writeHistory(batch.history)
+		batch.history = nil
+	}
+	if len(batch.activity) >= q.config.BatchSize {
+		q.writeActivity(batch.activity)
+		batch.activity = nil
+	}
+	if len(batch.feedback) >= q.config.BatchSize {
+		q.writeFeedback(batch.feedback)
+		batch.feedback = nil
+	}
+}
+
+// flush writes everything in batch, BatchSize rows at a time
+func (q *WriteBehindQueue) flush(batch *writeBatch) {
+	for len(batch.history) > 0 {
+		n := min(len(batch.history), q.config.BatchSize)
+		q.writeHistory(batch.history[:n])
+		batch.history = batch.history[n:]
+	}
+	for len(batch.activity) > 0 {
+		n := min(len(batch.activity), q.config.BatchSize)
+		q.writeActivity(batch.activity[:n])
+		batch.activity = batch.activity[n:]
+	}
+	for len(batch.feedback) > 0 {
+		n := min(len(batch.feedback), q.config.BatchSize)
+		q.writeFeedback(batch.feedback[:n])
+		batch.feedback = batch.feedback[n:]
+	}
+	*batch = writeBatch{}
+}
+
+func (q *WriteBehindQueue) writeHistory(entries []*models.PromptHistory) {
+	ctx := context.Background()
+	err := q.history.SavePromptHistoryBatch(ctx, entries)
+	if err == nil {
+		return
+	}
+	q.logger.WithError(err).WithField("rows", len(entries)).Warn("Prompt history batch failed, writing rows one at a time")
+	for _, entry := range entries {
+		if err := q.history.SavePromptHistory(ctx, entry); err != nil {
+			q.logger.WithError(err).WithField("history_id", entry.ID).Error("Failed to write queued prompt history")
+		}
+	}
+}
+
+func (q *WriteBehindQueue) writeActivity(activities []*models.UserActivity) {
+	ctx := context.Background()
+	err := q.analytics.RecordUserActivityBatch(ctx, activities)
+	if err == nil {
+		return
+	}
+	q.logger.WithError(err).WithField("rows", len(activities)).Warn("User activity batch failed, writing rows one at a time")
+	for _, activity := range activities {
+		if err := q.analytics.RecordUserActivity(ctx, activity); err != nil {
+			q.logger.WithError(err).WithField("activity_type", activity.Type).Error("Failed to write queued user activity")
+		}
+	}
+}
+
+func (q *WriteBehindQueue) writeFeedback(feedback []TechniqueFeedback) {
+	ctx := context.Background()
+	err := q.analytics.UpdateTechniqueEffectivenessBatch(ctx, feedback)
+	if err == nil {
+		return
+	}
+	q.logger.WithError(err).WithField("rows", len(feedback)).Warn("Technique effectiveness batch failed, writing rows one at a time")
+	for _, f := range feedback {
+		if err := q.analytics.UpdateTechniqueEffectiveness(ctx, f.Technique, f.Intent, f.Score); err != nil {
+			q.logger.WithError(err).WithField("technique", f.Technique).Error("Failed to write queued technique feedback")
+		}
+	}
+}
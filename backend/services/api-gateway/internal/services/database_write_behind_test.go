@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBatchWriteConfig(t *testing.T) {
+	config := LoadBatchWriteConfig()
+	assert.False(t, config.Enabled)
+	assert.Equal(t, 200, config.BatchSize)
+	assert.Equal(t, time.Second, config.FlushInterval)
+
+	t.Setenv("DB_WRITE_BEHIND", "true")
+	t.Setenv("DB_BATCH_SIZE", "50")
+	t.Setenv("DB_BATCH_FLUSH_INTERVAL", "250ms")
+	t.Setenv("DB_WRITE_BEHIND_QUEUE_SIZE", "-1")
+
+	config = LoadBatchWriteConfig()
+	assert.True(t, config.Enabled)
+	assert.Equal(t, 50, config.BatchSize)
+	assert.Equal(t, 250*time.Millisecond, config.FlushInterval)
+	assert.Equal(t, 10000, config.QueueSize)
+}
+
+func TestBatchWrites(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("history is one statement whatever the batch size", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 2 })
+		entries := []*models.PromptHistory{
+			{OriginalInput: "a", EnhancedOutput: "A", UserID: sql.NullString{String: "user-1", Valid: true}},
+			{OriginalInput: "b", EnhancedOutput: "B", TechniquesUsed: []string{"chain_of_thought"}},
+		}
+
+		require.NoError(t, NewRepositories(db, DBTimeoutConfig{}, nil).History.SavePromptHistoryBatch(ctx, entries))
+		require.Len(t, d.entries(), 1)
+		assert.Contains(t, d.entries()[0], "FROM unnest(")
+		assert.NotEmpty(t, entries[0].ID)
+		assert.False(t, entries[1].CreatedAt.IsZero())
+
+		args := d.args[0]
+		require.Len(t, args, 16)
+		assert.Equal(t, `{"user-1",NULL}`, args[1].Value, "anonymous entries have no user")
+		assert.Equal(t, `{"a","b"}`, args[4].Value)
+		assert.Equal(t, `{"[]","[\"chain_of_thought\"]"}`, args[9].Value)
+	})
+
+	t.Run("effectiveness is summed per technique and intent", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 2 })
+		feedback := []TechniqueFeedback{
+			{Technique: "role_play", Intent: "creative", Score: 5},
+			{Technique: "chain_of_thought", Intent: "reasoning", Score: 2},
+			{Technique: "role_play", Intent: "creative", Score: 3},
+		}
+
+		require.NoError(t, NewRepositories(db, DBTimeoutConfig{}, nil).Analytics.UpdateTechniqueEffectivenessBatch(ctx, feedback))
+		args := d.args[0]
+		assert.Equal(t, `{"chain_of_thought","role_play"}`, args[0].Value)
+		assert.Equal(t, `{"reasoning","creative"}`, args[1].Value)
+		assert.Equal(t, `{0,1}`, args[2].Value)
+		assert.Equal(t, `{1,2}`, args[3].Value)
+		assert.Equal(t, `{2,4}`, args[4].Value)
+	})
+
+	t.Run("empty batches write nothing", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		repos := NewRepositories(db, DBTimeoutConfig{}, nil)
+
+		require.NoError(t, repos.History.SavePromptHistoryBatch(ctx, nil))
+		require.NoError(t, repos.Analytics.RecordUserActivityBatch(ctx, nil))
+		require.NoError(t, repos.Analytics.UpdateTechniqueEffectivenessBatch(ctx, nil))
+		assert.Empty(t, d.entries())
+	})
+}
+
+func TestWriteBehindQueue(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	ctx := context.Background()
+
+	t.Run("full batches are written without waiting", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 2 })
+		q := NewWriteBehindQueue(NewRepositories(db, DBTimeoutConfig{}, nil), BatchWriteConfig{BatchSize: 2, FlushInterval: time.Hour}, logger)
+		defer q.Close()
+
+		for i := 0; i < 3; i++ {
+			require.True(t, q.SavePromptHistory(&models.PromptHistory{OriginalInput: "prompt"}))
+		}
+		assert.Eventually(t, func() bool { return len(d.entries()) == 1 }, time.Second, time.Millisecond)
+
+		require.NoError(t, q.Flush(ctx))
+		assert.Len(t, d.entries(), 2, "the remainder goes at the flush")
+	})
+
+	t.Run("batches go out on the flush interval", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		q := NewWriteBehindQueue(NewRepositories(db, DBTimeoutConfig{}, nil), BatchWriteConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond}, logger)
+		defer q.Close()
+
+		require.True(t, q.RecordUserActivity(&models.UserActivity{Type: "login"}))
+		require.True(t, q.UpdateTechniqueEffectiveness(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 4}))
+		assert.Eventually(t, func() bool { return len(d.entries()) == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("failed batches are retried row by row", func(t *testing.T) {
+		hook.Reset()
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.fail = func(query string) error {
+			if strings.Contains(query, "unnest") {
+				return errors.New("duplicate key")
+			}
+			return nil
+		}
+		q := NewWriteBehindQueue(NewRepositories(db, DBTimeoutConfig{}, nil), BatchWriteConfig{BatchSize: 10, FlushInterval: time.Hour}, logger)
+		defer q.Close()
+
+		q.SavePromptHistory(&models.PromptHistory{OriginalInput: "a"})
+		q.SavePromptHistory(&models.PromptHistory{OriginalInput: "b"})
+		require.NoError(t, q.Flush(ctx))
+
+		assert.Len(t, d.entries(), 2)
+		for _, entry := range d.entries() {
+			assert.Contains(t, entry, "VALUES ($1, $2")
+		}
+		assert.Equal(t, "Prompt history batch failed, writing rows one at a time", hook.LastEntry().Message)
+	})
+
+	t.Run("close writes what is queued and refuses more", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		q := NewWriteBehindQueue(NewRepositories(db, DBTimeoutConfig{}, nil), BatchWriteConfig{BatchSize: 10, FlushInterval: time.Hour}, logger)
+
+		require.True(t, q.SavePromptHistory(&models.PromptHistory{OriginalInput: "a"}))
+		q.Close()
+		assert.Len(t, d.entries(), 1)
+		assert.False(t, q.SavePromptHistory(&models.PromptHistory{OriginalInput: "b"}))
+		assert.NoError(t, q.Flush(ctx))
+	})
+
+	t.Run("full queue hands writes back", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.delay = 50 * time.Millisecond
+		q := NewWriteBehindQueue(NewRepositories(db, DBTimeoutConfig{}, nil), BatchWriteConfig{BatchSize: 1, FlushInterval: time.Hour, QueueSize: 1}, logger)
+		defer q.Close()
+
+		accepted := 0
+		for i := 0; i < 5; i++ {
+			if q.SavePromptHistory(&models.PromptHistory{OriginalInput: "prompt"}) {
+				accepted++
+			}
+		}
+		assert.Less(t, accepted, 5)
+	})
+}
+
+func TestDatabaseServiceWriteBehind(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	service := NewDatabaseService(db.DB)
+	service.EnableWriteBehind(BatchWriteConfig{BatchSize: 10, FlushInterval: time.Hour}, logger)
+
+	id, err := service.SavePromptHistory(context.Background(), models.PromptHistory{OriginalInput: "prompt"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, id)
+	assert.Empty(t, d.entries(), "the entry waits for its batch")
+
+	require.NoError(t, service.FlushWrites(context.Background()))
+	require.Len(t, d.entries(), 1)
+	assert.Contains(t, d.args[0][0].Value, id)
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/lock"
+)
+
+// RequestDeduplicator collapses identical concurrent requests onto a single
+// execution. The first caller takes a Redis lock and runs the work; callers
+// arriving while it is in flight, or shortly after it finished, wait for and
+// receive the same result.
+type RequestDeduplicator struct {
+	cache        *CacheService
+	locks        *lock.Locker
+	lockTTL      time.Duration
+	resultTTL    time.Duration
+	pollInterval time.Duration
+}
+
+// NewRequestDeduplicator creates a deduplicator backed by the cache's Redis
+func NewRequestDeduplicator(cache *CacheService) *RequestDeduplicator {
+	return &RequestDeduplicator{
+		cache:        cache,
+		locks:        lock.New(cache.client),
+		lockTTL:      30 * time.Second,
+		resultTTL:    5 * time.Second,
+		pollInterval: 100 * time.Millisecond,
+	}
+}
+
+// Do runs fn at most once for concurrent callers sharing key and decodes the
+// result into dest. It reports whether the result came from another caller.
+// Redis failures fall back to running fn directly.
+func (d *RequestDeduplicator) Do(ctx context.Context, key string, dest interface{}, fn func() (interface{}, error)) (bool, error) {
+	lockKey := d.cache.LocalKey("dedup", key, "lock")
+	resultKey := d.cache.LocalKey("dedup", key, "result")
+
+	for {
+		// A recent result means this is a double submit
+		if data, err := d.cache.client.Get(ctx, resultKey).Bytes(); err == nil {
+			return true, json.Unmarshal(data, dest)
+		}
+
+		held, err := d.locks.TryAcquire(ctx, lockKey, lock.Options{TTL: d.lockTTL})
+		if err == nil {
+			return false, d.run(ctx, held, resultKey, dest, fn)
+		}
+		// A caller that gave up isn't Redis being unavailable
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return false, ctxErr
+		}
+		if !errors.Is(err, lock.ErrNotAcquired) {
+			return false, d.run(ctx, nil, resultKey, dest, fn)
+		}
+
+		// Another request is in flight; wait for its result
+		shared, err := d.wait(ctx, lockKey, resultKey, dest)
+		if err != nil || shared {
+			return shared, err
+		}
+		// The leader released without a result (it failed); retry as leader
+	}
+}
+
+// run executes fn, publishes the result for waiters and releases the lock.
+// held is nil when Redis is unavailable and fn runs unlocked.
+func (d *RequestDeduplicator) run(ctx context.Context, held *lock.Lock, resultKey string, dest interface{}, fn func() (interface{}, error)) error {
+	if held != nil {
+		defer held.Release(context.Background())
+	}
+
+	value, err := fn()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result: %w", err)
+	}
+
+	if held != nil {
+		if err := d.cache.client.Set(ctx, resultKey, data, d.resultTTL).Err(); err != nil {
+			d.cache.logger.WithError(err).Warn("Failed to publish deduplicated result")
+		}
+	}
+
+	return json.Unmarshal(data, dest)
+}
+
+// wait polls until the leader publishes a result or gives up the lock
+func (d *RequestDeduplicator) wait(ctx context.Context, lockKey, resultKey string, dest interface{}) (bool, error) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if data, err := d.cache.client.Get(ctx, resultKey).Bytes(); err == nil {
+			return true, json.Unmarshal(data, dest)
+		}
+
+		// Treat Redis errors like a released lock so the caller falls back to running itself
+		inFlight, err := d.locks.Held(ctx, lockKey)
+		if err != nil || !inFlight {
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/betterprompts/api-gateway/internal/lock"
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type dedupResult struct {
+	Value string `json:"value"`
+}
+
+// newTestDeduplicator returns a deduplicator over an in-memory Redis that
+// polls quickly
+func newTestDeduplicator(t *testing.T) (*RequestDeduplicator, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	dedup := NewRequestDeduplicator(NewCacheService(client, logger))
+	dedup.pollInterval = 5 * time.Millisecond
+	return dedup, server
+}
+
+func TestRequestDeduplicator(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("replays a recent result", func(t *testing.T) {
+		dedup, server := newTestDeduplicator(t)
+		var calls int32
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return dedupResult{Value: "enhanced"}, nil
+		}
+
+		var first, second dedupResult
+		shared, err := dedup.Do(ctx, "k1", &first, fn)
+		require.NoError(t, err)
+		assert.False(t, shared)
+		assert.Equal(t, "enhanced", first.Value)
+		assert.False(t, server.Exists("betterprompts:dedup:k1:lock"), "the lock is released")
+		assert.Equal(t, dedup.resultTTL, server.TTL("betterprompts:dedup:k1:result"))
+
+		shared, err = dedup.Do(ctx, "k1", &second, fn)
+		require.NoError(t, err)
+		assert.True(t, shared, "a double submit gets the first result")
+		assert.Equal(t, first, second)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+		// Once the result expires the work runs again
+		server.FastForward(dedup.resultTTL)
+		shared, err = dedup.Do(ctx, "k1", &second, fn)
+		require.NoError(t, err)
+		assert.False(t, shared)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("waiters share the leader's result", func(t *testing.T) {
+		dedup, _ := newTestDeduplicator(t)
+		started, finish := make(chan struct{}), make(chan struct{})
+		var calls int32
+
+		leaderDone := make(chan error, 1)
+		go func() {
+			var result dedupResult
+			_, err := dedup.Do(ctx, "k2", &result, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				close(started)
+				<-finish
+				return dedupResult{Value: "from leader"}, nil
+			})
+			leaderDone <- err
+		}()
+		<-started
+
+		waiterDone := make(chan error, 1)
+		var result dedupResult
+		var shared bool
+		go func() {
+			var err error
+			shared, err = dedup.Do(ctx, "k2", &result, func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				return dedupResult{Value: "from waiter"}, nil
+			})
+			waiterDone <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		close(finish)
+		require.NoError(t, <-leaderDone)
+		require.NoError(t, <-waiterDone)
+		assert.True(t, shared)
+		assert.Equal(t, "from leader", result.Value)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("waiters retry when the leader fails", func(t *testing.T) {
+		dedup, _ := newTestDeduplicator(t)
+		started, finish := make(chan struct{}), make(chan struct{})
+
+		leaderDone := make(chan error, 1)
+		go func() {
+			var result dedupResult
+			_, err := dedup.Do(ctx, "k3", &result, func() (interface{}, error) {
+				close(started)
+				<-finish
+				return nil, errors.New("generation failed")
+			})
+			leaderDone <- err
+		}()
+		<-started
+
+		waiterDone := make(chan error, 1)
+		var result dedupResult
+		var shared bool
+		go func() {
+			var err error
+			shared, err = dedup.Do(ctx, "k3", &result, func() (interface{}, error) {
+				return dedupResult{Value: "retried"}, nil
+			})
+			waiterDone <- err
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		close(finish)
+		assert.EqualError(t, <-leaderDone, "generation failed")
+		require.NoError(t, <-waiterDone)
+		assert.False(t, shared, "the waiter took over as leader")
+		assert.Equal(t, "retried", result.Value)
+	})
+
+	t.Run("waiting stops with the context", func(t *testing.T) {
+		dedup, server := newTestDeduplicator(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+		held, err := lock.New(client).TryAcquire(ctx, "betterprompts:dedup:k4:lock", lock.Options{TTL: time.Minute, RenewInterval: -1})
+		require.NoError(t, err)
+		defer held.Release(ctx)
+
+		waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		var result dedupResult
+		_, err = dedup.Do(waitCtx, "k4", &result, func() (interface{}, error) {
+			t.Error("the work runs once, in the lock holder")
+			return nil, nil
+		})
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("runs unlocked without Redis", func(t *testing.T) {
+		dedup, server := newTestDeduplicator(t)
+		server.Close()
+
+		var calls int32
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return dedupResult{Value: "direct"}, nil
+		}
+		for i := 0; i < 2; i++ {
+			var result dedupResult
+			shared, err := dedup.Do(ctx, "k5", &result, fn)
+			require.NoError(t, err)
+			assert.False(t, shared)
+			assert.Equal(t, "direct", result.Value)
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "nothing is shared without Redis")
+	})
+}
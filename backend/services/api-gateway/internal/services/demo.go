@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Ways a demo caller proves they aren't a script
+const (
+	DemoChallengeProofOfWork = "pow"     // Solve a hash puzzle issued by the gateway
+	DemoChallengeCaptcha     = "captcha" // Pass a CAPTCHA; Cloudflare Turnstile by default
+)
+
+var (
+	// ErrDemoProofRequired means the request carried no challenge solution
+	// or CAPTCHA token
+	ErrDemoProofRequired = errors.New("a demo challenge solution is required")
+	// ErrDemoProofInvalid means the solution, token or challenge didn't
+	// check out, including challenges that expired or were already used
+	ErrDemoProofInvalid = errors.New("demo challenge failed")
+	// ErrDemoCaptchaUnavailable means the CAPTCHA provider couldn't be
+	// asked; demo requests are refused until it answers
+	ErrDemoCaptchaUnavailable = errors.New("CAPTCHA verification unavailable")
+	// ErrDemoSecretMissing means the demo is enabled with proof-of-work
+	// challenges but there is no key to sign them with
+	ErrDemoSecretMissing = errors.New("DEMO_CHALLENGE_SECRET or JWT_SECRET_KEY must be set for proof-of-work challenges")
+)
+
+// DemoConfig configures the public demo on the landing page
+type DemoConfig struct {
+	Enabled          bool
+	Challenge        string        // DemoChallengeProofOfWork or DemoChallengeCaptcha
+	Difficulty       int           // Leading zero bits a proof of work's hash needs
+	ChallengeTTL     time.Duration // How long an issued challenge can be solved
+	Secret           string        // Signs proof-of-work challenges
+	CaptchaSiteKey   string        // Given to the landing page to render the CAPTCHA
+	CaptchaSecret    string
+	CaptchaVerifyURL string // The provider's siteverify endpoint
+	PerIPDaily       int    // Demo enhancements per IP per day
+	GlobalDaily      int    // Demo enhancements per day across every caller
+	MaxTextLength    int
+	Watermark        string // Appended to every demo result
+}
+
+// LoadDemoConfig reads the DEMO_* environment variables. The challenge
+// secret falls back to JWT_SECRET_KEY.
+func LoadDemoConfig() DemoConfig {
+	config := DemoConfig{
+		Enabled:          os.Getenv("DEMO_ENABLED") == "true",
+		Challenge:        DemoChallengeProofOfWork,
+		Difficulty:       20,
+		ChallengeTTL:     5 * time.Minute,
+		Secret:           signingSecret("DEMO_CHALLENGE_SECRET"),
+		CaptchaSiteKey:   os.Getenv("DEMO_CAPTCHA_SITE_KEY"),
+		CaptchaSecret:    os.Getenv("DEMO_CAPTCHA_SECRET"),
+		CaptchaVerifyURL: getEnv("DEMO_CAPTCHA_VERIFY_URL", "https://challenges.cloudflare.com/turnstile/v0/siteverify"),
+		PerIPDaily:       5,
+		GlobalDaily:      1000,
+		MaxTextLength:    1000,
+		Watermark:        getEnv("DEMO_WATERMARK", "Enhanced with the BetterPrompts demo. Sign up to save and refine your prompts."),
+	}
+	if v := strings.TrimSpace(os.Getenv("DEMO_CHALLENGE")); v == DemoChallengeCaptcha || v == DemoChallengeProofOfWork {
+		config.Challenge = v
+	}
+	if n, err := strconv.Atoi(os.Getenv("DEMO_POW_DIFFICULTY")); err == nil && n > 0 && n <= 32 {
+		config.Difficulty = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("DEMO_CHALLENGE_TTL")); err == nil && d > 0 {
+		config.ChallengeTTL = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("DEMO_PER_IP_DAILY")); err == nil && n > 0 {
+		config.PerIPDaily = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DEMO_GLOBAL_DAILY")); err == nil && n > 0 {
+		config.GlobalDaily = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("DEMO_MAX_TEXT_LENGTH")); err == nil && n > 0 {
+		config.MaxTextLength = n
+	}
+	return config
+}
+
+// DemoChallenge is what the landing page needs to prove it isn't a script:
+// a puzzle to solve, or the site key to render the CAPTCHA with
+type DemoChallenge struct {
+	Mode       string     `json:"mode"`
+	Challenge  string     `json:"challenge,omitempty"`
+	Difficulty int        `json:"difficulty,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	SiteKey    string     `json:"site_key,omitempty"`
+}
+
+// DemoProof is what a demo request presents
+type DemoProof struct {
+	Challenge    string // An issued proof-of-work challenge
+	Solution     string // Makes SHA-256(challenge + ":" + solution) start with Difficulty zero bits
+	CaptchaToken string
+	RemoteIP     string
+}
+
+// DemoService guards the public demo: it issues and checks proof-of-work
+// challenges or CAPTCHA tokens, and watermarks results. Proof-of-work
+// challenges are signed rather than stored, and each can be redeemed once.
+type DemoService struct {
+	config DemoConfig
+	cache  *CacheService
+	client *http.Client
+	logger *logrus.Logger
+}
+
+// NewDemoService creates the demo service, nil when the demo is disabled.
+// Redeemed challenges are remembered in cache, which the demo requires.
+func NewDemoService(config DemoConfig, cache *CacheService, logger *logrus.Logger) (*DemoService, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if cache == nil {
+		return nil, errors.New("the demo requires Redis")
+	}
+	switch config.Challenge {
+	case DemoChallengeProofOfWork:
+		if config.Secret == "" {
+			return nil, ErrDemoSecretMissing
+		}
+	case DemoChallengeCaptcha:
+		if config.CaptchaSecret == "" {
+			return nil, errors.New("DEMO_CAPTCHA_SECRET must be set for CAPTCHA challenges")
+		}
+	}
+	return &DemoService{
+		config: config,
+		cache:  cache,
+		client: &http.Client{Timeout: 5 * time.Second},
+		logger: logger,
+	}, nil
+}
+
+// Config returns the demo's configuration
+func (s *DemoService) Config() DemoConfig {
+	return s.config
+}
+
+// NewChallenge issues a challenge for the configured mode
+func (s *DemoService) NewChallenge() (*DemoChallenge, error) {
+	if s.config.Challenge == DemoChallengeCaptcha {
+		return &DemoChallenge{Mode: DemoChallengeCaptcha, SiteKey: s.config.CaptchaSiteKey}, nil
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	expires := time.Now().Add(s.config.ChallengeTTL).UTC().Truncate(time.Second)
+	payload := fmt.Sprintf("%s.%d.%d", hex.EncodeToString(nonce), expires.Unix(), s.config.Difficulty)
+	return &DemoChallenge{
+		Mode:       DemoChallengeProofOfWork,
+		Challenge:  payload + "." + s.sign(payload),
+		Difficulty: s.config.Difficulty,
+		ExpiresAt:  &expires,
+	}, nil
+}
+
+func (s *DemoService) sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.Secret))
+	mac.Write([]byte("demo-challenge:" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a demo request's proof against the configured mode
+func (s *DemoService) Verify(ctx context.Context, proof DemoProof) error {
+	if s.config.Challenge == DemoChallengeCaptcha {
+		if proof.CaptchaToken == "" {
+			return ErrDemoProofRequired
+		}
+		return s.verifyCaptcha(ctx, proof)
+	}
+	if proof.Challenge == "" || proof.Solution == "" {
+		return ErrDemoProofRequired
+	}
+	return s.verifyProofOfWork(ctx, proof)
+}
+
+// verifyProofOfWork checks the challenge is one the gateway issued and is
+// still current, that the solution solves it, and redeems it
+func (s *DemoService) verifyProofOfWork(ctx context.Context, proof DemoProof) error {
+	parts := strings.Split(proof.Challenge, ".")
+	if len(parts) != 4 || len(proof.Solution) > 64 {
+		return ErrDemoProofInvalid
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(s.sign(payload))) {
+		return ErrDemoProofInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return ErrDemoProofInvalid
+	}
+	difficulty, err := strconv.Atoi(parts[2])
+	if err != nil || !solvesDemoChallenge(proof.Challenge, proof.Solution, difficulty) {
+		return ErrDemoProofInvalid
+	}
+
+	// Each challenge is good for one request; remember it until it expires
+	ttl := time.Until(time.Unix(expires, 0)) + time.Second
+	first, err := s.cache.client.SetNX(ctx, s.cache.Key("demo", "redeemed", parts[0]), 1, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to redeem demo challenge: %w", err)
+	}
+	if !first {
+		return ErrDemoProofInvalid
+	}
+	return nil
+}
+
+// solvesDemoChallenge reports whether SHA-256(challenge + ":" + solution)
+// starts with difficulty zero bits
+func solvesDemoChallenge(challenge, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + ":" + solution))
+	zeros := 0
+	for _, b := range sum {
+		if b != 0 {
+			zeros += bits.LeadingZeros8(b)
+			break
+		}
+		zeros += 8
+	}
+	return zeros >= difficulty
+}
+
+// verifyCaptcha asks the CAPTCHA provider whether the token is valid. The
+// Turnstile, hCaptcha and reCAPTCHA siteverify endpoints share this form.
+func (s *DemoService) verifyCaptcha(ctx context.Context, proof DemoProof) error {
+	form := url.Values{"secret": {s.config.CaptchaSecret}, "response": {proof.CaptchaToken}}
+	if proof.RemoteIP != "" {
+		form.Set("remoteip", proof.RemoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.CaptchaVerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.logger.WithError(err).Warn("CAPTCHA verification failed")
+		return ErrDemoCaptchaUnavailable
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		s.logger.WithField("status", resp.StatusCode).Warn("Unexpected CAPTCHA verification response")
+		return ErrDemoCaptchaUnavailable
+	}
+	if !result.Success {
+		s.logger.WithField("error_codes", result.ErrorCodes).Debug("CAPTCHA rejected")
+		return ErrDemoProofInvalid
+	}
+	return nil
+}
+
+// Watermark marks an enhanced prompt as a demo result
+func (s *DemoService) Watermark(text string) string {
+	if s.config.Watermark == "" {
+		return text
+	}
+	return strings.TrimRight(text, "\n") + "\n\n---\n" + s.config.Watermark
+}
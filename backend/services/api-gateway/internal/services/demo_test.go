@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDemoConfig(t *testing.T) {
+	t.Setenv("DEMO_ENABLED", "true")
+	t.Setenv("DEMO_CHALLENGE_SECRET", "")
+	t.Setenv("JWT_SECRET_KEY", "jwt-secret")
+	t.Setenv("DEMO_CHALLENGE", "captcha")
+	t.Setenv("DEMO_POW_DIFFICULTY", "64")
+	t.Setenv("DEMO_PER_IP_DAILY", "3")
+
+	config := LoadDemoConfig()
+	assert.True(t, config.Enabled)
+	assert.Equal(t, DemoChallengeCaptcha, config.Challenge)
+	assert.Equal(t, "jwt-secret", config.Secret)
+	assert.Equal(t, 20, config.Difficulty, "out of range difficulties are ignored")
+	assert.Equal(t, 3, config.PerIPDaily)
+	assert.Equal(t, 1000, config.GlobalDaily)
+
+	t.Setenv("DEMO_ENABLED", "")
+	demo, err := NewDemoService(LoadDemoConfig(), nil, logrus.New())
+	assert.NoError(t, err)
+	assert.Nil(t, demo)
+
+	// Proof-of-work challenges can't be signed without a key
+	t.Setenv("DEMO_ENABLED", "true")
+	t.Setenv("DEMO_CHALLENGE", "pow")
+	t.Setenv("JWT_SECRET_KEY", "")
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	_, err = NewDemoService(LoadDemoConfig(), NewCacheService(client, logrus.New()), logrus.New())
+	assert.ErrorIs(t, err, ErrDemoSecretMissing)
+}
+
+// solveDemoChallenge finds a solution the way the landing page does
+func solveDemoChallenge(challenge string, difficulty int) string {
+	for n := 0; ; n++ {
+		if solution := strconv.Itoa(n); solvesDemoChallenge(challenge, solution, difficulty) {
+			return solution
+		}
+	}
+}
+
+func TestDemoProofOfWork(t *testing.T) {
+	// Nothing listens here: a proof that gets as far as being redeemed
+	// fails on Redis instead of ErrDemoProofInvalid
+	cache := NewCacheService(redis.NewClient(&redis.Options{Addr: "localhost:0"}), logrus.New())
+	demo, err := NewDemoService(DemoConfig{
+		Enabled:      true,
+		Challenge:    DemoChallengeProofOfWork,
+		Difficulty:   8,
+		ChallengeTTL: time.Minute,
+		Secret:       "secret",
+	}, cache, logrus.New())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	challenge, err := demo.NewChallenge()
+	require.NoError(t, err)
+	assert.Equal(t, DemoChallengeProofOfWork, challenge.Mode)
+	assert.Equal(t, 8, challenge.Difficulty)
+	solution := solveDemoChallenge(challenge.Challenge, 8)
+
+	err = demo.Verify(ctx, DemoProof{Challenge: challenge.Challenge, Solution: solution})
+	assert.ErrorContains(t, err, "failed to redeem demo challenge")
+	assert.NotErrorIs(t, err, ErrDemoProofInvalid)
+
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{Challenge: challenge.Challenge}), ErrDemoProofRequired)
+
+	wrong := solution + "x"
+	for solvesDemoChallenge(challenge.Challenge, wrong, 8) {
+		wrong += "x"
+	}
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{Challenge: challenge.Challenge, Solution: wrong}), ErrDemoProofInvalid)
+
+	// Lowering the difficulty breaks the signature
+	parts := strings.Split(challenge.Challenge, ".")
+	easier := strings.Join([]string{parts[0], parts[1], "0", parts[3]}, ".")
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{Challenge: easier, Solution: "1"}), ErrDemoProofInvalid)
+
+	// So does another secret
+	other, err := NewDemoService(DemoConfig{Enabled: true, Challenge: DemoChallengeProofOfWork, Difficulty: 8, Secret: "other"}, cache, logrus.New())
+	require.NoError(t, err)
+	assert.ErrorIs(t, other.Verify(ctx, DemoProof{Challenge: challenge.Challenge, Solution: solution}), ErrDemoProofInvalid)
+
+	// Expired challenges are refused however well solved
+	demo.config.ChallengeTTL = -time.Second
+	expired, err := demo.NewChallenge()
+	require.NoError(t, err)
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{Challenge: expired.Challenge, Solution: solveDemoChallenge(expired.Challenge, 8)}), ErrDemoProofInvalid)
+}
+
+func TestDemoCaptcha(t *testing.T) {
+	var forms []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.Form.Encode())
+		switch r.Form.Get("response") {
+		case "good":
+			w.Write([]byte(`{"success":true}`))
+		case "down":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+		}
+	}))
+	defer server.Close()
+
+	cache := NewCacheService(nil, logrus.New())
+	demo, err := NewDemoService(DemoConfig{
+		Enabled:          true,
+		Challenge:        DemoChallengeCaptcha,
+		CaptchaSiteKey:   "site-key",
+		CaptchaSecret:    "captcha-secret",
+		CaptchaVerifyURL: server.URL,
+	}, cache, logrus.New())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	challenge, err := demo.NewChallenge()
+	require.NoError(t, err)
+	assert.Equal(t, &DemoChallenge{Mode: DemoChallengeCaptcha, SiteKey: "site-key"}, challenge)
+
+	assert.NoError(t, demo.Verify(ctx, DemoProof{CaptchaToken: "good", RemoteIP: "203.0.113.7"}))
+	assert.Equal(t, "remoteip=203.0.113.7&response=good&secret=captcha-secret", forms[0])
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{CaptchaToken: "bad"}), ErrDemoProofInvalid)
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{CaptchaToken: "down"}), ErrDemoCaptchaUnavailable)
+	assert.ErrorIs(t, demo.Verify(ctx, DemoProof{Challenge: "a.b.c.d", Solution: "1"}), ErrDemoProofRequired)
+
+	_, err = NewDemoService(DemoConfig{Enabled: true, Challenge: DemoChallengeCaptcha}, cache, logrus.New())
+	assert.Error(t, err, "CAPTCHA without a secret")
+	_, err = NewDemoService(DemoConfig{Enabled: true, Challenge: DemoChallengeCaptcha, CaptchaSecret: "s"}, nil, logrus.New())
+	assert.Error(t, err, "the demo without Redis")
+}
+
+func TestDemoWatermark(t *testing.T) {
+	demo := &DemoService{config: DemoConfig{Watermark: "Made with the demo"}}
+	assert.Equal(t, "Enhanced prompt\n\n---\nMade with the demo", demo.Watermark("Enhanced prompt\n"))
+
+	demo.config.Watermark = ""
+	assert.Equal(t, "Enhanced prompt", demo.Watermark("Enhanced prompt"))
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxDeviceFingerprintLength matches auth.user_devices.fingerprint
+const maxDeviceFingerprintLength = 128
+
+// ErrDeviceNotFound is returned for devices that don't exist or belong to another user
+var ErrDeviceNotFound = errors.New("device not found")
+
+// Device is a device a user has signed in from, as stored in auth.user_devices
+type Device struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Fingerprint string     `json:"-"`
+	Name        string     `json:"name"`
+	Platform    string     `json:"platform"`
+	UserAgent   string     `json:"user_agent,omitempty"`
+	LastIP      string     `json:"last_ip,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+	LastSeenAt  time.Time  `json:"last_seen_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Revoked reports whether the device's refresh tokens have been revoked
+func (d *Device) Revoked() bool {
+	return d.RevokedAt != nil
+}
+
+// DeviceInfo describes the device making a sign-in or refresh request
+type DeviceInfo struct {
+	Fingerprint string
+	Name        string
+	Platform    string
+	UserAgent   string
+	IP          string
+}
+
+// DeviceFingerprint returns the fingerprint a client supplied, or one derived
+// from its user agent for clients that don't send one
+func DeviceFingerprint(supplied, userAgent string) string {
+	supplied = strings.TrimSpace(supplied)
+	if supplied == "" {
+		sum := sha256.Sum256([]byte(userAgent))
+		return "ua:" + hex.EncodeToString(sum[:16])
+	}
+	if len(supplied) > maxDeviceFingerprintLength {
+		supplied = supplied[:maxDeviceFingerprintLength]
+	}
+	return supplied
+}
+
+// DefaultDeviceName describes a device from its user agent, e.g. "Firefox on Windows"
+func DefaultDeviceName(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	var browser string
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	}
+
+	var os string
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"):
+		os = "iOS"
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os"):
+		os = "macOS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os + " device"
+	default:
+		return "Unknown device"
+	}
+}
+
+// DeviceService manages the devices refresh tokens are bound to
+type DeviceService struct {
+	db *DatabaseService
+}
+
+// NewDeviceService creates a new device service
+func NewDeviceService(db *DatabaseService) *DeviceService {
+	return &DeviceService{db: db}
+}
+
+// RegisterDevice records a sign-in from a device, creating it on first use.
+// Signing in again with credentials restores a revoked device.
+func (s *DeviceService) RegisterDevice(ctx context.Context, userID string, info DeviceInfo) (*Device, error) {
+	name := info.Name
+	if name == "" {
+		name = DefaultDeviceName(info.UserAgent)
+	}
+
+	query := `
+		INSERT INTO auth.user_devices (user_id, fingerprint, name, platform, user_agent, last_ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, fingerprint) DO UPDATE
+		SET name = CASE WHEN $7 THEN EXCLUDED.name ELSE auth.user_devices.name END,
+			platform = COALESCE(NULLIF(EXCLUDED.platform, ''), auth.user_devices.platform),
+			user_agent = EXCLUDED.user_agent,
+			last_ip = EXCLUDED.last_ip,
+			revoked_at = NULL,
+			last_seen_at = CURRENT_TIMESTAMP
+		RETURNING id, user_id, fingerprint, name, platform, user_agent,
+				  last_ip, revoked_at, last_seen_at, created_at`
+
+	device, err := scanDevice(s.db.DB.QueryRowContext(ctx, query,
+		userID, info.Fingerprint, name, info.Platform, nullableString(info.UserAgent), nullableString(info.IP), info.Name != "",
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register device: %w", err)
+	}
+	return device, nil
+}
+
+// GetDevice returns one of the user's devices
+func (s *DeviceService) GetDevice(ctx context.Context, userID, deviceID string) (*Device, error) {
+	query := `
+		SELECT id, user_id, fingerprint, name, platform, user_agent,
+			   last_ip, revoked_at, last_seen_at, created_at
+		FROM auth.user_devices
+		WHERE id = $1 AND user_id = $2`
+
+	device, err := scanDevice(s.db.DB.QueryRowContext(ctx, query, deviceID, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return device, nil
+}
+
+// ListDevices returns the user's devices, most recently seen first
+func (s *DeviceService) ListDevices(ctx context.Context, userID string) ([]*Device, error) {
+	query := `
+		SELECT id, user_id, fingerprint, name, platform, user_agent,
+			   last_ip, revoked_at, last_seen_at, created_at
+		FROM auth.user_devices
+		WHERE user_id = $1
+		ORDER BY last_seen_at DESC`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query devices: %w", err)
+	}
+	defer rows.Close()
+
+	devices := []*Device{}
+	for rows.Next() {
+		device, err := scanDevice(rows)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate devices: %w", err)
+	}
+
+	return devices, nil
+}
+
+// RenameDevice sets a device's display name
+func (s *DeviceService) RenameDevice(ctx context.Context, userID, deviceID, name string) (*Device, error) {
+	query := `
+		UPDATE auth.user_devices
+		SET name = $3
+		WHERE id = $1 AND user_id = $2
+		RETURNING id, user_id, fingerprint, name, platform, user_agent,
+				  last_ip, revoked_at, last_seen_at, created_at`
+
+	device, err := scanDevice(s.db.DB.QueryRowContext(ctx, query, deviceID, userID, name))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	return device, nil
+}
+
+// RevokeDevice invalidates every refresh token issued to the device. The
+// device stays listed so the user can see it was revoked.
+func (s *DeviceService) RevokeDevice(ctx context.Context, userID, deviceID string) error {
+	query := `
+		UPDATE auth.user_devices
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	result, err := s.db.DB.ExecContext(ctx, query, deviceID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke device: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrDeviceNotFound
+	}
+	return nil
+}
+
+// RevokeAllDevices signs the user out everywhere, returning how many devices
+// were revoked
+func (s *DeviceService) RevokeAllDevices(ctx context.Context, userID string) (int64, error) {
+	query := `
+		UPDATE auth.user_devices
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND revoked_at IS NULL`
+
+	result, err := s.db.DB.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke devices: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// TouchDevice records that the device just refreshed its session
+func (s *DeviceService) TouchDevice(ctx context.Context, deviceID, ip string) error {
+	query := `
+		UPDATE auth.user_devices
+		SET last_seen_at = CURRENT_TIMESTAMP, last_ip = COALESCE($2, last_ip)
+		WHERE id = $1`
+
+	if _, err := s.db.DB.ExecContext(ctx, query, deviceID, nullableString(ip)); err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	return nil
+}
+
+// nullableString maps empty strings to SQL NULL
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func scanDevice(row rowScanner) (*Device, error) {
+	var device Device
+	var userAgent, lastIP sql.NullString
+	var revokedAt sql.NullTime
+
+	err := row.Scan(
+		&device.ID, &device.UserID, &device.Fingerprint, &device.Name, &device.Platform,
+		&userAgent, &lastIP, &revokedAt, &device.LastSeenAt, &device.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan device: %w", err)
+	}
+
+	device.UserAgent = userAgent.String
+	device.LastIP = lastIP.String
+	if revokedAt.Valid {
+		device.RevokedAt = &revokedAt.Time
+	}
+	return &device, nil
+}
@@ -0,0 +1,49 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceFingerprint(t *testing.T) {
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/120.0"
+
+	t.Run("uses supplied fingerprint", func(t *testing.T) {
+		assert.Equal(t, "device-123", DeviceFingerprint("  device-123 ", ua))
+	})
+
+	t.Run("truncates long fingerprints", func(t *testing.T) {
+		fp := DeviceFingerprint(strings.Repeat("a", 200), ua)
+		assert.Len(t, fp, maxDeviceFingerprintLength)
+	})
+
+	t.Run("derives a stable fingerprint from the user agent", func(t *testing.T) {
+		fp := DeviceFingerprint("", ua)
+		assert.True(t, strings.HasPrefix(fp, "ua:"))
+		assert.Equal(t, fp, DeviceFingerprint("", ua))
+		assert.NotEqual(t, fp, DeviceFingerprint("", "curl/8.0"))
+	})
+}
+
+func TestDefaultDeviceName(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		expected  string
+	}{
+		{"firefox on windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:120.0) Gecko/20100101 Firefox/120.0", "Firefox on Windows"},
+		{"edge before chrome", "Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/120.0 Safari/537.36 Edg/120.0", "Edge on Windows"},
+		{"chrome on android", "Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"safari on ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"unknown", "curl/8.0", "Unknown device"},
+		{"empty", "", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, DefaultDeviceName(tt.userAgent))
+		})
+	}
+}
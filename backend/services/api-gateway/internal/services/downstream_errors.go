@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Downstream failure kinds. Use errors.Is with these to classify an error
+// returned by a service client.
+var (
+	// ErrDownstreamTimeout means the service didn't answer in time
+	ErrDownstreamTimeout = errors.New("downstream timeout")
+	// ErrDownstreamUnavailable means the service couldn't be reached or
+	// failed on its side
+	ErrDownstreamUnavailable = errors.New("downstream unavailable")
+	// ErrDownstreamBadRequest means the service rejected the request;
+	// sending it again won't help
+	ErrDownstreamBadRequest = errors.New("downstream rejected request")
+	// ErrDownstreamRateLimited means the service is shedding load
+	ErrDownstreamRateLimited = errors.New("downstream rate limited")
+	// ErrDownstreamInvalidResponse means the service answered with a
+	// response that doesn't match its schema
+	ErrDownstreamInvalidResponse = errors.New("downstream response doesn't match its schema")
+)
+
+// DownstreamError is a failed call to one of the ML services
+type DownstreamError struct {
+	Service    string
+	Kind       error // One of the ErrDownstream* kinds
+	StatusCode int   // Zero when no response was received
+	Body       string
+	RetryAfter time.Duration // From Retry-After when rate limited
+	Err        error         // Transport error, if any
+}
+
+func (e *DownstreamError) Error() string {
+	switch {
+	case e.StatusCode != 0 && e.Body != "":
+		return fmt.Sprintf("%s returned status %d: %s", e.Service, e.StatusCode, e.Body)
+	case e.StatusCode != 0:
+		return fmt.Sprintf("%s returned status %d", e.Service, e.StatusCode)
+	case e.Err != nil:
+		return fmt.Sprintf("%s: %v", e.Service, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Service, e.Kind)
+}
+
+// Is matches the error's kind
+func (e *DownstreamError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DownstreamError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetriable reports whether a failed downstream call may succeed if
+// repeated. Rejected requests and errors from outside the clients are not.
+func IsRetriable(err error) bool {
+	return errors.Is(err, ErrDownstreamTimeout) ||
+		errors.Is(err, ErrDownstreamUnavailable) ||
+		errors.Is(err, ErrDownstreamRateLimited)
+}
+
+// statusError classifies a non-2xx response from service
+func statusError(service string, resp *http.Response, body []byte) *DownstreamError {
+	err := &DownstreamError{
+		Service:    service,
+		StatusCode: resp.StatusCode,
+		Body:       string(body),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err.Kind = ErrDownstreamRateLimited
+		if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+			err.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusGatewayTimeout:
+		err.Kind = ErrDownstreamTimeout
+	case resp.StatusCode >= 500:
+		err.Kind = ErrDownstreamUnavailable
+	default:
+		err.Kind = ErrDownstreamBadRequest
+	}
+	return err
+}
+
+// transportError classifies a call to service that got no response. A
+// cancelled caller context is returned as is: nothing went wrong
+// downstream.
+func transportError(service string, err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	kind := ErrDownstreamUnavailable
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		kind = ErrDownstreamTimeout
+	}
+	return &DownstreamError{Service: service, Kind: kind, Err: err}
+}
+
+// downstreamRetry is how idempotent downstream calls are retried
+type downstreamRetry struct {
+	Attempts int           // Total attempts, including the first
+	Backoff  time.Duration // Wait before the first retry, doubling after
+}
+
+// maxDownstreamRetryWait is the longest a retry waits; services asking for
+// longer with Retry-After get the error passed on instead
+const maxDownstreamRetryWait = 2 * time.Second
+
+// defaultDownstreamRetry retries a retriable failure once, shortly after
+var defaultDownstreamRetry = downstreamRetry{Attempts: 2, Backoff: 100 * time.Millisecond}
+
+// do runs call until it succeeds, fails permanently or runs out of attempts.
+// A rate limited service's Retry-After is honoured if it fits the context's
+// deadline; otherwise the error is returned right away.
+func (r downstreamRetry) do(ctx context.Context, call func() error) error {
+	backoff := r.Backoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || attempt >= r.Attempts || !IsRetriable(err) {
+			return err
+		}
+
+		wait := backoff
+		var downstream *DownstreamError
+		if errors.As(err, &downstream) && downstream.RetryAfter > wait {
+			wait = downstream.RetryAfter
+		}
+		if wait > maxDownstreamRetryWait {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusError(t *testing.T) {
+	for status, kind := range map[int]error{
+		http.StatusBadRequest:          ErrDownstreamBadRequest,
+		http.StatusUnprocessableEntity: ErrDownstreamBadRequest,
+		http.StatusRequestTimeout:      ErrDownstreamTimeout,
+		http.StatusGatewayTimeout:      ErrDownstreamTimeout,
+		http.StatusInternalServerError: ErrDownstreamUnavailable,
+		http.StatusServiceUnavailable:  ErrDownstreamUnavailable,
+		http.StatusTooManyRequests:     ErrDownstreamRateLimited,
+	} {
+		resp := &http.Response{StatusCode: status, Header: http.Header{"Retry-After": {"3"}}}
+		err := statusError("intent classifier", resp, []byte("nope"))
+		assert.ErrorIs(t, err, kind, "status %d", status)
+		assert.Equal(t, kind != ErrDownstreamBadRequest, IsRetriable(err), "status %d", status)
+	}
+
+	err := statusError("intent classifier", &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}}}, nil)
+	assert.Equal(t, 3*time.Second, err.RetryAfter)
+	assert.Equal(t, "intent classifier returned status 429", err.Error())
+
+	assert.ErrorIs(t, transportError("selector", context.DeadlineExceeded), ErrDownstreamTimeout)
+	assert.ErrorIs(t, transportError("selector", errors.New("connection refused")), ErrDownstreamUnavailable)
+	assert.Equal(t, context.Canceled, transportError("selector", context.Canceled), "a caller going away isn't a downstream failure")
+	assert.False(t, IsRetriable(errors.New("anything else")))
+}
+
+func TestClientsRetryTransientFailures(t *testing.T) {
+	// serve answers the first failures requests with status, then with body
+	serve := func(failures int32, status int, body string) (*httptest.Server, *atomic.Int32) {
+		var calls atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		return server, &calls
+	}
+	ctx := context.Background()
+
+	t.Run("classification is retried once", func(t *testing.T) {
+		server, calls := serve(1, http.StatusServiceUnavailable, `{"intent":"reasoning","confidence":0.8,"complexity":"simple"}`)
+		client := &IntentClassifierClient{baseURL: server.URL, client: server.Client()}
+
+		result, err := client.ClassifyIntent(ctx, "why")
+		require.NoError(t, err)
+		assert.Equal(t, "reasoning", result.Intent)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("rejected selections are not retried", func(t *testing.T) {
+		server, calls := serve(5, http.StatusBadRequest, `{}`)
+		logger := logrus.New()
+		logger.SetLevel(logrus.PanicLevel)
+		client := &TechniqueSelectorClient{baseURL: server.URL, client: server.Client(), logger: logger}
+
+		_, err := client.SelectTechniques(ctx, models.TechniqueSelectionRequest{Text: "why"})
+		assert.ErrorIs(t, err, ErrDownstreamBadRequest)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+
+	t.Run("generation is never retried", func(t *testing.T) {
+		server, calls := serve(1, http.StatusServiceUnavailable, `{"text":"enhanced"}`)
+		client := &PromptGeneratorClient{baseURL: server.URL, client: server.Client()}
+
+		_, err := client.GeneratePrompt(ctx, models.PromptGenerationRequest{Text: "why"})
+		assert.ErrorIs(t, err, ErrDownstreamUnavailable)
+		assert.Equal(t, int32(1), calls.Load())
+	})
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// defaultDrainMinDuration gives load balancers a few health check intervals
+// to notice the gateway went not-ready before it stops
+const defaultDrainMinDuration = 15 * time.Second
+
+var (
+	gatewayDraining = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_draining",
+		Help: "1 while the gateway is draining connections ahead of a shutdown",
+	})
+	gatewayInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_in_flight_requests",
+		Help: "Number of requests the gateway is serving",
+	})
+	gatewayDrainedRequests = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "gateway_drained_requests_total",
+		Help: "Number of requests received while the gateway was draining",
+	})
+)
+
+// DrainStatus reports the gateway's drain state
+type DrainStatus struct {
+	Draining    bool       `json:"draining"`
+	Reason      string     `json:"reason,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	MinDuration string     `json:"min_duration"`
+	InFlight    int64      `json:"in_flight"`
+	// DrainedRequests counts requests received since draining started
+	DrainedRequests int64 `json:"drained_requests"`
+	// ReadyToStop is set once the minimum drain duration has passed and no
+	// requests are in flight
+	ReadyToStop bool `json:"ready_to_stop"`
+}
+
+// Drainer takes the gateway out of load balancer rotation during rolling
+// deploys. While draining, readiness fails but requests are still served,
+// so traffic moves to other instances without dropping any.
+type Drainer struct {
+	minDuration time.Duration
+
+	mu        sync.Mutex
+	startedAt time.Time // Zero when not draining
+	reason    string
+
+	inFlight atomic.Int64
+	drained  atomic.Int64
+}
+
+// NewDrainer creates a drainer that won't report ready to stop until
+// minDuration after draining starts
+func NewDrainer(minDuration time.Duration) *Drainer {
+	return &Drainer{minDuration: minDuration}
+}
+
+// LoadDrainMinDuration reads DRAIN_MIN_DURATION, falling back to 15s when
+// unset or invalid
+func LoadDrainMinDuration() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("DRAIN_MIN_DURATION")); err == nil && d >= 0 {
+		return d
+	}
+	return defaultDrainMinDuration
+}
+
+// Start begins draining, returning false when already draining
+func (d *Drainer) Start(reason string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.startedAt.IsZero() {
+		return false
+	}
+	d.startedAt = time.Now()
+	d.reason = reason
+	d.drained.Store(0)
+	gatewayDraining.Set(1)
+	return true
+}
+
+// Cancel puts the gateway back in rotation, returning false when it wasn't
+// draining
+func (d *Drainer) Cancel() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.startedAt.IsZero() {
+		return false
+	}
+	d.startedAt = time.Time{}
+	d.reason = ""
+	gatewayDraining.Set(0)
+	return true
+}
+
+// Draining reports whether the gateway is draining
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return !d.startedAt.IsZero()
+}
+
+// Begin tracks a request until the returned func is called
+func (d *Drainer) Begin() func() {
+	d.inFlight.Add(1)
+	gatewayInFlight.Inc()
+	if d.Draining() {
+		d.drained.Add(1)
+		gatewayDrainedRequests.Inc()
+	}
+	return func() {
+		d.inFlight.Add(-1)
+		gatewayInFlight.Dec()
+	}
+}
+
+// Status returns the current drain state
+func (d *Drainer) Status() DrainStatus {
+	d.mu.Lock()
+	startedAt, reason := d.startedAt, d.reason
+	d.mu.Unlock()
+
+	status := DrainStatus{
+		MinDuration: d.minDuration.String(),
+		InFlight:    d.inFlight.Load(),
+	}
+	if startedAt.IsZero() {
+		return status
+	}
+	status.Draining = true
+	status.Reason = reason
+	status.StartedAt = &startedAt
+	status.DrainedRequests = d.drained.Load()
+	status.ReadyToStop = time.Since(startedAt) >= d.minDuration && status.InFlight == 0
+	return status
+}
+
+// Wait blocks until the minimum drain duration has passed since draining
+// started, or ctx is done. It returns at once when not draining.
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	startedAt := d.startedAt
+	d.mu.Unlock()
+	if startedAt.IsZero() {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Until(startedAt.Add(d.minDuration)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
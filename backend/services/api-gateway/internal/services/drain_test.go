@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainer(t *testing.T) {
+	drainer := NewDrainer(50 * time.Millisecond)
+
+	done := drainer.Begin()
+	assert.False(t, drainer.Status().Draining)
+	assert.Equal(t, int64(1), drainer.Status().InFlight)
+
+	require.True(t, drainer.Start("deploy"))
+	assert.False(t, drainer.Start("again"), "a drain already under way isn't restarted")
+	drainer.Begin()()
+
+	status := drainer.Status()
+	assert.True(t, status.Draining)
+	assert.Equal(t, "deploy", status.Reason)
+	assert.Equal(t, int64(1), status.DrainedRequests, "requests in flight before draining aren't counted")
+	assert.False(t, status.ReadyToStop)
+
+	require.NoError(t, drainer.Wait(context.Background()))
+	assert.False(t, drainer.Status().ReadyToStop, "a request is still in flight")
+	done()
+	assert.True(t, drainer.Status().ReadyToStop)
+
+	require.True(t, drainer.Cancel())
+	assert.False(t, drainer.Status().Draining)
+	assert.False(t, drainer.Cancel())
+}
+
+func TestDrainerWaitHonorsContext(t *testing.T) {
+	drainer := NewDrainer(time.Hour)
+	require.NoError(t, drainer.Wait(context.Background()), "nothing to wait for when not draining")
+
+	drainer.Start("deploy")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, drainer.Wait(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,319 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var effectivenessBatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_effectiveness_batches_total",
+	Help: "Technique effectiveness batches by outcome: applied, duplicate, failed or spilled",
+}, []string{"result"})
+
+// EffectivenessAggregatorConfig controls how technique feedback is batched
+type EffectivenessAggregatorConfig struct {
+	FlushInterval time.Duration // How long feedback accumulates before it is written
+	// LedgerRetention is how long applied batch IDs are remembered. A
+	// spilled batch replayed later than this would be counted twice.
+	LedgerRetention time.Duration
+}
+
+// LoadEffectivenessAggregatorConfig reads EFFECTIVENESS_FLUSH_INTERVAL and
+// EFFECTIVENESS_LEDGER_RETENTION_DAYS
+func LoadEffectivenessAggregatorConfig() EffectivenessAggregatorConfig {
+	config := EffectivenessAggregatorConfig{
+		FlushInterval:   10 * time.Second,
+		LedgerRetention: 30 * 24 * time.Hour,
+	}
+	if d, err := time.ParseDuration(getEnv("EFFECTIVENESS_FLUSH_INTERVAL", "")); err == nil && d > 0 {
+		config.FlushInterval = d
+	}
+	if n, err := strconv.Atoi(getEnv("EFFECTIVENESS_LEDGER_RETENTION_DAYS", "")); err == nil && n > 0 {
+		config.LedgerRetention = time.Duration(n) * 24 * time.Hour
+	}
+	return config
+}
+
+// effectivenessKey identifies one analytics.technique_effectiveness row
+type effectivenessKey struct {
+	technique, intent string
+	date              time.Time
+}
+
+// EffectivenessAggregator sums technique feedback in memory and writes it
+// to analytics.technique_effectiveness once per flush interval, so hot
+// technique and intent rows take one update per interval rather than one
+// per feedback event. Each flush is a batch with its own ID, applied
+// idempotently: a batch whose write failed is retried under the same ID,
+// and batches still unwritten at shutdown are spilled to Redis for the next
+// instance to replay with CatchUp.
+type EffectivenessAggregator struct {
+	analytics AnalyticsRepo
+	spill     effectivenessSpill // Nil without Redis; unwritten batches are then lost at shutdown
+	config    EffectivenessAggregatorConfig
+	logger    *logrus.Logger
+
+	mu      sync.Mutex
+	closed  bool
+	pending map[effectivenessKey]*EffectivenessRow
+	failed  []EffectivenessBatch // Batches to retry under their own IDs
+
+	flushMu sync.Mutex // Serializes flushes, so a batch is never written twice at once
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewEffectivenessAggregator starts an aggregator writing through db. The
+// cache may be nil.
+func NewEffectivenessAggregator(db *DatabaseService, cache *CacheService, config EffectivenessAggregatorConfig, logger *logrus.Logger) *EffectivenessAggregator {
+	var spill effectivenessSpill
+	if cache != nil {
+		spill = &redisEffectivenessSpill{cache: cache}
+	}
+	return newEffectivenessAggregator(db.repos.Analytics, spill, config, logger)
+}
+
+func newEffectivenessAggregator(analytics AnalyticsRepo, spill effectivenessSpill, config EffectivenessAggregatorConfig, logger *logrus.Logger) *EffectivenessAggregator {
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+	a := &EffectivenessAggregator{
+		analytics: analytics,
+		spill:     spill,
+		config:    config,
+		logger:    logger,
+		pending:   make(map[effectivenessKey]*EffectivenessRow),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Record adds a feedback score to today's totals. It returns false once the
+// aggregator is closed.
+func (a *EffectivenessAggregator) Record(feedback TechniqueFeedback) bool {
+	now := time.Now().UTC()
+	key := effectivenessKey{
+		technique: feedback.Technique,
+		intent:    feedback.Intent,
+		date:      time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return false
+	}
+	row, ok := a.pending[key]
+	if !ok {
+		row = &EffectivenessRow{Technique: key.technique, Intent: key.intent, Date: key.date}
+		a.pending[key] = row
+	}
+	if feedback.Score >= 4 {
+		row.Success++
+	}
+	row.Total++
+	row.ScoreSum += feedback.Score
+	return true
+}
+
+// Flush writes the totals accumulated so far as a new batch, after retrying
+// any batches that failed before. Batches that fail again are kept for the
+// next flush.
+func (a *EffectivenessAggregator) Flush(ctx context.Context) error {
+	a.flushMu.Lock()
+	defer a.flushMu.Unlock()
+
+	a.mu.Lock()
+	batches := a.failed
+	a.failed = nil
+	if len(a.pending) > 0 {
+		batch := EffectivenessBatch{ID: uuid.New().String(), Rows: make([]EffectivenessRow, 0, len(a.pending))}
+		for _, row := range a.pending {
+			batch.Rows = append(batch.Rows, *row)
+		}
+		batches = append(batches, batch)
+		a.pending = make(map[effectivenessKey]*EffectivenessRow)
+	}
+	a.mu.Unlock()
+
+	for i, batch := range batches {
+		if err := a.apply(ctx, batch); err != nil {
+			a.mu.Lock()
+			a.failed = append(batches[i:], a.failed...)
+			a.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// apply writes one batch, counting replays of an applied batch as duplicates
+func (a *EffectivenessAggregator) apply(ctx context.Context, batch EffectivenessBatch) error {
+	applied, err := a.analytics.ApplyTechniqueEffectiveness(ctx, batch)
+	if err != nil {
+		effectivenessBatches.WithLabelValues("failed").Inc()
+		return err
+	}
+	if applied {
+		effectivenessBatches.WithLabelValues("applied").Inc()
+	} else {
+		effectivenessBatches.WithLabelValues("duplicate").Inc()
+	}
+	return nil
+}
+
+// Close stops accepting feedback and writes what has accumulated. Batches
+// that still can't be written are spilled to Redis for CatchUp; an error is
+// returned only for counts that are lost.
+func (a *EffectivenessAggregator) Close(ctx context.Context) error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	a.mu.Unlock()
+
+	close(a.done)
+	<-a.stopped
+
+	flushErr := a.Flush(ctx)
+	if flushErr == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	unwritten := a.failed
+	a.failed = nil
+	a.mu.Unlock()
+	if a.spill == nil {
+		return fmt.Errorf("failed to write %d technique effectiveness batches: %w", len(unwritten), flushErr)
+	}
+
+	// The spill outlives ctx, which the failed flush may have used up
+	spillCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, batch := range unwritten {
+		data, err := json.Marshal(batch)
+		if err == nil {
+			err = a.spill.push(spillCtx, data)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to spill %d technique effectiveness batches: %w", len(unwritten)-i, err)
+		}
+		effectivenessBatches.WithLabelValues("spilled").Inc()
+	}
+	a.logger.WithError(flushErr).WithField("batches", len(unwritten)).Warn("Spilled unwritten technique effectiveness batches")
+	return nil
+}
+
+// CatchUp writes the batches spilled by instances that shut down without
+// reaching the database, and returns how many it took. Replaying a batch
+// that was in fact applied counts nothing, so CatchUp is safe to run on
+// every instance at startup.
+func (a *EffectivenessAggregator) CatchUp(ctx context.Context) (int, error) {
+	if a.spill == nil {
+		return 0, nil
+	}
+	taken := 0
+	for {
+		data, err := a.spill.pop(ctx)
+		if err != nil {
+			return taken, fmt.Errorf("failed to read spilled technique effectiveness batch: %w", err)
+		}
+		if data == nil {
+			return taken, nil
+		}
+
+		var batch EffectivenessBatch
+		if err := json.Unmarshal(data, &batch); err != nil {
+			a.logger.WithError(err).Error("Dropping unreadable technique effectiveness batch")
+			continue
+		}
+		if err := a.apply(ctx, batch); err != nil {
+			if pushErr := a.spill.push(ctx, data); pushErr != nil {
+				a.logger.WithError(pushErr).WithField("batch_id", batch.ID).Error("Failed to return technique effectiveness batch to the spill")
+			}
+			return taken, err
+		}
+		taken++
+	}
+}
+
+// PruneJob returns the daily job forgetting applied batch IDs past the
+// ledger retention
+func (a *EffectivenessAggregator) PruneJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "effectiveness_ledger_prune",
+		Interval: 24 * time.Hour,
+		Timeout:  5 * time.Minute,
+		Run: func(ctx context.Context) error {
+			retention := a.config.LedgerRetention
+			if retention <= 0 {
+				return nil
+			}
+			pruned, err := a.analytics.PruneTechniqueEffectivenessFlushes(ctx, time.Now().Add(-retention))
+			if pruned > 0 {
+				a.logger.WithField("batches", pruned).Info("Pruned technique effectiveness ledger")
+			}
+			return err
+		},
+	}
+}
+
+func (a *EffectivenessAggregator) run() {
+	defer close(a.stopped)
+
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), a.config.FlushInterval)
+			if err := a.Flush(ctx); err != nil {
+				a.logger.WithError(err).Warn("Technique effectiveness flush failed, retrying next interval")
+			}
+			cancel()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// effectivenessSpill holds serialized batches across restarts
+type effectivenessSpill interface {
+	push(ctx context.Context, data []byte) error
+	// pop removes and returns one batch, or nil when there are none
+	pop(ctx context.Context) ([]byte, error)
+}
+
+// redisEffectivenessSpill keeps spilled batches in a Redis list
+type redisEffectivenessSpill struct {
+	cache *CacheService
+}
+
+func (s *redisEffectivenessSpill) push(ctx context.Context, data []byte) error {
+	return s.cache.client.RPush(ctx, s.cache.Key("effectiveness", "spill"), data).Err()
+}
+
+func (s *redisEffectivenessSpill) pop(ctx context.Context) ([]byte, error) {
+	data, err := s.cache.client.LPop(ctx, s.cache.Key("effectiveness", "spill")).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return data, err
+}
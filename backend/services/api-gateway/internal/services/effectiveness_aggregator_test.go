@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memorySpill is an in-memory effectivenessSpill
+type memorySpill struct {
+	mu      sync.Mutex
+	batches [][]byte
+}
+
+func (s *memorySpill) push(_ context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches = append(s.batches, data)
+	return nil
+}
+
+func (s *memorySpill) pop(context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batches) == 0 {
+		return nil, nil
+	}
+	data := s.batches[0]
+	s.batches = s.batches[1:]
+	return data, nil
+}
+
+func TestLoadEffectivenessAggregatorConfig(t *testing.T) {
+	config := LoadEffectivenessAggregatorConfig()
+	assert.Equal(t, 10*time.Second, config.FlushInterval)
+	assert.Equal(t, 30*24*time.Hour, config.LedgerRetention)
+
+	t.Setenv("EFFECTIVENESS_FLUSH_INTERVAL", "1m")
+	t.Setenv("EFFECTIVENESS_LEDGER_RETENTION_DAYS", "-3")
+	config = LoadEffectivenessAggregatorConfig()
+	assert.Equal(t, time.Minute, config.FlushInterval)
+	assert.Equal(t, 30*24*time.Hour, config.LedgerRetention)
+}
+
+func TestEffectivenessAggregator(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	ctx := context.Background()
+	config := EffectivenessAggregatorConfig{FlushInterval: time.Hour}
+
+	t.Run("feedback is summed into one batch per flush", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 2 })
+		a := newEffectivenessAggregator(NewRepositories(db, DBTimeoutConfig{}, nil).Analytics, nil, config, logger)
+		defer a.Close(ctx)
+
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 5})
+		a.Record(TechniqueFeedback{Technique: "chain_of_thought", Intent: "reasoning", Score: 2})
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 3})
+		require.NoError(t, a.Flush(ctx))
+
+		require.Len(t, d.entries(), 1)
+		assert.Contains(t, d.entries()[0], "technique_effectiveness_flushes")
+		args := d.args[0]
+		assert.NotEmpty(t, args[0].Value, "the batch has an ID")
+		assert.EqualValues(t, 2, args[1].Value)
+		assert.Equal(t, `{"chain_of_thought","role_play"}`, args[2].Value)
+		assert.Equal(t, `{"reasoning","creative"}`, args[3].Value)
+		assert.Equal(t, `{0,1}`, args[4].Value)
+		assert.Equal(t, `{1,2}`, args[5].Value)
+		assert.Equal(t, `{2,4}`, args[6].Value)
+		today := time.Now().UTC().Format("2006-01-02")
+		assert.Equal(t, `{"`+today+`","`+today+`"}`, args[7].Value)
+
+		require.NoError(t, a.Flush(ctx))
+		assert.Len(t, d.entries(), 1, "nothing accumulated, nothing written")
+	})
+
+	t.Run("failed batches are retried under the same ID", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		down := true
+		d.fail = func(string) error {
+			if down {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		a := newEffectivenessAggregator(NewRepositories(db, DBTimeoutConfig{}, nil).Analytics, nil, config, logger)
+		defer a.Close(ctx)
+
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 4})
+		require.Error(t, a.Flush(ctx))
+
+		down = false
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 2})
+		require.NoError(t, a.Flush(ctx))
+
+		require.Len(t, d.args, 2, "the retry and the new batch")
+		assert.NotEqual(t, d.args[0][0].Value, d.args[1][0].Value)
+		assert.Equal(t, `{1}`, d.args[0][5].Value)
+		assert.Equal(t, `{1}`, d.args[1][5].Value, "the new batch doesn't absorb the failed one")
+	})
+
+	t.Run("replayed batches count nothing", func(t *testing.T) {
+		db, _ := newRecordingDB(t, func(string) int64 { return 0 })
+		analytics := NewRepositories(db, DBTimeoutConfig{}, nil).Analytics
+
+		applied, err := analytics.ApplyTechniqueEffectiveness(ctx, EffectivenessBatch{
+			ID:   "batch-1",
+			Rows: []EffectivenessRow{{Technique: "role_play", Intent: "creative", Date: time.Now(), Total: 1, ScoreSum: 4}},
+		})
+		require.NoError(t, err)
+		assert.False(t, applied)
+	})
+
+	t.Run("unwritten batches are spilled at close and caught up", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.fail = func(string) error { return errors.New("connection refused") }
+		spill := &memorySpill{}
+		a := newEffectivenessAggregator(NewRepositories(db, DBTimeoutConfig{}, nil).Analytics, spill, config, logger)
+
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 5})
+		require.NoError(t, a.Close(ctx))
+		assert.False(t, a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 5}))
+		require.Len(t, spill.batches, 1)
+
+		caughtUp, err := a.CatchUp(ctx)
+		require.Error(t, err)
+		assert.Zero(t, caughtUp)
+		require.Len(t, spill.batches, 1, "a batch that still fails goes back")
+
+		d.fail = nil
+		next := newEffectivenessAggregator(NewRepositories(db, DBTimeoutConfig{}, nil).Analytics, spill, config, logger)
+		defer next.Close(ctx)
+		caughtUp, err = next.CatchUp(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, caughtUp)
+		assert.Empty(t, spill.batches)
+
+		args := d.args[len(d.args)-1]
+		assert.Equal(t, d.args[0][0].Value, args[0].Value, "the replay keeps the batch ID")
+	})
+
+	t.Run("without a spill unwritten counts are reported lost", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.fail = func(string) error { return errors.New("connection refused") }
+		a := newEffectivenessAggregator(NewRepositories(db, DBTimeoutConfig{}, nil).Analytics, nil, config, logger)
+
+		a.Record(TechniqueFeedback{Technique: "role_play", Intent: "creative", Score: 5})
+		assert.Error(t, a.Close(ctx))
+	})
+}
@@ -52,6 +52,8 @@ type EmailData struct {
 	Username        string
 	AppName         string
 	AppURL          string
+	Heading         string
+	Message         string
 }
 
 // SendVerificationEmail sends an email verification message
@@ -85,6 +87,69 @@ func (s *EmailService) SendVerificationEmail(ctx context.Context, to, username,
 	return s.sendEmail(ctx, data.To, data.Subject, htmlBody)
 }
 
+// SendNoticeEmail sends a plain account notice with a heading and message
+func (s *EmailService) SendNoticeEmail(ctx context.Context, to, username, subject, heading, message string) error {
+	data := EmailData{
+		To:       to,
+		Subject:  subject,
+		Username: username,
+		AppName:  "BetterPrompts",
+		AppURL:   getEnv("APP_URL", "http://localhost:3000"),
+		Heading:  heading,
+		Message:  message,
+	}
+
+	htmlBody, err := s.renderTemplate("notice", data)
+	if err != nil {
+		return fmt.Errorf("failed to render email template: %w", err)
+	}
+
+	return s.sendEmail(ctx, data.To, data.Subject, htmlBody)
+}
+
+// SendEmailChangeConfirmation asks the owner of an address to confirm a
+// pending email change by following link
+func (s *EmailService) SendEmailChangeConfirmation(ctx context.Context, to, username, message, link string) error {
+	data := EmailData{
+		To:               to,
+		Subject:          "Confirm your BetterPrompts email change",
+		VerificationLink: link,
+		Username:         username,
+		AppName:          "BetterPrompts",
+		AppURL:           getEnv("APP_URL", "http://localhost:3000"),
+		Heading:          "Confirm Your Email Change",
+		Message:          message,
+	}
+
+	htmlBody, err := s.renderTemplate("email_change", data)
+	if err != nil {
+		return fmt.Errorf("failed to render email template: %w", err)
+	}
+
+	return s.sendEmail(ctx, data.To, data.Subject, htmlBody)
+}
+
+// SendReportEmail sends a scheduled report's headline numbers with a link
+// to download the full report
+func (s *EmailService) SendReportEmail(ctx context.Context, to, subject, message, link string) error {
+	data := EmailData{
+		To:               to,
+		Subject:          subject,
+		VerificationLink: link,
+		AppName:          "BetterPrompts",
+		AppURL:           getEnv("APP_URL", "http://localhost:3000"),
+		Heading:          subject,
+		Message:          message,
+	}
+
+	htmlBody, err := s.renderTemplate("report", data)
+	if err != nil {
+		return fmt.Errorf("failed to render email template: %w", err)
+	}
+
+	return s.sendEmail(ctx, data.To, data.Subject, htmlBody)
+}
+
 // sendEmail sends an email using SMTP
 func (s *EmailService) sendEmail(ctx context.Context, to, subject, body string) error {
 	// Build the email message
@@ -276,6 +341,85 @@ func (s *EmailService) getEmailTemplate(templateName string) string {
         </div>
     </div>
 </body>
+</html>`
+	case "notice":
+		return `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Subject}}</title>
+</head>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; line-height: 1.6; color: #333; background-color: #f5f5f5; margin: 0; padding: 0;">
+    <div style="max-width: 600px; margin: 40px auto; background: white; border-radius: 8px; overflow: hidden;">
+        <div style="background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 30px; text-align: center;">
+            <h1 style="margin: 0; font-size: 24px;">{{.AppName}}</h1>
+            <p style="margin-top: 10px; opacity: 0.9;">{{.Heading}}</p>
+        </div>
+        <div style="padding: 30px;">
+            <p>Hi {{.Username}},</p>
+            <p>{{.Message}}</p>
+        </div>
+        <div style="background-color: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d;">
+            <p>This email was sent by {{.AppName}} | <a href="{{.AppURL}}" style="color: #667eea;">Visit our website</a></p>
+        </div>
+    </div>
+</body>
+</html>`
+	case "email_change":
+		return `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Subject}}</title>
+</head>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; line-height: 1.6; color: #333; background-color: #f5f5f5; margin: 0; padding: 0;">
+    <div style="max-width: 600px; margin: 40px auto; background: white; border-radius: 8px; overflow: hidden;">
+        <div style="background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 30px; text-align: center;">
+            <h1 style="margin: 0; font-size: 24px;">{{.AppName}}</h1>
+            <p style="margin-top: 10px; opacity: 0.9;">{{.Heading}}</p>
+        </div>
+        <div style="padding: 30px;">
+            <p>Hi {{.Username}},</p>
+            <p>{{.Message}}</p>
+            <div style="text-align: center;">
+                <a href="{{.VerificationLink}}" style="display: inline-block; padding: 12px 30px; background: #667eea; color: white; text-decoration: none; border-radius: 6px; font-weight: 500; margin: 20px 0;">Confirm Email Change</a>
+            </div>
+            <p style="color: #6c757d; font-size: 14px;">The change only takes effect once both the old and new addresses have been confirmed. If you didn't request it, don't follow the link and change your password.</p>
+        </div>
+        <div style="background-color: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d;">
+            <p>This email was sent by {{.AppName}} | <a href="{{.AppURL}}" style="color: #667eea;">Visit our website</a></p>
+        </div>
+    </div>
+</body>
+</html>`
+	case "report":
+		return `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Subject}}</title>
+</head>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; line-height: 1.6; color: #333; background-color: #f5f5f5; margin: 0; padding: 0;">
+    <div style="max-width: 600px; margin: 40px auto; background: white; border-radius: 8px; overflow: hidden;">
+        <div style="background: linear-gradient(135deg, #667eea 0%, #764ba2 100%); color: white; padding: 30px; text-align: center;">
+            <h1 style="margin: 0; font-size: 24px;">{{.AppName}}</h1>
+            <p style="margin-top: 10px; opacity: 0.9;">{{.Heading}}</p>
+        </div>
+        <div style="padding: 30px;">
+            <p>{{.Message}}</p>
+            <div style="text-align: center;">
+                <a href="{{.VerificationLink}}" style="display: inline-block; padding: 12px 30px; background: #667eea; color: white; text-decoration: none; border-radius: 6px; font-weight: 500; margin: 20px 0;">Download Report</a>
+            </div>
+            <p style="color: #6c757d; font-size: 14px;">Past reports can be downloaded again from the admin reports page.</p>
+        </div>
+        <div style="background-color: #f8f9fa; padding: 20px; text-align: center; font-size: 14px; color: #6c757d;">
+            <p>This email was sent by {{.AppName}} | <a href="{{.AppURL}}" style="color: #667eea;">Visit our website</a></p>
+        </div>
+    </div>
+</body>
 </html>`
 	default:
 		return ""
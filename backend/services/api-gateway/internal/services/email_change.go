@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/auth"
+	"github.com/lib/pq"
+)
+
+// emailChangeExpiry is how long both addresses have to confirm a change
+const emailChangeExpiry = 24 * time.Hour
+
+// ErrEmailChangeNotFound is returned for unknown, expired or already used
+// confirmation tokens and when a user has no pending change
+var ErrEmailChangeNotFound = errors.New("email change not found")
+
+// EmailChange is a user's pending change of email address. It completes once
+// both the current and the new address have confirmed it.
+type EmailChange struct {
+	UserID         string     `json:"-"`
+	OldEmail       string     `json:"old_email"`
+	NewEmail       string     `json:"new_email"`
+	OldConfirmedAt *time.Time `json:"old_confirmed_at,omitempty"`
+	NewConfirmedAt *time.Time `json:"new_confirmed_at,omitempty"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Completed      bool       `json:"completed"`
+}
+
+// Confirmed reports whether both addresses have confirmed the change
+func (e *EmailChange) Confirmed() bool {
+	return e.OldConfirmedAt != nil && e.NewConfirmedAt != nil
+}
+
+// hashEmailChangeToken is how confirmation tokens are stored, so a database
+// read doesn't hand out working links
+func hashEmailChangeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RequestEmailChange starts changing the user's email to newEmail after
+// checking their password, replacing any change already pending. A
+// confirmation link is sent to both the current and the new address.
+func (s *UserService) RequestEmailChange(ctx context.Context, userID, password, newEmail string) (*EmailChange, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.VerifyPassword(password, user.PasswordHash); err != nil {
+		return nil, errors.New("current password is incorrect")
+	}
+
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if newEmail == strings.ToLower(user.Email) {
+		return nil, errors.New("new email is the same as the current email")
+	}
+	if _, err := s.GetUserByEmail(ctx, newEmail); err == nil {
+		return nil, errors.New("email already exists")
+	}
+
+	oldToken, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+	newToken, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate confirmation token: %w", err)
+	}
+
+	change := &EmailChange{
+		UserID:    user.ID,
+		OldEmail:  user.Email,
+		NewEmail:  newEmail,
+		ExpiresAt: time.Now().Add(emailChangeExpiry),
+	}
+
+	query := `
+		INSERT INTO auth.email_changes (user_id, old_email, new_email, old_token_hash, new_token_hash, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET old_email = EXCLUDED.old_email,
+			new_email = EXCLUDED.new_email,
+			old_token_hash = EXCLUDED.old_token_hash,
+			new_token_hash = EXCLUDED.new_token_hash,
+			old_confirmed_at = NULL,
+			new_confirmed_at = NULL,
+			expires_at = EXCLUDED.expires_at,
+			created_at = CURRENT_TIMESTAMP
+		RETURNING created_at`
+
+	err = s.db.DB.QueryRowContext(ctx, query,
+		change.UserID, change.OldEmail, change.NewEmail,
+		hashEmailChangeToken(oldToken), hashEmailChangeToken(newToken), change.ExpiresAt,
+	).Scan(&change.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store email change: %w", err)
+	}
+
+	if s.email != nil {
+		appURL := getEnv("APP_URL", "http://localhost:3000")
+		link := func(token string) string {
+			return fmt.Sprintf("%s/confirm-email-change?token=%s", appURL, url.QueryEscape(token))
+		}
+
+		err = s.email.SendEmailChangeConfirmation(ctx, change.OldEmail, user.Username,
+			fmt.Sprintf("We received a request to change the email address on your account to %s. Please confirm you made this request.", change.NewEmail),
+			link(oldToken))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send confirmation email: %w", err)
+		}
+
+		err = s.email.SendEmailChangeConfirmation(ctx, change.NewEmail, user.Username,
+			"Please confirm this is the new email address for your account.",
+			link(newToken))
+		if err != nil {
+			return nil, fmt.Errorf("failed to send confirmation email: %w", err)
+		}
+	}
+
+	return change, nil
+}
+
+// ConfirmEmailChange records the confirmation for whichever address token
+// was sent to. Once both are confirmed the user's email is switched and the
+// change is marked Completed.
+func (s *UserService) ConfirmEmailChange(ctx context.Context, token string) (*EmailChange, error) {
+	hash := hashEmailChangeToken(token)
+
+	query := `
+		UPDATE auth.email_changes SET
+			old_confirmed_at = CASE WHEN old_token_hash = $1 THEN COALESCE(old_confirmed_at, CURRENT_TIMESTAMP) ELSE old_confirmed_at END,
+			new_confirmed_at = CASE WHEN new_token_hash = $1 THEN COALESCE(new_confirmed_at, CURRENT_TIMESTAMP) ELSE new_confirmed_at END
+		WHERE (old_token_hash = $1 OR new_token_hash = $1) AND expires_at > CURRENT_TIMESTAMP
+		RETURNING user_id, old_email, new_email, old_confirmed_at, new_confirmed_at, expires_at, created_at`
+
+	change, err := scanEmailChange(s.db.DB.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+
+	if !change.Confirmed() {
+		return change, nil
+	}
+
+	if err := s.completeEmailChange(ctx, change); err != nil {
+		return nil, err
+	}
+	change.Completed = true
+	return change, nil
+}
+
+// completeEmailChange switches the user's email and removes the pending change
+func (s *UserService) completeEmailChange(ctx context.Context, change *EmailChange) error {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The old address has confirmed, so the new one counts as verified too
+	_, err = tx.ExecContext(ctx, `
+		UPDATE auth.users SET
+			email = $2,
+			is_verified = true,
+			updated_at = $3
+		WHERE id = $1`,
+		change.UserID, change.NewEmail, time.Now(),
+	)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("email already exists")
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.email_changes WHERE user_id = $1`, change.UserID); err != nil {
+		return fmt.Errorf("failed to clear email change: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit email change: %w", err)
+	}
+	return nil
+}
+
+// GetPendingEmailChange returns the user's unexpired pending email change
+func (s *UserService) GetPendingEmailChange(ctx context.Context, userID string) (*EmailChange, error) {
+	query := `
+		SELECT user_id, old_email, new_email, old_confirmed_at, new_confirmed_at, expires_at, created_at
+		FROM auth.email_changes
+		WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP`
+
+	change, err := scanEmailChange(s.db.DB.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEmailChangeNotFound
+		}
+		return nil, err
+	}
+	return change, nil
+}
+
+// CancelEmailChange discards the user's pending email change
+func (s *UserService) CancelEmailChange(ctx context.Context, userID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM auth.email_changes WHERE user_id = $1`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel email change: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrEmailChangeNotFound
+	}
+	return nil
+}
+
+func scanEmailChange(row rowScanner) (*EmailChange, error) {
+	var change EmailChange
+	var oldConfirmedAt, newConfirmedAt sql.NullTime
+
+	err := row.Scan(
+		&change.UserID, &change.OldEmail, &change.NewEmail,
+		&oldConfirmedAt, &newConfirmedAt, &change.ExpiresAt, &change.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan email change: %w", err)
+	}
+
+	if oldConfirmedAt.Valid {
+		change.OldConfirmedAt = &oldConfirmedAt.Time
+	}
+	if newConfirmedAt.Valid {
+		change.NewConfirmedAt = &newConfirmedAt.Time
+	}
+	return &change, nil
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashEmailChangeToken(t *testing.T) {
+	hash := hashEmailChangeToken("token")
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, hashEmailChangeToken("token"))
+	assert.NotEqual(t, hash, hashEmailChangeToken("other"))
+}
+
+func TestEmailChangeConfirmed(t *testing.T) {
+	now := time.Now()
+	change := &EmailChange{}
+	assert.False(t, change.Confirmed())
+
+	change.OldConfirmedAt = &now
+	assert.False(t, change.Confirmed())
+
+	change.NewConfirmedAt = &now
+	assert.True(t, change.Confirmed())
+}
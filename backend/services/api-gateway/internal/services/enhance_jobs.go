@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// Enhancement job states
+const (
+	EnhanceJobPending   = "pending"
+	EnhanceJobCompleted = "completed"
+	EnhanceJobFailed    = "failed"
+)
+
+// ErrEnhanceJobNotFound is returned for unknown or expired jobs
+var ErrEnhanceJobNotFound = errors.New("enhancement job not found")
+
+// EnhanceJob tracks an enhancement that finishes after its request returned
+type EnhanceJob struct {
+	ID        string          `json:"id"`
+	UserID    string          `json:"-"`
+	Status    string          `json:"status"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// storedEnhanceJob is the Redis representation, keeping the owner
+type storedEnhanceJob struct {
+	EnhanceJob
+	Owner string `json:"user_id,omitempty"`
+}
+
+// EnhanceJobStore keeps enhancement jobs in Redis until they expire
+type EnhanceJobStore struct {
+	cache *CacheService
+	ttl   time.Duration
+}
+
+// NewEnhanceJobStore creates a job store whose jobs expire an hour after
+// their last update
+func NewEnhanceJobStore(cache *CacheService) *EnhanceJobStore {
+	return &EnhanceJobStore{
+		cache: cache,
+		ttl:   1 * time.Hour,
+	}
+}
+
+// Create starts a pending job owned by userID, empty for anonymous callers
+func (s *EnhanceJobStore) Create(ctx context.Context, userID string) (*EnhanceJob, error) {
+	now := time.Now().UTC()
+	job := &EnhanceJob{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Status:    EnhanceJobPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.save(ctx, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Complete records the job's result
+func (s *EnhanceJobStore) Complete(ctx context.Context, job *EnhanceJob, result interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job result: %w", err)
+	}
+	job.Status = EnhanceJobCompleted
+	job.Result = data
+	job.UpdatedAt = time.Now().UTC()
+	return s.save(ctx, job)
+}
+
+// Fail records the client-facing reason the job failed
+func (s *EnhanceJobStore) Fail(ctx context.Context, job *EnhanceJob, reason string) error {
+	job.Status = EnhanceJobFailed
+	job.Error = reason
+	job.UpdatedAt = time.Now().UTC()
+	return s.save(ctx, job)
+}
+
+// Get returns a job by ID
+func (s *EnhanceJobStore) Get(ctx context.Context, id string) (*EnhanceJob, error) {
+	data, err := s.cache.client.Get(ctx, s.cache.Key("enhance_job", id)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrEnhanceJobNotFound
+		}
+		return nil, fmt.Errorf("failed to get enhancement job: %w", err)
+	}
+
+	var stored storedEnhanceJob
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal enhancement job: %w", err)
+	}
+	job := stored.EnhanceJob
+	job.UserID = stored.Owner
+	return &job, nil
+}
+
+func (s *EnhanceJobStore) save(ctx context.Context, job *EnhanceJob) error {
+	data, err := json.Marshal(storedEnhanceJob{EnhanceJob: *job, Owner: job.UserID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal enhancement job: %w", err)
+	}
+	if err := s.cache.client.Set(ctx, s.cache.Key("enhance_job", job.ID), data, s.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store enhancement job: %w", err)
+	}
+	return nil
+}
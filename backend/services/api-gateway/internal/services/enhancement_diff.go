@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// PreviousEnhancementTTL is how long an enhancement is remembered for
+// comparison with later enhancements of the same text
+const PreviousEnhancementTTL = 30 * 24 * time.Hour
+
+// EnhancementSnapshot is the part of an enhancement kept for comparison
+type EnhancementSnapshot struct {
+	ID           string    `json:"id,omitempty"`
+	EnhancedText string    `json:"enhanced_text"`
+	Techniques   []string  `json:"techniques"`
+	ModelVersion string    `json:"model_version,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// EnhancementDiff summarizes how an enhancement differs from a previous
+// enhancement of the same text
+type EnhancementDiff struct {
+	TechniquesAdded   []string `json:"techniques_added"`
+	TechniquesRemoved []string `json:"techniques_removed"`
+	TechniquesChanged bool     `json:"techniques_changed"`
+	ModelChanged      bool     `json:"model_changed"`
+	LengthDelta       int      `json:"length_delta"`
+	Similarity        float64  `json:"similarity"` // 0..1, word overlap of the two outputs
+}
+
+// DiffEnhancements compares the current enhancement against a previous one
+func DiffEnhancements(previous, current EnhancementSnapshot) EnhancementDiff {
+	diff := EnhancementDiff{
+		TechniquesAdded:   stringsMissingFrom(current.Techniques, previous.Techniques),
+		TechniquesRemoved: stringsMissingFrom(previous.Techniques, current.Techniques),
+		ModelChanged:      previous.ModelVersion != current.ModelVersion,
+		LengthDelta:       len([]rune(current.EnhancedText)) - len([]rune(previous.EnhancedText)),
+		Similarity:        textSimilarity(previous.EnhancedText, current.EnhancedText),
+	}
+	diff.TechniquesChanged = len(diff.TechniquesAdded) > 0 || len(diff.TechniquesRemoved) > 0
+	return diff
+}
+
+// stringsMissingFrom returns the values of a that are not in b, in order
+func stringsMissingFrom(a, b []string) []string {
+	seen := make(map[string]bool, len(b))
+	for _, s := range b {
+		seen[s] = true
+	}
+	missing := []string{}
+	for _, s := range a {
+		if !seen[s] {
+			missing = append(missing, s)
+			seen[s] = true
+		}
+	}
+	return missing
+}
+
+// textSimilarity is the Dice coefficient over case-folded word counts,
+// rounded to three decimals
+func textSimilarity(a, b string) float64 {
+	wordsA := strings.Fields(strings.ToLower(a))
+	wordsB := strings.Fields(strings.ToLower(b))
+	if len(wordsA) == 0 && len(wordsB) == 0 {
+		return 1
+	}
+
+	counts := make(map[string]int, len(wordsA))
+	for _, w := range wordsA {
+		counts[w]++
+	}
+	shared := 0
+	for _, w := range wordsB {
+		if counts[w] > 0 {
+			counts[w]--
+			shared++
+		}
+	}
+
+	score := 2 * float64(shared) / float64(len(wordsA)+len(wordsB))
+	return math.Round(score*1000) / 1000
+}
+
+// GetPreviousEnhancement returns the user's last enhancement of the text
+// with the given hash, or nil when there is none
+func (c *CacheService) GetPreviousEnhancement(ctx context.Context, userID, textHash string) (*EnhancementSnapshot, error) {
+	data, err := c.client.Get(ctx, c.Key("enhanced_previous", userID, textHash)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get previous enhancement: %w", err)
+	}
+
+	var snapshot EnhancementSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal previous enhancement: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// StorePreviousEnhancement remembers an enhancement for comparison with the
+// user's next enhancement of the same text
+func (c *CacheService) StorePreviousEnhancement(ctx context.Context, userID, textHash string, snapshot EnhancementSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal enhancement: %w", err)
+	}
+
+	if err := c.client.Set(ctx, c.Key("enhanced_previous", userID, textHash), data, PreviousEnhancementTTL).Err(); err != nil {
+		return fmt.Errorf("failed to store previous enhancement: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffEnhancements(t *testing.T) {
+	previous := EnhancementSnapshot{
+		EnhancedText: "Think step by step about the problem",
+		Techniques:   []string{"chain_of_thought", "step_by_step"},
+		ModelVersion: "v1",
+	}
+
+	t.Run("identical results", func(t *testing.T) {
+		diff := DiffEnhancements(previous, previous)
+		assert.False(t, diff.TechniquesChanged)
+		assert.False(t, diff.ModelChanged)
+		assert.Empty(t, diff.TechniquesAdded)
+		assert.Empty(t, diff.TechniquesRemoved)
+		assert.Equal(t, 0, diff.LengthDelta)
+		assert.Equal(t, 1.0, diff.Similarity)
+	})
+
+	t.Run("changed techniques and model", func(t *testing.T) {
+		current := EnhancementSnapshot{
+			EnhancedText: "Think step by step about the problem and give examples",
+			Techniques:   []string{"step_by_step", "few_shot"},
+			ModelVersion: "v2",
+		}
+
+		diff := DiffEnhancements(previous, current)
+		assert.True(t, diff.TechniquesChanged)
+		assert.True(t, diff.ModelChanged)
+		assert.Equal(t, []string{"few_shot"}, diff.TechniquesAdded)
+		assert.Equal(t, []string{"chain_of_thought"}, diff.TechniquesRemoved)
+		assert.Equal(t, len(" and give examples"), diff.LengthDelta)
+		assert.Equal(t, 0.824, diff.Similarity)
+	})
+
+	t.Run("unrelated output", func(t *testing.T) {
+		current := previous
+		current.EnhancedText = "Completely different wording"
+
+		diff := DiffEnhancements(previous, current)
+		assert.Equal(t, 0.0, diff.Similarity)
+		assert.Negative(t, diff.LengthDelta)
+	})
+}
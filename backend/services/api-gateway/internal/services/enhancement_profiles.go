@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultProfilePreference is the preferences key naming the profile used
+// when a request doesn't pick one
+const DefaultProfilePreference = "default_profile"
+
+// Limits on enhancement profiles
+const (
+	MaxEnhancementProfiles = 20
+	maxProfileTechniques   = 10
+	maxProfileToneLength   = 200
+	maxProfileModelLength  = 100
+)
+
+var (
+	// ErrProfileNotFound is returned for profile names the user doesn't have
+	ErrProfileNotFound = errors.New("enhancement profile not found")
+	// ErrProfileExists is returned when the user already has a profile by that name
+	ErrProfileExists = errors.New("an enhancement profile with this name already exists")
+	// ErrTooManyProfiles is returned when the user has MaxEnhancementProfiles
+	ErrTooManyProfiles = fmt.Errorf("at most %d enhancement profiles are allowed", MaxEnhancementProfiles)
+)
+
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// EnhancementProfile is a named bundle of enhancement settings, e.g. "work"
+// or "study", that a user applies to a request by name
+type EnhancementProfile struct {
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	PreferTechniques  []string  `json:"prefer_techniques"`
+	ExcludeTechniques []string  `json:"exclude_techniques"`
+	Tone              string    `json:"tone,omitempty"`          // Passed to generation as the "tone" context
+	TargetModel       string    `json:"target_model,omitempty"`  // Passed to generation as the "target_model" context
+	OutputFormat      string    `json:"output_format,omitempty"` // "text" or "structured"; empty leaves it to the request
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// NormalizeEnhancementProfile trims a profile's fields, lower-cases its name
+// and techniques and reports what is malformed
+func NormalizeEnhancementProfile(profile *EnhancementProfile) error {
+	profile.Name = strings.ToLower(strings.TrimSpace(profile.Name))
+	if !profileNamePattern.MatchString(profile.Name) {
+		return errors.New("name must be 1-50 lowercase letters, digits, '-' or '_'")
+	}
+
+	var err error
+	if profile.PreferTechniques, err = normalizeProfileTechniques(profile.PreferTechniques); err != nil {
+		return fmt.Errorf("prefer_techniques: %w", err)
+	}
+	if profile.ExcludeTechniques, err = normalizeProfileTechniques(profile.ExcludeTechniques); err != nil {
+		return fmt.Errorf("exclude_techniques: %w", err)
+	}
+
+	profile.Tone = strings.TrimSpace(profile.Tone)
+	if len(profile.Tone) > maxProfileToneLength {
+		return fmt.Errorf("tone must be at most %d characters", maxProfileToneLength)
+	}
+	profile.TargetModel = strings.TrimSpace(profile.TargetModel)
+	if len(profile.TargetModel) > maxProfileModelLength {
+		return fmt.Errorf("target_model must be at most %d characters", maxProfileModelLength)
+	}
+	switch profile.OutputFormat = strings.ToLower(strings.TrimSpace(profile.OutputFormat)); profile.OutputFormat {
+	case "", "text", "structured":
+	default:
+		return errors.New("output_format must be text or structured")
+	}
+	return nil
+}
+
+func normalizeProfileTechniques(values []string) ([]string, error) {
+	techniques := make([]string, 0, len(values))
+	for _, technique := range values {
+		technique = strings.ToLower(strings.TrimSpace(technique))
+		if technique == "" {
+			return nil, errors.New("techniques must not be empty strings")
+		}
+		if !slices.Contains(techniques, technique) {
+			techniques = append(techniques, technique)
+		}
+	}
+	if len(techniques) > maxProfileTechniques {
+		return nil, fmt.Errorf("at most %d techniques are allowed", maxProfileTechniques)
+	}
+	return techniques, nil
+}
+
+// EnhancementProfileService stores users' enhancement profiles
+type EnhancementProfileService struct {
+	db *DatabaseService
+}
+
+// NewEnhancementProfileService creates a new enhancement profile service
+func NewEnhancementProfileService(db *DatabaseService) *EnhancementProfileService {
+	return &EnhancementProfileService{db: db}
+}
+
+const profileColumns = `id, name, prefer_techniques, exclude_techniques, tone, target_model,
+	output_format, created_at, updated_at`
+
+// ListProfiles returns a user's profiles by name
+func (s *EnhancementProfileService) ListProfiles(ctx context.Context, userID string) ([]*EnhancementProfile, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+profileColumns+`
+		FROM prompts.enhancement_profiles
+		WHERE user_id = $1
+		ORDER BY name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enhancement profiles: %w", err)
+	}
+	defer rows.Close()
+
+	profiles := []*EnhancementProfile{}
+	for rows.Next() {
+		profile, err := scanEnhancementProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate enhancement profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// GetProfile returns one of a user's profiles by name
+func (s *EnhancementProfileService) GetProfile(ctx context.Context, userID, name string) (*EnhancementProfile, error) {
+	profile, err := scanEnhancementProfile(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+profileColumns+`
+		FROM prompts.enhancement_profiles
+		WHERE user_id = $1 AND name = $2`, userID, strings.ToLower(name)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProfileNotFound
+	}
+	return profile, err
+}
+
+// CreateProfile stores a new profile. The profile must have been normalized.
+func (s *EnhancementProfileService) CreateProfile(ctx context.Context, userID string, profile EnhancementProfile) (*EnhancementProfile, error) {
+	// Nothing is inserted, and no row returned, once the user is at the limit
+	created, err := scanEnhancementProfile(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO prompts.enhancement_profiles
+			(user_id, name, prefer_techniques, exclude_techniques, tone, target_model, output_format)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE (SELECT COUNT(*) FROM prompts.enhancement_profiles WHERE user_id = $1) < $8
+		RETURNING `+profileColumns,
+		userID, profile.Name, pq.Array(profile.PreferTechniques), pq.Array(profile.ExcludeTechniques),
+		profile.Tone, profile.TargetModel, profile.OutputFormat, MaxEnhancementProfiles,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTooManyProfiles
+	}
+	if err != nil {
+		return nil, profileWriteError(err)
+	}
+	return created, nil
+}
+
+// UpdateProfile replaces the profile called name, which may be renamed. The
+// profile must have been normalized.
+func (s *EnhancementProfileService) UpdateProfile(ctx context.Context, userID, name string, profile EnhancementProfile) (*EnhancementProfile, error) {
+	updated, err := scanEnhancementProfile(s.db.DB.QueryRowContext(ctx, `
+		UPDATE prompts.enhancement_profiles
+		SET name = $3, prefer_techniques = $4, exclude_techniques = $5, tone = $6,
+			target_model = $7, output_format = $8, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND name = $2
+		RETURNING `+profileColumns,
+		userID, strings.ToLower(name), profile.Name, pq.Array(profile.PreferTechniques), pq.Array(profile.ExcludeTechniques),
+		profile.Tone, profile.TargetModel, profile.OutputFormat,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrProfileNotFound
+	}
+	if err != nil {
+		return nil, profileWriteError(err)
+	}
+	return updated, nil
+}
+
+// DeleteProfile removes one of a user's profiles
+func (s *EnhancementProfileService) DeleteProfile(ctx context.Context, userID, name string) error {
+	result, err := s.db.DB.ExecContext(ctx,
+		`DELETE FROM prompts.enhancement_profiles WHERE user_id = $1 AND name = $2`, userID, strings.ToLower(name))
+	if err != nil {
+		return fmt.Errorf("failed to delete enhancement profile: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrProfileNotFound
+	}
+	return nil
+}
+
+// ResolveProfile returns the profile a user's request runs with: the one
+// named, or with no name the default from their preferences. It returns
+// ErrProfileNotFound for an unknown name and nil when there is no default
+// or the default no longer exists.
+func (s *EnhancementProfileService) ResolveProfile(ctx context.Context, userID, name string) (*EnhancementProfile, error) {
+	if name != "" {
+		return s.GetProfile(ctx, userID, name)
+	}
+
+	var defaultName sql.NullString
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT preferences->>'`+DefaultProfilePreference+`' FROM auth.users WHERE id = $1`, userID,
+	).Scan(&defaultName)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && defaultName.String == "") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default profile: %w", err)
+	}
+
+	profile, err := s.GetProfile(ctx, userID, defaultName.String)
+	if errors.Is(err, ErrProfileNotFound) {
+		return nil, nil
+	}
+	return profile, err
+}
+
+func profileWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrProfileExists
+	}
+	return fmt.Errorf("failed to save enhancement profile: %w", err)
+}
+
+func scanEnhancementProfile(row rowScanner) (*EnhancementProfile, error) {
+	var profile EnhancementProfile
+	err := row.Scan(
+		&profile.ID, &profile.Name, pq.Array(&profile.PreferTechniques), pq.Array(&profile.ExcludeTechniques),
+		&profile.Tone, &profile.TargetModel, &profile.OutputFormat, &profile.CreatedAt, &profile.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan enhancement profile: %w", err)
+	}
+	return &profile, nil
+}
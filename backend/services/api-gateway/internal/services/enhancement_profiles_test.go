@@ -0,0 +1,76 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEnhancementProfile(t *testing.T) {
+	profile := EnhancementProfile{Name: " Work ", PreferTechniques: []string{"Few_Shot", "few_shot"}, OutputFormat: "Structured", Tone: " concise "}
+	require.NoError(t, NormalizeEnhancementProfile(&profile))
+	assert.Equal(t, "work", profile.Name)
+	assert.Equal(t, []string{"few_shot"}, profile.PreferTechniques)
+	assert.Equal(t, []string{}, profile.ExcludeTechniques)
+	assert.Equal(t, "structured", profile.OutputFormat)
+	assert.Equal(t, "concise", profile.Tone)
+
+	assert.Error(t, NormalizeEnhancementProfile(&EnhancementProfile{Name: "my profile"}))
+	assert.Error(t, NormalizeEnhancementProfile(&EnhancementProfile{Name: "work", OutputFormat: "xml"}))
+	assert.Error(t, NormalizeEnhancementProfile(&EnhancementProfile{Name: "work", ExcludeTechniques: []string{""}}))
+	assert.Error(t, NormalizeEnhancementProfile(&EnhancementProfile{Name: "work", Tone: strings.Repeat("a", 201)}))
+}
+
+func TestResolveProfile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	profileRow := []driver.Value{"p-1", "study", []byte("{step_by_step}"), []byte("{}"), "patient", "", "structured", now, now}
+	profileColumns := []string{"id", "name", "prefer_techniques", "exclude_techniques", "tone", "target_model", "output_format", "created_at", "updated_at"}
+
+	resolver := func(defaultName interface{}, profiles ...[]driver.Value) (*EnhancementProfileService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "auth.users") {
+				return []string{"default_profile"}, [][]driver.Value{{defaultName}}
+			}
+			return profileColumns, profiles
+		}
+		return NewEnhancementProfileService(NewDatabaseService(db.DB)), d
+	}
+
+	t.Run("default from preferences", func(t *testing.T) {
+		profiles, d := resolver("study", profileRow)
+		profile, err := profiles.ResolveProfile(ctx, "user-1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "study", profile.Name)
+		assert.Equal(t, []string{"step_by_step"}, profile.PreferTechniques)
+		assert.Len(t, d.entries(), 2)
+	})
+
+	t.Run("no default", func(t *testing.T) {
+		profiles, d := resolver(nil)
+		profile, err := profiles.ResolveProfile(ctx, "user-1", "")
+		require.NoError(t, err)
+		assert.Nil(t, profile)
+		assert.Len(t, d.entries(), 1)
+	})
+
+	t.Run("deleted default is ignored", func(t *testing.T) {
+		profiles, _ := resolver("study")
+		profile, err := profiles.ResolveProfile(ctx, "user-1", "")
+		require.NoError(t, err)
+		assert.Nil(t, profile)
+	})
+
+	t.Run("unknown name", func(t *testing.T) {
+		profiles, d := resolver("study")
+		_, err := profiles.ResolveProfile(ctx, "user-1", "Play")
+		assert.ErrorIs(t, err, ErrProfileNotFound)
+		assert.Len(t, d.entries(), 1, "a named profile skips the default lookup")
+	})
+}
@@ -0,0 +1,459 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Enhancement review statuses. Approved reviews kept the original output;
+// corrected reviews replaced it.
+const (
+	EnhancementReviewPending   = "pending"
+	EnhancementReviewClaimed   = "claimed"
+	EnhancementReviewApproved  = "approved"
+	EnhancementReviewCorrected = "corrected"
+)
+
+// Reasons an enhancement is queued for review
+const (
+	ReviewReasonLowIntentConfidence = "low_intent_confidence"
+	ReviewReasonLowValidationScore  = "low_validation_score"
+)
+
+const enhancementReviewEnqueueTimeout = 5 * time.Second
+
+var (
+	// ErrEnhancementReviewNotFound is returned for unknown reviews
+	ErrEnhancementReviewNotFound = errors.New("enhancement review not found")
+
+	// ErrEnhancementReviewUnavailable is returned when a review is claimed by
+	// another reviewer or already resolved
+	ErrEnhancementReviewUnavailable = errors.New("enhancement review is claimed by another reviewer or already resolved")
+)
+
+// ReviewQueueConfig decides which enhancements need a human look
+type ReviewQueueConfig struct {
+	Enabled             bool
+	MinIntentConfidence float64
+	MinValidationScore  float64
+	ClaimTimeout        time.Duration // Claims older than this can be taken over
+}
+
+// LoadReviewQueueConfig reads REVIEW_QUEUE_ENABLED,
+// REVIEW_MIN_INTENT_CONFIDENCE, REVIEW_MIN_VALIDATION_SCORE and
+// REVIEW_CLAIM_TIMEOUT. The queue is off unless explicitly enabled.
+func LoadReviewQueueConfig() ReviewQueueConfig {
+	config := ReviewQueueConfig{
+		MinIntentConfidence: 0.6,
+		MinValidationScore:  0.5,
+		ClaimTimeout:        30 * time.Minute,
+	}
+	config.Enabled, _ = strconv.ParseBool(os.Getenv("REVIEW_QUEUE_ENABLED"))
+	if v, err := strconv.ParseFloat(os.Getenv("REVIEW_MIN_INTENT_CONFIDENCE"), 64); err == nil {
+		config.MinIntentConfidence = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("REVIEW_MIN_VALIDATION_SCORE"), 64); err == nil {
+		config.MinValidationScore = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("REVIEW_CLAIM_TIMEOUT")); err == nil && d > 0 {
+		config.ClaimTimeout = d
+	}
+	return config
+}
+
+// ReviewReasons returns why an enhancement falls below the thresholds, if it
+// does and the queue is enabled. A nil validation score means the generator
+// didn't report one.
+func (c ReviewQueueConfig) ReviewReasons(intentConfidence float64, validationScore *float64) []string {
+	if !c.Enabled {
+		return nil
+	}
+	var reasons []string
+	if intentConfidence < c.MinIntentConfidence {
+		reasons = append(reasons, ReviewReasonLowIntentConfidence)
+	}
+	if validationScore != nil && *validationScore < c.MinValidationScore {
+		reasons = append(reasons, ReviewReasonLowValidationScore)
+	}
+	return reasons
+}
+
+// GenerationValidationScore extracts the overall quality score the prompt
+// generator reports in its response metadata
+func GenerationValidationScore(metadata map[string]interface{}) *float64 {
+	metrics, ok := metadata["metrics"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	score, ok := metrics["overall_quality"].(float64)
+	if !ok {
+		return nil
+	}
+	return &score
+}
+
+// ReviewCandidate is an enhancement that fell below the review thresholds
+type ReviewCandidate struct {
+	HistoryID        string
+	UserID           string
+	Reasons          []string
+	IntentConfidence float64
+	ValidationScore  *float64
+}
+
+// EnhancementReview is an entry in the human review queue
+type EnhancementReview struct {
+	ID                  string     `json:"id"`
+	HistoryID           string     `json:"history_id"`
+	UserID              *string    `json:"user_id,omitempty"`
+	Reasons             []string   `json:"reasons"`
+	IntentConfidence    *float64   `json:"intent_confidence,omitempty"`
+	ValidationScore     *float64   `json:"validation_score,omitempty"`
+	Status              string     `json:"status"`
+	OriginalInput       string     `json:"original_input"`
+	EnhancedOutput      string     `json:"enhanced_output"`
+	Intent              string     `json:"intent,omitempty"`
+	Techniques          []string   `json:"techniques"`
+	ClaimedBy           *string    `json:"claimed_by,omitempty"`
+	ClaimedAt           *time.Time `json:"claimed_at,omitempty"`
+	CorrectedOutput     *string    `json:"corrected_output,omitempty"`
+	CorrectedIntent     *string    `json:"corrected_intent,omitempty"`
+	CorrectedTechniques []string   `json:"corrected_techniques,omitempty"`
+	ReviewerNote        *string    `json:"reviewer_note,omitempty"`
+	ResolvedBy          *string    `json:"resolved_by,omitempty"`
+	ResolvedAt          *time.Time `json:"resolved_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
+
+// EnhancementCorrection is a reviewer's fix for a queued enhancement
+type EnhancementCorrection struct {
+	EnhancedOutput string   `json:"enhanced_output" binding:"required,max=20000"`
+	Intent         string   `json:"intent" binding:"max=100"`
+	Techniques     []string `json:"techniques" binding:"max=20"`
+	Note           string   `json:"note" binding:"max=1000"`
+}
+
+// EnhancementReviewService queues low-confidence enhancements for human
+// review. Corrections are sent back to the user and labeled as training data.
+type EnhancementReviewService struct {
+	db     *DatabaseService
+	users  *UserService
+	email  *EmailService
+	config ReviewQueueConfig
+	logger *logrus.Logger
+}
+
+// NewEnhancementReviewService creates a new enhancement review service
+func NewEnhancementReviewService(db *DatabaseService, users *UserService, email *EmailService, config ReviewQueueConfig, logger *logrus.Logger) *EnhancementReviewService {
+	return &EnhancementReviewService{
+		db:     db,
+		users:  users,
+		email:  email,
+		config: config,
+		logger: logger,
+	}
+}
+
+// Config returns the thresholds the queue was created with
+func (s *EnhancementReviewService) Config() ReviewQueueConfig {
+	return s.config
+}
+
+// Enqueue adds a candidate to the queue in the background so enhance
+// requests don't wait on it. Enhancements already queued are left alone.
+func (s *EnhancementReviewService) Enqueue(candidate ReviewCandidate) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), enhancementReviewEnqueueTimeout)
+		defer cancel()
+
+		// The review references the history entry, which may still be queued
+		if err := s.db.FlushWrites(ctx); err != nil {
+			s.logger.WithError(err).WithField("history_id", candidate.HistoryID).Warn("Failed to flush queued history before review")
+		}
+
+		var userID interface{}
+		if candidate.UserID != "" {
+			userID = candidate.UserID
+		}
+
+		_, err := s.db.DB.ExecContext(ctx, `
+			INSERT INTO prompts.enhancement_reviews (history_id, user_id, reasons, intent_confidence, validation_score)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (history_id) DO NOTHING`,
+			candidate.HistoryID, userID, pq.Array(candidate.Reasons), candidate.IntentConfidence, candidate.ValidationScore,
+		)
+		if err != nil {
+			s.logger.WithError(err).WithField("history_id", candidate.HistoryID).Warn("Failed to queue enhancement for review")
+		}
+	}()
+}
+
+const enhancementReviewColumns = `
+		r.id, r.history_id, r.user_id, r.reasons, r.intent_confidence, r.validation_score, r.status,
+		h.original_input, h.enhanced_output, h.intent, h.techniques_used,
+		r.claimed_by, r.claimed_at, r.corrected_output, r.corrected_intent, r.corrected_techniques,
+		r.reviewer_note, r.resolved_by, r.resolved_at, r.created_at`
+
+// ListReviews returns reviews with the given status, oldest first. Listing
+// pending reviews includes claims that have timed out.
+func (s *EnhancementReviewService) ListReviews(ctx context.Context, status string, limit, offset int) ([]*EnhancementReview, error) {
+	query := `
+		SELECT` + enhancementReviewColumns + `
+		FROM prompts.enhancement_reviews r
+		JOIN prompts.history h ON h.id = r.history_id
+		WHERE r.status = $1
+		   OR ($1 = 'pending' AND r.status = 'claimed' AND r.claimed_at < $2)
+		ORDER BY r.created_at ASC
+		LIMIT $3 OFFSET $4`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, status, s.claimExpiry(), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enhancement reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := []*EnhancementReview{}
+	for rows.Next() {
+		review, err := scanEnhancementReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate enhancement reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// GetReview returns a review
+func (s *EnhancementReviewService) GetReview(ctx context.Context, id string) (*EnhancementReview, error) {
+	query := `
+		SELECT` + enhancementReviewColumns + `
+		FROM prompts.enhancement_reviews r
+		JOIN prompts.history h ON h.id = r.history_id
+		WHERE r.id = $1`
+
+	review, err := scanEnhancementReview(s.db.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEnhancementReviewNotFound
+	}
+	return review, err
+}
+
+// Claim assigns a review to a reviewer. Pending reviews, the reviewer's own
+// claims and claims that have timed out can be claimed.
+func (s *EnhancementReviewService) Claim(ctx context.Context, id, reviewerID string) (*EnhancementReview, error) {
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE prompts.enhancement_reviews
+		SET status = 'claimed', claimed_by = $2, claimed_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		  AND (status = 'pending'
+		       OR (status = 'claimed' AND (claimed_by = $2 OR claimed_at < $3)))`,
+		id, reviewerID, s.claimExpiry())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim enhancement review: %w", err)
+	}
+	if err := s.checkUpdated(ctx, result, id); err != nil {
+		return nil, err
+	}
+	return s.GetReview(ctx, id)
+}
+
+// Correct records the reviewer's correction on a review they have claimed.
+// The correction takes effect when the review is approved.
+func (s *EnhancementReviewService) Correct(ctx context.Context, id, reviewerID string, correction EnhancementCorrection) (*EnhancementReview, error) {
+	var techniques interface{}
+	if len(correction.Techniques) > 0 {
+		techniques = pq.Array(correction.Techniques)
+	}
+
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE prompts.enhancement_reviews
+		SET corrected_output = $3, corrected_intent = NULLIF($4, ''),
+			corrected_techniques = $5, reviewer_note = NULLIF($6, '')
+		WHERE id = $1 AND status = 'claimed' AND claimed_by = $2`,
+		id, reviewerID, correction.EnhancedOutput, correction.Intent, techniques, correction.Note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save correction: %w", err)
+	}
+	if err := s.checkUpdated(ctx, result, id); err != nil {
+		return nil, err
+	}
+	return s.GetReview(ctx, id)
+}
+
+// Approve resolves a review the reviewer has claimed. The history entry is
+// labeled a good training example, with the correction as its output if
+// there is one, and the user is told about corrections.
+func (s *EnhancementReviewService) Approve(ctx context.Context, id, reviewerID string) (*EnhancementReview, error) {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var historyID string
+	var correctedOutput, note sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		UPDATE prompts.enhancement_reviews
+		SET status = CASE WHEN corrected_output IS NULL THEN 'approved' ELSE 'corrected' END,
+			resolved_by = $2, resolved_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'claimed' AND claimed_by = $2
+		RETURNING history_id, corrected_output, reviewer_note`,
+		id, reviewerID).Scan(&historyID, &correctedOutput, &note)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.GetReview(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrEnhancementReviewUnavailable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve enhancement review: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO prompts.training_labels (history_id, label, note, corrected_output, labeled_by, labeled_at)
+		VALUES ($1, 'good', $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (history_id) DO UPDATE
+		SET label = EXCLUDED.label, note = EXCLUDED.note, corrected_output = EXCLUDED.corrected_output,
+			labeled_by = EXCLUDED.labeled_by, labeled_at = EXCLUDED.labeled_at`,
+		historyID, note, correctedOutput, reviewerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to label reviewed enhancement: %w", err)
+	}
+
+	// Users see corrections alongside the original in their history
+	if correctedOutput.Valid {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE prompts.history
+			SET metadata = COALESCE(metadata, '{}'::jsonb) || jsonb_build_object('review', jsonb_build_object(
+				'status', 'corrected',
+				'corrected_output', $2::text,
+				'reviewed_at', CURRENT_TIMESTAMP))
+			WHERE id = $1`,
+			historyID, correctedOutput.String)
+		if err != nil {
+			return nil, fmt.Errorf("failed to attach correction to history: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit review approval: %w", err)
+	}
+
+	review, err := s.GetReview(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if review.Status == EnhancementReviewCorrected && review.UserID != nil {
+		go s.notify(*review.UserID, review.HistoryID)
+	}
+	return review, nil
+}
+
+// notify emails the user that their enhancement was corrected; failures are only logged
+func (s *EnhancementReviewService) notify(userID, historyID string) {
+	if s.users == nil || s.email == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"history_id": historyID,
+	})
+
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to load user for review notification")
+		return
+	}
+
+	subject := "We improved one of your enhanced prompts"
+	message := "One of your recent prompts was reviewed by our team and the enhanced version has been improved. Open it from your history to see the updated prompt."
+	if err := s.email.SendNoticeEmail(ctx, user.Email, user.Username, subject, subject, message); err != nil {
+		logger.WithError(err).Warn("Failed to send review notification")
+	}
+}
+
+// checkUpdated tells a missing review apart from one in the wrong state
+func (s *EnhancementReviewService) checkUpdated(ctx context.Context, result sql.Result, id string) error {
+	if n, _ := result.RowsAffected(); n > 0 {
+		return nil
+	}
+	if _, err := s.GetReview(ctx, id); err != nil {
+		return err
+	}
+	return ErrEnhancementReviewUnavailable
+}
+
+func (s *EnhancementReviewService) claimExpiry() time.Time {
+	return time.Now().Add(-s.config.ClaimTimeout)
+}
+
+func scanEnhancementReview(row rowScanner) (*EnhancementReview, error) {
+	var review EnhancementReview
+	var userID, intent, claimedBy, correctedOutput, correctedIntent, note, resolvedBy sql.NullString
+	var confidence, validation sql.NullFloat64
+	var claimedAt, resolvedAt sql.NullTime
+	var reasons, techniques, correctedTechniques pq.StringArray
+
+	err := row.Scan(
+		&review.ID, &review.HistoryID, &userID, &reasons, &confidence, &validation, &review.Status,
+		&review.OriginalInput, &review.EnhancedOutput, &intent, &techniques,
+		&claimedBy, &claimedAt, &correctedOutput, &correctedIntent, &correctedTechniques,
+		&note, &resolvedBy, &resolvedAt, &review.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan enhancement review: %w", err)
+	}
+
+	review.UserID = nullStringPtr(userID)
+	review.Reasons = []string(reasons)
+	if confidence.Valid {
+		review.IntentConfidence = &confidence.Float64
+	}
+	if validation.Valid {
+		review.ValidationScore = &validation.Float64
+	}
+	review.Intent = intent.String
+	review.Techniques = []string(techniques)
+	if review.Techniques == nil {
+		review.Techniques = []string{}
+	}
+	review.ClaimedBy = nullStringPtr(claimedBy)
+	if claimedAt.Valid {
+		review.ClaimedAt = &claimedAt.Time
+	}
+	review.CorrectedOutput = nullStringPtr(correctedOutput)
+	review.CorrectedIntent = nullStringPtr(correctedIntent)
+	review.CorrectedTechniques = []string(correctedTechniques)
+	review.ReviewerNote = nullStringPtr(note)
+	review.ResolvedBy = nullStringPtr(resolvedBy)
+	if resolvedAt.Valid {
+		review.ResolvedAt = &resolvedAt.Time
+	}
+
+	return &review, nil
+}
+
+func nullStringPtr(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
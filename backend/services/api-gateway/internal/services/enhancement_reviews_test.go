@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadReviewQueueConfig(t *testing.T) {
+	config := LoadReviewQueueConfig()
+	assert.False(t, config.Enabled, "off unless enabled")
+	assert.Equal(t, 0.6, config.MinIntentConfidence)
+	assert.Equal(t, 30*time.Minute, config.ClaimTimeout)
+
+	t.Setenv("REVIEW_QUEUE_ENABLED", "true")
+	t.Setenv("REVIEW_MIN_INTENT_CONFIDENCE", "0.4")
+	t.Setenv("REVIEW_MIN_VALIDATION_SCORE", "0.7")
+	t.Setenv("REVIEW_CLAIM_TIMEOUT", "not-a-duration")
+
+	config = LoadReviewQueueConfig()
+	assert.True(t, config.Enabled)
+	assert.Equal(t, 0.4, config.MinIntentConfidence)
+	assert.Equal(t, 0.7, config.MinValidationScore)
+	assert.Equal(t, 30*time.Minute, config.ClaimTimeout)
+}
+
+func TestReviewReasons(t *testing.T) {
+	config := ReviewQueueConfig{Enabled: true, MinIntentConfidence: 0.6, MinValidationScore: 0.5}
+	low, high := 0.3, 0.9
+
+	assert.Empty(t, config.ReviewReasons(0.8, &high))
+	assert.Empty(t, config.ReviewReasons(0.8, nil), "no score reported")
+	assert.Equal(t, []string{ReviewReasonLowIntentConfidence}, config.ReviewReasons(0.5, nil))
+	assert.Equal(t, []string{ReviewReasonLowValidationScore}, config.ReviewReasons(0.8, &low))
+	assert.Equal(t, []string{ReviewReasonLowIntentConfidence, ReviewReasonLowValidationScore}, config.ReviewReasons(0.1, &low))
+
+	config.Enabled = false
+	assert.Empty(t, config.ReviewReasons(0.1, &low))
+}
+
+func TestGenerationValidationScore(t *testing.T) {
+	score := GenerationValidationScore(map[string]interface{}{
+		"metrics": map[string]interface{}{"overall_quality": 0.42},
+	})
+	if assert.NotNil(t, score) {
+		assert.Equal(t, 0.42, *score)
+	}
+
+	assert.Nil(t, GenerationValidationScore(nil))
+	assert.Nil(t, GenerationValidationScore(map[string]interface{}{"metrics": map[string]interface{}{}}))
+	assert.Nil(t, GenerationValidationScore(map[string]interface{}{"metrics": "n/a"}))
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Event payload schema versions. Consumers of webhooks and the activity
+// stream pick one per endpoint and keep getting that shape while the
+// gateway's own events change.
+//
+// Version 1 is the original shape: activity events are flat and alert
+// webhooks are {"event": ..., "alert": {...}}. Version 2 puts every event
+// in one envelope:
+//
+//	{"id", "type", "occurred_at", "instance", "user_id",
+//	 "request": {"id", "method", "path", "status"}, "data": {...},
+//	 "meta": {"schema_version": "2"}}
+const (
+	EventSchemaV1 = "1"
+	EventSchemaV2 = "2"
+
+	// DefaultEventSchemaVersion is what endpoints that never chose a
+	// version get, so existing consumers keep working
+	DefaultEventSchemaVersion = EventSchemaV1
+	// LatestEventSchemaVersion is the newest version
+	LatestEventSchemaVersion = EventSchemaV2
+)
+
+// ErrUnknownEventSchemaVersion is returned for versions the gateway doesn't
+// produce
+var ErrUnknownEventSchemaVersion = errors.New("unknown event schema version")
+
+// EventSchemaVersion describes one payload schema version
+type EventSchemaVersion struct {
+	Version    string `json:"version"`
+	Deprecated bool   `json:"deprecated"`
+	Sunset     string `json:"sunset,omitempty"` // Date (YYYY-MM-DD) after which it may stop being produced
+}
+
+// EventSchemaVersions lists the supported versions, oldest first
+var EventSchemaVersions = []EventSchemaVersion{
+	{Version: EventSchemaV1, Deprecated: true, Sunset: "2027-06-30"},
+	{Version: EventSchemaV2},
+}
+
+// EventPayload is an event rendered at some schema version, ready to be
+// sent as JSON
+type EventPayload map[string]interface{}
+
+// eventSchemaMigration converts a payload between two adjacent versions.
+// Payloads are migrated without their meta, which is stamped afterwards.
+type eventSchemaMigration struct {
+	up   func(EventPayload) EventPayload
+	down func(EventPayload) EventPayload
+}
+
+// eventSchemaMigrations[i] migrates between EventSchemaVersions[i] and
+// EventSchemaVersions[i+1]. A new version adds a version and a migration
+// to it from the one before; every older version then still renders by
+// chaining.
+var eventSchemaMigrations = []eventSchemaMigration{
+	{up: upgradeEventV1, down: downgradeEventV2},
+}
+
+// ParseEventSchemaVersion validates a version a consumer asked for, empty
+// meaning DefaultEventSchemaVersion
+func ParseEventSchemaVersion(version string) (string, error) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if version == "" {
+		return DefaultEventSchemaVersion, nil
+	}
+	if eventSchemaIndex(version) < 0 {
+		return "", fmt.Errorf("%w %q", ErrUnknownEventSchemaVersion, version)
+	}
+	return version, nil
+}
+
+// RenderActivityEvent renders an activity event at a schema version
+func RenderActivityEvent(event ActivityEvent, version string) (EventPayload, error) {
+	payload, err := toEventPayload(event)
+	if err != nil {
+		return nil, err
+	}
+	return MigrateEventPayload(payload, EventSchemaV1, version)
+}
+
+// RenderAlertEvent renders an alert webhook at a schema version
+func RenderAlertEvent(alert Alert, version string) (EventPayload, error) {
+	payload, err := toEventPayload(map[string]interface{}{
+		"event": "alert." + alert.Status,
+		"alert": alert,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MigrateEventPayload(payload, EventSchemaV1, version)
+}
+
+// MigrateEventPayload converts a payload from one schema version to
+// another, through every version in between. Consumers can use it to bring
+// payloads they stored under an older version up to date.
+func MigrateEventPayload(payload EventPayload, from, to string) (EventPayload, error) {
+	i, j := eventSchemaIndex(from), eventSchemaIndex(to)
+	if i < 0 {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEventSchemaVersion, from)
+	}
+	if j < 0 {
+		return nil, fmt.Errorf("%w %q", ErrUnknownEventSchemaVersion, to)
+	}
+
+	migrated := stripEventMeta(payload)
+	for ; i < j; i++ {
+		migrated = eventSchemaMigrations[i].up(migrated)
+	}
+	for ; i > j; i-- {
+		migrated = eventSchemaMigrations[i-1].down(migrated)
+	}
+	stampEventMeta(migrated, EventSchemaVersions[j])
+	return migrated, nil
+}
+
+func eventSchemaIndex(version string) int {
+	for i, v := range EventSchemaVersions {
+		if v.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// toEventPayload turns a struct into its JSON object form
+func toEventPayload(v interface{}) (EventPayload, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	var payload EventPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to encode event: %w", err)
+	}
+	return payload, nil
+}
+
+// eventMetaKeys are where each version keeps its meta. Version 1 had none,
+// so its meta goes under a key no version 1 field uses.
+var eventMetaKeys = map[string]string{
+	EventSchemaV1: "_meta",
+	EventSchemaV2: "meta",
+}
+
+func stripEventMeta(payload EventPayload) EventPayload {
+	stripped := make(EventPayload, len(payload))
+	for key, value := range payload {
+		if key != "_meta" && key != "meta" {
+			stripped[key] = value
+		}
+	}
+	return stripped
+}
+
+// stampEventMeta records the version in the payload, with a deprecation
+// warning for versions on their way out. Version 1 payloads only get meta
+// when there is a warning to carry.
+func stampEventMeta(payload EventPayload, version EventSchemaVersion) {
+	if version.Version == EventSchemaV1 && !version.Deprecated {
+		return
+	}
+	meta := map[string]interface{}{"schema_version": version.Version}
+	if version.Deprecated {
+		meta["deprecated"] = true
+		meta["sunset"] = version.Sunset
+		meta["warning"] = fmt.Sprintf(
+			"Event schema version %s is deprecated and may stop being sent after %s; switch to version %s",
+			version.Version, version.Sunset, LatestEventSchemaVersion)
+	}
+	payload[eventMetaKeys[version.Version]] = meta
+}
+
+// upgradeEventV1 wraps a version 1 activity event or alert webhook in the
+// version 2 envelope
+func upgradeEventV1(v1 EventPayload) EventPayload {
+	v2 := EventPayload{}
+	if alert, ok := v1["alert"].(map[string]interface{}); ok {
+		v2["type"] = v1["event"]
+		v2["occurred_at"] = alert["evaluated_at"]
+		v2["instance"] = alert["instance"]
+		v2["data"] = alert
+		return v2
+	}
+
+	for from, to := range map[string]string{"id": "id", "type": "type", "timestamp": "occurred_at", "instance": "instance", "user_id": "user_id", "data": "data"} {
+		if value, ok := v1[from]; ok {
+			v2[to] = value
+		}
+	}
+	request := map[string]interface{}{}
+	for from, to := range map[string]string{"request_id": "id", "method": "method", "path": "path", "status": "status"} {
+		if value, ok := v1[from]; ok {
+			request[to] = value
+		}
+	}
+	if len(request) > 0 {
+		v2["request"] = request
+	}
+	return v2
+}
+
+// downgradeEventV2 flattens a version 2 envelope back into the version 1
+// activity event or alert webhook
+func downgradeEventV2(v2 EventPayload) EventPayload {
+	if eventType, _ := v2["type"].(string); strings.HasPrefix(eventType, "alert.") {
+		return EventPayload{"event": eventType, "alert": v2["data"]}
+	}
+
+	v1 := EventPayload{}
+	for from, to := range map[string]string{"id": "id", "type": "type", "occurred_at": "timestamp", "instance": "instance", "user_id": "user_id", "data": "data"} {
+		if value, ok := v2[from]; ok {
+			v1[to] = value
+		}
+	}
+	if request, ok := v2["request"].(map[string]interface{}); ok {
+		for from, to := range map[string]string{"id": "request_id", "method": "method", "path": "path", "status": "status"} {
+			if value, ok := request[from]; ok {
+				v1[to] = value
+			}
+		}
+	}
+	return v1
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eventSchemaSamples are one event of each kind the gateway sends
+func eventSchemaSamples() map[string]func(version string) (EventPayload, error) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	return map[string]func(string) (EventPayload, error){
+		"activity": func(version string) (EventPayload, error) {
+			return RenderActivityEvent(ActivityEvent{
+				ID: "evt-1", Type: ActivityEnhancement, UserID: "user-1", RequestID: "req-1",
+				Method: "POST", Path: "/api/v1/enhance", Status: 200,
+				Data: map[string]interface{}{"intent": "code_generation"}, Instance: "gw-1", Timestamp: at,
+			}, version)
+		},
+		"system activity": func(version string) (EventPayload, error) {
+			return RenderActivityEvent(ActivityEvent{
+				ID: "evt-2", Type: ActivityIntentDrift, Instance: "gw-1", Timestamp: at,
+			}, version)
+		},
+		"alert": func(version string) (EventPayload, error) {
+			return RenderAlertEvent(Alert{
+				Rule: "error_rate>0.05", Metric: "error_rate", Status: AlertStatusFiring,
+				Value: 0.2, Instance: "gw-1", FiredAt: at, EvaluatedAt: at,
+			}, version)
+		},
+	}
+}
+
+// roundTrip encodes a payload the way it is sent and decodes it the way a
+// consumer would
+func roundTrip(t *testing.T, payload EventPayload) EventPayload {
+	data, err := json.Marshal(payload)
+	require.NoError(t, err)
+	var decoded EventPayload
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	return decoded
+}
+
+// TestEventSchemaMatrix checks every supported version converts to every
+// other one: migrating a payload gives what the gateway renders at the
+// target version, and migrating back gives the payload again
+func TestEventSchemaMatrix(t *testing.T) {
+	for kind, render := range eventSchemaSamples() {
+		for _, from := range EventSchemaVersions {
+			for _, to := range EventSchemaVersions {
+				t.Run(kind+"/"+from.Version+"->"+to.Version, func(t *testing.T) {
+					source, err := render(from.Version)
+					require.NoError(t, err)
+					source = roundTrip(t, source)
+
+					migrated, err := MigrateEventPayload(source, from.Version, to.Version)
+					require.NoError(t, err)
+					expected, err := render(to.Version)
+					require.NoError(t, err)
+					expected = roundTrip(t, expected)
+					assert.Equal(t, expected, roundTrip(t, migrated))
+
+					back, err := MigrateEventPayload(migrated, to.Version, from.Version)
+					require.NoError(t, err)
+					assert.Equal(t, source, roundTrip(t, back))
+				})
+			}
+		}
+	}
+}
+
+func TestEventSchemaShapes(t *testing.T) {
+	samples := eventSchemaSamples()
+
+	v1, err := samples["activity"](EventSchemaV1)
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", v1["request_id"], "version 1 stays flat")
+	meta := v1["_meta"].(map[string]interface{})
+	assert.Equal(t, true, meta["deprecated"])
+	assert.Contains(t, meta["warning"], "switch to version 2")
+
+	v2, err := samples["activity"](EventSchemaV2)
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", v2["request"].(map[string]interface{})["id"])
+	assert.Equal(t, map[string]interface{}{"schema_version": "2"}, v2["meta"])
+	assert.NotContains(t, v2, "request_id")
+
+	alert, err := samples["alert"](EventSchemaV1)
+	require.NoError(t, err)
+	assert.Equal(t, "alert.firing", alert["event"], "alert webhooks keep their version 1 shape")
+
+	alert, err = samples["alert"](EventSchemaV2)
+	require.NoError(t, err)
+	assert.Equal(t, "alert.firing", alert["type"])
+	assert.Equal(t, "gw-1", alert["instance"])
+}
+
+func TestParseEventSchemaVersion(t *testing.T) {
+	for input, expected := range map[string]string{"": DefaultEventSchemaVersion, "2": EventSchemaV2, "v1": EventSchemaV1} {
+		version, err := ParseEventSchemaVersion(input)
+		require.NoError(t, err)
+		assert.Equal(t, expected, version)
+	}
+	_, err := ParseEventSchemaVersion("3")
+	assert.ErrorIs(t, err, ErrUnknownEventSchemaVersion)
+}
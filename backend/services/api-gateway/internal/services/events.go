@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Activity event types published on the event bus
+const (
+	ActivityAlert       = "alert"
+	ActivityEnhancement = "enhancement"
+	ActivityError       = "error"
+	ActivityIntentDrift = "intent_drift"
+	ActivityLogin       = "login"
+	ActivityLoginFailed = "login_failed"
+	ActivityRateLimit   = "rate_limit"
+)
+
+// ActivityEventTypes lists every event type, for validating subscriber filters
+var ActivityEventTypes = []string{
+	ActivityAlert,
+	ActivityEnhancement,
+	ActivityError,
+	ActivityIntentDrift,
+	ActivityLogin,
+	ActivityLoginFailed,
+	ActivityRateLimit,
+}
+
+const (
+	recentActivityEvents = 200
+	activityRedisQueue   = 1000
+)
+
+// ActivityEvent is a notable thing that happened while serving a request
+type ActivityEvent struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	UserID    string                 `json:"user_id,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Method    string                 `json:"method,omitempty"`
+	Path      string                 `json:"path,omitempty"`
+	Status    int                    `json:"status,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Instance  string                 `json:"instance"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// EventFilter selects events for a subscriber. Empty fields match everything.
+type EventFilter struct {
+	Types  map[string]bool
+	UserID string
+}
+
+// Match reports whether an event passes the filter
+func (f EventFilter) Match(event ActivityEvent) bool {
+	if len(f.Types) > 0 && !f.Types[event.Type] {
+		return false
+	}
+	return f.UserID == "" || f.UserID == event.UserID
+}
+
+type eventSubscriber struct {
+	filter  EventFilter
+	events  chan ActivityEvent
+	dropped uint64
+}
+
+// EventBus fans activity events out to in-process subscribers and keeps a
+// short history for late joiners. With BridgeRedis, events are also shared
+// with the other gateway instances over Redis pub/sub.
+type EventBus struct {
+	logger   *logrus.Logger
+	instance string
+
+	mu          sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+	recent      []ActivityEvent
+	next        int
+
+	outbound chan ActivityEvent
+}
+
+// NewEventBus creates an in-process event bus
+func NewEventBus(logger *logrus.Logger) *EventBus {
+	instance, _ := os.Hostname()
+	if instance == "" {
+		instance = uuid.New().String()[:8]
+	}
+
+	return &EventBus{
+		logger:      logger,
+		instance:    instance,
+		subscribers: make(map[*eventSubscriber]struct{}),
+		recent:      make([]ActivityEvent, 0, recentActivityEvents),
+	}
+}
+
+// Publish records an event and delivers it to matching subscribers. It
+// never blocks: subscribers that fall behind miss events.
+func (b *EventBus) Publish(event ActivityEvent) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if event.Instance == "" {
+		event.Instance = b.instance
+	}
+
+	b.deliver(event)
+
+	if b.outbound != nil && event.Instance == b.instance {
+		select {
+		case b.outbound <- event:
+		default:
+		}
+	}
+}
+
+func (b *EventBus) deliver(event ActivityEvent) {
+	b.mu.Lock()
+	if len(b.recent) < recentActivityEvents {
+		b.recent = append(b.recent, event)
+	} else {
+		b.recent[b.next] = event
+	}
+	b.next = (b.next + 1) % recentActivityEvents
+	b.mu.Unlock()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.filter.Match(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Subscribe returns a channel of events matching filter and a function that
+// ends the subscription. buffer bounds how far a subscriber may fall behind.
+func (b *EventBus) Subscribe(filter EventFilter, buffer int) (<-chan ActivityEvent, func()) {
+	sub := &eventSubscriber{
+		filter: filter,
+		events: make(chan ActivityEvent, buffer),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+
+			if dropped := atomic.LoadUint64(&sub.dropped); dropped > 0 {
+				b.logger.WithField("dropped", dropped).Debug("Activity subscriber fell behind")
+			}
+		})
+	}
+}
+
+// Recent returns up to limit of the most recent events matching filter, oldest first
+func (b *EventBus) Recent(filter EventFilter, limit int) []ActivityEvent {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	ordered := make([]ActivityEvent, 0, len(b.recent))
+	if len(b.recent) == recentActivityEvents {
+		ordered = append(ordered, b.recent[b.next:]...)
+		ordered = append(ordered, b.recent[:b.next]...)
+	} else {
+		ordered = append(ordered, b.recent...)
+	}
+
+	matched := make([]ActivityEvent, 0, limit)
+	for i := len(ordered) - 1; i >= 0 && len(matched) < limit; i-- {
+		if filter.Match(ordered[i]) {
+			matched = append(matched, ordered[i])
+		}
+	}
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// BridgeRedis shares events with other gateway instances through a Redis
+// pub/sub channel until ctx is cancelled. Call it once at startup.
+func (b *EventBus) BridgeRedis(ctx context.Context, cache *CacheService) {
+	channel := cache.Key("events", "activity")
+	b.outbound = make(chan ActivityEvent, activityRedisQueue)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-b.outbound:
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				if err := cache.client.Publish(ctx, channel, data).Err(); err != nil {
+					b.logger.WithError(err).Debug("Failed to publish activity event")
+				}
+			}
+		}
+	}()
+
+	go func() {
+		pubsub := cache.client.Subscribe(ctx, channel)
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				var event ActivityEvent
+				if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+					continue
+				}
+				if event.Instance != b.instance {
+					b.deliver(event)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBus(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("delivers matching events to subscribers", func(t *testing.T) {
+		bus := NewEventBus(logger)
+		events, unsubscribe := bus.Subscribe(EventFilter{
+			Types:  map[string]bool{ActivityLogin: true},
+			UserID: "user-1",
+		}, 10)
+		defer unsubscribe()
+
+		bus.Publish(ActivityEvent{Type: ActivityLogin, UserID: "user-2"})
+		bus.Publish(ActivityEvent{Type: ActivityError, UserID: "user-1"})
+		bus.Publish(ActivityEvent{Type: ActivityLogin, UserID: "user-1"})
+
+		select {
+		case event := <-events:
+			assert.Equal(t, ActivityLogin, event.Type)
+			assert.Equal(t, "user-1", event.UserID)
+			assert.NotEmpty(t, event.ID)
+			assert.NotEmpty(t, event.Instance)
+			assert.False(t, event.Timestamp.IsZero())
+		case <-time.After(time.Second):
+			t.Fatal("expected an event")
+		}
+		assert.Empty(t, events)
+	})
+
+	t.Run("slow subscribers never block publishers", func(t *testing.T) {
+		bus := NewEventBus(logger)
+		_, unsubscribe := bus.Subscribe(EventFilter{}, 1)
+		defer unsubscribe()
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				bus.Publish(ActivityEvent{Type: ActivityEnhancement})
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("publish blocked on a full subscriber")
+		}
+	})
+
+	t.Run("unsubscribed channels stop receiving", func(t *testing.T) {
+		bus := NewEventBus(logger)
+		events, unsubscribe := bus.Subscribe(EventFilter{}, 10)
+		unsubscribe()
+		unsubscribe()
+
+		bus.Publish(ActivityEvent{Type: ActivityEnhancement})
+		assert.Empty(t, events)
+	})
+}
+
+func TestEventBusRecent(t *testing.T) {
+	logger := logrus.New()
+	bus := NewEventBus(logger)
+
+	for i := 0; i < recentActivityEvents+5; i++ {
+		eventType := ActivityEnhancement
+		if i%2 == 0 {
+			eventType = ActivityRateLimit
+		}
+		bus.Publish(ActivityEvent{Type: eventType, Data: map[string]interface{}{"n": i}})
+	}
+
+	recent := bus.Recent(EventFilter{}, 3)
+	require.Len(t, recent, 3)
+	assert.Equal(t, recentActivityEvents+2, recent[0].Data["n"])
+	assert.Equal(t, recentActivityEvents+4, recent[2].Data["n"])
+
+	limited := bus.Recent(EventFilter{Types: map[string]bool{ActivityEnhancement: true}}, 2)
+	require.Len(t, limited, 2)
+	assert.Equal(t, recentActivityEvents+1, limited[0].Data["n"])
+	assert.Equal(t, recentActivityEvents+3, limited[1].Data["n"])
+
+	assert.Len(t, bus.Recent(EventFilter{}, 1000), recentActivityEvents)
+}
@@ -0,0 +1,18 @@
+package services
+
+// ExplanationStylePreference is the preferences key choosing how enhancement
+// explanations are worded
+const ExplanationStylePreference = "explanation_style"
+
+// Explanation styles. Plain language, the default, explains enhancements
+// without prompt-engineering or scoring vocabulary; technical shows the
+// selector's own reasoning.
+const (
+	ExplanationStylePlain     = "plain"
+	ExplanationStyleTechnical = "technical"
+)
+
+// ValidExplanationStyle reports whether style is an explanation style
+func ValidExplanationStyle(style string) bool {
+	return style == ExplanationStylePlain || style == ExplanationStyleTechnical
+}
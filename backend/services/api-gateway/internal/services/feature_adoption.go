@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Features whose adoption is tracked. Changelog entries may only name these.
+const (
+	FeatureQuickEnhance = "quick_enhance" // Browser extension enhancements
+	FeatureProfiles     = "profiles"      // Saved enhancement profiles
+	FeatureRerun        = "rerun"         // Rerunning a history item
+	FeaturePromptExport = "prompt_export" // Exporting a history item
+	FeatureAPIKeys      = "api_keys"      // Developer API keys
+)
+
+// TrackedFeatures lists every tracked feature in report order
+var TrackedFeatures = []string{
+	FeatureQuickEnhance,
+	FeatureProfiles,
+	FeatureRerun,
+	FeaturePromptExport,
+	FeatureAPIKeys,
+}
+
+const (
+	// featureTrackTimeout bounds the background insert of a first use
+	featureTrackTimeout = 5 * time.Second
+	// maxSeenFeatureUses bounds the in-memory record of uses already stored
+	// before it is cleared
+	maxSeenFeatureUses = 100000
+)
+
+// FeatureAdoption summarizes who has used one feature
+type FeatureAdoption struct {
+	Feature string `json:"feature"`
+	// LaunchedAt is when the first published changelog entry naming the
+	// feature went out, if any did
+	LaunchedAt *time.Time `json:"launched_at,omitempty"`
+	// Adopters is every user who has ever used the feature
+	Adopters int `json:"adopters"`
+	// NewAdopters used it for the first time in the period
+	NewAdopters int `json:"new_adopters"`
+	// AdoptionRate is the share of the period's active users who have used
+	// the feature
+	AdoptionRate float64 `json:"adoption_rate"`
+}
+
+// FeatureAdoptionReport is the adoption of every tracked feature over a period
+type FeatureAdoptionReport struct {
+	Since       time.Time          `json:"since"`
+	ActiveUsers int                `json:"active_users"`
+	Features    []*FeatureAdoption `json:"features"`
+}
+
+// FeatureAdoptionService records the first time each user uses a tracked
+// feature
+type FeatureAdoptionService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+
+	mu   sync.Mutex
+	seen map[string]struct{} // user_id/feature pairs already stored
+}
+
+// NewFeatureAdoptionService creates a new feature adoption service
+func NewFeatureAdoptionService(db *DatabaseService, logger *logrus.Logger) *FeatureAdoptionService {
+	return &FeatureAdoptionService{
+		db:     db,
+		logger: logger,
+		seen:   make(map[string]struct{}),
+	}
+}
+
+// RecordUse records in the background that a user used a feature. Only the
+// first use is kept, and uses this instance already stored skip the database.
+func (s *FeatureAdoptionService) RecordUse(userID, feature string) {
+	if userID == "" {
+		return
+	}
+	key := userID + "/" + feature
+
+	s.mu.Lock()
+	if _, ok := s.seen[key]; ok {
+		s.mu.Unlock()
+		return
+	}
+	if len(s.seen) >= maxSeenFeatureUses {
+		s.seen = make(map[string]struct{})
+	}
+	s.seen[key] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), featureTrackTimeout)
+		defer cancel()
+
+		_, err := s.db.DB.ExecContext(ctx, `
+			INSERT INTO analytics.feature_adoption (user_id, feature)
+			VALUES ($1, $2)
+			ON CONFLICT DO NOTHING`, userID, feature)
+		if err != nil {
+			s.logger.WithError(err).WithField("feature", feature).Warn("Failed to record feature use")
+			s.mu.Lock()
+			delete(s.seen, key)
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// Report summarizes the adoption of every tracked feature, counting users
+// with an enhancement since the given time as active
+func (s *FeatureAdoptionService) Report(ctx context.Context, since time.Time) (*FeatureAdoptionReport, error) {
+	report := &FeatureAdoptionReport{Since: since, Features: []*FeatureAdoption{}}
+	byFeature := make(map[string]*FeatureAdoption, len(TrackedFeatures))
+	for _, feature := range TrackedFeatures {
+		adoption := &FeatureAdoption{Feature: feature}
+		byFeature[feature] = adoption
+		report.Features = append(report.Features, adoption)
+	}
+
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT user_id)
+		FROM prompts.history
+		WHERE created_at >= $1 AND user_id IS NOT NULL`, since).Scan(&report.ActiveUsers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count active users: %w", err)
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		WITH active AS (
+			SELECT DISTINCT user_id FROM prompts.history
+			WHERE created_at >= $1 AND user_id IS NOT NULL
+		)
+		SELECT f.feature,
+			   COUNT(*),
+			   COUNT(*) FILTER (WHERE f.first_used_at >= $1),
+			   COUNT(a.user_id)
+		FROM analytics.feature_adoption f
+		LEFT JOIN active a ON a.user_id = f.user_id
+		GROUP BY f.feature`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feature adoption: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feature string
+		var adopters, newAdopters, activeAdopters int
+		if err := rows.Scan(&feature, &adopters, &newAdopters, &activeAdopters); err != nil {
+			return nil, fmt.Errorf("failed to scan feature adoption: %w", err)
+		}
+		adoption, ok := byFeature[feature]
+		if !ok {
+			continue // No longer tracked
+		}
+		adoption.Adopters = adopters
+		adoption.NewAdopters = newAdopters
+		if report.ActiveUsers > 0 {
+			adoption.AdoptionRate = float64(activeAdopters) / float64(report.ActiveUsers)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate feature adoption: %w", err)
+	}
+
+	launches, err := s.db.DB.QueryContext(ctx, `
+		SELECT feature, MIN(published_at)
+		FROM messaging.changelog_entries, unnest(features) AS feature
+		WHERE published_at IS NOT NULL AND published_at <= CURRENT_TIMESTAMP
+		GROUP BY feature`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feature launches: %w", err)
+	}
+	defer launches.Close()
+
+	for launches.Next() {
+		var feature string
+		var launchedAt time.Time
+		if err := launches.Scan(&feature, &launchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan feature launch: %w", err)
+		}
+		if adoption, ok := byFeature[feature]; ok {
+			adoption.LaunchedAt = &launchedAt
+		}
+	}
+	if err := launches.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate feature launches: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFeatureUse(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	adoption := NewFeatureAdoptionService(NewDatabaseService(db.DB), logger)
+
+	adoption.RecordUse("user-1", FeatureRerun)
+	require.Eventually(t, func() bool { return len(d.entries()) == 1 }, time.Second, 10*time.Millisecond)
+	adoption.RecordUse("user-1", FeatureRerun)
+	adoption.RecordUse("", FeatureRerun)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, d.entries(), 1, "later uses don't reach the database")
+
+	d.fail = func(string) error { return errors.New("connection reset") }
+	adoption.RecordUse("user-2", FeatureRerun)
+	require.Eventually(t, func() bool {
+		adoption.mu.Lock()
+		defer adoption.mu.Unlock()
+		_, seen := adoption.seen["user-2/"+FeatureRerun]
+		return !seen
+	}, time.Second, 10*time.Millisecond, "failed inserts are retried on the next use")
+}
+
+func TestFeatureAdoptionReport(t *testing.T) {
+	launched := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "changelog_entries"):
+			return []string{"feature", "min"}, [][]driver.Value{{FeatureProfiles, launched}}
+		case strings.Contains(query, "feature_adoption"):
+			return []string{"feature", "adopters", "new_adopters", "active_adopters"}, [][]driver.Value{
+				{FeatureProfiles, int64(40), int64(10), int64(20)},
+				{"retired", int64(5), int64(0), int64(0)},
+			}
+		default:
+			return []string{"count"}, [][]driver.Value{{int64(80)}}
+		}
+	}
+	adoption := NewFeatureAdoptionService(NewDatabaseService(db.DB), logrus.New())
+
+	report, err := adoption.Report(context.Background(), launched)
+	require.NoError(t, err)
+	assert.Equal(t, 80, report.ActiveUsers)
+	require.Len(t, report.Features, len(TrackedFeatures), "untracked features are left out")
+
+	for _, feature := range report.Features {
+		if feature.Feature != FeatureProfiles {
+			assert.Zero(t, feature.Adopters, feature.Feature)
+			assert.Nil(t, feature.LaunchedAt, feature.Feature)
+			continue
+		}
+		assert.Equal(t, 40, feature.Adopters)
+		assert.Equal(t, 10, feature.NewAdopters)
+		assert.InDelta(t, 0.25, feature.AdoptionRate, 1e-9)
+		require.NotNil(t, feature.LaunchedAt)
+		assert.True(t, launched.Equal(*feature.LaunchedAt))
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons feedback is filtered. Filtered feedback is still stored, marked
+// with its reasons, and left out of effectiveness analytics.
+const (
+	FeedbackFilterLowRatingBurst = "low_rating_burst"  // Many 1-star ratings from one user in a short time
+	FeedbackFilterRapid          = "rapid_submissions" // More submissions than a person could make
+	FeedbackFilterRepeatedText   = "repeated_text"     // The same comment pasted across items
+	FeedbackFilterInstant        = "instant_rating"    // Rated before the result could have been read
+)
+
+var feedbackFiltered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_feedback_filtered_total",
+	Help: "Feedback submissions filtered as spam, by reason",
+}, []string{"reason"})
+
+// FeedbackFilterConfig holds the thresholds separating genuine feedback
+// from spam
+type FeedbackFilterConfig struct {
+	PerItemLimit   int           // Submissions a user may make per history item per PerItemWindow
+	PerItemWindow  time.Duration // Window of the per-item limit
+	LowRatingBurst int           // 1-star ratings from one user within BurstWindow that mark them as spamming
+	RapidBurst     int           // Submissions from one user within BurstWindow that mark a bot
+	RepeatedText   int           // Identical comments from one user within BurstWindow that mark spam
+	BurstWindow    time.Duration // Window of the spam checks
+	MinDelay       time.Duration // Ratings given sooner than this after the enhancement are filtered
+}
+
+// LoadFeedbackFilterConfig reads FEEDBACK_PER_ITEM_LIMIT,
+// FEEDBACK_PER_ITEM_WINDOW, FEEDBACK_LOW_RATING_BURST, FEEDBACK_RAPID_BURST,
+// FEEDBACK_REPEATED_TEXT, FEEDBACK_BURST_WINDOW and FEEDBACK_MIN_DELAY
+func LoadFeedbackFilterConfig() FeedbackFilterConfig {
+	config := FeedbackFilterConfig{
+		PerItemLimit:   3,
+		PerItemWindow:  time.Hour,
+		LowRatingBurst: 10,
+		RapidBurst:     30,
+		RepeatedText:   3,
+		BurstWindow:    10 * time.Minute,
+		MinDelay:       2 * time.Second,
+	}
+	for env, target := range map[string]*int{
+		"FEEDBACK_PER_ITEM_LIMIT":   &config.PerItemLimit,
+		"FEEDBACK_LOW_RATING_BURST": &config.LowRatingBurst,
+		"FEEDBACK_RAPID_BURST":      &config.RapidBurst,
+		"FEEDBACK_REPEATED_TEXT":    &config.RepeatedText,
+	} {
+		if n, err := strconv.Atoi(getEnv(env, "")); err == nil && n > 0 {
+			*target = n
+		}
+	}
+	for env, target := range map[string]*time.Duration{
+		"FEEDBACK_PER_ITEM_WINDOW": &config.PerItemWindow,
+		"FEEDBACK_BURST_WINDOW":    &config.BurstWindow,
+	} {
+		if d, err := time.ParseDuration(getEnv(env, "")); err == nil && d > 0 {
+			*target = d
+		}
+	}
+	if d, err := time.ParseDuration(getEnv("FEEDBACK_MIN_DELAY", "")); err == nil && d >= 0 {
+		config.MinDelay = d
+	}
+	return config
+}
+
+// FeedbackSubmission is what the filter needs to know about one feedback
+// submission
+type FeedbackSubmission struct {
+	UserID     string
+	HistoryID  string
+	Rating     *int
+	Text       string
+	EnhancedAt time.Time // When the rated history entry was created
+}
+
+// FeedbackFilter rate-limits feedback per history item and spots feedback
+// spam. Counts are kept in Redis sliding windows, so they hold across
+// instances; when Redis fails, feedback is let through unfiltered rather
+// than rejected.
+type FeedbackFilter struct {
+	windows feedbackWindows // Nil without Redis; only the timing check applies then
+	config  FeedbackFilterConfig
+	logger  *logrus.Logger
+}
+
+// NewFeedbackFilter creates a feedback filter. The cache may be nil.
+func NewFeedbackFilter(cache *CacheService, config FeedbackFilterConfig, logger *logrus.Logger) *FeedbackFilter {
+	var windows feedbackWindows
+	if cache != nil {
+		windows = &redisFeedbackWindows{cache: cache}
+	}
+	return &FeedbackFilter{windows: windows, config: config, logger: logger}
+}
+
+// AllowSubmission counts a submission against its history item and reports
+// whether it is within the per-item limit
+func (f *FeedbackFilter) AllowSubmission(ctx context.Context, sub FeedbackSubmission) bool {
+	if f.windows == nil || f.config.PerItemLimit <= 0 {
+		return true
+	}
+	count, err := f.windows.add(ctx, "item:"+sub.UserID+":"+sub.HistoryID, f.config.PerItemWindow)
+	if err != nil {
+		f.logger.WithError(err).Warn("Feedback rate limit unavailable, allowing submission")
+		return true
+	}
+	return count <= int64(f.config.PerItemLimit)
+}
+
+// Assess returns the reasons a submission looks like spam, none for
+// feedback that looks genuine
+func (f *FeedbackFilter) Assess(ctx context.Context, sub FeedbackSubmission) []string {
+	var reasons []string
+	if f.config.MinDelay > 0 && sub.Rating != nil && !sub.EnhancedAt.IsZero() && time.Since(sub.EnhancedAt) < f.config.MinDelay {
+		reasons = append(reasons, FeedbackFilterInstant)
+	}
+
+	if f.windows != nil {
+		checks := []struct {
+			reason    string
+			window    string
+			threshold int
+			applies   bool
+		}{
+			{FeedbackFilterRapid, "all", f.config.RapidBurst, true},
+			{FeedbackFilterLowRatingBurst, "low", f.config.LowRatingBurst, sub.Rating != nil && *sub.Rating == 1},
+			{FeedbackFilterRepeatedText, "text:" + feedbackTextHash(sub.Text), f.config.RepeatedText, feedbackTextHash(sub.Text) != ""},
+		}
+		for _, check := range checks {
+			if !check.applies || check.threshold <= 0 {
+				continue
+			}
+			count, err := f.windows.add(ctx, check.window+":"+sub.UserID, f.config.BurstWindow)
+			if err != nil {
+				f.logger.WithError(err).Warn("Feedback spam check unavailable, skipping")
+				continue
+			}
+			if count >= int64(check.threshold) {
+				reasons = append(reasons, check.reason)
+			}
+		}
+	}
+
+	for _, reason := range reasons {
+		feedbackFiltered.WithLabelValues(reason).Inc()
+	}
+	return reasons
+}
+
+// feedbackTextHash identifies a comment regardless of case and spacing, or
+// is empty when there is no comment
+func feedbackTextHash(text string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(text)), " ")
+	if normalized == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:8])
+}
+
+// feedbackWindows counts events in sliding windows
+type feedbackWindows interface {
+	// add records an event under key and returns how many events the key has
+	// within window, this one included
+	add(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// redisFeedbackWindows keeps each window as a sorted set scored by time
+type redisFeedbackWindows struct {
+	cache *CacheService
+}
+
+func (w *redisFeedbackWindows) add(ctx context.Context, key string, window time.Duration) (int64, error) {
+	now := time.Now()
+	member := strconv.FormatInt(now.UnixNano(), 10)
+
+	key = w.cache.Key("feedback", key)
+	pipe := w.cache.client.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+	count := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("failed to count feedback: %w", err)
+	}
+	return count.Val(), nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingWindows is a feedbackWindows whose windows never expire
+type countingWindows struct {
+	counts map[string]int64
+	err    error
+}
+
+func (w *countingWindows) add(_ context.Context, key string, _ time.Duration) (int64, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.counts[key]++
+	return w.counts[key], nil
+}
+
+func TestLoadFeedbackFilterConfig(t *testing.T) {
+	t.Setenv("FEEDBACK_PER_ITEM_LIMIT", "5")
+	t.Setenv("FEEDBACK_BURST_WINDOW", "1m")
+	t.Setenv("FEEDBACK_MIN_DELAY", "0s")
+	t.Setenv("FEEDBACK_RAPID_BURST", "zero")
+
+	config := LoadFeedbackFilterConfig()
+	assert.Equal(t, 5, config.PerItemLimit)
+	assert.Equal(t, time.Hour, config.PerItemWindow)
+	assert.Equal(t, time.Minute, config.BurstWindow)
+	assert.Zero(t, config.MinDelay)
+	assert.Equal(t, 30, config.RapidBurst)
+}
+
+func TestFeedbackFilter(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	ctx := context.Background()
+	rating := func(n int) *int { return &n }
+	config := FeedbackFilterConfig{
+		PerItemLimit:   2,
+		LowRatingBurst: 3,
+		RapidBurst:     10,
+		RepeatedText:   2,
+		MinDelay:       time.Second,
+	}
+	newFilter := func() *FeedbackFilter {
+		return &FeedbackFilter{windows: &countingWindows{counts: map[string]int64{}}, config: config, logger: logger}
+	}
+	old := time.Now().Add(-time.Hour)
+
+	t.Run("submissions are limited per history item", func(t *testing.T) {
+		f := newFilter()
+		sub := FeedbackSubmission{UserID: "user-1", HistoryID: "history-1"}
+		assert.True(t, f.AllowSubmission(ctx, sub))
+		assert.True(t, f.AllowSubmission(ctx, sub))
+		assert.False(t, f.AllowSubmission(ctx, sub))
+
+		sub.HistoryID = "history-2"
+		assert.True(t, f.AllowSubmission(ctx, sub), "other items have their own limit")
+	})
+
+	t.Run("a burst of 1-star ratings is filtered", func(t *testing.T) {
+		f := newFilter()
+		for i := 0; i < 2; i++ {
+			assert.Empty(t, f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Rating: rating(1), EnhancedAt: old}))
+		}
+		assert.Empty(t, f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Rating: rating(5), EnhancedAt: old}))
+		assert.Equal(t, []string{FeedbackFilterLowRatingBurst},
+			f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Rating: rating(1), EnhancedAt: old}))
+		assert.Empty(t, f.Assess(ctx, FeedbackSubmission{UserID: "user-2", Rating: rating(1), EnhancedAt: old}))
+	})
+
+	t.Run("repeated comments are filtered whatever their case and spacing", func(t *testing.T) {
+		f := newFilter()
+		assert.Empty(t, f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Text: "Great  tool!", EnhancedAt: old}))
+		assert.Equal(t, []string{FeedbackFilterRepeatedText},
+			f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Text: "great tool! ", EnhancedAt: old}))
+	})
+
+	t.Run("instant ratings are filtered", func(t *testing.T) {
+		f := newFilter()
+		assert.Equal(t, []string{FeedbackFilterInstant},
+			f.Assess(ctx, FeedbackSubmission{UserID: "user-1", Rating: rating(4), EnhancedAt: time.Now()}))
+	})
+
+	t.Run("feedback passes when the windows are unavailable", func(t *testing.T) {
+		f := &FeedbackFilter{windows: &countingWindows{err: errors.New("redis down")}, config: config, logger: logger}
+		sub := FeedbackSubmission{UserID: "user-1", HistoryID: "history-1", Rating: rating(1), EnhancedAt: old}
+		for i := 0; i < 5; i++ {
+			assert.True(t, f.AllowSubmission(ctx, sub))
+			assert.Empty(t, f.Assess(ctx, sub))
+		}
+	})
+}
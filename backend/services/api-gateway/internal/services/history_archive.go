@@ -0,0 +1,472 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// HistoryArchiveTimeout bounds one run of the archive job
+	HistoryArchiveTimeout = 2 * time.Hour
+
+	// historyArchiveMaxLine bounds one JSONL line when reading an archive
+	historyArchiveMaxLine = 16 << 20
+)
+
+var historyArchived = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "gateway_history_archived_total",
+	Help: "Number of prompt history entries moved to the archive",
+})
+
+// HistoryArchiveConfig controls moving old prompt history to object storage
+type HistoryArchiveConfig struct {
+	Enabled bool
+	// After is how old an entry must be before it is archived
+	After time.Duration
+	// BatchSize is how many entries go into one archive object
+	BatchSize int
+}
+
+// LoadHistoryArchiveConfig reads HISTORY_ARCHIVE_ENABLED,
+// HISTORY_ARCHIVE_AFTER_DAYS and HISTORY_ARCHIVE_BATCH_SIZE
+func LoadHistoryArchiveConfig() HistoryArchiveConfig {
+	config := HistoryArchiveConfig{
+		After:     365 * 24 * time.Hour,
+		BatchSize: 5000,
+	}
+	config.Enabled, _ = strconv.ParseBool(os.Getenv("HISTORY_ARCHIVE_ENABLED"))
+	if v, err := strconv.Atoi(os.Getenv("HISTORY_ARCHIVE_AFTER_DAYS")); err == nil && v > 0 {
+		config.After = time.Duration(v) * 24 * time.Hour
+	}
+	if v, err := strconv.Atoi(os.Getenv("HISTORY_ARCHIVE_BATCH_SIZE")); err == nil && v > 0 {
+		config.BatchSize = v
+	}
+	return config
+}
+
+// NewArchiveStorageFromEnv creates the backend history archives are written
+// to, selected by ARCHIVE_STORAGE_BACKEND: "local" (the default) or "s3"
+func NewArchiveStorageFromEnv() (ObjectStorage, error) {
+	switch backend := getEnv("ARCHIVE_STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return NewLocalStorage(
+			getEnv("ARCHIVE_LOCAL_DIR", "./archive"),
+			"",
+			signingSecret("STORAGE_SIGNING_SECRET"),
+		)
+	case "s3":
+		config := S3Config{
+			Bucket:    os.Getenv("ARCHIVE_BUCKET"),
+			Region:    getEnv("ARCHIVE_REGION", "us-east-1"),
+			Endpoint:  os.Getenv("ARCHIVE_ENDPOINT"),
+			AccessKey: getEnv("ARCHIVE_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretKey: getEnv("ARCHIVE_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		}
+		if config.Bucket == "" {
+			return nil, fmt.Errorf("s3 archive storage needs ARCHIVE_BUCKET")
+		}
+		return NewS3Storage(config)
+	default:
+		return nil, fmt.Errorf("unknown archive storage backend %q", backend)
+	}
+}
+
+// HistoryArchiveService moves prompt history older than the configured age
+// out of prompts.history into gzipped JSONL objects, one per batch, keeping
+// a manifest row per object and an index row per entry. Archived entries
+// can still be read and deleted by ID through Wrap, but no longer show up in
+// history listings, search or analytics. Entries that are favorites, saved
+// to the library, labeled for training or under review are never archived.
+type HistoryArchiveService struct {
+	db      *DatabaseService
+	storage ObjectStorage
+	config  HistoryArchiveConfig
+	logger  *logrus.Logger
+}
+
+// NewHistoryArchiveService creates a new history archive service
+func NewHistoryArchiveService(db *DatabaseService, storage ObjectStorage, config HistoryArchiveConfig, logger *logrus.Logger) *HistoryArchiveService {
+	return &HistoryArchiveService{
+		db:      db,
+		storage: storage,
+		config:  config,
+		logger:  logger,
+	}
+}
+
+// Enabled reports whether archiving is turned on
+func (s *HistoryArchiveService) Enabled() bool {
+	return s.config.Enabled && s.storage != nil
+}
+
+// ArchiveJob returns the daily job archiving old history
+func (s *HistoryArchiveService) ArchiveJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "history_archive",
+		Interval: 24 * time.Hour,
+		Timeout:  HistoryArchiveTimeout,
+		Run: func(ctx context.Context) error {
+			archived, err := s.Archive(ctx, time.Now().Add(-s.config.After))
+			if archived > 0 {
+				s.logger.WithField("entries", archived).Info("Archived old prompt history")
+			}
+			return err
+		},
+	}
+}
+
+// Archive moves history created before cutoff to the archive, a batch at a
+// time, and returns how many entries were moved
+func (s *HistoryArchiveService) Archive(ctx context.Context, cutoff time.Time) (int, error) {
+	total := 0
+	for {
+		archived, err := s.archiveBatch(ctx, cutoff)
+		total += archived
+		if err != nil {
+			return total, err
+		}
+		if archived < s.config.BatchSize {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// archiveBatch archives one batch in a transaction holding the rows, so
+// they are only deleted once their object is stored and indexed. An upload
+// whose transaction then fails leaves an unreferenced object behind.
+func (s *HistoryArchiveService) archiveBatch(ctx context.Context, cutoff time.Time) (int, error) {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT h.id, h.created_at, to_jsonb(h)
+		FROM prompts.history h
+		WHERE h.created_at < $1
+		  AND NOT COALESCE(h.is_favorite, false)
+		  AND NOT EXISTS (SELECT 1 FROM prompts.saved_prompts p WHERE p.history_id = h.id)
+		  AND NOT EXISTS (SELECT 1 FROM prompts.training_labels l WHERE l.history_id = h.id)
+		  AND NOT EXISTS (SELECT 1 FROM prompts.training_dataset_items d WHERE d.history_id = h.id)
+		  AND NOT EXISTS (SELECT 1 FROM prompts.enhancement_reviews r WHERE r.history_id = h.id)
+		ORDER BY h.created_at
+		LIMIT $2
+		FOR UPDATE OF h SKIP LOCKED`, cutoff, s.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select history to archive: %w", err)
+	}
+	var (
+		ids            []string
+		lines          []json.RawMessage
+		oldest, newest time.Time
+	)
+	for rows.Next() {
+		var id string
+		var createdAt time.Time
+		var line []byte
+		if err := rows.Scan(&id, &createdAt, &line); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan history: %w", err)
+		}
+		if len(ids) == 0 {
+			oldest = createdAt
+		}
+		newest = createdAt
+		ids = append(ids, id)
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read history to archive: %w", err)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	body, err := encodeHistoryArchive(lines)
+	if err != nil {
+		return 0, err
+	}
+	archiveID := uuid.NewString()
+	now := time.Now().UTC()
+	key := fmt.Sprintf("history/archive/%04d/%02d/%s.jsonl.gz", now.Year(), now.Month(), archiveID)
+	if err := s.storage.Put(ctx, key, body, "application/gzip"); err != nil {
+		return 0, fmt.Errorf("failed to store history archive: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO prompts.history_archives (id, object_key, entry_count, size_bytes, oldest_created_at, newest_created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		archiveID, key, len(ids), len(body), oldest, newest); err != nil {
+		return 0, fmt.Errorf("failed to record history archive: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO prompts.archived_history (history_id, user_id, archive_id, line, created_at)
+		SELECT h.id, h.user_id, $1, t.line, h.created_at
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS t(id, line)
+		JOIN prompts.history h ON h.id = t.id`,
+		archiveID, pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to index archived history: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM prompts.history WHERE id = ANY($1::uuid[])`, pq.Array(ids)); err != nil {
+		return 0, fmt.Errorf("failed to delete archived history: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit history archive: %w", err)
+	}
+
+	historyArchived.Add(float64(len(ids)))
+	return len(ids), nil
+}
+
+// GetArchived reads an archived entry, or returns ErrPromptHistoryNotFound
+func (s *HistoryArchiveService) GetArchived(ctx context.Context, id string) (*models.PromptHistory, error) {
+	var key string
+	var line int
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT a.object_key, x.line
+		FROM prompts.archived_history x
+		JOIN prompts.history_archives a ON a.id = x.archive_id
+		WHERE x.history_id = $1`, id).Scan(&key, &line)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPromptHistoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up archived history: %w", err)
+	}
+
+	body, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch history archive %s: %w", key, err)
+	}
+	lines, err := decodeHistoryArchive(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history archive %s: %w", key, err)
+	}
+	if line < 1 || line > len(lines) || isJSONNull(lines[line-1]) {
+		return nil, ErrPromptHistoryNotFound
+	}
+	return decodeArchivedHistory(lines[line-1])
+}
+
+// DeleteArchived deletes an archived entry, blanking its line in the archive
+// object so the content is gone rather than just unindexed. Returns
+// ErrPromptHistoryNotFound when the entry isn't archived.
+func (s *HistoryArchiveService) DeleteArchived(ctx context.Context, id string) error {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Locking the manifest row serializes rewrites of the same object
+	var key string
+	var line int
+	err = tx.QueryRowContext(ctx, `
+		SELECT a.object_key, x.line
+		FROM prompts.archived_history x
+		JOIN prompts.history_archives a ON a.id = x.archive_id
+		WHERE x.history_id = $1
+		FOR UPDATE OF a`, id).Scan(&key, &line)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPromptHistoryNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up archived history: %w", err)
+	}
+
+	body, err := s.storage.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to fetch history archive %s: %w", key, err)
+	}
+	lines, err := decodeHistoryArchive(body)
+	if err != nil {
+		return fmt.Errorf("failed to read history archive %s: %w", key, err)
+	}
+	if line >= 1 && line <= len(lines) {
+		lines[line-1] = json.RawMessage("null")
+		if body, err = encodeHistoryArchive(lines); err != nil {
+			return err
+		}
+		if err := s.storage.Put(ctx, key, body, "application/gzip"); err != nil {
+			return fmt.Errorf("failed to rewrite history archive %s: %w", key, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM prompts.archived_history WHERE history_id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete archived history: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit archived history deletion: %w", err)
+	}
+	return nil
+}
+
+// Wrap returns store with reads and deletes by ID falling through to the
+// archive for entries no longer in it
+func (s *HistoryArchiveService) Wrap(store HistoryStore) HistoryStore {
+	return &archivedHistoryStore{HistoryStore: store, archive: s}
+}
+
+// archivedHistoryStore is a HistoryStore that also finds archived entries
+type archivedHistoryStore struct {
+	HistoryStore
+	archive historyArchive
+}
+
+// historyArchive is the part of HistoryArchiveService archivedHistoryStore uses
+type historyArchive interface {
+	GetArchived(ctx context.Context, id string) (*models.PromptHistory, error)
+	DeleteArchived(ctx context.Context, id string) error
+}
+
+// GetPromptHistory returns an entry from the store, else from the archive
+func (s *archivedHistoryStore) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	entry, err := s.HistoryStore.GetPromptHistory(ctx, id)
+	if errors.Is(err, ErrPromptHistoryNotFound) {
+		return s.archive.GetArchived(ctx, id)
+	}
+	return entry, err
+}
+
+// DeletePromptHistory deletes an entry from the store, else from the archive
+func (s *archivedHistoryStore) DeletePromptHistory(ctx context.Context, id string) error {
+	err := s.HistoryStore.DeletePromptHistory(ctx, id)
+	if errors.Is(err, ErrPromptHistoryNotFound) {
+		return s.archive.DeleteArchived(ctx, id)
+	}
+	return err
+}
+
+// encodeHistoryArchive writes one JSON document per line, gzipped
+func encodeHistoryArchive(lines []json.RawMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, line); err != nil {
+			return nil, fmt.Errorf("failed to encode archived history: %w", err)
+		}
+		compact.WriteByte('\n')
+		if _, err := zw.Write(compact.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to compress history archive: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress history archive: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeHistoryArchive returns the lines of a gzipped JSONL archive
+func decodeHistoryArchive(body []byte) ([]json.RawMessage, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var lines []json.RawMessage
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 64*1024), historyArchiveMaxLine)
+	for scanner.Scan() {
+		lines = append(lines, json.RawMessage(bytes.Clone(scanner.Bytes())))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// archivedHistoryRow is a prompts.history row as to_jsonb writes it
+type archivedHistoryRow struct {
+	ID               string                 `json:"id"`
+	UserID           *string                `json:"user_id"`
+	SessionID        *string                `json:"session_id"`
+	RequestID        *string                `json:"request_id"`
+	OriginalInput    string                 `json:"original_input"`
+	EnhancedOutput   string                 `json:"enhanced_output"`
+	Intent           *string                `json:"intent"`
+	IntentConfidence *float64               `json:"intent_confidence"`
+	Complexity       *string                `json:"complexity"`
+	TechniquesUsed   []string               `json:"techniques_used"`
+	TechniqueScores  map[string]float64     `json:"technique_scores"`
+	ProcessingTimeMs *int64                 `json:"processing_time_ms"`
+	TokenCount       *int64                 `json:"token_count"`
+	ModelUsed        *string                `json:"model_used"`
+	FeedbackScore    *int64                 `json:"feedback_score"`
+	FeedbackText     *string                `json:"feedback_text"`
+	IsFavorite       *bool                  `json:"is_favorite"`
+	Metadata         map[string]interface{} `json:"metadata"`
+	CreatedAt        time.Time              `json:"created_at"`
+	UpdatedAt        *time.Time             `json:"updated_at"`
+}
+
+// decodeArchivedHistory turns an archived row back into a history entry
+func decodeArchivedHistory(line json.RawMessage) (*models.PromptHistory, error) {
+	var row archivedHistoryRow
+	if err := json.Unmarshal(line, &row); err != nil {
+		return nil, fmt.Errorf("failed to decode archived history: %w", err)
+	}
+	entry := &models.PromptHistory{
+		ID:              row.ID,
+		UserID:          optionalString(row.UserID),
+		SessionID:       optionalString(row.SessionID),
+		RequestID:       optionalString(row.RequestID),
+		OriginalInput:   row.OriginalInput,
+		EnhancedOutput:  row.EnhancedOutput,
+		Intent:          optionalString(row.Intent),
+		Complexity:      optionalString(row.Complexity),
+		TechniquesUsed:  row.TechniquesUsed,
+		TechniqueScores: row.TechniqueScores,
+		ModelUsed:       optionalString(row.ModelUsed),
+		FeedbackText:    optionalString(row.FeedbackText),
+		IsFavorite:      row.IsFavorite != nil && *row.IsFavorite,
+		Metadata:        row.Metadata,
+		CreatedAt:       row.CreatedAt,
+		UpdatedAt:       row.CreatedAt,
+	}
+	if row.IntentConfidence != nil {
+		entry.IntentConfidence = sql.NullFloat64{Float64: *row.IntentConfidence, Valid: true}
+	}
+	if row.ProcessingTimeMs != nil {
+		entry.ProcessingTimeMs = sql.NullInt64{Int64: *row.ProcessingTimeMs, Valid: true}
+	}
+	if row.TokenCount != nil {
+		entry.TokenCount = sql.NullInt64{Int64: *row.TokenCount, Valid: true}
+	}
+	if row.FeedbackScore != nil {
+		entry.FeedbackScore = sql.NullInt64{Int64: *row.FeedbackScore, Valid: true}
+	}
+	if row.UpdatedAt != nil {
+		entry.UpdatedAt = *row.UpdatedAt
+	}
+	return entry, nil
+}
+
+func optionalString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A prompts.history row as to_jsonb renders it
+const archivedHistoryLine = `{"id": "5b0c7f0e-3f5d-4d8e-9a51-0f1c2b3d4e5f", "user_id": "u1", "session_id": null,
+	"request_id": "req-1", "original_input": "write a poem", "enhanced_output": "Write a sonnet",
+	"intent": "creative_writing", "intent_confidence": 0.92, "complexity": "simple",
+	"techniques_used": ["role_play", "few_shot"], "technique_scores": {"role_play": 0.8},
+	"processing_time_ms": 120, "token_count": null, "model_used": null, "feedback_score": 4,
+	"feedback_text": null, "is_favorite": false, "metadata": {"profile": "poetry"},
+	"created_at": "2023-02-01T10:00:00.123456+00:00", "updated_at": "2023-02-02T10:00:00+00:00"}`
+
+func TestHistoryArchiveEncoding(t *testing.T) {
+	lines := []json.RawMessage{
+		json.RawMessage(archivedHistoryLine),
+		json.RawMessage(`{"id": "second"}`),
+		json.RawMessage("null"),
+	}
+
+	body, err := encodeHistoryArchive(lines)
+	require.NoError(t, err)
+	decoded, err := decodeHistoryArchive(body)
+	require.NoError(t, err)
+	require.Len(t, decoded, 3)
+	assert.JSONEq(t, archivedHistoryLine, string(decoded[0]))
+	assert.NotContains(t, string(decoded[0]), "\n", "each entry is one line")
+	assert.True(t, isJSONNull(decoded[2]))
+
+	_, err = decodeHistoryArchive(body[:len(body)/2])
+	assert.Error(t, err, "truncated archives fail")
+}
+
+func TestDecodeArchivedHistory(t *testing.T) {
+	entry, err := decodeArchivedHistory(json.RawMessage(archivedHistoryLine))
+	require.NoError(t, err)
+
+	assert.Equal(t, "5b0c7f0e-3f5d-4d8e-9a51-0f1c2b3d4e5f", entry.ID)
+	assert.Equal(t, "u1", entry.UserID.String)
+	assert.False(t, entry.SessionID.Valid)
+	assert.Equal(t, "Write a sonnet", entry.EnhancedOutput)
+	assert.Equal(t, "creative_writing", entry.Intent.String)
+	assert.InDelta(t, 0.92, entry.IntentConfidence.Float64, 1e-9)
+	assert.Equal(t, []string{"role_play", "few_shot"}, entry.TechniquesUsed)
+	assert.Equal(t, int64(120), entry.ProcessingTimeMs.Int64)
+	assert.False(t, entry.TokenCount.Valid)
+	assert.Equal(t, int64(4), entry.FeedbackScore.Int64)
+	assert.Equal(t, "poetry", entry.Metadata["profile"])
+	assert.True(t, entry.CreatedAt.Equal(time.Date(2023, 2, 1, 10, 0, 0, 123456000, time.UTC)))
+	assert.True(t, entry.UpdatedAt.Equal(time.Date(2023, 2, 2, 10, 0, 0, 0, time.UTC)))
+}
+
+// hotHistory is a HistoryStore holding a single entry
+type hotHistory struct {
+	HistoryStore
+	entry   *models.PromptHistory
+	deleted []string
+}
+
+func (h *hotHistory) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	if h.entry != nil && h.entry.ID == id {
+		return h.entry, nil
+	}
+	return nil, ErrPromptHistoryNotFound
+}
+
+func (h *hotHistory) DeletePromptHistory(ctx context.Context, id string) error {
+	if h.entry == nil || h.entry.ID != id {
+		return ErrPromptHistoryNotFound
+	}
+	h.deleted = append(h.deleted, id)
+	return nil
+}
+
+// fakeHistoryArchive serves archived entries from memory
+type fakeHistoryArchive map[string]*models.PromptHistory
+
+func (f fakeHistoryArchive) GetArchived(ctx context.Context, id string) (*models.PromptHistory, error) {
+	if entry, ok := f[id]; ok {
+		return entry, nil
+	}
+	return nil, ErrPromptHistoryNotFound
+}
+
+func (f fakeHistoryArchive) DeleteArchived(ctx context.Context, id string) error {
+	if _, ok := f[id]; !ok {
+		return ErrPromptHistoryNotFound
+	}
+	delete(f, id)
+	return nil
+}
+
+func TestArchivedHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	hot := &hotHistory{entry: &models.PromptHistory{ID: "hot"}}
+	archive := fakeHistoryArchive{"old": {ID: "old", OriginalInput: "archived"}}
+	store := &archivedHistoryStore{HistoryStore: hot, archive: archive}
+
+	entry, err := store.GetPromptHistory(ctx, "hot")
+	require.NoError(t, err)
+	assert.Equal(t, "hot", entry.ID)
+
+	entry, err = store.GetPromptHistory(ctx, "old")
+	require.NoError(t, err)
+	assert.Equal(t, "archived", entry.OriginalInput)
+
+	_, err = store.GetPromptHistory(ctx, "missing")
+	assert.ErrorIs(t, err, ErrPromptHistoryNotFound)
+
+	require.NoError(t, store.DeletePromptHistory(ctx, "hot"))
+	assert.Equal(t, []string{"hot"}, hot.deleted)
+	assert.Contains(t, archive, "old", "hot deletes don't touch the archive")
+
+	require.NoError(t, store.DeletePromptHistory(ctx, "old"))
+	assert.NotContains(t, archive, "old")
+	assert.ErrorIs(t, store.DeletePromptHistory(ctx, "old"), ErrPromptHistoryNotFound)
+}
@@ -0,0 +1,357 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxHistoryBulkItems is the most history entries one bulk request
+	// may name
+	MaxHistoryBulkItems = 500
+
+	// historyBulkChunkSize is how many entries are looked up and checked at
+	// once. Chunks aren't atomic: the history store changes entries one at a
+	// time, so a chunk failing part way keeps the entries it already applied
+	// and reports the rest as failed. The remaining chunks still apply.
+	historyBulkChunkSize = 100
+
+	// maxSavedPromptTitle is the longest title prompts.saved_prompts holds
+	maxSavedPromptTitle = 255
+)
+
+// HistoryBulkAction is what a bulk request does to each history entry
+type HistoryBulkAction string
+
+const (
+	HistoryBulkDelete     HistoryBulkAction = "delete"
+	HistoryBulkFavorite   HistoryBulkAction = "favorite"
+	HistoryBulkUnfavorite HistoryBulkAction = "unfavorite"
+	HistoryBulkTag        HistoryBulkAction = "tag"  // Adds tags to metadata.tags
+	HistoryBulkMove       HistoryBulkAction = "move" // Saves entries into one of the user's collections, out of their others
+)
+
+// PromptAction is the permission each entry needs for the action
+func (a HistoryBulkAction) PromptAction() PromptAction {
+	if a == HistoryBulkDelete {
+		return PromptActionDelete
+	}
+	return PromptActionModify
+}
+
+// Outcomes of a bulk request for one history entry
+const (
+	HistoryBulkApplied   = "applied"
+	HistoryBulkNotFound  = "not_found"
+	HistoryBulkForbidden = "forbidden"
+	HistoryBulkPinned    = "pinned" // Pinned entries can't be deleted until unpinned
+	HistoryBulkFailed    = "failed" // It couldn't be applied, or its chunk failed before reaching it
+)
+
+var (
+	// ErrCollectionNotFound is returned for collections that don't exist or
+	// belong to another user
+	ErrCollectionNotFound = errors.New("collection not found")
+	// ErrInvalidHistoryBulkRequest is returned for bulk requests missing
+	// what their action needs
+	ErrInvalidHistoryBulkRequest = errors.New("invalid bulk history request")
+)
+
+// HistoryBulkRequest is one action applied to many of a user's history
+// entries
+type HistoryBulkRequest struct {
+	UserID       string
+	Action       HistoryBulkAction
+	IDs          []string
+	Tags         []string // For HistoryBulkTag
+	CollectionID string   // For HistoryBulkMove
+}
+
+// HistoryBulkItem reports what a bulk request did to one entry
+type HistoryBulkItem struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// HistoryBulkReport reports a bulk request, with one item per requested
+// entry in request order
+type HistoryBulkReport struct {
+	Action  HistoryBulkAction `json:"action"`
+	Applied int               `json:"applied"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Partial bool              `json:"partial"` // A chunk failed after applying some of its entries
+	Items   []HistoryBulkItem `json:"items"`
+}
+
+// HistoryBulkService applies actions to many history entries at once.
+// Entries are read and changed through the history store, so residency,
+// archiving and tenant isolation apply as they do to single entries.
+// Collections and pins are kept in Postgres.
+type HistoryBulkService struct {
+	db      *DatabaseService
+	history HistoryStore
+	logger  *logrus.Logger
+}
+
+// NewHistoryBulkService creates a new bulk history service
+func NewHistoryBulkService(db *DatabaseService, history HistoryStore, logger *logrus.Logger) *HistoryBulkService {
+	return &HistoryBulkService{db: db, history: history, logger: logger}
+}
+
+// Apply applies req to each entry authorize allows, historyBulkChunkSize
+// entries at a time. authorize is asked about every entry found; entries it
+// refuses are reported forbidden and left alone.
+func (s *HistoryBulkService) Apply(ctx context.Context, req HistoryBulkRequest, authorize func(PromptResource) error) (*HistoryBulkReport, error) {
+	if err := s.validate(ctx, req); err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]string, len(req.IDs))
+	ids := make([]string, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if _, seen := statuses[id]; !seen {
+			statuses[id] = HistoryBulkFailed
+			ids = append(ids, id)
+		}
+	}
+
+	report := &HistoryBulkReport{Action: req.Action, Items: make([]HistoryBulkItem, 0, len(req.IDs))}
+	for start := 0; start < len(ids); start += historyBulkChunkSize {
+		chunk := ids[start:min(start+historyBulkChunkSize, len(ids))]
+		if err := s.applyChunk(ctx, req, chunk, statuses, authorize); err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			for _, id := range chunk {
+				report.Partial = report.Partial || statuses[id] == HistoryBulkApplied
+			}
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"action": req.Action,
+				"items":  len(chunk),
+			}).Error("Bulk history chunk failed")
+		}
+	}
+
+	for _, id := range req.IDs {
+		status := statuses[id]
+		report.Items = append(report.Items, HistoryBulkItem{ID: id, Status: status})
+		switch status {
+		case HistoryBulkApplied:
+			report.Applied++
+		case HistoryBulkFailed:
+			report.Failed++
+		default:
+			report.Skipped++
+		}
+	}
+	return report, nil
+}
+
+func (s *HistoryBulkService) validate(ctx context.Context, req HistoryBulkRequest) error {
+	if len(req.IDs) == 0 || len(req.IDs) > MaxHistoryBulkItems {
+		return fmt.Errorf("%w: between 1 and %d ids are required", ErrInvalidHistoryBulkRequest, MaxHistoryBulkItems)
+	}
+	switch req.Action {
+	case HistoryBulkDelete, HistoryBulkFavorite, HistoryBulkUnfavorite:
+	case HistoryBulkTag:
+		if len(req.Tags) == 0 {
+			return fmt.Errorf("%w: tag needs tags", ErrInvalidHistoryBulkRequest)
+		}
+	case HistoryBulkMove:
+		if req.CollectionID == "" {
+			return fmt.Errorf("%w: move needs a collection_id", ErrInvalidHistoryBulkRequest)
+		}
+		var owned bool
+		err := s.db.DB.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM prompts.collections WHERE id = $1 AND user_id = $2)`,
+			req.CollectionID, req.UserID).Scan(&owned)
+		if err != nil {
+			return fmt.Errorf("failed to get collection: %w", err)
+		}
+		if !owned {
+			return ErrCollectionNotFound
+		}
+	default:
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidHistoryBulkRequest, req.Action)
+	}
+	return nil
+}
+
+// applyChunk reads a chunk's entries, settles each one's status and applies
+// the action to those allowed, marking each applied once it is
+func (s *HistoryBulkService) applyChunk(ctx context.Context, req HistoryBulkRequest, chunk []string, statuses map[string]string, authorize func(PromptResource) error) error {
+	var pinned map[string]bool
+	if req.Action == HistoryBulkDelete {
+		// Pins are made holding the same locks, so none can be made on these
+		// entries between checking them and deleting them. The locks are
+		// released when the chunk is done.
+		tx, err := s.db.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		if err := lockHistory(ctx, tx, chunk); err != nil {
+			return err
+		}
+		if pinned, err = pinnedHistory(ctx, tx, chunk); err != nil {
+			return err
+		}
+	}
+
+	var allowed []*models.PromptHistory
+	for _, id := range chunk {
+		entry, err := s.history.GetPromptHistory(ctx, id)
+		if errors.Is(err, ErrPromptHistoryNotFound) {
+			statuses[id] = HistoryBulkNotFound
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = authorize(PromptResource{Kind: "history", ID: id, OwnerID: entry.UserID.String})
+		switch {
+		case errors.Is(err, ErrPromptAccessDenied):
+			statuses[id] = HistoryBulkForbidden
+		case err != nil:
+			return err
+		case pinned[id]:
+			statuses[id] = HistoryBulkPinned
+		default:
+			allowed = append(allowed, entry)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	if req.Action == HistoryBulkMove {
+		if err := s.moveToCollection(ctx, req, allowed); err != nil {
+			return err
+		}
+		for _, entry := range allowed {
+			statuses[entry.ID] = HistoryBulkApplied
+		}
+		return nil
+	}
+
+	for _, entry := range allowed {
+		err := s.applyEntry(ctx, req, entry.ID)
+		if errors.Is(err, ErrPromptHistoryNotFound) {
+			statuses[entry.ID] = HistoryBulkNotFound
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		statuses[entry.ID] = HistoryBulkApplied
+	}
+	return nil
+}
+
+// applyEntry applies a delete, favorite or tag action to one entry
+func (s *HistoryBulkService) applyEntry(ctx context.Context, req HistoryBulkRequest, id string) error {
+	switch req.Action {
+	case HistoryBulkDelete:
+		return s.history.DeletePromptHistory(ctx, id)
+	case HistoryBulkFavorite, HistoryBulkUnfavorite:
+		favorite := req.Action == HistoryBulkFavorite
+		return s.history.UpdatePromptHistory(ctx, id, HistoryUpdate{Favorite: &favorite})
+	case HistoryBulkTag:
+		return s.history.UpdatePromptHistory(ctx, id, HistoryUpdate{AddTags: req.Tags})
+	}
+	return nil
+}
+
+// pinnedHistory returns which of the entries are a version of any pin
+func pinnedHistory(ctx context.Context, tx *sql.Tx, ids []string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT history_id FROM prompts.pin_versions WHERE history_id = ANY($1::uuid[])`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned history: %w", err)
+	}
+	defer rows.Close()
+
+	pinned := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan pinned history: %w", err)
+		}
+		pinned[id] = true
+	}
+	return pinned, rows.Err()
+}
+
+// savedPromptTitle titles a saved prompt after the start of its input
+func savedPromptTitle(input string) string {
+	if runes := []rune(input); len(runes) > maxSavedPromptTitle {
+		return string(runes[:maxSavedPromptTitle])
+	}
+	return input
+}
+
+// moveToCollection saves entries into the user's library, titled after
+// their input as read from the history store, and moves them into the
+// collection out of the user's others, all in one transaction
+func (s *HistoryBulkService) moveToCollection(ctx context.Context, req HistoryBulkRequest, entries []*models.PromptHistory) error {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	exec := func(query string, args ...interface{}) error {
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to move history: %w", err)
+		}
+		return nil
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+		err := exec(`
+			INSERT INTO prompts.saved_prompts (user_id, history_id, title)
+			SELECT $1, $2, $3
+			WHERE NOT EXISTS (
+				SELECT 1 FROM prompts.saved_prompts s
+				WHERE s.history_id = $2 AND s.user_id = $1
+			)`, req.UserID, entry.ID, savedPromptTitle(entry.OriginalInput))
+		if err != nil {
+			return err
+		}
+	}
+	err = exec(`
+		DELETE FROM prompts.collection_prompts cp
+		USING prompts.saved_prompts s, prompts.collections c
+		WHERE cp.saved_prompt_id = s.id AND cp.collection_id = c.id
+		  AND s.user_id = $2 AND s.history_id = ANY($1::uuid[])
+		  AND c.user_id = $2 AND c.id <> $3`, pq.Array(ids), req.UserID, req.CollectionID)
+	if err != nil {
+		return err
+	}
+	err = exec(`
+		INSERT INTO prompts.collection_prompts (collection_id, saved_prompt_id, position)
+		SELECT $3, s.id,
+			(SELECT COALESCE(MAX(position), 0) FROM prompts.collection_prompts WHERE collection_id = $3)
+			+ ROW_NUMBER() OVER (ORDER BY s.created_at)
+		FROM prompts.saved_prompts s
+		WHERE s.user_id = $2 AND s.history_id = ANY($1::uuid[])
+		ON CONFLICT (collection_id, saved_prompt_id) DO NOTHING`, pq.Array(ids), req.UserID, req.CollectionID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit bulk history: %w", err)
+	}
+	return nil
+}
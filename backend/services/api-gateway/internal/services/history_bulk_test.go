@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingHistory is a memoryHistory whose updates fail
+type failingHistory struct {
+	*memoryHistory
+}
+
+func (f failingHistory) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	return errors.New("connection reset")
+}
+
+// failingDeletes is a memoryHistory whose deletes of one entry fail
+type failingDeletes struct {
+	*memoryHistory
+	id string
+}
+
+func (f failingDeletes) DeletePromptHistory(ctx context.Context, id string) error {
+	if id == f.id {
+		return errors.New("connection reset")
+	}
+	return f.memoryHistory.DeletePromptHistory(ctx, id)
+}
+
+func TestHistoryBulkApply(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	newHistory := func() *memoryHistory {
+		history := &memoryHistory{entries: map[string]models.PromptHistory{}}
+		for id, owner := range map[string]string{"own": "user-1", "other": "user-2", "pinned": "user-1"} {
+			history.entries[id] = models.PromptHistory{
+				ID:            id,
+				UserID:        sql.NullString{String: owner, Valid: true},
+				OriginalInput: "draft the memo",
+				Metadata:      map[string]interface{}{"tags": []interface{}{"work"}},
+			}
+		}
+		return history
+	}
+	newService := func(history HistoryStore) (*HistoryBulkService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "pin_versions"):
+				return []string{"history_id"}, [][]driver.Value{{"pinned"}}
+			case strings.Contains(query, "prompts.collections"):
+				return []string{"owned"}, [][]driver.Value{{true}}
+			}
+			return nil, nil
+		}
+		return NewHistoryBulkService(NewDatabaseService(db.DB), history, logger), d
+	}
+	ownerOnly := func(resource PromptResource) error {
+		if resource.OwnerID != "user-1" {
+			return ErrPromptAccessDenied
+		}
+		return nil
+	}
+	statuses := func(report *HistoryBulkReport) map[string]string {
+		byID := map[string]string{}
+		for _, item := range report.Items {
+			byID[item.ID] = item.Status
+		}
+		return byID
+	}
+
+	t.Run("deletes only what the caller may", func(t *testing.T) {
+		history := newHistory()
+		bulk, _ := newService(history)
+		report, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkDelete,
+			IDs:    []string{"own", "other", "pinned", "missing"},
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"own":     HistoryBulkApplied,
+			"other":   HistoryBulkForbidden,
+			"pinned":  HistoryBulkPinned,
+			"missing": HistoryBulkNotFound,
+		}, statuses(report))
+		assert.Equal(t, 1, report.Applied)
+		assert.Equal(t, 3, report.Skipped)
+
+		assert.NotContains(t, history.entries, "own")
+		assert.Contains(t, history.entries, "other")
+		assert.Contains(t, history.entries, "pinned")
+		assert.False(t, report.Partial)
+	})
+
+	t.Run("checks pins holding the lock pins are made under", func(t *testing.T) {
+		bulk, d := newService(newHistory())
+		_, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkDelete,
+			IDs:    []string{"own"},
+		}, ownerOnly)
+		require.NoError(t, err)
+
+		entries := d.entries()
+		require.Len(t, entries, 4)
+		assert.Equal(t, "BEGIN", entries[0])
+		assert.Contains(t, entries[1], "pg_advisory_xact_lock(hashtext('history:' || id))")
+		assert.Contains(t, entries[2], "FROM prompts.pin_versions")
+		assert.Equal(t, "ROLLBACK", entries[3], "the locks are held until the deletes are done")
+	})
+
+	t.Run("chunks that fail part way are reported partial", func(t *testing.T) {
+		history := newHistory()
+		mine := history.entries["own"]
+		mine.ID = "mine"
+		history.entries["mine"] = mine
+		bulk, _ := newService(failingDeletes{history, "own"})
+
+		report, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkDelete,
+			IDs:    []string{"mine", "own"},
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{
+			"mine": HistoryBulkApplied,
+			"own":  HistoryBulkFailed,
+		}, statuses(report))
+		assert.True(t, report.Partial)
+		assert.NotContains(t, history.entries, "mine")
+	})
+
+	t.Run("favorites and tags through the history store", func(t *testing.T) {
+		history := newHistory()
+		bulk, _ := newService(history)
+		report, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkFavorite,
+			IDs:    []string{"own", "other"},
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Applied)
+		assert.True(t, history.entries["own"].IsFavorite)
+		assert.False(t, history.entries["other"].IsFavorite)
+
+		_, err = bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkTag,
+			IDs:    []string{"own"},
+			Tags:   []string{"legal", "work"},
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"legal", "work"}, history.entries["own"].Metadata["tags"])
+	})
+
+	t.Run("entries that fail are reported", func(t *testing.T) {
+		bulk, _ := newService(failingHistory{newHistory()})
+		report, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID: "user-1",
+			Action: HistoryBulkFavorite,
+			IDs:    []string{"own", "pinned", "other"},
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Failed)
+		assert.Equal(t, HistoryBulkForbidden, statuses(report)["other"])
+	})
+
+	t.Run("moves title saved prompts after the text the store returns", func(t *testing.T) {
+		history := newHistory()
+		own := history.entries["own"]
+		own.OriginalInput = strings.Repeat("é", maxSavedPromptTitle+10)
+		history.entries["own"] = own
+		bulk, d := newService(history)
+
+		report, err := bulk.Apply(ctx, HistoryBulkRequest{
+			UserID:       "user-1",
+			Action:       HistoryBulkMove,
+			IDs:          []string{"own"},
+			CollectionID: "collection-1",
+		}, ownerOnly)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Applied)
+
+		var title string
+		for i, entry := range d.entries() {
+			if strings.HasPrefix(entry, "INSERT INTO prompts.saved_prompts") {
+				title = d.args[i][2].Value.(string)
+			}
+		}
+		assert.Equal(t, maxSavedPromptTitle, utf8.RuneCountInString(title))
+		assert.True(t, strings.HasPrefix(own.OriginalInput, title))
+		assert.Equal(t, "COMMIT", d.entries()[len(d.entries())-1])
+	})
+
+	t.Run("validates the request", func(t *testing.T) {
+		bulk, _ := newService(newHistory())
+		for name, req := range map[string]HistoryBulkRequest{
+			"no ids":         {Action: HistoryBulkDelete},
+			"too many ids":   {Action: HistoryBulkDelete, IDs: make([]string, MaxHistoryBulkItems+1)},
+			"tag no tags":    {Action: HistoryBulkTag, IDs: []string{"own"}},
+			"move no target": {Action: HistoryBulkMove, IDs: []string{"own"}},
+			"unknown action": {Action: "archive", IDs: []string{"own"}},
+		} {
+			_, err := bulk.Apply(ctx, req, ownerOnly)
+			assert.ErrorIs(t, err, ErrInvalidHistoryBulkRequest, name)
+		}
+	})
+}
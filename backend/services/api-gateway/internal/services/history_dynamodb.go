@@ -0,0 +1,487 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// dynamoUserIndex is the global secondary index listing a user's history
+	dynamoUserIndex = "user_id-created_at"
+	// dynamoTimeLayout is fixed width so timestamps sort as strings
+	dynamoTimeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// DynamoConfig configures the DynamoDB history store
+type DynamoConfig struct {
+	Table     string
+	Region    string
+	Endpoint  string // Defaults to AWS; set for DynamoDB Local
+	AccessKey string
+	SecretKey string
+}
+
+// DynamoHistoryStore keeps prompt history in a DynamoDB table keyed by
+// entry ID, with a user_id/created_at index for listing a user's history.
+// It talks to the DynamoDB JSON API directly, signed with Signature
+// Version 4.
+type DynamoHistoryStore struct {
+	config   DynamoConfig
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewDynamoHistoryStore creates a DynamoDB history store
+func NewDynamoHistoryStore(config DynamoConfig) (*DynamoHistoryStore, error) {
+	if config.Table == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("dynamodb history store needs DYNAMODB_HISTORY_TABLE, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://dynamodb.%s.amazonaws.com", config.Region)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DynamoDB endpoint: %w", err)
+	}
+	return &DynamoHistoryStore{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// dynamoValue is a DynamoDB attribute value
+type dynamoValue struct {
+	S    *string       `json:"S,omitempty"`
+	N    *string       `json:"N,omitempty"`
+	L    []dynamoValue `json:"L,omitempty"`
+	NULL *bool         `json:"NULL,omitempty"`
+	BOOL *bool         `json:"BOOL,omitempty"`
+}
+
+type dynamoItem map[string]dynamoValue
+
+func dynamoString(s string) dynamoValue {
+	return dynamoValue{S: &s}
+}
+
+func (item dynamoItem) str(name string) string {
+	if value, ok := item[name]; ok && value.S != nil {
+		return *value.S
+	}
+	return ""
+}
+
+func (item dynamoItem) nullString(name string) sql.NullString {
+	if value, ok := item[name]; ok && value.S != nil {
+		return sql.NullString{String: *value.S, Valid: true}
+	}
+	return sql.NullString{}
+}
+
+func (item dynamoItem) time(name string) (time.Time, error) {
+	value := item.str(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(dynamoTimeLayout, value)
+}
+
+// dynamoError is an error response from DynamoDB
+type dynamoError struct {
+	Type    string // Exception name, e.g. ConditionalCheckFailedException
+	Message string
+	Status  int
+}
+
+func (e *dynamoError) Error() string {
+	return fmt.Sprintf("dynamodb %s (status %d): %s", e.Type, e.Status, e.Message)
+}
+
+func isDynamoError(err error, exception string) bool {
+	var dynamoErr *dynamoError
+	return errors.As(err, &dynamoErr) && dynamoErr.Type == exception
+}
+
+// call runs a DynamoDB API action, decoding the response into output
+func (s *DynamoHistoryStore) call(ctx context.Context, action string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", action, err)
+	}
+	path := s.endpoint.Path + "/"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint.Scheme+"://"+s.endpoint.Host+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create dynamodb request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", "DynamoDB_20120810."+action)
+
+	signer := sigV4{region: s.config.Region, accessKey: s.config.AccessKey, secretKey: s.config.SecretKey, service: "dynamodb"}
+	signer.sign(req, path, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("dynamodb %s failed: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read dynamodb %s response: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Type        string `json:"__type"`
+			Message     string `json:"message"`
+			MessageCaps string `json:"Message"`
+		}
+		json.Unmarshal(data, &failure)
+		dynamoErr := &dynamoError{Type: failure.Type, Message: failure.Message, Status: resp.StatusCode}
+		if i := strings.LastIndex(dynamoErr.Type, "#"); i >= 0 {
+			dynamoErr.Type = dynamoErr.Type[i+1:]
+		}
+		if dynamoErr.Message == "" {
+			dynamoErr.Message = failure.MessageCaps
+		}
+		return dynamoErr
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, output); err != nil {
+		return fmt.Errorf("failed to decode dynamodb %s response: %w", action, err)
+	}
+	return nil
+}
+
+// tableStatus returns the history table's status, e.g. CREATING or ACTIVE
+func (s *DynamoHistoryStore) tableStatus(ctx context.Context) (string, error) {
+	var output struct {
+		Table struct {
+			TableStatus string `json:"TableStatus"`
+		} `json:"Table"`
+	}
+	err := s.call(ctx, "DescribeTable", map[string]string{"TableName": s.config.Table}, &output)
+	return output.Table.TableStatus, err
+}
+
+// EnsureTable creates the history table and its user index unless the
+// table already exists, then waits for it to become active
+func (s *DynamoHistoryStore) EnsureTable(ctx context.Context) error {
+	_, err := s.tableStatus(ctx)
+	if isDynamoError(err, "ResourceNotFoundException") {
+		attribute := func(name string) map[string]string {
+			return map[string]string{"AttributeName": name, "AttributeType": "S"}
+		}
+		key := func(name, keyType string) map[string]string {
+			return map[string]string{"AttributeName": name, "KeyType": keyType}
+		}
+		err = s.call(ctx, "CreateTable", map[string]interface{}{
+			"TableName":            s.config.Table,
+			"BillingMode":          "PAY_PER_REQUEST",
+			"AttributeDefinitions": []map[string]string{attribute("id"), attribute("user_id"), attribute("created_at")},
+			"KeySchema":            []map[string]string{key("id", "HASH")},
+			"GlobalSecondaryIndexes": []map[string]interface{}{{
+				"IndexName":  dynamoUserIndex,
+				"KeySchema":  []map[string]string{key("user_id", "HASH"), key("created_at", "RANGE")},
+				"Projection": map[string]string{"ProjectionType": "ALL"},
+			}},
+		}, nil)
+		if isDynamoError(err, "ResourceInUseException") {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	for {
+		status, err := s.tableStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to describe history table: %w", err)
+		}
+		if status == "ACTIVE" {
+			return nil
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// encodeHistory turns an entry into an item. Anonymous entries have no
+// user_id, which keeps them out of the user index.
+func encodeHistory(entry models.PromptHistory) (dynamoItem, error) {
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	techniques := make([]dynamoValue, len(entry.TechniquesUsed))
+	for i, technique := range entry.TechniquesUsed {
+		techniques[i] = dynamoString(technique)
+	}
+	empty := true
+
+	item := dynamoItem{
+		"id":              dynamoString(entry.ID),
+		"original_input":  dynamoString(entry.OriginalInput),
+		"enhanced_output": dynamoString(entry.EnhancedOutput),
+		"techniques_used": {L: techniques},
+		"metadata":        dynamoString(string(metadata)),
+		"created_at":      dynamoString(entry.CreatedAt.UTC().Format(dynamoTimeLayout)),
+	}
+	if len(techniques) == 0 {
+		item["techniques_used"] = dynamoValue{NULL: &empty}
+	}
+	if entry.UserID.Valid && entry.UserID.String != "" {
+		item["user_id"] = dynamoString(entry.UserID.String)
+	}
+	if entry.Intent.Valid {
+		item["intent"] = dynamoString(entry.Intent.String)
+	}
+	if entry.Complexity.Valid {
+		item["complexity"] = dynamoString(entry.Complexity.String)
+	}
+	if entry.FeedbackScore.Valid {
+		score := strconv.FormatInt(entry.FeedbackScore.Int64, 10)
+		item["feedback_score"] = dynamoValue{N: &score}
+	}
+	if entry.FeedbackText.Valid {
+		item["feedback_text"] = dynamoString(entry.FeedbackText.String)
+	}
+	if entry.IsFavorite {
+		item["is_favorite"] = dynamoValue{BOOL: &entry.IsFavorite}
+	}
+	if !entry.UpdatedAt.IsZero() {
+		item["updated_at"] = dynamoString(entry.UpdatedAt.UTC().Format(dynamoTimeLayout))
+	}
+	return item, nil
+}
+
+func decodeHistory(item dynamoItem) (*models.PromptHistory, error) {
+	entry := &models.PromptHistory{
+		ID:             item.str("id"),
+		UserID:         item.nullString("user_id"),
+		OriginalInput:  item.str("original_input"),
+		EnhancedOutput: item.str("enhanced_output"),
+		Intent:         item.nullString("intent"),
+		Complexity:     item.nullString("complexity"),
+		FeedbackText:   item.nullString("feedback_text"),
+		TechniquesUsed: []string{},
+	}
+	if favorite := item["is_favorite"].BOOL; favorite != nil {
+		entry.IsFavorite = *favorite
+	}
+	for _, technique := range item["techniques_used"].L {
+		if technique.S != nil {
+			entry.TechniquesUsed = append(entry.TechniquesUsed, *technique.S)
+		}
+	}
+	if score := item["feedback_score"].N; score != nil {
+		value, err := strconv.ParseInt(*score, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feedback_score %q: %w", *score, err)
+		}
+		entry.FeedbackScore = sql.NullInt64{Int64: value, Valid: true}
+	}
+
+	var metadata map[string]interface{}
+	if err := json.Unmarshal([]byte(item.str("metadata")), &metadata); err == nil {
+		entry.Metadata = metadata
+	}
+
+	var err error
+	if entry.CreatedAt, err = item.time("created_at"); err != nil {
+		return nil, fmt.Errorf("invalid created_at: %w", err)
+	}
+	if entry.UpdatedAt, err = item.time("updated_at"); err != nil {
+		return nil, fmt.Errorf("invalid updated_at: %w", err)
+	}
+	return entry, nil
+}
+
+// put writes an entry if condition holds for the item it replaces
+func (s *DynamoHistoryStore) put(ctx context.Context, entry models.PromptHistory, condition string) error {
+	item, err := encodeHistory(entry)
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, "PutItem", map[string]interface{}{
+		"TableName":           s.config.Table,
+		"Item":                item,
+		"ConditionExpression": condition,
+	}, nil)
+}
+
+// SavePromptHistory saves an entry under a new ID
+func (s *DynamoHistoryStore) SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error) {
+	entry.ID = uuid.New().String()
+	entry.CreatedAt = time.Now()
+	entry.UpdatedAt = entry.CreatedAt
+	if err := s.put(ctx, entry, "attribute_not_exists(id)"); err != nil {
+		return "", fmt.Errorf("failed to save prompt history: %w", err)
+	}
+	return entry.ID, nil
+}
+
+// ImportPromptHistory stores an entry as is unless one with its ID exists
+func (s *DynamoHistoryStore) ImportPromptHistory(ctx context.Context, entry models.PromptHistory) error {
+	if entry.UpdatedAt.IsZero() {
+		entry.UpdatedAt = entry.CreatedAt
+	}
+	if err := s.put(ctx, entry, "attribute_not_exists(id)"); err != nil && !isDynamoError(err, "ConditionalCheckFailedException") {
+		return fmt.Errorf("failed to import prompt history: %w", err)
+	}
+	return nil
+}
+
+// GetPromptHistory returns an entry by ID
+func (s *DynamoHistoryStore) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	var output struct {
+		Item dynamoItem `json:"Item"`
+	}
+	err := s.call(ctx, "GetItem", map[string]interface{}{
+		"TableName":      s.config.Table,
+		"Key":            dynamoItem{"id": dynamoString(id)},
+		"ConsistentRead": true,
+	}, &output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get prompt history: %w", err)
+	}
+	if len(output.Item) == 0 {
+		return nil, ErrPromptHistoryNotFound
+	}
+	return decodeHistory(output.Item)
+}
+
+// DeletePromptHistory deletes an entry
+func (s *DynamoHistoryStore) DeletePromptHistory(ctx context.Context, id string) error {
+	var output struct {
+		Attributes dynamoItem `json:"Attributes"`
+	}
+	err := s.call(ctx, "DeleteItem", map[string]interface{}{
+		"TableName":    s.config.Table,
+		"Key":          dynamoItem{"id": dynamoString(id)},
+		"ReturnValues": "ALL_OLD",
+	}, &output)
+	if err != nil {
+		return fmt.Errorf("failed to delete prompt history: %w", err)
+	}
+	if len(output.Attributes) == 0 {
+		return ErrPromptHistoryNotFound
+	}
+	return nil
+}
+
+// UpdatePromptHistory reads an entry, applies update and writes it back,
+// unless it was deleted in between
+func (s *DynamoHistoryStore) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	entry, err := s.GetPromptHistory(ctx, id)
+	if err != nil {
+		return err
+	}
+	update.apply(entry)
+	entry.UpdatedAt = time.Now()
+	if err := s.put(ctx, *entry, "attribute_exists(id)"); err != nil {
+		if isDynamoError(err, "ConditionalCheckFailedException") {
+			return ErrPromptHistoryNotFound
+		}
+		return fmt.Errorf("failed to update prompt history: %w", err)
+	}
+	return nil
+}
+
+// GetUserPromptHistoryWithFilters returns a page of a user's history
+func (s *DynamoHistoryStore) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	return s.userPromptHistory(ctx, userID, "", req)
+}
+
+// GetUserPromptHistoryForProfile returns a page of the history a user made
+// with one enhancement profile
+func (s *DynamoHistoryStore) GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	return s.userPromptHistory(ctx, userID, profile, req)
+}
+
+// userPromptHistory reads all of a user's history from the index and
+// filters it in memory; DynamoDB can neither search text nor count matches
+func (s *DynamoHistoryStore) userPromptHistory(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	var entries []*models.PromptHistory
+	var startKey dynamoItem
+	for {
+		input := map[string]interface{}{
+			"TableName":                 s.config.Table,
+			"IndexName":                 dynamoUserIndex,
+			"KeyConditionExpression":    "user_id = :user_id",
+			"ExpressionAttributeValues": dynamoItem{":user_id": dynamoString(userID)},
+			"ScanIndexForward":          false,
+		}
+		if startKey != nil {
+			input["ExclusiveStartKey"] = startKey
+		}
+		var output struct {
+			Items            []dynamoItem `json:"Items"`
+			LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+		}
+		if err := s.call(ctx, "Query", input, &output); err != nil {
+			return nil, 0, fmt.Errorf("failed to query prompts: %w", err)
+		}
+		for _, item := range output.Items {
+			entry, err := decodeHistory(item)
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to decode prompt %s: %w", item.str("id"), err)
+			}
+			entries = append(entries, entry)
+		}
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		startKey = output.LastEvaluatedKey
+	}
+
+	page, total := filterPromptHistory(entries, profile, req)
+	return page, total, nil
+}
+
+// ScanPromptHistory returns a page of the whole table in key order
+func (s *DynamoHistoryStore) ScanPromptHistory(ctx context.Context, cursor string, limit int) ([]models.PromptHistory, string, error) {
+	input := map[string]interface{}{
+		"TableName": s.config.Table,
+		"Limit":     limit,
+	}
+	if cursor != "" {
+		input["ExclusiveStartKey"] = dynamoItem{"id": dynamoString(cursor)}
+	}
+	var output struct {
+		Items            []dynamoItem `json:"Items"`
+		LastEvaluatedKey dynamoItem   `json:"LastEvaluatedKey"`
+	}
+	if err := s.call(ctx, "Scan", input, &output); err != nil {
+		return nil, "", fmt.Errorf("failed to scan prompt history: %w", err)
+	}
+
+	entries := make([]models.PromptHistory, 0, len(output.Items))
+	for _, item := range output.Items {
+		entry, err := decodeHistory(item)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode prompt %s: %w", item.str("id"), err)
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, output.LastEvaluatedKey.str("id"), nil
+}
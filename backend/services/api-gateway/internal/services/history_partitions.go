@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// historyPartitionPrefix starts the name of every prompts.history partition,
+// followed by its UTC month as YYYY_MM
+const historyPartitionPrefix = "history_p"
+
+// HistoryPartitionConfig controls the monthly partitions of prompts.history
+type HistoryPartitionConfig struct {
+	// Premake is how many months ahead of the current one get partitions
+	Premake int
+	// RetentionMonths is how many months before the current one are kept
+	// attached; older partitions are detached. Zero keeps every partition.
+	RetentionMonths int
+}
+
+// LoadHistoryPartitionConfig reads HISTORY_PARTITION_PREMAKE_MONTHS and
+// HISTORY_PARTITION_RETENTION_MONTHS
+func LoadHistoryPartitionConfig() HistoryPartitionConfig {
+	config := HistoryPartitionConfig{Premake: 3}
+	if v, err := strconv.Atoi(os.Getenv("HISTORY_PARTITION_PREMAKE_MONTHS")); err == nil && v >= 0 {
+		config.Premake = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("HISTORY_PARTITION_RETENTION_MONTHS")); err == nil && v >= 0 {
+		config.RetentionMonths = v
+	}
+	return config
+}
+
+// HistoryPartition is one monthly partition of prompts.history
+type HistoryPartition struct {
+	Name  string    `json:"name"`
+	Month time.Time `json:"month"` // First instant of the UTC month it holds
+}
+
+// HistoryPartitionService keeps prompts.history partitioned by month:
+// partitions are created ahead of the months they hold and detached once
+// they fall out of the retention window. Detached partitions stay in the
+// database as plain tables until an operator drops or archives them.
+type HistoryPartitionService struct {
+	db     *DatabaseService
+	config HistoryPartitionConfig
+	logger *logrus.Logger
+}
+
+// NewHistoryPartitionService creates a new history partition service
+func NewHistoryPartitionService(db *DatabaseService, config HistoryPartitionConfig, logger *logrus.Logger) *HistoryPartitionService {
+	return &HistoryPartitionService{
+		db:     db,
+		config: config,
+		logger: logger,
+	}
+}
+
+// MaintenanceJob returns the daily job creating and detaching partitions
+func (s *HistoryPartitionService) MaintenanceJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "history_partitions",
+		Interval: 24 * time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) error {
+			now := time.Now()
+			if err := s.EnsurePartitions(ctx, now); err != nil {
+				return err
+			}
+			detached, err := s.DetachExpired(ctx, now)
+			if len(detached) > 0 {
+				s.logger.WithField("partitions", detached).Info("Detached expired history partitions")
+			}
+			return err
+		},
+	}
+}
+
+// EnsurePartitions creates the partitions for now's month and the
+// configured number of months after it
+func (s *HistoryPartitionService) EnsurePartitions(ctx context.Context, now time.Time) error {
+	month := historyPartitionMonth(now)
+	for i := 0; i <= s.config.Premake; i++ {
+		if err := createHistoryPartition(ctx, s.db.DB, month.AddDate(0, i, 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Partitions lists the attached partitions, oldest first
+func (s *HistoryPartitionService) Partitions(ctx context.Context) ([]HistoryPartition, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		JOIN pg_namespace n ON n.oid = p.relnamespace
+		WHERE n.nspname = 'prompts' AND p.relname = 'history'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list history partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var partitions []HistoryPartition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan history partition: %w", err)
+		}
+		if month, ok := parseHistoryPartition(name); ok {
+			partitions = append(partitions, HistoryPartition{Name: name, Month: month})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list history partitions: %w", err)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Month.Before(partitions[j].Month) })
+	return partitions, nil
+}
+
+// DetachExpired detaches the partitions past the retention window and
+// returns their names. Nothing is detached when retention is unlimited.
+func (s *HistoryPartitionService) DetachExpired(ctx context.Context, now time.Time) ([]string, error) {
+	if s.config.RetentionMonths <= 0 {
+		return nil, nil
+	}
+	partitions, err := s.Partitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var detached []string
+	for _, partition := range expiredHistoryPartitions(partitions, now, s.config.RetentionMonths) {
+		query := "ALTER TABLE prompts.history DETACH PARTITION prompts." + pq.QuoteIdentifier(partition.Name)
+		if _, err := s.db.DB.ExecContext(ctx, query); err != nil {
+			return detached, fmt.Errorf("failed to detach history partition %s: %w", partition.Name, err)
+		}
+		detached = append(detached, partition.Name)
+	}
+	return detached, nil
+}
+
+// expiredHistoryPartitions returns the partitions whose whole month is
+// before the retention window: the current month and the retention months
+// before it
+func expiredHistoryPartitions(partitions []HistoryPartition, now time.Time, retentionMonths int) []HistoryPartition {
+	cutoff := historyPartitionMonth(now).AddDate(0, -retentionMonths, 0)
+	var expired []HistoryPartition
+	for _, partition := range partitions {
+		if partition.Month.Before(cutoff) {
+			expired = append(expired, partition)
+		}
+	}
+	return expired
+}
+
+// historyPartitionMonth returns the start of t's UTC month, which is the
+// lower bound of the partition holding t
+func historyPartitionMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// historyPartitionName names the partition holding t, matching
+// prompts.create_history_partition
+func historyPartitionName(t time.Time) string {
+	return historyPartitionPrefix + historyPartitionMonth(t).Format("2006_01")
+}
+
+// parseHistoryPartition returns the month a partition name holds
+func parseHistoryPartition(name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, historyPartitionPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	month, err := time.Parse("2006_01", suffix)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// historyExecer runs statements against prompts.history, on the pool, a
+// transaction or the statement cache
+type historyExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// createHistoryPartition creates the partition holding t if it is missing
+func createHistoryPartition(ctx context.Context, db historyExecer, t time.Time) error {
+	if _, err := db.ExecContext(ctx, `SELECT prompts.create_history_partition($1)`, historyPartitionMonth(t)); err != nil {
+		return fmt.Errorf("failed to create history partition %s: %w", historyPartitionName(t), err)
+	}
+	return nil
+}
+
+// isMissingHistoryPartition reports whether an insert failed because no
+// partition holds a row's created_at
+func isMissingHistoryPartition(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23514" && strings.Contains(pqErr.Message, "no partition")
+}
+
+// insertHistory runs an insert into prompts.history. Postgres routes each
+// row to the partition for its created_at; when one of them is missing, as
+// for imported or backdated entries, the partitions for createdAt are made
+// and the insert retried once. It can't recover inside a transaction, which
+// the failed insert has aborted.
+func insertHistory(ctx context.Context, db historyExecer, createdAt []time.Time, insert func() error) error {
+	err := insert()
+	if !isMissingHistoryPartition(err) {
+		return err
+	}
+	made := make(map[string]bool)
+	for _, t := range createdAt {
+		if name := historyPartitionName(t); !made[name] {
+			if err := createHistoryPartition(ctx, db, t); err != nil {
+				return err
+			}
+			made[name] = true
+		}
+	}
+	return insert()
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryPartitionBoundaries(t *testing.T) {
+	tests := []struct {
+		name string
+		at   time.Time
+		want string
+	}{
+		{"first instant of a month", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), "history_p2024_02"},
+		{"last instant of a month", time.Date(2024, 1, 31, 23, 59, 59, 999999000, time.UTC), "history_p2024_01"},
+		{"leap day", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), "history_p2024_02"},
+		{"year end", time.Date(2023, 12, 31, 23, 59, 59, 0, time.UTC), "history_p2023_12"},
+		{"new year", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "history_p2024_01"},
+		// Partitions hold UTC months whatever the writer's zone
+		{"local evening that is already next month in UTC", time.Date(2024, 3, 31, 20, 30, 0, 0, time.FixedZone("EDT", -4*3600)), "history_p2024_04"},
+		{"local morning still last month in UTC", time.Date(2024, 4, 1, 8, 0, 0, 0, time.FixedZone("JST", 9*3600)), "history_p2024_03"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, historyPartitionName(tt.at))
+
+			month, ok := parseHistoryPartition(tt.want)
+			require.True(t, ok)
+			assert.True(t, month.Equal(historyPartitionMonth(tt.at)))
+			assert.False(t, tt.at.Before(month), "lower bound is inclusive")
+			assert.True(t, tt.at.Before(month.AddDate(0, 1, 0)), "upper bound is exclusive")
+		})
+	}
+
+	for _, name := range []string{"history", "history_p2024", "history_p2024_13", "history_unpartitioned"} {
+		_, ok := parseHistoryPartition(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestExpiredHistoryPartitions(t *testing.T) {
+	var partitions []HistoryPartition
+	for month := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC); month.Before(time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)); month = month.AddDate(0, 1, 0) {
+		partitions = append(partitions, HistoryPartition{Name: historyPartitionName(month), Month: month})
+	}
+	names := func(partitions []HistoryPartition) []string {
+		var out []string
+		for _, p := range partitions {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+
+	// Keeping the current month and three before it
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"history_p2023_10", "history_p2023_11"}, names(expiredHistoryPartitions(partitions, now, 3)))
+	// The last instant of the month expires nothing more
+	now = time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC)
+	assert.Equal(t, []string{"history_p2023_10", "history_p2023_11"}, names(expiredHistoryPartitions(partitions, now, 3)))
+	// The next month expires the next partition
+	now = time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"history_p2023_10", "history_p2023_11", "history_p2023_12"}, names(expiredHistoryPartitions(partitions, now, 3)))
+	// Across a year end
+	now = time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"history_p2023_10", "history_p2023_11"}, names(expiredHistoryPartitions(partitions, now, 1)))
+}
+
+func TestEnsureHistoryPartitions(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	service := NewHistoryPartitionService(&DatabaseService{DB: db.DB}, HistoryPartitionConfig{Premake: 2}, nil)
+
+	require.NoError(t, service.EnsurePartitions(context.Background(), time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC)))
+
+	require.Len(t, d.entries(), 3)
+	var months []time.Time
+	for _, args := range d.args {
+		months = append(months, args[0].Value.(time.Time))
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, months)
+}
+
+func TestHistoryBatchCreatesMissingPartitions(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	missing := true
+	d.fail = func(query string) error {
+		if strings.Contains(query, "INSERT INTO prompts.history") && missing {
+			missing = false
+			return &pq.Error{Code: "23514", Message: `no partition of relation "history" found for row`}
+		}
+		return nil
+	}
+	repo := &historyRepo{db: db}
+
+	batch := []*models.PromptHistory{
+		{OriginalInput: "a", CreatedAt: time.Date(2019, 1, 31, 23, 59, 59, 0, time.UTC)},
+		{OriginalInput: "b", CreatedAt: time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{OriginalInput: "c", CreatedAt: time.Date(2019, 2, 14, 0, 0, 0, 0, time.UTC)},
+	}
+	require.NoError(t, repo.SavePromptHistoryBatch(context.Background(), batch))
+
+	log := d.entries()
+	require.Len(t, log, 3, "a partition per distinct month, then the retried insert")
+	assert.Contains(t, log[0], "prompts.create_history_partition")
+	assert.Equal(t, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), d.args[0][0].Value)
+	assert.Equal(t, time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC), d.args[1][0].Value)
+	assert.Contains(t, log[2], "INSERT INTO prompts.history")
+
+	t.Run("other errors aren't retried", func(t *testing.T) {
+		d.fail = func(string) error { return &pq.Error{Code: "23514", Message: "new row violates check constraint"} }
+		assert.Error(t, repo.SavePromptHistoryBatch(context.Background(), []*models.PromptHistory{{OriginalInput: "d"}}))
+		assert.Len(t, d.entries(), 3)
+	})
+}
+
+// Rows either side of a month boundary land in their own partitions, created
+// on demand for months too old to have been made ahead of time
+func TestPostgresHistoryPartitionRouting(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	ctx := context.Background()
+	service := NewDatabaseService(db)
+
+	lastOfMonth := time.Date(1999, 12, 31, 23, 59, 59, 999999000, time.UTC)
+	firstOfMonth := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := map[string]time.Time{
+		uuid.New().String(): lastOfMonth,
+		uuid.New().String(): firstOfMonth,
+	}
+	t.Cleanup(func() {
+		for id := range entries {
+			db.Exec(`DELETE FROM prompts.history WHERE id = $1`, id)
+		}
+	})
+
+	for id, createdAt := range entries {
+		require.NoError(t, service.ImportPromptHistory(ctx, models.PromptHistory{
+			ID: id, OriginalInput: "boundary", EnhancedOutput: "boundary", CreatedAt: createdAt,
+		}))
+
+		var partition string
+		require.NoError(t, db.QueryRow(`SELECT tableoid::regclass::text FROM prompts.history WHERE id = $1`, id).Scan(&partition))
+		assert.Equal(t, "prompts."+historyPartitionName(createdAt), partition)
+
+		entry, err := service.GetPromptHistory(ctx, id)
+		require.NoError(t, err)
+		assert.True(t, entry.CreatedAt.Equal(createdAt))
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+)
+
+// WrapHistory returns store, the default region's, routing each
+// organization's history to the store of its residency region. Entries are
+// written to the owner's region, and only read, listed or deleted for callers
+// whose organization is in the same region; reads across regions get
+// ErrPromptHistoryNotFound. Calls without a RequestContext are the gateway's
+// own and look in every region. Scans and imports stay on store.
+func (s *ResidencyService) WrapHistory(store HistoryStore, accounts *AccountResolver) HistoryStore {
+	wrapped := &residencyHistoryStore{HistoryStore: store, residency: s}
+	if accounts != nil {
+		wrapped.accounts = accounts
+	}
+	return wrapped
+}
+
+// residencyHistoryStore is a HistoryStore keeping history in its region
+type residencyHistoryStore struct {
+	HistoryStore
+	residency *ResidencyService
+	accounts  accountLookup
+}
+
+// SavePromptHistory writes an entry to its owner's region
+func (s *residencyHistoryStore) SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error) {
+	region, err := s.userRegion(ctx, entry.UserID.String)
+	if err != nil {
+		return "", err
+	}
+	store, err := s.store(region)
+	if err != nil {
+		return "", err
+	}
+	return store.SavePromptHistory(ctx, entry)
+}
+
+// GetPromptHistory returns an entry from the caller's region
+func (s *residencyHistoryStore) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	entry, _, err := s.find(ctx, id)
+	return entry, err
+}
+
+// GetUserPromptHistoryWithFilters lists a user's history from their region,
+// empty for callers in another region
+func (s *residencyHistoryStore) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	store, ok, err := s.userStore(ctx, userID)
+	if err != nil || !ok {
+		return []*models.PromptHistory{}, 0, err
+	}
+	return store.GetUserPromptHistoryWithFilters(ctx, userID, req)
+}
+
+// GetUserPromptHistoryForProfile lists a user's history under a profile,
+// scoped like GetUserPromptHistoryWithFilters
+func (s *residencyHistoryStore) GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	store, ok, err := s.userStore(ctx, userID)
+	if err != nil || !ok {
+		return []*models.PromptHistory{}, 0, err
+	}
+	return store.GetUserPromptHistoryForProfile(ctx, userID, profile, req)
+}
+
+// DeletePromptHistory deletes an entry from the caller's region
+func (s *residencyHistoryStore) DeletePromptHistory(ctx context.Context, id string) error {
+	_, store, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return store.DeletePromptHistory(ctx, id)
+}
+
+// UpdatePromptHistory changes an entry in the caller's region
+func (s *residencyHistoryStore) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	_, store, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	return store.UpdatePromptHistory(ctx, id, update)
+}
+
+// find returns an entry and the store holding it. Callers only see their own
+// region; the gateway looks in every region, the default first.
+func (s *residencyHistoryStore) find(ctx context.Context, id string) (*models.PromptHistory, HistoryStore, error) {
+	regions := s.residency.AvailableRegions()
+	if rc := RequestContextFrom(ctx); rc != nil {
+		region, err := s.residency.Region(ctx, rc.OrgID)
+		if err != nil {
+			return nil, nil, err
+		}
+		regions = []string{region}
+	}
+
+	for _, region := range regions {
+		store, err := s.store(region)
+		if err != nil {
+			return nil, nil, err
+		}
+		entry, err := store.GetPromptHistory(ctx, id)
+		if errors.Is(err, ErrPromptHistoryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return entry, store, nil
+	}
+	return nil, nil, ErrPromptHistoryNotFound
+}
+
+// userStore returns the store of a user's region, and false when the caller
+// is in another region
+func (s *residencyHistoryStore) userStore(ctx context.Context, userID string) (HistoryStore, bool, error) {
+	region, err := s.userRegion(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if rc := RequestContextFrom(ctx); rc != nil {
+		callerRegion, err := s.residency.Region(ctx, rc.OrgID)
+		if err != nil {
+			return nil, false, err
+		}
+		if callerRegion != region {
+			return nil, false, nil
+		}
+	}
+	store, err := s.store(region)
+	return store, err == nil, err
+}
+
+// userRegion returns the region of a user's organization
+func (s *residencyHistoryStore) userRegion(ctx context.Context, userID string) (string, error) {
+	if userID == "" || s.accounts == nil {
+		return s.residency.defaultRegion, nil
+	}
+	account, err := s.accounts.Resolve(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve history owner: %w", err)
+	}
+	return s.residency.Region(ctx, account.OrgID)
+}
+
+// store returns a region's store, refusing regions not configured here
+// rather than falling back to another region
+func (s *residencyHistoryStore) store(region string) (HistoryStore, error) {
+	if region == s.residency.defaultRegion {
+		return s.HistoryStore, nil
+	}
+	if store, ok := s.residency.regional[region]; ok {
+		return store, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrResidencyUnavailable, region)
+}
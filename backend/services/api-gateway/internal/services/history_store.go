@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+)
+
+// ErrPromptHistoryNotFound is returned for history entries that don't exist
+var ErrPromptHistoryNotFound = errors.New("prompt history not found")
+
+// HistoryStore persists users' prompt history. Postgres (DatabaseService) is
+// the default; DynamoHistoryStore keeps it in DynamoDB for deployments that
+// want document storage. Reports, analytics and training curation query
+// Postgres directly and don't see history kept elsewhere.
+type HistoryStore interface {
+	SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error)
+	GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error)
+	GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error)
+	GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error)
+	DeletePromptHistory(ctx context.Context, id string) error
+	// UpdatePromptHistory applies update to an entry. Archived entries
+	// can't be changed and are reported not found.
+	UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error
+
+	// ScanPromptHistory returns up to limit entries after cursor in an order
+	// of the store's choosing, and the cursor of the next page, empty after
+	// the last one. Used to copy history between stores.
+	ScanPromptHistory(ctx context.Context, cursor string, limit int) ([]models.PromptHistory, string, error)
+	// ImportPromptHistory stores an entry as is, keeping its ID and
+	// timestamps. Entries already present are left alone.
+	ImportPromptHistory(ctx context.Context, entry models.PromptHistory) error
+}
+
+// HistoryUpdate is a change to a stored history entry; fields left zero
+// are left alone
+type HistoryUpdate struct {
+	Favorite *bool
+	AddTags  []string // Merged into metadata.tags, sorted and without duplicates
+	UserID   string   // Gives the entry to another user
+}
+
+// apply applies the update to an entry in memory, for stores that can't
+// update in place
+func (u HistoryUpdate) apply(entry *models.PromptHistory) {
+	if u.UserID != "" {
+		entry.UserID = sql.NullString{String: u.UserID, Valid: true}
+	}
+	if u.Favorite != nil {
+		entry.IsFavorite = *u.Favorite
+	}
+	if len(u.AddTags) > 0 {
+		tags := append([]string(nil), u.AddTags...)
+		switch existing := entry.Metadata["tags"].(type) {
+		case []string:
+			tags = append(tags, existing...)
+		case []interface{}:
+			for _, tag := range existing {
+				if tag, ok := tag.(string); ok {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		slices.Sort(tags)
+		if entry.Metadata == nil {
+			entry.Metadata = map[string]interface{}{}
+		}
+		entry.Metadata["tags"] = slices.Compact(tags)
+	}
+}
+
+var (
+	_ HistoryStore = (*DatabaseService)(nil)
+	_ HistoryStore = (*DynamoHistoryStore)(nil)
+)
+
+// NewHistoryStoreFromEnv returns the store selected by HISTORY_STORE:
+// "postgres" (the default), which is db itself, or "dynamodb"
+func NewHistoryStoreFromEnv(db *DatabaseService) (HistoryStore, error) {
+	switch backend := getEnv("HISTORY_STORE", "postgres"); backend {
+	case "postgres":
+		return db, nil
+	case "dynamodb":
+		return NewDynamoHistoryStore(LoadDynamoConfig())
+	default:
+		return nil, fmt.Errorf("unknown history store %q", backend)
+	}
+}
+
+// MigrateHistory copies every entry from one store to another in batches,
+// calling progress with the running total after each. Entries already in the
+// destination are skipped, so an interrupted migration can be rerun.
+func MigrateHistory(ctx context.Context, from, to HistoryStore, batchSize int, progress func(copied int)) (int, error) {
+	copied, cursor := 0, ""
+	for {
+		entries, next, err := from.ScanPromptHistory(ctx, cursor, batchSize)
+		if err != nil {
+			return copied, fmt.Errorf("failed to read history after %q: %w", cursor, err)
+		}
+		for _, entry := range entries {
+			if err := to.ImportPromptHistory(ctx, entry); err != nil {
+				return copied, fmt.Errorf("failed to import history %s: %w", entry.ID, err)
+			}
+			copied++
+		}
+		if progress != nil && len(entries) > 0 {
+			progress(copied)
+		}
+		if next == "" {
+			return copied, nil
+		}
+		cursor = next
+	}
+}
+
+// filterPromptHistory applies a history page request to a user's entries
+// the way the Postgres store's query does, for stores that can't filter,
+// sort and count server-side. It returns the page and the matching total.
+func filterPromptHistory(entries []*models.PromptHistory, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64) {
+	search := strings.ToLower(req.Search)
+	matched := make([]*models.PromptHistory, 0, len(entries))
+	for _, entry := range entries {
+		if profile != "" {
+			if value, _ := entry.Metadata["profile"].(string); value != profile {
+				continue
+			}
+		}
+		if search != "" && !strings.Contains(strings.ToLower(entry.OriginalInput), search) &&
+			!strings.Contains(strings.ToLower(entry.EnhancedOutput), search) {
+			continue
+		}
+		if req.Technique != "" && !slices.Contains(entry.TechniquesUsed, req.Technique) {
+			continue
+		}
+		if !req.DateFrom.IsZero() && entry.CreatedAt.Before(req.DateFrom) {
+			continue
+		}
+		if !req.DateTo.IsZero() && entry.CreatedAt.After(req.DateTo) {
+			continue
+		}
+		matched = append(matched, entry)
+	}
+
+	ascending := strings.EqualFold(req.SortDirection, "ASC")
+	sort.SliceStable(matched, func(i, j int) bool {
+		if ascending {
+			return historyLess(matched[i], matched[j], req.SortBy)
+		}
+		return historyLess(matched[j], matched[i], req.SortBy)
+	})
+
+	total := int64(len(matched))
+	offset := req.CalculateOffset()
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(matched) || req.Limit <= 0 {
+		return []*models.PromptHistory{}, total
+	}
+	end := offset + req.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	return matched[offset:end], total
+}
+
+// historyLess orders entries by one of historyOrderColumns, falling back to
+// created_at
+func historyLess(a, b *models.PromptHistory, sortBy string) bool {
+	switch sortBy {
+	case "updated_at":
+		return a.UpdatedAt.Before(b.UpdatedAt)
+	case "feedback_score":
+		return a.FeedbackScore.Int64 < b.FeedbackScore.Int64
+	case "intent":
+		return a.Intent.String < b.Intent.String
+	default:
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+}
+
+// LoadDynamoConfig reads the DynamoDB history store settings
+func LoadDynamoConfig() DynamoConfig {
+	return DynamoConfig{
+		Table:     getEnv("DYNAMODB_HISTORY_TABLE", "prompt_history"),
+		Region:    getEnv("DYNAMODB_REGION", getEnv("AWS_REGION", "us-east-1")),
+		Endpoint:  os.Getenv("DYNAMODB_ENDPOINT"),
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDynamo serves the subset of the DynamoDB API the history store uses,
+// for the expressions it sends
+type fakeDynamo struct {
+	mu       sync.Mutex
+	created  bool
+	items    map[string]dynamoItem
+	pageSize int // Query and Scan page size when the request sets none
+}
+
+func newFakeDynamo(t *testing.T) *DynamoHistoryStore {
+	fake := &fakeDynamo{items: map[string]dynamoItem{}, pageSize: 2}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	store, err := NewDynamoHistoryStore(DynamoConfig{
+		Table:     "prompt_history",
+		Region:    "us-east-1",
+		Endpoint:  server.URL,
+		AccessKey: "test",
+		SecretKey: "test",
+	})
+	require.NoError(t, err)
+	return store
+}
+
+func (f *fakeDynamo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Item                      dynamoItem
+		Key                       dynamoItem
+		ExclusiveStartKey         dynamoItem
+		ExpressionAttributeValues dynamoItem
+		ConditionExpression       string
+		Limit                     int
+	}
+	json.NewDecoder(r.Body).Decode(&input)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "#MissingAuthenticationTokenException"})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(exception string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#" + exception, "message": exception})
+	}
+	respond := func(output interface{}) {
+		json.NewEncoder(w).Encode(output)
+	}
+	// page returns a page of items sorted by less, after the start key
+	page := func(items []dynamoItem, less func(a, b dynamoItem) bool) map[string]interface{} {
+		sort.Slice(items, func(i, j int) bool { return less(items[i], items[j]) })
+		start := 0
+		if input.ExclusiveStartKey != nil {
+			for i, item := range items {
+				if item.str("id") == input.ExclusiveStartKey.str("id") {
+					start = i + 1
+				}
+			}
+		}
+		size := input.Limit
+		if size == 0 {
+			size = f.pageSize
+		}
+		end := start + size
+		output := map[string]interface{}{}
+		if end < len(items) {
+			output["LastEvaluatedKey"] = dynamoItem{"id": items[end-1]["id"]}
+		} else {
+			end = len(items)
+		}
+		output["Items"] = items[start:end]
+		return output
+	}
+
+	switch action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810."); action {
+	case "DescribeTable":
+		if !f.created {
+			fail("ResourceNotFoundException")
+			return
+		}
+		respond(map[string]interface{}{"Table": map[string]string{"TableStatus": "ACTIVE"}})
+	case "CreateTable":
+		f.created = true
+		respond(map[string]interface{}{})
+	case "PutItem":
+		id := input.Item.str("id")
+		_, exists := f.items[id]
+		if exists && input.ConditionExpression == "attribute_not_exists(id)" ||
+			!exists && input.ConditionExpression == "attribute_exists(id)" {
+			fail("ConditionalCheckFailedException")
+			return
+		}
+		f.items[id] = input.Item
+		respond(map[string]interface{}{})
+	case "GetItem":
+		respond(map[string]interface{}{"Item": f.items[input.Key.str("id")]})
+	case "DeleteItem":
+		id := input.Key.str("id")
+		old := f.items[id]
+		delete(f.items, id)
+		respond(map[string]interface{}{"Attributes": old})
+	case "Query":
+		userID := input.ExpressionAttributeValues.str(":user_id")
+		var items []dynamoItem
+		for _, item := range f.items {
+			if item.str("user_id") == userID {
+				items = append(items, item)
+			}
+		}
+		respond(page(items, func(a, b dynamoItem) bool { return a.str("created_at") > b.str("created_at") }))
+	case "Scan":
+		var items []dynamoItem
+		for _, item := range f.items {
+			items = append(items, item)
+		}
+		respond(page(items, func(a, b dynamoItem) bool { return a.str("id") < b.str("id") }))
+	default:
+		fail("UnknownOperationException")
+	}
+}
+
+// testHistoryStoreParity checks the behavior every history store must
+// share, for entries of userID
+func testHistoryStoreParity(t *testing.T, store HistoryStore, userID string) {
+	ctx := context.Background()
+	user := sql.NullString{String: userID, Valid: true}
+	entry := func(input string, techniques []string, profile string) models.PromptHistory {
+		e := models.PromptHistory{
+			UserID:         user,
+			OriginalInput:  input,
+			EnhancedOutput: "Enhanced: " + input,
+			Intent:         sql.NullString{String: "code_generation", Valid: true},
+			Complexity:     sql.NullString{String: "moderate", Valid: true},
+			TechniquesUsed: techniques,
+			Metadata:       map[string]interface{}{"tokens": float64(42)},
+		}
+		if profile != "" {
+			e.Metadata["profile"] = profile
+		}
+		return e
+	}
+
+	var ids []string
+	for _, e := range []models.PromptHistory{
+		entry("Write a sorting function", []string{"chain_of_thought"}, ""),
+		entry("Explain QUICKSORT", []string{"few_shot", "chain_of_thought"}, "teaching"),
+		entry("Summarize this article", []string{"structured_output"}, "teaching"),
+	} {
+		id, err := store.SavePromptHistory(ctx, e)
+		require.NoError(t, err)
+		ids = append(ids, id)
+		time.Sleep(5 * time.Millisecond) // Distinct creation times
+	}
+
+	t.Run("get", func(t *testing.T) {
+		got, err := store.GetPromptHistory(ctx, ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, ids[1], got.ID)
+		assert.Equal(t, userID, got.UserID.String)
+		assert.Equal(t, "Explain QUICKSORT", got.OriginalInput)
+		assert.Equal(t, "Enhanced: Explain QUICKSORT", got.EnhancedOutput)
+		assert.Equal(t, "code_generation", got.Intent.String)
+		assert.Equal(t, "moderate", got.Complexity.String)
+		assert.Equal(t, []string{"few_shot", "chain_of_thought"}, got.TechniquesUsed)
+		assert.Equal(t, "teaching", got.Metadata["profile"])
+		assert.Equal(t, float64(42), got.Metadata["tokens"])
+		assert.WithinDuration(t, time.Now(), got.CreatedAt, time.Minute)
+
+		_, err = store.GetPromptHistory(ctx, uuid.New().String())
+		assert.ErrorIs(t, err, ErrPromptHistoryNotFound)
+	})
+
+	t.Run("list newest first with totals", func(t *testing.T) {
+		page, total, err := store.GetUserPromptHistoryWithFilters(ctx, userID, models.PaginationRequest{Page: 1, Limit: 2, SortBy: "created_at", SortDirection: "DESC"})
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+		require.Len(t, page, 2)
+		assert.Equal(t, ids[2], page[0].ID)
+		assert.Equal(t, ids[1], page[1].ID)
+
+		page, _, err = store.GetUserPromptHistoryWithFilters(ctx, userID, models.PaginationRequest{Page: 2, Limit: 2, SortBy: "created_at", SortDirection: "DESC"})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, ids[0], page[0].ID)
+
+		page, _, err = store.GetUserPromptHistoryWithFilters(ctx, userID, models.PaginationRequest{Page: 1, Limit: 10, SortBy: "created_at", SortDirection: "ASC"})
+		require.NoError(t, err)
+		require.Len(t, page, 3)
+		assert.Equal(t, ids[0], page[0].ID)
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		list := func(req models.PaginationRequest) []string {
+			req.Page, req.Limit = 1, 10
+			page, total, err := store.GetUserPromptHistoryWithFilters(ctx, userID, req)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(page)), total)
+			var got []string
+			for _, e := range page {
+				got = append(got, e.ID)
+			}
+			return got
+		}
+
+		assert.ElementsMatch(t, []string{ids[1]}, list(models.PaginationRequest{Search: "quicksort"}), "search ignores case")
+		assert.ElementsMatch(t, []string{ids[2]}, list(models.PaginationRequest{Search: "enhanced: summarize"}), "search covers the output")
+		assert.ElementsMatch(t, []string{ids[0], ids[1]}, list(models.PaginationRequest{Technique: "chain_of_thought"}))
+		assert.Empty(t, list(models.PaginationRequest{DateFrom: time.Now().Add(time.Hour)}))
+
+		page, total, err := store.GetUserPromptHistoryForProfile(ctx, userID, "teaching", models.PaginationRequest{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Len(t, page, 2)
+	})
+
+	t.Run("import keeps IDs and skips duplicates", func(t *testing.T) {
+		imported := entry("Imported prompt", []string{"zero_shot"}, "")
+		imported.ID = uuid.New().String()
+		imported.CreatedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		imported.FeedbackScore = sql.NullInt64{Int64: 4, Valid: true}
+		require.NoError(t, store.ImportPromptHistory(ctx, imported))
+
+		changed := imported
+		changed.OriginalInput = "Overwritten"
+		require.NoError(t, store.ImportPromptHistory(ctx, changed))
+
+		got, err := store.GetPromptHistory(ctx, imported.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Imported prompt", got.OriginalInput)
+		assert.True(t, imported.CreatedAt.Equal(got.CreatedAt))
+		assert.Equal(t, int64(4), got.FeedbackScore.Int64)
+		ids = append(ids, imported.ID)
+	})
+
+	t.Run("scan visits every entry", func(t *testing.T) {
+		seen := map[string]bool{}
+		cursor := ""
+		for {
+			entries, next, err := store.ScanPromptHistory(ctx, cursor, 2)
+			require.NoError(t, err)
+			for _, e := range entries {
+				seen[e.ID] = true
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		for _, id := range ids {
+			assert.True(t, seen[id], id)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		favorite := true
+		require.NoError(t, store.UpdatePromptHistory(ctx, ids[1], HistoryUpdate{Favorite: &favorite}))
+		require.NoError(t, store.UpdatePromptHistory(ctx, ids[1], HistoryUpdate{AddTags: []string{"sorting", "algorithms"}}))
+		require.NoError(t, store.UpdatePromptHistory(ctx, ids[1], HistoryUpdate{AddTags: []string{"sorting"}}))
+
+		got, err := store.GetPromptHistory(ctx, ids[1])
+		require.NoError(t, err)
+		assert.True(t, got.IsFavorite)
+		assert.Equal(t, []interface{}{"algorithms", "sorting"}, got.Metadata["tags"])
+		assert.Equal(t, "teaching", got.Metadata["profile"], "other metadata is kept")
+		assert.Equal(t, "Explain QUICKSORT", got.OriginalInput)
+
+		assert.ErrorIs(t, store.UpdatePromptHistory(ctx, uuid.New().String(), HistoryUpdate{Favorite: &favorite}), ErrPromptHistoryNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, store.DeletePromptHistory(ctx, ids[0]))
+		_, err := store.GetPromptHistory(ctx, ids[0])
+		assert.ErrorIs(t, err, ErrPromptHistoryNotFound)
+		assert.ErrorIs(t, store.DeletePromptHistory(ctx, ids[0]), ErrPromptHistoryNotFound)
+	})
+}
+
+func TestDynamoHistoryStoreParity(t *testing.T) {
+	t.Run("fake", func(t *testing.T) {
+		store := newFakeDynamo(t)
+		require.NoError(t, store.EnsureTable(context.Background()))
+		testHistoryStoreParity(t, store, uuid.New().String())
+	})
+
+	t.Run("dynamodb local", func(t *testing.T) {
+		endpoint := os.Getenv("TEST_DYNAMODB_ENDPOINT")
+		if endpoint == "" {
+			t.Skip("TEST_DYNAMODB_ENDPOINT not set")
+		}
+		store, err := NewDynamoHistoryStore(DynamoConfig{
+			Table:     "prompt_history_test",
+			Region:    "us-east-1",
+			Endpoint:  endpoint,
+			AccessKey: "test",
+			SecretKey: "test",
+		})
+		require.NoError(t, err)
+		require.NoError(t, store.EnsureTable(context.Background()))
+		testHistoryStoreParity(t, store, uuid.New().String())
+	})
+}
+
+func TestPostgresHistoryStoreParity(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	userID := uuid.New().String()
+	_, err = db.Exec(`INSERT INTO auth.users (id, email, username, password_hash) VALUES ($1, $2, $3, 'x')`,
+		userID, userID+"@example.com", "parity-"+userID[:8])
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM prompts.history WHERE user_id = $1`, userID)
+		db.Exec(`DELETE FROM auth.users WHERE id = $1`, userID)
+	})
+
+	testHistoryStoreParity(t, NewDatabaseService(db), userID)
+}
+
+func TestMigrateHistory(t *testing.T) {
+	ctx := context.Background()
+	from, to := newFakeDynamo(t), newFakeDynamo(t)
+
+	var ids []string
+	for _, input := range []string{"one", "two", "three", "four", "five"} {
+		id, err := from.SavePromptHistory(ctx, models.PromptHistory{
+			UserID:        sql.NullString{String: "user-1", Valid: true},
+			OriginalInput: input,
+		})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	var progress []int
+	copied, err := MigrateHistory(ctx, from, to, 2, func(n int) { progress = append(progress, n) })
+	require.NoError(t, err)
+	assert.Equal(t, 5, copied)
+	assert.Equal(t, []int{2, 4, 5}, progress)
+
+	for _, id := range ids {
+		original, err := from.GetPromptHistory(ctx, id)
+		require.NoError(t, err)
+		migrated, err := to.GetPromptHistory(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, original, migrated)
+	}
+
+	copied, err = MigrateHistory(ctx, from, to, 2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 5, copied, "rerunning skips entries already copied")
+}
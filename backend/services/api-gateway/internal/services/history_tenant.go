@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+)
+
+// ErrHistorySearchUnsupported is returned for text searches of an isolated
+// organization's member's history, whose prompts are sealed
+var ErrHistorySearchUnsupported = errors.New("history search is not supported for isolated organizations")
+
+// WrapHistory returns store with isolated organizations' history encrypted
+// under their data keys and scoped to them. Entries of an isolated
+// organization's members are sealed when saved, and only read, listed or
+// deleted for the owner or callers in the same organization; anyone else,
+// platform admins and share links included, gets ErrPromptHistoryNotFound.
+// Calls without a RequestContext are the gateway's own and aren't scoped.
+// Sealed text can't be searched, so searching an isolated organization's
+// member's history fails with ErrHistorySearchUnsupported.
+func (s *TenantKeyService) WrapHistory(store HistoryStore, accounts *AccountResolver) HistoryStore {
+	wrapped := &tenantHistoryStore{HistoryStore: store, keys: s}
+	if accounts != nil {
+		wrapped.accounts = accounts
+	}
+	return wrapped
+}
+
+// tenantHistoryStore is a HistoryStore isolating organizations' history
+type tenantHistoryStore struct {
+	HistoryStore
+	keys     tenantKeys
+	accounts accountLookup
+}
+
+// tenantKeys is the part of TenantKeyService tenantHistoryStore uses
+type tenantKeys interface {
+	Isolated(ctx context.Context, orgID string) (bool, error)
+	Seal(ctx context.Context, orgID, plaintext string) (string, error)
+	Open(ctx context.Context, orgID, value string) (string, error)
+}
+
+// SavePromptHistory seals the prompt and its enhancement for isolated
+// organizations' members
+func (s *tenantHistoryStore) SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error) {
+	orgID, isolated, err := s.tenant(ctx, entry.UserID.String)
+	if err != nil {
+		return "", err
+	}
+	if isolated {
+		if entry.OriginalInput, err = s.keys.Seal(ctx, orgID, entry.OriginalInput); err != nil {
+			return "", fmt.Errorf("failed to seal prompt history: %w", err)
+		}
+		if entry.EnhancedOutput, err = s.keys.Seal(ctx, orgID, entry.EnhancedOutput); err != nil {
+			return "", fmt.Errorf("failed to seal prompt history: %w", err)
+		}
+	}
+	return s.HistoryStore.SavePromptHistory(ctx, entry)
+}
+
+// GetPromptHistory returns an entry the caller's organization may read
+func (s *tenantHistoryStore) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	entry, err := s.HistoryStore.GetPromptHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reveal(ctx, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// GetUserPromptHistoryWithFilters lists a user's history, empty for callers
+// outside the user's isolated organization
+func (s *tenantHistoryStore) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	if err := s.checkSearch(ctx, userID, req); err != nil {
+		return nil, 0, err
+	}
+	entries, total, err := s.HistoryStore.GetUserPromptHistoryWithFilters(ctx, userID, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.revealAll(ctx, entries, total)
+}
+
+// GetUserPromptHistoryForProfile lists a user's history under a profile,
+// scoped like GetUserPromptHistoryWithFilters
+func (s *tenantHistoryStore) GetUserPromptHistoryForProfile(ctx context.Context, userID, profile string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	if err := s.checkSearch(ctx, userID, req); err != nil {
+		return nil, 0, err
+	}
+	entries, total, err := s.HistoryStore.GetUserPromptHistoryForProfile(ctx, userID, profile, req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s.revealAll(ctx, entries, total)
+}
+
+// DeletePromptHistory deletes an entry the caller's organization may read
+func (s *tenantHistoryStore) DeletePromptHistory(ctx context.Context, id string) error {
+	if RequestContextFrom(ctx) != nil {
+		if _, err := s.GetPromptHistory(ctx, id); err != nil {
+			return err
+		}
+	}
+	return s.HistoryStore.DeletePromptHistory(ctx, id)
+}
+
+// checkSearch refuses text searches of an isolated organization's member's
+// history, which would never match the sealed text
+func (s *tenantHistoryStore) checkSearch(ctx context.Context, userID string, req models.PaginationRequest) error {
+	if req.Search == "" {
+		return nil
+	}
+	_, isolated, err := s.tenant(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if isolated {
+		return ErrHistorySearchUnsupported
+	}
+	return nil
+}
+
+// UpdatePromptHistory changes an entry the caller's organization may read
+func (s *tenantHistoryStore) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	if RequestContextFrom(ctx) != nil {
+		if _, err := s.GetPromptHistory(ctx, id); err != nil {
+			return err
+		}
+	}
+	return s.HistoryStore.UpdatePromptHistory(ctx, id, update)
+}
+
+// revealAll reveals a page of one user's entries. A caller refused one of
+// them is refused all of them.
+func (s *tenantHistoryStore) revealAll(ctx context.Context, entries []*models.PromptHistory, total int64) ([]*models.PromptHistory, int64, error) {
+	for _, entry := range entries {
+		if err := s.reveal(ctx, entry); err != nil {
+			if errors.Is(err, ErrPromptHistoryNotFound) {
+				return []*models.PromptHistory{}, 0, nil
+			}
+			return nil, 0, err
+		}
+	}
+	return entries, total, nil
+}
+
+// reveal checks the caller may read an entry and opens its sealed text. A
+// sealed entry belongs to the organization it was sealed for, even if its
+// owner has since left it.
+func (s *tenantHistoryStore) reveal(ctx context.Context, entry *models.PromptHistory) error {
+	owner := entry.UserID.String
+	orgID, sealed := sealedTenant(entry.OriginalInput)
+	if !sealed {
+		var isolated bool
+		var err error
+		if orgID, isolated, err = s.tenant(ctx, owner); err != nil {
+			return err
+		}
+		if !isolated {
+			return nil
+		}
+	}
+
+	if rc := RequestContextFrom(ctx); rc != nil && !(rc.Authenticated() && (rc.UserID == owner || rc.OrgID == orgID)) {
+		return ErrPromptHistoryNotFound
+	}
+	for _, field := range []*string{&entry.OriginalInput, &entry.EnhancedOutput} {
+		if !isTenantSealed(*field) {
+			continue
+		}
+		plaintext, err := s.keys.Open(ctx, orgID, *field)
+		if err != nil {
+			return fmt.Errorf("failed to open prompt history %s: %w", entry.ID, err)
+		}
+		*field = plaintext
+	}
+	return nil
+}
+
+// tenant returns a user's organization and whether it is isolated
+func (s *tenantHistoryStore) tenant(ctx context.Context, userID string) (string, bool, error) {
+	if userID == "" || s.accounts == nil {
+		return "", false, nil
+	}
+	account, err := s.accounts.Resolve(ctx, userID)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to resolve history owner: %w", err)
+	}
+	if account.OrgID == "" {
+		return "", false, nil
+	}
+	isolated, err := s.keys.Isolated(ctx, account.OrgID)
+	if err != nil {
+		return "", false, err
+	}
+	return account.OrgID, isolated, nil
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Injection handling modes, from most to least lenient
+const (
+	InjectionModeFlag  = "flag"  // Report risky content but pass it through
+	InjectionModeStrip = "strip" // Remove risky context values and report them
+)
+
+// InjectionPolicy controls how detected injection attempts are handled
+type InjectionPolicy struct {
+	Mode           string
+	FlagThreshold  float64 // Minimum risk score to report
+	StripThreshold float64 // Minimum risk score to remove a context value (strip mode only)
+}
+
+// Built-in strictness levels selectable per tier
+var injectionStrictness = map[string]InjectionPolicy{
+	"strict":     {Mode: InjectionModeStrip, FlagThreshold: 0.3, StripThreshold: 0.4},
+	"standard":   {Mode: InjectionModeStrip, FlagThreshold: 0.4, StripThreshold: 0.7},
+	"permissive": {Mode: InjectionModeFlag, FlagThreshold: 0.7},
+}
+
+// defaultTierStrictness applies when INJECTION_STRICTNESS_<TIER> is not set.
+// Anonymous and free traffic is the most likely to carry hostile content.
+var defaultTierStrictness = map[string]string{
+	"anonymous":  "strict",
+	"free":       "strict",
+	"pro":        "standard",
+	"enterprise": "permissive",
+}
+
+// InjectionPolicyForTier returns the policy for a user tier, honouring
+// INJECTION_STRICTNESS_<TIER> overrides (strict, standard or permissive)
+func InjectionPolicyForTier(tier string) InjectionPolicy {
+	tier = strings.ToLower(tier)
+	level := os.Getenv("INJECTION_STRICTNESS_" + strings.ToUpper(tier))
+	if _, ok := injectionStrictness[level]; !ok {
+		level = defaultTierStrictness[tier]
+	}
+	if policy, ok := injectionStrictness[level]; ok {
+		return policy
+	}
+	return injectionStrictness["strict"]
+}
+
+type injectionPattern struct {
+	name   string
+	weight float64
+	re     *regexp.Regexp
+}
+
+// injectionPatterns are matched case-insensitively; weights add up per value
+var injectionPatterns = []injectionPattern{
+	{"ignore_instructions", 0.7, regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b[^.\n]{0,40}\b(previous|prior|above|earlier|all|system)\b[^.\n]{0,20}\b(instructions?|prompts?|rules|directions)\b`)},
+	{"role_hijack", 0.5, regexp.MustCompile(`(?i)\b(you are now|from now on,? you|act as (an? )?(unfiltered|unrestricted|jailbroken)|pretend (that )?you have no (rules|restrictions))\b`)},
+	{"system_prompt_probe", 0.5, regexp.MustCompile(`(?i)\b(reveal|print|show|repeat|output)\b[^.\n]{0,30}\b(system prompt|hidden instructions|initial instructions)\b`)},
+	{"fake_role_marker", 0.4, regexp.MustCompile(`(?im)^\s*(system|assistant)\s*:|<\|?(im_start|system)\|?>|\[/?INST\]`)},
+	{"jailbreak_keyword", 0.4, regexp.MustCompile(`(?i)\b(DAN mode|developer mode enabled|jailbreak)\b`)},
+	{"exfiltration", 0.3, regexp.MustCompile(`(?i)\b(send|post|upload)\b[^.\n]{0,40}\b(https?://|webhook|api key|password|token)`)},
+}
+
+// InjectionFinding describes risky content in one field
+type InjectionFinding struct {
+	Field    string   `json:"field"`
+	Score    float64  `json:"score"`
+	Patterns []string `json:"patterns"`
+	Stripped bool     `json:"stripped"`
+}
+
+// InjectionReport summarises an injection scan of a request
+type InjectionReport struct {
+	RiskScore float64            `json:"risk_score"`
+	Flagged   bool               `json:"flagged"`
+	Mode      string             `json:"mode"`
+	Findings  []InjectionFinding `json:"findings,omitempty"`
+}
+
+// ScanForInjection scores a single text, returning a risk score in [0, 1]
+// and the names of the patterns that matched
+func ScanForInjection(text string) (float64, []string) {
+	var score float64
+	var matched []string
+	for _, p := range injectionPatterns {
+		if p.re.MatchString(text) {
+			score += p.weight
+			matched = append(matched, p.name)
+		}
+	}
+	if score > 1 {
+		score = 1
+	}
+	return score, matched
+}
+
+// InspectForInjection scans the input text, the turns of any conversation
+// and context values. Context values over the strip threshold are removed
+// from the returned copy of the context in strip mode; the user's own text
+// and conversation are only ever flagged since they are the prompt being
+// enhanced.
+func InspectForInjection(text string, messages []ChatMessage, context map[string]interface{}, policy InjectionPolicy) (map[string]interface{}, InjectionReport) {
+	report := InjectionReport{Mode: policy.Mode}
+
+	record := func(field string, score float64, patterns []string, stripped bool) {
+		if score > report.RiskScore {
+			report.RiskScore = score
+		}
+		if score >= policy.FlagThreshold {
+			report.Findings = append(report.Findings, InjectionFinding{
+				Field:    field,
+				Score:    score,
+				Patterns: patterns,
+				Stripped: stripped,
+			})
+		}
+	}
+
+	if score, patterns := ScanForInjection(text); score > 0 {
+		record("text", score, patterns, false)
+	}
+	for i, message := range messages {
+		if score, patterns := ScanForInjection(message.Content); score > 0 {
+			record(fmt.Sprintf("messages[%d]", i), score, patterns, false)
+		}
+	}
+
+	sanitized := make(map[string]interface{}, len(context))
+	keys := make([]string, 0, len(context))
+	for k := range context {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := context[k]
+		score, patterns := ScanForInjection(flattenContextValue(value))
+		strip := policy.Mode == InjectionModeStrip && score >= policy.StripThreshold
+		if score > 0 {
+			record("context."+k, score, patterns, strip)
+		}
+		if !strip {
+			sanitized[k] = value
+		}
+	}
+
+	report.Flagged = len(report.Findings) > 0
+	return sanitized, report
+}
+
+// flattenContextValue renders nested context values as text for scanning
+func flattenContextValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, item := range v {
+			parts[i] = flattenContextValue(item)
+		}
+		return strings.Join(parts, "\n")
+	case map[string]interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			parts = append(parts, flattenContextValue(item))
+		}
+		return strings.Join(parts, "\n")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
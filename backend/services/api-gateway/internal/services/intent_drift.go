@@ -0,0 +1,528 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// Drift snapshot statuses
+const (
+	DriftStatusOK               = "ok"
+	DriftStatusWarning          = "warning"
+	DriftStatusAlert            = "alert"
+	DriftStatusInsufficientData = "insufficient_data"
+)
+
+// ConfidenceBuckets is the number of equal-width confidence histogram buckets
+const ConfidenceBuckets = 10
+
+const (
+	intentDriftTimeout = 2 * time.Minute
+
+	// psiEpsilon stands in for empty buckets so PSI stays finite
+	psiEpsilon = 1e-4
+)
+
+var (
+	intentDriftPSI = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "intent_drift_psi",
+		Help: "Population stability index of recent intent classifications against the baseline window",
+	}, []string{"dimension"})
+
+	intentFallbackRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "intent_classifier_fallback_rate",
+		Help: "Share of recent classifications answered by a classifier other than the primary model",
+	})
+
+	intentDriftAlerts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "intent_drift_alerts_total",
+		Help: "Number of times intent drift crossed the alert threshold",
+	})
+)
+
+// IntentDriftConfig controls the drift job's windows and thresholds
+type IntentDriftConfig struct {
+	Interval          time.Duration // How often snapshots are taken; 0 disables the job
+	Window            time.Duration // Recent classifications compared against the baseline
+	Baseline          time.Duration // Length of the baseline, which ends where the window starts
+	MinSamples        int           // Fewer classifications in either window skips the comparison
+	WarnPSI           float64
+	AlertPSI          float64
+	AlertFallbackRate float64
+	PrimaryClassifier string // Classifications by any other classifier count as fallbacks
+}
+
+// LoadIntentDriftConfig reads the INTENT_DRIFT_* environment variables
+func LoadIntentDriftConfig() IntentDriftConfig {
+	config := IntentDriftConfig{
+		Interval:          1 * time.Hour,
+		Window:            24 * time.Hour,
+		Baseline:          7 * 24 * time.Hour,
+		MinSamples:        200,
+		WarnPSI:           0.1,
+		AlertPSI:          0.25,
+		AlertFallbackRate: 0.3,
+		PrimaryClassifier: getEnv("INTENT_DRIFT_PRIMARY_CLASSIFIER", "distilbert"),
+	}
+	if d, err := time.ParseDuration(getEnv("INTENT_DRIFT_INTERVAL", "")); err == nil && d >= 0 {
+		config.Interval = d
+	}
+	if d, err := time.ParseDuration(getEnv("INTENT_DRIFT_WINDOW", "")); err == nil && d > 0 {
+		config.Window = d
+	}
+	if d, err := time.ParseDuration(getEnv("INTENT_DRIFT_BASELINE", "")); err == nil && d > 0 {
+		config.Baseline = d
+	}
+	if n, err := strconv.Atoi(getEnv("INTENT_DRIFT_MIN_SAMPLES", "")); err == nil && n > 0 {
+		config.MinSamples = n
+	}
+	if v, err := strconv.ParseFloat(getEnv("INTENT_DRIFT_WARN_PSI", ""), 64); err == nil {
+		config.WarnPSI = v
+	}
+	if v, err := strconv.ParseFloat(getEnv("INTENT_DRIFT_ALERT_PSI", ""), 64); err == nil {
+		config.AlertPSI = v
+	}
+	if v, err := strconv.ParseFloat(getEnv("INTENT_DRIFT_ALERT_FALLBACK_RATE", ""), 64); err == nil {
+		config.AlertFallbackRate = v
+	}
+	return config
+}
+
+// IntentDistribution summarizes the classifications in one window
+type IntentDistribution struct {
+	Samples     int64                    `json:"samples"`
+	Intents     map[string]int64         `json:"intents"`
+	Confidence  [ConfidenceBuckets]int64 `json:"confidence"` // Bucket i covers [i/10, (i+1)/10)
+	Classifiers map[string]int64         `json:"classifiers"`
+}
+
+func newIntentDistribution() *IntentDistribution {
+	return &IntentDistribution{
+		Intents:     map[string]int64{},
+		Classifiers: map[string]int64{},
+	}
+}
+
+// FallbackRate is the share of classifications with a known classifier that
+// weren't answered by primary
+func (d *IntentDistribution) FallbackRate(primary string) float64 {
+	var known, fallback int64
+	for classifier, count := range d.Classifiers {
+		if classifier == "unknown" {
+			continue
+		}
+		known += count
+		if classifier != primary {
+			fallback += count
+		}
+	}
+	if known == 0 {
+		return 0
+	}
+	return float64(fallback) / float64(known)
+}
+
+// IntentDriftSnapshot is one comparison of recent classifications against
+// the baseline
+type IntentDriftSnapshot struct {
+	ID            int64               `json:"id"`
+	WindowStart   time.Time           `json:"window_start"`
+	WindowEnd     time.Time           `json:"window_end"`
+	BaselineStart time.Time           `json:"baseline_start"`
+	BaselineEnd   time.Time           `json:"baseline_end"`
+	IntentPSI     *float64            `json:"intent_psi"`
+	ConfidencePSI *float64            `json:"confidence_psi"`
+	ClassifierPSI *float64            `json:"classifier_psi"`
+	FallbackRate  *float64            `json:"fallback_rate"`
+	Status        string              `json:"status"`
+	Current       *IntentDistribution `json:"current,omitempty"`
+	Reference     *IntentDistribution `json:"baseline,omitempty"`
+	ComputedAt    time.Time           `json:"computed_at"`
+}
+
+// intentDriftDistributions is how both distributions are stored
+type intentDriftDistributions struct {
+	Current  *IntentDistribution `json:"current"`
+	Baseline *IntentDistribution `json:"baseline"`
+}
+
+// IntentDriftService monitors the intent classifier's output for drift and
+// alerts admins when it exceeds the configured thresholds
+type IntentDriftService struct {
+	db       *DatabaseService
+	email    *EmailService
+	eventBus *EventBus
+	config   IntentDriftConfig
+	logger   *logrus.Logger
+}
+
+// NewIntentDriftService creates a new intent drift monitor. email and
+// eventBus are optional.
+func NewIntentDriftService(db *DatabaseService, email *EmailService, eventBus *EventBus, config IntentDriftConfig, logger *logrus.Logger) *IntentDriftService {
+	return &IntentDriftService{
+		db:       db,
+		email:    email,
+		eventBus: eventBus,
+		config:   config,
+		logger:   logger,
+	}
+}
+
+// MonitorJob returns the scheduled job that takes drift snapshots
+func (s *IntentDriftService) MonitorJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "intent_drift",
+		Interval: s.config.Interval,
+		Timeout:  intentDriftTimeout,
+		Run: func(ctx context.Context) error {
+			_, err := s.Snapshot(ctx)
+			return err
+		},
+	}
+}
+
+// Snapshot compares the most recent window against the baseline, stores the
+// result, updates the metrics and alerts when drift newly crosses the alert
+// threshold
+func (s *IntentDriftService) Snapshot(ctx context.Context) (*IntentDriftSnapshot, error) {
+	windowEnd := time.Now().UTC()
+	windowStart := windowEnd.Add(-s.config.Window)
+	baselineStart := windowStart.Add(-s.config.Baseline)
+
+	current, err := s.distribution(ctx, windowStart, windowEnd)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := s.distribution(ctx, baselineStart, windowStart)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := s.compare(current, baseline)
+	snapshot.WindowStart, snapshot.WindowEnd = windowStart, windowEnd
+	snapshot.BaselineStart, snapshot.BaselineEnd = baselineStart, windowStart
+
+	previous, err := s.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	distributions, err := json.Marshal(intentDriftDistributions{Current: current, Baseline: baseline})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal distributions: %w", err)
+	}
+
+	err = s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO analytics.intent_drift_snapshots
+			(window_start, window_end, baseline_start, baseline_end, samples, baseline_samples,
+			 intent_psi, confidence_psi, classifier_psi, fallback_rate, status, distributions)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, computed_at`,
+		snapshot.WindowStart, snapshot.WindowEnd, snapshot.BaselineStart, snapshot.BaselineEnd,
+		current.Samples, baseline.Samples, snapshot.IntentPSI, snapshot.ConfidencePSI,
+		snapshot.ClassifierPSI, snapshot.FallbackRate, snapshot.Status, distributions,
+	).Scan(&snapshot.ID, &snapshot.ComputedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store drift snapshot: %w", err)
+	}
+
+	s.recordMetrics(snapshot)
+
+	logger := s.logger.WithFields(logrus.Fields{
+		"status":         snapshot.Status,
+		"samples":        current.Samples,
+		"intent_psi":     snapshot.IntentPSI,
+		"confidence_psi": snapshot.ConfidencePSI,
+		"classifier_psi": snapshot.ClassifierPSI,
+		"fallback_rate":  snapshot.FallbackRate,
+	})
+	if snapshot.Status == DriftStatusAlert && (previous == nil || previous.Status != DriftStatusAlert) {
+		intentDriftAlerts.Inc()
+		logger.Warn("Intent classifier drift exceeded the alert threshold")
+		s.notify(ctx, snapshot)
+	} else {
+		logger.Info("Intent drift snapshot taken")
+	}
+
+	return snapshot, nil
+}
+
+// compare computes the drift metrics and status for two distributions
+func (s *IntentDriftService) compare(current, baseline *IntentDistribution) *IntentDriftSnapshot {
+	snapshot := &IntentDriftSnapshot{
+		Status:    DriftStatusInsufficientData,
+		Current:   current,
+		Reference: baseline,
+	}
+	if current.Samples < int64(s.config.MinSamples) || baseline.Samples < int64(s.config.MinSamples) {
+		return snapshot
+	}
+
+	intentPSI := PopulationStabilityIndex(baseline.Intents, current.Intents)
+	confidencePSI := PopulationStabilityIndex(bucketMap(baseline.Confidence), bucketMap(current.Confidence))
+	classifierPSI := PopulationStabilityIndex(baseline.Classifiers, current.Classifiers)
+	fallbackRate := current.FallbackRate(s.config.PrimaryClassifier)
+
+	snapshot.IntentPSI = &intentPSI
+	snapshot.ConfidencePSI = &confidencePSI
+	snapshot.ClassifierPSI = &classifierPSI
+	snapshot.FallbackRate = &fallbackRate
+
+	worst := math.Max(intentPSI, math.Max(confidencePSI, classifierPSI))
+	switch {
+	case worst >= s.config.AlertPSI || fallbackRate >= s.config.AlertFallbackRate:
+		snapshot.Status = DriftStatusAlert
+	case worst >= s.config.WarnPSI:
+		snapshot.Status = DriftStatusWarning
+	default:
+		snapshot.Status = DriftStatusOK
+	}
+	return snapshot
+}
+
+// distribution counts the classifications recorded in prompt history
+// between start and end
+func (s *IntentDriftService) distribution(ctx context.Context, start, end time.Time) (*IntentDistribution, error) {
+	query := `
+		SELECT COALESCE(intent, 'unknown'),
+			   CASE WHEN intent_confidence IS NULL THEN -1
+			        ELSE LEAST(GREATEST(FLOOR(intent_confidence * $3)::int, 0), $3 - 1) END,
+			   COALESCE(metadata->>'intent_classifier', 'unknown'),
+			   COUNT(*)
+		FROM prompts.history
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY 1, 2, 3`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, start, end, ConfidenceBuckets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query classifications: %w", err)
+	}
+	defer rows.Close()
+
+	dist := newIntentDistribution()
+	for rows.Next() {
+		var intent, classifier string
+		var bucket int
+		var count int64
+		if err := rows.Scan(&intent, &bucket, &classifier, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan classifications: %w", err)
+		}
+		dist.Samples += count
+		dist.Intents[intent] += count
+		dist.Classifiers[classifier] += count
+		if bucket >= 0 {
+			dist.Confidence[bucket] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate classifications: %w", err)
+	}
+	return dist, nil
+}
+
+// Latest returns the most recent snapshot, or nil before the first one
+func (s *IntentDriftService) Latest(ctx context.Context) (*IntentDriftSnapshot, error) {
+	snapshots, err := s.History(ctx, time.Time{}, 1)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return snapshots[0], nil
+}
+
+// History returns snapshots taken since the given time, newest first
+func (s *IntentDriftService) History(ctx context.Context, since time.Time, limit int) ([]*IntentDriftSnapshot, error) {
+	query := `
+		SELECT id, window_start, window_end, baseline_start, baseline_end, intent_psi,
+			   confidence_psi, classifier_psi, fallback_rate, status, distributions, computed_at
+		FROM analytics.intent_drift_snapshots
+		WHERE computed_at >= $1
+		ORDER BY computed_at DESC
+		LIMIT $2`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drift snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := []*IntentDriftSnapshot{}
+	for rows.Next() {
+		var snapshot IntentDriftSnapshot
+		var intentPSI, confidencePSI, classifierPSI, fallbackRate sql.NullFloat64
+		var distributions []byte
+		err := rows.Scan(&snapshot.ID, &snapshot.WindowStart, &snapshot.WindowEnd, &snapshot.BaselineStart,
+			&snapshot.BaselineEnd, &intentPSI, &confidencePSI, &classifierPSI, &fallbackRate,
+			&snapshot.Status, &distributions, &snapshot.ComputedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan drift snapshot: %w", err)
+		}
+		snapshot.IntentPSI = nullFloatPtr(intentPSI)
+		snapshot.ConfidencePSI = nullFloatPtr(confidencePSI)
+		snapshot.ClassifierPSI = nullFloatPtr(classifierPSI)
+		snapshot.FallbackRate = nullFloatPtr(fallbackRate)
+
+		var dists intentDriftDistributions
+		if err := json.Unmarshal(distributions, &dists); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal drift distributions: %w", err)
+		}
+		snapshot.Current, snapshot.Reference = dists.Current, dists.Baseline
+		snapshots = append(snapshots, &snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate drift snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+func (s *IntentDriftService) recordMetrics(snapshot *IntentDriftSnapshot) {
+	if snapshot.IntentPSI == nil {
+		return
+	}
+	intentDriftPSI.WithLabelValues("intent").Set(*snapshot.IntentPSI)
+	intentDriftPSI.WithLabelValues("confidence").Set(*snapshot.ConfidencePSI)
+	intentDriftPSI.WithLabelValues("classifier").Set(*snapshot.ClassifierPSI)
+	intentFallbackRate.Set(*snapshot.FallbackRate)
+}
+
+// notify tells admins about an alert on the activity stream and by email;
+// failures are only logged
+func (s *IntentDriftService) notify(ctx context.Context, snapshot *IntentDriftSnapshot) {
+	message := fmt.Sprintf(
+		"Intent classifications over the last %s have drifted from the preceding %s. Intent PSI %.3f, confidence PSI %.3f, classifier PSI %.3f, fallback rate %.1f%%. See /api/v1/admin/analytics/intent-drift for details.",
+		s.config.Window, s.config.Baseline, *snapshot.IntentPSI, *snapshot.ConfidencePSI,
+		*snapshot.ClassifierPSI, *snapshot.FallbackRate*100,
+	)
+
+	if s.eventBus != nil {
+		s.eventBus.Publish(ActivityEvent{
+			Type: ActivityIntentDrift,
+			Data: map[string]interface{}{
+				"snapshot_id":    snapshot.ID,
+				"intent_psi":     *snapshot.IntentPSI,
+				"confidence_psi": *snapshot.ConfidencePSI,
+				"classifier_psi": *snapshot.ClassifierPSI,
+				"fallback_rate":  *snapshot.FallbackRate,
+			},
+		})
+	}
+
+	if s.email == nil {
+		return
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT email, username FROM auth.users
+		WHERE 'admin' = ANY(roles) AND is_active`)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to load admins for drift alert")
+		return
+	}
+	defer rows.Close()
+
+	subject := "Intent classifier drift alert"
+	for rows.Next() {
+		var email, username string
+		if err := rows.Scan(&email, &username); err != nil {
+			s.logger.WithError(err).Warn("Failed to scan admin for drift alert")
+			return
+		}
+		if err := s.email.SendNoticeEmail(ctx, email, username, subject, subject, message); err != nil {
+			s.logger.WithError(err).Warn("Failed to send drift alert")
+		}
+	}
+}
+
+// PopulationStabilityIndex measures how far actual has shifted from
+// expected: sum((a - e) * ln(a / e)) over the share of each bucket. Below 0.1
+// is usually read as stable and above 0.25 as a significant shift.
+func PopulationStabilityIndex(expected, actual map[string]int64) float64 {
+	var expectedTotal, actualTotal int64
+	buckets := map[string]bool{}
+	for bucket, count := range expected {
+		expectedTotal += count
+		buckets[bucket] = true
+	}
+	for bucket, count := range actual {
+		actualTotal += count
+		buckets[bucket] = true
+	}
+	if expectedTotal == 0 || actualTotal == 0 {
+		return 0
+	}
+
+	psi := 0.0
+	for bucket := range buckets {
+		e := math.Max(float64(expected[bucket])/float64(expectedTotal), psiEpsilon)
+		a := math.Max(float64(actual[bucket])/float64(actualTotal), psiEpsilon)
+		psi += (a - e) * math.Log(a/e)
+	}
+	return psi
+}
+
+func bucketMap(histogram [ConfidenceBuckets]int64) map[string]int64 {
+	buckets := make(map[string]int64, len(histogram))
+	for i, count := range histogram {
+		buckets[strconv.Itoa(i)] = count
+	}
+	return buckets
+}
+
+func nullFloatPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
+
+// IntentDriftDashboard is the latest snapshot with its distributions and a
+// time series of the drift metrics
+type IntentDriftDashboard struct {
+	Latest     *IntentDriftSnapshot   `json:"latest"`
+	Series     []*IntentDriftSnapshot `json:"series"` // Oldest first, without distributions
+	Thresholds IntentDriftThresholds  `json:"thresholds"`
+}
+
+// IntentDriftThresholds are the configured alerting thresholds
+type IntentDriftThresholds struct {
+	WarnPSI           float64 `json:"warn_psi"`
+	AlertPSI          float64 `json:"alert_psi"`
+	AlertFallbackRate float64 `json:"alert_fallback_rate"`
+	MinSamples        int     `json:"min_samples"`
+	PrimaryClassifier string  `json:"primary_classifier"`
+}
+
+// Dashboard returns the drift snapshots taken since the given time
+func (s *IntentDriftService) Dashboard(ctx context.Context, since time.Time) (*IntentDriftDashboard, error) {
+	snapshots, err := s.History(ctx, since, 1000)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard := &IntentDriftDashboard{
+		Series: make([]*IntentDriftSnapshot, 0, len(snapshots)),
+		Thresholds: IntentDriftThresholds{
+			WarnPSI:           s.config.WarnPSI,
+			AlertPSI:          s.config.AlertPSI,
+			AlertFallbackRate: s.config.AlertFallbackRate,
+			MinSamples:        s.config.MinSamples,
+			PrimaryClassifier: s.config.PrimaryClassifier,
+		},
+	}
+	if len(snapshots) > 0 {
+		dashboard.Latest = snapshots[0]
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		point := *snapshots[i]
+		point.Current, point.Reference = nil, nil
+		dashboard.Series = append(dashboard.Series, &point)
+	}
+	return dashboard, nil
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPopulationStabilityIndex(t *testing.T) {
+	baseline := map[string]int64{"code_generation": 500, "explanation": 300, "analysis": 200}
+
+	assert.Equal(t, 0.0, PopulationStabilityIndex(baseline, baseline))
+	assert.InDelta(t, 0.0, PopulationStabilityIndex(baseline, map[string]int64{"code_generation": 50, "explanation": 30, "analysis": 20}), 1e-12, "scale doesn't matter")
+
+	// 50/30/20 -> 30/30/40: 0.2*ln(5/3) + 0.2*ln(2)
+	shifted := map[string]int64{"code_generation": 300, "explanation": 300, "analysis": 400}
+	assert.InDelta(t, 0.2*math.Log(5.0/3)+0.2*math.Log(2), PopulationStabilityIndex(baseline, shifted), 1e-9)
+
+	// A new intent appearing is drift, not a division by zero
+	withNew := map[string]int64{"code_generation": 500, "explanation": 300, "analysis": 100, "translation": 100}
+	psi := PopulationStabilityIndex(baseline, withNew)
+	assert.False(t, math.IsInf(psi, 0) || math.IsNaN(psi))
+	assert.Greater(t, psi, 0.25)
+
+	assert.Equal(t, 0.0, PopulationStabilityIndex(baseline, nil))
+}
+
+func TestIntentDriftCompare(t *testing.T) {
+	s := NewIntentDriftService(nil, nil, nil, IntentDriftConfig{
+		MinSamples:        100,
+		WarnPSI:           0.1,
+		AlertPSI:          0.25,
+		AlertFallbackRate: 0.3,
+		PrimaryClassifier: "distilbert",
+	}, nil)
+
+	dist := func(samples int64, classifiers map[string]int64, confidence ...int64) *IntentDistribution {
+		d := newIntentDistribution()
+		d.Samples = samples
+		d.Intents["code_generation"] = samples
+		d.Classifiers = classifiers
+		copy(d.Confidence[:], confidence)
+		return d
+	}
+
+	baseline := dist(1000, map[string]int64{"distilbert": 900, "rules": 100}, 0, 0, 0, 0, 0, 0, 100, 200, 300, 400)
+
+	stable := s.compare(dist(500, map[string]int64{"distilbert": 450, "rules": 50}, 0, 0, 0, 0, 0, 0, 50, 100, 150, 200), baseline)
+	assert.Equal(t, DriftStatusOK, stable.Status)
+	assert.InDelta(t, 0.1, *stable.FallbackRate, 1e-9)
+
+	lowConfidence := s.compare(dist(500, map[string]int64{"distilbert": 450, "rules": 50}, 0, 0, 0, 0, 100, 100, 100, 100, 50, 50), baseline)
+	assert.Equal(t, DriftStatusAlert, lowConfidence.Status)
+
+	fallbacks := s.compare(dist(500, map[string]int64{"distilbert": 300, "zero_shot": 200}, 0, 0, 0, 0, 0, 0, 50, 100, 150, 200), baseline)
+	assert.Equal(t, DriftStatusAlert, fallbacks.Status)
+	assert.InDelta(t, 0.4, *fallbacks.FallbackRate, 1e-9)
+
+	tooFew := s.compare(dist(50, map[string]int64{"distilbert": 50}), baseline)
+	assert.Equal(t, DriftStatusInsufficientData, tooFew.Status)
+	assert.Nil(t, tooFew.IntentPSI)
+}
+
+func TestFallbackRateIgnoresUnknownClassifier(t *testing.T) {
+	d := newIntentDistribution()
+	d.Classifiers = map[string]int64{"unknown": 1000, "distilbert": 75, "rules": 25}
+	assert.InDelta(t, 0.25, d.FallbackRate("distilbert"), 1e-9)
+
+	assert.Equal(t, 0.0, newIntentDistribution().FallbackRate("distilbert"))
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"time"
+)
+
+// KnownIntents are the intents the classifier predicts; users correcting an
+// intent pick one of them
+var KnownIntents = []string{
+	"question_answering",
+	"creative_writing",
+	"code_generation",
+	"data_analysis",
+	"reasoning",
+	"summarization",
+	"translation",
+	"conversation",
+	"task_planning",
+	"problem_solving",
+}
+
+// ErrUnknownIntent is returned for intents the classifier doesn't know
+var ErrUnknownIntent = errors.New("unknown intent")
+
+// IsKnownIntent reports whether intent is one of KnownIntents
+func IsKnownIntent(intent string) bool {
+	return slices.Contains(KnownIntents, intent)
+}
+
+// IntentCandidate is one intent the classifier considered, with its probability
+type IntentCandidate struct {
+	Intent string  `json:"intent"`
+	Score  float64 `json:"score"`
+}
+
+// TopIntents returns up to k intents from IntentScores, most likely first.
+// The classified intent always leads, scored with Confidence when the
+// classifier didn't return scores.
+func (r *IntentClassificationResult) TopIntents(k int) []IntentCandidate {
+	candidates := []IntentCandidate{{Intent: r.Intent, Score: r.Confidence}}
+	if score, ok := r.IntentScores[r.Intent]; ok {
+		candidates[0].Score = score
+	}
+
+	others := make([]IntentCandidate, 0, len(r.IntentScores))
+	for intent, score := range r.IntentScores {
+		if intent != r.Intent {
+			others = append(others, IntentCandidate{Intent: intent, Score: score})
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		if others[i].Score != others[j].Score {
+			return others[i].Score > others[j].Score
+		}
+		return others[i].Intent < others[j].Intent
+	})
+
+	candidates = append(candidates, others...)
+	if k > 0 && len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// IntentCorrection is a user enhancing a prompt with a different intent than
+// the classifier predicted
+type IntentCorrection struct {
+	HistoryID           string
+	Intent              string // The intent the user picked
+	PredictedIntent     string
+	PredictedConfidence float64
+	UserID              string // Empty for anonymous users
+}
+
+// IntentLabel is an intent correction as seen by the ML team. The prompt has
+// contact details and secrets redacted.
+type IntentLabel struct {
+	HistoryID           string    `json:"history_id"`
+	Input               string    `json:"input"`
+	Intent              string    `json:"intent"`
+	PredictedIntent     string    `json:"predicted_intent"`
+	PredictedConfidence *float64  `json:"predicted_confidence,omitempty"`
+	LabeledAt           time.Time `json:"labeled_at"`
+}
+
+// RecordIntentCorrection keeps a user's intent correction as an intent label,
+// replacing an earlier one for the same history entry
+func (s *TrainingService) RecordIntentCorrection(ctx context.Context, correction IntentCorrection) error {
+	if !IsKnownIntent(correction.Intent) {
+		return fmt.Errorf("%w: %q", ErrUnknownIntent, correction.Intent)
+	}
+
+	query := `
+		INSERT INTO prompts.intent_labels (history_id, intent, predicted_intent, predicted_confidence, labeled_by, labeled_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, CURRENT_TIMESTAMP)
+		ON CONFLICT (history_id) DO UPDATE
+		SET intent = EXCLUDED.intent, predicted_intent = EXCLUDED.predicted_intent,
+			predicted_confidence = EXCLUDED.predicted_confidence,
+			labeled_by = EXCLUDED.labeled_by, labeled_at = EXCLUDED.labeled_at`
+
+	_, err := s.db.DB.ExecContext(ctx, query, correction.HistoryID, correction.Intent,
+		correction.PredictedIntent, correction.PredictedConfidence, correction.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to record intent correction: %w", err)
+	}
+	return nil
+}
+
+// ListIntentLabels returns intent labels, most recent first
+func (s *TrainingService) ListIntentLabels(ctx context.Context, limit, offset int) ([]*IntentLabel, int64, error) {
+	var total int64
+	if err := s.db.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM prompts.intent_labels`).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count intent labels: %w", err)
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, intentLabelQuery+`
+		ORDER BY l.labeled_at DESC
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list intent labels: %w", err)
+	}
+	defer rows.Close()
+
+	labels := []*IntentLabel{}
+	for rows.Next() {
+		label, err := scanIntentLabel(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		labels = append(labels, label)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate intent labels: %w", err)
+	}
+	return labels, total, nil
+}
+
+// ExportIntentLabels writes every intent label as JSON lines, oldest first,
+// for retraining the intent classifier
+func (s *TrainingService) ExportIntentLabels(ctx context.Context, w io.Writer) (int, error) {
+	rows, err := s.db.DB.QueryContext(ctx, intentLabelQuery+`
+		ORDER BY l.labeled_at, l.history_id`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query intent labels: %w", err)
+	}
+	defer rows.Close()
+
+	encoder := json.NewEncoder(w)
+	written := 0
+	for rows.Next() {
+		label, err := scanIntentLabel(rows)
+		if err != nil {
+			return written, err
+		}
+		if err := encoder.Encode(label); err != nil {
+			return written, fmt.Errorf("failed to write intent label: %w", err)
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return written, fmt.Errorf("failed to iterate intent labels: %w", err)
+	}
+	return written, nil
+}
+
+const intentLabelQuery = `
+		SELECT l.history_id, h.original_input, l.intent, l.predicted_intent,
+			   l.predicted_confidence, l.labeled_at
+		FROM prompts.intent_labels l
+		JOIN prompts.history h ON h.id = l.history_id`
+
+func scanIntentLabel(row rowScanner) (*IntentLabel, error) {
+	var label IntentLabel
+	var confidence sql.NullFloat64
+
+	err := row.Scan(&label.HistoryID, &label.Input, &label.Intent, &label.PredictedIntent,
+		&confidence, &label.LabeledAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan intent label: %w", err)
+	}
+
+	label.Input = RedactPII(label.Input)
+	if confidence.Valid {
+		label.PredictedConfidence = &confidence.Float64
+	}
+	return &label, nil
+}
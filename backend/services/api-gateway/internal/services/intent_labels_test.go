@@ -0,0 +1,31 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopIntents(t *testing.T) {
+	result := &IntentClassificationResult{
+		Intent:     "code_generation",
+		Confidence: 0.55,
+		IntentScores: map[string]float64{
+			"code_generation":  0.55,
+			"reasoning":        0.2,
+			"data_analysis":    0.2,
+			"creative_writing": 0.05,
+		},
+	}
+
+	assert.Equal(t, []IntentCandidate{
+		{Intent: "code_generation", Score: 0.55},
+		{Intent: "data_analysis", Score: 0.2},
+		{Intent: "reasoning", Score: 0.2},
+	}, result.TopIntents(3))
+	assert.Len(t, result.TopIntents(10), 4)
+
+	// Classifiers without scores still offer the prediction
+	unscored := &IntentClassificationResult{Intent: "reasoning", Confidence: 0.9}
+	assert.Equal(t, []IntentCandidate{{Intent: "reasoning", Score: 0.9}}, unscored.TopIntents(3))
+}
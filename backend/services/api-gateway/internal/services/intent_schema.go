@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// IntentSchemaVersion is the classification response schema version the
+// gateway asks the intent classifier for. Published schemas are in
+// tests/contracts/schemas/intent-classifier.
+const IntentSchemaVersion = 1
+
+// intentSchemaVersionHeader carries the schema version the classifier served
+const intentSchemaVersionHeader = "X-Schema-Version"
+
+// intentSchemaRequired lists, per schema version the gateway understands,
+// the fields it can't do without. Other fields are defaulted when missing,
+// and fields it doesn't know are ignored.
+var intentSchemaRequired = map[int][]string{
+	1: {"intent", "confidence"},
+}
+
+// ErrIntentSchemaMismatch means a classification response doesn't match
+// any schema version the gateway understands
+var ErrIntentSchemaMismatch = errors.New("intent classifier response doesn't match its schema")
+
+// validComplexities are the complexity levels the pipeline branches on
+var validComplexities = map[string]bool{
+	"simple":   true,
+	"moderate": true,
+	"complex":  true,
+}
+
+// intentAcceptHeader asks the classifier for the gateway's schema version
+func intentAcceptHeader() string {
+	return "application/json; version=" + strconv.Itoa(IntentSchemaVersion)
+}
+
+// intentSchemaVersion returns the schema version of a classification
+// response from its fields and X-Schema-Version header
+func intentSchemaVersion(fields map[string]json.RawMessage, headerVersion string) (int, error) {
+	version := 1
+	if raw, ok := fields["schema_version"]; ok && !isJSONNull(raw) {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return 0, fmt.Errorf("%w: invalid schema_version %s", ErrIntentSchemaMismatch, raw)
+		}
+	} else if headerVersion != "" {
+		parsed, err := strconv.Atoi(headerVersion)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid %s %q", ErrIntentSchemaMismatch, intentSchemaVersionHeader, headerVersion)
+		}
+		version = parsed
+	}
+	return version, nil
+}
+
+// intentResponseVersion returns the schema version a classification
+// response claims, the one the gateway asked for when it can't tell
+func intentResponseVersion(body []byte, headerVersion string) int {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return IntentSchemaVersion
+	}
+	version, err := intentSchemaVersion(fields, headerVersion)
+	if err != nil {
+		return IntentSchemaVersion
+	}
+	return version
+}
+
+// decodeIntentClassification decodes a classification response of any
+// supported schema version. The version comes from the body's
+// schema_version, else the X-Schema-Version header; responses with neither
+// predate versioning and are version 1. Missing required fields fail
+// decoding rather than silently becoming zero values.
+func decodeIntentClassification(body []byte, headerVersion string) (*IntentClassificationResult, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, fmt.Errorf("%w: response is not a JSON object", ErrIntentSchemaMismatch)
+	}
+
+	version, err := intentSchemaVersion(fields, headerVersion)
+	if err != nil {
+		return nil, err
+	}
+	required, ok := intentSchemaRequired[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported schema version %d", ErrIntentSchemaMismatch, version)
+	}
+	for _, field := range required {
+		if raw, ok := fields[field]; !ok || isJSONNull(raw) {
+			return nil, fmt.Errorf("%w: missing required field %q", ErrIntentSchemaMismatch, field)
+		}
+	}
+
+	var result IntentClassificationResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIntentSchemaMismatch, err)
+	}
+	result.SchemaVersion = version
+	if result.Intent == "" {
+		return nil, fmt.Errorf("%w: empty intent", ErrIntentSchemaMismatch)
+	}
+	if result.Confidence < 0 || result.Confidence > 1 {
+		return nil, fmt.Errorf("%w: confidence %v out of range", ErrIntentSchemaMismatch, result.Confidence)
+	}
+
+	// Add default suggested techniques if none provided
+	if len(result.SuggestedTechniques) == 0 {
+		result.SuggestedTechniques = []string{"chain_of_thought"}
+	}
+	// Default to moderate if the complexity is missing or unknown
+	if !validComplexities[result.Complexity] {
+		result.Complexity = "moderate"
+	}
+	return &result, nil
+}
+
+func isJSONNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
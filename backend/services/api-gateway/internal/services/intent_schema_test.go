@@ -0,0 +1,129 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// publishedIntentSchemaDir holds the classification response schemas the
+// classifier has published, one file per version
+func publishedIntentSchemaDir() string {
+	return filepath.Join(filepath.Dir(pactDir()), "schemas", "intent-classifier")
+}
+
+// publishedIntentSchema is the part of a published JSON Schema the
+// compatibility tests check
+type publishedIntentSchema struct {
+	Required   []string                   `json:"required"`
+	Properties map[string]json.RawMessage `json:"properties"`
+	Examples   []map[string]interface{}   `json:"examples"`
+}
+
+func loadPublishedIntentSchemas(t *testing.T) map[int]publishedIntentSchema {
+	paths, err := filepath.Glob(filepath.Join(publishedIntentSchemaDir(), "classify-response.v*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths, "no published schemas in %s", publishedIntentSchemaDir())
+
+	versionPattern := regexp.MustCompile(`\.v(\d+)\.json$`)
+	schemas := make(map[int]publishedIntentSchema, len(paths))
+	for _, path := range paths {
+		m := versionPattern.FindStringSubmatch(path)
+		require.NotNil(t, m, path)
+		version, _ := strconv.Atoi(m[1])
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var schema publishedIntentSchema
+		require.NoError(t, json.Unmarshal(data, &schema), path)
+		schemas[version] = schema
+	}
+	return schemas
+}
+
+func TestIntentSchemaCompatibility(t *testing.T) {
+	published := loadPublishedIntentSchemas(t)
+
+	for version := range intentSchemaRequired {
+		_, ok := published[version]
+		assert.True(t, ok, "schema version %d is understood but was never published", version)
+	}
+
+	for version, schema := range published {
+		required, ok := intentSchemaRequired[version]
+		if !ok {
+			continue // Newer than this gateway; decoding refuses it
+		}
+
+		t.Run("v"+strconv.Itoa(version), func(t *testing.T) {
+			assert.Subset(t, schema.Required, required, "the gateway may only require fields the schema promises")
+			require.NotEmpty(t, schema.Examples)
+
+			for _, example := range schema.Examples {
+				body, err := json.Marshal(example)
+				require.NoError(t, err)
+				result, err := decodeIntentClassification(body, "")
+				require.NoError(t, err, "published example %s", body)
+				assert.Equal(t, version, result.SchemaVersion)
+				assert.Equal(t, example["intent"], result.Intent)
+
+				for _, field := range required {
+					trimmed := make(map[string]interface{}, len(example))
+					for k, v := range example {
+						trimmed[k] = v
+					}
+					delete(trimmed, field)
+					if _, versioned := trimmed["schema_version"]; !versioned {
+						trimmed["schema_version"] = version
+					}
+					body, err := json.Marshal(trimmed)
+					require.NoError(t, err)
+					_, err = decodeIntentClassification(body, "")
+					assert.ErrorIs(t, err, ErrIntentSchemaMismatch, "response without %q", field)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeIntentClassification(t *testing.T) {
+	t.Run("tolerates unknown fields and fills defaults", func(t *testing.T) {
+		result, err := decodeIntentClassification([]byte(`{"intent":"analysis","confidence":0.7,"alternatives":[{"intent":"qa"}]}`), "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SchemaVersion, "unversioned responses are version 1")
+		assert.Equal(t, "moderate", result.Complexity)
+		assert.Equal(t, []string{"chain_of_thought"}, result.SuggestedTechniques)
+	})
+
+	t.Run("version from header", func(t *testing.T) {
+		result, err := decodeIntentClassification([]byte(`{"intent":"analysis","confidence":0.7}`), "1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.SchemaVersion)
+	})
+
+	failures := map[string]struct {
+		body          string
+		headerVersion string
+	}{
+		"not an object":       {body: `[1]`},
+		"unsupported version": {body: `{"intent":"analysis","confidence":0.7,"schema_version":99}`},
+		"unsupported header":  {body: `{"intent":"analysis","confidence":0.7}`, headerVersion: "99"},
+		"null required field": {body: `{"intent":"analysis","confidence":null}`},
+		"retyped field":       {body: `{"intent":"analysis","confidence":"high"}`},
+		"confidence range":    {body: `{"intent":"analysis","confidence":1.5}`},
+		"empty intent":        {body: `{"intent":"","confidence":0.5}`},
+	}
+	for name, tc := range failures {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeIntentClassification([]byte(tc.body), tc.headerVersion)
+			assert.True(t, errors.Is(err, ErrIntentSchemaMismatch), "got %v", err)
+		})
+	}
+}
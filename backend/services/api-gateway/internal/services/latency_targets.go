@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Degradations the enhancement pipeline applies to meet an intent's latency
+// target, in the order it considers them; each gives up more quality than
+// the one before
+const (
+	DegradationSkipSelector      = "skip_selector"      // Techniques come from a preset or the classifier's suggestions
+	DegradationFewerTechniques   = "fewer_techniques"   // Only the first few techniques are applied
+	DegradationSmallerGeneration = "smaller_generation" // The generated prompt is capped in tokens
+)
+
+const (
+	// latencyEstimateWeight is how much each new observation moves a
+	// stage's latency estimate; older ones decay geometrically
+	latencyEstimateWeight = 0.2
+
+	// latencyEstimateMaxAge is how long an estimate is trusted without a new
+	// observation. A stage skipped because it looked slow would otherwise
+	// never be measured again; once its estimate expires the next request
+	// runs it.
+	latencyEstimateMaxAge = time.Minute
+)
+
+var latencyDegradations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "enhance_latency_degradations_total",
+	Help: "Number of enhancements degraded to meet their intent's latency target",
+}, []string{"intent", "degradation"})
+
+// LatencyTargetConfig sets how long enhancements of each intent should take
+type LatencyTargetConfig struct {
+	Default       time.Duration            // Target for intents without their own; zero leaves them alone
+	Intents       map[string]time.Duration // Targets by intent
+	MaxTechniques int                      // Techniques kept by DegradationFewerTechniques
+	MaxTokens     int                      // Tokens allowed by DegradationSmallerGeneration
+}
+
+// LoadLatencyTargetConfig reads ENHANCE_LATENCY_TARGETS, a comma-separated
+// list of intent=duration pairs where the intent "default" covers every
+// intent not listed, and ENHANCE_DEGRADED_MAX_TECHNIQUES and
+// ENHANCE_DEGRADED_MAX_TOKENS
+func LoadLatencyTargetConfig() LatencyTargetConfig {
+	config := LatencyTargetConfig{
+		Intents:       make(map[string]time.Duration),
+		MaxTechniques: 2,
+		MaxTokens:     300,
+	}
+	for _, entry := range strings.Split(os.Getenv("ENHANCE_LATENCY_TARGETS"), ",") {
+		intent, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		target, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil || target <= 0 {
+			continue
+		}
+		if intent = strings.TrimSpace(intent); intent == "default" {
+			config.Default = target
+		} else if intent != "" {
+			config.Intents[intent] = target
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("ENHANCE_DEGRADED_MAX_TECHNIQUES")); err == nil && n > 0 {
+		config.MaxTechniques = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("ENHANCE_DEGRADED_MAX_TOKENS")); err == nil && n > 0 {
+		config.MaxTokens = n
+	}
+	return config
+}
+
+// Enabled reports whether any intent has a target
+func (c LatencyTargetConfig) Enabled() bool {
+	return c.Default > 0 || len(c.Intents) > 0
+}
+
+// LatencyTargets plans enhancements around their intent's latency target.
+// It estimates how long the selector and generation will take from what
+// they took recently, and plans which degradations a request needs for the
+// time it has left.
+type LatencyTargets struct {
+	config LatencyTargetConfig
+
+	mu                sync.Mutex
+	selector          float64 // Estimated selector latency, in ms
+	selectorAt        time.Time
+	perTechnique      float64 // Estimated generation latency per technique plus one for the prompt itself, in ms
+	typicalTechniques float64 // Estimated techniques per generation
+	generationAt      time.Time
+}
+
+// NewLatencyTargets creates latency targets from config
+func NewLatencyTargets(config LatencyTargetConfig) *LatencyTargets {
+	return &LatencyTargets{config: config}
+}
+
+// Target returns the latency target for intent, or zero when it has none
+func (t *LatencyTargets) Target(intent string) time.Duration {
+	if target, ok := t.config.Intents[intent]; ok {
+		return target
+	}
+	return t.config.Default
+}
+
+// ObserveSelector records how long a technique selection took
+func (t *LatencyTargets) ObserveSelector(elapsed time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.selector = weigh(t.selector, msOf(elapsed), t.selectorAt)
+	t.selectorAt = time.Now()
+}
+
+// ObserveGeneration records how long generating with techniques took
+func (t *LatencyTargets) ObserveGeneration(elapsed time.Duration, techniques int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.perTechnique = weigh(t.perTechnique, msOf(elapsed)/float64(techniques+1), t.generationAt)
+	t.typicalTechniques = weigh(t.typicalTechniques, float64(techniques), t.generationAt)
+	t.generationAt = time.Now()
+}
+
+// weigh moves an estimate last updated at towards observed; expired
+// estimates start over from it
+func weigh(estimate, observed float64, at time.Time) float64 {
+	if time.Since(at) > latencyEstimateMaxAge {
+		return observed
+	}
+	return estimate + latencyEstimateWeight*(observed-estimate)
+}
+
+func msOf(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// estimates returns the selector estimate and generation estimate for
+// techniques, negative techniques standing for a typical generation. Stages
+// without a current estimate are estimated to take no time.
+func (t *LatencyTargets) estimates(techniques int) (selector, generation time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.selectorAt) <= latencyEstimateMaxAge {
+		selector = time.Duration(t.selector * float64(time.Millisecond))
+	}
+	if time.Since(t.generationAt) <= latencyEstimateMaxAge {
+		n := float64(techniques)
+		if techniques < 0 {
+			n = t.typicalTechniques
+		}
+		generation = time.Duration(t.perTechnique * (n + 1) * float64(time.Millisecond))
+	}
+	return selector, generation
+}
+
+// Plan starts planning an enhancement of intent that started at start. It
+// returns nil, which never degrades anything, when the intent has no
+// target.
+func (t *LatencyTargets) Plan(intent string, start time.Time) *LatencyPlan {
+	if t == nil {
+		return nil
+	}
+	target := t.Target(intent)
+	if target <= 0 {
+		return nil
+	}
+	return &LatencyPlan{targets: t, intent: intent, target: target, deadline: start.Add(target)}
+}
+
+// LatencyPlan decides, stage by stage, which degradations one enhancement
+// needs to finish within its target. Each decision compares the time left
+// with the estimated time of the stages still to run. Its methods are safe
+// to call on a nil plan.
+type LatencyPlan struct {
+	targets      *LatencyTargets
+	intent       string
+	target       time.Duration
+	deadline     time.Time
+	degradations []string
+}
+
+// LatencyReport describes the degradations applied to an enhancement
+type LatencyReport struct {
+	TargetMs     int64    `json:"target_ms"`
+	Degradations []string `json:"degradations"`
+}
+
+// SkipSelector reports whether technique selection should be skipped
+// because it and a typical generation wouldn't fit in the time left
+func (p *LatencyPlan) SkipSelector() bool {
+	if p == nil {
+		return false
+	}
+	selector, generation := p.targets.estimates(-1)
+	return p.degradeIf(DegradationSkipSelector, selector+generation)
+}
+
+// LimitTechniques trims techniques to the configured maximum when
+// generating with all of them wouldn't fit in the time left
+func (p *LatencyPlan) LimitTechniques(techniques []string) []string {
+	limit := 0
+	if p != nil {
+		limit = p.targets.config.MaxTechniques
+	}
+	if limit <= 0 || len(techniques) <= limit {
+		return techniques
+	}
+	_, generation := p.targets.estimates(len(techniques))
+	if !p.degradeIf(DegradationFewerTechniques, generation) {
+		return techniques
+	}
+	return techniques[:limit]
+}
+
+// MaxTokens returns the token cap for a generation with techniques that
+// wouldn't otherwise fit in the time left, or zero for no cap
+func (p *LatencyPlan) MaxTokens(techniques int) int {
+	if p == nil {
+		return 0
+	}
+	_, generation := p.targets.estimates(techniques)
+	if !p.degradeIf(DegradationSmallerGeneration, generation) {
+		return 0
+	}
+	return p.targets.config.MaxTokens
+}
+
+// Report returns the degradations applied so far, or nil when there were
+// none
+func (p *LatencyPlan) Report() *LatencyReport {
+	if p == nil || len(p.degradations) == 0 {
+		return nil
+	}
+	return &LatencyReport{TargetMs: p.target.Milliseconds(), Degradations: p.degradations}
+}
+
+func (p *LatencyPlan) degradeIf(degradation string, needed time.Duration) bool {
+	if time.Until(p.deadline) >= needed {
+		return false
+	}
+	p.degradations = append(p.degradations, degradation)
+	latencyDegradations.WithLabelValues(p.intent, degradation).Inc()
+	return true
+}
+
+type maxTokensContextKey struct{}
+
+// WithMaxGenerationTokens caps the tokens the prompt generator may produce
+// for requests made with the returned context
+func WithMaxGenerationTokens(ctx context.Context, maxTokens int) context.Context {
+	return context.WithValue(ctx, maxTokensContextKey{}, maxTokens)
+}
+
+func maxGenerationTokensFromContext(ctx context.Context) int {
+	maxTokens, _ := ctx.Value(maxTokensContextKey{}).(int)
+	return maxTokens
+}
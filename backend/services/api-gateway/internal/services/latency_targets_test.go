@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLatencyTargetConfig(t *testing.T) {
+	t.Setenv("ENHANCE_LATENCY_TARGETS", "question_answering=1500ms, default=8s,analysis=bogus,=2s,code_generation=-1s")
+	t.Setenv("ENHANCE_DEGRADED_MAX_TECHNIQUES", "1")
+
+	config := LoadLatencyTargetConfig()
+	assert.True(t, config.Enabled())
+	assert.Equal(t, 8*time.Second, config.Default)
+	assert.Equal(t, map[string]time.Duration{"question_answering": 1500 * time.Millisecond}, config.Intents)
+	assert.Equal(t, 1, config.MaxTechniques)
+	assert.Equal(t, 300, config.MaxTokens)
+
+	t.Setenv("ENHANCE_LATENCY_TARGETS", "")
+	assert.False(t, LoadLatencyTargetConfig().Enabled())
+}
+
+func TestLatencyPlan(t *testing.T) {
+	config := LatencyTargetConfig{
+		Default:       time.Second,
+		Intents:       map[string]time.Duration{"analysis": time.Minute},
+		MaxTechniques: 2,
+		MaxTokens:     200,
+	}
+	techniques := []string{"chain_of_thought", "few_shot", "structured_output"}
+
+	t.Run("without estimates nothing is degraded", func(t *testing.T) {
+		plan := NewLatencyTargets(config).Plan("reasoning", time.Now())
+		assert.False(t, plan.SkipSelector())
+		assert.Equal(t, techniques, plan.LimitTechniques(techniques))
+		assert.Zero(t, plan.MaxTokens(3))
+		assert.Nil(t, plan.Report())
+	})
+
+	t.Run("degrades only as far as needed", func(t *testing.T) {
+		targets := NewLatencyTargets(config)
+		targets.ObserveSelector(300 * time.Millisecond)
+		targets.ObserveGeneration(800*time.Millisecond, 3) // 200ms per technique and for the prompt
+
+		// 1s left: selector and a typical generation need 1.1s
+		plan := targets.Plan("reasoning", time.Now())
+		assert.True(t, plan.SkipSelector())
+		assert.Equal(t, techniques, plan.LimitTechniques(techniques), "three techniques need 800ms")
+		assert.Zero(t, plan.MaxTokens(3))
+		assert.Equal(t, &LatencyReport{TargetMs: 1000, Degradations: []string{DegradationSkipSelector}}, plan.Report())
+
+		// 500ms left
+		plan = targets.Plan("reasoning", time.Now().Add(-500*time.Millisecond))
+		assert.True(t, plan.SkipSelector())
+		assert.Equal(t, techniques[:2], plan.LimitTechniques(techniques))
+		assert.Equal(t, 200, plan.MaxTokens(2), "two techniques need 600ms")
+		assert.Equal(t, []string{DegradationSkipSelector, DegradationFewerTechniques, DegradationSmallerGeneration}, plan.Report().Degradations)
+
+		// Plenty of time for analysis
+		plan = targets.Plan("analysis", time.Now())
+		assert.False(t, plan.SkipSelector())
+		assert.Nil(t, plan.Report())
+	})
+
+	t.Run("intents without a target", func(t *testing.T) {
+		targets := NewLatencyTargets(LatencyTargetConfig{Intents: map[string]time.Duration{"analysis": time.Second}})
+		targets.ObserveSelector(time.Hour)
+		plan := targets.Plan("reasoning", time.Now())
+		assert.Nil(t, plan)
+		assert.False(t, plan.SkipSelector())
+		assert.Equal(t, techniques, plan.LimitTechniques(techniques))
+		assert.Zero(t, plan.MaxTokens(3))
+
+		var disabled *LatencyTargets
+		assert.Nil(t, disabled.Plan("analysis", time.Now()))
+		disabled.ObserveGeneration(time.Second, 1)
+	})
+
+	t.Run("estimates weigh recent observations and expire", func(t *testing.T) {
+		targets := NewLatencyTargets(config)
+		targets.ObserveSelector(100 * time.Millisecond)
+		targets.ObserveSelector(600 * time.Millisecond)
+		selector, generation := targets.estimates(-1)
+		assert.Equal(t, 200*time.Millisecond, selector)
+		assert.Zero(t, generation)
+
+		targets.selectorAt = time.Now().Add(-2 * latencyEstimateMaxAge)
+		selector, _ = targets.estimates(-1)
+		assert.Zero(t, selector)
+		targets.ObserveSelector(50 * time.Millisecond)
+		selector, _ = targets.estimates(-1)
+		assert.Equal(t, 50*time.Millisecond, selector, "an expired estimate starts over")
+	})
+}
+
+func TestPromptGeneratorClientMaxTokens(t *testing.T) {
+	var bodies []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		bodies = append(bodies, body)
+		w.Write([]byte(`{"text":"enhanced","model_version":"v1"}`))
+	}))
+	defer server.Close()
+	client := &PromptGeneratorClient{baseURL: server.URL, client: http.DefaultClient}
+
+	_, err := client.GeneratePrompt(context.Background(), models.PromptGenerationRequest{Text: "hi"})
+	require.NoError(t, err)
+	_, err = client.GeneratePrompt(WithMaxGenerationTokens(context.Background(), 200), models.PromptGenerationRequest{Text: "hi"})
+	require.NoError(t, err)
+
+	require.Len(t, bodies, 2)
+	assert.NotContains(t, bodies[0], "max_tokens")
+	assert.Equal(t, "hi", bodies[1]["text"])
+	assert.Equal(t, float64(200), bodies[1]["max_tokens"])
+}
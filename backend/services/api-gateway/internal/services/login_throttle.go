@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// LoginThrottleConfig sets the failed-login limits. Failures are tracked per
+// (account, IP) pair, per account across IPs and per IP across accounts.
+type LoginThrottleConfig struct {
+	PairLimit  int // Failures allowed per account and IP within PairWindow
+	PairWindow time.Duration
+
+	// An account failing from DistributedIPs distinct IPs within
+	// DistributedWindow is treated as under a distributed attack, and every
+	// IP gets StrictPairLimit instead of PairLimit
+	DistributedIPs    int
+	DistributedWindow time.Duration
+	StrictPairLimit   int
+
+	// An IP failing against IPAccountLimit distinct accounts within IPWindow
+	// is blocked outright, which stops credential stuffing
+	IPAccountLimit int
+	IPWindow       time.Duration
+}
+
+// DefaultLoginThrottleConfig returns the production login limits
+func DefaultLoginThrottleConfig() LoginThrottleConfig {
+	return LoginThrottleConfig{
+		PairLimit:         5,
+		PairWindow:        15 * time.Minute,
+		DistributedIPs:    10,
+		DistributedWindow: 1 * time.Hour,
+		StrictPairLimit:   2,
+		IPAccountLimit:    20,
+		IPWindow:          15 * time.Minute,
+	}
+}
+
+// LoginThrottleDecision is the outcome of checking a login attempt
+type LoginThrottleDecision struct {
+	Allowed     bool
+	Reason      string // "account_ip", "distributed" or "ip" when blocked
+	RetryAfter  time.Duration
+	Distributed bool // The account is under a distributed attack
+}
+
+// LoginThrottle limits failed logins in Redis sliding windows
+type LoginThrottle struct {
+	cache  *CacheService
+	config LoginThrottleConfig
+}
+
+// NewLoginThrottle creates a login throttle
+func NewLoginThrottle(cache *CacheService, config LoginThrottleConfig) *LoginThrottle {
+	return &LoginThrottle{
+		cache:  cache,
+		config: config,
+	}
+}
+
+// Config returns the limits the throttle enforces
+func (t *LoginThrottle) Config() LoginThrottleConfig {
+	return t.config
+}
+
+// normalizeLoginIdentifier keys accounts by the email or username as typed,
+// so attempts against accounts that don't exist are throttled the same way
+func normalizeLoginIdentifier(identifier string) string {
+	return strings.ToLower(strings.TrimSpace(identifier))
+}
+
+func (t *LoginThrottle) pairKey(identifier, ip string) string {
+	return t.cache.Key("login_throttle", "pair", identifier, ip)
+}
+
+func (t *LoginThrottle) accountKey(identifier string) string {
+	return t.cache.Key("login_throttle", "account", identifier)
+}
+
+func (t *LoginThrottle) ipKey(ip string) string {
+	return t.cache.Key("login_throttle", "ip", ip)
+}
+
+// Check decides whether a login attempt for identifier from ip may proceed
+func (t *LoginThrottle) Check(ctx context.Context, identifier, ip string) (*LoginThrottleDecision, error) {
+	identifier = normalizeLoginIdentifier(identifier)
+	now := time.Now()
+	decision := &LoginThrottleDecision{Allowed: true}
+
+	pipe := t.cache.client.Pipeline()
+	ipCount := pipe.ZCount(ctx, t.ipKey(ip), windowStart(now, t.config.IPWindow), "+inf")
+	ipOldest := pipe.ZRangeByScoreWithScores(ctx, t.ipKey(ip), &redis.ZRangeBy{Min: windowStart(now, t.config.IPWindow), Max: "+inf", Count: 1})
+	accountIPs := pipe.ZCount(ctx, t.accountKey(identifier), windowStart(now, t.config.DistributedWindow), "+inf")
+	pairTimes := pipe.ZRangeByScoreWithScores(ctx, t.pairKey(identifier, ip), &redis.ZRangeBy{Min: windowStart(now, t.config.PairWindow), Max: "+inf"})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to check login throttle: %w", err)
+	}
+
+	if int(ipCount.Val()) >= t.config.IPAccountLimit {
+		decision.Allowed = false
+		decision.Reason = "ip"
+		decision.RetryAfter = retryAfter(now, ipOldest.Val(), 0, t.config.IPWindow)
+		return decision, nil
+	}
+
+	limit := t.config.PairLimit
+	decision.Distributed = int(accountIPs.Val()) >= t.config.DistributedIPs
+	if decision.Distributed {
+		limit = t.config.StrictPairLimit
+	}
+
+	if failures := pairTimes.Val(); len(failures) >= limit {
+		decision.Allowed = false
+		decision.Reason = "account_ip"
+		if decision.Distributed {
+			decision.Reason = "distributed"
+		}
+		// The pair unblocks once enough failures age out to drop below the limit
+		decision.RetryAfter = retryAfter(now, failures, len(failures)-limit, t.config.PairWindow)
+	}
+	return decision, nil
+}
+
+// RecordFailure counts a failed login. It reports true when this failure
+// makes the account cross the distributed attack threshold.
+func (t *LoginThrottle) RecordFailure(ctx context.Context, identifier, ip string) (bool, error) {
+	identifier = normalizeLoginIdentifier(identifier)
+	now := time.Now()
+	score := float64(now.UnixNano())
+
+	pipe := t.cache.client.TxPipeline()
+	pipe.ZAdd(ctx, t.pairKey(identifier, ip), &redis.Z{Score: score, Member: strconv.FormatInt(now.UnixNano(), 10)})
+	pipe.ZRemRangeByScore(ctx, t.pairKey(identifier, ip), "-inf", "("+windowStart(now, t.config.PairWindow))
+	pipe.Expire(ctx, t.pairKey(identifier, ip), t.config.PairWindow)
+
+	newIP := pipe.ZAdd(ctx, t.accountKey(identifier), &redis.Z{Score: score, Member: ip})
+	pipe.ZRemRangeByScore(ctx, t.accountKey(identifier), "-inf", "("+windowStart(now, t.config.DistributedWindow))
+	pipe.Expire(ctx, t.accountKey(identifier), t.config.DistributedWindow)
+	accountIPs := pipe.ZCard(ctx, t.accountKey(identifier))
+
+	pipe.ZAdd(ctx, t.ipKey(ip), &redis.Z{Score: score, Member: identifier})
+	pipe.ZRemRangeByScore(ctx, t.ipKey(ip), "-inf", "("+windowStart(now, t.config.IPWindow))
+	pipe.Expire(ctx, t.ipKey(ip), t.config.IPWindow)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	return newIP.Val() == 1 && int(accountIPs.Val()) == t.config.DistributedIPs, nil
+}
+
+// RecordSuccess clears the failures of the pair that just signed in, so a
+// user who eventually remembers their password isn't left throttled
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, identifier, ip string) error {
+	if err := t.cache.client.Del(ctx, t.pairKey(normalizeLoginIdentifier(identifier), ip)).Err(); err != nil {
+		return fmt.Errorf("failed to clear login failures: %w", err)
+	}
+	return nil
+}
+
+// windowStart is the lowest score inside a sliding window ending now
+func windowStart(now time.Time, window time.Duration) string {
+	return strconv.FormatInt(now.Add(-window).UnixNano(), 10)
+}
+
+// retryAfter is how long until the entry at index of an oldest-first list of
+// scored entries leaves the window, at least a second
+func retryAfter(now time.Time, entries []redis.Z, index int, window time.Duration) time.Duration {
+	wait := window
+	if index >= 0 && index < len(entries) {
+		wait = time.Unix(0, int64(entries[index].Score)).Add(window).Sub(now)
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	window := 15 * time.Minute
+	entries := []redis.Z{
+		{Score: float64(now.Add(-10 * time.Minute).UnixNano())},
+		{Score: float64(now.Add(-5 * time.Minute).UnixNano())},
+	}
+
+	assert.Equal(t, 5*time.Minute, retryAfter(now, entries, 0, window))
+	assert.Equal(t, 10*time.Minute, retryAfter(now, entries, 1, window))
+	assert.Equal(t, window, retryAfter(now, entries, 2, window), "falls back to the full window")
+	assert.Equal(t, time.Second, retryAfter(now, []redis.Z{{Score: float64(now.Add(-window).UnixNano())}}, 0, window), "never below a second")
+}
+
+func TestNormalizeLoginIdentifier(t *testing.T) {
+	assert.Equal(t, "alice@example.com", normalizeLoginIdentifier("  Alice@Example.com "))
+}
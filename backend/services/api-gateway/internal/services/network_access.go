@@ -0,0 +1,414 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// networkRulesRefresh is how often each gateway picks up rule changes made
+// by admins on other instances
+const networkRulesRefresh = 30 * time.Second
+
+// NetworkAccessRules configures IP and country based access control.
+// Allowlisted networks always pass; otherwise denylisted networks and
+// blocked countries are rejected. With DefaultDeny only allowlisted
+// networks are admitted.
+type NetworkAccessRules struct {
+	AllowCIDRs       []string  `json:"allow_cidrs"`
+	DenyCIDRs        []string  `json:"deny_cidrs"`
+	BlockedCountries []string  `json:"blocked_countries"`
+	DefaultDeny      bool      `json:"default_deny"`
+	UpdatedBy        string    `json:"updated_by,omitempty"`
+	UpdatedAt        time.Time `json:"updated_at,omitempty"`
+}
+
+// NetworkDecision is the outcome of checking a client IP
+type NetworkDecision struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason"`
+	Rule    string `json:"rule,omitempty"`
+	Country string `json:"country,omitempty"`
+}
+
+// Reasons reported in a NetworkDecision
+const (
+	NetworkReasonAllowlisted    = "allowlisted"
+	NetworkReasonDenylisted     = "denylisted"
+	NetworkReasonCountryBlocked = "country_blocked"
+	NetworkReasonNotAllowlisted = "not_allowlisted"
+	NetworkReasonDefault        = "default"
+)
+
+// CountryResolver maps an IP address to an ISO 3166-1 alpha-2 country code,
+// returning "" when the address is unknown
+type CountryResolver interface {
+	Country(ip net.IP) string
+}
+
+// compiledNetworkRules is the parsed form of NetworkAccessRules used on the request path
+type compiledNetworkRules struct {
+	rules     NetworkAccessRules
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	countries map[string]bool
+}
+
+// NetworkAccessService evaluates client IPs against admin-managed rules.
+// Rules are persisted in Redis so every gateway instance enforces the same
+// lists; each instance keeps a compiled copy and refreshes it periodically.
+type NetworkAccessService struct {
+	cache  *CacheService
+	geo    CountryResolver
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	compiled *compiledNetworkRules
+	loadedAt time.Time
+}
+
+// NewNetworkAccessService creates a network access service seeded with
+// defaults. Rules stored in Redis take precedence over the defaults once
+// loaded. cache and geo may be nil.
+func NewNetworkAccessService(cache *CacheService, geo CountryResolver, defaults NetworkAccessRules, logger *logrus.Logger) (*NetworkAccessService, error) {
+	compiled, err := compileNetworkRules(defaults)
+	if err != nil {
+		return nil, err
+	}
+	if len(compiled.countries) > 0 && geo == nil {
+		logger.Warn("Country blocking configured without a GeoIP database; country rules will not match")
+	}
+
+	return &NetworkAccessService{
+		cache:    cache,
+		geo:      geo,
+		logger:   logger,
+		compiled: compiled,
+	}, nil
+}
+
+// LoadNetworkAccessRules reads default rules from the environment.
+// NETWORK_ALLOW_CIDRS and NETWORK_DENY_CIDRS are comma-separated CIDRs or
+// single addresses, GEO_BLOCKED_COUNTRIES is a comma-separated list of
+// country codes and NETWORK_DEFAULT_DENY=true admits only allowlisted networks.
+func LoadNetworkAccessRules() NetworkAccessRules {
+	return NetworkAccessRules{
+		AllowCIDRs:       splitEnvList("NETWORK_ALLOW_CIDRS"),
+		DenyCIDRs:        splitEnvList("NETWORK_DENY_CIDRS"),
+		BlockedCountries: splitEnvList("GEO_BLOCKED_COUNTRIES"),
+		DefaultDeny:      os.Getenv("NETWORK_DEFAULT_DENY") == "true",
+	}
+}
+
+// LoadTrustedProxies reads TRUSTED_PROXIES, the comma-separated addresses
+// or CIDRs of the proxies in front of the gateway. Only they may say who the
+// client is with X-Forwarded-For; unset, no proxy is trusted.
+func LoadTrustedProxies() []string {
+	return splitEnvList("TRUSTED_PROXIES")
+}
+
+func splitEnvList(name string) []string {
+	var values []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Check decides whether a client IP may reach the API
+func (s *NetworkAccessService) Check(ctx context.Context, ip net.IP) NetworkDecision {
+	compiled := s.current(ctx)
+
+	if ip == nil {
+		if compiled.rules.DefaultDeny {
+			return NetworkDecision{Allowed: false, Reason: NetworkReasonNotAllowlisted}
+		}
+		return NetworkDecision{Allowed: true, Reason: NetworkReasonDefault}
+	}
+
+	if network := matchNetwork(compiled.allow, ip); network != "" {
+		return NetworkDecision{Allowed: true, Reason: NetworkReasonAllowlisted, Rule: network}
+	}
+	if network := matchNetwork(compiled.deny, ip); network != "" {
+		return NetworkDecision{Allowed: false, Reason: NetworkReasonDenylisted, Rule: network}
+	}
+	if compiled.rules.DefaultDeny {
+		return NetworkDecision{Allowed: false, Reason: NetworkReasonNotAllowlisted}
+	}
+
+	if len(compiled.countries) > 0 && s.geo != nil {
+		if country := s.geo.Country(ip); country != "" && compiled.countries[country] {
+			return NetworkDecision{Allowed: false, Reason: NetworkReasonCountryBlocked, Rule: country, Country: country}
+		}
+	}
+
+	return NetworkDecision{Allowed: true, Reason: NetworkReasonDefault}
+}
+
+// GetRules returns the rules currently being enforced
+func (s *NetworkAccessService) GetRules(ctx context.Context) NetworkAccessRules {
+	return s.current(ctx).rules
+}
+
+// UpdateRules validates and replaces the rules, persisting them to Redis so
+// other gateway instances pick them up on their next refresh
+func (s *NetworkAccessService) UpdateRules(ctx context.Context, rules NetworkAccessRules, updatedBy string) (NetworkAccessRules, error) {
+	rules.UpdatedBy = updatedBy
+	rules.UpdatedAt = time.Now().UTC()
+
+	compiled, err := compileNetworkRules(rules)
+	if err != nil {
+		return NetworkAccessRules{}, err
+	}
+
+	if s.cache != nil {
+		data, err := json.Marshal(compiled.rules)
+		if err != nil {
+			return NetworkAccessRules{}, fmt.Errorf("failed to marshal network rules: %w", err)
+		}
+		if err := s.cache.client.Set(ctx, s.rulesKey(), data, 0).Err(); err != nil {
+			return NetworkAccessRules{}, fmt.Errorf("failed to store network rules: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.compiled = compiled
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"audit":             true,
+		"updated_by":        updatedBy,
+		"allow_cidrs":       compiled.rules.AllowCIDRs,
+		"deny_cidrs":        compiled.rules.DenyCIDRs,
+		"blocked_countries": compiled.rules.BlockedCountries,
+		"default_deny":      compiled.rules.DefaultDeny,
+	}).Info("Network access rules updated")
+
+	return compiled.rules, nil
+}
+
+// current returns the compiled rules, reloading them from Redis when stale.
+// A failed reload keeps enforcing the last known rules.
+func (s *NetworkAccessService) current(ctx context.Context) *compiledNetworkRules {
+	s.mu.RLock()
+	compiled, stale := s.compiled, time.Since(s.loadedAt) > networkRulesRefresh
+	s.mu.RUnlock()
+
+	if !stale || s.cache == nil {
+		return compiled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.loadedAt) <= networkRulesRefresh {
+		return s.compiled
+	}
+	s.loadedAt = time.Now()
+
+	data, err := s.cache.client.Get(ctx, s.rulesKey()).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			s.logger.WithError(err).Warn("Failed to refresh network access rules")
+		}
+		return s.compiled
+	}
+
+	var rules NetworkAccessRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		s.logger.WithError(err).Error("Stored network access rules are invalid")
+		return s.compiled
+	}
+	reloaded, err := compileNetworkRules(rules)
+	if err != nil {
+		s.logger.WithError(err).Error("Stored network access rules are invalid")
+		return s.compiled
+	}
+
+	s.compiled = reloaded
+	return s.compiled
+}
+
+func (s *NetworkAccessService) rulesKey() string {
+	return s.cache.Key("network_access", "rules")
+}
+
+// ValidateNetworkAccessRules reports malformed networks or country codes
+func ValidateNetworkAccessRules(rules NetworkAccessRules) error {
+	_, err := compileNetworkRules(rules)
+	return err
+}
+
+// compileNetworkRules validates rules and normalises them: single addresses
+// become host networks and country codes are upper-cased
+func compileNetworkRules(rules NetworkAccessRules) (*compiledNetworkRules, error) {
+	compiled := &compiledNetworkRules{countries: make(map[string]bool)}
+
+	var err error
+	if compiled.allow, rules.AllowCIDRs, err = parseNetworks(rules.AllowCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid allow list: %w", err)
+	}
+	if compiled.deny, rules.DenyCIDRs, err = parseNetworks(rules.DenyCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid deny list: %w", err)
+	}
+
+	countries := make([]string, 0, len(rules.BlockedCountries))
+	for _, code := range rules.BlockedCountries {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return nil, fmt.Errorf("invalid country code: %q", code)
+		}
+		if !compiled.countries[code] {
+			compiled.countries[code] = true
+			countries = append(countries, code)
+		}
+	}
+	rules.BlockedCountries = countries
+
+	if rules.AllowCIDRs == nil {
+		rules.AllowCIDRs = []string{}
+	}
+	if rules.DenyCIDRs == nil {
+		rules.DenyCIDRs = []string{}
+	}
+
+	compiled.rules = rules
+	return compiled, nil
+}
+
+func parseNetworks(values []string) ([]*net.IPNet, []string, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	normalized := make([]string, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+			}
+			if ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%q is not an IP address or CIDR", value)
+		}
+		networks = append(networks, network)
+		normalized = append(normalized, network.String())
+	}
+	return networks, normalized, nil
+}
+
+func matchNetwork(networks []*net.IPNet, ip net.IP) string {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return network.String()
+		}
+	}
+	return ""
+}
+
+// GeoIPDatabase resolves countries from a CSV of "network,country_code"
+// rows, e.g. a GeoLite2 country export joined with its location names.
+// Networks must not overlap.
+type GeoIPDatabase struct {
+	ranges []geoIPRange
+}
+
+type geoIPRange struct {
+	network *net.IPNet
+	start   net.IP
+	country string
+}
+
+// LoadGeoIPDatabase loads a GeoIP CSV file. A header row and comment lines
+// starting with '#' are skipped.
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	defer file.Close()
+
+	db := &GeoIPDatabase{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		cidr, country, ok := strings.Cut(text, ",")
+		if !ok {
+			return nil, fmt.Errorf("GeoIP database line %d: expected network,country_code", line)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("GeoIP database line %d: %w", line, err)
+		}
+
+		db.ranges = append(db.ranges, geoIPRange{
+			network: network,
+			start:   network.IP.To16(),
+			country: strings.ToUpper(strings.TrimSpace(country)),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read GeoIP database: %w", err)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+
+	return db, nil
+}
+
+// Len returns the number of networks in the database
+func (db *GeoIPDatabase) Len() int {
+	return len(db.ranges)
+}
+
+// Country returns the country code for ip, or "" if it isn't covered
+func (db *GeoIPDatabase) Country(ip net.IP) string {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return ""
+	}
+
+	// Find the last network starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip16) > 0
+	})
+	if i == 0 {
+		return ""
+	}
+
+	r := db.ranges[i-1]
+	if r.network.Contains(ip) {
+		return r.country
+	}
+	return ""
+}
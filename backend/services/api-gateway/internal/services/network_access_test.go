@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type staticCountries map[string]string
+
+func (s staticCountries) Country(ip net.IP) string {
+	return s[ip.String()]
+}
+
+func TestNetworkAccessCheck(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	geo := staticCountries{"198.51.100.9": "KP", "198.51.100.10": "DE"}
+	access, err := NewNetworkAccessService(nil, geo, NetworkAccessRules{
+		AllowCIDRs:       []string{"10.0.0.0/8"},
+		DenyCIDRs:        []string{"10.1.0.0/16", "203.0.113.7", "2001:db8::/32"},
+		BlockedCountries: []string{"kp"},
+	}, logger)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		ip      string
+		allowed bool
+		reason  string
+	}{
+		{"allowlist wins over denylist", "10.1.2.3", true, NetworkReasonAllowlisted},
+		{"denylisted address", "203.0.113.7", false, NetworkReasonDenylisted},
+		{"denylisted ipv6 network", "2001:db8::1", false, NetworkReasonDenylisted},
+		{"blocked country", "198.51.100.9", false, NetworkReasonCountryBlocked},
+		{"other country", "198.51.100.10", true, NetworkReasonDefault},
+		{"unknown address", "192.0.2.1", true, NetworkReasonDefault},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := access.Check(context.Background(), net.ParseIP(tt.ip))
+			assert.Equal(t, tt.allowed, decision.Allowed)
+			assert.Equal(t, tt.reason, decision.Reason)
+		})
+	}
+
+	t.Run("default deny admits only allowlisted networks", func(t *testing.T) {
+		_, err := access.UpdateRules(context.Background(), NetworkAccessRules{
+			AllowCIDRs:  []string{"10.0.0.0/8"},
+			DefaultDeny: true,
+		}, "admin-1")
+		require.NoError(t, err)
+
+		assert.True(t, access.Check(context.Background(), net.ParseIP("10.9.9.9")).Allowed)
+		decision := access.Check(context.Background(), net.ParseIP("192.0.2.1"))
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, NetworkReasonNotAllowlisted, decision.Reason)
+		assert.Equal(t, "admin-1", access.GetRules(context.Background()).UpdatedBy)
+	})
+}
+
+func TestValidateNetworkAccessRules(t *testing.T) {
+	assert.NoError(t, ValidateNetworkAccessRules(NetworkAccessRules{
+		AllowCIDRs:       []string{"192.0.2.0/24", "::1"},
+		BlockedCountries: []string{"us"},
+	}))
+	assert.Error(t, ValidateNetworkAccessRules(NetworkAccessRules{DenyCIDRs: []string{"not-an-ip"}}))
+	assert.Error(t, ValidateNetworkAccessRules(NetworkAccessRules{DenyCIDRs: []string{"10.0.0.0/33"}}))
+	assert.Error(t, ValidateNetworkAccessRules(NetworkAccessRules{BlockedCountries: []string{"USA"}}))
+}
+
+func TestLoadGeoIPDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"network,country_iso_code\n"+
+			"203.0.113.0/24,au\n"+
+			"# documentation ranges\n"+
+			"198.51.100.0/24,DE\n"+
+			"2001:db8::/32,JP\n"), 0o600))
+
+	db, err := LoadGeoIPDatabase(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, db.Len())
+
+	assert.Equal(t, "AU", db.Country(net.ParseIP("203.0.113.200")))
+	assert.Equal(t, "DE", db.Country(net.ParseIP("198.51.100.1")))
+	assert.Equal(t, "JP", db.Country(net.ParseIP("2001:db8::42")))
+	assert.Equal(t, "", db.Country(net.ParseIP("192.0.2.1")))
+	assert.Equal(t, "", db.Country(net.ParseIP("1.1.1.1")))
+}
@@ -0,0 +1,507 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// ParquetType is the type of a flat Parquet column
+type ParquetType int
+
+// Supported column types. Strings are UTF8 byte arrays and timestamps are
+// INT64 milliseconds since the epoch in UTC.
+const (
+	ParquetString ParquetType = iota
+	ParquetInt64
+	ParquetDouble
+	ParquetBool
+	ParquetTimestamp
+)
+
+// ParquetColumn describes one column of a flat Parquet schema
+type ParquetColumn struct {
+	Name     string      `json:"name"`
+	Type     ParquetType `json:"type"`
+	Optional bool        `json:"optional"`
+}
+
+// Physical types, converted types and enums from parquet.thrift
+const (
+	parquetPhysicalBoolean   = 0
+	parquetPhysicalInt64     = 2
+	parquetPhysicalDouble    = 5
+	parquetPhysicalByteArray = 6
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+
+	parquetPageData = 0
+)
+
+// parquetMagic starts and ends every Parquet file
+var parquetMagic = []byte("PAR1")
+
+// ParquetWriter writes rows to an uncompressed Parquet file with PLAIN
+// encoding. It supports flat schemas only, which is all the warehouse
+// exports need, and keeps each row group in memory until it is flushed.
+type ParquetWriter struct {
+	w        io.Writer
+	schema   []ParquetColumn
+	metadata map[string]string
+
+	offset    int64
+	rows      [][]interface{}
+	rowGroups []parquetRowGroup
+	numRows   int64
+	started   bool
+}
+
+type parquetRowGroup struct {
+	columns   []parquetColumnChunk
+	numRows   int64
+	totalSize int64
+}
+
+type parquetColumnChunk struct {
+	offset    int64
+	size      int64
+	numValues int64
+}
+
+// NewParquetWriter creates a writer for schema. metadata is stored in the
+// file footer as key/value pairs.
+func NewParquetWriter(w io.Writer, schema []ParquetColumn, metadata map[string]string) *ParquetWriter {
+	return &ParquetWriter{
+		w:        w,
+		schema:   schema,
+		metadata: metadata,
+	}
+}
+
+// Write buffers a row. Values are matched to columns by position and must
+// be string, int64, int, float64, bool or time.Time as the column type
+// requires, or nil for optional columns.
+func (p *ParquetWriter) Write(row []interface{}) error {
+	if len(row) != len(p.schema) {
+		return fmt.Errorf("row has %d values, schema has %d columns", len(row), len(p.schema))
+	}
+	for i, value := range row {
+		if err := checkParquetValue(p.schema[i], value); err != nil {
+			return err
+		}
+	}
+	p.rows = append(p.rows, row)
+	return nil
+}
+
+// Flush writes the buffered rows as a row group
+func (p *ParquetWriter) Flush() error {
+	if len(p.rows) == 0 {
+		return nil
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	group := parquetRowGroup{numRows: int64(len(p.rows))}
+	for i, column := range p.schema {
+		page, numValues := encodeParquetPage(column, p.rows, i)
+
+		header := &thriftWriter{}
+		header.i32Field(1, parquetPageData)
+		header.i32Field(2, int32(len(page)))
+		header.i32Field(3, int32(len(page)))
+		header.structField(5)
+		header.i32Field(1, int32(numValues))
+		header.i32Field(2, parquetEncodingPlain)
+		header.i32Field(3, parquetEncodingRLE)
+		header.i32Field(4, parquetEncodingRLE)
+		header.structEnd()
+		header.structEnd()
+
+		chunk := parquetColumnChunk{offset: p.offset, numValues: int64(numValues)}
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(page); err != nil {
+			return err
+		}
+		chunk.size = p.offset - chunk.offset
+		group.totalSize += chunk.size
+		group.columns = append(group.columns, chunk)
+	}
+
+	p.rowGroups = append(p.rowGroups, group)
+	p.numRows += group.numRows
+	p.rows = nil
+	return nil
+}
+
+// Close flushes buffered rows and writes the footer. It doesn't close the
+// underlying writer.
+func (p *ParquetWriter) Close() error {
+	if err := p.Flush(); err != nil {
+		return err
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+
+	footer := p.footer()
+	if err := p.write(footer); err != nil {
+		return err
+	}
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	if err := p.write(length); err != nil {
+		return err
+	}
+	return p.write(parquetMagic)
+}
+
+// NumRows returns the number of rows flushed so far
+func (p *ParquetWriter) NumRows() int64 {
+	return p.numRows
+}
+
+func (p *ParquetWriter) start() error {
+	if p.started {
+		return nil
+	}
+	p.started = true
+	return p.write(parquetMagic)
+}
+
+func (p *ParquetWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+	return err
+}
+
+// footer encodes the FileMetaData struct
+func (p *ParquetWriter) footer() []byte {
+	t := &thriftWriter{}
+	t.i32Field(1, 1)
+
+	t.listField(2, thriftStruct, len(p.schema)+1)
+	t.beginStruct()
+	t.binaryField(4, "schema")
+	t.i32Field(5, int32(len(p.schema)))
+	t.structEnd()
+	for _, column := range p.schema {
+		physical, converted := parquetTypes(column.Type)
+		t.beginStruct()
+		t.i32Field(1, physical)
+		repetition := int32(parquetRequired)
+		if column.Optional {
+			repetition = parquetOptional
+		}
+		t.i32Field(3, repetition)
+		t.binaryField(4, column.Name)
+		if converted >= 0 {
+			t.i32Field(6, converted)
+		}
+		t.structEnd()
+	}
+
+	t.i64Field(3, p.numRows)
+
+	t.listField(4, thriftStruct, len(p.rowGroups))
+	for _, group := range p.rowGroups {
+		t.beginStruct()
+		t.listField(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			physical, _ := parquetTypes(p.schema[i].Type)
+			t.beginStruct()
+			t.i64Field(2, chunk.offset)
+			t.structField(3)
+			t.i32Field(1, physical)
+			t.listField(2, thriftI32, 2)
+			t.listI32(parquetEncodingPlain)
+			t.listI32(parquetEncodingRLE)
+			t.listField(3, thriftBinary, 1)
+			t.listBinary(p.schema[i].Name)
+			t.i32Field(4, 0) // Uncompressed
+			t.i64Field(5, chunk.numValues)
+			t.i64Field(6, chunk.size)
+			t.i64Field(7, chunk.size)
+			t.i64Field(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64Field(2, group.totalSize)
+		t.i64Field(3, group.numRows)
+		t.structEnd()
+	}
+
+	if len(p.metadata) > 0 {
+		keys := make([]string, 0, len(p.metadata))
+		for key := range p.metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		t.listField(5, thriftStruct, len(keys))
+		for _, key := range keys {
+			t.beginStruct()
+			t.binaryField(1, key)
+			t.binaryField(2, p.metadata[key])
+			t.structEnd()
+		}
+	}
+
+	t.binaryField(6, "betterprompts api-gateway")
+	t.structEnd()
+	return t.buf.Bytes()
+}
+
+func parquetTypes(typ ParquetType) (physical, converted int32) {
+	switch typ {
+	case ParquetString:
+		return parquetPhysicalByteArray, parquetConvertedUTF8
+	case ParquetDouble:
+		return parquetPhysicalDouble, -1
+	case ParquetBool:
+		return parquetPhysicalBoolean, -1
+	case ParquetTimestamp:
+		return parquetPhysicalInt64, parquetConvertedTimestampMillis
+	default:
+		return parquetPhysicalInt64, -1
+	}
+}
+
+func checkParquetValue(column ParquetColumn, value interface{}) error {
+	if value == nil {
+		if !column.Optional {
+			return fmt.Errorf("column %s is required", column.Name)
+		}
+		return nil
+	}
+
+	ok := false
+	switch column.Type {
+	case ParquetString:
+		_, ok = value.(string)
+	case ParquetInt64:
+		switch value.(type) {
+		case int64, int:
+			ok = true
+		}
+	case ParquetDouble:
+		_, ok = value.(float64)
+	case ParquetBool:
+		_, ok = value.(bool)
+	case ParquetTimestamp:
+		_, ok = value.(time.Time)
+	}
+	if !ok {
+		return fmt.Errorf("column %s: unexpected value of type %T", column.Name, value)
+	}
+	return nil
+}
+
+// encodeParquetPage encodes column i of rows as a v1 data page body:
+// definition levels for optional columns followed by the PLAIN encoded
+// non-null values
+func encodeParquetPage(column ParquetColumn, rows [][]interface{}, i int) ([]byte, int) {
+	var page bytes.Buffer
+
+	if column.Optional {
+		levels := make([]bool, len(rows))
+		for r, row := range rows {
+			levels[r] = row[i] != nil
+		}
+		encoded := encodeDefinitionLevels(levels)
+		binary.Write(&page, binary.LittleEndian, uint32(len(encoded)))
+		page.Write(encoded)
+	}
+
+	var bits []bool
+	scratch := make([]byte, 8)
+	for _, row := range rows {
+		switch v := row[i].(type) {
+		case nil:
+		case string:
+			binary.LittleEndian.PutUint32(scratch, uint32(len(v)))
+			page.Write(scratch[:4])
+			page.WriteString(v)
+		case int64:
+			binary.LittleEndian.PutUint64(scratch, uint64(v))
+			page.Write(scratch)
+		case int:
+			binary.LittleEndian.PutUint64(scratch, uint64(int64(v)))
+			page.Write(scratch)
+		case float64:
+			binary.LittleEndian.PutUint64(scratch, math.Float64bits(v))
+			page.Write(scratch)
+		case time.Time:
+			binary.LittleEndian.PutUint64(scratch, uint64(v.UnixMilli()))
+			page.Write(scratch)
+		case bool:
+			bits = append(bits, v)
+		}
+	}
+
+	// Booleans are bit-packed, least significant bit first
+	if column.Type == ParquetBool {
+		packed := make([]byte, (len(bits)+7)/8)
+		for b, set := range bits {
+			if set {
+				packed[b/8] |= 1 << (b % 8)
+			}
+		}
+		page.Write(packed)
+	}
+
+	return page.Bytes(), len(rows)
+}
+
+// encodeDefinitionLevels encodes 0/1 levels with the RLE/bit-packing hybrid
+// encoding, using RLE runs only
+func encodeDefinitionLevels(levels []bool) []byte {
+	var buf bytes.Buffer
+	for start := 0; start < len(levels); {
+		end := start + 1
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		writeUvarint(&buf, uint64(end-start)<<1)
+		if levels[start] {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		start = end
+	}
+	return buf.Bytes()
+}
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes the subset of the Thrift compact protocol Parquet
+// metadata needs. Nested structs are tracked so field ids can be delta
+// encoded.
+type thriftWriter struct {
+	buf    bytes.Buffer
+	last   int16
+	parent []int16
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		writeUvarint(&t.buf, zigzag(int64(id)))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	writeUvarint(&t.buf, zigzag(v))
+}
+
+func (t *thriftWriter) binaryField(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listBinary(v)
+}
+
+// structField starts a nested struct field; end it with structEnd
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+// beginStruct starts a struct list element; end it with structEnd
+func (t *thriftWriter) beginStruct() {
+	t.parent = append(t.parent, t.last)
+	t.last = 0
+}
+
+// listField starts a list. Struct elements are written between beginStruct
+// and structEnd; other elements with the list helpers.
+func (t *thriftWriter) listField(id int16, elemType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		writeUvarint(&t.buf, uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	writeUvarint(&t.buf, zigzag(int64(v)))
+}
+
+func (t *thriftWriter) listBinary(v string) {
+	writeUvarint(&t.buf, uint64(len(v)))
+	t.buf.WriteString(v)
+}
+
+// structEnd writes a stop field and returns to the enclosing struct
+func (t *thriftWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.last = 0
+	if n := len(t.parent); n > 0 {
+		t.last = t.parent[n-1]
+		t.parent = t.parent[:n-1]
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+}
+
+// errParquetSchemaChanged is wrapped by CheckParquetSchemaEvolution
+var errParquetSchemaChanged = errors.New("incompatible schema change")
+
+// CheckParquetSchemaEvolution reports whether files written with next can
+// be read alongside files written with previous: existing columns keep
+// their position, type and nullability, and new columns are optional and
+// appended at the end
+func CheckParquetSchemaEvolution(previous, next []ParquetColumn) error {
+	if len(next) < len(previous) {
+		return fmt.Errorf("%w: %d columns removed", errParquetSchemaChanged, len(previous)-len(next))
+	}
+	for i, column := range previous {
+		if next[i] != column {
+			return fmt.Errorf("%w: column %d changed from %+v to %+v", errParquetSchemaChanged, i, column, next[i])
+		}
+	}
+	for _, column := range next[len(previous):] {
+		if !column.Optional {
+			return fmt.Errorf("%w: new column %s must be optional", errParquetSchemaChanged, column.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParquetWriterFileLayout(t *testing.T) {
+	schema := []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "score", Type: ParquetInt64, Optional: true},
+		{Name: "at", Type: ParquetTimestamp},
+	}
+
+	var buf bytes.Buffer
+	writer := NewParquetWriter(&buf, schema, map[string]string{"schema_version": "1"})
+	at := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, writer.Write([]interface{}{"a", int64(5), at}))
+	require.NoError(t, writer.Write([]interface{}{"b", nil, at}))
+	require.NoError(t, writer.Flush())
+	require.NoError(t, writer.Write([]interface{}{"c", 3, at}))
+	require.NoError(t, writer.Close())
+
+	data := buf.Bytes()
+	assert.Equal(t, int64(3), writer.NumRows())
+	assert.Equal(t, []byte("PAR1"), data[:4])
+	assert.Equal(t, []byte("PAR1"), data[len(data)-4:])
+
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8 : len(data)-4]))
+	require.Less(t, footerLen, len(data)-12)
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	assert.Contains(t, string(footer), "schema_version")
+	assert.True(t, bytes.HasSuffix(footer, []byte{0}), "footer struct ends with a stop field")
+
+	// Strings are PLAIN encoded with a length prefix, each in its row group
+	for _, id := range []string{"a", "b", "c"} {
+		assert.Equal(t, 1, bytes.Count(data, []byte("\x01\x00\x00\x00"+id)), id)
+	}
+}
+
+func TestParquetWriterRejectsBadRows(t *testing.T) {
+	writer := NewParquetWriter(&bytes.Buffer{}, []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "count", Type: ParquetInt64},
+	}, nil)
+
+	assert.Error(t, writer.Write([]interface{}{"a"}), "wrong number of values")
+	assert.Error(t, writer.Write([]interface{}{nil, int64(1)}), "null in required column")
+	assert.Error(t, writer.Write([]interface{}{"a", "1"}), "wrong type")
+	assert.NoError(t, writer.Write([]interface{}{"a", int64(1)}))
+}
+
+func TestEncodeDefinitionLevels(t *testing.T) {
+	encoded := encodeDefinitionLevels([]bool{true, true, true, false, true})
+
+	// Runs of 3x1, 1x0, 1x1 with headers of count<<1
+	assert.Equal(t, []byte{6, 1, 2, 0, 2, 1}, encoded)
+}
+
+func TestThriftWriterFieldDeltas(t *testing.T) {
+	tw := &thriftWriter{}
+	tw.i32Field(1, 1)
+	tw.structField(2)
+	tw.i64Field(1, -1)
+	tw.structEnd()
+	tw.i32Field(20, 3) // Too far for a delta
+	tw.structEnd()
+
+	assert.Equal(t, []byte{
+		0x15, 0x02, // field 1, i32, zigzag(1)
+		0x1c,       // field 2, struct
+		0x16, 0x01, // field 1, i64, zigzag(-1)
+		0x00,             // end of nested struct
+		0x05, 0x28, 0x06, // field 20 in long form, zigzag(3)
+		0x00,
+	}, tw.buf.Bytes())
+}
+
+func TestCheckParquetSchemaEvolution(t *testing.T) {
+	v1 := []ParquetColumn{
+		{Name: "id", Type: ParquetString},
+		{Name: "score", Type: ParquetInt64, Optional: true},
+	}
+
+	assert.NoError(t, CheckParquetSchemaEvolution(v1, v1))
+	assert.NoError(t, CheckParquetSchemaEvolution(v1, append(v1[:2:2], ParquetColumn{Name: "model", Type: ParquetString, Optional: true})))
+
+	changes := map[string][]ParquetColumn{
+		"removed column":         v1[:1],
+		"changed type":           {v1[0], {Name: "score", Type: ParquetDouble, Optional: true}},
+		"renamed column":         {v1[0], {Name: "rating", Type: ParquetInt64, Optional: true}},
+		"required new column":    append(v1[:2:2], ParquetColumn{Name: "model", Type: ParquetString}),
+		"now required":           {v1[0], {Name: "score", Type: ParquetInt64}},
+		"reordered columns":      {v1[1], v1[0]},
+		"inserted before others": {{Name: "model", Type: ParquetString, Optional: true}, v1[0], v1[1]},
+	}
+	for name, next := range changes {
+		err := CheckParquetSchemaEvolution(v1, next)
+		assert.True(t, errors.Is(err, errParquetSchemaChanged), name)
+	}
+}
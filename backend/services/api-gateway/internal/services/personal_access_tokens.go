@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/auth"
+	"github.com/lib/pq"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens, distinguishing
+// them from developer API keys (APIKeyPrefix) on the same Authorization header
+const PersonalAccessTokenPrefix = "bpat_"
+
+// Personal access token scopes. A token can only call the routes its scopes
+// grant; everything else still needs a signed-in session.
+const (
+	TokenScopeReadHistory = "read-history" // List, view and export prompt history
+	TokenScopeEnhance     = "enhance-only" // Enhance prompts as the user
+)
+
+// PersonalAccessTokenScopes lists the scopes a token can be granted
+var PersonalAccessTokenScopes = []string{TokenScopeReadHistory, TokenScopeEnhance}
+
+// Personal access token lifetimes
+const (
+	DefaultPersonalAccessTokenLifetime = 90 * 24 * time.Hour
+	MaxPersonalAccessTokenLifetime     = 365 * 24 * time.Hour
+)
+
+var (
+	// ErrPersonalAccessTokenNotFound is returned for tokens that don't exist,
+	// are already revoked or belong to another user
+	ErrPersonalAccessTokenNotFound = errors.New("personal access token not found")
+	// ErrPersonalAccessTokenNameTaken is returned when the user already has
+	// an active token with the name
+	ErrPersonalAccessTokenNameTaken = errors.New("personal access token name already exists")
+	// ErrInvalidPersonalAccessToken is returned for unknown, expired and
+	// revoked tokens, and tokens of deactivated users
+	ErrInvalidPersonalAccessToken = errors.New("invalid personal access token")
+	// ErrInvalidTokenScopes is returned when a token would be created without
+	// scopes or with unknown ones
+	ErrInvalidTokenScopes = errors.New("invalid token scopes")
+	// ErrInvalidTokenExpiry is returned for expiries in the past or beyond
+	// MaxPersonalAccessTokenLifetime
+	ErrInvalidTokenExpiry = errors.New("invalid token expiry")
+)
+
+// PersonalAccessToken is a token a user issued themselves, as stored in
+// auth.personal_access_tokens
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the token grants a scope. A token without a
+// scope is granted nothing.
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PersonalAccessTokenService manages users' personal access tokens
+type PersonalAccessTokenService struct {
+	db *DatabaseService
+}
+
+// NewPersonalAccessTokenService creates a new personal access token service
+func NewPersonalAccessTokenService(db *DatabaseService) *PersonalAccessTokenService {
+	return &PersonalAccessTokenService{db: db}
+}
+
+// normalizeTokenScopes checks scopes against PersonalAccessTokenScopes,
+// returning them sorted without duplicates
+func normalizeTokenScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := []string{}
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, s := range PersonalAccessTokenScopes {
+			known = known || s == scope
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidTokenScopes, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidTokenScopes)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// CreateToken issues a token for a user. A nil expiresAt expires it after
+// DefaultPersonalAccessTokenLifetime. The raw token is only returned here;
+// only its hash is stored.
+func (s *PersonalAccessTokenService) CreateToken(ctx context.Context, userID, name string, scopes []string, expiresAt *time.Time) (*PersonalAccessToken, string, error) {
+	scopes, err := normalizeTokenScopes(scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	expiry := now.Add(DefaultPersonalAccessTokenLifetime)
+	if expiresAt != nil {
+		if !expiresAt.After(now) || expiresAt.After(now.Add(MaxPersonalAccessTokenLifetime)) {
+			return nil, "", fmt.Errorf("%w: expires_at must be in the future and within %d days",
+				ErrInvalidTokenExpiry, int(MaxPersonalAccessTokenLifetime.Hours()/24))
+		}
+		expiry = *expiresAt
+	}
+
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	rawToken := PersonalAccessTokenPrefix + strings.TrimRight(secret, "=")
+
+	token := &PersonalAccessToken{UserID: userID, Name: name, Scopes: scopes, ExpiresAt: expiry}
+	err = s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO auth.personal_access_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		userID, name, HashAPIKey(rawToken), pq.Array(scopes), expiry).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, "", ErrPersonalAccessTokenNameTaken
+		}
+		return nil, "", fmt.Errorf("failed to create personal access token: %w", err)
+	}
+	return token, rawToken, nil
+}
+
+// ListTokens returns the user's unrevoked tokens, expired ones included,
+// newest first
+func (s *PersonalAccessTokenService) ListTokens(ctx context.Context, userID string) ([]*PersonalAccessToken, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, user_id, name, scopes, expires_at, last_used_at, last_used_ip, created_at
+		FROM auth.personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query personal access tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate personal access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken stops one of the user's tokens from authenticating
+func (s *PersonalAccessTokenService) RevokeToken(ctx context.Context, userID, tokenID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE auth.personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke personal access token: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrPersonalAccessTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a raw token to an unexpired, unrevoked token of an
+// active user, recording its use from ip
+func (s *PersonalAccessTokenService) Authenticate(ctx context.Context, rawToken, ip string) (*PersonalAccessToken, error) {
+	if !strings.HasPrefix(rawToken, PersonalAccessTokenPrefix) {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	token, err := scanPersonalAccessToken(s.db.DB.QueryRowContext(ctx, `
+		UPDATE auth.personal_access_tokens t
+		SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = COALESCE($2, t.last_used_ip)
+		FROM auth.users u
+		WHERE t.token_hash = $1
+		  AND t.revoked_at IS NULL
+		  AND t.expires_at > CURRENT_TIMESTAMP
+		  AND u.id = t.user_id AND u.is_active = true
+		RETURNING t.id, t.user_id, t.name, t.scopes, t.expires_at, t.last_used_at, t.last_used_ip, t.created_at`,
+		HashAPIKey(rawToken), nullableString(ip)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidPersonalAccessToken
+		}
+		return nil, err
+	}
+	return token, nil
+}
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	var lastUsedAt sql.NullTime
+	var lastUsedIP sql.NullString
+
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.Name, pq.Array(&token.Scopes), &token.ExpiresAt,
+		&lastUsedAt, &lastUsedIP, &token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan personal access token: %w", err)
+	}
+
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	token.LastUsedIP = lastUsedIP.String
+	return &token, nil
+}
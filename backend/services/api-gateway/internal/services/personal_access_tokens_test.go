@@ -0,0 +1,124 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTokenScopes(t *testing.T) {
+	scopes, err := normalizeTokenScopes([]string{"read-history", " enhance-only", "read-history"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{TokenScopeEnhance, TokenScopeReadHistory}, scopes)
+
+	for name, scopes := range map[string][]string{
+		"none":    nil,
+		"unknown": {"read-history", "admin"},
+		"api key": {"*"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := normalizeTokenScopes(scopes)
+			assert.ErrorIs(t, err, ErrInvalidTokenScopes)
+		})
+	}
+}
+
+func TestPersonalAccessTokenHasScope(t *testing.T) {
+	token := &PersonalAccessToken{Scopes: []string{TokenScopeReadHistory}}
+	assert.True(t, token.HasScope(TokenScopeReadHistory))
+	assert.False(t, token.HasScope(TokenScopeEnhance))
+	assert.False(t, (&PersonalAccessToken{}).HasScope(TokenScopeEnhance), "tokens without scopes grant nothing")
+}
+
+func TestCreatePersonalAccessToken(t *testing.T) {
+	ctx := context.Background()
+	const userID = "5f0c7a4e-7d1b-4c35-9a57-3c1b8b2a9d10"
+	newService := func() (*PersonalAccessTokenService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.rows = func(string) ([]string, [][]driver.Value) {
+			return []string{"id", "created_at"}, [][]driver.Value{{"tok-1", time.Now()}}
+		}
+		return NewPersonalAccessTokenService(NewDatabaseService(db.DB)), d
+	}
+
+	t.Run("stores only the hash", func(t *testing.T) {
+		tokens, d := newService()
+		token, rawToken, err := tokens.CreateToken(ctx, userID, "ci", []string{TokenScopeReadHistory}, nil)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(rawToken, PersonalAccessTokenPrefix))
+		assert.False(t, strings.HasPrefix(rawToken, APIKeyPrefix), "tokens don't pass for API keys")
+		assert.Equal(t, "tok-1", token.ID)
+		assert.WithinDuration(t, time.Now().Add(DefaultPersonalAccessTokenLifetime), token.ExpiresAt, time.Minute)
+
+		require.Len(t, d.args, 1)
+		assert.Equal(t, HashAPIKey(rawToken), d.args[0][2].Value)
+		for _, arg := range d.args[0] {
+			assert.NotEqual(t, rawToken, arg.Value)
+		}
+	})
+
+	t.Run("rejects bad expiries", func(t *testing.T) {
+		tokens, d := newService()
+		for _, expiresAt := range []time.Time{
+			time.Now().Add(-time.Minute),
+			time.Now().Add(MaxPersonalAccessTokenLifetime + time.Hour),
+		} {
+			_, _, err := tokens.CreateToken(ctx, userID, "ci", []string{TokenScopeEnhance}, &expiresAt)
+			assert.ErrorIs(t, err, ErrInvalidTokenExpiry)
+		}
+		assert.Empty(t, d.entries())
+	})
+}
+
+func TestAuthenticatePersonalAccessToken(t *testing.T) {
+	ctx := context.Background()
+	const rawToken = PersonalAccessTokenPrefix + "secret"
+	lastUsed := time.Now()
+
+	var found bool
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		columns := []string{"id", "user_id", "name", "scopes", "expires_at", "last_used_at", "last_used_ip", "created_at"}
+		if !found {
+			return columns, nil
+		}
+		return columns, [][]driver.Value{{
+			"tok-1", "user-1", "ci", []byte("{enhance-only,read-history}"),
+			lastUsed.Add(time.Hour), lastUsed, "203.0.113.7", lastUsed.Add(-time.Hour),
+		}}
+	}
+	tokens := NewPersonalAccessTokenService(NewDatabaseService(db.DB))
+
+	t.Run("resolves the owner and scopes", func(t *testing.T) {
+		found = true
+		token, err := tokens.Authenticate(ctx, rawToken, "203.0.113.7")
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", token.UserID)
+		assert.Equal(t, []string{TokenScopeEnhance, TokenScopeReadHistory}, token.Scopes)
+		assert.Equal(t, "203.0.113.7", token.LastUsedIP)
+
+		entries := d.entries()
+		query := entries[len(entries)-1]
+		assert.Contains(t, query, "t.revoked_at IS NULL")
+		assert.Contains(t, query, "t.expires_at > CURRENT_TIMESTAMP")
+		assert.Contains(t, query, "u.is_active = true")
+	})
+
+	t.Run("unknown, expired and revoked tokens are invalid", func(t *testing.T) {
+		found = false
+		_, err := tokens.Authenticate(ctx, rawToken, "")
+		assert.ErrorIs(t, err, ErrInvalidPersonalAccessToken)
+	})
+
+	t.Run("API keys are never looked up", func(t *testing.T) {
+		before := len(d.entries())
+		_, err := tokens.Authenticate(ctx, APIKeyPrefix+"secret", "")
+		assert.ErrorIs(t, err, ErrInvalidPersonalAccessToken)
+		assert.Len(t, d.entries(), before)
+	})
+}
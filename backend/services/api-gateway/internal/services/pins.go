@@ -0,0 +1,458 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// pinCacheTTL is how long a pinned version stays in Redis after it was last
+// read. Versions never change, so entries are only ever dropped, never
+// refreshed.
+const pinCacheTTL = 7 * 24 * time.Hour
+
+var (
+	// ErrPinNotFound is returned for pins and pin versions that don't exist
+	// or belong to another user
+	ErrPinNotFound = errors.New("pin not found")
+	// ErrAlreadyPinned is returned when a history entry is already a version
+	// of a pin
+	ErrAlreadyPinned = errors.New("history entry is already pinned")
+	// ErrPinsUnavailable is returned to members of organizations whose
+	// history is sealed under their own key or kept in another region
+	ErrPinsUnavailable = errors.New("pinning is not available for organizations with isolated or regional history")
+)
+
+// PinnedResult is one version of a pin: a copy of an enhancement taken when
+// it was pinned or re-enhanced, never changed afterwards
+type PinnedResult struct {
+	Version      int              `json:"version"`
+	HistoryID    string           `json:"history_id,omitempty"`
+	OriginalText string           `json:"original_text"`
+	EnhancedText string           `json:"enhanced_text"`
+	Intent       string           `json:"intent,omitempty"`
+	Complexity   string           `json:"complexity,omitempty"`
+	Techniques   []string         `json:"techniques_used"`
+	Versions     PipelineVersions `json:"versions"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+// Pin is a pinned enhancement with its current version
+type Pin struct {
+	ID        string        `json:"id"`
+	UserID    string        `json:"user_id"`
+	Name      string        `json:"name"`
+	Version   int           `json:"version"` // Current version
+	Result    *PinnedResult `json:"result,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// PinRef marks a history entry as a version of a pin
+type PinRef struct {
+	PinID   string `json:"id"`
+	Version int    `json:"version"`
+}
+
+// PinnedResultFromHistory copies a history entry into a pin version
+func PinnedResultFromHistory(entry *models.PromptHistory) PinnedResult {
+	result := PinnedResult{
+		HistoryID:    entry.ID,
+		OriginalText: entry.OriginalInput,
+		EnhancedText: entry.EnhancedOutput,
+		Intent:       entry.Intent.String,
+		Complexity:   entry.Complexity.String,
+		Techniques:   entry.TechniquesUsed,
+	}
+	// Stored as JSON, so versions come back as a map
+	if raw, err := json.Marshal(entry.Metadata["versions"]); err == nil {
+		json.Unmarshal(raw, &result.Versions)
+	}
+	if result.Versions.Model == "" {
+		result.Versions.Model, _ = entry.Metadata["model_version"].(string)
+	}
+	if result.Techniques == nil {
+		result.Techniques = []string{}
+	}
+	return result
+}
+
+// PinService keeps pinned enhancements. Pinned versions are served from
+// their copy, through Redis when configured; unlike cached enhancements
+// they are never regenerated, stale or not. The copies are plaintext in the
+// primary database, so members of organizations whose history is sealed or
+// kept in another region can't pin, nor read pins made before; they can
+// still unpin.
+type PinService struct {
+	db        *DatabaseService
+	cache     *CacheService // Optional; versions are read from Postgres each time when nil
+	accounts  accountLookup // Optional; nobody is refused when nil
+	keys      *TenantKeyService
+	residency *ResidencyService
+	logger    *logrus.Logger
+}
+
+// NewPinService creates a new pin service. accounts, keys and residency
+// tell which users' organizations keep their history apart; each may be
+// nil.
+func NewPinService(db *DatabaseService, cache *CacheService, accounts *AccountResolver, keys *TenantKeyService, residency *ResidencyService, logger *logrus.Logger) *PinService {
+	s := &PinService{db: db, cache: cache, keys: keys, residency: residency, logger: logger}
+	if accounts != nil {
+		s.accounts = accounts
+	}
+	return s
+}
+
+// checkAllowed refuses users whose organization's history may not be copied
+// into the primary database with ErrPinsUnavailable
+func (s *PinService) checkAllowed(ctx context.Context, userID string) error {
+	if s.accounts == nil {
+		return nil
+	}
+	account, err := s.accounts.Resolve(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve pin owner: %w", err)
+	}
+	if account.OrgID == "" {
+		return nil
+	}
+	if s.keys != nil {
+		isolated, err := s.keys.Isolated(ctx, account.OrgID)
+		if err != nil {
+			return err
+		}
+		if isolated {
+			return ErrPinsUnavailable
+		}
+	}
+	if s.residency != nil {
+		region, err := s.residency.Region(ctx, account.OrgID)
+		if err != nil {
+			return err
+		}
+		if region != s.residency.defaultRegion {
+			return ErrPinsUnavailable
+		}
+	}
+	return nil
+}
+
+// CreatePin pins result as the first version of a new pin
+func (s *PinService) CreatePin(ctx context.Context, userID, name string, result PinnedResult) (*Pin, error) {
+	if err := s.checkAllowed(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	pin := &Pin{UserID: userID, Name: name, Version: 1}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO prompts.pins (user_id, name)
+		VALUES ($1, $2)
+		RETURNING id, created_at, updated_at`,
+		userID, name).Scan(&pin.ID, &pin.CreatedAt, &pin.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pin: %w", err)
+	}
+
+	result.Version = pin.Version
+	if err := insertPinVersion(ctx, tx, pin.ID, &result); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pin: %w", err)
+	}
+
+	pin.Result = &result
+	return pin, nil
+}
+
+// AddVersion makes result the user's pin's next and current version
+func (s *PinService) AddVersion(ctx context.Context, userID, pinID string, result PinnedResult) (*Pin, error) {
+	if err := s.checkAllowed(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	pin, err := scanPin(tx.QueryRowContext(ctx, `
+		UPDATE prompts.pins
+		SET version = version + 1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+pinColumns, pinID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPinNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update pin: %w", err)
+	}
+
+	result.Version = pin.Version
+	if err := insertPinVersion(ctx, tx, pin.ID, &result); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit pin version: %w", err)
+	}
+
+	pin.Result = &result
+	return pin, nil
+}
+
+func insertPinVersion(ctx context.Context, tx *sql.Tx, pinID string, result *PinnedResult) error {
+	if result.HistoryID != "" {
+		if err := lockHistory(ctx, tx, []string{result.HistoryID}); err != nil {
+			return err
+		}
+	}
+	versions, err := json.Marshal(result.Versions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pipeline versions: %w", err)
+	}
+	if result.Techniques == nil {
+		result.Techniques = []string{}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO prompts.pin_versions (
+			pin_id, version, history_id, original_input, enhanced_output,
+			intent, complexity, techniques_used, versions
+		) VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8, $9)
+		RETURNING created_at`,
+		pinID, result.Version, result.HistoryID, result.OriginalText, result.EnhancedText,
+		result.Intent, result.Complexity, pq.Array(result.Techniques), versions,
+	).Scan(&result.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrAlreadyPinned
+		}
+		return fmt.Errorf("failed to store pin version: %w", err)
+	}
+	return nil
+}
+
+// GetPin returns one of the user's pins with its current version
+func (s *PinService) GetPin(ctx context.Context, userID, pinID string) (*Pin, error) {
+	if err := s.checkAllowed(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	pin, err := scanPin(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+pinColumns+`
+		FROM prompts.pins
+		WHERE id = $1 AND user_id = $2`, pinID, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPinNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pin: %w", err)
+	}
+
+	pin.Result, err = s.version(ctx, pin.ID, pin.Version)
+	if err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+// GetVersion returns a version of one of the user's pins
+func (s *PinService) GetVersion(ctx context.Context, userID, pinID string, version int) (*PinnedResult, error) {
+	if err := s.checkAllowed(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	var owned bool
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM prompts.pins WHERE id = $1 AND user_id = $2)`,
+		pinID, userID).Scan(&owned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pin: %w", err)
+	}
+	if !owned {
+		return nil, ErrPinNotFound
+	}
+	return s.version(ctx, pinID, version)
+}
+
+// version reads a pin version from the cache, falling back to Postgres and
+// caching what it read. Versions are immutable, so a cached copy is never
+// stale.
+func (s *PinService) version(ctx context.Context, pinID string, version int) (*PinnedResult, error) {
+	key := s.versionKey(pinID, version)
+	if s.cache != nil {
+		data, err := s.cache.client.Get(ctx, key).Bytes()
+		s.cache.countLookup(CacheNamespacePin, err == nil)
+		if err == nil {
+			var result PinnedResult
+			if err := json.Unmarshal(data, &result); err == nil {
+				return &result, nil
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			s.logger.WithError(err).Debug("Failed to read cached pin version")
+		}
+	}
+
+	var result PinnedResult
+	var historyID, intent, complexity sql.NullString
+	var versions []byte
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT version, history_id, original_input, enhanced_output, intent,
+			   complexity, techniques_used, versions, created_at
+		FROM prompts.pin_versions
+		WHERE pin_id = $1 AND version = $2`, pinID, version).Scan(
+		&result.Version, &historyID, &result.OriginalText, &result.EnhancedText, &intent,
+		&complexity, pq.Array(&result.Techniques), &versions, &result.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPinNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pin version: %w", err)
+	}
+	result.HistoryID = historyID.String
+	result.Intent = intent.String
+	result.Complexity = complexity.String
+	if err := json.Unmarshal(versions, &result.Versions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pipeline versions: %w", err)
+	}
+
+	if s.cache != nil {
+		if data, err := json.Marshal(result); err == nil {
+			if err := s.cache.client.Set(ctx, key, data, pinCacheTTL).Err(); err != nil {
+				s.logger.WithError(err).Debug("Failed to cache pin version")
+			}
+		}
+	}
+	return &result, nil
+}
+
+// ListPins returns the user's pins, most recently changed first, without
+// their results
+func (s *PinService) ListPins(ctx context.Context, userID string) ([]*Pin, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+pinColumns+`
+		FROM prompts.pins
+		WHERE user_id = $1
+		ORDER BY updated_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pins: %w", err)
+	}
+	defer rows.Close()
+
+	pins := []*Pin{}
+	for rows.Next() {
+		pin, err := scanPin(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pin: %w", err)
+		}
+		pins = append(pins, pin)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate pins: %w", err)
+	}
+	return pins, nil
+}
+
+// DeletePin unpins: the pin and its versions are deleted, and its ID stops
+// resolving. The history entries it was made from are left alone.
+func (s *PinService) DeletePin(ctx context.Context, userID, pinID string) error {
+	var version int
+	err := s.db.DB.QueryRowContext(ctx, `
+		DELETE FROM prompts.pins
+		WHERE id = $1 AND user_id = $2
+		RETURNING version`, pinID, userID).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPinNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete pin: %w", err)
+	}
+
+	if s.cache != nil {
+		keys := make([]string, version)
+		for i := range keys {
+			keys[i] = s.versionKey(pinID, i+1)
+		}
+		if err := s.cache.client.Del(ctx, keys...).Err(); err != nil {
+			s.logger.WithError(err).Warn("Failed to evict unpinned versions")
+		}
+	}
+	return nil
+}
+
+// lockHistory takes transaction-scoped locks on history entries, in a fixed
+// order so that two callers can't deadlock. Pinning an entry and bulk
+// deleting it both hold its lock.
+func lockHistory(ctx context.Context, tx *sql.Tx, ids []string) error {
+	_, err := tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(hashtext('history:' || id))
+		FROM (SELECT id FROM unnest($1::text[]) AS id ORDER BY id) ids`,
+		pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to lock history: %w", err)
+	}
+	return nil
+}
+
+// PinnedHistory returns the pins the given history entries of the user are
+// versions of, keyed by history ID. Entries that aren't pinned are left out.
+func (s *PinService) PinnedHistory(ctx context.Context, userID string, historyIDs []string) (map[string]PinRef, error) {
+	refs := map[string]PinRef{}
+	if len(historyIDs) == 0 {
+		return refs, nil
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT v.history_id, v.pin_id, v.version
+		FROM prompts.pin_versions v
+		JOIN prompts.pins p ON p.id = v.pin_id
+		WHERE p.user_id = $1 AND v.history_id = ANY($2::uuid[])`,
+		userID, pq.Array(historyIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pinned history: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var historyID string
+		var ref PinRef
+		if err := rows.Scan(&historyID, &ref.PinID, &ref.Version); err != nil {
+			return nil, fmt.Errorf("failed to scan pinned history: %w", err)
+		}
+		refs[historyID] = ref
+	}
+	return refs, rows.Err()
+}
+
+// versionKey is shared by every region; a version is the same everywhere
+func (s *PinService) versionKey(pinID string, version int) string {
+	return s.cache.Key("pins", pinID, strconv.Itoa(version))
+}
+
+const pinColumns = `id, user_id, name, version, created_at, updated_at`
+
+func scanPin(row rowScanner) (*Pin, error) {
+	var pin Pin
+	if err := row.Scan(&pin.ID, &pin.UserID, &pin.Name, &pin.Version, &pin.CreatedAt, &pin.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &pin, nil
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinnedResultFromHistory(t *testing.T) {
+	entry := &models.PromptHistory{
+		ID:             "history-1",
+		OriginalInput:  "explain recursion",
+		EnhancedOutput: "enhanced",
+		Metadata: map[string]interface{}{
+			"versions": map[string]interface{}{"gateway": "g-1", "model": "m-2"},
+		},
+	}
+	result := PinnedResultFromHistory(entry)
+	assert.Equal(t, "history-1", result.HistoryID)
+	assert.Equal(t, "m-2", result.Versions.Model)
+	assert.Equal(t, []string{}, result.Techniques)
+
+	// Entries from before pipeline versions were recorded
+	entry.Metadata = map[string]interface{}{"model_version": "m-1"}
+	assert.Equal(t, "m-1", PinnedResultFromHistory(entry).Versions.Model)
+}
+
+func TestGetPinScopedToOwner(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"id", "user_id", "name", "version", "created_at", "updated_at"}, nil
+	}
+	pins := NewPinService(NewDatabaseService(db.DB), nil, nil, nil, nil, logrus.New())
+
+	_, err := pins.GetPin(context.Background(), "user-2", "pin-1")
+	assert.ErrorIs(t, err, ErrPinNotFound)
+	assert.Contains(t, d.entries()[0], "user_id = $2")
+}
+
+func TestCreatePinLocksHistory(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "INSERT INTO prompts.pins") {
+			return []string{"id", "created_at", "updated_at"}, [][]driver.Value{{"pin-1", time.Now(), time.Now()}}
+		}
+		return []string{"created_at"}, [][]driver.Value{{time.Now()}}
+	}
+	pins := NewPinService(NewDatabaseService(db.DB), nil, nil, nil, nil, logrus.New())
+
+	_, err := pins.CreatePin(context.Background(), "user-1", "launch", PinnedResult{HistoryID: "history-1"})
+	require.NoError(t, err)
+
+	// Bulk deletes check for pins holding the same lock
+	var locked bool
+	for _, entry := range d.entries() {
+		locked = locked || strings.Contains(entry, "pg_advisory_xact_lock(hashtext('history:' || id))")
+		if strings.Contains(entry, "INSERT INTO prompts.pin_versions") {
+			assert.True(t, locked, "the entry is locked before it is pinned")
+		}
+	}
+	assert.True(t, locked)
+}
+
+func TestPinsRefusedForSeparatedHistory(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestTenantKeys(t)
+	_, err := keys.Isolate(ctx, "acme", "")
+	require.NoError(t, err)
+	residency := &ResidencyService{defaultRegion: ResidencyUS, regions: map[string]cachedResidency{
+		"globex":  {region: ResidencyEU, expires: time.Now().Add(time.Hour)},
+		"initech": {region: ResidencyUS, expires: time.Now().Add(time.Hour)},
+	}}
+
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"id", "user_id", "name", "version", "created_at", "updated_at"}, nil
+	}
+	pins := NewPinService(NewDatabaseService(db.DB), nil, nil, keys, residency, logrus.New())
+	pins.accounts = orgAccounts{"sealed": "acme", "regional": "globex", "member": "initech", "solo": ""}
+
+	for user, want := range map[string]error{
+		"sealed":   ErrPinsUnavailable,
+		"regional": ErrPinsUnavailable,
+		"member":   ErrPinNotFound,
+		"solo":     ErrPinNotFound,
+	} {
+		_, err := pins.GetPin(ctx, user, "pin-1")
+		assert.ErrorIs(t, err, want, user)
+	}
+
+	_, err = pins.CreatePin(ctx, "sealed", "", PinnedResult{OriginalText: "secret prompt"})
+	assert.ErrorIs(t, err, ErrPinsUnavailable)
+	for _, entry := range d.entries() {
+		assert.NotContains(t, entry, "INSERT INTO prompts.pin_versions", "nothing is copied")
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+)
+
+// ErrPromptAccessDenied is returned when the caller may not act on a prompt
+var ErrPromptAccessDenied = errors.New("access denied")
+
+// PromptAction is something a caller does with a prompt resource
+type PromptAction string
+
+const (
+	PromptActionRead    PromptAction = "read"    // View, export or rerun
+	PromptActionRate    PromptAction = "rate"    // Give or change feedback
+	PromptActionModify  PromptAction = "modify"  // Favorite, edit or reshare
+	PromptActionComment PromptAction = "comment" // Join the discussion on a saved prompt
+	PromptActionEdit    PromptAction = "edit"    // Join a live editing session on a saved prompt
+	PromptActionDelete  PromptAction = "delete"
+)
+
+// PromptGrant is why a caller was allowed to act on a prompt resource
+type PromptGrant string
+
+const (
+	PromptGrantOwner      PromptGrant = "owner"
+	PromptGrantAdmin      PromptGrant = "admin"
+	PromptGrantOrgMember  PromptGrant = "org_member"
+	PromptGrantShareToken PromptGrant = "share_token"
+)
+
+// promptPolicy lists the actions each grant allows. Owners do anything,
+// admins read and remove content for support and moderation, members of
+// the owner's organization read, comment and edit together with the owner,
+// and share-token holders only read.
+var promptPolicy = map[PromptGrant][]PromptAction{
+	PromptGrantOwner:      {PromptActionRead, PromptActionRate, PromptActionModify, PromptActionComment, PromptActionEdit, PromptActionDelete},
+	PromptGrantAdmin:      {PromptActionRead, PromptActionDelete},
+	PromptGrantOrgMember:  {PromptActionRead, PromptActionComment, PromptActionEdit},
+	PromptGrantShareToken: {PromptActionRead},
+}
+
+// PromptResource is who a prompt resource belongs to and how it is shared
+type PromptResource struct {
+	Kind       string // "history" or "saved_prompt"
+	ID         string
+	OwnerID    string // Empty for anonymous history
+	ShareToken string // Empty when the resource can't be reached by token
+}
+
+// HistoryResource describes a prompt history entry. History is never shared
+// by token.
+func HistoryResource(entry *models.PromptHistory) PromptResource {
+	return PromptResource{Kind: "history", ID: entry.ID, OwnerID: entry.UserID.String}
+}
+
+// SavedPromptResource describes a saved prompt; its share token only opens
+// it while it is public
+func SavedPromptResource(saved *models.SavedPrompt) PromptResource {
+	resource := PromptResource{Kind: "saved_prompt", ID: saved.ID, OwnerID: saved.UserID}
+	if saved.IsPublic && saved.ShareToken.Valid {
+		resource.ShareToken = saved.ShareToken.String
+	}
+	return resource
+}
+
+// accountLookup resolves the organization of a user
+type accountLookup interface {
+	Resolve(ctx context.Context, userID string) (Account, error)
+}
+
+// PromptAuthorizer decides who may act on prompt history and saved prompts.
+// Every handler serving a single prompt resource goes through it, so the
+// policy lives in one place.
+type PromptAuthorizer struct {
+	accounts accountLookup // Nil turns organization access off
+}
+
+// NewPromptAuthorizer creates a prompt authorizer. Without an account
+// resolver organization members get no access to each other's prompts.
+func NewPromptAuthorizer(accounts *AccountResolver) *PromptAuthorizer {
+	if accounts == nil {
+		return &PromptAuthorizer{}
+	}
+	return &PromptAuthorizer{accounts: accounts}
+}
+
+// Authorize returns the strongest grant the caller holds on a resource that
+// allows action, or ErrPromptAccessDenied. shareToken is the token the
+// caller presented, if any.
+func (a *PromptAuthorizer) Authorize(ctx context.Context, rc *RequestContext, resource PromptResource, action PromptAction, shareToken string) (PromptGrant, error) {
+	var grants []PromptGrant
+	if rc.Authenticated() && resource.OwnerID != "" && rc.UserID == resource.OwnerID {
+		grants = append(grants, PromptGrantOwner)
+	}
+	if slices.Contains(rc.Roles, "admin") {
+		grants = append(grants, PromptGrantAdmin)
+	}
+	if resource.ShareToken != "" && shareToken != "" &&
+		subtle.ConstantTimeCompare([]byte(resource.ShareToken), []byte(shareToken)) == 1 {
+		grants = append(grants, PromptGrantShareToken)
+	}
+	for _, grant := range grants {
+		if slices.Contains(promptPolicy[grant], action) {
+			return grant, nil
+		}
+	}
+
+	// Organization membership takes a lookup, so it is checked last and only
+	// when it could allow the action
+	if a.accounts != nil && rc.Authenticated() && rc.OrgID != "" && resource.OwnerID != "" &&
+		slices.Contains(promptPolicy[PromptGrantOrgMember], action) {
+		owner, err := a.accounts.Resolve(ctx, resource.OwnerID)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve prompt owner: %w", err)
+		}
+		if owner.OrgID == rc.OrgID {
+			return PromptGrantOrgMember, nil
+		}
+	}
+	return "", ErrPromptAccessDenied
+}
+
+// AuthorizeUser is Authorize for a user other than the caller, such as one
+// mentioned in a comment. Only ownership and organization membership count;
+// roles and share tokens belong to a request.
+func (a *PromptAuthorizer) AuthorizeUser(ctx context.Context, userID string, resource PromptResource, action PromptAction) (PromptGrant, error) {
+	rc := &RequestContext{UserID: userID}
+	if a.accounts != nil && userID != resource.OwnerID {
+		account, err := a.accounts.Resolve(ctx, userID)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve user: %w", err)
+		}
+		rc.OrgID = account.OrgID
+	}
+	return a.Authorize(ctx, rc, resource, action, "")
+}
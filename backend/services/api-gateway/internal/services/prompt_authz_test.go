@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orgAccounts resolves users to the organizations in the map
+type orgAccounts map[string]string
+
+func (a orgAccounts) Resolve(_ context.Context, userID string) (Account, error) {
+	org, ok := a[userID]
+	if !ok {
+		return Account{}, errors.New("user not found")
+	}
+	return Account{OrgID: org}, nil
+}
+
+func TestPromptAuthorizer(t *testing.T) {
+	ctx := context.Background()
+	authz := &PromptAuthorizer{accounts: orgAccounts{"owner": "acme", "colleague": "acme", "outsider": "globex"}}
+
+	history := HistoryResource(&models.PromptHistory{ID: "history-1", UserID: sql.NullString{String: "owner", Valid: true}})
+	anonymous := HistoryResource(&models.PromptHistory{ID: "history-2"})
+	shared := SavedPromptResource(&models.SavedPrompt{
+		ID: "saved-1", UserID: "owner", IsPublic: true,
+		ShareToken: sql.NullString{String: "token-1", Valid: true},
+	})
+	unshared := SavedPromptResource(&models.SavedPrompt{
+		ID: "saved-2", UserID: "owner",
+		ShareToken: sql.NullString{String: "token-2", Valid: true},
+	})
+
+	owner := &RequestContext{UserID: "owner", OrgID: "acme"}
+	colleague := &RequestContext{UserID: "colleague", OrgID: "acme"}
+	outsider := &RequestContext{UserID: "outsider", OrgID: "globex"}
+	admin := &RequestContext{UserID: "admin", Roles: []string{"user", "admin"}}
+	guest := &RequestContext{}
+
+	tests := []struct {
+		name     string
+		rc       *RequestContext
+		resource PromptResource
+		action   PromptAction
+		token    string
+		grant    PromptGrant // Empty when access is denied
+	}{
+		{"owner reads", owner, history, PromptActionRead, "", PromptGrantOwner},
+		{"owner rates", owner, history, PromptActionRate, "", PromptGrantOwner},
+		{"owner modifies", owner, history, PromptActionModify, "", PromptGrantOwner},
+		{"owner deletes", owner, history, PromptActionDelete, "", PromptGrantOwner},
+		{"admin reads", admin, history, PromptActionRead, "", PromptGrantAdmin},
+		{"admin deletes", admin, history, PromptActionDelete, "", PromptGrantAdmin},
+		{"admin can't rate", admin, history, PromptActionRate, "", ""},
+		{"admin can't modify", admin, history, PromptActionModify, "", ""},
+		{"admin reads anonymous history", admin, anonymous, PromptActionRead, "", PromptGrantAdmin},
+		{"org member reads", colleague, history, PromptActionRead, "", PromptGrantOrgMember},
+		{"org member can't rate", colleague, history, PromptActionRate, "", ""},
+		{"org member can't delete", colleague, history, PromptActionDelete, "", ""},
+		{"other org can't read", outsider, history, PromptActionRead, "", ""},
+		{"nobody owns anonymous history", outsider, anonymous, PromptActionRead, "", ""},
+		{"token holder reads a public prompt", guest, shared, PromptActionRead, "token-1", PromptGrantShareToken},
+		{"token holder can't modify", guest, shared, PromptActionModify, "token-1", ""},
+		{"wrong token", guest, shared, PromptActionRead, "token-2", ""},
+		{"token of a private prompt", guest, unshared, PromptActionRead, "token-2", ""},
+		{"history isn't shared by token", outsider, history, PromptActionRead, "token-1", ""},
+		{"guest without token", guest, shared, PromptActionRead, "", ""},
+		{"owner of a shared prompt", owner, shared, PromptActionDelete, "", PromptGrantOwner},
+		{"owner comments", owner, unshared, PromptActionComment, "", PromptGrantOwner},
+		{"org member comments", colleague, unshared, PromptActionComment, "", PromptGrantOrgMember},
+		{"admin can't comment", admin, unshared, PromptActionComment, "", ""},
+		{"token holder can't comment", guest, shared, PromptActionComment, "token-1", ""},
+		{"org member edits together", colleague, unshared, PromptActionEdit, "", PromptGrantOrgMember},
+		{"other org can't edit", outsider, unshared, PromptActionEdit, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant, err := authz.Authorize(ctx, tt.rc, tt.resource, tt.action, tt.token)
+			if tt.grant == "" {
+				assert.ErrorIs(t, err, ErrPromptAccessDenied)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.grant, grant)
+		})
+	}
+
+	t.Run("without accounts organizations get no access", func(t *testing.T) {
+		_, err := NewPromptAuthorizer(nil).Authorize(ctx, colleague, history, PromptActionRead, "")
+		assert.ErrorIs(t, err, ErrPromptAccessDenied)
+	})
+
+	t.Run("authorizes users other than the caller", func(t *testing.T) {
+		grant, err := authz.AuthorizeUser(ctx, "colleague", unshared, PromptActionRead)
+		require.NoError(t, err)
+		assert.Equal(t, PromptGrantOrgMember, grant)
+
+		_, err = authz.AuthorizeUser(ctx, "outsider", unshared, PromptActionRead)
+		assert.ErrorIs(t, err, ErrPromptAccessDenied)
+
+		_, err = authz.AuthorizeUser(ctx, "unknown", unshared, PromptActionRead)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrPromptAccessDenied)
+	})
+
+	t.Run("owner lookup failures aren't denials", func(t *testing.T) {
+		orphan := PromptResource{Kind: "history", ID: "history-3", OwnerID: "deleted"}
+		_, err := authz.Authorize(ctx, colleague, orphan, PromptActionRead, "")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrPromptAccessDenied)
+	})
+}
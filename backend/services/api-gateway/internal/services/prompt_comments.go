@@ -0,0 +1,632 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Saved prompt comment statuses. Held comments wait for an admin and only
+// their author sees them; removed and deleted comments keep their place in
+// the thread without a body.
+const (
+	CommentStatusVisible = "visible"
+	CommentStatusHeld    = "held"
+	CommentStatusRemoved = "removed" // By an admin
+	CommentStatusDeleted = "deleted" // By the author, the prompt's owner or an admin
+)
+
+const (
+	maxCommentMentions         = 10
+	commentExcerptLength       = 280
+	commentNotificationTimeout = 30 * time.Second
+)
+
+var (
+	// ErrCommentNotFound is returned for unknown comments, comments on
+	// another prompt and comments that have been deleted
+	ErrCommentNotFound = errors.New("comment not found")
+
+	// ErrCommentParentNotFound is returned when replying to a comment that
+	// isn't on the prompt or can't be replied to
+	ErrCommentParentNotFound = errors.New("parent comment not found")
+
+	// ErrCommentNotAuthor is returned when someone else edits or deletes a
+	// comment
+	ErrCommentNotAuthor = errors.New("only the author can change this comment")
+)
+
+// commentMentionPattern finds @username mentions, but not email addresses
+var commentMentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([A-Za-z0-9_][A-Za-z0-9_.-]{2,49})`)
+
+// commentLinkPattern counts links for LinkLimitHook
+var commentLinkPattern = regexp.MustCompile(`(?i)\bhttps?://|\bwww\.`)
+
+// PromptComment is a comment on a saved prompt. Top-level comments carry
+// their replies.
+type PromptComment struct {
+	ID               string           `json:"id"`
+	SavedPromptID    string           `json:"saved_prompt_id"`
+	ParentID         *string          `json:"parent_id,omitempty"`
+	AuthorID         string           `json:"author_id"`
+	AuthorUsername   string           `json:"author_username"`
+	Body             string           `json:"body"`
+	Mentions         []string         `json:"mentions"`
+	Status           string           `json:"status"`
+	ModerationReason *string          `json:"moderation_reason,omitempty"`
+	CreatedAt        time.Time        `json:"created_at"`
+	UpdatedAt        time.Time        `json:"updated_at"`
+	EditedAt         *time.Time       `json:"edited_at,omitempty"`
+	Replies          []*PromptComment `json:"replies,omitempty"`
+}
+
+// CommentVerdict is a moderation hook's decision on a comment
+type CommentVerdict struct {
+	Hold   bool // Hold the comment until an admin releases it
+	Reason string
+}
+
+// CommentModerationHook screens comments as they are posted and edited.
+// Hooks can only hold comments; removing them is up to an admin.
+type CommentModerationHook interface {
+	ModerateComment(ctx context.Context, authorID, body string) (CommentVerdict, error)
+}
+
+// CommentModerationFunc adapts a function to a CommentModerationHook
+type CommentModerationFunc func(ctx context.Context, authorID, body string) (CommentVerdict, error)
+
+// ModerateComment calls f
+func (f CommentModerationFunc) ModerateComment(ctx context.Context, authorID, body string) (CommentVerdict, error) {
+	return f(ctx, authorID, body)
+}
+
+// LinkLimitHook holds comments with more than max links, the usual shape of
+// comment spam
+func LinkLimitHook(max int) CommentModerationHook {
+	return CommentModerationFunc(func(_ context.Context, _, body string) (CommentVerdict, error) {
+		if links := len(commentLinkPattern.FindAllString(body, -1)); links > max {
+			return CommentVerdict{Hold: true, Reason: fmt.Sprintf("contains %d links", links)}, nil
+		}
+		return CommentVerdict{}, nil
+	})
+}
+
+// CommentConfig configures the built-in comment moderation
+type CommentConfig struct {
+	MaxLinks int // Comments with more links are held; negative turns the check off
+}
+
+// LoadCommentConfig reads COMMENT_MAX_LINKS, 3 by default
+func LoadCommentConfig() CommentConfig {
+	config := CommentConfig{MaxLinks: 3}
+	if v, err := strconv.Atoi(os.Getenv("COMMENT_MAX_LINKS")); err == nil {
+		config.MaxLinks = v
+	}
+	return config
+}
+
+// Hooks returns the built-in moderation hooks the config turns on
+func (c CommentConfig) Hooks() []CommentModerationHook {
+	if c.MaxLinks < 0 {
+		return nil
+	}
+	return []CommentModerationHook{LinkLimitHook(c.MaxLinks)}
+}
+
+// commentMention is a mentioned user who may read the prompt
+type commentMention struct {
+	ID       string
+	Username string
+	Email    string
+}
+
+// PromptCommentService keeps the discussion on saved prompts shared within
+// an organization. Who may comment is up to the PromptAuthorizer; mentioned
+// users who may read the prompt are notified by email.
+type PromptCommentService struct {
+	db     *DatabaseService
+	email  *EmailService
+	authz  *PromptAuthorizer
+	hooks  []CommentModerationHook
+	logger *logrus.Logger
+}
+
+// NewPromptCommentService creates a new prompt comment service. Hooks run
+// in order on every posted and edited comment.
+func NewPromptCommentService(db *DatabaseService, email *EmailService, authz *PromptAuthorizer, logger *logrus.Logger, hooks ...CommentModerationHook) *PromptCommentService {
+	return &PromptCommentService{
+		db:     db,
+		email:  email,
+		authz:  authz,
+		hooks:  hooks,
+		logger: logger,
+	}
+}
+
+// GetSavedPrompt returns the saved prompt comments are posted on
+func (s *PromptCommentService) GetSavedPrompt(ctx context.Context, id string) (*models.SavedPrompt, error) {
+	return s.db.GetSavedPrompt(ctx, id)
+}
+
+const promptCommentColumns = `
+		c.id, c.saved_prompt_id, c.parent_id, c.author_id, u.username, c.body, c.mentions,
+		c.status, c.moderation_reason, c.created_at, c.updated_at, c.edited_at`
+
+// List returns the comments on a saved prompt as threads, oldest first.
+// Held comments are included for their author and for moderators.
+func (s *PromptCommentService) List(ctx context.Context, savedPromptID, viewerID string, moderator bool) ([]*PromptComment, error) {
+	query := `
+		SELECT` + promptCommentColumns + `
+		FROM prompts.saved_prompt_comments c
+		JOIN auth.users u ON u.id = c.author_id
+		WHERE c.saved_prompt_id = $1
+		  AND (c.status <> 'held' OR c.author_id = $2 OR $3)
+		ORDER BY c.created_at ASC, c.id`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, savedPromptID, viewerID, moderator)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query comments: %w", err)
+	}
+	defer rows.Close()
+
+	var comments []*PromptComment
+	for rows.Next() {
+		comment, err := scanPromptComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate comments: %w", err)
+	}
+	return buildCommentThreads(comments), nil
+}
+
+// ListHeld returns comments held for moderation, oldest first
+func (s *PromptCommentService) ListHeld(ctx context.Context, limit, offset int) ([]*PromptComment, error) {
+	query := `
+		SELECT` + promptCommentColumns + `
+		FROM prompts.saved_prompt_comments c
+		JOIN auth.users u ON u.id = c.author_id
+		WHERE c.status = 'held'
+		ORDER BY c.created_at ASC
+		LIMIT $1 OFFSET $2`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query held comments: %w", err)
+	}
+	defer rows.Close()
+
+	comments := []*PromptComment{}
+	for rows.Next() {
+		comment, err := scanPromptComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate held comments: %w", err)
+	}
+	return comments, nil
+}
+
+// Get returns a comment
+func (s *PromptCommentService) Get(ctx context.Context, id string) (*PromptComment, error) {
+	query := `
+		SELECT` + promptCommentColumns + `
+		FROM prompts.saved_prompt_comments c
+		JOIN auth.users u ON u.id = c.author_id
+		WHERE c.id = $1`
+
+	comment, err := scanPromptComment(s.db.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCommentNotFound
+	}
+	return comment, err
+}
+
+// Create posts a comment on a saved prompt. The caller has checked the
+// author may comment on it. Replies to a reply join the reply's thread.
+func (s *PromptCommentService) Create(ctx context.Context, prompt *models.SavedPrompt, authorID, parentID, body string) (*PromptComment, error) {
+	var parent interface{}
+	if parentID != "" {
+		var root sql.NullString
+		var status string
+		err := s.db.DB.QueryRowContext(ctx, `
+			SELECT parent_id, status FROM prompts.saved_prompt_comments
+			WHERE id = $1 AND saved_prompt_id = $2`,
+			parentID, prompt.ID).Scan(&root, &status)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && status != CommentStatusVisible) {
+			return nil, ErrCommentParentNotFound
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load parent comment: %w", err)
+		}
+		parent = parentID
+		if root.Valid {
+			parent = root.String
+		}
+	}
+
+	verdict := s.moderate(ctx, authorID, body)
+	mentions, err := s.resolveMentions(ctx, prompt, authorID, body)
+	if err != nil {
+		return nil, err
+	}
+
+	status := CommentStatusVisible
+	var reason interface{}
+	if verdict.Hold {
+		status = CommentStatusHeld
+		reason = verdict.Reason
+	}
+
+	var id string
+	err = s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO prompts.saved_prompt_comments (saved_prompt_id, parent_id, author_id, body, mentions, status, moderation_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		prompt.ID, parent, authorID, body, pq.Array(mentionUsernames(mentions)), status, reason).Scan(&id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comment: %w", err)
+	}
+
+	comment, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Status == CommentStatusVisible {
+		go s.notifyMentions(comment, prompt.Title, mentions)
+	}
+	return comment, nil
+}
+
+// Edit replaces the body of the author's comment. Users mentioned for the
+// first time are notified. Edits don't release a held comment.
+func (s *PromptCommentService) Edit(ctx context.Context, prompt *models.SavedPrompt, commentID, authorID, body string) (*PromptComment, error) {
+	existing, err := s.commentOn(ctx, prompt.ID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.AuthorID != authorID {
+		return nil, ErrCommentNotAuthor
+	}
+
+	verdict := s.moderate(ctx, authorID, body)
+	mentions, err := s.resolveMentions(ctx, prompt, authorID, body)
+	if err != nil {
+		return nil, err
+	}
+
+	status := existing.Status
+	var reason interface{}
+	if existing.ModerationReason != nil {
+		reason = *existing.ModerationReason
+	}
+	if verdict.Hold && status == CommentStatusVisible {
+		status = CommentStatusHeld
+		reason = verdict.Reason
+	}
+
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE prompts.saved_prompt_comments
+		SET body = $3, mentions = $4, status = $5, moderation_reason = $6,
+			updated_at = CURRENT_TIMESTAMP, edited_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND author_id = $2 AND status IN ('visible', 'held')`,
+		commentID, authorID, body, pq.Array(mentionUsernames(mentions)), status, reason)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit comment: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrCommentNotFound
+	}
+
+	comment, err := s.Get(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment.Status == CommentStatusVisible {
+		var added []commentMention
+		for _, m := range mentions {
+			if !slices.Contains(existing.Mentions, m.Username) {
+				added = append(added, m)
+			}
+		}
+		go s.notifyMentions(comment, prompt.Title, added)
+	}
+	return comment, nil
+}
+
+// Delete removes a comment's body and mentions, keeping its place in the
+// thread for its replies. Authors delete their own comments; moderator is
+// for the prompt's owner and admins, who may delete any.
+func (s *PromptCommentService) Delete(ctx context.Context, savedPromptID, commentID, actorID string, moderator bool) error {
+	existing, err := s.commentOn(ctx, savedPromptID, commentID)
+	if err != nil {
+		return err
+	}
+	if existing.AuthorID != actorID && !moderator {
+		return ErrCommentNotAuthor
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		UPDATE prompts.saved_prompt_comments
+		SET status = 'deleted', body = '', mentions = '{}', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		commentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete comment: %w", err)
+	}
+	return nil
+}
+
+// Moderate releases a held comment or removes a comment. Mentions in a
+// released comment are notified then, as they weren't when it was posted.
+func (s *PromptCommentService) Moderate(ctx context.Context, commentID, moderatorID, status, reason string) (*PromptComment, error) {
+	if status != CommentStatusVisible && status != CommentStatusRemoved {
+		return nil, fmt.Errorf("invalid moderation status %q", status)
+	}
+
+	existing, err := s.Get(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.gone() {
+		return nil, ErrCommentNotFound
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		UPDATE prompts.saved_prompt_comments
+		SET status = $2, moderation_reason = NULLIF($3, ''), moderated_by = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		commentID, status, reason, moderatorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to moderate comment: %w", err)
+	}
+
+	comment, err := s.Get(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if existing.Status == CommentStatusHeld && status == CommentStatusVisible && len(comment.Mentions) > 0 {
+		prompt, err := s.GetSavedPrompt(ctx, comment.SavedPromptID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load saved prompt: %w", err)
+		}
+		mentions, err := s.lookupMentions(ctx, comment.Mentions)
+		if err != nil {
+			return nil, err
+		}
+		go s.notifyMentions(comment, prompt.Title, mentions)
+	}
+	return comment, nil
+}
+
+// commentOn returns a comment that is on the prompt and not deleted
+func (s *PromptCommentService) commentOn(ctx context.Context, savedPromptID, commentID string) (*PromptComment, error) {
+	comment, err := s.Get(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment.SavedPromptID != savedPromptID || comment.gone() {
+		return nil, ErrCommentNotFound
+	}
+	return comment, nil
+}
+
+// moderate runs the hooks until one holds the comment. Hooks that fail are
+// logged and skipped so an outage doesn't stop the discussion.
+func (s *PromptCommentService) moderate(ctx context.Context, authorID, body string) CommentVerdict {
+	for _, hook := range s.hooks {
+		verdict, err := hook.ModerateComment(ctx, authorID, body)
+		if err != nil {
+			s.logger.WithError(err).WithField("author_id", authorID).Warn("Comment moderation hook failed")
+			continue
+		}
+		if verdict.Hold {
+			return verdict
+		}
+	}
+	return CommentVerdict{}
+}
+
+// resolveMentions returns the mentioned users who may read the prompt,
+// leaving out the author. Anyone else is left unmentioned, so a comment
+// can't tell outsiders about a prompt.
+func (s *PromptCommentService) resolveMentions(ctx context.Context, prompt *models.SavedPrompt, authorID, body string) ([]commentMention, error) {
+	candidates, err := s.lookupMentions(ctx, parseCommentMentions(body))
+	if err != nil {
+		return nil, err
+	}
+
+	resource := SavedPromptResource(prompt)
+	var mentions []commentMention
+	for _, m := range candidates {
+		if m.ID == authorID {
+			continue
+		}
+		if _, err := s.authz.AuthorizeUser(ctx, m.ID, resource, PromptActionRead); err != nil {
+			if !errors.Is(err, ErrPromptAccessDenied) {
+				s.logger.WithError(err).WithField("user_id", m.ID).Warn("Failed to authorize mentioned user")
+			}
+			continue
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, nil
+}
+
+// lookupMentions finds the active users with the given usernames
+func (s *PromptCommentService) lookupMentions(ctx context.Context, usernames []string) ([]commentMention, error) {
+	if len(usernames) == 0 {
+		return nil, nil
+	}
+	lowered := make([]string, len(usernames))
+	for i, u := range usernames {
+		lowered[i] = strings.ToLower(u)
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, username, email FROM auth.users
+		WHERE LOWER(username) = ANY($1) AND is_active = true
+		ORDER BY username`,
+		pq.Array(lowered))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up mentioned users: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []commentMention
+	for rows.Next() {
+		var m commentMention
+		if err := rows.Scan(&m.ID, &m.Username, &m.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan mentioned user: %w", err)
+		}
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate mentioned users: %w", err)
+	}
+	return mentions, nil
+}
+
+// notifyMentions emails mentioned users; failures are only logged
+func (s *PromptCommentService) notifyMentions(comment *PromptComment, promptTitle string, mentions []commentMention) {
+	if s.email == nil || len(mentions) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commentNotificationTimeout)
+	defer cancel()
+
+	excerpt := comment.Body
+	if runes := []rune(excerpt); len(runes) > commentExcerptLength {
+		excerpt = string(runes[:commentExcerptLength]) + "…"
+	}
+	subject := fmt.Sprintf("%s mentioned you in a comment", comment.AuthorUsername)
+	message := fmt.Sprintf("%s mentioned you in a comment on the saved prompt %q:\n\n%s", comment.AuthorUsername, promptTitle, excerpt)
+
+	for _, m := range mentions {
+		if err := s.email.SendNoticeEmail(ctx, m.Email, m.Username, subject, subject, message); err != nil {
+			s.logger.WithError(err).WithFields(logrus.Fields{
+				"comment_id": comment.ID,
+				"user_id":    m.ID,
+			}).Warn("Failed to send mention notification")
+		}
+	}
+}
+
+// parseCommentMentions returns the distinct usernames mentioned in a
+// comment, in order, up to maxCommentMentions
+func parseCommentMentions(body string) []string {
+	var usernames []string
+	seen := make(map[string]bool)
+	for _, match := range commentMentionPattern.FindAllStringSubmatch(body, -1) {
+		username := strings.TrimRight(match[1], ".-")
+		key := strings.ToLower(username)
+		if len(username) < 3 || seen[key] {
+			continue
+		}
+		seen[key] = true
+		usernames = append(usernames, username)
+		if len(usernames) == maxCommentMentions {
+			break
+		}
+	}
+	return usernames
+}
+
+func mentionUsernames(mentions []commentMention) []string {
+	usernames := make([]string, 0, len(mentions))
+	for _, m := range mentions {
+		usernames = append(usernames, m.Username)
+	}
+	return usernames
+}
+
+// buildCommentThreads nests replies under their top-level comment. Removed
+// and deleted comments lose their body; without replies they are left out.
+// Replies whose thread the viewer can't see are left out too.
+func buildCommentThreads(comments []*PromptComment) []*PromptComment {
+	roots := make(map[string]*PromptComment)
+	for _, c := range comments {
+		if c.gone() {
+			c.Body = ""
+			c.Mentions = []string{}
+			c.ModerationReason = nil
+		}
+		if c.ParentID == nil {
+			roots[c.ID] = c
+		}
+	}
+
+	for _, c := range comments {
+		if c.ParentID == nil || c.gone() {
+			continue
+		}
+		if root, ok := roots[*c.ParentID]; ok {
+			root.Replies = append(root.Replies, c)
+		}
+	}
+
+	threads := []*PromptComment{}
+	for _, c := range comments {
+		if c.ParentID != nil || (c.gone() && len(c.Replies) == 0) {
+			continue
+		}
+		threads = append(threads, c)
+	}
+	return threads
+}
+
+func (c *PromptComment) gone() bool {
+	return c.Status == CommentStatusDeleted || c.Status == CommentStatusRemoved
+}
+
+func scanPromptComment(row rowScanner) (*PromptComment, error) {
+	var comment PromptComment
+	var parentID, reason sql.NullString
+	var editedAt sql.NullTime
+	var mentions pq.StringArray
+
+	err := row.Scan(
+		&comment.ID, &comment.SavedPromptID, &parentID, &comment.AuthorID, &comment.AuthorUsername,
+		&comment.Body, &mentions, &comment.Status, &reason,
+		&comment.CreatedAt, &comment.UpdatedAt, &editedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan comment: %w", err)
+	}
+
+	if parentID.Valid {
+		comment.ParentID = &parentID.String
+	}
+	if reason.Valid {
+		comment.ModerationReason = &reason.String
+	}
+	if editedAt.Valid {
+		comment.EditedAt = &editedAt.Time
+	}
+	comment.Mentions = []string(mentions)
+	if comment.Mentions == nil {
+		comment.Mentions = []string{}
+	}
+	return &comment, nil
+}
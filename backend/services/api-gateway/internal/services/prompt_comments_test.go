@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCommentMentions(t *testing.T) {
+	assert.Equal(t, []string{"alice", "bob.smith"},
+		parseCommentMentions("@alice can you check this? cc @bob.smith. Thanks @Alice"))
+	assert.Empty(t, parseCommentMentions("mail me at carol@example.com or @ab"))
+
+	var many []string
+	for i := 0; i < 15; i++ {
+		many = append(many, "@user"+strings.Repeat("x", i))
+	}
+	assert.Len(t, parseCommentMentions(strings.Join(many, " ")), maxCommentMentions)
+}
+
+func TestLinkLimitHook(t *testing.T) {
+	ctx := context.Background()
+	hook := LinkLimitHook(2)
+
+	verdict, err := hook.ModerateComment(ctx, "user-1", "see https://a.example and www.b.example")
+	require.NoError(t, err)
+	assert.False(t, verdict.Hold)
+
+	verdict, err = hook.ModerateComment(ctx, "user-1", "http://a.example http://b.example HTTPS://c.example")
+	require.NoError(t, err)
+	assert.Equal(t, CommentVerdict{Hold: true, Reason: "contains 3 links"}, verdict)
+
+	t.Setenv("COMMENT_MAX_LINKS", "-1")
+	assert.Empty(t, LoadCommentConfig().Hooks())
+}
+
+func TestBuildCommentThreads(t *testing.T) {
+	ref := func(id string) *string { return &id }
+	comments := []*PromptComment{
+		{ID: "c1", Body: "first", Status: CommentStatusVisible},
+		{ID: "c2", Body: "gone", Status: CommentStatusDeleted, Mentions: []string{"bob"}},
+		{ID: "c3", ParentID: ref("c1"), Body: "reply", Status: CommentStatusVisible},
+		{ID: "c4", Body: "spam", Status: CommentStatusRemoved},
+		{ID: "c5", ParentID: ref("c2"), Body: "reply to deleted", Status: CommentStatusVisible},
+		{ID: "c6", ParentID: ref("c1"), Body: "removed reply", Status: CommentStatusRemoved},
+		{ID: "c7", ParentID: ref("hidden"), Body: "reply to a held comment", Status: CommentStatusVisible},
+	}
+
+	threads := buildCommentThreads(comments)
+	require.Len(t, threads, 2)
+
+	assert.Equal(t, "c1", threads[0].ID)
+	require.Len(t, threads[0].Replies, 1)
+	assert.Equal(t, "c3", threads[0].Replies[0].ID)
+
+	// Deleted comments with replies stay as placeholders
+	assert.Equal(t, "c2", threads[1].ID)
+	assert.Empty(t, threads[1].Body)
+	assert.Empty(t, threads[1].Mentions)
+	assert.Equal(t, "c5", threads[1].Replies[0].ID)
+}
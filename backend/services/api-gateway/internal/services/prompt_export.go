@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported prompt export formats
+const (
+	ExportFormatLangChain  = "langchain"
+	ExportFormatLlamaIndex = "llamaindex"
+	ExportFormatOpenAI     = "openai"
+)
+
+// ExportMessage is a single role/content message in an exported prompt
+type ExportMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PromptExport is an enhanced prompt rendered for a specific framework
+type PromptExport struct {
+	Format    string          `json:"format"`
+	Language  string          `json:"language"`
+	Variables []string        `json:"variables"`
+	Messages  []ExportMessage `json:"messages"`
+	Snippet   string          `json:"snippet"`
+}
+
+// templateVariable matches {name} and {{ name }} placeholders
+var templateVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}|\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExportPrompt converts an enhanced prompt into a snippet for the requested
+// framework. Template variables in either placeholder style are normalised
+// to the {name} form used by LangChain and LlamaIndex; any other literal
+// braces are escaped so the template still formats cleanly.
+func ExportPrompt(enhanced, original, format string) (*PromptExport, error) {
+	sections := ParsePromptSections(enhanced, original)
+
+	var messages []ExportMessage
+	if sections.System != "" {
+		messages = append(messages, ExportMessage{Role: "system", Content: sections.System})
+	}
+	messages = append(messages, ExportMessage{Role: "user", Content: userContent(sections)})
+
+	export := &PromptExport{
+		Format:    format,
+		Variables: []string{},
+	}
+
+	seen := make(map[string]bool)
+	for i := range messages {
+		content, vars := normaliseTemplate(messages[i].Content, format != ExportFormatOpenAI)
+		messages[i].Content = content
+		for _, v := range vars {
+			if !seen[v] {
+				seen[v] = true
+				export.Variables = append(export.Variables, v)
+			}
+		}
+	}
+	sort.Strings(export.Variables)
+	export.Messages = messages
+
+	switch format {
+	case ExportFormatLangChain:
+		export.Language = "python"
+		export.Snippet = langChainSnippet(messages, export.Variables)
+	case ExportFormatLlamaIndex:
+		export.Language = "python"
+		export.Snippet = llamaIndexSnippet(messages)
+	case ExportFormatOpenAI:
+		body, err := json.MarshalIndent(map[string]interface{}{"messages": messages}, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode openai export: %w", err)
+		}
+		export.Language = "json"
+		export.Snippet = string(body)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	return export, nil
+}
+
+// userContent reassembles the non-system sections into a single user message
+func userContent(sections PromptSections) string {
+	var parts []string
+	if sections.Instructions != "" {
+		parts = append(parts, sections.Instructions)
+	}
+	if sections.UserTask != "" {
+		parts = append(parts, sections.UserTask)
+	}
+	if len(sections.Examples) > 0 {
+		parts = append(parts, "Examples:\n- "+strings.Join(sections.Examples, "\n- "))
+	}
+	if len(sections.Constraints) > 0 {
+		parts = append(parts, "Constraints:\n- "+strings.Join(sections.Constraints, "\n- "))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// normaliseTemplate rewrites placeholders to {name} and returns the variable
+// names found. When escape is set, remaining literal braces are doubled.
+func normaliseTemplate(text string, escape bool) (string, []string) {
+	var vars []string
+	var b strings.Builder
+	last := 0
+
+	for _, m := range templateVariable.FindAllStringSubmatchIndex(text, -1) {
+		name := ""
+		if m[2] >= 0 {
+			name = text[m[2]:m[3]]
+		} else {
+			name = text[m[4]:m[5]]
+		}
+		b.WriteString(escapeBraces(text[last:m[0]], escape))
+		b.WriteString("{" + name + "}")
+		vars = append(vars, name)
+		last = m[1]
+	}
+	b.WriteString(escapeBraces(text[last:], escape))
+
+	return b.String(), vars
+}
+
+func escapeBraces(text string, escape bool) string {
+	if !escape {
+		return text
+	}
+	return strings.NewReplacer("{", "{{", "}", "}}").Replace(text)
+}
+
+func langChainSnippet(messages []ExportMessage, variables []string) string {
+	var b strings.Builder
+	b.WriteString("from langchain_core.prompts import ChatPromptTemplate\n\n")
+	b.WriteString("prompt = ChatPromptTemplate.from_messages([\n")
+	for _, msg := range messages {
+		role := msg.Role
+		if role == "user" {
+			role = "human"
+		}
+		fmt.Fprintf(&b, "    (%q, %s),\n", role, strconv.Quote(msg.Content))
+	}
+	b.WriteString("])\n")
+
+	if len(variables) > 0 {
+		args := make([]string, len(variables))
+		for i, v := range variables {
+			args[i] = fmt.Sprintf("%s=...", v)
+		}
+		fmt.Fprintf(&b, "\nmessages = prompt.format_messages(%s)\n", strings.Join(args, ", "))
+	} else {
+		b.WriteString("\nmessages = prompt.format_messages()\n")
+	}
+	return b.String()
+}
+
+func llamaIndexSnippet(messages []ExportMessage) string {
+	var b strings.Builder
+	b.WriteString("from llama_index.core import ChatPromptTemplate\n")
+	b.WriteString("from llama_index.core.llms import ChatMessage, MessageRole\n\n")
+	b.WriteString("prompt = ChatPromptTemplate(message_templates=[\n")
+	for _, msg := range messages {
+		role := "MessageRole.USER"
+		if msg.Role == "system" {
+			role = "MessageRole.SYSTEM"
+		}
+		fmt.Fprintf(&b, "    ChatMessage(role=%s, content=%s),\n", role, strconv.Quote(msg.Content))
+	}
+	b.WriteString("])\n")
+	return b.String()
+}
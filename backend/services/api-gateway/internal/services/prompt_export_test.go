@@ -0,0 +1,57 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportPrompt(t *testing.T) {
+	original := "Write a product description for {product}"
+	enhanced := "You are a senior copywriter.\n\n" +
+		"Write a product description for {product}\n\n" +
+		"Address the reader as {{ audience }} and return JSON like {\"title\": \"...\"}."
+
+	t.Run("langchain preserves variables and escapes literal braces", func(t *testing.T) {
+		export, err := ExportPrompt(enhanced, original, ExportFormatLangChain)
+		require.NoError(t, err)
+
+		assert.Equal(t, "python", export.Language)
+		assert.Equal(t, []string{"audience", "product"}, export.Variables)
+		require.Len(t, export.Messages, 2)
+		assert.Equal(t, "system", export.Messages[0].Role)
+		assert.Contains(t, export.Messages[1].Content, "{audience}")
+		assert.Contains(t, export.Messages[1].Content, `{{"title": "..."}}`)
+		assert.Contains(t, export.Snippet, "ChatPromptTemplate.from_messages")
+		assert.Contains(t, export.Snippet, "format_messages(audience=..., product=...)")
+	})
+
+	t.Run("llamaindex", func(t *testing.T) {
+		export, err := ExportPrompt(enhanced, original, ExportFormatLlamaIndex)
+		require.NoError(t, err)
+
+		assert.Contains(t, export.Snippet, "MessageRole.SYSTEM")
+		assert.Contains(t, export.Snippet, "MessageRole.USER")
+	})
+
+	t.Run("openai leaves literal braces alone", func(t *testing.T) {
+		export, err := ExportPrompt(enhanced, original, ExportFormatOpenAI)
+		require.NoError(t, err)
+
+		assert.Equal(t, "json", export.Language)
+		assert.Contains(t, export.Messages[1].Content, `{"title": "..."}`)
+
+		var body struct {
+			Messages []ExportMessage `json:"messages"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(export.Snippet), &body))
+		assert.Equal(t, export.Messages, body.Messages)
+	})
+
+	t.Run("unsupported format", func(t *testing.T) {
+		_, err := ExportPrompt(enhanced, original, "yaml")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Context a prompt can leave out that the enhanced prompt would otherwise
+// have to guess
+const (
+	ContextAudience    = "audience"
+	ContextFormat      = "format"
+	ContextConstraints = "constraints"
+)
+
+// VaguenessIndicator is wording that leaves the model guessing
+type VaguenessIndicator struct {
+	Type  string `json:"type"`
+	Match string `json:"match,omitempty"` // The words that triggered it
+}
+
+// ClarifyingAddition is something the user could add to the prompt before
+// enhancing it: the question it answers and an example of the answer
+type ClarifyingAddition struct {
+	Category string `json:"category"` // A missing context category or vagueness type
+	Question string `json:"question"`
+	Example  string `json:"example"`
+}
+
+// PromptQualityReport diagnoses a prompt before enhancement
+type PromptQualityReport struct {
+	Words               int                  `json:"words"`
+	Sentences           int                  `json:"sentences"`
+	Vagueness           []VaguenessIndicator `json:"vagueness"`
+	VaguenessScore      float64              `json:"vagueness_score"` // 0 (specific) to 1 (vague)
+	MissingContext      []string             `json:"missing_context"`
+	EstimatedComplexity string               `json:"estimated_complexity"` // simple, moderate or complex
+	Suggestions         []ClarifyingAddition `json:"suggestions"`
+}
+
+// ClarifyingQuestions returns up to max questions to ask the user, the
+// most useful first. Zero max returns them all.
+func (r PromptQualityReport) ClarifyingQuestions(max int) []string {
+	questions := make([]string, 0, len(r.Suggestions))
+	for _, s := range r.Suggestions {
+		if max > 0 && len(questions) == max {
+			break
+		}
+		questions = append(questions, s.Question)
+	}
+	return questions
+}
+
+// minSpecificWords is the length below which a prompt can't say enough
+const minSpecificWords = 5
+
+type vaguenessPattern struct {
+	name   string
+	weight float64
+	re     *regexp.Regexp
+}
+
+// vaguenessPatterns are matched case-insensitively; weights add up per
+// prompt. The last group is the vague word that suggestions ask about.
+var vaguenessPatterns = []vaguenessPattern{
+	{"unclear_reference", 0.35, regexp.MustCompile(`(?i)^\s*(?:fix|improve|rewrite|explain|check|review|make|change|update)\s+(it|this|that|these|those)\b`)},
+	{"subjective_goal", 0.25, regexp.MustCompile(`(?i)\b(better|nicer|good|great|cool|interesting|more professional|more engaging)\b`)},
+	{"vague_quantity", 0.15, regexp.MustCompile(`(?i)\b(some|a few|several|a bit|a lot|many|various)\b`)},
+	{"placeholder_words", 0.2, regexp.MustCompile(`(?i)\b(stuff|things?|something|anything|whatever|etc)\b`)},
+}
+
+// contextPatterns detect each context category; a prompt matching none of a
+// category's patterns is missing that context
+var contextPatterns = map[string]*regexp.Regexp{
+	ContextAudience:    regexp.MustCompile(`(?i)\b(audience|readers?|for (a |an |my |our |the )?(beginners?|novices?|experts?|students?|kids|children|developers?|engineers?|managers?|executives?|customers?|clients?|team|colleagues|non-technical)|aimed at|targeted at|new to)\b`),
+	ContextFormat:      regexp.MustCompile(`(?i)\b(bullet(ed)? (points?|list)|numbered list|list of|table|json|yaml|csv|markdown|paragraphs?|essay|email|letter|outline|headings?|code (block|snippet)|format(ted)? as|in the form of|as a (list|table|summary|script|poem|story))\b`),
+	ContextConstraints: regexp.MustCompile(`(?i)(\b(under|at most|no more than|at least|within|limit(ed)? to|maximum|minimum|must|must not|should not|shouldn't|don't|do not|avoid|without|only|exactly|deadline|budget)\b|\b\d+\s*(words|characters|sentences|paragraphs|lines|pages|minutes|items|bullets|points)\b)`),
+}
+
+// multiStepPattern marks prompts asking for several things in sequence
+var multiStepPattern = regexp.MustCompile(`(?i)\b(first|then|next|after that|finally|step \d|and also|as well as)\b`)
+
+// sentenceEnd splits sentences well enough for counting
+var sentenceEnd = regexp.MustCompile(`[.!?]+(\s|$)`)
+
+// clarifyingAdditions are the suggestions for each missing context category
+// and vagueness type, in the order they are offered
+var clarifyingAdditions = []ClarifyingAddition{
+	{Category: "too_short", Question: "What are you trying to achieve, and what should the answer cover?", Example: "I need a cover letter for a junior data analyst role that highlights my SQL experience."},
+	{Category: "unclear_reference", Question: "What does %q refer to? Include the text or code itself.", Example: "Here is the function: ..."},
+	{Category: ContextAudience, Question: "Who is the answer for?", Example: "Write it for beginners with no programming background."},
+	{Category: ContextFormat, Question: "What form should the answer take?", Example: "Answer as a numbered list of steps."},
+	{Category: ContextConstraints, Question: "Are there limits the answer must respect, such as length, tone or things to avoid?", Example: "Keep it under 200 words and avoid jargon."},
+	{Category: "subjective_goal", Question: "What would %q look like for you?", Example: "Make it shorter and more formal."},
+	{Category: "placeholder_words", Question: "Can you name the %q you mean?", Example: "List the three features the summary must mention."},
+	{Category: "vague_quantity", Question: "How many or how much is %q?", Example: "Give me 5 examples."},
+}
+
+// AnalyzePromptQuality diagnoses a prompt before enhancement: what makes it
+// vague, which context it leaves out, how complex it is and what the user
+// could add. It only looks at the text, so it is cheap enough to run while
+// the user types.
+func AnalyzePromptQuality(text string) PromptQualityReport {
+	text = strings.TrimSpace(text)
+	words := len(strings.Fields(text))
+	report := PromptQualityReport{
+		Words:          words,
+		Sentences:      countSentences(text),
+		Vagueness:      []VaguenessIndicator{},
+		MissingContext: []string{},
+		Suggestions:    []ClarifyingAddition{},
+	}
+
+	matches := make(map[string]string)
+	if words < minSpecificWords {
+		report.Vagueness = append(report.Vagueness, VaguenessIndicator{Type: "too_short"})
+		report.VaguenessScore += 0.4
+		matches["too_short"] = ""
+	}
+	for _, p := range vaguenessPatterns {
+		match := p.re.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		report.Vagueness = append(report.Vagueness, VaguenessIndicator{Type: p.name, Match: strings.TrimSpace(match[0])})
+		report.VaguenessScore += p.weight
+		matches[p.name] = match[len(match)-1]
+	}
+	if report.VaguenessScore > 1 {
+		report.VaguenessScore = 1
+	}
+
+	for _, category := range []string{ContextAudience, ContextFormat, ContextConstraints} {
+		if !contextPatterns[category].MatchString(text) {
+			report.MissingContext = append(report.MissingContext, category)
+			matches[category] = ""
+		}
+	}
+
+	report.EstimatedComplexity = estimatePromptComplexity(text, words, report.Sentences)
+
+	for _, addition := range clarifyingAdditions {
+		match, ok := matches[addition.Category]
+		if !ok {
+			continue
+		}
+		if strings.Contains(addition.Question, "%q") {
+			addition.Question = fmt.Sprintf(addition.Question, match)
+		}
+		report.Suggestions = append(report.Suggestions, addition)
+	}
+	return report
+}
+
+// estimatePromptComplexity guesses the complexity the classifier would give,
+// by length and how many steps and requirements the prompt has
+func estimatePromptComplexity(text string, words, sentences int) string {
+	steps := len(multiStepPattern.FindAllString(text, -1))
+	requirements := len(contextPatterns[ContextConstraints].FindAllString(text, -1))
+
+	switch {
+	case words > 150 || steps >= 3 || (sentences >= 5 && requirements >= 2):
+		return "complex"
+	case words < 25 && steps == 0 && requirements <= 1:
+		return "simple"
+	default:
+		return "moderate"
+	}
+}
+
+func countSentences(text string) int {
+	if text == "" {
+		return 0
+	}
+	sentences := len(sentenceEnd.FindAllString(text, -1))
+	if !strings.ContainsAny(text[len(text)-1:], ".!?") {
+		// The last sentence has no closing punctuation
+		sentences++
+	}
+	return sentences
+}
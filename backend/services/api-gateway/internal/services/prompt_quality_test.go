@@ -0,0 +1,59 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzePromptQuality(t *testing.T) {
+	t.Run("vague prompts", func(t *testing.T) {
+		report := AnalyzePromptQuality("make it better")
+
+		var types []string
+		for _, v := range report.Vagueness {
+			types = append(types, v.Type)
+		}
+		assert.Equal(t, []string{"too_short", "unclear_reference", "subjective_goal"}, types)
+		assert.InDelta(t, 1.0, report.VaguenessScore, 0.001)
+		assert.Equal(t, []string{ContextAudience, ContextFormat, ContextConstraints}, report.MissingContext)
+		assert.Equal(t, "simple", report.EstimatedComplexity)
+
+		assert.Equal(t, []string{
+			"What are you trying to achieve, and what should the answer cover?",
+			`What does "it" refer to? Include the text or code itself.`,
+		}, report.ClarifyingQuestions(2))
+		assert.Len(t, report.ClarifyingQuestions(0), 6)
+		assert.Equal(t, `What would "better" look like for you?`, report.Suggestions[5].Question)
+	})
+
+	t.Run("specific prompts", func(t *testing.T) {
+		report := AnalyzePromptQuality("Write an email for non-technical customers announcing the outage postmortem. " +
+			"Keep it under 150 words and avoid blaming the vendor.")
+
+		assert.Empty(t, report.Vagueness)
+		assert.Zero(t, report.VaguenessScore)
+		assert.Empty(t, report.MissingContext)
+		assert.Empty(t, report.Suggestions)
+		assert.Equal(t, 2, report.Sentences)
+		assert.Equal(t, 20, report.Words)
+		assert.Equal(t, "moderate", report.EstimatedComplexity)
+	})
+
+	t.Run("multi-step prompts are complex", func(t *testing.T) {
+		report := AnalyzePromptQuality("First load the sales CSV, then clean the dates, next build a pivot by region " +
+			"and finally chart the trend as a table for managers")
+
+		assert.Equal(t, "complex", report.EstimatedComplexity)
+		assert.Equal(t, []string{ContextConstraints}, report.MissingContext)
+		assert.Equal(t, 1, report.Sentences)
+	})
+
+	t.Run("placeholder words need a word boundary", func(t *testing.T) {
+		report := AnalyzePromptQuality(strings.Repeat("Summarize my thinking about the migration plan for engineers. ", 2))
+		for _, v := range report.Vagueness {
+			assert.NotEqual(t, "placeholder_words", v.Type)
+		}
+	})
+}
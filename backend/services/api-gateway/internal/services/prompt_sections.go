@@ -0,0 +1,98 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// PromptSections is an enhanced prompt decomposed into chat-friendly parts
+type PromptSections struct {
+	System       string   `json:"system,omitempty"`
+	Instructions string   `json:"instructions,omitempty"`
+	Examples     []string `json:"examples,omitempty"`
+	UserTask     string   `json:"user_task"`
+	Constraints  []string `json:"constraints,omitempty"`
+}
+
+var (
+	systemPattern     = regexp.MustCompile(`(?i)^(you are|act as|as an? |imagine you are|take the role)`)
+	examplesHeading   = regexp.MustCompile(`(?i)^#*\s*(examples?|for example|here are some examples)\b[^\n]*:?\s*$`)
+	constraintHeading = regexp.MustCompile(`(?i)^#*\s*(constraints|requirements|rules|guidelines|notes)\b[^\n]*:?\s*$`)
+	constraintLine    = regexp.MustCompile(`(?i)^(do not|don't|never|must|avoid|ensure|make sure|keep|limit)\b`)
+	bulletPrefix      = regexp.MustCompile(`^\s*([-*•]|\d+[.)])\s+`)
+	paragraphBreak    = regexp.MustCompile(`\n\s*\n`)
+)
+
+// ParsePromptSections splits an enhanced prompt into system, instructions,
+// examples, user task and constraints. The original user text is used to
+// locate the task; when it cannot be found it becomes the task verbatim.
+func ParsePromptSections(enhanced, original string) PromptSections {
+	var sections PromptSections
+	var instructions []string
+	original = strings.TrimSpace(original)
+
+	for _, block := range splitParagraphs(enhanced) {
+		lines := strings.Split(block, "\n")
+		first := strings.TrimSpace(lines[0])
+
+		switch {
+		case examplesHeading.MatchString(first):
+			sections.Examples = append(sections.Examples, bulletItems(lines[1:])...)
+		case constraintHeading.MatchString(first):
+			sections.Constraints = append(sections.Constraints, bulletItems(lines[1:])...)
+		case sections.System == "" && systemPattern.MatchString(first):
+			sections.System = block
+		case sections.UserTask == "" && original != "" && strings.Contains(block, original):
+			sections.UserTask = original
+			if rest := strings.TrimSpace(strings.Replace(block, original, "", 1)); rest != "" {
+				instructions = append(instructions, rest)
+			}
+		case allConstraintLines(lines):
+			sections.Constraints = append(sections.Constraints, bulletItems(lines)...)
+		default:
+			instructions = append(instructions, block)
+		}
+	}
+
+	if sections.UserTask == "" {
+		sections.UserTask = original
+	}
+	sections.Instructions = strings.Join(instructions, "\n\n")
+
+	return sections
+}
+
+// splitParagraphs splits text on blank lines and drops empty blocks
+func splitParagraphs(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var blocks []string
+	for _, block := range paragraphBreak.Split(text, -1) {
+		if block = strings.TrimSpace(block); block != "" {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// bulletItems returns the non-empty lines with list markers stripped
+func bulletItems(lines []string) []string {
+	var items []string
+	for _, line := range lines {
+		line = strings.TrimSpace(bulletPrefix.ReplaceAllString(line, ""))
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+	return items
+}
+
+// allConstraintLines reports whether every line of a block reads as a constraint
+func allConstraintLines(lines []string) bool {
+	for _, line := range lines {
+		line = strings.TrimSpace(bulletPrefix.ReplaceAllString(line, ""))
+		if !constraintLine.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePromptSections(t *testing.T) {
+	original := "Explain how binary search works"
+	enhanced := `You are an experienced computer science tutor.
+
+I'll work through this step-by-step.
+
+Explain how binary search works
+
+Examples:
+- Searching for 7 in [1, 3, 5, 7, 9]
+- Searching for a missing value
+
+Constraints:
+1. Keep the explanation under 300 words
+2. Use plain language
+
+Do not include code longer than ten lines.`
+
+	sections := ParsePromptSections(enhanced, original)
+
+	assert.Equal(t, "You are an experienced computer science tutor.", sections.System)
+	assert.Equal(t, "I'll work through this step-by-step.", sections.Instructions)
+	assert.Equal(t, original, sections.UserTask)
+	assert.Equal(t, []string{
+		"Searching for 7 in [1, 3, 5, 7, 9]",
+		"Searching for a missing value",
+	}, sections.Examples)
+	assert.Equal(t, []string{
+		"Keep the explanation under 300 words",
+		"Use plain language",
+		"Do not include code longer than ten lines.",
+	}, sections.Constraints)
+}
+
+func TestParsePromptSectionsFallsBackToOriginalTask(t *testing.T) {
+	sections := ParsePromptSections("Let me break this down:", "Summarize this article")
+
+	assert.Empty(t, sections.System)
+	assert.Equal(t, "Let me break this down:", sections.Instructions)
+	assert.Equal(t, "Summarize this article", sections.UserTask)
+	assert.Nil(t, sections.Examples)
+	assert.Nil(t, sections.Constraints)
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// PublicStatsMinUsers is the k in the public stats' k-anonymity: every
+	// number is backed by at least this many distinct users or left out
+	PublicStatsMinUsers = 25
+
+	// PublicStatsInterval is how often the public stats are recomputed
+	PublicStatsInterval = 24 * time.Hour
+
+	// publicStatsCacheTTL outlives the daily recompute so the cache is
+	// replaced rather than left to expire
+	publicStatsCacheTTL = 26 * time.Hour
+
+	publicStatsTopTechniques = 5
+)
+
+// ErrPublicStatsUnavailable is returned before the first computation
+var ErrPublicStatsUnavailable = errors.New("public stats not computed yet")
+
+// PublicTechniqueStat is one of the most used techniques
+type PublicTechniqueStat struct {
+	Technique string  `json:"technique"`
+	Share     float64 `json:"share"` // Share of enhancements, to the nearest percent
+}
+
+// PublicStats are the coarse usage numbers shown on the marketing site
+type PublicStats struct {
+	TotalEnhancements int64                 `json:"total_enhancements"` // Rounded down to two significant digits
+	TopTechniques     []PublicTechniqueStat `json:"top_techniques"`
+	AverageRating     *float64              `json:"average_rating"` // Average 1-5 feedback score
+	ComputedAt        time.Time             `json:"computed_at"`
+}
+
+// PublicStatsService computes the public stats once a day and serves them
+// from the cache, so the unauthenticated endpoint never queries prompt history
+type PublicStatsService struct {
+	db     *DatabaseService
+	cache  *CacheService
+	logger *logrus.Logger
+}
+
+// NewPublicStatsService creates a new public stats service. cache may be nil.
+func NewPublicStatsService(db *DatabaseService, cache *CacheService, logger *logrus.Logger) *PublicStatsService {
+	return &PublicStatsService{
+		db:     db,
+		cache:  cache,
+		logger: logger,
+	}
+}
+
+// ComputeJob returns the scheduled job that recomputes the public stats
+func (s *PublicStatsService) ComputeJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "public_stats",
+		Interval: PublicStatsInterval,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) error {
+			_, err := s.Compute(ctx)
+			return err
+		},
+	}
+}
+
+// Compute recomputes today's public stats, stores them and refreshes the cache
+func (s *PublicStatsService) Compute(ctx context.Context) (*PublicStats, error) {
+	stats := &PublicStats{TopTechniques: []PublicTechniqueStat{}, ComputedAt: time.Now().UTC()}
+
+	var total, users int64
+	var rated, raters int64
+	var avgRating sql.NullFloat64
+	totalsQuery := `
+		SELECT COUNT(*), COUNT(DISTINCT user_id),
+			   COUNT(feedback_score), COUNT(DISTINCT user_id) FILTER (WHERE feedback_score IS NOT NULL),
+			   AVG(feedback_score)
+		FROM prompts.history`
+
+	if err := s.db.DB.QueryRowContext(ctx, totalsQuery).Scan(&total, &users, &rated, &raters, &avgRating); err != nil {
+		return nil, fmt.Errorf("failed to compute enhancement totals: %w", err)
+	}
+
+	if users >= PublicStatsMinUsers {
+		stats.TotalEnhancements = coarseCount(total)
+	}
+	if raters >= PublicStatsMinUsers && avgRating.Valid {
+		avg := math.Round(avgRating.Float64*10) / 10
+		stats.AverageRating = &avg
+	}
+
+	if total > 0 {
+		techniquesQuery := `
+			SELECT t.technique, COUNT(*)
+			FROM prompts.history h
+			CROSS JOIN LATERAL unnest(h.techniques_used) AS t(technique)
+			GROUP BY t.technique
+			HAVING COUNT(DISTINCT h.user_id) >= $1
+			ORDER BY COUNT(*) DESC, t.technique
+			LIMIT $2`
+
+		rows, err := s.db.DB.QueryContext(ctx, techniquesQuery, PublicStatsMinUsers, publicStatsTopTechniques)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute top techniques: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var technique string
+			var uses int64
+			if err := rows.Scan(&technique, &uses); err != nil {
+				return nil, fmt.Errorf("failed to scan top technique: %w", err)
+			}
+			stats.TopTechniques = append(stats.TopTechniques, PublicTechniqueStat{
+				Technique: technique,
+				Share:     math.Round(float64(uses)/float64(total)*100) / 100,
+			})
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to iterate top techniques: %w", err)
+		}
+	}
+
+	topTechniques, err := json.Marshal(stats.TopTechniques)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal top techniques: %w", err)
+	}
+
+	upsert := `
+		INSERT INTO analytics.public_stats (stat_date, total_enhancements, top_techniques, average_rating, computed_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (stat_date) DO UPDATE
+		SET total_enhancements = EXCLUDED.total_enhancements,
+			top_techniques = EXCLUDED.top_techniques,
+			average_rating = EXCLUDED.average_rating,
+			computed_at = EXCLUDED.computed_at`
+
+	if _, err := s.db.DB.ExecContext(ctx, upsert, stats.ComputedAt.Format("2006-01-02"), stats.TotalEnhancements, topTechniques, stats.AverageRating, stats.ComputedAt); err != nil {
+		return nil, fmt.Errorf("failed to store public stats: %w", err)
+	}
+
+	s.cacheStats(ctx, stats)
+	return stats, nil
+}
+
+// Get returns the latest public stats
+func (s *PublicStatsService) Get(ctx context.Context) (*PublicStats, error) {
+	if s.cache != nil {
+		data, err := s.cache.client.Get(ctx, s.cache.Key("public_stats")).Bytes()
+		if err == nil {
+			var stats PublicStats
+			if err := json.Unmarshal(data, &stats); err == nil {
+				return &stats, nil
+			}
+		} else if err != redis.Nil {
+			s.logger.WithError(err).Debug("Failed to read cached public stats")
+		}
+	}
+
+	query := `
+		SELECT total_enhancements, top_techniques, average_rating, computed_at
+		FROM analytics.public_stats
+		ORDER BY stat_date DESC
+		LIMIT 1`
+
+	var stats PublicStats
+	var topTechniques []byte
+	var avgRating sql.NullFloat64
+	err := s.db.DB.QueryRowContext(ctx, query).Scan(&stats.TotalEnhancements, &topTechniques, &avgRating, &stats.ComputedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPublicStatsUnavailable
+		}
+		return nil, fmt.Errorf("failed to get public stats: %w", err)
+	}
+	if err := json.Unmarshal(topTechniques, &stats.TopTechniques); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal top techniques: %w", err)
+	}
+	if avgRating.Valid {
+		stats.AverageRating = &avgRating.Float64
+	}
+
+	s.cacheStats(ctx, &stats)
+	return &stats, nil
+}
+
+func (s *PublicStatsService) cacheStats(ctx context.Context, stats *PublicStats) {
+	if s.cache == nil {
+		return
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return
+	}
+	if err := s.cache.client.Set(ctx, s.cache.Key("public_stats"), data, publicStatsCacheTTL).Err(); err != nil {
+		s.logger.WithError(err).Debug("Failed to cache public stats")
+	}
+}
+
+// coarseCount rounds n down to two significant digits, e.g. 123456 to
+// 120000, so the public total doesn't track individual enhancements
+func coarseCount(n int64) int64 {
+	if n < 100 {
+		return n / 10 * 10
+	}
+	unit := int64(1)
+	for n/unit >= 100 {
+		unit *= 10
+	}
+	return n / unit * unit
+}
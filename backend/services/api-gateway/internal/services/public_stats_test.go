@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoarseCount(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected int64
+	}{
+		{0, 0},
+		{7, 0},
+		{42, 40},
+		{99, 90},
+		{100, 100},
+		{987, 980},
+		{123456, 120000},
+		{1999999, 1900000},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, coarseCount(tt.input), "coarseCount(%d)", tt.input)
+	}
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var rateLimitWarnings = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "gateway_rate_limit_warnings_total",
+	Help: "Callers crossing the warning threshold of a rate limit window, by limiter",
+}, []string{"limiter"})
+
+// RateLimitWarning is a caller crossing the warning threshold of one rate
+// limiter's window
+type RateLimitWarning struct {
+	Limiter string    `json:"limiter"`
+	Used    int       `json:"used"`
+	Limit   int       `json:"limit"`
+	ResetAt time.Time `json:"reset_at"`
+}
+
+// RateLimitWarningConfig controls early warnings before callers hit a rate limit
+type RateLimitWarningConfig struct {
+	Threshold float64 // Fraction of a limit used from which responses warn; zero turns warnings off
+	Notices   bool    // Also keep an in-app notice for the user until the window resets
+}
+
+// LoadRateLimitWarningConfig reads RATE_LIMIT_WARNING_THRESHOLD and
+// RATE_LIMIT_WARNING_NOTICES
+func LoadRateLimitWarningConfig() RateLimitWarningConfig {
+	config := RateLimitWarningConfig{Threshold: 0.8}
+	if v, err := strconv.ParseFloat(os.Getenv("RATE_LIMIT_WARNING_THRESHOLD"), 64); err == nil && v >= 0 && v < 1 {
+		config.Threshold = v
+	}
+	config.Notices, _ = strconv.ParseBool(os.Getenv("RATE_LIMIT_WARNING_NOTICES"))
+	return config
+}
+
+// RateLimitResetAt returns when the current fixed window of the given length ends
+func RateLimitResetAt(now time.Time, window time.Duration) time.Time {
+	_, resetAt := rateLimitBucket(now, window)
+	return resetAt
+}
+
+// signalRecorder feeds events to abuse detection
+type signalRecorder interface {
+	RecordSignal(ctx context.Context, userID, signal, member string) (*AbuseRestriction, error)
+}
+
+// warningNotices keeps a user's latest warning per limiter until its window resets
+type warningNotices interface {
+	put(ctx context.Context, userID string, warning RateLimitWarning) error
+	get(ctx context.Context, userID string, limiters []string) ([]RateLimitWarning, error)
+}
+
+// RateLimitWarnings records callers approaching their rate limits, so abuse
+// detection can spot accounts that live at the edge of them and users can be
+// told before they are rejected
+type RateLimitWarnings struct {
+	abuse   signalRecorder // Nil without abuse detection
+	notices warningNotices // Nil unless in-app notices are on
+	logger  *logrus.Logger
+}
+
+// NewRateLimitWarnings creates a warning recorder. Without Redis there are no
+// rate limits to warn about, and neither signals nor notices are kept.
+func NewRateLimitWarnings(cache *CacheService, abuse *AbuseService, config RateLimitWarningConfig, logger *logrus.Logger) *RateLimitWarnings {
+	w := &RateLimitWarnings{logger: logger}
+	if abuse != nil {
+		w.abuse = abuse
+	}
+	if cache != nil && config.Notices {
+		w.notices = &redisWarningNotices{cache: cache}
+	}
+	return w
+}
+
+// Record notes that a caller crossed a warning threshold. userID is empty for
+// anonymous callers, who are only counted. Failures are logged; warnings
+// never affect the request.
+func (w *RateLimitWarnings) Record(ctx context.Context, userID string, warning RateLimitWarning) {
+	rateLimitWarnings.WithLabelValues(warning.Limiter).Inc()
+	if userID == "" {
+		return
+	}
+	logger := w.logger.WithFields(logrus.Fields{"user_id": userID, "limiter": warning.Limiter})
+
+	if w.abuse != nil {
+		// One signal per limiter window, however often the warning is seen
+		member := fmt.Sprintf("%s:%d", warning.Limiter, warning.ResetAt.Unix())
+		if _, err := w.abuse.RecordSignal(ctx, userID, AbuseSignalRateLimitWarning, member); err != nil {
+			logger.WithError(err).Debug("Failed to record rate limit warning signal")
+		}
+	}
+	if w.notices != nil {
+		if err := w.notices.put(ctx, userID, warning); err != nil {
+			logger.WithError(err).Warn("Failed to store rate limit notice")
+		}
+	}
+}
+
+// Notices returns the user's warnings for the given limiters whose windows
+// haven't reset yet
+func (w *RateLimitWarnings) Notices(ctx context.Context, userID string, limiters ...string) ([]RateLimitWarning, error) {
+	if w == nil || w.notices == nil || userID == "" || len(limiters) == 0 {
+		return []RateLimitWarning{}, nil
+	}
+	notices, err := w.notices.get(ctx, userID, limiters)
+	if err != nil {
+		return nil, err
+	}
+
+	current := []RateLimitWarning{}
+	now := time.Now()
+	for _, notice := range notices {
+		if notice.ResetAt.After(now) {
+			current = append(current, notice)
+		}
+	}
+	return current, nil
+}
+
+// redisWarningNotices keeps one key per user and limiter, expiring with the window
+type redisWarningNotices struct {
+	cache *CacheService
+}
+
+func (n *redisWarningNotices) key(userID, limiter string) string {
+	return n.cache.Key("ratelimit", "warning", userID, limiter)
+}
+
+func (n *redisWarningNotices) put(ctx context.Context, userID string, warning RateLimitWarning) error {
+	ttl := time.Until(warning.ResetAt)
+	if ttl <= 0 {
+		return nil
+	}
+	data, err := json.Marshal(warning)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rate limit notice: %w", err)
+	}
+	if err := n.cache.client.Set(ctx, n.key(userID, warning.Limiter), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store rate limit notice: %w", err)
+	}
+	return nil
+}
+
+func (n *redisWarningNotices) get(ctx context.Context, userID string, limiters []string) ([]RateLimitWarning, error) {
+	keys := make([]string, len(limiters))
+	for i, limiter := range limiters {
+		keys[i] = n.key(userID, limiter)
+	}
+	values, err := n.cache.client.MGet(ctx, keys...).Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get rate limit notices: %w", err)
+	}
+
+	var notices []RateLimitWarning
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var notice RateLimitWarning
+		if err := json.Unmarshal([]byte(data), &notice); err != nil {
+			continue
+		}
+		notices = append(notices, notice)
+	}
+	return notices, nil
+}
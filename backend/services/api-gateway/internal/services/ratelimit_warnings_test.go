@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingSignals counts the distinct signals recorded per member
+type countingSignals struct {
+	members map[string]bool
+}
+
+func (s *countingSignals) RecordSignal(_ context.Context, userID, signal, member string) (*AbuseRestriction, error) {
+	s.members[userID+"/"+signal+"/"+member] = true
+	return nil, nil
+}
+
+// memoryNotices is a warningNotices that never expires anything itself
+type memoryNotices map[string]RateLimitWarning
+
+func (n memoryNotices) put(_ context.Context, userID string, warning RateLimitWarning) error {
+	n[userID+"/"+warning.Limiter] = warning
+	return nil
+}
+
+func (n memoryNotices) get(_ context.Context, userID string, limiters []string) ([]RateLimitWarning, error) {
+	var notices []RateLimitWarning
+	for _, limiter := range limiters {
+		if notice, ok := n[userID+"/"+limiter]; ok {
+			notices = append(notices, notice)
+		}
+	}
+	return notices, nil
+}
+
+func TestLoadRateLimitWarningConfig(t *testing.T) {
+	t.Setenv("RATE_LIMIT_WARNING_THRESHOLD", "1.5")
+	t.Setenv("RATE_LIMIT_WARNING_NOTICES", "true")
+	config := LoadRateLimitWarningConfig()
+	assert.Equal(t, 0.8, config.Threshold, "thresholds at or past the limit are ignored")
+	assert.True(t, config.Notices)
+
+	t.Setenv("RATE_LIMIT_WARNING_THRESHOLD", "0")
+	assert.Zero(t, LoadRateLimitWarningConfig().Threshold)
+}
+
+func TestRateLimitWarnings(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	ctx := context.Background()
+	signals := &countingSignals{members: map[string]bool{}}
+	notices := memoryNotices{}
+	warnings := &RateLimitWarnings{abuse: signals, notices: notices, logger: logger}
+
+	resetAt := time.Now().Add(time.Minute)
+	warning := RateLimitWarning{Limiter: "default", Used: 80, Limit: 100, ResetAt: resetAt}
+	warnings.Record(ctx, "user-1", warning)
+	warnings.Record(ctx, "user-1", warning)
+	warnings.Record(ctx, "", warning)
+	assert.Len(t, signals.members, 1, "one signal per user and window")
+	assert.Len(t, notices, 1, "anonymous callers get no notice")
+
+	warnings.Record(ctx, "user-1", RateLimitWarning{Limiter: "extension", Used: 16, Limit: 20, ResetAt: time.Now().Add(-time.Second)})
+	current, err := warnings.Notices(ctx, "user-1", "default", "extension", "trial")
+	require.NoError(t, err)
+	assert.Equal(t, []RateLimitWarning{warning}, current, "notices of reset windows are dropped")
+
+	none, err := (&RateLimitWarnings{logger: logger}).Notices(ctx, "user-1", "default")
+	require.NoError(t, err)
+	assert.Empty(t, none)
+}
+
+func TestRateLimitResetAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 30, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 1, 0, 0, time.UTC), RateLimitResetAt(now, time.Minute))
+}
@@ -311,7 +311,8 @@ func (r *RedisService) Exists(ctx context.Context, namespace, key string) (bool,
 	return count > 0, nil
 }
 
-// SetNX sets a key only if it doesn't exist (useful for distributed locks)
+// SetNX sets a key only if it doesn't exist. Locks belong in internal/lock,
+// which renews and fences them.
 func (r *RedisService) SetNX(ctx context.Context, namespace, key string, value interface{}, ttl time.Duration) (bool, error) {
 	fullKey := r.buildKey(namespace, key)
 	
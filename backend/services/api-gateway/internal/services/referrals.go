@@ -0,0 +1,346 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Referral statuses
+const (
+	ReferralStatusRewarded = "rewarded"
+	ReferralStatusRejected = "rejected"
+)
+
+// Why a referral wasn't rewarded
+const (
+	ReferralRejectedSameIP     = "same_ip"     // The referrer signed in from, or already referred from, the signup's IP
+	ReferralRejectedSameDevice = "same_device" // The referrer signed in from the signup's device
+	ReferralRejectedLimit      = "limit"       // The referrer has earned all the rewards they can
+)
+
+const (
+	referralCodeLength = 8
+	// referralCodeAlphabet leaves out characters that are easily confused
+	referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	referralCodeAttempts = 5
+)
+
+// ErrUnknownReferralCode is returned for codes no user owns
+var ErrUnknownReferralCode = errors.New("unknown referral code")
+
+// ReferralConfig sets the extra enhancements a referral earns
+type ReferralConfig struct {
+	ReferrerBonus int // For the user whose code was used
+	ReferredBonus int // For the new user
+	MaxRewards    int // Rewarded referrals per referrer; zero is unlimited
+}
+
+// LoadReferralConfig reads REFERRAL_REFERRER_BONUS, REFERRAL_REFERRED_BONUS
+// and REFERRAL_MAX_REWARDS
+func LoadReferralConfig() ReferralConfig {
+	config := ReferralConfig{
+		ReferrerBonus: 100,
+		ReferredBonus: 50,
+		MaxRewards:    50,
+	}
+	if v, err := strconv.Atoi(os.Getenv("REFERRAL_REFERRER_BONUS")); err == nil && v >= 0 {
+		config.ReferrerBonus = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REFERRAL_REFERRED_BONUS")); err == nil && v >= 0 {
+		config.ReferredBonus = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("REFERRAL_MAX_REWARDS")); err == nil && v >= 0 {
+		config.MaxRewards = v
+	}
+	return config
+}
+
+// Referral is a signup made with another user's referral code
+type Referral struct {
+	ID              string    `json:"id"`
+	ReferrerID      string    `json:"referrer_id"`
+	ReferredID      string    `json:"referred_id"`
+	Code            string    `json:"code"`
+	Status          string    `json:"status"`
+	RejectionReason string    `json:"rejection_reason,omitempty"`
+	ReferrerBonus   int       `json:"referrer_bonus"`
+	ReferredBonus   int       `json:"referred_bonus"`
+	SignupIP        string    `json:"signup_ip,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// ReferralSummary is what a user sees of their own referrals
+type ReferralSummary struct {
+	Code        string `json:"code"`
+	Rewarded    int    `json:"rewarded"`
+	BonusEarned int    `json:"bonus_earned"`
+}
+
+// NormalizeReferralCode uppercases a code and strips the spaces people
+// paste around it
+func NormalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ReferralService hands out referral codes and rewards the signups made with
+// them. A referral is only rewarded when the new account doesn't look like
+// the referrer's own: it must come from an IP and device the referrer hasn't
+// used. Rejected referrals are kept for review.
+type ReferralService struct {
+	db     *DatabaseService
+	config ReferralConfig
+	logger *logrus.Logger
+}
+
+// NewReferralService creates a new referral service
+func NewReferralService(db *DatabaseService, config ReferralConfig, logger *logrus.Logger) *ReferralService {
+	return &ReferralService{
+		db:     db,
+		config: config,
+		logger: logger,
+	}
+}
+
+// GetCode returns the user's referral code, creating it the first time
+func (s *ReferralService) GetCode(ctx context.Context, userID string) (string, error) {
+	var code string
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT code FROM auth.referral_codes WHERE user_id = $1`, userID).Scan(&code)
+	if err == nil {
+		return code, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to get referral code: %w", err)
+	}
+
+	for attempt := 0; attempt < referralCodeAttempts; attempt++ {
+		candidate, err := generateReferralCode()
+		if err != nil {
+			return "", err
+		}
+		// A concurrent request may have created the user's code; it wins
+		err = s.db.DB.QueryRowContext(ctx, `
+			INSERT INTO auth.referral_codes (user_id, code)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+			RETURNING code`, userID, candidate).Scan(&code)
+		if err == nil {
+			return code, nil
+		}
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+			return "", fmt.Errorf("failed to create referral code: %w", err)
+		}
+	}
+	return "", errors.New("failed to create a unique referral code")
+}
+
+func generateReferralCode() (string, error) {
+	code := make([]byte, referralCodeLength)
+	max := big.NewInt(int64(len(referralCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate referral code: %w", err)
+		}
+		code[i] = referralCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Summary returns the user's code and the rewards their referrals earned
+func (s *ReferralService) Summary(ctx context.Context, userID string) (*ReferralSummary, error) {
+	code, err := s.GetCode(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &ReferralSummary{Code: code}
+	err = s.db.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(referrer_bonus), 0)
+		FROM auth.referrals
+		WHERE referrer_id = $1 AND status = $2`, userID, ReferralStatusRewarded,
+	).Scan(&summary.Rewarded, &summary.BonusEarned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize referrals: %w", err)
+	}
+	return summary, nil
+}
+
+// LookupCode returns the user who owns a referral code
+func (s *ReferralService) LookupCode(ctx context.Context, code string) (string, error) {
+	var referrerID string
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT user_id FROM auth.referral_codes WHERE code = $1`, NormalizeReferralCode(code)).Scan(&referrerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrUnknownReferralCode
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up referral code: %w", err)
+	}
+	return referrerID, nil
+}
+
+// ApplyReferral records that a new user signed up with a referral code from
+// the given device, rewarding both users unless the signup looks like a
+// self-referral
+func (s *ReferralService) ApplyReferral(ctx context.Context, referredID, code string, device DeviceInfo) (*Referral, error) {
+	code = NormalizeReferralCode(code)
+	referrerID, err := s.LookupCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	referral := &Referral{
+		ReferrerID: referrerID,
+		ReferredID: referredID,
+		Code:       code,
+		Status:     ReferralStatusRewarded,
+		SignupIP:   device.IP,
+	}
+	if referral.RejectionReason, err = s.fraudReason(ctx, referrerID, device); err != nil {
+		return nil, err
+	}
+	if referral.RejectionReason != "" {
+		referral.Status = ReferralStatusRejected
+	} else {
+		referral.ReferrerBonus = s.config.ReferrerBonus
+		referral.ReferredBonus = s.config.ReferredBonus
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO auth.referrals (
+			referrer_id, referred_id, code, status, rejection_reason,
+			referrer_bonus, referred_bonus, signup_ip, device_fingerprint
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, NULLIF($8, '')::inet, NULLIF($9, ''))
+		RETURNING id, created_at`,
+		referral.ReferrerID, referral.ReferredID, referral.Code, referral.Status, referral.RejectionReason,
+		referral.ReferrerBonus, referral.ReferredBonus, device.IP, device.Fingerprint,
+	).Scan(&referral.ID, &referral.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record referral: %w", err)
+	}
+
+	if referral.Status == ReferralStatusRewarded {
+		if err := addBonusQuota(ctx, tx, referrerID, referral.ReferrerBonus); err != nil {
+			return nil, err
+		}
+		if err := addBonusQuota(ctx, tx, referredID, referral.ReferredBonus); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit referral: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"referrer_id": referrerID,
+		"referred_id": referredID,
+		"status":      referral.Status,
+		"reason":      referral.RejectionReason,
+	}).Info("Referral recorded")
+	return referral, nil
+}
+
+// fraudReason returns why a signup from device shouldn't earn the referrer a
+// reward, or "" when it should. Fingerprints derived from the user agent
+// alone are shared by too many people to count as the same device.
+func (s *ReferralService) fraudReason(ctx context.Context, referrerID string, device DeviceInfo) (string, error) {
+	fingerprint := device.Fingerprint
+	if strings.HasPrefix(fingerprint, "ua:") {
+		fingerprint = ""
+	}
+
+	var sameIP, sameDevice bool
+	var rewarded int
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT
+			EXISTS (
+				SELECT 1 FROM auth.user_devices
+				WHERE user_id = $1 AND last_ip = NULLIF($2, '')::inet
+			) OR EXISTS (
+				SELECT 1 FROM auth.referrals
+				WHERE referrer_id = $1 AND signup_ip = NULLIF($2, '')::inet AND status = $4
+			),
+			EXISTS (
+				SELECT 1 FROM auth.user_devices
+				WHERE user_id = $1 AND fingerprint = NULLIF($3, '')
+			),
+			(SELECT COUNT(*) FROM auth.referrals WHERE referrer_id = $1 AND status = $4)`,
+		referrerID, device.IP, fingerprint, ReferralStatusRewarded,
+	).Scan(&sameIP, &sameDevice, &rewarded)
+	if err != nil {
+		return "", fmt.Errorf("failed to check referral: %w", err)
+	}
+
+	switch {
+	case sameDevice:
+		return ReferralRejectedSameDevice, nil
+	case sameIP:
+		return ReferralRejectedSameIP, nil
+	case s.config.MaxRewards > 0 && rewarded >= s.config.MaxRewards:
+		return ReferralRejectedLimit, nil
+	}
+	return "", nil
+}
+
+// ListReferrals returns the most recent referrals, optionally only those
+// with a status, for admins reviewing rewards and rejections
+func (s *ReferralService) ListReferrals(ctx context.Context, status string, limit int) ([]*Referral, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, referrer_id, referred_id, code, status, COALESCE(rejection_reason, ''),
+			   referrer_bonus, referred_bonus, COALESCE(host(signup_ip), ''), created_at
+		FROM auth.referrals
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC
+		LIMIT $2`, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query referrals: %w", err)
+	}
+	defer rows.Close()
+
+	referrals := []*Referral{}
+	for rows.Next() {
+		var r Referral
+		if err := rows.Scan(&r.ID, &r.ReferrerID, &r.ReferredID, &r.Code, &r.Status, &r.RejectionReason,
+			&r.ReferrerBonus, &r.ReferredBonus, &r.SignupIP, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan referral: %w", err)
+		}
+		referrals = append(referrals, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate referrals: %w", err)
+	}
+	return referrals, nil
+}
+
+// addBonusQuota grants a user extra enhancements on top of their plan
+func addBonusQuota(ctx context.Context, tx *sql.Tx, userID string, bonus int) error {
+	if bonus <= 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE auth.users SET bonus_quota = bonus_quota + $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID, bonus)
+	if err != nil {
+		return fmt.Errorf("failed to add bonus quota: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateReferralCode(t *testing.T) {
+	code, err := generateReferralCode()
+	require.NoError(t, err)
+	assert.Len(t, code, referralCodeLength)
+	for _, r := range code {
+		assert.Contains(t, referralCodeAlphabet, string(r))
+	}
+	assert.Equal(t, "AB12CD34", NormalizeReferralCode(" ab12cd34 "))
+}
+
+func TestApplyReferral(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		sameIP     bool
+		sameDevice bool
+		rewarded   int64
+		device     DeviceInfo
+		status     string
+		reason     string
+	}{
+		{"rewarded", false, false, 0, DeviceInfo{IP: "203.0.113.7", Fingerprint: "fp-new"}, ReferralStatusRewarded, ""},
+		{"same ip", true, false, 0, DeviceInfo{IP: "203.0.113.7"}, ReferralStatusRejected, ReferralRejectedSameIP},
+		{"same device", true, true, 0, DeviceInfo{IP: "203.0.113.7", Fingerprint: "fp-1"}, ReferralStatusRejected, ReferralRejectedSameDevice},
+		{"limit", false, false, 50, DeviceInfo{IP: "203.0.113.7"}, ReferralStatusRejected, ReferralRejectedLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, d := newRecordingDB(t, func(string) int64 { return 1 })
+			d.rows = func(query string) ([]string, [][]driver.Value) {
+				switch {
+				case strings.Contains(query, "FROM auth.referral_codes"):
+					return []string{"user_id"}, [][]driver.Value{{"referrer"}}
+				case strings.Contains(query, "INSERT INTO auth.referrals"):
+					return []string{"id", "created_at"}, [][]driver.Value{{"r-1", now}}
+				default:
+					return []string{"same_ip", "same_device", "rewarded"}, [][]driver.Value{{tt.sameIP, tt.sameDevice, tt.rewarded}}
+				}
+			}
+			logger := logrus.New()
+			logger.SetLevel(logrus.PanicLevel)
+			referrals := NewReferralService(NewDatabaseService(db.DB), ReferralConfig{ReferrerBonus: 100, ReferredBonus: 50, MaxRewards: 50}, logger)
+
+			referral, err := referrals.ApplyReferral(context.Background(), "referred", "ab12cd34", tt.device)
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, referral.Status)
+			assert.Equal(t, tt.reason, referral.RejectionReason)
+			assert.Equal(t, "AB12CD34", referral.Code)
+
+			var bonuses []interface{}
+			for i, entry := range d.entries() {
+				if strings.HasPrefix(entry, "UPDATE auth.users SET bonus_quota") {
+					bonuses = append(bonuses, d.args[i][0].Value, d.args[i][1].Value)
+				}
+			}
+			if tt.status == ReferralStatusRewarded {
+				assert.Equal(t, []interface{}{"referrer", int64(100), "referred", int64(50)}, bonuses)
+			} else {
+				assert.Empty(t, bonuses, "rejected referrals earn nothing")
+			}
+		})
+	}
+}
+
+func TestReferralFraudIgnoresUserAgentFingerprints(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"same_ip", "same_device", "rewarded"}, [][]driver.Value{{false, false, int64(0)}}
+	}
+	referrals := NewReferralService(NewDatabaseService(db.DB), ReferralConfig{}, logrus.New())
+
+	_, err := referrals.fraudReason(context.Background(), "referrer", DeviceInfo{
+		IP:          "203.0.113.7",
+		Fingerprint: DeviceFingerprint("", "Mozilla/5.0"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "", d.args[0][2].Value, "a user agent hash is shared by too many people")
+}
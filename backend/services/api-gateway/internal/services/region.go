@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// RegionConfig describes the region this gateway runs in and the Redis
+// instances of its peer regions. An empty Region means a single-region
+// deployment: keys carry no region segment and nothing is replicated.
+type RegionConfig struct {
+	Region               string
+	Replicas             map[string]string // peer region -> Redis address
+	ReplicationQueueSize int
+	ReplicationTimeout   time.Duration
+}
+
+// LoadRegionConfig reads region settings from the environment.
+// REDIS_REPLICA_ADDRS has the form "eu-west=redis-eu:6379,ap-south=redis-ap:6379".
+func LoadRegionConfig() RegionConfig {
+	config := RegionConfig{
+		Region:               strings.TrimSpace(os.Getenv("REGION")),
+		Replicas:             parseReplicaAddrs(os.Getenv("REDIS_REPLICA_ADDRS")),
+		ReplicationQueueSize: 1000,
+		ReplicationTimeout:   2 * time.Second,
+	}
+
+	if size, err := strconv.Atoi(os.Getenv("SESSION_REPLICATION_QUEUE_SIZE")); err == nil && size > 0 {
+		config.ReplicationQueueSize = size
+	}
+
+	// Never replicate to ourselves
+	delete(config.Replicas, config.Region)
+
+	return config
+}
+
+func parseReplicaAddrs(value string) map[string]string {
+	replicas := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		region, addr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		region, addr = strings.TrimSpace(region), strings.TrimSpace(addr)
+		if ok && region != "" && addr != "" {
+			replicas[region] = addr
+		}
+	}
+	return replicas
+}
+
+// ReplicationStats reports session replication health for this region
+type ReplicationStats struct {
+	Region     string   `json:"region"`
+	Peers      []string `json:"peers"`
+	Replicated int64    `json:"replicated"`
+	Failed     int64    `json:"failed"`
+	Dropped    int64    `json:"dropped"`
+	QueueDepth int      `json:"queue_depth"`
+}
+
+type replicationKind int
+
+const (
+	replicateSet replicationKind = iota
+	replicateDelete
+	replicateExpire
+)
+
+type replicationOp struct {
+	kind  replicationKind
+	key   string
+	value []byte
+	ttl   time.Duration
+}
+
+// sessionReplicator copies session writes to peer regions in the background
+// so request latency never includes a cross-region round trip. When the queue
+// is full, writes are dropped rather than blocking; peers then fall back to
+// treating the session as missing. Writes after close are dropped too.
+type sessionReplicator struct {
+	region  string
+	peers   map[string]*redis.Client
+	timeout time.Duration
+	ops     chan replicationOp
+	logger  *logrus.Logger
+	wg      sync.WaitGroup
+
+	// mu guards closed; enqueue holds it shared so ops is never closed
+	// under a send
+	mu     sync.RWMutex
+	closed bool
+
+	replicated int64
+	failed     int64
+	dropped    int64
+}
+
+func newSessionReplicator(config RegionConfig, logger *logrus.Logger) *sessionReplicator {
+	r := &sessionReplicator{
+		region:  config.Region,
+		peers:   make(map[string]*redis.Client, len(config.Replicas)),
+		timeout: config.ReplicationTimeout,
+		ops:     make(chan replicationOp, config.ReplicationQueueSize),
+		logger:  logger,
+	}
+
+	for region, addr := range config.Replicas {
+		r.peers[region] = redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: os.Getenv("REDIS_PASSWORD"),
+		})
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r
+}
+
+func (r *sessionReplicator) enqueue(op replicationOp) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		atomic.AddInt64(&r.dropped, 1)
+		return
+	}
+
+	select {
+	case r.ops <- op:
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+		r.logger.WithFields(logrus.Fields{
+			"region": r.region,
+			"key":    op.key,
+		}).Warn("Session replication queue full, dropping write")
+	}
+}
+
+func (r *sessionReplicator) run() {
+	defer r.wg.Done()
+	for op := range r.ops {
+		for region, client := range r.peers {
+			if err := r.apply(client, op); err != nil {
+				atomic.AddInt64(&r.failed, 1)
+				r.logger.WithError(err).WithFields(logrus.Fields{
+					"region":      r.region,
+					"peer_region": region,
+					"key":         op.key,
+				}).Warn("Session replication failed")
+				continue
+			}
+			atomic.AddInt64(&r.replicated, 1)
+		}
+	}
+}
+
+func (r *sessionReplicator) apply(client *redis.Client, op replicationOp) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	switch op.kind {
+	case replicateDelete:
+		return client.Del(ctx, op.key).Err()
+	case replicateExpire:
+		return client.Expire(ctx, op.key, op.ttl).Err()
+	default:
+		return client.Set(ctx, op.key, op.value, op.ttl).Err()
+	}
+}
+
+func (r *sessionReplicator) stats() ReplicationStats {
+	peers := make([]string, 0, len(r.peers))
+	for region := range r.peers {
+		peers = append(peers, region)
+	}
+	sort.Strings(peers)
+
+	return ReplicationStats{
+		Region:     r.region,
+		Peers:      peers,
+		Replicated: atomic.LoadInt64(&r.replicated),
+		Failed:     atomic.LoadInt64(&r.failed),
+		Dropped:    atomic.LoadInt64(&r.dropped),
+		QueueDepth: len(r.ops),
+	}
+}
+
+// close drains pending writes and disconnects from peers. Closing twice
+// is a no-op.
+func (r *sessionReplicator) close() {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.ops)
+	r.mu.Unlock()
+
+	r.wg.Wait()
+	for _, client := range r.peers {
+		client.Close()
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRegionConfig(t *testing.T) {
+	t.Setenv("REGION", "us-east")
+	t.Setenv("REDIS_REPLICA_ADDRS", "eu-west=redis-eu:6379, us-east=redis-us:6379,malformed,ap-south = redis-ap:6379")
+	t.Setenv("SESSION_REPLICATION_QUEUE_SIZE", "50")
+
+	config := LoadRegionConfig()
+
+	assert.Equal(t, "us-east", config.Region)
+	assert.Equal(t, map[string]string{
+		"eu-west":  "redis-eu:6379",
+		"ap-south": "redis-ap:6379",
+	}, config.Replicas)
+	assert.Equal(t, 50, config.ReplicationQueueSize)
+}
+
+func TestCacheServiceLocalKey(t *testing.T) {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:0"})
+	defer client.Close()
+	logger := logrus.New()
+
+	single := NewCacheService(client, logger)
+	assert.Equal(t, "betterprompts:intent:abc", single.LocalKey("intent", "abc"))
+	assert.Nil(t, single.ReplicationStats())
+
+	regional := NewRegionalCacheService(client, logger, RegionConfig{Region: "eu-west"})
+	assert.Equal(t, "eu-west", regional.Region())
+	assert.Equal(t, "betterprompts:eu-west:intent:abc", regional.LocalKey("intent", "abc"))
+	// Sessions stay global so they can be replicated under the same key
+	assert.Equal(t, "betterprompts:session:s1", regional.Key("session", "s1"))
+	// Without replicas there is nothing to replicate to
+	assert.Nil(t, regional.ReplicationStats())
+}
+
+func TestSessionReplicatorClose(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	replicator := newSessionReplicator(RegionConfig{
+		Region:               "us-east",
+		Replicas:             map[string]string{"eu-west": "localhost:0"},
+		ReplicationQueueSize: 10,
+		ReplicationTimeout:   10 * time.Millisecond,
+	}, logger)
+
+	replicator.close()
+	replicator.close()
+
+	// Session writes racing shutdown are dropped rather than panicking
+	assert.NotPanics(t, func() {
+		replicator.enqueue(replicationOp{kind: replicateDelete, key: "betterprompts:session:s1"})
+	})
+	assert.Equal(t, int64(1), replicator.stats().Dropped)
+}
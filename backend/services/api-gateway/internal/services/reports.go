@@ -0,0 +1,683 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// Report frequencies. Weekly reports cover Monday to Sunday, monthly ones a
+// calendar month, both in UTC.
+const (
+	ReportFrequencyWeekly  = "weekly"
+	ReportFrequencyMonthly = "monthly"
+)
+
+const (
+	// MaxReportRecipients caps how many addresses a schedule emails
+	MaxReportRecipients = 20
+
+	// ReportGenerationTimeout bounds one run of the report job
+	ReportGenerationTimeout = 30 * time.Minute
+
+	// maxReportAttempts is how many times a report is tried before it is
+	// left failed
+	maxReportAttempts = 3
+
+	// reportStaleAfter is when a report still marked running is assumed to
+	// have died with its instance
+	reportStaleAfter = time.Hour
+
+	// reportLinkExpiry is how long the download link in a report email works
+	reportLinkExpiry = 7 * 24 * time.Hour
+
+	// ReportDownloadExpiry is how long re-download links work
+	ReportDownloadExpiry = 15 * time.Minute
+)
+
+// Files stored for each report
+const (
+	ReportFileCSV     = "csv"
+	ReportFileSummary = "summary"
+)
+
+var (
+	// ErrReportScheduleNotFound is returned for schedules the organization doesn't have
+	ErrReportScheduleNotFound = errors.New("report schedule not found")
+	// ErrReportScheduleExists is returned when the organization already has a
+	// schedule at that frequency
+	ErrReportScheduleExists = errors.New("a report schedule with this frequency already exists")
+	// ErrReportNotFound is returned for reports the organization doesn't have
+	ErrReportNotFound = errors.New("report not found")
+	// ErrReportNotReady is returned when downloading a report that didn't succeed
+	ErrReportNotReady = errors.New("report has no files")
+)
+
+// ReportSchedule subscribes an organization's recipients to a report. An
+// empty OrgID covers the whole platform.
+type ReportSchedule struct {
+	ID         string    `json:"id"`
+	OrgID      string    `json:"org_id,omitempty"`
+	Frequency  string    `json:"frequency"`
+	Recipients []string  `json:"recipients"`
+	Enabled    bool      `json:"enabled"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ReportTechnique is one technique's usage and ratings in a report period
+type ReportTechnique struct {
+	Technique     string   `json:"technique"`
+	Uses          int64    `json:"uses"`
+	Rated         int64    `json:"rated"`
+	AverageRating *float64 `json:"average_rating,omitempty"` // 1 to 5; nil when nothing was rated
+}
+
+// ReportSummary is the usage and effectiveness of a report period
+type ReportSummary struct {
+	Enhancements  int64             `json:"enhancements"`
+	ActiveUsers   int64             `json:"active_users"`
+	Rated         int64             `json:"rated"`
+	AverageRating *float64          `json:"average_rating,omitempty"`
+	Techniques    []ReportTechnique `json:"techniques"`
+}
+
+// Report is one generated period of a schedule
+type Report struct {
+	ID          string         `json:"id"`
+	ScheduleID  string         `json:"schedule_id,omitempty"`
+	OrgID       string         `json:"org_id,omitempty"`
+	Frequency   string         `json:"frequency"`
+	PeriodStart string         `json:"period_start"`
+	PeriodEnd   string         `json:"period_end"` // Exclusive
+	Status      string         `json:"status"`     // running, succeeded or failed
+	Attempts    int            `json:"attempts"`
+	Summary     *ReportSummary `json:"summary,omitempty"`
+	EmailedTo   int            `json:"emailed_to"`
+	Error       string         `json:"error,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+
+	csvKey     string
+	summaryKey string
+}
+
+// ReportPeriod returns the last complete period of a frequency before now
+func ReportPeriod(frequency string, now time.Time) (start, end time.Time) {
+	now = now.UTC()
+	if frequency == ReportFrequencyMonthly {
+		end = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return end.AddDate(0, -1, 0), end
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	end = today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+	return end.AddDate(0, 0, -7), end
+}
+
+// NormalizeReportRecipients lower-cases and de-duplicates recipient
+// addresses and reports the malformed ones
+func NormalizeReportRecipients(recipients []string) ([]string, error) {
+	normalized := make([]string, 0, len(recipients))
+	seen := make(map[string]bool, len(recipients))
+	for _, recipient := range recipients {
+		address, err := mail.ParseAddress(strings.TrimSpace(recipient))
+		if err != nil || address.Name != "" {
+			return nil, fmt.Errorf("invalid recipient %q", recipient)
+		}
+		email := strings.ToLower(address.Address)
+		if !seen[email] {
+			seen[email] = true
+			normalized = append(normalized, email)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, errors.New("at least one recipient is required")
+	}
+	if len(normalized) > MaxReportRecipients {
+		return nil, fmt.Errorf("at most %d recipients are allowed", MaxReportRecipients)
+	}
+	return normalized, nil
+}
+
+// ReportService generates scheduled usage and effectiveness reports. Each
+// report is a CSV of per-technique usage plus a rendered HTML summary,
+// stored in object storage and emailed to the schedule's recipients.
+// Reports are claimed through a unique period key, so instances never
+// generate the same one twice.
+type ReportService struct {
+	db      *DatabaseService
+	storage ObjectStorage
+	email   *EmailService
+	logger  *logrus.Logger
+}
+
+// NewReportService creates a new report service. Reports are stored but
+// not emailed when email is nil.
+func NewReportService(db *DatabaseService, storage ObjectStorage, email *EmailService, logger *logrus.Logger) *ReportService {
+	return &ReportService{
+		db:      db,
+		storage: storage,
+		email:   email,
+		logger:  logger,
+	}
+}
+
+// ReportJob returns the hourly job generating reports whose period has
+// ended, and retrying ones that failed
+func (s *ReportService) ReportJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "scheduled_reports",
+		Interval: time.Hour,
+		Timeout:  ReportGenerationTimeout,
+		Run: func(ctx context.Context) error {
+			return s.GenerateDue(ctx, time.Now())
+		},
+	}
+}
+
+// GenerateDue generates the last complete period of every enabled schedule
+// that doesn't have it yet. Every schedule is attempted; the first error is
+// returned.
+func (s *ReportService) GenerateDue(ctx context.Context, now time.Time) error {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+reportScheduleColumns+`
+		FROM analytics.report_schedules
+		WHERE enabled
+		ORDER BY org_id, frequency`)
+	if err != nil {
+		return fmt.Errorf("failed to query report schedules: %w", err)
+	}
+	schedules := []*ReportSchedule{}
+	for rows.Next() {
+		schedule, err := scanReportSchedule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		schedules = append(schedules, schedule)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate report schedules: %w", err)
+	}
+
+	var firstErr error
+	for _, schedule := range schedules {
+		start, end := ReportPeriod(schedule.Frequency, now)
+		report, err := s.claim(ctx, schedule, start, end)
+		if err == nil && report != nil {
+			err = s.generate(ctx, schedule, report, start, end)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to generate %s report for org %q: %w", schedule.Frequency, schedule.OrgID, err)
+		}
+	}
+	return firstErr
+}
+
+// claim records a running report for a period, returning nil when it
+// already exists and isn't due a retry
+func (s *ReportService) claim(ctx context.Context, schedule *ReportSchedule, start, end time.Time) (*Report, error) {
+	report := &Report{
+		ScheduleID:  schedule.ID,
+		OrgID:       schedule.OrgID,
+		Frequency:   schedule.Frequency,
+		PeriodStart: start.Format("2006-01-02"),
+		PeriodEnd:   end.Format("2006-01-02"),
+		Status:      "running",
+	}
+	err := s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO analytics.reports (schedule_id, org_id, frequency, period_start, period_end)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (org_id, frequency, period_start) DO UPDATE
+		SET status = 'running', attempts = reports.attempts + 1, error = NULL,
+			schedule_id = EXCLUDED.schedule_id, started_at = CURRENT_TIMESTAMP
+		WHERE reports.attempts < $6
+		  AND (reports.status = 'failed' OR (reports.status = 'running' AND reports.started_at < $7))
+		RETURNING id, attempts, created_at`,
+		schedule.ID, schedule.OrgID, schedule.Frequency, start, end,
+		maxReportAttempts, time.Now().Add(-reportStaleAfter),
+	).Scan(&report.ID, &report.Attempts, &report.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to record report: %w", err)
+	}
+	return report, nil
+}
+
+// generate builds, stores and emails a claimed report
+func (s *ReportService) generate(ctx context.Context, schedule *ReportSchedule, report *Report, start, end time.Time) error {
+	logger := s.logger.WithFields(logrus.Fields{
+		"report_id":    report.ID,
+		"org_id":       report.OrgID,
+		"frequency":    report.Frequency,
+		"period_start": report.PeriodStart,
+	})
+
+	summary, err := s.Summarize(ctx, report.OrgID, start, end)
+	if err == nil {
+		report.Summary = summary
+		err = s.store(ctx, report)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Report generation failed")
+		s.finish(report, "failed", err.Error())
+		return err
+	}
+
+	report.EmailedTo = s.send(ctx, schedule, report, logger)
+	s.finish(report, "succeeded", "")
+	logger.WithField("emailed_to", report.EmailedTo).Info("Report generated")
+	return nil
+}
+
+// Summarize computes the usage and effectiveness of an organization's
+// enhancements in [start, end). An empty orgID covers the whole platform.
+func (s *ReportService) Summarize(ctx context.Context, orgID string, start, end time.Time) (*ReportSummary, error) {
+	summary := &ReportSummary{Techniques: []ReportTechnique{}}
+	var average sql.NullFloat64
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(DISTINCT h.user_id), COUNT(h.feedback_score), AVG(h.feedback_score)
+		FROM prompts.history h
+		LEFT JOIN auth.users u ON u.id = h.user_id
+		WHERE h.created_at >= $1 AND h.created_at < $2
+		  AND ($3 = '' OR u.metadata->>'org_id' = $3)`,
+		start, end, orgID,
+	).Scan(&summary.Enhancements, &summary.ActiveUsers, &summary.Rated, &average)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize usage: %w", err)
+	}
+	if average.Valid {
+		summary.AverageRating = &average.Float64
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT t.technique, COUNT(*), COUNT(h.feedback_score), AVG(h.feedback_score)
+		FROM prompts.history h
+		CROSS JOIN LATERAL unnest(h.techniques_used) AS t(technique)
+		LEFT JOIN auth.users u ON u.id = h.user_id
+		WHERE h.created_at >= $1 AND h.created_at < $2
+		  AND ($3 = '' OR u.metadata->>'org_id' = $3)
+		GROUP BY t.technique
+		ORDER BY COUNT(*) DESC, t.technique`,
+		start, end, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize techniques: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var technique ReportTechnique
+		var average sql.NullFloat64
+		if err := rows.Scan(&technique.Technique, &technique.Uses, &technique.Rated, &average); err != nil {
+			return nil, fmt.Errorf("failed to scan technique summary: %w", err)
+		}
+		if average.Valid {
+			technique.AverageRating = &average.Float64
+		}
+		summary.Techniques = append(summary.Techniques, technique)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate technique summaries: %w", err)
+	}
+	return summary, nil
+}
+
+// store writes a report's CSV and rendered summary to object storage
+func (s *ReportService) store(ctx context.Context, report *Report) error {
+	csvBody, err := RenderReportCSV(report.Summary)
+	if err != nil {
+		return err
+	}
+	summaryBody, err := RenderReportSummary(report)
+	if err != nil {
+		return err
+	}
+
+	csvKey := reportObjectKey(report, "usage.csv")
+	if err := s.storage.Put(ctx, csvKey, csvBody, "text/csv"); err != nil {
+		return fmt.Errorf("failed to upload report csv: %w", err)
+	}
+	summaryKey := reportObjectKey(report, "summary.html")
+	if err := s.storage.Put(ctx, summaryKey, summaryBody, "text/html; charset=utf-8"); err != nil {
+		return fmt.Errorf("failed to upload report summary: %w", err)
+	}
+	report.csvKey, report.summaryKey = csvKey, summaryKey
+	return nil
+}
+
+// send emails a stored report to the schedule's recipients, returning how
+// many it reached. A report that couldn't be emailed can still be
+// downloaded, so failures are only logged.
+func (s *ReportService) send(ctx context.Context, schedule *ReportSchedule, report *Report, logger *logrus.Entry) int {
+	if s.email == nil || len(schedule.Recipients) == 0 {
+		return 0
+	}
+	link, err := s.storage.SignedURL(ctx, report.csvKey, reportLinkExpiry)
+	if err != nil {
+		logger.WithError(err).Error("Failed to sign report link")
+		return 0
+	}
+
+	subject := fmt.Sprintf("Your %s BetterPrompts report", report.Frequency)
+	message := fmt.Sprintf("%d enhancements by %d users from %s to %s. The link to the full report works for %d days.",
+		report.Summary.Enhancements, report.Summary.ActiveUsers, report.PeriodStart, report.PeriodEnd,
+		int(reportLinkExpiry/(24*time.Hour)))
+	sent := 0
+	for _, recipient := range schedule.Recipients {
+		if err := s.email.SendReportEmail(ctx, recipient, subject, message, link); err != nil {
+			logger.WithError(err).WithField("recipient", recipient).Warn("Failed to email report")
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// finish records the outcome of a report. It uses its own context so a
+// report that timed out is still marked failed.
+func (s *ReportService) finish(report *Report, status, errMsg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	report.Status = status
+	report.Error = errMsg
+	report.CompletedAt = &now
+
+	var summary []byte
+	if report.Summary != nil && status == "succeeded" {
+		var err error
+		if summary, err = json.Marshal(report.Summary); err != nil {
+			s.logger.WithError(err).WithField("report_id", report.ID).Error("Failed to marshal report summary")
+		}
+	}
+	_, err := s.db.DB.ExecContext(ctx, `
+		UPDATE analytics.reports
+		SET status = $2, error = NULLIF($3, ''), summary = $4, csv_key = NULLIF($5, ''),
+			summary_key = NULLIF($6, ''), emailed_to = $7, completed_at = $8
+		WHERE id = $1`,
+		report.ID, status, errMsg, summary, report.csvKey, report.summaryKey, report.EmailedTo, now)
+	if err != nil {
+		s.logger.WithError(err).WithField("report_id", report.ID).Error("Failed to record report result")
+	}
+}
+
+var reportKeyUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// reportObjectKey lays reports out by organization, frequency and period
+func reportObjectKey(report *Report, file string) string {
+	org := "platform"
+	if report.OrgID != "" {
+		org = "org-" + reportKeyUnsafe.ReplaceAllString(report.OrgID, "_")
+	}
+	return fmt.Sprintf("reports/%s/%s/%s/%s", org, report.Frequency, report.PeriodStart, file)
+}
+
+// RenderReportCSV renders a summary as CSV, one row per technique after a
+// row totalling all enhancements
+func RenderReportCSV(summary *ReportSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	rating := func(average *float64) string {
+		if average == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*average, 'f', 2, 64)
+	}
+
+	w.Write([]string{"technique", "uses", "rated", "average_rating"})
+	w.Write([]string{"(all)", strconv.FormatInt(summary.Enhancements, 10), strconv.FormatInt(summary.Rated, 10), rating(summary.AverageRating)})
+	for _, technique := range summary.Techniques {
+		w.Write([]string{technique.Technique, strconv.FormatInt(technique.Uses, 10), strconv.FormatInt(technique.Rated, 10), rating(technique.AverageRating)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to render report csv: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+var reportSummaryTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"rating": func(average *float64) string {
+		if average == nil {
+			return "-"
+		}
+		return strconv.FormatFloat(*average, 'f', 2, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>BetterPrompts {{.Frequency}} report</title>
+</head>
+<body style="font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Arial, sans-serif; line-height: 1.6; color: #333; max-width: 800px; margin: 40px auto;">
+    <h1>BetterPrompts {{.Frequency}} report</h1>
+    <p>{{.PeriodStart}} to {{.PeriodEnd}}{{if .OrgID}} &middot; organization {{.OrgID}}{{end}}</p>
+    <table cellpadding="6">
+        <tr><td>Enhancements</td><td>{{.Summary.Enhancements}}</td></tr>
+        <tr><td>Active users</td><td>{{.Summary.ActiveUsers}}</td></tr>
+        <tr><td>Rated</td><td>{{.Summary.Rated}}</td></tr>
+        <tr><td>Average rating</td><td>{{rating .Summary.AverageRating}}</td></tr>
+    </table>
+    <h2>Techniques</h2>
+    <table cellpadding="6" style="border-collapse: collapse;">
+        <tr style="text-align: left; border-bottom: 1px solid #ddd;"><th>Technique</th><th>Uses</th><th>Rated</th><th>Average rating</th></tr>
+        {{range .Summary.Techniques}}<tr><td>{{.Technique}}</td><td>{{.Uses}}</td><td>{{.Rated}}</td><td>{{rating .AverageRating}}</td></tr>
+        {{else}}<tr><td colspan="4">No enhancements in this period</td></tr>
+        {{end}}
+    </table>
+</body>
+</html>`))
+
+// RenderReportSummary renders a report's summary as an HTML page
+func RenderReportSummary(report *Report) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := reportSummaryTemplate.Execute(&buf, report); err != nil {
+		return nil, fmt.Errorf("failed to render report summary: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+const reportScheduleColumns = `id, org_id, frequency, recipients, enabled, COALESCE(created_by::text, ''), created_at, updated_at`
+
+// ListSchedules returns an organization's report schedules
+func (s *ReportService) ListSchedules(ctx context.Context, orgID string) ([]*ReportSchedule, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+reportScheduleColumns+`
+		FROM analytics.report_schedules
+		WHERE org_id = $1
+		ORDER BY frequency`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query report schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := []*ReportSchedule{}
+	for rows.Next() {
+		schedule, err := scanReportSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate report schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// CreateSchedule adds a report schedule. Recipients must have been
+// normalized.
+func (s *ReportService) CreateSchedule(ctx context.Context, schedule ReportSchedule) (*ReportSchedule, error) {
+	created, err := scanReportSchedule(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO analytics.report_schedules (org_id, frequency, recipients, enabled, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid)
+		RETURNING `+reportScheduleColumns,
+		schedule.OrgID, schedule.Frequency, pq.Array(schedule.Recipients), schedule.Enabled, schedule.CreatedBy,
+	))
+	if err != nil {
+		return nil, reportScheduleWriteError(err)
+	}
+	return created, nil
+}
+
+// UpdateSchedule replaces an organization's report schedule. Recipients
+// must have been normalized.
+func (s *ReportService) UpdateSchedule(ctx context.Context, orgID, id string, schedule ReportSchedule) (*ReportSchedule, error) {
+	updated, err := scanReportSchedule(s.db.DB.QueryRowContext(ctx, `
+		UPDATE analytics.report_schedules
+		SET frequency = $3, recipients = $4, enabled = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE org_id = $1 AND id = $2
+		RETURNING `+reportScheduleColumns,
+		orgID, id, schedule.Frequency, pq.Array(schedule.Recipients), schedule.Enabled,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReportScheduleNotFound
+	}
+	if err != nil {
+		return nil, reportScheduleWriteError(err)
+	}
+	return updated, nil
+}
+
+// DeleteSchedule removes an organization's report schedule. Reports it
+// generated are kept.
+func (s *ReportService) DeleteSchedule(ctx context.Context, orgID, id string) error {
+	result, err := s.db.DB.ExecContext(ctx,
+		`DELETE FROM analytics.report_schedules WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete report schedule: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrReportScheduleNotFound
+	}
+	return nil
+}
+
+const reportColumns = `id, COALESCE(schedule_id::text, ''), org_id, frequency, period_start, period_end, status,
+	attempts, summary, COALESCE(csv_key, ''), COALESCE(summary_key, ''), emailed_to, error, created_at, completed_at`
+
+// ListReports returns an organization's reports, newest period first
+func (s *ReportService) ListReports(ctx context.Context, orgID string, limit int) ([]*Report, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM analytics.reports
+		WHERE org_id = $1
+		ORDER BY period_start DESC, frequency
+		LIMIT $2`, orgID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate reports: %w", err)
+	}
+	return reports, nil
+}
+
+// GetReport returns one of an organization's reports
+func (s *ReportService) GetReport(ctx context.Context, orgID, id string) (*Report, error) {
+	report, err := scanReport(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+reportColumns+`
+		FROM analytics.reports
+		WHERE org_id = $1 AND id = $2`, orgID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrReportNotFound
+	}
+	return report, err
+}
+
+// DownloadURL returns a short-lived link to one of a report's files,
+// ReportFileCSV or ReportFileSummary
+func (s *ReportService) DownloadURL(ctx context.Context, report *Report, file string) (string, error) {
+	key := report.csvKey
+	if file == ReportFileSummary {
+		key = report.summaryKey
+	}
+	if key == "" {
+		return "", ErrReportNotReady
+	}
+	return s.storage.SignedURL(ctx, key, ReportDownloadExpiry)
+}
+
+func reportScheduleWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrReportScheduleExists
+	}
+	return fmt.Errorf("failed to save report schedule: %w", err)
+}
+
+func scanReportSchedule(row rowScanner) (*ReportSchedule, error) {
+	var schedule ReportSchedule
+	err := row.Scan(&schedule.ID, &schedule.OrgID, &schedule.Frequency, pq.Array(&schedule.Recipients),
+		&schedule.Enabled, &schedule.CreatedBy, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan report schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+func scanReport(row rowScanner) (*Report, error) {
+	var report Report
+	var periodStart, periodEnd time.Time
+	var summary []byte
+	var errMsg sql.NullString
+	var completedAt sql.NullTime
+
+	err := row.Scan(&report.ID, &report.ScheduleID, &report.OrgID, &report.Frequency, &periodStart, &periodEnd,
+		&report.Status, &report.Attempts, &summary, &report.csvKey, &report.summaryKey, &report.EmailedTo,
+		&errMsg, &report.CreatedAt, &completedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan report: %w", err)
+	}
+
+	report.PeriodStart = periodStart.Format("2006-01-02")
+	report.PeriodEnd = periodEnd.Format("2006-01-02")
+	report.Error = errMsg.String
+	if completedAt.Valid {
+		report.CompletedAt = &completedAt.Time
+	}
+	if len(summary) > 0 {
+		if err := json.Unmarshal(summary, &report.Summary); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal report summary: %w", err)
+		}
+	}
+	return &report, nil
+}
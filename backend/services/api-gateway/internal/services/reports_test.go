@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportPeriod(t *testing.T) {
+	// Wednesday 4 March 2026
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+
+	start, end := ReportPeriod(ReportFrequencyWeekly, now)
+	assert.Equal(t, "2026-02-23", start.Format("2006-01-02"))
+	assert.Equal(t, "2026-03-02", end.Format("2006-01-02"))
+
+	start, end = ReportPeriod(ReportFrequencyWeekly, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC))
+	assert.Equal(t, "2026-02-23", start.Format("2006-01-02"), "a week is complete from Monday midnight")
+	assert.Equal(t, "2026-03-02", end.Format("2006-01-02"))
+
+	start, end = ReportPeriod(ReportFrequencyMonthly, now)
+	assert.Equal(t, "2026-02-01", start.Format("2006-01-02"))
+	assert.Equal(t, "2026-03-01", end.Format("2006-01-02"))
+}
+
+func TestNormalizeReportRecipients(t *testing.T) {
+	recipients, err := NormalizeReportRecipients([]string{" Ops@Example.com", "ops@example.com", "cfo@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops@example.com", "cfo@example.com"}, recipients)
+
+	_, err = NormalizeReportRecipients(nil)
+	assert.Error(t, err)
+	_, err = NormalizeReportRecipients([]string{"not an address"})
+	assert.Error(t, err)
+	_, err = NormalizeReportRecipients([]string{"Ops <ops@example.com>"})
+	assert.Error(t, err, "display names aren't stored")
+}
+
+func TestRenderReportCSV(t *testing.T) {
+	rating := 4.25
+	body, err := RenderReportCSV(&ReportSummary{
+		Enhancements: 12, ActiveUsers: 3, Rated: 4, AverageRating: &rating,
+		Techniques: []ReportTechnique{
+			{Technique: "chain_of_thought", Uses: 9, Rated: 4, AverageRating: &rating},
+			{Technique: "few_shot", Uses: 2},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "technique,uses,rated,average_rating\n"+
+		"(all),12,4,4.25\n"+
+		"chain_of_thought,9,4,4.25\n"+
+		"few_shot,2,0,\n", string(body))
+}
+
+func TestGenerateDueReports(t *testing.T) {
+	now := time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	claimed := map[string]bool{}
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "FROM analytics.report_schedules"):
+			return []string{"id", "org_id", "frequency", "recipients", "enabled", "created_by", "created_at", "updated_at"}, [][]driver.Value{
+				{"s-1", "acme", ReportFrequencyWeekly, []byte("{}"), true, "", now, now},
+				{"s-2", "acme", ReportFrequencyMonthly, []byte("{}"), true, "", now, now},
+			}
+		case strings.Contains(query, "INSERT INTO analytics.reports"):
+			// The monthly report was already generated by another instance
+			if claimed["weekly"] {
+				return []string{"id", "attempts", "created_at"}, nil
+			}
+			claimed["weekly"] = true
+			return []string{"id", "attempts", "created_at"}, [][]driver.Value{{"r-1", int64(1), now}}
+		case strings.Contains(query, "GROUP BY t.technique"):
+			return []string{"technique", "uses", "rated", "average"}, [][]driver.Value{{"chain_of_thought", int64(5), int64(2), 4.5}}
+		default:
+			return []string{"count", "users", "rated", "average"}, [][]driver.Value{{int64(6), int64(2), int64(2), 4.5}}
+		}
+	}
+
+	dir := t.TempDir()
+	storage, err := NewLocalStorage(dir, "/files", "secret")
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	reports := NewReportService(NewDatabaseService(db.DB), storage, nil, logger)
+
+	require.NoError(t, reports.GenerateDue(context.Background(), now))
+
+	csvBody, err := os.ReadFile(filepath.Join(dir, "reports/org-acme/weekly/2026-02-23/usage.csv"))
+	require.NoError(t, err)
+	assert.Contains(t, string(csvBody), "chain_of_thought,5,2,4.50")
+	summary, err := os.ReadFile(filepath.Join(dir, "reports/org-acme/weekly/2026-02-23/summary.html"))
+	require.NoError(t, err)
+	assert.Contains(t, string(summary), "<td>chain_of_thought</td>")
+
+	var finished int
+	for _, entry := range d.entries() {
+		if strings.HasPrefix(entry, "UPDATE analytics.reports") {
+			finished++
+		}
+	}
+	assert.Equal(t, 1, finished, "only the claimed report is generated")
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tiers a RequestContext can carry besides the paid ones stored on the user
+const (
+	TierAnonymous = "anonymous"
+	TierFree      = "free"
+)
+
+// ClientInfo describes where a request came from
+type ClientInfo struct {
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	APIKeyID  string `json:"api_key_id,omitempty"`
+	Region    string `json:"region,omitempty"`
+}
+
+// RequestContext is everything the gateway knows about the caller of one
+// request. It is built once by middleware and handed to the pipeline and the
+// downstream clients, so limits, model routing and logs all agree on who is
+// asking.
+type RequestContext struct {
+	RequestID   string          `json:"request_id"`
+	TraceParent string          `json:"-"` // W3C trace context, with the gateway's span as parent
+	TraceState  string          `json:"-"`
+	UserID      string          `json:"user_id,omitempty"`
+	Email       string          `json:"-"`
+	Roles       []string        `json:"roles,omitempty"`
+	Tier        string          `json:"tier"`
+	OrgID       string          `json:"org_id,omitempty"`
+	Flags       map[string]bool `json:"flags,omitempty"`
+	Trial       *time.Time      `json:"trial_expires_at,omitempty"` // Set while on a trial
+	Locale      string          `json:"locale"`
+	Deadline    time.Time       `json:"deadline"`
+	Client      ClientInfo      `json:"client"`
+
+	// ExplanationStyle is how enhancement explanations are worded for the
+	// caller, from their preferences; empty means plain language
+	ExplanationStyle string `json:"explanation_style,omitempty"`
+}
+
+// Authenticated reports whether the request carries a user
+func (rc *RequestContext) Authenticated() bool {
+	return rc != nil && rc.UserID != ""
+}
+
+// OnTrial reports whether the caller's tier comes from a trial
+func (rc *RequestContext) OnTrial() bool {
+	return rc != nil && rc.Trial != nil
+}
+
+// HasFlag reports whether a feature flag is on for the caller
+func (rc *RequestContext) HasFlag(name string) bool {
+	return rc != nil && rc.Flags[name]
+}
+
+// RoutingKey keeps a caller on the same canary variant: the user when known,
+// otherwise the session
+func (rc *RequestContext) RoutingKey() string {
+	if rc == nil {
+		return ""
+	}
+	if rc.UserID != "" {
+		return rc.UserID
+	}
+	return rc.Client.SessionID
+}
+
+// LogFields are the caller fields every request log line carries
+func (rc *RequestContext) LogFields() map[string]interface{} {
+	fields := map[string]interface{}{"tier": rc.Tier}
+	if rc.UserID != "" {
+		fields["user_id"] = rc.UserID
+	}
+	if rc.OrgID != "" {
+		fields["org_id"] = rc.OrgID
+	}
+	if rc.Client.APIKeyID != "" {
+		fields["api_key_id"] = rc.Client.APIKeyID
+	}
+	return fields
+}
+
+type requestContextKey struct{}
+
+// WithRequestContext attaches rc to ctx for the downstream clients
+func WithRequestContext(ctx context.Context, rc *RequestContext) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, rc)
+}
+
+// RequestContextFrom returns the RequestContext attached to ctx, or nil
+func RequestContextFrom(ctx context.Context) *RequestContext {
+	rc, _ := ctx.Value(requestContextKey{}).(*RequestContext)
+	return rc
+}
+
+// setCallerHeaders forwards the caller's identity to a downstream service so
+// its logs and decisions line up with the gateway's
+func setCallerHeaders(ctx context.Context, req *http.Request) {
+	rc := RequestContextFrom(ctx)
+	if rc == nil {
+		return
+	}
+	if rc.RequestID != "" {
+		req.Header.Set("X-Request-ID", rc.RequestID)
+	}
+	if rc.TraceParent != "" {
+		req.Header.Set("traceparent", rc.TraceParent)
+		if rc.TraceState != "" {
+			req.Header.Set("tracestate", rc.TraceState)
+		}
+	}
+	if rc.UserID != "" {
+		req.Header.Set("X-User-ID", rc.UserID)
+	}
+	if rc.Tier != "" {
+		req.Header.Set("X-User-Tier", rc.Tier)
+	}
+	if rc.Locale != "" {
+		req.Header.Set("Accept-Language", rc.Locale)
+	}
+}
+
+// callerHeadersTransport forwards the caller's identity on every request
+// made with a context carrying a RequestContext, for clients shared by code
+// that builds its own requests
+type callerHeadersTransport struct {
+	base http.RoundTripper
+}
+
+func (t *callerHeadersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if RequestContextFrom(req.Context()) != nil {
+		req = req.Clone(req.Context())
+		setCallerHeaders(req.Context(), req)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Account is the per-user part of a RequestContext
+type Account struct {
+	Tier  string
+	OrgID string
+	Flags map[string]bool
+	Trial *time.Time // When the trial the tier comes from expires
+
+	ExplanationStyle string // From the user's preferences
+}
+
+// AccountResolver looks up the tier, trial, organization and feature flags
+// of a user, keeping each answer for a short while so they aren't read on every
+// request. The organization and flags live in the user's metadata under
+// "org_id" and "feature_flags".
+type AccountResolver struct {
+	db  *DatabaseService
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedAccount
+}
+
+// maxCachedAccounts is when Resolve starts sweeping out expired accounts
+const maxCachedAccounts = 10000
+
+type cachedAccount struct {
+	account Account
+	expires time.Time
+}
+
+// NewAccountResolver creates an AccountResolver caching accounts for ttl
+func NewAccountResolver(db *DatabaseService, ttl time.Duration) *AccountResolver {
+	return &AccountResolver{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[string]cachedAccount),
+	}
+}
+
+// Resolve returns a user's account
+func (r *AccountResolver) Resolve(ctx context.Context, userID string) (Account, error) {
+	now := time.Now()
+	r.mu.Lock()
+	cached, ok := r.entries[userID]
+	r.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.account, nil
+	}
+
+	var tier sql.NullString
+	var metaJSON []byte
+	var trial sql.NullTime
+	var explanationStyle sql.NullString
+	query := `SELECT tier, metadata, trial_expires_at, preferences->>'` + ExplanationStylePreference + `' FROM auth.users WHERE id = $1`
+	if err := r.db.DB.QueryRowContext(ctx, query, userID).Scan(&tier, &metaJSON, &trial, &explanationStyle); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Account{}, errors.New("user not found")
+		}
+		return Account{}, fmt.Errorf("failed to resolve account: %w", err)
+	}
+
+	account := Account{Tier: tier.String, ExplanationStyle: explanationStyle.String}
+	if account.Tier == "" {
+		account.Tier = TierFree
+	}
+	if trial.Valid {
+		account.Trial = &trial.Time
+	}
+	var metadata struct {
+		OrgID        string   `json:"org_id"`
+		FeatureFlags []string `json:"feature_flags"`
+	}
+	if len(metaJSON) > 0 && json.Unmarshal(metaJSON, &metadata) == nil {
+		account.OrgID = metadata.OrgID
+		if len(metadata.FeatureFlags) > 0 {
+			account.Flags = make(map[string]bool, len(metadata.FeatureFlags))
+			for _, flag := range metadata.FeatureFlags {
+				account.Flags[flag] = true
+			}
+		}
+	}
+
+	r.mu.Lock()
+	if len(r.entries) >= maxCachedAccounts {
+		for id, entry := range r.entries {
+			if now.After(entry.expires) {
+				delete(r.entries, id)
+			}
+		}
+	}
+	r.entries[userID] = cachedAccount{account: account, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+	return account, nil
+}
+
+// Forget drops a cached account, for changes that must apply immediately
+func (r *AccountResolver) Forget(userID string) {
+	r.mu.Lock()
+	delete(r.entries, userID)
+	r.mu.Unlock()
+}
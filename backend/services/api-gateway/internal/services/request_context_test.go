@@ -0,0 +1,86 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountResolver(t *testing.T) {
+	ctx := context.Background()
+	trialEnd := time.Date(2026, 3, 18, 12, 0, 0, 0, time.UTC)
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"tier", "metadata", "trial_expires_at", "explanation_style"}, [][]driver.Value{
+			{"pro", []byte(`{"org_id":"org-1","feature_flags":["streaming","beta_models"]}`), trialEnd, "technical"},
+		}
+	}
+	resolver := NewAccountResolver(NewDatabaseService(db.DB), time.Minute)
+
+	account, err := resolver.Resolve(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, "pro", account.Tier)
+	assert.Equal(t, "org-1", account.OrgID)
+	assert.Equal(t, map[string]bool{"streaming": true, "beta_models": true}, account.Flags)
+	require.NotNil(t, account.Trial)
+	assert.Equal(t, trialEnd, *account.Trial)
+	assert.Equal(t, ExplanationStyleTechnical, account.ExplanationStyle)
+
+	_, err = resolver.Resolve(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Len(t, d.entries(), 1, "the second lookup is served from the cache")
+
+	resolver.Forget("user-1")
+	_, err = resolver.Resolve(ctx, "user-1")
+	require.NoError(t, err)
+	assert.Len(t, d.entries(), 2)
+}
+
+func TestSetCallerHeaders(t *testing.T) {
+	req, err := http.NewRequest(http.MethodPost, "http://selector/api/v1/select", nil)
+	require.NoError(t, err)
+
+	setCallerHeaders(context.Background(), req)
+	assert.Empty(t, req.Header, "nothing to forward without a RequestContext")
+
+	ctx := WithRequestContext(context.Background(), &RequestContext{
+		RequestID:   "req-1",
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceState:  "vendor=1",
+		UserID:      "user-1",
+		Tier:        "pro",
+		Locale:      "de-de",
+	})
+	setCallerHeaders(ctx, req)
+	assert.Equal(t, "req-1", req.Header.Get("X-Request-ID"))
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", req.Header.Get("traceparent"))
+	assert.Equal(t, "vendor=1", req.Header.Get("tracestate"))
+	assert.Equal(t, "user-1", req.Header.Get("X-User-ID"))
+	assert.Equal(t, "pro", req.Header.Get("X-User-Tier"))
+	assert.Equal(t, "de-de", req.Header.Get("Accept-Language"))
+}
+
+func TestCallerHeadersTransport(t *testing.T) {
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &callerHeadersTransport{base: http.DefaultTransport}}
+
+	ctx := WithRequestContext(context.Background(), &RequestContext{RequestID: "req-1"})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "req-1", got.Get("X-Request-ID"))
+	assert.Empty(t, req.Header.Get("X-Request-ID"), "the caller's request isn't modified")
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// MaxRequestLogRetention caps REQUEST_LOG_RETENTION; the request log is
+	// for recent traffic, not an archive
+	MaxRequestLogRetention = 14 * 24 * time.Hour
+
+	// MaxRequestLogResults bounds one search page
+	MaxRequestLogResults = 500
+)
+
+var requestLogDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "gateway_request_log_dropped_total",
+	Help: "Requests left out of the request log because its queue was full or a write failed",
+})
+
+// ErrInvalidRequestLogQuery is returned for searches with contradictory
+// bounds
+var ErrInvalidRequestLogQuery = errors.New("invalid request log query")
+
+// RequestLogConfig controls the admin request log
+type RequestLogConfig struct {
+	Enabled       bool
+	Retention     time.Duration // Rows older than this are pruned and never returned
+	BatchSize     int           // Rows written per statement
+	FlushInterval time.Duration // Longest a record waits for its batch to fill
+	QueueSize     int           // Records held before new ones are dropped
+	TraceURL      string        // Optional; link to a trace with {trace_id} in place of its ID
+}
+
+// LoadRequestLogConfig reads REQUEST_LOG_ENABLED, REQUEST_LOG_RETENTION,
+// REQUEST_LOG_BATCH_SIZE, REQUEST_LOG_FLUSH_INTERVAL,
+// REQUEST_LOG_QUEUE_SIZE and REQUEST_LOG_TRACE_URL
+func LoadRequestLogConfig() RequestLogConfig {
+	config := RequestLogConfig{
+		Enabled:       true,
+		Retention:     72 * time.Hour,
+		BatchSize:     500,
+		FlushInterval: 2 * time.Second,
+		QueueSize:     10000,
+		TraceURL:      getEnv("REQUEST_LOG_TRACE_URL", ""),
+	}
+	if enabled, err := strconv.ParseBool(getEnv("REQUEST_LOG_ENABLED", "")); err == nil {
+		config.Enabled = enabled
+	}
+	if d, err := time.ParseDuration(getEnv("REQUEST_LOG_RETENTION", "")); err == nil && d > 0 {
+		config.Retention = min(d, MaxRequestLogRetention)
+	}
+	if n, err := strconv.Atoi(getEnv("REQUEST_LOG_BATCH_SIZE", "")); err == nil && n > 0 {
+		config.BatchSize = n
+	}
+	if d, err := time.ParseDuration(getEnv("REQUEST_LOG_FLUSH_INTERVAL", "")); err == nil && d > 0 {
+		config.FlushInterval = d
+	}
+	if n, err := strconv.Atoi(getEnv("REQUEST_LOG_QUEUE_SIZE", "")); err == nil && n > 0 {
+		config.QueueSize = n
+	}
+	return config
+}
+
+// RequestRecord is one request as the request log keeps it
+type RequestRecord struct {
+	ID            int64     `json:"id"`
+	RequestID     string    `json:"request_id"`
+	TraceID       string    `json:"trace_id,omitempty"`
+	TraceURL      string    `json:"trace_url,omitempty"`
+	UserID        string    `json:"user_id,omitempty"`
+	Method        string    `json:"method"`
+	Route         string    `json:"route,omitempty"` // The matched route pattern, empty for unmatched paths
+	Path          string    `json:"path"`
+	Status        int       `json:"status"`
+	LatencyMs     int64     `json:"latency_ms"`
+	ResponseBytes int       `json:"response_bytes"`
+	IP            string    `json:"ip,omitempty"`
+	Region        string    `json:"region,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// RequestLogQuery filters a request log search. Zero fields match
+// everything.
+type RequestLogQuery struct {
+	UserID      string
+	RequestID   string
+	TraceID     string
+	Status      int    // One status code
+	StatusClass int    // A class of status codes, such as 5 for 5xx
+	PathPrefix  string // Matched against the request path
+	MinLatency  time.Duration
+	MaxLatency  time.Duration
+	From        time.Time
+	To          time.Time
+	Before      int64 // Only records with a smaller ID, for paging
+	Limit       int
+}
+
+// RequestLogPage is one page of a search, newest first
+type RequestLogPage struct {
+	Records    []*RequestRecord `json:"records"`
+	NextBefore int64            `json:"next_before,omitempty"` // Pass as ?before= for the next page; 0 after the last
+	Retention  string           `json:"retention"`
+}
+
+// RequestLogService keeps a short-lived, searchable log of the requests the
+// gateway served. Records are queued by the request logging middleware and
+// written in batches; when the queue is full they are dropped rather than
+// slowing requests down.
+type RequestLogService struct {
+	db     *DatabaseService
+	config RequestLogConfig
+	logger *logrus.Logger
+
+	mu      sync.RWMutex // Held for reading while enqueueing, so Close can't miss a record
+	closed  bool
+	records chan *RequestRecord
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewRequestLogService starts a request log writing to db. It returns nil
+// when the request log is disabled.
+func NewRequestLogService(db *DatabaseService, config RequestLogConfig, logger *logrus.Logger) *RequestLogService {
+	if !config.Enabled {
+		return nil
+	}
+	s := &RequestLogService{
+		db:      db,
+		config:  config,
+		logger:  logger,
+		records: make(chan *RequestRecord, config.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Record queues a request to be written. It never blocks.
+func (s *RequestLogService) Record(record *RequestRecord) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.records <- record:
+	default:
+		requestLogDropped.Inc()
+	}
+}
+
+// Close stops accepting records and waits for the queued ones to be written
+func (s *RequestLogService) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		<-s.stopped
+		return
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.done)
+	<-s.stopped
+}
+
+func (s *RequestLogService) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*RequestRecord, 0, s.config.BatchSize)
+	for {
+		select {
+		case record := <-s.records:
+			batch = append(batch, record)
+			if len(batch) >= s.config.BatchSize {
+				batch = s.write(batch)
+			}
+		case <-ticker.C:
+			batch = s.write(batch)
+		case <-s.done:
+			for {
+				select {
+				case record := <-s.records:
+					batch = append(batch, record)
+				default:
+					s.write(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// write stores a batch and returns it emptied. A batch that fails is
+// dropped; the request log is best effort.
+func (s *RequestLogService) write(batch []*RequestRecord) []*RequestRecord {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.insert(ctx, batch); err != nil {
+		requestLogDropped.Add(float64(len(batch)))
+		s.logger.WithError(err).WithField("records", len(batch)).Warn("Failed to write request log batch")
+	}
+	return batch[:0]
+}
+
+func (s *RequestLogService) insert(ctx context.Context, batch []*RequestRecord) error {
+	n := len(batch)
+	requestIDs, traceIDs, userIDs := make([]string, n), make([]string, n), make([]string, n)
+	methods, routes, paths := make([]string, n), make([]string, n), make([]string, n)
+	statuses, latencies, sizes := make([]int64, n), make([]int64, n), make([]int64, n)
+	ips, regions, createdAt := make([]string, n), make([]string, n), make([]time.Time, n)
+	for i, r := range batch {
+		requestIDs[i], traceIDs[i], userIDs[i] = r.RequestID, r.TraceID, r.UserID
+		methods[i], routes[i], paths[i] = r.Method, r.Route, r.Path
+		statuses[i], latencies[i], sizes[i] = int64(r.Status), r.LatencyMs, int64(r.ResponseBytes)
+		ips[i], regions[i], createdAt[i] = r.IP, r.Region, r.CreatedAt
+	}
+
+	_, err := s.db.DB.ExecContext(ctx, `
+		INSERT INTO analytics.request_log (
+			request_id, trace_id, user_id, method, route, path, status,
+			latency_ms, response_bytes, ip_address, region, created_at
+		)
+		SELECT LEFT(r.request_id, 128), NULLIF(r.trace_id, ''), NULLIF(r.user_id, '')::uuid, r.method,
+			NULLIF(LEFT(r.route, 255), ''), LEFT(r.path, 2048), r.status, r.latency_ms, r.response_bytes,
+			NULLIF(r.ip_address, ''), NULLIF(r.region, ''), r.created_at
+		FROM unnest(
+			$1::text[], $2::text[], $3::text[], $4::text[], $5::text[], $6::text[], $7::int[],
+			$8::bigint[], $9::bigint[], $10::text[], $11::text[], $12::timestamptz[]
+		) AS r(request_id, trace_id, user_id, method, route, path, status,
+			latency_ms, response_bytes, ip_address, region, created_at)`,
+		pq.Array(requestIDs), pq.Array(traceIDs), pq.Array(userIDs), pq.Array(methods),
+		pq.Array(routes), pq.Array(paths), pq.Array(statuses), pq.Array(latencies),
+		pq.Array(sizes), pq.Array(ips), pq.Array(regions), pq.Array(createdAt))
+	if err != nil {
+		return fmt.Errorf("failed to write request log: %w", err)
+	}
+	return nil
+}
+
+// Search returns the records matching query, newest first. Records past
+// the retention are never returned, whether or not they were pruned yet.
+func (s *RequestLogService) Search(ctx context.Context, query RequestLogQuery) (*RequestLogPage, error) {
+	if query.MinLatency > 0 && query.MaxLatency > 0 && query.MinLatency > query.MaxLatency {
+		return nil, fmt.Errorf("%w: min_latency_ms is above max_latency_ms", ErrInvalidRequestLogQuery)
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && !query.From.Before(query.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidRequestLogQuery)
+	}
+	if query.Limit <= 0 || query.Limit > MaxRequestLogResults {
+		query.Limit = MaxRequestLogResults
+	}
+
+	var args sqlArgs
+	conditions := []string{"created_at >= " + args.add(time.Now().Add(-s.config.Retention))}
+	if query.UserID != "" {
+		conditions = append(conditions, "user_id = "+args.add(query.UserID))
+	}
+	if query.RequestID != "" {
+		conditions = append(conditions, "request_id = "+args.add(query.RequestID))
+	}
+	if query.TraceID != "" {
+		conditions = append(conditions, "trace_id = "+args.add(query.TraceID))
+	}
+	if query.Status > 0 {
+		conditions = append(conditions, "status = "+args.add(query.Status))
+	}
+	if query.StatusClass > 0 {
+		conditions = append(conditions, "status BETWEEN "+args.add(query.StatusClass*100)+" AND "+args.add(query.StatusClass*100+99))
+	}
+	if query.PathPrefix != "" {
+		conditions = append(conditions, "path LIKE "+args.add(escapeLike(query.PathPrefix)+"%"))
+	}
+	if query.MinLatency > 0 {
+		conditions = append(conditions, "latency_ms >= "+args.add(query.MinLatency.Milliseconds()))
+	}
+	if query.MaxLatency > 0 {
+		conditions = append(conditions, "latency_ms <= "+args.add(query.MaxLatency.Milliseconds()))
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "created_at >= "+args.add(query.From))
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "created_at < "+args.add(query.To))
+	}
+	if query.Before > 0 {
+		conditions = append(conditions, "id < "+args.add(query.Before))
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, request_id, COALESCE(trace_id, ''), COALESCE(user_id::text, ''), method,
+			COALESCE(route, ''), path, status, latency_ms, response_bytes,
+			COALESCE(ip_address, ''), COALESCE(region, ''), created_at
+		FROM analytics.request_log
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY id DESC
+		LIMIT `+args.add(query.Limit+1), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search request log: %w", err)
+	}
+	defer rows.Close()
+
+	page := &RequestLogPage{Records: []*RequestRecord{}, Retention: s.config.Retention.String()}
+	for rows.Next() {
+		var r RequestRecord
+		if err := rows.Scan(&r.ID, &r.RequestID, &r.TraceID, &r.UserID, &r.Method, &r.Route, &r.Path,
+			&r.Status, &r.LatencyMs, &r.ResponseBytes, &r.IP, &r.Region, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan request log: %w", err)
+		}
+		r.TraceURL = s.traceURL(r.TraceID)
+		page.Records = append(page.Records, &r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search request log: %w", err)
+	}
+
+	if len(page.Records) > query.Limit {
+		page.Records = page.Records[:query.Limit]
+		page.NextBefore = page.Records[query.Limit-1].ID
+	}
+	return page, nil
+}
+
+// traceURL links a trace ID to the tracing UI, when one is configured
+func (s *RequestLogService) traceURL(traceID string) string {
+	if traceID == "" || s.config.TraceURL == "" {
+		return ""
+	}
+	return strings.ReplaceAll(s.config.TraceURL, "{trace_id}", traceID)
+}
+
+// PruneJob returns the hourly job deleting records past the retention
+func (s *RequestLogService) PruneJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "request_log_prune",
+		Interval: time.Hour,
+		Timeout:  10 * time.Minute,
+		Run: func(ctx context.Context) error {
+			pruned, err := s.prune(ctx, time.Now().Add(-s.config.Retention))
+			if pruned > 0 {
+				s.logger.WithField("records", pruned).Info("Pruned request log")
+			}
+			return err
+		},
+	}
+}
+
+// prune deletes records created before cutoff in batches, so a large
+// backlog doesn't hold one long lock
+func (s *RequestLogService) prune(ctx context.Context, cutoff time.Time) (int64, error) {
+	var pruned int64
+	for {
+		result, err := s.db.DB.ExecContext(ctx, `
+			DELETE FROM analytics.request_log
+			WHERE id IN (
+				SELECT id FROM analytics.request_log
+				WHERE created_at < $1
+				LIMIT 10000
+			)`, cutoff)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to prune request log: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return pruned, fmt.Errorf("failed to prune request log: %w", err)
+		}
+		pruned += n
+		if n < 10000 {
+			return pruned, nil
+		}
+	}
+}
+
+// escapeLike escapes LIKE wildcards so a path prefix matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
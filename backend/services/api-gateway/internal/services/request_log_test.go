@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLog(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	config := RequestLogConfig{
+		Enabled:       true,
+		Retention:     72 * time.Hour,
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+		QueueSize:     10,
+		TraceURL:      "https://traces.example.com/trace/{trace_id}",
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		assert.Nil(t, NewRequestLogService(nil, RequestLogConfig{}, logger))
+	})
+
+	t.Run("writes queued records in one batch on close", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 2 })
+		requestLog := NewRequestLogService(NewDatabaseService(db.DB), config, logger)
+
+		requestLog.Record(&RequestRecord{RequestID: "req-1", Method: "GET", Path: "/api/v1/prompts", Status: 200})
+		requestLog.Record(&RequestRecord{RequestID: "req-2", Method: "POST", Path: "/api/v1/enhance", Status: 502})
+		requestLog.Close()
+		requestLog.Close()
+		requestLog.Record(&RequestRecord{RequestID: "req-3"})
+
+		entries := d.entries()
+		require.Len(t, entries, 1)
+		assert.Contains(t, entries[0], "INSERT INTO analytics.request_log")
+		assert.Contains(t, entries[0], "FROM unnest(")
+	})
+
+	t.Run("search", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		requestLog := NewRequestLogService(NewDatabaseService(db.DB), config, logger)
+		defer requestLog.Close()
+
+		at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		d.rows = func(string) ([]string, [][]driver.Value) {
+			columns := []string{"id", "request_id", "trace_id", "user_id", "method", "route", "path",
+				"status", "latency_ms", "response_bytes", "ip_address", "region", "created_at"}
+			return columns, [][]driver.Value{
+				{int64(30), "req-30", "4bf92f3577b34da6a3ce929d0e0e4736", "", "GET", "/api/v1/prompts/:id", "/api/v1/prompts/p1", int64(503), int64(1200), int64(80), "203.0.113.7", "eu", at},
+				{int64(29), "req-29", "", "", "GET", "", "/api/v1/prompts_x", int64(500), int64(900), int64(0), "", "", at},
+				{int64(28), "req-28", "", "", "GET", "", "/api/v1/prompts", int64(500), int64(800), int64(0), "", "", at},
+			}
+		}
+
+		page, err := requestLog.Search(ctx, RequestLogQuery{
+			UserID:      "11111111-1111-1111-1111-111111111111",
+			StatusClass: 5,
+			PathPrefix:  "/api/v1/prompts_",
+			MinLatency:  500 * time.Millisecond,
+			Limit:       2,
+		})
+		require.NoError(t, err)
+
+		entries := d.entries()
+		require.Len(t, entries, 1)
+		assert.Contains(t, entries[0], "WHERE created_at >= $1 AND user_id = $2 AND status BETWEEN $3 AND $4 AND path LIKE $5 AND latency_ms >= $6 ORDER BY id DESC LIMIT $7")
+		args := d.args[0]
+		assert.WithinDuration(t, time.Now().Add(-72*time.Hour), args[0].Value.(time.Time), time.Minute)
+		assert.Equal(t, int64(500), args[2].Value)
+		assert.Equal(t, int64(599), args[3].Value)
+		assert.Equal(t, `/api/v1/prompts\_%`, args[4].Value)
+		assert.Equal(t, int64(500), args[5].Value)
+		assert.Equal(t, int64(3), args[6].Value)
+
+		require.Len(t, page.Records, 2)
+		assert.Equal(t, "https://traces.example.com/trace/4bf92f3577b34da6a3ce929d0e0e4736", page.Records[0].TraceURL)
+		assert.Empty(t, page.Records[1].TraceURL)
+		assert.Equal(t, int64(29), page.NextBefore)
+		assert.Equal(t, "72h0m0s", page.Retention)
+	})
+
+	t.Run("rejects contradictory bounds", func(t *testing.T) {
+		requestLog := &RequestLogService{config: config}
+		_, err := requestLog.Search(ctx, RequestLogQuery{MinLatency: time.Second, MaxLatency: time.Millisecond})
+		assert.ErrorIs(t, err, ErrInvalidRequestLogQuery)
+		from := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		_, err = requestLog.Search(ctx, RequestLogQuery{From: from, To: from.Add(-time.Hour)})
+		assert.ErrorIs(t, err, ErrInvalidRequestLogQuery)
+	})
+
+	t.Run("prunes in batches", func(t *testing.T) {
+		deleted := []int64{10000, 10000, 42}
+		db, d := newRecordingDB(t, func(string) int64 {
+			n := deleted[0]
+			deleted = deleted[1:]
+			return n
+		})
+		requestLog := &RequestLogService{db: NewDatabaseService(db.DB), config: config, logger: logger}
+
+		pruned, err := requestLog.prune(ctx, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, int64(20042), pruned)
+		entries := d.entries()
+		assert.Len(t, entries, 3)
+		assert.True(t, strings.HasPrefix(entries[0], "DELETE FROM analytics.request_log"))
+	})
+}
+
+func TestLoadRequestLogConfig(t *testing.T) {
+	t.Setenv("REQUEST_LOG_RETENTION", "720h")
+	t.Setenv("REQUEST_LOG_ENABLED", "false")
+
+	config := LoadRequestLogConfig()
+	assert.False(t, config.Enabled)
+	assert.Equal(t, MaxRequestLogRetention, config.Retention)
+	assert.Equal(t, 500, config.BatchSize)
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Data residency regions
+const (
+	ResidencyEU = "eu"
+	ResidencyUS = "us"
+)
+
+// ResidencyRegions lists the regions an organization can pin its data to
+var ResidencyRegions = []string{ResidencyEU, ResidencyUS}
+
+var (
+	// ErrInvalidResidency is returned for regions that aren't residency regions
+	ErrInvalidResidency = errors.New("region must be one of eu, us")
+	// ErrResidencyUnavailable is returned when the region an organization's
+	// data belongs in has no database configured
+	ErrResidencyUnavailable = errors.New("data residency region is not available")
+	// ErrResidencyLocked is returned when moving an organization whose members
+	// already have history
+	ErrResidencyLocked = errors.New("data residency can't be changed once the organization has prompt history")
+)
+
+// ResidencyConfig holds the databases history is kept in per region
+type ResidencyConfig struct {
+	DefaultRegion string            // Region of the primary database
+	DatabaseURLs  map[string]string // Other regions' databases
+	CacheTTL      time.Duration     // How long an organization's region is trusted
+}
+
+// LoadResidencyConfig reads DATA_RESIDENCY_DEFAULT_REGION (us by default),
+// DATA_RESIDENCY_DATABASE_URLS, comma-separated region=url pairs, and
+// DATA_RESIDENCY_CACHE_TTL
+func LoadResidencyConfig() (ResidencyConfig, error) {
+	config := ResidencyConfig{
+		DefaultRegion: strings.ToLower(getEnv("DATA_RESIDENCY_DEFAULT_REGION", ResidencyUS)),
+		DatabaseURLs:  parseReplicaAddrs(os.Getenv("DATA_RESIDENCY_DATABASE_URLS")),
+		CacheTTL:      time.Minute,
+	}
+	if !slices.Contains(ResidencyRegions, config.DefaultRegion) {
+		return config, fmt.Errorf("DATA_RESIDENCY_DEFAULT_REGION: %w", ErrInvalidResidency)
+	}
+	for region := range config.DatabaseURLs {
+		if !slices.Contains(ResidencyRegions, region) {
+			return config, fmt.Errorf("DATA_RESIDENCY_DATABASE_URLS %q: %w", region, ErrInvalidResidency)
+		}
+	}
+	// The default region is the primary database
+	delete(config.DatabaseURLs, config.DefaultRegion)
+	if v, err := time.ParseDuration(os.Getenv("DATA_RESIDENCY_CACHE_TTL")); err == nil && v > 0 {
+		config.CacheTTL = v
+	}
+	return config, nil
+}
+
+// OrgResidency is the region an organization's data is kept in
+type OrgResidency struct {
+	OrgID     string     `json:"org_id"`
+	Region    string     `json:"region"`
+	Pinned    bool       `json:"pinned"`    // False when the organization is in the default region by default
+	Available bool       `json:"available"` // Whether the region's database is configured here
+	SetBy     string     `json:"set_by,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// ResidencyReport describes the regions this gateway serves and the
+// organizations pinned to one
+type ResidencyReport struct {
+	DefaultRegion    string          `json:"default_region"`
+	AvailableRegions []string        `json:"available_regions"`
+	Organizations    []*OrgResidency `json:"organizations"`
+}
+
+// ResidencyService keeps organizations' data residency and the history
+// store of each region. Organizations are in the default region, the
+// primary database's, until pinned to another.
+type ResidencyService struct {
+	db            *DatabaseService
+	defaultRegion string
+	regional      map[string]HistoryStore // Stores of the regions other than the default
+	ttl           time.Duration
+	logger        *logrus.Logger
+
+	mu      sync.Mutex
+	regions map[string]cachedResidency
+}
+
+type cachedResidency struct {
+	region  string
+	expires time.Time
+}
+
+// NewResidencyService creates a residency service, connecting to the
+// configured regional databases
+func NewResidencyService(db *DatabaseService, config ResidencyConfig, logger *logrus.Logger) (*ResidencyService, error) {
+	s := &ResidencyService{
+		db:            db,
+		defaultRegion: config.DefaultRegion,
+		regional:      make(map[string]HistoryStore),
+		ttl:           config.CacheTTL,
+		logger:        logger,
+		regions:       make(map[string]cachedResidency),
+	}
+	timeouts := LoadDBTimeoutConfig()
+	for region, dsn := range config.DatabaseURLs {
+		conn, err := sql.Open("postgres", timeouts.ApplyToDSN(dsn))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s database: %w", region, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = conn.PingContext(ctx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to ping %s database: %w", region, err)
+		}
+		s.regional[region] = NewDatabaseServiceWithTimeouts(conn, timeouts, logger)
+	}
+	return s, nil
+}
+
+// Enabled reports whether any region besides the default is configured
+func (s *ResidencyService) Enabled() bool {
+	return len(s.regional) > 0
+}
+
+// AvailableRegions returns the regions history can be kept in, the default
+// first
+func (s *ResidencyService) AvailableRegions() []string {
+	regions := make([]string, 0, len(s.regional))
+	for region := range s.regional {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	return append([]string{s.defaultRegion}, regions...)
+}
+
+// Region returns the region an organization's data is kept in. Users without
+// an organization are in the default region.
+func (s *ResidencyService) Region(ctx context.Context, orgID string) (string, error) {
+	if orgID == "" {
+		return s.defaultRegion, nil
+	}
+	s.mu.Lock()
+	cached, ok := s.regions[orgID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.region, nil
+	}
+
+	residency, err := s.Get(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.regions[orgID] = cachedResidency{region: residency.Region, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return residency.Region, nil
+}
+
+// Get returns an organization's residency
+func (s *ResidencyService) Get(ctx context.Context, orgID string) (*OrgResidency, error) {
+	residency := &OrgResidency{OrgID: orgID, Region: s.defaultRegion}
+	var setBy sql.NullString
+	var updatedAt time.Time
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT region, set_by, updated_at FROM auth.org_residency WHERE org_id = $1`, orgID).
+		Scan(&residency.Region, &setBy, &updatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, fmt.Errorf("failed to get data residency: %w", err)
+	default:
+		residency.Pinned = true
+		residency.SetBy = setBy.String
+		residency.UpdatedAt = &updatedAt
+	}
+	residency.Available = s.available(residency.Region)
+	return residency, nil
+}
+
+// SetRegion pins an organization's data to a region. The region can only
+// change while none of the organization's members have history.
+func (s *ResidencyService) SetRegion(ctx context.Context, orgID, region, setBy string, history HistoryStore) (*OrgResidency, error) {
+	if !slices.Contains(ResidencyRegions, region) {
+		return nil, ErrInvalidResidency
+	}
+	if !s.available(region) {
+		return nil, ErrResidencyUnavailable
+	}
+	current, err := s.Get(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if current.Region != region {
+		members, err := s.members(ctx, orgID)
+		if err != nil {
+			return nil, err
+		}
+		for _, userID := range members {
+			entries, _, err := history.GetUserPromptHistoryWithFilters(ctx, userID, models.PaginationRequest{Page: 1, Limit: 1})
+			if err != nil {
+				return nil, fmt.Errorf("failed to check organization history: %w", err)
+			}
+			if len(entries) > 0 {
+				return nil, ErrResidencyLocked
+			}
+		}
+	}
+
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO auth.org_residency (org_id, region, set_by, updated_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, CURRENT_TIMESTAMP)
+		ON CONFLICT (org_id) DO UPDATE SET region = EXCLUDED.region, set_by = EXCLUDED.set_by, updated_at = EXCLUDED.updated_at`,
+		orgID, region, setBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set data residency: %w", err)
+	}
+	s.mu.Lock()
+	delete(s.regions, orgID)
+	s.mu.Unlock()
+	return s.Get(ctx, orgID)
+}
+
+// Report lists the regions served here and every pinned organization
+func (s *ResidencyService) Report(ctx context.Context) (*ResidencyReport, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT org_id, region, COALESCE(set_by::text, ''), updated_at
+		FROM auth.org_residency ORDER BY org_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list data residency: %w", err)
+	}
+	defer rows.Close()
+
+	report := &ResidencyReport{
+		DefaultRegion:    s.defaultRegion,
+		AvailableRegions: s.AvailableRegions(),
+		Organizations:    []*OrgResidency{},
+	}
+	for rows.Next() {
+		residency := &OrgResidency{Pinned: true}
+		var updatedAt time.Time
+		if err := rows.Scan(&residency.OrgID, &residency.Region, &residency.SetBy, &updatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan data residency: %w", err)
+		}
+		residency.UpdatedAt = &updatedAt
+		residency.Available = s.available(residency.Region)
+		report.Organizations = append(report.Organizations, residency)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list data residency: %w", err)
+	}
+	return report, nil
+}
+
+// members returns the IDs of an organization's members
+func (s *ResidencyService) members(ctx context.Context, orgID string) ([]string, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `SELECT id FROM auth.users WHERE metadata->>'org_id' = $1`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organization members: %w", err)
+	}
+	defer rows.Close()
+	var members []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan organization member: %w", err)
+		}
+		members = append(members, id)
+	}
+	return members, rows.Err()
+}
+
+// available reports whether a region's history store is configured
+func (s *ResidencyService) available(region string) bool {
+	return region == s.defaultRegion || s.regional[region] != nil
+}
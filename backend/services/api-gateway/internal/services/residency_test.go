@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestResidency returns a residency service with us as the default region,
+// eu served by regional and the given organizations pinned
+func newTestResidency(t *testing.T, regional HistoryStore, pinned map[string]string) *ResidencyService {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, "FROM auth.org_residency WHERE org_id") {
+			return nil, nil
+		}
+		d.mu.Lock()
+		orgID := d.args[len(d.args)-1][0].Value.(string)
+		d.mu.Unlock()
+		if region, ok := pinned[orgID]; ok {
+			return []string{"region", "set_by", "updated_at"}, [][]driver.Value{{region, nil, time.Now()}}
+		}
+		return nil, nil
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	residency, err := NewResidencyService(NewDatabaseService(db.DB), ResidencyConfig{DefaultRegion: ResidencyUS, CacheTTL: time.Minute}, logger)
+	require.NoError(t, err)
+	if regional != nil {
+		residency.regional[ResidencyEU] = regional
+	}
+	return residency
+}
+
+func TestResidencyHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	us := &memoryHistory{entries: map[string]models.PromptHistory{}}
+	eu := &memoryHistory{entries: map[string]models.PromptHistory{}}
+	residency := newTestResidency(t, eu, map[string]string{"acme-eu": ResidencyEU})
+	store := &residencyHistoryStore{
+		HistoryStore: us,
+		residency:    residency,
+		accounts:     orgAccounts{"berlin": "acme-eu", "paris": "acme-eu", "boston": "globex", "solo": ""},
+	}
+	as := func(userID, orgID string) context.Context {
+		return WithRequestContext(ctx, &RequestContext{UserID: userID, OrgID: orgID})
+	}
+	save := func(userID string) string {
+		id, err := store.SavePromptHistory(ctx, models.PromptHistory{
+			UserID:        sql.NullString{String: userID, Valid: true},
+			OriginalInput: "summarize the quarterly numbers",
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	euID, usID := save("berlin"), save("boston")
+	assert.Contains(t, eu.entries, euID)
+	assert.NotContains(t, us.entries, euID)
+	assert.Contains(t, us.entries, usID)
+
+	for name, ctx := range map[string]context.Context{
+		"the owner":   as("berlin", "acme-eu"),
+		"a colleague": as("paris", "acme-eu"),
+		"the gateway": ctx,
+	} {
+		entry, err := store.GetPromptHistory(ctx, euID)
+		require.NoError(t, err, name)
+		assert.Equal(t, "berlin", entry.UserID.String, name)
+	}
+
+	t.Run("blocks reads across regions", func(t *testing.T) {
+		for name, ctx := range map[string]context.Context{
+			"another region's organization": as("boston", "globex"),
+			"a user without organization":   as("solo", ""),
+		} {
+			_, err := store.GetPromptHistory(ctx, euID)
+			assert.True(t, errors.Is(err, ErrPromptHistoryNotFound), "%s: %v", name, err)
+
+			entries, total, err := store.GetUserPromptHistoryWithFilters(ctx, "berlin", models.PaginationRequest{Page: 1, Limit: 10})
+			require.NoError(t, err)
+			assert.Empty(t, entries, name)
+			assert.Zero(t, total, name)
+
+			assert.True(t, errors.Is(store.DeletePromptHistory(ctx, euID), ErrPromptHistoryNotFound), name)
+			assert.Contains(t, eu.entries, euID, name)
+		}
+
+		_, err := store.GetPromptHistory(as("berlin", "acme-eu"), usID)
+		assert.True(t, errors.Is(err, ErrPromptHistoryNotFound), "%v", err)
+	})
+
+	t.Run("lists and deletes within the region", func(t *testing.T) {
+		entries, total, err := store.GetUserPromptHistoryWithFilters(as("paris", "acme-eu"), "berlin", models.PaginationRequest{Page: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+		assert.EqualValues(t, 1, total)
+
+		require.NoError(t, store.DeletePromptHistory(as("berlin", "acme-eu"), euID))
+		assert.NotContains(t, eu.entries, euID)
+	})
+
+	t.Run("never falls back to another region", func(t *testing.T) {
+		delete(residency.regional, ResidencyEU)
+		_, err := store.SavePromptHistory(ctx, models.PromptHistory{UserID: sql.NullString{String: "berlin", Valid: true}})
+		assert.True(t, errors.Is(err, ErrResidencyUnavailable), "%v", err)
+		assert.Len(t, us.entries, 1)
+	})
+}
+
+func TestResidencySetRegion(t *testing.T) {
+	ctx := context.Background()
+	residency := newTestResidency(t, nil, nil)
+	history := &memoryHistory{entries: map[string]models.PromptHistory{}}
+
+	_, err := residency.SetRegion(ctx, "acme", "apac", "", history)
+	assert.True(t, errors.Is(err, ErrInvalidResidency), "%v", err)
+	_, err = residency.SetRegion(ctx, "acme", ResidencyEU, "", history)
+	assert.True(t, errors.Is(err, ErrResidencyUnavailable), "%v", err)
+
+	residency.regional[ResidencyEU] = &memoryHistory{entries: map[string]models.PromptHistory{}}
+	assert.Equal(t, []string{ResidencyUS, ResidencyEU}, residency.AvailableRegions())
+	_, err = residency.SetRegion(ctx, "acme", ResidencyEU, "", history)
+	require.NoError(t, err)
+}
+
+func TestLoadResidencyConfig(t *testing.T) {
+	t.Setenv("DATA_RESIDENCY_DEFAULT_REGION", "EU")
+	t.Setenv("DATA_RESIDENCY_DATABASE_URLS", "eu=postgres://primary, us=postgres://us-east")
+	config, err := LoadResidencyConfig()
+	require.NoError(t, err)
+	assert.Equal(t, ResidencyEU, config.DefaultRegion)
+	assert.Equal(t, map[string]string{ResidencyUS: "postgres://us-east"}, config.DatabaseURLs)
+
+	t.Setenv("DATA_RESIDENCY_DATABASE_URLS", "apac=postgres://sydney")
+	_, err = LoadResidencyConfig()
+	assert.True(t, errors.Is(err, ErrInvalidResidency), "%v", err)
+}
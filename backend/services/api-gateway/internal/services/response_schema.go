@@ -0,0 +1,353 @@
+package services
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// responseSchemaFiles are copies of the response schemas published in
+// tests/contracts/schemas, one directory per service
+//
+//go:embed schemas/*/*.json
+var responseSchemaFiles embed.FS
+
+// Embedded schemas of the responses validated in strict mode
+const (
+	selectionResponseSchema  = "technique-selector/select-response.v1.json"
+	generationResponseSchema = "prompt-generator/generate-response.v1.json"
+)
+
+// intentResponseSchema names the schema of a classification response of
+// the given version
+func intentResponseSchema(version int) string {
+	return "intent-classifier/classify-response.v" + strconv.Itoa(version) + ".json"
+}
+
+var downstreamSchemaViolations = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "downstream_schema_violations_total",
+		Help: "Downstream responses rejected in strict mode for not matching their schema, by service",
+	},
+	[]string{"service"},
+)
+
+// ResponseSchemas validates downstream responses against the JSON Schemas
+// embedded in the binary. Clients holding one reject responses that don't
+// match instead of decoding whatever they can of them, so a service that
+// drifts from its contract fails loudly rather than feeding zero values to
+// the pipeline.
+type ResponseSchemas struct {
+	schemas map[string]*jsonSchema
+}
+
+// NewResponseSchemas compiles the embedded schemas
+func NewResponseSchemas() (*ResponseSchemas, error) {
+	s := &ResponseSchemas{schemas: make(map[string]*jsonSchema)}
+	err := fs.WalkDir(responseSchemaFiles, "schemas", func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := responseSchemaFiles.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		schema, err := compileJSONSchema(data)
+		if err != nil {
+			return fmt.Errorf("schema %s: %w", path, err)
+		}
+		s.schemas[strings.TrimPrefix(path, "schemas/")] = schema
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for version := range intentSchemaRequired {
+		if _, ok := s.schemas[intentResponseSchema(version)]; !ok {
+			return nil, fmt.Errorf("no schema for intent classifier responses of version %d", version)
+		}
+	}
+	return s, nil
+}
+
+// Validate checks a response body of service against the named schema. A
+// mismatch is returned as a DownstreamError of kind
+// ErrDownstreamInvalidResponse and counted. A nil ResponseSchemas accepts
+// everything.
+func (s *ResponseSchemas) Validate(service, name string, body []byte) error {
+	if s == nil {
+		return nil
+	}
+	err := s.validate(name, body)
+	if err == nil {
+		return nil
+	}
+	downstreamSchemaViolations.WithLabelValues(service).Inc()
+	return &DownstreamError{
+		Service: service,
+		Kind:    ErrDownstreamInvalidResponse,
+		Body:    string(body),
+		Err:     err,
+	}
+}
+
+func (s *ResponseSchemas) validate(name string, body []byte) error {
+	schema, ok := s.schemas[name]
+	if !ok {
+		return fmt.Errorf("no schema %s", name)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("response is not JSON: %w", err)
+	}
+	return schema.validate("$", value)
+}
+
+// jsonSchema is a compiled JSON Schema. The subset of draft-07 the
+// published schemas use is supported; compiling a schema using any other
+// validation keyword fails rather than silently skipping it.
+type jsonSchema struct {
+	types                []string
+	required             []string
+	properties           map[string]*jsonSchema
+	additionalProperties *jsonSchema // Schema of properties not listed in properties
+	closed               bool        // additionalProperties is false
+	items                *jsonSchema
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	minimum, maximum     *float64
+	minItems, minLength  int
+}
+
+// annotationKeywords don't constrain values
+var annotationKeywords = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true,
+	"description": true, "examples": true, "default": true, "definitions": true,
+}
+
+func compileJSONSchema(data []byte) (*jsonSchema, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	definitions, _ := raw["definitions"].(map[string]interface{})
+	return compileSchemaNode(raw, definitions)
+}
+
+func compileSchemaNode(raw map[string]interface{}, definitions map[string]interface{}) (*jsonSchema, error) {
+	if ref, ok := raw["$ref"].(string); ok {
+		target, ok := definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		if !ok || !strings.HasPrefix(ref, "#/definitions/") {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		return compileSchemaNode(target, definitions)
+	}
+
+	schema := &jsonSchema{}
+	child := func(keyword string, value interface{}) (*jsonSchema, error) {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a schema", keyword)
+		}
+		return compileSchemaNode(node, definitions)
+	}
+	for keyword, value := range raw {
+		var err error
+		switch keyword {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				schema.types = []string{t}
+			case []interface{}:
+				for _, v := range t {
+					name, _ := v.(string)
+					schema.types = append(schema.types, name)
+				}
+			}
+		case "required":
+			list, _ := value.([]interface{})
+			for _, v := range list {
+				name, _ := v.(string)
+				schema.required = append(schema.required, name)
+			}
+		case "properties":
+			props, _ := value.(map[string]interface{})
+			schema.properties = make(map[string]*jsonSchema, len(props))
+			for name, prop := range props {
+				if schema.properties[name], err = child("properties."+name, prop); err != nil {
+					return nil, err
+				}
+			}
+		case "additionalProperties":
+			if allowed, ok := value.(bool); ok {
+				schema.closed = !allowed
+			} else {
+				schema.additionalProperties, err = child(keyword, value)
+			}
+		case "items":
+			schema.items, err = child(keyword, value)
+		case "enum":
+			schema.enum, _ = value.([]interface{})
+		case "const":
+			schema.constant, schema.hasConst = value, true
+		case "minimum", "maximum":
+			bound, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a number", keyword)
+			}
+			if keyword == "minimum" {
+				schema.minimum = &bound
+			} else {
+				schema.maximum = &bound
+			}
+		case "minItems", "minLength":
+			bound, _ := value.(float64)
+			if keyword == "minItems" {
+				schema.minItems = int(bound)
+			} else {
+				schema.minLength = int(bound)
+			}
+		default:
+			if !annotationKeywords[keyword] {
+				return nil, fmt.Errorf("unsupported keyword %q", keyword)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+// validate checks value, found at path, returning the first violation
+func (s *jsonSchema) validate(path string, value interface{}) error {
+	if len(s.types) > 0 && !hasJSONType(s.types, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(s.types, " or "), jsonTypeOf(value))
+	}
+	if s.hasConst && !jsonEqual(value, s.constant) {
+		return fmt.Errorf("%s: must be %v", path, s.constant)
+	}
+	if len(s.enum) > 0 {
+		found := false
+		for _, allowed := range s.enum {
+			if jsonEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of the allowed values", path, value)
+		}
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		n, _ := v.Float64()
+		if s.minimum != nil && n < *s.minimum {
+			return fmt.Errorf("%s: %v is below the minimum of %v", path, v, *s.minimum)
+		}
+		if s.maximum != nil && n > *s.maximum {
+			return fmt.Errorf("%s: %v is above the maximum of %v", path, v, *s.maximum)
+		}
+	case string:
+		if len([]rune(v)) < s.minLength {
+			return fmt.Errorf("%s: shorter than %d characters", path, s.minLength)
+		}
+	case []interface{}:
+		if len(v) < s.minItems {
+			return fmt.Errorf("%s: fewer than %d items", path, s.minItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(path+"["+strconv.Itoa(i)+"]", item); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required field %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, listed := s.properties[name]
+			switch {
+			case listed:
+			case s.closed:
+				return fmt.Errorf("%s: unexpected field %q", path, name)
+			case s.additionalProperties != nil:
+				prop = s.additionalProperties
+			default:
+				continue
+			}
+			if err := prop.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonTypeOf returns the JSON Schema type of a value decoded with UseNumber
+func jsonTypeOf(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// hasJSONType reports whether value is of one of types, integers
+// counting as numbers
+func hasJSONType(types []string, value interface{}) bool {
+	actual := jsonTypeOf(value)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual compares a decoded response value with one from a schema,
+// numbers by value
+func jsonEqual(value, expected interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, err := n.Float64()
+		e, isNumber := expected.(float64)
+		return err == nil && isNumber && f == e
+	}
+	return reflect.DeepEqual(value, expected)
+}
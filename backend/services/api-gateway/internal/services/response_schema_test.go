@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// strictSchemas returns the embedded response schemas
+func strictSchemas(t *testing.T) *ResponseSchemas {
+	t.Helper()
+	schemas, err := NewResponseSchemas()
+	require.NoError(t, err)
+	return schemas
+}
+
+func TestEmbeddedSchemasArePublished(t *testing.T) {
+	published := filepath.Join(filepath.Dir(pactDir()), "schemas")
+	embedded, err := filepath.Glob("schemas/*/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, embedded)
+
+	for _, path := range embedded {
+		name := strings.TrimPrefix(filepath.ToSlash(path), "schemas/")
+		want, err := os.ReadFile(filepath.Join(published, name))
+		require.NoError(t, err, "%s is embedded but not published", name)
+		got, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(got), "embedded %s differs from the published schema", name)
+	}
+}
+
+func TestResponseSchemas(t *testing.T) {
+	schemas := strictSchemas(t)
+
+	t.Run("published examples are valid", func(t *testing.T) {
+		for name := range schemas.schemas {
+			data, err := responseSchemaFiles.ReadFile("schemas/" + name)
+			require.NoError(t, err)
+			var schema struct {
+				Examples []json.RawMessage `json:"examples"`
+			}
+			require.NoError(t, json.Unmarshal(data, &schema))
+			require.NotEmpty(t, schema.Examples, name)
+			for _, example := range schema.Examples {
+				assert.NoError(t, schemas.validate(name, example), "%s example %s", name, example)
+			}
+		}
+	})
+
+	valid := map[string]string{
+		intentResponseSchema(1):  `{"intent":"analysis","confidence":0.7,"complexity":"simple","suggested_techniques":[],"intent_scores":{"analysis":0.7}}`,
+		selectionResponseSchema:  `{"techniques":[{"id":"few_shot","name":"Few-shot","description":"","priority":3,"score":1,"confidence":0.5,"reasoning":"","template":"x"}],"primary_technique":"few_shot","confidence":0.5,"reasoning":"","metadata":null}`,
+		generationResponseSchema: `{"text":"enhanced","tokens_used":0,"model_version":"1.0.0","extra":true}`,
+	}
+	for name, body := range valid {
+		assert.NoError(t, schemas.validate(name, []byte(body)), name)
+	}
+
+	failures := map[string]struct {
+		schema string
+		body   string
+		want   string
+	}{
+		"not JSON":           {generationResponseSchema, `text`, "not JSON"},
+		"not an object":      {generationResponseSchema, `["enhanced"]`, "$: expected object, got array"},
+		"missing field":      {generationResponseSchema, `{"text":"enhanced","model_version":"1.0.0"}`, `missing required field "tokens_used"`},
+		"retyped field":      {generationResponseSchema, `{"text":"enhanced","tokens_used":"42","model_version":"1.0.0"}`, "$.tokens_used: expected integer, got string"},
+		"fraction":           {generationResponseSchema, `{"text":"enhanced","tokens_used":4.2,"model_version":"1.0.0"}`, "$.tokens_used: expected integer, got number"},
+		"empty text":         {generationResponseSchema, `{"text":"","tokens_used":1,"model_version":"1.0.0"}`, "$.text: shorter than 1"},
+		"out of range":       {intentResponseSchema(1), `{"intent":"analysis","confidence":1.5,"complexity":"simple","suggested_techniques":[]}`, "$.confidence: 1.5 is above the maximum"},
+		"unknown enum value": {intentResponseSchema(1), `{"intent":"analysis","confidence":0.5,"complexity":"huge","suggested_techniques":[]}`, "$.complexity: huge is not one of"},
+		"wrong const":        {intentResponseSchema(1), `{"intent":"analysis","confidence":0.5,"complexity":"simple","suggested_techniques":[],"schema_version":2}`, "$.schema_version: must be 1"},
+		"additional schema":  {intentResponseSchema(1), `{"intent":"analysis","confidence":0.5,"complexity":"simple","suggested_techniques":[],"intent_scores":{"qa":2}}`, "$.intent_scores.qa: 2 is above"},
+		"no techniques":      {selectionResponseSchema, `{"techniques":[],"primary_technique":"few_shot","confidence":0.5,"reasoning":""}`, "$.techniques: fewer than 1 items"},
+		"unknown technique":  {selectionResponseSchema, `{"techniques":[{"id":"magic","name":"Magic","description":"","priority":1,"score":1,"confidence":1,"reasoning":""}],"primary_technique":"few_shot","confidence":0.5,"reasoning":""}`, "$.techniques[0].id: magic is not one of"},
+		"unknown schema":     {intentResponseSchema(99), `{}`, "no schema"},
+	}
+	for name, tc := range failures {
+		t.Run(name, func(t *testing.T) {
+			err := schemas.validate(tc.schema, []byte(tc.body))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.want)
+		})
+	}
+
+	t.Run("unsupported keywords don't compile", func(t *testing.T) {
+		_, err := compileJSONSchema([]byte(`{"type":"object","properties":{"id":{"type":"string","pattern":"^[a-z]+$"}}}`))
+		assert.ErrorContains(t, err, `unsupported keyword "pattern"`)
+		_, err = compileJSONSchema([]byte(`{"$ref":"#/definitions/missing"}`))
+		assert.ErrorContains(t, err, "unresolvable $ref")
+	})
+}
+
+func TestStrictClients(t *testing.T) {
+	serve := func(body string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	ctx := context.Background()
+	schemas := strictSchemas(t)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	t.Run("classifications", func(t *testing.T) {
+		// Lenient decoding fills in what strict mode refuses
+		server := serve(`{"intent":"reasoning","confidence":0.8}`)
+		lenient := &IntentClassifierClient{baseURL: server.URL, client: server.Client()}
+		_, err := lenient.ClassifyIntent(ctx, "why")
+		require.NoError(t, err)
+
+		before := testutil.ToFloat64(downstreamSchemaViolations.WithLabelValues("intent classifier"))
+		strict := &IntentClassifierClient{baseURL: server.URL, client: server.Client(), schemas: schemas}
+		_, err = strict.ClassifyIntent(ctx, "why")
+		assert.ErrorIs(t, err, ErrDownstreamInvalidResponse)
+		assert.False(t, IsRetriable(err))
+		assert.Contains(t, err.Error(), `intent classifier: $: missing required field "complexity"`)
+		assert.Equal(t, before+1, testutil.ToFloat64(downstreamSchemaViolations.WithLabelValues("intent classifier")))
+
+		server = serve(`{"intent":"reasoning","confidence":0.8,"complexity":"simple","suggested_techniques":[],"schema_version":7}`)
+		strict = &IntentClassifierClient{baseURL: server.URL, client: server.Client(), schemas: schemas}
+		_, err = strict.ClassifyIntent(ctx, "why")
+		assert.ErrorIs(t, err, ErrDownstreamInvalidResponse, "versions without an embedded schema are refused")
+	})
+
+	t.Run("selections", func(t *testing.T) {
+		server := serve(`{"techniques":[{"id":"few_shot","name":"Few-shot"}],"primary_technique":"few_shot","confidence":0.5,"reasoning":""}`)
+		client := &TechniqueSelectorClient{baseURL: server.URL, client: server.Client(), logger: logger, schemas: schemas}
+		_, err := client.SelectTechniques(ctx, models.TechniqueSelectionRequest{Text: "why"})
+		assert.ErrorIs(t, err, ErrDownstreamInvalidResponse)
+	})
+
+	t.Run("generations", func(t *testing.T) {
+		server := serve(`{"text":"enhanced","tokens_used":12,"model_version":"1.0.0"}`)
+		client := &PromptGeneratorClient{baseURL: server.URL, client: server.Client(), schemas: schemas}
+		result, err := client.GeneratePrompt(ctx, models.PromptGenerationRequest{Text: "why"})
+		require.NoError(t, err)
+		assert.Equal(t, 12, result.TokensUsed)
+
+		server = serve(`{"text":"enhanced"}`)
+		client = &PromptGeneratorClient{baseURL: server.URL, client: server.Client(), schemas: schemas}
+		_, err = client.GeneratePrompt(ctx, models.PromptGenerationRequest{Text: "why"})
+		assert.ErrorIs(t, err, ErrDownstreamInvalidResponse)
+	})
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/lock"
+	"github.com/sirupsen/logrus"
+)
+
+// ScheduledJob is a periodic background task
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Timeout  time.Duration // Defaults to the interval
+	Run      func(ctx context.Context) error
+}
+
+// JobScheduler runs periodic jobs. With Redis available each run takes a
+// lock held for the job's interval, so a job runs once per interval across
+// all gateway instances rather than once per instance.
+type JobScheduler struct {
+	cache  *CacheService
+	locks  *lock.Locker // Nil without Redis
+	logger *logrus.Logger
+
+	mu   sync.Mutex
+	jobs []ScheduledJob
+}
+
+// NewJobScheduler creates a scheduler. cache may be nil, in which case
+// every instance runs every job.
+func NewJobScheduler(cache *CacheService, logger *logrus.Logger) *JobScheduler {
+	s := &JobScheduler{
+		cache:  cache,
+		logger: logger,
+	}
+	if cache != nil {
+		s.locks = lock.New(cache.client)
+	}
+	return s
+}
+
+// Register adds a job. Jobs with a zero interval are disabled and skipped.
+func (s *JobScheduler) Register(job ScheduledJob) {
+	if job.Interval <= 0 {
+		s.logger.WithField("job", job.Name).Info("Scheduled job disabled")
+		return
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = job.Interval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs = append(s.jobs, job)
+}
+
+// Start runs each registered job now and then every interval until ctx is
+// done
+func (s *JobScheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		go s.loop(ctx, job)
+	}
+}
+
+func (s *JobScheduler) loop(ctx context.Context, job ScheduledJob) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce runs job unless another instance already ran it this interval
+func (s *JobScheduler) runOnce(ctx context.Context, job ScheduledJob) {
+	logger := s.logger.WithField("job", job.Name)
+
+	if s.locks != nil {
+		// The lock is neither renewed nor released but left to expire,
+		// which is what spaces runs an interval apart across instances.
+		// Slightly shorter than the interval so this instance's next tick
+		// isn't skipped.
+		lockTTL := job.Interval - job.Interval/10
+		_, err := s.locks.TryAcquire(ctx, s.cache.Key("scheduler", job.Name), lock.Options{TTL: lockTTL, RenewInterval: -1})
+		if errors.Is(err, lock.ErrNotAcquired) {
+			logger.Debug("Scheduled job already ran on another instance")
+			return
+		} else if err != nil {
+			logger.WithError(err).Warn("Failed to take scheduler lock, running anyway")
+		}
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, job.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := job.Run(runCtx); err != nil {
+		logger.WithError(err).Error("Scheduled job failed")
+		return
+	}
+	logger.WithField("duration_ms", time.Since(start).Milliseconds()).Debug("Scheduled job complete")
+}
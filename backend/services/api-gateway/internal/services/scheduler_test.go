@@ -0,0 +1,47 @@
+package services
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobSchedulerRunsRegisteredJobs(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	scheduler := NewJobScheduler(nil, logger)
+
+	ran := make(chan struct{}, 10)
+	scheduler.Register(ScheduledJob{
+		Name:     "counter",
+		Interval: 10 * time.Millisecond,
+		Run: func(ctx context.Context) error {
+			ran <- struct{}{}
+			return nil
+		},
+	})
+	scheduler.Register(ScheduledJob{
+		Name: "disabled",
+		Run: func(ctx context.Context) error {
+			t.Error("disabled job ran")
+			return nil
+		},
+	})
+	assert.Len(t, scheduler.jobs, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.Start(ctx)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("job did not run")
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/auth"
+	"github.com/sirupsen/logrus"
+)
+
+// SCIM 2.0 schema URNs (RFC 7643, RFC 7644)
+const (
+	SCIMUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMPatchSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// SCIMTokenPrefix marks SCIM bearer tokens, like APIKeyPrefix does API keys
+const SCIMTokenPrefix = "scim_"
+
+// Page sizes for SCIM list requests
+const (
+	DefaultSCIMPageSize = 100
+	MaxSCIMPageSize     = 200
+)
+
+var (
+	// ErrSCIMTokenNotFound is returned when an organization has no such
+	// active token
+	ErrSCIMTokenNotFound = errors.New("scim token not found")
+	// ErrInvalidSCIMToken is returned for unknown and revoked tokens
+	ErrInvalidSCIMToken = errors.New("invalid scim token")
+)
+
+// SCIMError is a SCIM protocol error, answered with its status and, for
+// 400s and 409s, a scimType keyword
+type SCIMError struct {
+	Status   int
+	SCIMType string
+	Detail   string
+}
+
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+func scimNotFound(resource string) *SCIMError {
+	return &SCIMError{Status: http.StatusNotFound, Detail: resource + " not found"}
+}
+
+func scimInvalidValue(format string, args ...interface{}) *SCIMError {
+	return &SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidValue", Detail: fmt.Sprintf(format, args...)}
+}
+
+func scimUniqueness(format string, args ...interface{}) *SCIMError {
+	return &SCIMError{Status: http.StatusConflict, SCIMType: "uniqueness", Detail: fmt.Sprintf(format, args...)}
+}
+
+// SCIMMeta is a resource's metadata
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// SCIMName is a user's name
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMEmail is one of a user's email addresses. Only the primary one, or
+// else the first, is kept.
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMReference points at another resource, a group a user is in or a member
+// of a group
+type SCIMReference struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// SCIMUser is a user as a directory sees it
+type SCIMUser struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	UserName    string          `json:"userName"`
+	Name        *SCIMName       `json:"name,omitempty"`
+	DisplayName string          `json:"displayName,omitempty"`
+	Emails      []SCIMEmail     `json:"emails,omitempty"`
+	Active      *bool           `json:"active,omitempty"` // Defaults to true
+	Groups      []SCIMReference `json:"groups,omitempty"` // Read-only
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMGroup is a group of an organization's users
+type SCIMGroup struct {
+	Schemas     []string        `json:"schemas"`
+	ID          string          `json:"id,omitempty"`
+	ExternalID  string          `json:"externalId,omitempty"`
+	DisplayName string          `json:"displayName"`
+	Members     []SCIMReference `json:"members,omitempty"`
+	Meta        *SCIMMeta       `json:"meta,omitempty"`
+}
+
+// SCIMListResponse is a page of a list or filtered search
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMToken authenticates an organization's directory
+type SCIMToken struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	Name       string     `json:"name"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// SCIMService lets enterprise directories provision an organization's
+// users and groups. Provisioned users join the organization and sign in
+// through its single sign-on; deactivating one signs them out. Group
+// membership grants roles through the organization's SSO role mapping.
+type SCIMService struct {
+	db       *DatabaseService
+	cache    *CacheService    // Optional; deactivated users' refresh tokens outlive them when nil
+	accounts *AccountResolver // Optional; cached organizations expire on their own when nil
+	logger   *logrus.Logger
+}
+
+// NewSCIMService creates a new SCIM service
+func NewSCIMService(db *DatabaseService, cache *CacheService, accounts *AccountResolver, logger *logrus.Logger) *SCIMService {
+	return &SCIMService{
+		db:       db,
+		cache:    cache,
+		accounts: accounts,
+		logger:   logger,
+	}
+}
+
+// CreateToken issues a directory token for an organization. The raw token
+// is only returned here; only its hash is stored.
+func (s *SCIMService) CreateToken(ctx context.Context, orgID, name, createdBy string) (*SCIMToken, string, error) {
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+	rawToken := SCIMTokenPrefix + strings.TrimRight(secret, "=")
+
+	token := &SCIMToken{OrgID: orgID, Name: name, CreatedBy: createdBy}
+	err = s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO auth.scim_tokens (org_id, name, token_hash, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid)
+		RETURNING id, created_at`,
+		orgID, name, HashAPIKey(rawToken), createdBy).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create scim token: %w", err)
+	}
+	return token, rawToken, nil
+}
+
+// ListTokens returns an organization's active tokens, newest first
+func (s *SCIMService) ListTokens(ctx context.Context, orgID string) ([]*SCIMToken, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, org_id, name, COALESCE(created_by::text, ''), created_at, last_used_at
+		FROM auth.scim_tokens
+		WHERE org_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scim tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []*SCIMToken{}
+	for rows.Next() {
+		token := &SCIMToken{}
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&token.ID, &token.OrgID, &token.Name, &token.CreatedBy, &token.CreatedAt, &lastUsed); err != nil {
+			return nil, fmt.Errorf("failed to scan scim token: %w", err)
+		}
+		if lastUsed.Valid {
+			token.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeToken stops an organization's token from authenticating
+func (s *SCIMService) RevokeToken(ctx context.Context, orgID, id string) error {
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE auth.scim_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE org_id = $1 AND id = $2 AND revoked_at IS NULL`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke scim token: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrSCIMTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a raw token to the organization it was issued for,
+// recording its use
+func (s *SCIMService) Authenticate(ctx context.Context, rawToken string) (*SCIMToken, error) {
+	if !strings.HasPrefix(rawToken, SCIMTokenPrefix) {
+		return nil, ErrInvalidSCIMToken
+	}
+
+	token := &SCIMToken{}
+	var lastUsed sql.NullTime
+	err := s.db.DB.QueryRowContext(ctx, `
+		UPDATE auth.scim_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND revoked_at IS NULL
+		RETURNING id, org_id, name, COALESCE(created_by::text, ''), created_at, last_used_at`,
+		HashAPIKey(rawToken)).Scan(&token.ID, &token.OrgID, &token.Name, &token.CreatedBy, &token.CreatedAt, &lastUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidSCIMToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate scim token: %w", err)
+	}
+	if lastUsed.Valid {
+		token.LastUsedAt = &lastUsed.Time
+	}
+	return token, nil
+}
+
+// signOut revokes a deactivated user's refresh tokens and forgets their
+// cached account. Their current access token runs out on its own.
+func (s *SCIMService) signOut(ctx context.Context, userID string) {
+	if s.accounts != nil {
+		s.accounts.Forget(userID)
+	}
+	if s.cache == nil {
+		return
+	}
+	if _, err := s.cache.DeleteSessionsWithPrefix(ctx, s.cache.Key("refresh_token", userID)); err != nil {
+		s.logger.WithError(err).WithField("user_id", userID).Warn("Failed to revoke refresh tokens of deactivated user")
+	}
+}
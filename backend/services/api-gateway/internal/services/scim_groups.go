@@ -0,0 +1,415 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const scimGroupColumns = `g.id, g.display_name, COALESCE(g.external_id, ''), g.created_at, g.updated_at`
+
+func scanSCIMGroup(row interface{ Scan(...interface{}) error }) (*SCIMGroup, error) {
+	group := &SCIMGroup{Schemas: []string{SCIMGroupSchema}, Meta: &SCIMMeta{ResourceType: "Group"}}
+	err := row.Scan(&group.ID, &group.DisplayName, &group.ExternalID, &group.Meta.Created, &group.Meta.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// ListGroups returns a page of the organization's groups, matching an
+// optional filter on displayName, externalId or id. Directories that only
+// need the groups themselves pass withMembers false.
+func (s *SCIMService) ListGroups(ctx context.Context, orgID, filter string, startIndex, count int, withMembers bool) (*SCIMListResponse, error) {
+	f, err := parseSCIMFilter(filter, "displayname", "externalid", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	where := `g.org_id = $1`
+	args := []interface{}{orgID}
+	if f != nil {
+		switch f.Attribute {
+		case "displayname":
+			where += ` AND LOWER(g.display_name) = LOWER($2)`
+		case "externalid":
+			where += ` AND g.external_id = $2`
+		case "id":
+			where += ` AND g.id::text = LOWER($2)`
+		}
+		args = append(args, f.Value)
+	}
+	from := ` FROM auth.scim_groups g WHERE ` + where
+
+	list := &SCIMListResponse{Schemas: []string{SCIMListSchema}, StartIndex: startIndex, Resources: []*SCIMGroup{}}
+	if err := s.db.DB.QueryRowContext(ctx, `SELECT COUNT(*)`+from, args...).Scan(&list.TotalResults); err != nil {
+		return nil, fmt.Errorf("failed to count scim groups: %w", err)
+	}
+	if count == 0 || list.TotalResults < startIndex {
+		return list, nil
+	}
+
+	page := ` LIMIT $2 OFFSET $3`
+	if f != nil {
+		page = ` LIMIT $3 OFFSET $4`
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `SELECT `+scimGroupColumns+from+` ORDER BY g.display_name, g.id`+page,
+		append(args, count, startIndex-1)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scim groups: %w", err)
+	}
+	defer rows.Close()
+	groups := []*SCIMGroup{}
+	for rows.Next() {
+		group, err := scanSCIMGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scim group: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate scim groups: %w", err)
+	}
+
+	if withMembers {
+		if err := s.loadMembers(ctx, orgID, groups); err != nil {
+			return nil, err
+		}
+	}
+	list.Resources = groups
+	list.ItemsPerPage = len(groups)
+	return list, nil
+}
+
+// GetGroup returns one of the organization's groups with its members
+func (s *SCIMService) GetGroup(ctx context.Context, orgID, id string) (*SCIMGroup, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, scimNotFound("Group")
+	}
+	group, err := scanSCIMGroup(s.db.DB.QueryRowContext(ctx,
+		`SELECT `+scimGroupColumns+` FROM auth.scim_groups g WHERE g.org_id = $1 AND g.id = $2`, orgID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, scimNotFound("Group")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scim group: %w", err)
+	}
+	if err := s.loadMembers(ctx, orgID, []*SCIMGroup{group}); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// loadMembers fills in each group's members
+func (s *SCIMService) loadMembers(ctx context.Context, orgID string, groups []*SCIMGroup) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	byID := make(map[string]*SCIMGroup, len(groups))
+	ids := make([]string, len(groups))
+	for i, group := range groups {
+		group.Members = []SCIMReference{}
+		byID[group.ID] = group
+		ids[i] = group.ID
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT m.group_id, su.user_id, su.user_name
+		FROM auth.scim_group_members m
+		JOIN auth.scim_users su ON su.user_id = m.user_id AND su.org_id = $1
+		WHERE m.group_id = ANY($2::uuid[])
+		ORDER BY su.user_name`, orgID, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get scim group members: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var groupID string
+		var member SCIMReference
+		if err := rows.Scan(&groupID, &member.Value, &member.Display); err != nil {
+			return fmt.Errorf("failed to scan scim group member: %w", err)
+		}
+		if group, ok := byID[groupID]; ok {
+			group.Members = append(group.Members, member)
+		}
+	}
+	return rows.Err()
+}
+
+// CreateGroup creates a group of the organization's provisioned users
+func (s *SCIMService) CreateGroup(ctx context.Context, orgID string, in *SCIMGroup) (*SCIMGroup, error) {
+	displayName := strings.TrimSpace(in.DisplayName)
+	if displayName == "" {
+		return nil, scimInvalidValue("displayName is required")
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO auth.scim_groups (org_id, display_name, external_id)
+		VALUES ($1, $2, NULLIF($3, ''))
+		RETURNING id`, orgID, displayName, strings.TrimSpace(in.ExternalID)).Scan(&id)
+	if err != nil {
+		return nil, scimGroupWriteError(err, displayName)
+	}
+	members, err := setSCIMGroupMembers(ctx, tx, orgID, id, in.Members)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncSCIMRoles(ctx, tx, orgID, members); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scim group: %w", err)
+	}
+	s.logger.WithFields(logrus.Fields{"group_id": id, "org_id": orgID, "members": len(members)}).Info("Group provisioned through SCIM")
+	return s.GetGroup(ctx, orgID, id)
+}
+
+// ReplaceGroup overwrites a group's name and members, updating the roles of
+// everyone who joined or left it
+func (s *SCIMService) ReplaceGroup(ctx context.Context, orgID, id string, in *SCIMGroup) (*SCIMGroup, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, scimNotFound("Group")
+	}
+	displayName := strings.TrimSpace(in.DisplayName)
+	if displayName == "" {
+		return nil, scimInvalidValue("displayName is required")
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE auth.scim_groups SET display_name = $3, external_id = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE org_id = $1 AND id = $2`, orgID, id, displayName, strings.TrimSpace(in.ExternalID))
+	if err != nil {
+		return nil, scimGroupWriteError(err, displayName)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to get affected rows: %w", err)
+	} else if rowsAffected == 0 {
+		return nil, scimNotFound("Group")
+	}
+
+	former, err := scimGroupMemberIDs(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := setSCIMGroupMembers(ctx, tx, orgID, id, in.Members)
+	if err != nil {
+		return nil, err
+	}
+	if err := syncSCIMRoles(ctx, tx, orgID, append(former, members...)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scim group: %w", err)
+	}
+	return s.GetGroup(ctx, orgID, id)
+}
+
+// PatchGroup applies PATCH operations to a group
+func (s *SCIMService) PatchGroup(ctx context.Context, orgID, id string, ops []SCIMPatchOperation) (*SCIMGroup, error) {
+	group, err := s.GetGroup(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySCIMGroupPatch(group, ops); err != nil {
+		return nil, err
+	}
+	return s.ReplaceGroup(ctx, orgID, id, group)
+}
+
+// DeleteGroup removes a group, updating its former members' roles
+func (s *SCIMService) DeleteGroup(ctx context.Context, orgID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return scimNotFound("Group")
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	former, err := scimGroupMemberIDs(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM auth.scim_groups WHERE org_id = $1 AND id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete scim group: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	} else if rowsAffected == 0 {
+		return scimNotFound("Group")
+	}
+	if err := syncSCIMRoles(ctx, tx, orgID, former); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit scim group deletion: %w", err)
+	}
+	return nil
+}
+
+func scimGroupWriteError(err error, displayName string) error {
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return scimUniqueness("a group named %q already exists", displayName)
+	}
+	return fmt.Errorf("failed to save scim group: %w", err)
+}
+
+func scimGroupMemberIDs(ctx context.Context, tx *sql.Tx, groupID string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id FROM auth.scim_group_members WHERE group_id = $1`, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scim group members: %w", err)
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan scim group member: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setSCIMGroupMembers replaces a group's members, which must all be users
+// the organization's directory provisioned, returning their IDs
+func setSCIMGroupMembers(ctx context.Context, tx *sql.Tx, orgID, groupID string, members []SCIMReference) ([]string, error) {
+	seen := make(map[string]bool, len(members))
+	ids := make([]string, 0, len(members))
+	for _, member := range members {
+		id := strings.ToLower(strings.TrimSpace(member.Value))
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if len(ids) > 0 {
+		rows, err := tx.QueryContext(ctx, `
+			SELECT user_id::text FROM auth.scim_users WHERE org_id = $1 AND user_id::text = ANY($2)`,
+			orgID, pq.Array(ids))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check scim group members: %w", err)
+		}
+		known := make(map[string]bool, len(ids))
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan scim group member: %w", err)
+			}
+			known[id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to check scim group members: %w", err)
+		}
+		for _, id := range ids {
+			if !known[id] {
+				return nil, scimInvalidValue("member %q is not a provisioned user", id)
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.scim_group_members WHERE group_id = $1`, groupID); err != nil {
+		return nil, fmt.Errorf("failed to replace scim group members: %w", err)
+	}
+	if len(ids) > 0 {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO auth.scim_group_members (group_id, user_id)
+			SELECT $1, unnest($2::uuid[])`, groupID, pq.Array(ids))
+		if err != nil {
+			return nil, fmt.Errorf("failed to add scim group members: %w", err)
+		}
+	}
+	return ids, nil
+}
+
+// syncSCIMRoles sets the roles of the organization's provisioned users to
+// the ones their groups map to through the organization's single sign-on
+// role mapping. Without a connection, roles are left as they are.
+func syncSCIMRoles(ctx context.Context, tx *sql.Tx, orgID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	conn := &SSOConnection{}
+	var mappingJSON []byte
+	err := tx.QueryRowContext(ctx,
+		`SELECT role_mapping, default_role FROM auth.sso_connections WHERE org_id = $1`, orgID).Scan(&mappingJSON, &conn.DefaultRole)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get sso role mapping: %w", err)
+	}
+	if err := json.Unmarshal(mappingJSON, &conn.RoleMapping); err != nil {
+		return fmt.Errorf("failed to parse sso role mapping: %w", err)
+	}
+
+	groups := make(map[string][]string, len(userIDs))
+	for _, id := range userIDs {
+		groups[id] = nil
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT m.user_id, g.display_name
+		FROM auth.scim_group_members m JOIN auth.scim_groups g ON g.id = m.group_id
+		WHERE g.org_id = $1 AND m.user_id = ANY($2::uuid[])`, orgID, pq.Array(userIDs))
+	if err != nil {
+		return fmt.Errorf("failed to get scim user groups: %w", err)
+	}
+	for rows.Next() {
+		var id, group string
+		if err := rows.Scan(&id, &group); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan scim user group: %w", err)
+		}
+		groups[id] = append(groups[id], group)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get scim user groups: %w", err)
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		_, err := tx.ExecContext(ctx, `
+			UPDATE auth.users SET roles = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND id IN (SELECT user_id FROM auth.scim_users WHERE org_id = $3)`,
+			id, pq.Array(conn.roles(groups[id])), orgID)
+		if err != nil {
+			return fmt.Errorf("failed to update scim user roles: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SCIMPatchRequest is a PATCH request's body
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is one change of a PATCH request. Without a path,
+// value is an object of attributes to change.
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// scimFilter is an `attribute eq "value"` filter, the only kind directories
+// send when looking up what they provisioned
+type scimFilter struct {
+	Attribute string // Lowercased
+	Value     string
+}
+
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9_.]*)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseSCIMFilter parses a ?filter= on one of the allowed attributes, given
+// lowercased. An empty filter matches everything and returns nil.
+func parseSCIMFilter(filter string, allowed ...string) (*scimFilter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	invalid := &SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidFilter",
+		Detail: `only filters of the form 'attribute eq "value"' are supported`}
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, invalid
+	}
+	value, err := strconv.Unquote(match[2])
+	if err != nil {
+		return nil, invalid
+	}
+	attribute := strings.ToLower(match[1])
+	for _, a := range allowed {
+		if a == attribute {
+			return &scimFilter{Attribute: attribute, Value: value}, nil
+		}
+	}
+	invalid.Detail = "filtering on " + match[1] + " is not supported"
+	return nil, invalid
+}
+
+func scimInvalidSyntax(detail string) *SCIMError {
+	return &SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidSyntax", Detail: detail}
+}
+
+// scimPatch calls apply for every attribute an operation changes, splitting
+// path-less operations into their attributes. Paths are lowercased, without
+// the core schema's URN prefix.
+func scimPatch(ops []SCIMPatchOperation, schema string, apply func(op, path string, value json.RawMessage) error) error {
+	if len(ops) == 0 {
+		return scimInvalidSyntax("Operations is required")
+	}
+	prefix := strings.ToLower(schema) + ":"
+	for _, operation := range ops {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return scimInvalidSyntax("unsupported op " + strconv.Quote(operation.Op))
+		}
+		if operation.Path != "" {
+			path := strings.TrimPrefix(strings.ToLower(operation.Path), prefix)
+			if err := apply(op, path, operation.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var attributes map[string]json.RawMessage
+		if op == "remove" || json.Unmarshal(operation.Value, &attributes) != nil {
+			return scimInvalidSyntax("an operation without a path needs an object value")
+		}
+		for name, value := range attributes {
+			path := strings.TrimPrefix(strings.ToLower(name), prefix)
+			if err := apply(op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applySCIMUserPatch applies PATCH operations to a user. Attributes the
+// gateway doesn't store are ignored, as they are when creating a user.
+func applySCIMUserPatch(user *SCIMUser, ops []SCIMPatchOperation) error {
+	return scimPatch(ops, SCIMUserSchema, func(op, path string, value json.RawMessage) error {
+		if user.Name == nil {
+			user.Name = &SCIMName{}
+		}
+		switch {
+		case path == "active":
+			if op == "remove" {
+				return scimInvalidValue("active can't be removed")
+			}
+			active, err := scimBool(value)
+			if err != nil {
+				return err
+			}
+			user.Active = &active
+		case path == "username":
+			if op == "remove" {
+				return scimInvalidValue("userName can't be removed")
+			}
+			return scimSetString(&user.UserName, op, value)
+		case path == "externalid":
+			return scimSetString(&user.ExternalID, op, value)
+		case path == "displayname":
+			return scimSetString(&user.DisplayName, op, value)
+		case path == "name.givenname":
+			return scimSetString(&user.Name.GivenName, op, value)
+		case path == "name.familyname":
+			return scimSetString(&user.Name.FamilyName, op, value)
+		case path == "name.formatted":
+			return scimSetString(&user.Name.Formatted, op, value)
+		case path == "name":
+			if op == "remove" {
+				user.Name = &SCIMName{}
+				return nil
+			}
+			var name SCIMName
+			if err := json.Unmarshal(value, &name); err != nil {
+				return scimInvalidValue("name must be an object")
+			}
+			user.Name = &name
+		case path == "emails":
+			if op == "remove" {
+				user.Emails = nil
+				return nil
+			}
+			var emails []SCIMEmail
+			if err := json.Unmarshal(value, &emails); err != nil {
+				return scimInvalidValue("emails must be a list")
+			}
+			user.Emails = emails
+		case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+			// Directories address the work or primary email this way; there
+			// is only one to change
+			var email string
+			if err := scimSetString(&email, op, value); err != nil {
+				return err
+			}
+			if len(user.Emails) == 0 {
+				user.Emails = []SCIMEmail{{Primary: true}}
+			}
+			user.Emails[primarySCIMEmail(user.Emails)].Value = email
+		}
+		return nil
+	})
+}
+
+// applySCIMGroupPatch applies PATCH operations to a group
+func applySCIMGroupPatch(group *SCIMGroup, ops []SCIMPatchOperation) error {
+	return scimPatch(ops, SCIMGroupSchema, func(op, path string, value json.RawMessage) error {
+		switch {
+		case path == "displayname":
+			if op == "remove" {
+				return scimInvalidValue("displayName can't be removed")
+			}
+			return scimSetString(&group.DisplayName, op, value)
+		case path == "externalid":
+			return scimSetString(&group.ExternalID, op, value)
+		case path == "members":
+			var members []SCIMReference
+			if len(value) > 0 {
+				if err := json.Unmarshal(value, &members); err != nil {
+					return scimInvalidValue("members must be a list")
+				}
+			}
+			switch {
+			case op == "replace":
+				group.Members = members
+			case op == "add":
+				group.Members = append(group.Members, members...)
+			case len(members) == 0:
+				group.Members = nil
+			default:
+				for _, member := range members {
+					group.Members = removeSCIMMember(group.Members, member.Value)
+				}
+			}
+		case strings.HasPrefix(path, "members[value eq ") && strings.HasSuffix(path, "]"):
+			if op != "remove" {
+				return scimInvalidSyntax("members can only be removed by filter")
+			}
+			id, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(path, "members[value eq "), "]"))
+			if err != nil {
+				return scimInvalidSyntax("invalid members filter")
+			}
+			group.Members = removeSCIMMember(group.Members, id)
+		default:
+			return &SCIMError{Status: http.StatusBadRequest, SCIMType: "invalidPath", Detail: "unsupported path " + strconv.Quote(path)}
+		}
+		return nil
+	})
+}
+
+func removeSCIMMember(members []SCIMReference, id string) []SCIMReference {
+	kept := members[:0]
+	for _, member := range members {
+		if !strings.EqualFold(member.Value, id) {
+			kept = append(kept, member)
+		}
+	}
+	return kept
+}
+
+// primarySCIMEmail returns the index of the email to keep: the primary one,
+// or else the first
+func primarySCIMEmail(emails []SCIMEmail) int {
+	for i, email := range emails {
+		if email.Primary {
+			return i
+		}
+	}
+	return 0
+}
+
+func scimSetString(target *string, op string, value json.RawMessage) error {
+	if op == "remove" {
+		*target = ""
+		return nil
+	}
+	if err := json.Unmarshal(value, target); err != nil {
+		return scimInvalidValue("expected a string value")
+	}
+	return nil
+}
+
+// scimBool reads a boolean, which some directories send as "True" or "False"
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return b, nil
+		}
+	}
+	return false, scimInvalidValue("expected a boolean value")
+}
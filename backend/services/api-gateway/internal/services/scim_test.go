@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	f, err := parseSCIMFilter(`userName eq "jane@acme.test"`, "username", "externalid")
+	require.NoError(t, err)
+	assert.Equal(t, &scimFilter{Attribute: "username", Value: "jane@acme.test"}, f)
+
+	f, err = parseSCIMFilter(`  externalId EQ "a \"quoted\" id" `, "username", "externalid")
+	require.NoError(t, err)
+	assert.Equal(t, &scimFilter{Attribute: "externalid", Value: `a "quoted" id`}, f)
+
+	f, err = parseSCIMFilter(" ", "username")
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	for _, filter := range []string{
+		`userName co "jane"`,
+		`userName eq jane`,
+		`userName eq "a" or userName eq "b"`,
+		`title eq "CTO"`,
+	} {
+		_, err := parseSCIMFilter(filter, "username")
+		var scimErr *SCIMError
+		require.True(t, errors.As(err, &scimErr), filter)
+		assert.Equal(t, http.StatusBadRequest, scimErr.Status)
+		assert.Equal(t, "invalidFilter", scimErr.SCIMType)
+	}
+}
+
+func TestApplySCIMUserPatch(t *testing.T) {
+	active := true
+	user := &SCIMUser{
+		UserName: "jane@acme.test",
+		Emails:   []SCIMEmail{{Value: "jane@acme.test", Type: "work", Primary: true}},
+		Active:   &active,
+	}
+
+	// Azure AD sends string booleans and filtered email paths; Okta sends
+	// path-less operations
+	var ops []SCIMPatchOperation
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jane.doe@acme.test"},
+		{"op": "add", "value": {"name.givenName": "Jane", "urn:ietf:params:scim:schemas:core:2.0:User:externalId": "00u1"}},
+		{"op": "replace", "path": "title", "value": "CTO"}
+	]`), &ops))
+	require.NoError(t, applySCIMUserPatch(user, ops))
+
+	assert.False(t, *user.Active)
+	assert.Equal(t, "jane.doe@acme.test", user.Emails[0].Value)
+	assert.Equal(t, "Jane", user.Name.GivenName)
+	assert.Equal(t, "00u1", user.ExternalID)
+
+	for name, raw := range map[string]string{
+		"no operations":    `[]`,
+		"unknown op":       `[{"op": "move", "path": "active", "value": true}]`,
+		"remove username":  `[{"op": "remove", "path": "userName"}]`,
+		"not a boolean":    `[{"op": "replace", "path": "active", "value": "maybe"}]`,
+		"path-less remove": `[{"op": "remove", "value": {"active": true}}]`,
+	} {
+		require.NoError(t, json.Unmarshal([]byte(raw), &ops))
+		assert.Error(t, applySCIMUserPatch(user, ops), name)
+	}
+}
+
+func TestApplySCIMGroupPatch(t *testing.T) {
+	group := &SCIMGroup{DisplayName: "Engineering", Members: []SCIMReference{{Value: "u-1"}, {Value: "u-2"}}}
+
+	var ops []SCIMPatchOperation
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"op": "add", "path": "members", "value": [{"value": "u-3"}]},
+		{"op": "remove", "path": "members[value eq \"u-1\"]"},
+		{"op": "remove", "path": "members", "value": [{"value": "U-2"}]},
+		{"op": "replace", "value": {"displayName": "Platform"}}
+	]`), &ops))
+	require.NoError(t, applySCIMGroupPatch(group, ops))
+	assert.Equal(t, "Platform", group.DisplayName)
+	assert.Equal(t, []SCIMReference{{Value: "u-3"}}, group.Members)
+
+	require.NoError(t, json.Unmarshal([]byte(`[{"op": "remove", "path": "members"}]`), &ops))
+	require.NoError(t, applySCIMGroupPatch(group, ops))
+	assert.Empty(t, group.Members)
+
+	require.NoError(t, json.Unmarshal([]byte(`[{"op": "replace", "path": "owner", "value": "u-1"}]`), &ops))
+	var scimErr *SCIMError
+	require.True(t, errors.As(applySCIMGroupPatch(group, ops), &scimErr))
+	assert.Equal(t, "invalidPath", scimErr.SCIMType)
+}
+
+func TestSCIMCreateUser(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	const existingID = "5f0c7a4e-7d1b-4c35-9a57-3c1b8b2a9d10"
+
+	// existing describes the account that already has the email; nil when
+	// there is none
+	type account struct {
+		org                  string
+		provisioned, claimed bool
+	}
+	newService := func(existing *account) (*SCIMService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "SELECT EXISTS (SELECT 1 FROM auth.scim_users"):
+				return []string{"taken"}, [][]driver.Value{{false}}
+			case strings.Contains(query, "SELECT EXISTS (SELECT 1 FROM auth.sso_domains"):
+				return []string{"claimed"}, [][]driver.Value{{true}}
+			case strings.Contains(query, "WHERE LOWER(u.email) = $1") && existing != nil:
+				return []string{"id", "org_id", "provisioned", "claimed"},
+					[][]driver.Value{{existingID, existing.org, existing.provisioned, existing.claimed}}
+			case strings.Contains(query, "LOWER(username) = $1"):
+				return []string{"taken"}, [][]driver.Value{{false}}
+			case strings.Contains(query, "FROM auth.sso_connections"):
+				return []string{"role_mapping", "default_role"}, [][]driver.Value{{[]byte(`{"Engineering":"developer"}`), "user"}}
+			case strings.Contains(query, "SELECT m.user_id, g.display_name"):
+				return []string{"user_id", "display_name"}, [][]driver.Value{{existingID, "Engineering"}}
+			case strings.Contains(query, "WHERE su.org_id = $1 AND su.user_id = $2"):
+				now := time.Now()
+				return []string{"id", "user_name", "external_id", "email", "first_name", "last_name", "is_active", "created_at", "updated_at"},
+					[][]driver.Value{{existingID, "jane@acme.test", "00u1", "jane@acme.test", "Jane", "Doe", true, now, now}}
+			}
+			return nil, nil
+		}
+		return NewSCIMService(NewDatabaseService(db.DB), nil, nil, logger), d
+	}
+	in := &SCIMUser{
+		UserName:   "jane@acme.test",
+		ExternalID: "00u1",
+		Name:       &SCIMName{GivenName: "Jane", FamilyName: "Doe"},
+		Emails:     []SCIMEmail{{Value: "Jane@acme.test", Primary: true}},
+	}
+
+	t.Run("creates a new account", func(t *testing.T) {
+		scim, d := newService(nil)
+		user, err := scim.CreateUser(ctx, "acme", in)
+		require.NoError(t, err)
+		assert.Equal(t, "Jane Doe", user.DisplayName)
+
+		var created, linked bool
+		for i, entry := range d.entries() {
+			if strings.HasPrefix(entry, "INSERT INTO auth.users") {
+				created = true
+				assert.Equal(t, "jane@acme.test", d.args[i][1].Value)
+				assert.Equal(t, ssoPasswordHash, d.args[i][3].Value)
+				assert.Equal(t, "acme", d.args[i][7].Value)
+			}
+			linked = linked || strings.HasPrefix(entry, "INSERT INTO auth.scim_users")
+		}
+		assert.True(t, created)
+		assert.True(t, linked)
+	})
+
+	for name, existing := range map[string]account{
+		"adopts a member of the organization":   {org: "acme"},
+		"adopts an account of a claimed domain": {claimed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scim, d := newService(&existing)
+			_, err := scim.CreateUser(ctx, "acme", in)
+			require.NoError(t, err)
+
+			var moved, roles bool
+			for i, entry := range d.entries() {
+				assert.False(t, strings.HasPrefix(entry, "INSERT INTO auth.users"), entry)
+				if strings.HasPrefix(entry, "UPDATE auth.users SET email") {
+					moved = true
+					assert.Equal(t, "acme", d.args[i][5].Value)
+				}
+				if strings.HasPrefix(entry, "UPDATE auth.users SET roles") {
+					roles = true
+					assert.Equal(t, `{"developer"}`, d.args[i][1].Value)
+				}
+			}
+			assert.True(t, moved)
+			assert.True(t, roles)
+		})
+	}
+
+	for name, existing := range map[string]account{
+		"another organization's account": {org: "globex", claimed: true},
+		"an unclaimed domain's account":  {},
+		"an already provisioned account": {org: "acme", provisioned: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			scim, d := newService(&existing)
+			_, err := scim.CreateUser(ctx, "acme", in)
+			var scimErr *SCIMError
+			require.True(t, errors.As(err, &scimErr), "%v", err)
+			assert.Equal(t, "uniqueness", scimErr.SCIMType)
+			for _, entry := range d.entries() {
+				assert.NotEqual(t, "COMMIT", entry)
+			}
+		})
+	}
+
+	t.Run("rejects new accounts outside the organization's domains", func(t *testing.T) {
+		scim, d := newService(nil)
+		rows := d.rows
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "SELECT EXISTS (SELECT 1 FROM auth.sso_domains") {
+				return []string{"claimed"}, [][]driver.Value{{false}}
+			}
+			return rows(query)
+		}
+		_, err := scim.CreateUser(ctx, "acme", in)
+		var scimErr *SCIMError
+		require.True(t, errors.As(err, &scimErr), "%v", err)
+		assert.Equal(t, "invalidValue", scimErr.SCIMType)
+		for _, entry := range d.entries() {
+			assert.False(t, strings.HasPrefix(entry, "INSERT INTO auth.users"), entry)
+		}
+	})
+
+	t.Run("requires an email", func(t *testing.T) {
+		scim, _ := newService(nil)
+		_, err := scim.CreateUser(ctx, "acme", &SCIMUser{UserName: "jane"})
+		var scimErr *SCIMError
+		require.True(t, errors.As(err, &scimErr))
+		assert.Equal(t, "invalidValue", scimErr.SCIMType)
+	})
+}
+
+func TestSCIMReplaceUserEmailDomain(t *testing.T) {
+	ctx := context.Background()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	const userID = "5f0c7a4e-7d1b-4c35-9a57-3c1b8b2a9d10"
+
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "FOR UPDATE OF su, u"):
+			return []string{"email", "is_active", "taken"}, [][]driver.Value{{"jane@acme.test", true, false}}
+		case strings.Contains(query, "SELECT EXISTS (SELECT 1 FROM auth.sso_domains"):
+			return []string{"claimed"}, [][]driver.Value{{false}}
+		}
+		return nil, nil
+	}
+	scim := NewSCIMService(NewDatabaseService(db.DB), nil, nil, logger)
+
+	// Moving a user to an email the organization doesn't own is rejected
+	_, err := scim.ReplaceUser(ctx, "acme", userID, &SCIMUser{
+		UserName: "jane@acme.test",
+		Emails:   []SCIMEmail{{Value: "jane@gmail.test", Primary: true}},
+	})
+	var scimErr *SCIMError
+	require.True(t, errors.As(err, &scimErr), "%v", err)
+	assert.Equal(t, "invalidValue", scimErr.SCIMType)
+	for _, entry := range d.entries() {
+		assert.False(t, strings.HasPrefix(entry, "UPDATE auth.users"), entry)
+	}
+}
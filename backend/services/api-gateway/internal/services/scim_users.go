@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// scimUserFields are the parts of a SCIM user the gateway stores
+type scimUserFields struct {
+	UserName   string
+	ExternalID string
+	Email      string
+	FirstName  string
+	LastName   string
+	Active     bool
+}
+
+// scimUserInput checks a user a directory sent. The email is the primary
+// one, or the userName when the directory sent no emails.
+func scimUserInput(user *SCIMUser) (scimUserFields, error) {
+	fields := scimUserFields{
+		UserName:   strings.TrimSpace(user.UserName),
+		ExternalID: strings.TrimSpace(user.ExternalID),
+		Active:     user.Active == nil || *user.Active,
+	}
+	if fields.UserName == "" {
+		return fields, scimInvalidValue("userName is required")
+	}
+	if user.Name != nil {
+		fields.FirstName = strings.TrimSpace(user.Name.GivenName)
+		fields.LastName = strings.TrimSpace(user.Name.FamilyName)
+	}
+
+	email := fields.UserName
+	if len(user.Emails) > 0 {
+		email = user.Emails[primarySCIMEmail(user.Emails)].Value
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		return fields, scimInvalidValue("a valid email address is required, in emails or as the userName")
+	}
+	fields.Email = email
+	return fields, nil
+}
+
+const scimUserColumns = `u.id, su.user_name, COALESCE(su.external_id, ''), u.email,
+	COALESCE(u.first_name, ''), COALESCE(u.last_name, ''), u.is_active, su.created_at,
+	GREATEST(su.updated_at, u.updated_at)`
+
+func scanSCIMUser(row interface{ Scan(...interface{}) error }) (*SCIMUser, error) {
+	user := &SCIMUser{Schemas: []string{SCIMUserSchema}, Name: &SCIMName{}, Meta: &SCIMMeta{ResourceType: "User"}}
+	var email string
+	var active bool
+	err := row.Scan(&user.ID, &user.UserName, &user.ExternalID, &email,
+		&user.Name.GivenName, &user.Name.FamilyName, &active, &user.Meta.Created, &user.Meta.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	user.Name.Formatted = strings.TrimSpace(user.Name.GivenName + " " + user.Name.FamilyName)
+	user.DisplayName = user.Name.Formatted
+	user.Emails = []SCIMEmail{{Value: email, Type: "work", Primary: true}}
+	user.Active = &active
+	return user, nil
+}
+
+// ListUsers returns a page of the users the organization's directory
+// provisioned, matching an optional filter on userName, externalId,
+// emails or id. startIndex is 1-based.
+func (s *SCIMService) ListUsers(ctx context.Context, orgID, filter string, startIndex, count int) (*SCIMListResponse, error) {
+	f, err := parseSCIMFilter(filter, "username", "externalid", "emails", "emails.value", "id")
+	if err != nil {
+		return nil, err
+	}
+
+	where := `su.org_id = $1`
+	args := []interface{}{orgID}
+	if f != nil {
+		switch f.Attribute {
+		case "username":
+			where += ` AND LOWER(su.user_name) = LOWER($2)`
+		case "externalid":
+			where += ` AND su.external_id = $2`
+		case "emails", "emails.value":
+			where += ` AND LOWER(u.email) = LOWER($2)`
+		case "id":
+			where += ` AND u.id::text = LOWER($2)`
+		}
+		args = append(args, f.Value)
+	}
+	from := ` FROM auth.scim_users su JOIN auth.users u ON u.id = su.user_id WHERE ` + where
+
+	list := &SCIMListResponse{Schemas: []string{SCIMListSchema}, StartIndex: startIndex, Resources: []*SCIMUser{}}
+	if err := s.db.DB.QueryRowContext(ctx, `SELECT COUNT(*)`+from, args...).Scan(&list.TotalResults); err != nil {
+		return nil, fmt.Errorf("failed to count scim users: %w", err)
+	}
+	if count == 0 || list.TotalResults < startIndex {
+		return list, nil
+	}
+
+	page := ` LIMIT $2 OFFSET $3`
+	if f != nil {
+		page = ` LIMIT $3 OFFSET $4`
+	}
+	rows, err := s.db.DB.QueryContext(ctx, `SELECT `+scimUserColumns+from+` ORDER BY su.created_at, u.id`+page,
+		append(args, count, startIndex-1)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scim users: %w", err)
+	}
+	defer rows.Close()
+	users := []*SCIMUser{}
+	for rows.Next() {
+		user, err := scanSCIMUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan scim user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate scim users: %w", err)
+	}
+
+	if err := s.loadUserGroups(ctx, orgID, users); err != nil {
+		return nil, err
+	}
+	list.Resources = users
+	list.ItemsPerPage = len(users)
+	return list, nil
+}
+
+// GetUser returns a user the organization's directory provisioned
+func (s *SCIMService) GetUser(ctx context.Context, orgID, id string) (*SCIMUser, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, scimNotFound("User")
+	}
+	user, err := scanSCIMUser(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+scimUserColumns+`
+		FROM auth.scim_users su JOIN auth.users u ON u.id = su.user_id
+		WHERE su.org_id = $1 AND su.user_id = $2`, orgID, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, scimNotFound("User")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scim user: %w", err)
+	}
+	if err := s.loadUserGroups(ctx, orgID, []*SCIMUser{user}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// loadUserGroups fills in the organization's groups each user is in
+func (s *SCIMService) loadUserGroups(ctx context.Context, orgID string, users []*SCIMUser) error {
+	if len(users) == 0 {
+		return nil
+	}
+	byID := make(map[string]*SCIMUser, len(users))
+	ids := make([]string, len(users))
+	for i, user := range users {
+		byID[user.ID] = user
+		ids[i] = user.ID
+	}
+
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT m.user_id, g.id, g.display_name
+		FROM auth.scim_group_members m JOIN auth.scim_groups g ON g.id = m.group_id
+		WHERE g.org_id = $1 AND m.user_id = ANY($2::uuid[])
+		ORDER BY g.display_name`, orgID, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("failed to get scim user groups: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var userID string
+		var group SCIMReference
+		if err := rows.Scan(&userID, &group.Value, &group.Display); err != nil {
+			return fmt.Errorf("failed to scan scim user group: %w", err)
+		}
+		if user, ok := byID[userID]; ok {
+			user.Groups = append(user.Groups, group)
+		}
+	}
+	return rows.Err()
+}
+
+// CreateUser provisions a user into the organization. An existing account
+// with the same email is adopted when it already belongs to the
+// organization, or has none and its email domain is claimed by the
+// organization's single sign-on; any other is a uniqueness conflict.
+func (s *SCIMService) CreateUser(ctx context.Context, orgID string, in *SCIMUser) (*SCIMUser, error) {
+	fields, err := scimUserInput(in)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM auth.scim_users WHERE org_id = $1 AND LOWER(user_name) = LOWER($2))`,
+		orgID, fields.UserName).Scan(&taken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check scim user name: %w", err)
+	}
+	if taken {
+		return nil, scimUniqueness("userName %q is already provisioned", fields.UserName)
+	}
+
+	var userID, userOrg string
+	var provisioned, claimed bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.id, COALESCE(u.metadata->>'org_id', ''),
+			EXISTS (SELECT 1 FROM auth.scim_users WHERE user_id = u.id),
+			EXISTS (SELECT 1 FROM auth.sso_domains WHERE domain = $2 AND org_id = $3)
+		FROM auth.users u
+		WHERE LOWER(u.email) = $1
+		FOR UPDATE OF u`, fields.Email, EmailDomain(fields.Email), orgID).Scan(&userID, &userOrg, &provisioned, &claimed)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if err := checkSCIMEmailDomain(ctx, tx, orgID, fields.Email); err != nil {
+			return nil, err
+		}
+		userID, err = s.insertUser(ctx, tx, orgID, fields)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to look up user by email: %w", err)
+	case provisioned:
+		return nil, scimUniqueness("a user with email %s is already provisioned", fields.Email)
+	case userOrg != orgID && !(userOrg == "" && claimed):
+		return nil, scimUniqueness("email %s belongs to an account outside the organization", fields.Email)
+	default:
+		if err := s.updateUser(ctx, tx, orgID, userID, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth.scim_users (user_id, org_id, user_name, external_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''))`, userID, orgID, fields.UserName, fields.ExternalID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, scimUniqueness("userName %q is already provisioned", fields.UserName)
+		}
+		return nil, fmt.Errorf("failed to link scim user: %w", err)
+	}
+	if err := syncSCIMRoles(ctx, tx, orgID, []string{userID}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scim user: %w", err)
+	}
+	if !fields.Active {
+		s.signOut(ctx, userID)
+	} else if s.accounts != nil {
+		s.accounts.Forget(userID)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"org_id":  orgID,
+		"adopted": userOrg != "" || claimed,
+	}).Info("User provisioned through SCIM")
+	return s.GetUser(ctx, orgID, userID)
+}
+
+// checkSCIMEmailDomain rejects an email outside the organization's single
+// sign-on domains, which the organization can't vouch for
+func checkSCIMEmailDomain(ctx context.Context, tx *sql.Tx, orgID, email string) error {
+	var claimed bool
+	err := tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM auth.sso_domains WHERE domain = $1 AND org_id = $2)`,
+		EmailDomain(email), orgID).Scan(&claimed)
+	if err != nil {
+		return fmt.Errorf("failed to check email domain: %w", err)
+	}
+	if !claimed {
+		return scimInvalidValue("email %s is not in one of the organization's single sign-on domains", email)
+	}
+	return nil
+}
+
+// insertUser creates a verified account for a provisioned user, who signs
+// in through the organization's single sign-on
+func (s *SCIMService) insertUser(ctx context.Context, tx *sql.Tx, orgID string, fields scimUserFields) (string, error) {
+	username, err := availableUsername(ctx, tx, fields.Email)
+	if err != nil {
+		return "", err
+	}
+
+	userID := uuid.New().String()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth.users (
+			id, email, username, password_hash, first_name, last_name,
+			roles, is_active, is_verified, preferences, metadata
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), '{user}', $7, true, '{}', jsonb_build_object('org_id', $8::text))`,
+		userID, fields.Email, username, ssoPasswordHash, fields.FirstName, fields.LastName, fields.Active, orgID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+	return userID, nil
+}
+
+// updateUser writes a provisioned user's account fields, moving them into
+// the organization
+func (s *SCIMService) updateUser(ctx context.Context, tx *sql.Tx, orgID, userID string, fields scimUserFields) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET email = $2, first_name = NULLIF($3, ''), last_name = NULLIF($4, ''), is_active = $5,
+			metadata = jsonb_set(COALESCE(metadata, '{}'::jsonb), '{org_id}', to_jsonb($6::text)),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID, fields.Email, fields.FirstName, fields.LastName, fields.Active, orgID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return scimUniqueness("email %s is already in use", fields.Email)
+		}
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
+}
+
+// ReplaceUser overwrites a provisioned user. Deactivating them signs them
+// out.
+func (s *SCIMService) ReplaceUser(ctx context.Context, orgID, id string, in *SCIMUser) (*SCIMUser, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, scimNotFound("User")
+	}
+	fields, err := scimUserInput(in)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	var wasActive, taken bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.email, u.is_active,
+			EXISTS (SELECT 1 FROM auth.scim_users o WHERE o.org_id = $1 AND LOWER(o.user_name) = LOWER($3) AND o.user_id <> $2)
+		FROM auth.scim_users su JOIN auth.users u ON u.id = su.user_id
+		WHERE su.org_id = $1 AND su.user_id = $2
+		FOR UPDATE OF su, u`, orgID, id, fields.UserName).Scan(&email, &wasActive, &taken)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, scimNotFound("User")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scim user: %w", err)
+	}
+	if taken {
+		return nil, scimUniqueness("userName %q is already provisioned", fields.UserName)
+	}
+	if !strings.EqualFold(email, fields.Email) {
+		if err := checkSCIMEmailDomain(ctx, tx, orgID, fields.Email); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.updateUser(ctx, tx, orgID, id, fields); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE auth.scim_users SET user_name = $3, external_id = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE org_id = $1 AND user_id = $2`, orgID, id, fields.UserName, fields.ExternalID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update scim user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scim user: %w", err)
+	}
+	if wasActive && !fields.Active {
+		s.signOut(ctx, id)
+		s.logger.WithFields(logrus.Fields{"user_id": id, "org_id": orgID}).Info("User deactivated through SCIM")
+	}
+	return s.GetUser(ctx, orgID, id)
+}
+
+// PatchUser applies PATCH operations to a provisioned user
+func (s *SCIMService) PatchUser(ctx context.Context, orgID, id string, ops []SCIMPatchOperation) (*SCIMUser, error) {
+	user, err := s.GetUser(ctx, orgID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySCIMUserPatch(user, ops); err != nil {
+		return nil, err
+	}
+	return s.ReplaceUser(ctx, orgID, id, user)
+}
+
+// DeleteUser deprovisions a user: the account is deactivated and signed
+// out, and leaves the organization's groups. It is kept, so their history
+// survives a mistaken deprovisioning.
+func (s *SCIMService) DeleteUser(ctx context.Context, orgID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return scimNotFound("User")
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM auth.scim_users WHERE org_id = $1 AND user_id = $2`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete scim user: %w", err)
+	}
+	if rowsAffected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	} else if rowsAffected == 0 {
+		return scimNotFound("User")
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM auth.scim_group_members
+		WHERE user_id = $2 AND group_id IN (SELECT id FROM auth.scim_groups WHERE org_id = $1)`, orgID, id)
+	if err != nil {
+		return fmt.Errorf("failed to remove scim user from groups: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE auth.users SET is_active = false, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit scim user deletion: %w", err)
+	}
+	s.signOut(ctx, id)
+	s.logger.WithFields(logrus.Fields{"user_id": id, "org_id": orgID}).Info("User deprovisioned through SCIM")
+	return nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Search sources recorded in analytics.search_queries
+const (
+	SearchSourceHistory = "history"
+)
+
+// searchTrackTimeout bounds the background insert of a tracked search
+const searchTrackTimeout = 5 * time.Second
+
+// SearchQuery is one search to record
+type SearchQuery struct {
+	UserID      string
+	Source      string
+	Query       string
+	ResultCount int
+}
+
+// SearchTermStats aggregates searches for one normalized query
+type SearchTermStats struct {
+	Query            string    `json:"query"`
+	Searches         int       `json:"searches"`
+	AvgResults       float64   `json:"avg_results"`
+	Clicks           int       `json:"clicks"`
+	ClickThroughRate float64   `json:"click_through_rate"`
+	LastSearchedAt   time.Time `json:"last_searched_at"`
+}
+
+// SearchAnalyticsSummary summarizes search behavior over a period
+type SearchAnalyticsSummary struct {
+	Since              time.Time          `json:"since"`
+	TotalSearches      int                `json:"total_searches"`
+	ZeroResultSearches int                `json:"zero_result_searches"`
+	ZeroResultRate     float64            `json:"zero_result_rate"`
+	ClickThroughRate   float64            `json:"click_through_rate"`
+	TopQueries         []*SearchTermStats `json:"top_queries"`
+	ZeroResultQueries  []*SearchTermStats `json:"zero_result_queries"`
+}
+
+// SearchAnalyticsService records searches and result clicks
+type SearchAnalyticsService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewSearchAnalyticsService creates a new search analytics service
+func NewSearchAnalyticsService(db *DatabaseService, logger *logrus.Logger) *SearchAnalyticsService {
+	return &SearchAnalyticsService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// NormalizeSearchQuery folds case and whitespace so equivalent queries
+// aggregate together
+func NormalizeSearchQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// TrackQuery records a search in the background so it never slows the
+// search itself, returning the ID clients use to report clicks
+func (s *SearchAnalyticsService) TrackQuery(query SearchQuery) string {
+	id := uuid.New().String()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), searchTrackTimeout)
+		defer cancel()
+
+		var userID interface{}
+		if query.UserID != "" {
+			userID = query.UserID
+		}
+
+		_, err := s.db.DB.ExecContext(ctx, `
+			INSERT INTO analytics.search_queries (id, user_id, source, query, normalized_query, result_count)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			id, userID, query.Source, query.Query, NormalizeSearchQuery(query.Query), query.ResultCount,
+		)
+		if err != nil {
+			s.logger.WithError(err).WithField("source", query.Source).Warn("Failed to record search query")
+		}
+	}()
+
+	return id
+}
+
+// RecordClick records that a user opened a result of one of their searches
+func (s *SearchAnalyticsService) RecordClick(ctx context.Context, searchID, userID, resultID string, position int) error {
+	result, err := s.db.DB.ExecContext(ctx, `
+		INSERT INTO analytics.search_clicks (search_id, result_id, position)
+		SELECT id, $3, $4
+		FROM analytics.search_queries
+		WHERE id = $1 AND user_id = $2`,
+		searchID, userID, resultID, position,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record search click: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record search click: %w", err)
+	}
+	if rows == 0 {
+		return errors.New("search not found")
+	}
+	return nil
+}
+
+// Summary reports search volume, zero-result and click-through rates, and
+// the most popular and most common zero-result queries since the given time
+func (s *SearchAnalyticsService) Summary(ctx context.Context, since time.Time, limit int) (*SearchAnalyticsSummary, error) {
+	summary := &SearchAnalyticsSummary{Since: since}
+
+	var clickedSearches int
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			   COUNT(*) FILTER (WHERE q.result_count = 0),
+			   COUNT(*) FILTER (WHERE EXISTS (
+				   SELECT 1 FROM analytics.search_clicks c WHERE c.search_id = q.id))
+		FROM analytics.search_queries q
+		WHERE q.created_at >= $1`,
+		since,
+	).Scan(&summary.TotalSearches, &summary.ZeroResultSearches, &clickedSearches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize searches: %w", err)
+	}
+
+	if summary.TotalSearches > 0 {
+		summary.ZeroResultRate = float64(summary.ZeroResultSearches) / float64(summary.TotalSearches)
+		summary.ClickThroughRate = float64(clickedSearches) / float64(summary.TotalSearches)
+	}
+
+	if summary.TopQueries, err = s.termStats(ctx, since, limit, false); err != nil {
+		return nil, err
+	}
+	if summary.ZeroResultQueries, err = s.termStats(ctx, since, limit, true); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+func (s *SearchAnalyticsService) termStats(ctx context.Context, since time.Time, limit int, zeroResultsOnly bool) ([]*SearchTermStats, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT q.normalized_query,
+			   COUNT(*),
+			   AVG(q.result_count),
+			   COUNT(c.search_id),
+			   MAX(q.created_at)
+		FROM analytics.search_queries q
+		LEFT JOIN (
+			SELECT DISTINCT search_id FROM analytics.search_clicks
+		) c ON c.search_id = q.id
+		WHERE q.created_at >= $1
+		  AND ($3 = false OR q.result_count = 0)
+		GROUP BY q.normalized_query
+		ORDER BY COUNT(*) DESC, MAX(q.created_at) DESC
+		LIMIT $2`,
+		since, limit, zeroResultsOnly,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query search terms: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []*SearchTermStats{}
+	for rows.Next() {
+		term := &SearchTermStats{}
+		var avgResults sql.NullFloat64
+		if err := rows.Scan(&term.Query, &term.Searches, &avgResults, &term.Clicks, &term.LastSearchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan search terms: %w", err)
+		}
+		term.AvgResults = avgResults.Float64
+		if term.Searches > 0 {
+			term.ClickThroughRate = float64(term.Clicks) / float64(term.Searches)
+		}
+		stats = append(stats, term)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate search terms: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSearchQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"already normalized", "chain of thought", "chain of thought"},
+		{"mixed case", "Chain Of Thought", "chain of thought"},
+		{"surrounding whitespace", "  few-shot\t", "few-shot"},
+		{"repeated inner whitespace", "role   play\n prompts", "role play prompts"},
+		{"empty", "   ", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, NormalizeSearchQuery(tt.input))
+		})
+	}
+}
@@ -0,0 +1,546 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// SettingsBundleVersion is the version of the bundle format exports write.
+// Imports read it and any earlier version.
+const SettingsBundleVersion = 1
+
+// CustomInstructionsPreference is the preferences key holding the
+// instructions a user wants applied to all their enhancements
+const CustomInstructionsPreference = "custom_instructions"
+
+// Limits on settings bundles
+const (
+	MaxSettingsBundleSize       = 1 << 20
+	MaxUserTemplates            = 100
+	maxCustomInstructionsLength = 4000
+	maxTemplateNameLength       = 255
+	maxTemplateLabelLength      = 100 // technique and category
+	maxTemplateTextLength       = 20000
+)
+
+// ErrTooManyTemplates is returned when an import would leave the user with
+// more than MaxUserTemplates
+var ErrTooManyTemplates = fmt.Errorf("at most %d templates are allowed", MaxUserTemplates)
+
+// privatePreferences are preferences the gateway keeps for itself. They are
+// never exported, and imports can't set or clear them.
+var privatePreferences = []string{"verification_code"}
+
+var templateSlugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// SettingsImportMode is how an import treats settings the user already has
+type SettingsImportMode string
+
+const (
+	// SettingsImportMerge keeps the user's settings, overwriting preferences,
+	// profiles and templates the bundle also has
+	SettingsImportMerge SettingsImportMode = "merge"
+	// SettingsImportReplace makes the user's settings exactly the bundle's
+	SettingsImportReplace SettingsImportMode = "replace"
+)
+
+// SettingsBundle is a user's settings in a portable form, exported from one
+// account and imported into another
+type SettingsBundle struct {
+	Version            int                    `json:"version"`
+	ExportedAt         time.Time              `json:"exported_at"`
+	Preferences        map[string]interface{} `json:"preferences"`
+	CustomInstructions string                 `json:"custom_instructions,omitempty"`
+	Profiles           []*EnhancementProfile  `json:"profiles"`
+	Templates          []*UserTemplate        `json:"templates"`
+}
+
+// UserTemplate is a prompt template a user created. Templates are matched
+// by name on import.
+type UserTemplate struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	Technique    string                 `json:"technique"`
+	Category     string                 `json:"category,omitempty"`
+	TemplateText string                 `json:"template_text"`
+	Variables    []interface{}          `json:"variables"`
+	Examples     []interface{}          `json:"examples"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	IsPublic     bool                   `json:"is_public"`
+}
+
+// SettingsBundleError lists everything wrong with a bundle
+type SettingsBundleError struct {
+	Problems []string
+}
+
+func (e *SettingsBundleError) Error() string {
+	return "invalid settings bundle: " + strings.Join(e.Problems, "; ")
+}
+
+// SettingsImportCounts is how many of one kind of setting an import changed
+type SettingsImportCounts struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+	Deleted int `json:"deleted"`
+}
+
+// SettingsImportResult reports what an import changed
+type SettingsImportResult struct {
+	Mode               SettingsImportMode   `json:"mode"`
+	PreferencesVersion int64                `json:"preferences_version"`
+	Profiles           SettingsImportCounts `json:"profiles"`
+	Templates          SettingsImportCounts `json:"templates"`
+}
+
+// SettingsService exports and imports users' settings: preferences, custom
+// instructions, enhancement profiles and templates
+type SettingsService struct {
+	db       *DatabaseService
+	profiles *EnhancementProfileService
+	logger   *logrus.Logger
+}
+
+// NewSettingsService creates a new settings service
+func NewSettingsService(db *DatabaseService, profiles *EnhancementProfileService, logger *logrus.Logger) *SettingsService {
+	return &SettingsService{
+		db:       db,
+		profiles: profiles,
+		logger:   logger,
+	}
+}
+
+// Export returns the user's settings as a bundle
+func (s *SettingsService) Export(ctx context.Context, userID string) (*SettingsBundle, error) {
+	var prefsJSON []byte
+	err := s.db.DB.QueryRowContext(ctx, `SELECT preferences FROM auth.users WHERE id = $1`, userID).Scan(&prefsJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("user not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+
+	bundle := &SettingsBundle{
+		Version:     SettingsBundleVersion,
+		ExportedAt:  time.Now().UTC(),
+		Preferences: make(map[string]interface{}),
+	}
+	if err := json.Unmarshal(prefsJSON, &bundle.Preferences); err != nil || bundle.Preferences == nil {
+		bundle.Preferences = make(map[string]interface{})
+	}
+	for _, key := range privatePreferences {
+		delete(bundle.Preferences, key)
+	}
+	if instructions, ok := bundle.Preferences[CustomInstructionsPreference].(string); ok {
+		bundle.CustomInstructions = instructions
+	}
+	delete(bundle.Preferences, CustomInstructionsPreference)
+
+	if bundle.Profiles, err = s.profiles.ListProfiles(ctx, userID); err != nil {
+		return nil, err
+	}
+	if bundle.Templates, err = s.listTemplates(ctx, userID); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// Import applies a bundle to the user's settings in one transaction, so a
+// failed import changes nothing. The bundle is validated, and normalized,
+// first.
+func (s *SettingsService) Import(ctx context.Context, userID string, bundle *SettingsBundle, mode SettingsImportMode) (*SettingsImportResult, error) {
+	if mode != SettingsImportMerge && mode != SettingsImportReplace {
+		return nil, fmt.Errorf("unknown import mode %q", mode)
+	}
+	if err := ValidateSettingsBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result := &SettingsImportResult{Mode: mode}
+	if result.PreferencesVersion, err = importPreferences(ctx, tx, userID, bundle, mode); err != nil {
+		return nil, err
+	}
+	if result.Profiles, err = importProfiles(ctx, tx, userID, bundle.Profiles, mode); err != nil {
+		return nil, err
+	}
+	if result.Templates, err = importTemplates(ctx, tx, userID, bundle.Templates, mode); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit settings import: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id":           userID,
+		"mode":              mode,
+		"bundle_version":    bundle.Version,
+		"profiles_created":  result.Profiles.Created,
+		"profiles_updated":  result.Profiles.Updated,
+		"profiles_deleted":  result.Profiles.Deleted,
+		"templates_created": result.Templates.Created,
+		"templates_updated": result.Templates.Updated,
+		"templates_deleted": result.Templates.Deleted,
+	}).Info("Settings imported")
+	return result, nil
+}
+
+// ValidateSettingsBundle normalizes a bundle's profiles and templates and
+// returns a SettingsBundleError listing whatever is malformed
+func ValidateSettingsBundle(bundle *SettingsBundle) error {
+	var problems []string
+	switch {
+	case bundle.Version < 1:
+		problems = append(problems, "version is required")
+	case bundle.Version > SettingsBundleVersion:
+		problems = append(problems, fmt.Sprintf("version %d is newer than the supported version %d", bundle.Version, SettingsBundleVersion))
+	}
+
+	bundle.CustomInstructions = strings.TrimSpace(bundle.CustomInstructions)
+	if utf8.RuneCountInString(bundle.CustomInstructions) > maxCustomInstructionsLength {
+		problems = append(problems, fmt.Sprintf("custom_instructions must be at most %d characters", maxCustomInstructionsLength))
+	}
+
+	if len(bundle.Profiles) > MaxEnhancementProfiles {
+		problems = append(problems, fmt.Sprintf("at most %d profiles are allowed", MaxEnhancementProfiles))
+	}
+	profileNames := make([]string, 0, len(bundle.Profiles))
+	for i, profile := range bundle.Profiles {
+		if profile == nil {
+			problems = append(problems, fmt.Sprintf("profiles[%d]: must be an object", i))
+			continue
+		}
+		if err := NormalizeEnhancementProfile(profile); err != nil {
+			problems = append(problems, fmt.Sprintf("profiles[%d]: %v", i, err))
+			continue
+		}
+		if slices.Contains(profileNames, profile.Name) {
+			problems = append(problems, fmt.Sprintf("profiles[%d]: duplicate name %q", i, profile.Name))
+		}
+		profileNames = append(profileNames, profile.Name)
+	}
+
+	if len(bundle.Templates) > MaxUserTemplates {
+		problems = append(problems, fmt.Sprintf("at most %d templates are allowed", MaxUserTemplates))
+	}
+	templateNames := make([]string, 0, len(bundle.Templates))
+	for i, template := range bundle.Templates {
+		if template == nil {
+			problems = append(problems, fmt.Sprintf("templates[%d]: must be an object", i))
+			continue
+		}
+		if err := normalizeUserTemplate(template); err != nil {
+			problems = append(problems, fmt.Sprintf("templates[%d]: %v", i, err))
+			continue
+		}
+		if slices.Contains(templateNames, template.Name) {
+			problems = append(problems, fmt.Sprintf("templates[%d]: duplicate name %q", i, template.Name))
+		}
+		templateNames = append(templateNames, template.Name)
+	}
+
+	if len(problems) > 0 {
+		return &SettingsBundleError{Problems: problems}
+	}
+	return nil
+}
+
+func normalizeUserTemplate(template *UserTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" || utf8.RuneCountInString(template.Name) > maxTemplateNameLength {
+		return fmt.Errorf("name must be 1-%d characters", maxTemplateNameLength)
+	}
+	template.Technique = strings.ToLower(strings.TrimSpace(template.Technique))
+	if template.Technique == "" || len(template.Technique) > maxTemplateLabelLength {
+		return fmt.Errorf("technique must be 1-%d characters", maxTemplateLabelLength)
+	}
+	template.Category = strings.TrimSpace(template.Category)
+	if utf8.RuneCountInString(template.Category) > maxTemplateLabelLength {
+		return fmt.Errorf("category must be at most %d characters", maxTemplateLabelLength)
+	}
+	if strings.TrimSpace(template.TemplateText) == "" || utf8.RuneCountInString(template.TemplateText) > maxTemplateTextLength {
+		return fmt.Errorf("template_text must be 1-%d characters", maxTemplateTextLength)
+	}
+
+	template.Description = strings.TrimSpace(template.Description)
+	if template.Variables == nil {
+		template.Variables = []interface{}{}
+	}
+	if template.Examples == nil {
+		template.Examples = []interface{}{}
+	}
+	if template.Metadata == nil {
+		template.Metadata = make(map[string]interface{})
+	}
+	return nil
+}
+
+// importedPreferences returns the preferences a user has after importing
+// the bundle's over current. Private preferences always stay as they were.
+func importedPreferences(current map[string]interface{}, bundle *SettingsBundle, mode SettingsImportMode) map[string]interface{} {
+	preferences := make(map[string]interface{})
+	if mode == SettingsImportMerge {
+		for key, value := range current {
+			preferences[key] = value
+		}
+	}
+	for key, value := range bundle.Preferences {
+		preferences[key] = value
+	}
+
+	// The bundle's own field is what carries custom instructions
+	delete(preferences, CustomInstructionsPreference)
+	if instructions, ok := current[CustomInstructionsPreference]; ok && mode == SettingsImportMerge {
+		preferences[CustomInstructionsPreference] = instructions
+	}
+	if bundle.CustomInstructions != "" {
+		preferences[CustomInstructionsPreference] = bundle.CustomInstructions
+	}
+
+	for _, key := range privatePreferences {
+		delete(preferences, key)
+		if value, ok := current[key]; ok {
+			preferences[key] = value
+		}
+	}
+	return preferences
+}
+
+func importPreferences(ctx context.Context, tx *sql.Tx, userID string, bundle *SettingsBundle, mode SettingsImportMode) (int64, error) {
+	var prefsJSON []byte
+	err := tx.QueryRowContext(ctx, `SELECT preferences FROM auth.users WHERE id = $1 FOR UPDATE`, userID).Scan(&prefsJSON)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errors.New("user not found")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get preferences: %w", err)
+	}
+	var current map[string]interface{}
+	if err := json.Unmarshal(prefsJSON, &current); err != nil || current == nil {
+		current = make(map[string]interface{})
+	}
+
+	prefsJSON, err = json.Marshal(importedPreferences(current, bundle, mode))
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	var version int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE auth.users SET
+			preferences = $2, updated_at = CURRENT_TIMESTAMP,
+			preferences_version = preferences_version + 1,
+			profile_version = profile_version + 1
+		WHERE id = $1
+		RETURNING preferences_version`, userID, prefsJSON).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update preferences: %w", err)
+	}
+	return version, nil
+}
+
+// planSettingsImport splits the names of incoming settings into those to
+// create and update, and picks which existing ones go: in replace mode,
+// those the bundle doesn't have
+func planSettingsImport(existing, incoming []string, mode SettingsImportMode) (create, update, remove []string) {
+	for _, name := range incoming {
+		if slices.Contains(existing, name) {
+			update = append(update, name)
+		} else {
+			create = append(create, name)
+		}
+	}
+	if mode == SettingsImportReplace {
+		for _, name := range existing {
+			if !slices.Contains(incoming, name) {
+				remove = append(remove, name)
+			}
+		}
+	}
+	return create, update, remove
+}
+
+func importProfiles(ctx context.Context, tx *sql.Tx, userID string, profiles []*EnhancementProfile, mode SettingsImportMode) (SettingsImportCounts, error) {
+	var counts SettingsImportCounts
+	existing, err := queryNames(ctx, tx, `SELECT name FROM prompts.enhancement_profiles WHERE user_id = $1`, userID)
+	if err != nil {
+		return counts, fmt.Errorf("failed to query enhancement profiles: %w", err)
+	}
+
+	incoming := make([]string, len(profiles))
+	for i, profile := range profiles {
+		incoming[i] = profile.Name
+	}
+	create, update, remove := planSettingsImport(existing, incoming, mode)
+	if len(existing)-len(remove)+len(create) > MaxEnhancementProfiles {
+		return counts, ErrTooManyProfiles
+	}
+
+	if len(remove) > 0 {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM prompts.enhancement_profiles WHERE user_id = $1 AND name = ANY($2)`, userID, pq.Array(remove))
+		if err != nil {
+			return counts, fmt.Errorf("failed to delete enhancement profiles: %w", err)
+		}
+	}
+	for _, profile := range profiles {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO prompts.enhancement_profiles
+				(user_id, name, prefer_techniques, exclude_techniques, tone, target_model, output_format)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (user_id, name) DO UPDATE SET
+				prefer_techniques = EXCLUDED.prefer_techniques, exclude_techniques = EXCLUDED.exclude_techniques,
+				tone = EXCLUDED.tone, target_model = EXCLUDED.target_model,
+				output_format = EXCLUDED.output_format, updated_at = CURRENT_TIMESTAMP`,
+			userID, profile.Name, pq.Array(profile.PreferTechniques), pq.Array(profile.ExcludeTechniques),
+			profile.Tone, profile.TargetModel, profile.OutputFormat)
+		if err != nil {
+			return counts, fmt.Errorf("failed to save enhancement profile: %w", err)
+		}
+	}
+	return SettingsImportCounts{Created: len(create), Updated: len(update), Deleted: len(remove)}, nil
+}
+
+func importTemplates(ctx context.Context, tx *sql.Tx, userID string, templates []*UserTemplate, mode SettingsImportMode) (SettingsImportCounts, error) {
+	var counts SettingsImportCounts
+	existing, err := queryNames(ctx, tx, `SELECT DISTINCT name FROM prompts.templates WHERE created_by = $1`, userID)
+	if err != nil {
+		return counts, fmt.Errorf("failed to query templates: %w", err)
+	}
+
+	incoming := make([]string, len(templates))
+	for i, template := range templates {
+		incoming[i] = template.Name
+	}
+	create, update, remove := planSettingsImport(existing, incoming, mode)
+	if len(existing)-len(remove)+len(create) > MaxUserTemplates {
+		return counts, ErrTooManyTemplates
+	}
+
+	if len(remove) > 0 {
+		_, err := tx.ExecContext(ctx,
+			`DELETE FROM prompts.templates WHERE created_by = $1 AND name = ANY($2)`, userID, pq.Array(remove))
+		if err != nil {
+			return counts, fmt.Errorf("failed to delete templates: %w", err)
+		}
+	}
+	for _, template := range templates {
+		variables, _ := json.Marshal(template.Variables)
+		examples, _ := json.Marshal(template.Examples)
+		metadata, _ := json.Marshal(template.Metadata)
+
+		if slices.Contains(update, template.Name) {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE prompts.templates SET
+					description = NULLIF($3, ''), technique = $4, category = NULLIF($5, ''), template_text = $6,
+					variables = $7, examples = $8, metadata = $9, is_public = $10, is_active = true
+				WHERE created_by = $1 AND name = $2`,
+				userID, template.Name, template.Description, template.Technique, template.Category,
+				template.TemplateText, variables, examples, metadata, template.IsPublic)
+		} else {
+			// Slugs are unique across all users, so the name alone won't do
+			var slug string
+			if slug, err = templateSlug(template.Name); err != nil {
+				return counts, err
+			}
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO prompts.templates
+					(name, slug, description, technique, category, template_text,
+					 variables, examples, metadata, is_public, created_by)
+				VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11)`,
+				template.Name, slug, template.Description, template.Technique, template.Category,
+				template.TemplateText, variables, examples, metadata, template.IsPublic, userID)
+		}
+		if err != nil {
+			return counts, fmt.Errorf("failed to save template: %w", err)
+		}
+	}
+	return SettingsImportCounts{Created: len(create), Updated: len(update), Deleted: len(remove)}, nil
+}
+
+// listTemplates returns the active templates a user created by name
+func (s *SettingsService) listTemplates(ctx context.Context, userID string) ([]*UserTemplate, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT name, COALESCE(description, ''), technique, COALESCE(category, ''), template_text,
+			variables, examples, metadata, is_public
+		FROM prompts.templates
+		WHERE created_by = $1 AND is_active
+		ORDER BY name`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query templates: %w", err)
+	}
+	defer rows.Close()
+
+	templates := []*UserTemplate{}
+	for rows.Next() {
+		var template UserTemplate
+		var variables, examples, metadata []byte
+		err := rows.Scan(&template.Name, &template.Description, &template.Technique, &template.Category,
+			&template.TemplateText, &variables, &examples, &metadata, &template.IsPublic)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan template: %w", err)
+		}
+		json.Unmarshal(variables, &template.Variables)
+		json.Unmarshal(examples, &template.Examples)
+		json.Unmarshal(metadata, &template.Metadata)
+		templates = append(templates, &template)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate templates: %w", err)
+	}
+	return templates, nil
+}
+
+func queryNames(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// templateSlug returns a slug for a new template: its name in URL form with
+// a random suffix
+func templateSlug(name string) (string, error) {
+	base := strings.Trim(templateSlugPattern.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(base) > 200 {
+		base = strings.TrimRight(base[:200], "-")
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate template slug: %w", err)
+	}
+	if base == "" {
+		return "template-" + hex.EncodeToString(suffix), nil
+	}
+	return base + "-" + hex.EncodeToString(suffix), nil
+}
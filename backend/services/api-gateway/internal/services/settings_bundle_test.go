@@ -0,0 +1,174 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSettingsBundle(t *testing.T) {
+	bundle := &SettingsBundle{
+		Version:            1,
+		CustomInstructions: "  Answer in British English ",
+		Profiles:           []*EnhancementProfile{{Name: " Work ", PreferTechniques: []string{"Few_Shot"}}},
+		Templates:          []*UserTemplate{{Name: " Bug report ", Technique: "Structured_Output", TemplateText: "Describe {{bug}}"}},
+	}
+	require.NoError(t, ValidateSettingsBundle(bundle))
+	assert.Equal(t, "Answer in British English", bundle.CustomInstructions)
+	assert.Equal(t, "work", bundle.Profiles[0].Name)
+	assert.Equal(t, "Bug report", bundle.Templates[0].Name)
+	assert.Equal(t, "structured_output", bundle.Templates[0].Technique)
+	assert.Equal(t, []interface{}{}, bundle.Templates[0].Variables)
+	assert.Equal(t, map[string]interface{}{}, bundle.Templates[0].Metadata)
+
+	err := ValidateSettingsBundle(&SettingsBundle{
+		Version:            2,
+		CustomInstructions: strings.Repeat("a", maxCustomInstructionsLength+1),
+		Profiles:           []*EnhancementProfile{{Name: "work"}, {Name: "Work"}, {Name: "my profile"}},
+		Templates:          []*UserTemplate{{Name: "Bug report", Technique: "cot"}, nil},
+	})
+	var bundleErr *SettingsBundleError
+	require.ErrorAs(t, err, &bundleErr)
+	assert.Equal(t, []string{
+		"version 2 is newer than the supported version 1",
+		"custom_instructions must be at most 4000 characters",
+		`profiles[1]: duplicate name "work"`,
+		"profiles[2]: name must be 1-50 lowercase letters, digits, '-' or '_'",
+		"templates[0]: template_text must be 1-20000 characters",
+		"templates[1]: must be an object",
+	}, bundleErr.Problems)
+
+	err = ValidateSettingsBundle(&SettingsBundle{})
+	require.ErrorAs(t, err, &bundleErr)
+	assert.Equal(t, []string{"version is required"}, bundleErr.Problems)
+}
+
+func TestImportedPreferences(t *testing.T) {
+	current := map[string]interface{}{
+		"theme":                      "dark",
+		"language":                   "en",
+		"verification_code":          "123456",
+		CustomInstructionsPreference: "Be brief",
+	}
+	bundle := &SettingsBundle{Preferences: map[string]interface{}{
+		"theme":             "light",
+		"verification_code": "000000",
+	}}
+
+	assert.Equal(t, map[string]interface{}{
+		"theme":                      "light",
+		"language":                   "en",
+		"verification_code":          "123456",
+		CustomInstructionsPreference: "Be brief",
+	}, importedPreferences(current, bundle, SettingsImportMerge))
+
+	assert.Equal(t, map[string]interface{}{
+		"theme":             "light",
+		"verification_code": "123456",
+	}, importedPreferences(current, bundle, SettingsImportReplace))
+
+	bundle.CustomInstructions = "Cite sources"
+	assert.Equal(t, "Cite sources", importedPreferences(current, bundle, SettingsImportMerge)[CustomInstructionsPreference])
+	assert.Equal(t, "Cite sources", importedPreferences(current, bundle, SettingsImportReplace)[CustomInstructionsPreference])
+}
+
+func TestPlanSettingsImport(t *testing.T) {
+	create, update, remove := planSettingsImport([]string{"work", "study"}, []string{"study", "travel"}, SettingsImportMerge)
+	assert.Equal(t, []string{"travel"}, create)
+	assert.Equal(t, []string{"study"}, update)
+	assert.Empty(t, remove)
+
+	_, _, remove = planSettingsImport([]string{"work", "study"}, []string{"study", "travel"}, SettingsImportReplace)
+	assert.Equal(t, []string{"work"}, remove)
+}
+
+func TestSettingsImport(t *testing.T) {
+	ctx := context.Background()
+	newService := func(profiles []string) (*SettingsService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "FOR UPDATE"):
+				return []string{"preferences"}, [][]driver.Value{{[]byte(`{"theme":"dark"}`)}}
+			case strings.Contains(query, "RETURNING preferences_version"):
+				return []string{"preferences_version"}, [][]driver.Value{{int64(4)}}
+			case strings.Contains(query, "enhancement_profiles"):
+				values := make([][]driver.Value, len(profiles))
+				for i, name := range profiles {
+					values[i] = []driver.Value{name}
+				}
+				return []string{"name"}, values
+			default:
+				return []string{"name"}, [][]driver.Value{{"Bug report"}}
+			}
+		}
+		dbService := NewDatabaseService(db.DB)
+		return NewSettingsService(dbService, NewEnhancementProfileService(dbService), logrus.New()), d
+	}
+	bundle := func() *SettingsBundle {
+		return &SettingsBundle{
+			Version:   1,
+			Profiles:  []*EnhancementProfile{{Name: "study"}, {Name: "travel"}},
+			Templates: []*UserTemplate{{Name: "Standup", Technique: "structured_output", TemplateText: "Yesterday, today, blockers"}},
+		}
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		settings, d := newService([]string{"work", "study"})
+		result, err := settings.Import(ctx, "user-1", bundle(), SettingsImportReplace)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), result.PreferencesVersion)
+		assert.Equal(t, SettingsImportCounts{Created: 1, Updated: 1, Deleted: 1}, result.Profiles)
+		assert.Equal(t, SettingsImportCounts{Created: 1, Deleted: 1}, result.Templates)
+
+		entries := d.entries()
+		assert.Equal(t, "BEGIN", entries[0])
+		assert.Equal(t, "COMMIT", entries[len(entries)-1])
+		assert.Contains(t, entries, "DELETE FROM prompts.enhancement_profiles WHERE user_id = $1 AND name = ANY($2)")
+		assert.Contains(t, entries, "DELETE FROM prompts.templates WHERE created_by = $1 AND name = ANY($2)")
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		settings, d := newService([]string{"work"})
+		result, err := settings.Import(ctx, "user-1", bundle(), SettingsImportMerge)
+		require.NoError(t, err)
+		assert.Equal(t, SettingsImportCounts{Created: 2}, result.Profiles)
+		for _, entry := range d.entries() {
+			assert.False(t, strings.HasPrefix(entry, "DELETE"), entry)
+		}
+	})
+
+	t.Run("profile limit", func(t *testing.T) {
+		existing := make([]string, MaxEnhancementProfiles)
+		for i := range existing {
+			existing[i] = strings.Repeat("p", i+1)
+		}
+		settings, d := newService(existing)
+		_, err := settings.Import(ctx, "user-1", bundle(), SettingsImportMerge)
+		assert.ErrorIs(t, err, ErrTooManyProfiles)
+		assert.Equal(t, "ROLLBACK", d.entries()[len(d.entries())-1])
+	})
+
+	t.Run("invalid bundle touches nothing", func(t *testing.T) {
+		settings, d := newService(nil)
+		_, err := settings.Import(ctx, "user-1", &SettingsBundle{}, SettingsImportMerge)
+		var bundleErr *SettingsBundleError
+		assert.ErrorAs(t, err, &bundleErr)
+		assert.Empty(t, d.entries())
+	})
+}
+
+func TestTemplateSlug(t *testing.T) {
+	slug, err := templateSlug("Bug report: Crash!")
+	require.NoError(t, err)
+	assert.Regexp(t, `^bug-report-crash-[0-9a-f]{8}$`, slug)
+
+	slug, err = templateSlug("日本語")
+	require.NoError(t, err)
+	assert.Regexp(t, `^template-[0-9a-f]{8}$`, slug)
+}
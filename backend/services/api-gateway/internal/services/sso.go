@@ -0,0 +1,644 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+// SSOProtocolOIDC is the only single sign-on protocol connections support
+const SSOProtocolOIDC = "oidc"
+
+// ssoPasswordHash is stored for users provisioned through single sign-on. It
+// isn't a valid bcrypt hash, so no password ever matches it.
+const ssoPasswordHash = "!sso"
+
+// maxSSODomains caps the email domains one connection claims
+const maxSSODomains = 20
+
+var (
+	// ErrSSOConnectionNotFound is returned when an organization, or an email
+	// domain, has no single sign-on connection
+	ErrSSOConnectionNotFound = errors.New("sso connection not found")
+	// ErrSSODomainTaken is returned when a domain belongs to another
+	// organization's connection, or has accounts outside the organization
+	ErrSSODomainTaken = errors.New("email domain is used outside the organization")
+	// ErrSSOClientSecretRequired is returned when a new connection has no
+	// client secret
+	ErrSSOClientSecretRequired = errors.New("client_secret is required")
+	// ErrSSOLoginFailed is returned when a sign-in can't be completed; the
+	// wrapped error says why
+	ErrSSOLoginFailed = errors.New("single sign-on failed")
+)
+
+// ssoAssignableRoles are the roles a connection's role mapping may grant.
+// Admin is gateway-wide, so an organization's identity provider can never
+// grant it.
+var ssoAssignableRoles = map[string]bool{"user": true, "developer": true}
+
+var ssoDomainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// SSOConfig configures the single sign-on flow
+type SSOConfig struct {
+	CallbackURL string // Registered with providers as the redirect URI
+	SuccessURL  string // Where the browser lands once signed in
+	FailureURL  string // Where the browser lands when sign-in fails, with ?error=
+	StateTTL    time.Duration
+	StateKey    []byte // Signs the state carried through the provider
+}
+
+// LoadSSOConfig reads the single sign-on configuration from the
+// environment. The state is signed with a key derived from secret, the
+// gateway's JWT secret, so a state can never pass for an access token.
+func LoadSSOConfig(secret string) SSOConfig {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ssoStateAudience))
+	appURL := getEnv("APP_URL", "http://localhost:3000")
+	config := SSOConfig{
+		CallbackURL: getEnv("SSO_CALLBACK_URL", appURL+"/api/v1/auth/sso/callback"),
+		SuccessURL:  getEnv("SSO_SUCCESS_URL", appURL+"/"),
+		FailureURL:  getEnv("SSO_FAILURE_URL", appURL+"/login"),
+		StateTTL:    10 * time.Minute,
+		StateKey:    mac.Sum(nil),
+	}
+	if d, err := time.ParseDuration(getEnv("SSO_STATE_TTL", "")); err == nil && d > 0 {
+		config.StateTTL = d
+	}
+	return config
+}
+
+// SSOConnection is an organization's OpenID Connect provider. Members whose
+// email domain is one of Domains sign in through it.
+type SSOConnection struct {
+	OrgID        string            `json:"org_id"`
+	Protocol     string            `json:"protocol"`
+	Issuer       string            `json:"issuer"`
+	ClientID     string            `json:"client_id"`
+	ClientSecret string            `json:"-"`
+	Domains      []string          `json:"domains"`
+	RoleClaim    string            `json:"role_claim"`   // ID token claim holding the user's groups or roles
+	RoleMapping  map[string]string `json:"role_mapping"` // Claim value -> gateway role
+	DefaultRole  string            `json:"default_role"` // Granted when no claim value is mapped
+	Enabled      bool              `json:"enabled"`
+	CreatedBy    string            `json:"created_by,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// NormalizeSSOConnection checks a connection an admin submitted, lowercasing
+// its domains and filling in the defaults
+func NormalizeSSOConnection(conn SSOConnection) (SSOConnection, error) {
+	if conn.Protocol == "" {
+		conn.Protocol = SSOProtocolOIDC
+	}
+	if conn.Protocol != SSOProtocolOIDC {
+		return conn, fmt.Errorf("protocol %q is not supported; only %q connections can be configured", conn.Protocol, SSOProtocolOIDC)
+	}
+
+	conn.Issuer = strings.TrimRight(strings.TrimSpace(conn.Issuer), "/")
+	issuer, err := url.Parse(conn.Issuer)
+	if err != nil || issuer.Host == "" || issuer.RawQuery != "" || issuer.Fragment != "" {
+		return conn, errors.New("issuer must be an absolute URL")
+	}
+	if issuer.Scheme != "https" && !(issuer.Scheme == "http" && isLoopbackHost(issuer.Hostname())) {
+		return conn, errors.New("issuer must use https")
+	}
+	conn.ClientID = strings.TrimSpace(conn.ClientID)
+	if conn.ClientID == "" {
+		return conn, errors.New("client_id is required")
+	}
+
+	seen := make(map[string]bool, len(conn.Domains))
+	domains := make([]string, 0, len(conn.Domains))
+	for _, domain := range conn.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if !ssoDomainPattern.MatchString(domain) {
+			return conn, fmt.Errorf("invalid email domain %q", domain)
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		return conn, errors.New("at least one email domain is required")
+	}
+	if len(domains) > maxSSODomains {
+		return conn, fmt.Errorf("at most %d email domains can be claimed", maxSSODomains)
+	}
+	sort.Strings(domains)
+	conn.Domains = domains
+
+	conn.RoleClaim = strings.TrimSpace(conn.RoleClaim)
+	if conn.RoleClaim == "" {
+		conn.RoleClaim = "groups"
+	}
+	if conn.DefaultRole == "" {
+		conn.DefaultRole = "user"
+	}
+	if !ssoAssignableRoles[conn.DefaultRole] {
+		return conn, fmt.Errorf("default_role %q can't be granted through single sign-on", conn.DefaultRole)
+	}
+	for value, role := range conn.RoleMapping {
+		if !ssoAssignableRoles[role] {
+			return conn, fmt.Errorf("role %q mapped from %q can't be granted through single sign-on", role, value)
+		}
+	}
+	if conn.RoleMapping == nil {
+		conn.RoleMapping = map[string]string{}
+	}
+	return conn, nil
+}
+
+func isLoopbackHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// EmailDomain returns the lowercased domain of an email address
+func EmailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+// SSOIdentity is who a provider signed in
+type SSOIdentity struct {
+	OrgID     string
+	Subject   string
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string // Values of the connection's role claim
+}
+
+// roles maps the identity's groups to gateway roles through the connection's
+// role mapping, falling back to its default role. Roles that can't be granted
+// through single sign-on, kept by connections saved before they were
+// rejected, are dropped.
+func (conn *SSOConnection) roles(groups []string) []string {
+	seen := map[string]bool{}
+	var roles []string
+	for _, group := range groups {
+		if role, ok := conn.RoleMapping[group]; ok && ssoAssignableRoles[role] && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		if !ssoAssignableRoles[conn.DefaultRole] {
+			return []string{"user"}
+		}
+		return []string{conn.DefaultRole}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// SSOService stores organizations' single sign-on connections, runs the
+// OpenID Connect authorization code flow against them and provisions the
+// users they sign in
+type SSOService struct {
+	db       *DatabaseService
+	config   SSOConfig
+	oidc     *oidcClient
+	accounts *AccountResolver // Optional; cached organizations expire on their own when nil
+	logger   *logrus.Logger
+}
+
+// NewSSOService creates a new single sign-on service
+func NewSSOService(db *DatabaseService, config SSOConfig, accounts *AccountResolver, logger *logrus.Logger) *SSOService {
+	return &SSOService{
+		db:       db,
+		config:   config,
+		oidc:     newOIDCClient(&http.Client{Timeout: 10 * time.Second}),
+		accounts: accounts,
+		logger:   logger,
+	}
+}
+
+// Config returns the service's configuration
+func (s *SSOService) Config() SSOConfig {
+	return s.config
+}
+
+const ssoConnectionColumns = `org_id, protocol, issuer, client_id, client_secret, role_claim, role_mapping,
+	default_role, enabled, COALESCE(created_by::text, ''), created_at, updated_at`
+
+// scanSSOConnection reads a row selected with ssoConnectionColumns, without
+// its domains
+func scanSSOConnection(row interface{ Scan(...interface{}) error }) (*SSOConnection, error) {
+	conn := &SSOConnection{}
+	var mappingJSON []byte
+	err := row.Scan(&conn.OrgID, &conn.Protocol, &conn.Issuer, &conn.ClientID, &conn.ClientSecret,
+		&conn.RoleClaim, &mappingJSON, &conn.DefaultRole, &conn.Enabled, &conn.CreatedBy,
+		&conn.CreatedAt, &conn.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(mappingJSON, &conn.RoleMapping); err != nil || conn.RoleMapping == nil {
+		conn.RoleMapping = map[string]string{}
+	}
+	return conn, nil
+}
+
+// GetConnection returns an organization's connection
+func (s *SSOService) GetConnection(ctx context.Context, orgID string) (*SSOConnection, error) {
+	conn, err := scanSSOConnection(s.db.DB.QueryRowContext(ctx,
+		`SELECT `+ssoConnectionColumns+` FROM auth.sso_connections WHERE org_id = $1`, orgID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSSOConnectionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sso connection: %w", err)
+	}
+	if err := s.loadDomains(ctx, conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ConnectionForEmail returns the enabled connection claiming an email
+// address's domain
+func (s *SSOService) ConnectionForEmail(ctx context.Context, email string) (*SSOConnection, error) {
+	conn, err := scanSSOConnection(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+ssoConnectionColumns+`
+		FROM auth.sso_connections
+		WHERE enabled AND org_id = (SELECT org_id FROM auth.sso_domains WHERE domain = $1)`, EmailDomain(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSSOConnectionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sso connection: %w", err)
+	}
+	if err := s.loadDomains(ctx, conn); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *SSOService) loadDomains(ctx context.Context, conn *SSOConnection) error {
+	rows, err := s.db.DB.QueryContext(ctx,
+		`SELECT domain FROM auth.sso_domains WHERE org_id = $1 ORDER BY domain`, conn.OrgID)
+	if err != nil {
+		return fmt.Errorf("failed to get sso domains: %w", err)
+	}
+	defer rows.Close()
+	conn.Domains = []string{}
+	for rows.Next() {
+		var domain string
+		if err := rows.Scan(&domain); err != nil {
+			return fmt.Errorf("failed to scan sso domain: %w", err)
+		}
+		conn.Domains = append(conn.Domains, domain)
+	}
+	return rows.Err()
+}
+
+// SaveConnection creates or replaces an organization's connection. conn
+// must have been normalized; an empty client secret keeps the stored one.
+// A domain can only be claimed while every account using it belongs to the
+// organization, so a connection can't take over other people's logins.
+func (s *SSOService) SaveConnection(ctx context.Context, conn SSOConnection) (*SSOConnection, error) {
+	mappingJSON, err := json.Marshal(conn.RoleMapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal role mapping: %w", err)
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if conn.ClientSecret == "" {
+		err := tx.QueryRowContext(ctx,
+			`SELECT client_secret FROM auth.sso_connections WHERE org_id = $1 FOR UPDATE`, conn.OrgID).Scan(&conn.ClientSecret)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSSOClientSecretRequired
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sso connection: %w", err)
+		}
+	}
+
+	var outsider string
+	err = tx.QueryRowContext(ctx, `
+		SELECT LOWER(split_part(email, '@', 2))
+		FROM auth.users
+		WHERE LOWER(split_part(email, '@', 2)) = ANY($1)
+		  AND COALESCE(metadata->>'org_id', '') <> $2
+		LIMIT 1`, pq.Array(conn.Domains), conn.OrgID).Scan(&outsider)
+	if err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSSODomainTaken, outsider)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to check sso domains: %w", err)
+	}
+
+	saved, err := scanSSOConnection(tx.QueryRowContext(ctx, `
+		INSERT INTO auth.sso_connections (org_id, protocol, issuer, client_id, client_secret, role_claim,
+			role_mapping, default_role, enabled, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, '')::uuid)
+		ON CONFLICT (org_id) DO UPDATE SET
+			protocol = EXCLUDED.protocol, issuer = EXCLUDED.issuer, client_id = EXCLUDED.client_id,
+			client_secret = EXCLUDED.client_secret, role_claim = EXCLUDED.role_claim,
+			role_mapping = EXCLUDED.role_mapping, default_role = EXCLUDED.default_role,
+			enabled = EXCLUDED.enabled, updated_at = CURRENT_TIMESTAMP
+		RETURNING `+ssoConnectionColumns,
+		conn.OrgID, conn.Protocol, conn.Issuer, conn.ClientID, conn.ClientSecret, conn.RoleClaim,
+		mappingJSON, conn.DefaultRole, conn.Enabled, conn.CreatedBy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save sso connection: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.sso_domains WHERE org_id = $1`, conn.OrgID); err != nil {
+		return nil, fmt.Errorf("failed to replace sso domains: %w", err)
+	}
+	for _, domain := range conn.Domains {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO auth.sso_domains (domain, org_id) VALUES ($1, $2)`, domain, conn.OrgID); err != nil {
+			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+				return nil, fmt.Errorf("%w: %s", ErrSSODomainTaken, domain)
+			}
+			return nil, fmt.Errorf("failed to claim sso domain: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit sso connection: %w", err)
+	}
+	saved.Domains = conn.Domains
+	return saved, nil
+}
+
+// DeleteConnection removes an organization's connection. Its users keep
+// their accounts, and sign in with a password once they set one.
+func (s *SSOService) DeleteConnection(ctx context.Context, orgID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM auth.sso_connections WHERE org_id = $1`, orgID)
+	if err != nil {
+		return fmt.Errorf("failed to delete sso connection: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrSSOConnectionNotFound
+	}
+	return nil
+}
+
+// ManagedConnection returns the enabled connection a user must sign in
+// through instead of a password: the one they were provisioned or linked by,
+// or else the one claiming their email domain. userID is empty for signups.
+func (s *SSOService) ManagedConnection(ctx context.Context, userID, email string) (*SSOConnection, error) {
+	conn, err := scanSSOConnection(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+ssoConnectionColumns+`
+		FROM auth.sso_connections
+		WHERE enabled AND org_id IN (
+			SELECT org_id FROM auth.sso_identities WHERE user_id = NULLIF($1, '')::uuid
+			UNION ALL
+			SELECT org_id FROM auth.sso_domains WHERE domain = $2
+		)
+		ORDER BY org_id
+		LIMIT 1`, userID, EmailDomain(email)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSSOConnectionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up sso connection: %w", err)
+	}
+	return conn, nil
+}
+
+// AuthorizationURL starts a sign-in through conn, returning where to send
+// the browser and the state the callback must come back with
+func (s *SSOService) AuthorizationURL(ctx context.Context, conn *SSOConnection, loginHint string) (string, string, error) {
+	provider, err := s.oidc.provider(ctx, conn.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := signSSOState(s.config.StateKey, ssoState{OrgID: conn.OrgID, Nonce: nonce}, s.config.StateTTL)
+	if err != nil {
+		return "", "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", conn.ClientID)
+	query.Set("redirect_uri", s.config.CallbackURL)
+	query.Set("scope", "openid email profile")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	if loginHint != "" {
+		query.Set("login_hint", loginHint)
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + query.Encode(), state, nil
+}
+
+// CompleteLogin finishes a sign-in: it checks the state, redeems the code
+// and verifies the ID token, then provisions or updates the user it names.
+// Failures wrap ErrSSOLoginFailed.
+func (s *SSOService) CompleteLogin(ctx context.Context, state, code string) (string, *SSOIdentity, error) {
+	claims, err := parseSSOState(s.config.StateKey, state)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrSSOLoginFailed, err)
+	}
+	conn, err := s.GetConnection(ctx, claims.OrgID)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrSSOLoginFailed, err)
+	}
+	if !conn.Enabled {
+		return "", nil, fmt.Errorf("%w: connection is disabled", ErrSSOLoginFailed)
+	}
+
+	identity, err := s.oidc.authenticate(ctx, conn, code, s.config.CallbackURL, claims.Nonce)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrSSOLoginFailed, err)
+	}
+	// The provider vouches for its own domains only; anything else could
+	// take over an unrelated account with the same email
+	domain := EmailDomain(identity.Email)
+	claimed := false
+	for _, d := range conn.Domains {
+		claimed = claimed || d == domain
+	}
+	if !claimed {
+		return "", nil, fmt.Errorf("%w: email domain %q is not claimed by the organization", ErrSSOLoginFailed, domain)
+	}
+
+	userID, err := s.provision(ctx, conn, identity)
+	if err != nil {
+		return "", nil, err
+	}
+	return userID, identity, nil
+}
+
+// provision finds the user an identity belongs to, by its subject or else by
+// email, creating them on first sign-in. The user joins the organization and
+// their roles are replaced with the ones the provider's groups map to.
+func (s *SSOService) provision(ctx context.Context, conn *SSOConnection, identity *SSOIdentity) (string, error) {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id FROM auth.sso_identities WHERE org_id = $1 AND subject = $2`,
+		conn.OrgID, identity.Subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		var userOrg string
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, COALESCE(metadata->>'org_id', '') FROM auth.users WHERE LOWER(email) = $1 FOR UPDATE`,
+			identity.Email).Scan(&userID, &userOrg)
+		if err == nil && userOrg != "" && userOrg != conn.OrgID {
+			return "", fmt.Errorf("%w: account belongs to another organization", ErrSSOLoginFailed)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			userID, err = s.createUser(ctx, tx, identity)
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to provision sso user: %w", err)
+	}
+
+	roles := conn.roles(identity.Groups)
+	_, err = tx.ExecContext(ctx, `
+		UPDATE auth.users
+		SET roles = $2,
+			metadata = jsonb_set(COALESCE(metadata, '{}'::jsonb), '{org_id}', to_jsonb($3::text)),
+			is_verified = true,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, userID, pq.Array(roles), conn.OrgID)
+	if err != nil {
+		return "", fmt.Errorf("failed to update sso user: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth.sso_identities (org_id, subject, user_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (org_id, subject) DO UPDATE SET last_login_at = CURRENT_TIMESTAMP`,
+		conn.OrgID, identity.Subject, userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to link sso identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("failed to commit sso user: %w", err)
+	}
+	if s.accounts != nil {
+		s.accounts.Forget(userID)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"user_id": userID,
+		"org_id":  conn.OrgID,
+		"roles":   roles,
+	}).Info("Signed in through SSO")
+	return userID, nil
+}
+
+// createUser provisions a verified, password-less user for an identity,
+// with a username taken from their email address
+func (s *SSOService) createUser(ctx context.Context, tx *sql.Tx, identity *SSOIdentity) (string, error) {
+	username, err := availableUsername(ctx, tx, identity.Email)
+	if err != nil {
+		return "", err
+	}
+
+	userID := uuid.New().String()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth.users (
+			id, email, username, password_hash, first_name, last_name,
+			roles, is_active, is_verified, preferences
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), '{user}', true, true, '{}')`,
+		userID, identity.Email, username, ssoPasswordHash, identity.FirstName, identity.LastName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create user: %w", err)
+	}
+	return userID, nil
+}
+
+// availableUsername derives an unclaimed username from an email address's
+// local part, adding a random suffix when it's taken
+func availableUsername(ctx context.Context, tx *sql.Tx, email string) (string, error) {
+	base := ssoUsernameBase(email)
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		var taken bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(username) = $1)
+				OR EXISTS (SELECT 1 FROM auth.username_redirects WHERE username = $1 AND expires_at > CURRENT_TIMESTAMP)`,
+			candidate).Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("failed to check username: %w", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		suffix, err := randomHex(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = base + "-" + suffix
+	}
+	return "", errors.New("no available username")
+}
+
+var ssoUsernameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// ssoUsernameBase turns an email's local part into a valid username,
+// leaving room for a suffix
+func ssoUsernameBase(email string) string {
+	local := email
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		local = email[:at]
+	}
+	base := ssoUsernameInvalid.ReplaceAllString(strings.ToLower(local), "-")
+	base = strings.TrimLeft(base, "_-")
+	if len(base) > maxUsernameLength-7 {
+		base = base[:maxUsernameLength-7]
+	}
+	if ValidateUsername(base) != nil {
+		base = "member-" + base
+		if ValidateUsername(base) != nil {
+			base = "member"
+		}
+	}
+	return base
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
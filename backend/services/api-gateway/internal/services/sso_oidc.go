@@ -0,0 +1,296 @@
+package services
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcDiscoveryTTL is how long a provider's discovery document is kept
+	oidcDiscoveryTTL = time.Hour
+	// oidcKeyRefetchInterval throttles refetching a provider's keys when a
+	// token names one it doesn't know
+	oidcKeyRefetchInterval = time.Minute
+	// oidcClockSkew is tolerated on ID token timestamps
+	oidcClockSkew = time.Minute
+	// oidcMaxResponseSize caps what is read from a provider
+	oidcMaxResponseSize = 1 << 20
+
+	ssoStateAudience = "sso-state"
+)
+
+// oidcProvider is a provider's discovery document and signing keys
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	discovered  time.Time
+	keys        map[string]*rsa.PublicKey // By key ID
+	keysFetched time.Time
+}
+
+// oidcClient talks to OpenID Connect providers, caching their discovery
+// documents and keys by issuer
+type oidcClient struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	providers map[string]*oidcProvider
+}
+
+func newOIDCClient(client *http.Client) *oidcClient {
+	return &oidcClient{
+		client:    client,
+		now:       time.Now,
+		providers: map[string]*oidcProvider{},
+	}
+}
+
+// provider returns an issuer's discovery document, fetching it when it
+// isn't cached or has expired
+func (o *oidcClient) provider(ctx context.Context, issuer string) (*oidcProvider, error) {
+	o.mu.Lock()
+	cached, ok := o.providers[issuer]
+	o.mu.Unlock()
+	if ok && o.now().Sub(cached.discovered) < oidcDiscoveryTTL {
+		return cached, nil
+	}
+
+	provider := &oidcProvider{}
+	if err := o.get(ctx, issuer+"/.well-known/openid-configuration", provider); err != nil {
+		return nil, fmt.Errorf("failed to discover provider: %w", err)
+	}
+	if strings.TrimRight(provider.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("provider reports issuer %q, expected %q", provider.Issuer, issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("provider discovery document is missing endpoints")
+	}
+	provider.Issuer = issuer
+	provider.discovered = o.now()
+
+	o.mu.Lock()
+	o.providers[issuer] = provider
+	o.mu.Unlock()
+	return provider, nil
+}
+
+// key returns a provider's signing key by ID, refetching the key set when
+// the ID is new, as providers rotate keys
+func (o *oidcClient) key(ctx context.Context, provider *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	key, ok := provider.keys[kid]
+	stale := o.now().Sub(provider.keysFetched) >= oidcKeyRefetchInterval
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.get(ctx, provider.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	o.mu.Lock()
+	provider.keys = keys
+	provider.keysFetched = o.now()
+	o.mu.Unlock()
+
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// authenticate redeems an authorization code at conn's provider and
+// verifies the ID token it returns
+func (o *oidcClient) authenticate(ctx context.Context, conn *SSOConnection, code, redirectURI, nonce string) (*SSOIdentity, error) {
+	provider, err := o.provider(ctx, conn.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(conn.ClientID), url.QueryEscape(conn.ClientSecret))
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := o.do(req, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to redeem authorization code: %w", err)
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("provider returned no id_token")
+	}
+	return o.verifyIDToken(ctx, provider, conn, tokens.IDToken, nonce)
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry and
+// nonce, and reads the identity it asserts
+func (o *oidcClient) verifyIDToken(ctx context.Context, provider *oidcProvider, conn *SSOConnection, raw, nonce string) (*SSOIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.key(ctx, provider, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithIssuer(conn.Issuer),
+		jwt.WithAudience(conn.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(oidcClockSkew),
+		jwt.WithTimeFunc(o.now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("id_token nonce doesn't match")
+	}
+
+	identity := &SSOIdentity{OrgID: conn.OrgID}
+	identity.Subject, _ = claims["sub"].(string)
+	email, _ := claims["email"].(string)
+	identity.Email = strings.ToLower(strings.TrimSpace(email))
+	identity.FirstName, _ = claims["given_name"].(string)
+	identity.LastName, _ = claims["family_name"].(string)
+	if identity.Subject == "" || identity.Email == "" {
+		return nil, errors.New("id_token has no subject or email")
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, errors.New("provider hasn't verified the email address")
+	}
+
+	switch groups := claims[conn.RoleClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if s, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	return identity, nil
+}
+
+func (o *oidcClient) get(ctx context.Context, rawURL string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return o.do(req, out)
+}
+
+func (o *oidcClient) do(req *http.Request, out interface{}) error {
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &problem) == nil && problem.Error != "" {
+			return fmt.Errorf("provider returned %d: %s %s", resp.StatusCode, problem.Error, problem.Description)
+		}
+		return fmt.Errorf("provider returned %d", resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// ssoState is carried through the provider and back to the callback, so the
+// callback knows which connection to finish the sign-in with
+type ssoState struct {
+	OrgID string `json:"org"`
+	Nonce string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func signSSOState(key []byte, state ssoState, ttl time.Duration) (string, error) {
+	now := time.Now()
+	state.Audience = jwt.ClaimStrings{ssoStateAudience}
+	state.IssuedAt = jwt.NewNumericDate(now)
+	state.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign sso state: %w", err)
+	}
+	return signed, nil
+}
+
+func parseSSOState(key []byte, raw string) (*ssoState, error) {
+	state := &ssoState{}
+	_, err := jwt.ParseWithClaims(raw, state, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(ssoStateAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state: %w", err)
+	}
+	if state.OrgID == "" || state.Nonce == "" {
+		return nil, errors.New("invalid state")
+	}
+	return state, nil
+}
@@ -0,0 +1,260 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSSOConnection(t *testing.T) {
+	conn, err := NormalizeSSOConnection(SSOConnection{
+		Issuer:      "https://login.acme.test/ ",
+		ClientID:    " gateway ",
+		Domains:     []string{"Acme.test", "@eu.acme.test", "acme.test"},
+		RoleMapping: map[string]string{"eng": "developer"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, SSOProtocolOIDC, conn.Protocol)
+	assert.Equal(t, "https://login.acme.test", conn.Issuer)
+	assert.Equal(t, "gateway", conn.ClientID)
+	assert.Equal(t, []string{"acme.test", "eu.acme.test"}, conn.Domains)
+	assert.Equal(t, "groups", conn.RoleClaim)
+	assert.Equal(t, "user", conn.DefaultRole)
+
+	assert.Equal(t, []string{"developer"}, conn.roles([]string{"sales", "eng", "eng"}))
+	assert.Equal(t, []string{"user"}, conn.roles(nil))
+
+	// Connections stored while admin could be mapped no longer grant it
+	stored := SSOConnection{RoleMapping: map[string]string{"ops": "admin", "eng": "developer"}, DefaultRole: "admin"}
+	assert.Equal(t, []string{"developer"}, stored.roles([]string{"ops", "eng"}))
+	assert.Equal(t, []string{"user"}, stored.roles([]string{"ops"}))
+
+	valid := SSOConnection{Issuer: "https://login.acme.test", ClientID: "gateway", Domains: []string{"acme.test"}}
+	for name, change := range map[string]func(*SSOConnection){
+		"saml":             func(c *SSOConnection) { c.Protocol = "saml" },
+		"plain http":       func(c *SSOConnection) { c.Issuer = "http://login.acme.test" },
+		"no client":        func(c *SSOConnection) { c.ClientID = " " },
+		"no domains":       func(c *SSOConnection) { c.Domains = nil },
+		"bad domain":       func(c *SSOConnection) { c.Domains = []string{"acme"} },
+		"mapped to root":   func(c *SSOConnection) { c.RoleMapping = map[string]string{"ops": "superuser"} },
+		"mapped to admin":  func(c *SSOConnection) { c.RoleMapping = map[string]string{"ops": "admin"} },
+		"admin by default": func(c *SSOConnection) { c.DefaultRole = "admin" },
+	} {
+		conn := valid
+		change(&conn)
+		_, err := NormalizeSSOConnection(conn)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestSSOUsernameBase(t *testing.T) {
+	assert.Equal(t, "jane-doe", ssoUsernameBase("Jane.Doe@acme.test"))
+	assert.Equal(t, "member-ab", ssoUsernameBase("ab@acme.test"))
+	assert.Equal(t, "member-admin", ssoUsernameBase("admin@acme.test"))
+	assert.Len(t, ssoUsernameBase(strings.Repeat("a", 80)+"@acme.test"), maxUsernameLength-7)
+}
+
+func TestSSOState(t *testing.T) {
+	key := LoadSSOConfig("secret").StateKey
+	state, err := signSSOState(key, ssoState{OrgID: "acme", Nonce: "n-1"}, time.Minute)
+	require.NoError(t, err)
+
+	parsed, err := parseSSOState(key, state)
+	require.NoError(t, err)
+	assert.Equal(t, "acme", parsed.OrgID)
+	assert.Equal(t, "n-1", parsed.Nonce)
+
+	_, err = parseSSOState(LoadSSOConfig("other").StateKey, state)
+	assert.Error(t, err)
+	expired, err := signSSOState(key, ssoState{OrgID: "acme", Nonce: "n-1"}, -time.Minute)
+	require.NoError(t, err)
+	_, err = parseSSOState(key, expired)
+	assert.Error(t, err)
+}
+
+// testOIDCProvider is an OpenID Connect provider issuing ID tokens for
+// whatever claims the test sets
+type testOIDCProvider struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := &testOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "gateway" || secret != "s3cret" || r.FormValue("code") != "code-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, p.claims)
+		token.Header["kid"] = "k1"
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestSSOCompleteLogin(t *testing.T) {
+	ctx := context.Background()
+	provider := newTestOIDCProvider(t)
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// existingOrg is the organization of the account with the signed-in
+	// email; nil when there is none
+	newService := func(existingOrg *string) (*SSOService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 1 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "FROM auth.sso_connections"):
+				now := time.Now()
+				return []string{"org_id", "protocol", "issuer", "client_id", "client_secret", "role_claim", "role_mapping",
+						"default_role", "enabled", "created_by", "created_at", "updated_at"},
+					[][]driver.Value{{"acme", "oidc", provider.URL, "gateway", "s3cret", "groups",
+						[]byte(`{"eng":"developer"}`), "user", true, "", now, now}}
+			case strings.Contains(query, "FROM auth.sso_domains"):
+				return []string{"domain"}, [][]driver.Value{{"acme.test"}}
+			case strings.Contains(query, "LOWER(email) = $1") && existingOrg != nil:
+				return []string{"id", "org_id"}, [][]driver.Value{{"user-1", *existingOrg}}
+			case strings.Contains(query, "LOWER(username) = $1"):
+				return []string{"taken"}, [][]driver.Value{{false}}
+			}
+			return nil, nil
+		}
+		return NewSSOService(NewDatabaseService(db.DB), LoadSSOConfig("secret"), nil, logger), d
+	}
+	// login starts a sign-in and has the provider sign claims for it
+	login := func(sso *SSOService, claims jwt.MapClaims) (string, *SSOIdentity, error) {
+		conn, err := sso.GetConnection(ctx, "acme")
+		require.NoError(t, err)
+		location, state, err := sso.AuthorizationURL(ctx, conn, "jane@acme.test")
+		require.NoError(t, err)
+		authorize, err := url.Parse(location)
+		require.NoError(t, err)
+		assert.Equal(t, provider.URL+"/authorize", authorize.Scheme+"://"+authorize.Host+authorize.Path)
+		assert.Equal(t, "jane@acme.test", authorize.Query().Get("login_hint"))
+
+		provider.claims = jwt.MapClaims{
+			"iss":            provider.URL,
+			"aud":            "gateway",
+			"sub":            "idp-42",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          authorize.Query().Get("nonce"),
+			"email":          "Jane@acme.test",
+			"email_verified": true,
+			"given_name":     "Jane",
+			"groups":         []string{"eng", "all"},
+		}
+		for k, v := range claims {
+			provider.claims[k] = v
+		}
+		return sso.CompleteLogin(ctx, state, "code-1")
+	}
+
+	t.Run("provisions a new user", func(t *testing.T) {
+		sso, d := newService(nil)
+		userID, identity, err := login(sso, nil)
+		require.NoError(t, err)
+		assert.NotEmpty(t, userID)
+		assert.Equal(t, &SSOIdentity{
+			OrgID:     "acme",
+			Subject:   "idp-42",
+			Email:     "jane@acme.test",
+			FirstName: "Jane",
+			Groups:    []string{"eng", "all"},
+		}, identity)
+
+		entries := d.entries()
+		assert.Equal(t, "COMMIT", entries[len(entries)-1])
+		var created, linked bool
+		for i, entry := range entries {
+			if strings.HasPrefix(entry, "INSERT INTO auth.users") {
+				created = true
+				assert.Equal(t, "jane", d.args[i][2].Value)
+				assert.Equal(t, ssoPasswordHash, d.args[i][3].Value)
+			}
+			if strings.HasPrefix(entry, "UPDATE auth.users SET roles") {
+				assert.Equal(t, "{\"developer\"}", d.args[i][1].Value)
+			}
+			linked = linked || strings.HasPrefix(entry, "INSERT INTO auth.sso_identities")
+		}
+		assert.True(t, created)
+		assert.True(t, linked)
+	})
+
+	t.Run("links an account of the organization", func(t *testing.T) {
+		org := ""
+		sso, d := newService(&org)
+		userID, _, err := login(sso, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", userID)
+		for _, entry := range d.entries() {
+			assert.False(t, strings.HasPrefix(entry, "INSERT INTO auth.users"), entry)
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		existingOrg string
+		claims      jwt.MapClaims
+	}{
+		"another organization's account": {existingOrg: "globex"},
+		"unclaimed domain":               {claims: jwt.MapClaims{"email": "jane@gmail.test"}},
+		"unverified email":               {claims: jwt.MapClaims{"email_verified": false}},
+		"wrong nonce":                    {claims: jwt.MapClaims{"nonce": "replayed"}},
+		"wrong audience":                 {claims: jwt.MapClaims{"aud": "someone-else"}},
+		"expired":                        {claims: jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var existing *string
+			if tc.existingOrg != "" {
+				existing = &tc.existingOrg
+			}
+			sso, _ := newService(existing)
+			_, _, err := login(sso, tc.claims)
+			assert.True(t, errors.Is(err, ErrSSOLoginFailed), "%v", err)
+		})
+	}
+}
@@ -0,0 +1,461 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrObjectNotFound is returned for keys that aren't in storage
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectStorage stores user uploads such as avatars. Objects are private and
+// handed out through short-lived signed URLs.
+type ObjectStorage interface {
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error) // ErrObjectNotFound for missing objects
+	Delete(ctx context.Context, key string) error
+	SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// signingSecret returns the secret set in key, falling back to
+// JWT_SECRET_KEY, the gateway's token signing key
+func signingSecret(key string) string {
+	return getEnv(key, os.Getenv("JWT_SECRET_KEY"))
+}
+
+// NewStorageFromEnv creates the backend selected by STORAGE_BACKEND: "local"
+// (the default) or "s3"
+func NewStorageFromEnv() (ObjectStorage, error) {
+	switch backend := getEnv("STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return NewLocalStorage(
+			getEnv("STORAGE_LOCAL_DIR", "./uploads"),
+			getEnv("STORAGE_PUBLIC_URL", "/api/v1/storage"),
+			signingSecret("STORAGE_SIGNING_SECRET"),
+		)
+	case "s3":
+		return NewS3Storage(S3Config{
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// NewExportStorageFromEnv creates the backend warehouse exports are written
+// to, selected by EXPORT_STORAGE_BACKEND: "local" (the default), "s3" or
+// "gcs". GCS is reached through its S3-compatible XML API with HMAC keys.
+func NewExportStorageFromEnv() (ObjectStorage, error) {
+	switch backend := getEnv("EXPORT_STORAGE_BACKEND", "local"); backend {
+	case "local":
+		return NewLocalStorage(
+			getEnv("EXPORT_LOCAL_DIR", "./exports"),
+			"",
+			signingSecret("STORAGE_SIGNING_SECRET"),
+		)
+	case "s3", "gcs":
+		config := S3Config{
+			Bucket:    os.Getenv("EXPORT_BUCKET"),
+			Region:    os.Getenv("EXPORT_REGION"),
+			Endpoint:  os.Getenv("EXPORT_ENDPOINT"),
+			AccessKey: getEnv("EXPORT_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+			SecretKey: getEnv("EXPORT_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		}
+		if backend == "gcs" {
+			if config.Endpoint == "" {
+				config.Endpoint = "https://storage.googleapis.com"
+			}
+			if config.Region == "" {
+				config.Region = "auto"
+			}
+		} else if config.Region == "" {
+			config.Region = "us-east-1"
+		}
+		if config.Bucket == "" {
+			return nil, fmt.Errorf("%s export storage needs EXPORT_BUCKET", backend)
+		}
+		return NewS3Storage(config)
+	default:
+		return nil, fmt.Errorf("unknown export storage backend %q", backend)
+	}
+}
+
+// validObjectKey rejects keys that could escape the storage root
+func validObjectKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalStorage keeps objects on disk, for development and single-node
+// deployments. Signed URLs point at the gateway, which serves the file after
+// checking the signature.
+type LocalStorage struct {
+	dir     string
+	baseURL string
+	secret  []byte
+}
+
+// NewLocalStorage stores objects under dir, signing URLs under baseURL with secret
+func NewLocalStorage(dir, baseURL, secret string) (*LocalStorage, error) {
+	if secret == "" {
+		return nil, errors.New("local storage needs STORAGE_SIGNING_SECRET or JWT_SECRET_KEY")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  []byte(secret),
+	}, nil
+}
+
+// Put writes an object, replacing any existing one
+func (s *LocalStorage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	if !validObjectKey(key) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+
+	// Write then rename so readers never see a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store object: %w", err)
+	}
+	return nil
+}
+
+// Get reads an object's contents
+func (s *LocalStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	if !validObjectKey(key) {
+		return nil, fmt.Errorf("invalid object key %q", key)
+	}
+	body, err := os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return body, nil
+}
+
+// Delete removes an object. Deleting a missing object is not an error.
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	if !validObjectKey(key) {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// SignedURL returns a gateway URL for the object that stops working after expiry
+func (s *LocalStorage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if !validObjectKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.signature(key, expires)},
+	}
+	return s.baseURL + "/" + key + "?" + query.Encode(), nil
+}
+
+// Open returns the path of an object after checking a signed URL's expires
+// and signature parameters
+func (s *LocalStorage) Open(key, expires, signature string) (string, error) {
+	if !validObjectKey(key) || !hmac.Equal([]byte(signature), []byte(s.signature(key, expires))) {
+		return "", ErrObjectNotFound
+	}
+	if unix, err := strconv.ParseInt(expires, 10, 64); err != nil || time.Now().Unix() > unix {
+		return "", ErrObjectNotFound
+	}
+
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if _, err := os.Stat(path); err != nil {
+		return "", ErrObjectNotFound
+	}
+	return path, nil
+}
+
+func (s *LocalStorage) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// S3Config configures an S3-compatible bucket
+type S3Config struct {
+	Bucket    string
+	Region    string
+	Endpoint  string // Defaults to AWS; set for MinIO and other compatible stores
+	AccessKey string
+	SecretKey string
+}
+
+// S3Storage keeps objects in an S3-compatible bucket, addressed path-style
+// and signed with AWS Signature Version 4
+type S3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Storage creates an S3 backend
+func NewS3Storage(config S3Config) (*S3Storage, error) {
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("s3 storage needs S3_BUCKET, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+	}
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	return &S3Storage{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (s *S3Storage) objectPath(key string) string {
+	return s.endpoint.Path + "/" + s.config.Bucket + "/" + key
+}
+
+// Put uploads an object
+func (s *S3Storage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 put failed with status %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// Get downloads an object
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("s3 get failed with status %d: %s", resp.StatusCode, detail)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read s3 object: %w", err)
+	}
+	return body, nil
+}
+
+// Delete removes an object. S3 treats deleting a missing object as success.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("s3 delete failed with status %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// SignedURL returns a presigned GET URL for the object
+func (s *S3Storage) SignedURL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if !validObjectKey(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	signer := sigV4{region: s.config.Region, accessKey: s.config.AccessKey, secretKey: s.config.SecretKey}
+	query := signer.presign(http.MethodGet, s.endpoint.Host, s.objectPath(key), time.Now(), expiry)
+	return s.endpoint.Scheme + "://" + s.endpoint.Host + awsURIEncode(s.objectPath(key), false) + "?" + query, nil
+}
+
+func (s *S3Storage) do(ctx context.Context, method, key string, body []byte, contentType string) (*http.Response, error) {
+	if !validObjectKey(key) {
+		return nil, fmt.Errorf("invalid object key %q", key)
+	}
+
+	path := s.objectPath(key)
+	req, err := http.NewRequestWithContext(ctx, method,
+		s.endpoint.Scheme+"://"+s.endpoint.Host+awsURIEncode(path, false), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	signer := sigV4{region: s.config.Region, accessKey: s.config.AccessKey, secretKey: s.config.SecretKey}
+	signer.sign(req, path, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// sigV4 signs AWS requests with Signature Version 4
+type sigV4 struct {
+	region    string
+	accessKey string
+	secretKey string
+	service   string // Defaults to s3
+}
+
+func (v sigV4) serviceName() string {
+	if v.service == "" {
+		return "s3"
+	}
+	return v.service
+}
+
+func (v sigV4) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + v.region + "/" + v.serviceName() + "/aws4_request"
+}
+
+func (v sigV4) signature(t time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + v.scope(t) + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+v.secretKey), t.Format("20060102"))
+	key = hmacSHA256(key, v.region)
+	key = hmacSHA256(key, v.serviceName())
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// sign adds the Authorization header for a request to path
+func (v sigV4) sign(req *http.Request, path string, body []byte, now time.Time) {
+	t := now.UTC()
+	payloadHash := sha256.Sum256(body)
+	payload := hex.EncodeToString(payloadHash[:])
+
+	req.Header.Set("X-Amz-Date", t.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(path, false),
+		"",
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payload + "\n" +
+			"x-amz-date:" + t.Format("20060102T150405Z") + "\n",
+		signedHeaders,
+		payload,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		v.accessKey, v.scope(t), signedHeaders, v.signature(t, canonicalRequest)))
+}
+
+// presign returns the query string of a presigned URL for path on host
+func (v sigV4) presign(method, host, path string, now time.Time, expiry time.Duration) string {
+	t := now.UTC()
+	params := map[string]string{
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    v.accessKey + "/" + v.scope(t),
+		"X-Amz-Date":          t.Format("20060102T150405Z"),
+		"X-Amz-Expires":       strconv.Itoa(int(expiry.Seconds())),
+		"X-Amz-SignedHeaders": "host",
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = awsURIEncode(name, true) + "=" + awsURIEncode(params[name], true)
+	}
+	query := strings.Join(pairs, "&")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		awsURIEncode(path, false),
+		query,
+		"host:" + host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	return query + "&X-Amz-Signature=" + v.signature(t, canonicalRequest)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and
+// slashes too when encodeSlash is set, as SigV4 canonicalization requires
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance is how old a webhook's signed timestamp may be,
+// which bounds replays of captured deliveries
+const stripeSignatureTolerance = 5 * time.Minute
+
+// ErrInvalidStripeSignature is returned for webhooks that weren't signed
+// with the endpoint's secret, or were signed too long ago
+var ErrInvalidStripeSignature = errors.New("invalid stripe signature")
+
+// stripeClient calls the Stripe REST API, which takes form-encoded bodies
+// and answers with JSON
+type stripeClient struct {
+	baseURL   string
+	secretKey string
+	client    *http.Client
+}
+
+// stripeErrorBody is the error envelope Stripe answers failures with
+type stripeErrorBody struct {
+	Error struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// post creates or updates a resource. Requests with an idempotency key can
+// be retried without doing the work twice.
+func (c *stripeClient) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	return c.do(req, out)
+}
+
+// get reads a resource or list
+func (c *stripeClient) get(ctx context.Context, path string, query url.Values, out interface{}) error {
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+func (c *stripeClient) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+c.secretKey)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return transportError("stripe", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		var stripeErr stripeErrorBody
+		if json.Unmarshal(body, &stripeErr) == nil && stripeErr.Error.Message != "" {
+			body = []byte(stripeErr.Error.Message)
+		}
+		return statusError("stripe", resp, body)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode stripe response: %w", err)
+	}
+	return nil
+}
+
+// VerifyStripeSignature checks a webhook's Stripe-Signature header, which
+// holds the signing time and one or more HMAC-SHA256 signatures of
+// "<time>.<payload>" keyed by the endpoint's secret
+func VerifyStripeSignature(payload []byte, header, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidStripeSignature
+	}
+	if age := now.Sub(time.Unix(signedAt, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return ErrInvalidStripeSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if decoded, err := hex.DecodeString(signature); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidStripeSignature
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TechniqueAffinityWindowDays is how far back history counts towards
+	// a user's technique affinity
+	TechniqueAffinityWindowDays = 90
+
+	// AffinityWeightRange is how far a weight strays from 1: an affinity of
+	// -1 halves a technique's selector score and +1 adds half again
+	AffinityWeightRange = 0.5
+
+	// rerunAffinitySignal is what rerunning a prompt, which reapplies its
+	// techniques, counts for each of them. A rating counts (score-3)/2.
+	rerunAffinitySignal = 0.5
+
+	// affinityPriorSignals pulls scores backed by few signals towards 0
+	affinityPriorSignals = 2
+
+	// techniqueAffinityTimeout bounds a single recomputation
+	techniqueAffinityTimeout = 10 * time.Minute
+)
+
+// TechniqueAffinity is how much a user appears to like a technique
+type TechniqueAffinity struct {
+	Technique string  `json:"technique"`
+	Score     float64 `json:"score"`  // -1 to 1
+	Weight    float64 `json:"weight"` // Multiplier applied to the technique's selector score
+	Rated     int     `json:"rated"`  // Rated enhancements that used the technique
+	Reruns    int     `json:"reruns"` // Reruns that reapplied the technique
+}
+
+// TechniqueAffinityReport is what has been learned about a user's
+// technique preferences
+type TechniqueAffinityReport struct {
+	Techniques []*TechniqueAffinity `json:"techniques"`
+	ComputedAt *time.Time           `json:"computed_at,omitempty"`
+	ResetAt    *time.Time           `json:"reset_at,omitempty"`
+}
+
+// TechniqueAffinityService learns per-user technique affinity from
+// feedback scores and reruns in prompt history. Affinity is recomputed in
+// the background; selection only reads the stored scores.
+type TechniqueAffinityService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewTechniqueAffinityService creates a new technique affinity service
+func NewTechniqueAffinityService(db *DatabaseService, logger *logrus.Logger) *TechniqueAffinityService {
+	return &TechniqueAffinityService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// TechniqueAffinityInterval reads TECHNIQUE_AFFINITY_INTERVAL, defaulting to
+// every six hours. Zero disables scheduled recomputation.
+func TechniqueAffinityInterval() time.Duration {
+	if d, err := time.ParseDuration(getEnv("TECHNIQUE_AFFINITY_INTERVAL", "")); err == nil && d >= 0 {
+		return d
+	}
+	return 6 * time.Hour
+}
+
+// AffinityJob returns the scheduled job that recomputes technique affinity
+func (s *TechniqueAffinityService) AffinityJob(interval time.Duration) ScheduledJob {
+	return ScheduledJob{
+		Name:     "technique_affinity",
+		Interval: interval,
+		Timeout:  techniqueAffinityTimeout,
+		Run:      s.Recompute,
+	}
+}
+
+// AffinityWeight turns an affinity score into a selector weight
+func AffinityWeight(score float64) float64 {
+	return 1 + AffinityWeightRange*score
+}
+
+// Recompute replaces every user's affinity with one computed from their
+// history in the window, leaving out history from before a reset. Users
+// with no signals left in the window lose their affinity.
+func (s *TechniqueAffinityService) Recompute(ctx context.Context) error {
+	start := time.Now()
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// CURRENT_TIMESTAMP is the transaction's start, so rows not upserted
+	// here are exactly the stale ones
+	result, err := tx.ExecContext(ctx, `
+		WITH signals AS (
+			SELECT h.user_id, t.technique,
+				   (h.feedback_score - 3) / 2.0 AS rating,
+				   h.metadata ? 'rerun_from' AS rerun
+			FROM prompts.history h
+			CROSS JOIN LATERAL unnest(h.techniques_used) AS t(technique)
+			LEFT JOIN prompts.technique_affinity_resets r ON r.user_id = h.user_id
+			WHERE h.user_id IS NOT NULL
+			  AND h.created_at >= CURRENT_TIMESTAMP - $1 * INTERVAL '1 day'
+			  AND (r.reset_at IS NULL OR h.created_at > r.reset_at)
+		),
+		totals AS (
+			SELECT user_id, technique,
+				   COALESCE(SUM(rating), 0) + $2 * COUNT(*) FILTER (WHERE rerun) AS signal_sum,
+				   COUNT(rating) AS rated,
+				   COUNT(*) FILTER (WHERE rerun) AS reruns
+			FROM signals
+			GROUP BY user_id, technique
+		)
+		INSERT INTO prompts.technique_affinity (user_id, technique, score, rated, reruns, computed_at)
+		SELECT user_id, technique,
+			   GREATEST(-1, LEAST(1, signal_sum / (rated + reruns + $3))),
+			   rated, reruns, CURRENT_TIMESTAMP
+		FROM totals
+		WHERE rated + reruns > 0
+		ON CONFLICT (user_id, technique) DO UPDATE SET
+			score = EXCLUDED.score,
+			rated = EXCLUDED.rated,
+			reruns = EXCLUDED.reruns,
+			computed_at = EXCLUDED.computed_at`,
+		TechniqueAffinityWindowDays, rerunAffinitySignal, affinityPriorSignals)
+	if err != nil {
+		return fmt.Errorf("failed to compute technique affinity: %w", err)
+	}
+	upserted, _ := result.RowsAffected()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM prompts.technique_affinity WHERE computed_at < CURRENT_TIMESTAMP`); err != nil {
+		return fmt.Errorf("failed to delete stale technique affinity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit technique affinity: %w", err)
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"affinities":  upserted,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}).Info("Technique affinity recomputed")
+	return nil
+}
+
+// GetAffinity returns what has been learned about a user, strongest
+// affinities first
+func (s *TechniqueAffinityService) GetAffinity(ctx context.Context, userID string) (*TechniqueAffinityReport, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT technique, score, rated, reruns, computed_at
+		FROM prompts.technique_affinity
+		WHERE user_id = $1
+		ORDER BY abs(score) DESC, technique`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query technique affinity: %w", err)
+	}
+	defer rows.Close()
+
+	report := &TechniqueAffinityReport{Techniques: []*TechniqueAffinity{}}
+	for rows.Next() {
+		var affinity TechniqueAffinity
+		var computedAt time.Time
+		if err := rows.Scan(&affinity.Technique, &affinity.Score, &affinity.Rated, &affinity.Reruns, &computedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan technique affinity: %w", err)
+		}
+		affinity.Weight = AffinityWeight(affinity.Score)
+		if report.ComputedAt == nil || computedAt.After(*report.ComputedAt) {
+			report.ComputedAt = &computedAt
+		}
+		report.Techniques = append(report.Techniques, &affinity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate technique affinity: %w", err)
+	}
+
+	var resetAt time.Time
+	err = s.db.DB.QueryRowContext(ctx,
+		`SELECT reset_at FROM prompts.technique_affinity_resets WHERE user_id = $1`, userID,
+	).Scan(&resetAt)
+	switch {
+	case err == nil:
+		report.ResetAt = &resetAt
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("failed to get technique affinity reset: %w", err)
+	}
+	return report, nil
+}
+
+// ResetAffinity forgets a user's affinity. History up to now is ignored by
+// later recomputations, so it isn't relearned.
+func (s *TechniqueAffinityService) ResetAffinity(ctx context.Context, userID string) error {
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM prompts.technique_affinity WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete technique affinity: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO prompts.technique_affinity_resets (user_id, reset_at)
+		VALUES ($1, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET reset_at = EXCLUDED.reset_at`, userID); err != nil {
+		return fmt.Errorf("failed to record technique affinity reset: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit technique affinity reset: %w", err)
+	}
+	return nil
+}
+
+// TechniqueWeights returns the selector weights for a user's techniques,
+// empty when nothing has been learned about them
+func (s *TechniqueAffinityService) TechniqueWeights(ctx context.Context, userID string) (map[string]float64, error) {
+	rows, err := s.db.DB.QueryContext(ctx,
+		`SELECT technique, score FROM prompts.technique_affinity WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query technique affinity: %w", err)
+	}
+	defer rows.Close()
+
+	weights := make(map[string]float64)
+	for rows.Next() {
+		var technique string
+		var score float64
+		if err := rows.Scan(&technique, &score); err != nil {
+			return nil, fmt.Errorf("failed to scan technique affinity: %w", err)
+		}
+		weights[technique] = AffinityWeight(score)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate technique affinity: %w", err)
+	}
+	return weights, nil
+}
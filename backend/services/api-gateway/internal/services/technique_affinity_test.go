@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTechniqueWeights(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"technique", "score"}, [][]driver.Value{
+			{"few_shot", 1.0},
+			{"chain_of_thought", -0.5},
+		}
+	}
+	affinity := NewTechniqueAffinityService(NewDatabaseService(db.DB), logrus.New())
+
+	weights, err := affinity.TechniqueWeights(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"few_shot": 1.5, "chain_of_thought": 0.75}, weights)
+}
+
+// staticWeights serves the same weights to every user
+type staticWeights map[string]float64
+
+func (w staticWeights) TechniqueWeights(ctx context.Context, userID string) (map[string]float64, error) {
+	return w, nil
+}
+
+func TestSelectTechniquesSendsWeights(t *testing.T) {
+	var sent []TechniqueSelectionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req TechniqueSelectionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sent = append(sent, req)
+		json.NewEncoder(w).Encode(TechniqueSelectionResponse{Techniques: []SelectedTechnique{{ID: "few_shot"}}})
+	}))
+	defer server.Close()
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	client := &TechniqueSelectorClient{baseURL: server.URL, client: server.Client(), logger: logger}
+	client.SetTechniqueWeights(staticWeights{"few_shot": 1.5})
+
+	ctx := context.Background()
+	_, err := client.SelectTechniques(ctx, models.TechniqueSelectionRequest{Text: "why", UserID: "user-1"})
+	require.NoError(t, err)
+	_, err = client.SelectTechniques(ctx, models.TechniqueSelectionRequest{Text: "why"})
+	require.NoError(t, err)
+
+	require.Len(t, sent, 2)
+	assert.Equal(t, map[string]interface{}{"few_shot": 1.5}, sent[0].Context["technique_weights"])
+	assert.Nil(t, sent[1].Context, "anonymous selections aren't personalized")
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNotModified is returned by conditional fetches when the remote
+// resource still matches the caller's ETag
+var ErrNotModified = errors.New("not modified")
+
+// CatalogTechnique is a technique as listed by the technique selector
+type CatalogTechnique struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// TechniqueList is the technique selector's catalog and its version ETag
+type TechniqueList struct {
+	Techniques []CatalogTechnique `json:"techniques"`
+	Total      int                `json:"total"`
+	Version    string             `json:"version"`
+	ETag       string             `json:"-"`
+}
+
+// TechniqueCatalogSource is implemented by technique selector clients that
+// can list the techniques available for selection
+type TechniqueCatalogSource interface {
+	ListTechniques(ctx context.Context, etag string) (*TechniqueList, error)
+}
+
+// ListTechniques fetches the technique catalog. When etag is set the request
+// is conditional and ErrNotModified is returned if the catalog is unchanged.
+func (c *TechniqueSelectorClient) ListTechniques(ctx context.Context, etag string) (*TechniqueList, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/v1/techniques", nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		httpReq.Header.Set("If-None-Match", etag)
+	}
+	setCallerHeaders(ctx, httpReq)
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("technique selector returned status %d: %s", resp.StatusCode, responseBody)
+	}
+
+	var list TechniqueList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	list.ETag = resp.Header.Get("ETag")
+
+	return &list, nil
+}
@@ -0,0 +1,349 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// Why a preset replaced the technique selector's choice
+const (
+	PresetReasonRequested           = "requested"            // The caller set use_preset
+	PresetReasonSelectorUnavailable = "selector_unavailable" // The selector failed
+	PresetReasonLatencyTarget       = "latency_target"       // The selector was skipped to meet the intent's latency target
+)
+
+// maxPresetTechniques bounds the techniques in one preset
+const maxPresetTechniques = 10
+
+// presetsRefresh is how often each gateway picks up presets changed by
+// admins on other instances
+const presetsRefresh = 30 * time.Second
+
+var (
+	// ErrPresetNotFound is returned for unknown preset IDs
+	ErrPresetNotFound = errors.New("technique preset not found")
+	// ErrPresetExists is returned when the intent and tier already have a preset
+	ErrPresetExists = errors.New("a preset for this intent and tier already exists")
+)
+
+var presetsApplied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "technique_presets_applied_total",
+	Help: "Number of enhancements whose techniques came from an admin preset",
+}, []string{"intent", "reason"})
+
+// TechniquePreset is an admin-defined default technique combination for an
+// intent. A preset with an empty Tier applies to every tier that has no
+// preset of its own.
+type TechniquePreset struct {
+	ID          string    `json:"id"`
+	Intent      string    `json:"intent"`
+	Tier        string    `json:"tier"`
+	Techniques  []string  `json:"techniques"`
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// TechniquesExcluding returns the preset's techniques minus exclude
+func (p *TechniquePreset) TechniquesExcluding(exclude []string) []string {
+	techniques := make([]string, 0, len(p.Techniques))
+	for _, technique := range p.Techniques {
+		if !slices.Contains(exclude, technique) {
+			techniques = append(techniques, technique)
+		}
+	}
+	return techniques
+}
+
+// TechniquePresetUsage counts the enhancements a preset was applied to
+type TechniquePresetUsage struct {
+	PresetID string           `json:"preset_id"`
+	Total    int64            `json:"total"`
+	Reasons  map[string]int64 `json:"reasons"`
+}
+
+// RecordPresetApplied counts a preset standing in for the technique selector
+func RecordPresetApplied(preset *TechniquePreset, reason string) {
+	presetsApplied.WithLabelValues(preset.Intent, reason).Inc()
+}
+
+// NormalizeTechniquePreset trims and lower-cases a preset's intent, tier and
+// techniques, drops duplicate techniques and reports what is missing or
+// malformed
+func NormalizeTechniquePreset(preset *TechniquePreset) error {
+	preset.Intent = strings.ToLower(strings.TrimSpace(preset.Intent))
+	preset.Tier = strings.ToLower(strings.TrimSpace(preset.Tier))
+	preset.Description = strings.TrimSpace(preset.Description)
+	if preset.Intent == "" {
+		return errors.New("intent is required")
+	}
+
+	techniques := make([]string, 0, len(preset.Techniques))
+	for _, technique := range preset.Techniques {
+		technique = strings.ToLower(strings.TrimSpace(technique))
+		if technique == "" {
+			return errors.New("techniques must not be empty strings")
+		}
+		if !slices.Contains(techniques, technique) {
+			techniques = append(techniques, technique)
+		}
+	}
+	if len(techniques) == 0 {
+		return errors.New("at least one technique is required")
+	}
+	if len(techniques) > maxPresetTechniques {
+		return fmt.Errorf("at most %d techniques are allowed", maxPresetTechniques)
+	}
+	preset.Techniques = techniques
+	return nil
+}
+
+// TechniquePresetService stores technique presets. The enabled presets are
+// kept in memory for the enhancement pipeline and reloaded periodically so
+// changes made on other instances are picked up.
+type TechniquePresetService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+
+	mu       sync.RWMutex
+	enabled  map[string]*TechniquePreset // By presetKey
+	loadedAt time.Time
+}
+
+// NewTechniquePresetService creates a new technique preset service
+func NewTechniquePresetService(db *DatabaseService, logger *logrus.Logger) *TechniquePresetService {
+	return &TechniquePresetService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const presetColumns = `id, intent, tier, techniques, description, enabled,
+	COALESCE(updated_by::text, ''), created_at, updated_at`
+
+// ListPresets returns every preset, enabled or not, by intent and tier
+func (s *TechniquePresetService) ListPresets(ctx context.Context) ([]*TechniquePreset, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT `+presetColumns+`
+		FROM prompts.technique_presets
+		ORDER BY intent, tier`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query technique presets: %w", err)
+	}
+	defer rows.Close()
+
+	presets := []*TechniquePreset{}
+	for rows.Next() {
+		preset, err := scanTechniquePreset(rows)
+		if err != nil {
+			return nil, err
+		}
+		presets = append(presets, preset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate technique presets: %w", err)
+	}
+	return presets, nil
+}
+
+// GetPreset returns one preset
+func (s *TechniquePresetService) GetPreset(ctx context.Context, id string) (*TechniquePreset, error) {
+	preset, err := scanTechniquePreset(s.db.DB.QueryRowContext(ctx, `
+		SELECT `+presetColumns+`
+		FROM prompts.technique_presets
+		WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPresetNotFound
+	}
+	return preset, err
+}
+
+// CreatePreset stores a new preset. The preset must have been normalized.
+func (s *TechniquePresetService) CreatePreset(ctx context.Context, preset TechniquePreset, adminID string) (*TechniquePreset, error) {
+	created, err := scanTechniquePreset(s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO prompts.technique_presets (intent, tier, techniques, description, enabled, updated_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid)
+		RETURNING `+presetColumns,
+		preset.Intent, preset.Tier, pq.Array(preset.Techniques), preset.Description, preset.Enabled, adminID,
+	))
+	if err != nil {
+		return nil, presetWriteError(err)
+	}
+
+	s.changed(created, adminID, "Technique preset created")
+	return created, nil
+}
+
+// UpdatePreset replaces a preset. The preset must have been normalized.
+func (s *TechniquePresetService) UpdatePreset(ctx context.Context, id string, preset TechniquePreset, adminID string) (*TechniquePreset, error) {
+	updated, err := scanTechniquePreset(s.db.DB.QueryRowContext(ctx, `
+		UPDATE prompts.technique_presets
+		SET intent = $2, tier = $3, techniques = $4, description = $5, enabled = $6,
+			updated_by = NULLIF($7, '')::uuid, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+presetColumns,
+		id, preset.Intent, preset.Tier, pq.Array(preset.Techniques), preset.Description, preset.Enabled, adminID,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPresetNotFound
+	}
+	if err != nil {
+		return nil, presetWriteError(err)
+	}
+
+	s.changed(updated, adminID, "Technique preset updated")
+	return updated, nil
+}
+
+// DeletePreset removes a preset
+func (s *TechniquePresetService) DeletePreset(ctx context.Context, id, adminID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM prompts.technique_presets WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete technique preset: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrPresetNotFound
+	}
+
+	s.changed(&TechniquePreset{ID: id}, adminID, "Technique preset deleted")
+	return nil
+}
+
+// MatchPreset returns the enabled preset for an intent and tier, falling
+// back to the intent's all-tier preset. It returns nil when there is none or
+// the presets can't be loaded.
+func (s *TechniquePresetService) MatchPreset(ctx context.Context, intent, tier string) *TechniquePreset {
+	enabled := s.current(ctx)
+	if preset, ok := enabled[presetKey(intent, tier)]; ok {
+		return preset
+	}
+	return enabled[presetKey(intent, "")]
+}
+
+// PresetUsage counts the enhancements each preset was applied to since the
+// given time, as recorded in prompt history
+func (s *TechniquePresetService) PresetUsage(ctx context.Context, since time.Time) ([]TechniquePresetUsage, error) {
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT metadata->'technique_preset'->>'id',
+			   COALESCE(metadata->'technique_preset'->>'reason', ''),
+			   COUNT(*)
+		FROM prompts.history
+		WHERE created_at >= $1 AND metadata ? 'technique_preset'
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query preset usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := []TechniquePresetUsage{}
+	for rows.Next() {
+		var presetID, reason string
+		var count int64
+		if err := rows.Scan(&presetID, &reason, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan preset usage: %w", err)
+		}
+		if len(usage) == 0 || usage[len(usage)-1].PresetID != presetID {
+			usage = append(usage, TechniquePresetUsage{PresetID: presetID, Reasons: map[string]int64{}})
+		}
+		last := &usage[len(usage)-1]
+		last.Total += count
+		last.Reasons[reason] += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate preset usage: %w", err)
+	}
+	return usage, nil
+}
+
+// current returns the enabled presets, reloading them when stale. A failed
+// reload keeps serving the last presets loaded.
+func (s *TechniquePresetService) current(ctx context.Context) map[string]*TechniquePreset {
+	s.mu.RLock()
+	enabled, stale := s.enabled, time.Since(s.loadedAt) > presetsRefresh
+	s.mu.RUnlock()
+	if !stale {
+		return enabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.loadedAt) <= presetsRefresh {
+		return s.enabled
+	}
+	s.loadedAt = time.Now()
+
+	presets, err := s.ListPresets(ctx)
+	if err != nil {
+		s.logger.WithError(err).Warn("Failed to refresh technique presets")
+		return s.enabled
+	}
+	s.enabled = make(map[string]*TechniquePreset, len(presets))
+	for _, preset := range presets {
+		if preset.Enabled {
+			s.enabled[presetKey(preset.Intent, preset.Tier)] = preset
+		}
+	}
+	return s.enabled
+}
+
+// changed audits a preset change and has the next match reload the presets
+func (s *TechniquePresetService) changed(preset *TechniquePreset, adminID, message string) {
+	s.mu.Lock()
+	s.loadedAt = time.Time{}
+	s.mu.Unlock()
+
+	s.logger.WithFields(logrus.Fields{
+		"audit":      true,
+		"updated_by": adminID,
+		"preset_id":  preset.ID,
+		"intent":     preset.Intent,
+		"tier":       preset.Tier,
+		"techniques": preset.Techniques,
+		"enabled":    preset.Enabled,
+	}).Info(message)
+}
+
+func presetKey(intent, tier string) string {
+	return intent + "|" + tier
+}
+
+func presetWriteError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrPresetExists
+	}
+	return fmt.Errorf("failed to save technique preset: %w", err)
+}
+
+func scanTechniquePreset(row rowScanner) (*TechniquePreset, error) {
+	var preset TechniquePreset
+	err := row.Scan(
+		&preset.ID, &preset.Intent, &preset.Tier, pq.Array(&preset.Techniques), &preset.Description,
+		&preset.Enabled, &preset.UpdatedBy, &preset.CreatedAt, &preset.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan technique preset: %w", err)
+	}
+	return &preset, nil
+}
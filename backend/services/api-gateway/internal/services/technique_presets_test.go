@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeTechniquePreset(t *testing.T) {
+	preset := TechniquePreset{Intent: " Explanation ", Tier: "FREE", Techniques: []string{"ELI5", "analogies", " eli5 "}}
+	require.NoError(t, NormalizeTechniquePreset(&preset))
+	assert.Equal(t, "explanation", preset.Intent)
+	assert.Equal(t, "free", preset.Tier)
+	assert.Equal(t, []string{"eli5", "analogies"}, preset.Techniques)
+
+	assert.EqualError(t, NormalizeTechniquePreset(&TechniquePreset{Techniques: []string{"eli5"}}), "intent is required")
+	assert.EqualError(t, NormalizeTechniquePreset(&TechniquePreset{Intent: "explanation"}), "at least one technique is required")
+	assert.Error(t, NormalizeTechniquePreset(&TechniquePreset{Intent: "explanation", Techniques: []string{"eli5", " "}}))
+	assert.Error(t, NormalizeTechniquePreset(&TechniquePreset{Intent: "explanation", Techniques: strings.Fields("a b c d e f g h i j k")}))
+}
+
+func TestMatchPreset(t *testing.T) {
+	ctx := context.Background()
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	now := time.Now()
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"id", "intent", "tier", "techniques", "description", "enabled", "updated_by", "created_at", "updated_at"}, [][]driver.Value{
+			{"p-1", "explanation", "", []byte("{step_by_step}"), "", true, "", now, now},
+			{"p-2", "explanation", "free", []byte("{eli5,analogies}"), "", true, "", now, now},
+			{"p-3", "reasoning", "", []byte("{chain_of_thought}"), "", false, "", now, now},
+		}
+	}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	presets := NewTechniquePresetService(NewDatabaseService(db.DB), logger)
+
+	assert.Equal(t, []string{"eli5", "analogies"}, presets.MatchPreset(ctx, "explanation", "free").Techniques)
+	assert.Equal(t, "p-1", presets.MatchPreset(ctx, "explanation", "pro").ID, "tiers without a preset use the all-tier one")
+	assert.Nil(t, presets.MatchPreset(ctx, "reasoning", "free"), "disabled presets don't apply")
+	assert.Nil(t, presets.MatchPreset(ctx, "creative_writing", "free"))
+	assert.Len(t, d.entries(), 1, "presets are loaded once per refresh")
+}
+
+func TestPresetUsage(t *testing.T) {
+	db, d := newRecordingDB(t, func(string) int64 { return 0 })
+	d.rows = func(string) ([]string, [][]driver.Value) {
+		return []string{"id", "reason", "count"}, [][]driver.Value{
+			{"p-1", PresetReasonRequested, int64(5)},
+			{"p-1", PresetReasonSelectorUnavailable, int64(2)},
+			{"p-2", PresetReasonRequested, int64(1)},
+		}
+	}
+	presets := NewTechniquePresetService(NewDatabaseService(db.DB), logrus.New())
+
+	usage, err := presets.PresetUsage(context.Background(), time.Now().AddDate(0, 0, -30))
+	require.NoError(t, err)
+	assert.Equal(t, []TechniquePresetUsage{
+		{PresetID: "p-1", Total: 7, Reasons: map[string]int64{PresetReasonRequested: 5, PresetReasonSelectorUnavailable: 2}},
+		{PresetID: "p-2", Total: 1, Reasons: map[string]int64{PresetReasonRequested: 1}},
+	}, usage)
+}
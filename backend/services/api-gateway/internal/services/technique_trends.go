@@ -0,0 +1,417 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// minFeedbackSamples is how many rated enhancements a technique needs
+	// before its feedback average and correlation are reported
+	minFeedbackSamples = 10
+
+	// MinPublicTechniqueUses is the smallest count shown in public stats.
+	// Anything rarer is dropped or folded into "other" so public numbers
+	// can't be traced back to individual users.
+	MinPublicTechniqueUses = 20
+
+	// techniqueTrendsRefreshTimeout bounds a single materialized view refresh
+	techniqueTrendsRefreshTimeout = 10 * time.Minute
+)
+
+// techniqueTrendViews are the materialized views behind the trend reports
+var techniqueTrendViews = []string{
+	"analytics.technique_usage_weekly",
+	"analytics.enhancement_feedback_weekly",
+}
+
+// TechniqueTrendPoint is a technique's usage in one week
+type TechniqueTrendPoint struct {
+	Week        string  `json:"week"` // Monday of the week, YYYY-MM-DD
+	Uses        int     `json:"uses"`
+	UniqueUsers int     `json:"unique_users"`
+	Share       float64 `json:"share"` // Share of the week's enhancements
+}
+
+// TechniqueIntentUsage is a technique's usage for one intent
+type TechniqueIntentUsage struct {
+	Intent string  `json:"intent"`
+	Uses   int     `json:"uses"`
+	Share  float64 `json:"share"` // Share of the technique's uses
+}
+
+// TechniqueTrend is one technique's usage and feedback over the report window
+type TechniqueTrend struct {
+	Technique string  `json:"technique"`
+	Uses      int     `json:"uses"`
+	Share     float64 `json:"share"` // Share of all enhancements in the window
+
+	// Growth of the last complete week over the one before; nil when the
+	// earlier week had no uses
+	WeekOverWeek *float64 `json:"week_over_week"`
+
+	Rated           int      `json:"rated"`
+	AverageFeedback *float64 `json:"average_feedback"`
+
+	// Point-biserial correlation between using the technique and the
+	// feedback score of rated enhancements, from -1 to 1
+	FeedbackCorrelation *float64 `json:"feedback_correlation"`
+
+	Weekly   []TechniqueTrendPoint  `json:"weekly"`
+	ByIntent []TechniqueIntentUsage `json:"by_intent"`
+
+	scoreSum float64
+}
+
+// TechniqueTrendReport is technique usage trends over recent weeks
+type TechniqueTrendReport struct {
+	Weeks        int               `json:"weeks"`
+	Intent       string            `json:"intent,omitempty"`
+	Enhancements int               `json:"enhancements"`
+	Rated        int               `json:"rated"`
+	Techniques   []*TechniqueTrend `json:"techniques"`
+}
+
+// PublicTechniqueTrend is a TechniqueTrend with absolute counts removed
+type PublicTechniqueTrend struct {
+	Technique           string              `json:"technique"`
+	Share               float64             `json:"share"`
+	WeekOverWeek        *float64            `json:"week_over_week"`
+	AverageFeedback     *float64            `json:"average_feedback"`
+	FeedbackCorrelation *float64            `json:"feedback_correlation"`
+	Weekly              []PublicTrendPoint  `json:"weekly"`
+	ByIntent            []PublicIntentShare `json:"by_intent"`
+}
+
+// PublicTrendPoint is a technique's share of one week's enhancements
+type PublicTrendPoint struct {
+	Week  string  `json:"week"`
+	Share float64 `json:"share"`
+}
+
+// PublicIntentShare is the share of a technique's uses for one intent
+type PublicIntentShare struct {
+	Intent string  `json:"intent"`
+	Share  float64 `json:"share"`
+}
+
+// PublicTechniqueTrendReport is the anonymized trend report served to
+// everyone who isn't an admin
+type PublicTechniqueTrendReport struct {
+	Weeks      int                     `json:"weeks"`
+	Intent     string                  `json:"intent,omitempty"`
+	Techniques []*PublicTechniqueTrend `json:"techniques"`
+}
+
+// TechniqueTrendService reports technique popularity from materialized
+// views refreshed by the job scheduler
+type TechniqueTrendService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewTechniqueTrendService creates a new technique trend service
+func NewTechniqueTrendService(db *DatabaseService, logger *logrus.Logger) *TechniqueTrendService {
+	return &TechniqueTrendService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// TechniqueTrendsRefreshInterval reads TECHNIQUE_TRENDS_REFRESH_INTERVAL,
+// defaulting to hourly. Zero disables scheduled refreshes.
+func TechniqueTrendsRefreshInterval() time.Duration {
+	if d, err := time.ParseDuration(getEnv("TECHNIQUE_TRENDS_REFRESH_INTERVAL", "")); err == nil && d >= 0 {
+		return d
+	}
+	return 1 * time.Hour
+}
+
+// RefreshJob returns the scheduled job that refreshes the trend views
+func (s *TechniqueTrendService) RefreshJob(interval time.Duration) ScheduledJob {
+	return ScheduledJob{
+		Name:     "technique_trends_refresh",
+		Interval: interval,
+		Timeout:  techniqueTrendsRefreshTimeout,
+		Run:      s.Refresh,
+	}
+}
+
+// Refresh recomputes the trend views. Populated views are refreshed
+// concurrently so reports keep being served while it runs.
+func (s *TechniqueTrendService) Refresh(ctx context.Context) error {
+	for _, view := range techniqueTrendViews {
+		populated, err := s.populated(ctx, view)
+		if err != nil {
+			return err
+		}
+
+		query := "REFRESH MATERIALIZED VIEW CONCURRENTLY " + view
+		if !populated {
+			query = "REFRESH MATERIALIZED VIEW " + view
+		}
+		if _, err := s.db.DB.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to refresh %s: %w", view, err)
+		}
+	}
+	return nil
+}
+
+// populated reports whether a materialized view has been refreshed at least
+// once; querying one that hasn't is an error
+func (s *TechniqueTrendService) populated(ctx context.Context, view string) (bool, error) {
+	var populated bool
+	err := s.db.DB.QueryRowContext(ctx, `SELECT relispopulated FROM pg_class WHERE oid = $1::regclass`, view).Scan(&populated)
+	if err != nil {
+		return false, fmt.Errorf("failed to check %s: %w", view, err)
+	}
+	return populated, nil
+}
+
+// GetTrends reports technique usage over the last weeks weeks, optionally
+// limited to one intent, most used first
+func (s *TechniqueTrendService) GetTrends(ctx context.Context, weeks int, intent string) (*TechniqueTrendReport, error) {
+	report := &TechniqueTrendReport{
+		Weeks:      weeks,
+		Intent:     intent,
+		Techniques: []*TechniqueTrend{},
+	}
+
+	for _, view := range techniqueTrendViews {
+		populated, err := s.populated(ctx, view)
+		if err != nil {
+			return nil, err
+		}
+		if !populated {
+			return report, nil
+		}
+	}
+
+	now := time.Now().UTC()
+	since := now.AddDate(0, 0, -7*weeks)
+
+	// Feedback distribution over all enhancements, for the correlations
+	var scoreSum, scoreSqSum float64
+	weekTotals := map[string]int{}
+	totalsQuery := `
+		SELECT week, SUM(enhancements), SUM(rated), SUM(score_sum), SUM(score_sq_sum)
+		FROM analytics.enhancement_feedback_weekly
+		WHERE week > $1 AND ($2 = '' OR intent = $2)
+		GROUP BY week`
+
+	rows, err := s.db.DB.QueryContext(ctx, totalsQuery, since, intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enhancement totals: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var week time.Time
+		var enhancements, rated int
+		var sum, sqSum float64
+		if err := rows.Scan(&week, &enhancements, &rated, &sum, &sqSum); err != nil {
+			return nil, fmt.Errorf("failed to scan enhancement totals: %w", err)
+		}
+		weekTotals[week.Format("2006-01-02")] = enhancements
+		report.Enhancements += enhancements
+		report.Rated += rated
+		scoreSum += sum
+		scoreSqSum += sqSum
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate enhancement totals: %w", err)
+	}
+
+	usageQuery := `
+		SELECT week, technique, intent, uses, unique_users, rated, score_sum
+		FROM analytics.technique_usage_weekly
+		WHERE week > $1 AND ($2 = '' OR intent = $2)
+		ORDER BY week`
+
+	usageRows, err := s.db.DB.QueryContext(ctx, usageQuery, since, intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query technique usage: %w", err)
+	}
+	defer usageRows.Close()
+
+	trends := map[string]*TechniqueTrend{}
+	intents := map[string]map[string]int{}
+	for usageRows.Next() {
+		var week time.Time
+		var technique, rowIntent string
+		var uses, users, rated int
+		var sum float64
+		if err := usageRows.Scan(&week, &technique, &rowIntent, &uses, &users, &rated, &sum); err != nil {
+			return nil, fmt.Errorf("failed to scan technique usage: %w", err)
+		}
+
+		trend, ok := trends[technique]
+		if !ok {
+			trend = &TechniqueTrend{Technique: technique}
+			trends[technique] = trend
+			intents[technique] = map[string]int{}
+		}
+		trend.Uses += uses
+		trend.Rated += rated
+		trend.scoreSum += sum
+		intents[technique][rowIntent] += uses
+
+		// Rows arrive in week order, one per intent
+		key := week.Format("2006-01-02")
+		if n := len(trend.Weekly); n > 0 && trend.Weekly[n-1].Week == key {
+			trend.Weekly[n-1].Uses += uses
+			// Distinct users can't be summed across intents; the largest
+			// intent is a lower bound
+			if users > trend.Weekly[n-1].UniqueUsers {
+				trend.Weekly[n-1].UniqueUsers = users
+			}
+		} else {
+			trend.Weekly = append(trend.Weekly, TechniqueTrendPoint{Week: key, Uses: uses, UniqueUsers: users})
+		}
+	}
+	if err := usageRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate technique usage: %w", err)
+	}
+
+	lastWeek := startOfWeek(now).AddDate(0, 0, -7).Format("2006-01-02")
+	weekBefore := startOfWeek(now).AddDate(0, 0, -14).Format("2006-01-02")
+
+	for technique, trend := range trends {
+		trend.Share = rate(trend.Uses, report.Enhancements)
+		for i := range trend.Weekly {
+			trend.Weekly[i].Share = rate(trend.Weekly[i].Uses, weekTotals[trend.Weekly[i].Week])
+		}
+		trend.WeekOverWeek = weekOverWeek(trend.Weekly, lastWeek, weekBefore)
+
+		if trend.Rated >= minFeedbackSamples {
+			avg := trend.scoreSum / float64(trend.Rated)
+			trend.AverageFeedback = &avg
+			trend.FeedbackCorrelation = pointBiserial(trend.Rated, trend.scoreSum, report.Rated, scoreSum, scoreSqSum)
+		}
+
+		trend.ByIntent = []TechniqueIntentUsage{}
+		for name, uses := range intents[technique] {
+			trend.ByIntent = append(trend.ByIntent, TechniqueIntentUsage{Intent: name, Uses: uses, Share: rate(uses, trend.Uses)})
+		}
+		sort.Slice(trend.ByIntent, func(i, j int) bool {
+			if trend.ByIntent[i].Uses != trend.ByIntent[j].Uses {
+				return trend.ByIntent[i].Uses > trend.ByIntent[j].Uses
+			}
+			return trend.ByIntent[i].Intent < trend.ByIntent[j].Intent
+		})
+
+		report.Techniques = append(report.Techniques, trend)
+	}
+	sort.Slice(report.Techniques, func(i, j int) bool {
+		if report.Techniques[i].Uses != report.Techniques[j].Uses {
+			return report.Techniques[i].Uses > report.Techniques[j].Uses
+		}
+		return report.Techniques[i].Technique < report.Techniques[j].Technique
+	})
+
+	return report, nil
+}
+
+// Anonymize reduces the report to shares and correlations. Techniques,
+// weeks and intents with fewer than minUses uses are dropped, with rare
+// intents folded into "other".
+func (r *TechniqueTrendReport) Anonymize(minUses int) *PublicTechniqueTrendReport {
+	public := &PublicTechniqueTrendReport{
+		Weeks:      r.Weeks,
+		Intent:     r.Intent,
+		Techniques: []*PublicTechniqueTrend{},
+	}
+
+	for _, trend := range r.Techniques {
+		if trend.Uses < minUses {
+			continue
+		}
+
+		out := &PublicTechniqueTrend{
+			Technique:           trend.Technique,
+			Share:               trend.Share,
+			WeekOverWeek:        trend.WeekOverWeek,
+			AverageFeedback:     trend.AverageFeedback,
+			FeedbackCorrelation: trend.FeedbackCorrelation,
+			Weekly:              []PublicTrendPoint{},
+			ByIntent:            []PublicIntentShare{},
+		}
+
+		for _, point := range trend.Weekly {
+			if point.Uses >= minUses {
+				out.Weekly = append(out.Weekly, PublicTrendPoint{Week: point.Week, Share: point.Share})
+			}
+		}
+
+		other := 0
+		for _, usage := range trend.ByIntent {
+			if usage.Uses < minUses {
+				other += usage.Uses
+				continue
+			}
+			out.ByIntent = append(out.ByIntent, PublicIntentShare{Intent: usage.Intent, Share: usage.Share})
+		}
+		if other >= minUses {
+			out.ByIntent = append(out.ByIntent, PublicIntentShare{Intent: "other", Share: rate(other, trend.Uses)})
+		}
+
+		public.Techniques = append(public.Techniques, out)
+	}
+	return public
+}
+
+// startOfWeek returns midnight on the Monday of t's week, matching
+// Postgres date_trunc('week')
+func startOfWeek(t time.Time) time.Time {
+	t = t.UTC()
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, time.UTC)
+}
+
+// weekOverWeek is the relative change in uses from weekBefore to lastWeek
+func weekOverWeek(points []TechniqueTrendPoint, lastWeek, weekBefore string) *float64 {
+	var last, before int
+	for _, point := range points {
+		switch point.Week {
+		case lastWeek:
+			last = point.Uses
+		case weekBefore:
+			before = point.Uses
+		}
+	}
+	if before == 0 {
+		return nil
+	}
+	growth := float64(last-before) / float64(before)
+	return &growth
+}
+
+// pointBiserial correlates using a technique with feedback scores, given
+// the technique's rated count and score sum and the count, sum and sum of
+// squares of all rated enhancements. It is nil when either group is empty
+// or every score is the same.
+func pointBiserial(n1 int, sum1 float64, n int, sum, sqSum float64) *float64 {
+	if n1 <= 0 || n1 >= n {
+		return nil
+	}
+
+	total := float64(n)
+	mean := sum / total
+	variance := sqSum/total - mean*mean
+	if variance <= 0 {
+		return nil
+	}
+
+	mean1 := sum1 / float64(n1)
+	mean0 := (sum - sum1) / float64(n-n1)
+	p := float64(n1) / total
+
+	r := (mean1 - mean0) / math.Sqrt(variance) * math.Sqrt(p*(1-p))
+	r = math.Max(-1, math.Min(1, r))
+	return &r
+}
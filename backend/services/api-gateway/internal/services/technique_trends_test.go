@@ -0,0 +1,97 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartOfWeek(t *testing.T) {
+	monday := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, monday, startOfWeek(monday))
+	assert.Equal(t, monday, startOfWeek(time.Date(2026, 3, 4, 15, 30, 0, 0, time.UTC)))
+	assert.Equal(t, monday, startOfWeek(time.Date(2026, 3, 8, 23, 59, 0, 0, time.UTC)))
+}
+
+func TestWeekOverWeek(t *testing.T) {
+	points := []TechniqueTrendPoint{
+		{Week: "2026-02-16", Uses: 80},
+		{Week: "2026-02-23", Uses: 100},
+		{Week: "2026-03-02", Uses: 5},
+	}
+
+	growth := weekOverWeek(points, "2026-02-23", "2026-02-16")
+	require.NotNil(t, growth)
+	assert.InDelta(t, 0.25, *growth, 1e-9)
+
+	assert.Nil(t, weekOverWeek(points, "2026-02-16", "2026-02-09"), "no uses in the earlier week")
+}
+
+func TestPointBiserial(t *testing.T) {
+	// Scores 5,5,4 with the technique and 2,1,1 without
+	with := []float64{5, 5, 4}
+	without := []float64{2, 1, 1}
+
+	var sum1, sum, sqSum float64
+	for _, s := range with {
+		sum1 += s
+		sum += s
+		sqSum += s * s
+	}
+	for _, s := range without {
+		sum += s
+		sqSum += s * s
+	}
+
+	r := pointBiserial(len(with), sum1, len(with)+len(without), sum, sqSum)
+	require.NotNil(t, r)
+
+	// Pearson correlation of the scores with a 1/0 usage indicator
+	assert.InDelta(t, 5/math.Sqrt(27), *r, 1e-9)
+
+	assert.Nil(t, pointBiserial(0, 0, 6, sum, sqSum), "technique never rated")
+	assert.Nil(t, pointBiserial(6, sum, 6, sum, sqSum), "every rating used the technique")
+	assert.Nil(t, pointBiserial(2, 8, 4, 16, 64), "all scores equal")
+}
+
+func TestTechniqueTrendReportAnonymize(t *testing.T) {
+	growth := 0.5
+	report := &TechniqueTrendReport{
+		Weeks: 4,
+		Techniques: []*TechniqueTrend{
+			{
+				Technique:    "chain_of_thought",
+				Uses:         100,
+				Share:        0.5,
+				WeekOverWeek: &growth,
+				Weekly: []TechniqueTrendPoint{
+					{Week: "2026-02-23", Uses: 90, UniqueUsers: 40, Share: 0.45},
+					{Week: "2026-03-02", Uses: 10, UniqueUsers: 3, Share: 0.05},
+				},
+				ByIntent: []TechniqueIntentUsage{
+					{Intent: "code_generation", Uses: 70, Share: 0.7},
+					{Intent: "analysis", Uses: 15, Share: 0.15},
+					{Intent: "translation", Uses: 15, Share: 0.15},
+				},
+			},
+			{Technique: "few_shot", Uses: 5, Share: 0.025},
+		},
+	}
+
+	public := report.Anonymize(20)
+
+	require.Len(t, public.Techniques, 1)
+	trend := public.Techniques[0]
+	assert.Equal(t, "chain_of_thought", trend.Technique)
+	assert.Equal(t, &growth, trend.WeekOverWeek)
+	assert.Equal(t, []PublicTrendPoint{{Week: "2026-02-23", Share: 0.45}}, trend.Weekly)
+
+	require.Len(t, trend.ByIntent, 2)
+	assert.Equal(t, "code_generation", trend.ByIntent[0].Intent)
+	assert.Equal(t, "other", trend.ByIntent[1].Intent)
+	assert.InDelta(t, 0.3, trend.ByIntent[1].Share, 1e-9)
+}
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tenantSealPrefix starts every value sealed with an organization's data
+// key: bpenc1:<key version>:<base64url org>:<base64 nonce and ciphertext>
+const tenantSealPrefix = "bpenc1:"
+
+var (
+	// ErrTenantKeysDisabled is returned when no master key is configured
+	ErrTenantKeysDisabled = errors.New("tenant encryption is not configured")
+	// ErrTenantNotIsolated is returned for organizations without data keys
+	ErrTenantNotIsolated = errors.New("tenant isolation is not enabled for the organization")
+	// ErrCrossTenantAccess is returned when a value sealed for one
+	// organization is opened for another
+	ErrCrossTenantAccess = errors.New("value belongs to another organization")
+)
+
+// TenantKeyConfig holds the master key organizations' data keys are wrapped
+// with
+type TenantKeyConfig struct {
+	MasterKey []byte        // AES-256 key; tenant isolation is off when empty
+	CacheTTL  time.Duration // How long an organization's current key version is trusted
+}
+
+// LoadTenantKeyConfig reads TENANT_MASTER_KEY, 32 base64-encoded bytes, and
+// TENANT_KEY_CACHE_TTL
+func LoadTenantKeyConfig() (TenantKeyConfig, error) {
+	config := TenantKeyConfig{CacheTTL: time.Minute}
+	if raw := strings.TrimSpace(os.Getenv("TENANT_MASTER_KEY")); raw != "" {
+		key, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(key) != 32 {
+			return config, errors.New("TENANT_MASTER_KEY must be 32 base64-encoded bytes")
+		}
+		config.MasterKey = key
+	}
+	if v, err := time.ParseDuration(os.Getenv("TENANT_KEY_CACHE_TTL")); err == nil && v > 0 {
+		config.CacheTTL = v
+	}
+	return config, nil
+}
+
+// TenantKeyStatus describes an organization's data keys
+type TenantKeyStatus struct {
+	OrgID      string     `json:"org_id"`
+	Isolated   bool       `json:"isolated"`
+	KeyVersion int        `json:"key_version,omitempty"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"` // When the current key was created
+}
+
+// TenantKeyService keeps a data key per isolated organization for
+// encrypting its data at rest. Data keys are AES-256-GCM keys wrapped by the
+// master key and bound to their organization: a value sealed for one
+// organization can't be opened with another's key, even if the envelope is
+// relabelled. Rotating adds a key version; older versions stay to open what
+// they sealed.
+type TenantKeyService struct {
+	db     *DatabaseService
+	master cipher.AEAD // Nil when tenant isolation is off
+	ttl    time.Duration
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	keys    map[tenantKeyID]cipher.AEAD
+	current map[string]cachedTenantVersion
+}
+
+type tenantKeyID struct {
+	orgID   string
+	version int
+}
+
+type cachedTenantVersion struct {
+	version int // 0 when the organization isn't isolated
+	expires time.Time
+}
+
+// NewTenantKeyService creates a tenant key service. Without a master key it
+// is disabled and every organization is left unisolated.
+func NewTenantKeyService(db *DatabaseService, config TenantKeyConfig, logger *logrus.Logger) (*TenantKeyService, error) {
+	s := &TenantKeyService{
+		db:      db,
+		ttl:     config.CacheTTL,
+		logger:  logger,
+		keys:    make(map[tenantKeyID]cipher.AEAD),
+		current: make(map[string]cachedTenantVersion),
+	}
+	if len(config.MasterKey) > 0 {
+		master, err := newGCM(config.MasterKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tenant master key: %w", err)
+		}
+		s.master = master
+	}
+	return s, nil
+}
+
+// Enabled reports whether a master key is configured
+func (s *TenantKeyService) Enabled() bool {
+	return s.master != nil
+}
+
+// Status returns an organization's key state
+func (s *TenantKeyService) Status(ctx context.Context, orgID string) (*TenantKeyStatus, error) {
+	status := &TenantKeyStatus{OrgID: orgID}
+	var createdAt time.Time
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT version, created_at FROM auth.org_data_keys
+		WHERE org_id = $1 ORDER BY version DESC LIMIT 1`, orgID).Scan(&status.KeyVersion, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant key: %w", err)
+	}
+	status.Isolated = true
+	status.RotatedAt = &createdAt
+	return status, nil
+}
+
+// Isolate gives an organization its first data key. Organizations that
+// already have one are left as they are.
+func (s *TenantKeyService) Isolate(ctx context.Context, orgID, createdBy string) (*TenantKeyStatus, error) {
+	if !s.Enabled() {
+		return nil, ErrTenantKeysDisabled
+	}
+	wrapped, err := s.newDataKey(orgID, 1)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO auth.org_data_keys (org_id, version, wrapped_key, created_by)
+		SELECT $1, 1, $2, NULLIF($3, '')::uuid
+		WHERE NOT EXISTS (SELECT 1 FROM auth.org_data_keys WHERE org_id = $1)
+		ON CONFLICT (org_id, version) DO NOTHING`, orgID, wrapped, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tenant key: %w", err)
+	}
+	s.forgetVersion(orgID)
+	return s.Status(ctx, orgID)
+}
+
+// RotateKey adds a new current key version for an isolated organization
+func (s *TenantKeyService) RotateKey(ctx context.Context, orgID, createdBy string) (*TenantKeyStatus, error) {
+	if !s.Enabled() {
+		return nil, ErrTenantKeysDisabled
+	}
+	status, err := s.Status(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Isolated {
+		return nil, ErrTenantNotIsolated
+	}
+
+	version := status.KeyVersion + 1
+	wrapped, err := s.newDataKey(orgID, version)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.DB.ExecContext(ctx, `
+		INSERT INTO auth.org_data_keys (org_id, version, wrapped_key, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, '')::uuid)`, orgID, version, wrapped, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate tenant key: %w", err)
+	}
+	s.forgetVersion(orgID)
+	s.logger.WithFields(logrus.Fields{"org_id": orgID, "key_version": version}).Info("Tenant data key rotated")
+	return s.Status(ctx, orgID)
+}
+
+// Isolated reports whether an organization has a data key
+func (s *TenantKeyService) Isolated(ctx context.Context, orgID string) (bool, error) {
+	if !s.Enabled() || orgID == "" {
+		return false, nil
+	}
+	version, err := s.currentVersion(ctx, orgID)
+	return version > 0, err
+}
+
+// Seal encrypts a value under the organization's current data key
+func (s *TenantKeyService) Seal(ctx context.Context, orgID, plaintext string) (string, error) {
+	if !s.Enabled() {
+		return "", ErrTenantKeysDisabled
+	}
+	version, err := s.currentVersion(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	if version == 0 {
+		return "", ErrTenantNotIsolated
+	}
+	aead, err := s.key(ctx, orgID, version)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), tenantSealAAD(orgID))
+	return tenantSealPrefix + strconv.Itoa(version) + ":" +
+		base64.RawURLEncoding.EncodeToString([]byte(orgID)) + ":" +
+		base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed for orgID. Values sealed for any other
+// organization are refused with ErrCrossTenantAccess.
+func (s *TenantKeyService) Open(ctx context.Context, orgID, value string) (string, error) {
+	owner, version, sealed, err := parseTenantSeal(value)
+	if err != nil {
+		return "", err
+	}
+	if owner != orgID {
+		return "", ErrCrossTenantAccess
+	}
+	if !s.Enabled() {
+		return "", ErrTenantKeysDisabled
+	}
+	aead, err := s.key(ctx, orgID, version)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed value is truncated")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], tenantSealAAD(orgID))
+	if err != nil {
+		return "", fmt.Errorf("failed to open sealed value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// isTenantSealed reports whether a value was sealed with a data key
+func isTenantSealed(value string) bool {
+	return strings.HasPrefix(value, tenantSealPrefix)
+}
+
+// sealedTenant returns the organization a value was sealed for
+func sealedTenant(value string) (string, bool) {
+	orgID, _, _, err := parseTenantSeal(value)
+	return orgID, err == nil
+}
+
+func parseTenantSeal(value string) (orgID string, version int, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, tenantSealPrefix), ":")
+	if !isTenantSealed(value) || len(parts) != 3 {
+		return "", 0, nil, errors.New("value is not sealed")
+	}
+	version, err = strconv.Atoi(parts[0])
+	if err != nil || version < 1 {
+		return "", 0, nil, errors.New("sealed value has an invalid key version")
+	}
+	org, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", 0, nil, errors.New("sealed value has an invalid organization")
+	}
+	sealed, err = base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", 0, nil, errors.New("sealed value is corrupt")
+	}
+	return string(org), version, sealed, nil
+}
+
+// tenantSealAAD binds sealed values to their organization
+func tenantSealAAD(orgID string) []byte {
+	return []byte("tenant:" + orgID)
+}
+
+// dataKeyAAD binds a wrapped data key to its organization and version, so
+// one can't be swapped in for another
+func dataKeyAAD(orgID string, version int) []byte {
+	return []byte("data_key:" + orgID + ":" + strconv.Itoa(version))
+}
+
+// newDataKey generates a data key and returns it wrapped by the master key
+func (s *TenantKeyService) newDataKey(orgID string, version int) ([]byte, error) {
+	key := make([]byte, 32)
+	nonce := make([]byte, s.master.NonceSize())
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return s.master.Seal(nonce, nonce, key, dataKeyAAD(orgID, version)), nil
+}
+
+// currentVersion returns the organization's newest key version, or 0
+func (s *TenantKeyService) currentVersion(ctx context.Context, orgID string) (int, error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.current[orgID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.version, nil
+	}
+
+	var version int
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM auth.org_data_keys WHERE org_id = $1`, orgID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tenant key version: %w", err)
+	}
+
+	s.mu.Lock()
+	s.current[orgID] = cachedTenantVersion{version: version, expires: now.Add(s.ttl)}
+	s.mu.Unlock()
+	return version, nil
+}
+
+func (s *TenantKeyService) forgetVersion(orgID string) {
+	s.mu.Lock()
+	delete(s.current, orgID)
+	s.mu.Unlock()
+}
+
+// key returns one of an organization's data keys, unwrapped. Keys never
+// change once created, so they are kept for the life of the process.
+func (s *TenantKeyService) key(ctx context.Context, orgID string, version int) (cipher.AEAD, error) {
+	id := tenantKeyID{orgID: orgID, version: version}
+	s.mu.Lock()
+	aead, ok := s.keys[id]
+	s.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	var wrapped []byte
+	err := s.db.DB.QueryRowContext(ctx,
+		`SELECT wrapped_key FROM auth.org_data_keys WHERE org_id = $1 AND version = $2`, orgID, version).Scan(&wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("tenant key version %d not found", version)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tenant key: %w", err)
+	}
+	nonceSize := s.master.NonceSize()
+	if len(wrapped) < nonceSize {
+		return nil, errors.New("wrapped tenant key is truncated")
+	}
+	key, err := s.master.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], dataKeyAAD(orgID, version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap tenant key: %w", err)
+	}
+	aead, err = newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.keys[id] = aead
+	s.mu.Unlock()
+	return aead, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTenantKeys returns a tenant key service over a recording database
+// serving auth.org_data_keys from the keys inserted into it
+func newTestTenantKeys(t *testing.T) *TenantKeyService {
+	db, d := newRecordingDB(t, func(string) int64 { return 1 })
+	stored := func() map[tenantKeyID][]byte {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		keys := make(map[tenantKeyID][]byte)
+		for i, entry := range d.log {
+			args := d.args[i]
+			switch {
+			case strings.HasPrefix(entry, "INSERT INTO auth.org_data_keys") && strings.Contains(entry, "SELECT $1, 1, $2"):
+				id := tenantKeyID{orgID: args[0].Value.(string), version: 1}
+				if _, ok := keys[id]; !ok {
+					keys[id] = args[1].Value.([]byte)
+				}
+			case strings.HasPrefix(entry, "INSERT INTO auth.org_data_keys"):
+				keys[tenantKeyID{orgID: args[0].Value.(string), version: int(args[1].Value.(int64))}] = args[2].Value.([]byte)
+			}
+		}
+		return keys
+	}
+	lastArgs := func() []driver.NamedValue {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.args[len(d.args)-1]
+	}
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		keys, args := stored(), lastArgs()
+		orgID := args[0].Value.(string)
+		latest := 0
+		for id := range keys {
+			if id.orgID == orgID && id.version > latest {
+				latest = id.version
+			}
+		}
+		switch {
+		case strings.Contains(query, "COALESCE(MAX(version), 0)"):
+			return []string{"version"}, [][]driver.Value{{int64(latest)}}
+		case strings.Contains(query, "SELECT version, created_at") && latest > 0:
+			return []string{"version", "created_at"}, [][]driver.Value{{int64(latest), time.Now()}}
+		case strings.Contains(query, "SELECT wrapped_key"):
+			if wrapped, ok := keys[tenantKeyID{orgID: orgID, version: int(args[1].Value.(int64))}]; ok {
+				return []string{"wrapped_key"}, [][]driver.Value{{wrapped}}
+			}
+		}
+		return nil, nil
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	master := make([]byte, 32)
+	copy(master, "test master key, not for prod!!")
+	keys, err := NewTenantKeyService(NewDatabaseService(db.DB), TenantKeyConfig{MasterKey: master, CacheTTL: time.Minute}, logger)
+	require.NoError(t, err)
+	return keys
+}
+
+func TestTenantKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestTenantKeys(t)
+
+	_, err := keys.Seal(ctx, "acme", "secret prompt")
+	assert.True(t, errors.Is(err, ErrTenantNotIsolated), "%v", err)
+
+	status, err := keys.Isolate(ctx, "acme", "")
+	require.NoError(t, err)
+	assert.True(t, status.Isolated)
+	assert.Equal(t, 1, status.KeyVersion)
+	isolated, err := keys.Isolated(ctx, "acme")
+	require.NoError(t, err)
+	assert.True(t, isolated)
+
+	sealed, err := keys.Seal(ctx, "acme", "secret prompt")
+	require.NoError(t, err)
+	assert.True(t, isTenantSealed(sealed))
+	assert.NotContains(t, sealed, "secret")
+	again, err := keys.Seal(ctx, "acme", "secret prompt")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	opened, err := keys.Open(ctx, "acme", sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "secret prompt", opened)
+
+	t.Run("refuses other organizations", func(t *testing.T) {
+		_, err := keys.Open(ctx, "globex", sealed)
+		assert.True(t, errors.Is(err, ErrCrossTenantAccess), "%v", err)
+
+		// Relabelling the envelope doesn't help: globex's key can't open it
+		_, err = keys.Isolate(ctx, "globex", "")
+		require.NoError(t, err)
+		relabelled := strings.Replace(sealed,
+			":"+base64.RawURLEncoding.EncodeToString([]byte("acme"))+":",
+			":"+base64.RawURLEncoding.EncodeToString([]byte("globex"))+":", 1)
+		_, err = keys.Open(ctx, "globex", relabelled)
+		assert.Error(t, err)
+	})
+
+	t.Run("rotation keeps old versions readable", func(t *testing.T) {
+		status, err := keys.RotateKey(ctx, "acme", "")
+		require.NoError(t, err)
+		assert.Equal(t, 2, status.KeyVersion)
+
+		rotated, err := keys.Seal(ctx, "acme", "newer prompt")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(rotated, tenantSealPrefix+"2:"), rotated)
+		for value, want := range map[string]string{sealed: "secret prompt", rotated: "newer prompt"} {
+			opened, err := keys.Open(ctx, "acme", value)
+			require.NoError(t, err)
+			assert.Equal(t, want, opened)
+		}
+
+		_, err = keys.RotateKey(ctx, "initech", "")
+		assert.True(t, errors.Is(err, ErrTenantNotIsolated), "%v", err)
+	})
+
+	t.Run("disabled without a master key", func(t *testing.T) {
+		disabled, err := NewTenantKeyService(nil, TenantKeyConfig{}, logrus.New())
+		require.NoError(t, err)
+		assert.False(t, disabled.Enabled())
+		_, err = disabled.Isolate(ctx, "acme", "")
+		assert.True(t, errors.Is(err, ErrTenantKeysDisabled))
+		isolated, err := disabled.Isolated(ctx, "acme")
+		require.NoError(t, err)
+		assert.False(t, isolated)
+	})
+}
+
+// memoryHistory is a HistoryStore keeping entries in memory as given
+type memoryHistory struct {
+	HistoryStore
+	entries map[string]models.PromptHistory
+}
+
+func (m *memoryHistory) SavePromptHistory(ctx context.Context, entry models.PromptHistory) (string, error) {
+	entry.ID = "history-" + entry.UserID.String
+	m.entries[entry.ID] = entry
+	return entry.ID, nil
+}
+
+func (m *memoryHistory) GetPromptHistory(ctx context.Context, id string) (*models.PromptHistory, error) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return nil, ErrPromptHistoryNotFound
+	}
+	return &entry, nil
+}
+
+func (m *memoryHistory) GetUserPromptHistoryWithFilters(ctx context.Context, userID string, req models.PaginationRequest) ([]*models.PromptHistory, int64, error) {
+	var entries []*models.PromptHistory
+	for _, entry := range m.entries {
+		if entry.UserID.String == userID {
+			entry := entry
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, int64(len(entries)), nil
+}
+
+func (m *memoryHistory) DeletePromptHistory(ctx context.Context, id string) error {
+	delete(m.entries, id)
+	return nil
+}
+
+func (m *memoryHistory) UpdatePromptHistory(ctx context.Context, id string, update HistoryUpdate) error {
+	entry, ok := m.entries[id]
+	if !ok {
+		return ErrPromptHistoryNotFound
+	}
+	update.apply(&entry)
+	m.entries[id] = entry
+	return nil
+}
+
+func TestTenantHistoryStore(t *testing.T) {
+	ctx := context.Background()
+	keys := newTestTenantKeys(t)
+	_, err := keys.Isolate(ctx, "acme", "")
+	require.NoError(t, err)
+
+	inner := &memoryHistory{entries: map[string]models.PromptHistory{}}
+	store := &tenantHistoryStore{
+		HistoryStore: inner,
+		keys:         keys,
+		accounts:     orgAccounts{"owner": "acme", "colleague": "acme", "outsider": "globex", "solo": "", "admin": ""},
+	}
+	as := func(userID, orgID string, roles ...string) context.Context {
+		return WithRequestContext(ctx, &RequestContext{UserID: userID, OrgID: orgID, Roles: roles})
+	}
+	save := func(userID string) string {
+		id, err := store.SavePromptHistory(as(userID, ""), models.PromptHistory{
+			UserID:         sql.NullString{String: userID, Valid: true},
+			OriginalInput:  "draft the merger memo",
+			EnhancedOutput: "You are counsel drafting the merger memo",
+		})
+		require.NoError(t, err)
+		return id
+	}
+
+	isolatedID := save("owner")
+	assert.True(t, isTenantSealed(inner.entries[isolatedID].OriginalInput))
+	assert.True(t, isTenantSealed(inner.entries[isolatedID].EnhancedOutput))
+
+	for name, ctx := range map[string]context.Context{
+		"the owner":   as("owner", "acme"),
+		"a colleague": as("colleague", "acme"),
+		"the gateway": ctx,
+	} {
+		entry, err := store.GetPromptHistory(ctx, isolatedID)
+		require.NoError(t, err, name)
+		assert.Equal(t, "draft the merger memo", entry.OriginalInput, name)
+		assert.Equal(t, "You are counsel drafting the merger memo", entry.EnhancedOutput, name)
+	}
+
+	for name, ctx := range map[string]context.Context{
+		"another organization": as("outsider", "globex"),
+		"a platform admin":     as("admin", "", "admin"),
+		"an anonymous caller":  WithRequestContext(ctx, &RequestContext{Tier: TierAnonymous}),
+	} {
+		_, err := store.GetPromptHistory(ctx, isolatedID)
+		assert.True(t, errors.Is(err, ErrPromptHistoryNotFound), "%s: %v", name, err)
+
+		entries, total, err := store.GetUserPromptHistoryWithFilters(ctx, "owner", models.PaginationRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, entries, name)
+		assert.Zero(t, total, name)
+
+		assert.True(t, errors.Is(store.DeletePromptHistory(ctx, isolatedID), ErrPromptHistoryNotFound), name)
+		assert.Contains(t, inner.entries, isolatedID, name)
+	}
+
+	t.Run("sealed history can't be searched", func(t *testing.T) {
+		_, _, err := store.GetUserPromptHistoryWithFilters(as("owner", "acme"), "owner", models.PaginationRequest{Search: "merger"})
+		assert.True(t, errors.Is(err, ErrHistorySearchUnsupported), "%v", err)
+		entries, _, err := store.GetUserPromptHistoryWithFilters(as("owner", "acme"), "owner", models.PaginationRequest{Technique: "role_play"})
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "other filters still apply")
+		_, _, err = store.GetUserPromptHistoryWithFilters(as("solo", ""), "solo", models.PaginationRequest{Search: "merger"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("organizations that aren't isolated are left alone", func(t *testing.T) {
+		id := save("solo")
+		assert.Equal(t, "draft the merger memo", inner.entries[id].OriginalInput)
+		entry, err := store.GetPromptHistory(as("outsider", "globex"), id)
+		require.NoError(t, err)
+		assert.Equal(t, "draft the merger memo", entry.OriginalInput)
+	})
+
+	t.Run("sealed history stays with its organization after the owner leaves", func(t *testing.T) {
+		store.accounts = orgAccounts{"owner": "globex", "colleague": "acme", "outsider": "globex"}
+		_, err := store.GetPromptHistory(as("outsider", "globex"), isolatedID)
+		assert.True(t, errors.Is(err, ErrPromptHistoryNotFound), "%v", err)
+		entry, err := store.GetPromptHistory(as("colleague", "acme"), isolatedID)
+		require.NoError(t, err)
+		assert.Equal(t, "draft the merger memo", entry.OriginalInput)
+	})
+}
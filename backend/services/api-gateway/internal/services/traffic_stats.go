@@ -0,0 +1,181 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Traffic is counted in buckets of trafficBucketWidth, keeping the last
+// trafficBuckets of them, so windows up to an hour can be summarized
+const (
+	trafficBucketWidth = 10 * time.Second
+	trafficBuckets     = 360
+)
+
+// latencyBoundsMs are the upper bounds of the latency histogram buckets; a
+// last, unbounded bucket catches the rest
+var latencyBoundsMs = []float64{10, 25, 50, 100, 250, 500, 1000, 2000, 5000, 10000, 30000}
+
+// gatewayTraffic is this instance's recent traffic, fed by the request
+// middleware and the intent classifier client
+var gatewayTraffic = newTrafficWindow(time.Now)
+
+// RecordRequest counts a served request towards the alerting metrics
+func RecordRequest(status int, latency time.Duration) {
+	gatewayTraffic.recordRequest(status, latency)
+}
+
+// RecordClassification counts an intent classification by the classifier
+// model that answered it
+func RecordClassification(classifier string) {
+	gatewayTraffic.recordClassification(classifier)
+}
+
+type trafficBucket struct {
+	slot         int64 // Which bucketWidth-long interval since the epoch this counts
+	requests     int64
+	serverErrors int64
+	latency      []int64 // Counts per latencyBoundsMs bucket
+	classifiers  map[string]int64
+}
+
+// trafficWindow keeps recent traffic in a ring of time buckets
+type trafficWindow struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets [trafficBuckets]trafficBucket
+}
+
+func newTrafficWindow(now func() time.Time) *trafficWindow {
+	return &trafficWindow{now: now}
+}
+
+// current returns the bucket for now, clearing it if it last counted an
+// older interval. The caller holds w.mu.
+func (w *trafficWindow) current() *trafficBucket {
+	slot := w.now().UnixNano() / int64(trafficBucketWidth)
+	bucket := &w.buckets[slot%trafficBuckets]
+	if bucket.slot != slot {
+		*bucket = trafficBucket{
+			slot:        slot,
+			latency:     make([]int64, len(latencyBoundsMs)+1),
+			classifiers: map[string]int64{},
+		}
+	}
+	return bucket
+}
+
+func (w *trafficWindow) recordRequest(status int, latency time.Duration) {
+	ms := float64(latency) / float64(time.Millisecond)
+	i := 0
+	for i < len(latencyBoundsMs) && ms > latencyBoundsMs[i] {
+		i++
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	bucket := w.current()
+	bucket.requests++
+	if status >= 500 {
+		bucket.serverErrors++
+	}
+	bucket.latency[i]++
+}
+
+func (w *trafficWindow) recordClassification(classifier string) {
+	if classifier == "" {
+		classifier = "unknown"
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.current().classifiers[classifier]++
+}
+
+// TrafficSummary is the traffic seen over a window
+type TrafficSummary struct {
+	Requests     int64
+	ServerErrors int64
+	Latency      []int64 // Counts per latencyBoundsMs bucket
+	Classifiers  map[string]int64
+}
+
+// summary adds up the buckets covering the last window
+func (w *trafficWindow) summary(window time.Duration) TrafficSummary {
+	summary := TrafficSummary{
+		Latency:     make([]int64, len(latencyBoundsMs)+1),
+		Classifiers: map[string]int64{},
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	newest := w.now().UnixNano() / int64(trafficBucketWidth)
+	oldest := newest - int64(window/trafficBucketWidth) + 1
+	for i := range w.buckets {
+		bucket := &w.buckets[i]
+		if bucket.slot < oldest || bucket.slot > newest || bucket.latency == nil {
+			continue
+		}
+		summary.Requests += bucket.requests
+		summary.ServerErrors += bucket.serverErrors
+		for j, count := range bucket.latency {
+			summary.Latency[j] += count
+		}
+		for classifier, count := range bucket.classifiers {
+			summary.Classifiers[classifier] += count
+		}
+	}
+	return summary
+}
+
+// ErrorRate is the share of requests answered with a 5xx
+func (s TrafficSummary) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.ServerErrors) / float64(s.Requests)
+}
+
+// LatencyPercentile estimates the latency in milliseconds below which p of
+// the requests finished, interpolating within the histogram bucket it
+// falls in. Requests past the last bound count as taking that long.
+func (s TrafficSummary) LatencyPercentile(p float64) float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	rank := p * float64(s.Requests)
+	var seen float64
+	for i, count := range s.Latency {
+		if count == 0 || seen+float64(count) < rank {
+			seen += float64(count)
+			continue
+		}
+		if i == len(latencyBoundsMs) {
+			return latencyBoundsMs[i-1]
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = latencyBoundsMs[i-1]
+		}
+		return lower + (latencyBoundsMs[i]-lower)*(rank-seen)/float64(count)
+	}
+	return latencyBoundsMs[len(latencyBoundsMs)-1]
+}
+
+// ClassifierFallbackRate is the share of classifications with a known
+// classifier that weren't answered by primary
+func (s TrafficSummary) ClassifierFallbackRate(primary string) float64 {
+	return (&IntentDistribution{Classifiers: s.Classifiers}).FallbackRate(primary)
+}
+
+// knownClassifications counts the classifications with a known classifier
+func (s TrafficSummary) knownClassifications() int64 {
+	var known int64
+	for classifier, count := range s.Classifiers {
+		if classifier != "unknown" {
+			known += count
+		}
+	}
+	return known
+}
@@ -0,0 +1,544 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TrainingLabelGood and TrainingLabelBad are the training example labels
+	TrainingLabelGood = "good"
+	TrainingLabelBad  = "bad"
+
+	// MaxBulkTrainingLabels caps how many entries one bulk label request may touch
+	MaxBulkTrainingLabels = 10_000
+
+	// MaxTrainingDatasetSize caps the examples frozen into one dataset version
+	MaxTrainingDatasetSize = 100_000
+)
+
+var (
+	// ErrTrainingExampleNotFound is returned for history entries that don't exist
+	ErrTrainingExampleNotFound = errors.New("history entry not found")
+
+	// ErrTrainingLabelNotFound is returned when removing a label that isn't set
+	ErrTrainingLabelNotFound = errors.New("training label not found")
+
+	// ErrTrainingDatasetNotFound is returned for unknown dataset versions
+	ErrTrainingDatasetNotFound = errors.New("training dataset not found")
+
+	// ErrTrainingDatasetEmpty is returned when a dataset filter matches nothing
+	ErrTrainingDatasetEmpty = errors.New("no labeled examples match the filter")
+
+	// ErrInvalidTrainingDataset wraps problems with a dataset request
+	ErrInvalidTrainingDataset = errors.New("invalid training dataset")
+)
+
+// ValidTrainingLabel reports whether label is a known training label
+func ValidTrainingLabel(label string) bool {
+	return label == TrainingLabelGood || label == TrainingLabelBad
+}
+
+// TrainingFilter selects history entries for bulk labeling and datasets
+type TrainingFilter struct {
+	Labels      []string   `json:"labels,omitempty"` // Datasets only; defaults to good
+	Intent      string     `json:"intent,omitempty"`
+	Technique   string     `json:"technique,omitempty"`
+	Model       string     `json:"model,omitempty"`
+	MinFeedback int        `json:"min_feedback,omitempty"`
+	MaxFeedback int        `json:"max_feedback,omitempty"`
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+	Unlabeled   bool       `json:"unlabeled,omitempty"` // Bulk labeling only: skip entries already labeled
+}
+
+// Validate checks the filter's values
+func (f TrainingFilter) Validate() error {
+	for _, label := range f.Labels {
+		if !ValidTrainingLabel(label) {
+			return fmt.Errorf("unknown label %q", label)
+		}
+	}
+	if f.MinFeedback < 0 || f.MinFeedback > 5 || f.MaxFeedback < 0 || f.MaxFeedback > 5 {
+		return errors.New("feedback bounds must be between 1 and 5")
+	}
+	if f.MinFeedback > 0 && f.MaxFeedback > 0 && f.MinFeedback > f.MaxFeedback {
+		return errors.New("min_feedback is greater than max_feedback")
+	}
+	if f.From != nil && f.To != nil && f.From.After(*f.To) {
+		return errors.New("from is after to")
+	}
+	return nil
+}
+
+// where builds conditions on prompts.history h and prompts.training_labels l,
+// adding their values to args
+func (f TrainingFilter) where(args *sqlArgs) string {
+	conditions := []string{"TRUE"}
+
+	if len(f.Labels) > 0 {
+		conditions = append(conditions, "l.label = ANY("+args.add(pq.Array(f.Labels))+")")
+	}
+	if f.Intent != "" {
+		conditions = append(conditions, "h.intent = "+args.add(f.Intent))
+	}
+	if f.Technique != "" {
+		conditions = append(conditions, args.add(f.Technique)+" = ANY(h.techniques_used::text[])")
+	}
+	if f.Model != "" {
+		conditions = append(conditions, "h.model_used = "+args.add(f.Model))
+	}
+	if f.MinFeedback > 0 {
+		conditions = append(conditions, "h.feedback_score >= "+args.add(f.MinFeedback))
+	}
+	if f.MaxFeedback > 0 {
+		conditions = append(conditions, "h.feedback_score <= "+args.add(f.MaxFeedback))
+	}
+	if f.From != nil {
+		conditions = append(conditions, "h.created_at >= "+args.add(*f.From))
+	}
+	if f.To != nil {
+		conditions = append(conditions, "h.created_at < "+args.add(*f.To))
+	}
+	if f.Unlabeled {
+		conditions = append(conditions, "l.history_id IS NULL")
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// TrainingExample is a history entry as seen by the ML team. Prompt text
+// has contact details and secrets redacted, and the output is replaced by a
+// reviewer's correction when there is one.
+type TrainingExample struct {
+	ID            string    `json:"id"`
+	Label         string    `json:"label,omitempty"`
+	Split         string    `json:"split,omitempty"`
+	Input         string    `json:"input"`
+	Output        string    `json:"output"`
+	Corrected     bool      `json:"corrected,omitempty"` // Output is a reviewer's correction
+	Intent        string    `json:"intent,omitempty"`
+	Techniques    []string  `json:"techniques"`
+	FeedbackScore *int      `json:"feedback_score,omitempty"`
+	Model         string    `json:"model,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TrainingDataset is one frozen version of a named training set
+type TrainingDataset struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Version     int            `json:"version"`
+	Description string         `json:"description,omitempty"`
+	Filter      TrainingFilter `json:"filter"`
+	ValRatio    float64        `json:"val_ratio"`
+	Seed        string         `json:"seed"`
+	TrainCount  int            `json:"train_count"`
+	ValCount    int            `json:"val_count"`
+	CreatedBy   string         `json:"created_by,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// CreateTrainingDatasetRequest describes a new dataset version
+type CreateTrainingDatasetRequest struct {
+	Name        string         `json:"name" binding:"required,max=100"`
+	Description string         `json:"description" binding:"max=1000"`
+	Filter      TrainingFilter `json:"filter"`
+	ValRatio    *float64       `json:"val_ratio"` // Defaults to 0.1
+	Seed        string         `json:"seed" binding:"max=100"`
+}
+
+// TrainingSplit deterministically assigns a history entry to the train or
+// val split, so the same seed always splits the same entries the same way
+// regardless of the order they are read in
+func TrainingSplit(seed, historyID string, valRatio float64) string {
+	sum := md5.Sum([]byte(seed + ":" + historyID))
+	if float64(binary.BigEndian.Uint32(sum[:4]))/(1<<32) < valRatio {
+		return "val"
+	}
+	return "train"
+}
+
+// TrainingService manages training labels and dataset versions
+type TrainingService struct {
+	db     *DatabaseService
+	logger *logrus.Logger
+}
+
+// NewTrainingService creates a new training data service
+func NewTrainingService(db *DatabaseService, logger *logrus.Logger) *TrainingService {
+	return &TrainingService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// SetLabel labels a history entry, replacing any existing label
+func (s *TrainingService) SetLabel(ctx context.Context, historyID, label, note, labeledBy string) error {
+	query := `
+		INSERT INTO prompts.training_labels (history_id, label, note, labeled_by, labeled_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (history_id) DO UPDATE
+		SET label = EXCLUDED.label, note = EXCLUDED.note,
+			labeled_by = EXCLUDED.labeled_by, labeled_at = EXCLUDED.labeled_at`
+
+	if _, err := s.db.DB.ExecContext(ctx, query, historyID, label, note, labeledBy); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+			return ErrTrainingExampleNotFound
+		}
+		return fmt.Errorf("failed to set training label: %w", err)
+	}
+	return nil
+}
+
+// DeleteLabel removes a history entry's label
+func (s *TrainingService) DeleteLabel(ctx context.Context, historyID string) error {
+	result, err := s.db.DB.ExecContext(ctx, `DELETE FROM prompts.training_labels WHERE history_id = $1`, historyID)
+	if err != nil {
+		return fmt.Errorf("failed to delete training label: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTrainingLabelNotFound
+	}
+	return nil
+}
+
+// BulkLabel labels every history entry matching filter, returning how many
+// matched. Nothing is written when dryRun is set or more than
+// MaxBulkTrainingLabels entries match.
+func (s *TrainingService) BulkLabel(ctx context.Context, filter TrainingFilter, label, note, labeledBy string, dryRun bool) (int64, error) {
+	var args sqlArgs
+	where := filter.where(&args)
+
+	var matched int64
+	countQuery := `
+		SELECT COUNT(*)
+		FROM prompts.history h
+		LEFT JOIN prompts.training_labels l ON l.history_id = h.id
+		WHERE ` + where
+	if err := s.db.DB.QueryRowContext(ctx, countQuery, args...).Scan(&matched); err != nil {
+		return 0, fmt.Errorf("failed to count matching entries: %w", err)
+	}
+	if dryRun || matched == 0 {
+		return matched, nil
+	}
+	if matched > MaxBulkTrainingLabels {
+		return matched, fmt.Errorf("filter matches %d entries, more than the %d allowed per request", matched, MaxBulkTrainingLabels)
+	}
+
+	query := `
+		INSERT INTO prompts.training_labels (history_id, label, note, labeled_by, labeled_at)
+		SELECT h.id, ` + args.add(label) + `, NULLIF(` + args.add(note) + `, ''), ` + args.add(labeledBy) + `, CURRENT_TIMESTAMP
+		FROM prompts.history h
+		LEFT JOIN prompts.training_labels l ON l.history_id = h.id
+		WHERE ` + where + `
+		ON CONFLICT (history_id) DO UPDATE
+		SET label = EXCLUDED.label, note = EXCLUDED.note,
+			labeled_by = EXCLUDED.labeled_by, labeled_at = EXCLUDED.labeled_at`
+
+	result, err := s.db.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bulk label entries: %w", err)
+	}
+	labeled, _ := result.RowsAffected()
+	return labeled, nil
+}
+
+// ListLabeled returns labeled history entries, most recently labeled first,
+// optionally for one label
+func (s *TrainingService) ListLabeled(ctx context.Context, label string, limit, offset int) ([]*TrainingExample, int64, error) {
+	var total int64
+	if err := s.db.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM prompts.training_labels WHERE $1 = '' OR label = $1`, label).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count training labels: %w", err)
+	}
+
+	query := `
+		SELECT h.id, l.label, '', h.original_input, COALESCE(l.corrected_output, h.enhanced_output),
+			   l.corrected_output IS NOT NULL, h.intent, h.techniques_used, h.feedback_score,
+			   h.model_used, h.created_at
+		FROM prompts.training_labels l
+		JOIN prompts.history h ON h.id = l.history_id
+		WHERE $1 = '' OR l.label = $1
+		ORDER BY l.labeled_at DESC
+		LIMIT $2 OFFSET $3`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, label, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list training labels: %w", err)
+	}
+	defer rows.Close()
+
+	examples := []*TrainingExample{}
+	for rows.Next() {
+		example, err := scanTrainingExample(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		examples = append(examples, example)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate training labels: %w", err)
+	}
+	return examples, total, nil
+}
+
+// CreateDataset freezes the labeled entries matching the request's filter
+// into the next version of the named dataset, split into train and val
+func (s *TrainingService) CreateDataset(ctx context.Context, req CreateTrainingDatasetRequest, createdBy string) (*TrainingDataset, error) {
+	filter := req.Filter
+	filter.Unlabeled = false
+	if len(filter.Labels) == 0 {
+		filter.Labels = []string{TrainingLabelGood}
+	}
+	if err := filter.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTrainingDataset, err)
+	}
+
+	valRatio := 0.1
+	if req.ValRatio != nil {
+		valRatio = *req.ValRatio
+	}
+	if valRatio < 0 || valRatio > 0.5 {
+		return nil, fmt.Errorf("%w: val_ratio must be between 0 and 0.5", ErrInvalidTrainingDataset)
+	}
+
+	dataset := &TrainingDataset{
+		Name:        req.Name,
+		Description: req.Description,
+		Filter:      filter,
+		ValRatio:    valRatio,
+		Seed:        req.Seed,
+		CreatedBy:   createdBy,
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize version numbering per dataset name
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('training_dataset:' || $1))`, req.Name); err != nil {
+		return nil, fmt.Errorf("failed to lock dataset: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) + 1 FROM prompts.training_datasets WHERE name = $1`, req.Name).Scan(&dataset.Version); err != nil {
+		return nil, fmt.Errorf("failed to get next dataset version: %w", err)
+	}
+	if dataset.Seed == "" {
+		dataset.Seed = fmt.Sprintf("%s-v%d", dataset.Name, dataset.Version)
+	}
+
+	var args sqlArgs
+	where := filter.where(&args)
+	query := `
+		SELECT h.id, l.label
+		FROM prompts.history h
+		JOIN prompts.training_labels l ON l.history_id = h.id
+		WHERE ` + where + `
+		ORDER BY h.created_at, h.id
+		LIMIT ` + args.add(MaxTrainingDatasetSize+1)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select dataset examples: %w", err)
+	}
+	var ids, labels, splits []string
+	for rows.Next() {
+		var id, label string
+		if err := rows.Scan(&id, &label); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan dataset example: %w", err)
+		}
+		split := TrainingSplit(dataset.Seed, id, valRatio)
+		if split == "val" {
+			dataset.ValCount++
+		} else {
+			dataset.TrainCount++
+		}
+		ids = append(ids, id)
+		labels = append(labels, label)
+		splits = append(splits, split)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate dataset examples: %w", err)
+	}
+
+	if len(ids) == 0 {
+		return nil, ErrTrainingDatasetEmpty
+	}
+	if len(ids) > MaxTrainingDatasetSize {
+		return nil, fmt.Errorf("%w: filter matches more than %d examples", ErrInvalidTrainingDataset, MaxTrainingDatasetSize)
+	}
+
+	filterJSON, err := json.Marshal(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal filter: %w", err)
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO prompts.training_datasets
+			(name, version, description, filter, val_ratio, seed, train_count, val_count, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9)
+		RETURNING id, created_at`,
+		dataset.Name, dataset.Version, dataset.Description, filterJSON, dataset.ValRatio, dataset.Seed,
+		dataset.TrainCount, dataset.ValCount, createdBy).Scan(&dataset.ID, &dataset.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dataset: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO prompts.training_dataset_items (dataset_id, history_id, label, split)
+		SELECT $1, unnest($2::uuid[]), unnest($3::text[]), unnest($4::text[])`,
+		dataset.ID, pq.Array(ids), pq.Array(labels), pq.Array(splits))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add dataset examples: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit dataset: %w", err)
+	}
+	return dataset, nil
+}
+
+// ListDatasets returns dataset versions, newest first, optionally for one name
+func (s *TrainingService) ListDatasets(ctx context.Context, name string) ([]*TrainingDataset, error) {
+	query := `
+		SELECT id, name, version, description, filter, val_ratio, seed, train_count, val_count, created_by, created_at
+		FROM prompts.training_datasets
+		WHERE $1 = '' OR name = $1
+		ORDER BY created_at DESC
+		LIMIT 200`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list datasets: %w", err)
+	}
+	defer rows.Close()
+
+	datasets := []*TrainingDataset{}
+	for rows.Next() {
+		dataset, err := scanTrainingDataset(rows)
+		if err != nil {
+			return nil, err
+		}
+		datasets = append(datasets, dataset)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate datasets: %w", err)
+	}
+	return datasets, nil
+}
+
+// GetDataset returns a dataset version
+func (s *TrainingService) GetDataset(ctx context.Context, id string) (*TrainingDataset, error) {
+	query := `
+		SELECT id, name, version, description, filter, val_ratio, seed, train_count, val_count, created_by, created_at
+		FROM prompts.training_datasets
+		WHERE id = $1`
+
+	dataset, err := scanTrainingDataset(s.db.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTrainingDatasetNotFound
+	}
+	return dataset, err
+}
+
+// ExportDataset writes a dataset version as JSON lines, one example per
+// line, limited to one split unless split is empty. Examples whose history
+// entry has since been deleted are left out.
+func (s *TrainingService) ExportDataset(ctx context.Context, id, split string, w io.Writer) (int, error) {
+	query := `
+		SELECT h.id, i.label, i.split, h.original_input, COALESCE(l.corrected_output, h.enhanced_output),
+			   l.corrected_output IS NOT NULL, h.intent, h.techniques_used, h.feedback_score,
+			   h.model_used, h.created_at
+		FROM prompts.training_dataset_items i
+		JOIN prompts.history h ON h.id = i.history_id
+		LEFT JOIN prompts.training_labels l ON l.history_id = i.history_id
+		WHERE i.dataset_id = $1 AND ($2 = '' OR i.split = $2)
+		ORDER BY i.split, h.created_at, h.id`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, id, split)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query dataset examples: %w", err)
+	}
+	defer rows.Close()
+
+	encoder := json.NewEncoder(w)
+	written := 0
+	for rows.Next() {
+		example, err := scanTrainingExample(rows)
+		if err != nil {
+			return written, err
+		}
+		if err := encoder.Encode(example); err != nil {
+			return written, fmt.Errorf("failed to write example: %w", err)
+		}
+		written++
+	}
+	if err := rows.Err(); err != nil {
+		return written, fmt.Errorf("failed to iterate dataset examples: %w", err)
+	}
+	return written, nil
+}
+
+func scanTrainingExample(row rowScanner) (*TrainingExample, error) {
+	var example TrainingExample
+	var intent, model sql.NullString
+	var techniques pq.StringArray
+	var feedback sql.NullInt64
+
+	err := row.Scan(&example.ID, &example.Label, &example.Split, &example.Input, &example.Output,
+		&example.Corrected, &intent, &techniques, &feedback, &model, &example.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan training example: %w", err)
+	}
+
+	example.Input = RedactPII(example.Input)
+	example.Output = RedactPII(example.Output)
+	example.Intent = intent.String
+	example.Model = model.String
+	example.Techniques = []string(techniques)
+	if example.Techniques == nil {
+		example.Techniques = []string{}
+	}
+	if feedback.Valid {
+		score := int(feedback.Int64)
+		example.FeedbackScore = &score
+	}
+	return &example, nil
+}
+
+func scanTrainingDataset(row rowScanner) (*TrainingDataset, error) {
+	var dataset TrainingDataset
+	var description, createdBy sql.NullString
+	var filter []byte
+
+	err := row.Scan(&dataset.ID, &dataset.Name, &dataset.Version, &description, &filter, &dataset.ValRatio,
+		&dataset.Seed, &dataset.TrainCount, &dataset.ValCount, &createdBy, &dataset.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan dataset: %w", err)
+	}
+
+	dataset.Description = description.String
+	dataset.CreatedBy = createdBy.String
+	if err := json.Unmarshal(filter, &dataset.Filter); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dataset filter: %w", err)
+	}
+	return &dataset, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrainingFilterValidate(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	assert.NoError(t, TrainingFilter{}.Validate())
+	assert.NoError(t, TrainingFilter{Labels: []string{"good", "bad"}, MinFeedback: 4, MaxFeedback: 5, From: &from, To: &to}.Validate())
+
+	invalid := map[string]TrainingFilter{
+		"unknown label":     {Labels: []string{"meh"}},
+		"feedback too high": {MinFeedback: 6},
+		"negative feedback": {MaxFeedback: -1},
+		"inverted feedback": {MinFeedback: 4, MaxFeedback: 2},
+		"inverted dates":    {From: &to, To: &from},
+	}
+	for name, filter := range invalid {
+		assert.Error(t, filter.Validate(), name)
+	}
+}
+
+func TestTrainingFilterWhere(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	args := sqlArgs{"a", "b"}
+	where := TrainingFilter{Intent: "code_generation", MinFeedback: 4, From: &from, Unlabeled: true}.where(&args)
+	assert.Equal(t, "TRUE AND h.intent = $3 AND h.feedback_score >= $4 AND h.created_at >= $5 AND l.history_id IS NULL", where)
+	assert.Equal(t, sqlArgs{"a", "b", "code_generation", 4, from}, args)
+
+	args = nil
+	where = TrainingFilter{}.where(&args)
+	assert.Equal(t, "TRUE", where)
+	assert.Empty(t, args)
+}
+
+func TestTrainingSplit(t *testing.T) {
+	id := "8f14e45f-ceea-467f-a8a8-1a2b3c4d5e6f"
+	assert.Equal(t, TrainingSplit("seed", id, 0.2), TrainingSplit("seed", id, 0.2), "deterministic")
+	assert.Equal(t, "train", TrainingSplit("seed", id, 0))
+	assert.Equal(t, "val", TrainingSplit("seed", id, 1))
+
+	val := 0
+	for i := 0; i < 10000; i++ {
+		if TrainingSplit("seed", fmt.Sprintf("id-%d", i), 0.1) == "val" {
+			val++
+		}
+	}
+	assert.InDelta(t, 1000, val, 100, "roughly val_ratio of examples go to val")
+}
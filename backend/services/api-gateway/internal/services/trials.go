@@ -0,0 +1,219 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// trialJobTimeout bounds one run of the trial job
+const trialJobTimeout = 5 * time.Minute
+
+// TrialConfig describes the pro trial new users get
+type TrialConfig struct {
+	Duration      time.Duration // Zero disables trials
+	WarningPeriod time.Duration // How long before expiry users are warned
+	GracePeriod   time.Duration // How long after expiry users keep pro
+	DailyQuota    int           // Enhancements per day while on trial
+	Interval      time.Duration // How often the trial job runs
+}
+
+// LoadTrialConfig reads TRIAL_DURATION, TRIAL_WARNING_PERIOD,
+// TRIAL_GRACE_PERIOD, TRIAL_DAILY_ENHANCEMENTS and TRIAL_CHECK_INTERVAL.
+// Trials last 14 days unless TRIAL_DURATION says otherwise; 0 turns them off.
+func LoadTrialConfig() TrialConfig {
+	config := TrialConfig{
+		Duration:      14 * 24 * time.Hour,
+		WarningPeriod: 3 * 24 * time.Hour,
+		GracePeriod:   24 * time.Hour,
+		DailyQuota:    50,
+		Interval:      time.Hour,
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRIAL_DURATION")); err == nil && d >= 0 {
+		config.Duration = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRIAL_WARNING_PERIOD")); err == nil && d >= 0 {
+		config.WarningPeriod = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRIAL_GRACE_PERIOD")); err == nil && d >= 0 {
+		config.GracePeriod = d
+	}
+	if v, err := strconv.Atoi(os.Getenv("TRIAL_DAILY_ENHANCEMENTS")); err == nil && v > 0 {
+		config.DailyQuota = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("TRIAL_CHECK_INTERVAL")); err == nil && d > 0 {
+		config.Interval = d
+	}
+	return config
+}
+
+// TrialService grants new users a pro trial and ends it: users are emailed
+// once the trial is about to expire, keep pro for a grace period after it
+// does, and are then returned to the free tier. Subscribing through billing
+// clears the trial, so paying users are never downgraded.
+type TrialService struct {
+	db       *DatabaseService
+	email    *EmailService    // Optional; no warnings are sent when nil
+	accounts *AccountResolver // Optional; cached tiers expire on their own when nil
+	config   TrialConfig
+	logger   *logrus.Logger
+}
+
+// NewTrialService creates a new trial service
+func NewTrialService(db *DatabaseService, email *EmailService, accounts *AccountResolver, config TrialConfig, logger *logrus.Logger) *TrialService {
+	return &TrialService{
+		db:       db,
+		email:    email,
+		accounts: accounts,
+		config:   config,
+		logger:   logger,
+	}
+}
+
+// Enabled reports whether new users get a trial
+func (s *TrialService) Enabled() bool {
+	return s.config.Duration > 0
+}
+
+// StartTrial puts a free user on the pro tier until the trial expires,
+// returning the expiry. Users who already have a paid tier or trial are
+// left alone and get a zero time.
+func (s *TrialService) StartTrial(ctx context.Context, userID string) (time.Time, error) {
+	if !s.Enabled() {
+		return time.Time{}, nil
+	}
+
+	expires := time.Now().Add(s.config.Duration).UTC()
+	result, err := s.db.DB.ExecContext(ctx, `
+		UPDATE auth.users
+		SET tier = $2, trial_expires_at = $3, trial_warned_at = NULL,
+			enhancement_quota = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND trial_expires_at IS NULL AND COALESCE(tier, $5) = $5`,
+		userID, TierPro, expires, TierQuota(TierPro), TierFree)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to start trial: %w", err)
+	}
+	if started, _ := result.RowsAffected(); started == 0 {
+		return time.Time{}, nil
+	}
+
+	if s.accounts != nil {
+		s.accounts.Forget(userID)
+	}
+	s.logger.WithFields(logrus.Fields{
+		"user_id":    userID,
+		"expires_at": expires,
+	}).Info("Trial started")
+	return expires, nil
+}
+
+// TrialJob returns the scheduled job that warns and downgrades trial users
+func (s *TrialService) TrialJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "trials",
+		Interval: s.config.Interval,
+		Timeout:  trialJobTimeout,
+		Run: func(ctx context.Context) error {
+			return s.ProcessTrials(ctx, time.Now())
+		},
+	}
+}
+
+// ProcessTrials warns users whose trial ends within the warning period and
+// downgrades those whose grace period is over
+func (s *TrialService) ProcessTrials(ctx context.Context, now time.Time) error {
+	if err := s.warnExpiring(ctx, now); err != nil {
+		return err
+	}
+	return s.downgradeExpired(ctx, now)
+}
+
+// trialUser is a user the trial job acted on
+type trialUser struct {
+	id, email, username string
+	expires             time.Time
+}
+
+// warnExpiring marks and emails users whose trial ends soon. Marking first
+// means a failed email isn't retried, but no user is warned twice.
+func (s *TrialService) warnExpiring(ctx context.Context, now time.Time) error {
+	users, err := s.claimTrialUsers(ctx, `
+		UPDATE auth.users
+		SET trial_warned_at = CURRENT_TIMESTAMP
+		WHERE trial_expires_at IS NOT NULL AND trial_warned_at IS NULL
+		  AND trial_expires_at <= $1
+		RETURNING id, email, username, trial_expires_at`, now.Add(s.config.WarningPeriod))
+	if err != nil {
+		return fmt.Errorf("failed to mark expiring trials: %w", err)
+	}
+
+	for _, user := range users {
+		keepUntil := user.expires.Add(s.config.GracePeriod).UTC().Format("January 2, 2006 15:04")
+		s.notify(ctx, user, "Your Pro trial is ending",
+			fmt.Sprintf("Your BetterPrompts Pro trial ends on %s UTC. Upgrade before %s UTC to keep Pro features and higher limits; after that your account moves to the Free plan. Your prompt history is kept either way.",
+				user.expires.UTC().Format("January 2, 2006 15:04"), keepUntil))
+	}
+	if len(users) > 0 {
+		s.logger.WithField("users", len(users)).Info("Warned users of expiring trials")
+	}
+	return nil
+}
+
+// downgradeExpired returns users whose trial and grace period are over to
+// the free tier
+func (s *TrialService) downgradeExpired(ctx context.Context, now time.Time) error {
+	users, err := s.claimTrialUsers(ctx, `
+		UPDATE auth.users
+		SET tier = $2, enhancement_quota = $3, trial_expires_at = NULL,
+			trial_warned_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE trial_expires_at <= $1
+		RETURNING id, email, username, $1::timestamptz`,
+		now.Add(-s.config.GracePeriod), TierFree, TierQuota(TierFree))
+	if err != nil {
+		return fmt.Errorf("failed to downgrade expired trials: %w", err)
+	}
+
+	for _, user := range users {
+		if s.accounts != nil {
+			s.accounts.Forget(user.id)
+		}
+		s.notify(ctx, user, "Your Pro trial has ended",
+			"Your BetterPrompts Pro trial has ended and your account is now on the Free plan. You can upgrade to Pro at any time from your billing settings.")
+	}
+	if len(users) > 0 {
+		s.logger.WithField("users", len(users)).Info("Downgraded expired trials")
+	}
+	return nil
+}
+
+func (s *TrialService) claimTrialUsers(ctx context.Context, query string, args ...interface{}) ([]trialUser, error) {
+	rows, err := s.db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []trialUser
+	for rows.Next() {
+		var user trialUser
+		if err := rows.Scan(&user.id, &user.email, &user.username, &user.expires); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// notify emails a trial user; failures are only logged
+func (s *TrialService) notify(ctx context.Context, user trialUser, subject, message string) {
+	if s.email == nil {
+		return
+	}
+	if err := s.email.SendNoticeEmail(ctx, user.email, user.username, subject, subject, message); err != nil {
+		s.logger.WithError(err).WithField("user_id", user.id).Warn("Failed to send trial notification")
+	}
+}
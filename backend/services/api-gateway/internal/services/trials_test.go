@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTrialService(t *testing.T, rowsAffected int64) (*TrialService, *recordingDriver) {
+	db, d := newRecordingDB(t, func(string) int64 { return rowsAffected })
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	return NewTrialService(NewDatabaseService(db.DB), nil, nil, TrialConfig{
+		Duration:      14 * 24 * time.Hour,
+		WarningPeriod: 3 * 24 * time.Hour,
+		GracePeriod:   24 * time.Hour,
+		DailyQuota:    50,
+		Interval:      time.Hour,
+	}, logger), d
+}
+
+func TestStartTrial(t *testing.T) {
+	trials, d := newTestTrialService(t, 1)
+
+	expires, err := trials.StartTrial(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), expires, time.Minute)
+	require.Len(t, d.entries(), 1)
+	assert.Equal(t, TierPro, d.args[0][1].Value)
+
+	trials, _ = newTestTrialService(t, 0)
+	expires, err = trials.StartTrial(context.Background(), "user-1")
+	require.NoError(t, err)
+	assert.True(t, expires.IsZero(), "users with a tier or trial already keep it")
+
+	trials.config.Duration = 0
+	assert.False(t, trials.Enabled())
+}
+
+func TestProcessTrials(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+	trials, d := newTestTrialService(t, 1)
+	d.rows = func(query string) ([]string, [][]driver.Value) {
+		columns := []string{"id", "email", "username", "trial_expires_at"}
+		if strings.Contains(query, "SET trial_warned_at") {
+			return columns, [][]driver.Value{{"user-1", "a@example.com", "alice", now.Add(48 * time.Hour)}}
+		}
+		return columns, [][]driver.Value{{"user-2", "b@example.com", "bob", now}}
+	}
+
+	require.NoError(t, trials.ProcessTrials(context.Background(), now))
+
+	entries := d.entries()
+	require.Len(t, entries, 2)
+	assert.Contains(t, entries[0], "SET trial_warned_at")
+	assert.Equal(t, now.Add(3*24*time.Hour), d.args[0][0].Value, "users are warned within the warning period")
+	assert.Contains(t, entries[1], "trial_expires_at = NULL")
+	assert.Equal(t, now.Add(-24*time.Hour), d.args[1][0].Value, "users keep pro through the grace period")
+	assert.Equal(t, TierFree, d.args[1][1].Value)
+	assert.Equal(t, int64(100), d.args[1][2].Value)
+}
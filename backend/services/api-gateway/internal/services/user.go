@@ -36,6 +36,18 @@ func (s *UserService) CreateUser(ctx context.Context, req models.UserRegistratio
 		return nil, fmt.Errorf("password validation failed: %w", err)
 	}
 
+	// Validate username, including handles still redirecting after a rename
+	if err := ValidateUsername(req.Username); err != nil {
+		return nil, fmt.Errorf("username validation failed: %w", err)
+	}
+	availability, err := s.CheckUsernameAvailability(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if !availability.Available {
+		return nil, errors.New("username already exists")
+	}
+
 	// Hash password
 	passwordHash, err := auth.HashPassword(req.Password)
 	if err != nil {
@@ -55,7 +67,7 @@ func (s *UserService) CreateUser(ctx context.Context, req models.UserRegistratio
 	user := &models.User{
 		ID:               uuid.New().String(),
 		Email:            strings.ToLower(req.Email),
-		Username:         req.Username,
+		Username:         strings.TrimSpace(req.Username),
 		PasswordHash:     passwordHash,
 		FirstName:        sql.NullString{String: req.FirstName, Valid: req.FirstName != ""},
 		LastName:         sql.NullString{String: req.LastName, Valid: req.LastName != ""},
@@ -142,7 +154,7 @@ func (s *UserService) GetUserByID(ctx context.Context, userID string) (*models.U
 			roles, is_active, is_verified, email_verify_token,
 			password_reset_token, password_reset_expires, last_login_at,
 			failed_login_attempts, locked_until, preferences,
-			created_at, updated_at
+			avatar_url, created_at, updated_at
 		FROM auth.users
 		WHERE id = $1`
 
@@ -152,7 +164,7 @@ func (s *UserService) GetUserByID(ctx context.Context, userID string) (*models.U
 		&user.IsActive, &user.IsVerified, &user.EmailVerifyToken,
 		&user.PasswordResetToken, &user.PasswordResetExpires, &user.LastLoginAt,
 		&user.FailedLoginAttempts, &user.LockedUntil, &prefsJSON,
-		&user.CreatedAt, &user.UpdatedAt,
+		&user.AvatarURL, &user.CreatedAt, &user.UpdatedAt,
 	)
 
 	if err != nil {
@@ -258,13 +270,30 @@ func (s *UserService) GetUserByEmailOrUsername(ctx context.Context, emailOrUsern
 	return s.GetUserByUsername(ctx, emailOrUsername)
 }
 
-// UpdateUser updates user information
+// UpdateUser updates user information, whatever changed since it was read
 func (s *UserService) UpdateUser(ctx context.Context, userID string, req models.UserUpdateRequest) (*models.User, error) {
+	profile, err := s.UpdateProfile(ctx, userID, req, 0)
+	if err != nil {
+		return nil, err
+	}
+	return profile.User, nil
+}
+
+// UpdateProfile updates user information if the profile is still at
+// expectedVersion, returning ErrVersionConflict if it isn't. Zero skips the
+// check. Preference changes bump the preferences version too.
+func (s *UserService) UpdateProfile(ctx context.Context, userID string, req models.UserUpdateRequest, expectedVersion int64) (*Profile, error) {
 	// Get existing user
-	user, err := s.GetUserByID(ctx, userID)
+	profile, err := s.GetProfile(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	user := profile.User
+
+	// Check before the rename, which is written separately
+	if expectedVersion != 0 && profile.Version != expectedVersion {
+		return nil, ErrVersionConflict
+	}
 
 	// Update fields if provided
 	if req.FirstName != nil {
@@ -274,7 +303,12 @@ func (s *UserService) UpdateUser(ctx context.Context, userID string, req models.
 		user.LastName = sql.NullString{String: *req.LastName, Valid: *req.LastName != ""}
 	}
 	if req.Username != nil {
-		user.Username = *req.Username
+		// Renames go through the handle rules and cooldown, and leave a
+		// redirect from the old handle
+		if err := s.changeUsername(ctx, user.ID, user.Username, *req.Username); err != nil {
+			return nil, err
+		}
+		user.Username = strings.TrimSpace(*req.Username)
 	}
 	if req.Preferences != nil {
 		user.Preferences = *req.Preferences
@@ -291,15 +325,21 @@ func (s *UserService) UpdateUser(ctx context.Context, userID string, req models.
 	query := `
 		UPDATE auth.users SET
 			username = $2, first_name = $3, last_name = $4,
-			preferences = $5, updated_at = $6
-		WHERE id = $1`
+			preferences = $5, updated_at = $6,
+			profile_version = profile_version + 1,
+			preferences_version = preferences_version + CASE WHEN $7 THEN 1 ELSE 0 END
+		WHERE id = $1 AND ($8 = 0 OR profile_version = $8)
+		RETURNING profile_version`
 
-	_, err = s.db.DB.ExecContext(ctx, query,
+	err = s.db.DB.QueryRowContext(ctx, query,
 		user.ID, user.Username, user.FirstName, user.LastName,
-		prefsJSON, user.UpdatedAt,
-	)
+		prefsJSON, user.UpdatedAt, req.Preferences != nil, expectedVersion,
+	).Scan(&profile.Version)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrVersionConflict
+		}
 		if pqErr, ok := err.(*pq.Error); ok {
 			if pqErr.Code == "23505" && strings.Contains(pqErr.Error(), "username") {
 				return nil, errors.New("username already exists")
@@ -308,7 +348,7 @@ func (s *UserService) UpdateUser(ctx context.Context, userID string, req models.
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
 
-	return user, nil
+	return profile, nil
 }
 
 // UpdateLastLoginAt updates the user's last login time
@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// MaxUserListResults bounds one page of the admin user list
+	MaxUserListResults = 200
+
+	// MaxUserExportRows bounds a CSV export of the user list
+	MaxUserExportRows = 100000
+)
+
+// Orders the admin user list can be sorted in
+const (
+	UserSortCreatedAt = "created_at"
+	UserSortLastLogin = "last_login_at"
+	UserSortEmail     = "email"
+	UserSortUsername  = "username"
+)
+
+// ErrInvalidUserListQuery is returned for user list queries with an unknown
+// sort, contradictory bounds or a cursor from a different query
+var ErrInvalidUserListQuery = errors.New("invalid user list query")
+
+// userSortColumn is how the user list is ordered for one sort. Unique
+// columns page on their value alone; the others break ties on id, which
+// their indexes include.
+type userSortColumn struct {
+	expr   string
+	unique bool
+	time   bool
+}
+
+var userSortColumns = map[string]userSortColumn{
+	UserSortCreatedAt: {expr: "created_at", time: true},
+	// Users who never logged in sort as the oldest logins
+	UserSortLastLogin: {expr: "COALESCE(last_login_at, 'epoch'::timestamptz)", time: true},
+	UserSortEmail:     {expr: "email", unique: true},
+	UserSortUsername:  {expr: "username", unique: true},
+}
+
+// UserListQuery filters and orders the admin user list. Zero fields match
+// everything.
+type UserListQuery struct {
+	Search      string // Matched anywhere in the email or username, ignoring case
+	Tier        string
+	Role        string
+	Verified    *bool
+	Locked      *bool // Whether the account is locked out by failed logins right now
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Sort        string // One of the UserSort constants; UserSortCreatedAt when empty
+	Descending  bool
+	Cursor      string // A previous page's NextCursor
+	Limit       int
+}
+
+// UserSummary is one account as the admin user list shows it
+type UserSummary struct {
+	ID          string     `json:"id"`
+	Email       string     `json:"email"`
+	Username    string     `json:"username"`
+	FirstName   string     `json:"first_name,omitempty"`
+	LastName    string     `json:"last_name,omitempty"`
+	Roles       []string   `json:"roles"`
+	Tier        string     `json:"tier"`
+	IsActive    bool       `json:"is_active"`
+	IsVerified  bool       `json:"is_verified"`
+	Locked      bool       `json:"locked"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	sortValue interface{} // The value the page was ordered by, for its cursor
+}
+
+// UserListPage is one page of the admin user list
+type UserListPage struct {
+	Users      []*UserSummary `json:"users"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Pass as ?cursor= for the next page; empty after the last
+}
+
+// userListCursor is where a page ended. It records the sort it was made
+// for, so a cursor can't be replayed against a different order.
+type userListCursor struct {
+	Sort       string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Value      string `json:"v"`
+	ID         string `json:"id"`
+}
+
+// ListUsers returns a page of accounts matching query. Pages are keyed on
+// the sort column rather than offset, so they stay fast and stable however
+// deep an admin pages while accounts are added.
+func (s *UserService) ListUsers(ctx context.Context, query UserListQuery) (*UserListPage, error) {
+	if query.Sort == "" {
+		query.Sort = UserSortCreatedAt
+	}
+	column, ok := userSortColumns[query.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidUserListQuery, query.Sort)
+	}
+	if !query.CreatedFrom.IsZero() && !query.CreatedTo.IsZero() && !query.CreatedFrom.Before(query.CreatedTo) {
+		return nil, fmt.Errorf("%w: created_from must be before created_to", ErrInvalidUserListQuery)
+	}
+	if query.Limit <= 0 || query.Limit > MaxUserListResults {
+		query.Limit = MaxUserListResults
+	}
+
+	var args sqlArgs
+	conditions := []string{"TRUE"}
+	if search := strings.TrimSpace(query.Search); search != "" {
+		pattern := args.add("%" + escapeLike(search) + "%")
+		conditions = append(conditions, "(email ILIKE "+pattern+" OR username ILIKE "+pattern+")")
+	}
+	if query.Tier != "" {
+		conditions = append(conditions, "tier = "+args.add(query.Tier))
+	}
+	if query.Role != "" {
+		conditions = append(conditions, "roles @> ARRAY["+args.add(query.Role)+"]::text[]")
+	}
+	if query.Verified != nil {
+		conditions = append(conditions, "is_verified = "+args.add(*query.Verified))
+	}
+	if query.Locked != nil {
+		if *query.Locked {
+			conditions = append(conditions, "locked_until > NOW()")
+		} else {
+			conditions = append(conditions, "(locked_until IS NULL OR locked_until <= NOW())")
+		}
+	}
+	if !query.CreatedFrom.IsZero() {
+		conditions = append(conditions, "created_at >= "+args.add(query.CreatedFrom))
+	}
+	if !query.CreatedTo.IsZero() {
+		conditions = append(conditions, "created_at < "+args.add(query.CreatedTo))
+	}
+
+	direction, after := "ASC", ">"
+	if query.Descending {
+		direction, after = "DESC", "<"
+	}
+	if query.Cursor != "" {
+		cursor, err := decodeUserListCursor(query.Cursor)
+		if err != nil || cursor.Sort != query.Sort || cursor.Descending != query.Descending {
+			return nil, fmt.Errorf("%w: cursor does not match the query", ErrInvalidUserListQuery)
+		}
+		var value interface{} = cursor.Value
+		if column.time {
+			if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidUserListQuery)
+			}
+		}
+		if column.unique {
+			conditions = append(conditions, column.expr+" "+after+" "+args.add(value))
+		} else {
+			conditions = append(conditions, "("+column.expr+", id) "+after+" ("+args.add(value)+", "+args.add(cursor.ID)+")")
+		}
+	}
+
+	order := column.expr + " " + direction
+	if !column.unique {
+		order += ", id " + direction
+	}
+
+	// One extra row tells whether there is a next page
+	rows, err := s.db.DB.QueryContext(ctx, `
+		SELECT id, email, username, COALESCE(first_name, ''), COALESCE(last_name, ''),
+			roles, tier, is_active, is_verified, locked_until, last_login_at, created_at
+		FROM auth.users
+		WHERE `+strings.Join(conditions, " AND ")+`
+		ORDER BY `+order+`
+		LIMIT `+args.add(query.Limit+1), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	page := &UserListPage{Users: []*UserSummary{}}
+	for rows.Next() {
+		var u UserSummary
+		var lockedUntil, lastLogin sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.FirstName, &u.LastName, pq.Array(&u.Roles),
+			&u.Tier, &u.IsActive, &u.IsVerified, &lockedUntil, &lastLogin, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if lockedUntil.Valid {
+			u.LockedUntil = &lockedUntil.Time
+			u.Locked = lockedUntil.Time.After(now)
+		}
+		if lastLogin.Valid {
+			u.LastLoginAt = &lastLogin.Time
+		}
+		switch query.Sort {
+		case UserSortCreatedAt:
+			u.sortValue = u.CreatedAt
+		case UserSortLastLogin:
+			u.sortValue = time.Unix(0, 0).UTC()
+			if u.LastLoginAt != nil {
+				u.sortValue = *u.LastLoginAt
+			}
+		case UserSortEmail:
+			u.sortValue = u.Email
+		case UserSortUsername:
+			u.sortValue = u.Username
+		}
+		page.Users = append(page.Users, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	if len(page.Users) > query.Limit {
+		page.Users = page.Users[:query.Limit]
+		last := page.Users[query.Limit-1]
+		page.NextCursor = encodeUserListCursor(query, last)
+	}
+	return page, nil
+}
+
+// ExportUsers returns every account matching query, in its order, up to
+// MaxUserExportRows; truncated reports whether there were more
+func (s *UserService) ExportUsers(ctx context.Context, query UserListQuery) (users []*UserSummary, truncated bool, err error) {
+	query.Cursor, query.Limit = "", MaxUserListResults
+	for {
+		page, err := s.ListUsers(ctx, query)
+		if err != nil {
+			return nil, false, err
+		}
+		users = append(users, page.Users...)
+		if len(users) >= MaxUserExportRows {
+			return users[:MaxUserExportRows], len(users) > MaxUserExportRows || page.NextCursor != "", nil
+		}
+		if page.NextCursor == "" {
+			return users, false, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func encodeUserListCursor(query UserListQuery, last *UserSummary) string {
+	cursor := userListCursor{Sort: query.Sort, Descending: query.Descending, ID: last.ID}
+	switch v := last.sortValue.(type) {
+	case time.Time:
+		cursor.Value = v.UTC().Format(time.RFC3339Nano)
+	case string:
+		cursor.Value = v
+	}
+	encoded, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+func decodeUserListCursor(s string) (*userListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor userListCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// RenderUserListCSV renders accounts as CSV, one per row, roles separated
+// by semicolons
+func RenderUserListCSV(users []*UserSummary) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"id", "email", "username", "first_name", "last_name", "roles", "tier",
+		"is_active", "is_verified", "locked", "locked_until", "last_login_at", "created_at"})
+	for _, u := range users {
+		w.Write([]string{u.ID, csvSafe(u.Email), csvSafe(u.Username), csvSafe(u.FirstName), csvSafe(u.LastName),
+			strings.Join(u.Roles, ";"), u.Tier, strconv.FormatBool(u.IsActive), strconv.FormatBool(u.IsVerified),
+			strconv.FormatBool(u.Locked), formatOptionalTime(u.LockedUntil), formatOptionalTime(u.LastLoginAt),
+			u.CreatedAt.UTC().Format(time.RFC3339)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to render user list: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// csvSafe keeps user-entered text from being read as a formula when the
+// export is opened in a spreadsheet
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
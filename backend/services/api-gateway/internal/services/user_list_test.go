@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	userColumns := []string{"id", "email", "username", "first_name", "last_name", "roles", "tier",
+		"is_active", "is_verified", "locked_until", "last_login_at", "created_at"}
+
+	t.Run("filters and pages by created_at", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		users := NewUserService(NewDatabaseService(db.DB), nil)
+		lockedUntil := time.Now().Add(time.Hour)
+		d.rows = func(string) ([]string, [][]driver.Value) {
+			return userColumns, [][]driver.Value{
+				{"u3", "c@example.com", "carol", "Carol", "", "{admin,user}", "pro", true, true, lockedUntil, nil, created.Add(2 * time.Hour)},
+				{"u2", "b@example.com", "bob", "", "", "{user}", "pro", true, true, nil, created, created.Add(time.Hour)},
+				{"u1", "a@example.com", "alice", "", "", "{user}", "pro", true, true, nil, nil, created},
+			}
+		}
+
+		verified := true
+		page, err := users.ListUsers(ctx, UserListQuery{
+			Search:      "50%_off",
+			Tier:        TierPro,
+			Role:        "admin",
+			Verified:    &verified,
+			CreatedFrom: created.Add(-24 * time.Hour),
+			Descending:  true,
+			Limit:       2,
+		})
+		require.NoError(t, err)
+
+		entries := d.entries()
+		require.Len(t, entries, 1)
+		assert.Contains(t, entries[0], "WHERE TRUE AND (email ILIKE $1 OR username ILIKE $1) AND tier = $2 AND roles @> ARRAY[$3]::text[] AND is_verified = $4 AND created_at >= $5 ORDER BY created_at DESC, id DESC LIMIT $6")
+		args := d.args[0]
+		assert.Equal(t, `%50\%\_off%`, args[0].Value)
+		assert.Equal(t, "admin", args[2].Value)
+		assert.Equal(t, int64(3), args[5].Value)
+
+		require.Len(t, page.Users, 2)
+		assert.Equal(t, []string{"admin", "user"}, page.Users[0].Roles)
+		assert.True(t, page.Users[0].Locked)
+		assert.Nil(t, page.Users[0].LastLoginAt)
+		assert.False(t, page.Users[1].Locked)
+		require.NotEmpty(t, page.NextCursor)
+
+		// The next page continues after the last user, on created_at then id
+		_, err = users.ListUsers(ctx, UserListQuery{Descending: true, Cursor: page.NextCursor, Limit: 2})
+		require.NoError(t, err)
+		entries = d.entries()
+		require.Len(t, entries, 2)
+		assert.Contains(t, entries[1], "WHERE TRUE AND (created_at, id) < ($1, $2) ORDER BY created_at DESC, id DESC LIMIT $3")
+		assert.True(t, created.Add(time.Hour).Equal(d.args[1][0].Value.(time.Time)))
+		assert.Equal(t, "u2", d.args[1][1].Value)
+	})
+
+	t.Run("unique sort columns page on their value alone", func(t *testing.T) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		users := NewUserService(NewDatabaseService(db.DB), nil)
+		d.rows = func(string) ([]string, [][]driver.Value) {
+			return userColumns, [][]driver.Value{
+				{"u1", "a@example.com", "alice", "", "", "{user}", "free", true, false, nil, nil, created},
+				{"u2", "b@example.com", "bob", "", "", "{user}", "free", true, false, nil, nil, created},
+			}
+		}
+		locked := false
+		page, err := users.ListUsers(ctx, UserListQuery{Sort: UserSortEmail, Locked: &locked, Limit: 1})
+		require.NoError(t, err)
+		_, err = users.ListUsers(ctx, UserListQuery{Sort: UserSortEmail, Locked: &locked, Cursor: page.NextCursor, Limit: 1})
+		require.NoError(t, err)
+
+		entries := d.entries()
+		require.Len(t, entries, 2)
+		assert.Contains(t, entries[0], "(locked_until IS NULL OR locked_until <= NOW()) ORDER BY email ASC LIMIT $1")
+		assert.Contains(t, entries[1], "AND email > $1 ORDER BY email ASC LIMIT $2")
+		assert.Equal(t, "a@example.com", d.args[1][0].Value)
+	})
+
+	t.Run("rejects invalid queries", func(t *testing.T) {
+		users := &UserService{}
+		_, err := users.ListUsers(ctx, UserListQuery{Sort: "password_hash"})
+		assert.ErrorIs(t, err, ErrInvalidUserListQuery)
+		_, err = users.ListUsers(ctx, UserListQuery{CreatedFrom: created, CreatedTo: created.Add(-time.Hour)})
+		assert.ErrorIs(t, err, ErrInvalidUserListQuery)
+		_, err = users.ListUsers(ctx, UserListQuery{Cursor: "not a cursor"})
+		assert.ErrorIs(t, err, ErrInvalidUserListQuery)
+
+		// A cursor only continues the order it was made for
+		cursor := encodeUserListCursor(UserListQuery{Sort: UserSortEmail}, &UserSummary{ID: "u1", sortValue: "a@example.com"})
+		_, err = users.ListUsers(ctx, UserListQuery{Sort: UserSortUsername, Cursor: cursor})
+		assert.ErrorIs(t, err, ErrInvalidUserListQuery)
+	})
+}
+
+func TestRenderUserListCSV(t *testing.T) {
+	login := time.Date(2026, 3, 2, 9, 30, 0, 0, time.UTC)
+	body, err := RenderUserListCSV([]*UserSummary{{
+		ID:          "u1",
+		Email:       "a@example.com",
+		Username:    "alice",
+		FirstName:   "=HYPERLINK(\"x\")",
+		Roles:       []string{"admin", "user"},
+		Tier:        TierEnterprise,
+		IsActive:    true,
+		LastLoginAt: &login,
+		CreatedAt:   time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "id,email,username,first_name,last_name,roles,tier,is_active,is_verified,locked,locked_until,last_login_at,created_at", lines[0])
+	assert.Equal(t, `u1,a@example.com,alice,"'=HYPERLINK(""x"")",,admin;user,enterprise,true,false,false,,2026-03-02T09:30:00Z,2026-03-01T12:00:00Z`, lines[1])
+}
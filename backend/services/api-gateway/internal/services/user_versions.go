@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/betterprompts/api-gateway/internal/models"
+)
+
+// ErrVersionConflict is returned when an update names a version the
+// resource has moved on from, because another request changed it first
+var ErrVersionConflict = errors.New("modified by another request")
+
+// Profile is a user with the version of their profile. The version goes up
+// with every profile or preferences change.
+type Profile struct {
+	*models.User
+	Version int64 `json:"version"`
+}
+
+// UserPreferences is a user's preferences with their version
+type UserPreferences struct {
+	Preferences map[string]interface{} `json:"preferences"`
+	Version     int64                  `json:"version"`
+}
+
+// GetProfile returns a user and their profile version
+func (s *UserService) GetProfile(ctx context.Context, userID string) (*Profile, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &Profile{User: user}
+	query := `SELECT profile_version FROM auth.users WHERE id = $1`
+	if err := s.db.DB.QueryRowContext(ctx, query, userID).Scan(&profile.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get profile version: %w", err)
+	}
+	return profile, nil
+}
+
+// GetPreferences returns a user's preferences and their version
+func (s *UserService) GetPreferences(ctx context.Context, userID string) (*UserPreferences, error) {
+	var prefsJSON []byte
+	prefs := &UserPreferences{}
+
+	query := `SELECT preferences, preferences_version FROM auth.users WHERE id = $1`
+	if err := s.db.DB.QueryRowContext(ctx, query, userID).Scan(&prefsJSON, &prefs.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to get preferences: %w", err)
+	}
+
+	if err := json.Unmarshal(prefsJSON, &prefs.Preferences); err != nil || prefs.Preferences == nil {
+		prefs.Preferences = make(map[string]interface{})
+	}
+	return prefs, nil
+}
+
+// UpdatePreferences replaces a user's preferences if they are still at
+// expectedVersion, returning ErrVersionConflict if they aren't. Zero skips
+// the check. The profile version goes up too, as profiles include
+// preferences.
+func (s *UserService) UpdatePreferences(ctx context.Context, userID string, preferences map[string]interface{}, expectedVersion int64) (*UserPreferences, error) {
+	if preferences == nil {
+		preferences = make(map[string]interface{})
+	}
+	prefsJSON, err := json.Marshal(preferences)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal preferences: %w", err)
+	}
+
+	query := `
+		UPDATE auth.users SET
+			preferences = $2, updated_at = $3,
+			preferences_version = preferences_version + 1,
+			profile_version = profile_version + 1
+		WHERE id = $1 AND ($4 = 0 OR preferences_version = $4)
+		RETURNING preferences_version`
+
+	prefs := &UserPreferences{Preferences: preferences}
+	err = s.db.DB.QueryRowContext(ctx, query, userID, prefsJSON, time.Now(), expectedVersion).Scan(&prefs.Version)
+	if err == sql.ErrNoRows {
+		// Either the user is gone or the version moved on
+		if _, getErr := s.GetPreferences(ctx, userID); getErr != nil {
+			return nil, getErr
+		}
+		return nil, ErrVersionConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update preferences: %w", err)
+	}
+	return prefs, nil
+}
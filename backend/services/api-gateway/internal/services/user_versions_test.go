@@ -0,0 +1,56 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdatePreferencesVersions(t *testing.T) {
+	ctx := context.Background()
+
+	// newUsers answers the preference queries: the guarded UPDATE returns
+	// updated's rows, the SELECT the stored preferences at version 7
+	newUsers := func(t *testing.T, updated [][]driver.Value) (*UserService, *recordingDriver) {
+		db, d := newRecordingDB(t, func(string) int64 { return 0 })
+		d.rows = func(query string) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "UPDATE auth.users") {
+				return []string{"preferences_version"}, updated
+			}
+			return []string{"preferences", "preferences_version"}, [][]driver.Value{{[]byte(`{"theme":"dark"}`), int64(7)}}
+		}
+		return NewUserService(NewDatabaseService(db.DB), nil), d
+	}
+
+	t.Run("matching version updates and bumps it", func(t *testing.T) {
+		users, d := newUsers(t, [][]driver.Value{{int64(8)}})
+
+		prefs, err := users.UpdatePreferences(ctx, "user-1", map[string]interface{}{"theme": "light"}, 7)
+		require.NoError(t, err)
+		assert.Equal(t, int64(8), prefs.Version)
+		assert.Equal(t, "light", prefs.Preferences["theme"])
+
+		require.Len(t, d.args, 1)
+		assert.Equal(t, int64(7), d.args[0][3].Value, "the expected version guards the update")
+	})
+
+	t.Run("stale version conflicts", func(t *testing.T) {
+		users, _ := newUsers(t, nil)
+
+		_, err := users.UpdatePreferences(ctx, "user-1", map[string]interface{}{"theme": "light"}, 6)
+		assert.ErrorIs(t, err, ErrVersionConflict)
+	})
+
+	t.Run("current preferences carry their version", func(t *testing.T) {
+		users, _ := newUsers(t, nil)
+
+		prefs, err := users.GetPreferences(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(7), prefs.Version)
+		assert.Equal(t, map[string]interface{}{"theme": "dark"}, prefs.Preferences)
+	})
+}
@@ -0,0 +1,290 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 50
+
+	// usernameRedirectRetention is how long an old handle keeps redirecting
+	// to its owner's new one, and stays unavailable to everyone else
+	usernameRedirectRetention = 180 * 24 * time.Hour
+
+	// AvailabilityCacheTTL is short enough that a handle claimed elsewhere
+	// shows as taken quickly, long enough to absorb as-you-type checks
+	AvailabilityCacheTTL = 15 * time.Second
+)
+
+// Availability reasons
+const (
+	AvailabilityInvalid = "invalid"
+	AvailabilityTaken   = "taken"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedUsernames can't be registered because they collide with routes or
+// could be mistaken for staff accounts
+var reservedUsernames = map[string]bool{
+	"about": true, "account": true, "admin": true, "administrator": true,
+	"api": true, "app": true, "auth": true, "betterprompts": true,
+	"billing": true, "dashboard": true, "developer": true, "docs": true,
+	"help": true, "history": true, "login": true, "logout": true,
+	"me": true, "moderator": true, "null": true, "privacy": true,
+	"register": true, "root": true, "security": true, "settings": true,
+	"signup": true, "staff": true, "support": true, "system": true,
+	"terms": true, "undefined": true, "user": true, "users": true,
+}
+
+// UsernameCooldownError is returned when a user changes their username again
+// before the cooldown has passed
+type UsernameCooldownError struct {
+	RetryAt time.Time
+}
+
+func (e *UsernameCooldownError) Error() string {
+	return fmt.Sprintf("username can be changed again after %s", e.RetryAt.UTC().Format(time.RFC3339))
+}
+
+// Availability reports whether a username or email can be claimed
+type Availability struct {
+	Value     string `json:"value"`
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// NormalizeUsername returns the form usernames are compared in
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// ValidateUsername checks a username against the handle rules: 3-50
+// letters, numbers, underscores or hyphens starting with a letter or number,
+// and not reserved
+func ValidateUsername(username string) error {
+	normalized := NormalizeUsername(username)
+	if len(normalized) < minUsernameLength || len(normalized) > maxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters", minUsernameLength, maxUsernameLength)
+	}
+	if !usernamePattern.MatchString(normalized) {
+		return errors.New("username may only contain letters, numbers, underscores and hyphens, and must start with a letter or number")
+	}
+	if reservedUsernames[normalized] {
+		return errors.New("username is reserved")
+	}
+	return nil
+}
+
+// usernameChangeCooldown reads USERNAME_CHANGE_COOLDOWN, defaulting to 30 days
+func usernameChangeCooldown() time.Duration {
+	if d, err := time.ParseDuration(getEnv("USERNAME_CHANGE_COOLDOWN", "")); err == nil && d >= 0 {
+		return d
+	}
+	return 30 * 24 * time.Hour
+}
+
+// CheckUsernameAvailability reports whether username is valid and unclaimed.
+// Recently released handles stay unavailable while they redirect.
+func (s *UserService) CheckUsernameAvailability(ctx context.Context, username string) (*Availability, error) {
+	availability := &Availability{Value: strings.TrimSpace(username)}
+
+	if err := ValidateUsername(username); err != nil {
+		availability.Reason = AvailabilityInvalid
+		availability.Message = err.Error()
+		return availability, nil
+	}
+
+	var taken bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(username) = $1)
+			OR EXISTS (SELECT 1 FROM auth.username_redirects WHERE username = $1 AND expires_at > CURRENT_TIMESTAMP)`
+	if err := s.db.DB.QueryRowContext(ctx, query, NormalizeUsername(username)).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("failed to check username: %w", err)
+	}
+
+	if taken {
+		availability.Reason = AvailabilityTaken
+		availability.Message = "username is already taken"
+		return availability, nil
+	}
+
+	availability.Available = true
+	return availability, nil
+}
+
+// CheckEmailAvailability reports whether email is valid and not in use or
+// pending as another account's new address
+func (s *UserService) CheckEmailAvailability(ctx context.Context, email string) (*Availability, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	availability := &Availability{Value: email}
+
+	if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+		availability.Reason = AvailabilityInvalid
+		availability.Message = "email address is invalid"
+		return availability, nil
+	}
+
+	var taken bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM auth.users WHERE LOWER(email) = $1)
+			OR EXISTS (SELECT 1 FROM auth.email_changes WHERE new_email = $1 AND expires_at > CURRENT_TIMESTAMP)`
+	if err := s.db.DB.QueryRowContext(ctx, query, email).Scan(&taken); err != nil {
+		return nil, fmt.Errorf("failed to check email: %w", err)
+	}
+
+	if taken {
+		availability.Reason = AvailabilityTaken
+		availability.Message = "email is already registered"
+		return availability, nil
+	}
+
+	availability.Available = true
+	return availability, nil
+}
+
+// changeUsername switches userID's handle from oldUsername, enforcing the
+// cooldown and leaving a redirect from the old handle. Case-only changes
+// keep the same handle, so they skip both.
+func (s *UserService) changeUsername(ctx context.Context, userID, oldUsername, newUsername string) error {
+	if NormalizeUsername(oldUsername) == NormalizeUsername(newUsername) {
+		return nil
+	}
+	if err := ValidateUsername(newUsername); err != nil {
+		return fmt.Errorf("username validation failed: %w", err)
+	}
+
+	if cooldown := usernameChangeCooldown(); cooldown > 0 {
+		var lastChange sql.NullTime
+		query := `SELECT MAX(created_at) FROM auth.username_redirects WHERE user_id = $1`
+		if err := s.db.DB.QueryRowContext(ctx, query, userID).Scan(&lastChange); err != nil {
+			return fmt.Errorf("failed to check last username change: %w", err)
+		}
+		if lastChange.Valid && time.Since(lastChange.Time) < cooldown {
+			return &UsernameCooldownError{RetryAt: lastChange.Time.Add(cooldown)}
+		}
+	}
+
+	// A handle held by someone else's redirect isn't free yet; the user's
+	// own old handles can be reclaimed
+	var held bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM auth.username_redirects
+			WHERE username = $1 AND user_id <> $2 AND expires_at > CURRENT_TIMESTAMP
+		)`
+	if err := s.db.DB.QueryRowContext(ctx, query, NormalizeUsername(newUsername), userID).Scan(&held); err != nil {
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+	if held {
+		return errors.New("username already exists")
+	}
+
+	tx, err := s.db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE auth.users SET username = $2, updated_at = $3 WHERE id = $1`,
+		userID, strings.TrimSpace(newUsername), time.Now())
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return errors.New("username already exists")
+		}
+		return fmt.Errorf("failed to update username: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM auth.username_redirects WHERE username = $1`, NormalizeUsername(newUsername)); err != nil {
+		return fmt.Errorf("failed to reclaim username: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO auth.username_redirects (username, user_id, expires_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO UPDATE
+		SET user_id = EXCLUDED.user_id, created_at = CURRENT_TIMESTAMP, expires_at = EXCLUDED.expires_at`,
+		NormalizeUsername(oldUsername), userID, time.Now().Add(usernameRedirectRetention))
+	if err != nil {
+		return fmt.Errorf("failed to store username redirect: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit username change: %w", err)
+	}
+	return nil
+}
+
+// ResolveUsername maps a handle from a share URL to its owner's current
+// username. renamed is true when handle is a retired username that
+// redirects.
+func (s *UserService) ResolveUsername(ctx context.Context, handle string) (current string, renamed bool, err error) {
+	normalized := NormalizeUsername(handle)
+
+	err = s.db.DB.QueryRowContext(ctx, `SELECT username FROM auth.users WHERE LOWER(username) = $1`, normalized).Scan(&current)
+	if err == nil {
+		return current, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", false, fmt.Errorf("failed to resolve username: %w", err)
+	}
+
+	query := `
+		SELECT u.username
+		FROM auth.username_redirects r
+		JOIN auth.users u ON u.id = r.user_id
+		WHERE r.username = $1 AND r.expires_at > CURRENT_TIMESTAMP`
+	err = s.db.DB.QueryRowContext(ctx, query, normalized).Scan(&current)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", false, errors.New("user not found")
+		}
+		return "", false, fmt.Errorf("failed to resolve username redirect: %w", err)
+	}
+	return current, true, nil
+}
+
+// GetCachedAvailability returns a recent availability check of kind
+// ("username" or "email") for value, or nil when there is none
+func (c *CacheService) GetCachedAvailability(ctx context.Context, kind, value string) (*Availability, error) {
+	data, err := c.client.Get(ctx, c.Key("availability", kind, strings.ToLower(value))).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get cached availability: %w", err)
+	}
+
+	var availability Availability
+	if err := json.Unmarshal(data, &availability); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal availability: %w", err)
+	}
+	return &availability, nil
+}
+
+// CacheAvailability stores an availability check for AvailabilityCacheTTL
+func (c *CacheService) CacheAvailability(ctx context.Context, kind string, availability *Availability) error {
+	data, err := json.Marshal(availability)
+	if err != nil {
+		return fmt.Errorf("failed to marshal availability: %w", err)
+	}
+
+	key := c.Key("availability", kind, strings.ToLower(availability.Value))
+	if err := c.client.Set(ctx, key, data, AvailabilityCacheTTL).Err(); err != nil {
+		return fmt.Errorf("failed to cache availability: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		valid    bool
+	}{
+		{"simple", "alice", true},
+		{"mixed case and digits", "Alice42", true},
+		{"underscore and hyphen", "prompt_fan-1", true},
+		{"surrounding whitespace", "  alice  ", true},
+		{"too short", "ab", false},
+		{"too long", strings.Repeat("a", 51), false},
+		{"leading underscore", "_alice", false},
+		{"dot", "alice.b", false},
+		{"space", "alice b", false},
+		{"non-ascii", "ålice", false},
+		{"reserved", "admin", false},
+		{"reserved any case", "Support", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	assert.Equal(t, "alice", NormalizeUsername("  Alice "))
+}
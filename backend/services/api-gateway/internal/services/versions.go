@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BuildVersion is the gateway build version, set at build time with
+// -ldflags "-X github.com/betterprompts/api-gateway/internal/services.BuildVersion=<version>"
+var BuildVersion = "dev"
+
+// versionCheckTimeout bounds each component version lookup
+const versionCheckTimeout = 3 * time.Second
+
+// PipelineVersions identifies the component versions that produced an enhancement
+type PipelineVersions struct {
+	Gateway string `json:"gateway"`
+	Rules   string `json:"rules,omitempty"`
+	Model   string `json:"model,omitempty"`
+}
+
+// ComponentVersion reports the deployed version of one pipeline component
+type ComponentVersion struct {
+	Status  string            `json:"status"` // "ok" or "unavailable"
+	Version string            `json:"version,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// RulesVersion returns the rules version the selector reported with its last
+// selection, empty before the first selection
+func (c *TechniqueSelectorClient) RulesVersion() string {
+	version, _ := c.rulesVersion.Load().(string)
+	return version
+}
+
+// RulesVersion returns the technique selector rules version, empty when the
+// configured selector doesn't report one
+func (s *ServiceClients) RulesVersion() string {
+	if selector, ok := s.TechniqueSelector.(interface{ RulesVersion() string }); ok {
+		return selector.RulesVersion()
+	}
+	return ""
+}
+
+// componentVersionSource describes where a component reports its version
+type componentVersionSource struct {
+	name         string
+	url          string
+	versionField string
+	detailFields []string
+}
+
+// DeployedVersions asks every pipeline component for its currently deployed
+// version. Components that can't be reached are reported as unavailable
+// rather than failing the whole lookup.
+func (s *ServiceClients) DeployedVersions(ctx context.Context) map[string]ComponentVersion {
+	sources := []componentVersionSource{
+		{name: "intent_classifier", url: s.IntentClassifierURL + "/", versionField: "version"},
+		{name: "technique_selector", url: s.TechniqueSelectorURL + "/api/v1/version", versionField: "rules_version", detailFields: []string{"catalog_version"}},
+		{name: "prompt_generator", url: s.PromptGeneratorURL + "/health", versionField: "version"},
+	}
+
+	versions := map[string]ComponentVersion{
+		"api_gateway": {Status: "ok", Version: BuildVersion},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source componentVersionSource) {
+			defer wg.Done()
+			version := s.fetchComponentVersion(ctx, source)
+			mu.Lock()
+			versions[source.name] = version
+			mu.Unlock()
+		}(source)
+	}
+	wg.Wait()
+
+	return versions
+}
+
+func (s *ServiceClients) fetchComponentVersion(ctx context.Context, source componentVersionSource) ComponentVersion {
+	ctx, cancel := context.WithTimeout(ctx, versionCheckTimeout)
+	defer cancel()
+
+	unavailable := func(err error) ComponentVersion {
+		return ComponentVersion{Status: "unavailable", Error: err.Error()}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.url, nil)
+	if err != nil {
+		return unavailable(err)
+	}
+	setCallerHeaders(ctx, req)
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unavailable(fmt.Errorf("returned status %d", resp.StatusCode))
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return unavailable(fmt.Errorf("invalid version response: %w", err))
+	}
+
+	version := ComponentVersion{Status: "ok"}
+	version.Version, _ = body[source.versionField].(string)
+	for _, field := range source.detailFields {
+		if value, ok := body[field].(string); ok {
+			if version.Details == nil {
+				version.Details = make(map[string]string)
+			}
+			version.Details[field] = value
+		}
+	}
+	return version
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeployedVersions(t *testing.T) {
+	selector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/version", r.URL.Path)
+		w.Write([]byte(`{"service":"technique-selector","rules_version":"abc123","catalog_version":"def456"}`))
+	}))
+	defer selector.Close()
+
+	generator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer generator.Close()
+
+	clients := &ServiceClients{
+		IntentClassifierURL:  "http://127.0.0.1:0",
+		TechniqueSelectorURL: selector.URL,
+		PromptGeneratorURL:   generator.URL,
+	}
+
+	versions := clients.DeployedVersions(context.Background())
+
+	assert.Equal(t, ComponentVersion{Status: "ok", Version: BuildVersion}, versions["api_gateway"])
+	assert.Equal(t, ComponentVersion{
+		Status:  "ok",
+		Version: "abc123",
+		Details: map[string]string{"catalog_version": "def456"},
+	}, versions["technique_selector"])
+	assert.Equal(t, "unavailable", versions["prompt_generator"].Status)
+	assert.Contains(t, versions["prompt_generator"].Error, "503")
+	assert.Equal(t, "unavailable", versions["intent_classifier"].Status)
+}
+
+func TestServiceClientsRulesVersion(t *testing.T) {
+	selector := &TechniqueSelectorClient{}
+	clients := &ServiceClients{TechniqueSelector: selector}
+	assert.Empty(t, clients.RulesVersion())
+
+	selector.rulesVersion.Store("abc123")
+	assert.Equal(t, "abc123", clients.RulesVersion())
+
+	clients.TechniqueSelector = nil
+	assert.Empty(t, clients.RulesVersion())
+}
@@ -0,0 +1,614 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// warehouseRowGroupSize is how many rows are buffered per Parquet row group
+	warehouseRowGroupSize = 50_000
+
+	// warehouseExportStaleAfter is when a run still marked running is assumed
+	// to have died with its instance
+	warehouseExportStaleAfter = 2 * time.Hour
+
+	// WarehouseExportTimeout bounds an export of every dataset for one day
+	WarehouseExportTimeout = 90 * time.Minute
+)
+
+var (
+	// ErrWarehouseExportNotFound is returned for unknown export runs
+	ErrWarehouseExportNotFound = errors.New("export not found")
+
+	// ErrWarehouseExportRunning is returned when the same day of a dataset is
+	// already being exported
+	ErrWarehouseExportRunning = errors.New("export already running")
+
+	// ErrWarehouseExportsDisabled is returned when no anonymization key is set
+	ErrWarehouseExportsDisabled = errors.New("warehouse exports need EXPORT_ANONYMIZATION_KEY")
+)
+
+// WarehouseExport is one run exporting a day of a dataset
+type WarehouseExport struct {
+	ID            string          `json:"id"`
+	Dataset       string          `json:"dataset"`
+	ExportDate    string          `json:"export_date"`
+	SchemaVersion int             `json:"schema_version"`
+	Columns       []ParquetColumn `json:"columns"`
+	Status        string          `json:"status"` // running, succeeded or failed
+	ObjectKey     string          `json:"object_key,omitempty"`
+	RowCount      int64           `json:"row_count"`
+	SizeBytes     int64           `json:"size_bytes"`
+	Error         string          `json:"error,omitempty"`
+	TriggeredBy   string          `json:"triggered_by"`
+	StartedAt     time.Time       `json:"started_at"`
+	CompletedAt   *time.Time      `json:"completed_at,omitempty"`
+}
+
+// warehouseDataset is a table exported to the warehouse. Columns may only
+// grow by appending optional columns; anything else needs a new Version,
+// which starts a new path so old and new files are never mixed.
+type warehouseDataset struct {
+	Name    string
+	Version int
+	Columns []ParquetColumn
+
+	// Query selects one day's rows given its start and end
+	Query string
+	Scan  func(s *WarehouseExportService, row rowScanner) ([]interface{}, error)
+}
+
+// warehouseDatasets are exported in this order
+var warehouseDatasets = []warehouseDataset{
+	{
+		Name:    "prompt_history",
+		Version: 1,
+		Columns: []ParquetColumn{
+			{Name: "id", Type: ParquetString},
+			{Name: "user_hash", Type: ParquetString, Optional: true},
+			{Name: "created_at", Type: ParquetTimestamp},
+			{Name: "intent", Type: ParquetString, Optional: true},
+			{Name: "intent_confidence", Type: ParquetDouble, Optional: true},
+			{Name: "complexity", Type: ParquetString, Optional: true},
+			{Name: "techniques", Type: ParquetString, Optional: true},
+			{Name: "original_input", Type: ParquetString},
+			{Name: "enhanced_output", Type: ParquetString},
+			{Name: "processing_time_ms", Type: ParquetInt64, Optional: true},
+			{Name: "token_count", Type: ParquetInt64, Optional: true},
+			{Name: "model_used", Type: ParquetString, Optional: true},
+			{Name: "feedback_score", Type: ParquetInt64, Optional: true},
+		},
+		Query: `
+			SELECT id, user_id, created_at, intent, intent_confidence, complexity, techniques_used,
+				   original_input, enhanced_output, processing_time_ms, token_count, model_used, feedback_score
+			FROM prompts.history
+			WHERE created_at >= $1 AND created_at < $2
+			ORDER BY created_at`,
+		Scan: func(s *WarehouseExportService, row rowScanner) ([]interface{}, error) {
+			var id, original, enhanced string
+			var userID, intent, complexity, model sql.NullString
+			var createdAt time.Time
+			var confidence sql.NullFloat64
+			var techniques pq.StringArray
+			var processingTime, tokens, feedback sql.NullInt64
+			if err := row.Scan(&id, &userID, &createdAt, &intent, &confidence, &complexity, &techniques,
+				&original, &enhanced, &processingTime, &tokens, &model, &feedback); err != nil {
+				return nil, err
+			}
+			return []interface{}{
+				id, s.userHash(userID), createdAt, nullString(intent), nullFloat(confidence), nullString(complexity),
+				joinTechniques(techniques), RedactPII(original), RedactPII(enhanced),
+				nullInt(processingTime), nullInt(tokens), nullString(model), nullInt(feedback),
+			}, nil
+		},
+	},
+	{
+		Name:    "feedback",
+		Version: 1,
+		Columns: []ParquetColumn{
+			{Name: "history_id", Type: ParquetString},
+			{Name: "user_hash", Type: ParquetString, Optional: true},
+			{Name: "created_at", Type: ParquetTimestamp},
+			{Name: "intent", Type: ParquetString, Optional: true},
+			{Name: "techniques", Type: ParquetString, Optional: true},
+			{Name: "feedback_score", Type: ParquetInt64},
+			{Name: "feedback_text", Type: ParquetString, Optional: true},
+		},
+		Query: `
+			SELECT id, user_id, created_at, intent, techniques_used, feedback_score, feedback_text
+			FROM prompts.history
+			WHERE created_at >= $1 AND created_at < $2 AND feedback_score IS NOT NULL
+			ORDER BY created_at`,
+		Scan: func(s *WarehouseExportService, row rowScanner) ([]interface{}, error) {
+			var id string
+			var userID, intent, text sql.NullString
+			var createdAt time.Time
+			var techniques pq.StringArray
+			var score int64
+			if err := row.Scan(&id, &userID, &createdAt, &intent, &techniques, &score, &text); err != nil {
+				return nil, err
+			}
+			var feedbackText interface{}
+			if text.Valid && text.String != "" {
+				feedbackText = RedactPII(text.String)
+			}
+			return []interface{}{
+				id, s.userHash(userID), createdAt, nullString(intent), joinTechniques(techniques), score, feedbackText,
+			}, nil
+		},
+	},
+	{
+		Name:    "technique_effectiveness",
+		Version: 1,
+		Columns: []ParquetColumn{
+			{Name: "date", Type: ParquetTimestamp},
+			{Name: "technique", Type: ParquetString},
+			{Name: "intent", Type: ParquetString},
+			{Name: "success_count", Type: ParquetInt64},
+			{Name: "total_count", Type: ParquetInt64},
+			{Name: "average_feedback", Type: ParquetDouble, Optional: true},
+			{Name: "average_processing_time_ms", Type: ParquetDouble, Optional: true},
+			{Name: "p95_processing_time_ms", Type: ParquetDouble, Optional: true},
+		},
+		Query: `
+			SELECT date, technique, intent, COALESCE(success_count, 0), COALESCE(total_count, 0),
+				   average_feedback, average_processing_time_ms, p95_processing_time_ms
+			FROM analytics.technique_effectiveness
+			WHERE date >= $1::date AND date < $2::date
+			ORDER BY technique, intent`,
+		Scan: func(s *WarehouseExportService, row rowScanner) ([]interface{}, error) {
+			var date time.Time
+			var technique, intent string
+			var success, total int64
+			var feedback, avgTime, p95 sql.NullFloat64
+			if err := row.Scan(&date, &technique, &intent, &success, &total, &feedback, &avgTime, &p95); err != nil {
+				return nil, err
+			}
+			return []interface{}{
+				date, technique, intent, success, total, nullFloat(feedback), nullFloat(avgTime), nullFloat(p95),
+			}, nil
+		},
+	},
+}
+
+// WarehouseDatasetNames lists the datasets that can be exported
+func WarehouseDatasetNames() []string {
+	names := make([]string, len(warehouseDatasets))
+	for i, dataset := range warehouseDatasets {
+		names[i] = dataset.Name
+	}
+	return names
+}
+
+func findWarehouseDataset(name string) (warehouseDataset, bool) {
+	for _, dataset := range warehouseDatasets {
+		if dataset.Name == name {
+			return dataset, true
+		}
+	}
+	return warehouseDataset{}, false
+}
+
+// warehouseObjectKey lays exports out as Hive-style date partitions, one
+// prefix per schema version
+func warehouseObjectKey(dataset warehouseDataset, date time.Time) string {
+	return fmt.Sprintf("warehouse/%s/v%d/date=%s/part-00000.parquet", dataset.Name, dataset.Version, date.Format("2006-01-02"))
+}
+
+// WarehouseExportService dumps anonymized prompt history, feedback and
+// technique effectiveness to Parquet files in object storage for the ML
+// training pipeline. User IDs are replaced with keyed hashes, which stay
+// stable across exports without being reversible, and prompt text has
+// contact details and secrets redacted.
+type WarehouseExportService struct {
+	db      *DatabaseService
+	storage ObjectStorage
+	key     []byte
+	logger  *logrus.Logger
+}
+
+// NewWarehouseExportService creates a new warehouse export service. Exports
+// are disabled without an anonymization key.
+func NewWarehouseExportService(db *DatabaseService, storage ObjectStorage, anonymizationKey string, logger *logrus.Logger) *WarehouseExportService {
+	return &WarehouseExportService{
+		db:      db,
+		storage: storage,
+		key:     []byte(anonymizationKey),
+		logger:  logger,
+	}
+}
+
+// Enabled reports whether exports can run
+func (s *WarehouseExportService) Enabled() bool {
+	return len(s.key) > 0 && s.storage != nil
+}
+
+// ExportJob returns the nightly job exporting the previous UTC day
+func (s *WarehouseExportService) ExportJob() ScheduledJob {
+	return ScheduledJob{
+		Name:     "warehouse_export",
+		Interval: 24 * time.Hour,
+		Timeout:  WarehouseExportTimeout,
+		Run: func(ctx context.Context) error {
+			yesterday := time.Now().UTC().AddDate(0, 0, -1)
+			_, err := s.Export(ctx, yesterday, nil, "scheduler", false)
+			return err
+		},
+	}
+}
+
+// Export exports one UTC day of the named datasets, or all of them when
+// names is empty. Days already exported at the current schema version are
+// skipped unless force is set. Every dataset is attempted; the first error
+// is returned along with the runs.
+func (s *WarehouseExportService) Export(ctx context.Context, date time.Time, names []string, triggeredBy string, force bool) ([]*WarehouseExport, error) {
+	if !s.Enabled() {
+		return nil, ErrWarehouseExportsDisabled
+	}
+	if len(names) == 0 {
+		names = WarehouseDatasetNames()
+	}
+
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	runs := []*WarehouseExport{}
+	var firstErr error
+	for _, name := range names {
+		dataset, ok := findWarehouseDataset(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown dataset %q", name)
+		}
+
+		run, err := s.exportDataset(ctx, dataset, day, triggeredBy, force)
+		if run != nil {
+			runs = append(runs, run)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to export %s: %w", name, err)
+		}
+	}
+	return runs, firstErr
+}
+
+// exportDataset exports one day of a dataset. It returns a nil run when the
+// day was already exported.
+func (s *WarehouseExportService) exportDataset(ctx context.Context, dataset warehouseDataset, day time.Time, triggeredBy string, force bool) (*WarehouseExport, error) {
+	logger := s.logger.WithFields(logrus.Fields{
+		"dataset": dataset.Name,
+		"date":    day.Format("2006-01-02"),
+	})
+
+	if !force {
+		var exists bool
+		err := s.db.DB.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1 FROM analytics.warehouse_exports
+				WHERE dataset = $1 AND export_date = $2 AND schema_version = $3 AND status = 'succeeded'
+			)`, dataset.Name, day, dataset.Version).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check previous exports: %w", err)
+		}
+		if exists {
+			logger.Debug("Warehouse export already done")
+			return nil, nil
+		}
+	}
+
+	if err := s.checkSchema(ctx, dataset); err != nil {
+		return nil, err
+	}
+
+	run, err := s.startRun(ctx, dataset, day, triggeredBy)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, size, err := s.writeDataset(ctx, dataset, day, run.ObjectKey)
+	if err != nil {
+		logger.WithError(err).Error("Warehouse export failed")
+		s.finishRun(run, "failed", 0, 0, err.Error())
+		return run, err
+	}
+
+	s.finishRun(run, "succeeded", rows, size, "")
+	logger.WithFields(logrus.Fields{
+		"rows":  rows,
+		"bytes": size,
+	}).Info("Warehouse export complete")
+	return run, nil
+}
+
+// checkSchema rejects column changes that readers of earlier files at the
+// same schema version couldn't handle
+func (s *WarehouseExportService) checkSchema(ctx context.Context, dataset warehouseDataset) error {
+	var columns []byte
+	err := s.db.DB.QueryRowContext(ctx, `
+		SELECT columns FROM analytics.warehouse_exports
+		WHERE dataset = $1 AND schema_version = $2 AND status = 'succeeded'
+		ORDER BY started_at DESC
+		LIMIT 1`, dataset.Name, dataset.Version).Scan(&columns)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get previous schema: %w", err)
+	}
+
+	var previous []ParquetColumn
+	if err := json.Unmarshal(columns, &previous); err != nil {
+		return fmt.Errorf("failed to unmarshal previous schema: %w", err)
+	}
+	if err := CheckParquetSchemaEvolution(previous, dataset.Columns); err != nil {
+		return fmt.Errorf("%s schema v%d: %w; bump the dataset version", dataset.Name, dataset.Version, err)
+	}
+	return nil
+}
+
+// startRun records a running export, clearing out runs whose instance died
+func (s *WarehouseExportService) startRun(ctx context.Context, dataset warehouseDataset, day time.Time, triggeredBy string) (*WarehouseExport, error) {
+	_, err := s.db.DB.ExecContext(ctx, `
+		UPDATE analytics.warehouse_exports
+		SET status = 'failed', error = 'abandoned', completed_at = CURRENT_TIMESTAMP
+		WHERE dataset = $1 AND export_date = $2 AND status = 'running' AND started_at < $3`,
+		dataset.Name, day, time.Now().Add(-warehouseExportStaleAfter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to clear stale exports: %w", err)
+	}
+
+	columns, err := json.Marshal(dataset.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+
+	run := &WarehouseExport{
+		Dataset:       dataset.Name,
+		ExportDate:    day.Format("2006-01-02"),
+		SchemaVersion: dataset.Version,
+		Columns:       dataset.Columns,
+		Status:        "running",
+		ObjectKey:     warehouseObjectKey(dataset, day),
+		TriggeredBy:   triggeredBy,
+	}
+	err = s.db.DB.QueryRowContext(ctx, `
+		INSERT INTO analytics.warehouse_exports (dataset, export_date, schema_version, columns, object_key, triggered_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, started_at`,
+		dataset.Name, day, dataset.Version, columns, run.ObjectKey, triggeredBy).Scan(&run.ID, &run.StartedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrWarehouseExportRunning
+		}
+		return nil, fmt.Errorf("failed to record export: %w", err)
+	}
+	return run, nil
+}
+
+// finishRun records the outcome of a run. It uses its own context so a
+// run that timed out is still marked failed.
+func (s *WarehouseExportService) finishRun(run *WarehouseExport, status string, rows, size int64, errMsg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	run.Status = status
+	run.RowCount = rows
+	run.SizeBytes = size
+	run.Error = errMsg
+	run.CompletedAt = &now
+
+	_, err := s.db.DB.ExecContext(ctx, `
+		UPDATE analytics.warehouse_exports
+		SET status = $2, row_count = $3, size_bytes = $4, error = NULLIF($5, ''), completed_at = $6
+		WHERE id = $1`,
+		run.ID, status, rows, size, errMsg, now)
+	if err != nil {
+		s.logger.WithError(err).WithField("export_id", run.ID).Error("Failed to record export result")
+	}
+}
+
+// writeDataset writes a day of a dataset to key along with its schema,
+// returning the row count and file size
+func (s *WarehouseExportService) writeDataset(ctx context.Context, dataset warehouseDataset, day time.Time, key string) (int64, int64, error) {
+	rows, err := s.db.DB.QueryContext(ctx, dataset.Query, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query %s: %w", dataset.Name, err)
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	writer := NewParquetWriter(&buf, dataset.Columns, map[string]string{
+		"dataset":        dataset.Name,
+		"schema_version": fmt.Sprintf("%d", dataset.Version),
+		"export_date":    day.Format("2006-01-02"),
+	})
+
+	var buffered int
+	for rows.Next() {
+		values, err := dataset.Scan(s, rows)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to scan %s: %w", dataset.Name, err)
+		}
+		if err := writer.Write(values); err != nil {
+			return 0, 0, err
+		}
+		if buffered++; buffered == warehouseRowGroupSize {
+			if err := writer.Flush(); err != nil {
+				return 0, 0, err
+			}
+			buffered = 0
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to iterate %s: %w", dataset.Name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, 0, fmt.Errorf("failed to write parquet: %w", err)
+	}
+
+	if err := s.storage.Put(ctx, key, buf.Bytes(), "application/vnd.apache.parquet"); err != nil {
+		return 0, 0, fmt.Errorf("failed to upload export: %w", err)
+	}
+
+	// The schema next to the partitions lets consumers build table
+	// definitions without opening a file
+	schema, err := json.MarshalIndent(map[string]interface{}{
+		"dataset":        dataset.Name,
+		"schema_version": dataset.Version,
+		"columns":        dataset.Columns,
+	}, "", "  ")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	schemaKey := fmt.Sprintf("warehouse/%s/v%d/_schema.json", dataset.Name, dataset.Version)
+	if err := s.storage.Put(ctx, schemaKey, schema, "application/json"); err != nil {
+		return 0, 0, fmt.Errorf("failed to upload schema: %w", err)
+	}
+
+	return writer.NumRows(), int64(buf.Len()), nil
+}
+
+// ListExports returns recent export runs, newest first, optionally for one dataset
+func (s *WarehouseExportService) ListExports(ctx context.Context, dataset string, limit int) ([]*WarehouseExport, error) {
+	query := `
+		SELECT id, dataset, export_date, schema_version, columns, status, object_key, row_count,
+			   size_bytes, error, triggered_by, started_at, completed_at
+		FROM analytics.warehouse_exports
+		WHERE $1 = '' OR dataset = $1
+		ORDER BY started_at DESC
+		LIMIT $2`
+
+	rows, err := s.db.DB.QueryContext(ctx, query, dataset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+	defer rows.Close()
+
+	exports := []*WarehouseExport{}
+	for rows.Next() {
+		export, err := scanWarehouseExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate exports: %w", err)
+	}
+	return exports, nil
+}
+
+// GetExport returns an export run
+func (s *WarehouseExportService) GetExport(ctx context.Context, id string) (*WarehouseExport, error) {
+	query := `
+		SELECT id, dataset, export_date, schema_version, columns, status, object_key, row_count,
+			   size_bytes, error, triggered_by, started_at, completed_at
+		FROM analytics.warehouse_exports
+		WHERE id = $1`
+
+	export, err := scanWarehouseExport(s.db.DB.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWarehouseExportNotFound
+	}
+	return export, err
+}
+
+func scanWarehouseExport(row rowScanner) (*WarehouseExport, error) {
+	var export WarehouseExport
+	var exportDate time.Time
+	var columns []byte
+	var objectKey, errMsg sql.NullString
+	var completedAt sql.NullTime
+
+	err := row.Scan(&export.ID, &export.Dataset, &exportDate, &export.SchemaVersion, &columns, &export.Status,
+		&objectKey, &export.RowCount, &export.SizeBytes, &errMsg, &export.TriggeredBy, &export.StartedAt, &completedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to scan export: %w", err)
+	}
+
+	export.ExportDate = exportDate.Format("2006-01-02")
+	export.ObjectKey = objectKey.String
+	export.Error = errMsg.String
+	if completedAt.Valid {
+		export.CompletedAt = &completedAt.Time
+	}
+	if err := json.Unmarshal(columns, &export.Columns); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export columns: %w", err)
+	}
+	return &export, nil
+}
+
+// userHash pseudonymizes a user ID with the anonymization key
+func (s *WarehouseExportService) userHash(userID sql.NullString) interface{} {
+	if !userID.Valid || userID.String == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(userID.String))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// piiPatterns are replaced in exported text, most specific first
+var piiPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(?:sk|pk|rk|ghp|gho|xox[abp])[-_][A-Za-z0-9_-]{16,}\b`), "[SECRET]"},
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`), "Bearer [SECRET]"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[EMAIL]"},
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[NUMBER]"},
+	{regexp.MustCompile(`\+?\(?\d{1,4}\)?[ .-]?\(?\d{2,4}\)?[ .-]\d{3,4}[ .-]\d{3,4}\b`), "[PHONE]"},
+	{regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`), "[IP]"},
+}
+
+// RedactPII replaces email addresses, phone numbers, card-like numbers, IP
+// addresses and API keys in text with placeholders
+func RedactPII(text string) string {
+	for _, p := range piiPatterns {
+		text = p.pattern.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
+
+func joinTechniques(techniques pq.StringArray) interface{} {
+	if len(techniques) == 0 {
+		return nil
+	}
+	return strings.Join(techniques, ",")
+}
+
+func nullString(v sql.NullString) interface{} {
+	if !v.Valid {
+		return nil
+	}
+	return v.String
+}
+
+func nullFloat(v sql.NullFloat64) interface{} {
+	if !v.Valid {
+		return nil
+	}
+	return v.Float64
+}
+
+func nullInt(v sql.NullInt64) interface{} {
+	if !v.Valid {
+		return nil
+	}
+	return v.Int64
+}
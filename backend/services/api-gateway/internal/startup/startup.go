@@ -0,0 +1,156 @@
+// Package startup brings the gateway up without crash looping while its
+// dependencies are still starting. Wait retries each dependency with capped
+// exponential backoff, and Handler answers liveness probes in the meantime,
+// so an orchestrator sees a live process that isn't ready yet instead of one
+// that keeps exiting.
+package startup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LivePath is answered with 200 from the moment the gateway listens
+const LivePath = "/health/live"
+
+// Config controls how long and how often dependencies are retried
+type Config struct {
+	InitialBackoff time.Duration // Wait after the first failed attempt, doubling after each
+	MaxBackoff     time.Duration // Longest wait between attempts
+	CheckTimeout   time.Duration // Bound on each attempt
+	Timeout        time.Duration // Gives up on required dependencies after this long; zero waits forever
+	OptionalWait   time.Duration // How long optional dependencies are waited for before starting without them
+	FailFast       bool          // Try each dependency once and fail right away
+}
+
+// LoadConfig reads STARTUP_BACKOFF_INITIAL, STARTUP_BACKOFF_MAX,
+// STARTUP_CHECK_TIMEOUT, STARTUP_TIMEOUT and STARTUP_OPTIONAL_WAIT
+func LoadConfig() Config {
+	return Config{
+		InitialBackoff: envDuration("STARTUP_BACKOFF_INITIAL", 500*time.Millisecond),
+		MaxBackoff:     envDuration("STARTUP_BACKOFF_MAX", 30*time.Second),
+		CheckTimeout:   envDuration("STARTUP_CHECK_TIMEOUT", 5*time.Second),
+		Timeout:        envDuration("STARTUP_TIMEOUT", 0),
+		OptionalWait:   envDuration("STARTUP_OPTIONAL_WAIT", 30*time.Second),
+	}
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d >= 0 {
+		return d
+	}
+	return fallback
+}
+
+// Dependency is something the gateway needs reachable before serving
+type Dependency struct {
+	Name     string
+	Check    func(ctx context.Context) error
+	Optional bool // The gateway can start without it, degraded
+}
+
+// Wait checks each dependency in turn until it answers, backing off between
+// attempts. Required dependencies are waited for until Timeout, optional
+// ones for OptionalWait after which startup goes on without them. It
+// returns the first required dependency's error once it gives up, or ctx's
+// error when cancelled.
+func Wait(ctx context.Context, dependencies []Dependency, config Config, logger *logrus.Logger) error {
+	start := time.Now()
+	for _, dependency := range dependencies {
+		limit := config.Timeout
+		if dependency.Optional {
+			limit = config.OptionalWait
+		}
+		err := waitFor(ctx, dependency, limit, config, logger)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case dependency.Optional:
+			logger.WithError(err).WithField("dependency", dependency.Name).Warn("Starting without optional dependency")
+		default:
+			return fmt.Errorf("%s not ready: %w", dependency.Name, err)
+		}
+	}
+	logger.WithField("waited_ms", time.Since(start).Milliseconds()).Info("Dependencies ready")
+	return nil
+}
+
+// waitFor retries one dependency until it answers, limit passes or ctx is
+// done. A zero limit waits forever.
+func waitFor(ctx context.Context, dependency Dependency, limit time.Duration, config Config, logger *logrus.Logger) error {
+	entry := logger.WithField("dependency", dependency.Name)
+	start := time.Now()
+	backoff := config.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		checkCtx, cancel := context.WithTimeout(ctx, config.CheckTimeout)
+		err := dependency.Check(checkCtx)
+		cancel()
+		if err == nil {
+			entry.WithFields(logrus.Fields{
+				"attempts":  attempt,
+				"waited_ms": time.Since(start).Milliseconds(),
+			}).Info("Dependency ready")
+			return nil
+		}
+		if config.FailFast || ctx.Err() != nil {
+			return err
+		}
+		if limit > 0 && time.Since(start)+backoff > limit {
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+
+		entry.WithError(err).WithFields(logrus.Fields{
+			"attempt":  attempt,
+			"retry_in": backoff.String(),
+		}).Warn("Dependency not ready, retrying")
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, config.MaxBackoff)
+	}
+}
+
+// Handler serves the gateway's port while it starts. Until Ready is called
+// it answers liveness probes with 200 and everything else, readiness probes
+// included, with 503; afterwards every request goes to the ready handler.
+type Handler struct {
+	ready atomic.Pointer[http.Handler]
+}
+
+// NewHandler creates a handler for a gateway that isn't ready yet
+func NewHandler() *Handler {
+	return &Handler{}
+}
+
+// Ready hands all further requests to handler
+func (h *Handler) Ready(handler http.Handler) {
+	h.ready.Store(&handler)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ready := h.ready.Load(); ready != nil {
+		(*ready).ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == LivePath {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"alive","ready":false}`))
+		return
+	}
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write([]byte(`{"status":"starting","error":"the gateway is waiting for its dependencies"}`))
+}
@@ -0,0 +1,126 @@
+package startup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errRefused = errors.New("connection refused")
+
+// flaky fails its first failures checks
+func flaky(failures int, calls *int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		*calls++
+		if *calls <= failures {
+			return errRefused
+		}
+		return nil
+	}
+}
+
+func quietLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+var fastConfig = Config{
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+	CheckTimeout:   time.Second,
+	OptionalWait:   20 * time.Millisecond,
+}
+
+func TestWait(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retries until dependencies answer", func(t *testing.T) {
+		var postgres, redis int
+		err := Wait(ctx, []Dependency{
+			{Name: "postgres", Check: flaky(5, &postgres)},
+			{Name: "redis", Check: flaky(2, &redis), Optional: true},
+		}, fastConfig, quietLogger())
+		require.NoError(t, err)
+		assert.Equal(t, 6, postgres)
+		assert.Equal(t, 3, redis)
+	})
+
+	t.Run("backoff is capped", func(t *testing.T) {
+		var calls int
+		start := time.Now()
+		require.NoError(t, Wait(ctx, []Dependency{{Name: "postgres", Check: flaky(10, &calls)}}, fastConfig, quietLogger()))
+		// 1+2+4 then 4ms for each of the remaining seven attempts, where
+		// uncapped doubling would take over a second
+		assert.Less(t, time.Since(start), 500*time.Millisecond)
+	})
+
+	t.Run("starts without optional dependencies", func(t *testing.T) {
+		var calls int
+		err := Wait(ctx, []Dependency{{Name: "redis", Check: flaky(1000, &calls), Optional: true}}, fastConfig, quietLogger())
+		require.NoError(t, err)
+		assert.Greater(t, calls, 1)
+	})
+
+	t.Run("gives up on required dependencies after the timeout", func(t *testing.T) {
+		config := fastConfig
+		config.Timeout = 20 * time.Millisecond
+		var calls int
+		err := Wait(ctx, []Dependency{{Name: "postgres", Check: flaky(1000, &calls)}}, config, quietLogger())
+		assert.ErrorIs(t, err, errRefused)
+		assert.Contains(t, err.Error(), "postgres not ready")
+	})
+
+	t.Run("fails fast", func(t *testing.T) {
+		config := fastConfig
+		config.FailFast = true
+		var postgres, redis int
+		err := Wait(ctx, []Dependency{
+			{Name: "redis", Check: flaky(1, &redis), Optional: true},
+			{Name: "postgres", Check: flaky(1, &postgres)},
+		}, config, quietLogger())
+		assert.ErrorIs(t, err, errRefused)
+		assert.Equal(t, 1, redis, "optional dependencies are tried once and skipped")
+		assert.Equal(t, 1, postgres)
+	})
+
+	t.Run("stops when cancelled", func(t *testing.T) {
+		config := fastConfig
+		config.InitialBackoff = time.Hour
+		cancelled, cancel := context.WithCancel(ctx)
+		time.AfterFunc(10*time.Millisecond, cancel)
+		var calls int
+		err := Wait(cancelled, []Dependency{{Name: "postgres", Check: flaky(1000, &calls)}}, config, quietLogger())
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	handler := NewHandler()
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get(LivePath).Code)
+	starting := get("/api/v1/ready")
+	assert.Equal(t, http.StatusServiceUnavailable, starting.Code)
+	assert.Equal(t, "5", starting.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/api/v1/enhance").Code)
+
+	handler.Ready(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	assert.Equal(t, http.StatusTeapot, get("/api/v1/ready").Code)
+	assert.Equal(t, http.StatusTeapot, get(LivePath).Code, "once ready the router answers everything")
+}
@@ -0,0 +1,161 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Pre-tokenization patterns of the BPE encodings, which split text into the
+// pieces merged independently. The originals end in \s+(?!\S)|\s+, a
+// lookahead Go's regexp lacks; the trailing (\s+) group stands in for both
+// and split gives back the last whitespace rune the lookahead would have
+// left for the next piece.
+const (
+	gpt2Pattern   = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|(\s+)`
+	cl100kPattern = `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|(\s+)`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+		`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|(\s+)`
+)
+
+// encodingPatterns are the BPE encodings the gateway can load, by name
+var encodingPatterns = map[string]string{
+	"r50k_base":   gpt2Pattern,
+	"p50k_base":   gpt2Pattern,
+	"cl100k_base": cl100kPattern,
+	"o200k_base":  o200kPattern,
+}
+
+// maxPieceBytes bounds the pieces merged as a whole. Merging is quadratic
+// in a piece's length, so longer pieces, such as base64 blobs, are merged
+// in chunks, which can count a token or two more than the model would.
+const maxPieceBytes = 512
+
+// BPE is a byte-level byte pair encoding, the tokenization of the GPT
+// family of models
+type BPE struct {
+	name   string
+	ranks  map[string]int
+	pieces *regexp.Regexp
+}
+
+// NewBPE creates the named encoding from its merge ranks, keyed by the
+// bytes of each token
+func NewBPE(name string, ranks map[string]int) (*BPE, error) {
+	pattern, ok := encodingPatterns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return &BPE{name: name, ranks: ranks, pieces: regexp.MustCompile(pattern)}, nil
+}
+
+// LoadBPE reads the named encoding from a file in tiktoken's format: one
+// token per line, base64-encoded and followed by its rank
+func LoadBPE(name, path string) (*BPE, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		token, rank, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if token == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil || !ok {
+			return nil, fmt.Errorf("%s:%d: malformed token", path, line)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: malformed rank", path, line)
+		}
+		ranks[string(decoded)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewBPE(name, ranks)
+}
+
+// Name returns the encoding's name
+func (b *BPE) Name() string {
+	return b.name
+}
+
+// Count returns the number of tokens text encodes to
+func (b *BPE) Count(text string) int {
+	n := 0
+	for _, piece := range b.split(text) {
+		for len(piece) > maxPieceBytes {
+			cut := maxPieceBytes
+			for cut > 0 && !utf8.RuneStart(piece[cut]) {
+				cut--
+			}
+			n += b.merge(piece[:cut])
+			piece = piece[cut:]
+		}
+		n += b.merge(piece)
+	}
+	return n
+}
+
+// split pre-tokenizes text into the pieces merged independently
+func (b *BPE) split(text string) []string {
+	var pieces []string
+	for start := 0; start < len(text); {
+		m := b.pieces.FindStringSubmatchIndex(text[start:])
+		if m == nil {
+			// Every rune matches some branch, so this is unreachable
+			pieces = append(pieces, text[start:])
+			break
+		}
+		end := start + m[1]
+		// Whitespace before more text leaves its last rune to the next
+		// piece, so " world" stays one token
+		if m[2] >= 0 && end < len(text) {
+			if _, size := utf8.DecodeLastRuneInString(text[start:end]); end-size > start {
+				end -= size
+			}
+		}
+		pieces = append(pieces, text[start:end])
+		start = end
+	}
+	return pieces
+}
+
+// merge counts the tokens of one piece by repeatedly merging the adjacent
+// pair of parts with the lowest rank until no pair is a token
+func (b *BPE) merge(piece string) int {
+	if _, ok := b.ranks[piece]; ok {
+		return 1
+	}
+	// bounds holds where each part starts, and the piece's end
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		best, at := math.MaxInt, -1
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < best {
+				best, at = rank, i
+			}
+		}
+		if at < 0 {
+			break
+		}
+		bounds = append(bounds[:at+1], bounds[at+2:]...)
+	}
+	return len(bounds) - 1
+}
@@ -0,0 +1,79 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// toyRanks is a byte-level vocabulary of every byte plus a few merges
+func toyRanks() map[string]int {
+	ranks := make(map[string]int)
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, token := range []string{"ll", "he", "hell", "hello", " w"} {
+		ranks[token] = 256 + i
+	}
+	return ranks
+}
+
+func TestBPESplit(t *testing.T) {
+	cases := []struct {
+		encoding string
+		text     string
+		pieces   []string
+	}{
+		{"cl100k_base", "Hello  world", []string{"Hello", " ", " world"}},
+		{"cl100k_base", "I'm 12345 ok\n\n  x", []string{"I", "'m", " ", "123", "45", " ok", "\n\n", " ", " x"}},
+		{"cl100k_base", "end  ", []string{"end", "  "}},
+		{"r50k_base", "don't  stop\n\nnow", []string{"don", "'t", " ", " stop", "\n", "\n", "now"}},
+		{"o200k_base", "HelloWorld's", []string{"Hello", "World's"}},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s %q", tc.encoding, tc.text), func(t *testing.T) {
+			bpe, err := NewBPE(tc.encoding, toyRanks())
+			require.NoError(t, err)
+			assert.Equal(t, tc.pieces, bpe.split(tc.text))
+		})
+	}
+}
+
+func TestBPECount(t *testing.T) {
+	bpe, err := NewBPE("r50k_base", toyRanks())
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, bpe.Count("hello"))
+	assert.Equal(t, 2, bpe.Count("hellohello"), "merges apply lowest rank first")
+	assert.Equal(t, 1+5, bpe.Count("hello world"), `" world" merges only " w"`)
+	assert.Equal(t, 0, bpe.Count(""))
+	assert.Equal(t, 1200, bpe.Count(strings.Repeat("é", 600)), "long pieces are merged in chunks")
+
+	_, err = NewBPE("unknown", toyRanks())
+	assert.Error(t, err)
+}
+
+func TestLoadBPE(t *testing.T) {
+	dir := t.TempDir()
+	var vocab strings.Builder
+	for token, rank := range toyRanks() {
+		fmt.Fprintf(&vocab, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	path := filepath.Join(dir, "cl100k_base.tiktoken")
+	require.NoError(t, os.WriteFile(path, []byte(vocab.String()), 0o600))
+
+	bpe, err := LoadBPE("cl100k_base", path)
+	require.NoError(t, err)
+	assert.Equal(t, "cl100k_base", bpe.Name())
+	assert.Equal(t, 1, bpe.Count("hello"))
+
+	require.NoError(t, os.WriteFile(path, []byte("aGk= 1\nnot-base64 2\n"), 0o600))
+	_, err = LoadBPE("cl100k_base", path)
+	assert.ErrorContains(t, err, ":2: malformed token")
+}
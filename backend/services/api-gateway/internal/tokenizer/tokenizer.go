@@ -0,0 +1,207 @@
+// Package tokenizer counts tokens the way the models behind prompt
+// generation do, for sizing generations before they run, accounting for
+// the tokens enhancements use and estimating their cost.
+//
+// Models map to BPE encodings by name prefix, as in tiktoken. An encoding
+// is exact once its vocabulary is loaded from TOKENIZER_VOCAB_DIR; until
+// then, and for models with no known encoding, counts fall back to
+// estimating from the text's length.
+package tokenizer
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens of text in one encoding
+type Tokenizer interface {
+	Name() string
+	Count(text string) int
+}
+
+// CharsPerToken is the length of the average token in English text, which
+// Estimate divides by
+const CharsPerToken = 4
+
+// Estimate approximates token counts from the length of the text
+type Estimate struct{}
+
+// Name returns "estimate"
+func (Estimate) Name() string {
+	return "estimate"
+}
+
+// Count returns the characters in text over CharsPerToken, rounded up
+func (Estimate) Count(text string) int {
+	return (utf8.RuneCountInString(text) + CharsPerToken - 1) / CharsPerToken
+}
+
+// defaultModelEncodings maps model name prefixes to their encodings
+var defaultModelEncodings = map[string]string{
+	"gpt-4o":                 "o200k_base",
+	"gpt-4.1":                "o200k_base",
+	"o1":                     "o200k_base",
+	"o3":                     "o200k_base",
+	"o4":                     "o200k_base",
+	"gpt-4":                  "cl100k_base",
+	"gpt-3.5-turbo":          "cl100k_base",
+	"text-embedding-ada-002": "cl100k_base",
+	"text-embedding-3":       "cl100k_base",
+	"text-davinci-002":       "p50k_base",
+	"text-davinci-003":       "p50k_base",
+	"code-davinci":           "p50k_base",
+	"davinci":                "r50k_base",
+	"gpt2":                   "r50k_base",
+}
+
+// Config sets where vocabularies are loaded from and which models use them
+type Config struct {
+	VocabDir     string            // Directory of <encoding>.tiktoken vocabularies; empty estimates every count
+	Models       map[string]string // Encodings by model name prefix, over the defaults
+	DefaultModel string            // Model counted for when none is given, the one generation uses
+}
+
+// LoadConfig reads TOKENIZER_VOCAB_DIR, TOKENIZER_MODELS, a comma-separated
+// list of model-prefix=encoding pairs, and TOKENIZER_DEFAULT_MODEL (default
+// gpt-4)
+func LoadConfig() Config {
+	config := Config{
+		VocabDir:     strings.TrimSpace(os.Getenv("TOKENIZER_VOCAB_DIR")),
+		Models:       make(map[string]string),
+		DefaultModel: "gpt-4",
+	}
+	for _, entry := range strings.Split(os.Getenv("TOKENIZER_MODELS"), ",") {
+		prefix, encoding, ok := strings.Cut(entry, "=")
+		prefix, encoding = strings.TrimSpace(prefix), strings.TrimSpace(encoding)
+		if ok && prefix != "" && encoding != "" {
+			config.Models[prefix] = encoding
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("TOKENIZER_DEFAULT_MODEL")); v != "" {
+		config.DefaultModel = v
+	}
+	return config
+}
+
+// Count is the tokens in a text for a model
+type Count struct {
+	Model       string `json:"model"`
+	Encoding    string `json:"encoding"`
+	Tokens      int    `json:"tokens"`
+	Approximate bool   `json:"approximate"` // The encoding's vocabulary isn't loaded, or the model has none
+}
+
+// Registry finds the tokenizer of each model. Its lookups are safe to call
+// on a nil Registry, which estimates every count.
+type Registry struct {
+	encodings    map[string]Tokenizer
+	prefixes     []string // Model name prefixes, longest first
+	models       map[string]string
+	defaultModel string
+}
+
+// NewRegistry loads the vocabularies of the known encodings found in the
+// configured directory. Encodings without one are estimated; a vocabulary
+// that fails to load is an error.
+func NewRegistry(config Config) (*Registry, error) {
+	r := &Registry{
+		encodings:    make(map[string]Tokenizer),
+		models:       make(map[string]string),
+		defaultModel: config.DefaultModel,
+	}
+	for prefix, encoding := range defaultModelEncodings {
+		r.models[prefix] = encoding
+	}
+	for prefix, encoding := range config.Models {
+		r.models[prefix] = encoding
+	}
+	for prefix := range r.models {
+		r.prefixes = append(r.prefixes, prefix)
+	}
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		if len(r.prefixes[i]) != len(r.prefixes[j]) {
+			return len(r.prefixes[i]) > len(r.prefixes[j])
+		}
+		return r.prefixes[i] < r.prefixes[j]
+	})
+
+	if config.VocabDir == "" {
+		return r, nil
+	}
+	for name := range encodingPatterns {
+		bpe, err := LoadBPE(name, filepath.Join(config.VocabDir, name+".tiktoken"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.encodings[name] = bpe
+	}
+	return r, nil
+}
+
+// Add registers a tokenizer for an encoding, replacing any loaded for it
+func (r *Registry) Add(t Tokenizer) {
+	r.encodings[t.Name()] = t
+}
+
+// Encodings returns the names of the encodings counted exactly
+func (r *Registry) Encodings() []string {
+	if r == nil {
+		return nil
+	}
+	names := make([]string, 0, len(r.encodings))
+	for name := range r.encodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultModel returns the model counted for when none is given
+func (r *Registry) DefaultModel() string {
+	if r == nil {
+		return ""
+	}
+	return r.defaultModel
+}
+
+// For returns the tokenizer of model, or of the default model when model
+// is empty. An encoding's name works as a model. Models whose encoding
+// isn't loaded get Estimate.
+func (r *Registry) For(model string) Tokenizer {
+	if r == nil {
+		return Estimate{}
+	}
+	if model == "" {
+		model = r.defaultModel
+	}
+	if t, ok := r.encodings[model]; ok {
+		return t
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(model, prefix) {
+			if t, ok := r.encodings[r.models[prefix]]; ok {
+				return t
+			}
+			break
+		}
+	}
+	return Estimate{}
+}
+
+// Count counts the tokens in text for model, or for the default model when
+// model is empty
+func (r *Registry) Count(model, text string) Count {
+	if model == "" {
+		model = r.DefaultModel()
+	}
+	t := r.For(model)
+	_, approximate := t.(Estimate)
+	return Count{Model: model, Encoding: t.Name(), Tokens: t.Count(text), Approximate: approximate}
+}
@@ -0,0 +1,63 @@
+package tokenizer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimate(t *testing.T) {
+	assert.Equal(t, 2, Estimate{}.Count("héllo"))
+	assert.Equal(t, 0, Estimate{}.Count(""))
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Setenv("TOKENIZER_VOCAB_DIR", " /vocab ")
+	t.Setenv("TOKENIZER_MODELS", "internal-llm=cl100k_base, =o200k_base,bogus")
+	t.Setenv("TOKENIZER_DEFAULT_MODEL", "")
+
+	config := LoadConfig()
+	assert.Equal(t, "/vocab", config.VocabDir)
+	assert.Equal(t, map[string]string{"internal-llm": "cl100k_base"}, config.Models)
+	assert.Equal(t, "gpt-4", config.DefaultModel)
+}
+
+func TestRegistry(t *testing.T) {
+	cl100k, err := NewBPE("cl100k_base", toyRanks())
+	require.NoError(t, err)
+	registry, err := NewRegistry(Config{Models: map[string]string{"internal-llm": "cl100k_base"}, DefaultModel: "gpt-4"})
+	require.NoError(t, err)
+	registry.Add(cl100k)
+
+	assert.Equal(t, cl100k, registry.For("gpt-4-turbo"))
+	assert.Equal(t, cl100k, registry.For(""), "the default model")
+	assert.Equal(t, cl100k, registry.For("internal-llm-v2"))
+	assert.Equal(t, cl100k, registry.For("cl100k_base"), "encodings work as models")
+	assert.Equal(t, Estimate{}, registry.For("gpt-4o"), "o200k_base isn't loaded")
+	assert.Equal(t, Estimate{}, registry.For("claude-3-opus"))
+	assert.Equal(t, []string{"cl100k_base"}, registry.Encodings())
+
+	assert.Equal(t, Count{Model: "gpt-4", Encoding: "cl100k_base", Tokens: 1}, registry.Count("", "hello"))
+	assert.Equal(t, Count{Model: "gpt-4o", Encoding: "estimate", Tokens: 2, Approximate: true}, registry.Count("gpt-4o", "hello"))
+
+	var disabled *Registry
+	assert.Equal(t, Estimate{}, disabled.For("gpt-4"))
+	assert.Equal(t, Count{Encoding: "estimate", Tokens: 2, Approximate: true}, disabled.Count("", "hello"))
+}
+
+func TestNewRegistryLoadsVocabularies(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "o200k_base.tiktoken"), []byte("aGk= 0\n"), 0o600))
+
+	registry, err := NewRegistry(Config{VocabDir: dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"o200k_base"}, registry.Encodings())
+	assert.Equal(t, 1, registry.For("gpt-4o-mini").Count("hi"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cl100k_base.tiktoken"), []byte("aGk=\n"), 0o600))
+	_, err = NewRegistry(Config{VocabDir: dir})
+	assert.Error(t, err)
+}
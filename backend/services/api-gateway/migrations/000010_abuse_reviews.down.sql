@@ -0,0 +1,5 @@
+-- Rollback: Abuse review queue
+
+DROP INDEX IF EXISTS auth.idx_abuse_reviews_user_id;
+DROP INDEX IF EXISTS auth.idx_abuse_reviews_status_created_at;
+DROP TABLE IF EXISTS auth.abuse_reviews;
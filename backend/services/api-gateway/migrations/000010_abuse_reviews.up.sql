@@ -0,0 +1,19 @@
+-- Migration: Abuse review queue
+-- Restrictions themselves live in Redis; this table records them for admin review
+
+CREATE TABLE IF NOT EXISTS auth.abuse_reviews (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    rule VARCHAR(100) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    reason TEXT NOT NULL,
+    signal_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    restricted_until TIMESTAMP WITH TIME ZONE NOT NULL,
+    reviewed_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_abuse_reviews_status_created_at ON auth.abuse_reviews(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_abuse_reviews_user_id ON auth.abuse_reviews(user_id, created_at DESC);
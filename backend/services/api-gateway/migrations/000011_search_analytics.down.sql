@@ -0,0 +1,4 @@
+-- Rollback: Search analytics
+
+DROP TABLE IF EXISTS analytics.search_clicks;
+DROP TABLE IF EXISTS analytics.search_queries;
@@ -0,0 +1,25 @@
+-- Migration: Search analytics
+-- Records searches and result click-through so naming and docs can be improved
+
+CREATE TABLE IF NOT EXISTS analytics.search_queries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    source VARCHAR(50) NOT NULL,
+    query TEXT NOT NULL,
+    normalized_query TEXT NOT NULL,
+    result_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS analytics.search_clicks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    search_id UUID NOT NULL REFERENCES analytics.search_queries(id) ON DELETE CASCADE,
+    result_id VARCHAR(255) NOT NULL,
+    position INTEGER NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_queries_created_at ON analytics.search_queries(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_search_queries_normalized ON analytics.search_queries(normalized_query, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_search_queries_zero_results ON analytics.search_queries(created_at DESC) WHERE result_count = 0;
+CREATE INDEX IF NOT EXISTS idx_search_clicks_search_id ON analytics.search_clicks(search_id);
@@ -0,0 +1,3 @@
+-- Rollback: User devices
+
+DROP TABLE IF EXISTS auth.user_devices;
@@ -0,0 +1,18 @@
+-- Migration: User devices
+-- Refresh tokens are bound to the device they were issued to
+
+CREATE TABLE IF NOT EXISTS auth.user_devices (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    fingerprint VARCHAR(128) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    platform VARCHAR(50) NOT NULL DEFAULT '',
+    user_agent TEXT,
+    last_ip INET,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    last_seen_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, fingerprint)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_devices_user_id ON auth.user_devices(user_id, last_seen_at DESC);
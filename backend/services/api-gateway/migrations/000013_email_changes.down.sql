@@ -0,0 +1,3 @@
+-- Rollback: Email changes
+
+DROP TABLE IF EXISTS auth.email_changes;
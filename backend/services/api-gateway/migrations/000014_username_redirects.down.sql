@@ -0,0 +1,3 @@
+-- Rollback: Username redirects
+
+DROP TABLE IF EXISTS auth.username_redirects;
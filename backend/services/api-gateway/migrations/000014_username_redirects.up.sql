@@ -0,0 +1,12 @@
+-- Migration: Username redirects
+-- Old handles keep pointing at their owner for a while after a rename, so
+-- share links containing them still resolve
+
+CREATE TABLE IF NOT EXISTS auth.username_redirects (
+    username VARCHAR(50) PRIMARY KEY, -- lowercased old handle
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_username_redirects_user_id ON auth.username_redirects(user_id, created_at DESC);
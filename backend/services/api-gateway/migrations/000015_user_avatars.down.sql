@@ -0,0 +1,3 @@
+-- Rollback: User avatars
+
+ALTER TABLE auth.users DROP COLUMN IF EXISTS avatar_key;
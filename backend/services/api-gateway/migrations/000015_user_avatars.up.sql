@@ -0,0 +1,5 @@
+-- Migration: User avatars
+-- avatar_url is the stable gateway URL; avatar_key locates the image in object storage
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(500);
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS avatar_key VARCHAR(255);
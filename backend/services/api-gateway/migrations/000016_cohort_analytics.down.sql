@@ -0,0 +1,4 @@
+-- Rollback: Cohort analytics
+
+DROP TABLE IF EXISTS analytics.activation_funnel;
+DROP TABLE IF EXISTS analytics.cohort_retention;
@@ -0,0 +1,20 @@
+-- Migration: Cohort analytics
+-- Weekly signup cohorts, filled by the gateway's scheduled aggregation job
+
+CREATE TABLE IF NOT EXISTS analytics.cohort_retention (
+    cohort_week DATE NOT NULL,
+    week_offset INTEGER NOT NULL,
+    cohort_size INTEGER NOT NULL,
+    active_users INTEGER NOT NULL, -- Users who enhanced at least one prompt in the week
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (cohort_week, week_offset)
+);
+
+CREATE TABLE IF NOT EXISTS analytics.activation_funnel (
+    cohort_week DATE PRIMARY KEY,
+    registered INTEGER NOT NULL,
+    first_enhance INTEGER NOT NULL,
+    first_feedback INTEGER NOT NULL,
+    first_saved_prompt INTEGER NOT NULL,
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
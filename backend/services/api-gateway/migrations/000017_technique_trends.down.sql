@@ -0,0 +1,4 @@
+-- Rollback: Technique trends
+
+DROP MATERIALIZED VIEW IF EXISTS analytics.enhancement_feedback_weekly;
+DROP MATERIALIZED VIEW IF EXISTS analytics.technique_usage_weekly;
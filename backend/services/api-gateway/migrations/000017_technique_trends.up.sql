@@ -0,0 +1,35 @@
+-- Migration: Technique trends
+-- Weekly technique usage and feedback, refreshed by the gateway's job scheduler.
+-- The unique indexes allow REFRESH MATERIALIZED VIEW CONCURRENTLY.
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS analytics.technique_usage_weekly AS
+SELECT date_trunc('week', h.created_at)::date AS week,
+       t.technique,
+       COALESCE(NULLIF(h.intent, ''), 'unknown') AS intent,
+       COUNT(*) AS uses,
+       COUNT(DISTINCT h.user_id) AS unique_users,
+       COUNT(h.feedback_score) AS rated,
+       COALESCE(SUM(h.feedback_score), 0) AS score_sum
+FROM prompts.history h
+CROSS JOIN LATERAL unnest(h.techniques_used) AS t(technique)
+GROUP BY 1, 2, 3
+WITH NO DATA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_technique_usage_weekly_key
+    ON analytics.technique_usage_weekly(week, technique, intent);
+
+-- Totals per prompt rather than per technique, so prompts using several
+-- techniques aren't counted more than once
+CREATE MATERIALIZED VIEW IF NOT EXISTS analytics.enhancement_feedback_weekly AS
+SELECT date_trunc('week', created_at)::date AS week,
+       COALESCE(NULLIF(intent, ''), 'unknown') AS intent,
+       COUNT(*) AS enhancements,
+       COUNT(feedback_score) AS rated,
+       COALESCE(SUM(feedback_score), 0) AS score_sum,
+       COALESCE(SUM(feedback_score * feedback_score), 0) AS score_sq_sum
+FROM prompts.history
+GROUP BY 1, 2
+WITH NO DATA;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_enhancement_feedback_weekly_key
+    ON analytics.enhancement_feedback_weekly(week, intent);
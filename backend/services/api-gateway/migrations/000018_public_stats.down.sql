@@ -0,0 +1,3 @@
+-- Rollback: Public stats
+
+DROP TABLE IF EXISTS analytics.public_stats;
@@ -0,0 +1,10 @@
+-- Migration: Public stats
+-- Daily snapshots of the coarse, k-anonymous numbers served to the marketing site
+
+CREATE TABLE IF NOT EXISTS analytics.public_stats (
+    stat_date DATE PRIMARY KEY,
+    total_enhancements BIGINT NOT NULL,
+    top_techniques JSONB NOT NULL DEFAULT '[]',
+    average_rating NUMERIC(3, 1),
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- Rollback: Warehouse exports
+
+DROP TABLE IF EXISTS analytics.warehouse_exports;
@@ -0,0 +1,25 @@
+-- Migration: Warehouse exports
+-- One row per nightly or admin-triggered export of a dataset to object storage
+
+CREATE TABLE IF NOT EXISTS analytics.warehouse_exports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    dataset VARCHAR(100) NOT NULL,
+    export_date DATE NOT NULL,
+    schema_version INTEGER NOT NULL,
+    columns JSONB NOT NULL DEFAULT '[]',
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+    object_key TEXT,
+    row_count BIGINT NOT NULL DEFAULT 0,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    error TEXT,
+    triggered_by VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_warehouse_exports_dataset ON analytics.warehouse_exports(dataset, export_date DESC);
+CREATE INDEX IF NOT EXISTS idx_warehouse_exports_started_at ON analytics.warehouse_exports(started_at DESC);
+
+-- Only one export of a dataset's day may run at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_warehouse_exports_running
+    ON analytics.warehouse_exports(dataset, export_date) WHERE status = 'running';
@@ -0,0 +1,5 @@
+-- Rollback: Training data curation
+
+DROP TABLE IF EXISTS prompts.training_dataset_items;
+DROP TABLE IF EXISTS prompts.training_datasets;
+DROP TABLE IF EXISTS prompts.training_labels;
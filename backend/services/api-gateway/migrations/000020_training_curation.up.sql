@@ -0,0 +1,38 @@
+-- Migration: Training data curation
+-- Labels on history entries and frozen, versioned training datasets built from them
+
+CREATE TABLE IF NOT EXISTS prompts.training_labels (
+    history_id UUID PRIMARY KEY REFERENCES prompts.history(id) ON DELETE CASCADE,
+    label VARCHAR(10) NOT NULL CHECK (label IN ('good', 'bad')),
+    note TEXT,
+    labeled_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    labeled_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_training_labels_label ON prompts.training_labels(label, labeled_at DESC);
+
+CREATE TABLE IF NOT EXISTS prompts.training_datasets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL,
+    description TEXT,
+    filter JSONB NOT NULL DEFAULT '{}',
+    val_ratio DOUBLE PRECISION NOT NULL,
+    seed VARCHAR(100) NOT NULL,
+    train_count INTEGER NOT NULL DEFAULT 0,
+    val_count INTEGER NOT NULL DEFAULT 0,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (name, version)
+);
+
+-- Membership is frozen when a version is created so exports are reproducible
+CREATE TABLE IF NOT EXISTS prompts.training_dataset_items (
+    dataset_id UUID NOT NULL REFERENCES prompts.training_datasets(id) ON DELETE CASCADE,
+    history_id UUID NOT NULL REFERENCES prompts.history(id) ON DELETE CASCADE,
+    split VARCHAR(5) NOT NULL CHECK (split IN ('train', 'val')),
+    label VARCHAR(10) NOT NULL,
+    PRIMARY KEY (dataset_id, history_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_training_dataset_items_split ON prompts.training_dataset_items(dataset_id, split);
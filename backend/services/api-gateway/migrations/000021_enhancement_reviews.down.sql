@@ -0,0 +1,4 @@
+-- Rollback: Enhancement review queue
+
+ALTER TABLE prompts.training_labels DROP COLUMN IF EXISTS corrected_output;
+DROP TABLE IF EXISTS prompts.enhancement_reviews;
@@ -0,0 +1,27 @@
+-- Migration: Enhancement review queue
+-- Low-confidence enhancements queued for human review, with reviewer corrections
+
+CREATE TABLE IF NOT EXISTS prompts.enhancement_reviews (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    history_id UUID NOT NULL UNIQUE REFERENCES prompts.history(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    reasons TEXT[] NOT NULL,
+    intent_confidence DOUBLE PRECISION,
+    validation_score DOUBLE PRECISION,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'claimed', 'approved', 'corrected')),
+    claimed_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    claimed_at TIMESTAMP WITH TIME ZONE,
+    corrected_output TEXT,
+    corrected_intent VARCHAR(100),
+    corrected_techniques TEXT[],
+    reviewer_note TEXT,
+    resolved_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_enhancement_reviews_status ON prompts.enhancement_reviews(status, created_at);
+
+-- Reviewer corrections become the output of the training example
+ALTER TABLE prompts.training_labels ADD COLUMN IF NOT EXISTS corrected_output TEXT;
@@ -0,0 +1,3 @@
+-- Rollback: Intent classifier drift monitoring
+
+DROP TABLE IF EXISTS analytics.intent_drift_snapshots;
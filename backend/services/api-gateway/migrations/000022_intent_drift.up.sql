@@ -0,0 +1,22 @@
+-- Migration: Intent classifier drift monitoring
+-- Periodic snapshots comparing recent classifications against a baseline window
+
+CREATE TABLE IF NOT EXISTS analytics.intent_drift_snapshots (
+    id BIGSERIAL PRIMARY KEY,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    window_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    baseline_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    baseline_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    samples INTEGER NOT NULL,
+    baseline_samples INTEGER NOT NULL,
+    intent_psi DOUBLE PRECISION,
+    confidence_psi DOUBLE PRECISION,
+    classifier_psi DOUBLE PRECISION,
+    fallback_rate DOUBLE PRECISION,
+    status VARCHAR(20) NOT NULL
+        CHECK (status IN ('ok', 'warning', 'alert', 'insufficient_data')),
+    distributions JSONB NOT NULL DEFAULT '{}', -- Current and baseline histograms for the dashboard
+    computed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_intent_drift_snapshots_computed ON analytics.intent_drift_snapshots(computed_at DESC);
@@ -0,0 +1,5 @@
+-- Rollback: Profile and preference versions
+
+ALTER TABLE auth.users
+    DROP COLUMN IF EXISTS preferences_version,
+    DROP COLUMN IF EXISTS profile_version;
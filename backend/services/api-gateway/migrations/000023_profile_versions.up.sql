@@ -0,0 +1,6 @@
+-- Migration: Profile and preference versions
+-- Versions back If-Match updates, so concurrent edits conflict instead of overwriting each other
+
+ALTER TABLE auth.users
+    ADD COLUMN IF NOT EXISTS profile_version BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS preferences_version BIGINT NOT NULL DEFAULT 1;
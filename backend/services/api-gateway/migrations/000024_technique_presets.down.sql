@@ -0,0 +1,3 @@
+-- Rollback: Technique presets
+
+DROP TABLE IF EXISTS prompts.technique_presets;
@@ -0,0 +1,15 @@
+-- Migration: Technique presets
+-- Admin-defined default technique combinations per intent and tier
+
+CREATE TABLE IF NOT EXISTS prompts.technique_presets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    intent VARCHAR(100) NOT NULL,
+    tier VARCHAR(50) NOT NULL DEFAULT '', -- Empty applies to every tier without its own preset
+    techniques TEXT[] NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    updated_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (intent, tier)
+);
@@ -0,0 +1,4 @@
+-- Rollback: Enhancement profiles
+
+DROP INDEX IF EXISTS prompts.idx_history_profile;
+DROP TABLE IF EXISTS prompts.enhancement_profiles;
@@ -0,0 +1,20 @@
+-- Migration: Enhancement profiles
+-- Named bundles of enhancement settings users switch between per request
+
+CREATE TABLE IF NOT EXISTS prompts.enhancement_profiles (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    prefer_techniques TEXT[] NOT NULL DEFAULT '{}',
+    exclude_techniques TEXT[] NOT NULL DEFAULT '{}',
+    tone VARCHAR(200) NOT NULL DEFAULT '',
+    target_model VARCHAR(100) NOT NULL DEFAULT '',
+    output_format VARCHAR(20) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, name)
+);
+
+-- History is filtered by the profile an enhancement used
+CREATE INDEX IF NOT EXISTS idx_history_profile ON prompts.history(user_id, (metadata->>'profile'))
+    WHERE metadata ? 'profile';
@@ -0,0 +1,4 @@
+-- Rollback: Technique affinity
+
+DROP TABLE IF EXISTS prompts.technique_affinity_resets;
+DROP TABLE IF EXISTS prompts.technique_affinity;
@@ -0,0 +1,19 @@
+-- Migration: Technique affinity
+-- Per-user technique affinity learned from feedback and reruns, recomputed by
+-- the gateway's job scheduler and passed to the selector as weights
+
+CREATE TABLE IF NOT EXISTS prompts.technique_affinity (
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    technique VARCHAR(100) NOT NULL,
+    score DOUBLE PRECISION NOT NULL CHECK (score >= -1 AND score <= 1),
+    rated INTEGER NOT NULL DEFAULT 0,
+    reruns INTEGER NOT NULL DEFAULT 0,
+    computed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, technique)
+);
+
+-- History from before a user's reset is left out of their affinity
+CREATE TABLE IF NOT EXISTS prompts.technique_affinity_resets (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    reset_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,4 @@
+-- Rollback: Scheduled reports
+
+DROP TABLE IF EXISTS analytics.reports;
+DROP TABLE IF EXISTS analytics.report_schedules;
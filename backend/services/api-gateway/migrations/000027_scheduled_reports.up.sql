@@ -0,0 +1,39 @@
+-- Migration: Scheduled reports
+-- Weekly and monthly usage and effectiveness reports admins subscribe to,
+-- generated by the gateway's job scheduler into object storage
+
+CREATE TABLE IF NOT EXISTS analytics.report_schedules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id VARCHAR(100) NOT NULL DEFAULT '', -- '' covers the whole platform
+    frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('weekly', 'monthly')),
+    recipients TEXT[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, frequency)
+);
+
+-- One row per generated period; the unique key keeps instances from
+-- generating the same report twice
+CREATE TABLE IF NOT EXISTS analytics.reports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    schedule_id UUID REFERENCES analytics.report_schedules(id) ON DELETE SET NULL,
+    org_id VARCHAR(100) NOT NULL DEFAULT '',
+    frequency VARCHAR(10) NOT NULL,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 1,
+    summary JSONB,
+    csv_key TEXT,
+    summary_key TEXT,
+    emailed_to INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP, -- Of the latest attempt
+    completed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (org_id, frequency, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_org_created_at ON analytics.reports(org_id, created_at DESC);
@@ -0,0 +1,6 @@
+-- Rollback: Billing
+
+ALTER TABLE auth.users DROP COLUMN IF EXISTS enhancement_quota;
+DROP TABLE IF EXISTS billing.webhook_events;
+DROP TABLE IF EXISTS billing.customers;
+DROP SCHEMA IF EXISTS billing;
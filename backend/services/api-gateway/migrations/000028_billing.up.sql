@@ -0,0 +1,27 @@
+-- Migration: Billing
+-- Stripe customers and subscriptions behind paid tiers. Tiers and quotas on
+-- auth.users are only changed by Stripe webhooks.
+
+CREATE SCHEMA IF NOT EXISTS billing;
+
+CREATE TABLE IF NOT EXISTS billing.customers (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    stripe_customer_id VARCHAR(255) NOT NULL UNIQUE,
+    subscription_id VARCHAR(255),
+    price_id VARCHAR(255),
+    subscription_status VARCHAR(50),
+    current_period_end TIMESTAMP WITH TIME ZONE,
+    cancel_at_period_end BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Stripe delivers events at least once; processed IDs are skipped
+CREATE TABLE IF NOT EXISTS billing.webhook_events (
+    event_id VARCHAR(255) PRIMARY KEY,
+    type VARCHAR(100) NOT NULL,
+    processed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Monthly enhancements allowed by the user's plan; NULL is unlimited
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS enhancement_quota INTEGER;
@@ -0,0 +1,5 @@
+-- Rollback: Trials
+
+DROP INDEX IF EXISTS auth.idx_users_trial_expires_at;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS trial_warned_at;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS trial_expires_at;
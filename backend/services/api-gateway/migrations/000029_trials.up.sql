@@ -0,0 +1,10 @@
+-- Migration: Trials
+-- New users start on a time-limited pro trial. The trial job warns users
+-- before it ends and returns them to free after a grace period; a paid
+-- subscription clears the trial.
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS trial_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS trial_warned_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_trial_expires_at
+    ON auth.users(trial_expires_at) WHERE trial_expires_at IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Rollback: Referrals and coupons
+
+DROP TABLE IF EXISTS billing.coupon_redemptions;
+DROP TABLE IF EXISTS billing.coupons;
+DROP TABLE IF EXISTS auth.referrals;
+DROP TABLE IF EXISTS auth.referral_codes;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS bonus_quota;
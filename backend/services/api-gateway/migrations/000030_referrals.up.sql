@@ -0,0 +1,51 @@
+-- Migration: Referrals and coupons
+-- Per-user referral codes, the referrals made with them at signup and
+-- admin-managed promo coupons. Both reward extra enhancements, kept apart
+-- from the plan's quota so plan changes don't take them away.
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS bonus_quota INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS auth.referral_codes (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    code VARCHAR(32) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every signup with a referral code, including rejected self-referrals
+CREATE TABLE IF NOT EXISTS auth.referrals (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    referrer_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    referred_id UUID NOT NULL UNIQUE REFERENCES auth.users(id) ON DELETE CASCADE,
+    code VARCHAR(32) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('rewarded', 'rejected')),
+    rejection_reason VARCHAR(50),
+    referrer_bonus INTEGER NOT NULL DEFAULT 0,
+    referred_bonus INTEGER NOT NULL DEFAULT 0,
+    signup_ip INET,
+    device_fingerprint VARCHAR(128),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_referrals_referrer ON auth.referrals(referrer_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_referrals_status ON auth.referrals(status, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS billing.coupons (
+    code VARCHAR(32) PRIMARY KEY,
+    description VARCHAR(500) NOT NULL DEFAULT '',
+    bonus_quota INTEGER NOT NULL CHECK (bonus_quota > 0),
+    max_redemptions INTEGER CHECK (max_redemptions > 0), -- NULL is unlimited
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS billing.coupon_redemptions (
+    code VARCHAR(32) NOT NULL REFERENCES billing.coupons(code) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    bonus_quota INTEGER NOT NULL,
+    redeemed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (code, user_id)
+);
@@ -0,0 +1,5 @@
+-- Rollback: Announcements
+
+DROP TABLE IF EXISTS messaging.announcement_dismissals;
+DROP TABLE IF EXISTS messaging.announcements;
+DROP SCHEMA IF EXISTS messaging;
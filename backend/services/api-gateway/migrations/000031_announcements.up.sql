@@ -0,0 +1,31 @@
+-- Migration: Announcements
+-- Timed banners admins show to some or all users, e.g. for maintenance
+-- windows or new features, and which users dismissed them.
+
+CREATE SCHEMA IF NOT EXISTS messaging;
+
+CREATE TABLE IF NOT EXISTS messaging.announcements (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('info', 'feature', 'maintenance')),
+    title VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    link_url VARCHAR(500),
+    tiers TEXT[] NOT NULL DEFAULT '{}', -- Empty targets every tier
+    roles TEXT[] NOT NULL DEFAULT '{}', -- Empty targets every role
+    dismissible BOOLEAN NOT NULL DEFAULT true,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (ends_at > starts_at)
+);
+
+CREATE INDEX IF NOT EXISTS idx_announcements_ends_at ON messaging.announcements(ends_at);
+
+CREATE TABLE IF NOT EXISTS messaging.announcement_dismissals (
+    announcement_id UUID NOT NULL REFERENCES messaging.announcements(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    dismissed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (announcement_id, user_id)
+);
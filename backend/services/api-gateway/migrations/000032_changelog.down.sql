@@ -0,0 +1,4 @@
+-- Rollback: Changelog
+
+DROP TABLE IF EXISTS analytics.feature_adoption;
+DROP TABLE IF EXISTS messaging.changelog_entries;
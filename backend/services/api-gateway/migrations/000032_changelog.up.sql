@@ -0,0 +1,29 @@
+-- Migration: Changelog
+-- Release notes shown in the product, and the first time each user used a
+-- tracked feature so adoption can be measured against releases.
+
+CREATE TABLE IF NOT EXISTS messaging.changelog_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    version VARCHAR(50) NOT NULL DEFAULT '',
+    title VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    category VARCHAR(20) NOT NULL CHECK (category IN ('feature', 'improvement', 'fix')),
+    features TEXT[] NOT NULL DEFAULT '{}', -- Tracked features the release introduced
+    published_at TIMESTAMP WITH TIME ZONE, -- NULL while a draft
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_changelog_entries_published_at
+    ON messaging.changelog_entries(published_at DESC)
+    WHERE published_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS analytics.feature_adoption (
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    feature VARCHAR(50) NOT NULL,
+    first_used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, feature)
+);
+
+CREATE INDEX IF NOT EXISTS idx_feature_adoption_feature ON analytics.feature_adoption(feature, first_used_at);
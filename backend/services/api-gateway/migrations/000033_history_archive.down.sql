@@ -0,0 +1,4 @@
+-- Rollback: History archive
+
+DROP TABLE IF EXISTS prompts.archived_history;
+DROP TABLE IF EXISTS prompts.history_archives;
@@ -0,0 +1,27 @@
+-- Migration: History archive
+-- Old prompt history moved out of prompts.history into gzipped JSONL objects
+-- in object storage. Each archive object has a manifest row, and each entry
+-- an index row pointing at its line so it can still be read by ID.
+
+CREATE TABLE IF NOT EXISTS prompts.history_archives (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    object_key VARCHAR(500) NOT NULL UNIQUE,
+    entry_count INTEGER NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    oldest_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    newest_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_history_archives_archived_at ON prompts.history_archives(archived_at DESC);
+
+CREATE TABLE IF NOT EXISTS prompts.archived_history (
+    history_id UUID PRIMARY KEY,
+    user_id UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    archive_id UUID NOT NULL REFERENCES prompts.history_archives(id) ON DELETE CASCADE,
+    line INTEGER NOT NULL, -- 1-based line of the entry in the archive object
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_archived_history_user ON prompts.archived_history(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_archived_history_archive ON prompts.archived_history(archive_id);
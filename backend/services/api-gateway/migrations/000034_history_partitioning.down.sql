@@ -0,0 +1,42 @@
+-- Rollback: History partitioning
+-- Detached partitions are left alone; reattach any whose history should be
+-- kept before rolling back.
+
+ALTER TABLE prompts.history RENAME TO history_partitioned;
+
+CREATE TABLE prompts.history (
+    LIKE prompts.history_partitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS
+);
+
+INSERT INTO prompts.history SELECT * FROM prompts.history_partitioned;
+
+DROP TABLE prompts.history_partitioned;
+DROP FUNCTION IF EXISTS prompts.delete_history_references();
+DROP FUNCTION IF EXISTS prompts.create_history_partition(TIMESTAMP WITH TIME ZONE);
+
+ALTER TABLE prompts.history
+    ADD PRIMARY KEY (id),
+    ADD UNIQUE (request_id),
+    ADD FOREIGN KEY (user_id) REFERENCES auth.users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_history_user_id_created_at ON prompts.history(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_created_at ON prompts.history(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_updated_at ON prompts.history(updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_session_id ON prompts.history(session_id);
+CREATE INDEX IF NOT EXISTS idx_history_intent_complexity ON prompts.history(intent, complexity);
+CREATE INDEX IF NOT EXISTS idx_history_techniques ON prompts.history USING GIN(techniques_used);
+CREATE INDEX IF NOT EXISTS idx_history_profile ON prompts.history(user_id, (metadata->>'profile'))
+    WHERE metadata ? 'profile';
+
+CREATE TRIGGER update_prompts_history_updated_at
+    BEFORE UPDATE ON prompts.history
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE prompts.training_labels
+    ADD FOREIGN KEY (history_id) REFERENCES prompts.history(id) ON DELETE CASCADE;
+ALTER TABLE prompts.training_dataset_items
+    ADD FOREIGN KEY (history_id) REFERENCES prompts.history(id) ON DELETE CASCADE;
+ALTER TABLE prompts.enhancement_reviews
+    ADD FOREIGN KEY (history_id) REFERENCES prompts.history(id) ON DELETE CASCADE;
+ALTER TABLE prompts.saved_prompts
+    ADD FOREIGN KEY (history_id) REFERENCES prompts.history(id) ON DELETE CASCADE;
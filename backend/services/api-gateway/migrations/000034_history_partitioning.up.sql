@@ -0,0 +1,97 @@
+-- Migration: History partitioning
+-- prompts.history becomes a table partitioned by month of created_at, one
+-- partition per UTC month named history_pYYYY_MM. The gateway creates
+-- partitions ahead of time and detaches expired ones.
+--
+-- A partitioned table's unique constraints must include the partition key,
+-- so the primary key becomes (id, created_at) and other tables can no longer
+-- reference history by a foreign key. Their ON DELETE CASCADE is replaced by
+-- a trigger deleting the rows that referenced a deleted entry.
+
+DO $$
+DECLARE
+    fk RECORD;
+BEGIN
+    FOR fk IN
+        SELECT conrelid::regclass AS tbl, conname
+        FROM pg_constraint
+        WHERE contype = 'f' AND confrelid = 'prompts.history'::regclass
+    LOOP
+        EXECUTE format('ALTER TABLE %s DROP CONSTRAINT %I', fk.tbl, fk.conname);
+    END LOOP;
+END $$;
+
+ALTER TABLE prompts.history RENAME TO history_unpartitioned;
+
+UPDATE prompts.history_unpartitioned
+SET created_at = COALESCE(updated_at, CURRENT_TIMESTAMP)
+WHERE created_at IS NULL;
+
+CREATE TABLE prompts.history (
+    LIKE prompts.history_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS,
+    PRIMARY KEY (id, created_at),
+    UNIQUE (request_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES auth.users(id) ON DELETE SET NULL
+) PARTITION BY RANGE (created_at);
+
+-- Creates the partition holding the UTC month of month, if missing, and
+-- returns its name
+CREATE OR REPLACE FUNCTION prompts.create_history_partition(month TIMESTAMP WITH TIME ZONE)
+RETURNS TEXT AS $$
+DECLARE
+    start_at TIMESTAMP := date_trunc('month', month AT TIME ZONE 'UTC');
+    partition_name TEXT := 'history_p' || to_char(start_at, 'YYYY_MM');
+BEGIN
+    EXECUTE format(
+        'CREATE TABLE IF NOT EXISTS prompts.%I PARTITION OF prompts.history FOR VALUES FROM (%L) TO (%L)',
+        partition_name,
+        start_at AT TIME ZONE 'UTC',
+        (start_at + INTERVAL '1 month') AT TIME ZONE 'UTC'
+    );
+    RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Partitions for every month with history, and the next three
+SELECT prompts.create_history_partition(month)
+FROM generate_series(
+    date_trunc('month', COALESCE((SELECT min(created_at) FROM prompts.history_unpartitioned), CURRENT_TIMESTAMP)),
+    date_trunc('month', CURRENT_TIMESTAMP) + INTERVAL '3 months',
+    INTERVAL '1 month'
+) AS month;
+
+INSERT INTO prompts.history SELECT * FROM prompts.history_unpartitioned;
+
+DROP TABLE prompts.history_unpartitioned;
+
+CREATE INDEX IF NOT EXISTS idx_history_user_id_created_at ON prompts.history(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_created_at ON prompts.history(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_updated_at ON prompts.history(updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_history_session_id ON prompts.history(session_id);
+CREATE INDEX IF NOT EXISTS idx_history_intent_complexity ON prompts.history(intent, complexity);
+CREATE INDEX IF NOT EXISTS idx_history_techniques ON prompts.history USING GIN(techniques_used);
+CREATE INDEX IF NOT EXISTS idx_history_profile ON prompts.history(user_id, (metadata->>'profile'))
+    WHERE metadata ? 'profile';
+
+CREATE TRIGGER update_prompts_history_updated_at
+    BEFORE UPDATE ON prompts.history
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+CREATE OR REPLACE FUNCTION prompts.delete_history_references()
+RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM prompts.training_labels WHERE history_id = OLD.id;
+    DELETE FROM prompts.training_dataset_items WHERE history_id = OLD.id;
+    DELETE FROM prompts.enhancement_reviews WHERE history_id = OLD.id;
+    DELETE FROM prompts.saved_prompts WHERE history_id = OLD.id;
+    -- Owned by the prompt generator, which may not be deployed
+    IF to_regclass('prompts.prompt_feedback') IS NOT NULL THEN
+        EXECUTE 'DELETE FROM prompts.prompt_feedback WHERE prompt_history_id = $1' USING OLD.id;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER delete_prompts_history_references
+    AFTER DELETE ON prompts.history
+    FOR EACH ROW EXECUTE FUNCTION prompts.delete_history_references();
@@ -0,0 +1,3 @@
+-- Rollback: Technique effectiveness flushes
+
+DROP TABLE IF EXISTS analytics.technique_effectiveness_flushes;
@@ -0,0 +1,13 @@
+-- Migration: Technique effectiveness flushes
+-- Feedback is accumulated in memory and written to
+-- analytics.technique_effectiveness in batches. Every applied batch is
+-- recorded here under its ID, so a batch retried after a failure or replayed
+-- after a restart is only counted once.
+
+CREATE TABLE IF NOT EXISTS analytics.technique_effectiveness_flushes (
+    id UUID PRIMARY KEY,
+    rows INTEGER NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_technique_effectiveness_flushes_applied_at ON analytics.technique_effectiveness_flushes(applied_at);
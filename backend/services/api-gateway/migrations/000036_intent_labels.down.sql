@@ -0,0 +1,18 @@
+-- Rollback: Intent labels
+
+CREATE OR REPLACE FUNCTION prompts.delete_history_references()
+RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM prompts.training_labels WHERE history_id = OLD.id;
+    DELETE FROM prompts.training_dataset_items WHERE history_id = OLD.id;
+    DELETE FROM prompts.enhancement_reviews WHERE history_id = OLD.id;
+    DELETE FROM prompts.saved_prompts WHERE history_id = OLD.id;
+    -- Owned by the prompt generator, which may not be deployed
+    IF to_regclass('prompts.prompt_feedback') IS NOT NULL THEN
+        EXECUTE 'DELETE FROM prompts.prompt_feedback WHERE prompt_history_id = $1' USING OLD.id;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS prompts.intent_labels;
@@ -0,0 +1,32 @@
+-- Migration: Intent labels
+-- Intents users picked over the classifier's prediction before enhancing,
+-- kept as labels for retraining the intent classifier. History can't be
+-- referenced by a foreign key since it is partitioned, so the history delete
+-- trigger removes them instead.
+
+CREATE TABLE IF NOT EXISTS prompts.intent_labels (
+    history_id UUID PRIMARY KEY,
+    intent VARCHAR(100) NOT NULL,
+    predicted_intent VARCHAR(100) NOT NULL,
+    predicted_confidence DOUBLE PRECISION,
+    labeled_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    labeled_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_intent_labels_labeled_at ON prompts.intent_labels(labeled_at DESC);
+
+CREATE OR REPLACE FUNCTION prompts.delete_history_references()
+RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM prompts.training_labels WHERE history_id = OLD.id;
+    DELETE FROM prompts.training_dataset_items WHERE history_id = OLD.id;
+    DELETE FROM prompts.enhancement_reviews WHERE history_id = OLD.id;
+    DELETE FROM prompts.saved_prompts WHERE history_id = OLD.id;
+    DELETE FROM prompts.intent_labels WHERE history_id = OLD.id;
+    -- Owned by the prompt generator, which may not be deployed
+    IF to_regclass('prompts.prompt_feedback') IS NOT NULL THEN
+        EXECUTE 'DELETE FROM prompts.prompt_feedback WHERE prompt_history_id = $1' USING OLD.id;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,3 @@
+-- Rollback: Saved prompt comments
+
+DROP TABLE IF EXISTS prompts.saved_prompt_comments;
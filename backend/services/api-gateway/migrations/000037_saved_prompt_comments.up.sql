@@ -0,0 +1,25 @@
+-- Migration: Saved prompt comments
+-- Threaded discussion on saved prompts between the owner and members of
+-- their organization. Replies hang off a top-level comment. Deleted comments
+-- keep their row, without the body, so their replies stay in place; comments
+-- held by moderation are only shown to their author and admins.
+
+CREATE TABLE IF NOT EXISTS prompts.saved_prompt_comments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    saved_prompt_id UUID NOT NULL REFERENCES prompts.saved_prompts(id) ON DELETE CASCADE,
+    parent_id UUID REFERENCES prompts.saved_prompt_comments(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    mentions TEXT[] NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'visible'
+        CHECK (status IN ('visible', 'held', 'removed', 'deleted')),
+    moderation_reason TEXT,
+    moderated_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    edited_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_saved_prompt_comments_prompt ON prompts.saved_prompt_comments(saved_prompt_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_saved_prompt_comments_parent ON prompts.saved_prompt_comments(parent_id);
+CREATE INDEX IF NOT EXISTS idx_saved_prompt_comments_held ON prompts.saved_prompt_comments(created_at) WHERE status = 'held';
@@ -0,0 +1,3 @@
+-- Rollback: User templates
+
+DROP INDEX IF EXISTS prompts.idx_templates_created_by;
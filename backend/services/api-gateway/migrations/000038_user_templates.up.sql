@@ -0,0 +1,6 @@
+-- Migration: User templates
+-- Templates users bring along in settings bundles are looked up by their
+-- creator
+
+CREATE INDEX IF NOT EXISTS idx_templates_created_by ON prompts.templates(created_by, name)
+    WHERE created_by IS NOT NULL;
@@ -0,0 +1,6 @@
+-- Rollback: Single sign-on connections
+
+DROP INDEX IF EXISTS auth.idx_users_email_domain;
+DROP TABLE IF EXISTS auth.sso_identities;
+DROP TABLE IF EXISTS auth.sso_domains;
+DROP TABLE IF EXISTS auth.sso_connections;
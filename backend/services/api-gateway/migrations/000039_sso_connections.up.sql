@@ -0,0 +1,42 @@
+-- Migration: Single sign-on connections
+-- An organization can route its members through its own OpenID Connect
+-- provider. Email domains map a login to the organization; each domain
+-- belongs to at most one. Identities link the provider's subject to the
+-- user provisioned or matched on first sign-in.
+
+CREATE TABLE IF NOT EXISTS auth.sso_connections (
+    org_id VARCHAR(100) PRIMARY KEY,
+    protocol VARCHAR(10) NOT NULL DEFAULT 'oidc' CHECK (protocol IN ('oidc')),
+    issuer TEXT NOT NULL,
+    client_id TEXT NOT NULL,
+    client_secret TEXT NOT NULL,
+    role_claim VARCHAR(100) NOT NULL DEFAULT 'groups',
+    role_mapping JSONB NOT NULL DEFAULT '{}', -- Claim value -> gateway role
+    default_role VARCHAR(50) NOT NULL DEFAULT 'user',
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS auth.sso_domains (
+    domain VARCHAR(255) PRIMARY KEY,
+    org_id VARCHAR(100) NOT NULL REFERENCES auth.sso_connections(org_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sso_domains_org ON auth.sso_domains(org_id);
+
+CREATE TABLE IF NOT EXISTS auth.sso_identities (
+    org_id VARCHAR(100) NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sso_identities_user ON auth.sso_identities(user_id);
+
+-- A domain can only be claimed when every account using it already belongs
+-- to the organization
+CREATE INDEX IF NOT EXISTS idx_users_email_domain ON auth.users(LOWER(split_part(email, '@', 2)));
@@ -0,0 +1,6 @@
+-- Rollback: SCIM provisioning
+
+DROP TABLE IF EXISTS auth.scim_group_members;
+DROP TABLE IF EXISTS auth.scim_groups;
+DROP TABLE IF EXISTS auth.scim_users;
+DROP TABLE IF EXISTS auth.scim_tokens;
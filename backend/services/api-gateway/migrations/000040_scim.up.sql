@@ -0,0 +1,48 @@
+-- Migration: SCIM provisioning
+-- Enterprise directories manage an organization's users and groups through
+-- the SCIM 2.0 API, authenticated by tokens the organization's admins issue.
+-- Only the token's hash is stored. scim_users records which accounts a
+-- directory manages and the userName it knows them by.
+
+CREATE TABLE IF NOT EXISTS auth.scim_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id VARCHAR(100) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_tokens_org ON auth.scim_tokens(org_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS auth.scim_users (
+    user_id UUID PRIMARY KEY REFERENCES auth.users(id) ON DELETE CASCADE,
+    org_id VARCHAR(100) NOT NULL,
+    user_name TEXT NOT NULL,
+    external_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_scim_users_user_name ON auth.scim_users(org_id, LOWER(user_name));
+CREATE INDEX IF NOT EXISTS idx_scim_users_external_id ON auth.scim_users(org_id, external_id);
+
+CREATE TABLE IF NOT EXISTS auth.scim_groups (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id VARCHAR(100) NOT NULL,
+    display_name TEXT NOT NULL,
+    external_id TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (org_id, display_name)
+);
+
+CREATE TABLE IF NOT EXISTS auth.scim_group_members (
+    group_id UUID NOT NULL REFERENCES auth.scim_groups(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_scim_group_members_user ON auth.scim_group_members(user_id);
@@ -0,0 +1,3 @@
+-- Rollback: Tenant data keys
+
+DROP TABLE IF EXISTS auth.org_data_keys;
@@ -0,0 +1,14 @@
+-- Migration: Tenant data keys
+-- Organizations that turn on tenant isolation get their own data keys. Their
+-- members' prompt history is encrypted under the current key, and only read
+-- back for callers in the organization. Keys are stored wrapped by the
+-- gateway's master key; retired versions stay to open what they sealed.
+
+CREATE TABLE IF NOT EXISTS auth.org_data_keys (
+    org_id VARCHAR(100) NOT NULL,
+    version INTEGER NOT NULL,
+    wrapped_key BYTEA NOT NULL,
+    created_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, version)
+);
@@ -0,0 +1,3 @@
+-- Rollback: Access events
+
+DROP TABLE IF EXISTS auth.access_events;
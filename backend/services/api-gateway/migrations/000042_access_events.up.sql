@@ -0,0 +1,22 @@
+-- Migration: Access events
+-- A per-user trail of authentications, data accesses and admin actions
+-- affecting the user, compiled with their devices and activity into the
+-- access log compliance exports are made from. actor_id is who acted when
+-- it wasn't the user themselves, such as an admin.
+
+CREATE TABLE IF NOT EXISTS auth.access_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    actor_id UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    category VARCHAR(20) NOT NULL CHECK (category IN ('authentication', 'data_access', 'admin_action')),
+    action VARCHAR(100) NOT NULL,
+    resource_id VARCHAR(255),
+    request_id VARCHAR(100),
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_access_events_user ON auth.access_events(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_access_events_actor ON auth.access_events(actor_id, created_at) WHERE actor_id IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Rollback: Organization data residency
+
+DROP TABLE IF EXISTS auth.org_residency;
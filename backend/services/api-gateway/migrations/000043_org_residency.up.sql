@@ -0,0 +1,11 @@
+-- Migration: Organization data residency
+-- Organizations can pin their members' prompt history to a region. History
+-- is then written to and only read from that region's database; the region
+-- can't change once the organization has history.
+
+CREATE TABLE IF NOT EXISTS auth.org_residency (
+    org_id VARCHAR(100) PRIMARY KEY,
+    region VARCHAR(20) NOT NULL CHECK (region IN ('eu', 'us')),
+    set_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- Rollback: Personal access tokens
+
+DROP TABLE IF EXISTS auth.personal_access_tokens;
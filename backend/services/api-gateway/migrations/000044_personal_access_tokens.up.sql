@@ -0,0 +1,20 @@
+-- Migration: Personal access tokens
+-- Users issue themselves scoped tokens for scripts and extensions. Unlike
+-- developer API keys they act as the user on the regular API, limited to the
+-- routes their scopes grant, and always expire.
+
+CREATE TABLE IF NOT EXISTS auth.personal_access_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    last_used_ip INET,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_personal_access_tokens_user_id ON auth.personal_access_tokens(user_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_personal_access_tokens_name ON auth.personal_access_tokens(user_id, name) WHERE revoked_at IS NULL;
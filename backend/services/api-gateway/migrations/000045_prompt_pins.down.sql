@@ -0,0 +1,5 @@
+-- Rollback: Pinned enhancements
+
+DROP TABLE IF EXISTS prompts.pin_versions;
+DROP FUNCTION IF EXISTS prompts.reject_pin_version_update();
+DROP TABLE IF EXISTS prompts.pins;
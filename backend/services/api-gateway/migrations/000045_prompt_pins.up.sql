@@ -0,0 +1,46 @@
+-- Migration: Pinned enhancements
+-- Users pin an enhanced prompt to use it in production. A pin keeps a copy
+-- of the result, so it neither drifts with the cache or model nor goes away
+-- with its history entry, and is served at a stable ID. Re-enhancing a pin
+-- adds a version; versions are never changed once written.
+
+CREATE TABLE IF NOT EXISTS prompts.pins (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES auth.users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_pins_user_id ON prompts.pins(user_id, created_at DESC);
+
+-- history_id isn't a foreign key since history is partitioned; the copy
+-- outlives the entry
+CREATE TABLE IF NOT EXISTS prompts.pin_versions (
+    pin_id UUID NOT NULL REFERENCES prompts.pins(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    history_id UUID,
+    original_input TEXT NOT NULL,
+    enhanced_output TEXT NOT NULL,
+    intent VARCHAR(100),
+    complexity VARCHAR(50),
+    techniques_used TEXT[] NOT NULL DEFAULT '{}',
+    versions JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (pin_id, version)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_pin_versions_history_id ON prompts.pin_versions(history_id) WHERE history_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION prompts.reject_pin_version_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'pinned versions are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS pin_versions_immutable ON prompts.pin_versions;
+CREATE TRIGGER pin_versions_immutable
+    BEFORE UPDATE ON prompts.pin_versions
+    FOR EACH ROW EXECUTE FUNCTION prompts.reject_pin_version_update();
@@ -0,0 +1,3 @@
+-- Rollback: Request log
+
+DROP TABLE IF EXISTS analytics.request_log;
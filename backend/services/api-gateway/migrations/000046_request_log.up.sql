@@ -0,0 +1,27 @@
+-- Migration: Request log
+-- One row per request served by the gateway, for admins searching recent
+-- traffic by user, status, path and latency. Rows are kept for a short
+-- retention (REQUEST_LOG_RETENTION) and pruned hourly; trace_id links each
+-- to its distributed trace.
+
+CREATE TABLE IF NOT EXISTS analytics.request_log (
+    id BIGSERIAL PRIMARY KEY,
+    request_id VARCHAR(128) NOT NULL,
+    trace_id CHAR(32),
+    user_id UUID,
+    method VARCHAR(10) NOT NULL,
+    route VARCHAR(255),
+    path VARCHAR(2048) NOT NULL,
+    status SMALLINT NOT NULL,
+    latency_ms INTEGER NOT NULL,
+    response_bytes INTEGER NOT NULL DEFAULT 0,
+    ip_address VARCHAR(45),
+    region VARCHAR(50),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_request_log_created_at ON analytics.request_log(created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_request_log_user ON analytics.request_log(user_id, created_at DESC) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_request_log_status ON analytics.request_log(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_request_log_request_id ON analytics.request_log(request_id);
+CREATE INDEX IF NOT EXISTS idx_request_log_trace_id ON analytics.request_log(trace_id) WHERE trace_id IS NOT NULL;
@@ -0,0 +1,9 @@
+-- Rollback: User list indexes
+
+DROP INDEX IF EXISTS auth.idx_users_locked_until;
+DROP INDEX IF EXISTS auth.idx_users_tier_created_at;
+DROP INDEX IF EXISTS auth.idx_users_last_login_id;
+DROP INDEX IF EXISTS auth.idx_users_created_at_id;
+DROP INDEX IF EXISTS auth.idx_users_roles;
+DROP INDEX IF EXISTS auth.idx_users_username_trgm;
+DROP INDEX IF EXISTS auth.idx_users_email_trgm;
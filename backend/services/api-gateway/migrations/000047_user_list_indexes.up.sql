@@ -0,0 +1,14 @@
+-- Migration: User list indexes
+-- Backs the admin user list: substring search over emails and usernames,
+-- the role filter, and keyset paging in each sort order. Paging orders
+-- break ties on id, so the non-unique sort columns are indexed with it.
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON auth.users USING gin(email gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON auth.users USING gin(username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_roles ON auth.users USING gin(roles);
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON auth.users(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_users_last_login_id ON auth.users((COALESCE(last_login_at, 'epoch'::timestamptz)), id);
+CREATE INDEX IF NOT EXISTS idx_users_tier_created_at ON auth.users(tier, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_users_locked_until ON auth.users(locked_until) WHERE locked_until IS NOT NULL;
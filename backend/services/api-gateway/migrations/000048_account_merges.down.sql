@@ -0,0 +1,9 @@
+-- Rollback: Account merges
+
+DROP TABLE IF EXISTS auth.account_merges;
+DROP INDEX IF EXISTS auth.idx_user_devices_fingerprint;
+DROP INDEX IF EXISTS billing.idx_customers_payment_fingerprint;
+ALTER TABLE billing.customers DROP COLUMN IF EXISTS payment_fingerprint;
+ALTER TABLE auth.users DROP COLUMN IF EXISTS merged_into;
+DROP INDEX IF EXISTS auth.idx_users_canonical_email;
+DROP FUNCTION IF EXISTS auth.canonical_email(TEXT);
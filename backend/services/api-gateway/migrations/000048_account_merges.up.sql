@@ -0,0 +1,56 @@
+-- Migration: Account merges
+-- Admins find likely duplicate accounts by email alias, payment method and
+-- shared devices, and merge one into another. A merge moves the merged-away
+-- account's history, library and pins, folds its preferences and bonus
+-- quota into the kept account, and deactivates it rather than deleting it.
+-- Each merge keeps snapshots of both accounts and the IDs of every row it
+-- moved, so it can be reverted.
+
+-- The address mail to an email is delivered to: lowercase, without a
+-- +suffix, and for Gmail without dots
+CREATE OR REPLACE FUNCTION auth.canonical_email(email TEXT)
+RETURNS TEXT AS $$
+    SELECT CASE
+        WHEN parts.domain IN ('gmail.com', 'googlemail.com') THEN replace(parts.local, '.', '') || '@gmail.com'
+        ELSE parts.local || '@' || parts.domain
+    END
+    FROM (
+        SELECT split_part(split_part(lower(email), '@', 1), '+', 1) AS local,
+               split_part(lower(email), '@', 2) AS domain
+    ) parts
+$$ LANGUAGE SQL IMMUTABLE STRICT;
+
+CREATE INDEX IF NOT EXISTS idx_users_canonical_email ON auth.users(auth.canonical_email(email));
+
+ALTER TABLE auth.users ADD COLUMN IF NOT EXISTS merged_into UUID REFERENCES auth.users(id) ON DELETE SET NULL;
+
+-- Stripe's fingerprint of the customer's card; the same card has the same
+-- fingerprint across customers
+ALTER TABLE billing.customers ADD COLUMN IF NOT EXISTS payment_fingerprint VARCHAR(64);
+CREATE INDEX IF NOT EXISTS idx_customers_payment_fingerprint ON billing.customers(payment_fingerprint)
+    WHERE payment_fingerprint IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_user_devices_fingerprint ON auth.user_devices(fingerprint);
+
+CREATE TABLE IF NOT EXISTS auth.account_merges (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    source_user_id UUID NOT NULL, -- The merged-away account
+    target_user_id UUID NOT NULL, -- The account kept
+    merged_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    signals TEXT[] NOT NULL DEFAULT '{}',
+    source_snapshot JSONB NOT NULL,
+    target_snapshot JSONB NOT NULL,
+    moved JSONB NOT NULL DEFAULT '{}', -- Table to the IDs of the rows moved
+    -- The kept account's preferences_version after the merge; reverting
+    -- restores its preferences only if they haven't been changed since
+    target_preferences_version BIGINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'merged' CHECK (status IN ('merged', 'reverted')),
+    reverted_by UUID REFERENCES auth.users(id) ON DELETE SET NULL,
+    reverted_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_merges_source ON auth.account_merges(source_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_account_merges_target ON auth.account_merges(target_user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_account_merges_created_at ON auth.account_merges(created_at DESC);
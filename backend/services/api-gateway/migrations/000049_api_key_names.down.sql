@@ -0,0 +1,4 @@
+-- Rollback: API key names
+-- Names numbered by the migration keep their numbers.
+
+DROP INDEX IF EXISTS auth.idx_api_keys_user_name;
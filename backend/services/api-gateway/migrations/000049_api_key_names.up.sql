@@ -0,0 +1,15 @@
+-- Migration: API key names
+-- A user's active API keys have distinct names, so keys can be told apart
+-- in the dashboard and in integration settings. Revoked keys keep their
+-- names and don't count. Existing duplicates are numbered, oldest first.
+
+UPDATE auth.api_keys k
+SET name = k.name || ' (' || d.n || ')', updated_at = CURRENT_TIMESTAMP
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY user_id, name ORDER BY created_at, id) AS n
+    FROM auth.api_keys
+    WHERE is_active
+) d
+WHERE k.id = d.id AND d.n > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_user_name ON auth.api_keys(user_id, name) WHERE is_active;
@@ -0,0 +1,3 @@
+-- Rollback: API key scopes
+-- Keys granted every scope by the migration keep them; they already had
+-- full access.
@@ -0,0 +1,7 @@
+-- Migration: API key scopes
+-- Keys are now only granted the scopes they list. Keys created before
+-- scopes were enforced, without scopes or with "*", keep full access.
+
+UPDATE auth.api_keys
+SET scopes = ARRAY['prompt:read', 'prompt:write'], updated_at = CURRENT_TIMESTAMP
+WHERE scopes IS NULL OR scopes = ARRAY[]::TEXT[] OR '*' = ANY(scopes);
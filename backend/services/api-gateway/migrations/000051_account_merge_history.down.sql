@@ -0,0 +1,3 @@
+-- Rollback: Account merge history
+
+ALTER TABLE auth.account_merges DROP COLUMN IF EXISTS history_pending;
@@ -0,0 +1,7 @@
+-- Migration: Account merge history
+-- Merges and reverts move history through the history store after they
+-- commit, rather than while holding the accounts' locks. A merge's history
+-- is pending until it has all moved, so a move that failed part way can be
+-- resumed.
+
+ALTER TABLE auth.account_merges ADD COLUMN IF NOT EXISTS history_pending BOOLEAN NOT NULL DEFAULT false;
@@ -55,6 +55,12 @@ feedback_corrections = Counter(
 )
 
 
+def top_intent_scores(scores: Dict[str, float], top_k: int) -> Dict[str, float]:
+    """Return the top_k most likely intents of a full probability map."""
+    ranked = sorted(scores.items(), key=lambda item: (-item[1], item[0]))
+    return {intent: float(score) for intent, score in ranked[:top_k]}
+
+
 @router.post("/intents/classify", response_model=IntentResponse)
 async def classify_intent(
     request: IntentRequest,
@@ -70,9 +76,14 @@ async def classify_intent(
         # Check cache if enabled by feature flag
         if user_flags.get("caching", True) and settings.ENABLE_CACHING:
             cached_result = await cache.get_intent(request.text)
-            if cached_result:
+            # Entries cached without scores can't answer a top_k request
+            if cached_result and (not request.top_k or cached_result.get("intent_scores")):
                 intent_requests.labels(status="cache_hit").inc()
-                return IntentResponse(**cached_result)
+                cached = IntentResponse(**cached_result)
+                cached.intent_scores = (
+                    top_intent_scores(cached.intent_scores, request.top_k) if request.top_k else None
+                )
+                return cached
         
         # Determine latency requirement based on feature flags
         latency_requirement = "standard"
@@ -99,10 +110,25 @@ async def classify_intent(
                 "tokens_used": result.get("tokens_used", 0),
             }
         )
+
+        # Report which model answered so the gateway can track fallback usage
+        selected_model = result.get("routing_metadata", {}).get("selected_model")
+        if selected_model:
+            response.metadata["classifier"] = getattr(selected_model, "value", selected_model)
+        
+        # The full probability map is cached so any later top_k can be answered
+        all_scores = result.get("all_probabilities")
+        if not isinstance(all_scores, dict):
+            all_scores = {}
         
         # Cache result if enabled by feature flag
         if user_flags.get("caching", True) and settings.ENABLE_CACHING:
-            await cache.set_intent(request.text, response.model_dump())
+            await cache.set_intent(request.text, {**response.model_dump(), "intent_scores": all_scores or None})
+        
+        if request.top_k:
+            response.intent_scores = top_intent_scores(
+                all_scores or {response.intent: response.confidence}, request.top_k
+            )
         
         # Record metrics
         intent_requests.labels(status="success").inc()
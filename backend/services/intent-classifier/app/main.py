@@ -59,6 +59,10 @@ app.add_middleware(
 from app.middleware.monitoring import HealthCheckMiddleware
 app.add_middleware(HealthCheckMiddleware)
 
+# Response schema version negotiation
+from app.middleware.schema_version import SchemaVersionMiddleware
+app.add_middleware(SchemaVersionMiddleware)
+
 # Mount Prometheus metrics
 metrics_app = make_asgi_app()
 app.mount("/metrics", metrics_app)
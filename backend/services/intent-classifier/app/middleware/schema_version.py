@@ -0,0 +1,58 @@
+"""Response schema version negotiation for classification endpoints."""
+
+from typing import Callable, Optional
+
+from fastapi import Request, Response
+from fastapi.responses import JSONResponse
+from starlette.middleware.base import BaseHTTPMiddleware
+
+from app.schemas.intent import SCHEMA_VERSION, SUPPORTED_SCHEMA_VERSIONS
+
+SCHEMA_VERSION_HEADER = "X-Schema-Version"
+
+
+def negotiate_schema_version(accept: Optional[str]) -> Optional[int]:
+    """Pick the response schema version for an Accept header.
+
+    Clients ask for versions with a ``version`` media type parameter, e.g.
+    ``application/json; version=1``. Without one they get the current
+    version. Returns None when none of the requested versions is supported.
+    """
+    requested = []
+    for media_range in (accept or "").split(","):
+        for param in media_range.split(";")[1:]:
+            name, _, value = param.partition("=")
+            if name.strip().lower() != "version":
+                continue
+            try:
+                requested.append(int(value.strip().strip('"')))
+            except ValueError:
+                continue
+    if not requested:
+        return SCHEMA_VERSION
+
+    supported = [v for v in requested if v in SUPPORTED_SCHEMA_VERSIONS]
+    return max(supported) if supported else None
+
+
+class SchemaVersionMiddleware(BaseHTTPMiddleware):
+    """Refuse classification requests for unsupported schema versions with
+    406 and report the version served in X-Schema-Version."""
+
+    async def dispatch(self, request: Request, call_next: Callable) -> Response:
+        if not request.url.path.startswith("/api/v1/intents/classify"):
+            return await call_next(request)
+
+        version = negotiate_schema_version(request.headers.get("accept"))
+        if version is None:
+            return JSONResponse(
+                status_code=406,
+                content={
+                    "detail": "Unsupported response schema version",
+                    "supported_versions": sorted(SUPPORTED_SCHEMA_VERSIONS),
+                },
+            )
+
+        response = await call_next(request)
+        response.headers[SCHEMA_VERSION_HEADER] = str(version)
+        return response
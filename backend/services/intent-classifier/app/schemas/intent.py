@@ -4,6 +4,13 @@ from typing import Dict, List, Optional, Any
 from pydantic import BaseModel, Field, ConfigDict
 
 
+# Classification response schema versions. A version may gain optional
+# fields, but removing, renaming or retyping a field needs a new version.
+# Published schemas live in tests/contracts/schemas/intent-classifier.
+SCHEMA_VERSION = 1
+SUPPORTED_SCHEMA_VERSIONS = {1}
+
+
 class IntentRequest(BaseModel):
     """Request model for intent classification."""
     
@@ -21,6 +28,12 @@ class IntentRequest(BaseModel):
         default=None,
         description="User ID for personalization",
     )
+    top_k: Optional[int] = Field(
+        default=None,
+        description="Return the top k intents with their probabilities in intent_scores",
+        ge=1,
+        le=10,
+    )
 
 
 class IntentResponse(BaseModel):
@@ -51,6 +64,14 @@ class IntentResponse(BaseModel):
         default=None,
         description="Additional metadata",
     )
+    intent_scores: Optional[Dict[str, float]] = Field(
+        default=None,
+        description="Probabilities of the most likely intents, when top_k was requested",
+    )
+    schema_version: int = Field(
+        default=SCHEMA_VERSION,
+        description="Version of this response schema",
+    )
 
 
 class IntentBatchRequest(BaseModel):
@@ -82,6 +82,62 @@ class TestClassifyIntentEndpoint:
             # Verify classifier was not called
             mock_cache_service.set_intent.assert_not_called()
     
+    @pytest.mark.asyncio
+    async def test_classify_intent_top_k(self, mock_cache_service):
+        """Test that top_k returns the most likely intents and caches them all."""
+        # Arrange
+        request = IntentRequest(text="Why does my loop never end?", top_k=2)
+        mock_classifier = AsyncMock()
+        mock_classifier.classify = AsyncMock(return_value={
+            "intent": "problem_solving",
+            "confidence": 0.55,
+            "complexity": "moderate",
+            "suggested_techniques": ["chain_of_thought"],
+            "all_probabilities": {
+                "problem_solving": 0.55,
+                "code_generation": 0.30,
+                "question_answering": 0.15,
+            },
+        })
+        
+        with patch('app.api.v1.intents.classifier', mock_classifier), \
+             patch('app.api.v1.intents.settings.ENABLE_CACHING', True):
+            # Act
+            response = await classify_intent(request, cache=mock_cache_service)
+            
+            # Assert
+            assert response.intent_scores == {"problem_solving": 0.55, "code_generation": 0.30}
+            cached = mock_cache_service.set_intent.call_args[0][1]
+            assert len(cached["intent_scores"]) == 3
+    
+    @pytest.mark.asyncio
+    async def test_classify_intent_top_k_skips_unscored_cache(self, mock_cache_service):
+        """Test that cache entries without scores don't answer top_k requests."""
+        # Arrange
+        mock_cache_service.get_intent.return_value = {
+            "intent": "question_answering",
+            "confidence": 0.92,
+            "complexity": "simple",
+            "suggested_techniques": ["direct_answer"],
+        }
+        request = IntentRequest(text="What is the capital of France?", top_k=3)
+        mock_classifier = AsyncMock()
+        mock_classifier.classify = AsyncMock(return_value={
+            "intent": "question_answering",
+            "confidence": 0.92,
+            "complexity": "simple",
+            "suggested_techniques": ["direct_answer"],
+        })
+        
+        with patch('app.api.v1.intents.classifier', mock_classifier), \
+             patch('app.api.v1.intents.settings.ENABLE_CACHING', True):
+            # Act
+            response = await classify_intent(request, cache=mock_cache_service)
+            
+            # Assert
+            mock_classifier.classify.assert_called_once()
+            assert response.intent_scores == {"question_answering": 0.92}
+    
     @pytest.mark.asyncio
     async def test_classify_intent_torchserve_connection_error(self, mock_cache_service):
         """Test handling of TorchServe connection errors."""
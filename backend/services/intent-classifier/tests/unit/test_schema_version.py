@@ -0,0 +1,53 @@
+"""Unit tests for response schema versioning."""
+
+import json
+from pathlib import Path
+
+import pytest
+
+from app.middleware.schema_version import negotiate_schema_version
+from app.schemas.intent import IntentResponse, SUPPORTED_SCHEMA_VERSIONS
+
+PUBLISHED_SCHEMAS = Path(__file__).resolve().parents[5] / "tests" / "contracts" / "schemas" / "intent-classifier"
+
+
+def load_published_schema(version):
+    path = PUBLISHED_SCHEMAS / f"classify-response.v{version}.json"
+    if not path.exists():
+        pytest.skip("published schemas are only available in a repository checkout")
+    return json.loads(path.read_text())
+
+
+class TestNegotiateSchemaVersion:
+    """Test suite for Accept header negotiation."""
+
+    @pytest.mark.parametrize("accept,expected", [
+        (None, 1),
+        ("application/json", 1),
+        ("application/json; version=1", 1),
+        ('application/json; version="1"', 1),
+        ("application/json; version=2, application/json; version=1", 1),
+        ("application/json; version=2", None),
+        ("application/json; version=abc", 1),
+    ])
+    def test_negotiate(self, accept, expected):
+        assert negotiate_schema_version(accept) == expected
+
+
+class TestPublishedSchemas:
+    """Responses must keep every field promised by the published schemas."""
+
+    @pytest.mark.parametrize("version", sorted(SUPPORTED_SCHEMA_VERSIONS))
+    def test_response_matches_published_schema(self, version):
+        published = load_published_schema(version)
+        schema = IntentResponse.model_json_schema()
+
+        assert set(published["properties"]) <= set(schema["properties"])
+        assert set(published["required"]) <= set(schema.get("required", []))
+
+    @pytest.mark.parametrize("version", sorted(SUPPORTED_SCHEMA_VERSIONS))
+    def test_published_examples_validate(self, version):
+        published = load_published_schema(version)
+
+        for example in published["examples"]:
+            assert IntentResponse(**example).schema_version == version
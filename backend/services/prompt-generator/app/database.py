@@ -242,6 +242,9 @@ def calculate_technique_effectiveness(
         func.sum(func.cast(PromptFeedback.rating <= 2, Integer)).label("negative_count")
     ).filter(
         PromptFeedback.created_at >= datetime.utcnow() - timedelta(days=period_days)
+    ).filter(
+        # The gateway marks feedback it filtered as spam
+        func.coalesce(PromptFeedback.extra_metadata["filtered"].astext, "false") != "true"
     )
     
     # Add filters if specified
@@ -0,0 +1,73 @@
+// Command calibrate fits the confidence calibration in configs/rules.yaml
+// from historical feedback. It reads JSON lines of the form
+//
+//	{"raw_score": 84, "positive": true}
+//
+// pairing the raw score of a selected technique (the "raw_scores" metadata
+// of a selection) with whether the user rated the enhancement positively,
+// and prints a calibration section to paste over the existing one.
+//
+//	go run ./cmd/calibrate feedback.jsonl
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/betterprompts/technique-selector/internal/rules"
+	"gopkg.in/yaml.v3"
+)
+
+func main() {
+	var in io.Reader = os.Stdin
+	if len(os.Args) > 1 && os.Args[1] != "-" {
+		f, err := os.Open(os.Args[1])
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var samples []rules.CalibrationSample
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var sample rules.CalibrationSample
+		if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+			fatalf("line %d: %v", line, err)
+		}
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("%v", err)
+	}
+
+	calibration, err := rules.FitCalibration(samples)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	calibration.FittedAt = time.Now().UTC().Format("2006-01-02")
+
+	out, err := yaml.Marshal(map[string]rules.Calibration{"calibration": calibration})
+	if err != nil {
+		fatalf("%v", err)
+	}
+	os.Stdout.Write(out)
+
+	fmt.Fprintln(os.Stderr, "raw score -> confidence:")
+	for _, raw := range []float64{30, 50, 70, 90, 110} {
+		fmt.Fprintf(os.Stderr, "  %3.0f -> %.3f\n", raw, calibration.Confidence(raw))
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "calibrate: "+format+"\n", args...)
+	os.Exit(1)
+}
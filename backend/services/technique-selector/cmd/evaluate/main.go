@@ -0,0 +1,201 @@
+// Command evaluate measures the rules against a labeled dataset before they
+// are deployed. It reads JSON lines of the form
+//
+//	{"prompt": "Why does ice float?", "intent": "reasoning", "expected_techniques": ["chain_of_thought"]}
+//
+// with an optional "complexity", runs the engine over each case offline and
+// prints precision and recall per technique and intent, the most common
+// confusions and how often each rule condition contributed to correct and
+// incorrect selections. With -baseline, the same dataset is also run
+// against another rules file, usually the deployed one, and the changes
+// are shown alongside.
+//
+//	go run ./cmd/evaluate -rules configs/rules.yaml dataset.jsonl
+//	go run ./cmd/evaluate -baseline deployed-rules.yaml dataset.jsonl
+//	go run ./cmd/evaluate -json dataset.jsonl > report.json
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/betterprompts/technique-selector/internal/config"
+	"github.com/betterprompts/technique-selector/internal/rules"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	rulesPath := flag.String("rules", "configs/rules.yaml", "rules file to evaluate")
+	baselinePath := flag.String("baseline", "", "rules file to compare against")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	confusions := flag.Int("confusions", 10, "most common confusions to print")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if path := flag.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var cases []rules.EvaluationCase
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var c rules.EvaluationCase
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			fatalf("line %d: %v", line, err)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		fatalf("%v", err)
+	}
+	if len(cases) == 0 {
+		fatalf("the dataset is empty")
+	}
+
+	report := evaluate(*rulesPath, cases)
+	var baseline *rules.EvaluationReport
+	if *baselinePath != "" {
+		baseline = evaluate(*baselinePath, cases)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		out := interface{}(report)
+		if baseline != nil {
+			out = map[string]*rules.EvaluationReport{"report": report, "baseline": baseline}
+		}
+		if err := enc.Encode(out); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	printReport(os.Stdout, report, baseline, *confusions)
+}
+
+// evaluate loads a rules file with its calibration, as the server does,
+// and runs the dataset through it
+func evaluate(path string, cases []rules.EvaluationCase) *rules.EvaluationReport {
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	calibration, err := rules.LoadCalibration(path)
+	if err != nil {
+		fatalf("%s: %v", path, err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	engine := rules.NewEngine(cfg, logger)
+	engine.SetCalibration(calibration)
+
+	report, err := rules.Evaluate(engine, cases)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	return report
+}
+
+func printReport(out io.Writer, report, baseline *rules.EvaluationReport, confusions int) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	// delta formats the change from the baseline, when there is one
+	delta := func(current float64, previous func(*rules.EvaluationReport) (float64, bool)) string {
+		if baseline == nil {
+			return ""
+		}
+		if p, ok := previous(baseline); ok {
+			return fmt.Sprintf("%+.3f", current-p)
+		}
+		return "new"
+	}
+
+	fmt.Fprintf(w, "rules %s over %d cases", report.RulesVersion, report.Cases)
+	if baseline != nil {
+		fmt.Fprintf(w, " (baseline %s)", baseline.RulesVersion)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "overall\tvalue\tchange\t")
+	for _, row := range []struct {
+		name  string
+		value func(*rules.EvaluationReport) float64
+	}{
+		{"exact match", func(r *rules.EvaluationReport) float64 { return r.ExactMatchRate }},
+		{"precision", func(r *rules.EvaluationReport) float64 { return r.Precision }},
+		{"recall", func(r *rules.EvaluationReport) float64 { return r.Recall }},
+		{"f1", func(r *rules.EvaluationReport) float64 { return r.F1 }},
+	} {
+		current := row.value(report)
+		fmt.Fprintf(w, "%s\t%.3f\t%s\t\n", row.name, current, delta(current, func(b *rules.EvaluationReport) (float64, bool) {
+			return row.value(b), true
+		}))
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "technique\tsupport\tselected\tprecision\trecall\tf1\tf1 change\tno match\tlow confidence\toutranked\t")
+	for _, t := range report.Techniques {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.3f\t%.3f\t%.3f\t%s\t%d\t%d\t%d\t\n",
+			t.ID, t.Support, t.Selected, t.Precision, t.Recall, t.F1,
+			delta(t.F1, func(b *rules.EvaluationReport) (float64, bool) {
+				for _, bt := range b.Techniques {
+					if bt.ID == t.ID {
+						return bt.F1, true
+					}
+				}
+				return 0, false
+			}),
+			t.Missed.NoMatch, t.Missed.BelowMinConfidence, t.Missed.Outranked)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "intent\tcases\texact\tprecision\trecall\t")
+	for _, i := range report.Intents {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.3f\t%.3f\t\n", i.Intent, i.Cases, i.ExactMatches, i.Precision, i.Recall)
+	}
+	fmt.Fprintln(w)
+
+	if len(report.Confusions) > 0 && confusions > 0 {
+		fmt.Fprintln(w, "expected\tselected instead\tcases\t")
+		for i, c := range report.Confusions {
+			if i == confusions {
+				break
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t\n", orNone(c.Expected), orNone(c.Selected), c.Count)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintln(w, "rule\tselections\tcorrect\tincorrect\tprecision\t")
+	for _, r := range report.Rules {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.3f\t\n", r.Rule, r.Selections, r.Correct, r.Incorrect, r.Precision)
+	}
+}
+
+func orNone(id string) string {
+	if id == "" {
+		return "-"
+	}
+	return id
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "evaluate: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/betterprompts/technique-selector/internal/contract"
 	"github.com/betterprompts/technique-selector/internal/handlers"
 	"github.com/betterprompts/technique-selector/internal/models"
 	"github.com/betterprompts/technique-selector/internal/rules"
@@ -14,6 +20,9 @@ import (
 )
 
 func main() {
+	verifyPacts := flag.String("verify-pacts", "", "verify the consumer contracts in this directory against the service and exit")
+	flag.Parse()
+
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
 		logrus.Warn("No .env file found")
@@ -45,18 +54,53 @@ func main() {
 		logger.WithError(err).Fatal("Failed to load rules configuration")
 	}
 
+	calibration, err := rules.LoadCalibration(configPath)
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to load confidence calibration")
+	}
+
+	// Initialize rules engine
+	engine := rules.NewEngine(config, logger)
+	engine.SetCalibration(calibration)
+	if jitter, _ := strconv.ParseFloat(os.Getenv("SELECTION_EXPLORATION_JITTER"), 64); jitter > 0 {
+		seed, _ := strconv.ParseInt(os.Getenv("SELECTION_EXPLORATION_SEED"), 10, 64)
+		engine.EnableExploration(seed, jitter)
+		logger.WithFields(logrus.Fields{
+			"seed":   seed,
+			"jitter": jitter,
+		}).Info("Seeded selection exploration enabled")
+	}
+
 	logger.WithFields(logrus.Fields{
 		"techniques_count": len(config.Techniques),
 		"max_techniques":   config.SelectionRules.MaxTechniques,
+		"rules_version":    engine.Version(),
+		"calibrated":       calibration.Enabled(),
 	}).Info("Loaded rules configuration")
 
-	// Initialize rules engine
-	engine := rules.NewEngine(config, logger)
-
 	// Initialize handlers
 	handler := handlers.NewTechniqueHandler(engine, logger)
 
-	// Setup Gin router
+	router := newRouter(handler, logger)
+
+	if *verifyPacts != "" {
+		os.Exit(runPactVerification(router, *verifyPacts, logger))
+	}
+
+	// Start server
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8002"
+	}
+
+	logger.WithField("port", port).Info("Starting technique selector service")
+	if err := router.Run(":" + port); err != nil {
+		logger.WithError(err).Fatal("Failed to start server")
+	}
+}
+
+// newRouter sets up the service's middleware and routes
+func newRouter(handler *handlers.TechniqueHandler, logger *logrus.Logger) *gin.Engine {
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -75,18 +119,41 @@ func main() {
 		v1.POST("/select", handler.SelectTechniques)
 		v1.GET("/techniques", handler.ListTechniques)
 		v1.GET("/techniques/:id", handler.GetTechniqueByID)
+		v1.GET("/version", handler.Version)
 	}
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8002"
+	return router
+}
+
+// runPactVerification replays the consumer contracts in dir against router
+// and returns the process exit code
+func runPactVerification(router *gin.Engine, dir string, logger *logrus.Logger) int {
+	pacts, err := contract.LoadPacts(dir)
+	if err != nil {
+		logger.WithError(err).Error("Failed to load consumer contracts")
+		return 2
 	}
 
-	logger.WithField("port", port).Info("Starting technique selector service")
-	if err := router.Run(":" + port); err != nil {
-		logger.WithError(err).Fatal("Failed to start server")
+	failed := 0
+	for _, pact := range pacts {
+		for _, result := range contract.Verify(router, pact) {
+			entry := logger.WithFields(logrus.Fields{
+				"consumer":    result.Consumer,
+				"interaction": result.Interaction,
+			})
+			if result.Passed() {
+				entry.Info("Contract interaction verified")
+				continue
+			}
+			failed++
+			entry.WithField("mismatches", result.Mismatches).Error("Contract interaction failed")
+		}
+	}
+
+	if failed > 0 {
+		return 1
 	}
+	return 0
 }
 
 // loadConfig loads the rules configuration from a YAML file
@@ -107,12 +174,27 @@ func loadConfig(path string) (*models.RulesConfig, error) {
 // loggerMiddleware creates a Gin middleware for logging
 func loggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// The gateway sends the ID its caller was given; echo it back and
+		// log it so the two services' logs line up
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 128 || strings.ContainsAny(requestID, "\r\n") {
+			requestID = newRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+
 		// Before request
-		entry := logger.WithFields(logrus.Fields{
-			"method": c.Request.Method,
-			"path":   c.Request.URL.Path,
-			"ip":     c.ClientIP(),
-		})
+		fields := logrus.Fields{
+			"request_id": requestID,
+			"method":     c.Request.Method,
+			"path":       c.Request.URL.Path,
+			"ip":         c.ClientIP(),
+		}
+		if parts := strings.Split(c.GetHeader("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 {
+			fields["trace_id"] = parts[1]
+		}
+		entry := logger.WithFields(fields)
+		c.Set("logger", entry)
 
 		// Process request
 		c.Next()
@@ -130,4 +212,11 @@ func loggerMiddleware(logger *logrus.Logger) gin.HandlerFunc {
 			entry.Info("Request completed")
 		}
 	}
-}
\ No newline at end of file
+}
+
+// newRequestID identifies requests that arrive without an X-Request-ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/betterprompts/technique-selector/internal/contract"
+	"github.com/betterprompts/technique-selector/internal/handlers"
+	"github.com/betterprompts/technique-selector/internal/rules"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConsumerContracts verifies the committed consumer contracts against
+// the service as it is routed in production
+func TestConsumerContracts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	config, err := loadConfig("../../configs/rules.yaml")
+	require.NoError(t, err)
+	calibration, err := rules.LoadCalibration("../../configs/rules.yaml")
+	require.NoError(t, err)
+	engine := rules.NewEngine(config, logger)
+	engine.SetCalibration(calibration)
+	router := newRouter(handlers.NewTechniqueHandler(engine, logger), logger)
+
+	dir := os.Getenv("PACT_DIR")
+	if dir == "" {
+		dir = "../../../../../tests/contracts/pacts"
+	}
+	pacts, err := contract.LoadPacts(dir)
+	require.NoError(t, err)
+
+	for _, pact := range pacts {
+		for _, result := range contract.Verify(router, pact) {
+			assert.True(t, result.Passed(), "%s: %s: %v", result.Consumer, result.Interaction, result.Mismatches)
+		}
+	}
+	assert.Equal(t, 0, runPactVerification(router, dir, logger))
+}
+
+// TestRequestIDEchoed checks the gateway's request ID comes back, and that
+// requests without one are still given an ID
+func TestRequestIDEchoed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	config, err := loadConfig("../../configs/rules.yaml")
+	require.NoError(t, err)
+	router := newRouter(handlers.NewTechniqueHandler(rules.NewEngine(config, logger), logger), logger)
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "req-1", w.Header().Get("X-Request-ID"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Len(t, w.Header().Get("X-Request-ID"), 32)
+}
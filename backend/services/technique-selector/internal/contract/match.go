@@ -0,0 +1,104 @@
+package contract
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Match reports how actual differs from expected using the consumer's
+// matching semantics: examples stand for their JSON type, objects may carry
+// extra keys, array elements are checked against the first example element
+// (at least one unless a rule lowers Min), and regex rules pin values such
+// as technique IDs. This must stay in step with the gateway's matcher.
+func Match(root string, expected, actual interface{}, rules map[string]Rule) []string {
+	var mismatches []string
+	match(root, expected, actual, rules, &mismatches)
+	return mismatches
+}
+
+func match(path string, expected, actual interface{}, rules map[string]Rule, mismatches *[]string) {
+	rule, hasRule := rules[path]
+
+	if hasRule && rule.Match == "regex" {
+		s, ok := actual.(string)
+		if !ok {
+			*mismatches = append(*mismatches, fmt.Sprintf("%s: expected a string matching %s, got %s", path, rule.Regex, jsonType(actual)))
+			return
+		}
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			*mismatches = append(*mismatches, fmt.Sprintf("%s: invalid regex %s: %v", path, rule.Regex, err))
+			return
+		}
+		if !re.MatchString(s) {
+			*mismatches = append(*mismatches, fmt.Sprintf("%s: %q doesn't match %s", path, s, rule.Regex))
+		}
+		return
+	}
+
+	// A null example marks a value the consumer doesn't depend on, which
+	// may also be absent
+	if expected == nil {
+		return
+	}
+	if jsonType(expected) != jsonType(actual) {
+		*mismatches = append(*mismatches, fmt.Sprintf("%s: expected %s, got %s", path, jsonType(expected), jsonType(actual)))
+		return
+	}
+
+	switch want := expected.(type) {
+	case map[string]interface{}:
+		got := actual.(map[string]interface{})
+		keys := make([]string, 0, len(want))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, ok := got[key]
+			if !ok && want[key] != nil {
+				*mismatches = append(*mismatches, fmt.Sprintf("%s.%s: missing", path, key))
+				continue
+			}
+			match(path+"."+key, want[key], value, rules, mismatches)
+		}
+
+	case []interface{}:
+		got := actual.([]interface{})
+		if len(want) == 0 {
+			return
+		}
+		min := 1
+		if hasRule {
+			min = rule.Min
+		}
+		if len(got) < min {
+			*mismatches = append(*mismatches, fmt.Sprintf("%s: expected at least %d elements, got %d", path, min, len(got)))
+		}
+		for _, element := range got {
+			match(path+"[*]", want[0], element, rules, mismatches)
+		}
+	}
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", v), "*")
+	}
+}
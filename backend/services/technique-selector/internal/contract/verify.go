@@ -0,0 +1,152 @@
+// Package contract verifies this service against the Pact-style contracts
+// its consumers publish, so a rename or enum change that would break the
+// API gateway fails here first.
+package contract
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Provider is the name consumers use for this service in their pacts
+const Provider = "technique-selector"
+
+// Rule tightens or loosens matching of the value at a JSONPath
+type Rule struct {
+	Match string `json:"match"`
+	Regex string `json:"regex,omitempty"`
+	Min   int    `json:"min,omitempty"`
+}
+
+// Request is the request a consumer sends
+type Request struct {
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          interface{}       `json:"body,omitempty"`
+	MatchingRules map[string]Rule   `json:"matchingRules,omitempty"`
+}
+
+// Response is the response a consumer relies on
+type Response struct {
+	Status        int               `json:"status"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Body          interface{}       `json:"body,omitempty"`
+	MatchingRules map[string]Rule   `json:"matchingRules,omitempty"`
+}
+
+// Interaction is one request/response pair a consumer depends on
+type Interaction struct {
+	Description   string   `json:"description"`
+	ProviderState string   `json:"providerState,omitempty"`
+	Request       Request  `json:"request"`
+	Response      Response `json:"response"`
+}
+
+// Pact is a consumer's contract with this service
+type Pact struct {
+	Consumer struct {
+		Name string `json:"name"`
+	} `json:"consumer"`
+	Provider struct {
+		Name string `json:"name"`
+	} `json:"provider"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Result is the outcome of replaying one interaction
+type Result struct {
+	Consumer    string
+	Interaction string
+	Mismatches  []string
+}
+
+// Passed reports whether the service honoured the interaction
+func (r Result) Passed() bool {
+	return len(r.Mismatches) == 0
+}
+
+// LoadPacts reads every pact in dir whose provider is this service
+func LoadPacts(dir string) ([]*Pact, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*-"+Provider+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pacts []*Pact
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var pact Pact
+		if err := json.Unmarshal(data, &pact); err != nil {
+			return nil, fmt.Errorf("invalid pact %s: %w", path, err)
+		}
+		if pact.Provider.Name != Provider {
+			continue
+		}
+		pacts = append(pacts, &pact)
+	}
+	if len(pacts) == 0 {
+		return nil, fmt.Errorf("no pacts for %s in %s", Provider, dir)
+	}
+	return pacts, nil
+}
+
+// Verify replays each interaction in pact against handler and checks the
+// responses satisfy the consumer's expectations
+func Verify(handler http.Handler, pact *Pact) []Result {
+	results := make([]Result, 0, len(pact.Interactions))
+	for _, interaction := range pact.Interactions {
+		results = append(results, Result{
+			Consumer:    pact.Consumer.Name,
+			Interaction: interaction.Description,
+			Mismatches:  replay(handler, interaction),
+		})
+	}
+	return results
+}
+
+func replay(handler http.Handler, interaction Interaction) []string {
+	var body bytes.Buffer
+	if interaction.Request.Body != nil {
+		if err := json.NewEncoder(&body).Encode(interaction.Request.Body); err != nil {
+			return []string{fmt.Sprintf("invalid request body: %v", err)}
+		}
+	}
+
+	req := httptest.NewRequest(interaction.Request.Method, interaction.Request.Path, &body)
+	for key, value := range interaction.Request.Headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	want := interaction.Response
+	var mismatches []string
+	if rec.Code != want.Status {
+		mismatches = append(mismatches, fmt.Sprintf("status: expected %d, got %d: %s", want.Status, rec.Code, strings.TrimSpace(rec.Body.String())))
+		return mismatches
+	}
+	for key, value := range want.Headers {
+		if got := rec.Header().Get(key); !strings.HasPrefix(got, value) {
+			mismatches = append(mismatches, fmt.Sprintf("header %s: expected %q, got %q", key, value, got))
+		}
+	}
+
+	if want.Body == nil {
+		return mismatches
+	}
+	var got interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		return append(mismatches, fmt.Sprintf("response body isn't JSON: %v", err))
+	}
+	return append(mismatches, Match("$.body", want.Body, got, want.MatchingRules)...)
+}
@@ -0,0 +1,58 @@
+package contract
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyCatchesDrift(t *testing.T) {
+	pact := &Pact{Interactions: []Interaction{{
+		Description: "a technique selection",
+		Request: Request{
+			Method:  http.MethodPost,
+			Path:    "/api/v1/select",
+			Headers: map[string]string{"Content-Type": "application/json"},
+			Body:    map[string]interface{}{"text": "Sort a list", "intent": "code_generation", "complexity": "simple"},
+		},
+		Response: Response{
+			Status: http.StatusOK,
+			Body: map[string]interface{}{
+				"primary_technique": "chain_of_thought",
+				"techniques":        []interface{}{map[string]interface{}{"id": "chain_of_thought", "score": 1.0}},
+			},
+			MatchingRules: map[string]Rule{
+				"$.body.techniques[*].id": {Match: "regex", Regex: "^(chain_of_thought|few_shot)$"},
+			},
+		},
+	}}}
+
+	respond := func(body interface{}) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, "code_generation", req["intent"])
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(body)
+		})
+	}
+
+	results := Verify(respond(map[string]interface{}{
+		"primary_technique": "few_shot",
+		"techniques":        []interface{}{map[string]interface{}{"id": "few_shot", "score": 4.2, "name": "Few-Shot"}},
+	}), pact)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Passed(), "%v", results[0].Mismatches)
+
+	results = Verify(respond(map[string]interface{}{
+		"primaryTechnique": "few_shot",
+		"techniques":       []interface{}{map[string]interface{}{"id": "fewshot", "score": 4.2}},
+	}), pact)
+	assert.Equal(t, []string{
+		"$.body.primary_technique: missing",
+		`$.body.techniques[*].id: "fewshot" doesn't match ^(chain_of_thought|few_shot)$`,
+	}, results[0].Mismatches)
+}
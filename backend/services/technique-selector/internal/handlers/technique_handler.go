@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"net/http"
 
 	"github.com/betterprompts/technique-selector/internal/models"
@@ -23,20 +26,34 @@ func NewTechniqueHandler(engine *rules.Engine, logger *logrus.Logger) *Technique
 	}
 }
 
+// requestLogger returns the logger for the current request, carrying its
+// request ID when the router set one
+func (h *TechniqueHandler) requestLogger(c *gin.Context) *logrus.Entry {
+	if value, ok := c.Get("logger"); ok {
+		if entry, ok := value.(*logrus.Entry); ok {
+			return entry
+		}
+	}
+	return logrus.NewEntry(h.logger)
+}
+
 // SelectTechniques handles POST /select endpoint
 func (h *TechniqueHandler) SelectTechniques(c *gin.Context) {
+	logger := h.requestLogger(c)
+
 	var req models.SelectionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.logger.WithError(err).Error("Failed to bind request")
+		logger.WithError(err).Error("Failed to bind request")
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "Invalid request body",
 			"details": err.Error(),
+			"request_id": c.GetString("request_id"),
 		})
 		return
 	}
 
 	// Log request
-	h.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"intent":     req.Intent,
 		"complexity": req.Complexity,
 		"text_len":   len(req.Text),
@@ -45,16 +62,17 @@ func (h *TechniqueHandler) SelectTechniques(c *gin.Context) {
 	// Select techniques
 	response, err := h.engine.SelectTechniques(&req)
 	if err != nil {
-		h.logger.WithError(err).Error("Failed to select techniques")
+		logger.WithError(err).Error("Failed to select techniques")
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "Failed to select techniques",
 			"details": err.Error(),
+			"request_id": c.GetString("request_id"),
 		})
 		return
 	}
 
 	// Log response
-	h.logger.WithFields(logrus.Fields{
+	logger.WithFields(logrus.Fields{
 		"techniques_count": len(response.Techniques),
 		"primary_technique": response.PrimaryTechnique,
 		"confidence": response.Confidence,
@@ -63,66 +81,91 @@ func (h *TechniqueHandler) SelectTechniques(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// techniqueCatalog lists the techniques this service can select.
+// This would eventually be generated from the technique config.
+var techniqueCatalog = []gin.H{
+	{
+		"id":          "chain_of_thought",
+		"name":        "Chain of Thought",
+		"description": "Step-by-step reasoning that breaks down complex problems",
+	},
+	{
+		"id":          "tree_of_thoughts",
+		"name":        "Tree of Thoughts",
+		"description": "Explores multiple reasoning paths and evaluates different approaches",
+	},
+	{
+		"id":          "few_shot",
+		"name":        "Few-Shot Learning",
+		"description": "Provides examples to guide the response format and style",
+	},
+	{
+		"id":          "zero_shot",
+		"name":        "Zero-Shot Learning",
+		"description": "Direct task completion without examples",
+	},
+	{
+		"id":          "self_consistency",
+		"name":        "Self-Consistency",
+		"description": "Multiple attempts at solving with consistency verification",
+	},
+	{
+		"id":          "constitutional_ai",
+		"name":        "Constitutional AI",
+		"description": "Applies ethical principles and guidelines to responses",
+	},
+	{
+		"id":          "iterative_refinement",
+		"name":        "Iterative Refinement",
+		"description": "Progressive improvement through multiple iterations",
+	},
+	{
+		"id":          "role_based",
+		"name":        "Role-Based Prompting",
+		"description": "Assumes a specific role or expertise for the response",
+	},
+	{
+		"id":          "structured_output",
+		"name":        "Structured Output",
+		"description": "Provides response in a specific structured format",
+	},
+	{
+		"id":          "metacognitive",
+		"name":        "Metacognitive Prompting",
+		"description": "Reflects on the thinking process while solving",
+	},
+}
+
+// techniqueCatalogVersion is a content hash of techniqueCatalog, sent as the
+// ETag so callers like the API gateway can revalidate without a full fetch
+var techniqueCatalogVersion = func() string {
+	data, _ := json.Marshal(techniqueCatalog)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}()
+
 // ListTechniques handles GET /techniques endpoint
 func (h *TechniqueHandler) ListTechniques(c *gin.Context) {
-	// This would return all available techniques
-	// For now, we'll return a placeholder
-	techniques := []gin.H{
-		{
-			"id":          "chain_of_thought",
-			"name":        "Chain of Thought",
-			"description": "Step-by-step reasoning that breaks down complex problems",
-		},
-		{
-			"id":          "tree_of_thoughts",
-			"name":        "Tree of Thoughts",
-			"description": "Explores multiple reasoning paths and evaluates different approaches",
-		},
-		{
-			"id":          "few_shot",
-			"name":        "Few-Shot Learning",
-			"description": "Provides examples to guide the response format and style",
-		},
-		{
-			"id":          "zero_shot",
-			"name":        "Zero-Shot Learning",
-			"description": "Direct task completion without examples",
-		},
-		{
-			"id":          "self_consistency",
-			"name":        "Self-Consistency",
-			"description": "Multiple attempts at solving with consistency verification",
-		},
-		{
-			"id":          "constitutional_ai",
-			"name":        "Constitutional AI",
-			"description": "Applies ethical principles and guidelines to responses",
-		},
-		{
-			"id":          "iterative_refinement",
-			"name":        "Iterative Refinement",
-			"description": "Progressive improvement through multiple iterations",
-		},
-		{
-			"id":          "role_based",
-			"name":        "Role-Based Prompting",
-			"description": "Assumes a specific role or expertise for the response",
-		},
-		{
-			"id":          "structured_output",
-			"name":        "Structured Output",
-			"description": "Provides response in a specific structured format",
-		},
-		{
-			"id":          "metacognitive",
-			"name":        "Metacognitive Prompting",
-			"description": "Reflects on the thinking process while solving",
-		},
+	etag := `"` + techniqueCatalogVersion + `"`
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"techniques": techniques,
-		"total":      len(techniques),
+		"techniques": techniqueCatalog,
+		"total":      len(techniqueCatalog),
+		"version":    techniqueCatalogVersion,
+	})
+}
+
+// Version handles GET /version, reporting the loaded rules and catalog versions
+func (h *TechniqueHandler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"service":         "technique-selector",
+		"rules_version":   h.engine.Version(),
+		"catalog_version": techniqueCatalogVersion,
 	})
 }
 
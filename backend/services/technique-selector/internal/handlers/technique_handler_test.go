@@ -266,6 +266,29 @@ func TestListTechniques(t *testing.T) {
 	assert.Contains(t, firstTech, "description")
 }
 
+// TestListTechniquesConditional tests ETag revalidation of the catalog
+func TestListTechniquesConditional(t *testing.T) {
+	logger := logrus.New()
+	handler := NewTechniqueHandler(nil, logger)
+	router := setupRouter(handler)
+
+	req := httptest.NewRequest("GET", "/techniques", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	req = httptest.NewRequest("GET", "/techniques", nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+}
+
 // TestGetTechniqueByID tests the GetTechniqueByID handler
 func TestGetTechniqueByID(t *testing.T) {
 	logger := logrus.New()
@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Calibration maps a technique's raw score to the probability that users
+// rate an enhancement using it positively, by Platt scaling:
+//
+//	confidence = 1 / (1 + exp(A*raw + B))
+//
+// A is negative so higher scores give higher confidence. The zero value
+// isn't calibrated and falls back to raw/100, capped at 1.
+type Calibration struct {
+	A        float64 `yaml:"a" json:"a"`
+	B        float64 `yaml:"b" json:"b"`
+	Samples  int     `yaml:"samples,omitempty" json:"samples,omitempty"`
+	FittedAt string  `yaml:"fitted_at,omitempty" json:"fitted_at,omitempty"`
+}
+
+// Enabled reports whether coefficients are configured
+func (c Calibration) Enabled() bool {
+	return c.A != 0 || c.B != 0
+}
+
+// Confidence returns the calibrated confidence for a raw score
+func (c Calibration) Confidence(raw float64) float64 {
+	if raw <= 0 {
+		return 0
+	}
+	if !c.Enabled() {
+		return math.Min(raw/100.0, 1.0)
+	}
+	return 1 / (1 + math.Exp(c.A*raw+c.B))
+}
+
+// LoadCalibration reads the optional top-level "calibration" section of a
+// rules file. A file without one returns the zero Calibration.
+func LoadCalibration(path string) (Calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Calibration{}, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file struct {
+		Calibration Calibration `yaml:"calibration"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return Calibration{}, fmt.Errorf("failed to parse calibration: %w", err)
+	}
+	if file.Calibration.A > 0 {
+		return Calibration{}, errors.New("calibration coefficient a must be negative so confidence rises with score")
+	}
+	return file.Calibration, nil
+}
+
+// CalibrationSample is one historical selection: the raw score of the
+// technique used and whether the user rated the result positively
+type CalibrationSample struct {
+	RawScore float64 `json:"raw_score"`
+	Positive bool    `json:"positive"`
+}
+
+// FitCalibration fits Platt scaling coefficients to samples by Newton's
+// method, using Platt's smoothed targets so a small or one-sided sample
+// doesn't push confidence to exactly 0 or 1
+func FitCalibration(samples []CalibrationSample) (Calibration, error) {
+	var positives, negatives float64
+	for _, s := range samples {
+		if s.Positive {
+			positives++
+		} else {
+			negatives++
+		}
+	}
+	if positives == 0 || negatives == 0 {
+		return Calibration{}, errors.New("calibration needs both positive and negative samples")
+	}
+
+	hiTarget := (positives + 1) / (positives + 2)
+	loTarget := 1 / (negatives + 2)
+
+	// Start from the prior so the first step is well conditioned
+	a, b := 0.0, math.Log((negatives+1)/(positives+1))
+	for iter := 0; iter < 100; iter++ {
+		// Gradient and Hessian of the negative log-likelihood in (a, b)
+		var ga, gb, haa, hab, hbb float64
+		for _, s := range samples {
+			target := loTarget
+			if s.Positive {
+				target = hiTarget
+			}
+			p := 1 / (1 + math.Exp(a*s.RawScore+b))
+			d := target - p
+			w := p * (1 - p)
+			ga += d * s.RawScore
+			gb += d
+			haa += w * s.RawScore * s.RawScore
+			hab += w * s.RawScore
+			hbb += w
+		}
+
+		det := haa*hbb - hab*hab
+		if det <= 1e-12 {
+			return Calibration{}, errors.New("calibration samples don't vary in score")
+		}
+		da := -(hbb*ga - hab*gb) / det
+		db := -(haa*gb - hab*ga) / det
+		a += da
+		b += db
+		if math.Abs(da) < 1e-10 && math.Abs(db) < 1e-10 {
+			break
+		}
+	}
+
+	if a >= 0 {
+		return Calibration{}, errors.New("higher scores aren't rated better in these samples; keep the current calibration")
+	}
+	return Calibration{A: a, B: b, Samples: len(samples)}, nil
+}
@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/betterprompts/technique-selector/internal/models"
+)
+
+// TestCalibrationConfidence tests the uncalibrated fallback and Platt scaling
+func TestCalibrationConfidence(t *testing.T) {
+	var none Calibration
+	if none.Enabled() {
+		t.Fatal("Expected the zero calibration to be disabled")
+	}
+	if got := none.Confidence(70); got != 0.7 {
+		t.Errorf("Expected uncalibrated confidence 0.7, got %f", got)
+	}
+	if got := none.Confidence(130); got != 1 {
+		t.Errorf("Expected uncalibrated confidence to cap at 1, got %f", got)
+	}
+
+	platt := Calibration{A: -0.045, B: 2.3}
+	if got := platt.Confidence(0); got != 0 {
+		t.Errorf("Expected no confidence without a score, got %f", got)
+	}
+	previous := 0.0
+	for _, raw := range []float64{10, 50, 70, 100, 150} {
+		got := platt.Confidence(raw)
+		if got <= previous || got >= 1 {
+			t.Errorf("Expected confidence to rise within (0, 1), got %f at %v", got, raw)
+		}
+		previous = got
+	}
+	if got := platt.Confidence(70); math.Abs(got-0.7) > 0.001 {
+		t.Errorf("Expected raw 70 to calibrate to about 0.7, got %f", got)
+	}
+}
+
+// TestFitCalibration tests that fitting recovers the coefficients that
+// generated the samples
+func TestFitCalibration(t *testing.T) {
+	truth := Calibration{A: -0.06, B: 4}
+	rng := rand.New(rand.NewSource(1))
+
+	var samples []CalibrationSample
+	for i := 0; i < 5000; i++ {
+		raw := 20 + rng.Float64()*100
+		samples = append(samples, CalibrationSample{
+			RawScore: raw,
+			Positive: rng.Float64() < truth.Confidence(raw),
+		})
+	}
+
+	fitted, err := FitCalibration(samples)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fitted.Samples != len(samples) {
+		t.Errorf("Expected %d samples recorded, got %d", len(samples), fitted.Samples)
+	}
+	for _, raw := range []float64{40, 70, 100} {
+		if diff := math.Abs(fitted.Confidence(raw) - truth.Confidence(raw)); diff > 0.03 {
+			t.Errorf("Fitted confidence at %v is %f off the truth", raw, diff)
+		}
+	}
+
+	if _, err := FitCalibration([]CalibrationSample{{RawScore: 80, Positive: true}}); err == nil {
+		t.Error("Expected an error without negative samples")
+	}
+	inverted := []CalibrationSample{
+		{RawScore: 90, Positive: false}, {RawScore: 80, Positive: false},
+		{RawScore: 40, Positive: true}, {RawScore: 30, Positive: true},
+	}
+	if _, err := FitCalibration(inverted); err == nil {
+		t.Error("Expected an error when scores predict negative feedback")
+	}
+}
+
+// TestLoadCalibration tests reading the calibration section of a rules file
+func TestLoadCalibration(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "rules.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	calibration, err := LoadCalibration(write("selection_rules:\n  max_techniques: 3\ncalibration:\n  a: -0.05\n  b: 3.5\n  samples: 1200\n"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calibration != (Calibration{A: -0.05, B: 3.5, Samples: 1200}) {
+		t.Errorf("Unexpected calibration %+v", calibration)
+	}
+
+	calibration, err = LoadCalibration(write("selection_rules:\n  max_techniques: 3\n"))
+	if err != nil || calibration.Enabled() {
+		t.Errorf("Expected no calibration, got %+v, %v", calibration, err)
+	}
+
+	if _, err := LoadCalibration(write("calibration:\n  a: 0.05\n  b: 1\n")); err == nil {
+		t.Error("Expected an error for a positive slope")
+	}
+}
+
+// TestScoreNormalization tests that selected scores are rescaled to [0, 1]
+// with the raw scores reported alongside
+func TestScoreNormalization(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+	engine.SetCalibration(Calibration{A: -0.045, B: 2.3})
+
+	response, err := engine.SelectTechniques(&models.SelectionRequest{
+		Text:       "Explain why this works and verify it step by step",
+		Intent:     "reasoning",
+		Complexity: "complex",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Techniques) == 0 {
+		t.Fatal("Expected techniques to be selected")
+	}
+
+	raw, ok := response.Metadata["raw_scores"].(map[string]float64)
+	if !ok {
+		t.Fatalf("Expected raw scores in metadata, got %v", response.Metadata["raw_scores"])
+	}
+	if response.Techniques[0].Score != 1 {
+		t.Errorf("Expected the top technique to normalize to 1, got %f", response.Techniques[0].Score)
+	}
+	for _, tech := range response.Techniques {
+		if tech.Score <= 0 || tech.Score > 1 {
+			t.Errorf("Expected %s score in (0, 1], got %f", tech.ID, tech.Score)
+		}
+		if want := engine.calibration.Confidence(raw[tech.ID]); tech.Confidence != want {
+			t.Errorf("Expected %s confidence %f from raw score %f, got %f", tech.ID, want, raw[tech.ID], tech.Confidence)
+		}
+	}
+	if response.Metadata["confidence_calibration"] != "platt" {
+		t.Errorf("Expected platt calibration, got %v", response.Metadata["confidence_calibration"])
+	}
+
+	uncalibrated := NewEngine(createTestConfig(), createTestLogger())
+	if engine.Version() == uncalibrated.Version() {
+		t.Error("Expected calibration to change the rules version")
+	}
+}
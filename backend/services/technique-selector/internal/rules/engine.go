@@ -1,10 +1,17 @@
 package rules
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
 	"math"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/betterprompts/technique-selector/internal/models"
 	"github.com/sirupsen/logrus"
@@ -12,8 +19,20 @@ import (
 
 // Engine is the rule-based technique selection engine
 type Engine struct {
-	config *models.RulesConfig
-	logger *logrus.Logger
+	config      *models.RulesConfig
+	logger      *logrus.Logger
+	matchers    []techniqueMatcher
+	version     string
+	calibration Calibration
+	exploration *Exploration // Optional; techniques are ranked strictly by score when nil
+}
+
+// Exploration perturbs the ranking of near-tied techniques so alternatives
+// get selected some of the time. The perturbation is derived from Seed and
+// the request, so an identical request always gets an identical selection.
+type Exploration struct {
+	Seed   int64
+	Jitter float64 // Largest score bonus a technique can receive for ranking
 }
 
 // complexityStringToFloat converts string complexity to float value
@@ -42,19 +61,125 @@ func complexityFloatToString(complexity float64) string {
 
 // NewEngine creates a new technique selection engine
 func NewEngine(config *models.RulesConfig, logger *logrus.Logger) *Engine {
+	matchers := make([]techniqueMatcher, len(config.Techniques))
+	for i := range config.Techniques {
+		matchers[i] = newTechniqueMatcher(&config.Techniques[i])
+	}
 	return &Engine{
-		config: config,
-		logger: logger,
+		config:   config,
+		logger:   logger,
+		matchers: matchers,
+		version:  rulesVersion(config),
+	}
+}
+
+// techniqueMatcher is a technique with its text conditions lowercased once
+// at load instead of on every request
+type techniqueMatcher struct {
+	technique           *models.Technique
+	keywords            []string
+	multiStepIndicators []string
+}
+
+func newTechniqueMatcher(technique *models.Technique) techniqueMatcher {
+	lower := func(terms []string) []string {
+		lowered := make([]string, len(terms))
+		for i, term := range terms {
+			lowered[i] = strings.ToLower(term)
+		}
+		return lowered
+	}
+	return techniqueMatcher{
+		technique:           technique,
+		keywords:            lower(technique.Conditions.Keywords),
+		multiStepIndicators: lower(technique.Conditions.MultiStepIndicators),
+	}
+}
+
+// selection is the scratch state of one SelectTechniques call. It is
+// pooled so the slices are reused across requests rather than regrown.
+type selection struct {
+	scored  []models.SelectedTechnique
+	reasons []scoreReasons // reasons[i] explains scored[i]
+	ranked  []models.SelectedTechnique
+}
+
+var selectionPool = sync.Pool{
+	New: func() interface{} { return new(selection) },
+}
+
+// reasonsFor returns the reasons recorded for a scored technique
+func (s *selection) reasonsFor(id string) scoreReasons {
+	for i := range s.scored {
+		if s.scored[i].ID == id {
+			return s.reasons[i]
+		}
 	}
+	return scoreReasons{}
+}
+
+// SetCalibration sets the coefficients used to turn raw scores into
+// confidence. Calibrated engines report a different rules version, since
+// the same rules can select differently under a new calibration.
+func (e *Engine) SetCalibration(calibration Calibration) {
+	e.calibration = calibration
+	e.version = rulesVersion(e.config)
+	if calibration.Enabled() {
+		data, _ := json.Marshal(struct {
+			Version     string
+			Calibration Calibration
+		}{e.version, calibration})
+		sum := sha256.Sum256(data)
+		e.version = hex.EncodeToString(sum[:6])
+	}
+}
+
+// EnableExploration turns on seeded exploration. A non-positive jitter
+// disables it again.
+func (e *Engine) EnableExploration(seed int64, jitter float64) {
+	if jitter <= 0 {
+		e.exploration = nil
+		return
+	}
+	e.exploration = &Exploration{Seed: seed, Jitter: jitter}
+}
+
+// rulesVersion is a content hash of the rules configuration, so every
+// deployment of the same rules reports the same version
+func rulesVersion(config *models.RulesConfig) string {
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// Version returns the version of the loaded rules configuration
+func (e *Engine) Version() string {
+	return e.version
 }
 
 // SelectTechniques selects appropriate techniques based on the request
 func (e *Engine) SelectTechniques(req *models.SelectionRequest) (*models.SelectionResponse, error) {
-	e.logger.WithFields(logrus.Fields{
-		"intent":     req.Intent,
-		"complexity": req.Complexity,
-		"text_len":   len(req.Text),
-	}).Debug("Selecting techniques")
+	return e.selectTechniques(req, nil)
+}
+
+// selectionTrace records how a selection was made, for offline evaluation:
+// why each selected technique scored, and the confidence of every technique
+// that matched at all
+type selectionTrace struct {
+	reasons map[string]scoreReasons
+	matched map[string]float64
+}
+
+// selectTechniques selects techniques for the request, filling trace when
+// it isn't nil
+func (e *Engine) selectTechniques(req *models.SelectionRequest, trace *selectionTrace) (*models.SelectionResponse, error) {
+	if e.logger.IsLevelEnabled(logrus.DebugLevel) {
+		e.logger.WithFields(logrus.Fields{
+			"intent":     req.Intent,
+			"complexity": req.Complexity,
+			"text_len":   len(req.Text),
+		}).Debug("Selecting techniques")
+	}
 
 	// Convert string complexity to float for internal calculations
 	complexityFloat := complexityStringToFloat(req.Complexity)
@@ -65,11 +190,19 @@ func (e *Engine) SelectTechniques(req *models.SelectionRequest) (*models.Selecti
 		req.Complexity = complexityFloatToString(complexityFloat)
 	}
 
+	s := selectionPool.Get().(*selection)
+	defer selectionPool.Put(s)
+
 	// Score all techniques
-	scoredTechniques := e.scoreTechniques(req, complexityFloat)
+	e.scoreTechniques(s, req, complexityFloat)
+	best := 0.0
+	for _, tech := range s.scored {
+		best = math.Max(best, tech.Score)
+	}
 
 	// Filter and sort techniques
-	selectedTechniques := e.filterAndSort(scoredTechniques, req)
+	s.ranked = append(s.ranked[:0], s.scored...)
+	selectedTechniques := e.filterAndSort(s.ranked, req)
 
 	// Apply combination rules
 	selectedTechniques = e.applyCombinationRules(selectedTechniques)
@@ -83,6 +216,26 @@ func (e *Engine) SelectTechniques(req *models.SelectionRequest) (*models.Selecti
 		selectedTechniques = selectedTechniques[:maxTechniques]
 	}
 
+	// Copy the selection out of the pooled scratch, formatting reasoning
+	// only for the techniques that made it
+	if len(selectedTechniques) > 0 {
+		selectedTechniques = append([]models.SelectedTechnique(nil), selectedTechniques...)
+		for i := range selectedTechniques {
+			reasons := s.reasonsFor(selectedTechniques[i].ID)
+			selectedTechniques[i].Reasoning = reasons.String()
+			if trace != nil {
+				trace.reasons[selectedTechniques[i].ID] = reasons
+			}
+		}
+	} else {
+		selectedTechniques = nil
+	}
+	if trace != nil {
+		for _, tech := range s.scored {
+			trace.matched[tech.ID] = tech.Confidence
+		}
+	}
+
 	// Build response
 	response := &models.SelectionResponse{
 		Techniques: selectedTechniques,
@@ -91,10 +244,20 @@ func (e *Engine) SelectTechniques(req *models.SelectionRequest) (*models.Selecti
 		Metadata: map[string]interface{}{
 			"complexity":     req.Complexity,
 			"intent":         req.Intent,
-			"word_count":     len(strings.Fields(req.Text)),
-			"techniques_evaluated": len(scoredTechniques),
+			"word_count":     countWords(req.Text),
+			"techniques_evaluated": len(s.scored),
+			"rules_version":  e.version,
 		},
 	}
+	if e.exploration != nil {
+		response.Metadata["exploration_seed"] = e.exploration.Seed
+	}
+	response.Metadata["raw_scores"] = normalizeScores(response.Techniques, best)
+	if e.calibration.Enabled() {
+		response.Metadata["confidence_calibration"] = "platt"
+	} else {
+		response.Metadata["confidence_calibration"] = "none"
+	}
 
 	if len(selectedTechniques) > 0 {
 		response.PrimaryTechnique = selectedTechniques[0].ID
@@ -103,15 +266,26 @@ func (e *Engine) SelectTechniques(req *models.SelectionRequest) (*models.Selecti
 	return response, nil
 }
 
-// scoreTechniques scores all techniques based on the request
-func (e *Engine) scoreTechniques(req *models.SelectionRequest, complexityFloat float64) []models.SelectedTechnique {
-	var scoredTechniques []models.SelectedTechnique
+// scoreTechniques scores all techniques based on the request into s.scored,
+// recording why each scored in s.reasons
+func (e *Engine) scoreTechniques(s *selection, req *models.SelectionRequest, complexityFloat float64) {
+	s.scored = s.scored[:0]
+	s.reasons = s.reasons[:0]
+	textLower := strings.ToLower(req.Text)
+	weights := techniqueWeights(req)
+
+	for i := range e.matchers {
+		m := &e.matchers[i]
+		score, confidence, reasons := e.scoreTechnique(m, req, textLower, complexityFloat)
+		if weight, ok := weights[m.technique.ID]; ok && score > 0 && weight != 1 {
+			score *= weight
+			reasons.add(reasonPersonalized)
+			reasons.weight = weight
+		}
 
-	for _, technique := range e.config.Techniques {
-		score, confidence, reasoning := e.scoreTechnique(technique, req, complexityFloat)
-		
 		if score > 0 {
-			selected := models.SelectedTechnique{
+			technique := m.technique
+			s.scored = append(s.scored, models.SelectedTechnique{
 				ID:          technique.ID,
 				Name:        technique.Name,
 				Description: technique.Description,
@@ -119,22 +293,44 @@ func (e *Engine) scoreTechniques(req *models.SelectionRequest, complexityFloat f
 				Priority:    technique.Priority,
 				Score:       score,
 				Confidence:  confidence,
-				Reasoning:   reasoning,
 				Parameters:  technique.Parameters,
-			}
-			scoredTechniques = append(scoredTechniques, selected)
+			})
+			s.reasons = append(s.reasons, reasons)
 		}
 	}
+}
+
+// Bounds on the per-user technique weights a request can carry
+const (
+	minTechniqueWeight = 0.5
+	maxTechniqueWeight = 1.5
+)
 
-	return scoredTechniques
+// techniqueWeights reads the per-user weights the gateway sends as the
+// "technique_weights" context, clamped so personalization can reorder
+// techniques that matched but can't make one match on its own
+func techniqueWeights(req *models.SelectionRequest) map[string]float64 {
+	raw, ok := req.Context["technique_weights"].(map[string]interface{})
+	if !ok || len(raw) == 0 {
+		return nil
+	}
+	weights := make(map[string]float64, len(raw))
+	for id, value := range raw {
+		if weight, ok := value.(float64); ok {
+			weights[id] = math.Max(minTechniqueWeight, math.Min(maxTechniqueWeight, weight))
+		}
+	}
+	return weights
 }
 
-// scoreTechnique scores a single technique
-func (e *Engine) scoreTechnique(technique models.Technique, req *models.SelectionRequest, complexityFloat float64) (float64, float64, string) {
+// scoreTechnique scores a single technique against the request, given the
+// request text already lowercased
+func (e *Engine) scoreTechnique(m *techniqueMatcher, req *models.SelectionRequest, textLower string, complexityFloat float64) (float64, float64, scoreReasons) {
 	score := 0.0
 	confidence := 0.0
-	var reasons []string
+	var reasons scoreReasons
 
+	technique := m.technique
 	conditions := technique.Conditions
 
 	// Check intent match
@@ -144,13 +340,14 @@ func (e *Engine) scoreTechnique(technique models.Technique, req *models.Selectio
 			if intent == req.Intent {
 				intentMatch = true
 				score += 30.0
-				reasons = append(reasons, fmt.Sprintf("matches intent '%s'", intent))
+				reasons.add(reasonIntent)
+				reasons.intent = intent
 				break
 			}
 		}
 		if !intentMatch && len(conditions.Intents) > 0 {
 			// Intent specified but doesn't match
-			return 0, 0, ""
+			return 0, 0, scoreReasons{}
 		}
 	}
 
@@ -161,99 +358,212 @@ func (e *Engine) scoreTechnique(technique models.Technique, req *models.Selectio
 			if level == req.Complexity {
 				complexityMatch = true
 				score += 20.0
-				reasons = append(reasons, fmt.Sprintf("matches complexity level '%s'", level))
+				reasons.add(reasonComplexityLevel)
+				reasons.complexityLevel = level
 				break
 			}
 		}
 		if !complexityMatch {
 			// Complexity level doesn't match
-			return 0, 0, ""
+			return 0, 0, scoreReasons{}
 		}
 	}
-	
+
+	reasons.complexity = complexityFloat
+
 	// Check complexity threshold (legacy float-based approach)
 	if conditions.ComplexityThreshold > 0 && complexityFloat >= conditions.ComplexityThreshold {
 		score += 20.0
-		reasons = append(reasons, fmt.Sprintf("complexity %.2f >= %.2f", complexityFloat, conditions.ComplexityThreshold))
+		reasons.add(reasonComplexityThreshold)
+		reasons.threshold = conditions.ComplexityThreshold
 	} else if conditions.ComplexityThreshold > 0 && complexityFloat < conditions.ComplexityThreshold {
 		// Complexity too low
-		return 0, 0, ""
+		return 0, 0, scoreReasons{}
 	}
 
 	// Check maximum complexity threshold
 	if conditions.ComplexityThresholdMax > 0 && complexityFloat <= conditions.ComplexityThresholdMax {
 		score += 10.0
-		reasons = append(reasons, fmt.Sprintf("complexity %.2f <= %.2f", complexityFloat, conditions.ComplexityThresholdMax))
+		reasons.add(reasonComplexityThresholdMax)
+		reasons.thresholdMax = conditions.ComplexityThresholdMax
 	} else if conditions.ComplexityThresholdMax > 0 && complexityFloat > conditions.ComplexityThresholdMax {
 		// Complexity too high
-		return 0, 0, ""
+		return 0, 0, scoreReasons{}
 	}
 
 	// Check keywords
 	keywordMatches := 0
-	textLower := strings.ToLower(req.Text)
-	for _, keyword := range conditions.Keywords {
-		if strings.Contains(textLower, strings.ToLower(keyword)) {
+	for _, keyword := range m.keywords {
+		if strings.Contains(textLower, keyword) {
 			keywordMatches++
 		}
 	}
 	if keywordMatches > 0 {
 		keywordScore := math.Min(float64(keywordMatches)*10, 30)
 		score += keywordScore
-		reasons = append(reasons, fmt.Sprintf("%d keyword matches", keywordMatches))
+		reasons.add(reasonKeywords)
+		reasons.keywordMatches = keywordMatches
 	}
 
 	// Check multi-step indicators
 	multiStepMatches := 0
-	for _, indicator := range conditions.MultiStepIndicators {
-		if strings.Contains(textLower, strings.ToLower(indicator)) {
+	for _, indicator := range m.multiStepIndicators {
+		if strings.Contains(textLower, indicator) {
 			multiStepMatches++
 		}
 	}
 	if multiStepMatches > 0 {
 		score += float64(multiStepMatches) * 15
-		reasons = append(reasons, "contains multi-step indicators")
+		reasons.add(reasonMultiStep)
 	}
 
 	// Check boolean conditions
 	if conditions.RequiresExploration && strings.Contains(textLower, "explore") {
 		score += 15
-		reasons = append(reasons, "requires exploration")
+		reasons.add(reasonExploration)
 	}
 	if conditions.RequiresPattern && (strings.Contains(textLower, "pattern") || strings.Contains(textLower, "example")) {
 		score += 15
-		reasons = append(reasons, "requires pattern matching")
+		reasons.add(reasonPattern)
 	}
 	if conditions.RequiresAccuracy && (strings.Contains(textLower, "accurate") || strings.Contains(textLower, "verify")) {
 		score += 15
-		reasons = append(reasons, "requires accuracy")
+		reasons.add(reasonAccuracy)
 	}
 	if conditions.SimpleRequest && req.Complexity == "simple" {
 		score += 20
-		reasons = append(reasons, "simple request")
+		reasons.add(reasonSimpleRequest)
 	}
 
 	// Apply priority boost based on intent
 	if boost, exists := e.config.SelectionRules.IntentPriorityBoost[req.Intent][technique.ID]; exists {
 		score += float64(boost) * 10
-		reasons = append(reasons, fmt.Sprintf("intent priority boost +%d", boost))
+		reasons.add(reasonPriorityBoost)
+		reasons.boost = boost
 	}
 
 	// Apply base priority
 	score += float64(technique.Priority)
 
-	// Calculate confidence
-	if score > 0 {
-		confidence = math.Min(score/100.0, 1.0)
+	confidence = e.calibration.Confidence(score)
+
+	return score, confidence, reasons
+}
+
+// scoreReasons records which conditions contributed to a technique's score.
+// Most scored techniques are filtered out, so the reasoning text is only
+// built by String for the ones that are returned.
+type scoreReasons struct {
+	matched         uint16
+	intent          string
+	complexityLevel string
+	complexity      float64
+	threshold       float64
+	thresholdMax    float64
+	keywordMatches  int
+	boost           int
+	weight          float64
+}
+
+// Conditions a technique can match, in the order they appear in reasoning
+const (
+	reasonIntent uint16 = 1 << iota
+	reasonComplexityLevel
+	reasonComplexityThreshold
+	reasonComplexityThresholdMax
+	reasonKeywords
+	reasonMultiStep
+	reasonExploration
+	reasonPattern
+	reasonAccuracy
+	reasonSimpleRequest
+	reasonPriorityBoost
+	reasonPersonalized
+)
+
+func (r *scoreReasons) add(reason uint16) {
+	r.matched |= reason
+}
+
+// String formats the reasons as a comma-separated list. It writes pieces
+// rather than using fmt, which would allocate for every boxed argument.
+func (r scoreReasons) String() string {
+	var b strings.Builder
+	b.Grow(128)
+	var num [24]byte
+	writeFloat := func(f float64) {
+		b.Write(strconv.AppendFloat(num[:0], f, 'f', 2, 64))
+	}
+	for reason := reasonIntent; reason <= reasonPersonalized; reason <<= 1 {
+		if r.matched&reason == 0 {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		switch reason {
+		case reasonIntent:
+			b.WriteString("matches intent '")
+			b.WriteString(r.intent)
+			b.WriteByte('\'')
+		case reasonComplexityLevel:
+			b.WriteString("matches complexity level '")
+			b.WriteString(r.complexityLevel)
+			b.WriteByte('\'')
+		case reasonComplexityThreshold:
+			b.WriteString("complexity ")
+			writeFloat(r.complexity)
+			b.WriteString(" >= ")
+			writeFloat(r.threshold)
+		case reasonComplexityThresholdMax:
+			b.WriteString("complexity ")
+			writeFloat(r.complexity)
+			b.WriteString(" <= ")
+			writeFloat(r.thresholdMax)
+		case reasonKeywords:
+			b.Write(strconv.AppendInt(num[:0], int64(r.keywordMatches), 10))
+			b.WriteString(" keyword matches")
+		case reasonMultiStep:
+			b.WriteString("contains multi-step indicators")
+		case reasonExploration:
+			b.WriteString("requires exploration")
+		case reasonPattern:
+			b.WriteString("requires pattern matching")
+		case reasonAccuracy:
+			b.WriteString("requires accuracy")
+		case reasonSimpleRequest:
+			b.WriteString("simple request")
+		case reasonPriorityBoost:
+			b.WriteString("intent priority boost +")
+			b.Write(strconv.AppendInt(num[:0], int64(r.boost), 10))
+		case reasonPersonalized:
+			b.WriteString("personalized weight x")
+			writeFloat(r.weight)
+		}
 	}
+	return b.String()
+}
 
-	reasoning := strings.Join(reasons, ", ")
-	return score, confidence, reasoning
+// normalizeScores rescales the selected techniques' raw scores to [0, 1]
+// relative to best, the top raw score among all techniques considered for
+// the request, and returns the raw scores by technique ID. Raw points only
+// mean something relative to each other, so they aren't comparable across
+// requests; confidence is the cross-request measure.
+func normalizeScores(selected []models.SelectedTechnique, best float64) map[string]float64 {
+	raw := make(map[string]float64, len(selected))
+	for i := range selected {
+		raw[selected[i].ID] = selected[i].Score
+		if best > 0 {
+			selected[i].Score /= best
+		}
+	}
+	return raw
 }
 
-// filterAndSort filters techniques by minimum confidence and sorts by score
+// filterAndSort filters techniques by minimum confidence and sorts by score.
+// It filters in place, reusing the backing array of techniques.
 func (e *Engine) filterAndSort(techniques []models.SelectedTechnique, req *models.SelectionRequest) []models.SelectedTechnique {
-	var filtered []models.SelectedTechnique
+	filtered := techniques[:0]
 
 	minConfidence := e.config.SelectionRules.MinConfidence
 	for _, tech := range techniques {
@@ -261,23 +571,56 @@ func (e *Engine) filterAndSort(techniques []models.SelectedTechnique, req *model
 			filtered = append(filtered, tech)
 		}
 	}
+	if len(filtered) == 0 {
+		return nil
+	}
 
-	// Sort by score (descending)
-	sort.Slice(filtered, func(i, j int) bool {
-		return filtered[i].Score > filtered[j].Score
+	// Rank by score, breaking ties by priority then ID so the order doesn't
+	// depend on where techniques appear in the config
+	rankScore := func(tech *models.SelectedTechnique) float64 {
+		if e.exploration != nil {
+			return tech.Score + e.exploration.bonus(req, tech.ID)
+		}
+		return tech.Score
+	}
+	slices.SortStableFunc(filtered, func(x, y models.SelectedTechnique) int {
+		a, b := rankScore(&x), rankScore(&y)
+		switch {
+		case a > b:
+			return -1
+		case a < b:
+			return 1
+		case x.Priority != y.Priority:
+			return y.Priority - x.Priority
+		}
+		return strings.Compare(x.ID, y.ID)
 	})
 
 	return filtered
 }
 
-// applyCombinationRules applies compatibility rules to selected techniques
+// bonus returns a ranking bonus in [0, Jitter) for a technique, derived
+// from the seed, the request and the technique ID alone
+func (x *Exploration) bonus(req *models.SelectionRequest, techniqueID string) float64 {
+	h := fnv.New64a()
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], uint64(x.Seed))
+	h.Write(seed[:])
+	for _, part := range []string{req.Text, req.Intent, req.Complexity, techniqueID} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return float64(h.Sum64()>>11) / (1 << 53) * x.Jitter
+}
+
+// applyCombinationRules applies compatibility rules to selected techniques,
+// in place
 func (e *Engine) applyCombinationRules(techniques []models.SelectedTechnique) []models.SelectedTechnique {
 	if len(techniques) <= 1 {
 		return techniques
 	}
 
-	var result []models.SelectedTechnique
-	result = append(result, techniques[0]) // Always keep the highest scoring technique
+	result := techniques[:1] // Always keep the highest scoring technique
 
 	for i := 1; i < len(techniques); i++ {
 		candidate := techniques[i]
@@ -287,10 +630,12 @@ func (e *Engine) applyCombinationRules(techniques []models.SelectedTechnique) []
 		for _, existing := range result {
 			if e.areIncompatible(existing.ID, candidate.ID) {
 				compatible = false
-				e.logger.WithFields(logrus.Fields{
-					"technique1": existing.ID,
-					"technique2": candidate.ID,
-				}).Debug("Techniques are incompatible")
+				if e.logger.IsLevelEnabled(logrus.DebugLevel) {
+					e.logger.WithFields(logrus.Fields{
+						"technique1": existing.ID,
+						"technique2": candidate.ID,
+					}).Debug("Techniques are incompatible")
+				}
 				break
 			}
 		}
@@ -319,7 +664,7 @@ func (e *Engine) calculateComplexity(text string) float64 {
 	factors := e.config.ComplexityFactors
 
 	// Word count factor
-	wordCount := len(strings.Fields(text))
+	wordCount := countWords(text)
 	for _, wcRange := range factors.WordCount {
 		if wordCount >= wcRange.Range[0] && (wcRange.Range[1] == -1 || wordCount <= wcRange.Range[1]) {
 			complexity += wcRange.Score
@@ -332,10 +677,12 @@ func (e *Engine) calculateComplexity(text string) float64 {
 		complexity += factors.MultiPartQuestion
 	}
 
+	textLower := strings.ToLower(text)
+
 	// Technical terms (simplified check)
 	technicalTerms := []string{"algorithm", "function", "database", "API", "implement", "optimize", "analyze"}
 	for _, term := range technicalTerms {
-		if strings.Contains(strings.ToLower(text), term) {
+		if strings.Contains(textLower, term) {
 			complexity += factors.TechnicalTerms / float64(len(technicalTerms))
 		}
 	}
@@ -343,7 +690,7 @@ func (e *Engine) calculateComplexity(text string) float64 {
 	// Abstract concepts
 	abstractTerms := []string{"concept", "theory", "principle", "philosophy", "abstract"}
 	for _, term := range abstractTerms {
-		if strings.Contains(strings.ToLower(text), term) {
+		if strings.Contains(textLower, term) {
 			complexity += factors.AbstractConcepts / float64(len(abstractTerms))
 		}
 	}
@@ -351,6 +698,22 @@ func (e *Engine) calculateComplexity(text string) float64 {
 	return math.Min(complexity, 1.0)
 }
 
+// countWords counts whitespace-separated words like len(strings.Fields(text))
+// without allocating the fields
+func countWords(text string) int {
+	count := 0
+	inWord := false
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			inWord = false
+		} else if !inWord {
+			inWord = true
+			count++
+		}
+	}
+	return count
+}
+
 // calculateOverallConfidence calculates the overall confidence
 func (e *Engine) calculateOverallConfidence(techniques []models.SelectedTechnique) float64 {
 	if len(techniques) == 0 {
@@ -375,25 +738,36 @@ func (e *Engine) calculateOverallConfidence(techniques []models.SelectedTechniqu
 
 // generateReasoning generates human-readable reasoning for the selection
 func (e *Engine) generateReasoning(techniques []models.SelectedTechnique, req *models.SelectionRequest) string {
-	parts := []string{
-		fmt.Sprintf("Based on intent '%s' and complexity '%s'", req.Intent, req.Complexity),
-	}
+	var b strings.Builder
+	b.Grow(256)
+	b.WriteString("Based on intent '")
+	b.WriteString(req.Intent)
+	b.WriteString("' and complexity '")
+	b.WriteString(req.Complexity)
+	b.WriteString("'. ")
 
 	if len(techniques) == 0 {
-		parts = append(parts, "no techniques were selected as none met the criteria")
+		b.WriteString("no techniques were selected as none met the criteria")
 	} else if len(techniques) == 1 {
-		parts = append(parts, fmt.Sprintf("selected '%s' technique because it %s", 
-			techniques[0].Name, techniques[0].Reasoning))
+		b.WriteString("selected '")
+		b.WriteString(techniques[0].Name)
+		b.WriteString("' technique because it ")
+		b.WriteString(techniques[0].Reasoning)
 	} else {
-		techNames := make([]string, len(techniques))
+		b.WriteString("selected ")
+		b.WriteString(strconv.Itoa(len(techniques)))
+		b.WriteString(" techniques: ")
 		for i, tech := range techniques {
-			techNames[i] = tech.Name
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(tech.Name)
 		}
-		parts = append(parts, fmt.Sprintf("selected %d techniques: %s", 
-			len(techniques), strings.Join(techNames, ", ")))
-		parts = append(parts, fmt.Sprintf("Primary technique '%s' scored highest because it %s",
-			techniques[0].Name, techniques[0].Reasoning))
+		b.WriteString(". Primary technique '")
+		b.WriteString(techniques[0].Name)
+		b.WriteString("' scored highest because it ")
+		b.WriteString(techniques[0].Reasoning)
 	}
 
-	return strings.Join(parts, ". ")
+	return b.String()
 }
\ No newline at end of file
@@ -1,9 +1,12 @@
 package rules
 
 import (
+	"fmt"
+	"io"
 	"strings"
 	"testing"
 
+	"github.com/betterprompts/technique-selector/internal/config"
 	"github.com/betterprompts/technique-selector/internal/models"
 	"github.com/sirupsen/logrus"
 )
@@ -140,6 +143,34 @@ func TestNewEngine(t *testing.T) {
 	}
 }
 
+// TestRulesVersion tests that the rules version tracks the configuration content
+func TestRulesVersion(t *testing.T) {
+	logger := createTestLogger()
+
+	first := NewEngine(createTestConfig(), logger)
+	second := NewEngine(createTestConfig(), logger)
+	if first.Version() == "" {
+		t.Fatal("Expected a rules version")
+	}
+	if first.Version() != second.Version() {
+		t.Errorf("Expected identical configs to share a version, got %s and %s", first.Version(), second.Version())
+	}
+
+	changed := createTestConfig()
+	changed.SelectionRules.MaxTechniques++
+	if NewEngine(changed, logger).Version() == first.Version() {
+		t.Error("Expected a changed config to get a new version")
+	}
+
+	response, err := first.SelectTechniques(&models.SelectionRequest{Text: "explain why", Intent: "reasoning"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if response.Metadata["rules_version"] != first.Version() {
+		t.Errorf("Expected response to be stamped with rules version %s, got %v", first.Version(), response.Metadata["rules_version"])
+	}
+}
+
 // TestComplexityConversion tests complexity string/float conversion
 func TestComplexityConversion(t *testing.T) {
 	testCases := []struct {
@@ -430,7 +461,9 @@ func TestScoreTechnique(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			score, confidence, reasoning := engine.scoreTechnique(tc.technique, tc.request, tc.complexityFloat)
+			matcher := newTechniqueMatcher(&tc.technique)
+			score, confidence, reasons := engine.scoreTechnique(&matcher, tc.request, strings.ToLower(tc.request.Text), tc.complexityFloat)
+			reasoning := reasons.String()
 
 			if tc.expectScore && score == 0 {
 				t.Errorf("Expected score > 0, got %f. Reasoning: %s", score, reasoning)
@@ -486,6 +519,120 @@ func TestFilterAndSort(t *testing.T) {
 	}
 }
 
+// TestFilterAndSortTieBreak tests that equal scores rank by priority then ID
+// regardless of input order
+func TestFilterAndSortTieBreak(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+
+	techniques := []models.SelectedTechnique{
+		{ID: "zeta", Score: 70, Priority: 3, Confidence: 0.7},
+		{ID: "beta", Score: 70, Priority: 3, Confidence: 0.7},
+		{ID: "alpha", Score: 70, Priority: 1, Confidence: 0.7},
+		{ID: "gamma", Score: 70, Priority: 5, Confidence: 0.7},
+		{ID: "top", Score: 80, Priority: 1, Confidence: 0.8},
+	}
+	expectedOrder := []string{"top", "gamma", "beta", "zeta", "alpha"}
+
+	for _, rotation := range []int{0, 1, 2, 3, 4} {
+		input := append(append([]models.SelectedTechnique{}, techniques[rotation:]...), techniques[:rotation]...)
+		filtered := engine.filterAndSort(input, &models.SelectionRequest{})
+		for i, tech := range filtered {
+			if tech.ID != expectedOrder[i] {
+				t.Errorf("Rotation %d: expected %s at position %d, got %s", rotation, expectedOrder[i], i, tech.ID)
+			}
+		}
+	}
+}
+
+// TestExploration tests that seeded exploration is deterministic per request
+// and only reorders techniques within the jitter
+func TestExploration(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+	engine.EnableExploration(42, 10)
+
+	techniques := []models.SelectedTechnique{
+		{ID: "a", Score: 70, Confidence: 0.7},
+		{ID: "b", Score: 70, Confidence: 0.7},
+		{ID: "c", Score: 70, Confidence: 0.7},
+		{ID: "d", Score: 95, Confidence: 0.95},
+	}
+	order := func(text string) string {
+		input := append([]models.SelectedTechnique{}, techniques...)
+		filtered := engine.filterAndSort(input, &models.SelectionRequest{Text: text, Intent: "reasoning"})
+		ids := make([]string, len(filtered))
+		for i, tech := range filtered {
+			ids[i] = tech.ID
+		}
+		return strings.Join(ids, ",")
+	}
+
+	orders := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		text := fmt.Sprintf("request %d", i)
+		first := order(text)
+		if again := order(text); again != first {
+			t.Errorf("Same request ranked differently: %s vs %s", first, again)
+		}
+		if !strings.HasPrefix(first, "d,") {
+			t.Errorf("Technique outside the jitter was reordered: %s", first)
+		}
+		orders[first] = true
+	}
+	if len(orders) < 2 {
+		t.Errorf("Expected exploration to vary tie order across requests, got %v", orders)
+	}
+
+	engine.EnableExploration(42, 0)
+	if got := order("request 0"); got != "d,a,b,c" {
+		t.Errorf("Expected strict ordering with exploration disabled, got %s", got)
+	}
+}
+
+// TestPersonalizedWeights tests that per-user weights reorder matching
+// techniques within their bounds without adding new ones
+func TestPersonalizedWeights(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+	selectIDs := func(weights map[string]interface{}) ([]string, *models.SelectionResponse) {
+		req := &models.SelectionRequest{
+			Text:       "Explore alternatives and options, then explain why step by step",
+			Intent:     "problem_solving",
+			Complexity: "complex",
+		}
+		if weights != nil {
+			req.Context = map[string]interface{}{"technique_weights": weights}
+		}
+		resp, err := engine.SelectTechniques(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(resp.Techniques))
+		for i, tech := range resp.Techniques {
+			ids[i] = tech.ID
+		}
+		return ids, resp
+	}
+
+	// Tree of thoughts matches too, but is incompatible with and outscored
+	// by chain of thought
+	if ids, _ := selectIDs(nil); strings.Join(ids, ",") != "chain_of_thought" {
+		t.Fatalf("Expected chain_of_thought alone without weights, got %v", ids)
+	}
+
+	ids, resp := selectIDs(map[string]interface{}{"chain_of_thought": 0.5, "tree_of_thoughts": 1.5, "few_shot": 1.5})
+	if strings.Join(ids, ",") != "tree_of_thoughts" {
+		t.Errorf("Expected weights to favor tree_of_thoughts without adding few_shot, got %v", ids)
+	} else if !strings.Contains(resp.Techniques[0].Reasoning, "personalized weight x1.50") {
+		t.Errorf("Expected reasoning to mention the weight, got %q", resp.Techniques[0].Reasoning)
+	}
+
+	weights := techniqueWeights(&models.SelectionRequest{Context: map[string]interface{}{
+		"technique_weights": map[string]interface{}{"a": 100.0, "b": 0.01, "c": "high"},
+	}})
+	if len(weights) != 2 || weights["a"] != maxTechniqueWeight || weights["b"] != minTechniqueWeight {
+		t.Errorf("Expected weights clamped and malformed ones dropped, got %v", weights)
+	}
+}
+
 // TestApplyCombinationRules tests compatibility rules
 func TestApplyCombinationRules(t *testing.T) {
 	config := createTestConfig()
@@ -767,4 +914,85 @@ func TestGenerateReasoning(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// TestSelectTechniquesAllocs guards the allocation budget of the selection
+// hot path: half the 48 allocations per request it made before keywords
+// were lowercased at load, reasoning was built lazily and scratch slices
+// pooled. The budget leaves room for the pool misses the race detector
+// injects.
+func TestSelectTechniquesAllocs(t *testing.T) {
+	engine := newBenchmarkEngine(t)
+	for _, req := range benchmarkRequests {
+		allocs := testing.AllocsPerRun(100, func() {
+			r := req
+			if _, err := engine.SelectTechniques(&r); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs > 24 {
+			t.Errorf("%q: expected at most 24 allocations, got %.0f", req.Text, allocs)
+		}
+	}
+}
+
+// Benchmark tests
+
+// benchmarkRequests is a mix of requests that select zero to three
+// techniques under the shipped rules
+var benchmarkRequests = []models.SelectionRequest{
+	{Text: "Explain step by step how to solve a recursion that overflows the stack", Intent: "problem_solving", Complexity: "complex"},
+	{Text: "Why does the sky appear blue? Verify the physics is accurate", Intent: "reasoning", Complexity: "moderate"},
+	{Text: "Write a function like this example that parses dates", Intent: "code_generation", Complexity: "moderate"},
+	{Text: "What is the capital of France?", Intent: "question_answering", Complexity: "simple"},
+	{Text: "Explore alternatives and options for our quarterly plan, then compare the approaches", Intent: "task_planning", Complexity: "complex"},
+	{Text: "hello", Intent: "conversation", Complexity: "simple"},
+}
+
+func newBenchmarkEngine(b testing.TB) *Engine {
+	cfg, err := config.LoadConfig("../../configs/rules.yaml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	calibration, err := LoadCalibration("../../configs/rules.yaml")
+	if err != nil {
+		b.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	engine := NewEngine(cfg, logger)
+	engine.SetCalibration(calibration)
+	return engine
+}
+
+// BenchmarkSelectTechniques measures the per-request cost of selection.
+// Run with -benchmem: allocs/op is what drives GC pressure at high QPS.
+func BenchmarkSelectTechniques(b *testing.B) {
+	engine := newBenchmarkEngine(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := benchmarkRequests[i%len(benchmarkRequests)]
+		if _, err := engine.SelectTechniques(&req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSelectTechniquesParallel runs selection from concurrent
+// goroutines, as the handler does under load
+func BenchmarkSelectTechniquesParallel(b *testing.B) {
+	engine := newBenchmarkEngine(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			req := benchmarkRequests[i%len(benchmarkRequests)]
+			if _, err := engine.SelectTechniques(&req); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
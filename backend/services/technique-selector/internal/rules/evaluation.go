@@ -0,0 +1,299 @@
+package rules
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/betterprompts/technique-selector/internal/models"
+)
+
+// EvaluationCase is one labeled example: a prompt, its intent and the
+// techniques a good selection would use
+type EvaluationCase struct {
+	Prompt     string   `json:"prompt"`
+	Intent     string   `json:"intent"`
+	Complexity string   `json:"complexity,omitempty"` // Estimated from the prompt when empty, as in the service
+	Expected   []string `json:"expected_techniques"`
+}
+
+// EvaluationReport measures how well the rules select the labeled
+// techniques. Precision, recall and F1 are micro-averaged over every
+// (case, technique) decision.
+type EvaluationReport struct {
+	RulesVersion   string             `json:"rules_version"`
+	Cases          int                `json:"cases"`
+	ExactMatches   int                `json:"exact_matches"` // Cases whose selection was exactly the expected set
+	ExactMatchRate float64            `json:"exact_match_rate"`
+	Precision      float64            `json:"precision"`
+	Recall         float64            `json:"recall"`
+	F1             float64            `json:"f1"`
+	Techniques     []TechniqueMetrics `json:"techniques"`
+	Intents        []IntentMetrics    `json:"intents"`
+	Confusions     []Confusion        `json:"confusions"`
+	Rules          []RuleAttribution  `json:"rules"`
+}
+
+// TechniqueMetrics is one technique's precision and recall, with why the
+// engine missed it when it was expected
+type TechniqueMetrics struct {
+	ID             string      `json:"id"`
+	Support        int         `json:"support"` // Cases expecting it
+	Selected       int         `json:"selected"`
+	TruePositives  int         `json:"true_positives"`
+	FalsePositives int         `json:"false_positives"`
+	FalseNegatives int         `json:"false_negatives"`
+	Precision      float64     `json:"precision"`
+	Recall         float64     `json:"recall"`
+	F1             float64     `json:"f1"`
+	Missed         MissReasons `json:"missed"`
+}
+
+// MissReasons breaks a technique's false negatives down by where in the
+// selection it was lost
+type MissReasons struct {
+	NoMatch            int `json:"no_match"`             // Its conditions didn't match the case
+	BelowMinConfidence int `json:"below_min_confidence"` // It matched with too little confidence
+	Outranked          int `json:"outranked"`            // Higher scoring or incompatible techniques took its place
+}
+
+// IntentMetrics is how well the rules do on one intent's cases
+type IntentMetrics struct {
+	Intent       string  `json:"intent"`
+	Cases        int     `json:"cases"`
+	ExactMatches int     `json:"exact_matches"`
+	Precision    float64 `json:"precision"`
+	Recall       float64 `json:"recall"`
+}
+
+// Confusion counts the cases where an expected technique was missed and
+// another selected in its place. An empty Selected means nothing was
+// selected instead; an empty Expected means a technique was selected that
+// no missed one accounts for.
+type Confusion struct {
+	Expected string `json:"expected,omitempty"`
+	Selected string `json:"selected,omitempty"`
+	Count    int    `json:"count"`
+}
+
+// RuleAttribution counts how often a rule condition contributed to a
+// selected technique, split by whether the technique was expected. A rule
+// with a low precision is adding score to the wrong techniques.
+type RuleAttribution struct {
+	Rule       string  `json:"rule"`
+	Selections int     `json:"selections"`
+	Correct    int     `json:"correct"`
+	Incorrect  int     `json:"incorrect"`
+	Precision  float64 `json:"precision"`
+}
+
+// reasonRules names each score reason after the rules file setting behind it
+var reasonRules = map[uint16]string{
+	reasonIntent:                 "intents",
+	reasonComplexityLevel:        "complexity_levels",
+	reasonComplexityThreshold:    "complexity_threshold",
+	reasonComplexityThresholdMax: "complexity_threshold_max",
+	reasonKeywords:               "keywords",
+	reasonMultiStep:              "multi_step_indicators",
+	reasonExploration:            "requires_exploration",
+	reasonPattern:                "requires_pattern",
+	reasonAccuracy:               "requires_accuracy",
+	reasonSimpleRequest:          "simple_request",
+	reasonPriorityBoost:          "intent_priority_boost",
+	reasonPersonalized:           "technique_weights",
+}
+
+// Evaluate runs the engine over the labeled cases, as the service would
+// select for them, and scores the selections against the labels
+func Evaluate(engine *Engine, cases []EvaluationCase) (*EvaluationReport, error) {
+	report := &EvaluationReport{RulesVersion: engine.Version(), Cases: len(cases)}
+	techniques := map[string]*TechniqueMetrics{}
+	intents := map[string]*intentCounts{}
+	confusions := map[Confusion]int{}
+	rules := map[string]*RuleAttribution{}
+	var total counts
+
+	technique := func(id string) *TechniqueMetrics {
+		if techniques[id] == nil {
+			techniques[id] = &TechniqueMetrics{ID: id}
+		}
+		return techniques[id]
+	}
+
+	for i, c := range cases {
+		if c.Prompt == "" || c.Intent == "" {
+			return nil, fmt.Errorf("case %d: prompt and intent are required", i+1)
+		}
+		req := models.SelectionRequest{Text: c.Prompt, Intent: c.Intent, Complexity: c.Complexity}
+		trace := &selectionTrace{reasons: map[string]scoreReasons{}, matched: map[string]float64{}}
+		resp, err := engine.selectTechniques(&req, trace)
+		if err != nil {
+			return nil, fmt.Errorf("case %d: %w", i+1, err)
+		}
+
+		expected := make(map[string]bool, len(c.Expected))
+		for _, id := range c.Expected {
+			expected[id] = true
+		}
+		selected := make(map[string]bool, len(resp.Techniques))
+		for _, tech := range resp.Techniques {
+			selected[tech.ID] = true
+		}
+
+		if intents[c.Intent] == nil {
+			intents[c.Intent] = &intentCounts{}
+		}
+		ic := intents[c.Intent]
+		ic.cases++
+
+		var missed, extra []string
+		for id := range expected {
+			m := technique(id)
+			m.Support++
+			if selected[id] {
+				continue
+			}
+			m.FalseNegatives++
+			missed = append(missed, id)
+			confidence, ok := trace.matched[id]
+			switch {
+			case !ok:
+				m.Missed.NoMatch++
+			case confidence < engine.config.SelectionRules.MinConfidence:
+				m.Missed.BelowMinConfidence++
+			default:
+				m.Missed.Outranked++
+			}
+		}
+		for _, tech := range resp.Techniques {
+			m := technique(tech.ID)
+			m.Selected++
+			correct := expected[tech.ID]
+			if correct {
+				m.TruePositives++
+			} else {
+				m.FalsePositives++
+				extra = append(extra, tech.ID)
+			}
+
+			reasons := trace.reasons[tech.ID]
+			for reason, rule := range reasonRules {
+				if reasons.matched&reason == 0 {
+					continue
+				}
+				if rules[rule] == nil {
+					rules[rule] = &RuleAttribution{Rule: rule}
+				}
+				rules[rule].Selections++
+				if correct {
+					rules[rule].Correct++
+				} else {
+					rules[rule].Incorrect++
+				}
+			}
+		}
+
+		caseCounts := counts{tp: len(resp.Techniques) - len(extra), fp: len(extra), fn: len(missed)}
+		total.add(caseCounts)
+		ic.add(caseCounts)
+		if len(missed) == 0 && len(extra) == 0 {
+			report.ExactMatches++
+			ic.exact++
+		}
+
+		slices.Sort(missed)
+		for _, e := range missed {
+			if len(extra) == 0 {
+				confusions[Confusion{Expected: e}]++
+			}
+			for _, s := range extra {
+				confusions[Confusion{Expected: e, Selected: s}]++
+			}
+		}
+		if len(missed) == 0 {
+			for _, s := range extra {
+				confusions[Confusion{Selected: s}]++
+			}
+		}
+	}
+
+	if report.Cases > 0 {
+		report.ExactMatchRate = float64(report.ExactMatches) / float64(report.Cases)
+	}
+	report.Precision, report.Recall, report.F1 = total.scores()
+
+	for _, m := range techniques {
+		c := counts{tp: m.TruePositives, fp: m.FalsePositives, fn: m.FalseNegatives}
+		m.Precision, m.Recall, m.F1 = c.scores()
+		report.Techniques = append(report.Techniques, *m)
+	}
+	slices.SortFunc(report.Techniques, func(a, b TechniqueMetrics) int { return cmp.Compare(a.ID, b.ID) })
+
+	for intent, ic := range intents {
+		precision, recall, _ := ic.scores()
+		report.Intents = append(report.Intents, IntentMetrics{
+			Intent:       intent,
+			Cases:        ic.cases,
+			ExactMatches: ic.exact,
+			Precision:    precision,
+			Recall:       recall,
+		})
+	}
+	slices.SortFunc(report.Intents, func(a, b IntentMetrics) int { return cmp.Compare(a.Intent, b.Intent) })
+
+	for confusion, n := range confusions {
+		confusion.Count = n
+		report.Confusions = append(report.Confusions, confusion)
+	}
+	slices.SortFunc(report.Confusions, func(a, b Confusion) int {
+		if a.Count != b.Count {
+			return b.Count - a.Count
+		}
+		if a.Expected != b.Expected {
+			return cmp.Compare(a.Expected, b.Expected)
+		}
+		return cmp.Compare(a.Selected, b.Selected)
+	})
+
+	for _, r := range rules {
+		r.Precision = ratio(r.Correct, r.Selections)
+		report.Rules = append(report.Rules, *r)
+	}
+	slices.SortFunc(report.Rules, func(a, b RuleAttribution) int {
+		if a.Selections != b.Selections {
+			return b.Selections - a.Selections
+		}
+		return cmp.Compare(a.Rule, b.Rule)
+	})
+
+	return report, nil
+}
+
+// counts are true positive, false positive and false negative decisions
+type counts struct{ tp, fp, fn int }
+
+func (c *counts) add(o counts) {
+	c.tp += o.tp
+	c.fp += o.fp
+	c.fn += o.fn
+}
+
+func (c counts) scores() (precision, recall, f1 float64) {
+	precision, recall = ratio(c.tp, c.tp+c.fp), ratio(c.tp, c.tp+c.fn)
+	if precision+recall > 0 {
+		f1 = 2 * precision * recall / (precision + recall)
+	}
+	return precision, recall, f1
+}
+
+type intentCounts struct {
+	counts
+	cases, exact int
+}
+
+func ratio(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+
+	report, err := Evaluate(engine, []EvaluationCase{
+		{Prompt: "Explain why the result holds and verify it is accurate", Intent: "reasoning", Complexity: "complex", Expected: []string{"chain_of_thought", "self_consistency"}},
+		{Prompt: "What is 2+2?", Intent: "question_answering", Complexity: "simple", Expected: []string{"zero_shot"}},
+		{Prompt: "Write a poem like this example", Intent: "creative_writing", Complexity: "simple", Expected: []string{"few_shot"}},
+		{Prompt: "Why?", Intent: "reasoning", Complexity: "simple", Expected: []string{"chain_of_thought"}},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %v", err)
+	}
+
+	if report.Cases != 4 || report.ExactMatches != 3 {
+		t.Errorf("Expected 3 of 4 exact matches, got %d of %d", report.ExactMatches, report.Cases)
+	}
+	if report.Precision != 0.8 || report.Recall != 0.8 {
+		t.Errorf("Expected precision and recall of 0.8, got %f and %f", report.Precision, report.Recall)
+	}
+
+	techniques := map[string]TechniqueMetrics{}
+	for _, m := range report.Techniques {
+		techniques[m.ID] = m
+	}
+	cot := techniques["chain_of_thought"]
+	if cot.Support != 2 || cot.TruePositives != 1 || cot.Recall != 0.5 {
+		t.Errorf("Unexpected chain_of_thought metrics: %+v", cot)
+	}
+	// Its complexity levels exclude simple prompts
+	if cot.Missed != (MissReasons{NoMatch: 1}) {
+		t.Errorf("Expected chain_of_thought to be missed for not matching, got %+v", cot.Missed)
+	}
+	zeroShot := techniques["zero_shot"]
+	if zeroShot.Selected != 2 || zeroShot.FalsePositives != 1 || zeroShot.Precision != 0.5 {
+		t.Errorf("Unexpected zero_shot metrics: %+v", zeroShot)
+	}
+
+	if len(report.Intents) != 3 || report.Intents[2].Intent != "reasoning" || report.Intents[2].ExactMatches != 1 {
+		t.Errorf("Unexpected intent metrics: %+v", report.Intents)
+	}
+
+	want := []Confusion{{Expected: "chain_of_thought", Selected: "zero_shot", Count: 1}}
+	if len(report.Confusions) != 1 || report.Confusions[0] != want[0] {
+		t.Errorf("Expected confusions %+v, got %+v", want, report.Confusions)
+	}
+
+	rules := map[string]RuleAttribution{}
+	for _, r := range report.Rules {
+		rules[r.Rule] = r
+	}
+	if r := rules["simple_request"]; r.Selections != 2 || r.Correct != 1 || r.Incorrect != 1 {
+		t.Errorf("Unexpected simple_request attribution: %+v", r)
+	}
+	if r := rules["intents"]; r.Selections != 3 || r.Precision != 1 {
+		t.Errorf("Unexpected intents attribution: %+v", r)
+	}
+	if report.Rules[0].Rule != "complexity_levels" {
+		t.Errorf("Expected rules ordered by selections, got %+v", report.Rules)
+	}
+}
+
+func TestEvaluateUnselectedAndUnlabeled(t *testing.T) {
+	engine := NewEngine(createTestConfig(), createTestLogger())
+
+	report, err := Evaluate(engine, []EvaluationCase{
+		{Prompt: "What is 2+2?", Intent: "question_answering", Complexity: "simple"},
+	})
+	if err != nil {
+		t.Fatalf("Evaluate returned error: %v", err)
+	}
+	want := Confusion{Selected: "zero_shot", Count: 1}
+	if len(report.Confusions) != 1 || report.Confusions[0] != want {
+		t.Errorf("Expected a selection with nothing missed, got %+v", report.Confusions)
+	}
+
+	if _, err := Evaluate(engine, []EvaluationCase{{Prompt: "Why?"}}); err == nil {
+		t.Error("Expected an error for a case without an intent")
+	}
+}
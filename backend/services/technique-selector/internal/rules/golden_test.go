@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/betterprompts/technique-selector/internal/config"
+	"github.com/betterprompts/technique-selector/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected selections in testdata/golden")
+
+// goldenCase is a corpus entry: a request and the selection the shipped
+// rules are expected to make for it
+type goldenCase struct {
+	Request  models.SelectionRequest `json:"request"`
+	Expected goldenSelection         `json:"expected"`
+}
+
+type goldenSelection struct {
+	PrimaryTechnique string            `json:"primary_technique"`
+	Techniques       []goldenTechnique `json:"techniques"`
+}
+
+type goldenTechnique struct {
+	ID         string  `json:"id"`
+	RawScore   float64 `json:"raw_score"`
+	Score      float64 `json:"score"`
+	Confidence float64 `json:"confidence"`
+}
+
+// TestGoldenCorpus runs the engine with configs/rules.yaml over every case
+// in testdata/golden. A rules change that alters selections fails here with
+// a diff; rerun with -update to accept it and commit the changed cases so
+// the selection changes are reviewed alongside the rules.
+func TestGoldenCorpus(t *testing.T) {
+	cfg, err := config.LoadConfig("../../configs/rules.yaml")
+	require.NoError(t, err)
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	engine := NewEngine(cfg, logger)
+	calibration, err := LoadCalibration("../../configs/rules.yaml")
+	require.NoError(t, err)
+	engine.SetCalibration(calibration)
+
+	paths, err := filepath.Glob("testdata/golden/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, paths, "golden corpus is empty")
+
+	var diffs []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		var gc goldenCase
+		require.NoError(t, json.Unmarshal(data, &gc), path)
+
+		req := gc.Request
+		resp, err := engine.SelectTechniques(&req)
+		require.NoError(t, err, path)
+		got := newGoldenSelection(resp)
+
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		if diff := diffSelections(gc.Expected, got); diff != "" {
+			diffs = append(diffs, name+":\n"+diff)
+		}
+
+		if *update {
+			gc.Expected = got
+			require.NoError(t, writeGoldenCase(path, gc))
+		}
+	}
+
+	if len(diffs) == 0 {
+		return
+	}
+	if *update {
+		t.Logf("updated %d golden cases:\n%s", len(diffs), strings.Join(diffs, "\n"))
+		return
+	}
+	t.Errorf("selections differ from the golden corpus in %d cases; if intended, rerun with -update:\n%s",
+		len(diffs), strings.Join(diffs, "\n"))
+}
+
+func newGoldenSelection(resp *models.SelectionResponse) goldenSelection {
+	selection := goldenSelection{
+		PrimaryTechnique: resp.PrimaryTechnique,
+		Techniques:       []goldenTechnique{},
+	}
+	raw, _ := resp.Metadata["raw_scores"].(map[string]float64)
+	for _, tech := range resp.Techniques {
+		selection.Techniques = append(selection.Techniques, goldenTechnique{
+			ID:         tech.ID,
+			RawScore:   roundGolden(raw[tech.ID]),
+			Score:      roundGolden(tech.Score),
+			Confidence: roundGolden(tech.Confidence),
+		})
+	}
+	return selection
+}
+
+// roundGolden drops float noise that would make the corpus churn
+func roundGolden(v float64) float64 {
+	return math.Round(v*1e4) / 1e4
+}
+
+// diffSelections describes how got differs from want, one change per line:
+// "-" for a technique no longer selected, "+" for a new one and "~" for a
+// changed score, confidence or rank
+func diffSelections(want, got goldenSelection) string {
+	var b strings.Builder
+	if want.PrimaryTechnique != got.PrimaryTechnique {
+		fmt.Fprintf(&b, "  primary: %q -> %q\n", want.PrimaryTechnique, got.PrimaryTechnique)
+	}
+
+	rank := func(techniques []goldenTechnique) map[string]int {
+		m := make(map[string]int, len(techniques))
+		for i, tech := range techniques {
+			m[tech.ID] = i
+		}
+		return m
+	}
+	wantRank, gotRank := rank(want.Techniques), rank(got.Techniques)
+
+	for i, tech := range want.Techniques {
+		j, ok := gotRank[tech.ID]
+		if !ok {
+			fmt.Fprintf(&b, "  - %s (score %g, confidence %g)\n", tech.ID, tech.Score, tech.Confidence)
+			continue
+		}
+		now := got.Techniques[j]
+		var changes []string
+		if i != j {
+			changes = append(changes, fmt.Sprintf("rank %d -> %d", i+1, j+1))
+		}
+		if tech.RawScore != now.RawScore {
+			changes = append(changes, fmt.Sprintf("raw score %g -> %g", tech.RawScore, now.RawScore))
+		}
+		if tech.Score != now.Score {
+			changes = append(changes, fmt.Sprintf("score %g -> %g", tech.Score, now.Score))
+		}
+		if tech.Confidence != now.Confidence {
+			changes = append(changes, fmt.Sprintf("confidence %g -> %g", tech.Confidence, now.Confidence))
+		}
+		if len(changes) > 0 {
+			fmt.Fprintf(&b, "  ~ %s: %s\n", tech.ID, strings.Join(changes, ", "))
+		}
+	}
+	for _, tech := range got.Techniques {
+		if _, ok := wantRank[tech.ID]; !ok {
+			fmt.Fprintf(&b, "  + %s (score %g, confidence %g)\n", tech.ID, tech.Score, tech.Confidence)
+		}
+	}
+	return b.String()
+}
+
+func writeGoldenCase(path string, gc goldenCase) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(gc); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
+
+func TestDiffSelections(t *testing.T) {
+	want := goldenSelection{
+		PrimaryTechnique: "chain_of_thought",
+		Techniques: []goldenTechnique{
+			{ID: "chain_of_thought", Score: 7.5, Confidence: 0.85},
+			{ID: "few_shot", Score: 3, Confidence: 0.6},
+		},
+	}
+	require.Empty(t, diffSelections(want, want))
+
+	got := goldenSelection{
+		PrimaryTechnique: "role_based",
+		Techniques: []goldenTechnique{
+			{ID: "role_based", Score: 8, Confidence: 0.9},
+			{ID: "chain_of_thought", Score: 7.5, Confidence: 0.8},
+		},
+	}
+	require.Equal(t, strings.Join([]string{
+		`  primary: "chain_of_thought" -> "role_based"`,
+		"  ~ chain_of_thought: rank 1 -> 2, confidence 0.85 -> 0.8",
+		"  - few_shot (score 3, confidence 0.6)",
+		"  + role_based (score 8, confidence 0.9)",
+		"",
+	}, "\n"), diffSelections(want, got))
+}